@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package info
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	lxdapi "github.com/canonical/lxd/shared/api"
+)
+
+// smartctl exit status is a bit mask, bits 0 and 1 mean that the command
+// line could not be parsed or the device could not be opened.
+const smartctlFatalMask = 0x03
+
+const smartctlTimeout = 30 * time.Second
+
+// ATA SMART attributes that indicate a failing disk when non-zero
+var ataFailureAttributes = map[int]string{
+	5:   "reallocated sectors",
+	197: "pending sectors",
+	198: "offline uncorrectable sectors",
+}
+
+// DiskHealth represents SMART or NVMe health of a disk
+type DiskHealth struct {
+	ID       string `json:"id" yaml:"id"`
+	Protocol string `json:"protocol" yaml:"protocol"`
+	Model    string `json:"model" yaml:"model"`
+	Serial   string `json:"serial" yaml:"serial"`
+	// Reasons why the disk is considered failing
+	Reasons         []string `json:"reasons,omitempty" yaml:"reasons,omitempty"`
+	Temperature     int      `json:"temperature" yaml:"temperature"`
+	PowerOnHours    int64    `json:"power_on_hours" yaml:"power_on_hours"`
+	PercentageUsed  int      `json:"percentage_used,omitempty" yaml:"percentage_used,omitempty"`
+	MediaErrors     int64    `json:"media_errors,omitempty" yaml:"media_errors,omitempty"`
+	CriticalWarning int      `json:"critical_warning,omitempty" yaml:"critical_warning,omitempty"`
+	SMARTPassed     bool     `json:"smart_passed" yaml:"smart_passed"`
+	Failing         bool     `json:"failing" yaml:"failing"`
+}
+
+// smartctlOutput is a subset of `smartctl --json -a` output
+type smartctlOutput struct {
+	Device struct {
+		Protocol string `json:"protocol"`
+	} `json:"device"`
+	SMARTStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATASMARTAttributes struct {
+		Table []struct {
+			Name string `json:"name"`
+			Raw  struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+			ID int `json:"id"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeHealth *struct {
+		CriticalWarning         int   `json:"critical_warning"`
+		AvailableSpare          int   `json:"available_spare"`
+		AvailableSpareThreshold int   `json:"available_spare_threshold"`
+		PercentageUsed          int   `json:"percentage_used"`
+		MediaErrors             int64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+	ModelName    string `json:"model_name"`
+	SerialNumber string `json:"serial_number"`
+	Temperature  struct {
+		Current int `json:"current"`
+	} `json:"temperature"`
+	PowerOnTime struct {
+		Hours int64 `json:"hours"`
+	} `json:"power_on_time"`
+}
+
+func parseSmartctl(id string, data []byte) (*DiskHealth, error) {
+	var out smartctlOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+
+	// Devices without SMART support (e.g. virtual disks) have no status
+	if out.SMARTStatus == nil {
+		return nil, nil
+	}
+
+	health := &DiskHealth{
+		ID:           id,
+		Protocol:     out.Device.Protocol,
+		Model:        out.ModelName,
+		Serial:       out.SerialNumber,
+		Temperature:  out.Temperature.Current,
+		PowerOnHours: out.PowerOnTime.Hours,
+		SMARTPassed:  out.SMARTStatus.Passed,
+	}
+
+	if !health.SMARTPassed {
+		health.Reasons = append(health.Reasons, "SMART overall health check failed")
+	}
+
+	for _, attr := range out.ATASMARTAttributes.Table {
+		if name, ok := ataFailureAttributes[attr.ID]; ok && attr.Raw.Value > 0 {
+			health.Reasons = append(health.Reasons, fmt.Sprintf("%d %s", attr.Raw.Value, name))
+		}
+	}
+
+	if nvme := out.NVMeHealth; nvme != nil {
+		health.CriticalWarning = nvme.CriticalWarning
+		health.PercentageUsed = nvme.PercentageUsed
+		health.MediaErrors = nvme.MediaErrors
+
+		if nvme.CriticalWarning != 0 {
+			health.Reasons = append(health.Reasons,
+				fmt.Sprintf("critical warning 0x%02x", nvme.CriticalWarning))
+		}
+
+		if nvme.MediaErrors > 0 {
+			health.Reasons = append(health.Reasons, fmt.Sprintf("%d media errors", nvme.MediaErrors))
+		}
+
+		if nvme.PercentageUsed >= 100 {
+			health.Reasons = append(health.Reasons,
+				fmt.Sprintf("%d%% of rated endurance used", nvme.PercentageUsed))
+		}
+
+		if nvme.AvailableSpare < nvme.AvailableSpareThreshold {
+			health.Reasons = append(health.Reasons,
+				fmt.Sprintf("available spare %d%% is below threshold %d%%",
+					nvme.AvailableSpare, nvme.AvailableSpareThreshold))
+		}
+	}
+
+	health.Failing = len(health.Reasons) > 0
+
+	return health, nil
+}
+
+func getDiskHealth(id string) (*DiskHealth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), smartctlTimeout)
+	defer cancel()
+
+	//nolint:gosec // device name comes from sysfs
+	cmd := exec.CommandContext(ctx, "smartctl", "--json", "-a", "/dev/"+id)
+
+	data, err := cmd.Output()
+	if err != nil {
+		// Non-zero exit status is also used to report disk problems,
+		// the output is still valid unless the device couldn't be read.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode()&smartctlFatalMask != 0 {
+			return nil, err
+		}
+	}
+
+	return parseSmartctl(id, data)
+}
+
+// getStorageHealth returns health of disks that support SMART. Health is
+// collected on a best effort basis, disks that cannot be queried (or all
+// of them, if smartctl is not installed) are skipped.
+func getStorageHealth(storage lxdapi.ResourcesStorage) []DiskHealth {
+	if _, err := exec.LookPath("smartctl"); err != nil {
+		return nil
+	}
+
+	var result []DiskHealth
+
+	for _, disk := range storage.Disks {
+		if disk.Type == "virtio" || disk.ReadOnly {
+			continue
+		}
+
+		health, err := getDiskHealth(disk.ID)
+		if err != nil || health == nil {
+			continue
+		}
+
+		result = append(result, *health)
+	}
+
+	return result
+}
@@ -59,6 +59,9 @@ type HostInfo struct {
 type AllInfo struct {
 	Resources *lxdapi.Resources      `json:"resources" yaml:"resources"`
 	Networks  map[string]interface{} `json:"networks" yaml:"networks"`
+	// DiskHealth is collected during commissioning and hardware sync,
+	// so failing disks can be flagged before they die.
+	DiskHealth []DiskHealth `json:"disk_health,omitempty" yaml:"disk_health,omitempty"`
 	HostInfo
 }
 
@@ -217,8 +220,9 @@ func GetInfo() (*AllInfo, error) {
 	}
 
 	return &AllInfo{
-		HostInfo:  *hostInfo,
-		Resources: resInfo,
-		Networks:  netInfo,
+		HostInfo:   *hostInfo,
+		Resources:  resInfo,
+		Networks:   netInfo,
+		DiskHealth: getStorageHealth(resInfo.Storage),
 	}, nil
 }
@@ -19,16 +19,20 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -54,20 +58,80 @@ import (
 	"go.temporal.io/sdk/interceptor"
 	"gopkg.in/yaml.v3"
 
+	"maas.io/core/src/maasagent/internal/activitymon"
+	"maas.io/core/src/maasagent/internal/adminauth"
+	"maas.io/core/src/maasagent/internal/annotation"
+	"maas.io/core/src/maasagent/internal/anomaly"
 	"maas.io/core/src/maasagent/internal/apiclient"
+	"maas.io/core/src/maasagent/internal/backpressure"
+	"maas.io/core/src/maasagent/internal/blob"
+	"maas.io/core/src/maasagent/internal/burnin"
 	"maas.io/core/src/maasagent/internal/cache"
+	"maas.io/core/src/maasagent/internal/calibration"
+	"maas.io/core/src/maasagent/internal/certrenew"
+	"maas.io/core/src/maasagent/internal/cli"
+	"maas.io/core/src/maasagent/internal/console"
+	"maas.io/core/src/maasagent/internal/deploycreds"
 	"maas.io/core/src/maasagent/internal/dhcp"
+	"maas.io/core/src/maasagent/internal/dnspub"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/eventexport"
+	"maas.io/core/src/maasagent/internal/fshealth"
+	"maas.io/core/src/maasagent/internal/hook"
 	"maas.io/core/src/maasagent/internal/httpproxy"
+	"maas.io/core/src/maasagent/internal/idle"
+	"maas.io/core/src/maasagent/internal/imagecapture"
+	"maas.io/core/src/maasagent/internal/imagesync"
+	"maas.io/core/src/maasagent/internal/ipconflict"
+	"maas.io/core/src/maasagent/internal/ipmibridge"
+	"maas.io/core/src/maasagent/internal/journal"
+	"maas.io/core/src/maasagent/internal/lifecycle"
+	"maas.io/core/src/maasagent/internal/linkcheck"
+	"maas.io/core/src/maasagent/internal/listener"
+	"maas.io/core/src/maasagent/internal/loadtest"
+	"maas.io/core/src/maasagent/internal/netboot"
+	"maas.io/core/src/maasagent/internal/netif"
+	"maas.io/core/src/maasagent/internal/netplan"
+	"maas.io/core/src/maasagent/internal/operation"
+	"maas.io/core/src/maasagent/internal/pathutil"
+	"maas.io/core/src/maasagent/internal/phonehome"
 	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/redact"
+	"maas.io/core/src/maasagent/internal/remediation"
+	"maas.io/core/src/maasagent/internal/ringbuf"
 	"maas.io/core/src/maasagent/internal/servicecontroller"
+	"maas.io/core/src/maasagent/internal/slo"
+	"maas.io/core/src/maasagent/internal/snmptrap"
+	"maas.io/core/src/maasagent/internal/state"
+	"maas.io/core/src/maasagent/internal/subnetmap"
+	"maas.io/core/src/maasagent/internal/switchport"
+	"maas.io/core/src/maasagent/internal/tagging"
+	"maas.io/core/src/maasagent/internal/wake"
+	"maas.io/core/src/maasagent/internal/webhook"
+	"maas.io/core/src/maasagent/internal/workflow/history"
 	wflog "maas.io/core/src/maasagent/internal/workflow/log"
+	"maas.io/core/src/maasagent/internal/workflow/payload"
+	"maas.io/core/src/maasagent/internal/workflow/schedule"
 	"maas.io/core/src/maasagent/internal/workflow/worker"
 	"maas.io/core/src/maasagent/pkg/workflow/codec"
 )
 
+// Roles are subsystems that can be enabled on the Agent. Active roles are
+// advertised to the Region, so it only routes relevant work to the Agent.
+const (
+	roleDHCP      = "dhcp"
+	roleHTTPProxy = "httpproxy"
+	rolePower     = "power"
+)
+
+var allRoles = []string{roleDHCP, roleHTTPProxy, rolePower}
+
 const (
 	defaultTemporalPort        = 5271
 	defaultMAASInternalAPIPort = 5242
+	// calibrationTimeout bounds how long the first start can be delayed
+	// by benchmarks
+	calibrationTimeout = time.Minute
 )
 
 // config represents a necessary set of configuration options for MAAS Agent
@@ -78,10 +142,26 @@ type config struct {
 	Secret    string `yaml:"secret"`
 	LogLevel  string `yaml:"log_level"`
 	HTTPProxy struct {
-		CacheDir  string `yaml:"cache_dir"`
-		CacheSize int64  `yaml:"cache_size"`
+		CacheDir string `yaml:"cache_dir"`
+		// Bindings are interfaces or VRFs where proxy is served directly
+		// on Port, in addition to the socket consumed by NGINX.
+		Bindings  []listener.Binding `yaml:"bindings"`
+		Families  listener.Families  `yaml:"families"`
+		CacheSize int64              `yaml:"cache_size"`
+		Port      int                `yaml:"port"`
 	} `yaml:"httpproxy"`
+	ImageSync struct {
+		// Interval is how often boot resources are synced with the Region,
+		// 0 keeps the default, negative disables
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"image_sync"`
+	Certificates struct {
+		// RenewBefore is how long before expiry the cluster certificate is
+		// renewed, 0 keeps the default, negative disables renewals
+		RenewBefore time.Duration `yaml:"renew_before"`
+	} `yaml:"certificates"`
 	Controllers []string `yaml:"controllers,flow"`
+	Roles       []string `yaml:"roles,flow"`
 	Tracing     struct {
 		OTLPHTTPEndpoint string `yaml:"otlp_http_endpoint"`
 		Enabled          bool   `yaml:"enabled"`
@@ -92,6 +172,92 @@ type config struct {
 	Profiling struct {
 		Enabled bool `yaml:"enabled"`
 	} `yaml:"profiling"`
+	Artifacts struct {
+		// Quotas limit disk usage per artifact kind (e.g. console, pcap)
+		Quotas map[string]int64 `yaml:"quotas"`
+		Dir    string           `yaml:"dir"`
+		S3     blob.S3Config    `yaml:"s3"`
+	} `yaml:"artifacts"`
+	SLO struct {
+		// Objectives are latency objectives keyed by operation
+		// (activity name or deploy phase reported by the Region)
+		Objectives map[string]slo.Objective `yaml:"objectives"`
+	} `yaml:"slo"`
+	Remediation struct {
+		// Rules are evaluated against events of the Agent (e.g. failed
+		// activities) and can be replaced by the Region
+		Rules []remediation.Rule `yaml:"rules"`
+	} `yaml:"remediation"`
+	Webhooks struct {
+		// Endpoints receive signed webhooks on workflow lifecycle events
+		// and can be replaced by the Region
+		Endpoints []webhook.Endpoint `yaml:"endpoints"`
+	} `yaml:"webhooks"`
+	// Hooks are run before and after power and deploy operations
+	Hooks []hook.Hook `yaml:"hooks"`
+	// EventExport publishes events of the Agent to Kafka or NATS
+	EventExport eventexport.Config `yaml:"event_export"`
+	// Redaction strips secrets and personal data from exported events,
+	// webhooks and text artifacts
+	Redaction redact.Config `yaml:"redaction"`
+	SNMPTrap  struct {
+		// Communities which are accepted, any if empty
+		Communities []string `yaml:"communities"`
+		// Mappings of traps to machine events (IF-MIB link traps if empty)
+		Mappings []snmptrap.Mapping `yaml:"mappings"`
+		// Port of the trap receiver (usually 162), disabled if 0
+		Port int `yaml:"port"`
+	} `yaml:"snmp_trap"`
+	IPMIBridge struct {
+		// MinInterval between commands sent to the same BMC
+		MinInterval time.Duration `yaml:"min_interval"`
+		// Enabled serves ipmitool compatible bridge to power drivers
+		// on the local socket
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"ipmi_bridge"`
+	// AdminAuth restricts who can use the local API (and the CLI)
+	AdminAuth adminauth.Config `yaml:"admin_auth"`
+	Power     struct {
+		// NativeDrivers are power driver types performed by drivers built
+		// into the Agent rather than the MAAS power CLI
+		NativeDrivers []string `yaml:"native_drivers"`
+		// Retry is the policy of power actions of drivers without one
+		Retry power.RetryPolicy `yaml:"retry"`
+		// DriverRetry are retry policies keyed by power driver type
+		DriverRetry map[string]power.RetryPolicy `yaml:"driver_retry"`
+		// DriverTimeouts limit single commands of power driver types,
+		// 0 removes the default timeout of the driver type
+		DriverTimeouts map[string]time.Duration `yaml:"driver_timeouts"`
+		// SoftOffTimeout is how long machines with "soft" power_off_mode
+		// can take to shut down before power is removed, 0 keeps the default
+		SoftOffTimeout time.Duration `yaml:"soft_off_timeout"`
+		// Anomaly thresholds of power behavior reported to the Region
+		Anomaly anomaly.Config `yaml:"anomaly"`
+		// RetryStatsInterval is how often retry statistics of power actions
+		// are reported to the Region, 0 keeps the default, negative disables
+		RetryStatsInterval time.Duration `yaml:"retry_stats_interval"`
+		// SweepInterval is how often power states of machines in transition
+		// are reconciled, 0 keeps the default, negative disables
+		SweepInterval time.Duration `yaml:"sweep_interval"`
+		// IdleOff powers off machines the Region flags as idle
+		IdleOff idle.Config `yaml:"idle_off"`
+	} `yaml:"power"`
+	DNSPublication struct {
+		// Endpoints are services of the rack published in rack-local
+		// zones, metadata, boot HTTP and syslog if empty
+		Endpoints []dnspub.Endpoint `yaml:"endpoints"`
+		// TTL of published records in seconds, 0 keeps the default
+		TTL      int  `yaml:"ttl"`
+		Disabled bool `yaml:"disabled"`
+	} `yaml:"dns_publication"`
+	Calibration struct {
+		// Overrides replace values derived from calibration
+		Overrides calibration.Tuning `yaml:"overrides"`
+		// ProbeAddresses are host:port of BMCs, which round-trip times
+		// are measured on the first start
+		ProbeAddresses []string `yaml:"probe_addresses"`
+		Disabled       bool     `yaml:"disabled"`
+	} `yaml:"calibration"`
 }
 
 // setupLogger sets the global logger with the provided logLevel.
@@ -147,12 +313,16 @@ func getClusterCert() (tls.Certificate, *x509.CertPool, error) {
 	return cert, ca, nil
 }
 
+// clientCertFunc returns the client certificate used for mTLS, which can
+// change while the Agent is running (e.g. when it is renewed).
+type clientCertFunc func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
 // getTemporalClient returns Temporal Client that is used to communicate
 // to MAAS Temporal server (running next to the Region Controller).
 //
 // secret is used for EncryptionCodec (AES) to encrypt input/output (payloads)
-// cert, ca are used to setup mTLS
-func getTemporalClient(systemID string, secret []byte, cert tls.Certificate,
+// getCert, ca are used to setup mTLS
+func getTemporalClient(systemID string, secret []byte, getCert clientCertFunc,
 	ca *x509.CertPool, endpoints []string,
 	metrics temporalotel.MetricsHandler, tracer trace.Tracer) (client.Client, error) {
 	// Encryption Codec required for Temporal Workflow's payload encoding
@@ -186,9 +356,9 @@ func getTemporalClient(systemID string, secret []byte, cert tls.Certificate,
 				),
 				ConnectionOptions: client.ConnectionOptions{
 					TLS: &tls.Config{
-						MinVersion:   tls.VersionTLS12,
-						Certificates: []tls.Certificate{cert},
-						RootCAs:      ca,
+						MinVersion:           tls.VersionTLS12,
+						GetClientCertificate: getCert,
+						RootCAs:              ca,
 						// NOTE: this should be configurable.
 						// Right now it is hardcoded because we use MAAS self-signed
 						// certificate for mTLS. But that needs to be refactored once
@@ -209,7 +379,7 @@ func getTemporalClient(systemID string, secret []byte, cert tls.Certificate,
 func getConfig() (*config, error) {
 	fname := os.Getenv("MAAS_AGENT_CONFIG")
 	if fname == "" {
-		fname = "/etc/maas/agent.yaml"
+		fname = defaultConfigPath()
 	}
 
 	data, err := os.ReadFile(filepath.Clean(fname))
@@ -218,15 +388,104 @@ func getConfig() (*config, error) {
 	}
 
 	cfg := &config{}
+	cfg.HTTPProxy.Families = listener.DualStack
 
 	err = yaml.Unmarshal([]byte(data), cfg)
 	if err != nil {
 		return nil, fmt.Errorf("configuration error: %w", err)
 	}
 
+	if len(cfg.Roles) == 0 {
+		cfg.Roles = supportedRoles
+	}
+
+	for _, role := range cfg.Roles {
+		if !slices.Contains(allRoles, role) {
+			return nil, fmt.Errorf("configuration error: unknown role %q", role)
+		}
+
+		if !slices.Contains(supportedRoles, role) {
+			return nil, fmt.Errorf("configuration error: role %q is not supported on %s",
+				role, runtime.GOOS)
+		}
+	}
+
+	if len(cfg.HTTPProxy.Bindings) > 0 && cfg.HTTPProxy.Port == 0 {
+		return nil, fmt.Errorf("configuration error: httpproxy: port is required with bindings")
+	}
+
+	if err := cfg.HTTPProxy.Families.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration error: httpproxy: %w", err)
+	}
+
+	for _, b := range cfg.HTTPProxy.Bindings {
+		if err := b.Validate(); err != nil {
+			return nil, fmt.Errorf("configuration error: httpproxy: %w", err)
+		}
+	}
+
+	if err := cfg.AdminAuth.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration error: admin_auth: %w", err)
+	}
+
+	if err := cfg.Power.Retry.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration error: power: %w", err)
+	}
+
+	for driverType, p := range cfg.Power.DriverRetry {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("configuration error: power: %s: %w", driverType, err)
+		}
+	}
+
 	return cfg, nil
 }
 
+// resolveBindings replaces binding interfaces with interfaces that are
+// actually serving traffic (e.g. masters of enslaved interfaces).
+func resolveBindings(resolver *netif.Resolver,
+	bindings []listener.Binding) ([]listener.Binding, error) {
+	resolved := make([]listener.Binding, len(bindings))
+
+	for i, b := range bindings {
+		resolved[i] = b
+
+		if b.Interface == "" {
+			continue
+		}
+
+		iface, err := resolver.ServingInterface(context.Background(), b.Interface)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved[i].Interface = iface
+	}
+
+	return resolved, nil
+}
+
+// reloadHTTPProxyBindings re-reads the configuration and applies HTTP proxy
+// bindings to the running service.
+func reloadHTTPProxyBindings(resolver *netif.Resolver,
+	service *httpproxy.HTTPProxyService) error {
+	cfg, err := getConfig()
+	if err != nil {
+		return err
+	}
+
+	bindings, err := resolveBindings(resolver, cfg.HTTPProxy.Bindings)
+	if err != nil {
+		return err
+	}
+
+	return service.SetBindings(cfg.HTTPProxy.Port, bindings, cfg.HTTPProxy.Families)
+}
+
+func (c *config) hasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
 func getOrCreateDir(path string) (string, error) {
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -246,18 +505,12 @@ func getRunDir() string {
 		return fmt.Sprintf("/run/snap.%s", name)
 	}
 
-	return "/run/maas"
+	return defaultRunDir()
 }
 
 // getCertificatesDir returns directory that contains MAAS certificates.
 func getCertificatesDir() string {
-	dataDir := os.Getenv("SNAP_DATA")
-
-	if dataDir != "" {
-		return filepath.Join(filepath.Clean(dataDir), "certificates")
-	}
-
-	return "/var/lib/maas/certificates"
+	return pathutil.GetDataPath("certificates")
 }
 
 func setupMetrics(meterProvider *metric.MeterProvider, mux *http.ServeMux) error {
@@ -287,6 +540,219 @@ func setupMetrics(meterProvider *metric.MeterProvider, mux *http.ServeMux) error
 	return nil
 }
 
+// getArtifactStore returns a store for large artifacts produced by the Agent.
+// S3 compatible storage is used when configured, otherwise artifacts are kept
+// on the local disk. Disk usage is limited by per artifact kind quotas.
+func getArtifactStore(cfg *config, meter metric.Meter,
+	options ...blob.QuotaStoreOption) (*blob.QuotaStore, error) {
+	quotas := cfg.Artifacts.Quotas
+	if quotas == nil {
+		quotas = map[string]int64{
+			"audit":   512 * cache.Megabyte,
+			"console": 1 * cache.Gigabyte,
+			"pcap":    2 * cache.Gigabyte,
+		}
+	}
+
+	var store blob.Store
+
+	if cfg.Artifacts.S3.Bucket != "" {
+		s3Store, err := blob.NewS3Store(cfg.Artifacts.S3, http.DefaultClient)
+		if err != nil {
+			return nil, err
+		}
+
+		store = s3Store
+	} else {
+		fileStore, err := blob.NewFileStore(getArtifactsDir(cfg))
+		if err != nil {
+			return nil, err
+		}
+
+		store = fileStore
+	}
+
+	options = append(options, blob.WithMetricMeter(meter))
+
+	return blob.NewQuotaStore(context.TODO(), store, quotas, options...)
+}
+
+// getTuning returns defaults tuned for the environment the Agent runs in.
+// Benchmarks only run on the first start, later the stored result is used.
+// Configured overrides always take precedence.
+func getTuning(cfg *config) calibration.Tuning {
+	if cfg.Calibration.Disabled {
+		return cfg.Calibration.Overrides
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), calibrationTimeout)
+	defer cancel()
+
+	calibrator := calibration.NewCalibrator(pathutil.GetDataPath(""),
+		calibration.WithProbeAddresses(cfg.Calibration.ProbeAddresses))
+
+	res, err := calibration.LoadOrRun(ctx, pathutil.GetDataPath("calibration.json"), calibrator)
+	if err != nil {
+		log.Warn().Err(err).Msg("Calibration failed, affected defaults are kept")
+	}
+
+	var tuning calibration.Tuning
+	if res != nil {
+		tuning = res.Tuning
+	}
+
+	return tuning.Override(cfg.Calibration.Overrides)
+}
+
+func getArtifactsDir(cfg *config) string {
+	if cfg.Artifacts.Dir != "" {
+		return cfg.Artifacts.Dir
+	}
+
+	return pathutil.GetDataPath("artifacts")
+}
+
+// setupDiskUsage exposes disk usage of artifacts and the image cache.
+func setupDiskUsage(mux *http.ServeMux, artifacts *blob.QuotaStore, images *cache.FileCache) {
+	mux.HandleFunc("/disk-usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		usage := artifacts.Usage()
+
+		if images != nil {
+			usage = append(usage, blob.Usage{
+				Subsystem: "image-cache",
+				Used:      images.Size(),
+				Quota:     images.MaxSize(),
+				Items:     images.Len(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(usage)
+	})
+}
+
+// setupSubnetServices exposes services configured for the subnet of an IP
+// address (?ip=), so boot and metadata services can consume them.
+func setupSubnetServices(mux *http.ServeMux, subnets *subnetmap.Map) {
+	mux.HandleFunc("/subnet-services", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		addr, err := netip.ParseAddr(r.URL.Query().Get("ip"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		services, ok := subnets.Lookup(addr)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(services)
+	})
+}
+
+// getLatencyTracker returns Tracker for configured latency objectives,
+// or default objectives for power operations if none are configured.
+func getLatencyTracker(cfg *config, options ...slo.TrackerOption) *slo.Tracker {
+	objectives := cfg.SLO.Objectives
+	if objectives == nil {
+		objectives = map[string]slo.Objective{
+			"power-on":    {P95: 30 * time.Second, P99: 60 * time.Second},
+			"power-off":   {P95: 30 * time.Second, P99: 60 * time.Second},
+			"power-cycle": {P95: 60 * time.Second, P99: 120 * time.Second},
+			"power-query": {P95: 10 * time.Second, P99: 30 * time.Second},
+		}
+	}
+
+	return slo.NewTracker(objectives, options...)
+}
+
+// setupLatencies exposes rolling latency percentiles of tracked operations.
+func setupLatencies(mux *http.ServeMux, tracker *slo.Tracker) {
+	mux.HandleFunc("/latencies", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(tracker.Latencies())
+	})
+}
+
+// setupCircuits exposes circuits opened by remediation rules.
+func setupCircuits(mux *http.ServeMux, engine *remediation.Engine) {
+	mux.HandleFunc("/circuits", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(engine.Circuits())
+	})
+}
+
+// setupHealth exposes the last health check of subsystem directories.
+func setupHealth(mux *http.ServeMux, monitor *fshealth.Monitor) {
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(monitor.Statuses())
+	})
+}
+
+// flushBuffers returns lifecycle.Flusher waiting until buffered events
+// are delivered.
+func flushBuffers(buffers []func() ringbuf.Stats) lifecycle.Flusher {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			pending := 0
+			for _, stats := range buffers {
+				pending += stats().Messages
+			}
+
+			if pending == 0 {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%d events are not delivered: %w", pending, ctx.Err())
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
 func setupProfiling(mux *http.ServeMux) {
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -295,38 +761,99 @@ func setupProfiling(mux *http.ServeMux) {
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 }
 
-func setupHTTP(mux *http.ServeMux) error {
-	socketPath := path.Join(getRunDir(), "agent-http.sock")
+// getSocketPath returns path of the socket serving the local API
+func getSocketPath() string {
+	return filepath.Join(getRunDir(), "agent-http.sock")
+}
 
+// listenUnix listens on a new Unix socket at socketPath
+func listenUnix(socketPath string) (net.Listener, error) {
 	if err := syscall.Unlink(socketPath); err != nil {
 		if !os.IsNotExist(err) {
-			return err
+			return nil, err
 		}
 	}
 
 	listener, err := net.Listen("unix", socketPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	//nolint:gosec // we know what we are doing here and we need 0660
 	if err := os.Chmod(socketPath, 0660); err != nil {
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// getAdminGuard returns Guard of the local API, which decisions are served
+// on the local API and appended to the audit log. Power actions and
+// draining of the Agent are dangerous operations.
+func getAdminGuard(cfg *config, mux *http.ServeMux) (*adminauth.Guard, error) {
+	var options []adminauth.DecisionLogOption
+
+	if cfg.AdminAuth.AuditLog != "" {
+		f, err := os.OpenFile(filepath.Clean(cfg.AdminAuth.AuditLog),
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, adminauth.WithAuditLog(f))
+	}
+
+	decisions := adminauth.NewDecisionLog(options...)
+
+	mux.Handle(adminauth.DecisionsPath, decisions.Handler())
+
+	return cfg.AdminAuth.Guard(
+		adminauth.WithDecisionLog(decisions),
+		adminauth.WithDangerousPaths(ipmibridge.Path, lifecycle.PathPrefix+lifecycle.HookPreStop),
+	)
+}
+
+// setupHTTP serves handler on the Unix socket. With mTLS the API is also
+// served on another socket speaking TLS.
+func setupHTTP(handler http.Handler, auth adminauth.Config) error {
+	listener, err := listenUnix(getSocketPath())
+	if err != nil {
 		return err
 	}
 
 	server := &http.Server{
-		Handler:           mux,
+		Handler:           handler,
 		ReadHeaderTimeout: 60 * time.Second,
+		ConnContext:       adminauth.ConnContext,
 	}
 
-	return server.Serve(listener)
+	if !auth.MTLS.Enabled() {
+		return server.Serve(listener)
+	}
+
+	tlsConfig, err := auth.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	tlsListener, err := listenUnix(filepath.Join(getRunDir(), adminauth.TLSSocketName))
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, 2)
+
+	go func() { errs <- server.Serve(listener) }()
+	go func() { errs <- server.Serve(tls.NewListener(tlsListener, tlsConfig)) }()
+
+	return <-errs
 }
 
-func setupHTTPClient(cert tls.Certificate, ca *x509.CertPool) http.Client {
+func setupHTTPClient(getCert clientCertFunc, ca *x509.CertPool) http.Client {
 	tlsConfig := &tls.Config{
-		MinVersion:   tls.VersionTLS12,
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      ca,
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: getCert,
+		RootCAs:              ca,
 		// NOTE: this should be configurable.
 		// Right now it is hardcoded because we use MAAS self-signed
 		// certificate for mTLS. But that needs to be refactored once
@@ -411,7 +938,95 @@ func Run() int {
 		setupProfiling(mux)
 	}
 
-	go func() { fatal <- setupHTTP(mux) }()
+	cert, ca, err := getClusterCert()
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot fetch cluster certificate")
+		return 1
+	}
+
+	var renewerOptions []certrenew.RenewerOption
+
+	if cfg.Certificates.RenewBefore != 0 {
+		renewerOptions = append(renewerOptions, certrenew.WithRenewBefore(cfg.Certificates.RenewBefore))
+	}
+
+	// The cluster certificate is renewed before it expires, connections
+	// made afterwards use the new one
+	certRenewer := certrenew.NewRenewer(cfg.SystemID,
+		filepath.Join(getCertificatesDir(), "cluster.pem"),
+		filepath.Join(getCertificatesDir(), "cluster.key"),
+		cert, renewerOptions...)
+
+	u := &url.URL{
+		Scheme: "https",
+		Host:   net.JoinHostPort(cfg.Controllers[0], strconv.Itoa(defaultMAASInternalAPIPort)),
+		Path:   "/MAAS/a/v3internal",
+	}
+
+	u.RawPath = u.EscapedPath()
+
+	httpClient := setupHTTPClient(certRenewer.GetClientCertificate, ca)
+
+	apiClient := apiclient.NewAPIClient(u, &httpClient)
+
+	// Producers of reports are slowed down when the Region is slow to
+	// acknowledge them, instead of buffering reports in memory.
+	pressure := backpressure.NewController()
+	mux.Handle(backpressure.Path, pressure.Handler())
+
+	// Health of data directories is checked periodically, so subsystems can
+	// stop writing to a failing or read-only filesystem instead of erroring.
+	fsDirs := map[string]string{
+		"data": pathutil.GetDataPath(""),
+	}
+
+	if cfg.Artifacts.S3.Bucket == "" {
+		fsDirs["artifacts"] = getArtifactsDir(cfg)
+	}
+
+	if cfg.hasRole(roleHTTPProxy) {
+		fsDirs["image-cache"] = cfg.HTTPProxy.CacheDir
+	}
+
+	fsMonitor := fshealth.NewMonitor(fsDirs,
+		fshealth.WithReporter(backpressure.ObserveReporter[fshealth.Status](
+			fshealth.NewAPIReporter(apiClient, cfg.SystemID), pressure)))
+	setupHealth(mux, fsMonitor)
+
+	artifactStore, err := getArtifactStore(cfg, meterProvider.Meter("artifacts"),
+		blob.WithHealthCheck(func() bool { return fsMonitor.Healthy("artifacts") }))
+	if err != nil {
+		log.Error().Err(err).Msg("Artifact store initialisation error")
+		return 1
+	}
+
+	// Artifacts are retrieved with time-limited URLs signed with the Agent secret
+	mux.Handle("/artifacts/", blob.NewHandler("/artifacts/", artifactStore,
+		blob.NewURLSigner([]byte(cfg.Secret))))
+
+	// Data leaving the Agent, or stored for later retrieval, is filtered
+	// at compliance-constrained sites
+	redaction, err := redact.New(cfg.Redaction)
+	if err != nil {
+		log.Error().Err(err).Msg("Redaction configuration error")
+		return 1
+	}
+
+	redactedStore := redact.NewStore(artifactStore, redaction, cfg.Redaction.Artifacts)
+
+	var adminHandler http.Handler = mux
+
+	if cfg.AdminAuth.Enabled() {
+		guard, err := getAdminGuard(cfg, mux)
+		if err != nil {
+			log.Error().Err(err).Msg("Admin API authentication initialisation error")
+			return 1
+		}
+
+		adminHandler = guard.Handler(mux)
+	}
+
+	go func() { fatal <- setupHTTP(adminHandler, cfg.AdminAuth) }()
 
 	if cfg.Tracing.Enabled {
 		//nolint:govet // false positive
@@ -423,14 +1038,8 @@ func Run() int {
 		tracerProvider = tracenoop.NewTracerProvider()
 	}
 
-	cert, ca, err := getClusterCert()
-	if err != nil {
-		log.Error().Err(err).Msg("Cannot fetch cluster certificate")
-		return 1
-	}
-
 	temporalClient, err := getTemporalClient(cfg.SystemID, []byte(cfg.Secret),
-		cert, ca, cfg.Controllers,
+		certRenewer.GetClientCertificate, ca, cfg.Controllers,
 		temporalotel.NewMetricsHandler(
 			temporalotel.MetricsHandlerOptions{
 				Meter: meterProvider.Meter("temporal")},
@@ -442,57 +1051,476 @@ func Run() int {
 		return 1
 	}
 
-	u := &url.URL{
-		Scheme: "https",
-		Host:   net.JoinHostPort(cfg.Controllers[0], strconv.Itoa(defaultMAASInternalAPIPort)),
-		Path:   "/MAAS/a/v3internal",
+	// In-flight local operations are journaled, so they can be resumed
+	// or cleaned up if the Agent was restarted in the middle of them.
+	opJournal, err := journal.New(pathutil.GetDataPath("journal"))
+	if err != nil {
+		log.Error().Err(err).Msg("Operation journal initialisation error")
+		return 1
 	}
 
-	u.RawPath = u.EscapedPath()
+	imageFetcher := imagesync.NewFetcher(&httpClient, imagesync.WithJournal(opJournal))
 
-	httpClient := setupHTTPClient(cert, ca)
+	// Agent-local state (e.g. reports the Region couldn't receive yet) is
+	// kept in an embedded database, so it survives Agent restarts.
+	stateStore, err := state.Open(context.Background(), pathutil.GetDataPath("state.db"))
+	if err != nil {
+		log.Error().Err(err).Msg("State store initialisation error")
+		return 1
+	}
 
-	apiClient := apiclient.NewAPIClient(u, &httpClient)
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer stateStore.Close()
 
 	var workerPool worker.WorkerPool
 
-	httpProxyCache, err := cache.NewFileCache(
-		cfg.HTTPProxy.CacheSize,
-		cfg.HTTPProxy.CacheDir,
-		cache.WithMetricMeter(meterProvider.Meter("httpproxy")),
-	)
+	// Services are served on masters of enslaved interfaces (e.g. OVS bridges
+	// instead of their physical ports).
+	ifResolver := netif.NewResolver()
+
+	exporter, err := eventexport.NewExporter(cfg.SystemID, cfg.EventExport,
+		eventexport.WithRedaction(redaction))
 	if err != nil {
-		log.Error().Err(err).Msg("HTTP Proxy cache initialisation error")
+		log.Error().Err(err).Msg("Event export error")
 		return 1
 	}
 
-	serviceV4 := servicecontroller.GetServiceName(servicecontroller.DHCPv4)
+	var (
+		sloReporter slo.Reporter = state.NewQueuedReporter[slo.Event](stateStore, "latency-events",
+			backpressure.ObserveReporter[slo.Event](slo.NewAPIReporter(apiClient, cfg.SystemID), pressure))
+		remediationReporter remediation.Reporter = state.NewQueuedReporter[remediation.Notification](
+			stateStore, "remediation-events", backpressure.ObserveReporter[remediation.Notification](
+				remediation.NewAPIReporter(apiClient, cfg.SystemID), pressure))
+	)
+
+	if exporter != nil {
+		sloReporter = eventexport.SLOReporter(exporter, sloReporter)
+		remediationReporter = eventexport.RemediationReporter(exporter, remediationReporter)
+	}
 
-	controllerV4, err := servicecontroller.NewController(serviceV4)
+	latencyTracker := getLatencyTracker(cfg, slo.WithReporter(sloReporter),
+		slo.WithBackpressure(pressure))
+	setupLatencies(mux, latencyTracker)
+
+	remediationEngine, err := remediation.NewEngine(cfg.Remediation.Rules,
+		remediation.WithReporter(remediationReporter),
+		remediation.WithBackpressure(pressure))
 	if err != nil {
-		log.Error().Err(err).Msg("DHCP V4 controller initialisation error")
+		log.Error().Err(err).Msg("Remediation rules error")
 		return 1
 	}
 
-	serviceV6 := servicecontroller.GetServiceName(servicecontroller.DHCPv6)
+	setupCircuits(mux, remediationEngine)
+
+	// Power behavior deviating from the usual patterns (e.g. machines found
+	// off unexpectedly) is reported, helping to spot failing PSUs.
+	anomalyDetector := anomaly.NewDetector(cfg.Power.Anomaly,
+		anomaly.WithReporter(state.NewQueuedReporter[anomaly.Anomaly](stateStore, "power-anomalies",
+			backpressure.ObserveReporter[anomaly.Anomaly](anomaly.NewAPIReporter(apiClient, cfg.SystemID), pressure))),
+		anomaly.WithBackpressure(pressure))
+
+	activityMonitor := activitymon.NewMonitor()
+	mux.Handle("/activity", activityMonitor.Handler())
 
-	controllerV6, err := servicecontroller.NewController(serviceV6)
+	webhooks, err := webhook.NewDispatcher(cfg.SystemID, cfg.Webhooks.Endpoints,
+		webhook.WithRedaction(redaction))
 	if err != nil {
-		log.Error().Err(err).Msg("DHCP V6 controller initialisation error")
+		log.Error().Err(err).Msg("Webhook endpoints error")
 		return 1
 	}
 
-	powerService := power.NewPowerService(cfg.SystemID, &workerPool)
-	httpProxyService := httpproxy.NewHTTPProxyService(runDir, httpProxyCache)
-	dhcpService := dhcp.NewDHCPService(cfg.SystemID, controllerV4, controllerV6, dhcp.WithAPIClient(apiClient))
+	// Operators can quiesce workloads before machines are powered off, or
+	// abort operations, with hooks run synchronously around them.
+	hooks, err := hook.NewRunner(cfg.Hooks)
+	if err != nil {
+		log.Error().Err(err).Msg("Hooks error")
+		return 1
+	}
 
-	workerPool = *worker.NewWorkerPool(cfg.SystemID, temporalClient,
+	workerPoolOptions := []worker.WorkerPoolOption{
 		worker.WithMainWorkerTaskQueueSuffix("agent:main"),
-		worker.WithConfigurator(powerService),
-		worker.WithConfigurator(httpProxyService),
-		worker.WithConfigurator(dhcpService),
+		worker.WithInterceptors(payload.NewGuardInterceptor(payload.DefaultMaxSize),
+			slo.NewInterceptor(latencyTracker), remediation.NewInterceptor(remediationEngine),
+			webhook.NewInterceptor(webhooks), activitymon.NewInterceptor(activityMonitor),
+			anomaly.NewInterceptor(anomalyDetector), hook.NewInterceptor(hooks)),
+		worker.WithConfigurator(latencyTracker),
+		worker.WithConfigurator(remediationEngine),
+		worker.WithConfigurator(webhooks),
+	}
+
+	if exporter != nil {
+		workerPoolOptions = append(workerPoolOptions,
+			worker.WithInterceptors(eventexport.NewInterceptor(exporter)))
+	}
+
+	// Codes of errors are reported by the innermost interceptor, so the
+	// others see them too
+	workerPoolOptions = append(workerPoolOptions, worker.WithInterceptors(errcode.NewInterceptor()))
+
+	subnetServices := subnetmap.New()
+	setupSubnetServices(mux, subnetServices)
+	workerPoolOptions = append(workerPoolOptions,
+		worker.WithConfigurator(subnetmap.NewSubnetMapService(subnetServices)),
+		worker.WithConfigurator(linkcheck.NewService()),
+		worker.WithConfigurator(switchport.NewService()),
+		worker.WithConfigurator(ipconflict.NewService()),
+		worker.WithConfigurator(tagging.NewService(tagging.NewEngine())))
+
+	// Endpoints of rack services are published in rack-local zones, so
+	// preseed templates don't need addresses of the rack. The Region
+	// configures publication of every Agent, so the workflow is registered
+	// even if publication is disabled.
+	var host string
+
+	if !cfg.DNSPublication.Disabled {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Error().Err(err).Msg("Hostname lookup error")
+			return 1
+		}
+
+		host, _, _ = strings.Cut(hostname, ".")
+	}
+
+	workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(
+		dnspub.NewService(host,
+			dnspub.WithEndpoints(cfg.DNSPublication.Endpoints),
+			dnspub.WithTTL(cfg.DNSPublication.TTL),
+			dnspub.WithDisabled(cfg.DNSPublication.Disabled))))
+
+	// Workflows waiting for deployed machines to boot are signalled when
+	// cloud-init phones home with URLs signed by the Agent.
+	phoneHome := phonehome.NewService(cfg.SystemID, temporalClient,
+		blob.NewURLSigner([]byte(cfg.Secret)), phonehome.WithStore(stateStore))
+	mux.Handle(phonehome.PathPrefix, phoneHome)
+	workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(phoneHome))
+
+	// Endpoints used by machines being deployed are also served behind
+	// per-deployment tokens, which are revoked when the deployment ends.
+	deployCreds, err := deploycreds.NewStore(pathutil.GetDataPath("deploycreds/grants.json"))
+	if err != nil {
+		log.Error().Err(err).Msg("Deployment credentials initialisation error")
+		return 1
+	}
+
+	mux.Handle(deploycreds.PathPrefix, deployCreds.Handler(mux, map[string]string{
+		phonehome.PathPrefix:    deploycreds.ScopeCallback,
+		imagecapture.PathPrefix: deploycreds.ScopeCallback,
+		"/artifacts/":           deploycreds.ScopeImages,
+	}))
+	workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(deployCreds))
+
+	// Operations triggered via the local API get stable IDs, so external
+	// automation can safely retry them and poll for their results.
+	operations, err := operation.NewStore(pathutil.GetDataPath("operations"))
+	if err != nil {
+		log.Error().Err(err).Msg("Operation store initialisation error")
+		return 1
+	}
+
+	mux.Handle(operation.PathPrefix, operations.Handler())
+	workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(operations))
+
+	// Workflows and operators can leave short notes about machines, which
+	// are kept across restarts and reported to the Region.
+	annotations, err := annotation.NewStore(pathutil.GetDataPath("annotations.json"))
+	if err != nil {
+		log.Error().Err(err).Msg("Machine annotations initialisation error")
+		return 1
+	}
+
+	annotationService := annotation.NewService(annotations,
+		annotation.WithReporter(annotation.NewAPIReporter(apiClient, cfg.SystemID)))
+	mux.Handle(annotation.PathPrefix, annotationService)
+	workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(annotationService))
+
+	// Traps of PDUs and switches are reported as machine events, so external
+	// power changes are reflected without polling.
+	var (
+		trapReceiver *snmptrap.Receiver
+		trapConns    []net.PacketConn
+	)
+
+	if cfg.SNMPTrap.Port != 0 {
+		trapReceiver, err = snmptrap.NewReceiver(cfg.SNMPTrap.Mappings,
+			snmptrap.WithCommunities(cfg.SNMPTrap.Communities...),
+			snmptrap.WithReporter(state.NewQueuedReporter[snmptrap.MachineEvent](stateStore, "machine-events",
+				backpressure.ObserveReporter[snmptrap.MachineEvent](
+					snmptrap.NewAPIReporter(apiClient, cfg.SystemID), pressure))),
+			snmptrap.WithBackpressure(pressure),
+			snmptrap.WithSignaler(temporalClient))
+		if err != nil {
+			log.Error().Err(err).Msg("SNMP trap mappings error")
+			return 1
+		}
+
+		trapConns, err = listener.ListenPacketDualStack(context.Background(), "udp",
+			cfg.SNMPTrap.Port, nil, listener.DualStack)
+		if err != nil {
+			log.Error().Err(err).Msg("SNMP trap receiver error")
+			return 1
+		}
+
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(trapReceiver))
+	}
+
+	// Queues of events have byte budgets, so a chatty producer cannot make
+	// the Agent run out of memory. Dropped events are counted instead.
+	buffers := []func() ringbuf.Stats{
+		latencyTracker.BufferStats,
+		remediationEngine.BufferStats,
+		webhooks.BufferStats,
+		anomalyDetector.BufferStats,
+	}
+
+	if exporter != nil {
+		buffers = append(buffers, exporter.BufferStats)
+	}
+
+	if trapReceiver != nil {
+		buffers = append(buffers, trapReceiver.BufferStats)
+	}
+
+	mux.Handle(ringbuf.Path, ringbuf.Handler(buffers...))
+
+	tuning := getTuning(cfg)
+	log.Info().Dur("power_command_timeout", tuning.PowerCommandTimeout).
+		Int("power_concurrency", tuning.PowerConcurrency).
+		Dur("probe_timeout", tuning.ProbeTimeout).
+		Int("verify_concurrency", tuning.VerifyConcurrency).
+		Msg("Using tuned defaults")
+
+	var (
+		scheduleOptions = []schedule.ManagerOption{schedule.WithProvider(certRenewer)}
+		idlePolicy      *idle.Policy
 	)
 
+	workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(certRenewer))
+
+	if cfg.hasRole(rolePower) {
+		powerOptions := []power.PowerServiceOption{
+			power.WithCommandTimeout(tuning.PowerCommandTimeout),
+			power.WithConcurrency(tuning.PowerConcurrency),
+			power.WithProbeTimeout(tuning.ProbeTimeout),
+			power.WithRetryPolicy(cfg.Power.Retry),
+			power.WithNativeDrivers(cfg.Power.NativeDrivers...),
+			power.WithStateStore(stateStore),
+			// Recurring power actions of machines are Temporal Schedules
+			power.WithScheduleClient(temporalClient.ScheduleClient()),
+		}
+
+		for driverType, p := range cfg.Power.DriverRetry {
+			powerOptions = append(powerOptions, power.WithDriverRetryPolicy(driverType, p))
+		}
+
+		for driverType, d := range cfg.Power.DriverTimeouts {
+			powerOptions = append(powerOptions, power.WithDriverTimeout(driverType, d))
+		}
+
+		if cfg.Power.SoftOffTimeout > 0 {
+			powerOptions = append(powerOptions, power.WithSoftOffTimeout(cfg.Power.SoftOffTimeout))
+		}
+
+		if cfg.Power.RetryStatsInterval != 0 {
+			powerOptions = append(powerOptions, power.WithRetryStatsInterval(cfg.Power.RetryStatsInterval))
+		}
+
+		if cfg.Power.SweepInterval != 0 {
+			powerOptions = append(powerOptions, power.WithPowerSweepInterval(cfg.Power.SweepInterval))
+		}
+
+		powerService := power.NewPowerService(cfg.SystemID, &workerPool, powerOptions...)
+
+		log.Info().Strs("drivers", powerService.Drivers()).Msg("Native power drivers")
+
+		for _, driverType := range cfg.Power.NativeDrivers {
+			if !slices.Contains(powerService.Drivers(), driverType) {
+				log.Warn().Str("driver", driverType).Msg("No native power driver, using the MAAS power CLI")
+			}
+		}
+
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(powerService))
+		// Retry statistics of power actions are reported to the Region
+		// on a schedule, so it can show machines with degraded BMCs.
+		scheduleOptions = append(scheduleOptions, schedule.WithProvider(powerService))
+
+		// Existing operator scripts using ipmitool can be pointed to
+		// maas-ipmitool, so their commands go through the Agent.
+		if cfg.IPMIBridge.Enabled {
+			bridgeOptions := []ipmibridge.BridgeOption{ipmibridge.WithRemediation(remediationEngine)}
+			if cfg.IPMIBridge.MinInterval > 0 {
+				bridgeOptions = append(bridgeOptions, ipmibridge.WithMinInterval(cfg.IPMIBridge.MinInterval))
+			}
+
+			mux.Handle(ipmibridge.Path, operations.Middleware("ipmi-bridge",
+				ipmibridge.NewBridge(powerService, bridgeOptions...)))
+		}
+
+		// Machines parked off by power-saving policies of the Region are
+		// powered on when something asks for them through the Agent.
+		wakeService := wake.NewService(powerService, temporalClient,
+			wake.WithRemediation(remediationEngine))
+		mux.Handle(wake.PathPrefix, wakeService)
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(wakeService))
+
+		// Idle machines are tracked even with the policy disabled, so the
+		// energy it would save can be seen before enabling it.
+		idlePolicy = idle.NewPolicy(cfg.Power.IdleOff, powerService,
+			idle.WithParker(wakeService),
+			idle.WithMetricMeter(meterProvider.Meter("idle")))
+		mux.Handle(idle.Path, idlePolicy.Handler())
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(idlePolicy))
+
+		// Consoles of composed VMs are opened by the Region UI through
+		// the Agent, with sessions created by the Region. Sessions can be
+		// recorded to the artifact store for compliance review.
+		consoleProxy := console.NewProxy(console.WithRecordingStore(redactedStore))
+		mux.Handle(console.PathPrefix, consoleProxy)
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(consoleProxy))
+
+		// Disks of deployed machines are uploaded by the ephemeral
+		// environment to the artifact store with signed URLs.
+		imageCapture := imagecapture.NewService(cfg.SystemID, redactedStore,
+			blob.NewURLSigner([]byte(cfg.Secret)))
+		mux.Handle(imagecapture.PathPrefix, imageCapture)
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(imageCapture))
+
+		workerPoolOptions = append(workerPoolOptions,
+			worker.WithConfigurator(burnin.NewService(cfg.SystemID)))
+	}
+
+	// Network boot of machines powered on by power-on-watched is watched
+	// through leases of dhcpd and requests of the HTTP proxy.
+	netbootWatcher := netboot.NewWatcher()
+
+	var httpProxyService *httpproxy.HTTPProxyService
+
+	if cfg.hasRole(roleHTTPProxy) {
+		httpProxyCache, err := cache.NewFileCache(
+			cfg.HTTPProxy.CacheSize,
+			cfg.HTTPProxy.CacheDir,
+			cache.WithMetricMeter(meterProvider.Meter("httpproxy")),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("HTTP Proxy cache initialisation error")
+			return 1
+		}
+
+		setupDiskUsage(mux, artifactStore, httpProxyCache)
+
+		cfg.HTTPProxy.Bindings, err = resolveBindings(ifResolver, cfg.HTTPProxy.Bindings)
+		if err != nil {
+			log.Error().Err(err).Msg("HTTP Proxy binding error")
+			return 1
+		}
+
+		httpProxyService = httpproxy.NewHTTPProxyService(runDir,
+			httpproxy.NewGuardedCache(httpProxyCache, func() bool { return fsMonitor.Healthy("image-cache") }),
+			httpproxy.WithBindings(cfg.HTTPProxy.Port, cfg.HTTPProxy.Bindings),
+			httpproxy.WithFamilies(cfg.HTTPProxy.Families),
+			httpproxy.WithSubnetServices(subnetServices),
+			httpproxy.WithRequestObserver(netbootWatcher.ObserveHTTP))
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(httpProxyService))
+
+		// Bootloaders of every supported client architecture are served
+		// regardless of the architecture of the rack
+		mux.Handle(httpproxy.BootloadersPath, httpProxyService.BootloadersHandler())
+
+		imageSyncOptions := []imagesync.ServiceOption{
+			imagesync.WithVerifier(imagesync.NewVerifier(
+				imagesync.WithConcurrency(tuning.VerifyConcurrency),
+				// Serving images to machines takes precedence over verification
+				imagesync.WithIOPriority(imagesync.IOPriorityBestEffort))),
+		}
+
+		if cfg.ImageSync.Interval != 0 {
+			imageSyncOptions = append(imageSyncOptions, imagesync.WithSyncInterval(cfg.ImageSync.Interval))
+		}
+
+		imageSync := imagesync.NewService(cfg.SystemID, pathutil.GetDataPath("boot-resources"),
+			imageFetcher, imageSyncOptions...)
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(imageSync))
+		scheduleOptions = append(scheduleOptions, schedule.WithProvider(imageSync))
+	} else {
+		setupDiskUsage(mux, artifactStore, nil)
+	}
+
+	if cfg.hasRole(roleDHCP) {
+		serviceV4 := servicecontroller.GetServiceName(servicecontroller.DHCPv4)
+
+		controllerV4, err := servicecontroller.NewController(serviceV4)
+		if err != nil {
+			log.Error().Err(err).Msg("DHCP V4 controller initialisation error")
+			return 1
+		}
+
+		serviceV6 := servicecontroller.GetServiceName(servicecontroller.DHCPv6)
+
+		controllerV6, err := servicecontroller.NewController(serviceV6)
+		if err != nil {
+			log.Error().Err(err).Msg("DHCP V6 controller initialisation error")
+			return 1
+		}
+
+		dhcpService := dhcp.NewDHCPService(cfg.SystemID, controllerV4, controllerV6,
+			dhcp.WithAPIClient(apiClient),
+			dhcp.WithInterfaceResolver(ifResolver.ServingInterfaces),
+			dhcp.WithArtifactStore(redactedStore),
+			dhcp.WithBackpressure(pressure),
+			dhcp.WithNotificationObserver(netbootWatcher.ObserveDHCP))
+		workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(dhcpService),
+			worker.WithConfigurator(netbootWatcher))
+
+		mux.Handle("/dhcp/leases", dhcpService.LeasesHandler())
+	}
+
+	regionAddresses := make([]string, 0, len(cfg.Controllers))
+	for _, c := range cfg.Controllers {
+		regionAddresses = append(regionAddresses,
+			net.JoinHostPort(c, strconv.Itoa(defaultMAASInternalAPIPort)))
+	}
+
+	// Host network configuration is rolled back if the Region is not
+	// reachable after it was applied.
+	netplanService := netplan.NewNetplanService(
+		netplan.WithConnectivityCheck(netplan.DialCheck(regionAddresses)))
+	workerPoolOptions = append(workerPoolOptions, worker.WithConfigurator(netplanService))
+
+	// Packaging calls lifecycle hooks around restarts of the Agent (e.g. snap
+	// refreshes), so in-flight activities are completed and buffered events
+	// are delivered before the Agent is stopped. Activities are registered
+	// by the outermost interceptor, so rejected ones are not reported.
+	lifecycleManager := lifecycle.NewManager(
+		lifecycle.WithFlusher("events", flushBuffers(buffers)),
+		lifecycle.WithHealthCheck("data-directories", func() error {
+			for _, s := range fsMonitor.Statuses() {
+				if !s.Healthy() {
+					return fmt.Errorf("%s: %s", s.Subsystem, s.Error)
+				}
+			}
+
+			return nil
+		}))
+	mux.Handle(lifecycle.PathPrefix, lifecycleManager.Handler())
+
+	workerPoolOptions = append([]worker.WorkerPoolOption{
+		worker.WithInterceptors(lifecycle.NewInterceptor(lifecycleManager)),
+	}, workerPoolOptions...)
+
+	workerPool = *worker.NewWorkerPool(cfg.SystemID, temporalClient, workerPoolOptions...)
+
+	// Histories of workflows are exported for bug reports and replayed
+	// to check that they can be executed by this version of the Agent
+	mux.Handle(history.PathPrefix, history.NewHandler(temporalClient, workerPool.Workflows()))
+
+	// Synthetic power workload is executed with the simulator driver,
+	// so capacity of the rack can be measured without touching BMCs
+	if cfg.hasRole(rolePower) {
+		loadTests := loadtest.NewRunner(cfg.SystemID,
+			loadtest.NewTemporalExecutor(temporalClient, workerPool.TaskQueue()))
+		mux.Handle(loadtest.Path, loadTests.Handler())
+	}
+
 	workerPoolBackoff := backoff.NewExponentialBackOff()
 	workerPoolBackoff.MaxElapsedTime = 60 * time.Second
 
@@ -505,6 +1533,38 @@ func Run() int {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	go fsMonitor.Run(ctx)
+	go latencyTracker.Run(ctx)
+	go remediationEngine.Run(ctx)
+	go webhooks.Run(ctx)
+	go anomalyDetector.Run(ctx)
+	go exporter.Run(ctx)
+
+	if idlePolicy != nil {
+		go idlePolicy.Run(ctx)
+	}
+
+	if trapReceiver != nil {
+		go trapReceiver.Run(ctx)
+
+		for _, conn := range trapConns {
+			go func(conn net.PacketConn) {
+				if err := trapReceiver.Serve(ctx, conn); err != nil {
+					log.Error().Err(err).Msg("SNMP trap receiver failure")
+				}
+			}(conn)
+		}
+	}
+
+	go func() {
+		err := opJournal.Recover(ctx, map[string]journal.RecoverFunc{
+			imagesync.OperationFetch: imageFetcher.Resume,
+		})
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to recover interrupted operations")
+		}
+	}()
+
 	// NOTE: Signal Region Controller that Agent has started.
 	// This should trigger configuration workflows execution.
 	// Region controller will start configuration workflows based on certain
@@ -513,7 +1573,8 @@ func Run() int {
 	// Once Region can detect that Agent was reconnected or restarted via
 	// Temporal server API, we should no longer need this.
 	type configureAgentParam struct {
-		SystemID string `json:"system_id"`
+		SystemID string   `json:"system_id"`
+		Roles    []string `json:"roles"`
 	}
 
 	workflowOptions := client.StartWorkflowOptions{
@@ -526,7 +1587,7 @@ func Run() int {
 	}
 
 	workflowRun, err := temporalClient.ExecuteWorkflow(ctx, workflowOptions,
-		"configure-agent", configureAgentParam{SystemID: cfg.SystemID},
+		"configure-agent", configureAgentParam{SystemID: cfg.SystemID, Roles: cfg.Roles},
 	)
 
 	if err != nil {
@@ -539,13 +1600,42 @@ func Run() int {
 		return 1
 	}
 
+	lifecycleManager.Started()
+
+	// Power transitions might have been interrupted while the Agent was down.
+	// Reconciliation runs in the background and reports to the Region itself.
+	if cfg.hasRole(rolePower) {
+		_, err = temporalClient.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+			ID:                       fmt.Sprintf("reconcile-power-states:%s", cfg.SystemID),
+			TaskQueue:                workerPool.TaskQueue(),
+			WorkflowExecutionTimeout: 10 * time.Minute,
+			WorkflowIDReusePolicy:    enums.WORKFLOW_ID_REUSE_POLICY_TERMINATE_IF_RUNNING,
+		}, "reconcile-power-states", power.ReconcilePowerStatesParam{SystemID: cfg.SystemID})
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to start power states reconciliation")
+		}
+	}
+
+	// Recurring maintenance work is executed via Temporal Schedules, so it is
+	// not affected by Agent restarts or rack clock drift.
+	scheduleManager := schedule.NewManager(temporalClient.ScheduleClient(),
+		cfg.SystemID, workerPool.TaskQueue(), scheduleOptions...)
+
+	if err := scheduleManager.Reconcile(ctx); err != nil {
+		// Schedules are not critical for the Agent to start and will be
+		// reconciled again on the next start.
+		log.Warn().Err(err).Msg("Failed to reconcile schedules")
+	}
+
 	go func() {
 		fatal <- workerPool.Error()
 	}()
 
-	go func() {
-		fatal <- httpProxyService.Error()
-	}()
+	if httpProxyService != nil {
+		go func() {
+			fatal <- httpProxyService.Error()
+		}()
+	}
 
 	log.Info().Msg("Service MAAS Agent started")
 
@@ -553,15 +1643,35 @@ func Run() int {
 
 	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
 
-	select {
-	case err := <-fatal:
-		log.Err(err).Msg("Service failure")
-		return 1
-	case <-sigs:
-		return 0
+	for {
+		select {
+		case err := <-fatal:
+			log.Err(err).Msg("Service failure")
+			return 1
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				return 0
+			}
+
+			// Listening services are switched over without refusing
+			// connections, everything else requires a restart.
+			if httpProxyService != nil {
+				if err := reloadHTTPProxyBindings(ifResolver, httpProxyService); err != nil {
+					log.Error().Err(err).Msg("Failed to reload configuration")
+					continue
+				}
+			}
+
+			log.Info().Msg("Configuration reloaded")
+		}
 	}
 }
 
 func main() {
+	// Debug commands query the running Agent
+	if len(os.Args) > 1 {
+		os.Exit(cli.Main(os.Args[1:], getSocketPath(), os.Stdout, os.Stderr))
+	}
+
 	os.Exit(Run())
 }
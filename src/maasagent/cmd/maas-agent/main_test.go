@@ -16,9 +16,12 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetRunDir(t *testing.T) {
@@ -80,3 +83,51 @@ func TestCertificatesDir(t *testing.T) {
 		})
 	}
 }
+
+func TestGetConfigRoles(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out []string
+		err string
+	}{
+		"default": {
+			in:  "system_id: abc\n",
+			out: supportedRoles,
+		},
+		"subset": {
+			in:  "system_id: abc\nroles: [power]\n",
+			out: []string{rolePower},
+		},
+		"unknown": {
+			in:  "system_id: abc\nroles: [power, tftp]\n",
+			err: `configuration error: unknown role "tftp"`,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "agent.yaml")
+			require.NoError(t, os.WriteFile(fname, []byte(tc.in), 0o600))
+			t.Setenv("MAAS_AGENT_CONFIG", fname)
+
+			cfg, err := getConfig()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, cfg.Roles)
+		})
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	cfg := &config{Roles: []string{roleDHCP, rolePower}}
+
+	assert.True(t, cfg.hasRole(roleDHCP))
+	assert.True(t, cfg.hasRole(rolePower))
+	assert.False(t, cfg.hasRole(roleHTTPProxy))
+}
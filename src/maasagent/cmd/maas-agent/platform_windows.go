@@ -0,0 +1,38 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// supportedRoles are roles, which can be enabled on this platform. Only
+// native power drivers are available on Windows, so the Agent can run on
+// jump hosts of OOB management networks.
+var supportedRoles = []string{rolePower}
+
+// defaultRunDir returns directory for runtime files on Windows.
+func defaultRunDir() string {
+	return filepath.Join(os.Getenv("ProgramData"), "MAAS", "run")
+}
+
+// defaultConfigPath returns path of the Agent configuration on Windows.
+func defaultConfigPath() string {
+	return filepath.Join(os.Getenv("ProgramData"), "MAAS", "agent.yaml")
+}
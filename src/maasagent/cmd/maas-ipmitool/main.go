@@ -0,0 +1,155 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/adminauth"
+	"maas.io/core/src/maasagent/internal/ipmibridge"
+	"maas.io/core/src/maasagent/internal/operation"
+)
+
+const requestTimeout = 5 * time.Minute
+
+// getRunDir returns the run directory of the MAAS Agent
+func getRunDir() string {
+	if name := os.Getenv("SNAP_INSTANCE_NAME"); name != "" {
+		return fmt.Sprintf("/run/snap.%s", name)
+	}
+
+	return "/run/maas"
+}
+
+// password resolves the password of -E and -f options like ipmitool does
+func password(args []string) (string, error) {
+	for i, arg := range args {
+		switch arg {
+		case "-E":
+			if p, ok := os.LookupEnv("IPMI_PASSWORD"); ok {
+				return p, nil
+			}
+
+			return os.Getenv("IPMITOOL_PASSWORD"), nil
+		case "-f":
+			if i+1 >= len(args) {
+				return "", nil
+			}
+
+			f, err := os.Open(filepath.Clean(args[i+1]))
+			if err != nil {
+				return "", err
+			}
+
+			//nolint:errcheck // should be safe to ignore an error from Close()
+			defer f.Close()
+
+			s := bufio.NewScanner(f)
+			s.Scan()
+
+			return strings.TrimRight(s.Text(), "\r"), s.Err()
+		}
+	}
+
+	return "", nil
+}
+
+func Run() int {
+	args := os.Args[1:]
+
+	p, err := password(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read password: %s\n", err)
+		return 1
+	}
+
+	body, err := json.Marshal(ipmibridge.Request{Args: args, Password: p})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	socketPath := path.Join(getRunDir(), "agent-http.sock")
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+		Timeout: requestTimeout,
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://agent"+ipmibridge.Path, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// Sites restricting the local API of the Agent issue tokens to scripts,
+	// unless they are authenticated as OS users
+	if token := os.Getenv(adminauth.TokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	// Scripts can retry commands with the same ID without executing them twice
+	if id := os.Getenv("MAAS_OPERATION_ID"); id != "" {
+		req.Header.Set(operation.HeaderIdempotencyKey, id)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to reach MAAS Agent: %s\n", err)
+		return 1
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "MAAS Agent error: %s\n", resp.Status)
+		return 1
+	}
+
+	var res ipmibridge.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid MAAS Agent response: %s\n", err)
+		return 1
+	}
+
+	fmt.Fprint(os.Stdout, res.Stdout)
+	fmt.Fprint(os.Stderr, res.Stderr)
+
+	return res.ExitCode
+}
+
+func main() {
+	os.Exit(Run())
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package activitymon
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+// NewInterceptor returns a worker interceptor that records workflow and
+// activity executions in the Monitor.
+func NewInterceptor(m *Monitor) interceptor.WorkerInterceptor {
+	return &monitorInterceptor{monitor: m}
+}
+
+type monitorInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	monitor *Monitor
+}
+
+func (x *monitorInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityMonitor{monitor: x.monitor}
+	i.Next = next
+
+	return i
+}
+
+func (x *monitorInterceptor) InterceptWorkflow(_ workflow.Context,
+	next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	i := &workflowMonitor{monitor: x.monitor}
+	i.Next = next
+
+	return i
+}
+
+type activityMonitor struct {
+	interceptor.ActivityInboundInterceptorBase
+	monitor *Monitor
+}
+
+func (a *activityMonitor) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	info := activity.GetInfo(ctx)
+	key := info.WorkflowExecution.RunID + "/" + info.ActivityID
+	p := powerParam(in.Args)
+
+	a.monitor.Start(key, Execution{
+		Kind:          KindActivity,
+		Name:          info.ActivityType.Name,
+		WorkflowID:    info.WorkflowExecution.ID,
+		Driver:        p.DriverType,
+		Requester:     p.Requester,
+		Reason:        p.Reason,
+		CorrelationID: p.CorrelationID,
+		Attempt:       info.Attempt,
+	})
+
+	res, err := a.Next.ExecuteActivity(ctx, in)
+
+	a.monitor.Finish(key, err)
+
+	return res, err
+}
+
+// monitoredParam are fields of power activity parameters shown in the
+// Monitor
+type monitoredParam struct {
+	DriverType    string `json:"driver_type"`
+	Requester     string `json:"requester"`
+	Reason        string `json:"reason"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+// powerParam returns fields of power activity parameters, which are empty
+// for other activities
+func powerParam(args []interface{}) monitoredParam {
+	var param monitoredParam
+
+	if len(args) == 0 {
+		return param
+	}
+
+	b, err := json.Marshal(args[0])
+	if err != nil {
+		return param
+	}
+
+	if err := json.Unmarshal(b, &param); err != nil {
+		return monitoredParam{}
+	}
+
+	return param
+}
+
+type workflowMonitor struct {
+	interceptor.WorkflowInboundInterceptorBase
+	monitor *Monitor
+}
+
+// ExecuteWorkflow records workflow runs. Workflows are executed again when
+// their history is replayed (e.g. after eviction from the cache or to answer
+// a query of a closed workflow), so runs are identified by run ID and are
+// recorded as finished only when the workflow returns for real.
+func (w *workflowMonitor) ExecuteWorkflow(ctx workflow.Context,
+	in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	info := workflow.GetInfo(ctx)
+	key := info.WorkflowExecution.RunID
+
+	w.monitor.Start(key, Execution{
+		StartedAt:  info.WorkflowStartTime,
+		Kind:       KindWorkflow,
+		Name:       info.WorkflowType.Name,
+		WorkflowID: info.WorkflowExecution.ID,
+		Attempt:    info.Attempt,
+	})
+
+	res, err := w.Next.ExecuteWorkflow(ctx, in)
+
+	switch {
+	case workflow.IsReplaying(ctx):
+		w.monitor.Forget(key)
+	case workflow.IsContinueAsNewError(err):
+		// The run is complete, the workflow continues with a new run
+		w.monitor.Finish(key, nil)
+	default:
+		w.monitor.Finish(key, err)
+	}
+
+	return res, err
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package activitymon keeps a live view of workflows and activities
+// executed by the Agent, together with error rates of power drivers,
+// so the Agent can be troubleshooted on the host (e.g. with maas-agent top)
+// without a metrics stack.
+package activitymon
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWindow  = 15 * time.Minute
+	defaultHistory = 50
+	bucketSize     = time.Minute
+)
+
+// Kinds of executions
+const (
+	KindWorkflow = "workflow"
+	KindActivity = "activity"
+)
+
+// States of executions
+const (
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+)
+
+// Execution is a workflow or an activity execution
+type Execution struct {
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Kind       string     `json:"kind"`
+	Name       string     `json:"name"`
+	WorkflowID string     `json:"workflow_id"`
+	// Driver is the power driver used by the activity, if any
+	Driver string `json:"driver,omitempty"`
+	// Requester, Reason and CorrelationID are metadata of the request of
+	// the power action, if any
+	Requester     string `json:"requester,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	State         string `json:"state"`
+	Error         string `json:"error,omitempty"`
+	Attempt       int32  `json:"attempt,omitempty"`
+}
+
+// DriverStats are numbers of activity executions using a power driver
+// within the window
+type DriverStats struct {
+	Driver    string  `json:"driver"`
+	Total     int     `json:"total"`
+	Failed    int     `json:"failed"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// Snapshot is the current state of the Monitor
+type Snapshot struct {
+	Time time.Time `json:"time"`
+	// Running are executions in progress, oldest first
+	Running []Execution `json:"running"`
+	// Recent are finished executions, most recent first
+	Recent  []Execution   `json:"recent"`
+	Drivers []DriverStats `json:"drivers"`
+	// Window is the period of DriverStats in seconds
+	Window int64 `json:"window"`
+}
+
+// bucket counts driver executions finished within bucketSize
+type bucket struct {
+	start  time.Time
+	total  int
+	failed int
+}
+
+// Monitor tracks executions of workflows and activities
+type Monitor struct {
+	now     func() time.Time
+	running map[string]Execution
+	drivers map[string][]bucket
+	recent  []Execution
+	window  time.Duration
+	history int
+	mutex   sync.Mutex
+}
+
+// MonitorOption allows to set additional Monitor options
+type MonitorOption func(*Monitor)
+
+// NewMonitor returns a Monitor
+func NewMonitor(options ...MonitorOption) *Monitor {
+	m := &Monitor{
+		now:     time.Now,
+		running: make(map[string]Execution),
+		drivers: make(map[string][]bucket),
+		window:  defaultWindow,
+		history: defaultHistory,
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	return m
+}
+
+// WithWindow sets the period over which driver error rates are calculated.
+// (default: 15 minutes)
+func WithWindow(d time.Duration) MonitorOption {
+	return func(m *Monitor) {
+		m.window = d
+	}
+}
+
+// WithHistory sets how many finished executions are kept.
+// (default: 50)
+func WithHistory(n int) MonitorOption {
+	return func(m *Monitor) {
+		m.history = n
+	}
+}
+
+// Start records execution e identified by key (e.g. workflow run ID) as
+// running. Starting the same key again (e.g. when workflow history is
+// replayed) keeps the original start time.
+func (m *Monitor) Start(key string, e Execution) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.running[key]; ok {
+		return
+	}
+
+	if e.StartedAt.IsZero() {
+		e.StartedAt = m.now()
+	}
+
+	e.State = StateRunning
+	m.running[key] = e
+}
+
+// Finish records execution identified by key as finished, failed if err
+// is not nil. Unknown keys are ignored.
+func (m *Monitor) Finish(key string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.running[key]
+	if !ok {
+		return
+	}
+
+	delete(m.running, key)
+
+	now := m.now()
+	e.FinishedAt = &now
+	e.State = StateCompleted
+
+	if err != nil {
+		e.State = StateFailed
+		e.Error = err.Error()
+	}
+
+	m.recent = append([]Execution{e}, m.recent...)
+	if len(m.recent) > m.history {
+		m.recent = m.recent[:m.history]
+	}
+
+	if e.Driver != "" {
+		m.count(e.Driver, now, err != nil)
+	}
+}
+
+// Forget removes execution identified by key without recording it
+// as finished.
+func (m *Monitor) Forget(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.running, key)
+}
+
+func (m *Monitor) count(driver string, now time.Time, failed bool) {
+	start := now.Truncate(bucketSize)
+	buckets := expire(m.drivers[driver], now.Add(-m.window))
+
+	if n := len(buckets); n == 0 || !buckets[n-1].start.Equal(start) {
+		buckets = append(buckets, bucket{start: start})
+	}
+
+	b := &buckets[len(buckets)-1]
+	b.total++
+
+	if failed {
+		b.failed++
+	}
+
+	m.drivers[driver] = buckets
+}
+
+// expire drops buckets that ended before since. Buckets are ordered,
+// so expired ones are at the beginning.
+func expire(buckets []bucket, since time.Time) []bucket {
+	i := sort.Search(len(buckets), func(i int) bool {
+		return buckets[i].start.Add(bucketSize).After(since)
+	})
+
+	return buckets[i:]
+}
+
+// Snapshot returns the current state of the Monitor
+func (m *Monitor) Snapshot() Snapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	s := Snapshot{
+		Time:    now,
+		Running: make([]Execution, 0, len(m.running)),
+		Recent:  append([]Execution{}, m.recent...),
+		Drivers: []DriverStats{},
+		Window:  int64(m.window / time.Second),
+	}
+
+	for _, e := range m.running {
+		s.Running = append(s.Running, e)
+	}
+
+	sort.Slice(s.Running, func(i, j int) bool {
+		return s.Running[i].StartedAt.Before(s.Running[j].StartedAt)
+	})
+
+	since := now.Add(-m.window)
+
+	for driver, buckets := range m.drivers {
+		buckets = expire(buckets, since)
+		if len(buckets) == 0 {
+			delete(m.drivers, driver)
+			continue
+		}
+
+		m.drivers[driver] = buckets
+
+		stats := DriverStats{Driver: driver}
+		for _, b := range buckets {
+			stats.Total += b.total
+			stats.Failed += b.failed
+		}
+
+		stats.ErrorRate = float64(stats.Failed) / float64(stats.Total)
+		s.Drivers = append(s.Drivers, stats)
+	}
+
+	sort.Slice(s.Drivers, func(i, j int) bool { return s.Drivers[i].Driver < s.Drivers[j].Driver })
+
+	return s
+}
+
+// Handler returns http.Handler serving the Snapshot
+func (m *Monitor) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(m.Snapshot())
+	})
+}
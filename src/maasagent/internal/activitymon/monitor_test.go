@@ -0,0 +1,205 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package activitymon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestMonitor(options ...MonitorOption) (*Monitor, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)}
+
+	m := NewMonitor(options...)
+	m.now = clock.Now
+
+	return m, clock
+}
+
+func TestMonitorRunning(t *testing.T) {
+	t.Parallel()
+
+	m, clock := newTestMonitor()
+
+	m.Start("run-1", Execution{Kind: KindWorkflow, Name: "deploy", WorkflowID: "wf-1"})
+	clock.Advance(time.Second)
+	m.Start("run-1/1", Execution{Kind: KindActivity, Name: "power-on", WorkflowID: "wf-1",
+		Driver: "ipmi"})
+	clock.Advance(time.Second)
+	// Replayed workflow keeps the original start time
+	m.Start("run-1", Execution{Kind: KindWorkflow, Name: "deploy", WorkflowID: "wf-1"})
+
+	s := m.Snapshot()
+
+	require.Len(t, s.Running, 2)
+	assert.Equal(t, "deploy", s.Running[0].Name)
+	assert.Equal(t, StateRunning, s.Running[0].State)
+	assert.Equal(t, clock.now.Add(-2*time.Second), s.Running[0].StartedAt)
+	assert.Equal(t, "power-on", s.Running[1].Name)
+	assert.Empty(t, s.Recent)
+	assert.Empty(t, s.Drivers)
+
+	m.Finish("run-1/1", errors.New("BMC unreachable"))
+	m.Forget("run-1")
+
+	s = m.Snapshot()
+
+	assert.Empty(t, s.Running)
+	require.Len(t, s.Recent, 1)
+	assert.Equal(t, StateFailed, s.Recent[0].State)
+	assert.Equal(t, "BMC unreachable", s.Recent[0].Error)
+	assert.Equal(t, clock.now, *s.Recent[0].FinishedAt)
+}
+
+func TestMonitorHistory(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newTestMonitor(WithHistory(2))
+
+	for _, key := range []string{"a", "b", "c"} {
+		m.Start(key, Execution{Name: key})
+		m.Finish(key, nil)
+	}
+
+	// Unknown executions are ignored
+	m.Finish("d", nil)
+
+	s := m.Snapshot()
+
+	require.Len(t, s.Recent, 2)
+	assert.Equal(t, "c", s.Recent[0].Name)
+	assert.Equal(t, StateCompleted, s.Recent[0].State)
+	assert.Equal(t, "b", s.Recent[1].Name)
+}
+
+func TestMonitorDrivers(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		elapsed time.Duration
+		out     []DriverStats
+	}{
+		"within window": {
+			elapsed: 10 * time.Minute,
+			out: []DriverStats{
+				{Driver: "ipmi", Total: 4, Failed: 3, ErrorRate: 0.75},
+				{Driver: "redfish", Total: 1},
+			},
+		},
+		"partially expired": {
+			elapsed: 16 * time.Minute,
+			out: []DriverStats{
+				{Driver: "ipmi", Total: 2, Failed: 2, ErrorRate: 1},
+			},
+		},
+		"expired": {
+			elapsed: time.Hour,
+			out:     []DriverStats{},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m, clock := newTestMonitor(WithWindow(15 * time.Minute))
+
+			record := func(driver string, err error) {
+				m.Start("key", Execution{Kind: KindActivity, Driver: driver})
+				m.Finish("key", err)
+			}
+
+			record("ipmi", nil)
+			record("ipmi", errors.New("timeout"))
+			record("redfish", nil)
+			// Activities without a driver are not counted
+			record("", errors.New("failure"))
+
+			clock.Advance(5 * time.Minute)
+			record("ipmi", errors.New("timeout"))
+			record("ipmi", errors.New("timeout"))
+
+			clock.Advance(tc.elapsed - 5*time.Minute)
+
+			s := m.Snapshot()
+			assert.Equal(t, tc.out, s.Drivers)
+			assert.Equal(t, int64(900), s.Window)
+		})
+	}
+}
+
+func TestMonitorHandler(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newTestMonitor()
+	m.Start("run-1", Execution{Kind: KindWorkflow, Name: "deploy", WorkflowID: "wf-1"})
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/activity", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var s Snapshot
+
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &s))
+	require.Len(t, s.Running, 1)
+	assert.Equal(t, "wf-1", s.Running[0].WorkflowID)
+	assert.Equal(t, []Execution{}, s.Recent)
+
+	rec = httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/activity", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestPowerParam(t *testing.T) {
+	assert.Equal(t, monitoredParam{
+		DriverType:    "ipmi",
+		Requester:     "admin",
+		Reason:        "deploy",
+		CorrelationID: "req-1",
+	}, powerParam([]interface{}{map[string]interface{}{
+		"driver_type":    "ipmi",
+		"driver_opts":    map[string]interface{}{"power_address": "10.0.0.1"},
+		"requester":      "admin",
+		"reason":         "deploy",
+		"correlation_id": "req-1",
+	}}))
+
+	assert.Equal(t, monitoredParam{}, powerParam(nil))
+	assert.Equal(t, monitoredParam{}, powerParam([]interface{}{"system-id"}))
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package adminauth authenticates clients of the local API of the Agent,
+// so multi-operator sites can restrict who may trigger power actions and
+// other operations locally. Clients are authenticated with bearer tokens,
+// client certificates (mTLS) or as local OS users connected to the socket.
+package adminauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TLSSocketName is the name of the socket serving the local API with mTLS,
+// next to the plain one
+const TLSSocketName = "agent-https.sock"
+
+// TokenEnv is the environment variable with the token used by clients
+const TokenEnv = "MAAS_AGENT_TOKEN"
+
+// Authentication methods
+const (
+	MethodToken = "token"
+	MethodCert  = "cert"
+	MethodPeer  = "peer"
+)
+
+var (
+	// ErrNoCredentials is returned by Authenticator when the request has no
+	// credentials of its kind, so other authenticators are tried
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned when credentials are not valid
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrNotAllowed is returned when the client is authenticated, but is not
+	// allowed to use the local API
+	ErrNotAllowed = errors.New("not allowed")
+	// ErrInvalidConfig is returned for invalid authentication configuration
+	ErrInvalidConfig = errors.New("invalid admin API authentication configuration")
+	// ErrUnsupported is returned when the authentication method is not
+	// supported on this platform
+	ErrUnsupported = errors.New("unsupported authentication method")
+)
+
+// Identity of an authenticated client
+type Identity struct {
+	User string `json:"user"`
+	// Method is the authentication method, e.g. MethodToken
+	Method string `json:"method"`
+	// Groups of local OS users
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Authenticator authenticates requests by one kind of credentials
+type Authenticator interface {
+	// Authenticate returns identity of the client, ErrNoCredentials if the
+	// request has no credentials of this kind, or another error if they are
+	// not valid
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns ctx carrying id
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns identity of the client which made the request,
+// or false if it is anonymous
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Guard rejects requests which are not authenticated by any of its
+// authenticators, or which are above the level granted to the client
+type Guard struct {
+	authorizer     *Authorizer
+	decisions      *DecisionLog
+	authenticators []Authenticator
+	dangerousPaths []string
+	anonymousReads bool
+}
+
+// GuardOption allows to set additional Guard options
+type GuardOption func(*Guard)
+
+// NewGuard returns Guard accepting requests authenticated by any of
+// authenticators
+func NewGuard(authenticators []Authenticator, options ...GuardOption) *Guard {
+	g := &Guard{authenticators: authenticators}
+
+	for _, opt := range options {
+		opt(g)
+	}
+
+	return g
+}
+
+// WithAnonymousReads allows GET and HEAD requests without credentials, so
+// monitoring keeps working while only operations require authentication
+func WithAnonymousReads() GuardOption {
+	return func(g *Guard) {
+		g.anonymousReads = true
+	}
+}
+
+// WithAuthorizer limits authenticated clients to levels granted by a.
+// Without it, authenticated clients can perform any operation.
+func WithAuthorizer(a *Authorizer) GuardOption {
+	return func(g *Guard) {
+		g.authorizer = a
+	}
+}
+
+// WithDangerousPaths marks requests of paths (or path prefixes ending with
+// "/") as dangerous, regardless of their method, e.g. power actions
+func WithDangerousPaths(paths ...string) GuardOption {
+	return func(g *Guard) {
+		g.dangerousPaths = append(g.dangerousPaths, paths...)
+	}
+}
+
+// WithDecisionLog records decisions of g in l
+func WithDecisionLog(l *DecisionLog) GuardOption {
+	return func(g *Guard) {
+		g.decisions = l
+	}
+}
+
+// Handler returns next guarded by g. Identity of the client is available
+// to next with IdentityFromContext.
+func (g *Guard) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		level := g.levelOf(r)
+		d := Decision{Request: r.Method + " " + r.URL.Path, Level: level}
+
+		id, err := g.authenticate(r)
+
+		switch {
+		case err == nil:
+			d.User, d.Method = id.User, id.Method
+			err = g.authorize(id, level)
+		case g.anonymousReads && level == LevelQuery:
+			err = nil
+		}
+
+		d.Allowed = err == nil
+		if err != nil {
+			d.Reason = err.Error()
+		}
+
+		g.record(d)
+
+		switch {
+		case err == nil && d.User != "":
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, ErrNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="maas-agent"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+	})
+}
+
+// levelOf returns the level of the operation requested by r
+func (g *Guard) levelOf(r *http.Request) Level {
+	for _, p := range g.dangerousPaths {
+		if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+			return LevelDangerous
+		}
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return LevelQuery
+	}
+
+	return LevelMutate
+}
+
+func (g *Guard) authorize(id Identity, level Level) error {
+	if g.authorizer == nil {
+		return nil
+	}
+
+	return g.authorizer.Authorize(id, level)
+}
+
+// record logs operations and every rejected request. Queries are only kept
+// in the decision log, as monitoring makes them all the time.
+func (g *Guard) record(d Decision) {
+	switch {
+	case !d.Allowed:
+		log.Warn().Str("user", d.User).Str("request", d.Request).Str("level", string(d.Level)).
+			Str("reason", d.Reason).Msg("Admin API request rejected")
+	case d.Level != LevelQuery:
+		log.Info().Str("user", d.User).Str("method", d.Method).Str("request", d.Request).
+			Str("level", string(d.Level)).Msg("Admin API request")
+	}
+
+	if g.decisions != nil && (!d.Allowed || d.Level != LevelQuery) {
+		g.decisions.Record(d)
+	}
+}
+
+// authenticate returns identity established by the first authenticator
+// accepting the request. Otherwise the error of the last authenticator
+// which found credentials is returned, if any.
+func (g *Guard) authenticate(r *http.Request) (Identity, error) {
+	failure := ErrNoCredentials
+
+	for _, a := range g.authenticators {
+		id, err := a.Authenticate(r)
+		if err == nil {
+			return id, nil
+		}
+
+		if !errors.Is(err, ErrNoCredentials) {
+			failure = err
+		}
+	}
+
+	return Identity{}, failure
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package adminauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func fakePeerAuthenticator() *PeerAuthenticator {
+	users := map[string]*user.User{
+		"1000": {Uid: "1000", Username: "alice"},
+		"1001": {Uid: "1001", Username: "bob"},
+		"1002": {Uid: "1002", Username: "carol"},
+	}
+
+	return &PeerAuthenticator{
+		lookupUser: func(uid string) (*user.User, error) {
+			u, ok := users[uid]
+			if !ok {
+				return nil, user.UnknownUserIdError(1)
+			}
+
+			return u, nil
+		},
+		groupNames: func(u *user.User) ([]string, error) {
+			if u.Username == "bob" {
+				return []string{"bob", "maas-operators"}, nil
+			}
+
+			return []string{u.Username}, nil
+		},
+		users:  []string{"alice"},
+		groups: []string{"maas-operators"},
+	}
+}
+
+func TestGuard(t *testing.T) {
+	tokens, err := NewTokenAuthenticator([]Token{{User: "script", SHA256: digest("s3cret")}})
+	require.NoError(t, err)
+
+	authenticators := []Authenticator{tokens, NewCertAuthenticator([]string{"alice"}), fakePeerAuthenticator()}
+
+	testcases := map[string]struct {
+		peer           *uint32
+		cert           string
+		token          string
+		method         string
+		anonymousReads bool
+		status         int
+		user           string
+	}{
+		"token": {
+			token:  "s3cret",
+			status: http.StatusOK,
+			user:   "script",
+		},
+		"invalid token": {
+			token:  "guess",
+			status: http.StatusUnauthorized,
+		},
+		"certificate": {
+			cert:   "alice",
+			status: http.StatusOK,
+			user:   "alice",
+		},
+		"certificate not allowed": {
+			cert:   "mallory",
+			status: http.StatusForbidden,
+		},
+		"peer user": {
+			peer:   ptr(1000),
+			status: http.StatusOK,
+			user:   "alice",
+		},
+		"peer group member": {
+			peer:   ptr(1001),
+			status: http.StatusOK,
+			user:   "bob",
+		},
+		"peer root": {
+			peer:   ptr(0),
+			status: http.StatusOK,
+			user:   "root",
+		},
+		"peer not allowed": {
+			peer:   ptr(1002),
+			status: http.StatusForbidden,
+		},
+		"peer not allowed with token": {
+			peer:   ptr(1002),
+			token:  "s3cret",
+			status: http.StatusOK,
+			user:   "script",
+		},
+		"no credentials": {
+			status: http.StatusUnauthorized,
+		},
+		"anonymous read": {
+			anonymousReads: true,
+			method:         http.MethodGet,
+			status:         http.StatusOK,
+		},
+		"anonymous read of peer not allowed": {
+			peer:           ptr(1002),
+			anonymousReads: true,
+			method:         http.MethodGet,
+			status:         http.StatusOK,
+		},
+		"anonymous write": {
+			anonymousReads: true,
+			status:         http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var options []GuardOption
+			if tc.anonymousReads {
+				options = append(options, WithAnonymousReads())
+			}
+
+			var user string
+
+			h := NewGuard(authenticators, options...).Handler(
+				http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					id, ok := IdentityFromContext(r.Context())
+					if ok {
+						user = id.User
+					}
+				}))
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			r := httptest.NewRequest(method, "/ipmi-bridge", nil)
+
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			if tc.cert != "" {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{
+					{{Subject: pkix.Name{CommonName: tc.cert}}},
+				}}
+			}
+
+			if tc.peer != nil {
+				r = r.WithContext(context.WithValue(r.Context(), peerKey{}, *tc.peer))
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.status, w.Code, w.Body.String())
+			assert.Equal(t, tc.user, user)
+
+			if tc.status == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func ptr(uid uint32) *uint32 {
+	return &uid
+}
+
+func TestConfigValidate(t *testing.T) {
+	testcases := map[string]struct {
+		cfg Config
+		err error
+	}{
+		"disabled": {},
+		"tokens": {
+			cfg: Config{Tokens: []Token{{User: "script", SHA256: digest("s3cret")}}},
+		},
+		"token is not a digest": {
+			cfg: Config{Tokens: []Token{{User: "script", SHA256: "s3cret"}}},
+			err: ErrInvalidConfig,
+		},
+		"token without user": {
+			cfg: Config{Tokens: []Token{{SHA256: digest("s3cret")}}},
+			err: ErrInvalidConfig,
+		},
+		"unknown level": {
+			cfg: Config{Authorization: AuthorizationConfig{Default: "admin"}},
+			err: ErrInvalidConfig,
+		},
+		"incomplete mtls": {
+			cfg: Config{MTLS: MTLSConfig{CertFile: "agent.pem"}},
+			err: ErrInvalidConfig,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, tc.cfg.Validate(), tc.err)
+		})
+	}
+}
+
+func TestPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	guard, err := Config{Peer: PeerConfig{Users: []string{current.Username}}}.Guard()
+	require.NoError(t, err)
+
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	identities := make(chan Identity, 1)
+
+	srv := &http.Server{
+		Handler: guard.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			id, _ := IdentityFromContext(r.Context())
+			identities <- id
+		})),
+		ReadHeaderTimeout: time.Second,
+		ConnContext:       ConnContext,
+	}
+
+	//nolint:errcheck // server is closed by the test
+	go srv.Serve(l)
+
+	t.Cleanup(func() {
+		//nolint:errcheck // test server
+		srv.Close()
+	})
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	resp, err := client.Post("http://agent/ipmi-bridge", "application/json", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	id := <-identities
+	assert.Equal(t, MethodPeer, id.Method)
+
+	// root is always allowed
+	if current.Uid != "0" {
+		assert.Equal(t, current.Username, id.User)
+	}
+}
+
+func TestTokenAuthenticatorNoCredentials(t *testing.T) {
+	a, err := NewTokenAuthenticator(nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Basic YWxpY2U6cGFzcw==")
+
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
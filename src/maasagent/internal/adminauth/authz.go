@@ -0,0 +1,105 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package adminauth
+
+import (
+	"fmt"
+)
+
+// Level of an operation. Every level includes the ones below it.
+type Level string
+
+// Levels of operations
+const (
+	// LevelNone grants nothing
+	LevelNone Level = "none"
+	// LevelQuery inspects state (GET and HEAD requests)
+	LevelQuery Level = "query"
+	// LevelMutate changes state of the Agent (other requests)
+	LevelMutate Level = "mutate"
+	// LevelDangerous affects machines, e.g. power actions
+	LevelDangerous Level = "dangerous"
+)
+
+var levelRanks = map[Level]int{
+	LevelNone:      0,
+	LevelQuery:     1,
+	LevelMutate:    2,
+	LevelDangerous: 3,
+}
+
+// Valid returns true if l is a known level
+func (l Level) Valid() bool {
+	_, ok := levelRanks[l]
+	return ok
+}
+
+// Includes returns true if l grants operations of level other
+func (l Level) Includes(other Level) bool {
+	return levelRanks[l] >= levelRanks[other]
+}
+
+// Authorizer grants levels of operations to users and groups. The highest
+// level granted to the user or any of their groups applies.
+type Authorizer struct {
+	users    map[string]Level
+	groups   map[string]Level
+	fallback Level
+}
+
+// NewAuthorizer returns Authorizer granting fallback to users without
+// a grant of their own or of their groups
+func NewAuthorizer(fallback Level, users, groups map[string]Level) (*Authorizer, error) {
+	if !fallback.Valid() {
+		return nil, fmt.Errorf("%w: unknown level %q", ErrInvalidConfig, fallback)
+	}
+
+	for name, grants := range map[string]map[string]Level{"user": users, "group": groups} {
+		for k, l := range grants {
+			if !l.Valid() {
+				return nil, fmt.Errorf("%w: unknown level %q of %s %q", ErrInvalidConfig, l, name, k)
+			}
+		}
+	}
+
+	return &Authorizer{users: users, groups: groups, fallback: fallback}, nil
+}
+
+// Level returns the level granted to id
+func (a *Authorizer) Level(id Identity) Level {
+	granted, ok := a.users[id.User]
+	if !ok {
+		granted = a.fallback
+	}
+
+	for _, g := range id.Groups {
+		if l, ok := a.groups[g]; ok && l.Includes(granted) {
+			granted = l
+		}
+	}
+
+	return granted
+}
+
+// Authorize returns ErrNotAllowed if operations of level are not granted
+// to id
+func (a *Authorizer) Authorize(id Identity, level Level) error {
+	if granted := a.Level(id); !granted.Includes(level) {
+		return fmt.Errorf("%w: %q is granted %s operations, not %s", ErrNotAllowed, id.User, granted, level)
+	}
+
+	return nil
+}
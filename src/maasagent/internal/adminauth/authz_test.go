@@ -0,0 +1,165 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package adminauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizerLevel(t *testing.T) {
+	a, err := NewAuthorizer(LevelQuery,
+		map[string]Level{"alice": LevelDangerous, "bob": LevelNone},
+		map[string]Level{"maas-operators": LevelMutate, "maas-admins": LevelDangerous})
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		id    Identity
+		level Level
+	}{
+		"user grant": {
+			id:    Identity{User: "alice"},
+			level: LevelDangerous,
+		},
+		"default": {
+			id:    Identity{User: "carol"},
+			level: LevelQuery,
+		},
+		"group grant": {
+			id:    Identity{User: "carol", Groups: []string{"carol", "maas-operators"}},
+			level: LevelMutate,
+		},
+		"highest group grant": {
+			id:    Identity{User: "carol", Groups: []string{"maas-admins", "maas-operators"}},
+			level: LevelDangerous,
+		},
+		"group grant above user grant": {
+			id:    Identity{User: "bob", Groups: []string{"maas-operators"}},
+			level: LevelMutate,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.level, a.Level(tc.id))
+		})
+	}
+
+	_, err = NewAuthorizer(LevelQuery, map[string]Level{"alice": "admin"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestGuardAuthorization(t *testing.T) {
+	tokens, err := NewTokenAuthenticator([]Token{
+		{User: "junior", SHA256: digest("junior")},
+		{User: "operator", SHA256: digest("operator")},
+		{User: "senior", SHA256: digest("senior")},
+	})
+	require.NoError(t, err)
+
+	authorizer, err := NewAuthorizer(LevelQuery, map[string]Level{
+		"operator": LevelMutate,
+		"senior":   LevelDangerous,
+	}, nil)
+	require.NoError(t, err)
+
+	var audit bytes.Buffer
+
+	decisions := NewDecisionLog(WithAuditLog(&audit), WithDecisionsKept(2))
+
+	h := NewGuard([]Authenticator{tokens},
+		WithAuthorizer(authorizer),
+		WithDangerousPaths("/ipmi-bridge", "/console/"),
+		WithDecisionLog(decisions),
+	).Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	testcases := []struct {
+		token  string
+		method string
+		path   string
+		status int
+	}{
+		{token: "junior", method: http.MethodGet, path: "/circuits", status: http.StatusOK},
+		{token: "junior", method: http.MethodPost, path: "/operations/", status: http.StatusForbidden},
+		{token: "operator", method: http.MethodPost, path: "/operations/", status: http.StatusOK},
+		{token: "operator", method: http.MethodPost, path: "/ipmi-bridge", status: http.StatusForbidden},
+		{token: "operator", method: http.MethodGet, path: "/console/1", status: http.StatusForbidden},
+		{token: "senior", method: http.MethodPost, path: "/ipmi-bridge", status: http.StatusOK},
+		{token: "senior", method: http.MethodPost, path: "/ipmi-bridge-other", status: http.StatusOK},
+	}
+
+	for _, tc := range testcases {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.status, w.Code, "%s %s %s", tc.token, tc.method, tc.path)
+	}
+
+	// Allowed queries are not recorded
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	require.Len(t, lines, 6)
+
+	var d Decision
+
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &d))
+	assert.Equal(t, "junior", d.User)
+	assert.Equal(t, MethodToken, d.Method)
+	assert.Equal(t, "POST /operations/", d.Request)
+	assert.Equal(t, LevelMutate, d.Level)
+	assert.False(t, d.Allowed)
+	assert.Contains(t, d.Reason, "not allowed")
+
+	recent := decisions.Recent()
+	require.Len(t, recent, 2)
+	assert.Equal(t, "POST /ipmi-bridge-other", recent[0].Request)
+	assert.Equal(t, LevelMutate, recent[0].Level)
+	assert.Equal(t, "POST /ipmi-bridge", recent[1].Request)
+	assert.True(t, recent[1].Allowed)
+}
+
+func TestDecisionLogHandler(t *testing.T) {
+	l := NewDecisionLog()
+	l.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	l.Record(Decision{Request: "POST /ipmi-bridge", Level: LevelDangerous, Reason: "no credentials"})
+
+	w := httptest.NewRecorder()
+	l.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, DecisionsPath, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"time":"2024-01-02T03:04:05Z","request":"POST /ipmi-bridge",
+		"level":"dangerous","reason":"no credentials","allowed":false}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	l.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodDelete, DecisionsPath, nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package adminauth
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// CertAuthenticator authenticates requests served with mTLS by the common
+// name of the verified client certificate
+type CertAuthenticator struct {
+	users []string
+}
+
+// NewCertAuthenticator returns CertAuthenticator accepting certificates of
+// users, or of anyone with a certificate signed by the client CA if users
+// are empty
+func NewCertAuthenticator(users []string) *CertAuthenticator {
+	return &CertAuthenticator{users: users}
+}
+
+func (a *CertAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+
+	user := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if user == "" {
+		return Identity{}, fmt.Errorf("%w: certificate without common name", ErrInvalidCredentials)
+	}
+
+	if len(a.users) > 0 && !slices.Contains(a.users, user) {
+		return Identity{}, fmt.Errorf("%w: certificate of %q", ErrNotAllowed, user)
+	}
+
+	return Identity{User: user, Method: MethodCert}, nil
+}
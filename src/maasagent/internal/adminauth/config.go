@@ -0,0 +1,194 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package adminauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config of the local API authentication. The local API is open to anyone
+// who can access its socket, unless an authentication method is configured.
+type Config struct {
+	// Tokens are accepted as "Authorization: Bearer <token>"
+	Tokens []Token    `yaml:"tokens"`
+	MTLS   MTLSConfig `yaml:"mtls"`
+	Peer   PeerConfig `yaml:"peer"`
+	// Authorization limits levels of operations of authenticated clients
+	Authorization AuthorizationConfig `yaml:"authorization"`
+	// AuditLog is the file where decisions about operations and rejected
+	// requests are appended as JSON lines
+	AuditLog string `yaml:"audit_log"`
+	// AnonymousReads allows GET and HEAD requests without credentials
+	AnonymousReads bool `yaml:"anonymous_reads"`
+}
+
+// AuthorizationConfig grants levels of operations ("none", "query",
+// "mutate" or "dangerous") to users and groups of local OS users.
+// Authenticated clients can perform any operation if it is not enabled.
+type AuthorizationConfig struct {
+	Users  map[string]Level `yaml:"users"`
+	Groups map[string]Level `yaml:"groups"`
+	// Default is the level of clients without a grant (default: query)
+	Default Level `yaml:"default"`
+}
+
+// Enabled returns true if any level is granted
+func (c AuthorizationConfig) Enabled() bool {
+	return c.Default != "" || len(c.Users) > 0 || len(c.Groups) > 0
+}
+
+// Authorizer returns Authorizer of the configured grants
+func (c AuthorizationConfig) Authorizer() (*Authorizer, error) {
+	fallback := c.Default
+	if fallback == "" {
+		fallback = LevelQuery
+	}
+
+	return NewAuthorizer(fallback, c.Users, c.Groups)
+}
+
+// MTLSConfig enables the socket serving the local API with mTLS, which
+// authenticates clients by their certificates
+type MTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile is the CA bundle verifying client certificates
+	ClientCAFile string `yaml:"client_ca_file"`
+	// Users are common names of allowed certificates, any if empty
+	Users []string `yaml:"users"`
+}
+
+// PeerConfig allows local OS users connected to the plain socket (Linux
+// only), which is how the socket is accessed by operators logged in to the
+// rack controller
+type PeerConfig struct {
+	Users  []string `yaml:"users"`
+	Groups []string `yaml:"groups"`
+}
+
+// Enabled returns true if the mTLS socket is configured
+func (c MTLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ClientCAFile != ""
+}
+
+func (c PeerConfig) enabled() bool {
+	return len(c.Users) > 0 || len(c.Groups) > 0
+}
+
+// Enabled returns true if any authentication method is configured
+func (c Config) Enabled() bool {
+	return len(c.Tokens) > 0 || c.MTLS.Enabled() || c.Peer.enabled()
+}
+
+// Validate returns ErrInvalidConfig if the configuration is incomplete
+func (c Config) Validate() error {
+	if c.MTLS.Enabled() && (c.MTLS.CertFile == "" || c.MTLS.KeyFile == "" || c.MTLS.ClientCAFile == "") {
+		return fmt.Errorf("%w: mtls requires cert_file, key_file and client_ca_file", ErrInvalidConfig)
+	}
+
+	if c.Peer.enabled() && !peerCredentialsSupported {
+		return fmt.Errorf("%w: peer credentials are not supported on this platform", ErrUnsupported)
+	}
+
+	if _, err := NewTokenAuthenticator(c.Tokens); err != nil {
+		return err
+	}
+
+	if _, err := c.Authorization.Authorizer(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Guard returns Guard of the configured authentication methods and grants,
+// with additional options (e.g. WithDangerousPaths). Guard without any
+// method rejects every request, so it should only be used if the
+// configuration is Enabled.
+func (c Config) Guard(options ...GuardOption) (*Guard, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	var authenticators []Authenticator
+
+	if len(c.Tokens) > 0 {
+		a, err := NewTokenAuthenticator(c.Tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		authenticators = append(authenticators, a)
+	}
+
+	if c.MTLS.Enabled() {
+		authenticators = append(authenticators, NewCertAuthenticator(c.MTLS.Users))
+	}
+
+	if c.Peer.enabled() {
+		a, err := NewPeerAuthenticator(c.Peer.Users, c.Peer.Groups)
+		if err != nil {
+			return nil, err
+		}
+
+		authenticators = append(authenticators, a)
+	}
+
+	if c.Authorization.Enabled() {
+		a, err := c.Authorization.Authorizer()
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, WithAuthorizer(a))
+	}
+
+	if c.AnonymousReads {
+		options = append(options, WithAnonymousReads())
+	}
+
+	return NewGuard(authenticators, options...), nil
+}
+
+// TLSConfig returns configuration of the mTLS socket. Clients without
+// certificates are accepted, so they can authenticate with tokens.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.MTLS.CertFile, c.MTLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	pem, err := os.ReadFile(filepath.Clean(c.MTLS.ClientCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w: no certificates in %s", ErrInvalidConfig, c.MTLS.ClientCAFile)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}, nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package adminauth
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DecisionsPath is where recent decisions are served on the local API
+const DecisionsPath = "/auth/decisions"
+
+// defaultDecisionsKept is how many recent decisions are kept in memory
+const defaultDecisionsKept = 256
+
+// Decision of Guard about a request
+type Decision struct {
+	Time time.Time `json:"time"`
+	// User is empty for anonymous requests
+	User string `json:"user,omitempty"`
+	// Method is the authentication method
+	Method string `json:"method,omitempty"`
+	// Request is "<method> <path>"
+	Request string `json:"request"`
+	Level   Level  `json:"level"`
+	Reason  string `json:"reason,omitempty"`
+	Allowed bool   `json:"allowed"`
+}
+
+// DecisionLog keeps recent decisions in memory and appends every decision
+// as a JSON line to the audit log, if any
+type DecisionLog struct {
+	audit  io.Writer
+	now    func() time.Time
+	recent []Decision
+	next   int
+	kept   int
+	mutex  sync.Mutex
+}
+
+// DecisionLogOption allows to set additional DecisionLog options
+type DecisionLogOption func(*DecisionLog)
+
+// NewDecisionLog returns an empty DecisionLog
+func NewDecisionLog(options ...DecisionLogOption) *DecisionLog {
+	l := &DecisionLog{now: time.Now, kept: defaultDecisionsKept}
+
+	for _, opt := range options {
+		opt(l)
+	}
+
+	l.recent = make([]Decision, 0, l.kept)
+
+	return l
+}
+
+// WithAuditLog appends decisions to w, e.g. a file opened with O_APPEND
+func WithAuditLog(w io.Writer) DecisionLogOption {
+	return func(l *DecisionLog) {
+		l.audit = w
+	}
+}
+
+// WithDecisionsKept sets how many recent decisions are kept in memory
+// (default: 256)
+func WithDecisionsKept(n int) DecisionLogOption {
+	return func(l *DecisionLog) {
+		if n > 0 {
+			l.kept = n
+		}
+	}
+}
+
+// Record adds d to the log, setting its time
+func (l *DecisionLog) Record(d Decision) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	d.Time = l.now().UTC()
+
+	if len(l.recent) < l.kept {
+		l.recent = append(l.recent, d)
+	} else {
+		l.recent[l.next] = d
+	}
+
+	l.next = (l.next + 1) % l.kept
+
+	if l.audit == nil {
+		return
+	}
+
+	if err := json.NewEncoder(l.audit).Encode(d); err != nil {
+		log.Error().Err(err).Msg("Failed to write admin API audit log")
+	}
+}
+
+// Recent returns decisions kept in memory, the newest first
+func (l *DecisionLog) Recent() []Decision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	res := make([]Decision, 0, len(l.recent))
+
+	for i := 1; i <= len(l.recent); i++ {
+		res = append(res, l.recent[(l.next-i+len(l.recent))%len(l.recent)])
+	}
+
+	return res
+}
+
+// Handler serves recent decisions
+func (l *DecisionLog) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(l.Recent())
+	})
+}
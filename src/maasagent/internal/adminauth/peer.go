@@ -0,0 +1,133 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package adminauth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os/user"
+	"slices"
+	"strconv"
+)
+
+type peerKey struct{}
+
+// ConnContext records credentials of the process connected to the Unix
+// socket, so PeerAuthenticator can identify it. It is meant to be used as
+// http.Server.ConnContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	uid, err := peerUID(uc)
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerKey{}, uid)
+}
+
+// PeerAuthenticator authenticates local OS users connected to the Unix
+// socket by credentials of their process. root is always allowed, as it
+// can do anything the Agent does anyway.
+type PeerAuthenticator struct {
+	lookupUser func(uid string) (*user.User, error)
+	groupNames func(u *user.User) ([]string, error)
+	users      []string
+	groups     []string
+}
+
+// NewPeerAuthenticator returns PeerAuthenticator accepting users and
+// members of groups
+func NewPeerAuthenticator(users, groups []string) (*PeerAuthenticator, error) {
+	if !peerCredentialsSupported {
+		return nil, fmt.Errorf("%w: peer credentials", ErrUnsupported)
+	}
+
+	return &PeerAuthenticator{
+		lookupUser: user.LookupId,
+		groupNames: groupNames,
+		users:      users,
+		groups:     groups,
+	}, nil
+}
+
+func (a *PeerAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	uid, ok := r.Context().Value(peerKey{}).(uint32)
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+
+	if uid == 0 {
+		return Identity{User: "root", Method: MethodPeer}, nil
+	}
+
+	u, err := a.lookupUser(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: uid %d: %v", ErrInvalidCredentials, uid, err)
+	}
+
+	// Groups are also needed for authorization of allowed users
+	groups, err := a.groupNames(u)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: groups of %q: %v", ErrInvalidCredentials, u.Username, err)
+	}
+
+	id := Identity{User: u.Username, Method: MethodPeer, Groups: groups}
+
+	if slices.Contains(a.users, u.Username) {
+		return id, nil
+	}
+
+	for _, g := range groups {
+		if slices.Contains(a.groups, g) {
+			return id, nil
+		}
+	}
+
+	return Identity{}, fmt.Errorf("%w: user %q", ErrNotAllowed, u.Username)
+}
+
+// groupNames returns names of groups u is a member of
+func groupNames(u *user.User) ([]string, error) {
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			// Groups without names can't be configured anyway
+			continue
+		}
+
+		names = append(names, g.Name)
+	}
+
+	return names, nil
+}
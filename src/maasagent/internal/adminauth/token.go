@@ -0,0 +1,87 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package adminauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Token is a bearer token of a user. Only the SHA-256 digest of the token
+// is kept, so the configuration doesn't contain secrets.
+type Token struct {
+	User string `yaml:"user"`
+	// SHA256 is the hex encoded SHA-256 digest of the token
+	SHA256 string `yaml:"sha256"`
+}
+
+// TokenAuthenticator authenticates requests with "Authorization: Bearer"
+type TokenAuthenticator struct {
+	tokens []tokenDigest
+}
+
+type tokenDigest struct {
+	user   string
+	digest []byte
+}
+
+// NewTokenAuthenticator returns TokenAuthenticator accepting tokens
+func NewTokenAuthenticator(tokens []Token) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{tokens: make([]tokenDigest, 0, len(tokens))}
+
+	for _, t := range tokens {
+		if t.User == "" {
+			return nil, fmt.Errorf("%w: token without user", ErrInvalidConfig)
+		}
+
+		digest, err := hex.DecodeString(t.SHA256)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("%w: token of %q is not a SHA-256 digest", ErrInvalidConfig, t.User)
+		}
+
+		a.tokens = append(a.tokens, tokenDigest{user: t.User, digest: digest})
+	}
+
+	return a, nil
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return Identity{}, ErrNoCredentials
+	}
+
+	digest := sha256.Sum256([]byte(token))
+
+	// All tokens are compared, so the time doesn't reveal which one matched
+	var user string
+
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(digest[:], t.digest) == 1 {
+			user = t.user
+		}
+	}
+
+	if user == "" {
+		return Identity{}, fmt.Errorf("%w: unknown token", ErrInvalidCredentials)
+	}
+
+	return Identity{User: user, Method: MethodToken}, nil
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package annotation keeps short notes about machines on the Agent (e.g.
+// "BMC flaky, circuit opened twice this week"), attached by workflows or
+// operators, so what the Agent learned about a machine is not lost in logs.
+// Notes are served on the local API and reported to the Region.
+package annotation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+)
+
+const (
+	// MaxTextLength is the longest text of an annotation in characters
+	MaxTextLength = 280
+	// maxPerMachine annotations are kept per machine, older are dropped
+	maxPerMachine = 20
+)
+
+var (
+	// ErrInvalidSystemID is returned for system_ids which can't be annotated
+	ErrInvalidSystemID = errors.New("invalid system_id")
+	// ErrInvalidText is returned for empty or too long annotations
+	ErrInvalidText = errors.New("invalid annotation text")
+)
+
+// Annotation is a note about a machine
+type Annotation struct {
+	CreatedAt time.Time `json:"created_at"`
+	Text      string    `json:"text"`
+	// Source is who attached the annotation, e.g. workflow type or user
+	Source string `json:"source,omitempty"`
+}
+
+// Store keeps annotations of machines by system_id
+type Store struct {
+	machines map[string][]Annotation
+	now      func() time.Time
+	path     string
+	mutex    sync.Mutex
+}
+
+// NewStore returns Store persisting annotations in path, so they survive
+// Agent restarts. Annotations are only kept in memory if path is empty.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		machines: make(map[string][]Annotation),
+		now:      time.Now,
+		path:     path,
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path) //nolint:gosec // path is not user provided
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.machines); err != nil {
+		return nil, fmt.Errorf("failed to load annotations: %w", err)
+	}
+
+	return s, nil
+}
+
+func validate(systemID, text string) error {
+	if systemID == "" || strings.ContainsAny(systemID, "/\\?#") {
+		return fmt.Errorf("%w: %q", ErrInvalidSystemID, systemID)
+	}
+
+	if strings.TrimSpace(text) == "" || utf8.RuneCountInString(text) > MaxTextLength {
+		return fmt.Errorf("%w: must be 1 to %d characters", ErrInvalidText, MaxTextLength)
+	}
+
+	return nil
+}
+
+// Add attaches annotation with text to the machine and returns all
+// annotations of the machine, oldest first. Only the latest annotations
+// of a machine are kept.
+func (s *Store) Add(systemID, text, source string) ([]Annotation, error) {
+	if err := validate(systemID, text); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	prev := s.machines[systemID]
+
+	annotations := append(slices.Clone(prev), Annotation{
+		CreatedAt: s.now().UTC(),
+		Text:      strings.TrimSpace(text),
+		Source:    source,
+	})
+	if len(annotations) > maxPerMachine {
+		annotations = annotations[len(annotations)-maxPerMachine:]
+	}
+
+	s.machines[systemID] = annotations
+
+	if err := s.save(); err != nil {
+		s.machines[systemID] = prev
+		return nil, err
+	}
+
+	return slices.Clone(annotations), nil
+}
+
+// Get returns annotations of the machine, oldest first
+func (s *Store) Get(systemID string) []Annotation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]Annotation{}, s.machines[systemID]...)
+}
+
+// All returns annotations of all annotated machines by system_id
+func (s *Store) All() map[string][]Annotation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := make(map[string][]Annotation, len(s.machines))
+	for systemID, annotations := range s.machines {
+		res[systemID] = slices.Clone(annotations)
+	}
+
+	return res
+}
+
+// Clear removes all annotations of the machine
+func (s *Store) Clear(systemID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	prev, ok := s.machines[systemID]
+	if !ok {
+		return nil
+	}
+
+	delete(s.machines, systemID)
+
+	if err := s.save(); err != nil {
+		s.machines[systemID] = prev
+		return err
+	}
+
+	return nil
+}
+
+// save persists annotations. Must be called with mutex held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(s.machines)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path, b, 0o600)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package annotation
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "annotations", "annotations.json")
+
+	s, err := NewStore(path)
+	require.NoError(t, err)
+
+	s.now = func() time.Time { return time.Unix(100, 0) }
+
+	assert.Empty(t, s.Get("abc"))
+
+	_, err = s.Add("abc", "BMC flaky, circuit opened twice this week", "remediation")
+	require.NoError(t, err)
+
+	annotations, err := s.Add("abc", "  PSU replaced  ", "alice")
+	require.NoError(t, err)
+
+	expected := []Annotation{
+		{CreatedAt: time.Unix(100, 0).UTC(), Text: "BMC flaky, circuit opened twice this week", Source: "remediation"},
+		{CreatedAt: time.Unix(100, 0).UTC(), Text: "PSU replaced", Source: "alice"},
+	}
+	assert.Equal(t, expected, annotations)
+
+	// Annotations survive restarts
+	s, err = NewStore(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, expected, s.Get("abc"))
+
+	require.NoError(t, s.Clear("abc"))
+
+	s, err = NewStore(path)
+	require.NoError(t, err)
+
+	assert.Empty(t, s.All())
+}
+
+func TestStoreLatest(t *testing.T) {
+	s, err := NewStore("")
+	require.NoError(t, err)
+
+	for i := 0; i < maxPerMachine+5; i++ {
+		_, err := s.Add("abc", fmt.Sprintf("note %d", i), "")
+		require.NoError(t, err)
+	}
+
+	annotations := s.Get("abc")
+	require.Len(t, annotations, maxPerMachine)
+	assert.Equal(t, "note 5", annotations[0].Text)
+	assert.Equal(t, fmt.Sprintf("note %d", maxPerMachine+4), annotations[maxPerMachine-1].Text)
+}
+
+func TestStoreInvalid(t *testing.T) {
+	testcases := map[string]struct {
+		systemID string
+		text     string
+		err      error
+	}{
+		"no system_id": {
+			text: "note",
+			err:  ErrInvalidSystemID,
+		},
+		"path in system_id": {
+			systemID: "abc/def",
+			text:     "note",
+			err:      ErrInvalidSystemID,
+		},
+		"empty text": {
+			systemID: "abc",
+			text:     "  ",
+			err:      ErrInvalidText,
+		},
+		"long text": {
+			systemID: "abc",
+			text:     strings.Repeat("a", MaxTextLength+1),
+			err:      ErrInvalidText,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewStore("")
+			require.NoError(t, err)
+
+			_, err = s.Add(tc.systemID, tc.text, "")
+			assert.ErrorIs(t, err, tc.err)
+			assert.Empty(t, s.All())
+		})
+	}
+}
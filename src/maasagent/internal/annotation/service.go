@@ -0,0 +1,258 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package annotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"maas.io/core/src/maasagent/internal/adminauth"
+	"maas.io/core/src/maasagent/internal/apiclient"
+)
+
+// PathPrefix is where Service is expected to be served, e.g.
+// /annotations/<system_id>
+const PathPrefix = "/annotations/"
+
+var (
+	// ErrFailedToReport is returned when the Region rejects annotations
+	ErrFailedToReport = errors.New("failed to report machine annotations")
+)
+
+// Reporter reports all annotations of a machine to the Region
+type Reporter interface {
+	Report(ctx context.Context, systemID string, annotations []Annotation) error
+}
+
+// APIReporter reports annotations to the Region via internal API
+type APIReporter struct {
+	client   *apiclient.APIClient
+	systemID string
+}
+
+// NewAPIReporter returns APIReporter for the Agent with systemID
+func NewAPIReporter(client *apiclient.APIClient, systemID string) *APIReporter {
+	return &APIReporter{client: client, systemID: systemID}
+}
+
+type machineAnnotations struct {
+	SystemID    string       `json:"system_id"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+func (r *APIReporter) Report(ctx context.Context, systemID string, annotations []Annotation) error {
+	body, err := json.Marshal(machineAnnotations{SystemID: systemID, Annotations: annotations})
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Request(ctx, http.MethodPost,
+		fmt.Sprintf("/v3internal/agents/%s/machine-annotations", r.systemID), body)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("%w: %s", ErrFailedToReport, resp.Status)
+	}
+
+	return nil
+}
+
+// Service attaches annotations to machines on behalf of workflows and
+// operators of the local API
+type Service struct {
+	store    *Store
+	reporter Reporter
+}
+
+// ServiceOption allows to set additional options for the Service
+type ServiceOption func(*Service)
+
+// WithReporter reports annotations of machines to the Region whenever
+// they change
+func WithReporter(r Reporter) ServiceOption {
+	return func(s *Service) {
+		s.reporter = r
+	}
+}
+
+// NewService returns Service keeping annotations in store
+func NewService(store *Store, options ...ServiceOption) *Service {
+	s := &Service{store: store}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// AnnotateMachineParam is the activity parameter for annotate-machine
+type AnnotateMachineParam struct {
+	SystemID string `json:"system_id"`
+	Text     string `json:"text"`
+	// Source is who attaches the annotation, the type of the workflow
+	// running the activity by default
+	Source string `json:"source,omitempty"`
+}
+
+// GetMachineAnnotationsParam is the activity parameter for
+// get-machine-annotations
+type GetMachineAnnotationsParam struct {
+	SystemID string `json:"system_id"`
+}
+
+// MachineAnnotationsResult is the result of annotate-machine and
+// get-machine-annotations
+type MachineAnnotationsResult struct {
+	Annotations []Annotation `json:"annotations"`
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"annotate-machine":        s.annotateMachine,
+		"get-machine-annotations": s.getMachineAnnotations,
+	}
+}
+
+func (s *Service) annotateMachine(ctx context.Context,
+	param AnnotateMachineParam) (*MachineAnnotationsResult, error) {
+	source := param.Source
+	if source == "" {
+		source = activity.GetInfo(ctx).WorkflowType.Name
+	}
+
+	annotations, err := s.add(ctx, param.SystemID, param.Text, source)
+	if errors.Is(err, ErrInvalidSystemID) || errors.Is(err, ErrInvalidText) {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &MachineAnnotationsResult{Annotations: annotations}, nil
+}
+
+func (s *Service) getMachineAnnotations(_ context.Context,
+	param GetMachineAnnotationsParam) (*MachineAnnotationsResult, error) {
+	return &MachineAnnotationsResult{Annotations: s.store.Get(param.SystemID)}, nil
+}
+
+func (s *Service) add(ctx context.Context, systemID, text, source string) ([]Annotation, error) {
+	annotations, err := s.store.Add(systemID, text, source)
+	if err != nil {
+		return nil, err
+	}
+
+	s.report(ctx, systemID, annotations)
+
+	return annotations, nil
+}
+
+// report sends annotations to the Region. Failures are only logged, as
+// annotations are kept by the Agent and the Region can ask for them.
+func (s *Service) report(ctx context.Context, systemID string, annotations []Annotation) {
+	if s.reporter == nil {
+		return
+	}
+
+	if err := s.reporter.Report(ctx, systemID, annotations); err != nil {
+		log.Warn().Err(err).Str("system_id", systemID).Msg("Failed to report machine annotations")
+	}
+}
+
+type addRequest struct {
+	Text string `json:"text"`
+}
+
+// ServeHTTP lists annotations of all machines on GET of PathPrefix, and
+// serves annotations of a machine on PathPrefix<system_id>: GET lists them,
+// POST attaches {"text": ...} on behalf of the client and DELETE removes
+// them.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	systemID := strings.TrimPrefix(r.URL.Path, PathPrefix)
+
+	var (
+		res interface{}
+		err error
+	)
+
+	switch {
+	case systemID == "" && r.Method == http.MethodGet:
+		res = s.store.All()
+	case systemID == "":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	case r.Method == http.MethodGet:
+		res = MachineAnnotationsResult{Annotations: s.store.Get(systemID)}
+	case r.Method == http.MethodPost:
+		var req addRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		source := "local-api"
+		if id, ok := adminauth.IdentityFromContext(r.Context()); ok && id.User != "" {
+			source = id.User
+		}
+
+		var annotations []Annotation
+
+		annotations, err = s.add(r.Context(), systemID, req.Text, source)
+		res = MachineAnnotationsResult{Annotations: annotations}
+	case r.Method == http.MethodDelete:
+		if err = s.store.Clear(systemID); err == nil {
+			s.report(r.Context(), systemID, []Annotation{})
+		}
+
+		res = MachineAnnotationsResult{Annotations: []Annotation{}}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, ErrInvalidSystemID) || errors.Is(err, ErrInvalidText):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint:errcheck // nothing to do if the client went away
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package annotation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"maas.io/core/src/maasagent/internal/adminauth"
+)
+
+type fakeReporter struct {
+	err     error
+	reports map[string][]Annotation
+}
+
+func (f *fakeReporter) Report(_ context.Context, systemID string, annotations []Annotation) error {
+	f.reports[systemID] = annotations
+	return f.err
+}
+
+func newService(t *testing.T, reporter Reporter) *Service {
+	t.Helper()
+
+	store, err := NewStore("")
+	require.NoError(t, err)
+
+	return NewService(store, WithReporter(reporter))
+}
+
+func request(s *Service, method, target, body string, id *adminauth.Identity) (int, string) {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if id != nil {
+		r = r.WithContext(adminauth.WithIdentity(r.Context(), *id))
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	return w.Code, w.Body.String()
+}
+
+func TestServiceHTTP(t *testing.T) {
+	reporter := &fakeReporter{reports: make(map[string][]Annotation)}
+	s := newService(t, reporter)
+
+	code, _ := request(s, http.MethodPost, "/annotations/abc", `{"text": "PSU replaced"}`,
+		&adminauth.Identity{User: "alice", Method: adminauth.MethodToken})
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body := request(s, http.MethodGet, "/annotations/abc", "", nil)
+	require.Equal(t, http.StatusOK, code)
+
+	var res MachineAnnotationsResult
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Len(t, res.Annotations, 1)
+	assert.Equal(t, "alice", res.Annotations[0].Source)
+	assert.Equal(t, res.Annotations, reporter.reports["abc"])
+
+	code, body = request(s, http.MethodGet, "/annotations/", "", nil)
+	require.Equal(t, http.StatusOK, code)
+
+	var all map[string][]Annotation
+	require.NoError(t, json.Unmarshal([]byte(body), &all))
+	assert.Equal(t, res.Annotations, all["abc"])
+
+	code, _ = request(s, http.MethodDelete, "/annotations/abc", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, s.store.Get("abc"))
+	assert.Empty(t, reporter.reports["abc"])
+
+	code, _ = request(s, http.MethodPost, "/annotations/abc", `{"text": ""}`, nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = request(s, http.MethodPut, "/annotations/abc", "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestAnnotateMachine(t *testing.T) {
+	// Annotations are kept when they can't be reported
+	reporter := &fakeReporter{err: errors.New("region is down"), reports: make(map[string][]Annotation)}
+	s := newService(t, reporter)
+
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(s.annotateMachine)
+	env.RegisterActivity(s.getMachineAnnotations)
+
+	_, err := env.ExecuteActivity(s.annotateMachine, AnnotateMachineParam{
+		SystemID: "abc",
+		Text:     "BMC flaky, circuit opened twice this week",
+		Source:   "remediation",
+	})
+	require.NoError(t, err)
+
+	val, err := env.ExecuteActivity(s.getMachineAnnotations, GetMachineAnnotationsParam{SystemID: "abc"})
+	require.NoError(t, err)
+
+	var res MachineAnnotationsResult
+	require.NoError(t, val.Get(&res))
+	require.Len(t, res.Annotations, 1)
+	assert.Equal(t, "remediation", res.Annotations[0].Source)
+
+	_, err = env.ExecuteActivity(s.annotateMachine, AnnotateMachineParam{SystemID: "abc"})
+	assert.ErrorContains(t, err, ErrInvalidText.Error())
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package anomaly tracks power behavior of machines (e.g. machines found
+// off although nobody powered them off, or machines power cycled over and
+// over) and raises anomaly events when it deviates from the usual patterns.
+// Such events help to spot failing PSUs and out-of-band access to BMCs.
+package anomaly
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/backpressure"
+	"maas.io/core/src/maasagent/internal/ringbuf"
+)
+
+// Kinds of anomalies
+const (
+	// KindUnexpectedOff is raised when a machine is found off, although
+	// the last power action requested by MAAS left it on
+	KindUnexpectedOff = "unexpected-off"
+	// KindUnexpectedOn is raised when a machine is found on, although
+	// the last power action requested by MAAS left it off
+	KindUnexpectedOn = "unexpected-on"
+	// KindFlapping is raised when power of a machine changes too often
+	KindFlapping = "flapping"
+)
+
+const (
+	// anomalyBufferBytes limits anomalies waiting to be reported
+	anomalyBufferBytes = 1024 * 1024
+	// anomalyOverhead is an approximate size of Anomaly without strings
+	anomalyOverhead = 64
+	// machineTTL is how long machines without any power activity are
+	// tracked, so decommissioned machines are forgotten
+	machineTTL = 24 * time.Hour
+)
+
+// Threshold raises an anomaly when Count occurrences happen within Window
+type Threshold struct {
+	Count  int           `yaml:"count" json:"count"`
+	Window time.Duration `yaml:"window" json:"window"`
+}
+
+// Config sets thresholds of anomaly kinds. Zero values keep the defaults.
+type Config struct {
+	UnexpectedOff Threshold `yaml:"unexpected_off" json:"unexpected_off"`
+	UnexpectedOn  Threshold `yaml:"unexpected_on" json:"unexpected_on"`
+	// Flapping counts power changes (on, off, cycle and reset)
+	Flapping Threshold `yaml:"flapping" json:"flapping"`
+	// Disabled stops tracking of power behavior
+	Disabled bool `yaml:"disabled" json:"disabled"`
+}
+
+// DefaultConfig returns thresholds used when they are not configured.
+// Machines found on unexpectedly are reported straight away, because
+// MAAS is normally the only one powering machines on.
+func DefaultConfig() Config {
+	return Config{
+		UnexpectedOff: Threshold{Count: 3, Window: time.Hour},
+		UnexpectedOn:  Threshold{Count: 1, Window: time.Hour},
+		Flapping:      Threshold{Count: 6, Window: 10 * time.Minute},
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+
+	for _, t := range []struct{ value, def *Threshold }{
+		{&c.UnexpectedOff, &d.UnexpectedOff},
+		{&c.UnexpectedOn, &d.UnexpectedOn},
+		{&c.Flapping, &d.Flapping},
+	} {
+		if t.value.Count <= 0 {
+			t.value.Count = t.def.Count
+		}
+
+		if t.value.Window <= 0 {
+			t.value.Window = t.def.Window
+		}
+	}
+
+	return c
+}
+
+// Anomaly is reported when power behavior of a machine crossed a threshold
+type Anomaly struct {
+	Time    time.Time `json:"time"`
+	Machine string    `json:"machine"`
+	Kind    string    `json:"kind"`
+	// State is the power state the machine was found in, if any
+	State string `json:"state,omitempty"`
+	// Count of occurrences within Window
+	Count  int           `json:"count"`
+	Window time.Duration `json:"window"`
+}
+
+func (a Anomaly) size() int {
+	return anomalyOverhead + len(a.Machine) + len(a.Kind) + len(a.State)
+}
+
+// Reporter is used to report anomalies (e.g. to the Region).
+type Reporter interface {
+	Report(ctx context.Context, anomalies []Anomaly) error
+}
+
+// machine is the tracked power behavior of a machine
+type machine struct {
+	seen time.Time
+	// expected is the power state left by the last power action
+	expected string
+	history  map[string][]time.Time
+}
+
+// Detector tracks power behavior of machines and raises anomalies
+type Detector struct {
+	reporter  Reporter
+	pressure  *backpressure.Controller
+	now       func() time.Time
+	machines  map[string]*machine
+	anomalies *ringbuf.Buffer[Anomaly]
+	cfg       Config
+	mutex     sync.Mutex
+}
+
+// DetectorOption allows to set additional Detector options
+type DetectorOption func(*Detector)
+
+// NewDetector returns Detector raising anomalies according to cfg
+func NewDetector(cfg Config, options ...DetectorOption) *Detector {
+	d := &Detector{
+		cfg:       cfg.withDefaults(),
+		now:       time.Now,
+		machines:  make(map[string]*machine),
+		anomalies: ringbuf.New("anomaly", anomalyBufferBytes, Anomaly.size),
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	return d
+}
+
+// WithReporter sets Reporter called with anomalies.
+func WithReporter(r Reporter) DetectorOption {
+	return func(d *Detector) {
+		d.reporter = r
+	}
+}
+
+// WithBackpressure sets Controller used to batch anomalies for longer
+// when the Region is slow to acknowledge them.
+func WithBackpressure(c *backpressure.Controller) DetectorOption {
+	return func(d *Detector) {
+		d.pressure = c
+	}
+}
+
+// Observe records the power state of a machine returned by a power action
+// ("on", "off", "cycle", "reset" or "status"). It is safe to call Observe
+// on nil Detector.
+func (d *Detector) Observe(machineID, action, state string) {
+	if d == nil || d.cfg.Disabled || machineID == "" {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := d.now()
+	d.expire(now)
+
+	m, ok := d.machines[machineID]
+	if !ok {
+		m = &machine{history: make(map[string][]time.Time)}
+		d.machines[machineID] = m
+	}
+
+	m.seen = now
+
+	if action != "status" {
+		d.count(machineID, m, KindFlapping, d.cfg.Flapping, state, now)
+
+		if state == "on" || state == "off" {
+			m.expected = state
+		}
+
+		return
+	}
+
+	switch {
+	case m.expected == "on" && state == "off":
+		d.count(machineID, m, KindUnexpectedOff, d.cfg.UnexpectedOff, state, now)
+	case m.expected == "off" && state == "on":
+		d.count(machineID, m, KindUnexpectedOn, d.cfg.UnexpectedOn, state, now)
+	}
+
+	// Changes made outside of MAAS are reported once, further queries
+	// are compared with the state the machine was found in.
+	if state == "on" || state == "off" {
+		m.expected = state
+	}
+}
+
+// count records an occurrence of kind and raises an anomaly once the
+// threshold is reached. Occurrences are counted from scratch afterwards.
+func (d *Detector) count(machineID string, m *machine, kind string, t Threshold,
+	state string, now time.Time) {
+	cutoff := now.Add(-t.Window)
+
+	history := append(m.history[kind], now)
+	for len(history) > 0 && !history[0].After(cutoff) {
+		history = history[1:]
+	}
+
+	if len(history) < t.Count {
+		m.history[kind] = history
+		return
+	}
+
+	delete(m.history, kind)
+
+	a := Anomaly{
+		Time:    now,
+		Machine: machineID,
+		Kind:    kind,
+		State:   state,
+		Count:   len(history),
+		Window:  t.Window,
+	}
+
+	log.Warn().Str("machine", machineID).Str("kind", kind).
+		Int("count", a.Count).Dur("window", t.Window).Msg("Power anomaly")
+
+	d.anomalies.Push(a)
+}
+
+// expire forgets machines without power activity for machineTTL
+func (d *Detector) expire(now time.Time) {
+	for id, m := range d.machines {
+		if now.Sub(m.seen) > machineTTL {
+			delete(d.machines, id)
+		}
+	}
+}
+
+// Run reports anomalies until ctx is cancelled. Anomalies raised in
+// a quick succession are reported together.
+func (d *Detector) Run(ctx context.Context) {
+	for {
+		anomalies, err := d.anomalies.PopAll(ctx)
+		if err != nil {
+			return
+		}
+
+		if d.reporter == nil {
+			continue
+		}
+
+		if err := d.reporter.Report(ctx, anomalies); err != nil {
+			log.Warn().Err(err).Msg("Failed to report power anomalies")
+		}
+
+		d.pressure.Wait(ctx)
+	}
+}
+
+// BufferStats returns usage and drop counters of anomalies waiting
+// to be reported.
+func (d *Detector) BufferStats() ringbuf.Stats {
+	return d.anomalies.Stats()
+}
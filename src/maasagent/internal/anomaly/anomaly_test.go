@@ -0,0 +1,188 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package anomaly
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReporter struct {
+	reported chan []Anomaly
+}
+
+func (r *fakeReporter) Report(_ context.Context, a []Anomaly) error {
+	r.reported <- a
+	return nil
+}
+
+func newTestDetector(t *testing.T, cfg Config) (*Detector, *fakeReporter, *time.Time) {
+	t.Helper()
+
+	reporter := &fakeReporter{reported: make(chan []Anomaly, 10)}
+	d := NewDetector(cfg, WithReporter(reporter))
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		d.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	return d, reporter, &now
+}
+
+func reported(t *testing.T, r *fakeReporter) Anomaly {
+	t.Helper()
+
+	select {
+	case a := <-r.reported:
+		require.Len(t, a, 1)
+		return a[0]
+	case <-time.After(5 * time.Second):
+		t.Fatal("anomaly was not reported")
+	}
+
+	return Anomaly{}
+}
+
+func TestUnexpectedOff(t *testing.T) {
+	d, reporter, now := newTestDetector(t, Config{
+		UnexpectedOff: Threshold{Count: 2, Window: time.Hour},
+	})
+
+	// Machines powered off by MAAS are expected to be off
+	d.Observe("abc", "on", "on")
+	d.Observe("abc", "off", "off")
+	d.Observe("abc", "status", "off")
+
+	d.Observe("abc", "on", "on")
+	d.Observe("abc", "status", "off")
+
+	// Another machine is counted separately
+	d.Observe("def", "on", "on")
+	d.Observe("def", "status", "off")
+
+	*now = now.Add(time.Minute)
+
+	d.Observe("abc", "on", "on")
+	d.Observe("abc", "status", "off")
+
+	a := reported(t, reporter)
+	assert.Equal(t, "abc", a.Machine)
+	assert.Equal(t, KindUnexpectedOff, a.Kind)
+	assert.Equal(t, "off", a.State)
+	assert.Equal(t, 2, a.Count)
+	assert.Equal(t, time.Hour, a.Window)
+}
+
+func TestUnexpectedOn(t *testing.T) {
+	d, reporter, _ := newTestDetector(t, Config{})
+
+	d.Observe("abc", "off", "off")
+	d.Observe("abc", "status", "on")
+
+	a := reported(t, reporter)
+	assert.Equal(t, KindUnexpectedOn, a.Kind)
+	assert.Equal(t, 1, a.Count)
+
+	// Machine found on is reported once
+	d.Observe("abc", "status", "on")
+	assert.Empty(t, reporter.reported)
+}
+
+func TestFlapping(t *testing.T) {
+	d, reporter, now := newTestDetector(t, Config{
+		Flapping: Threshold{Count: 3, Window: time.Minute},
+	})
+
+	d.Observe("abc", "cycle", "on")
+
+	*now = now.Add(time.Minute)
+
+	// The first cycle is out of the window
+	d.Observe("abc", "cycle", "on")
+	d.Observe("abc", "off", "off")
+	assert.Empty(t, reporter.reported)
+
+	d.Observe("abc", "on", "on")
+
+	a := reported(t, reporter)
+	assert.Equal(t, KindFlapping, a.Kind)
+	assert.Equal(t, 3, a.Count)
+}
+
+func TestDisabled(t *testing.T) {
+	d, reporter, _ := newTestDetector(t, Config{Disabled: true})
+
+	d.Observe("abc", "off", "off")
+	d.Observe("abc", "status", "on")
+
+	assert.Empty(t, reporter.reported)
+	assert.Empty(t, d.machines)
+}
+
+func TestMachineID(t *testing.T) {
+	testcases := map[string]struct {
+		in  interface{}
+		out string
+	}{
+		"system_id": {
+			in: map[string]interface{}{"driver_opts": map[string]interface{}{
+				"system_id": "abc", "power_address": "10.0.0.1"}},
+			out: "abc",
+		},
+		"address": {
+			in:  map[string]interface{}{"driver_opts": map[string]interface{}{"power_address": "10.0.0.1"}},
+			out: "10.0.0.1",
+		},
+		"instance": {
+			in: map[string]interface{}{"driver_opts": map[string]interface{}{
+				"power_address": "qemu+ssh://host/system", "power_id": "vm1"}},
+			out: "qemu+ssh://host/system/vm1",
+		},
+		"no options": {
+			in:  map[string]interface{}{},
+			out: "",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, machineID(tc.in))
+		})
+	}
+}
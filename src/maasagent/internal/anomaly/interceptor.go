@@ -0,0 +1,127 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+)
+
+// powerActions are power actions keyed by activity names
+var powerActions = map[string]string{
+	"power-on":    "on",
+	"power-off":   "off",
+	"power-cycle": "cycle",
+	"power-reset": "reset",
+	"power-query": "status",
+}
+
+// NewInterceptor returns a worker interceptor that observes power states
+// returned by successful power activities.
+func NewInterceptor(d *Detector) interceptor.WorkerInterceptor {
+	return &anomalyInterceptor{detector: d}
+}
+
+type anomalyInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	detector *Detector
+}
+
+func (a *anomalyInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityAnomaly{detector: a.detector}
+	i.Next = next
+
+	return i
+}
+
+type activityAnomaly struct {
+	interceptor.ActivityInboundInterceptorBase
+	detector *Detector
+}
+
+func (a *activityAnomaly) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	res, err := a.Next.ExecuteActivity(ctx, in)
+
+	action, ok := powerActions[activity.GetInfo(ctx).ActivityType.Name]
+	if !ok || err != nil || len(in.Args) == 0 {
+		return res, err
+	}
+
+	if state := powerState(res); state != "" {
+		a.detector.Observe(machineID(in.Args[0]), action, state)
+	}
+
+	return res, err
+}
+
+// powerState returns the state of the power activity result
+func powerState(res interface{}) string {
+	var r struct {
+		State string `json:"state"`
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		return ""
+	}
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		return ""
+	}
+
+	return r.State
+}
+
+// machineID returns the system_id of the machine the power activity was
+// executed for, or the BMC address (with the instance on it, if any) when
+// the Region didn't pass the system_id.
+func machineID(param interface{}) string {
+	var p struct {
+		DriverOpts map[string]interface{} `json:"driver_opts"`
+	}
+
+	b, err := json.Marshal(param)
+	if err != nil {
+		return ""
+	}
+
+	if err := json.Unmarshal(b, &p); err != nil {
+		return ""
+	}
+
+	if id, ok := p.DriverOpts["system_id"].(string); ok && id != "" {
+		return id
+	}
+
+	address, ok := p.DriverOpts["power_address"].(string)
+	if !ok || address == "" {
+		return ""
+	}
+
+	for _, opt := range []string{"power_id", "node_id", "power_vm_name"} {
+		if v, ok := p.DriverOpts[opt]; ok && v != nil && fmt.Sprint(v) != "" {
+			return address + "/" + fmt.Sprint(v)
+		}
+	}
+
+	return address
+}
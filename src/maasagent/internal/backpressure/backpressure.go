@@ -0,0 +1,268 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package backpressure slows down producers of reports to the Region when
+// the Region is slow to acknowledge them. Producers batch for longer and
+// skip events which can be lost (sampling), rather than buffering them
+// in memory until the Region catches up.
+package backpressure
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Path is the URL path of the back-pressure status on the local API
+const Path = "/backpressure"
+
+const (
+	defaultTarget        = 2 * time.Second
+	defaultMaxInterval   = time.Minute
+	defaultMinSampleRate = 0.1
+	// minInterval is the first step of batching delay once the Region lags
+	minInterval = time.Second
+	// lagSmoothing is the weight of the latest acknowledgement in the lag
+	lagSmoothing = 0.3
+	// sampleRecovery is how much of sample rate is recovered on each
+	// acknowledgement in time. Recovery is slower than the back-off, so
+	// producers don't oscillate when the Region is close to its limit.
+	sampleRecovery = 0.1
+)
+
+// Reporter is implemented by reporters of the producers (e.g. slo.Reporter)
+type Reporter[T any] interface {
+	Report(ctx context.Context, events []T) error
+}
+
+// Status is the current state of a Controller.
+type Status struct {
+	// Lag is the smoothed time the Region takes to acknowledge a report
+	Lag time.Duration `json:"lag"`
+	// Interval is the extra time producers batch events for
+	Interval time.Duration `json:"interval"`
+	// SampleRate is the ratio of events which can be lost that are produced
+	SampleRate float64 `json:"sample_rate"`
+	// Skipped is the number of events skipped by sampling
+	Skipped uint64 `json:"skipped"`
+	// Throttled is true when producers are slowed down
+	Throttled bool `json:"throttled"`
+}
+
+// Controller adapts producers to the acknowledgement lag of the Region.
+// All methods are safe to call on nil Controller, which never throttles.
+type Controller struct {
+	random        func() float64
+	target        time.Duration
+	maxInterval   time.Duration
+	minSampleRate float64
+	status        Status
+	mutex         sync.Mutex
+}
+
+// ControllerOption allows to set additional Controller options
+type ControllerOption func(*Controller)
+
+// NewController returns a Controller which doesn't throttle until the
+// Region lags.
+func NewController(options ...ControllerOption) *Controller {
+	c := &Controller{
+		//nolint:gosec // sampling doesn't need a cryptographic random
+		random:        rand.Float64,
+		target:        defaultTarget,
+		maxInterval:   defaultMaxInterval,
+		minSampleRate: defaultMinSampleRate,
+		status:        Status{SampleRate: 1},
+	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	return c
+}
+
+// WithTarget sets the acknowledgement lag above which producers are
+// slowed down. (default: 2 seconds)
+func WithTarget(d time.Duration) ControllerOption {
+	return func(c *Controller) {
+		c.target = d
+	}
+}
+
+// WithMaxInterval sets the maximum extra time producers batch events for.
+// (default: 1 minute)
+func WithMaxInterval(d time.Duration) ControllerOption {
+	return func(c *Controller) {
+		c.maxInterval = d
+	}
+}
+
+// WithMinSampleRate sets the minimal ratio of events which can be lost
+// that are still produced when the Region lags. (default: 0.1)
+func WithMinSampleRate(r float64) ControllerOption {
+	return func(c *Controller) {
+		c.minSampleRate = r
+	}
+}
+
+// Observe records how long the Region took to acknowledge a report.
+// Failed reports count as lagging, whatever their latency.
+func (c *Controller) Observe(latency time.Duration, err error) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := &c.status
+
+	if s.Lag == 0 {
+		s.Lag = latency
+	} else {
+		s.Lag = time.Duration(lagSmoothing*float64(latency) + (1-lagSmoothing)*float64(s.Lag))
+	}
+
+	if err != nil || s.Lag > c.target {
+		s.Interval = min(max(2*s.Interval, minInterval), c.maxInterval)
+		s.SampleRate = max(s.SampleRate/2, c.minSampleRate)
+	} else {
+		s.Interval /= 2
+		if s.Interval < minInterval {
+			s.Interval = 0
+		}
+
+		s.SampleRate = min(s.SampleRate+sampleRecovery, 1)
+	}
+
+	throttled := s.Interval > 0 || s.SampleRate < 1
+	if throttled == s.Throttled {
+		return
+	}
+
+	s.Throttled = throttled
+
+	if throttled {
+		log.Warn().Dur("lag", s.Lag).Err(err).
+			Msg("Region is slow to acknowledge reports, producers are slowed down")
+	} else {
+		log.Info().Dur("lag", s.Lag).Uint64("skipped", s.Skipped).
+			Msg("Region caught up, producers are no longer slowed down")
+	}
+}
+
+// Interval returns the extra time producers should batch events for
+// before the next report. It is zero while the Region keeps up.
+func (c *Controller) Interval() time.Duration {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.status.Interval
+}
+
+// Sample returns false if an event which can be lost (e.g. a periodic
+// reading) should be skipped, because the Region lags.
+func (c *Controller) Sample() bool {
+	if c == nil {
+		return true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.status.SampleRate >= 1 || c.random() < c.status.SampleRate {
+		return true
+	}
+
+	c.status.Skipped++
+
+	return false
+}
+
+// Wait blocks for Interval or until ctx is cancelled. Producers reporting
+// in a loop call it after each report, so events are batched for longer.
+func (c *Controller) Wait(ctx context.Context) {
+	d := c.Interval()
+	if d == 0 {
+		return
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// Status returns the current state of the Controller.
+func (c *Controller) Status() Status {
+	if c == nil {
+		return Status{SampleRate: 1}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.status
+}
+
+// Handler returns http.Handler serving the current Status.
+func (c *Controller) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(c.Status())
+	})
+}
+
+// ObserveReporter returns Reporter passing events to next and observing
+// how long it takes to acknowledge them.
+func ObserveReporter[T any](next Reporter[T], c *Controller) Reporter[T] {
+	return &observedReporter[T]{next: next, controller: c}
+}
+
+type observedReporter[T any] struct {
+	next       Reporter[T]
+	controller *Controller
+}
+
+func (r *observedReporter[T]) Report(ctx context.Context, events []T) error {
+	start := time.Now()
+	err := r.next.Report(ctx, events)
+
+	// Cancelled reports say nothing about the Region
+	if ctx.Err() == nil {
+		r.controller.Observe(time.Since(start), err)
+	}
+
+	return err
+}
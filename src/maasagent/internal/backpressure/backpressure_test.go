@@ -0,0 +1,211 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package backpressure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControllerObserve(t *testing.T) {
+	errReport := errors.New("report failed")
+
+	type observation struct {
+		err     error
+		latency time.Duration
+	}
+
+	testcases := map[string]struct {
+		in  []observation
+		out Status
+	}{
+		"region keeps up": {
+			in: []observation{{latency: 100 * time.Millisecond}, {latency: 200 * time.Millisecond}},
+			out: Status{
+				Lag:        130 * time.Millisecond,
+				SampleRate: 1,
+			},
+		},
+		"region lags": {
+			in: []observation{{latency: 5 * time.Second}, {latency: 5 * time.Second}, {latency: 5 * time.Second}},
+			out: Status{
+				Lag:        5 * time.Second,
+				Interval:   4 * time.Second,
+				SampleRate: 0.125,
+				Throttled:  true,
+			},
+		},
+		"failures": {
+			in: []observation{{latency: time.Millisecond, err: errReport}},
+			out: Status{
+				Lag:        time.Millisecond,
+				Interval:   time.Second,
+				SampleRate: 0.5,
+				Throttled:  true,
+			},
+		},
+		"minimal sample rate and maximal interval": {
+			in: []observation{
+				{err: errReport}, {err: errReport}, {err: errReport},
+				{err: errReport}, {err: errReport}, {err: errReport},
+			},
+			out: Status{
+				Interval:   10 * time.Second,
+				SampleRate: 0.1,
+				Throttled:  true,
+			},
+		},
+		"region caught up": {
+			in: []observation{
+				{err: errReport}, {err: errReport},
+				{latency: time.Millisecond}, {latency: time.Millisecond},
+			},
+			out: Status{
+				Lag:        time.Millisecond,
+				SampleRate: 0.45,
+				Throttled:  true,
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := NewController(WithMaxInterval(10 * time.Second))
+
+			for _, o := range tc.in {
+				c.Observe(o.latency, o.err)
+			}
+
+			status := c.Status()
+			assert.InDelta(t, tc.out.SampleRate, status.SampleRate, 0.001)
+
+			status.SampleRate = tc.out.SampleRate
+			assert.Equal(t, tc.out, status)
+		})
+	}
+}
+
+func TestControllerSample(t *testing.T) {
+	t.Parallel()
+
+	c := NewController()
+	c.random = func() float64 { return 0.3 }
+
+	assert.True(t, c.Sample())
+
+	// Sample rate drops to 0.25
+	c.Observe(0, errors.New("report failed"))
+	c.Observe(0, errors.New("report failed"))
+
+	assert.False(t, c.Sample())
+	assert.False(t, c.Sample())
+	assert.Equal(t, uint64(2), c.Status().Skipped)
+
+	c.random = func() float64 { return 0.2 }
+
+	assert.True(t, c.Sample())
+}
+
+func TestNilController(t *testing.T) {
+	t.Parallel()
+
+	var c *Controller
+
+	c.Observe(time.Hour, errors.New("report failed"))
+	c.Wait(context.Background())
+
+	assert.True(t, c.Sample())
+	assert.Zero(t, c.Interval())
+	assert.Equal(t, Status{SampleRate: 1}, c.Status())
+}
+
+func TestControllerWait(t *testing.T) {
+	t.Parallel()
+
+	c := NewController()
+	c.Observe(0, errors.New("report failed"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Cancelled wait returns immediately, even though Interval is 1s
+	start := time.Now()
+	c.Wait(ctx)
+
+	assert.Less(t, time.Since(start), c.Interval())
+}
+
+type fakeReporter struct {
+	err    error
+	events []string
+	delay  time.Duration
+}
+
+func (r *fakeReporter) Report(_ context.Context, events []string) error {
+	time.Sleep(r.delay)
+
+	r.events = append(r.events, events...)
+
+	return r.err
+}
+
+func TestObserveReporter(t *testing.T) {
+	t.Parallel()
+
+	c := NewController(WithTarget(time.Millisecond))
+	next := &fakeReporter{delay: 10 * time.Millisecond}
+
+	err := ObserveReporter[string](next, c).Report(context.Background(), []string{"a", "b"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b"}, next.events)
+	assert.GreaterOrEqual(t, c.Status().Lag, 10*time.Millisecond)
+	assert.True(t, c.Status().Throttled)
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	c := NewController()
+	c.Observe(0, errors.New("report failed"))
+
+	w := httptest.NewRecorder()
+	c.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, Path, nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var status Status
+
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, c.Status(), status)
+
+	w = httptest.NewRecorder()
+	c.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, Path, nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature is returned when a signed URL has been tampered with
+	ErrInvalidSignature = errors.New("invalid artifact URL signature")
+	// ErrExpiredSignature is returned when a signed URL is no longer valid
+	ErrExpiredSignature = errors.New("artifact URL has expired")
+)
+
+// URLSigner signs artifact keys, so artifacts can be retrieved over HTTP
+// with time-limited URLs that require no other authentication.
+type URLSigner struct {
+	now    func() time.Time
+	secret []byte
+}
+
+// NewURLSigner returns URLSigner using secret as HMAC key.
+func NewURLSigner(secret []byte) *URLSigner {
+	return &URLSigner{secret: secret, now: time.Now}
+}
+
+// Sign returns query parameters that grant access to the artifact
+// for the given duration.
+func (s *URLSigner) Sign(key string, expires time.Duration) url.Values {
+	exp := strconv.FormatInt(s.now().Add(expires).Unix(), 10)
+
+	return url.Values{
+		"expires":   []string{exp},
+		"signature": []string{s.mac(key, exp)},
+	}
+}
+
+// Verify checks that query parameters contain a valid signature
+// for the given key.
+func (s *URLSigner) Verify(key string, q url.Values) error {
+	exp := q.Get("expires")
+
+	sig, err := hex.DecodeString(q.Get("signature"))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	expected, err := hex.DecodeString(s.mac(key, exp))
+	if err != nil || !hmac.Equal(sig, expected) {
+		return ErrInvalidSignature
+	}
+
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if s.now().After(time.Unix(ts, 0)) {
+		return ErrExpiredSignature
+	}
+
+	return nil
+}
+
+func (s *URLSigner) mac(key, expires string) string {
+	return hex.EncodeToString(hmacSHA256(s.secret, key+"\n"+expires))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+type handler struct {
+	store  Store
+	signer *URLSigner
+	prefix string
+}
+
+// NewHandler returns http.Handler that streams artifacts from the store.
+// Handler is expected to be mounted at prefix and every request must be
+// signed with URLSigner.
+func NewHandler(prefix string, store Store, signer *URLSigner) http.Handler {
+	return &handler{prefix: prefix, store: store, signer: signer}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, h.prefix)
+
+	if err := h.signer.Verify(key, r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	rc, err := h.store.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrInvalidKey):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	// Seekable artifacts (FileStore) support range requests, which allows
+	// resuming downloads of large artifacts such as firmware images.
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, time.Time{}, rs)
+		return
+	}
+
+	//nolint:errcheck // nothing can be done once streaming has started
+	io.Copy(w, rc)
+}
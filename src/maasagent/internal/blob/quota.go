@@ -0,0 +1,387 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package blob
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	// ErrQuotaExceeded is returned when an artifact is larger than
+	// the quota of its subsystem
+	ErrQuotaExceeded = errors.New("artifact quota exceeded")
+	// ErrUnavailable is returned when artifacts cannot be written, because
+	// the underlying storage is unhealthy
+	ErrUnavailable = errors.New("artifact store is unavailable")
+)
+
+// Usage describes disk usage of a single subsystem.
+type Usage struct {
+	Subsystem string `json:"subsystem"`
+	// Used is the total size of artifacts in bytes
+	Used int64 `json:"used"`
+	// Quota is the maximum size in bytes. Zero means unlimited.
+	Quota int64 `json:"quota"`
+	Items int   `json:"items"`
+}
+
+type quotaEntry struct {
+	key  string
+	size int64
+}
+
+type subsystem struct {
+	// lru holds *quotaEntry with the most recently used at the front
+	lru      *list.List
+	items    map[string]*list.Element
+	quota    int64
+	used     int64
+	reserved int64
+}
+
+// walker is implemented by stores that can enumerate existing artifacts.
+type walker interface {
+	Walk(ctx context.Context, fn func(key string, size int64, modTime time.Time) error) error
+}
+
+// QuotaStore is a Store that limits the total size of artifacts per subsystem.
+// Subsystem is the first element of the artifact key (e.g. "console", "pcap").
+// Before an artifact of known size is written, least recently used artifacts
+// of the same subsystem are evicted to make room for it. Artifacts of unknown
+// size are aborted once they exceed the quota.
+type QuotaStore struct {
+	store      Store
+	writable   func() bool
+	subsystems map[string]*subsystem
+	quotas     map[string]int64
+	mutex      sync.Mutex
+}
+
+// QuotaStoreOption allows to set additional QuotaStore options
+type QuotaStoreOption func(*QuotaStore)
+
+// NewQuotaStore returns QuotaStore wrapping store. Subsystems without
+// a quota are tracked, but not limited. If store can enumerate existing
+// artifacts, they are indexed ordered by modification time.
+func NewQuotaStore(ctx context.Context, store Store, quotas map[string]int64,
+	options ...QuotaStoreOption) (*QuotaStore, error) {
+	s := &QuotaStore{
+		store:      store,
+		quotas:     quotas,
+		subsystems: make(map[string]*subsystem),
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	for name := range quotas {
+		s.subsystem(name)
+	}
+
+	if w, ok := store.(walker); ok {
+		if err := s.reindex(ctx, w); err != nil {
+			return nil, fmt.Errorf("failed to index artifacts: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// WithMetricMeter allows to set OpenTelemetry metric.Meter
+// to collect disk usage per subsystem.
+func WithMetricMeter(meter metric.Meter) QuotaStoreOption {
+	return func(s *QuotaStore) {
+		must(meter.Int64ObservableGauge("artifacts.size",
+			metric.WithUnit("byte"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				for _, u := range s.Usage() {
+					sub := attribute.String("subsystem", u.Subsystem)
+
+					o.Observe(u.Used, metric.WithAttributes(sub, attribute.String("type", "current")))
+
+					if u.Quota > 0 {
+						o.Observe(u.Quota, metric.WithAttributes(sub, attribute.String("type", "max")))
+					}
+				}
+
+				return nil
+			})))
+	}
+}
+
+// WithHealthCheck allows to set a function reporting whether the underlying
+// storage is writable. Put fails fast with ErrUnavailable otherwise.
+func WithHealthCheck(writable func() bool) QuotaStoreOption {
+	return func(s *QuotaStore) {
+		s.writable = writable
+	}
+}
+
+func (s *QuotaStore) reindex(ctx context.Context, w walker) error {
+	type item struct {
+		modTime time.Time
+		quotaEntry
+	}
+
+	var items []item
+
+	err := w.Walk(ctx, func(key string, size int64, modTime time.Time) error {
+		items = append(items, item{quotaEntry: quotaEntry{key: key, size: size}, modTime: modTime})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].modTime.Before(items[j].modTime) })
+
+	var victims []string
+
+	s.mutex.Lock()
+
+	for _, i := range items {
+		s.add(s.subsystem(i.key), i.key, i.size)
+	}
+
+	// Quotas might have been lowered since artifacts were written
+	for _, sub := range s.subsystems {
+		victims = append(victims, s.evict(sub, "", 0)...)
+	}
+
+	s.mutex.Unlock()
+
+	s.remove(ctx, victims)
+
+	return nil
+}
+
+// subsystem returns state of the subsystem owning key. Must be called
+// with mutex held.
+func (s *QuotaStore) subsystem(key string) *subsystem {
+	name, _, _ := strings.Cut(key, "/")
+
+	sub, ok := s.subsystems[name]
+	if !ok {
+		sub = &subsystem{
+			lru:   list.New(),
+			items: make(map[string]*list.Element),
+			quota: s.quotas[name],
+		}
+		s.subsystems[name] = sub
+	}
+
+	return sub
+}
+
+// add records artifact as the most recently used, replacing previous
+// artifact with the same key. Must be called with mutex held.
+func (s *QuotaStore) add(sub *subsystem, key string, size int64) {
+	if e, ok := sub.items[key]; ok {
+		sub.used -= e.Value.(*quotaEntry).size
+		sub.lru.Remove(e)
+	}
+
+	sub.items[key] = sub.lru.PushFront(&quotaEntry{key: key, size: size})
+	sub.used += size
+}
+
+// evict removes least recently used artifacts, except keep, from the index
+// until size bytes fit into the quota. Keys of evicted artifacts are returned,
+// so they can be deleted from the store without holding the mutex.
+// Must be called with mutex held.
+func (s *QuotaStore) evict(sub *subsystem, keep string, size int64) []string {
+	var victims []string
+
+	if sub.quota <= 0 {
+		return nil
+	}
+
+	e := sub.lru.Back()
+	for e != nil && sub.used+sub.reserved+size > sub.quota {
+		prev := e.Prev()
+
+		if entry := e.Value.(*quotaEntry); entry.key != keep {
+			sub.used -= entry.size
+			sub.lru.Remove(e)
+			delete(sub.items, entry.key)
+
+			victims = append(victims, entry.key)
+		}
+
+		e = prev
+	}
+
+	return victims
+}
+
+func (s *QuotaStore) remove(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		//nolint:errcheck // artifact is no longer indexed, a leftover is harmless
+		s.store.Delete(ctx, key)
+	}
+}
+
+// Put checks the quota before writing, evicting least recently used
+// artifacts of the same subsystem when needed.
+func (s *QuotaStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if s.writable != nil && !s.writable() {
+		return ErrUnavailable
+	}
+
+	s.mutex.Lock()
+
+	sub := s.subsystem(key)
+
+	if sub.quota > 0 && size > sub.quota {
+		s.mutex.Unlock()
+		return fmt.Errorf("%w: %s is %d bytes, quota is %d", ErrQuotaExceeded, key, size, sub.quota)
+	}
+
+	reserve := max(size, 0)
+
+	victims := s.evict(sub, key, reserve)
+	sub.reserved += reserve
+	quota := sub.quota
+
+	s.mutex.Unlock()
+
+	s.remove(ctx, victims)
+
+	cr := &countingReader{r: r, limit: quota}
+
+	err := s.store.Put(ctx, key, cr, size)
+
+	s.mutex.Lock()
+
+	sub.reserved -= reserve
+
+	if err == nil {
+		s.add(sub, key, cr.n)
+		victims = s.evict(sub, key, 0)
+	}
+
+	s.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	s.remove(ctx, victims)
+
+	return nil
+}
+
+// Get marks artifact as recently used.
+func (s *QuotaStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sub := s.subsystem(key)
+
+	if e, ok := sub.items[key]; ok {
+		sub.lru.MoveToFront(e)
+	}
+
+	return rc, nil
+}
+
+func (s *QuotaStore) Delete(ctx context.Context, key string) error {
+	if err := s.store.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sub := s.subsystem(key)
+
+	if e, ok := sub.items[key]; ok {
+		sub.used -= e.Value.(*quotaEntry).size
+		sub.lru.Remove(e)
+		delete(sub.items, key)
+	}
+
+	return nil
+}
+
+// Usage returns disk usage of all known subsystems sorted by name.
+func (s *QuotaStore) Usage() []Usage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := make([]Usage, 0, len(s.subsystems))
+
+	for name, sub := range s.subsystems {
+		res = append(res, Usage{
+			Subsystem: name,
+			Used:      sub.used,
+			Quota:     sub.quota,
+			Items:     sub.lru.Len(),
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Subsystem < res[j].Subsystem })
+
+	return res
+}
+
+// countingReader counts bytes read and fails once more than limit bytes
+// were read. Zero limit means unlimited.
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+
+	if r.limit > 0 && r.n > r.limit {
+		return n, fmt.Errorf("%w: more than %d bytes written", ErrQuotaExceeded, r.limit)
+	}
+
+	return n, err
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// s3PartSize is the size of parts artifacts of unknown size are
+	// streamed in, which is also the amount of memory used to upload them.
+	s3PartSize = 16 * 1024 * 1024
+)
+
+// S3Config contains settings required to access S3 compatible storage.
+type S3Config struct {
+	// Endpoint is the base URL, e.g. https://s3.us-east-1.amazonaws.com
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// PathStyle addresses objects as {endpoint}/{bucket}/{key} instead of
+	// {bucket}.{endpoint}/{key}. Required by most on-premise implementations.
+	PathStyle bool `yaml:"path_style"`
+}
+
+// S3Store is a Store backed by S3 compatible object storage.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Store returns S3Store sending requests through the provided
+// http.Client transport.
+func NewS3Store(cfg S3Config, client *http.Client) (*S3Store, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := minio.BucketLookupDNS
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	c, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       u.Scheme == "https",
+		Transport:    client.Transport,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{client: c, bucket: cfg.Bucket}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if size < 0 {
+		// Artifacts smaller than a part are uploaded with a single request,
+		// larger ones are streamed as a multipart upload.
+		b, err := io.ReadAll(io.LimitReader(r, s3PartSize))
+		if err != nil {
+			return err
+		}
+
+		if len(b) < s3PartSize {
+			r, size = bytes.NewReader(b), int64(len(b))
+		} else {
+			r = io.MultiReader(bytes.NewReader(b), r)
+		}
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size,
+		minio.PutObjectOptions{PartSize: s3PartSize})
+
+	return s3Error(err)
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	// Object is retrieved on first use, hence Stat() is needed to know
+	// whether it exists.
+	if _, err := obj.Stat(); err != nil {
+		//nolint:errcheck // should be safe to ignore an error from Close()
+		obj.Close()
+		return nil, s3Error(err)
+	}
+
+	return obj, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	err := s3Error(s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// Presign returns a URL that can be used by the Region to retrieve
+// the artifact directly from S3 until it expires.
+func (s *S3Store) Presign(key string, expires time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	u, err := s.client.PresignedGetObject(context.Background(), s.bucket, key, expires, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	return fmt.Errorf("s3 request failed: %w", err)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package blob provides a store for large artifacts produced by the Agent
+// (console logs, pcaps, inventory reports, firmware images) that should not
+// be passed through Temporal.
+//
+// Artifacts are addressed by slash separated keys, where the first element is
+// expected to be the kind of the artifact, e.g. "console/<system_id>/<ts>.log".
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when there is no artifact with the given key
+	ErrNotFound = errors.New("artifact not found")
+	// ErrInvalidKey is returned when key is empty or escapes the store
+	ErrInvalidKey = errors.New("invalid artifact key")
+	// ErrSizeMismatch is returned when the amount of data written differs
+	// from the declared size
+	ErrSizeMismatch = errors.New("artifact size mismatch")
+)
+
+// Store is an interface that wraps artifact storage operations.
+type Store interface {
+	// Put stores size bytes read from r under the given key, replacing any
+	// existing artifact. Negative size means the size is unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get returns a reader for the artifact. Caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the artifact. Deleting a non existing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// validateKey ensures that key is a clean relative path, so it cannot be
+// used to read or write outside of the store.
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key {
+		return ErrInvalidKey
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == "." {
+			return ErrInvalidKey
+		}
+	}
+
+	return nil
+}
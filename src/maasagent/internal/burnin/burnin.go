@@ -0,0 +1,188 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package burnin runs hardware stress tests on machines before they are
+// made available for deployment, so that faulty hardware is caught before
+// it is handed to users.
+package burnin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Supported stress test tools, executed by the ephemeral environment
+const (
+	ToolMemtest  = "memtest"
+	ToolFIO      = "fio"
+	ToolStressNG = "stress-ng"
+)
+
+var (
+	// ErrInvalidTest is returned when burn-in test definition is not valid
+	ErrInvalidTest = errors.New("invalid burn-in test")
+)
+
+// Threshold limits a metric reported by a test. Nil bounds are not checked.
+type Threshold struct {
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+	Metric string   `json:"metric"`
+}
+
+// Test is a single stress test
+type Test struct {
+	Name string `json:"name"`
+	Tool string `json:"tool"`
+	// Args are passed to the tool as is
+	Args       []string    `json:"args,omitempty"`
+	Thresholds []Threshold `json:"thresholds,omitempty"`
+	// Duration in seconds the tool is allowed to run
+	Duration int `json:"duration"`
+}
+
+// TestResult is the result of a test as reported by the ephemeral environment
+type TestResult struct {
+	Metrics map[string]float64 `json:"metrics"`
+	Name    string             `json:"name"`
+	// Duration in seconds the tool actually ran
+	Duration   int `json:"duration"`
+	ExitStatus int `json:"exit_status"`
+}
+
+// Verdict is the evaluation of a test result
+type Verdict struct {
+	Name     string   `json:"name"`
+	Failures []string `json:"failures,omitempty"`
+	Passed   bool     `json:"passed"`
+}
+
+// Validate checks that tests can be executed
+func Validate(tests []Test) error {
+	if len(tests) == 0 {
+		return fmt.Errorf("%w: no tests", ErrInvalidTest)
+	}
+
+	names := make(map[string]struct{}, len(tests))
+
+	for _, t := range tests {
+		if t.Name == "" {
+			return fmt.Errorf("%w: test name is required", ErrInvalidTest)
+		}
+
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("%w: duplicate test %q", ErrInvalidTest, t.Name)
+		}
+
+		names[t.Name] = struct{}{}
+
+		switch t.Tool {
+		case ToolMemtest, ToolFIO, ToolStressNG:
+		default:
+			return fmt.Errorf("%w: %s: unsupported tool %q", ErrInvalidTest, t.Name, t.Tool)
+		}
+
+		if t.Duration <= 0 {
+			return fmt.Errorf("%w: %s: duration must be positive", ErrInvalidTest, t.Name)
+		}
+
+		for _, th := range t.Thresholds {
+			if th.Metric == "" || (th.Min == nil && th.Max == nil) {
+				return fmt.Errorf("%w: %s: threshold needs a metric and a bound", ErrInvalidTest, t.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// TotalDuration returns the time needed to run all tests one after another
+func TotalDuration(tests []Test) time.Duration {
+	var total time.Duration
+
+	for _, t := range tests {
+		total += time.Duration(t.Duration) * time.Second
+	}
+
+	return total
+}
+
+// Evaluate checks results against the tests. A test fails if it has no
+// result, exited with non-zero status, ran longer than its duration limit,
+// or any of its metrics is missing or out of bounds.
+func Evaluate(tests []Test, results []TestResult) []Verdict {
+	byName := make(map[string]TestResult, len(results))
+	for _, r := range results {
+		byName[r.Name] = r
+	}
+
+	verdicts := make([]Verdict, 0, len(tests))
+
+	for _, t := range tests {
+		v := Verdict{Name: t.Name}
+
+		r, ok := byName[t.Name]
+
+		switch {
+		case !ok:
+			v.Failures = append(v.Failures, "no result")
+		case r.ExitStatus != 0:
+			v.Failures = append(v.Failures, fmt.Sprintf("exit status %d", r.ExitStatus))
+		default:
+			if r.Duration > t.Duration {
+				v.Failures = append(v.Failures,
+					fmt.Sprintf("ran for %ds, limit is %ds", r.Duration, t.Duration))
+			}
+
+			for _, th := range t.Thresholds {
+				if f := th.check(r.Metrics); f != "" {
+					v.Failures = append(v.Failures, f)
+				}
+			}
+		}
+
+		v.Passed = len(v.Failures) == 0
+		verdicts = append(verdicts, v)
+	}
+
+	return verdicts
+}
+
+func (th Threshold) check(metrics map[string]float64) string {
+	value, ok := metrics[th.Metric]
+
+	switch {
+	case !ok:
+		return fmt.Sprintf("%s: not reported", th.Metric)
+	case th.Min != nil && value < *th.Min:
+		return fmt.Sprintf("%s: %g is below %g", th.Metric, value, *th.Min)
+	case th.Max != nil && value > *th.Max:
+		return fmt.Sprintf("%s: %g is above %g", th.Metric, value, *th.Max)
+	}
+
+	return ""
+}
+
+// Passed returns true if every verdict passed
+func Passed(verdicts []Verdict) bool {
+	for _, v := range verdicts {
+		if !v.Passed {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package burnin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestValidate(t *testing.T) {
+	testcases := map[string]struct {
+		in  []Test
+		err error
+	}{
+		"valid": {
+			in: []Test{
+				{Name: "memory", Tool: ToolMemtest, Duration: 600},
+				{Name: "disk", Tool: ToolFIO, Duration: 300, Thresholds: []Threshold{
+					{Metric: "read_iops", Min: ptr(1000)},
+				}},
+			},
+		},
+		"empty": {
+			err: ErrInvalidTest,
+		},
+		"unsupported tool": {
+			in:  []Test{{Name: "cpu", Tool: "prime95", Duration: 60}},
+			err: ErrInvalidTest,
+		},
+		"duplicate name": {
+			in: []Test{
+				{Name: "cpu", Tool: ToolStressNG, Duration: 60},
+				{Name: "cpu", Tool: ToolStressNG, Duration: 60},
+			},
+			err: ErrInvalidTest,
+		},
+		"no duration": {
+			in:  []Test{{Name: "cpu", Tool: ToolStressNG}},
+			err: ErrInvalidTest,
+		},
+		"threshold without bounds": {
+			in: []Test{{Name: "cpu", Tool: ToolStressNG, Duration: 60, Thresholds: []Threshold{
+				{Metric: "bogo_ops"},
+			}}},
+			err: ErrInvalidTest,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, Validate(tc.in), tc.err)
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []Test{
+		{Name: "memory", Tool: ToolMemtest, Duration: 600},
+		{Name: "disk", Tool: ToolFIO, Duration: 300, Thresholds: []Threshold{
+			{Metric: "read_iops", Min: ptr(1000)},
+			{Metric: "latency_ms", Max: ptr(5)},
+		}},
+	}
+
+	testcases := map[string]struct {
+		in     []TestResult
+		out    []Verdict
+		passed bool
+	}{
+		"passed": {
+			in: []TestResult{
+				{Name: "memory", Duration: 600},
+				{Name: "disk", Duration: 290, Metrics: map[string]float64{
+					"read_iops": 25000, "latency_ms": 0.8,
+				}},
+			},
+			out: []Verdict{
+				{Name: "memory", Passed: true},
+				{Name: "disk", Passed: true},
+			},
+			passed: true,
+		},
+		"failed": {
+			in: []TestResult{
+				{Name: "memory", Duration: 120, ExitStatus: 1},
+				{Name: "disk", Duration: 310, Metrics: map[string]float64{"read_iops": 200}},
+			},
+			out: []Verdict{
+				{Name: "memory", Failures: []string{"exit status 1"}},
+				{Name: "disk", Failures: []string{
+					"ran for 310s, limit is 300s",
+					"read_iops: 200 is below 1000",
+					"latency_ms: not reported",
+				}},
+			},
+		},
+		"missing result": {
+			in: []TestResult{
+				{Name: "memory", Duration: 600},
+			},
+			out: []Verdict{
+				{Name: "memory", Passed: true},
+				{Name: "disk", Failures: []string{"no result"}},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			verdicts := Evaluate(tests, tc.in)
+			assert.Equal(t, tc.out, verdicts)
+			assert.Equal(t, tc.passed, Passed(verdicts))
+		})
+	}
+}
+
+func TestTotalDuration(t *testing.T) {
+	assert.Equal(t, 15*time.Minute, TotalDuration([]Test{
+		{Name: "memory", Duration: 600},
+		{Name: "disk", Duration: 300},
+	}))
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package burnin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	// bootMargin is added to the duration of tests to allow the machine
+	// to boot the ephemeral environment and report results
+	bootMargin   = 20 * time.Minute
+	pollInterval = 30 * time.Second
+)
+
+// BurnInMachineParam is the parameter of burn-in-machine workflow
+type BurnInMachineParam struct {
+	SystemID string `json:"system_id"`
+	power.PowerParam
+	Tests []Test `json:"tests"`
+}
+
+// BurnInMachineResult is the result of burn-in-machine workflow, which is
+// also reported to the Region
+type BurnInMachineResult struct {
+	SystemID string       `json:"system_id"`
+	Verdicts []Verdict    `json:"verdicts"`
+	Results  []TestResult `json:"results"`
+	// TimedOut is set if not all results were reported in time
+	TimedOut bool `json:"timed_out"`
+	Passed   bool `json:"passed"`
+}
+
+type machineParam struct {
+	SystemID string `json:"system_id"`
+}
+
+type ephemeralBurnInParam struct {
+	SystemID string `json:"system_id"`
+	Tests    []Test `json:"tests"`
+}
+
+type getBurnInResultsResult struct {
+	Results   []TestResult `json:"results"`
+	Completed bool         `json:"completed"`
+}
+
+// Service executes burn-in tests. Invocation of this service normally
+// should happen via Temporal.
+type Service struct {
+	systemID string
+}
+
+// NewService returns an instance of Service
+func NewService(systemID string) *Service {
+	return &Service{systemID: systemID}
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{"burn-in-machine": s.burnInMachine}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func regionContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              "region",
+		ScheduleToCloseTimeout: 60 * time.Second,
+	})
+}
+
+func (s *Service) powerContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:           fmt.Sprintf("%s@agent:power", s.systemID),
+		StartToCloseTimeout: 60 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+}
+
+// burnInMachine boots the machine into the ephemeral environment which runs
+// the stress tests, evaluates reported results against thresholds and
+// reports the verdict to the Region, which decides whether the machine
+// can become Ready. A failed burn-in is not a workflow error.
+func (s *Service) burnInMachine(ctx tworkflow.Context,
+	param BurnInMachineParam) (result *BurnInMachineResult, err error) {
+	log := tworkflow.GetLogger(ctx)
+
+	if err := Validate(param.Tests); err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	machine := machineParam{SystemID: param.SystemID}
+
+	if err := tworkflow.ExecuteActivity(s.powerContext(ctx), "power-off",
+		power.PowerOffParam{PowerParam: param.PowerParam}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "set-machine-ephemeral-burn-in",
+		ephemeralBurnInParam{SystemID: param.SystemID, Tests: param.Tests}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		// Cleanup must happen even if the workflow was cancelled
+		ctx, cancel := tworkflow.NewDisconnectedContext(ctx)
+		defer cancel()
+
+		cleanupErr := errors.Join(
+			tworkflow.ExecuteActivity(s.powerContext(ctx), "power-off",
+				power.PowerOffParam{PowerParam: param.PowerParam}).Get(ctx, nil),
+			tworkflow.ExecuteActivity(regionContext(ctx), "restore-machine-boot",
+				machine).Get(ctx, nil),
+		)
+		if cleanupErr != nil {
+			log.Error("Failed to restore machine after burn-in", tag.Builder().
+				KV("system_id", param.SystemID).Error(cleanupErr).KeyVals...)
+
+			err = errors.Join(err, cleanupErr)
+		}
+	}()
+
+	if err := tworkflow.ExecuteActivity(s.powerContext(ctx), "power-on",
+		power.PowerOnParam{PowerParam: param.PowerParam}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	deadline := tworkflow.Now(ctx).Add(TotalDuration(param.Tests) + bootMargin)
+
+	results, completed, err := waitResults(ctx, machine, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts := Evaluate(param.Tests, results)
+
+	result = &BurnInMachineResult{
+		SystemID: param.SystemID,
+		Verdicts: verdicts,
+		Results:  results,
+		TimedOut: !completed,
+		Passed:   completed && Passed(verdicts),
+	}
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "report-burn-in",
+		result).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	log.Info("Burn-in finished", tag.Builder().
+		KV("system_id", param.SystemID).
+		KV("passed", result.Passed).
+		KV("timed_out", result.TimedOut).KeyVals...)
+
+	return result, nil
+}
+
+// waitResults polls the Region for results reported by the ephemeral
+// environment until all tests have completed or the deadline has passed,
+// in which case partial results are returned.
+func waitResults(ctx tworkflow.Context, machine machineParam,
+	deadline time.Time) ([]TestResult, bool, error) {
+	for {
+		var res getBurnInResultsResult
+
+		if err := tworkflow.ExecuteActivity(regionContext(ctx), "get-burn-in-results", machine).
+			Get(ctx, &res); err != nil {
+			return nil, false, err
+		}
+
+		if res.Completed {
+			return res.Results, true, nil
+		}
+
+		if !tworkflow.Now(ctx).Before(deadline) {
+			return res.Results, false, nil
+		}
+
+		if err := tworkflow.Sleep(ctx, pollInterval); err != nil {
+			return nil, false, err
+		}
+	}
+}
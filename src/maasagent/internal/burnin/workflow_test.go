@@ -0,0 +1,172 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package burnin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+func machineActivity(_ context.Context, _ machineParam) error {
+	return nil
+}
+
+func ephemeralBurnInActivity(_ context.Context, _ ephemeralBurnInParam) error {
+	return nil
+}
+
+func getBurnInResultsActivity(_ context.Context, _ machineParam) (getBurnInResultsResult, error) {
+	return getBurnInResultsResult{}, nil
+}
+
+func reportBurnInActivity(_ context.Context, _ *BurnInMachineResult) error {
+	return nil
+}
+
+func powerOffActivity(_ context.Context, _ power.PowerOffParam) (*power.PowerOffResult, error) {
+	return nil, nil
+}
+
+func powerOnActivity(_ context.Context, _ power.PowerOnParam) (*power.PowerOnResult, error) {
+	return nil, nil
+}
+
+func TestBurnInMachine(t *testing.T) {
+	tests := []Test{
+		{Name: "memory", Tool: ToolMemtest, Duration: 600},
+		{Name: "disk", Tool: ToolFIO, Duration: 300, Thresholds: []Threshold{
+			{Metric: "read_iops", Min: ptr(1000)},
+		}},
+	}
+
+	testcases := map[string]struct {
+		results  []getBurnInResultsResult
+		passed   bool
+		timedOut bool
+	}{
+		"passed": {
+			results: []getBurnInResultsResult{
+				{Results: []TestResult{{Name: "memory", Duration: 600}}},
+				{Completed: true, Results: []TestResult{
+					{Name: "memory", Duration: 600},
+					{Name: "disk", Duration: 300, Metrics: map[string]float64{"read_iops": 5000}},
+				}},
+			},
+			passed: true,
+		},
+		"below threshold": {
+			results: []getBurnInResultsResult{
+				{Completed: true, Results: []TestResult{
+					{Name: "memory", Duration: 600},
+					{Name: "disk", Duration: 300, Metrics: map[string]float64{"read_iops": 50}},
+				}},
+			},
+		},
+		"timed out": {
+			results: []getBurnInResultsResult{
+				{Results: []TestResult{{Name: "memory", Duration: 600}}},
+			},
+			timedOut: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			suite := testsuite.WorkflowTestSuite{}
+			suite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+			env := suite.NewTestWorkflowEnvironment()
+
+			env.RegisterActivityWithOptions(powerOffActivity, activity.RegisterOptions{Name: "power-off"})
+			env.RegisterActivityWithOptions(powerOnActivity, activity.RegisterOptions{Name: "power-on"})
+			env.RegisterActivityWithOptions(ephemeralBurnInActivity,
+				activity.RegisterOptions{Name: "set-machine-ephemeral-burn-in"})
+			env.RegisterActivityWithOptions(machineActivity,
+				activity.RegisterOptions{Name: "restore-machine-boot"})
+			env.RegisterActivityWithOptions(getBurnInResultsActivity,
+				activity.RegisterOptions{Name: "get-burn-in-results"})
+			env.RegisterActivityWithOptions(reportBurnInActivity,
+				activity.RegisterOptions{Name: "report-burn-in"})
+
+			env.OnActivity("power-off", mock.Anything, mock.Anything).
+				Return(&power.PowerOffResult{State: "off"}, nil)
+			env.OnActivity("power-on", mock.Anything, mock.Anything).
+				Return(&power.PowerOnResult{State: "on"}, nil)
+			env.OnActivity("set-machine-ephemeral-burn-in", mock.Anything,
+				ephemeralBurnInParam{SystemID: "abc123", Tests: tests}).Return(nil)
+			env.OnActivity("restore-machine-boot", mock.Anything, machineParam{SystemID: "abc123"}).
+				Return(nil).Once()
+
+			for i, r := range tc.results {
+				call := env.OnActivity("get-burn-in-results", mock.Anything, mock.Anything).Return(r, nil)
+				// The last result is returned until the deadline
+				if i < len(tc.results)-1 {
+					call.Once()
+				}
+			}
+
+			var reported *BurnInMachineResult
+
+			env.OnActivity("report-burn-in", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, r *BurnInMachineResult) error {
+					reported = r
+					return nil
+				})
+
+			env.ExecuteWorkflow(NewService("agent").burnInMachine, BurnInMachineParam{
+				SystemID:   "abc123",
+				PowerParam: power.PowerParam{DriverType: "ipmi"},
+				Tests:      tests,
+			})
+
+			require.True(t, env.IsWorkflowCompleted())
+			require.NoError(t, env.GetWorkflowError())
+
+			var result BurnInMachineResult
+			require.NoError(t, env.GetWorkflowResult(&result))
+
+			assert.Equal(t, tc.passed, result.Passed)
+			assert.Equal(t, tc.timedOut, result.TimedOut)
+			require.NotNil(t, reported)
+			assert.Equal(t, result, *reported)
+
+			env.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBurnInMachineInvalid(t *testing.T) {
+	suite := testsuite.WorkflowTestSuite{}
+	suite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	env.ExecuteWorkflow(NewService("agent").burnInMachine, BurnInMachineParam{SystemID: "abc123"})
+
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), ErrInvalidTest.Error())
+}
@@ -182,6 +182,21 @@ func (c *FileCache) Get(key string) (io.ReadSeekCloser, error) {
 	return c.get(key)
 }
 
+// Size returns the current cache size in bytes.
+func (c *FileCache) Size() int64 {
+	return c.size.Load()
+}
+
+// MaxSize returns the maximum cache size in bytes.
+func (c *FileCache) MaxSize() int64 {
+	return c.maxSize
+}
+
+// Len returns the number of cached items.
+func (c *FileCache) Len() int {
+	return c.index.Len()
+}
+
 func (c *FileCache) set(key string, value io.Reader, valueSize int64) (err error) {
 	// Because of the cleanup logic that happens in defer func() we have to use
 	// named return variable here, so we can return error happened during cleanup.
@@ -0,0 +1,277 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package calibration benchmarks the environment the Agent runs in, so
+// default timeouts and concurrency fit both fast lab networks and slow
+// out-of-band VPNs. Benchmarks run once, on the first start, and their
+// result is persisted, so later restarts are not delayed.
+package calibration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+)
+
+const (
+	defaultProbeCount   = 5
+	defaultProbeTimeout = 5 * time.Second
+	// defaultDiskSampleSize is big enough to get past the page cache
+	// effects once the data is synced, yet small enough to not delay start.
+	defaultDiskSampleSize = 16 * 1024 * 1024
+	diskChunkSize         = 1024 * 1024
+)
+
+var (
+	// ErrNoProbes is returned when none of BMC probes succeeded
+	ErrNoProbes = errors.New("no BMC probe succeeded")
+)
+
+// Result is the outcome of calibration.
+type Result struct {
+	Time time.Time `json:"time"`
+	// RTTMedian and RTTMax are TCP connect times to probe addresses.
+	// Both are zero when no probe address was configured or reachable.
+	RTTMedian time.Duration `json:"rtt_median"`
+	RTTMax    time.Duration `json:"rtt_max"`
+	// DiskThroughput is synchronous write throughput in bytes per second.
+	// Zero means it was not measured.
+	DiskThroughput float64 `json:"disk_throughput"`
+	Tuning         Tuning  `json:"tuning"`
+}
+
+// Calibrator measures BMC round-trip times and disk throughput.
+type Calibrator struct {
+	dial           func(ctx context.Context, network, address string) (net.Conn, error)
+	dir            string
+	addresses      []string
+	probes         int
+	probeTimeout   time.Duration
+	diskSampleSize int64
+}
+
+// CalibratorOption allows to set additional Calibrator options
+type CalibratorOption func(*Calibrator)
+
+// NewCalibrator returns a Calibrator measuring disk throughput in dir.
+func NewCalibrator(dir string, options ...CalibratorOption) *Calibrator {
+	c := &Calibrator{
+		dir:            dir,
+		probes:         defaultProbeCount,
+		probeTimeout:   defaultProbeTimeout,
+		diskSampleSize: defaultDiskSampleSize,
+	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	if c.dial == nil {
+		dialer := net.Dialer{Timeout: c.probeTimeout}
+		c.dial = dialer.DialContext
+	}
+
+	return c
+}
+
+// WithProbeAddresses sets host:port addresses used to measure round-trip
+// times, normally BMCs (e.g. IPMI over LAN is not TCP, so the Redfish or
+// SSH port of the same BMC is a good choice). Without any, BMC benchmark
+// is skipped and network related defaults are kept.
+func WithProbeAddresses(addresses []string) CalibratorOption {
+	return func(c *Calibrator) {
+		c.addresses = addresses
+	}
+}
+
+// WithProbeCount sets how many times each address is probed.
+// (default: 5)
+func WithProbeCount(n int) CalibratorOption {
+	return func(c *Calibrator) {
+		if n > 0 {
+			c.probes = n
+		}
+	}
+}
+
+// WithDiskSampleSize sets how many bytes are written to measure disk
+// throughput. Zero skips disk benchmark. (default: 16 MiB)
+func WithDiskSampleSize(n int64) CalibratorOption {
+	return func(c *Calibrator) {
+		c.diskSampleSize = n
+	}
+}
+
+// Run benchmarks BMC round-trip times and disk throughput and returns
+// tuning derived from them. Failure of one benchmark doesn't prevent
+// the other, the affected tuning keeps its default.
+func (c *Calibrator) Run(ctx context.Context) (*Result, error) {
+	res := &Result{Time: time.Now().UTC()}
+
+	var errs []error
+
+	if len(c.addresses) > 0 {
+		median, maxRTT, err := c.probeRTT(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		res.RTTMedian, res.RTTMax = median, maxRTT
+	}
+
+	if c.diskSampleSize > 0 {
+		throughput, err := c.diskThroughput()
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		res.DiskThroughput = throughput
+	}
+
+	res.Tuning = Tune(res.RTTMedian, res.RTTMax, res.DiskThroughput)
+
+	return res, errors.Join(errs...)
+}
+
+func (c *Calibrator) probeRTT(ctx context.Context) (time.Duration, time.Duration, error) {
+	var samples []time.Duration
+
+	for _, address := range c.addresses {
+		for i := 0; i < c.probes; i++ {
+			if err := ctx.Err(); err != nil {
+				return 0, 0, err
+			}
+
+			start := time.Now()
+
+			conn, err := c.dial(ctx, "tcp", address)
+			if err != nil {
+				// Unreachable addresses would only inflate results by the
+				// dial timeout, so they are left out.
+				break
+			}
+
+			samples = append(samples, time.Since(start))
+
+			//nolint:errcheck // connection was only used as a probe
+			conn.Close()
+		}
+	}
+
+	if len(samples) == 0 {
+		return 0, 0, ErrNoProbes
+	}
+
+	slices.Sort(samples)
+
+	return samples[len(samples)/2], samples[len(samples)-1], nil
+}
+
+func (c *Calibrator) diskThroughput() (throughput float64, err error) {
+	f, err := os.CreateTemp(c.dir, ".calibration-*")
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		err = errors.Join(err, os.Remove(f.Name()))
+	}()
+
+	//nolint:errcheck // file is removed anyway, write errors are returned
+	defer f.Close()
+
+	chunk := make([]byte, diskChunkSize)
+	start := time.Now()
+
+	for written := int64(0); written < c.diskSampleSize; {
+		n := min(int64(len(chunk)), c.diskSampleSize-written)
+		if _, err = f.Write(chunk[:n]); err != nil {
+			return 0, err
+		}
+
+		written += n
+	}
+
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+
+	elapsed := time.Since(start)
+	if elapsed <= 0 {
+		elapsed = time.Nanosecond
+	}
+
+	return float64(c.diskSampleSize) / elapsed.Seconds(), nil
+}
+
+// Load returns the result previously stored with Save.
+func Load(path string) (*Result, error) {
+	//nolint:gosec // path is controlled by the Agent
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var res Result
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// Save stores res at path, so calibration doesn't run on the next start.
+func Save(path string, res *Result) error {
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(path, data, 0o600)
+}
+
+// LoadOrRun returns the result stored at path. If there is none (e.g. the
+// Agent starts for the first time), calibration is run and its result is
+// stored. Delete the file to calibrate again.
+func LoadOrRun(ctx context.Context, path string, c *Calibrator) (*Result, error) {
+	res, err := Load(path)
+	if err == nil {
+		return res, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	res, err = c.Run(ctx)
+	if ctx.Err() != nil {
+		// Interrupted calibration is run again on the next start
+		return res, err
+	}
+
+	// Partial result is still stored, otherwise an unreachable probe
+	// address would delay every start of the Agent.
+	if serr := Save(path, res); serr != nil {
+		err = errors.Join(err, serr)
+	}
+
+	return res, err
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package calibration
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTune(t *testing.T) {
+	testcases := map[string]struct {
+		rttMedian      time.Duration
+		rttMax         time.Duration
+		diskThroughput float64
+		out            Tuning
+	}{
+		"nothing measured": {},
+		"lab network": {
+			rttMedian:      time.Millisecond,
+			rttMax:         2 * time.Millisecond,
+			diskThroughput: 500 * megabyte,
+			out: Tuning{
+				PowerCommandTimeout: time.Minute + 500*time.Millisecond,
+				ProbeTimeout:        time.Second,
+				VerifyConcurrency:   4,
+			},
+		},
+		"OOB VPN": {
+			rttMedian:      300 * time.Millisecond,
+			rttMax:         time.Second,
+			diskThroughput: 20 * megabyte,
+			out: Tuning{
+				PowerCommandTimeout: 3*time.Minute + 30*time.Second,
+				PowerConcurrency:    8,
+				ProbeTimeout:        10 * time.Second,
+				VerifyConcurrency:   1,
+			},
+		},
+		"limits": {
+			rttMedian:      5 * time.Second,
+			rttMax:         5 * time.Second,
+			diskThroughput: 2000 * megabyte,
+			out: Tuning{
+				PowerCommandTimeout: 10 * time.Minute,
+				PowerConcurrency:    8,
+				ProbeTimeout:        30 * time.Second,
+				VerifyConcurrency:   8,
+			},
+		},
+		"slow network": {
+			rttMedian: 50 * time.Millisecond,
+			rttMax:    50 * time.Millisecond,
+			out: Tuning{
+				PowerCommandTimeout: time.Minute + 25*time.Second,
+				PowerConcurrency:    32,
+				ProbeTimeout:        time.Second,
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, Tune(tc.rttMedian, tc.rttMax, tc.diskThroughput))
+		})
+	}
+}
+
+func TestTuningOverride(t *testing.T) {
+	t.Parallel()
+
+	tuning := Tuning{
+		PowerCommandTimeout: time.Minute,
+		PowerConcurrency:    8,
+		ProbeTimeout:        time.Second,
+		VerifyConcurrency:   2,
+	}
+
+	res := tuning.Override(Tuning{PowerConcurrency: 100, ProbeTimeout: 5 * time.Second})
+
+	assert.Equal(t, Tuning{
+		PowerCommandTimeout: time.Minute,
+		PowerConcurrency:    100,
+		ProbeTimeout:        5 * time.Second,
+		VerifyConcurrency:   2,
+	}, res)
+}
+
+func TestCalibratorRun(t *testing.T) {
+	t.Parallel()
+
+	var dialed []string
+
+	c := NewCalibrator(t.TempDir(),
+		WithProbeAddresses([]string{"10.0.0.1:443", "10.0.0.2:443"}),
+		WithProbeCount(3),
+		WithDiskSampleSize(4*diskChunkSize+1))
+
+	c.dial = func(_ context.Context, _, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+
+		if address == "10.0.0.2:443" {
+			return nil, errors.New("unreachable")
+		}
+
+		client, server := net.Pipe()
+		//nolint:errcheck // test pipe
+		server.Close()
+
+		return client, nil
+	}
+
+	res, err := c.Run(context.Background())
+	require.NoError(t, err)
+
+	// Unreachable address is only probed once
+	assert.Equal(t, []string{"10.0.0.1:443", "10.0.0.1:443", "10.0.0.1:443", "10.0.0.2:443"}, dialed)
+	assert.Greater(t, res.RTTMedian, time.Duration(0))
+	assert.GreaterOrEqual(t, res.RTTMax, res.RTTMedian)
+	assert.Greater(t, res.DiskThroughput, 0.0)
+	assert.Equal(t, Tune(res.RTTMedian, res.RTTMax, res.DiskThroughput), res.Tuning)
+
+	// Temporary file is removed
+	files, err := filepath.Glob(filepath.Join(c.dir, "*"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestCalibratorRunNoProbes(t *testing.T) {
+	t.Parallel()
+
+	c := NewCalibrator(t.TempDir(),
+		WithProbeAddresses([]string{"10.0.0.1:443"}),
+		WithDiskSampleSize(diskChunkSize))
+
+	c.dial = func(context.Context, string, string) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	}
+
+	res, err := c.Run(context.Background())
+	assert.ErrorIs(t, err, ErrNoProbes)
+
+	// Disk is still benchmarked
+	assert.Greater(t, res.DiskThroughput, 0.0)
+	assert.Zero(t, res.Tuning.PowerCommandTimeout)
+	assert.Greater(t, res.Tuning.VerifyConcurrency, 0)
+}
+
+func TestLoadOrRun(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "calibration.json")
+	c := NewCalibrator(dir, WithDiskSampleSize(diskChunkSize))
+
+	first, err := LoadOrRun(context.Background(), path, c)
+	require.NoError(t, err)
+
+	// The second start uses the stored result, even if it could be
+	// different when measured again.
+	c.diskSampleSize = 0
+
+	second, err := LoadOrRun(context.Background(), path, c)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.DiskThroughput, second.DiskThroughput)
+	assert.Equal(t, first.Tuning, second.Tuning)
+	assert.True(t, first.Time.Equal(second.Time))
+}
+
+func TestLoadOrRunInterrupted(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "calibration.json")
+	c := NewCalibrator(dir, WithProbeAddresses([]string{"10.0.0.1:443"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := LoadOrRun(ctx, path, c)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = Load(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
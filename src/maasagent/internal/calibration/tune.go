@@ -0,0 +1,119 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package calibration
+
+import (
+	"time"
+)
+
+const (
+	// powerCommandRoundTrips is a rough number of round trips a power
+	// driver makes in a single command (session setup, retries, polling).
+	powerCommandRoundTrips = 500
+	minPowerCommandTimeout = time.Minute
+	maxPowerCommandTimeout = 10 * time.Minute
+	// probeRoundTrips allows for retransmissions of a TCP handshake
+	probeRoundTrips = 10
+	minProbeTimeout = time.Second
+	maxProbeTimeout = 30 * time.Second
+	// slowRTT and verySlowRTT are typical for OOB networks reached over
+	// a VPN, where many concurrent BMC sessions saturate the tunnel and
+	// time out on retransmissions.
+	slowRTT              = 20 * time.Millisecond
+	verySlowRTT          = 100 * time.Millisecond
+	slowPowerConcurrency = 32
+	verySlowConcurrency  = 8
+
+	megabyte = 1024 * 1024
+)
+
+// Tuning is a set of defaults derived from calibration.
+// Zero values keep defaults of the respective subsystem.
+type Tuning struct {
+	// PowerCommandTimeout limits a single power driver command
+	PowerCommandTimeout time.Duration `json:"power_command_timeout" yaml:"power_command_timeout"`
+	// PowerConcurrency is the maximum number of power activities
+	// executed at once
+	PowerConcurrency int `json:"power_concurrency" yaml:"power_concurrency"`
+	// ProbeTimeout limits TCP connect probes of BMCs and hosts
+	ProbeTimeout time.Duration `json:"probe_timeout" yaml:"probe_timeout"`
+	// VerifyConcurrency is the maximum number of images verified at once
+	VerifyConcurrency int `json:"verify_concurrency" yaml:"verify_concurrency"`
+}
+
+// Tune derives Tuning from BMC round-trip times and disk throughput
+// (bytes per second). Zero measurements keep the respective defaults.
+func Tune(rttMedian, rttMax time.Duration, diskThroughput float64) Tuning {
+	var t Tuning
+
+	if rttMedian > 0 {
+		t.PowerCommandTimeout = clamp(minPowerCommandTimeout+powerCommandRoundTrips*rttMedian,
+			minPowerCommandTimeout, maxPowerCommandTimeout)
+
+		switch {
+		case rttMedian >= verySlowRTT:
+			t.PowerConcurrency = verySlowConcurrency
+		case rttMedian >= slowRTT:
+			t.PowerConcurrency = slowPowerConcurrency
+		}
+	}
+
+	if rttMax > 0 {
+		t.ProbeTimeout = clamp(probeRoundTrips*rttMax, minProbeTimeout, maxProbeTimeout)
+	}
+
+	// Checksum verification is bound by disk throughput, so parallel
+	// reads only help on fast disks and make it worse on slow ones.
+	switch {
+	case diskThroughput <= 0:
+	case diskThroughput < 50*megabyte:
+		t.VerifyConcurrency = 1
+	case diskThroughput < 200*megabyte:
+		t.VerifyConcurrency = 2
+	case diskThroughput < 1000*megabyte:
+		t.VerifyConcurrency = 4
+	default:
+		t.VerifyConcurrency = 8
+	}
+
+	return t
+}
+
+// Override returns t with fields set in o (non-zero) replacing calibrated
+// values, so operators can pin any of them in the configuration.
+func (t Tuning) Override(o Tuning) Tuning {
+	if o.PowerCommandTimeout != 0 {
+		t.PowerCommandTimeout = o.PowerCommandTimeout
+	}
+
+	if o.PowerConcurrency != 0 {
+		t.PowerConcurrency = o.PowerConcurrency
+	}
+
+	if o.ProbeTimeout != 0 {
+		t.ProbeTimeout = o.ProbeTimeout
+	}
+
+	if o.VerifyConcurrency != 0 {
+		t.VerifyConcurrency = o.VerifyConcurrency
+	}
+
+	return t
+}
+
+func clamp(d, lo, hi time.Duration) time.Duration {
+	return min(max(d, lo), hi)
+}
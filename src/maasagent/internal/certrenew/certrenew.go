@@ -0,0 +1,338 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package certrenew renews the client certificate used by the Agent for mTLS
+// with the Region before it expires.
+package certrenew
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/atomicfile"
+	"maas.io/core/src/maasagent/internal/workflow/schedule"
+)
+
+const (
+	// defaultRenewBefore is how long before expiry the certificate is renewed
+	defaultRenewBefore = 30 * 24 * time.Hour
+	// checkInterval is how often expiry of the certificate is checked
+	checkInterval = 24 * time.Hour
+	// checkJitter spreads checks of Agents over time
+	checkJitter = time.Hour
+
+	pendingKeyExt = ".pending"
+)
+
+var (
+	// ErrInvalidCertificate is returned when the certificate issued by the
+	// Region can't be used with the pending private key
+	ErrInvalidCertificate = errors.New("invalid agent certificate")
+	// ErrNoPendingKey is returned when a certificate is installed without
+	// a prior certificate request
+	ErrNoPendingKey = errors.New("no pending certificate request")
+)
+
+// Renewer holds the client certificate of the Agent and replaces it with
+// a certificate issued by the Region when it is about to expire. Connections
+// made after the renewal use the new certificate without an Agent restart.
+type Renewer struct {
+	cert        atomic.Pointer[tls.Certificate]
+	now         func() time.Time
+	systemID    string
+	certFile    string
+	keyFile     string
+	renewBefore time.Duration
+}
+
+// RenewerOption allows to set additional Renewer options
+type RenewerOption func(*Renewer)
+
+// NewRenewer returns Renewer of cert, which is stored in certFile and keyFile.
+func NewRenewer(systemID, certFile, keyFile string, cert tls.Certificate,
+	options ...RenewerOption) *Renewer {
+	r := &Renewer{
+		now:         time.Now,
+		systemID:    systemID,
+		certFile:    certFile,
+		keyFile:     keyFile,
+		renewBefore: defaultRenewBefore,
+	}
+
+	r.cert.Store(&cert)
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+// WithRenewBefore sets how long before expiry the certificate is renewed.
+// Zero disables renewals. (default: 30 days)
+func WithRenewBefore(d time.Duration) RenewerOption {
+	return func(r *Renewer) {
+		r.renewBefore = max(d, 0)
+	}
+}
+
+// GetClientCertificate returns the current certificate. It is meant to be
+// used as tls.Config.GetClientCertificate.
+func (r *Renewer) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// RenewAgentCertificateParam is the parameter of renew-agent-certificate
+// workflow
+type RenewAgentCertificateParam struct {
+	SystemID string `json:"system_id"`
+}
+
+// RenewAgentCertificateResult is the result of renew-agent-certificate
+// workflow
+type RenewAgentCertificateResult struct {
+	NotAfter time.Time `json:"not_after"`
+	Renewed  bool      `json:"renewed"`
+}
+
+// CheckAgentCertificateResult is the result of check-agent-certificate
+// activity
+type CheckAgentCertificateResult struct {
+	NotAfter time.Time `json:"not_after"`
+	// Due is true when the certificate should be renewed
+	Due bool `json:"due"`
+}
+
+// CertificateRequest is the result of create-agent-certificate-request
+// activity
+type CertificateRequest struct {
+	// CSR is a PEM encoded certificate signing request
+	CSR string `json:"csr"`
+}
+
+type issueAgentCertificateParam struct {
+	SystemID string `json:"system_id"`
+	CSR      string `json:"csr"`
+}
+
+// InstallAgentCertificateParam is the parameter of install-agent-certificate
+// activity (and the result of issue-agent-certificate Region activity)
+type InstallAgentCertificateParam struct {
+	// Certificate is a PEM encoded certificate chain, leaf first
+	Certificate string `json:"certificate"`
+}
+
+func (r *Renewer) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{
+		"renew-agent-certificate": r.renewAgentCertificate,
+	}
+}
+
+func (r *Renewer) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"check-agent-certificate":          r.checkAgentCertificate,
+		"create-agent-certificate-request": r.createAgentCertificateRequest,
+		"install-agent-certificate":        r.installAgentCertificate,
+	}
+}
+
+// Schedules implements schedule.Provider
+func (r *Renewer) Schedules() []schedule.Schedule {
+	if r.renewBefore == 0 {
+		return nil
+	}
+
+	return []schedule.Schedule{
+		{
+			ID:               "renew-agent-certificate",
+			Workflow:         "renew-agent-certificate",
+			Args:             []any{RenewAgentCertificateParam{SystemID: r.systemID}},
+			Every:            checkInterval,
+			Jitter:           checkJitter,
+			ExecutionTimeout: 10 * time.Minute,
+		},
+	}
+}
+
+// renewAgentCertificate checks expiry of the client certificate and, when it
+// is due, asks the Region to sign a request for a new key. The private key
+// never leaves the Agent.
+func (r *Renewer) renewAgentCertificate(ctx tworkflow.Context,
+	param RenewAgentCertificateParam) (*RenewAgentCertificateResult, error) {
+	localCtx := tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+
+	var check CheckAgentCertificateResult
+
+	if err := tworkflow.ExecuteActivity(localCtx, "check-agent-certificate").
+		Get(ctx, &check); err != nil {
+		return nil, err
+	}
+
+	if !check.Due {
+		return &RenewAgentCertificateResult{NotAfter: check.NotAfter}, nil
+	}
+
+	var req CertificateRequest
+
+	if err := tworkflow.ExecuteActivity(localCtx, "create-agent-certificate-request").
+		Get(ctx, &req); err != nil {
+		return nil, err
+	}
+
+	regionCtx := tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              "region",
+		ScheduleToCloseTimeout: 60 * time.Second,
+	})
+
+	var issued InstallAgentCertificateParam
+
+	if err := tworkflow.ExecuteActivity(regionCtx, "issue-agent-certificate",
+		issueAgentCertificateParam{SystemID: param.SystemID, CSR: req.CSR}).
+		Get(ctx, &issued); err != nil {
+		return nil, err
+	}
+
+	var installed CheckAgentCertificateResult
+
+	if err := tworkflow.ExecuteActivity(localCtx, "install-agent-certificate", issued).
+		Get(ctx, &installed); err != nil {
+		return nil, err
+	}
+
+	return &RenewAgentCertificateResult{NotAfter: installed.NotAfter, Renewed: true}, nil
+}
+
+func (r *Renewer) leaf() (*x509.Certificate, error) {
+	cert := r.cert.Load()
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+
+	if len(cert.Certificate) == 0 {
+		return nil, ErrInvalidCertificate
+	}
+
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+func (r *Renewer) checkAgentCertificate(_ context.Context) (*CheckAgentCertificateResult, error) {
+	leaf, err := r.leaf()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckAgentCertificateResult{
+		NotAfter: leaf.NotAfter,
+		Due:      r.renewBefore > 0 && r.now().Add(r.renewBefore).After(leaf.NotAfter),
+	}, nil
+}
+
+// createAgentCertificateRequest generates a new private key, which is kept
+// next to the current one until the certificate is issued, and returns
+// a request with the subject of the current certificate.
+func (r *Renewer) createAgentCertificateRequest(_ context.Context) (*CertificateRequest, error) {
+	leaf, err := r.leaf()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := atomicfile.WriteFile(r.keyFile+pendingKeyExt,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     leaf.Subject,
+		DNSNames:    leaf.DNSNames,
+		IPAddresses: leaf.IPAddresses,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertificateRequest{
+		CSR: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+	}, nil
+}
+
+// installAgentCertificate stores the issued certificate with the pending
+// private key and starts using them. The key is moved into place last, with
+// a rename, which keeps the window with a mismatched pair on disk short.
+func (r *Renewer) installAgentCertificate(_ context.Context,
+	param InstallAgentCertificateParam) (*CheckAgentCertificateResult, error) {
+	pendingKey := r.keyFile + pendingKeyExt
+
+	//nolint:gosec // path is provided by the Agent
+	keyPEM, err := os.ReadFile(pendingKey)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, temporal.NewNonRetryableApplicationError(ErrNoPendingKey.Error(), "", ErrNoPendingKey)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair([]byte(param.Certificate), keyPEM)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidCertificate, err)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	cert.Leaf = leaf
+
+	if err := atomicfile.WriteFile(r.certFile, []byte(param.Certificate), 0o644); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(pendingKey, r.keyFile); err != nil {
+		return nil, err
+	}
+
+	r.cert.Store(&cert)
+
+	return &CheckAgentCertificateResult{NotAfter: leaf.NotAfter}, nil
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package certrenew
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// Region activities are implemented in Python, hence dummy activities
+// are required to match function signatures.
+func issueAgentCertificateActivity(_ context.Context,
+	_ issueAgentCertificateParam) (InstallAgentCertificateParam, error) {
+	return InstallAgentCertificateParam{}, nil
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "maas-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+// sign issues a certificate for the public key and subject of csr,
+// valid for the given duration.
+func (ca *testCA) sign(t *testing.T, csr *x509.CertificateRequest, valid time.Duration) []byte {
+	t.Helper()
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(valid),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writeCert writes a certificate valid for the given duration and its key
+// into dir, and returns the loaded key pair.
+func (ca *testCA) writeCert(t *testing.T, dir string, valid time.Duration) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := ca.sign(t, &x509.CertificateRequest{
+		Subject:   pkix.Name{CommonName: "maas"},
+		DNSNames:  []string{"maas"},
+		PublicKey: &key.PublicKey,
+	}, valid)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cluster.pem"), certPEM, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cluster.key"), keyPEM, 0o600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func TestRenewAgentCertificate(t *testing.T) {
+	testcases := map[string]struct {
+		valid   time.Duration
+		renewed bool
+	}{
+		"expiring": {
+			valid:   7 * 24 * time.Hour,
+			renewed: true,
+		},
+		"valid": {
+			valid: 90 * 24 * time.Hour,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ca := newTestCA(t)
+			dir := t.TempDir()
+			certFile, keyFile := filepath.Join(dir, "cluster.pem"), filepath.Join(dir, "cluster.key")
+
+			r := NewRenewer("agent", certFile, keyFile, ca.writeCert(t, dir, tc.valid))
+			old, err := r.GetClientCertificate(nil)
+			require.NoError(t, err)
+
+			suite := testsuite.WorkflowTestSuite{}
+			env := suite.NewTestWorkflowEnvironment()
+
+			env.RegisterWorkflow(r.renewAgentCertificate)
+			env.RegisterActivityWithOptions(issueAgentCertificateActivity,
+				activity.RegisterOptions{Name: "issue-agent-certificate"})
+
+			for name, fn := range r.ConfigurationActivities() {
+				env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+			}
+
+			env.OnActivity("issue-agent-certificate", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, p issueAgentCertificateParam) (InstallAgentCertificateParam, error) {
+					assert.Equal(t, "agent", p.SystemID)
+
+					block, _ := pem.Decode([]byte(p.CSR))
+					require.NotNil(t, block)
+
+					csr, err := x509.ParseCertificateRequest(block.Bytes)
+					require.NoError(t, err)
+					require.NoError(t, csr.CheckSignature())
+					assert.Equal(t, "maas", csr.Subject.CommonName)
+
+					return InstallAgentCertificateParam{
+						Certificate: string(ca.sign(t, csr, 365*24*time.Hour)),
+					}, nil
+				})
+
+			env.ExecuteWorkflow(r.renewAgentCertificate, RenewAgentCertificateParam{SystemID: "agent"})
+
+			require.True(t, env.IsWorkflowCompleted())
+			require.NoError(t, env.GetWorkflowError())
+
+			var result RenewAgentCertificateResult
+			require.NoError(t, env.GetWorkflowResult(&result))
+			assert.Equal(t, tc.renewed, result.Renewed)
+
+			current, err := r.GetClientCertificate(nil)
+			require.NoError(t, err)
+
+			if !tc.renewed {
+				assert.Same(t, old, current)
+				return
+			}
+
+			assert.NotEqual(t, old.Certificate, current.Certificate)
+			assert.True(t, result.NotAfter.After(time.Now().Add(300*24*time.Hour)))
+
+			// The renewed certificate is used after the Agent restarts
+			loaded, err := tls.LoadX509KeyPair(certFile, keyFile)
+			require.NoError(t, err)
+			assert.Equal(t, current.Certificate, loaded.Certificate)
+
+			_, err = os.Stat(keyFile + pendingKeyExt)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+func TestInstallAgentCertificateMismatch(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cluster.pem"), filepath.Join(dir, "cluster.key")
+	cert := ca.writeCert(t, dir, time.Hour)
+
+	r := NewRenewer("agent", certFile, keyFile, cert)
+
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(r.createAgentCertificateRequest)
+	env.RegisterActivity(r.installAgentCertificate)
+
+	certPEM, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+
+	_, err = env.ExecuteActivity(r.installAgentCertificate,
+		InstallAgentCertificateParam{Certificate: string(certPEM)})
+	assert.ErrorContains(t, err, ErrNoPendingKey.Error())
+
+	_, err = env.ExecuteActivity(r.createAgentCertificateRequest)
+	require.NoError(t, err)
+
+	// Certificate of the current key doesn't match the pending key
+	_, err = env.ExecuteActivity(r.installAgentCertificate,
+		InstallAgentCertificateParam{Certificate: string(certPEM)})
+	assert.ErrorContains(t, err, ErrInvalidCertificate.Error())
+
+	current, err := r.GetClientCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, cert.Certificate, current.Certificate)
+}
+
+func TestRenewerSchedules(t *testing.T) {
+	r := NewRenewer("agent", "", "", tls.Certificate{})
+
+	schedules := r.Schedules()
+	require.Len(t, schedules, 1)
+	assert.Equal(t, "renew-agent-certificate", schedules[0].Workflow)
+	assert.Equal(t, []any{RenewAgentCertificateParam{SystemID: "agent"}}, schedules[0].Args)
+
+	r = NewRenewer("agent", "", "", tls.Certificate{}, WithRenewBefore(-1))
+	assert.Empty(t, r.Schedules())
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/adminauth"
+)
+
+type decisionList []adminauth.Decision
+
+func (l decisionList) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "TIME\tUSER\tREQUEST\tLEVEL\tDECISION")
+
+	for _, d := range l {
+		user := d.User
+		if user == "" {
+			user = "-"
+		}
+
+		decision := "allowed"
+		if !d.Allowed {
+			decision = "denied: " + d.Reason
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.Time.Format(time.RFC3339),
+			user, d.Request, d.Level, decision)
+	}
+
+	return tw.Flush()
+}
+
+func authDecisions(ctx context.Context, c *Client, _ url.Values, args []string) (Result, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	res := decisionList{}
+
+	if err := c.Get(ctx, adminauth.DecisionsPath, nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,381 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package cli implements debug commands of the Agent, which query the
+// local API of the running Agent. Every command supports --format json,
+// printing results with stable schemas, and exits with a code determined
+// by the class of the error, so operators can script against them.
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/adminauth"
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Schema is the version of JSON output. Fields are only added within
+// the same version.
+const Schema = "maas.agent.cli.v1"
+
+const (
+	defaultRefreshInterval = 2 * time.Second
+	// clearScreen moves the cursor home and clears the terminal
+	clearScreen = "\x1b[H\x1b[2J"
+)
+
+// Exit codes
+const (
+	ExitOK = 0
+	// ExitError is returned for unexpected errors
+	ExitError = 1
+	// ExitUsage is returned for invalid command line
+	ExitUsage = 2
+	// ExitUnavailable is returned when the Agent is not running or its
+	// socket is not accessible
+	ExitUnavailable = 3
+	// ExitNotFound is returned when the requested object doesn't exist
+	ExitNotFound = 4
+	// ExitInvalidRequest is returned when the Agent rejects the request
+	ExitInvalidRequest = 5
+	// ExitAgentError is returned when the Agent fails to handle the request
+	ExitAgentError = 6
+	// ExitUnauthorized is returned when the Agent doesn't accept credentials
+	// of the client, or there are none
+	ExitUnauthorized = 7
+)
+
+var (
+	// ErrUsage is returned for invalid command line
+	ErrUsage = errors.New("invalid usage")
+	// ErrUnavailable is returned when the Agent cannot be reached
+	ErrUnavailable = errors.New("agent is not available")
+	// ErrNotFound is returned when the requested object doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidRequest is returned when the Agent rejects the request
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrAgent is returned when the Agent fails to handle the request
+	ErrAgent = errors.New("agent error")
+	// ErrUnauthorized is returned when the Agent rejects credentials
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// taxonomy maps errors to codes of JSON output and exit codes
+var taxonomy = []struct {
+	err  error
+	code string
+	exit int
+}{
+	{err: ErrUsage, code: "usage", exit: ExitUsage},
+	{err: ErrUnavailable, code: "unavailable", exit: ExitUnavailable},
+	{err: ErrNotFound, code: "not-found", exit: ExitNotFound},
+	{err: ErrInvalidRequest, code: "invalid-request", exit: ExitInvalidRequest},
+	{err: ErrAgent, code: "agent-error", exit: ExitAgentError},
+	{err: ErrUnauthorized, code: "unauthorized", exit: ExitUnauthorized},
+}
+
+func classify(err error) (string, int) {
+	for _, t := range taxonomy {
+		if errors.Is(err, t.err) {
+			return t.code, t.exit
+		}
+	}
+
+	return "error", ExitError
+}
+
+// Result of a command. JSON encoding of the result is its stable schema,
+// Text is the human readable form.
+type Result interface {
+	Text(w io.Writer) error
+}
+
+// Command is a debug command
+type Command struct {
+	// Flags registers flags of the command, which set query parameters
+	// of the request
+	Flags func(fs *flag.FlagSet, query url.Values)
+	Run   func(ctx context.Context, c *Client, query url.Values, args []string) (Result, error)
+	Name  string
+	// Args describes positional arguments, e.g. "<id>"
+	Args    string
+	Summary string
+	// Refresh makes text output refreshed every --interval until
+	// interrupted, unless --once is given
+	Refresh bool
+}
+
+// Commands are available debug commands. They are set in init, as help
+// refers to them.
+var Commands []Command
+
+func lookup(name string) (Command, bool) {
+	for _, c := range Commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return Command{}, false
+}
+
+type envelope struct {
+	Result  any            `json:"result,omitempty"`
+	Error   *envelopeError `json:"error,omitempty"`
+	Schema  string         `json:"schema"`
+	Command string         `json:"command"`
+}
+
+type envelopeError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Main executes the command line args (command name first) against
+// the Agent listening on socketPath and returns the exit code.
+// In JSON mode both results and errors are printed to stdout.
+func Main(args []string, socketPath string, stdout, stderr io.Writer) int {
+	name := "help"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	format := FormatText
+
+	cmd, ok := lookup(name)
+	if !ok {
+		return report(stdout, stderr, format, name,
+			fmt.Errorf("%w: unknown command %q", ErrUsage, name))
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&format, "format", FormatText, "output format (text or json)")
+	fs.StringVar(&socketPath, "socket", socketPath, "path of the Agent socket")
+
+	var creds credentials
+
+	fs.StringVar(&creds.token, "token", os.Getenv(adminauth.TokenEnv), "token authenticating to the Agent")
+	fs.StringVar(&creds.cert, "cert", "", "client certificate for the mTLS socket")
+	fs.StringVar(&creds.key, "key", "", "key of the client certificate")
+	fs.StringVar(&creds.ca, "ca", "", "CA bundle verifying the Agent certificate")
+
+	interval, once := defaultRefreshInterval, false
+
+	if cmd.Refresh {
+		fs.DurationVar(&interval, "interval", defaultRefreshInterval, "refresh interval")
+		fs.BoolVar(&once, "once", false, "print once instead of refreshing")
+	}
+
+	query := url.Values{}
+
+	if cmd.Flags != nil {
+		cmd.Flags(fs, query)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if format != FormatJSON {
+			format = FormatText
+		}
+
+		return report(stdout, stderr, format, name, fmt.Errorf("%w: %v", ErrUsage, err))
+	}
+
+	if format != FormatText && format != FormatJSON {
+		return report(stdout, stderr, FormatText, name,
+			fmt.Errorf("%w: unknown format %q", ErrUsage, format))
+	}
+
+	if interval <= 0 {
+		return report(stdout, stderr, format, name,
+			fmt.Errorf("%w: interval must be positive", ErrUsage))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Clients with certificates talk to the mTLS socket next to the plain one
+	if creds.cert != "" && !isFlagSet(fs, "socket") {
+		socketPath = filepath.Join(filepath.Dir(socketPath), adminauth.TLSSocketName)
+	}
+
+	options, err := creds.clientOptions()
+	if err != nil {
+		return report(stdout, stderr, format, name, err)
+	}
+
+	client := NewClient(socketPath, options...)
+
+	if cmd.Refresh && format == FormatText && !once {
+		return refresh(ctx, cmd, client, query, fs.Args(), interval, stdout, stderr)
+	}
+
+	res, err := cmd.Run(ctx, client, query, fs.Args())
+	if err != nil {
+		return report(stdout, stderr, format, name, err)
+	}
+
+	if format == FormatJSON {
+		err = json.NewEncoder(stdout).Encode(envelope{Schema: Schema, Command: name, Result: res})
+	} else {
+		err = res.Text(stdout)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+
+	return ExitOK
+}
+
+// credentials of the client given on the command line
+type credentials struct {
+	token string
+	cert  string
+	key   string
+	ca    string
+}
+
+func (c credentials) clientOptions() ([]ClientOption, error) {
+	var options []ClientOption
+
+	if c.token != "" {
+		options = append(options, WithToken(c.token))
+	}
+
+	if c.cert == "" && c.key == "" {
+		return options, nil
+	}
+
+	if c.cert == "" || c.key == "" {
+		return nil, fmt.Errorf("%w: --cert and --key are required together", ErrUsage)
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.cert, c.key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+
+	//nolint:gosec // the socket is trusted by its permissions, unless CA is given
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: c.ca == "",
+	}
+
+	if c.ca != "" {
+		pem, err := os.ReadFile(filepath.Clean(c.ca))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUsage, err)
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates in %s", ErrUsage, c.ca)
+		}
+	}
+
+	return append(options, WithTLS(cfg)), nil
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+func report(stdout, stderr io.Writer, format, name string, err error) int {
+	code, exit := classify(err)
+
+	if format == FormatJSON {
+		//nolint:errcheck // nothing can be done if stdout is closed
+		json.NewEncoder(stdout).Encode(envelope{
+			Schema:  Schema,
+			Command: name,
+			Error:   &envelopeError{Code: code, Message: err.Error()},
+		})
+	} else {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+	}
+
+	return exit
+}
+
+// refresh redraws text output of cmd every interval until ctx is cancelled.
+// Errors other than usage errors are displayed instead of the output,
+// as the Agent may be restarted while it is being watched.
+func refresh(ctx context.Context, cmd Command, c *Client, query url.Values, args []string,
+	interval time.Duration, stdout, stderr io.Writer) int {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var buf bytes.Buffer
+
+		buf.WriteString(clearScreen)
+
+		res, err := cmd.Run(ctx, c, query, args)
+
+		switch {
+		case ctx.Err() != nil:
+			return ExitOK
+		case errors.Is(err, ErrUsage):
+			return report(stdout, stderr, FormatText, cmd.Name, err)
+		case err != nil:
+			fmt.Fprintf(&buf, "Error: %s\n", err)
+		default:
+			if err := res.Text(&buf); err != nil {
+				fmt.Fprintln(stderr, err)
+				return ExitError
+			}
+		}
+
+		if _, err := stdout.Write(buf.Bytes()); err != nil {
+			return ExitError
+		}
+
+		select {
+		case <-ctx.Done():
+			return ExitOK
+		case <-ticker.C:
+		}
+	}
+}
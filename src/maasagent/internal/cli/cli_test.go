@@ -0,0 +1,480 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/loadtest"
+)
+
+// fakeAgent serves the local API on a socket and returns its path
+func fakeAgent(t *testing.T) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latencies", func(w http.ResponseWriter, _ *http.Request) {
+		//nolint:errcheck // test response
+		w.Write([]byte(`[{"operation":"power-on","p95":2000000000,"p99":3000000000,"samples":10},
+			{"operation":"power-cycle","p95":1000000000,"p99":1000000000,"samples":1}]`))
+	})
+	mux.HandleFunc("/circuits", func(w http.ResponseWriter, _ *http.Request) {
+		//nolint:errcheck // test response
+		w.Write([]byte(`[{"until":"2024-01-02T03:04:05Z","rule":"bmc-failures","source":"power-on",
+			"group":{"driver_opts.power_address":"10.0.0.5"}}]`))
+	})
+	mux.HandleFunc("/activity", func(w http.ResponseWriter, _ *http.Request) {
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"time":"2024-01-02T03:05:00Z","window":900,
+			"running":[{"started_at":"2024-01-02T03:04:00Z","kind":"workflow","name":"deploy",
+				"workflow_id":"deploy:1","state":"running"}],
+			"recent":[{"started_at":"2024-01-02T03:04:50Z","finished_at":"2024-01-02T03:04:55Z",
+				"kind":"activity","name":"power-on","workflow_id":"power-on:1","driver":"ipmi",
+				"state":"failed","error":"BMC unreachable"}],
+			"drivers":[{"driver":"ipmi","total":4,"failed":1,"error_rate":0.25}]}`))
+	})
+	mux.HandleFunc("/disk-usage", func(w http.ResponseWriter, _ *http.Request) {
+		//nolint:errcheck // test response
+		w.Write([]byte(`[{"subsystem":"pcap","used":2048,"quota":1024,"items":2}]`))
+	})
+	mux.HandleFunc("/workflows/noop:1/history", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "run-1", r.URL.Query().Get("run_id"))
+
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"events":[{"eventId":"1"},{"eventId":"2"}]}`))
+	})
+	mux.HandleFunc("/workflows/replay", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if !json.Valid(body) {
+			http.Error(w, "invalid history", http.StatusBadRequest)
+			return
+		}
+
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"workflow_type":"noop","events":2}`))
+	})
+	mux.HandleFunc("/load-test", func(w http.ResponseWriter, r *http.Request) {
+		var cfg loadtest.Config
+
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&cfg))
+		assert.Equal(t, loadtest.Config{Action: "power-on", Count: 5, Rate: 2.5}, cfg)
+
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"action":"power-on","started":5,"succeeded":3,"failed":2,
+			"duration":2000000000,"throughput":2.5,
+			"latency":{"p50":100000000,"p95":200000000,"p99":300000000,"max":400000000},
+			"errors":{"simulated BMC failure":1,"context deadline exceeded":1}}`))
+	})
+	mux.HandleFunc("/operations/op-1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"id":"op-1","kind":"ipmi-bridge","state":"succeeded",
+			"started_at":"2024-01-02T03:04:05Z"}`))
+	})
+	mux.HandleFunc("/auth/decisions", func(w http.ResponseWriter, _ *http.Request) {
+		//nolint:errcheck // test response
+		w.Write([]byte(`[{"time":"2024-01-02T03:04:06Z","user":"junior","method":"peer",
+			"request":"POST /ipmi-bridge","level":"dangerous","allowed":false,
+			"reason":"not allowed: \"junior\" is granted query operations, not dangerous"},
+			{"time":"2024-01-02T03:04:05Z","user":"alice","method":"token",
+			"request":"POST /ipmi-bridge","level":"dangerous","allowed":true}]`))
+	})
+	mux.HandleFunc("/operations/broken", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "operation store failure", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/subnet-services", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ip") != "10.0.0.1" {
+			http.Error(w, "invalid IP", http.StatusBadRequest)
+			return
+		}
+
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"images":["ubuntu/jammy"],"proxies":[],"dns_servers":["10.0.0.2"]}`))
+	})
+	mux.HandleFunc("/dhcp/leases", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "10.0.0.0/24", r.URL.Query().Get("subnet"))
+		assert.Equal(t, "active", r.URL.Query().Get("state"))
+
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"leases":[{"ip":"10.0.0.9","mac":"00:16:3e:00:00:01","state":"active",
+			"starts":"2024-01-02T03:04:05Z","ends":"2024-01-02T04:04:05Z"}],"truncated":false}`))
+	})
+
+	mux.HandleFunc("/bootloaders", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "arm64", r.URL.Query().Get("arch"))
+
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"host_arch":"ppc64le","archs":[{"arch":"arm64","available":false,"bootloaders":[
+			{"arch":"arm64","firmware":"uefi","file":"bootaa64.efi","available":true},
+			{"arch":"arm64","firmware":"uefi","file":"grubaa64.efi","available":false,
+				"error":"boot-resources/bootloaders/uefi/arm64/grubaa64.efi: 404 Not Found"}]}]}`))
+	})
+	mux.HandleFunc("/hooks/pre-stop", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+
+		if r.URL.Query().Get("timeout") == "1ms" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			//nolint:errcheck // test response
+			w.Write([]byte(`{"state":"draining","in_flight":2}`))
+
+			return
+		}
+
+		//nolint:errcheck // test response
+		w.Write([]byte(`{"state":"drained","in_flight":0,"errors":{"events":"unreachable"}}`))
+	})
+
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: time.Second}
+
+	//nolint:errcheck // server is closed by the test
+	go srv.Serve(l)
+
+	t.Cleanup(func() {
+		//nolint:errcheck // test server
+		srv.Close()
+	})
+
+	return socketPath
+}
+
+func TestMain(t *testing.T) {
+	socketPath := fakeAgent(t)
+
+	testcases := map[string]struct {
+		args   []string
+		socket string
+		exit   int
+		stdout string
+		stderr string
+	}{
+		"latencies text": {
+			args: []string{"latencies"},
+			stdout: "OPERATION    P95  P99  SAMPLES\n" +
+				"power-cycle  1s   1s   1\n" +
+				"power-on     2s   3s   10\n",
+		},
+		"latencies json": {
+			args: []string{"latencies", "--format", "json"},
+			stdout: `{"schema":"maas.agent.cli.v1","command":"latencies","result":[
+				{"operation":"power-cycle","p95":1000000000,"p99":1000000000,"samples":1},
+				{"operation":"power-on","p95":2000000000,"p99":3000000000,"samples":10}]}`,
+		},
+		"circuits text": {
+			args: []string{"circuits"},
+			stdout: "SOURCE    RULE          UNTIL                 GROUP\n" +
+				"power-on  bmc-failures  2024-01-02T03:04:05Z  driver_opts.power_address=10.0.0.5\n",
+		},
+		"subnet services": {
+			args:   []string{"subnet-services", "--format=json", "10.0.0.1"},
+			stdout: `{"schema":"maas.agent.cli.v1","command":"subnet-services","result":{"images":["ubuntu/jammy"],"proxies":[],"dns_servers":["10.0.0.2"]}}`,
+		},
+		"dhcp leases": {
+			args: []string{"dhcp-leases", "--subnet", "10.0.0.0/24", "--state", "active"},
+			stdout: "IP        MAC                STATE   HOSTNAME  ENDS\n" +
+				"10.0.0.9  00:16:3e:00:00:01  active            2024-01-02T04:04:05Z\n",
+		},
+		"help": {
+			args: []string{},
+		},
+		"invalid request": {
+			args:   []string{"subnet-services", "--format", "json", "300.0.0.1"},
+			exit:   ExitInvalidRequest,
+			stdout: `{"schema":"maas.agent.cli.v1","command":"subnet-services","error":{"code":"invalid-request","message":"invalid request: invalid IP"}}`,
+		},
+		"not found": {
+			args:   []string{"operation", "unknown"},
+			exit:   ExitNotFound,
+			stderr: "Error: not found: 404 page not found\n",
+		},
+		"agent error": {
+			args:   []string{"operation", "broken"},
+			exit:   ExitAgentError,
+			stderr: "Error: agent error: operation store failure\n",
+		},
+		"token": {
+			args: []string{"operation", "--token", "s3cret", "op-1"},
+			stdout: "ID:       op-1\n" +
+				"Kind:     ipmi-bridge\n" +
+				"State:    succeeded\n" +
+				"Started:  2024-01-02T03:04:05Z\n",
+		},
+		"unauthorized": {
+			args:   []string{"operation", "--format", "json", "op-1"},
+			exit:   ExitUnauthorized,
+			stdout: `{"schema":"maas.agent.cli.v1","command":"operation","error":{"code":"unauthorized","message":"unauthorized: invalid credentials"}}`,
+		},
+		"certificate without key": {
+			args: []string{"circuits", "--cert", "client.pem"},
+			exit: ExitUsage,
+		},
+		"auth decisions": {
+			args: []string{"auth-decisions"},
+			stdout: "TIME                  USER    REQUEST            LEVEL      DECISION\n" +
+				"2024-01-02T03:04:06Z  junior  POST /ipmi-bridge  dangerous  " +
+				"denied: not allowed: \"junior\" is granted query operations, not dangerous\n" +
+				"2024-01-02T03:04:05Z  alice   POST /ipmi-bridge  dangerous  allowed\n",
+		},
+		"disk usage": {
+			args: []string{"disk-usage"},
+			stdout: "SUBSYSTEM  USED  QUOTA  ITEMS\n" +
+				"pcap       2048  1024   2\n",
+		},
+		"top once": {
+			args: []string{"top", "--once"},
+			stdout: "maas-agent top - 2024-01-02T03:05:00Z  running: 1  recently failed: 1  open circuits: 1\n" +
+				"\n" +
+				"KIND      NAME      WORKFLOW    DRIVER  STATE    TIME  ERROR\n" +
+				"workflow  deploy    deploy:1    -       running  1m0s  -\n" +
+				"activity  power-on  power-on:1  ipmi    failed   5s    BMC unreachable\n" +
+				"\n" +
+				"DRIVER  TOTAL  FAILED  ERROR RATE (15m0s)\n" +
+				"ipmi    4      1       25.0%\n" +
+				"\n" +
+				"SUBSYSTEM  STATUS          FREE  DETAIL\n" +
+				"power-on   circuit open    -     bmc-failures until 2024-01-02T03:04:05Z\n" +
+				"pcap       quota exceeded  -     2048 of 1024 bytes used\n",
+		},
+		"load test": {
+			args: []string{"load-test", "--count", "5", "--rate", "2.5", "--action", "power-on"},
+			stdout: "Action:      power-on\n" +
+				"Started:     5\n" +
+				"Succeeded:   3\n" +
+				"Failed:      2\n" +
+				"Duration:    2s\n" +
+				"Throughput:  2.50/s\n" +
+				"Latency:     p50 100ms, p95 200ms, p99 300ms, max 400ms\n" +
+				"Error:       context deadline exceeded (1)\n" +
+				"Error:       simulated BMC failure (1)\n",
+		},
+		"bootloaders": {
+			args: []string{"bootloaders", "--arch", "arm64"},
+			stdout: "Rack architecture: ppc64le\n" +
+				"\n" +
+				"ARCH   FIRMWARE  FILE          STATUS\n" +
+				"arm64  uefi      bootaa64.efi  available\n" +
+				"arm64  uefi      grubaa64.efi  boot-resources/bootloaders/uefi/arm64/grubaa64.efi: 404 Not Found\n",
+		},
+		"pre-stop": {
+			args: []string{"pre-stop", "--timeout", "5m"},
+			stdout: "State:      drained\n" +
+				"In flight:  0\n" +
+				"Error:      events: unreachable\n",
+		},
+		"pre-stop timeout": {
+			args:   []string{"pre-stop", "--timeout", "1ms"},
+			exit:   ExitAgentError,
+			stderr: "Error: agent error: {\"state\":\"draining\",\"in_flight\":2}\n",
+		},
+		"load test invalid count": {
+			args: []string{"load-test", "--count", "many"},
+			exit: ExitUsage,
+		},
+		"top invalid interval": {
+			args: []string{"top", "--interval", "0s"},
+			exit: ExitUsage,
+		},
+		"unavailable": {
+			args:   []string{"circuits", "--format", "json"},
+			socket: "/nonexistent/agent.sock",
+			exit:   ExitUnavailable,
+		},
+		"unknown command": {
+			args:   []string{"reboot"},
+			exit:   ExitUsage,
+			stderr: "Error: invalid usage: unknown command \"reboot\"\n",
+		},
+		"missing argument": {
+			args:   []string{"operation", "--format", "json"},
+			exit:   ExitUsage,
+			stdout: `{"schema":"maas.agent.cli.v1","command":"operation","error":{"code":"usage","message":"invalid usage: operation ID is required"}}`,
+		},
+		"unknown flag": {
+			args: []string{"circuits", "--verbose"},
+			exit: ExitUsage,
+		},
+		"unknown format": {
+			args: []string{"circuits", "--format", "yaml"},
+			exit: ExitUsage,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			socket := socketPath
+			if tc.socket != "" {
+				socket = tc.socket
+			}
+
+			var stdout, stderr bytes.Buffer
+
+			exit := Main(tc.args, socket, &stdout, &stderr)
+			assert.Equal(t, tc.exit, exit, stderr.String())
+
+			switch {
+			case json.Valid(stdout.Bytes()) && tc.stdout != "":
+				assert.JSONEq(t, tc.stdout, stdout.String())
+			case tc.stdout != "":
+				assert.Equal(t, tc.stdout, stdout.String())
+			}
+
+			if tc.stderr != "" {
+				assert.Equal(t, tc.stderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestMainJSONError(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+
+	exit := Main([]string{"circuits", "--format", "json"}, "/nonexistent/agent.sock", &stdout, &stderr)
+	require.Equal(t, ExitUnavailable, exit)
+	assert.Empty(t, stderr.String())
+
+	var res struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+		Schema string `json:"schema"`
+	}
+
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &res))
+	assert.Equal(t, Schema, res.Schema)
+	assert.Equal(t, "unavailable", res.Error.Code)
+}
+
+func TestTopJSON(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+
+	// JSON output is never refreshed
+	exit := Main([]string{"top", "--format", "json"}, fakeAgent(t), &stdout, &stderr)
+	require.Equal(t, ExitOK, exit, stderr.String())
+
+	var res struct {
+		Result TopResult `json:"result"`
+	}
+
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &res))
+	assert.Len(t, res.Result.Activity.Running, 1)
+	assert.Len(t, res.Result.Activity.Drivers, 1)
+	assert.Len(t, res.Result.Circuits, 1)
+	assert.Len(t, res.Result.Latencies, 2)
+	// Missing endpoints are skipped
+	assert.Empty(t, res.Result.Health)
+	assert.NotNil(t, res.Result.Health)
+}
+
+func TestRefresh(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	cmd := Command{
+		Name: "test",
+		Run: func(ctx context.Context, _ *Client, _ url.Values, _ []string) (Result, error) {
+			calls++
+
+			switch calls {
+			case 1:
+				return nil, ErrUnavailable
+			case 2:
+				return commandList{{Name: "test", Summary: "Refreshed"}}, nil
+			default:
+				cancel()
+				return nil, ctx.Err()
+			}
+		},
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	exit := refresh(ctx, cmd, nil, nil, nil, time.Millisecond, &stdout, &stderr)
+	assert.Equal(t, ExitOK, exit)
+	assert.Equal(t, 3, calls)
+
+	screens := strings.Split(stdout.String(), clearScreen)
+	require.Len(t, screens, 3)
+	assert.Equal(t, "Error: agent is not available\n", screens[1])
+	assert.Contains(t, screens[2], "Refreshed")
+	assert.Empty(t, stderr.String())
+}
+
+func TestWorkflowHistory(t *testing.T) {
+	t.Parallel()
+
+	socketPath := fakeAgent(t)
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	var stdout, stderr bytes.Buffer
+
+	exit := Main([]string{"workflow-history", "--run-id", "run-1", "noop:1", path},
+		socketPath, &stdout, &stderr)
+	require.Equal(t, ExitOK, exit, stderr.String())
+	assert.Equal(t, "Exported 2 events of noop:1 to "+path+"\n", stdout.String())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"events":[{"eventId":"1"},{"eventId":"2"}]}`, string(data))
+
+	stdout.Reset()
+
+	exit = Main([]string{"replay-history", "--format", "json", path}, socketPath, &stdout, &stderr)
+	require.Equal(t, ExitOK, exit, stderr.String())
+	assert.JSONEq(t, `{"schema":"maas.agent.cli.v1","command":"replay-history",`+
+		`"result":{"workflow_type":"noop","events":2}}`, stdout.String())
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	stdout.Reset()
+
+	exit = Main([]string{"replay-history", path}, socketPath, &stdout, &stderr)
+	assert.Equal(t, ExitInvalidRequest, exit)
+	assert.Equal(t, "Error: invalid request: invalid history\n", stderr.String())
+}
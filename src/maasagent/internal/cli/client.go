@@ -0,0 +1,148 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// requestTimeout limits queries. Commands posting to the Agent (e.g. load
+// tests) can run longer and are only stopped when interrupted.
+const requestTimeout = time.Minute
+
+// Client queries the local API of the Agent
+type Client struct {
+	transport *http.Transport
+	client    http.Client
+	scheme    string
+	token     string
+}
+
+// ClientOption allows to set additional Client options
+type ClientOption func(*Client)
+
+// NewClient returns Client of the Agent listening on socketPath
+func NewClient(socketPath string, options ...ClientOption) *Client {
+	c := &Client{
+		transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+		scheme: "http",
+	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	c.client = http.Client{Transport: c.transport}
+
+	return c
+}
+
+// WithToken authenticates requests with the bearer token
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithTLS makes requests over TLS, for the socket serving the local API
+// with mTLS
+func WithTLS(cfg *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.transport.TLSClientConfig = cfg
+		c.scheme = "https"
+	}
+}
+
+// Get decodes JSON response of GET path with query into out
+func (c *Client) Get(ctx context.Context, path string, query url.Values, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	return c.do(ctx, http.MethodGet, path, query, nil, out)
+}
+
+// Post sends body to path and decodes JSON response into out
+func (c *Client) Post(ctx context.Context, path string, body io.Reader, out any) error {
+	return c.do(ctx, http.MethodPost, path, nil, body, out)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values,
+	body io.Reader, out any) error {
+	u := url.URL{Scheme: c.scheme, Host: "agent", Path: path, RawQuery: query.Encode()}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return err
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return fmt.Errorf("%w: %v", ErrUnavailable, opErr.Err)
+		}
+
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		detail := strings.TrimSpace(string(msg))
+		if detail == "" {
+			detail = resp.Status
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrNotFound, detail)
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("%w: %s", ErrUnauthorized, detail)
+		case resp.StatusCode >= http.StatusInternalServerError:
+			return fmt.Errorf("%w: %s", ErrAgent, detail)
+		default:
+			return fmt.Errorf("%w: %s", ErrInvalidRequest, detail)
+		}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: invalid response: %v", ErrAgent, err)
+	}
+
+	return nil
+}
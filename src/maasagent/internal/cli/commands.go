@@ -0,0 +1,444 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/blob"
+	"maas.io/core/src/maasagent/internal/dhcp"
+	"maas.io/core/src/maasagent/internal/httpproxy"
+	"maas.io/core/src/maasagent/internal/lifecycle"
+	"maas.io/core/src/maasagent/internal/operation"
+	"maas.io/core/src/maasagent/internal/remediation"
+	"maas.io/core/src/maasagent/internal/slo"
+	"maas.io/core/src/maasagent/internal/subnetmap"
+)
+
+func init() {
+	Commands = []Command{
+		{
+			Name:    "help",
+			Summary: "List available commands",
+			Run:     help,
+		},
+		{
+			Name:    "latencies",
+			Summary: "Show rolling latency percentiles of operations",
+			Run:     latencies,
+		},
+		{
+			Name:    "circuits",
+			Summary: "Show circuits opened by remediation rules",
+			Run:     circuits,
+		},
+		{
+			Name:    "disk-usage",
+			Summary: "Show disk usage of artifacts and the image cache",
+			Run:     diskUsage,
+		},
+		{
+			Name:    "subnet-services",
+			Args:    "<ip>",
+			Summary: "Show services configured for the subnet of an IP address",
+			Run:     subnetServices,
+		},
+		{
+			Name:    "dhcp-leases",
+			Summary: "Query DHCP leases",
+			Flags:   dhcpLeasesFlags,
+			Run:     dhcpLeases,
+		},
+		{
+			Name:    "top",
+			Summary: "Show live workflow activity, driver error rates and subsystem health",
+			Refresh: true,
+			Run:     top,
+		},
+		{
+			Name:    "workflow-history",
+			Args:    "<workflow-id> [file]",
+			Summary: "Export workflow history with secrets redacted",
+			Flags:   workflowHistoryFlags,
+			Run:     workflowHistory,
+		},
+		{
+			Name:    "replay-history",
+			Args:    "<file>",
+			Summary: "Replay exported workflow history against the Agent workflows",
+			Run:     replayHistory,
+		},
+		{
+			Name:    "load-test",
+			Summary: "Run synthetic power workflows against the simulator and report throughput",
+			Flags:   loadTestFlags,
+			Run:     loadTest,
+		},
+		{
+			Name:    "operation",
+			Args:    "<id>",
+			Summary: "Show the outcome of an operation",
+			Run:     getOperation,
+		},
+		{
+			Name:    "bootloaders",
+			Summary: "Check availability of bootloaders per client architecture",
+			Flags:   bootloadersFlags,
+			Run:     bootloaders,
+		},
+		{
+			Name:    "pre-stop",
+			Summary: "Drain the Agent and flush its state before it is stopped",
+			Flags:   hookFlags,
+			Run:     hook(http.MethodPost, lifecycle.HookPreStop),
+		},
+		{
+			Name:    "resume",
+			Summary: "Accept work again after pre-stop (e.g. if a refresh is aborted)",
+			Run:     hook(http.MethodDelete, lifecycle.HookPreStop),
+		},
+		{
+			Name:    "post-start",
+			Summary: "Wait for the Agent to become healthy after it is started",
+			Flags:   hookFlags,
+			Run:     hook(http.MethodGet, lifecycle.HookPostStart),
+		},
+		{
+			Name:    "auth-decisions",
+			Summary: "Show recent authorization decisions of the local API",
+			Run:     authDecisions,
+		},
+	}
+}
+
+func newTabWriter(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
+
+// CommandInfo describes a command in the output of help
+type CommandInfo struct {
+	Name    string `json:"name"`
+	Args    string `json:"args,omitempty"`
+	Summary string `json:"summary"`
+}
+
+type commandList []CommandInfo
+
+func (l commandList) Text(w io.Writer) error {
+	fmt.Fprintln(w, "Usage: maas-agent <command> [--format text|json] [--socket path] "+
+		"[--token token | --cert file --key file [--ca file]] [flags] [args]")
+	fmt.Fprintln(w)
+
+	tw := newTabWriter(w)
+
+	for _, c := range l {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.Name, c.Args, c.Summary)
+	}
+
+	return tw.Flush()
+}
+
+func help(_ context.Context, _ *Client, _ url.Values, _ []string) (Result, error) {
+	res := make(commandList, 0, len(Commands))
+
+	for _, c := range Commands {
+		res = append(res, CommandInfo{Name: c.Name, Args: c.Args, Summary: c.Summary})
+	}
+
+	return res, nil
+}
+
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("%w: unexpected arguments %q", ErrUsage, args)
+	}
+
+	return nil
+}
+
+func oneArg(args []string, name string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%w: %s is required", ErrUsage, name)
+	}
+
+	return args[0], nil
+}
+
+func bootloadersFlags(fs *flag.FlagSet, query url.Values) {
+	fs.Func("arch", "client architecture, e.g. arm64 (default: all)", func(v string) error {
+		query.Set("arch", v)
+		return nil
+	})
+}
+
+type selfTest httpproxy.SelfTest
+
+func (r selfTest) Text(w io.Writer) error {
+	fmt.Fprintf(w, "Rack architecture: %s\n\n", r.HostArch)
+
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "ARCH\tFIRMWARE\tFILE\tSTATUS")
+
+	for _, arch := range r.Archs {
+		for _, b := range arch.Bootloaders {
+			status := "available"
+			if !b.Available {
+				status = b.Error
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", b.Arch, b.Firmware, b.File, status)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// bootloaders requests bootloaders through the HTTP proxy of the Agent
+func bootloaders(ctx context.Context, c *Client, query url.Values, args []string) (Result, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	var res selfTest
+
+	if err := c.Get(ctx, httpproxy.BootloadersPath, query, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+type latencyList []slo.Latency
+
+func (l latencyList) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "OPERATION\tP95\tP99\tSAMPLES")
+
+	for _, v := range l {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", v.Operation, v.P95, v.P99, v.Samples)
+	}
+
+	return tw.Flush()
+}
+
+func latencies(ctx context.Context, c *Client, _ url.Values, args []string) (Result, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	res := latencyList{}
+
+	if err := c.Get(ctx, "/latencies", nil, &res); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Operation < res[j].Operation })
+
+	return res, nil
+}
+
+type circuitList []remediation.Circuit
+
+func (l circuitList) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "SOURCE\tRULE\tUNTIL\tGROUP")
+
+	for _, c := range l {
+		group := make([]string, 0, len(c.Group))
+		for k, v := range c.Group {
+			group = append(group, k+"="+v)
+		}
+
+		sort.Strings(group)
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Source, c.Rule,
+			c.Until.Format(time.RFC3339), strings.Join(group, ","))
+	}
+
+	return tw.Flush()
+}
+
+func circuits(ctx context.Context, c *Client, _ url.Values, args []string) (Result, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	res := circuitList{}
+
+	if err := c.Get(ctx, "/circuits", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+type usageList []blob.Usage
+
+func (l usageList) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "SUBSYSTEM\tUSED\tQUOTA\tITEMS")
+
+	for _, u := range l {
+		quota := "unlimited"
+		if u.Quota > 0 {
+			quota = strconv.FormatInt(u.Quota, 10)
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\n", u.Subsystem, u.Used, quota, u.Items)
+	}
+
+	return tw.Flush()
+}
+
+func diskUsage(ctx context.Context, c *Client, _ url.Values, args []string) (Result, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	res := usageList{}
+
+	if err := c.Get(ctx, "/disk-usage", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+type services subnetmap.Services
+
+func (s services) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "Images:\t%s\n", strings.Join(s.Images, ", "))
+	fmt.Fprintf(tw, "Proxies:\t%s\n", strings.Join(s.Proxies, ", "))
+	fmt.Fprintf(tw, "DNS servers:\t%s\n", strings.Join(s.DNSServers, ", "))
+
+	return tw.Flush()
+}
+
+func subnetServices(ctx context.Context, c *Client, _ url.Values, args []string) (Result, error) {
+	ip, err := oneArg(args, "IP address")
+	if err != nil {
+		return nil, err
+	}
+
+	var res services
+
+	if err := c.Get(ctx, "/subnet-services", url.Values{"ip": {ip}}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func dhcpLeasesFlags(fs *flag.FlagSet, query url.Values) {
+	for _, name := range []string{"subnet", "mac", "state", "limit"} {
+		name := name
+
+		fs.Func(name, "filter leases by "+name, func(v string) error {
+			query.Set(name, v)
+			return nil
+		})
+	}
+}
+
+type leases dhcp.QueryLeasesResult
+
+func (l leases) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "IP\tMAC\tSTATE\tHOSTNAME\tENDS")
+
+	for _, lease := range l.Leases {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", lease.IP, lease.MAC, lease.State,
+			lease.Hostname, lease.Ends.Format(time.RFC3339))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if l.Truncated {
+		fmt.Fprintln(w, "(more leases matched, use --limit)")
+	}
+
+	return nil
+}
+
+func dhcpLeases(ctx context.Context, c *Client, query url.Values, args []string) (Result, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	var res leases
+
+	if err := c.Get(ctx, "/dhcp/leases", query, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+type operationResult operation.Operation
+
+func (o operationResult) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "ID:\t%s\n", o.ID)
+	fmt.Fprintf(tw, "Kind:\t%s\n", o.Kind)
+	fmt.Fprintf(tw, "State:\t%s\n", o.State)
+	fmt.Fprintf(tw, "Started:\t%s\n", o.StartedAt.Format(time.RFC3339))
+
+	if o.FinishedAt != nil {
+		fmt.Fprintf(tw, "Finished:\t%s\n", o.FinishedAt.Format(time.RFC3339))
+	}
+
+	if o.Error != "" {
+		fmt.Fprintf(tw, "Error:\t%s\n", o.Error)
+	}
+
+	if o.Response != nil {
+		fmt.Fprintf(tw, "Status:\t%d\n", o.Response.Status)
+	}
+
+	if len(o.Result) > 0 {
+		fmt.Fprintf(tw, "Result:\t%s\n", o.Result)
+	}
+
+	return tw.Flush()
+}
+
+func getOperation(ctx context.Context, c *Client, _ url.Values, args []string) (Result, error) {
+	id, err := oneArg(args, "operation ID")
+	if err != nil {
+		return nil, err
+	}
+
+	var res operationResult
+
+	if err := c.Get(ctx, operation.PathPrefix+url.PathEscape(id), nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
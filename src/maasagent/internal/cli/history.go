@@ -0,0 +1,122 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+	"maas.io/core/src/maasagent/internal/workflow/history"
+)
+
+// HistoryExport is the result of workflow-history
+type HistoryExport struct {
+	WorkflowID string `json:"workflow_id"`
+	RunID      string `json:"run_id,omitempty"`
+	Path       string `json:"path"`
+	Events     int    `json:"events"`
+}
+
+func (e HistoryExport) Text(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Exported %d events of %s to %s\n", e.Events, e.WorkflowID, e.Path)
+	return err
+}
+
+func workflowHistoryFlags(fs *flag.FlagSet, query url.Values) {
+	fs.Func("run-id", "export the given run instead of the latest one", func(v string) error {
+		query.Set("run_id", v)
+		return nil
+	})
+}
+
+// workflowHistory writes redacted history of a workflow to a file, which
+// defaults to <workflow-id>.json in the current directory
+func workflowHistory(ctx context.Context, c *Client, query url.Values, args []string) (Result, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("%w: workflow ID is required", ErrUsage)
+	}
+
+	res := HistoryExport{
+		WorkflowID: args[0],
+		RunID:      query.Get("run_id"),
+		Path:       strings.ReplaceAll(args[0], "/", "_") + ".json",
+	}
+
+	if len(args) == 2 {
+		res.Path = args[1]
+	}
+
+	var data json.RawMessage
+
+	if err := c.Get(ctx, history.PathPrefix+url.PathEscape(res.WorkflowID)+"/history",
+		query, &data); err != nil {
+		return nil, err
+	}
+
+	var events struct {
+		Events []json.RawMessage `json:"events"`
+	}
+
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("%w: invalid history: %v", ErrAgent, err)
+	}
+
+	res.Events = len(events.Events)
+
+	if err := atomicfile.WriteFile(res.Path, data, 0o600); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+type replayResult history.ReplayResult
+
+func (r replayResult) Text(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Replayed %d events of %s workflow\n", r.Events, r.WorkflowType)
+	return err
+}
+
+// replayHistory replays a history exported by workflow-history (or Temporal
+// tools) against workflows of the running Agent
+func replayHistory(ctx context.Context, c *Client, _ url.Values, args []string) (Result, error) {
+	path, err := oneArg(args, "history file")
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:gosec // path is given by the operator
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var res replayResult
+
+	if err := c.Post(ctx, history.PathPrefix+"replay", bytes.NewReader(data), &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+
+	"maas.io/core/src/maasagent/internal/lifecycle"
+)
+
+func hookFlags(fs *flag.FlagSet, query url.Values) {
+	fs.Func("timeout", "how long to wait, e.g. 5m (default: set by the Agent)", func(v string) error {
+		query.Set("timeout", v)
+		return nil
+	})
+}
+
+type hookStatus lifecycle.Status
+
+func (s hookStatus) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "State:\t%s\n", s.State)
+	fmt.Fprintf(tw, "In flight:\t%d\n", s.InFlight)
+
+	names := make([]string, 0, len(s.Errors))
+	for name := range s.Errors {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(tw, "Error:\t%s: %s\n", name, s.Errors[name])
+	}
+
+	return tw.Flush()
+}
+
+// hook returns a command calling the lifecycle hook of the Agent. Hooks are
+// called by packaging, so they wait as long as the Agent does.
+func hook(method, name string) func(ctx context.Context, c *Client, query url.Values,
+	args []string) (Result, error) {
+	return func(ctx context.Context, c *Client, query url.Values, args []string) (Result, error) {
+		if err := noArgs(args); err != nil {
+			return nil, err
+		}
+
+		var res hookStatus
+
+		if err := c.do(ctx, method, lifecycle.PathPrefix+name, query, nil, &res); err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+}
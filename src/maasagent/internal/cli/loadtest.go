@@ -0,0 +1,144 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"maas.io/core/src/maasagent/internal/loadtest"
+)
+
+const (
+	defaultLoadTestCount = 100
+	defaultLoadTestRate  = 10
+)
+
+func loadTestFlags(fs *flag.FlagSet, query url.Values) {
+	for name, usage := range map[string]string{
+		"count":        "number of workflows",
+		"rate":         "workflows started per second",
+		"action":       "power action: power-on, power-off, power-cycle or power-query",
+		"latency":      "latency of the simulated BMC, e.g. 500ms",
+		"failure-rate": "probability of a simulated BMC failure",
+		"machines":     "number of simulated machines",
+	} {
+		name := name
+
+		fs.Func(name, usage, func(v string) error {
+			query.Set(name, v)
+			return nil
+		})
+	}
+}
+
+// loadTestConfig returns Config set by flags of load-test
+func loadTestConfig(query url.Values) (loadtest.Config, error) {
+	cfg := loadtest.Config{
+		Action:  query.Get("action"),
+		Latency: query.Get("latency"),
+		Count:   defaultLoadTestCount,
+		Rate:    defaultLoadTestRate,
+	}
+
+	var err error
+
+	ints := map[string]*int{"count": &cfg.Count, "machines": &cfg.Machines}
+	for name, v := range ints {
+		if s := query.Get(name); s != "" {
+			if *v, err = strconv.Atoi(s); err != nil {
+				return cfg, fmt.Errorf("%w: invalid --%s: %v", ErrUsage, name, err)
+			}
+		}
+	}
+
+	floats := map[string]*float64{"rate": &cfg.Rate, "failure-rate": &cfg.FailureRate}
+	for name, v := range floats {
+		if s := query.Get(name); s != "" {
+			if *v, err = strconv.ParseFloat(s, 64); err != nil {
+				return cfg, fmt.Errorf("%w: invalid --%s: %v", ErrUsage, name, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+type loadTestReport loadtest.Report
+
+func (r loadTestReport) Text(w io.Writer) error {
+	tw := newTabWriter(w)
+	fmt.Fprintf(tw, "Action:\t%s\n", r.Action)
+	fmt.Fprintf(tw, "Started:\t%d\n", r.Started)
+	fmt.Fprintf(tw, "Succeeded:\t%d\n", r.Succeeded)
+	fmt.Fprintf(tw, "Failed:\t%d\n", r.Failed)
+	fmt.Fprintf(tw, "Duration:\t%s\n", r.Duration)
+	fmt.Fprintf(tw, "Throughput:\t%.2f/s\n", r.Throughput)
+	fmt.Fprintf(tw, "Latency:\tp50 %s, p95 %s, p99 %s, max %s\n",
+		r.Latency.P50, r.Latency.P95, r.Latency.P99, r.Latency.Max)
+
+	errs := make([]string, 0, len(r.Errors))
+	for msg := range r.Errors {
+		errs = append(errs, msg)
+	}
+
+	sort.Slice(errs, func(i, j int) bool {
+		if r.Errors[errs[i]] != r.Errors[errs[j]] {
+			return r.Errors[errs[i]] > r.Errors[errs[j]]
+		}
+
+		return errs[i] < errs[j]
+	})
+
+	for _, msg := range errs {
+		fmt.Fprintf(tw, "Error:\t%s (%d)\n", msg, r.Errors[msg])
+	}
+
+	return tw.Flush()
+}
+
+// loadTest runs synthetic power workflows with the simulator driver
+// on the Agent, until all of them complete or the command is interrupted
+func loadTest(ctx context.Context, c *Client, query url.Values, args []string) (Result, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	cfg, err := loadTestConfig(query)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var res loadTestReport
+
+	if err := c.Post(ctx, loadtest.Path, bytes.NewReader(body), &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/activitymon"
+	"maas.io/core/src/maasagent/internal/blob"
+	"maas.io/core/src/maasagent/internal/fshealth"
+	"maas.io/core/src/maasagent/internal/remediation"
+	"maas.io/core/src/maasagent/internal/slo"
+)
+
+const (
+	// topRecent is the number of finished executions shown by top
+	topRecent = 10
+	// topErrorWidth is the maximal width of errors shown by top
+	topErrorWidth = 60
+)
+
+// TopResult is a snapshot of the Agent shown by top
+type TopResult struct {
+	Activity  activitymon.Snapshot  `json:"activity"`
+	Health    []fshealth.Status     `json:"health"`
+	Circuits  []remediation.Circuit `json:"circuits"`
+	Latencies []slo.Latency         `json:"latencies"`
+	DiskUsage []blob.Usage          `json:"disk_usage"`
+}
+
+func top(ctx context.Context, c *Client, _ url.Values, args []string) (Result, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	res := TopResult{
+		Health:    []fshealth.Status{},
+		Circuits:  []remediation.Circuit{},
+		Latencies: []slo.Latency{},
+		DiskUsage: []blob.Usage{},
+	}
+
+	if err := c.Get(ctx, "/activity", nil, &res.Activity); err != nil {
+		return nil, err
+	}
+
+	// Subsystems are set up while the Agent starts, so their endpoints
+	// may not be available yet
+	for path, out := range map[string]any{
+		"/health":     &res.Health,
+		"/circuits":   &res.Circuits,
+		"/latencies":  &res.Latencies,
+		"/disk-usage": &res.DiskUsage,
+	} {
+		if err := c.Get(ctx, path, nil, out); err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (r TopResult) Text(w io.Writer) error {
+	failed := 0
+
+	for _, e := range r.Activity.Recent {
+		if e.State == activitymon.StateFailed {
+			failed++
+		}
+	}
+
+	fmt.Fprintf(w, "maas-agent top - %s  running: %d  recently failed: %d  open circuits: %d\n\n",
+		r.Activity.Time.Format(time.RFC3339), len(r.Activity.Running), failed, len(r.Circuits))
+
+	tw := newTabWriter(w)
+	fmt.Fprintln(tw, "KIND\tNAME\tWORKFLOW\tDRIVER\tSTATE\tTIME\tERROR")
+
+	recent := r.Activity.Recent
+	if len(recent) > topRecent {
+		recent = recent[:topRecent]
+	}
+
+	for _, e := range append(r.Activity.Running, recent...) {
+		end := r.Activity.Time
+		if e.FinishedAt != nil {
+			end = *e.FinishedAt
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Kind, e.Name, e.WorkflowID,
+			dash(e.Driver), e.State, end.Sub(e.StartedAt).Round(time.Second), dash(truncate(e.Error)))
+	}
+
+	fmt.Fprintf(tw, "\nDRIVER\tTOTAL\tFAILED\tERROR RATE (%s)\n",
+		time.Duration(r.Activity.Window)*time.Second)
+
+	for _, d := range r.Activity.Drivers {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\n", d.Driver, d.Total, d.Failed, d.ErrorRate*100)
+	}
+
+	fmt.Fprintln(tw, "\nSUBSYSTEM\tSTATUS\tFREE\tDETAIL")
+
+	for _, s := range r.Health {
+		status := "healthy"
+		if s.Error != "" {
+			status = "degraded"
+		}
+
+		free := "-"
+		if s.TotalBytes > 0 {
+			free = fmt.Sprintf("%.1f%%", float64(s.FreeBytes)/float64(s.TotalBytes)*100)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Subsystem, status, free, truncate(s.Error))
+	}
+
+	for _, c := range r.Circuits {
+		fmt.Fprintf(tw, "%s\tcircuit open\t-\t%s until %s\n", c.Source, c.Rule,
+			c.Until.Format(time.RFC3339))
+	}
+
+	for _, u := range r.DiskUsage {
+		if u.Quota > 0 && u.Used >= u.Quota {
+			fmt.Fprintf(tw, "%s\tquota exceeded\t-\t%d of %d bytes used\n", u.Subsystem, u.Used, u.Quota)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// truncate shortens s to fit in a column of top
+func truncate(s string) string {
+	r := []rune(s)
+	if len(r) <= topErrorWidth {
+		return s
+	}
+
+	return string(r[:topErrorWidth-3]) + "..."
+}
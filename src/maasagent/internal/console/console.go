@@ -0,0 +1,393 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package console proxies graphical consoles (VNC, SPICE) of composed VMs
+// over WebSocket, so they can be opened from the Region UI.
+// Access to a console is granted by a single-use token that is valid for
+// a limited time, and the connection is closed once it expires.
+// Sessions can be recorded to the artifact store for compliance review.
+package console
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+// Supported console protocols
+const (
+	ProtocolVNC   = "vnc"
+	ProtocolSPICE = "spice"
+)
+
+const (
+	defaultTTL     = time.Hour
+	defaultMaxTTL  = 8 * time.Hour
+	dialTimeout    = 10 * time.Second
+	tokenSize      = 32
+	binaryProtocol = "binary"
+)
+
+var (
+	// ErrInvalidSession is returned when token is unknown, already used
+	// or expired
+	ErrInvalidSession = errors.New("invalid or expired console session")
+	// ErrSessionInUse is returned when there is already a connection
+	// for the session
+	ErrSessionInUse = errors.New("console session is in use")
+	// ErrUnsupportedProtocol is returned for consoles other than VNC or SPICE
+	ErrUnsupportedProtocol = errors.New("unsupported console protocol")
+	// ErrRecordingUnavailable is returned when recording of a session is
+	// requested, but there is no store for recordings
+	ErrRecordingUnavailable = errors.New("console session recording is unavailable")
+)
+
+type session struct {
+	expires  time.Time
+	conn     net.Conn
+	address  string
+	protocol string
+	// recording is the artifact key of the recording, empty if the
+	// session is not recorded
+	recording string
+	active    bool
+}
+
+// SessionOption allows to set additional session options
+type SessionOption func(*session)
+
+// WithRecording records the session to the artifact store under key.
+// Recording starts when the console is connected.
+func WithRecording(key string) SessionOption {
+	return func(s *session) {
+		s.recording = key
+	}
+}
+
+// Proxy keeps console sessions and serves them over WebSocket.
+type Proxy struct {
+	sessions map[string]*session
+	dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	now      func() time.Time
+	store    blob.Store
+	maxTTL   time.Duration
+	mutex    sync.Mutex
+}
+
+// ProxyOption allows to set additional Proxy options
+type ProxyOption func(*Proxy)
+
+// NewProxy returns an instance of Proxy
+func NewProxy(options ...ProxyOption) *Proxy {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	p := &Proxy{
+		sessions: make(map[string]*session),
+		dial:     dialer.DialContext,
+		now:      time.Now,
+		maxTTL:   defaultMaxTTL,
+	}
+
+	for _, opt := range options {
+		opt(p)
+	}
+
+	return p
+}
+
+// WithMaxTTL allows to limit how long a console session can last.
+// Longer TTLs requested by the Region are capped to this value.
+func WithMaxTTL(d time.Duration) ProxyOption {
+	return func(p *Proxy) {
+		p.maxTTL = d
+	}
+}
+
+// WithDialer allows to set a custom dial function used to connect to
+// consoles of VM hosts.
+func WithDialer(dial func(ctx context.Context, network, address string) (net.Conn, error)) ProxyOption {
+	return func(p *Proxy) {
+		p.dial = dial
+	}
+}
+
+// WithRecordingStore allows sessions to be recorded to store
+func WithRecordingStore(store blob.Store) ProxyOption {
+	return func(p *Proxy) {
+		p.store = store
+	}
+}
+
+// Create registers a session for the console at address and returns its
+// token. Zero TTL means the default of one hour.
+func (p *Proxy) Create(address, protocol string, ttl time.Duration,
+	options ...SessionOption) (string, time.Time, error) {
+	if protocol != ProtocolVNC && protocol != ProtocolSPICE {
+		return "", time.Time{}, fmt.Errorf("%w: %q", ErrUnsupportedProtocol, protocol)
+	}
+
+	s := &session{
+		address:  address,
+		protocol: protocol,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	if s.recording != "" && p.store == nil {
+		return "", time.Time{}, ErrRecordingUnavailable
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", time.Time{}, err
+	}
+
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	ttl = min(ttl, p.maxTTL)
+
+	token, err := newToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.purge()
+
+	s.expires = p.now().Add(ttl)
+	p.sessions[token] = s
+
+	return token, s.expires, nil
+}
+
+// newToken returns a random URL safe token
+func newToken() (string, error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Revoke invalidates the session and closes its connection, if any.
+func (p *Proxy) Revoke(token string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.sessions[token]
+	if !ok {
+		return
+	}
+
+	delete(p.sessions, token)
+
+	if s.conn != nil {
+		//nolint:errcheck // connection is discarded anyway
+		s.conn.Close()
+	}
+}
+
+// Len returns the number of sessions that are not yet closed or expired.
+func (p *Proxy) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.purge()
+
+	return len(p.sessions)
+}
+
+// purge removes expired sessions that were never connected.
+// Active sessions are removed once their connection is closed.
+// Must be called with mutex held.
+func (p *Proxy) purge() {
+	now := p.now()
+
+	for token, s := range p.sessions {
+		if !s.active && !now.Before(s.expires) {
+			delete(p.sessions, token)
+		}
+	}
+}
+
+// connect claims the session and connects to the console. The returned
+// function must be called once the connection is no longer needed, after
+// that the token cannot be used again.
+func (p *Proxy) connect(ctx context.Context, token string) (net.Conn, func(), error) {
+	p.mutex.Lock()
+
+	s, ok := p.sessions[token]
+	if !ok || !p.now().Before(s.expires) {
+		p.mutex.Unlock()
+		return nil, nil, ErrInvalidSession
+	}
+
+	if s.active {
+		p.mutex.Unlock()
+		return nil, nil, ErrSessionInUse
+	}
+
+	s.active = true
+	address := s.address
+	p.mutex.Unlock()
+
+	conn, err := p.dial(ctx, "tcp", address)
+
+	var rec *recorder
+
+	if err == nil && s.recording != "" {
+		// Sessions which should be recorded are never left unrecorded
+		rec, err = newRecorder(RecordingHeader{
+			Version:  recordingVersion,
+			Started:  p.now(),
+			Address:  s.address,
+			Protocol: s.protocol,
+		}, p.now)
+		if err != nil {
+			//nolint:errcheck // connection is discarded anyway
+			conn.Close()
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err != nil {
+		// Allow to retry, e.g. if the VM was not running yet
+		s.active = false
+		return nil, nil, err
+	}
+
+	if p.sessions[token] != s {
+		// Revoked while we were connecting
+		//nolint:errcheck // connection is discarded anyway
+		conn.Close()
+
+		if rec != nil {
+			rec.discard()
+		}
+
+		return nil, nil, ErrInvalidSession
+	}
+
+	s.conn = conn
+
+	// Connection is closed when the session expires
+	timer := time.AfterFunc(s.expires.Sub(p.now()), func() { p.Revoke(token) })
+
+	release := func() {
+		timer.Stop()
+		p.Revoke(token)
+	}
+
+	if rec == nil {
+		return conn, release, nil
+	}
+
+	key := s.recording
+
+	return &recordedConn{Conn: conn, rec: rec}, func() {
+		release()
+		p.finishRecording(rec, key)
+	}, nil
+}
+
+// ServeHTTP upgrades request for /<prefix>/<token> to WebSocket and proxies
+// it to the console of the session.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	conn, release, err := p.connect(r.Context(), path.Base(r.URL.Path))
+
+	switch {
+	case errors.Is(err, ErrInvalidSession):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrSessionInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	defer release()
+
+	server := websocket.Server{
+		// Access is granted by the token, so Origin is not checked.
+		// noVNC and spice-html5 negotiate "binary" subprotocol.
+		Handshake: func(cfg *websocket.Config, _ *http.Request) error {
+			protocols := cfg.Protocol
+			cfg.Protocol = nil
+
+			for _, p := range protocols {
+				if p == binaryProtocol {
+					cfg.Protocol = []string{binaryProtocol}
+				}
+			}
+
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			pipe(ws, conn)
+		},
+	}
+
+	server.ServeHTTP(w, r)
+}
+
+// pipe copies data in both directions until either side is closed
+func pipe(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+
+	cp := func(dst io.Writer, src io.Reader) {
+		//nolint:errcheck // errors just mean one of the sides was closed
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+
+	go cp(a, b)
+	go cp(b, a)
+
+	<-done
+
+	//nolint:errcheck // connections are discarded anyway
+	a.Close()
+	//nolint:errcheck // connections are discarded anyway
+	b.Close()
+
+	<-done
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package console
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func pipeDialer(t *testing.T) (func(context.Context, string, string) (net.Conn, error), <-chan net.Conn) {
+	t.Helper()
+
+	remote := make(chan net.Conn, 1)
+
+	return func(context.Context, string, string) (net.Conn, error) {
+		local, r := net.Pipe()
+		remote <- r
+
+		return local, nil
+	}, remote
+}
+
+func TestCreate(t *testing.T) {
+	testcases := map[string]struct {
+		address  string
+		protocol string
+		ttl      time.Duration
+		expires  time.Duration
+		err      error
+	}{
+		"vnc": {
+			address:  "10.0.0.2:5900",
+			protocol: ProtocolVNC,
+			ttl:      time.Minute,
+			expires:  time.Minute,
+		},
+		"default ttl": {
+			address:  "10.0.0.2:5900",
+			protocol: ProtocolSPICE,
+			expires:  defaultTTL,
+		},
+		"ttl is capped": {
+			address:  "[fd00::2]:5900",
+			protocol: ProtocolVNC,
+			ttl:      24 * time.Hour,
+			expires:  2 * time.Hour,
+		},
+		"unsupported protocol": {
+			address:  "10.0.0.2:5900",
+			protocol: "rdp",
+			err:      ErrUnsupportedProtocol,
+		},
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := NewProxy(WithMaxTTL(2 * time.Hour))
+			p.now = func() time.Time { return now }
+
+			token, expires, err := p.Create(tc.address, tc.protocol, tc.ttl)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				assert.Equal(t, 0, p.Len())
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, token, 43)
+			assert.Equal(t, now.Add(tc.expires), expires)
+			assert.Equal(t, 1, p.Len())
+		})
+	}
+}
+
+func TestCreateInvalidAddress(t *testing.T) {
+	p := NewProxy()
+
+	_, _, err := p.Create("10.0.0.2", ProtocolVNC, 0)
+	assert.Error(t, err)
+}
+
+func TestConnectSingleUse(t *testing.T) {
+	dial, remote := pipeDialer(t)
+	p := NewProxy(WithDialer(dial))
+
+	token, _, err := p.Create("10.0.0.2:5900", ProtocolVNC, time.Minute)
+	require.NoError(t, err)
+
+	conn, release, err := p.connect(context.Background(), token)
+	require.NoError(t, err)
+
+	<-remote
+
+	_, _, err = p.connect(context.Background(), token)
+	assert.ErrorIs(t, err, ErrSessionInUse)
+
+	release()
+
+	_, err = conn.Write([]byte("x"))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+
+	_, _, err = p.connect(context.Background(), token)
+	assert.ErrorIs(t, err, ErrInvalidSession)
+	assert.Equal(t, 0, p.Len())
+}
+
+func TestConnectExpired(t *testing.T) {
+	dial, _ := pipeDialer(t)
+	p := NewProxy(WithDialer(dial))
+
+	token, _, err := p.Create("10.0.0.2:5900", ProtocolVNC, time.Minute)
+	require.NoError(t, err)
+
+	p.now = func() time.Time { return time.Now().Add(time.Minute) }
+
+	_, _, err = p.connect(context.Background(), token)
+	assert.ErrorIs(t, err, ErrInvalidSession)
+	assert.Equal(t, 0, p.Len())
+}
+
+func TestConnectDialError(t *testing.T) {
+	attempts := 0
+
+	p := NewProxy(WithDialer(func(context.Context, string, string) (net.Conn, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("connection refused")
+		}
+
+		local, _ := net.Pipe()
+
+		return local, nil
+	}))
+
+	token, _, err := p.Create("10.0.0.2:5900", ProtocolVNC, time.Minute)
+	require.NoError(t, err)
+
+	_, _, err = p.connect(context.Background(), token)
+	assert.Error(t, err)
+
+	// Session can be used again if console was not reachable
+	_, release, err := p.connect(context.Background(), token)
+	require.NoError(t, err)
+
+	release()
+}
+
+func TestRevokeClosesConnection(t *testing.T) {
+	dial, remote := pipeDialer(t)
+	p := NewProxy(WithDialer(dial))
+
+	token, _, err := p.Create("10.0.0.2:5900", ProtocolVNC, time.Minute)
+	require.NoError(t, err)
+
+	conn, release, err := p.connect(context.Background(), token)
+	require.NoError(t, err)
+
+	defer release()
+
+	<-remote
+
+	p.Revoke(token)
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+	assert.Equal(t, 0, p.Len())
+}
+
+func TestServeHTTP(t *testing.T) {
+	// Fake console that echoes everything back
+	console, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer console.Close()
+
+	go func() {
+		for {
+			conn, err := console.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				//nolint:errcheck // test echo server
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	p := NewProxy()
+
+	mux := http.NewServeMux()
+	mux.Handle(PathPrefix, p)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	t.Run("unknown token", func(t *testing.T) {
+		//nolint:noctx // this is okay not to have a context here
+		resp, err := http.Get(server.URL + PathPrefix + "unknown")
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("echo", func(t *testing.T) {
+		token, _, err := p.Create(console.Addr().String(), ProtocolVNC, time.Minute)
+		require.NoError(t, err)
+
+		cfg, err := websocket.NewConfig(wsURL+PathPrefix+token, server.URL)
+		require.NoError(t, err)
+
+		cfg.Protocol = []string{binaryProtocol}
+
+		ws, err := websocket.DialConfig(cfg)
+		require.NoError(t, err)
+
+		defer ws.Close()
+
+		ws.PayloadType = websocket.BinaryFrame
+
+		_, err = ws.Write([]byte("RFB 003.008\n"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 12)
+		_, err = io.ReadFull(ws, buf)
+		require.NoError(t, err)
+
+		assert.Equal(t, "RFB 003.008\n", string(buf))
+
+		// Token cannot be reused for another connection
+		_, err = websocket.DialConfig(cfg)
+		assert.Error(t, err)
+	})
+}
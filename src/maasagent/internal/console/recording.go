@@ -0,0 +1,195 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package console
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+// Directions of recorded data
+const (
+	// DirectionInput is data sent by the operator to the console
+	DirectionInput = "i"
+	// DirectionOutput is data sent by the console to the operator
+	DirectionOutput = "o"
+)
+
+const (
+	recordingVersion = 1
+	// uploadTimeout limits how long a recording can take to be stored
+	// once the session is closed
+	uploadTimeout = 5 * time.Minute
+)
+
+// RecordingHeader is the first line of a recording. It is followed by one
+// RecordingEvent per line.
+type RecordingHeader struct {
+	Started  time.Time `json:"started"`
+	Address  string    `json:"address"`
+	Protocol string    `json:"protocol"`
+	Version  int       `json:"version"`
+}
+
+// RecordingEvent is data sent in one direction of the session
+type RecordingEvent struct {
+	// Direction is either DirectionInput or DirectionOutput
+	Direction string `json:"d"`
+	// Data is raw protocol data (encoded as base64)
+	Data []byte `json:"data"`
+	// Time is the offset in nanoseconds from the start of the session
+	Time time.Duration `json:"t"`
+}
+
+// RecordingKey returns the artifact key of the recording of a session of
+// the machine, made unique by id. Recordings are stored as console
+// artifacts, so they share the quota of console logs.
+func RecordingKey(systemID string, created time.Time, id string) string {
+	return path.Join("console", systemID,
+		fmt.Sprintf("session-%s-%s.jsonl", created.UTC().Format("20060102T150405Z"), id[:min(len(id), 8)]))
+}
+
+// recorder writes events of a session into a temporary file, which is
+// uploaded to the artifact store once the session is closed, so slow
+// storage doesn't slow down the console.
+type recorder struct {
+	file    *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+	started time.Time
+	err     error
+	now     func() time.Time
+	mutex   sync.Mutex
+}
+
+func newRecorder(header RecordingHeader, now func() time.Time) (*recorder, error) {
+	f, err := os.CreateTemp("", "maas-console-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	r := &recorder{
+		file:    f,
+		w:       w,
+		enc:     json.NewEncoder(w),
+		started: header.Started,
+		now:     now,
+	}
+
+	if err := r.enc.Encode(header); err != nil {
+		r.discard()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// record appends data sent in the given direction. The first error is kept
+// and reported on upload, recording never interrupts the session.
+func (r *recorder) record(direction string, data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	r.err = r.enc.Encode(RecordingEvent{
+		Time:      r.now().Sub(r.started),
+		Direction: direction,
+		Data:      data,
+	})
+}
+
+// upload stores the recording under key and removes the temporary file
+func (r *recorder) upload(ctx context.Context, store blob.Store, key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	defer r.discard()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	return store.Put(ctx, key, r.file, info.Size())
+}
+
+func (r *recorder) discard() {
+	//nolint:errcheck // temporary file is removed anyway
+	r.file.Close()
+	//nolint:errcheck,gosec // nothing can be done if it can't be removed
+	os.Remove(r.file.Name())
+}
+
+// recordedConn records data read from and written to the console
+type recordedConn struct {
+	net.Conn
+	rec *recorder
+}
+
+func (c *recordedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.rec.record(DirectionOutput, b[:n])
+	}
+
+	return n, err
+}
+
+func (c *recordedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.rec.record(DirectionInput, b[:n])
+	}
+
+	return n, err
+}
+
+// finishRecording uploads the recording of the session, logging failures,
+// as the operator has already gone away.
+func (p *Proxy) finishRecording(rec *recorder, key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	if err := rec.upload(ctx, p.store, key); err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Failed to store console session recording")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package console
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+func TestRecordingKey(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+
+	assert.Equal(t, "console/abc123/session-20240102T020405Z-0123456.jsonl",
+		RecordingKey("abc123", created, "0123456"))
+	assert.Equal(t, "console/abc123/session-20240102T020405Z-01234567.jsonl",
+		RecordingKey("abc123", created, "0123456789"))
+}
+
+func TestCreateRecordingUnavailable(t *testing.T) {
+	p := NewProxy()
+
+	_, _, err := p.Create("10.0.0.2:5900", ProtocolVNC, time.Minute, WithRecording("console/abc/1.jsonl"))
+	assert.ErrorIs(t, err, ErrRecordingUnavailable)
+	assert.Equal(t, 0, p.Len())
+
+	_, err = p.createSession(context.Background(), CreateConsoleSessionParam{
+		Address:  "10.0.0.2:5900",
+		Protocol: ProtocolVNC,
+		Record:   true,
+	})
+	assert.ErrorIs(t, err, ErrRecordingUnavailable)
+}
+
+func TestRecordSession(t *testing.T) {
+	store, err := blob.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	dial, remote := pipeDialer(t)
+	p := NewProxy(WithDialer(dial), WithRecordingStore(store))
+
+	res, err := p.createSession(context.Background(), CreateConsoleSessionParam{
+		Address:  "10.0.0.2:5900",
+		Protocol: ProtocolVNC,
+		SystemID: "abc123",
+		Record:   true,
+	})
+	require.NoError(t, err)
+	assert.Regexp(t, `^console/abc123/session-\d{8}T\d{6}Z-.{8}\.jsonl$`, res.RecordingKey)
+
+	conn, release, err := p.connect(context.Background(), res.Token)
+	require.NoError(t, err)
+
+	console := <-remote
+
+	go func() {
+		buf := make([]byte, 12)
+		//nolint:errcheck // checked by reading the echo
+		io.ReadFull(console, buf)
+		//nolint:errcheck // checked by reading the echo
+		console.Write([]byte("RFB 003.008\n"))
+	}()
+
+	_, err = conn.Write([]byte("RFB 003.003\n"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 12)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+
+	release()
+
+	r, err := store.Get(context.Background(), res.RecordingKey)
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+
+	require.True(t, scanner.Scan())
+
+	var header RecordingHeader
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, recordingVersion, header.Version)
+	assert.Equal(t, "10.0.0.2:5900", header.Address)
+	assert.Equal(t, ProtocolVNC, header.Protocol)
+
+	var events []RecordingEvent
+
+	for scanner.Scan() {
+		var ev RecordingEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &ev))
+
+		events = append(events, ev)
+	}
+
+	require.Len(t, events, 2)
+	assert.Equal(t, DirectionInput, events[0].Direction)
+	assert.Equal(t, "RFB 003.003\n", string(events[0].Data))
+	assert.Equal(t, DirectionOutput, events[1].Direction)
+	assert.Equal(t, "RFB 003.008\n", string(events[1].Data))
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package console
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CreateConsoleSessionParam is the activity parameter for create-console-session
+type CreateConsoleSessionParam struct {
+	// Address of the console on the VM host, e.g. "10.0.0.2:5900"
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"`
+	// SystemID of the machine, which recordings are stored under
+	SystemID string `json:"system_id"`
+	// TTL in seconds
+	TTL int64 `json:"ttl"`
+	// Record the session to the artifact store
+	Record bool `json:"record"`
+}
+
+// CreateConsoleSessionResult is the activity result for create-console-session
+type CreateConsoleSessionResult struct {
+	Expires time.Time `json:"expires"`
+	Token   string    `json:"token"`
+	// Path of the WebSocket endpoint on the Agent HTTP socket
+	Path string `json:"path"`
+	// RecordingKey is the artifact key of the recording, which is stored
+	// once the session is closed. Empty if the session is not recorded.
+	RecordingKey string `json:"recording_key,omitempty"`
+}
+
+// RevokeConsoleSessionParam is the activity parameter for revoke-console-session
+type RevokeConsoleSessionParam struct {
+	Token string `json:"token"`
+}
+
+// PathPrefix is where Proxy is expected to be served
+const PathPrefix = "/console/"
+
+func (p *Proxy) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// ConfigurationActivities allows the Region to open and close console
+// sessions on behalf of UI users.
+func (p *Proxy) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"create-console-session": p.createSession,
+		"revoke-console-session": p.revokeSession,
+	}
+}
+
+func (p *Proxy) createSession(_ context.Context,
+	param CreateConsoleSessionParam) (*CreateConsoleSessionResult, error) {
+	var options []SessionOption
+
+	var key string
+
+	if param.Record {
+		if param.SystemID == "" {
+			return nil, fmt.Errorf("%w: system_id is required", ErrRecordingUnavailable)
+		}
+
+		// Token is not known yet, so the key is based on a random ID
+		id, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+
+		key = RecordingKey(param.SystemID, p.now(), id)
+		options = append(options, WithRecording(key))
+	}
+
+	token, expires, err := p.Create(param.Address, param.Protocol,
+		time.Duration(param.TTL)*time.Second, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateConsoleSessionResult{
+		Token:        token,
+		Path:         PathPrefix + token,
+		Expires:      expires,
+		RecordingKey: key,
+	}, nil
+}
+
+func (p *Proxy) revokeSession(_ context.Context, param RevokeConsoleSessionParam) error {
+	p.Revoke(param.Token)
+	return nil
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package deploycreds issues short-lived credentials for a single
+// deployment, so endpoints used by the machine being deployed (metadata,
+// images and callbacks) can only be accessed while its workflow is running.
+// Credentials are revoked when the workflow ends, which limits the blast
+// radius of leaked preseed data.
+package deploycreds
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+)
+
+// Scopes of the endpoints credentials can grant access to
+const (
+	ScopeMetadata = "metadata"
+	ScopeImages   = "images"
+	ScopeCallback = "callback"
+)
+
+const (
+	defaultTTL = 2 * time.Hour
+	maxTTL     = 24 * time.Hour
+	tokenSize  = 32
+)
+
+var (
+	// ErrInvalidToken is returned when token is unknown, expired or revoked
+	ErrInvalidToken = errors.New("invalid deployment token")
+	// ErrScopeNotGranted is returned when token doesn't grant access to scope
+	ErrScopeNotGranted = errors.New("scope not granted")
+	// ErrInvalidScope is returned when issuing credentials for unknown scope
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
+// Grant is what deployment credentials give access to
+type Grant struct {
+	Expires    time.Time `json:"expires"`
+	SystemID   string    `json:"system_id"`
+	WorkflowID string    `json:"workflow_id"`
+	Scopes     []string  `json:"scopes"`
+}
+
+// Store issues and verifies deployment credentials. Only hashes of tokens
+// are kept, so tokens cannot be recovered from the persisted state.
+type Store struct {
+	grants map[string]Grant
+	now    func() time.Time
+	path   string
+	mutex  sync.Mutex
+}
+
+// NewStore returns Store persisting grants in path, so deployments survive
+// Agent restarts. Grants are only kept in memory if path is empty.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		grants: make(map[string]Grant),
+		now:    time.Now,
+		path:   path,
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path) //nolint:gosec // path is not user provided
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.grants); err != nil {
+		return nil, fmt.Errorf("failed to load deployment credentials: %w", err)
+	}
+
+	return s, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue returns a new token granting access to scopes of the machine for
+// ttl, or a default duration if ttl is not positive.
+func (s *Store) Issue(systemID, workflowID string, scopes []string, ttl time.Duration) (string, Grant, error) {
+	for _, scope := range scopes {
+		if !slices.Contains([]string{ScopeMetadata, ScopeImages, ScopeCallback}, scope) {
+			return "", Grant{}, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	ttl = min(ttl, maxTTL)
+
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", Grant{}, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	g := Grant{
+		SystemID:   systemID,
+		WorkflowID: workflowID,
+		Scopes:     slices.Clone(scopes),
+		Expires:    s.now().Add(ttl),
+	}
+
+	s.grants[hashToken(token)] = g
+
+	if err := s.save(); err != nil {
+		delete(s.grants, hashToken(token))
+		return "", Grant{}, err
+	}
+
+	return token, g, nil
+}
+
+// Verify returns the grant of token if it gives access to scope
+func (s *Store) Verify(token, scope string) (Grant, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	g, ok := s.grants[hashToken(token)]
+	if !ok || !s.now().Before(g.Expires) {
+		return Grant{}, ErrInvalidToken
+	}
+
+	if !slices.Contains(g.Scopes, scope) {
+		return Grant{}, fmt.Errorf("%w: %s", ErrScopeNotGranted, scope)
+	}
+
+	return g, nil
+}
+
+// Revoke invalidates all credentials issued for the workflow and returns
+// how many were revoked.
+func (s *Store) Revoke(workflowID string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var n int
+
+	for k, g := range s.grants {
+		if g.WorkflowID == workflowID {
+			delete(s.grants, k)
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, s.save()
+}
+
+// save removes expired grants and persists the rest. Must be called with
+// mutex held.
+func (s *Store) save() error {
+	now := s.now()
+
+	for k, g := range s.grants {
+		if !now.Before(g.Expires) {
+			delete(s.grants, k)
+		}
+	}
+
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(s.grants)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path, b, 0o600)
+}
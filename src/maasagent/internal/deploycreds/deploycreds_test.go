@@ -0,0 +1,151 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package deploycreds
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	s, err := NewStore("")
+	require.NoError(t, err)
+
+	token, _, err := s.Issue("abc123", "deploy:abc123", []string{ScopeCallback, ScopeImages}, time.Hour)
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		token string
+		scope string
+		err   error
+	}{
+		"granted": {
+			token: token,
+			scope: ScopeCallback,
+		},
+		"not granted": {
+			token: token,
+			scope: ScopeMetadata,
+			err:   ErrScopeNotGranted,
+		},
+		"unknown token": {
+			token: "foo",
+			scope: ScopeCallback,
+			err:   ErrInvalidToken,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			g, err := s.Verify(tc.token, tc.scope)
+			assert.ErrorIs(t, err, tc.err)
+
+			if tc.err == nil {
+				assert.Equal(t, "abc123", g.SystemID)
+			}
+		})
+	}
+}
+
+func TestIssueInvalidScope(t *testing.T) {
+	s, err := NewStore("")
+	require.NoError(t, err)
+
+	_, _, err = s.Issue("abc123", "deploy:abc123", []string{"admin"}, time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestExpiry(t *testing.T) {
+	s, err := NewStore("")
+	require.NoError(t, err)
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	token, g, err := s.Issue("abc123", "deploy:abc123", []string{ScopeMetadata}, 48*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(maxTTL), g.Expires)
+
+	s.now = func() time.Time { return now.Add(maxTTL) }
+
+	_, err = s.Verify(token, ScopeMetadata)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRevoke(t *testing.T) {
+	s, err := NewStore("")
+	require.NoError(t, err)
+
+	first, _, err := s.Issue("abc123", "deploy:abc123", []string{ScopeMetadata}, time.Hour)
+	require.NoError(t, err)
+
+	second, _, err := s.Issue("abc123", "deploy:abc123", []string{ScopeCallback}, time.Hour)
+	require.NoError(t, err)
+
+	other, _, err := s.Issue("def456", "deploy:def456", []string{ScopeMetadata}, time.Hour)
+	require.NoError(t, err)
+
+	n, err := s.Revoke("deploy:abc123")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = s.Verify(first, ScopeMetadata)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = s.Verify(second, ScopeCallback)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = s.Verify(other, ScopeMetadata)
+	assert.NoError(t, err)
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploycreds", "grants.json")
+
+	s, err := NewStore(path)
+	require.NoError(t, err)
+
+	token, _, err := s.Issue("abc123", "deploy:abc123", []string{ScopeMetadata}, time.Hour)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), token)
+
+	s, err = NewStore(path)
+	require.NoError(t, err)
+
+	_, err = s.Verify(token, ScopeMetadata)
+	require.NoError(t, err)
+
+	_, err = s.Revoke("deploy:abc123")
+	require.NoError(t, err)
+
+	s, err = NewStore(path)
+	require.NoError(t, err)
+
+	_, err = s.Verify(token, ScopeMetadata)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
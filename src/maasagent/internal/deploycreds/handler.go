@@ -0,0 +1,79 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package deploycreds
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// PathPrefix is where Handler is expected to be served. URLs handed over
+// to the machine are prefixed with PathPrefix and the token, e.g.
+// /deploy/<token>/phone-home/<system_id>
+const PathPrefix = "/deploy/"
+
+// Handler verifies the token in the request path and passes the request with
+// PathPrefix and token stripped to next. scopes maps path prefixes of next
+// to the scope required to access them. Paths without a scope are rejected.
+func (s *Store) Handler(next http.Handler, scopes map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, PathPrefix), "/")
+		if !ok || token == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		rest = "/" + rest
+
+		scope, ok := scopeOf(scopes, rest)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if _, err := s.Verify(token, scope); err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrScopeNotGranted) {
+				status = http.StatusForbidden
+			}
+
+			http.Error(w, err.Error(), status)
+
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+		r2.RequestURI = r2.URL.RequestURI()
+
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// scopeOf returns scope of the longest path prefix matching path
+func scopeOf(scopes map[string]string, path string) (string, bool) {
+	var match, scope string
+
+	for prefix, s := range scopes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(match) {
+			match, scope = prefix, s
+		}
+	}
+
+	return scope, match != ""
+}
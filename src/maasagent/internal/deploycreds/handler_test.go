@@ -0,0 +1,90 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package deploycreds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	s, err := NewStore("")
+	require.NoError(t, err)
+
+	token, _, err := s.Issue("abc123", "deploy:abc123", []string{ScopeCallback}, time.Hour)
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-Query", r.URL.RawQuery)
+	})
+
+	h := s.Handler(next, map[string]string{
+		"/phone-home/": ScopeCallback,
+		"/artifacts/":  ScopeImages,
+	})
+
+	testcases := map[string]struct {
+		path   string
+		status int
+		next   string
+	}{
+		"granted": {
+			path:   "/deploy/" + token + "/phone-home/abc123?signature=x",
+			status: http.StatusOK,
+			next:   "/phone-home/abc123",
+		},
+		"scope not granted": {
+			path:   "/deploy/" + token + "/artifacts/foo",
+			status: http.StatusForbidden,
+		},
+		"invalid token": {
+			path:   "/deploy/foo/phone-home/abc123",
+			status: http.StatusUnauthorized,
+		},
+		"unscoped path": {
+			path:   "/deploy/" + token + "/metrics",
+			status: http.StatusNotFound,
+		},
+		"no path": {
+			path:   "/deploy/" + token,
+			status: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, nil))
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, tc.next, w.Header().Get("X-Path"))
+
+			if tc.next != "" {
+				assert.Equal(t, "signature=x", w.Header().Get("X-Query"))
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package deploycreds
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// IssueCredentialsParam is the activity parameter for issue-deployment-credentials
+type IssueCredentialsParam struct {
+	SystemID string `json:"system_id"`
+	// WorkflowID of the deployment, credentials are revoked by it
+	WorkflowID string   `json:"workflow_id"`
+	Scopes     []string `json:"scopes"`
+	// TTL in seconds
+	TTL int `json:"ttl"`
+}
+
+// IssueCredentialsResult is the result of issue-deployment-credentials
+type IssueCredentialsResult struct {
+	Expires time.Time `json:"expires"`
+	Token   string    `json:"token"`
+	// PathPrefix to prepend to paths on the Agent HTTP socket handed over
+	// to the machine
+	PathPrefix string `json:"path_prefix"`
+}
+
+// RevokeCredentialsParam is the activity parameter for revoke-deployment-credentials
+type RevokeCredentialsParam struct {
+	WorkflowID string `json:"workflow_id"`
+}
+
+// VerifyCredentialsParam is the activity parameter for verify-deployment-credentials
+type VerifyCredentialsParam struct {
+	Token string `json:"token"`
+	Scope string `json:"scope"`
+}
+
+func (s *Store) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// ConfigurationActivities are used by deployment workflows, which issue
+// credentials before the machine is powered on and revoke them when the
+// workflow ends. Endpoints served by the Region verify tokens with the Agent.
+func (s *Store) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"issue-deployment-credentials":  s.issueCredentials,
+		"revoke-deployment-credentials": s.revokeCredentials,
+		"verify-deployment-credentials": s.verifyCredentials,
+	}
+}
+
+func (s *Store) issueCredentials(_ context.Context, param IssueCredentialsParam) (*IssueCredentialsResult, error) {
+	token, g, err := s.Issue(param.SystemID, param.WorkflowID, param.Scopes,
+		time.Duration(param.TTL)*time.Second)
+	if errors.Is(err, ErrInvalidScope) {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &IssueCredentialsResult{
+		Token:      token,
+		PathPrefix: PathPrefix + token,
+		Expires:    g.Expires,
+	}, nil
+}
+
+func (s *Store) revokeCredentials(_ context.Context, param RevokeCredentialsParam) error {
+	_, err := s.Revoke(param.WorkflowID)
+	return err
+}
+
+func (s *Store) verifyCredentials(_ context.Context, param VerifyCredentialsParam) (*Grant, error) {
+	g, err := s.Verify(param.Token, param.Scope)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "ErrInvalidToken", err)
+	}
+
+	return &g, nil
+}
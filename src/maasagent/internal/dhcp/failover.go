@@ -0,0 +1,420 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/dhcpd/omapi"
+	wf "maas.io/core/src/maasagent/internal/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	dhcpdFailoverConfigName = "dhcpd-failover.conf"
+
+	FailoverRolePrimary   = "primary"
+	FailoverRoleSecondary = "secondary"
+
+	defaultFailoverPort              = 647
+	defaultFailoverMaxResponseDelay  = 60
+	defaultFailoverMaxUnackedUpdates = 10
+	defaultFailoverMCLT              = 3600
+	defaultFailoverSplit             = 128
+	defaultFailoverLoadBalanceMax    = 3
+
+	defaultFailoverMonitorInterval = 30 * time.Second
+	// defaultFailoverPromoteAfter is how long communication with the partner
+	// must be interrupted before it is considered for promotion
+	defaultFailoverPromoteAfter = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidFailoverConfig is returned when failover peer configuration
+	// cannot be rendered
+	ErrInvalidFailoverConfig = errors.New("invalid DHCP failover configuration")
+)
+
+var failoverNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// FailoverPeerConfig describes one side of a DHCP failover pair. Pools
+// served by the pair refer to the peer by its Name.
+type FailoverPeerConfig struct {
+	Name        string `json:"name"`
+	Role        string `json:"role"`
+	Address     string `json:"address"`
+	PeerAddress string `json:"peer_address"`
+	// PeerSystemID is the system ID of the agent running the partner
+	PeerSystemID      string `json:"peer_system_id"`
+	Port              int    `json:"port"`
+	PeerPort          int    `json:"peer_port"`
+	MaxResponseDelay  int    `json:"max_response_delay"`
+	MaxUnackedUpdates int    `json:"max_unacked_updates"`
+	// MCLT and Split are only used by the primary
+	MCLT                  int `json:"mclt"`
+	Split                 int `json:"split"`
+	LoadBalanceMaxSeconds int `json:"load_balance_max_seconds"`
+}
+
+func (c *FailoverPeerConfig) setDefaults() {
+	if c.Port == 0 {
+		c.Port = defaultFailoverPort
+	}
+
+	if c.PeerPort == 0 {
+		c.PeerPort = defaultFailoverPort
+	}
+
+	if c.MaxResponseDelay == 0 {
+		c.MaxResponseDelay = defaultFailoverMaxResponseDelay
+	}
+
+	if c.MaxUnackedUpdates == 0 {
+		c.MaxUnackedUpdates = defaultFailoverMaxUnackedUpdates
+	}
+
+	if c.MCLT == 0 {
+		c.MCLT = defaultFailoverMCLT
+	}
+
+	if c.Split == 0 {
+		c.Split = defaultFailoverSplit
+	}
+
+	if c.LoadBalanceMaxSeconds == 0 {
+		c.LoadBalanceMaxSeconds = defaultFailoverLoadBalanceMax
+	}
+}
+
+func (c *FailoverPeerConfig) validate() error {
+	if !failoverNameRe.MatchString(c.Name) {
+		return fmt.Errorf("%w: peer name %q", ErrInvalidFailoverConfig, c.Name)
+	}
+
+	if c.Role != FailoverRolePrimary && c.Role != FailoverRoleSecondary {
+		return fmt.Errorf("%w: %s: role %q", ErrInvalidFailoverConfig, c.Name, c.Role)
+	}
+
+	// ISC DHCP supports failover only for DHCPv4
+	for _, addr := range []string{c.Address, c.PeerAddress} {
+		if ip, err := netip.ParseAddr(addr); err != nil || !ip.Is4() {
+			return fmt.Errorf("%w: %s: address %q", ErrInvalidFailoverConfig, c.Name, addr)
+		}
+	}
+
+	for _, port := range []int{c.Port, c.PeerPort} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("%w: %s: port %d", ErrInvalidFailoverConfig, c.Name, port)
+		}
+	}
+
+	if c.Split < 0 || c.Split > 256 {
+		return fmt.Errorf("%w: %s: split %d", ErrInvalidFailoverConfig, c.Name, c.Split)
+	}
+
+	return nil
+}
+
+// renderFailoverConfig returns failover peer declarations for dhcpd.conf
+func renderFailoverConfig(peers []FailoverPeerConfig) (string, error) {
+	var b strings.Builder
+
+	for _, p := range peers {
+		p.setDefaults()
+
+		if err := p.validate(); err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&b, "failover peer %q {\n", p.Name)
+		fmt.Fprintf(&b, "    %s;\n", p.Role)
+		fmt.Fprintf(&b, "    address %s;\n", p.Address)
+		fmt.Fprintf(&b, "    port %d;\n", p.Port)
+		fmt.Fprintf(&b, "    peer address %s;\n", p.PeerAddress)
+		fmt.Fprintf(&b, "    peer port %d;\n", p.PeerPort)
+		fmt.Fprintf(&b, "    max-response-delay %d;\n", p.MaxResponseDelay)
+		fmt.Fprintf(&b, "    max-unacked-updates %d;\n", p.MaxUnackedUpdates)
+
+		if p.Role == FailoverRolePrimary {
+			fmt.Fprintf(&b, "    mclt %d;\n", p.MCLT)
+			fmt.Fprintf(&b, "    split %d;\n", p.Split)
+		}
+
+		fmt.Fprintf(&b, "    load balance max seconds %d;\n", p.LoadBalanceMaxSeconds)
+		b.WriteString("}\n")
+	}
+
+	return b.String(), nil
+}
+
+// ApplyFailoverConfigParam is the activity parameter for
+// apply-dhcp-failover-config
+type ApplyFailoverConfigParam struct {
+	Peers []FailoverPeerConfig `json:"peers"`
+}
+
+// configureFailover writes failover peer declarations, which are included by
+// dhcpd.conf rendered by the Region. dhcpd has to be restarted afterwards.
+func (s *DHCPService) configureFailover(_ context.Context, param ApplyFailoverConfigParam) error {
+	config, err := renderFailoverConfig(param.Peers)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return s.writeConfigFile(dhcpdFailoverConfigName, []byte(config))
+}
+
+// FailoverPeerParam is the activity parameter for activities operating on
+// a failover peer
+type FailoverPeerParam struct {
+	Secret string `json:"secret"`
+	Name   string `json:"name"`
+}
+
+// FailoverStatus is the state of a failover pair as seen by this agent
+type FailoverStatus struct {
+	Name         string `json:"name"`
+	LocalState   string `json:"local_state"`
+	PartnerState string `json:"partner_state"`
+	Primary      bool   `json:"primary"`
+	// Healthy is true when both servers are in normal state
+	Healthy bool `json:"healthy"`
+}
+
+func (s *DHCPService) withOMAPIv4(secret string, fn func(omapi.OMAPI) error) (err error) {
+	if !s.runningV4.Load() {
+		return ErrV4NotActive
+	}
+
+	conn, err := s.omapiConnFactory("tcp", dhcpdOMAPIV4Endpoint)
+	if err != nil {
+		return err
+	}
+
+	authenticator := omapi.NewHMACMD5Authenticator("omapi_key", secret)
+
+	client, err := s.omapiClientFactory(conn, &authenticator)
+	if err != nil {
+		//nolint:errcheck // we already return a more important error
+		conn.Close()
+		return err
+	}
+
+	defer func() {
+		cErr := client.Close()
+		if err == nil && cErr != nil {
+			err = cErr
+		}
+	}()
+
+	return fn(client)
+}
+
+func (s *DHCPService) getFailoverStatus(_ context.Context, param FailoverPeerParam) (*FailoverStatus, error) {
+	var peer omapi.FailoverPeer
+
+	err := s.withOMAPIv4(param.Secret, func(client omapi.OMAPI) (err error) {
+		peer, err = client.GetFailoverPeer(param.Name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &FailoverStatus{
+		Name:         param.Name,
+		LocalState:   peer.LocalState.String(),
+		PartnerState: peer.PartnerState.String(),
+		Primary:      peer.Primary,
+		Healthy: peer.LocalState == omapi.FailoverStateNormal &&
+			peer.PartnerState == omapi.FailoverStateNormal,
+	}, nil
+}
+
+// promoteFailover puts the local server into partner-down state, so that it
+// serves the whole pool. It must only be used when the partner is down,
+// otherwise both servers might allocate the same addresses.
+func (s *DHCPService) promoteFailover(_ context.Context, param FailoverPeerParam) error {
+	return s.withOMAPIv4(param.Secret, func(client omapi.OMAPI) error {
+		return client.SetFailoverState(param.Name, omapi.FailoverStatePartnerDown)
+	})
+}
+
+// MonitorFailoverParam is the parameter of monitor-dhcp-failover workflow.
+// It is also carried over when the workflow continues as new.
+type MonitorFailoverParam struct {
+	// InterruptedSince is when communication with the partner was first
+	// observed as interrupted
+	InterruptedSince time.Time             `json:"interrupted_since,omitempty"`
+	LastReported     *FailoverHealthReport `json:"last_reported,omitempty"`
+	Name             string                `json:"name"`
+	Secret           string                `json:"secret"`
+	PeerSystemID     string                `json:"peer_system_id"`
+	Interval         time.Duration         `json:"interval"`
+	PromoteAfter     time.Duration         `json:"promote_after"`
+	AutoPromote      bool                  `json:"auto_promote"`
+}
+
+// FailoverHealthReport is reported to the Region whenever health of the
+// failover pair changes
+type FailoverHealthReport struct {
+	SystemID string `json:"system_id"`
+	Error    string `json:"error,omitempty"`
+	FailoverStatus
+	PeerReachable bool `json:"peer_reachable"`
+	Promoted      bool `json:"promoted"`
+}
+
+func (s *DHCPService) localContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:           fmt.Sprintf("%s@agent:main", s.systemID),
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+}
+
+// peerContext is used to check whether the partner agent is alive. It is not
+// retried, as a timeout is the answer we are looking for.
+func peerContext(ctx tworkflow.Context, systemID string) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              fmt.Sprintf("%s@agent:main", systemID),
+		ScheduleToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 1,
+		},
+	})
+}
+
+func regionContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              "region",
+		ScheduleToCloseTimeout: 60 * time.Second,
+	})
+}
+
+// monitorFailover periodically checks the state of a failover pair and
+// reports changes of its health to the Region.
+//
+// When communication with the partner has been interrupted for PromoteAfter
+// and the partner agent cannot report the state of its dhcpd either, the
+// partner is assumed to be down and the local server is promoted to
+// partner-down (if AutoPromote is set). If the partner agent reports the
+// state, only the link between the dhcpd servers is broken and promotion
+// would cause conflicting allocations.
+func (s *DHCPService) monitorFailover(ctx tworkflow.Context, param MonitorFailoverParam) error {
+	if param.Interval <= 0 {
+		param.Interval = defaultFailoverMonitorInterval
+	}
+
+	if param.PromoteAfter <= 0 {
+		param.PromoteAfter = defaultFailoverPromoteAfter
+	}
+
+	opts := wf.MonitorOptions{Interval: param.Interval}
+
+	return wf.Monitor(ctx, "monitor-dhcp-failover", param, opts,
+		func(ctx tworkflow.Context, p *MonitorFailoverParam) (bool, error) {
+			return false, s.checkFailover(ctx, p)
+		})
+}
+
+func (s *DHCPService) checkFailover(ctx tworkflow.Context, p *MonitorFailoverParam) error {
+	log := tworkflow.GetLogger(ctx)
+	peer := FailoverPeerParam{Secret: p.Secret, Name: p.Name}
+	report := FailoverHealthReport{SystemID: s.systemID, PeerReachable: true}
+
+	var status FailoverStatus
+
+	err := tworkflow.ExecuteActivity(s.localContext(ctx), "get-dhcp-failover-status", peer).
+		Get(ctx, &status)
+	if err != nil {
+		// dhcpd might be restarting, report and check again later
+		report.Name = p.Name
+		report.Error = err.Error()
+
+		return s.reportFailoverHealth(ctx, p, report)
+	}
+
+	report.FailoverStatus = status
+
+	if status.LocalState != omapi.FailoverStateCommunicationsInterrupted.String() {
+		p.InterruptedSince = time.Time{}
+		return s.reportFailoverHealth(ctx, p, report)
+	}
+
+	now := tworkflow.Now(ctx)
+
+	if p.InterruptedSince.IsZero() {
+		p.InterruptedSince = now
+	}
+
+	if !p.AutoPromote || p.PeerSystemID == "" || now.Sub(p.InterruptedSince) < p.PromoteAfter {
+		return s.reportFailoverHealth(ctx, p, report)
+	}
+
+	report.PeerReachable = tworkflow.ExecuteActivity(peerContext(ctx, p.PeerSystemID),
+		"get-dhcp-failover-status", peer).Get(ctx, nil) == nil
+
+	if report.PeerReachable {
+		log.Warn("DHCP failover partner is interrupted, but its agent is reachable",
+			tag.Builder().KV("peer", p.Name).KV("peer_system_id", p.PeerSystemID).KeyVals...)
+
+		return s.reportFailoverHealth(ctx, p, report)
+	}
+
+	log.Warn("Promoting DHCP failover peer to partner-down",
+		tag.Builder().KV("peer", p.Name).KV("peer_system_id", p.PeerSystemID).KeyVals...)
+
+	if err := tworkflow.ExecuteActivity(s.localContext(ctx), "promote-dhcp-failover", peer).
+		Get(ctx, nil); err != nil {
+		report.Error = err.Error()
+		return s.reportFailoverHealth(ctx, p, report)
+	}
+
+	report.Promoted = true
+	report.LocalState = omapi.FailoverStatePartnerDown.String()
+	p.InterruptedSince = time.Time{}
+
+	return s.reportFailoverHealth(ctx, p, report)
+}
+
+// reportFailoverHealth reports health to the Region, unless it is the same
+// as the last reported one.
+func (s *DHCPService) reportFailoverHealth(ctx tworkflow.Context, p *MonitorFailoverParam,
+	report FailoverHealthReport) error {
+	if p.LastReported != nil && *p.LastReported == report {
+		return nil
+	}
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "report-dhcp-failover-health",
+		report).Get(ctx, nil); err != nil {
+		return err
+	}
+
+	p.LastReported = &report
+
+	return nil
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+func TestRenderFailoverConfig(t *testing.T) {
+	testcases := map[string]struct {
+		in  []FailoverPeerConfig
+		out string
+		err error
+	}{
+		"primary with defaults": {
+			in: []FailoverPeerConfig{{
+				Name: "failover-vlan-5001", Role: FailoverRolePrimary,
+				Address: "10.0.0.2", PeerAddress: "10.0.0.3",
+			}},
+			out: `failover peer "failover-vlan-5001" {
+    primary;
+    address 10.0.0.2;
+    port 647;
+    peer address 10.0.0.3;
+    peer port 647;
+    max-response-delay 60;
+    max-unacked-updates 10;
+    mclt 3600;
+    split 128;
+    load balance max seconds 3;
+}
+`,
+		},
+		"secondary": {
+			in: []FailoverPeerConfig{{
+				Name: "failover-vlan-5001", Role: FailoverRoleSecondary,
+				Address: "10.0.0.3", PeerAddress: "10.0.0.2", Port: 847, PeerPort: 647,
+				MaxResponseDelay: 30, MaxUnackedUpdates: 5, LoadBalanceMaxSeconds: 5,
+			}},
+			out: `failover peer "failover-vlan-5001" {
+    secondary;
+    address 10.0.0.3;
+    port 847;
+    peer address 10.0.0.2;
+    peer port 647;
+    max-response-delay 30;
+    max-unacked-updates 5;
+    load balance max seconds 5;
+}
+`,
+		},
+		"no peers": {},
+		"invalid name": {
+			in: []FailoverPeerConfig{{
+				Name: `x" { }`, Role: FailoverRolePrimary, Address: "10.0.0.2", PeerAddress: "10.0.0.3",
+			}},
+			err: ErrInvalidFailoverConfig,
+		},
+		"invalid role": {
+			in: []FailoverPeerConfig{{
+				Name: "x", Role: "leader", Address: "10.0.0.2", PeerAddress: "10.0.0.3",
+			}},
+			err: ErrInvalidFailoverConfig,
+		},
+		"IPv6 address": {
+			in: []FailoverPeerConfig{{
+				Name: "x", Role: FailoverRolePrimary, Address: "fd00::2", PeerAddress: "fd00::3",
+			}},
+			err: ErrInvalidFailoverConfig,
+		},
+		"invalid split": {
+			in: []FailoverPeerConfig{{
+				Name: "x", Role: FailoverRolePrimary, Address: "10.0.0.2", PeerAddress: "10.0.0.3",
+				Split: 300,
+			}},
+			err: ErrInvalidFailoverConfig,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := renderFailoverConfig(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+// Region activities are implemented in Python, hence dummy activities
+// are required to match function signatures.
+func reportFailoverHealthActivity(_ context.Context, _ FailoverHealthReport) error {
+	return nil
+}
+
+func TestCheckFailover(t *testing.T) {
+	interrupted := FailoverStatus{
+		Name:         "failover",
+		LocalState:   "communications-interrupted",
+		PartnerState: "unknown",
+		Primary:      true,
+	}
+	healthy := FailoverStatus{
+		Name:         "failover",
+		LocalState:   "normal",
+		PartnerState: "normal",
+		Primary:      true,
+		Healthy:      true,
+	}
+	errUnreachable := errors.New("activity timeout")
+
+	testcases := map[string]struct {
+		// statuses are returned by get-dhcp-failover-status in order,
+		// a nil status returns errUnreachable
+		statuses []*FailoverStatus
+		reports  []FailoverHealthReport
+		promoted bool
+	}{
+		"healthy is reported once": {
+			statuses: []*FailoverStatus{&healthy, &healthy, &healthy},
+			reports: []FailoverHealthReport{
+				{SystemID: "agent", FailoverStatus: healthy, PeerReachable: true},
+			},
+		},
+		"promote when partner is down": {
+			statuses: []*FailoverStatus{&interrupted, &interrupted, nil, &interrupted},
+			reports: []FailoverHealthReport{
+				{SystemID: "agent", FailoverStatus: interrupted, PeerReachable: true},
+				{
+					SystemID: "agent",
+					FailoverStatus: FailoverStatus{
+						Name:         "failover",
+						LocalState:   "partner-down",
+						PartnerState: "unknown",
+						Primary:      true,
+					},
+					Promoted: true,
+				},
+				{SystemID: "agent", FailoverStatus: interrupted, PeerReachable: true},
+			},
+			promoted: true,
+		},
+		"no promotion when partner agent is reachable": {
+			statuses: []*FailoverStatus{&interrupted, &interrupted, &healthy, &healthy},
+			reports: []FailoverHealthReport{
+				{SystemID: "agent", FailoverStatus: interrupted, PeerReachable: true},
+				{SystemID: "agent", FailoverStatus: healthy, PeerReachable: true},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			svc := NewDHCPService("agent", nil, nil)
+
+			wfTestSuite := testsuite.WorkflowTestSuite{}
+			wfTestSuite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+			env := wfTestSuite.NewTestWorkflowEnvironment()
+
+			env.RegisterActivityWithOptions(svc.getFailoverStatus,
+				activity.RegisterOptions{Name: "get-dhcp-failover-status"})
+			env.RegisterActivityWithOptions(svc.promoteFailover,
+				activity.RegisterOptions{Name: "promote-dhcp-failover"})
+			env.RegisterActivityWithOptions(reportFailoverHealthActivity,
+				activity.RegisterOptions{Name: "report-dhcp-failover-health"})
+
+			statuses := tc.statuses
+
+			env.OnActivity("get-dhcp-failover-status", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, _ FailoverPeerParam) (*FailoverStatus, error) {
+					status := statuses[0]
+					statuses = statuses[1:]
+
+					if status == nil {
+						return nil, errUnreachable
+					}
+
+					return status, nil
+				})
+
+			promoted := false
+
+			env.OnActivity("promote-dhcp-failover", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, _ FailoverPeerParam) error {
+					promoted = true
+					return nil
+				})
+
+			var reports []FailoverHealthReport
+
+			env.OnActivity("report-dhcp-failover-health", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, r FailoverHealthReport) error {
+					reports = append(reports, r)
+					return nil
+				})
+
+			// Run checks until all statuses are consumed
+			env.ExecuteWorkflow(func(ctx tworkflow.Context, p MonitorFailoverParam) error {
+				for len(statuses) > 0 {
+					if err := svc.checkFailover(ctx, &p); err != nil {
+						return err
+					}
+
+					if err := tworkflow.Sleep(ctx, p.Interval); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			}, MonitorFailoverParam{
+				Name:         "failover",
+				PeerSystemID: "peer",
+				Interval:     time.Minute,
+				PromoteAfter: time.Minute,
+				AutoPromote:  true,
+			})
+
+			assert.True(t, env.IsWorkflowCompleted())
+			assert.NoError(t, env.GetWorkflowError())
+			assert.Equal(t, tc.reports, reports)
+			assert.Equal(t, tc.promoted, promoted)
+		})
+	}
+}
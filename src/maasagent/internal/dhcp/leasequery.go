@@ -0,0 +1,208 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dhcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+
+	"go.temporal.io/sdk/temporal"
+	"maas.io/core/src/maasagent/internal/dhcpd"
+)
+
+var (
+	// ErrInvalidLeaseFilter is returned when lease query cannot be parsed
+	ErrInvalidLeaseFilter = errors.New("invalid lease filter")
+)
+
+// LeaseFilter selects leases returned by a lease query. Empty fields match
+// all leases.
+type LeaseFilter struct {
+	// Subnet in CIDR notation
+	Subnet string `json:"subnet"`
+	MAC    string `json:"mac"`
+	// State is the binding state, e.g. active
+	State string `json:"state"`
+	// Limit is the maximum number of returned leases, 0 means no limit
+	Limit int `json:"limit"`
+}
+
+// QueryLeasesResult is the result of query-dhcp-leases
+type QueryLeasesResult struct {
+	Leases []dhcpd.Lease `json:"leases"`
+	// Truncated is set when more leases matched than Limit
+	Truncated bool `json:"truncated"`
+}
+
+type leaseMatcher struct {
+	mac    string
+	state  string
+	subnet netip.Prefix
+}
+
+func (f LeaseFilter) matcher() (*leaseMatcher, error) {
+	m := &leaseMatcher{state: f.State}
+
+	if f.Subnet != "" {
+		prefix, err := netip.ParsePrefix(f.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("%w: subnet %q", ErrInvalidLeaseFilter, f.Subnet)
+		}
+
+		m.subnet = prefix.Masked()
+	}
+
+	if f.MAC != "" {
+		mac, err := net.ParseMAC(f.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("%w: MAC %q", ErrInvalidLeaseFilter, f.MAC)
+		}
+
+		m.mac = mac.String()
+	}
+
+	if f.Limit < 0 {
+		return nil, fmt.Errorf("%w: limit %d", ErrInvalidLeaseFilter, f.Limit)
+	}
+
+	return m, nil
+}
+
+func (m *leaseMatcher) match(l dhcpd.Lease) bool {
+	if m.subnet.IsValid() && !m.subnet.Contains(l.IP) {
+		return false
+	}
+
+	if m.mac != "" && m.mac != l.MAC {
+		return false
+	}
+
+	return m.state == "" || m.state == l.State
+}
+
+// leases returns leases from databases of dhcpd and dhcpd6 matching filter
+func (s *DHCPService) leases(filter LeaseFilter) (*QueryLeasesResult, error) {
+	m, err := filter.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryLeasesResult{Leases: []dhcpd.Lease{}}
+
+	for _, path := range leaseFiles {
+		leases, err := parseLeaseFile(s.dataPathFactory(path))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range leases {
+			if m.match(l) {
+				result.Leases = append(result.Leases, l)
+			}
+		}
+	}
+
+	sort.Slice(result.Leases, func(i, j int) bool {
+		return result.Leases[i].IP.Less(result.Leases[j].IP)
+	})
+
+	if filter.Limit > 0 && len(result.Leases) > filter.Limit {
+		result.Leases = result.Leases[:filter.Limit]
+		result.Truncated = true
+	}
+
+	return result, nil
+}
+
+func parseLeaseFile(path string) ([]dhcpd.Lease, error) {
+	f, err := os.Open(path) //nolint:gosec // path is not user provided
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer f.Close()
+
+	return dhcpd.ParseLeases(f)
+}
+
+func (s *DHCPService) queryLeases(_ context.Context, filter LeaseFilter) (*QueryLeasesResult, error) {
+	result, err := s.leases(filter)
+	if errors.Is(err, ErrInvalidLeaseFilter) {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return result, err
+}
+
+// LeasesHandler returns handler serving current leases as JSON. Leases can
+// be filtered with subnet, mac, state and limit query parameters, e.g.
+// GET /dhcp/leases?subnet=10.0.0.0/24&state=active
+func (s *DHCPService) LeasesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+		filter := LeaseFilter{
+			Subnet: q.Get("subnet"),
+			MAC:    q.Get("mac"),
+			State:  q.Get("state"),
+		}
+
+		if limit := q.Get("limit"); limit != "" {
+			var err error
+
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s: limit %q", ErrInvalidLeaseFilter, limit),
+					http.StatusBadRequest)
+
+				return
+			}
+		}
+
+		result, err := s.leases(filter)
+		if errors.Is(err, ErrInvalidLeaseFilter) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing we can do if the client went away
+		json.NewEncoder(w).Encode(result)
+	})
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dhcp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testQueryLeases = testLeases + `
+lease 10.0.1.20 {
+  starts 3 2024/08/14 10:00:00;
+  ends 3 2024/08/14 10:10:00;
+  binding state free;
+  hardware ethernet 00:16:3e:00:00:20;
+}
+`
+
+func TestLeases(t *testing.T) {
+	svc, dataPath, _ := newLeaseTestService(t)
+	path := filepath.Join(dataPath, "dhcp", "dhcpd.leases")
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+	require.NoError(t, os.WriteFile(path, []byte(testQueryLeases), 0o600))
+
+	testcases := map[string]struct {
+		filter    LeaseFilter
+		out       []string
+		truncated bool
+		err       error
+	}{
+		"all": {
+			out: []string{"10.0.0.10", "10.0.1.20"},
+		},
+		"subnet": {
+			filter: LeaseFilter{Subnet: "10.0.1.0/24"},
+			out:    []string{"10.0.1.20"},
+		},
+		"mac": {
+			filter: LeaseFilter{MAC: "00-16-3E-00-00-10"},
+			out:    []string{"10.0.0.10"},
+		},
+		"state": {
+			filter: LeaseFilter{State: "free"},
+			out:    []string{"10.0.1.20"},
+		},
+		"no match": {
+			filter: LeaseFilter{Subnet: "192.168.0.0/16"},
+			out:    []string{},
+		},
+		"limit": {
+			filter:    LeaseFilter{Limit: 1},
+			out:       []string{"10.0.0.10"},
+			truncated: true,
+		},
+		"invalid subnet": {
+			filter: LeaseFilter{Subnet: "10.0.0.0"},
+			err:    ErrInvalidLeaseFilter,
+		},
+		"invalid mac": {
+			filter: LeaseFilter{MAC: "foo"},
+			err:    ErrInvalidLeaseFilter,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := svc.leases(tc.filter)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			ips := []string{}
+			for _, l := range res.Leases {
+				ips = append(ips, l.IP.String())
+			}
+
+			assert.Equal(t, tc.out, ips)
+			assert.Equal(t, tc.truncated, res.Truncated)
+		})
+	}
+}
+
+func TestLeasesHandler(t *testing.T) {
+	svc, dataPath, _ := newLeaseTestService(t)
+	path := filepath.Join(dataPath, "dhcp", "dhcpd.leases")
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+	require.NoError(t, os.WriteFile(path, []byte(testQueryLeases), 0o600))
+
+	testcases := map[string]struct {
+		method string
+		url    string
+		status int
+		count  int
+	}{
+		"filtered": {
+			method: http.MethodGet,
+			url:    "/dhcp/leases?state=active",
+			status: http.StatusOK,
+			count:  1,
+		},
+		"invalid filter": {
+			method: http.MethodGet,
+			url:    "/dhcp/leases?subnet=foo",
+			status: http.StatusBadRequest,
+		},
+		"invalid limit": {
+			method: http.MethodGet,
+			url:    "/dhcp/leases?limit=foo",
+			status: http.StatusBadRequest,
+		},
+		"method not allowed": {
+			method: http.MethodPost,
+			url:    "/dhcp/leases",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := httptest.NewRecorder()
+			svc.LeasesHandler().ServeHTTP(rec, httptest.NewRequest(tc.method, tc.url, nil))
+
+			assert.Equal(t, tc.status, rec.Code)
+
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var res QueryLeasesResult
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Len(t, res.Leases, tc.count)
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dhcp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"maas.io/core/src/maasagent/internal/atomicfile"
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+const (
+	leaseBackupPrefix = "dhcp-leases"
+	// maxLeaseFileSize limits the size of a restored lease file
+	maxLeaseFileSize = 512 << 20
+)
+
+// leaseFiles are lease databases of dhcpd and dhcpd6 relative to data path
+var leaseFiles = map[string]string{
+	"dhcpd.leases":  "dhcp/dhcpd.leases",
+	"dhcpd6.leases": "dhcp/dhcpd6.leases",
+}
+
+var (
+	// ErrNoArtifactStore is returned when lease backup is requested, but
+	// the service has no artifact store
+	ErrNoArtifactStore = errors.New("artifact store is not configured")
+	// ErrInvalidLeaseBackup is returned when the backup cannot be restored
+	ErrInvalidLeaseBackup = errors.New("invalid lease backup")
+)
+
+// WithArtifactStore allows setting the store used for lease backups.
+func WithArtifactStore(store blob.Store) DHCPServiceOption {
+	return func(s *DHCPService) {
+		s.artifacts = store
+	}
+}
+
+// BackupLeasesResult is the result of backup-dhcp-leases
+type BackupLeasesResult struct {
+	// Key of the backup in the artifact store, empty if there were no leases
+	Key    string   `json:"key"`
+	SHA256 string   `json:"sha256"`
+	Files  []string `json:"files"`
+	Size   int64    `json:"size"`
+}
+
+// RestoreLeasesParam is the activity parameter for restore-dhcp-leases
+type RestoreLeasesParam struct {
+	Key string `json:"key"`
+}
+
+// completeLeases strips an incomplete statement from the end of a lease
+// database, which might be there if dhcpd was writing it at the moment.
+// Lease databases consist of top-level statements, which either end with
+// ";" or "}" at the beginning of a line.
+func completeLeases(data []byte) []byte {
+	end := 0
+
+	for i := 0; i < len(data); {
+		n := bytes.IndexByte(data[i:], '\n')
+		if n < 0 {
+			break
+		}
+
+		line := bytes.TrimRight(data[i:i+n], " \t\r")
+		i += n + 1
+
+		if len(line) == 0 {
+			end = i
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		if line[0] == '#' || bytes.HasSuffix(line, []byte(";")) || bytes.Equal(line, []byte("}")) {
+			end = i
+		}
+	}
+
+	return data[:end]
+}
+
+// archiveLeases returns gzipped tar with lease databases
+func archiveLeases(files map[string][]byte, modTime time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(files[name])),
+			ModTime: modTime,
+		}); err != nil {
+			return nil, err
+		}
+
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// extractLeases returns lease databases from the archive. Unknown entries
+// are rejected, so a backup cannot write outside of lease files.
+func extractLeases(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLeaseBackup, err)
+	}
+
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidLeaseBackup, err)
+		}
+
+		if _, ok := leaseFiles[hdr.Name]; !ok || hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: unexpected entry %q", ErrInvalidLeaseBackup, hdr.Name)
+		}
+
+		if hdr.Size > maxLeaseFileSize {
+			return nil, fmt.Errorf("%w: %s is too large", ErrInvalidLeaseBackup, hdr.Name)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(tr, maxLeaseFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidLeaseBackup, err)
+		}
+
+		files[hdr.Name] = data
+	}
+
+	return files, nil
+}
+
+// backupLeases snapshots lease databases into the artifact store, so they
+// can be restored when the Agent host is rebuilt.
+func (s *DHCPService) backupLeases(ctx context.Context) (*BackupLeasesResult, error) {
+	if s.artifacts == nil {
+		return nil, temporal.NewNonRetryableApplicationError(ErrNoArtifactStore.Error(), "",
+			ErrNoArtifactStore)
+	}
+
+	files := make(map[string][]byte)
+
+	for name, path := range leaseFiles {
+		data, err := os.ReadFile(s.dataPathFactory(path))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		files[name] = completeLeases(data)
+	}
+
+	result := &BackupLeasesResult{Files: []string{}}
+
+	if len(files) == 0 {
+		return result, nil
+	}
+
+	now := time.Now()
+
+	archive, err := archiveLeases(files, now)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%s/%d.tar.gz", leaseBackupPrefix, s.systemID, now.Unix())
+
+	if err := s.artifacts.Put(ctx, key, bytes.NewReader(archive), int64(len(archive))); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(archive)
+
+	for name := range files {
+		result.Files = append(result.Files, name)
+	}
+
+	sort.Strings(result.Files)
+
+	result.Key = key
+	result.SHA256 = hex.EncodeToString(sum[:])
+	result.Size = int64(len(archive))
+
+	return result, nil
+}
+
+// restoreLeases replaces lease databases with the backup. dhcpd is stopped
+// while files are replaced, as it rewrites the database on its own.
+func (s *DHCPService) restoreLeases(ctx context.Context, param RestoreLeasesParam) (err error) {
+	if s.artifacts == nil {
+		return temporal.NewNonRetryableApplicationError(ErrNoArtifactStore.Error(), "",
+			ErrNoArtifactStore)
+	}
+
+	if !strings.HasPrefix(param.Key, leaseBackupPrefix+"/") {
+		err := fmt.Errorf("%w: key %q", ErrInvalidLeaseBackup, param.Key)
+		return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	r, err := s.artifacts.Get(ctx, param.Key)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+		}
+
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer r.Close()
+
+	files, err := extractLeases(r)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	if err := s.controllerV4.Stop(ctx); err != nil {
+		return err
+	}
+
+	if err := s.controllerV6.Stop(ctx); err != nil {
+		return err
+	}
+
+	defer func() {
+		if s.runningV4.Load() {
+			err = errors.Join(err, s.controllerV4.Start(ctx))
+		}
+
+		if s.runningV6.Load() {
+			err = errors.Join(err, s.controllerV6.Start(ctx))
+		}
+	}()
+
+	for name, data := range files {
+		path := s.dataPathFactory(leaseFiles[name])
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return err
+		}
+
+		if err := atomicfile.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dhcp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+const testLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+# This lease file was written by isc-dhcp-4.4.1
+
+# authoring-byte-order entry is generated, DO NOT DELETE
+authoring-byte-order little-endian;
+
+server-duid "\000\001\000\001,\016\254\312\000\026>\000\000\001";
+
+lease 10.0.0.10 {
+  starts 3 2024/08/14 10:00:00;
+  ends 3 2024/08/14 10:10:00;
+  binding state active;
+  hardware ethernet 00:16:3e:00:00:10;
+}
+`
+
+func TestCompleteLeases(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out string
+	}{
+		"complete": {
+			in:  testLeases,
+			out: testLeases,
+		},
+		"incomplete lease": {
+			in:  testLeases + "lease 10.0.0.11 {\n  starts 3 2024/08/14 10:00:00;\n",
+			out: testLeases,
+		},
+		"incomplete line": {
+			in:  testLeases + "lease 10.0.0.11 {\n  sta",
+			out: testLeases,
+		},
+		"empty": {},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, string(completeLeases([]byte(tc.in))))
+		})
+	}
+}
+
+func TestExtractLeases(t *testing.T) {
+	archive, err := archiveLeases(map[string][]byte{
+		"dhcpd.leases":  []byte(testLeases),
+		"dhcpd6.leases": {},
+	}, time.Now())
+	require.NoError(t, err)
+
+	files, err := extractLeases(bytes.NewReader(archive))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"dhcpd.leases":  []byte(testLeases),
+		"dhcpd6.leases": {},
+	}, files)
+
+	archive, err = archiveLeases(map[string][]byte{"../../etc/passwd": {}}, time.Now())
+	require.NoError(t, err)
+
+	_, err = extractLeases(bytes.NewReader(archive))
+	assert.ErrorIs(t, err, ErrInvalidLeaseBackup)
+
+	_, err = extractLeases(strings.NewReader("not an archive"))
+	assert.ErrorIs(t, err, ErrInvalidLeaseBackup)
+}
+
+func newLeaseTestService(t *testing.T) (*DHCPService, string, *MockDHCPController) {
+	dataPath := t.TempDir()
+
+	store, err := blob.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	controllerV4 := NewMockDHCPController("dhcpd")
+
+	svc := NewDHCPService("agent", controllerV4, NewMockDHCPController("dhcpd6"),
+		WithArtifactStore(store),
+		WithDataPathFactory(func(path string) string {
+			return filepath.Join(dataPath, path)
+		}),
+	)
+
+	return svc, dataPath, controllerV4
+}
+
+func TestBackupAndRestoreLeases(t *testing.T) {
+	svc, dataPath, controllerV4 := newLeaseTestService(t)
+	leases := filepath.Join(dataPath, "dhcp", "dhcpd.leases")
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(leases), 0o750))
+	require.NoError(t, os.WriteFile(leases, []byte(testLeases+"lease 10.0.0.11 {\n"), 0o644))
+
+	result, err := svc.backupLeases(context.Background())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(result.Key, "dhcp-leases/agent/"))
+	assert.Equal(t, []string{"dhcpd.leases"}, result.Files)
+	assert.NotEmpty(t, result.SHA256)
+
+	// Agent host is rebuilt
+	require.NoError(t, os.RemoveAll(filepath.Join(dataPath, "dhcp")))
+
+	svc.runningV4.Store(true)
+
+	require.NoError(t, svc.restoreLeases(context.Background(), RestoreLeasesParam{Key: result.Key}))
+
+	data, err := os.ReadFile(leases)
+	require.NoError(t, err)
+	assert.Equal(t, testLeases, string(data))
+
+	// dhcpd6 is not running, so only dhcpd is started again
+	assert.True(t, controllerV4.started)
+
+	_, err = os.Stat(filepath.Join(dataPath, "dhcp", "dhcpd6.leases"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestBackupLeasesEmpty(t *testing.T) {
+	svc, _, _ := newLeaseTestService(t)
+
+	result, err := svc.backupLeases(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &BackupLeasesResult{Files: []string{}}, result)
+}
+
+func TestRestoreLeasesInvalidKey(t *testing.T) {
+	svc, _, _ := newLeaseTestService(t)
+
+	err := svc.restoreLeases(context.Background(), RestoreLeasesParam{Key: "capture/agent/root.img"})
+	assert.ErrorIs(t, err, ErrInvalidLeaseBackup)
+
+	err = svc.restoreLeases(context.Background(), RestoreLeasesParam{Key: "dhcp-leases/agent/0.tar.gz"})
+	assert.ErrorIs(t, err, blob.ErrNotFound)
+}
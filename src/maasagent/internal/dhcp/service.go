@@ -16,6 +16,7 @@
 package dhcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -25,6 +26,9 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -34,6 +38,8 @@ import (
 	tworkflow "go.temporal.io/sdk/workflow"
 	"maas.io/core/src/maasagent/internal/apiclient"
 	"maas.io/core/src/maasagent/internal/atomicfile"
+	"maas.io/core/src/maasagent/internal/backpressure"
+	"maas.io/core/src/maasagent/internal/blob"
 	"maas.io/core/src/maasagent/internal/dhcpd"
 	"maas.io/core/src/maasagent/internal/dhcpd/omapi"
 	"maas.io/core/src/maasagent/internal/pathutil"
@@ -57,17 +63,29 @@ var (
 type DHCPService struct {
 	fatal              chan error
 	client             *apiclient.APIClient
+	artifacts          blob.Store
+	pressure           *backpressure.Controller
+	observer           func([]*dhcpd.Notification)
 	notificationSock   net.Conn
 	notificationCancel context.CancelFunc
 	omapiConnFactory   omapiConnFactory
 	omapiClientFactory omapiClientFactory
 	dataPathFactory    dataPathFactory
+	interfaceResolver  interfaceResolver
 	controllerV4       servicecontroller.Controller
 	controllerV6       servicecontroller.Controller
 	runningV4          *atomic.Bool
 	runningV6          *atomic.Bool
 	running            *atomic.Bool
-	systemID           string
+	// changedV4 and changedV6 are set when configuration dhcpd depends on
+	// changed since it was restarted
+	changedV4 *atomic.Bool
+	changedV6 *atomic.Bool
+	// interfaceIndexes are indexes of interfaces dhcpd listens on, by name
+	// of the interfaces file
+	interfaceIndexes map[string]string
+	mutex            sync.Mutex
+	systemID         string
 }
 
 type omapiConnFactory func(string, string) (net.Conn, error)
@@ -76,6 +94,10 @@ type omapiClientFactory func(net.Conn, omapi.Authenticator) (omapi.OMAPI, error)
 
 type dataPathFactory func(string) string
 
+// interfaceResolver maps whitespace separated interface names to those
+// dhcpd should listen on.
+type interfaceResolver func(context.Context, string) (string, error)
+
 type DHCPServiceOption func(*DHCPService)
 
 func NewDHCPService(
@@ -94,6 +116,9 @@ func NewDHCPService(
 		runningV4:          &atomic.Bool{},
 		runningV6:          &atomic.Bool{},
 		running:            &atomic.Bool{},
+		changedV4:          &atomic.Bool{},
+		changedV6:          &atomic.Bool{},
+		interfaceIndexes:   make(map[string]string),
 	}
 
 	for _, opt := range options {
@@ -111,6 +136,22 @@ func WithAPIClient(c *apiclient.APIClient) DHCPServiceOption {
 	}
 }
 
+// WithBackpressure sets Controller used to report lease notifications in
+// bigger batches when the Region is slow to acknowledge them.
+func WithBackpressure(c *backpressure.Controller) DHCPServiceOption {
+	return func(s *DHCPService) {
+		s.pressure = c
+	}
+}
+
+// WithNotificationObserver sets a function lease notifications of dhcpd are
+// passed to, before they are reported to the Region.
+func WithNotificationObserver(fn func([]*dhcpd.Notification)) DHCPServiceOption {
+	return func(s *DHCPService) {
+		s.observer = fn
+	}
+}
+
 func WithOMAPIConnFactory(factory omapiConnFactory) DHCPServiceOption {
 	return func(s *DHCPService) {
 		s.omapiConnFactory = factory
@@ -130,6 +171,14 @@ func WithDataPathFactory(factory dataPathFactory) DHCPServiceOption {
 	}
 }
 
+// WithInterfaceResolver allows rewriting interfaces dhcpd listens on, e.g.
+// to serve DHCP on an Open vSwitch bridge instead of its physical port.
+func WithInterfaceResolver(resolver interfaceResolver) DHCPServiceOption {
+	return func(s *DHCPService) {
+		s.interfaceResolver = resolver
+	}
+}
+
 func queueFlush(c *apiclient.APIClient, interval time.Duration) func(context.Context, []*dhcpd.Notification) error {
 	retry := backoff.NewExponentialBackOff()
 	retry.MaxElapsedTime = interval
@@ -156,7 +205,10 @@ func queueFlush(c *apiclient.APIClient, interval time.Duration) func(context.Con
 }
 
 func (s *DHCPService) ConfigurationWorkflows() map[string]interface{} {
-	return map[string]interface{}{"configure-dhcp-service": s.configure}
+	return map[string]interface{}{
+		"configure-dhcp-service": s.configure,
+		"monitor-dhcp-failover":  s.monitorFailover,
+	}
 }
 
 func (s *DHCPService) ConfigurationActivities() map[string]interface{} {
@@ -165,6 +217,12 @@ func (s *DHCPService) ConfigurationActivities() map[string]interface{} {
 		"apply-dhcp-config-via-file":  s.configureViaFile,
 		"apply-dhcp-config-via-omapi": s.configureViaOMAPI,
 		"restart-dhcp-service":        s.restartService,
+		"apply-dhcp-failover-config":  s.configureFailover,
+		"get-dhcp-failover-status":    s.getFailoverStatus,
+		"promote-dhcp-failover":       s.promoteFailover,
+		"backup-dhcp-leases":          s.backupLeases,
+		"restore-dhcp-leases":         s.restoreLeases,
+		"query-dhcp-leases":           s.queryLeases,
 	}
 }
 
@@ -240,8 +298,19 @@ func (s *DHCPService) start(ctx context.Context) error {
 		return err
 	}
 
+	flush := queueFlush(s.client, flushInterval)
+
+	if s.observer != nil {
+		report := flush
+		flush = func(ctx context.Context, n []*dhcpd.Notification) error {
+			s.observer(n)
+			return report(ctx, n)
+		}
+	}
+
 	notificationListener := dhcpd.NewNotificationListener(s.notificationSock,
-		queueFlush(s.client, flushInterval), dhcpd.WithInterval(flushInterval))
+		flush, dhcpd.WithInterval(flushInterval),
+		dhcpd.WithBackpressure(s.pressure))
 
 	ctx, s.notificationCancel = context.WithCancel(ctx)
 
@@ -441,6 +510,19 @@ func (s *DHCPService) configureViaFile(ctx context.Context) error {
 			return err
 		}
 
+		if strings.HasSuffix(file, "-interfaces") && s.interfaceResolver != nil {
+			interfaces, err := s.interfaceResolver(ctx, string(data))
+			if err != nil {
+				return err
+			}
+
+			data = []byte(interfaces)
+		}
+
+		if strings.HasSuffix(file, "-interfaces") {
+			s.updateInterfaceIndexes(file, data)
+		}
+
 		hasData := len(data) != 0
 
 		if file == "dhcpd.conf" {
@@ -474,20 +556,20 @@ func (s *DHCPService) configureViaFile(ctx context.Context) error {
 	return nil
 }
 
+// restartService restarts dhcpd to apply its configuration. dhcpd can't take
+// over sockets of a running instance, so packets sent while it restarts are
+// dropped (and retransmitted by clients). To keep that to a minimum, dhcpd is
+// only restarted if configuration it depends on changed, or if it is not
+// running.
 func (s *DHCPService) restartService(ctx context.Context) error {
-	runningV4 := s.runningV4.Load()
-	runningV6 := s.runningV6.Load()
-
-	if runningV4 {
-		err := s.controllerV4.Restart(ctx)
-		if err != nil {
+	if s.runningV4.Load() {
+		if err := restartIfChanged(ctx, s.controllerV4, s.changedV4); err != nil {
 			return err
 		}
 	}
 
-	if runningV6 {
-		err := s.controllerV6.Restart(ctx)
-		if err != nil {
+	if s.runningV6.Load() {
+		if err := restartIfChanged(ctx, s.controllerV6, s.changedV6); err != nil {
 			return err
 		}
 	}
@@ -495,6 +577,58 @@ func (s *DHCPService) restartService(ctx context.Context) error {
 	return nil
 }
 
+func restartIfChanged(ctx context.Context, controller servicecontroller.Controller,
+	changed *atomic.Bool) error {
+	if !changed.Swap(false) {
+		status, err := controller.Status(ctx)
+		if err == nil && status == servicecontroller.StatusRunning {
+			return nil
+		}
+	}
+
+	if err := controller.Restart(ctx); err != nil {
+		changed.Store(true)
+		return err
+	}
+
+	return nil
+}
+
+// updateInterfaceIndexes marks dhcpd changed when interfaces it listens on
+// were recreated, which changes their indexes but not the configuration.
+// Indexes are unknown when the Agent starts, so dhcpd is restarted once.
+func (s *DHCPService) updateInterfaceIndexes(file string, interfaces []byte) {
+	names := strings.Fields(string(interfaces))
+	indexes := make([]string, len(names))
+
+	for i, name := range names {
+		indexes[i] = "-1"
+
+		if iface, err := net.InterfaceByName(name); err == nil {
+			indexes[i] = strconv.Itoa(iface.Index)
+		}
+	}
+
+	state := strings.Join(indexes, " ")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if old, ok := s.interfaceIndexes[file]; !ok || old != state {
+		s.interfaceIndexes[file] = state
+		s.changed(file).Store(true)
+	}
+}
+
+// changed returns flag of the dhcpd the file belongs to
+func (s *DHCPService) changed(file string) *atomic.Bool {
+	if strings.HasPrefix(file, "dhcpd6") {
+		return s.changedV6
+	}
+
+	return s.changedV4
+}
+
 // getConfig retrieves the DHCP configuration from the Region Controller by
 // sending a GET request to the relevant endpoint based on the systemID.
 func (s *DHCPService) getConfig(ctx context.Context) (*dhcpConfig, error) {
@@ -518,10 +652,22 @@ func (s *DHCPService) getConfig(ctx context.Context) (*dhcpConfig, error) {
 	return &config, json.Unmarshal(body, &config)
 }
 
-func (s *DHCPService) writeConfigFile(path string, data []byte) error {
-	path = s.dataPathFactory(path)
+// writeConfigFile writes the file, unless it already has data, and marks
+// dhcpd the file belongs to changed.
+func (s *DHCPService) writeConfigFile(file string, data []byte) error {
+	path := s.dataPathFactory(file)
+
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+
+	if err := atomicfile.WriteFile(path, data, 0o640); err != nil {
+		return err
+	}
 
-	return atomicfile.WriteFile(path, data, 0o640)
+	s.changed(file).Store(true)
+
+	return nil
 }
 
 func (s *DHCPService) Error() error {
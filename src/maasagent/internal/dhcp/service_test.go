@@ -27,6 +27,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -61,7 +62,9 @@ func (m *mockOMAPIClient) AddHost(ip net.IP, mac net.HardwareAddr) error {
 }
 
 type MockDHCPController struct {
+	status    servicecontroller.ServiceStatus
 	restarted bool
+	started   bool
 }
 
 func NewMockDHCPController(service string) *MockDHCPController {
@@ -69,6 +72,7 @@ func NewMockDHCPController(service string) *MockDHCPController {
 }
 
 func (m *MockDHCPController) Start(ctx context.Context) error {
+	m.started = true
 	return nil
 }
 
@@ -82,7 +86,7 @@ func (m *MockDHCPController) Restart(ctx context.Context) error {
 }
 
 func (m *MockDHCPController) Status(ctx context.Context) (servicecontroller.ServiceStatus, error) {
-	return 0, nil
+	return m.status, nil
 }
 
 type DHCPServiceTestSuite struct {
@@ -368,6 +372,29 @@ func (s *DHCPServiceTestSuite) TestConfigureViaFile() {
 	}
 }
 
+func (s *DHCPServiceTestSuite) TestConfigureViaFileResolvesInterfaces() {
+	s.configAPIResponse = []byte(`{
+    "dhcpd": "Y29uZmlndXJhdGlvbl92NA==",
+    "dhcpd_interfaces": "aW50ZXJmYWNlc192NA==",
+    "dhcpd6": "",
+    "dhcpd6_interfaces": ""
+  }`)
+
+	s.svc.interfaceResolver = func(_ context.Context, names string) (string, error) {
+		return strings.ReplaceAll(names, "interfaces", "br"), nil
+	}
+
+	_, err := s.activityEnv.ExecuteActivity(
+		"configure-dhcp-via-file",
+	)
+
+	assert.NoError(s.T(), err)
+
+	data, err := os.ReadFile(s.svc.dataPathFactory("dhcpd-interfaces"))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []byte("br_v4"), data)
+}
+
 func TestHostMarshalJSON(t *testing.T) {
 	h := Host{
 		Hostname: "localhost",
@@ -448,6 +475,44 @@ func (s *DHCPServiceTestSuite) TestRestartDHCPServiceV6() {
 	s.False(controllerV6.restarted)
 }
 
+func (s *DHCPServiceTestSuite) TestRestartDHCPServiceWhenChanged() {
+	controllerV4 := s.svc.controllerV4.(*MockDHCPController)
+	controllerV4.status = servicecontroller.StatusRunning
+
+	configure := func(config string) {
+		s.configAPIResponse = []byte(fmt.Sprintf(`{
+    "dhcpd": %q,
+    "dhcpd_interfaces": "aW50ZXJmYWNlc192NA==",
+    "dhcpd6": "",
+    "dhcpd6_interfaces": ""
+  }`, base64.StdEncoding.EncodeToString([]byte(config))))
+
+		_, err := s.activityEnv.ExecuteActivity("configure-dhcp-via-file")
+		s.NoError(err)
+
+		controllerV4.restarted = false
+
+		_, err = s.activityEnv.ExecuteActivity("restart-dhcp-service")
+		s.NoError(err)
+	}
+
+	// Interfaces are unknown after start
+	configure("configuration_v4")
+	s.True(controllerV4.restarted)
+
+	configure("configuration_v4")
+	s.False(controllerV4.restarted)
+
+	configure("other_configuration_v4")
+	s.True(controllerV4.restarted)
+
+	// dhcpd is started again, if it is not running
+	controllerV4.status = servicecontroller.StatusStopped
+
+	configure("other_configuration_v4")
+	s.True(controllerV4.restarted)
+}
+
 type mockRoundTripper struct {
 	calledOnce bool
 	Error      error
@@ -0,0 +1,279 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dhcpd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// leaseTimeLayout is the format of times in lease databases, which are
+// always in UTC unless db-time-format is set to local
+const leaseTimeLayout = "2006/01/02 15:04:05"
+
+var (
+	// ErrMalformedLeases is returned when a lease database cannot be parsed
+	ErrMalformedLeases = errors.New("malformed lease database")
+)
+
+// Lease is the last recorded state of an address in a lease database
+type Lease struct {
+	Starts   time.Time  `json:"starts"`
+	Ends     time.Time  `json:"ends"`
+	IP       netip.Addr `json:"ip"`
+	MAC      string     `json:"mac,omitempty"`
+	Hostname string     `json:"hostname,omitempty"`
+	// State is the binding state, e.g. active, free, expired or backup
+	State string `json:"state"`
+}
+
+// ParseLeases parses dhcpd.leases(5) database of dhcpd or dhcpd6.
+// Lease databases are append-only logs, so when an address appears several
+// times only the last entry is returned. Leases are sorted by address.
+func ParseLeases(r io.Reader) ([]Lease, error) {
+	p := &leaseParser{leases: make(map[netip.Addr]Lease)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		p.line++
+
+		if err := p.parseLine(strings.TrimSpace(scanner.Text())); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrMalformedLeases, p.line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]Lease, 0, len(p.leases))
+	for _, l := range p.leases {
+		result = append(result, l)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].IP.Less(result[j].IP)
+	})
+
+	return result, nil
+}
+
+type leaseParser struct {
+	leases map[netip.Addr]Lease
+	// lease is the lease (or iaaddr) being parsed, nil outside of it
+	lease *Lease
+	// iaMAC is the MAC address derived from DUID of the enclosing IA
+	iaMAC string
+	// blocks are keywords of open blocks
+	blocks []string
+	line   int
+}
+
+func (p *leaseParser) parseLine(line string) error {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	if line == "}" {
+		return p.closeBlock()
+	}
+
+	if strings.HasSuffix(line, "{") {
+		return p.openBlock(strings.TrimSpace(strings.TrimSuffix(line, "{")))
+	}
+
+	if p.lease == nil {
+		return nil
+	}
+
+	return p.parseStatement(strings.TrimSuffix(line, ";"))
+}
+
+func (p *leaseParser) openBlock(header string) error {
+	keyword, arg, _ := strings.Cut(header, " ")
+	p.blocks = append(p.blocks, keyword)
+
+	switch {
+	case keyword == "lease" && len(p.blocks) == 1,
+		keyword == "iaaddr" && len(p.blocks) == 2 && p.inIA():
+		ip, err := netip.ParseAddr(arg)
+		if err != nil {
+			return err
+		}
+
+		p.lease = &Lease{IP: ip, MAC: p.iaMAC}
+	case (keyword == "ia-na" || keyword == "ia-ta") && len(p.blocks) == 1:
+		p.iaMAC = macFromIAID(unquote(arg))
+	}
+
+	return nil
+}
+
+func (p *leaseParser) inIA() bool {
+	return p.blocks[0] == "ia-na" || p.blocks[0] == "ia-ta"
+}
+
+func (p *leaseParser) closeBlock() error {
+	if len(p.blocks) == 0 {
+		return errors.New("unexpected }")
+	}
+
+	keyword := p.blocks[len(p.blocks)-1]
+	p.blocks = p.blocks[:len(p.blocks)-1]
+
+	switch keyword {
+	case "lease", "iaaddr":
+		if p.lease != nil {
+			p.leases[p.lease.IP] = *p.lease
+			p.lease = nil
+		}
+	case "ia-na", "ia-ta":
+		p.iaMAC = ""
+	}
+
+	return nil
+}
+
+func (p *leaseParser) parseStatement(stmt string) error {
+	switch {
+	case strings.HasPrefix(stmt, "binding state "):
+		p.lease.State = strings.TrimPrefix(stmt, "binding state ")
+	case strings.HasPrefix(stmt, "hardware ethernet "):
+		mac, err := net.ParseMAC(strings.TrimPrefix(stmt, "hardware ethernet "))
+		if err != nil {
+			return err
+		}
+
+		p.lease.MAC = mac.String()
+	case strings.HasPrefix(stmt, "client-hostname "):
+		p.lease.Hostname = unquote(strings.TrimPrefix(stmt, "client-hostname "))
+	case strings.HasPrefix(stmt, "starts "):
+		t, err := parseLeaseTime(strings.TrimPrefix(stmt, "starts "))
+		if err != nil {
+			return err
+		}
+
+		p.lease.Starts = t
+	case strings.HasPrefix(stmt, "ends "):
+		t, err := parseLeaseTime(strings.TrimPrefix(stmt, "ends "))
+		if err != nil {
+			return err
+		}
+
+		p.lease.Ends = t
+	}
+
+	return nil
+}
+
+// parseLeaseTime parses "<weekday> <date> <time>", "epoch <seconds>" or
+// "never", which is returned as zero time
+func parseLeaseTime(s string) (time.Time, error) {
+	// Local time format has a comment with the date
+	s, _, _ = strings.Cut(s, ";")
+	fields := strings.Fields(s)
+
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, nil
+	case len(fields) == 2 && fields[0] == "epoch":
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return time.Unix(sec, 0).UTC(), nil
+	case len(fields) == 3:
+		return time.Parse(leaseTimeLayout, fields[1]+" "+fields[2])
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// unquote decodes a quoted string with octal escapes as written by dhcpd
+func unquote(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+
+				continue
+			}
+		}
+
+		b.WriteByte(s[i+1])
+		i++
+	}
+
+	return b.String()
+}
+
+// macFromIAID returns MAC address from DUID-LLT or DUID-LL of the client,
+// if it has one. IA key is 4 bytes of IAID followed by DUID.
+func macFromIAID(key string) string {
+	if len(key) < 4+4 {
+		return ""
+	}
+
+	duid := key[4:]
+	duidType := uint16(duid[0])<<8 | uint16(duid[1])
+	hwType := uint16(duid[2])<<8 | uint16(duid[3])
+
+	if hwType != 1 {
+		return ""
+	}
+
+	var lladdr string
+
+	switch duidType {
+	case 1: // DUID-LLT has 4 bytes of time before the address
+		if len(duid) < 8 {
+			return ""
+		}
+
+		lladdr = duid[8:]
+	case 3: // DUID-LL
+		lladdr = duid[4:]
+	default:
+		return ""
+	}
+
+	if len(lladdr) != 6 {
+		return ""
+	}
+
+	return net.HardwareAddr(lladdr).String()
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dhcpd
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testLeasesV4 = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+# This lease file was written by isc-dhcp-4.4.1
+
+# authoring-byte-order entry is generated, DO NOT DELETE
+authoring-byte-order little-endian;
+
+server-duid "\000\001\000\001,\016\254\312\000\026>\000\000\001";
+
+lease 10.0.0.10 {
+  starts 3 2024/08/14 10:00:00;
+  ends 3 2024/08/14 10:10:00;
+  cltt 3 2024/08/14 10:00:00;
+  binding state active;
+  next binding state free;
+  rewind binding state free;
+  hardware ethernet 00:16:3E:00:00:10;
+  uid "\001\000\026>\000\000\020{";
+  client-hostname "node-1";
+}
+lease 10.0.0.11 {
+  starts epoch 1723629600; # Wed Aug 14 10:00:00 2024
+  ends never;
+  binding state backup;
+}
+lease 10.0.0.10 {
+  starts 3 2024/08/14 10:10:00;
+  ends 3 2024/08/14 10:10:00;
+  binding state free;
+  hardware ethernet 00:16:3e:00:00:10;
+}
+failover peer "failover-vlan-5001" state {
+  my state normal at 3 2024/08/14 09:00:00;
+  partner state normal at 3 2024/08/14 09:00:00;
+}
+`
+
+const testLeasesV6 = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+# This lease file was written by isc-dhcp-4.4.1
+
+server-duid "\000\001\000\001,\016\254\312\000\026>\000\000\001";
+
+ia-na "\020\000\000\000\000\001\000\001,\016\254\312\000\026>\000\000 " {
+  cltt 3 2024/08/14 10:00:00;
+  iaaddr 2001:db8::20 {
+    binding state active;
+    preferred-life 375;
+    max-life 600;
+    ends 3 2024/08/14 10:10:00;
+  }
+}
+ia-na "\020\000\000\000\000\002\000\000\000\011abcdefgh" {
+  iaaddr 2001:db8::21 {
+    binding state expired;
+    ends 3 2024/08/14 09:10:00;
+  }
+}
+ia-pd "\020\000\000\000\000\003\000\001\000\026>\000\000!" {
+  iaprefix 2001:db8:1::/64 {
+    binding state active;
+  }
+}
+`
+
+func TestParseLeases(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse(leaseTimeLayout, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tm
+	}
+
+	testcases := map[string]struct {
+		in  string
+		out []Lease
+		err error
+	}{
+		"dhcpd": {
+			in: testLeasesV4,
+			out: []Lease{
+				{
+					IP:     netip.MustParseAddr("10.0.0.10"),
+					MAC:    "00:16:3e:00:00:10",
+					State:  "free",
+					Starts: at("2024/08/14 10:10:00"),
+					Ends:   at("2024/08/14 10:10:00"),
+				},
+				{
+					IP:     netip.MustParseAddr("10.0.0.11"),
+					State:  "backup",
+					Starts: at("2024/08/14 10:00:00"),
+				},
+			},
+		},
+		"dhcpd6": {
+			in: testLeasesV6,
+			out: []Lease{
+				{
+					IP:    netip.MustParseAddr("2001:db8::20"),
+					MAC:   "00:16:3e:00:00:20",
+					State: "active",
+					Ends:  at("2024/08/14 10:10:00"),
+				},
+				{
+					// DUID-EN has no link-layer address
+					IP:    netip.MustParseAddr("2001:db8::21"),
+					State: "expired",
+					Ends:  at("2024/08/14 09:10:00"),
+				},
+			},
+		},
+		"empty": {
+			out: []Lease{},
+		},
+		"invalid address": {
+			in:  "lease 10.0.0 {\n}\n",
+			err: ErrMalformedLeases,
+		},
+		"invalid time": {
+			in:  "lease 10.0.0.1 {\n  starts 3 yesterday;\n}\n",
+			err: ErrMalformedLeases,
+		},
+		"unbalanced braces": {
+			in:  "}\n",
+			err: ErrMalformedLeases,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			leases, err := ParseLeases(strings.NewReader(tc.in))
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, leases)
+		})
+	}
+}
+
+func TestParseLeasesHostname(t *testing.T) {
+	leases, err := ParseLeases(strings.NewReader(
+		"lease 10.0.0.1 {\n  client-hostname \"node\\0561\";\n}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "node.1", leases[0].Hostname)
+}
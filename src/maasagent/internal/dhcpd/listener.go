@@ -24,8 +24,14 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/backpressure"
 )
 
+// defaultMaxPending bounds memory used by notifications which were not
+// acknowledged by the Region yet
+const defaultMaxPending = 65536
+
 type Notification struct {
 	Action    string           `json:"action"`
 	IPFamily  string           `json:"ip_family"`
@@ -37,12 +43,15 @@ type Notification struct {
 }
 
 type NotificationListener struct {
-	conn     net.Conn
-	queue    *NotificationQueue
-	buf      chan *Notification
-	pool     *sync.Pool
-	fn       func(context.Context, []*Notification) error
-	interval time.Duration
+	conn       net.Conn
+	queue      *NotificationQueue
+	buf        chan *Notification
+	pool       *sync.Pool
+	pressure   *backpressure.Controller
+	fn         func(context.Context, []*Notification) error
+	interval   time.Duration
+	maxPending int
+	dropped    int
 }
 
 type NotificationListenerOption func(*NotificationListener)
@@ -57,11 +66,12 @@ func NewNotificationListener(conn net.Conn, fn func(context.Context, []*Notifica
 	}
 
 	l := &NotificationListener{
-		pool:  pool,
-		conn:  conn,
-		buf:   make(chan *Notification, 1024),
-		queue: NewNotificationQueue(),
-		fn:    fn,
+		pool:       pool,
+		conn:       conn,
+		buf:        make(chan *Notification, 1024),
+		queue:      NewNotificationQueue(),
+		fn:         fn,
+		maxPending: defaultMaxPending,
 	}
 
 	for _, opt := range options {
@@ -77,6 +87,20 @@ func WithInterval(d time.Duration) NotificationListenerOption {
 	return func(l *NotificationListener) { l.interval = d }
 }
 
+// WithMaxPending sets the maximum number of notifications kept while they
+// cannot be flushed. The oldest notifications are dropped above it.
+// (default: 65536)
+func WithMaxPending(n int) NotificationListenerOption {
+	return func(l *NotificationListener) { l.maxPending = n }
+}
+
+// WithBackpressure sets Controller which observes how long flushes take
+// and is used to flush less often when the Region is slow to acknowledge
+// them, so notifications are sent in bigger batches.
+func WithBackpressure(c *backpressure.Controller) NotificationListenerOption {
+	return func(l *NotificationListener) { l.pressure = c }
+}
+
 func (l *NotificationListener) Listen(ctx context.Context) {
 	go l.read(ctx)
 
@@ -93,7 +117,7 @@ func (l *NotificationListener) Listen(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case notification := <-l.buf:
-			heap.Push(l.queue, notification)
+			l.push(notification)
 		case <-ticker.C:
 			batchPtr, ok := l.pool.Get().(*[]*Notification)
 			if !ok {
@@ -131,16 +155,43 @@ func (l *NotificationListener) Listen(ctx context.Context) {
 			copy(copied, batch)
 			l.pool.Put(&batch)
 
+			start := time.Now()
+
 			err := l.fn(ctx, copied)
+			if ctx.Err() == nil {
+				l.pressure.Observe(time.Since(start), err)
+			}
+
 			if err != nil { // failed to send, push leases back
 				for _, n := range copied {
-					heap.Push(l.queue, n)
+					l.push(n)
 				}
 			}
+
+			if l.dropped > 0 {
+				log.Warn().Int("dropped", l.dropped).
+					Msg("Too many pending DHCP notifications, the oldest were dropped")
+
+				l.dropped = 0
+			}
+
+			ticker.Reset(interval + l.pressure.Interval())
 		}
 	}
 }
 
+// push adds notification to the queue, dropping the oldest one if the
+// queue is full.
+func (l *NotificationListener) push(notification *Notification) {
+	if l.maxPending > 0 && l.queue.Len() >= l.maxPending {
+		heap.Pop(l.queue)
+
+		l.dropped++
+	}
+
+	heap.Push(l.queue, notification)
+}
+
 func (l *NotificationListener) read(ctx context.Context) {
 	decoder := json.NewDecoder(l.conn)
 
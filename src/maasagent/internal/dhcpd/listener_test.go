@@ -16,6 +16,7 @@
 package dhcpd
 
 import (
+	"container/heap"
 	"context"
 	"encoding/json"
 	"net"
@@ -238,3 +239,28 @@ func TestNotificationListener(t *testing.T) {
 		})
 	}
 }
+
+func TestNotificationListenerMaxPending(t *testing.T) {
+	t.Parallel()
+
+	listener := NewNotificationListener(nil, nil, WithMaxPending(2))
+
+	for _, ts := range []int64{3, 1, 2} {
+		listener.push(&Notification{Timestamp: ts})
+	}
+
+	assert.Equal(t, 2, listener.queue.Len())
+	assert.Equal(t, 1, listener.dropped)
+
+	// The oldest notification is dropped
+	var timestamps []int64
+
+	for listener.queue.Len() > 0 {
+		n, ok := heap.Pop(listener.queue).(*Notification)
+		assert.True(t, ok)
+
+		timestamps = append(timestamps, n.Timestamp)
+	}
+
+	assert.Equal(t, []int64{2, 3}, timestamps)
+}
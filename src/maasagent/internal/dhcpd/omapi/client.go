@@ -35,6 +35,8 @@ type OMAPI interface {
 	AddHost(net.IP, net.HardwareAddr) error
 	GetHost(map[string][]byte) (Host, error)
 	DeleteHost(net.HardwareAddr) error
+	GetFailoverPeer(string) (FailoverPeer, error)
+	SetFailoverState(string, FailoverState) error
 }
 
 type Client struct {
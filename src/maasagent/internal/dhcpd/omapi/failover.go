@@ -0,0 +1,179 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package omapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// FailoverState is the failover protocol state of a server, as exposed by
+// failover-state objects (see dhcpd(8))
+type FailoverState int32
+
+const (
+	FailoverStateUnknown FailoverState = iota
+	FailoverStatePartnerDown
+	FailoverStateNormal
+	FailoverStateCommunicationsInterrupted
+	FailoverStateResolutionInterrupted
+	FailoverStatePotentialConflict
+	FailoverStateRecover
+	FailoverStateRecoverDone
+	FailoverStateShutdown
+	FailoverStatePaused
+	FailoverStateStartup
+	FailoverStateRecoverWait
+)
+
+var failoverStateNames = map[FailoverState]string{
+	FailoverStateUnknown:                   "unknown",
+	FailoverStatePartnerDown:               "partner-down",
+	FailoverStateNormal:                    "normal",
+	FailoverStateCommunicationsInterrupted: "communications-interrupted",
+	FailoverStateResolutionInterrupted:     "resolution-interrupted",
+	FailoverStatePotentialConflict:         "potential-conflict",
+	FailoverStateRecover:                   "recover",
+	FailoverStateRecoverDone:               "recover-done",
+	FailoverStateShutdown:                  "shutdown",
+	FailoverStatePaused:                    "paused",
+	FailoverStateStartup:                   "startup",
+	FailoverStateRecoverWait:               "recover-wait",
+}
+
+func (s FailoverState) String() string {
+	if name, ok := failoverStateNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("FailoverState(%d)", int32(s))
+}
+
+// FailoverPeer is the state of a failover peer relationship
+type FailoverPeer struct {
+	Name           string
+	LocalAddress   net.IP
+	PartnerAddress net.IP
+	LocalState     FailoverState
+	PartnerState   FailoverState
+	LocalPort      int32
+	PartnerPort    int32
+	MCLT           int32
+	// Primary is true when this server is the primary of the pair
+	Primary bool
+}
+
+func bytesToInt32(b []byte) int32 {
+	if len(b) != 4 {
+		return 0
+	}
+
+	//nolint:gosec // OMAPI integers are signed 32-bit values
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+// failoverPeerFromObject converts attributes of failover-state object
+func failoverPeerFromObject(object map[string][]byte) FailoverPeer {
+	return FailoverPeer{
+		Name:           string(object["name"]),
+		LocalAddress:   net.IP(object["local-address"]),
+		PartnerAddress: net.IP(object["partner-address"]),
+		LocalState:     FailoverState(bytesToInt32(object["local-state"])),
+		PartnerState:   FailoverState(bytesToInt32(object["partner-state"])),
+		LocalPort:      bytesToInt32(object["local-port"]),
+		PartnerPort:    bytesToInt32(object["partner-port"]),
+		MCLT:           bytesToInt32(object["mclt"]),
+		// hierarchy is 0 for primary and 1 for secondary
+		Primary: bytesToInt32(object["hierarchy"]) == 0,
+	}
+}
+
+func expectOperation(op Opcode) validator {
+	return func(resp *Message) error {
+		if resp.Operation != op {
+			if text, ok := resp.Message["message"]; ok && len(text) > 0 {
+				return fmt.Errorf("%s", text)
+			}
+
+			return fmt.Errorf("wrong response type, got %s", resp.Operation)
+		}
+
+		return nil
+	}
+}
+
+// GetFailoverPeer retrieves the state of a failover peer via OMAPI.
+func (c *Client) GetFailoverPeer(name string) (FailoverPeer, error) {
+	message := NewOpenMessage()
+	message.Message["type"] = []byte("failover-state")
+	message.Object["name"] = []byte(name)
+
+	resp, err := c.send(message, expectOperation(OpUpdate))
+	if err != nil {
+		return FailoverPeer{}, fmt.Errorf("failover peer %q lookup failed: %w", name, err)
+	}
+
+	return failoverPeerFromObject(resp.Object), nil
+}
+
+// SetFailoverState changes the local failover state of a peer via OMAPI.
+//
+// This is mostly useful to put the server into partner-down, so that it takes
+// over the whole address pool when its partner is known to be down.
+func (c *Client) SetFailoverState(name string, state FailoverState) error {
+	message := NewOpenMessage()
+	message.Message["type"] = []byte("failover-state")
+	message.Object["name"] = []byte(name)
+
+	resp, err := c.send(message, func(resp *Message) error {
+		if err := expectOperation(OpUpdate)(resp); err != nil {
+			return err
+		}
+
+		if resp.Handle == 0 {
+			return fmt.Errorf("invalid message handle")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failover peer %q lookup failed: %w", name, err)
+	}
+
+	message = NewMessage()
+	message.Operation = OpUpdate
+	message.Handle = resp.Handle
+	message.Object["local-state"] = int32ToBytes(int32(state))
+
+	_, err = c.send(message, func(resp *Message) error {
+		// dhcpd replies with status when the update has no response object
+		if resp.Operation == OpStatus {
+			if result := bytesToInt32(resp.Message["result"]); result != 0 {
+				return fmt.Errorf("%s", resp.Message["message"])
+			}
+
+			return nil
+		}
+
+		return expectOperation(OpUpdate)(resp)
+	})
+	if err != nil {
+		return fmt.Errorf("failed setting failover peer %q to %s: %w", name, state, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package omapi
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverPeerFromObject(t *testing.T) {
+	peer := failoverPeerFromObject(map[string][]byte{
+		"name":            []byte("failover-vlan-5001"),
+		"local-address":   {10, 0, 0, 2},
+		"partner-address": {10, 0, 0, 3},
+		"local-state":     int32ToBytes(int32(FailoverStateCommunicationsInterrupted)),
+		"partner-state":   int32ToBytes(int32(FailoverStateNormal)),
+		"local-port":      int32ToBytes(647),
+		"partner-port":    int32ToBytes(847),
+		"mclt":            int32ToBytes(3600),
+		"hierarchy":       int32ToBytes(1),
+	})
+
+	assert.Equal(t, FailoverPeer{
+		Name:           "failover-vlan-5001",
+		LocalAddress:   net.IP{10, 0, 0, 2},
+		PartnerAddress: net.IP{10, 0, 0, 3},
+		LocalState:     FailoverStateCommunicationsInterrupted,
+		PartnerState:   FailoverStateNormal,
+		LocalPort:      647,
+		PartnerPort:    847,
+		MCLT:           3600,
+		Primary:        false,
+	}, peer)
+}
+
+func TestFailoverStateString(t *testing.T) {
+	assert.Equal(t, "partner-down", FailoverStatePartnerDown.String())
+	assert.Equal(t, "recover-wait", FailoverStateRecoverWait.String())
+	assert.Equal(t, "FailoverState(42)", FailoverState(42).String())
+}
+
+// fakeServer replies to every request with the next response, signed with
+// the same authenticator as the client
+func fakeServer(t *testing.T, conn net.Conn, auth Authenticator, responses ...*Message) <-chan *Message {
+	requests := make(chan *Message, len(responses))
+
+	go func() {
+		defer close(requests)
+
+		buf := make([]byte, 2048)
+
+		for _, resp := range responses {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			req := NewEmptyMessage()
+			if err := req.UnmarshalBinary(buf[:n]); err != nil {
+				t.Error(err)
+				return
+			}
+
+			requests <- req
+
+			resp.ResponseID = req.TransactionID
+			if err := sign(auth, resp); err != nil {
+				t.Error(err)
+				return
+			}
+
+			data, err := resp.MarshalBinary()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if _, err := conn.Write(data); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	return requests
+}
+
+func TestSetFailoverState(t *testing.T) {
+	testcases := map[string]struct {
+		status *Message
+		err    string
+	}{
+		"success": {
+			status: &Message{
+				Operation: OpStatus,
+				Message:   map[string][]byte{"result": int32ToBytes(0)},
+				Object:    map[string][]byte{},
+			},
+		},
+		"failure": {
+			status: &Message{
+				Operation: OpStatus,
+				Message: map[string][]byte{
+					"result":  int32ToBytes(36),
+					"message": []byte("invalid argument"),
+				},
+				Object: map[string][]byte{},
+			},
+			err: `failed setting failover peer "failover" to partner-down: invalid argument`,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			client, server := net.Pipe()
+
+			t.Cleanup(func() {
+				//nolint:errcheck // pipe is closed anyway
+				client.Close()
+				//nolint:errcheck // pipe is closed anyway
+				server.Close()
+			})
+
+			auth := NewHMACMD5Authenticator("omapi_key", "a2V5")
+			auth.SetAuthID(1)
+
+			opened := &Message{
+				Operation: OpUpdate,
+				Handle:    7,
+				Message:   map[string][]byte{},
+				Object:    map[string][]byte{"name": []byte("failover")},
+			}
+
+			requests := fakeServer(t, server, &auth, opened, tc.status)
+
+			c := &Client{authenticator: &auth, conn: client}
+
+			err := c.SetFailoverState("failover", FailoverStatePartnerDown)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			open := <-requests
+			assert.Equal(t, OpOpen, open.Operation)
+			assert.Equal(t, []byte("failover-state"), open.Message["type"])
+			assert.Equal(t, []byte("failover"), open.Object["name"])
+
+			update := <-requests
+			require.NotNil(t, update)
+			assert.Equal(t, OpUpdate, update.Operation)
+			assert.Equal(t, uint32(7), update.Handle)
+			assert.Equal(t, int32ToBytes(int32(FailoverStatePartnerDown)), update.Object["local-state"])
+		})
+	}
+}
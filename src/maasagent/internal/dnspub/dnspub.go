@@ -0,0 +1,201 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package dnspub publishes endpoints of services of the Agent (metadata,
+// boot HTTP, syslog) as SRV and TXT records in rack-local DNS zones, so
+// preseed templates can discover them by name rather than hard-coding
+// addresses of the rack.
+package dnspub
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultTTL of published records, in seconds
+	DefaultTTL = 300
+	protoTCP   = "tcp"
+	protoUDP   = "udp"
+)
+
+var (
+	// ErrInvalidEndpoint is returned when an endpoint can't be published
+	// as a SRV record
+	ErrInvalidEndpoint = errors.New("invalid service endpoint")
+	// ErrInvalidZone is returned when a rack-local zone has invalid name
+	// or subnet
+	ErrInvalidZone = errors.New("invalid zone")
+)
+
+// Endpoint is a service of the Agent published as SRV record
+// _<service>._<proto>.<zone> and TXT record of the same name
+type Endpoint struct {
+	// TXT are key=value attributes of the service (e.g. URL path)
+	TXT     map[string]string `yaml:"txt" json:"txt,omitempty"`
+	Service string            `yaml:"service" json:"service"`
+	// Proto is "tcp" or "udp"
+	Proto string `yaml:"proto" json:"proto"`
+	Port  int    `yaml:"port" json:"port"`
+}
+
+// DefaultEndpoints are services of the rack, on their default ports
+var DefaultEndpoints = []Endpoint{
+	{Service: "maas-metadata", Proto: protoTCP, Port: 5248, TXT: map[string]string{"path": "/MAAS/metadata/"}},
+	{Service: "maas-boot", Proto: protoTCP, Port: 5248, TXT: map[string]string{"path": "/images/"}},
+	{Service: "syslog", Proto: protoUDP, Port: 5247},
+}
+
+// Zone is a rack-local zone of a subnet served by the rack
+type Zone struct {
+	Name   string `json:"name"`
+	Subnet string `json:"subnet"`
+}
+
+// Record is a resource record in presentation format of zone files
+type Record struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl"`
+}
+
+// ZoneRecords are records of the rack published in zone
+type ZoneRecords struct {
+	Zone    string   `json:"zone"`
+	Records []Record `json:"records"`
+}
+
+// Validate returns an error if the endpoint can't be published
+func (e Endpoint) Validate() error {
+	if !validLabel(e.Service) {
+		return fmt.Errorf("%w: service %q", ErrInvalidEndpoint, e.Service)
+	}
+
+	if e.Proto != protoTCP && e.Proto != protoUDP {
+		return fmt.Errorf("%w: %s protocol %q", ErrInvalidEndpoint, e.Service, e.Proto)
+	}
+
+	if e.Port < 1 || e.Port > 65535 {
+		return fmt.Errorf("%w: %s port %d", ErrInvalidEndpoint, e.Service, e.Port)
+	}
+
+	return nil
+}
+
+// Records returns records of endpoints of the rack named host in zone.
+// The host is published with its addresses in the subnet of the zone, and
+// no records are returned if it has none.
+func Records(zone Zone, host string, addrs []netip.Addr, endpoints []Endpoint, ttl int) ([]Record, error) {
+	name := strings.TrimSuffix(zone.Name, ".")
+	if name == "" {
+		return nil, fmt.Errorf("%w: empty name", ErrInvalidZone)
+	}
+
+	prefix, err := netip.ParsePrefix(zone.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidZone, err)
+	}
+
+	if !validLabel(host) {
+		return nil, fmt.Errorf("%w: host name %q", ErrInvalidZone, host)
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	target := host + "." + name + "."
+
+	var records []Record
+
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if !prefix.Contains(addr) {
+			continue
+		}
+
+		rrType := "A"
+		if addr.Is6() {
+			rrType = "AAAA"
+		}
+
+		records = append(records, Record{Name: target, Type: rrType, Data: addr.String(), TTL: ttl})
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	for _, e := range endpoints {
+		if err := e.Validate(); err != nil {
+			return nil, err
+		}
+
+		owner := "_" + e.Service + "._" + e.Proto + "." + name + "."
+
+		records = append(records, Record{
+			Name: owner,
+			Type: "SRV",
+			Data: "0 0 " + strconv.Itoa(e.Port) + " " + target,
+			TTL:  ttl,
+		})
+
+		if len(e.TXT) > 0 {
+			records = append(records, Record{Name: owner, Type: "TXT", Data: txtData(e.TXT), TTL: ttl})
+		}
+	}
+
+	return records, nil
+}
+
+// txtData returns character strings of TXT record with attributes sorted
+// by key, as in DNS-SD (RFC 6763)
+func txtData(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	strs := make([]string, len(keys))
+
+	for i, k := range keys {
+		s := strings.ReplaceAll(k+"="+attrs[k], `\`, `\\`)
+		strs[i] = `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+
+	return strings.Join(strs, " ")
+}
+
+// validLabel returns whether s can be used as a single DNS label
+func validLabel(s string) bool {
+	if s == "" || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+
+	return true
+}
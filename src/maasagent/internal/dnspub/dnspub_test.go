@@ -0,0 +1,98 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dnspub
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecords(t *testing.T) {
+	addrs := []netip.Addr{
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("192.168.1.2"),
+		netip.MustParseAddr("fd00::2"),
+	}
+
+	testcases := map[string]struct {
+		zone      Zone
+		endpoints []Endpoint
+		records   []Record
+		err       error
+	}{
+		"default endpoints": {
+			zone:      Zone{Name: "rack1.maas.", Subnet: "10.0.0.0/24"},
+			endpoints: DefaultEndpoints,
+			records: []Record{
+				{Name: "rack.rack1.maas.", Type: "A", Data: "10.0.0.2", TTL: 60},
+				{Name: "_maas-metadata._tcp.rack1.maas.", Type: "SRV", Data: "0 0 5248 rack.rack1.maas.", TTL: 60},
+				{Name: "_maas-metadata._tcp.rack1.maas.", Type: "TXT", Data: `"path=/MAAS/metadata/"`, TTL: 60},
+				{Name: "_maas-boot._tcp.rack1.maas.", Type: "SRV", Data: "0 0 5248 rack.rack1.maas.", TTL: 60},
+				{Name: "_maas-boot._tcp.rack1.maas.", Type: "TXT", Data: `"path=/images/"`, TTL: 60},
+				{Name: "_syslog._udp.rack1.maas.", Type: "SRV", Data: "0 0 5247 rack.rack1.maas.", TTL: 60},
+			},
+		},
+		"ipv6 and TXT attributes": {
+			zone: Zone{Name: "v6.maas", Subnet: "fd00::/64"},
+			endpoints: []Endpoint{
+				{Service: "maas-proxy", Proto: "tcp", Port: 8000, TXT: map[string]string{"b": `say "hi"`, "a": "1"}},
+			},
+			records: []Record{
+				{Name: "rack.v6.maas.", Type: "AAAA", Data: "fd00::2", TTL: 60},
+				{Name: "_maas-proxy._tcp.v6.maas.", Type: "SRV", Data: "0 0 8000 rack.v6.maas.", TTL: 60},
+				{Name: "_maas-proxy._tcp.v6.maas.", Type: "TXT", Data: `"a=1" "b=say \"hi\""`, TTL: 60},
+			},
+		},
+		"no address in the subnet": {
+			zone:      Zone{Name: "other.maas", Subnet: "172.16.0.0/16"},
+			endpoints: DefaultEndpoints,
+		},
+		"invalid subnet": {
+			zone: Zone{Name: "rack1.maas", Subnet: "10.0.0.0"},
+			err:  ErrInvalidZone,
+		},
+		"invalid service": {
+			zone:      Zone{Name: "rack1.maas", Subnet: "10.0.0.0/24"},
+			endpoints: []Endpoint{{Service: "_http", Proto: "tcp", Port: 80}},
+			err:       ErrInvalidEndpoint,
+		},
+		"invalid protocol": {
+			zone:      Zone{Name: "rack1.maas", Subnet: "10.0.0.0/24"},
+			endpoints: []Endpoint{{Service: "http", Proto: "sctp", Port: 80}},
+			err:       ErrInvalidEndpoint,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			records, err := Records(tc.zone, "rack", addrs, tc.endpoints, 60)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.records, records)
+		})
+	}
+}
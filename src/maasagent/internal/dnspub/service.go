@@ -0,0 +1,202 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dnspub
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+// Service publishes records of the rack in rack-local zones of subnets
+// served by the rack. Invocation of this service normally should happen
+// via Temporal.
+type Service struct {
+	// addrs returns addresses of the rack
+	addrs     func() ([]netip.Addr, error)
+	host      string
+	endpoints []Endpoint
+	ttl       int
+	disabled  bool
+}
+
+// ServiceOption allows to set additional options for Service
+type ServiceOption func(*Service)
+
+// NewService returns Service publishing endpoints of the rack named host
+// (the first label of its host name). DefaultEndpoints are published,
+// unless others are given.
+func NewService(host string, options ...ServiceOption) *Service {
+	s := &Service{
+		addrs:     interfaceAddrs,
+		host:      host,
+		endpoints: DefaultEndpoints,
+		ttl:       DefaultTTL,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithEndpoints sets endpoints published instead of DefaultEndpoints
+func WithEndpoints(endpoints []Endpoint) ServiceOption {
+	return func(s *Service) {
+		if len(endpoints) > 0 {
+			s.endpoints = endpoints
+		}
+	}
+}
+
+// WithTTL sets TTL of published records in seconds
+func WithTTL(ttl int) ServiceOption {
+	return func(s *Service) {
+		if ttl > 0 {
+			s.ttl = ttl
+		}
+	}
+}
+
+type getRackDNSZonesParam struct {
+	SystemID string `json:"system_id"`
+}
+
+type getRackDNSZonesResult struct {
+	Zones []Zone `json:"zones"`
+}
+
+// PublishDNSRecordsParam is the parameter of publish-dns-records activity
+// of the Region. Zones without records are published as well, so records
+// of addresses the rack no longer has are removed.
+type PublishDNSRecordsParam struct {
+	SystemID string        `json:"system_id"`
+	Zones    []ZoneRecords `json:"zones"`
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{"configure-dns-publication": s.configure}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// WithDisabled makes the service publish no records, while its workflow is
+// still registered for the Region to start
+func WithDisabled(disabled bool) ServiceOption {
+	return func(s *Service) {
+		s.disabled = disabled
+	}
+}
+
+func regionContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              "region",
+		ScheduleToCloseTimeout: 60 * time.Second,
+	})
+}
+
+// configure publishes records of the rack in rack-local zones given by the
+// Region. It runs whenever the Agent is configured, so records follow
+// addresses of the rack.
+func (s *Service) configure(ctx tworkflow.Context, systemID string) error {
+	log := tworkflow.GetLogger(ctx)
+
+	if s.disabled {
+		return nil
+	}
+
+	var zones getRackDNSZonesResult
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "get-rack-dns-zones",
+		getRackDNSZonesParam{SystemID: systemID}).Get(ctx, &zones); err != nil {
+		return err
+	}
+
+	if len(zones.Zones) == 0 {
+		return nil
+	}
+
+	localCtx := tworkflow.WithLocalActivityOptions(ctx, tworkflow.LocalActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+	})
+
+	param := PublishDNSRecordsParam{SystemID: systemID}
+
+	if err := tworkflow.ExecuteLocalActivity(localCtx, s.records, zones.Zones).
+		Get(ctx, &param.Zones); err != nil {
+		return err
+	}
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "publish-dns-records", param).
+		Get(ctx, nil); err != nil {
+		return err
+	}
+
+	log.Info("DNS records published", tag.Builder().KV("zones", len(param.Zones)).KeyVals...)
+
+	return nil
+}
+
+// records returns records of the rack in zones
+func (s *Service) records(_ context.Context, zones []Zone) ([]ZoneRecords, error) {
+	addrs, err := s.addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ZoneRecords, len(zones))
+
+	for i, zone := range zones {
+		records, err := Records(zone, s.host, addrs, s.endpoints, s.ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = ZoneRecords{Zone: zone.Name, Records: records}
+	}
+
+	return result, nil
+}
+
+// interfaceAddrs returns unicast addresses of interfaces of the host
+func interfaceAddrs() ([]netip.Addr, error) {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []netip.Addr
+
+	for _, a := range ifaceAddrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+
+		if addr := prefix.Addr(); addr.IsGlobalUnicast() {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs, nil
+}
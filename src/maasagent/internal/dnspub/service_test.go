@@ -0,0 +1,111 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dnspub
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+// Region activities are implemented in Python, hence dummy activities
+// are required to match function signatures.
+func getRackDNSZonesActivity(_ context.Context, _ getRackDNSZonesParam) (getRackDNSZonesResult, error) {
+	return getRackDNSZonesResult{}, nil
+}
+
+func publishDNSRecordsActivity(_ context.Context, _ PublishDNSRecordsParam) error {
+	return nil
+}
+
+func TestConfigure(t *testing.T) {
+	wfTestSuite := testsuite.WorkflowTestSuite{}
+	wfTestSuite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := wfTestSuite.NewTestWorkflowEnvironment()
+	env.RegisterActivityWithOptions(getRackDNSZonesActivity,
+		activity.RegisterOptions{Name: "get-rack-dns-zones"})
+	env.RegisterActivityWithOptions(publishDNSRecordsActivity,
+		activity.RegisterOptions{Name: "publish-dns-records"})
+
+	s := NewService("rack", WithEndpoints([]Endpoint{{Service: "syslog", Proto: "udp", Port: 5247}}))
+	s.addrs = func() ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("10.0.0.2")}, nil
+	}
+
+	env.OnActivity("get-rack-dns-zones", mock.Anything, getRackDNSZonesParam{SystemID: "abc"}).
+		Return(getRackDNSZonesResult{Zones: []Zone{
+			{Name: "rack1.maas", Subnet: "10.0.0.0/24"},
+			{Name: "stale.maas", Subnet: "10.1.0.0/24"},
+		}}, nil)
+
+	var published PublishDNSRecordsParam
+
+	env.OnActivity("publish-dns-records", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, p PublishDNSRecordsParam) error {
+			published = p
+			return nil
+		})
+
+	env.ExecuteWorkflow(s.configure, "abc")
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	assert.Equal(t, PublishDNSRecordsParam{
+		SystemID: "abc",
+		Zones: []ZoneRecords{
+			{
+				Zone: "rack1.maas",
+				Records: []Record{
+					{Name: "rack.rack1.maas.", Type: "A", Data: "10.0.0.2", TTL: DefaultTTL},
+					{Name: "_syslog._udp.rack1.maas.", Type: "SRV", Data: "0 0 5247 rack.rack1.maas.", TTL: DefaultTTL},
+				},
+			},
+			{Zone: "stale.maas"},
+		},
+	}, published)
+}
+
+func TestConfigureDisabled(t *testing.T) {
+	wfTestSuite := testsuite.WorkflowTestSuite{}
+	wfTestSuite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := wfTestSuite.NewTestWorkflowEnvironment()
+	env.RegisterActivityWithOptions(getRackDNSZonesActivity,
+		activity.RegisterOptions{Name: "get-rack-dns-zones"})
+	env.RegisterActivityWithOptions(publishDNSRecordsActivity,
+		activity.RegisterOptions{Name: "publish-dns-records"})
+
+	s := NewService("", WithDisabled(true))
+
+	env.ExecuteWorkflow(s.configure, "abc")
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// The Region is not asked for zones, so nothing is published
+	env.AssertNotCalled(t, "get-rack-dns-zones", mock.Anything, mock.Anything)
+	env.AssertNotCalled(t, "publish-dns-records", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package errcode attaches stable machine-readable codes to errors of the
+// Agent, so the Region can localize messages and automation can branch on
+// codes rather than on messages, which may change. Codes are reported as
+// type of Temporal application errors of failed activities and workflows.
+package errcode
+
+import (
+	"errors"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// Codes of errors. Codes are part of the API of the Agent: they are never
+// renamed, and a code is never reused for a different kind of error.
+const (
+	// PowerAuthFailed means the BMC or VM host rejected credentials
+	PowerAuthFailed = "POWER_AUTH_FAILED"
+	// PowerUnreachable means the BMC or VM host could not be reached
+	PowerUnreachable = "POWER_UNREACHABLE"
+	// PowerInvalidResponse means the BMC or VM host returned a response
+	// which could not be understood
+	PowerInvalidResponse = "POWER_INVALID_RESPONSE"
+	// PowerWrongState means the machine is not in the expected power
+	// state after the power action
+	PowerWrongState = "POWER_WRONG_STATE"
+	// PowerUnsupported means the BMC (or the driver) doesn't support the
+	// requested action, boot device, boot mode or protocol
+	PowerUnsupported = "POWER_UNSUPPORTED"
+	// PowerInvalidParameters means power parameters of the machine are
+	// invalid
+	PowerInvalidParameters = "POWER_INVALID_PARAMETERS"
+	// PowerNotFound means the VM or device doesn't exist on the host
+	PowerNotFound = "POWER_NOT_FOUND"
+	// PowerCertificateMismatch means the identity of the BMC or VM host
+	// doesn't match the pinned one
+	PowerCertificateMismatch = "POWER_CERTIFICATE_MISMATCH"
+	// PowerOperationFailed means the BMC or VM host failed to perform the
+	// power action
+	PowerOperationFailed = "POWER_OPERATION_FAILED"
+	// PowerCLIUnavailable means the MAAS power CLI is not installed
+	PowerCLIUnavailable = "POWER_CLI_UNAVAILABLE"
+	// PowerDependencyInvalid means dependencies of machines powered on in
+	// order are cyclic or unknown
+	PowerDependencyInvalid = "POWER_DEPENDENCY_INVALID"
+	// PowerHealthTimeout means the machine didn't become healthy in time
+	PowerHealthTimeout = "POWER_HEALTH_TIMEOUT"
+	// DeploymentFailed means the machine failed to deploy
+	DeploymentFailed = "DEPLOYMENT_FAILED"
+	// NetbootNotObserved means the machine was powered on, but it didn't
+	// request DHCP or boot files in time, e.g. because it failed POST
+	NetbootNotObserved = "NETBOOT_NOT_OBSERVED"
+)
+
+// Error is an error with a code. Errors are compared by identity, so
+// sentinel errors created with New work with errors.Is.
+type Error struct {
+	code string
+	msg  string
+}
+
+// New returns an error with the code and message, which is meant to be a
+// sentinel error, the same as of errors.New
+func New(code, msg string) error {
+	return &Error{code: code, msg: msg}
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// Code returns the code of the error
+func (e *Error) Code() string {
+	return e.code
+}
+
+// Of returns the code of the first error in the chain of err with a code,
+// either an Error or an application error with a code as type (e.g. of an
+// activity which failed), or "" if there is none.
+func Of(err error) string {
+	for err != nil {
+		switch e := err.(type) {
+		case *Error:
+			return e.code
+		case *temporal.ApplicationError:
+			// Types of other application errors are names of Go types
+			// (e.g. "wrapError") or were set explicitly
+			if isCode(e.Type()) {
+				return e.Type()
+			}
+		}
+
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			err = u.Unwrap()
+		case interface{ Unwrap() []error }:
+			for _, err := range u.Unwrap() {
+				if code := Of(err); code != "" {
+					return code
+				}
+			}
+
+			return ""
+		default:
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// Wrap returns err as an application error with its code as type, so the
+// code is reported to Temporal. Application errors with a type are
+// returned as they are, and others keep whether they can be retried.
+// Errors without a code are returned as they are.
+func Wrap(err error) error {
+	code := Of(err)
+	if code == "" {
+		return err
+	}
+
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) {
+		return temporal.NewApplicationErrorWithOptions(err.Error(), code,
+			temporal.ApplicationErrorOptions{Cause: err})
+	}
+
+	// Details can't be copied, so such errors are left as they are
+	if appErr.Type() != "" || appErr.HasDetails() {
+		return err
+	}
+
+	return temporal.NewApplicationErrorWithOptions(err.Error(), code, temporal.ApplicationErrorOptions{
+		NonRetryable:   appErr.NonRetryable(),
+		Cause:          errors.Unwrap(appErr),
+		NextRetryDelay: appErr.NextRetryDelay(),
+	})
+}
+
+// isCode returns whether s is formatted as a code, e.g. "POWER_AUTH_FAILED"
+func isCode(s string) bool {
+	if s == "" || s[0] < 'A' || s[0] > 'Z' {
+		return false
+	}
+
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
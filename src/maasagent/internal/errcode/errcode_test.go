@@ -0,0 +1,130 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package errcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+var errAuth = New(PowerAuthFailed, "authentication failed")
+
+func TestOf(t *testing.T) {
+	testcases := map[string]struct {
+		err  error
+		code string
+	}{
+		"nil": {},
+		"no code": {
+			err: errors.New("boom"),
+		},
+		"sentinel": {
+			err:  errAuth,
+			code: PowerAuthFailed,
+		},
+		"wrapped": {
+			err:  fmt.Errorf("redfish: %w", errAuth),
+			code: PowerAuthFailed,
+		},
+		"first of joined": {
+			err:  fmt.Errorf("%w: %w", errors.New("boom"), errAuth),
+			code: PowerAuthFailed,
+		},
+		"application error": {
+			err:  temporal.NewApplicationError("unreachable", PowerUnreachable),
+			code: PowerUnreachable,
+		},
+		"application error with cause": {
+			err:  temporal.NewNonRetryableApplicationError("failed", "", errAuth),
+			code: PowerAuthFailed,
+		},
+		"application error with Go type": {
+			err: temporal.NewApplicationError("failed", "wrapError"),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.code, Of(tc.err))
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	assert.NoError(t, Wrap(nil))
+
+	plain := errors.New("boom")
+	assert.Same(t, plain, Wrap(plain))
+
+	var appErr *temporal.ApplicationError
+
+	err := Wrap(fmt.Errorf("redfish: %w", errAuth))
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, PowerAuthFailed, appErr.Type())
+	assert.False(t, appErr.NonRetryable())
+	assert.ErrorIs(t, err, errAuth)
+
+	err = Wrap(temporal.NewNonRetryableApplicationError("failed", "", errAuth))
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, PowerAuthFailed, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+
+	typed := temporal.NewNonRetryableApplicationError("conflict", "ErrConflict", errAuth)
+	assert.Equal(t, typed, Wrap(typed))
+}
+
+func TestInterceptor(t *testing.T) {
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestWorkflowEnvironment()
+
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{NewInterceptor()}})
+	env.RegisterActivityWithOptions(func(context.Context) error {
+		return fmt.Errorf("ipmi: %w", errAuth)
+	}, activity.RegisterOptions{Name: "power-on"})
+
+	env.ExecuteWorkflow(func(ctx workflow.Context) error {
+		ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+			RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 1},
+		})
+
+		return workflow.ExecuteActivity(ctx, "power-on").Get(ctx, nil)
+	})
+
+	require.True(t, env.IsWorkflowCompleted())
+
+	var appErr *temporal.ApplicationError
+
+	require.ErrorAs(t, env.GetWorkflowError(), &appErr)
+	assert.Equal(t, PowerAuthFailed, appErr.Type())
+	assert.Equal(t, PowerAuthFailed, Of(env.GetWorkflowError()))
+}
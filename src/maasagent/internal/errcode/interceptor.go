@@ -0,0 +1,70 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package errcode
+
+import (
+	"context"
+
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+// NewInterceptor returns a worker interceptor that reports codes of errors
+// of failed activities and workflows with Wrap. It should be the innermost
+// interceptor, so the others see the codes too.
+func NewInterceptor() interceptor.WorkerInterceptor {
+	return &codeInterceptor{}
+}
+
+type codeInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+func (c *codeInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityCodes{}
+	i.Next = next
+
+	return i
+}
+
+func (c *codeInterceptor) InterceptWorkflow(_ workflow.Context,
+	next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	i := &workflowCodes{}
+	i.Next = next
+
+	return i
+}
+
+type activityCodes struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (a *activityCodes) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	res, err := a.Next.ExecuteActivity(ctx, in)
+	return res, Wrap(err)
+}
+
+type workflowCodes struct {
+	interceptor.WorkflowInboundInterceptorBase
+}
+
+func (w *workflowCodes) ExecuteWorkflow(ctx workflow.Context,
+	in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	res, err := w.Next.ExecuteWorkflow(ctx, in)
+	return res, Wrap(err)
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package eventexport publishes events and state changes of the Agent to
+// Kafka or NATS, for sites that pipe infrastructure events into data
+// platforms. Every event is wrapped into Envelope with a versioned schema
+// name, so consumers can rely on the payload structure.
+package eventexport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/redact"
+	"maas.io/core/src/maasagent/internal/ringbuf"
+)
+
+// Supported backends
+const (
+	// BackendNATS publishes to NATS subjects using the NATS client protocol
+	BackendNATS = "nats"
+	// BackendKafka publishes to Kafka topics using the Kafka protocol
+	BackendKafka = "kafka"
+)
+
+// Kinds of exported events. Payload of every kind is described by
+// the corresponding type (e.g. WorkflowEvent).
+const (
+	KindWorkflow    = "workflow"
+	KindActivity    = "activity"
+	KindLatency     = "latency"
+	KindRemediation = "remediation"
+)
+
+const (
+	schemaVersion      = "v1"
+	defaultTopicPrefix = "maas.agent"
+	// defaultBufferBytes limits events waiting to be published, e.g.
+	// while the backend is not reachable
+	defaultBufferBytes = 16 * 1024 * 1024
+)
+
+var (
+	// ErrUnsupportedBackend is returned when backend is unknown
+	ErrUnsupportedBackend = errors.New("unsupported event export backend")
+)
+
+// Config of the exporter. Exporter is disabled if Backend is empty.
+type Config struct {
+	Backend string `yaml:"backend"`
+	// URL is nats://host:4222 (tls://host:4222 for TLS) for NATS, or
+	// kafka://host:9092[,host:9092...] (kafka+tls:// for TLS) for Kafka
+	// brokers
+	URL string `yaml:"url"`
+	// TopicPrefix is prepended to the event kind to form topic or subject
+	// name (default: maas.agent)
+	TopicPrefix string `yaml:"topic_prefix"`
+	// Username and Password authenticate to NATS, or to Kafka with
+	// SASL/PLAIN
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Token is NATS authentication token
+	Token string `yaml:"token"`
+	// BufferBytes limits the size of events waiting to be published.
+	// The oldest events are dropped above it. (default: 16 MiB)
+	BufferBytes int64 `yaml:"buffer_bytes"`
+}
+
+// States of workflows and activities
+const (
+	StateStarted   = "started"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+)
+
+// WorkflowEvent is the payload of maas.agent.workflow.v1
+type WorkflowEvent struct {
+	State      string `json:"state"`
+	Name       string `json:"name"`
+	WorkflowID string `json:"workflow_id"`
+	RunID      string `json:"run_id"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ActivityEvent is the payload of maas.agent.activity.v1
+type ActivityEvent struct {
+	State      string `json:"state"`
+	Name       string `json:"name"`
+	WorkflowID string `json:"workflow_id"`
+	Error      string `json:"error,omitempty"`
+	// Duration of the attempt in milliseconds
+	Duration int64 `json:"duration"`
+	Attempt  int32 `json:"attempt"`
+}
+
+// Envelope wraps every exported event
+type Envelope struct {
+	Time time.Time `json:"time"`
+	// Schema is "maas.agent.<kind>.<version>", e.g. maas.agent.workflow.v1
+	Schema   string          `json:"schema"`
+	ID       string          `json:"id"`
+	SystemID string          `json:"system_id"`
+	Kind     string          `json:"kind"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// sink publishes messages to a topic (or subject)
+type sink interface {
+	Publish(ctx context.Context, topic, key string, value []byte) error
+	Close() error
+}
+
+type message struct {
+	topic string
+	key   string
+	value []byte
+}
+
+func (m message) size() int {
+	return len(m.topic) + len(m.key) + len(m.value)
+}
+
+// Exporter publishes events to the configured backend in the background
+type Exporter struct {
+	sink        sink
+	queue       *ringbuf.Buffer[message]
+	now         func() time.Time
+	redaction   *redact.Filter
+	systemID    string
+	topicPrefix string
+}
+
+// ExporterOption allows to set additional Exporter options
+type ExporterOption func(*Exporter)
+
+// WithRedaction filters payloads of events with f before they are
+// published
+func WithRedaction(f *redact.Filter) ExporterOption {
+	return func(e *Exporter) {
+		e.redaction = f
+	}
+}
+
+// NewExporter returns Exporter for cfg, or nil if export is disabled.
+// Connection to the backend is established on the first publish.
+func NewExporter(systemID string, cfg Config, options ...ExporterOption) (*Exporter, error) {
+	var (
+		s   sink
+		err error
+	)
+
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case BackendNATS:
+		s, err = newNATSSink(cfg)
+	case BackendKafka:
+		s, err = newKafkaSink(cfg)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedBackend, cfg.Backend)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := cfg.TopicPrefix
+	if prefix == "" {
+		prefix = defaultTopicPrefix
+	}
+
+	bufferBytes := cfg.BufferBytes
+	if bufferBytes <= 0 {
+		bufferBytes = defaultBufferBytes
+	}
+
+	e := &Exporter{
+		sink:        s,
+		queue:       ringbuf.New("event-export", bufferBytes, message.size),
+		now:         time.Now,
+		systemID:    systemID,
+		topicPrefix: prefix,
+	}
+
+	for _, opt := range options {
+		opt(e)
+	}
+
+	return e, nil
+}
+
+// Export queues event of the kind. Key is used for partitioning by
+// backends which support it (e.g. workflow ID). It is safe to call Export
+// on nil Exporter.
+func (e *Exporter) Export(kind, key string, payload any) {
+	if e == nil {
+		return
+	}
+
+	p, err := json.Marshal(payload)
+	if err != nil {
+		log.Warn().Err(err).Str("kind", kind).Msg("Failed to encode exported event")
+		return
+	}
+
+	id := make([]byte, 16)
+	//nolint:errcheck // crypto/rand never fails on supported platforms
+	rand.Read(id)
+
+	value, err := json.Marshal(Envelope{
+		Schema:   fmt.Sprintf("%s.%s.%s", defaultTopicPrefix, kind, schemaVersion),
+		ID:       hex.EncodeToString(id),
+		Time:     e.now().UTC(),
+		SystemID: e.systemID,
+		Kind:     kind,
+		Payload:  e.redaction.JSON(p),
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("kind", kind).Msg("Failed to encode exported event")
+		return
+	}
+
+	e.queue.Push(message{topic: e.topicPrefix + "." + kind, key: key, value: value})
+}
+
+// BufferStats returns usage and drop counters of events waiting to be
+// published.
+func (e *Exporter) BufferStats() ringbuf.Stats {
+	return e.queue.Stats()
+}
+
+// Run publishes queued events until ctx is cancelled. Events that cannot
+// be published are dropped, as they are only informational.
+func (e *Exporter) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer e.sink.Close()
+
+	for {
+		m, err := e.queue.Pop(ctx)
+		if err != nil {
+			return
+		}
+
+		err = e.sink.Publish(ctx, m.topic, m.key, m.value)
+		if err != nil {
+			// Sinks reconnect on the next publish, which is
+			// likely to succeed if the connection was just stale.
+			err = e.sink.Publish(ctx, m.topic, m.key, m.value)
+		}
+
+		if err != nil {
+			log.Warn().Err(err).Str("topic", m.topic).Msg("Failed to export event")
+		}
+	}
+}
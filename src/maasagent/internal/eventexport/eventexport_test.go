@@ -0,0 +1,168 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eventexport
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/redact"
+)
+
+func TestNewExporter(t *testing.T) {
+	testcases := map[string]struct {
+		cfg      Config
+		disabled bool
+		err      error
+	}{
+		"disabled": {
+			disabled: true,
+		},
+		"nats": {
+			cfg: Config{Backend: BackendNATS, URL: "nats://localhost:4222"},
+		},
+		"nats tls": {
+			cfg: Config{Backend: BackendNATS, URL: "tls://localhost"},
+		},
+		"kafka": {
+			cfg: Config{Backend: BackendKafka, URL: "kafka://localhost:9092"},
+		},
+		"unknown backend": {
+			cfg: Config{Backend: "amqp", URL: "amqp://localhost"},
+			err: ErrUnsupportedBackend,
+		},
+		"invalid nats scheme": {
+			cfg: Config{Backend: BackendNATS, URL: "http://localhost:4222"},
+			err: ErrUnsupportedBackend,
+		},
+		"invalid kafka scheme": {
+			cfg: Config{Backend: BackendKafka, URL: "http://localhost:8082"},
+			err: ErrUnsupportedBackend,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			e, err := NewExporter("abc", tc.cfg)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.disabled, e == nil)
+		})
+	}
+}
+
+// fakeSink sends published messages to a channel
+type fakeSink struct {
+	messages chan message
+}
+
+func (s *fakeSink) Publish(_ context.Context, topic, key string, value []byte) error {
+	s.messages <- message{topic: topic, key: key, value: value}
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func TestExporterRun(t *testing.T) {
+	t.Parallel()
+
+	e, err := NewExporter("abc", Config{Backend: BackendKafka, URL: "kafka://localhost:9092"})
+	require.NoError(t, err)
+
+	sink := &fakeSink{messages: make(chan message, 1)}
+	e.sink = sink
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go e.Run(ctx)
+
+	e.Export(KindWorkflow, "wf-1", WorkflowEvent{
+		State:      StateFailed,
+		Name:       "deploy",
+		WorkflowID: "wf-1",
+		RunID:      "run-1",
+		Error:      "boom",
+	})
+
+	var msg message
+	select {
+	case msg = <-sink.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not exported")
+	}
+
+	assert.Equal(t, "maas.agent.workflow", msg.topic)
+	assert.Equal(t, "wf-1", msg.key)
+
+	var env Envelope
+	require.NoError(t, json.Unmarshal(msg.value, &env))
+	assert.Equal(t, "maas.agent.workflow.v1", env.Schema)
+	assert.Equal(t, "abc", env.SystemID)
+	assert.Equal(t, KindWorkflow, env.Kind)
+	assert.Equal(t, now, env.Time)
+	assert.Len(t, env.ID, 32)
+	assert.JSONEq(t, `{"state":"failed","name":"deploy","workflow_id":"wf-1",
+		"run_id":"run-1","error":"boom"}`, string(env.Payload))
+}
+
+func TestExportNil(t *testing.T) {
+	t.Parallel()
+
+	var e *Exporter
+
+	// Neither must panic, as disabled Exporter is nil
+	e.Export(KindLatency, "", nil)
+	e.Run(context.Background())
+}
+
+func TestExportRedacted(t *testing.T) {
+	t.Parallel()
+
+	f, err := redact.New(redact.Config{Fields: []string{"error"}})
+	require.NoError(t, err)
+
+	e, err := NewExporter("abc", Config{Backend: BackendKafka, URL: "kafka://localhost:9092"},
+		WithRedaction(f))
+	require.NoError(t, err)
+
+	e.Export(KindWorkflow, "wf-1", WorkflowEvent{State: StateFailed, Name: "deploy", Error: "token leaked"})
+
+	msg, err := e.queue.Pop(context.Background())
+	require.NoError(t, err)
+
+	var env Envelope
+	require.NoError(t, json.Unmarshal(msg.value, &env))
+	assert.JSONEq(t, `{"state":"failed","name":"deploy","workflow_id":"","run_id":"",
+		"error":"[REDACTED]"}`, string(env.Payload))
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eventexport
+
+import (
+	"context"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/remediation"
+	"maas.io/core/src/maasagent/internal/slo"
+)
+
+// NewInterceptor returns a worker interceptor that exports state changes
+// of workflows and activities executed by the Agent.
+// Events are not exported while workflow history is replayed.
+func NewInterceptor(e *Exporter) interceptor.WorkerInterceptor {
+	return &exportInterceptor{exporter: e}
+}
+
+type exportInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	exporter *Exporter
+}
+
+func (x *exportInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityExport{exporter: x.exporter}
+	i.Next = next
+
+	return i
+}
+
+func (x *exportInterceptor) InterceptWorkflow(_ workflow.Context,
+	next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	i := &workflowExport{exporter: x.exporter}
+	i.Next = next
+
+	return i
+}
+
+type activityExport struct {
+	interceptor.ActivityInboundInterceptorBase
+	exporter *Exporter
+}
+
+func (a *activityExport) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	start := time.Now()
+
+	res, err := a.Next.ExecuteActivity(ctx, in)
+
+	info := activity.GetInfo(ctx)
+	ev := ActivityEvent{
+		State:      StateCompleted,
+		Name:       info.ActivityType.Name,
+		WorkflowID: info.WorkflowExecution.ID,
+		Duration:   time.Since(start).Milliseconds(),
+		Attempt:    info.Attempt,
+	}
+
+	if err != nil {
+		ev.State = StateFailed
+		ev.Error = err.Error()
+	}
+
+	a.exporter.Export(KindActivity, ev.WorkflowID, ev)
+
+	return res, err
+}
+
+type workflowExport struct {
+	interceptor.WorkflowInboundInterceptorBase
+	exporter *Exporter
+}
+
+func (w *workflowExport) ExecuteWorkflow(ctx workflow.Context,
+	in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	info := workflow.GetInfo(ctx)
+	ev := WorkflowEvent{
+		Name:       info.WorkflowType.Name,
+		WorkflowID: info.WorkflowExecution.ID,
+		RunID:      info.WorkflowExecution.RunID,
+	}
+
+	if !workflow.IsReplaying(ctx) {
+		ev.State = StateStarted
+		w.exporter.Export(KindWorkflow, ev.WorkflowID, ev)
+	}
+
+	res, err := w.Next.ExecuteWorkflow(ctx, in)
+
+	// Workflow continued as new is not finished yet
+	if workflow.IsReplaying(ctx) || workflow.IsContinueAsNewError(err) {
+		return res, err
+	}
+
+	ev.State = StateCompleted
+
+	if err != nil {
+		ev.State = StateFailed
+		ev.Error = err.Error()
+	}
+
+	w.exporter.Export(KindWorkflow, ev.WorkflowID, ev)
+
+	return res, err
+}
+
+// SLOReporter exports SLO events (payload of maas.agent.latency.v1 is
+// slo.Event) and passes them to next, if not nil.
+func SLOReporter(e *Exporter, next slo.Reporter) slo.Reporter {
+	return &sloReporter{exporter: e, next: next}
+}
+
+type sloReporter struct {
+	next     slo.Reporter
+	exporter *Exporter
+}
+
+func (r *sloReporter) Report(ctx context.Context, events []slo.Event) error {
+	for _, ev := range events {
+		r.exporter.Export(KindLatency, ev.Operation, ev)
+	}
+
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.Report(ctx, events)
+}
+
+// RemediationReporter exports remediation notifications (payload of
+// maas.agent.remediation.v1 is remediation.Notification) and passes them
+// to next, if not nil.
+func RemediationReporter(e *Exporter, next remediation.Reporter) remediation.Reporter {
+	return &remediationReporter{exporter: e, next: next}
+}
+
+type remediationReporter struct {
+	next     remediation.Reporter
+	exporter *Exporter
+}
+
+func (r *remediationReporter) Report(ctx context.Context,
+	notifications []remediation.Notification) error {
+	for _, n := range notifications {
+		r.exporter.Export(KindRemediation, n.Rule, n)
+	}
+
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.Report(ctx, notifications)
+}
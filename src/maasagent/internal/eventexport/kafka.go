@@ -0,0 +1,107 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eventexport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+const (
+	kafkaScheme    = "kafka"
+	kafkaTLSScheme = "kafka+tls"
+	kafkaClientID  = "maas-agent"
+	kafkaTimeout   = 30 * time.Second
+)
+
+// kafkaSink produces records to Kafka brokers with the Kafka protocol
+type kafkaSink struct {
+	client *kgo.Client
+}
+
+// kafkaOptions returns client options for cfg, where URL is
+// kafka://host:9092[,host:9092...] (kafka+tls:// for TLS)
+func kafkaOptions(cfg Config) ([]kgo.Opt, error) {
+	scheme, hosts, ok := strings.Cut(cfg.URL, "://")
+	if !ok || hosts == "" {
+		return nil, fmt.Errorf("%w: invalid Kafka URL %q", ErrUnsupportedBackend, cfg.URL)
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(strings.Split(strings.TrimSuffix(hosts, "/"), ",")...),
+		kgo.ClientID(kafkaClientID),
+		kgo.ProduceRequestTimeout(kafkaTimeout),
+		kgo.RecordDeliveryTimeout(kafkaTimeout),
+	}
+
+	switch scheme {
+	case kafkaScheme:
+	case kafkaTLSScheme:
+		// Server name is set by the client for every broker
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	default:
+		return nil, fmt.Errorf("%w: unsupported Kafka URL scheme %q", ErrUnsupportedBackend, scheme)
+	}
+
+	if cfg.Username != "" {
+		opts = append(opts, kgo.SASL(plain.Auth{
+			User: cfg.Username,
+			Pass: cfg.Password,
+		}.AsMechanism()))
+	}
+
+	return opts, nil
+}
+
+func newKafkaSink(cfg Config) (*kafkaSink, error) {
+	opts, err := kafkaOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Brokers are not contacted until the first record is produced
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaSink{client: client}, nil
+}
+
+// Publish produces a single record with the key to the topic and waits
+// until it is acknowledged. Records without a key are spread across
+// partitions.
+func (s *kafkaSink) Publish(ctx context.Context, topic, key string,
+	value []byte) error {
+	record := &kgo.Record{Topic: topic, Value: value}
+	if key != "" {
+		record.Key = []byte(key)
+	}
+
+	return s.client.ProduceSync(ctx, record).FirstErr()
+}
+
+// Close closes connections to brokers
+func (s *kafkaSink) Close() error {
+	s.client.Close()
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eventexport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestNewKafkaSink(t *testing.T) {
+	testcases := map[string]struct {
+		url     string
+		brokers []string
+		err     error
+	}{
+		"single broker": {
+			url:     "kafka://localhost:9092",
+			brokers: []string{"localhost:9092"},
+		},
+		"brokers": {
+			url:     "kafka://10.0.0.1:9092,10.0.0.2:9092/",
+			brokers: []string{"10.0.0.1:9092", "10.0.0.2:9092"},
+		},
+		"tls": {
+			url:     "kafka+tls://kafka.example.com:9093",
+			brokers: []string{"kafka.example.com:9093"},
+		},
+		"rest proxy": {
+			url: "http://localhost:8082",
+			err: ErrUnsupportedBackend,
+		},
+		"no scheme": {
+			url: "localhost:9092",
+			err: ErrUnsupportedBackend,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := newKafkaSink(Config{URL: tc.url, Username: "maas", Password: "secret"})
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			//nolint:errcheck // test sink
+			defer s.Close()
+
+			assert.Equal(t, tc.brokers, s.client.OptValue(kgo.SeedBrokers))
+		})
+	}
+}
+
+func TestKafkaSinkPublishUnreachable(t *testing.T) {
+	t.Parallel()
+
+	// Reserve an address nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	s, err := newKafkaSink(Config{URL: "kafka://" + addr})
+	require.NoError(t, err)
+
+	//nolint:errcheck // test sink
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.Error(t, s.Publish(ctx, "maas.agent.workflow", "wf-1", []byte("{}")))
+}
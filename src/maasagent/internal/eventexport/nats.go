@@ -0,0 +1,119 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eventexport
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	natsConnectTimeout = 10 * time.Second
+	natsClientName     = "maas-agent"
+)
+
+// natsSink publishes messages to NATS subjects
+type natsSink struct {
+	conn *nats.Conn
+	url  string
+	opts []nats.Option
+	mu   sync.Mutex
+}
+
+func newNATSSink(cfg Config) (*natsSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	// tls:// makes the client require TLS
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("%w: unsupported NATS URL scheme %q", ErrUnsupportedBackend, u.Scheme)
+	}
+
+	opts := []nats.Option{
+		nats.Name(natsClientName),
+		nats.Timeout(natsConnectTimeout),
+		// The exporter buffers events itself, so publishing must fail
+		// rather than be buffered while the client reconnects
+		nats.ReconnectBufSize(-1),
+	}
+
+	// Credentials of the configuration take precedence over the URL ones
+	if cfg.Username != "" {
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+
+	return &natsSink{url: cfg.URL, opts: opts}, nil
+}
+
+// Publish sends value to the subject and waits until the server has
+// processed it. Key is ignored, as NATS has no partitioning.
+func (s *natsSink) Publish(ctx context.Context, subject, _ string, value []byte) error {
+	conn, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Publish(subject, value); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, natsConnectTimeout)
+	defer cancel()
+
+	return conn.FlushWithContext(ctx)
+}
+
+// connect returns the connection to the server, which is established on
+// the first call. The client reconnects on its own afterwards.
+func (s *natsSink) connect() (*nats.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil || s.conn.IsClosed() {
+		conn, err := nats.Connect(s.url, s.opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		s.conn = conn
+	}
+
+	return s.conn, nil
+}
+
+// Close closes connection to the server, if any
+func (s *natsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eventexport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// natsConnect are options of CONNECT sent by clients
+type natsConnect struct {
+	User    string `json:"user"`
+	Pass    string `json:"pass"`
+	Token   string `json:"auth_token"`
+	Name    string `json:"name"`
+	Verbose bool   `json:"verbose"`
+}
+
+type natsMessage struct {
+	connect natsConnect
+	subject string
+	payload string
+}
+
+func readNATSLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// fakeNATS accepts a single connection and sends received messages to
+// the returned channel. If reject is set, CONNECT is answered with -ERR.
+func fakeNATS(t *testing.T, reject bool) (string, <-chan natsMessage) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		//nolint:errcheck // test listener
+		l.Close()
+	})
+
+	messages := make(chan natsMessage, 10)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		//nolint:errcheck // test connection
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+
+		var connect natsConnect
+
+		for {
+			line, err := readNATSLine(r)
+			if err != nil {
+				return
+			}
+
+			switch {
+			case strings.HasPrefix(line, "CONNECT "):
+				if reject {
+					fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+					return
+				}
+
+				//nolint:errcheck // test asserts fields
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &connect)
+			case line == "PING":
+				// Server PING must be answered by the client
+				fmt.Fprint(conn, "PONG\r\nPING\r\n")
+			case line == "PONG":
+			case strings.HasPrefix(line, "PUB "):
+				var (
+					subject string
+					size    int
+				)
+
+				_, err := fmt.Sscanf(line, "PUB %s %d", &subject, &size)
+				if err != nil {
+					return
+				}
+
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+
+				messages <- natsMessage{
+					connect: connect,
+					subject: subject,
+					payload: string(payload[:size]),
+				}
+			}
+		}
+	}()
+
+	return l.Addr().String(), messages
+}
+
+func TestNATSSinkPublish(t *testing.T) {
+	t.Parallel()
+
+	addr, messages := fakeNATS(t, false)
+
+	s, err := newNATSSink(Config{URL: "nats://maas:secret@" + addr})
+	require.NoError(t, err)
+
+	//nolint:errcheck // test sink
+	defer s.Close()
+
+	ctx := context.Background()
+
+	require.NoError(t, s.Publish(ctx, "maas.agent.workflow", "", []byte(`{"a":1}`)))
+	require.NoError(t, s.Publish(ctx, "maas.agent.activity", "", []byte("{}")))
+
+	m := <-messages
+	assert.Equal(t, "maas", m.connect.User)
+	assert.Equal(t, "secret", m.connect.Pass)
+	assert.Equal(t, natsClientName, m.connect.Name)
+	assert.False(t, m.connect.Verbose)
+	assert.Equal(t, "maas.agent.workflow", m.subject)
+	assert.Equal(t, `{"a":1}`, m.payload)
+
+	m = <-messages
+	assert.Equal(t, "maas.agent.activity", m.subject)
+	assert.Equal(t, "{}", m.payload)
+}
+
+func TestNATSSinkRejected(t *testing.T) {
+	t.Parallel()
+
+	addr, _ := fakeNATS(t, true)
+
+	s, err := newNATSSink(Config{URL: "nats://" + addr, Token: "invalid"})
+	require.NoError(t, err)
+
+	err = s.Publish(context.Background(), "maas.agent.workflow", "", []byte("{}"))
+	assert.ErrorIs(t, err, nats.ErrAuthorization)
+	assert.Nil(t, s.conn)
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package fshealth periodically checks that data directories used by the Agent
+// are writable and have enough free space. A failing disk or a filesystem
+// remounted read-only is a common failure mode, which otherwise surfaces only
+// as scattered write errors across unrelated subsystems.
+package fshealth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultInterval = time.Minute
+	// defaultMinFree is the minimal ratio of free space
+	defaultMinFree = 0.05
+)
+
+var (
+	// ErrReadOnly is returned when directory is on a read-only filesystem
+	ErrReadOnly = errors.New("filesystem is read-only")
+	// ErrLowDiskSpace is returned when free space is below the threshold
+	ErrLowDiskSpace = errors.New("low disk space")
+)
+
+// Status is the result of a health check of a single directory.
+type Status struct {
+	Err        error  `json:"-"`
+	Subsystem  string `json:"subsystem"`
+	Path       string `json:"path"`
+	Error      string `json:"error,omitempty"`
+	FreeBytes  uint64 `json:"free_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// Healthy returns true if directory is writable and has enough free space.
+func (s Status) Healthy() bool {
+	return s.Err == nil
+}
+
+// Check verifies that dir is writable and at least minFree ratio
+// of the filesystem is free.
+func Check(dir string, minFree float64) Status {
+	s := Status{Path: dir}
+
+	free, total, readOnly, err := statfs(dir)
+	if err != nil {
+		s.setErr(err)
+		return s
+	}
+
+	s.FreeBytes, s.TotalBytes = free, total
+
+	if readOnly {
+		s.setErr(ErrReadOnly)
+		return s
+	}
+
+	if err := probe(dir); err != nil {
+		s.setErr(err)
+		return s
+	}
+
+	if total > 0 && float64(free)/float64(total) < minFree {
+		s.setErr(fmt.Errorf("%w: %d of %d bytes free", ErrLowDiskSpace, free, total))
+	}
+
+	return s
+}
+
+func (s *Status) setErr(err error) {
+	s.Err = err
+	s.Error = err.Error()
+}
+
+// probe writes and removes a small file, because a filesystem can refuse
+// writes (e.g. I/O errors, exhausted inodes) without being mounted read-only.
+func probe(dir string) error {
+	f, err := os.CreateTemp(dir, ".fshealth-*")
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // file is removed regardless
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString("ok"); err != nil {
+		//nolint:errcheck,gosec // we already return a more important error
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		//nolint:errcheck,gosec // we already return a more important error
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Reporter is used to report health changes (e.g. to the Region).
+type Reporter interface {
+	Report(ctx context.Context, statuses []Status) error
+}
+
+// Monitor periodically checks health of directories used by subsystems.
+// Subsystems are expected to consult Healthy() and degrade gracefully
+// (e.g. stop caching) while their directory is unhealthy.
+type Monitor struct {
+	reporter Reporter
+	dirs     map[string]string
+	status   map[string]Status
+	interval time.Duration
+	minFree  float64
+	mutex    sync.RWMutex
+}
+
+// MonitorOption allows to set additional Monitor options
+type MonitorOption func(*Monitor)
+
+// NewMonitor returns a Monitor for dirs, which is a map of subsystem
+// names to directories used by them.
+func NewMonitor(dirs map[string]string, options ...MonitorOption) *Monitor {
+	m := &Monitor{
+		dirs:     dirs,
+		status:   make(map[string]Status),
+		interval: defaultInterval,
+		minFree:  defaultMinFree,
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	return m
+}
+
+// WithInterval sets how often directories are checked.
+// (default: 1 minute)
+func WithInterval(d time.Duration) MonitorOption {
+	return func(m *Monitor) {
+		m.interval = d
+	}
+}
+
+// WithMinFree sets the minimal ratio of free space.
+// (default: 0.05)
+func WithMinFree(ratio float64) MonitorOption {
+	return func(m *Monitor) {
+		m.minFree = ratio
+	}
+}
+
+// WithReporter sets Reporter called whenever health of any subsystem changes.
+func WithReporter(r Reporter) MonitorOption {
+	return func(m *Monitor) {
+		m.reporter = r
+	}
+}
+
+// Run checks directories until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.CheckNow(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckNow checks all directories and reports if health of any has changed.
+func (m *Monitor) CheckNow(ctx context.Context) {
+	changed := false
+
+	for name, dir := range m.dirs {
+		s := Check(dir, m.minFree)
+		s.Subsystem = name
+
+		m.mutex.Lock()
+		prev, ok := m.status[name]
+		m.status[name] = s
+		m.mutex.Unlock()
+
+		if ok && prev.Error == s.Error {
+			continue
+		}
+
+		changed = true
+
+		if s.Healthy() {
+			log.Info().Str("subsystem", name).Str("path", dir).Msg("Filesystem is healthy")
+		} else {
+			log.Warn().Err(s.Err).Str("subsystem", name).Str("path", dir).
+				Msg("Filesystem is unhealthy, subsystem is degraded")
+		}
+	}
+
+	if changed && m.reporter != nil {
+		if err := m.reporter.Report(ctx, m.Statuses()); err != nil {
+			log.Warn().Err(err).Msg("Failed to report filesystem health")
+		}
+	}
+}
+
+// Healthy returns false if the last check of subsystem directory failed.
+// Subsystems that were not checked yet are considered healthy, as well as
+// all subsystems when Monitor is nil (e.g. not configured).
+func (m *Monitor) Healthy(subsystem string) bool {
+	if m == nil {
+		return true
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	s, ok := m.status[subsystem]
+
+	return !ok || s.Healthy()
+}
+
+// Statuses returns the last status of all subsystems sorted by name.
+func (m *Monitor) Statuses() []Status {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	res := make([]Status, 0, len(m.status))
+	for _, s := range m.status {
+		res = append(res, s)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Subsystem < res[j].Subsystem })
+
+	return res
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fshealth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	testcases := map[string]struct {
+		dir     string
+		err     error
+		minFree float64
+	}{
+		"healthy": {
+			dir: dir,
+		},
+		"low disk space": {
+			dir:     dir,
+			minFree: 1.1,
+			err:     ErrLowDiskSpace,
+		},
+		"missing directory": {
+			dir: filepath.Join(dir, "missing"),
+			err: os.ErrNotExist,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := Check(tc.dir, tc.minFree)
+
+			assert.ErrorIs(t, s.Err, tc.err)
+			assert.Equal(t, tc.err == nil, s.Healthy())
+		})
+	}
+}
+
+type fakeReporter struct {
+	reports [][]Status
+}
+
+func (r *fakeReporter) Report(_ context.Context, statuses []Status) error {
+	r.reports = append(r.reports, statuses)
+	return nil
+}
+
+func TestMonitor(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	reporter := &fakeReporter{}
+	m := NewMonitor(map[string]string{"cache": dir, "artifacts": missing},
+		WithReporter(reporter))
+
+	assert.True(t, m.Healthy("artifacts"))
+
+	m.CheckNow(context.Background())
+
+	assert.True(t, m.Healthy("cache"))
+	assert.False(t, m.Healthy("artifacts"))
+	assert.Len(t, reporter.reports, 1)
+
+	// Nothing has changed, hence nothing is reported
+	m.CheckNow(context.Background())
+	assert.Len(t, reporter.reports, 1)
+
+	assert.NoError(t, os.Mkdir(missing, 0o750))
+
+	m.CheckNow(context.Background())
+	assert.True(t, m.Healthy("artifacts"))
+	assert.Len(t, reporter.reports, 2)
+
+	statuses := reporter.reports[1]
+	assert.Equal(t, "artifacts", statuses[0].Subsystem)
+	assert.Equal(t, "cache", statuses[1].Subsystem)
+}
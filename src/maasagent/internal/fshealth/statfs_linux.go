@@ -0,0 +1,39 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build linux
+
+package fshealth
+
+import (
+	"syscall"
+)
+
+// stRdonly is ST_RDONLY mount flag from statvfs(3)
+const stRdonly = 0x1
+
+//nolint:nonamedreturns // named returns document the values
+func statfs(dir string) (free, total uint64, readOnly bool, err error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, false, err
+	}
+
+	//nolint:gosec // block size is always positive
+	bsize := uint64(st.Bsize)
+
+	return st.Bavail * bsize, st.Blocks * bsize, st.Flags&stRdonly != 0, nil
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package hook runs operator defined hooks (local scripts or HTTP calls)
+// before and after operations of the Agent, e.g. to quiesce workloads of
+// a machine before it is powered off. Unlike webhooks, hooks are executed
+// synchronously and can abort the operation.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Stages of operations hooks are run at
+const (
+	StagePre  = "pre"
+	StagePost = "post"
+)
+
+// Failure policies of hooks
+const (
+	// FailureAbort fails the operation when the hook fails. Failed pre
+	// hooks prevent the operation from being performed.
+	FailureAbort = "abort"
+	// FailureContinue logs the failure and carries on with the operation
+	FailureContinue = "continue"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	// maxOutput limits the output of scripts included in errors
+	maxOutput = 1024
+)
+
+var (
+	// ErrInvalidHook is returned when a hook can't be run
+	ErrInvalidHook = errors.New("invalid hook")
+	// ErrHookFailed is returned when a hook with abort policy failed
+	ErrHookFailed = errors.New("hook failed")
+)
+
+// Hook is run at Stage of operations matching any of Operations patterns.
+// Patterns are matched against names of activities and workflows with
+// path.Match, e.g. "power-off" or "deploy*". A hook runs either Command
+// or makes a POST request to URL, with Call as a JSON body (or stdin).
+type Hook struct {
+	Name       string   `yaml:"name" json:"name"`
+	Stage      string   `yaml:"stage" json:"stage"`
+	Operations []string `yaml:"operations" json:"operations"`
+	// Command is the executable and its arguments
+	Command []string `yaml:"command" json:"command,omitempty"`
+	URL     string   `yaml:"url" json:"url,omitempty"`
+	// Timeout of the hook (default: 30s)
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	// OnFailure is FailureAbort (default) or FailureContinue
+	OnFailure string `yaml:"on_failure" json:"on_failure"`
+}
+
+func (h Hook) validate() error {
+	switch h.Stage {
+	case StagePre, StagePost:
+	default:
+		return fmt.Errorf("%w %q: unknown stage %q", ErrInvalidHook, h.Name, h.Stage)
+	}
+
+	switch h.OnFailure {
+	case "", FailureAbort, FailureContinue:
+	default:
+		return fmt.Errorf("%w %q: unknown failure policy %q", ErrInvalidHook, h.Name, h.OnFailure)
+	}
+
+	if (len(h.Command) == 0) == (h.URL == "") {
+		return fmt.Errorf("%w %q: either command or url is required", ErrInvalidHook, h.Name)
+	}
+
+	if h.URL != "" {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("%w %q: url must be http or https", ErrInvalidHook, h.Name)
+		}
+	}
+
+	if len(h.Operations) == 0 {
+		return fmt.Errorf("%w %q: no operations", ErrInvalidHook, h.Name)
+	}
+
+	for _, pattern := range h.Operations {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w %q: %w", ErrInvalidHook, h.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (h Hook) matches(stage, operation string) bool {
+	if h.Stage != stage {
+		return false
+	}
+
+	for _, pattern := range h.Operations {
+		if ok, _ := path.Match(pattern, operation); ok { //nolint:errcheck // validated
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h Hook) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+
+	return defaultTimeout
+}
+
+// Call describes the operation a hook is run for. Parameters of operations
+// are not passed, as they contain BMC credentials.
+type Call struct {
+	Stage     string `json:"stage"`
+	Operation string `json:"operation"`
+	// Machine is the system_id of the machine, or its BMC address if
+	// the Region didn't pass the system_id
+	Machine    string `json:"machine,omitempty"`
+	WorkflowID string `json:"workflow_id,omitempty"`
+	// Error of the failed operation, passed to post hooks
+	Error string `json:"error,omitempty"`
+}
+
+// Runner runs hooks of operations
+type Runner struct {
+	client *http.Client
+	hooks  []Hook
+}
+
+// NewRunner returns Runner of hooks
+func NewRunner(hooks []Hook) (*Runner, error) {
+	for _, h := range hooks {
+		if err := h.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Runner{client: &http.Client{}, hooks: hooks}, nil
+}
+
+// Matches returns true if any hook is run at stage of operation
+func (r *Runner) Matches(stage, operation string) bool {
+	for _, h := range r.hooks {
+		if h.matches(stage, operation) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Timeout returns the longest time hooks at stage of operation can take
+func (r *Runner) Timeout(stage, operation string) time.Duration {
+	var total time.Duration
+
+	for _, h := range r.hooks {
+		if h.matches(stage, operation) {
+			total += h.timeout()
+		}
+	}
+
+	return total
+}
+
+// Run runs hooks matching the stage and operation of call in the order
+// they are configured. It stops at the first failed hook with abort policy
+// and returns ErrHookFailed.
+func (r *Runner) Run(ctx context.Context, call Call) error {
+	for _, h := range r.hooks {
+		if !h.matches(call.Stage, call.Operation) {
+			continue
+		}
+
+		err := r.run(ctx, h, call)
+		if err == nil {
+			continue
+		}
+
+		if h.OnFailure == FailureContinue {
+			log.Warn().Err(err).Str("hook", h.Name).Str("operation", call.Operation).
+				Str("machine", call.Machine).Msg("Hook failed, continuing")
+
+			continue
+		}
+
+		return fmt.Errorf("%w: %s %s hook %q: %w", ErrHookFailed, call.Operation, call.Stage, h.Name, err)
+	}
+
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, h Hook, call Call) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout())
+	defer cancel()
+
+	body, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+
+	if h.URL != "" {
+		return r.post(ctx, h.URL, body)
+	}
+
+	//nolint:gosec // gosec's G204 flags any command execution using variables
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"MAAS_HOOK_STAGE="+call.Stage,
+		"MAAS_HOOK_OPERATION="+call.Operation,
+		"MAAS_HOOK_MACHINE="+call.Machine,
+		"MAAS_HOOK_WORKFLOW_ID="+call.WorkflowID,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > maxOutput {
+			out = out[:maxOutput]
+		}
+
+		if s := strings.TrimSpace(string(out)); s != "" {
+			return fmt.Errorf("%w: %s", err, s)
+		}
+	}
+
+	return err
+}
+
+func (r *Runner) post(ctx context.Context, u string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	//nolint:errcheck // the body is only drained
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxOutput))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRunner(t *testing.T) {
+	testcases := map[string]struct {
+		hook Hook
+		ok   bool
+	}{
+		"command": {
+			hook: Hook{Name: "a", Stage: StagePre, Operations: []string{"power-off"},
+				Command: []string{"true"}},
+			ok: true,
+		},
+		"url": {
+			hook: Hook{Name: "a", Stage: StagePost, Operations: []string{"deploy*"},
+				URL: "https://example.com/hook", OnFailure: FailureContinue},
+			ok: true,
+		},
+		"unknown stage": {
+			hook: Hook{Name: "a", Stage: "during", Operations: []string{"power-off"},
+				Command: []string{"true"}},
+		},
+		"unknown failure policy": {
+			hook: Hook{Name: "a", Stage: StagePre, Operations: []string{"power-off"},
+				Command: []string{"true"}, OnFailure: "retry"},
+		},
+		"command and url": {
+			hook: Hook{Name: "a", Stage: StagePre, Operations: []string{"power-off"},
+				Command: []string{"true"}, URL: "https://example.com/hook"},
+		},
+		"no operations": {
+			hook: Hook{Name: "a", Stage: StagePre, Command: []string{"true"}},
+		},
+		"invalid pattern": {
+			hook: Hook{Name: "a", Stage: StagePre, Operations: []string{"power-["},
+				Command: []string{"true"}},
+		},
+		"invalid url": {
+			hook: Hook{Name: "a", Stage: StagePre, Operations: []string{"power-off"},
+				URL: "ftp://example.com"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewRunner([]Hook{tc.hook})
+			if tc.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidHook)
+			}
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "call.json")
+
+	r, err := NewRunner([]Hook{
+		{
+			Name:       "quiesce",
+			Stage:      StagePre,
+			Operations: []string{"power-off", "power-cycle"},
+			Command:    []string{"sh", "-c", `cat > "$1"; printf '\n%s\n' "$MAAS_HOOK_MACHINE" >> "$1"`, "sh", out},
+		},
+		{
+			Name:       "fail",
+			Stage:      StagePost,
+			Operations: []string{"power-*"},
+			Command:    []string{"sh", "-c", "echo workload still running; exit 3"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, r.Matches(StagePre, "power-cycle"))
+	assert.False(t, r.Matches(StagePre, "power-on"))
+	assert.Equal(t, defaultTimeout, r.Timeout(StagePost, "power-on"))
+
+	call := Call{Stage: StagePre, Operation: "power-off", Machine: "abc123"}
+	require.NoError(t, r.Run(context.Background(), call))
+
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+
+	var got Call
+	require.NoError(t, json.NewDecoder(bytes.NewReader(b)).Decode(&got))
+	assert.Equal(t, call, got)
+	assert.Contains(t, string(b), "\nabc123\n")
+
+	err = r.Run(context.Background(), Call{Stage: StagePost, Operation: "power-off"})
+	assert.ErrorIs(t, err, ErrHookFailed)
+	assert.ErrorContains(t, err, "workload still running")
+}
+
+func TestRunContinue(t *testing.T) {
+	r, err := NewRunner([]Hook{
+		{
+			Name:       "fail",
+			Stage:      StagePre,
+			Operations: []string{"power-off"},
+			Command:    []string{"false"},
+			OnFailure:  FailureContinue,
+		},
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, r.Run(context.Background(), Call{Stage: StagePre, Operation: "power-off"}))
+}
+
+func TestRunURL(t *testing.T) {
+	calls := make(chan Call, 2)
+
+	var status atomic.Int32
+
+	status.Store(http.StatusNoContent)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c Call
+		if err := json.NewDecoder(r.Body).Decode(&c); err == nil {
+			calls <- c
+		}
+
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+
+	r, err := NewRunner([]Hook{
+		{Name: "notify", Stage: StagePost, Operations: []string{"deploy*"}, URL: srv.URL},
+	})
+	require.NoError(t, err)
+
+	call := Call{Stage: StagePost, Operation: "deploy", Machine: "abc123", Error: "failed"}
+	require.NoError(t, r.Run(context.Background(), call))
+	assert.Equal(t, call, <-calls)
+
+	status.Store(http.StatusServiceUnavailable)
+
+	assert.ErrorIs(t, r.Run(context.Background(), call), ErrHookFailed)
+}
+
+func TestRunTimeout(t *testing.T) {
+	r, err := NewRunner([]Hook{
+		{
+			Name:       "slow",
+			Stage:      StagePre,
+			Operations: []string{"power-off"},
+			Command:    []string{"sleep", "10"},
+			Timeout:    10 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, r.Run(context.Background(), Call{Stage: StagePre, Operation: "power-off"}), ErrHookFailed)
+}
+
+func TestMachineID(t *testing.T) {
+	testcases := map[string]struct {
+		args []interface{}
+		out  string
+	}{
+		"system_id": {
+			args: []interface{}{map[string]interface{}{"system_id": "abc123"}},
+			out:  "abc123",
+		},
+		"driver_opts system_id": {
+			args: []interface{}{map[string]interface{}{
+				"driver_opts": map[string]interface{}{"system_id": "abc123", "power_address": "10.0.0.1"},
+			}},
+			out: "abc123",
+		},
+		"power_address": {
+			args: []interface{}{map[string]interface{}{
+				"driver_opts": map[string]interface{}{"power_address": "10.0.0.1"},
+			}},
+			out: "10.0.0.1",
+		},
+		"no args": {},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, machineID(tc.args))
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// errorType is the type of application errors returned by aborted operations
+const errorType = "ErrHookFailed"
+
+// localActivitySlack is added to timeouts of hooks run by workflows
+const localActivitySlack = 10 * time.Second
+
+// NewInterceptor returns a worker interceptor that runs hooks around
+// activities and workflows. Hooks of workflows are run as local
+// activities, so they are not run again when workflow history is replayed.
+func NewInterceptor(r *Runner) interceptor.WorkerInterceptor {
+	return &hookInterceptor{runner: r}
+}
+
+type hookInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	runner *Runner
+}
+
+func (h *hookInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityHooks{runner: h.runner}
+	i.Next = next
+
+	return i
+}
+
+func (h *hookInterceptor) InterceptWorkflow(_ workflow.Context,
+	next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	i := &workflowHooks{runner: h.runner}
+	i.Next = next
+
+	return i
+}
+
+type activityHooks struct {
+	interceptor.ActivityInboundInterceptorBase
+	runner *Runner
+}
+
+func (a *activityHooks) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	info := activity.GetInfo(ctx)
+	call := Call{
+		Operation:  info.ActivityType.Name,
+		Machine:    machineID(in.Args),
+		WorkflowID: info.WorkflowExecution.ID,
+	}
+
+	if a.runner.Matches(StagePre, call.Operation) {
+		call.Stage = StagePre
+		if err := a.runner.Run(ctx, call); err != nil {
+			return nil, temporal.NewNonRetryableApplicationError(err.Error(), errorType, err)
+		}
+	}
+
+	res, err := a.Next.ExecuteActivity(ctx, in)
+
+	if a.runner.Matches(StagePost, call.Operation) {
+		call.Stage = StagePost
+		if err != nil {
+			call.Error = err.Error()
+		}
+
+		if hookErr := a.runner.Run(ctx, call); hookErr != nil && err == nil {
+			return nil, temporal.NewNonRetryableApplicationError(hookErr.Error(), errorType, hookErr)
+		}
+	}
+
+	return res, err
+}
+
+type workflowHooks struct {
+	interceptor.WorkflowInboundInterceptorBase
+	runner *Runner
+}
+
+func (w *workflowHooks) ExecuteWorkflow(ctx workflow.Context,
+	in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	info := workflow.GetInfo(ctx)
+	call := Call{
+		Operation:  info.WorkflowType.Name,
+		Machine:    machineID(in.Args),
+		WorkflowID: info.WorkflowExecution.ID,
+	}
+
+	if w.runner.Matches(StagePre, call.Operation) {
+		call.Stage = StagePre
+		if err := w.run(ctx, call); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := w.Next.ExecuteWorkflow(ctx, in)
+
+	if w.runner.Matches(StagePost, call.Operation) {
+		call.Stage = StagePost
+		if err != nil {
+			call.Error = err.Error()
+		}
+
+		if hookErr := w.run(ctx, call); hookErr != nil && err == nil {
+			return nil, hookErr
+		}
+	}
+
+	return res, err
+}
+
+// run runs hooks of call as a local activity
+func (w *workflowHooks) run(ctx workflow.Context, call Call) error {
+	ctx = workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{
+		StartToCloseTimeout: w.runner.Timeout(call.Stage, call.Operation) + localActivitySlack,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 1},
+	})
+
+	err := workflow.ExecuteLocalActivity(ctx, func(ctx context.Context) error {
+		if err := w.runner.Run(ctx, call); err != nil {
+			return temporal.NewNonRetryableApplicationError(err.Error(), errorType, err)
+		}
+
+		return nil
+	}).Get(ctx, nil)
+
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.Type() == errorType {
+		return temporal.NewNonRetryableApplicationError(appErr.Error(), errorType, nil)
+	}
+
+	return err
+}
+
+// machineID returns the system_id of the machine the operation is performed
+// for, or its BMC address when the Region didn't pass the system_id.
+func machineID(args []interface{}) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	var p struct {
+		SystemID   string                 `json:"system_id"`
+		DriverOpts map[string]interface{} `json:"driver_opts"`
+	}
+
+	b, err := json.Marshal(args[0])
+	if err != nil {
+		return ""
+	}
+
+	if err := json.Unmarshal(b, &p); err != nil {
+		return ""
+	}
+
+	if p.SystemID != "" {
+		return p.SystemID
+	}
+
+	if id, ok := p.DriverOpts["system_id"].(string); ok && id != "" {
+		return id
+	}
+
+	address, _ := p.DriverOpts["power_address"].(string) //nolint:errcheck // missing options are empty
+
+	return address
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package httpproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"runtime"
+)
+
+// Firmware of clients booting over the network
+const (
+	FirmwareUEFI         = "uefi"
+	FirmwareOpenFirmware = "open-firmware"
+	FirmwarePXE          = "pxe"
+)
+
+// Bootloader is a file requested by network booting clients of an
+// architecture. Bootloaders are always selected by the architecture of the
+// client, which might differ from the architecture of the rack (e.g. arm64
+// or ppc64el racks booting amd64 machines).
+type Bootloader struct {
+	Arch     string `json:"arch"`
+	Firmware string `json:"firmware"`
+	File     string `json:"file"`
+}
+
+// Path returns path of the bootloader on the Region
+func (b Bootloader) Path() string {
+	return path.Join("boot-resources/bootloaders", b.Firmware, b.Arch, b.File)
+}
+
+// Bootloaders are served to clients of supported architectures
+var Bootloaders = []Bootloader{
+	{Arch: "amd64", Firmware: FirmwareUEFI, File: "bootx64.efi"},
+	{Arch: "amd64", Firmware: FirmwareUEFI, File: "grubx64.efi"},
+	{Arch: "arm64", Firmware: FirmwareUEFI, File: "bootaa64.efi"},
+	{Arch: "arm64", Firmware: FirmwareUEFI, File: "grubaa64.efi"},
+	{Arch: "ppc64el", Firmware: FirmwareOpenFirmware, File: "bootppc64.bin"},
+	{Arch: "i386", Firmware: FirmwarePXE, File: "lpxelinux.0"},
+	{Arch: "i386", Firmware: FirmwarePXE, File: "chain.c32"},
+	{Arch: "i386", Firmware: FirmwarePXE, File: "ifcpu.c32"},
+	{Arch: "i386", Firmware: FirmwarePXE, File: "ifcpu64.c32"},
+	{Arch: "i386", Firmware: FirmwarePXE, File: "ldlinux.c32"},
+	{Arch: "i386", Firmware: FirmwarePXE, File: "libcom32.c32"},
+	{Arch: "i386", Firmware: FirmwarePXE, File: "libutil.c32"},
+}
+
+// bootloaderRules returns rules rewriting requests of bootloaders (e.g.
+// /grub/grubaa64.efi) to their path on the Region.
+func bootloaderRules(bootloaders []Bootloader) []*RewriteRule {
+	rules := make([]*RewriteRule, len(bootloaders))
+
+	for i, b := range bootloaders {
+		rules[i] = NewRewriteRule(regexp.MustCompile(".*/"+regexp.QuoteMeta(b.File)+"$"), b.Path())
+	}
+
+	return rules
+}
+
+// BootloaderCheck is the result of a self-test of a bootloader
+type BootloaderCheck struct {
+	Bootloader
+	Error     string `json:"error,omitempty"`
+	Available bool   `json:"available"`
+}
+
+// ArchCheck is the result of a self-test of bootloaders of a client
+// architecture
+type ArchCheck struct {
+	Arch        string            `json:"arch"`
+	Bootloaders []BootloaderCheck `json:"bootloaders"`
+	// Available is true if all bootloaders of the architecture are available
+	Available bool `json:"available"`
+}
+
+// SelfTest is the result of self-tests of bootloaders
+type SelfTest struct {
+	// HostArch is the architecture of the rack
+	HostArch string      `json:"host_arch"`
+	Archs    []ArchCheck `json:"archs"`
+}
+
+// CheckBootloaders requests bootloaders through the proxy the same way
+// network booting clients do, so both rewrite rules and availability on
+// the Region are verified. Bodies are not transferred.
+func (p *Proxy) CheckBootloaders(ctx context.Context, bootloaders []Bootloader) SelfTest {
+	res := SelfTest{HostArch: runtime.GOARCH}
+
+	archs := make(map[string]int)
+
+	for _, b := range bootloaders {
+		check := BootloaderCheck{Bootloader: b}
+
+		if err := p.checkBootloader(ctx, b); err != nil {
+			check.Error = err.Error()
+		} else {
+			check.Available = true
+		}
+
+		i, ok := archs[b.Arch]
+		if !ok {
+			i = len(res.Archs)
+			archs[b.Arch] = i
+			res.Archs = append(res.Archs, ArchCheck{Arch: b.Arch, Available: true})
+		}
+
+		res.Archs[i].Bootloaders = append(res.Archs[i].Bootloaders, check)
+		res.Archs[i].Available = res.Archs[i].Available && check.Available
+	}
+
+	return res
+}
+
+func (p *Proxy) checkBootloader(ctx context.Context, b Bootloader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "/"+b.File, nil)
+	if err != nil {
+		return err
+	}
+
+	w := &statusRecorder{header: make(http.Header)}
+
+	p.ServeHTTP(w, req)
+
+	if w.status != http.StatusOK {
+		return fmt.Errorf("%s: %d %s", b.Path(), w.status, http.StatusText(w.status))
+	}
+
+	return nil
+}
+
+// statusRecorder is http.ResponseWriter discarding everything, but status
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (w *statusRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return len(b), nil
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
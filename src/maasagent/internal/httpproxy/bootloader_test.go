@@ -0,0 +1,122 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package httpproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootloaderRules(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out string
+	}{
+		"uefi amd64": {
+			in:  "http://example.com/bootx64.efi",
+			out: "boot-resources/bootloaders/uefi/amd64/bootx64.efi",
+		},
+		"uefi arm64 grub": {
+			in:  "http://example.com/grub/grubaa64.efi",
+			out: "boot-resources/bootloaders/uefi/arm64/grubaa64.efi",
+		},
+		"open firmware ppc64el": {
+			in:  "http://example.com/ppc64el/bootppc64.bin",
+			out: "boot-resources/bootloaders/open-firmware/ppc64el/bootppc64.bin",
+		},
+		"pxe i386": {
+			in:  "http://example.com/ifcpu64.c32",
+			out: "boot-resources/bootloaders/pxe/i386/ifcpu64.c32",
+		},
+		"only whole file names": {
+			in:  "http://example.com/bootx64.efi.sig",
+			out: "/bootx64.efi.sig",
+		},
+		"dots are not wildcards": {
+			in:  "http://example.com/chainxc32",
+			out: "/chainxc32",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, tc.in, nil)
+
+			for _, rule := range bootloaderRules(Bootloaders) {
+				if rule.Rewrite(req) {
+					break
+				}
+			}
+
+			assert.Equal(t, tc.out, req.URL.Path)
+		})
+	}
+}
+
+func TestCheckBootloaders(t *testing.T) {
+	t.Parallel()
+
+	// Region only has bootloaders of amd64 and ppc64el
+	region := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+
+		switch r.URL.Path {
+		case "/boot-resources/bootloaders/uefi/amd64/bootx64.efi",
+			"/boot-resources/bootloaders/uefi/amd64/grubx64.efi",
+			"/boot-resources/bootloaders/open-firmware/ppc64el/bootppc64.bin":
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer region.Close()
+
+	target, err := url.Parse(region.URL)
+	require.NoError(t, err)
+
+	p, err := NewProxy([]*url.URL{target}, WithRewriter(NewRewriter(rewriteRules)))
+	require.NoError(t, err)
+
+	bootloaders := []Bootloader{Bootloaders[0], Bootloaders[1], Bootloaders[2], Bootloaders[4]}
+
+	res := p.CheckBootloaders(context.Background(), bootloaders)
+
+	assert.Equal(t, runtime.GOARCH, res.HostArch)
+	require.Len(t, res.Archs, 3)
+
+	assert.Equal(t, "amd64", res.Archs[0].Arch)
+	assert.True(t, res.Archs[0].Available)
+	assert.Len(t, res.Archs[0].Bootloaders, 2)
+
+	assert.Equal(t, "arm64", res.Archs[1].Arch)
+	assert.False(t, res.Archs[1].Available)
+	assert.Equal(t, "boot-resources/bootloaders/uefi/arm64/bootaa64.efi: 404 Not Found",
+		res.Archs[1].Bootloaders[0].Error)
+
+	assert.Equal(t, "ppc64el", res.Archs[2].Arch)
+	assert.True(t, res.Archs[2].Available)
+}
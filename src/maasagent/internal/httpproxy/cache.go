@@ -16,6 +16,7 @@
 package httpproxy
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
@@ -57,6 +58,10 @@ func (r *CacheRule) getKey(req *http.Request) (string, bool) {
 	return string(result), true
 }
 
+var (
+	ErrCacheUnavailable = errors.New("cache is unavailable")
+)
+
 type Cache interface {
 	Set(key string, value io.Reader, valueSize int64) error
 	Get(key string) (io.ReadSeekCloser, error)
@@ -75,3 +80,24 @@ func NewCacher(rules []*CacheRule, cache Cache) *Cacher {
 
 	return &c
 }
+
+// guardedCache rejects writes while the underlying storage is unhealthy,
+// so the proxy keeps serving requests from the upstream without caching.
+type guardedCache struct {
+	Cache
+	writable func() bool
+}
+
+// NewGuardedCache returns Cache that only stores values when writable
+// returns true. Cached values are served regardless.
+func NewGuardedCache(cache Cache, writable func() bool) Cache {
+	return &guardedCache{Cache: cache, writable: writable}
+}
+
+func (c *guardedCache) Set(key string, value io.Reader, valueSize int64) error {
+	if !c.writable() {
+		return ErrCacheUnavailable
+	}
+
+	return c.Cache.Set(key, value, valueSize)
+}
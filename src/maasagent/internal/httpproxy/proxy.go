@@ -16,6 +16,7 @@
 package httpproxy
 
 import (
+	"context"
 	"errors"
 	"io"
 	"io/fs"
@@ -24,10 +25,14 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
+	"maas.io/core/src/maasagent/internal/subnetmap"
 )
 
 // Proxy is a caching reverse HTTP proxy that sends request to a target.
@@ -35,9 +40,19 @@ type Proxy struct {
 	revproxy *httputil.ReverseProxy
 	rewriter *Rewriter
 	cacher   *Cacher
+	subnets  *subnetmap.Map
 	targets  []*url.URL
 }
 
+// Content-Encoding values of images served from the cache.
+const (
+	encodingZstd     = "zstd"
+	encodingIdentity = "identity"
+)
+
+// targetsKey is a context key for Region endpoints selected for the client
+type targetsKey struct{}
+
 // NewProxy returns a new caching reverse HTTP proxy, that caches all
 // HTTP 200 responses from the random pick target.
 func NewProxy(targets []*url.URL, options ...ProxyOption) (*Proxy, error) {
@@ -77,6 +92,15 @@ func WithCacher(c *Cacher) ProxyOption {
 	}
 }
 
+// WithSubnetMap allows to restrict images and Region endpoints available
+// to a client depending on the subnet it belongs to.
+// Restricting endpoints requires Rewriter, images require Cacher.
+func WithSubnetMap(m *subnetmap.Map) ProxyOption {
+	return func(p *Proxy) {
+		p.subnets = m
+	}
+}
+
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if p.rewriter != nil {
 		for _, rule := range p.rewriter.rules {
@@ -87,6 +111,15 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if p.subnets != nil {
+		var ok bool
+
+		r, ok = p.applySubnetServices(w, r)
+		if !ok {
+			return
+		}
+	}
+
 	if p.cacher != nil {
 		ok := p.getFromCache(w, r)
 		if ok {
@@ -97,7 +130,8 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	p.revproxy.ServeHTTP(w, r)
 }
 
-func (p *Proxy) getFromCache(w http.ResponseWriter, r *http.Request) bool {
+// cacheKey returns the key of the resource requested by r in the cache.
+func (p *Proxy) cacheKey(r *http.Request) (string, bool) {
 	var key string
 
 	ok := true
@@ -108,6 +142,11 @@ func (p *Proxy) getFromCache(w http.ResponseWriter, r *http.Request) bool {
 		}
 	}
 
+	return key, ok
+}
+
+func (p *Proxy) getFromCache(w http.ResponseWriter, r *http.Request) bool {
+	key, ok := p.cacheKey(r)
 	if !ok {
 		return false
 	}
@@ -141,11 +180,122 @@ func (p *Proxy) getFromCache(w http.ResponseWriter, r *http.Request) bool {
 	w.Header().Set("x-cache", "HIT")
 	// Explicity set the content type, so ServeContent doesn't have to guess.
 	w.Header().Set("content-type", "application/octet-stream")
+	w.Header().Add("vary", "Accept-Encoding")
+
+	// Ranges refer to the uncompressed content, hence only complete images
+	// are compressed for clients that accept it.
+	if r.Header.Get("range") == "" && acceptsEncoding(r.Header.Get("accept-encoding"), encodingZstd) {
+		serveZstd(w, r, modtime, reader)
+		return true
+	}
+
 	http.ServeContent(w, r, "", modtime, reader)
 
 	return true
 }
 
+// serveZstd writes content compressed with zstd. Compression is done while
+// the content is sent, as the size of the compressed content is unknown.
+func serveZstd(w http.ResponseWriter, r *http.Request, modtime time.Time, content io.Reader) {
+	w.Header().Set("content-encoding", encodingZstd)
+	w.Header().Set("last-modified", modtime.UTC().Format(http.TimeFormat))
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	enc, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	if err != nil {
+		log.Err(err).Send()
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	if _, err = io.Copy(enc, content); err != nil {
+		log.Warn().Err(err).Msg("Failed to send compressed content")
+	}
+
+	if err = enc.Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to send compressed content")
+	}
+}
+
+// acceptsEncoding reports whether Accept-Encoding header value accepts
+// the content encoding.
+func acceptsEncoding(header, encoding string) bool {
+	for _, value := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(value, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(k, "q") {
+				q, err := strconv.ParseFloat(v, 64)
+				return err == nil && q > 0
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// applySubnetServices rejects requests for images that are not available
+// in the client subnet and selects Region endpoints configured for it.
+func (p *Proxy) applySubnetServices(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	addr, ok := subnetmap.ClientAddr(r)
+	if !ok {
+		return r, true
+	}
+
+	services, ok := p.subnets.Lookup(addr)
+	if !ok {
+		return r, true
+	}
+
+	if len(services.Images) > 0 && p.cacher != nil {
+		for _, rule := range p.cacher.rules {
+			key, ok := rule.getKey(r)
+			if !ok {
+				continue
+			}
+
+			// Cache keys are partial image IDs
+			if !slices.ContainsFunc(services.Images, func(id string) bool {
+				return strings.HasPrefix(id, key) || strings.HasPrefix(key, id)
+			}) {
+				http.Error(w, "image is not available in the subnet", http.StatusForbidden)
+				return nil, false
+			}
+
+			break
+		}
+	}
+
+	var targets []*url.URL
+
+	for _, proxy := range services.Proxies {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			log.Warn().Str("proxy", proxy).Msg("Invalid subnet proxy")
+			continue
+		}
+
+		targets = append(targets, u)
+	}
+
+	if len(targets) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), targetsKey{}, targets))
+	}
+
+	return r, true
+}
+
 // modifyResponse is a function called by the underlying revproxy before response
 // is returned to the client. It is using io.Pipe and io.TeeReader to cache
 // response while it is being read by the client.
@@ -159,17 +309,14 @@ func (p *Proxy) modifyResponse() func(*http.Response) error {
 			return nil
 		}
 
-		var key string
-
-		ok := true
-		for _, rule := range p.cacher.rules {
-			key, ok = rule.getKey(resp.Request)
-			if ok {
-				break
-			}
+		key, ok := p.cacheKey(resp.Request)
+		if !ok {
+			return nil
 		}
 
-		if !ok {
+		// Only uncompressed content is cached, which is requested by
+		// rewriteRequest(). Some Region versions might ignore it.
+		if enc := resp.Header.Get("content-encoding"); enc != "" && enc != encodingIdentity {
 			return nil
 		}
 
@@ -204,8 +351,13 @@ func (p *Proxy) modifyResponse() func(*http.Response) error {
 // we want to dispatch our request and apply certain rewrite rules.
 func (p *Proxy) rewriteRequest() func(pr *httputil.ProxyRequest) {
 	return func(pr *httputil.ProxyRequest) {
+		targets := p.targets
+		if t, ok := pr.In.Context().Value(targetsKey{}).([]*url.URL); ok {
+			targets = t
+		}
+
 		//nolint:gosec // usage of math/rand is ok here
-		target := p.targets[rand.Intn(len(p.targets))]
+		target := targets[rand.Intn(len(targets))]
 
 		targetQuery := target.RawQuery
 		pr.Out.URL.Scheme = target.Scheme
@@ -217,6 +369,14 @@ func (p *Proxy) rewriteRequest() func(pr *httputil.ProxyRequest) {
 		} else {
 			pr.Out.URL.RawQuery = targetQuery + "&" + pr.In.URL.RawQuery
 		}
+
+		// Cached content is served to clients which might not accept
+		// the encoding the client of the request accepts.
+		if p.cacher != nil {
+			if _, ok := p.cacheKey(pr.In); ok {
+				pr.Out.Header.Set("accept-encoding", encodingIdentity)
+			}
+		}
 	}
 }
 
@@ -17,6 +17,7 @@ package httpproxy
 
 import (
 	"bytes"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -24,8 +25,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"maas.io/core/src/maasagent/internal/cache"
+	"maas.io/core/src/maasagent/internal/subnetmap"
 )
 
 func TestProxy(t *testing.T) {
@@ -244,3 +248,188 @@ func TestProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyZstd(t *testing.T) {
+	fileCache := cache.NewFakeFileCache()
+	body := bytes.NewReader([]byte("hello world"))
+	require.NoError(t, fileCache.Set("file", body, int64(body.Len())))
+
+	upstream := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Images are cached uncompressed
+			assert.Equal(t, "identity", r.Header.Get("Accept-Encoding"))
+			w.Header().Set("Content-Encoding", "zstd")
+			w.Write([]byte("compressed"))
+		}))
+	t.Cleanup(upstream.Close)
+
+	target, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	proxy, err := NewProxy([]*url.URL{target},
+		WithRewriter(NewRewriter(nil)),
+		WithCacher(NewCacher([]*CacheRule{
+			NewCacheRule(regexp.MustCompile("/(file.*)"), "$1"),
+		}, fileCache)),
+	)
+	require.NoError(t, err)
+
+	get := func(uri string, headers map[string]string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		for hk, hv := range headers {
+			req.Header.Set(hk, hv)
+		}
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		return w.Result()
+	}
+
+	t.Run("compressed", func(t *testing.T) {
+		resp := get("http://example.com/file", map[string]string{"Accept-Encoding": "gzip, zstd"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "zstd", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+
+		dec, err := zstd.NewReader(resp.Body)
+		require.NoError(t, err)
+
+		defer dec.Close()
+
+		data, err := io.ReadAll(dec)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("hello world"), data)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		resp := get("http://example.com/file", map[string]string{
+			"Accept-Encoding": "zstd",
+			"Range":           "bytes=0-4",
+		})
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		resp := get("http://example.com/file", map[string]string{"Accept-Encoding": "zstd;q=0"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	})
+
+	t.Run("compressed upstream is not cached", func(t *testing.T) {
+		resp := get("http://example.com/file-other", map[string]string{"Accept-Encoding": "zstd"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "MISS", resp.Header.Get("x-cache"))
+
+		_, err := fileCache.Get("file-other")
+		assert.Error(t, err)
+	})
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out bool
+	}{
+		"empty":       {in: "", out: false},
+		"single":      {in: "zstd", out: true},
+		"list":        {in: "gzip, zstd", out: true},
+		"other":       {in: "gzip, br", out: false},
+		"case":        {in: "ZSTD", out: true},
+		"quality":     {in: "gzip;q=1.0, zstd;q=0.5", out: true},
+		"not allowed": {in: "zstd;q=0", out: false},
+		"zero":        {in: "zstd; q=0.000", out: false},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, acceptsEncoding(tc.in, encodingZstd))
+		})
+	}
+}
+
+func TestProxyWithSubnetMap(t *testing.T) {
+	newUpstream := func(body string) *url.URL {
+		upstream := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Write([]byte(body))
+			}))
+		t.Cleanup(upstream.Close)
+
+		u, err := url.Parse(upstream.URL)
+		require.NoError(t, err)
+
+		return u
+	}
+
+	defaultTarget := newUpstream("default")
+	subnetTarget := newUpstream("subnet")
+
+	subnets := subnetmap.New()
+	require.NoError(t, subnets.Update([]subnetmap.Entry{
+		{
+			Subnet: "10.0.0.0/24",
+			Services: subnetmap.Services{
+				Images:  []string{"abc123def"},
+				Proxies: []string{subnetTarget.String()},
+			},
+		},
+	}))
+
+	proxy, err := NewProxy([]*url.URL{defaultTarget},
+		WithRewriter(NewRewriter(nil)),
+		WithCacher(NewCacher(cacheRules, cache.NewFakeFileCache())),
+		WithSubnetMap(subnets),
+	)
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		uri      string
+		clientIP string
+		code     int
+		body     string
+	}{
+		"subnet proxy": {
+			uri:      "http://example.com/boot-resources/abc123/a",
+			clientIP: "10.0.0.5",
+			code:     http.StatusOK,
+			body:     "subnet",
+		},
+		"image not available in the subnet": {
+			uri:      "http://example.com/boot-resources/fff000/b",
+			clientIP: "10.0.0.5",
+			code:     http.StatusForbidden,
+		},
+		"unmapped subnet": {
+			uri:      "http://example.com/boot-resources/fff000/c",
+			clientIP: "192.168.0.5",
+			code:     http.StatusOK,
+			body:     "default",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, tc.uri, nil)
+			req.Header.Set("X-Real-IP", tc.clientIP)
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.code, w.Code)
+
+			if tc.body != "" {
+				assert.Equal(t, tc.body, w.Body.String())
+			}
+		})
+	}
+}
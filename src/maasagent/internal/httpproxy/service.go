@@ -16,40 +16,36 @@
 package httpproxy
 
 import (
+	"context"
+	"encoding/json"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"regexp"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/listener"
+	"maas.io/core/src/maasagent/internal/subnetmap"
 	"maas.io/core/src/maasagent/internal/workflow/log/tag"
 )
 
 const (
 	httpProxyServiceWorkerPoolGroup = "httpproxy-service"
 	socketFileName                  = "httpproxy.sock"
+	// BootloadersPath serves self-tests of bootloaders
+	BootloadersPath = "/bootloaders"
 )
 
 var (
-	rewriteRules = []*RewriteRule{
-		NewRewriteRule(regexp.MustCompile(".*/bootaa64.efi"), "boot-resources/bootloaders/uefi/arm64/bootaa64.efi"),
-		NewRewriteRule(regexp.MustCompile(".*/grubaa64.efi"), "boot-resources/bootloaders/uefi/arm64/grubaa64.efi"),
-		NewRewriteRule(regexp.MustCompile(".*/bootx64.efi"), "boot-resources/bootloaders/uefi/amd64/bootx64.efi"),
-		NewRewriteRule(regexp.MustCompile(".*/grubx64.efi"), "boot-resources/bootloaders/uefi/amd64/grubx64.efi"),
-		NewRewriteRule(regexp.MustCompile(".*/bootppc64.bin"), "boot-resources/bootloaders/open-firmware/ppc64el/bootppc64.bin"),
-		NewRewriteRule(regexp.MustCompile(".*/lpxelinux.0"), "boot-resources/bootloaders/pxe/i386/lpxelinux.0"),
-		NewRewriteRule(regexp.MustCompile(".*/chain.c32"), "boot-resources/bootloaders/pxe/i386/chain.c32"),
-		NewRewriteRule(regexp.MustCompile(".*/ifcpu.c32"), "boot-resources/bootloaders/pxe/i386/ifcpu.c32"),
-		NewRewriteRule(regexp.MustCompile(".*/ifcpu64.c32"), "boot-resources/bootloaders/pxe/i386/ifcpu64.c32"),
-		NewRewriteRule(regexp.MustCompile(".*/ldlinux.c32"), "boot-resources/bootloaders/pxe/i386/ldlinux.c32"),
-		NewRewriteRule(regexp.MustCompile(".*/libcom32.c32"), "boot-resources/bootloaders/pxe/i386/libcom32.c32"),
-		NewRewriteRule(regexp.MustCompile(".*/libutil.c32"), "boot-resources/bootloaders/pxe/i386/libutil.c32"),
+	rewriteRules = append(bootloaderRules(Bootloaders),
 		NewRewriteRule(regexp.MustCompile(".*/images/(.*)"), "boot-resources/$1"),
-	}
+	)
 
 	cacheRules = []*CacheRule{
 		// Matches the partial sha256 we use to identify images.
@@ -60,19 +56,81 @@ var (
 // HTTPProxyService is a service that is used to proxy HTTP requests to the Region.
 // Invocation of this service normally should happen via Temporal.
 type HTTPProxyService struct {
-	listener   net.Listener
-	cache      Cache
-	proxy      *Proxy
+	listener net.Listener
+	cache    Cache
+	// proxy is replaced on reconfiguration, while listeners are kept, so
+	// NGINX and clients are never refused
+	proxy      atomic.Pointer[Proxy]
 	fatal      chan error
 	socketPath string
+	// listeners are optional direct listeners in addition to the socket
+	listeners *listener.Group
+	bindings  []listener.Binding
+	families  listener.Families
+	port      int
+	subnets   *subnetmap.Map
+	observer  func(*http.Request)
+	mutex     sync.Mutex
 }
 
+// HTTPProxyServiceOption allows to set additional HTTPProxyService options
+type HTTPProxyServiceOption func(*HTTPProxyService)
+
 // NewHTTPProxyService returns an instance of HTTPProxyService
-// TODO: consider switching to opts pattern
-func NewHTTPProxyService(socketDir string, cache Cache) *HTTPProxyService {
+func NewHTTPProxyService(socketDir string, cache Cache,
+	options ...HTTPProxyServiceOption) *HTTPProxyService {
 	socketPath := path.Join(socketDir, socketFileName)
 
-	return &HTTPProxyService{cache: cache, socketPath: socketPath}
+	s := &HTTPProxyService{
+		cache:      cache,
+		fatal:      make(chan error, 1),
+		socketPath: socketPath,
+		families:   listener.DualStack,
+	}
+
+	s.listeners = listener.NewGroup("http-proxy", func(l net.Listener) error {
+		//nolint:gosec // same as the socket, see configure
+		return http.Serve(l, http.HandlerFunc(s.serveHTTP))
+	})
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithBindings allows to serve proxy directly on port of specific interfaces
+// or VRFs, in addition to the socket consumed by NGINX.
+func WithBindings(port int, bindings []listener.Binding) HTTPProxyServiceOption {
+	return func(s *HTTPProxyService) {
+		s.port = port
+		s.bindings = bindings
+	}
+}
+
+// WithFamilies allows to enable only IPv4 or IPv6 for the bindings.
+// By default both families are enabled.
+func WithFamilies(families listener.Families) HTTPProxyServiceOption {
+	return func(s *HTTPProxyService) {
+		s.families = families
+	}
+}
+
+// WithSubnetServices allows to restrict images and Region endpoints per
+// client subnet.
+func WithSubnetServices(m *subnetmap.Map) HTTPProxyServiceOption {
+	return func(s *HTTPProxyService) {
+		s.subnets = m
+	}
+}
+
+// WithRequestObserver sets a function every proxied request is passed to,
+// e.g. to watch machines fetching boot files.
+func WithRequestObserver(fn func(*http.Request)) HTTPProxyServiceOption {
+	return func(s *HTTPProxyService) {
+		s.observer = fn
+	}
 }
 
 type getRegionEndpointsResult struct {
@@ -90,20 +148,6 @@ func (s *HTTPProxyService) ConfigurationActivities() map[string]interface{} {
 func (s *HTTPProxyService) configure(ctx tworkflow.Context, systemID string) error {
 	log := tworkflow.GetLogger(ctx)
 
-	// We will close existing listener without graceful shutdown, because it
-	// make no sense in case of endpoints reconfiguration.
-	if s.listener != nil {
-		if err := s.listener.Close(); err != nil {
-			return err
-		}
-	}
-
-	if err := syscall.Unlink(s.socketPath); err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-	}
-
 	var endpointsResult getRegionEndpointsResult
 
 	if err := tworkflow.ExecuteActivity(
@@ -165,39 +209,150 @@ func (s *HTTPProxyService) configure(ctx tworkflow.Context, systemID string) err
 	//nolint:errcheck // nothing to check here
 	_ = tworkflow.Await(ctx, func() bool { return counter == 0 })
 
-	var err error
-
-	s.proxy, err = NewProxy(targets,
+	proxy, err := NewProxy(targets,
 		WithRewriter(NewRewriter(rewriteRules)),
 		WithCacher(NewCacher(cacheRules, s.cache)),
+		WithSubnetMap(s.subnets),
 	)
 	if err != nil {
 		return err
 	}
 
-	s.listener, err = net.Listen("unix", s.socketPath)
+	// Requests in flight are completed by the previous proxy
+	s.proxy.Store(proxy)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listener == nil {
+		if err := s.listen(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.applyBindings(); err != nil {
+		return err
+	}
+
+	log.Info("Starting httpproxy-service", tag.Builder().KV("targets", targets).KeyVals...)
+	// We consider this workflow to be successful without checking if the service
+	// is up & running after a call to http.Serve().
+	// If there will be any error, it should be captured via HTTPProxyService.Error()
+	return nil
+}
+
+// listen creates the socket consumed by NGINX. It is kept for the lifetime
+// of the service.
+func (s *HTTPProxyService) listen() error {
+	if err := syscall.Unlink(s.socketPath); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	l, err := net.Listen("unix", s.socketPath)
 	if err != nil {
 		return err
 	}
 
 	//nolint:gosec // we know what we are doing here and we need 0660
 	if err := os.Chmod(s.socketPath, 0660); err != nil {
+		//nolint:errcheck // we already return a more important error
+		l.Close()
 		return err
 	}
 
+	s.listener = l
+
 	// XXX: While httpproxy-service service is consumed through socket via NGINX
 	// there is nothing bad about not setting the timeout on the listener/server
 
 	//nolint:gosec // this is okay in the current situation
-	go func() { s.fatal <- http.Serve(s.listener, s.proxy) }()
+	go func() { s.fatal <- http.Serve(l, http.HandlerFunc(s.serveHTTP)) }()
 
-	log.Info("Starting httpproxy-service", tag.Builder().KV("targets", targets).KeyVals...)
-	// We consider this workflow to be successful without checking if the service
-	// is up & running after a call to http.Serve().
-	// If there will be any error, it should be captured via HTTPProxyService.Error()
 	return nil
 }
 
+// applyBindings switches direct listeners over to the current bindings.
+// s.mutex must be held.
+func (s *HTTPProxyService) applyBindings() error {
+	if len(s.bindings) == 0 {
+		return s.listeners.Close()
+	}
+
+	return s.listeners.Apply(context.Background(), s.port, s.bindings, s.families)
+}
+
+// SetBindings switches direct listeners over to new bindings without
+// refusing connections in between. Connections accepted on removed
+// bindings are served until they are closed. Bindings are applied by
+// the configuration workflow, if it hasn't run yet.
+func (s *HTTPProxyService) SetBindings(port int, bindings []listener.Binding,
+	families listener.Families) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.port, s.bindings, s.families = port, bindings, families
+
+	if s.proxy.Load() == nil {
+		return nil
+	}
+
+	return s.applyBindings()
+}
+
+func (s *HTTPProxyService) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.observer != nil {
+		s.observer(r)
+	}
+
+	s.proxy.Load().ServeHTTP(w, r)
+}
+
+// BootloadersHandler returns http.Handler serving SelfTest of bootloaders of
+// all supported client architectures, or only of the arch query parameter.
+func (s *HTTPProxyService) BootloadersHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		proxy := s.proxy.Load()
+		if proxy == nil {
+			http.Error(w, "proxy is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		bootloaders := Bootloaders
+
+		if arch := r.URL.Query().Get("arch"); arch != "" {
+			bootloaders = nil
+
+			for _, b := range Bootloaders {
+				if b.Arch == arch {
+					bootloaders = append(bootloaders, b)
+				}
+			}
+
+			if len(bootloaders) == 0 {
+				http.Error(w, "unsupported architecture", http.StatusNotFound)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(proxy.CheckBootloaders(r.Context(), bootloaders))
+	})
+}
+
 func (s *HTTPProxyService) Error() error {
-	return <-s.fatal
+	select {
+	case err := <-s.fatal:
+		return err
+	case err := <-s.listeners.Errors():
+		return err
+	}
 }
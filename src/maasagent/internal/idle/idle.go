@@ -0,0 +1,477 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package idle powers off machines the Region flags as idle, once they have
+// been idle for a grace period, unless they are tagged to be kept on. Energy
+// saved by keeping them off is tracked, so the policy can be justified (or
+// tuned) with numbers rather than guesses.
+package idle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/wake"
+)
+
+// Path is where Policy.Handler is expected to be served
+const Path = "/idle-machines"
+
+const (
+	defaultGracePeriod = 30 * time.Minute
+	// defaultIdleWatts is a typical draw of an idle 1U server
+	defaultIdleWatts = 150
+	checkInterval    = time.Minute
+	powerOffTimeout  = 5 * time.Minute
+)
+
+// ErrStillOn is returned when the machine is on after powering it off
+var ErrStillOn = errors.New("machine is still on after power off")
+
+// Config of the policy, set under power.idle_off in agent.yaml
+type Config struct {
+	// GracePeriod is how long machines have to be idle before they are
+	// powered off
+	GracePeriod time.Duration `yaml:"grace_period" json:"grace_period"`
+	// ExcludeTags are tags of machines which are never powered off
+	ExcludeTags []string `yaml:"exclude_tags" json:"exclude_tags"`
+	// IdleWatts is the power drawn by idle machines, which the Region
+	// doesn't know the power draw of
+	IdleWatts float64 `yaml:"idle_watts" json:"idle_watts"`
+	// Enabled makes the policy power off machines. Otherwise idle machines
+	// are only tracked.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.GracePeriod <= 0 {
+		c.GracePeriod = defaultGracePeriod
+	}
+
+	if c.IdleWatts <= 0 {
+		c.IdleWatts = defaultIdleWatts
+	}
+
+	return c
+}
+
+// Executor runs power actions. It is implemented by power.PowerService.
+type Executor interface {
+	Execute(ctx context.Context, action string, param power.PowerParam) (string, error)
+}
+
+// Parker makes machines powered off by the policy available to be woken up
+// on demand. It is implemented by wake.Service.
+type Parker interface {
+	Park(param wake.ParkMachineParam) error
+}
+
+// IdleMachine is a machine the Region found idle (e.g. allocated but without
+// workload, or ready and not allocated)
+type IdleMachine struct {
+	SystemID   string                 `json:"system_id"`
+	DriverType string                 `json:"driver_type"`
+	DriverOpts map[string]interface{} `json:"driver_opts"`
+	Tags       []string               `json:"tags,omitempty"`
+	// IdleSince is when the machine became idle
+	IdleSince time.Time `json:"idle_since"`
+	// Watts is the power drawn by the machine when idle, if the Region
+	// knows it (e.g. from BMC power readings)
+	Watts float64 `json:"watts,omitempty"`
+	// ReadyAddress and ReadyTimeout are passed on to the wake service, so
+	// the machine is ready once woken up when its service is up
+	ReadyAddress string `json:"ready_address,omitempty"`
+	ReadyTimeout int    `json:"ready_timeout,omitempty"`
+}
+
+// SetIdleMachinesParam is the activity parameter for set-idle-machines
+type SetIdleMachinesParam struct {
+	// Machines are all machines currently idle. Machines which are no
+	// longer idle are left out.
+	Machines []IdleMachine `json:"machines"`
+}
+
+// MachineStatus is the status of an idle machine, as served on Path
+type MachineStatus struct {
+	SystemID  string    `json:"system_id"`
+	IdleSince time.Time `json:"idle_since"`
+	// PoweredOffAt is when the policy powered the machine off
+	PoweredOffAt *time.Time `json:"powered_off_at,omitempty"`
+	// Error of the last attempt to power the machine off
+	Error    string `json:"error,omitempty"`
+	Excluded bool   `json:"excluded,omitempty"`
+}
+
+// Status of the policy, as served on Path
+type Status struct {
+	Config   Config          `json:"config"`
+	Machines []MachineStatus `json:"machines"`
+	// EnergySaved is the energy in watt-hours not drawn by machines while
+	// they were kept off by the policy
+	EnergySaved float64 `json:"energy_saved_wh"`
+}
+
+type machine struct {
+	IdleMachine
+	poweredOffAt time.Time
+	err          string
+	// pending is true while the machine is being powered off
+	pending bool
+}
+
+// Policy powers off machines which have been idle for longer than the grace
+// period. Machines stay flagged, and their savings are counted, until the
+// Region no longer reports them idle, e.g. once they are woken up and used.
+type Policy struct {
+	executor Executor
+	parker   Parker
+	machines map[string]*machine
+	now      func() time.Time
+	// powerOffs counts power offs by result
+	powerOffs metric.Int64Counter
+	cfg       Config
+	// saved is energy in watt-hours saved by machines which are no longer
+	// flagged idle
+	saved float64
+	mutex sync.Mutex
+}
+
+// PolicyOption allows to set additional options for the Policy
+type PolicyOption func(*Policy)
+
+// WithParker makes machines powered off by the policy available to be
+// woken up by parker
+func WithParker(parker Parker) PolicyOption {
+	return func(p *Policy) {
+		p.parker = parker
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// WithMetricMeter allows to set OpenTelemetry metric.Meter to collect
+// machines kept off, their power offs and energy saved.
+func WithMetricMeter(meter metric.Meter) PolicyOption {
+	return func(p *Policy) {
+		p.powerOffs = must(meter.Int64Counter("idle.power_offs",
+			metric.WithDescription("Power offs of idle machines by the policy")))
+
+		must(meter.Float64ObservableCounter("idle.energy_saved",
+			metric.WithUnit("Wh"),
+			metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+				o.Observe(p.energySaved())
+				return nil
+			})))
+
+		must(meter.Int64ObservableGauge("idle.powered_off",
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(int64(p.poweredOff()))
+				return nil
+			})))
+	}
+}
+
+// NewPolicy returns Policy powering machines off with executor
+func NewPolicy(cfg Config, executor Executor, options ...PolicyOption) *Policy {
+	p := &Policy{
+		cfg:      cfg.withDefaults(),
+		executor: executor,
+		machines: make(map[string]*machine),
+		now:      time.Now,
+	}
+
+	for _, opt := range options {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Policy) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (p *Policy) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{"set-idle-machines": p.setIdleMachines}
+}
+
+// setIdleMachines replaces idle machines with the ones of the Region.
+// Machines powered off by the policy keep being counted as such.
+func (p *Policy) setIdleMachines(_ context.Context, param SetIdleMachinesParam) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	idle := make(map[string]bool, len(param.Machines))
+
+	for _, m := range param.Machines {
+		idle[m.SystemID] = true
+
+		if existing, ok := p.machines[m.SystemID]; ok {
+			existing.IdleMachine = m
+			continue
+		}
+
+		p.machines[m.SystemID] = &machine{IdleMachine: m}
+	}
+
+	for systemID, m := range p.machines {
+		if idle[systemID] {
+			continue
+		}
+
+		p.saved += p.savedBy(m, now)
+		delete(p.machines, systemID)
+	}
+
+	return nil
+}
+
+// savedBy returns energy in watt-hours saved by keeping the machine off
+// until now
+func (p *Policy) savedBy(m *machine, now time.Time) float64 {
+	if m.poweredOffAt.IsZero() {
+		return 0
+	}
+
+	watts := m.Watts
+	if watts <= 0 {
+		watts = p.cfg.IdleWatts
+	}
+
+	return watts * now.Sub(m.poweredOffAt).Hours()
+}
+
+func (p *Policy) energySaved() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	saved := p.saved
+
+	for _, m := range p.machines {
+		saved += p.savedBy(m, now)
+	}
+
+	return saved
+}
+
+func (p *Policy) poweredOff() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var n int
+
+	for _, m := range p.machines {
+		if !m.poweredOffAt.IsZero() {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (p *Policy) excluded(m *machine) bool {
+	for _, tag := range m.Tags {
+		if slices.Contains(p.cfg.ExcludeTags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Run powers off machines once they are idle for the grace period, until
+// ctx is cancelled
+func (p *Policy) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.check(ctx)
+		}
+	}
+}
+
+// check powers off machines idle for longer than the grace period, one after
+// another, so they are not powered off in a burst
+func (p *Policy) check(ctx context.Context) {
+	if !p.cfg.Enabled {
+		return
+	}
+
+	p.mutex.Lock()
+
+	now := p.now()
+
+	var due []*machine
+
+	for _, m := range p.machines {
+		if m.pending || !m.poweredOffAt.IsZero() || p.excluded(m) || now.Sub(m.IdleSince) < p.cfg.GracePeriod {
+			continue
+		}
+
+		m.pending = true
+		due = append(due, m)
+	}
+
+	p.mutex.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].IdleSince.Before(due[j].IdleSince) })
+
+	for _, m := range due {
+		if ctx.Err() != nil {
+			p.mutex.Lock()
+			m.pending = false
+			p.mutex.Unlock()
+
+			continue
+		}
+
+		p.powerOff(ctx, m)
+	}
+}
+
+func (p *Policy) powerOff(ctx context.Context, m *machine) {
+	p.mutex.Lock()
+	idle := m.IdleMachine
+	p.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, powerOffTimeout)
+	defer cancel()
+
+	state, err := p.executor.Execute(ctx, "off", power.PowerParam{
+		DriverType: idle.DriverType,
+		DriverOpts: idle.DriverOpts,
+		RequestMetadata: power.RequestMetadata{
+			Requester: "idle-power-off",
+			Reason:    "idle since " + idle.IdleSince.UTC().Format(time.RFC3339),
+			Priority:  power.PriorityLow,
+		},
+	})
+	if err == nil && state == "on" {
+		err = ErrStillOn
+	}
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	if p.powerOffs != nil {
+		p.powerOffs.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+	}
+
+	p.mutex.Lock()
+
+	m.pending = false
+
+	if err != nil {
+		m.err = err.Error()
+	} else {
+		m.poweredOffAt, m.err = p.now(), ""
+	}
+
+	p.mutex.Unlock()
+
+	if err != nil {
+		log.Warn().Err(err).Str("system_id", idle.SystemID).Msg("Failed to power off idle machine")
+		return
+	}
+
+	log.Info().Str("system_id", idle.SystemID).Time("idle_since", idle.IdleSince).
+		Msg("Idle machine powered off")
+
+	if p.parker == nil {
+		return
+	}
+
+	if err := p.parker.Park(wake.ParkMachineParam{
+		SystemID:     idle.SystemID,
+		DriverType:   idle.DriverType,
+		DriverOpts:   idle.DriverOpts,
+		ReadyAddress: idle.ReadyAddress,
+		ReadyTimeout: idle.ReadyTimeout,
+	}); err != nil {
+		log.Warn().Err(err).Str("system_id", idle.SystemID).Msg("Failed to park idle machine")
+	}
+}
+
+// Status returns idle machines, sorted by system_id, and energy saved
+func (p *Policy) Status() Status {
+	saved := p.energySaved()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := Status{Config: p.cfg, Machines: make([]MachineStatus, 0, len(p.machines)), EnergySaved: saved}
+
+	for _, m := range p.machines {
+		s := MachineStatus{
+			SystemID:  m.SystemID,
+			IdleSince: m.IdleSince,
+			Error:     m.err,
+			Excluded:  p.excluded(m),
+		}
+
+		if !m.poweredOffAt.IsZero() {
+			at := m.poweredOffAt.UTC()
+			s.PoweredOffAt = &at
+		}
+
+		status.Machines = append(status.Machines, s)
+	}
+
+	sort.Slice(status.Machines, func(i, j int) bool {
+		return status.Machines[i].SystemID < status.Machines[j].SystemID
+	})
+
+	return status
+}
+
+// Handler serves Status on GET
+func (p *Policy) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing to do if the client went away
+		json.NewEncoder(w).Encode(p.Status())
+	})
+}
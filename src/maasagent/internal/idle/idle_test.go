@@ -0,0 +1,198 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package idle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/wake"
+)
+
+type fakeExecutor struct {
+	// errs are errors of power offs by driver_opts power_id
+	errs   map[string]error
+	params []power.PowerParam
+	mutex  sync.Mutex
+}
+
+func (f *fakeExecutor) Execute(_ context.Context, action string, param power.PowerParam) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if action != "off" {
+		return "", errors.New("unexpected action " + action)
+	}
+
+	f.params = append(f.params, param)
+
+	if err := f.errs[param.DriverOpts["power_id"].(string)]; err != nil {
+		return "", err
+	}
+
+	return "off", nil
+}
+
+func (f *fakeExecutor) poweredOff() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ids := make([]string, 0, len(f.params))
+	for _, p := range f.params {
+		ids = append(ids, p.DriverOpts["power_id"].(string))
+	}
+
+	return ids
+}
+
+type fakeParker struct {
+	parked []string
+}
+
+func (f *fakeParker) Park(param wake.ParkMachineParam) error {
+	f.parked = append(f.parked, param.SystemID)
+	return nil
+}
+
+func idleMachine(systemID string, idleSince time.Time, tags ...string) IdleMachine {
+	return IdleMachine{
+		SystemID:   systemID,
+		DriverType: "ipmi",
+		DriverOpts: map[string]interface{}{"power_id": systemID},
+		Tags:       tags,
+		IdleSince:  idleSince,
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	executor := &fakeExecutor{errs: map[string]error{"failing": power.ErrAuthFailed}}
+	parker := &fakeParker{}
+
+	p := NewPolicy(Config{
+		Enabled:     true,
+		GracePeriod: time.Hour,
+		ExcludeTags: []string{"keep-on"},
+		IdleWatts:   100,
+	}, executor, WithParker(parker))
+	p.now = func() time.Time { return now }
+
+	require.NoError(t, p.setIdleMachines(context.Background(), SetIdleMachinesParam{
+		Machines: []IdleMachine{
+			idleMachine("due", now.Add(-2*time.Hour)),
+			idleMachine("recent", now.Add(-time.Minute)),
+			idleMachine("excluded", now.Add(-2*time.Hour), "keep-on"),
+			idleMachine("failing", now.Add(-3*time.Hour)),
+		},
+	}))
+
+	p.check(context.Background())
+
+	assert.Equal(t, []string{"failing", "due"}, executor.poweredOff())
+	assert.Equal(t, []string{"due"}, parker.parked)
+	assert.Equal(t, "low", executor.params[0].Priority)
+
+	// Powered off machines are not powered off again, failed ones are retried
+	p.check(context.Background())
+
+	assert.Equal(t, []string{"failing", "due", "failing"}, executor.poweredOff())
+
+	status := p.Status()
+	require.Len(t, status.Machines, 4)
+	assert.Equal(t, "due", status.Machines[0].SystemID)
+	assert.Equal(t, now, *status.Machines[0].PoweredOffAt)
+	assert.True(t, status.Machines[1].Excluded)
+	assert.Equal(t, power.ErrAuthFailed.Error(), status.Machines[2].Error)
+	assert.Nil(t, status.Machines[3].PoweredOffAt)
+
+	now = now.Add(3 * time.Hour)
+	assert.InDelta(t, 300, p.energySaved(), 0.001)
+
+	// Savings of machines no longer idle are kept
+	require.NoError(t, p.setIdleMachines(context.Background(), SetIdleMachinesParam{
+		Machines: []IdleMachine{idleMachine("recent", now.Add(-3*time.Hour))},
+	}))
+
+	now = now.Add(time.Hour)
+	assert.InDelta(t, 300, p.energySaved(), 0.001)
+	assert.Equal(t, 0, p.poweredOff())
+}
+
+func TestPolicyMachineWatts(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	p := NewPolicy(Config{Enabled: true}, &fakeExecutor{})
+	p.now = func() time.Time { return now }
+
+	m := idleMachine("abc", now.Add(-defaultGracePeriod))
+	m.Watts = 400
+
+	require.NoError(t, p.setIdleMachines(context.Background(), SetIdleMachinesParam{
+		Machines: []IdleMachine{m, idleMachine("def", now.Add(-defaultGracePeriod))},
+	}))
+
+	p.check(context.Background())
+
+	now = now.Add(30 * time.Minute)
+	assert.InDelta(t, 200+defaultIdleWatts/2, p.energySaved(), 0.001)
+}
+
+func TestPolicyDisabled(t *testing.T) {
+	executor := &fakeExecutor{}
+	p := NewPolicy(Config{}, executor)
+
+	require.NoError(t, p.setIdleMachines(context.Background(), SetIdleMachinesParam{
+		Machines: []IdleMachine{idleMachine("abc", time.Now().Add(-24*time.Hour))},
+	}))
+
+	p.check(context.Background())
+
+	assert.Empty(t, executor.poweredOff())
+	assert.Len(t, p.Status().Machines, 1)
+}
+
+func TestPolicyHandler(t *testing.T) {
+	p := NewPolicy(Config{}, &fakeExecutor{})
+
+	require.NoError(t, p.setIdleMachines(context.Background(), SetIdleMachinesParam{
+		Machines: []IdleMachine{idleMachine("abc", time.Now())},
+	}))
+
+	w := httptest.NewRecorder()
+	p.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, Path, nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var status Status
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, defaultGracePeriod, status.Config.GracePeriod)
+	assert.Equal(t, "abc", status.Machines[0].SystemID)
+
+	w = httptest.NewRecorder()
+	p.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, Path, nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package imagecapture captures disks of deployed machines into reusable
+// images. The machine is booted into the ephemeral environment which
+// streams the disk to the Agent over HTTP, and the image is kept in the
+// artifact store, so it can be served to other machines.
+package imagecapture
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+// PathPrefix is where Service is expected to be served
+const PathPrefix = "/capture/"
+
+// States of a capture
+const (
+	StatePending   = "pending"
+	StateUploading = "uploading"
+	StateDone      = "done"
+	StateFailed    = "failed"
+)
+
+const (
+	artifactKind         = "capture"
+	defaultUploadTimeout = 2 * time.Hour
+)
+
+var (
+	// ErrUnknownCapture is returned when there is no capture prepared
+	// for the artifact key
+	ErrUnknownCapture = errors.New("unknown image capture")
+	// ErrCaptureInProgress is returned when the image is already being uploaded
+	ErrCaptureInProgress = errors.New("image capture is in progress")
+	// ErrInvalidName is returned when image name cannot be used in artifact key
+	ErrInvalidName = errors.New("invalid image name")
+)
+
+// Status is the status of an image capture
+type Status struct {
+	State  string `json:"state"`
+	SHA256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Size   int64  `json:"size"`
+}
+
+type capture struct {
+	expires time.Time
+	status  Status
+}
+
+// Service accepts uploads of captured images into the artifact store.
+// Uploads are only accepted for captures prepared by the workflow, with
+// URLs signed by the Agent.
+type Service struct {
+	store    blob.Store
+	signer   *blob.URLSigner
+	captures map[string]*capture
+	now      func() time.Time
+	systemID string
+	mutex    sync.Mutex
+}
+
+// NewService returns an instance of Service
+func NewService(systemID string, store blob.Store, signer *blob.URLSigner) *Service {
+	return &Service{
+		systemID: systemID,
+		store:    store,
+		signer:   signer,
+		captures: make(map[string]*capture),
+		now:      time.Now,
+	}
+}
+
+// artifactKey returns the key of the image in the artifact store
+func artifactKey(systemID, name string) (string, error) {
+	for _, part := range []string{systemID, name} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, "/\\") {
+			return "", fmt.Errorf("%w: %q", ErrInvalidName, part)
+		}
+	}
+
+	return strings.Join([]string{artifactKind, systemID, name}, "/"), nil
+}
+
+// prepare registers a capture and returns its key together with the path
+// and query of the signed upload URL valid for timeout.
+func (s *Service) prepare(systemID, name string, timeout time.Duration) (string, string, error) {
+	key, err := artifactKey(systemID, name)
+	if err != nil {
+		return "", "", err
+	}
+
+	if timeout <= 0 {
+		timeout = defaultUploadTimeout
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+
+	for k, c := range s.captures {
+		if c.status.State != StateUploading && !now.Before(c.expires) {
+			delete(s.captures, k)
+		}
+	}
+
+	if c, ok := s.captures[key]; ok && c.status.State == StateUploading {
+		return "", "", fmt.Errorf("%w: %s", ErrCaptureInProgress, key)
+	}
+
+	s.captures[key] = &capture{
+		expires: now.Add(timeout),
+		status:  Status{State: StatePending},
+	}
+
+	return key, PathPrefix + key + "?" + s.signer.Sign(key, timeout).Encode(), nil
+}
+
+// Status returns status of the capture
+func (s *Service) Status(key string) (Status, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.captures[key]
+	if !ok {
+		return Status{}, fmt.Errorf("%w: %s", ErrUnknownCapture, key)
+	}
+
+	return c.status, nil
+}
+
+// begin marks the capture as being uploaded. Failed uploads can be retried
+// by the ephemeral environment.
+func (s *Service) begin(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.captures[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownCapture, key)
+	}
+
+	switch c.status.State {
+	case StateUploading:
+		return fmt.Errorf("%w: %s", ErrCaptureInProgress, key)
+	case StateDone:
+		return fmt.Errorf("%w: %s was already uploaded", ErrUnknownCapture, key)
+	}
+
+	c.status = Status{State: StateUploading}
+
+	return nil
+}
+
+func (s *Service) finish(key string, status Status) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if c, ok := s.captures[key]; ok {
+		c.status = status
+	}
+}
+
+// ServeHTTP accepts PUT of the image to the signed URL
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, PathPrefix)
+
+	if err := s.signer.Verify(key, r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := s.begin(key); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, ErrCaptureInProgress) {
+			status = http.StatusConflict
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	h := sha256.New()
+	cr := &countingReader{r: io.TeeReader(r.Body, h)}
+
+	// Negative ContentLength means the size is unknown (chunked upload)
+	if err := s.store.Put(r.Context(), key, cr, r.ContentLength); err != nil {
+		s.finish(key, Status{State: StateFailed, Error: err.Error(), Size: cr.n})
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, blob.ErrQuotaExceeded) {
+			status = http.StatusInsufficientStorage
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	s.finish(key, Status{
+		State:  StateDone,
+		Size:   cr.n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	})
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagecapture
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+func newTestService(t *testing.T) (*Service, blob.Store) {
+	t.Helper()
+
+	store, err := blob.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	return NewService("agent", store, blob.NewURLSigner([]byte("secret"))), store
+}
+
+func upload(t *testing.T, s *Service, path, body string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, req)
+
+	return w.Result()
+}
+
+func TestArtifactKey(t *testing.T) {
+	testcases := map[string]struct {
+		systemID string
+		name     string
+		out      string
+		err      error
+	}{
+		"valid": {
+			systemID: "abc123",
+			name:     "golden.img",
+			out:      "capture/abc123/golden.img",
+		},
+		"empty name": {
+			systemID: "abc123",
+			err:      ErrInvalidName,
+		},
+		"nested name": {
+			systemID: "abc123",
+			name:     "../golden.img",
+			err:      ErrInvalidName,
+		},
+		"parent system_id": {
+			systemID: "..",
+			name:     "golden.img",
+			err:      ErrInvalidName,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			key, err := artifactKey(tc.systemID, tc.name)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, key)
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	s, store := newTestService(t)
+
+	key, path, err := s.prepare("abc123", "golden.img", time.Hour)
+	require.NoError(t, err)
+
+	status, err := s.Status(key)
+	require.NoError(t, err)
+	assert.Equal(t, StatePending, status.State)
+
+	resp := upload(t, s, path, "disk image")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	sum := sha256.Sum256([]byte("disk image"))
+
+	status, err = s.Status(key)
+	require.NoError(t, err)
+	assert.Equal(t, Status{State: StateDone, Size: 10, SHA256: hex.EncodeToString(sum[:])}, status)
+
+	rc, err := store.Get(context.Background(), key)
+	require.NoError(t, err)
+
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "disk image", string(data))
+
+	// Uploaded image cannot be overwritten with the same URL
+	resp = upload(t, s, path, "something else")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestUploadRejected(t *testing.T) {
+	s, _ := newTestService(t)
+	signer := blob.NewURLSigner([]byte("secret"))
+
+	_, path, err := s.prepare("abc123", "golden.img", time.Hour)
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		method string
+		path   string
+		status int
+	}{
+		"wrong method": {
+			method: http.MethodGet,
+			path:   path,
+			status: http.StatusMethodNotAllowed,
+		},
+		"not signed": {
+			method: http.MethodPut,
+			path:   PathPrefix + "capture/abc123/golden.img",
+			status: http.StatusForbidden,
+		},
+		"other key": {
+			method: http.MethodPut,
+			path:   strings.Replace(path, "golden.img", "other.img", 1),
+			status: http.StatusForbidden,
+		},
+		"not prepared": {
+			method: http.MethodPut,
+			path: PathPrefix + "capture/abc123/other.img?" +
+				signer.Sign("capture/abc123/other.img", time.Hour).Encode(),
+			status: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("data"))
+			w := httptest.NewRecorder()
+
+			s.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
+
+func TestPrepareExpired(t *testing.T) {
+	s, _ := newTestService(t)
+
+	key, _, err := s.prepare("abc123", "golden.img", time.Minute)
+	require.NoError(t, err)
+
+	s.now = func() time.Time { return time.Now().Add(time.Hour) }
+
+	// Preparing another capture purges expired ones
+	_, _, err = s.prepare("abc123", "other.img", time.Minute)
+	require.NoError(t, err)
+
+	_, err = s.Status(key)
+	assert.ErrorIs(t, err, ErrUnknownCapture)
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagecapture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	capturePollInterval = 30 * time.Second
+)
+
+var (
+	// ErrCaptureFailed is returned when the image was not uploaded
+	ErrCaptureFailed = errors.New("image capture failed")
+)
+
+// CaptureMachineImageParam is the parameter of capture-machine-image workflow
+type CaptureMachineImageParam struct {
+	// SystemID of the machine to capture
+	SystemID string `json:"system_id"`
+	// Name of the resulting image
+	Name string `json:"name"`
+	// Disk to capture, e.g. "/dev/sda"
+	Disk string `json:"disk"`
+	power.PowerParam
+	// Timeout in seconds for the machine to boot and upload the image
+	Timeout int `json:"timeout"`
+}
+
+// CaptureMachineImageResult is the result of capture-machine-image workflow
+type CaptureMachineImageResult struct {
+	SystemID string `json:"system_id"`
+	Name     string `json:"name"`
+	// Key of the image in the artifact store
+	Key    string `json:"key"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// PrepareImageCaptureParam is the activity parameter for prepare-image-capture
+type PrepareImageCaptureParam struct {
+	SystemID string `json:"system_id"`
+	Name     string `json:"name"`
+	// Timeout in seconds
+	Timeout int `json:"timeout"`
+}
+
+// PrepareImageCaptureResult is the result of prepare-image-capture
+type PrepareImageCaptureResult struct {
+	Key string `json:"key"`
+	// UploadPath is path and query of the signed upload URL on the
+	// Agent HTTP socket
+	UploadPath string `json:"upload_path"`
+}
+
+// GetImageCaptureStatusParam is the activity parameter for get-image-capture-status
+type GetImageCaptureStatusParam struct {
+	Key string `json:"key"`
+}
+
+type ephemeralCaptureParam struct {
+	SystemID   string `json:"system_id"`
+	Disk       string `json:"disk"`
+	UploadPath string `json:"upload_path"`
+}
+
+type machineParam struct {
+	SystemID string `json:"system_id"`
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{"capture-machine-image": s.captureMachineImage}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"prepare-image-capture":    s.prepareImageCapture,
+		"get-image-capture-status": s.getImageCaptureStatus,
+	}
+}
+
+func (s *Service) prepareImageCapture(_ context.Context,
+	param PrepareImageCaptureParam) (*PrepareImageCaptureResult, error) {
+	key, path, err := s.prepare(param.SystemID, param.Name,
+		time.Duration(param.Timeout)*time.Second)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return &PrepareImageCaptureResult{Key: key, UploadPath: path}, nil
+}
+
+func (s *Service) getImageCaptureStatus(_ context.Context, param GetImageCaptureStatusParam) (Status, error) {
+	status, err := s.Status(param.Key)
+	if err != nil {
+		// Captures are not persisted, retrying won't help after a restart
+		return status, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return status, nil
+}
+
+func regionContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              "region",
+		ScheduleToCloseTimeout: 60 * time.Second,
+	})
+}
+
+func (s *Service) powerContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:           fmt.Sprintf("%s@agent:power", s.systemID),
+		StartToCloseTimeout: 60 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+}
+
+func localContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		StartToCloseTimeout: 60 * time.Second,
+	})
+}
+
+// captureMachineImage boots the machine into the ephemeral environment
+// which streams the disk to the Agent, and registers the resulting image
+// with the Region. The machine is powered off and its boot configuration
+// is restored regardless of the outcome.
+func (s *Service) captureMachineImage(ctx tworkflow.Context,
+	param CaptureMachineImageParam) (result *CaptureMachineImageResult, err error) {
+	log := tworkflow.GetLogger(ctx)
+
+	timeout := defaultUploadTimeout
+	if param.Timeout > 0 {
+		timeout = time.Duration(param.Timeout) * time.Second
+	}
+
+	var prepared PrepareImageCaptureResult
+
+	if err := tworkflow.ExecuteActivity(localContext(ctx), "prepare-image-capture",
+		PrepareImageCaptureParam{
+			SystemID: param.SystemID,
+			Name:     param.Name,
+			Timeout:  int(timeout.Seconds()),
+		}).Get(ctx, &prepared); err != nil {
+		return nil, err
+	}
+
+	machine := machineParam{SystemID: param.SystemID}
+
+	if err := tworkflow.ExecuteActivity(s.powerContext(ctx), "power-off",
+		power.PowerOffParam{PowerParam: param.PowerParam}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "set-machine-ephemeral-capture",
+		ephemeralCaptureParam{
+			SystemID:   param.SystemID,
+			Disk:       param.Disk,
+			UploadPath: prepared.UploadPath,
+		}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		// Cleanup must happen even if the workflow was cancelled
+		ctx, cancel := tworkflow.NewDisconnectedContext(ctx)
+		defer cancel()
+
+		cleanupErr := errors.Join(
+			tworkflow.ExecuteActivity(s.powerContext(ctx), "power-off",
+				power.PowerOffParam{PowerParam: param.PowerParam}).Get(ctx, nil),
+			tworkflow.ExecuteActivity(regionContext(ctx), "restore-machine-boot",
+				machine).Get(ctx, nil),
+		)
+		if cleanupErr != nil {
+			log.Error("Failed to restore machine after image capture", tag.Builder().
+				KV("system_id", param.SystemID).Error(cleanupErr).KeyVals...)
+
+			err = errors.Join(err, cleanupErr)
+		}
+	}()
+
+	if err := tworkflow.ExecuteActivity(s.powerContext(ctx), "power-on",
+		power.PowerOnParam{PowerParam: param.PowerParam}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	status, err := waitUploaded(ctx, prepared.Key, tworkflow.Now(ctx).Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	result = &CaptureMachineImageResult{
+		SystemID: param.SystemID,
+		Name:     param.Name,
+		Key:      prepared.Key,
+		SHA256:   status.SHA256,
+		Size:     status.Size,
+	}
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "register-captured-image",
+		result).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	log.Info("Machine image captured", tag.Builder().
+		KV("system_id", param.SystemID).
+		KV("key", result.Key).
+		KV("size", result.Size).KeyVals...)
+
+	return result, nil
+}
+
+func waitUploaded(ctx tworkflow.Context, key string, deadline time.Time) (Status, error) {
+	for {
+		var status Status
+
+		if err := tworkflow.ExecuteActivity(localContext(ctx), "get-image-capture-status",
+			GetImageCaptureStatusParam{Key: key}).Get(ctx, &status); err != nil {
+			return status, err
+		}
+
+		if status.State == StateDone {
+			return status, nil
+		}
+
+		// The ephemeral environment might retry a failed upload, so failure
+		// is only final once the deadline has passed.
+		if !tworkflow.Now(ctx).Before(deadline) {
+			return status, fmt.Errorf("%w: %s was not uploaded in time, state: %s %s",
+				ErrCaptureFailed, key, status.State, status.Error)
+		}
+
+		if err := tworkflow.Sleep(ctx, capturePollInterval); err != nil {
+			return status, err
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagecapture
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+func machineActivity(_ context.Context, _ machineParam) error {
+	return nil
+}
+
+func ephemeralCaptureActivity(_ context.Context, _ ephemeralCaptureParam) error {
+	return nil
+}
+
+func registerImageActivity(_ context.Context, _ *CaptureMachineImageResult) error {
+	return nil
+}
+
+func powerOffActivity(_ context.Context, _ power.PowerOffParam) (*power.PowerOffResult, error) {
+	return nil, nil
+}
+
+func powerOnActivity(_ context.Context, _ power.PowerOnParam) (*power.PowerOnResult, error) {
+	return nil, nil
+}
+
+func newCaptureEnvironment(s *Service) *testsuite.TestWorkflowEnvironment {
+	suite := testsuite.WorkflowTestSuite{}
+	suite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	for name, fn := range s.ConfigurationActivities() {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+
+	env.RegisterActivityWithOptions(powerOffActivity, activity.RegisterOptions{Name: "power-off"})
+	env.RegisterActivityWithOptions(powerOnActivity, activity.RegisterOptions{Name: "power-on"})
+	env.RegisterActivityWithOptions(ephemeralCaptureActivity,
+		activity.RegisterOptions{Name: "set-machine-ephemeral-capture"})
+	env.RegisterActivityWithOptions(machineActivity, activity.RegisterOptions{Name: "restore-machine-boot"})
+	env.RegisterActivityWithOptions(registerImageActivity,
+		activity.RegisterOptions{Name: "register-captured-image"})
+
+	env.OnActivity("power-off", mock.Anything, mock.Anything).
+		Return(&power.PowerOffResult{State: "off"}, nil)
+	env.OnActivity("power-on", mock.Anything, mock.Anything).
+		Return(&power.PowerOnResult{State: "on"}, nil)
+	env.OnActivity("restore-machine-boot", mock.Anything, machineParam{SystemID: "abc123"}).
+		Return(nil).Once()
+
+	return env
+}
+
+func TestCaptureMachineImage(t *testing.T) {
+	s, _ := newTestService(t)
+	env := newCaptureEnvironment(s)
+
+	param := CaptureMachineImageParam{
+		SystemID:   "abc123",
+		Name:       "golden.img",
+		Disk:       "/dev/sda",
+		PowerParam: power.PowerParam{DriverType: "ipmi"},
+	}
+
+	// Ephemeral environment uploads the image once it has booted
+	env.OnActivity("set-machine-ephemeral-capture", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, p ephemeralCaptureParam) error {
+			assert.Equal(t, "/dev/sda", p.Disk)
+
+			env.RegisterDelayedCallback(func() {
+				resp := upload(t, s, p.UploadPath, "disk image")
+				assert.Equal(t, http.StatusCreated, resp.StatusCode)
+			}, 2*capturePollInterval)
+
+			return nil
+		})
+
+	var registered *CaptureMachineImageResult
+
+	env.OnActivity("register-captured-image", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, r *CaptureMachineImageResult) error {
+			registered = r
+			return nil
+		})
+
+	env.ExecuteWorkflow(s.captureMachineImage, param)
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	var result CaptureMachineImageResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+
+	assert.Equal(t, "capture/abc123/golden.img", result.Key)
+	assert.Equal(t, int64(10), result.Size)
+	require.NotNil(t, registered)
+	assert.Equal(t, result, *registered)
+
+	env.AssertExpectations(t)
+}
+
+func TestCaptureMachineImageTimeout(t *testing.T) {
+	s, _ := newTestService(t)
+	env := newCaptureEnvironment(s)
+
+	env.OnActivity("set-machine-ephemeral-capture", mock.Anything, mock.Anything).Return(nil)
+
+	env.ExecuteWorkflow(s.captureMachineImage, CaptureMachineImageParam{
+		SystemID: "abc123",
+		Name:     "golden.img",
+		Timeout:  int(capturePollInterval.Seconds()) * 3,
+	})
+
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), ErrCaptureFailed.Error())
+
+	// Machine boot is restored even though capture failed
+	env.AssertExpectations(t)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagesync
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content-Encoding values understood by the Agent.
+const (
+	EncodingZstd     = "zstd"
+	EncodingGzip     = "gzip"
+	EncodingIdentity = "identity"
+)
+
+var (
+	// ErrUnsupportedEncoding is returned when the Region responds with
+	// Content-Encoding that cannot be decoded by the Agent
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// decoder wraps compressed stream with a reader returning decoded data.
+type decoder func(r io.Reader) (io.ReadCloser, error)
+
+type encoding struct {
+	decoder decoder
+	name    string
+}
+
+// encodings is a list of supported transfer encodings in order of preference.
+var encodings = []encoding{
+	{
+		name: EncodingZstd,
+		decoder: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+
+			return d.IOReadCloser(), nil
+		},
+	},
+	{
+		name: EncodingGzip,
+		decoder: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+}
+
+// AcceptEncoding returns value of Accept-Encoding header advertising
+// all encodings supported by the Agent.
+func AcceptEncoding() string {
+	names := make([]string, 0, len(encodings))
+	for _, e := range encodings {
+		names = append(names, e.name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// NewDecodingReader returns a reader that decodes r according to the value of
+// Content-Encoding header. Identity encoding returns r as is.
+func NewDecodingReader(contentEncoding string, r io.Reader) (io.ReadCloser, error) {
+	contentEncoding = strings.ToLower(strings.TrimSpace(contentEncoding))
+
+	if contentEncoding == "" || contentEncoding == EncodingIdentity {
+		return io.NopCloser(r), nil
+	}
+
+	for _, e := range encodings {
+		if e.name == contentEncoding {
+			return e.decoder(r)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, contentEncoding)
+}
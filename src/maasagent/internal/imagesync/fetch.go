@@ -0,0 +1,237 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagesync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"maas.io/core/src/maasagent/internal/journal"
+)
+
+// OperationFetch is the journal kind of image downloads.
+const OperationFetch = "image-fetch"
+
+const partialExt = ".partial"
+
+// Fetcher downloads images from the Region. Transfer is compressed when
+// the Region supports any of encodings returned by AcceptEncoding().
+type Fetcher struct {
+	client  *http.Client
+	journal *journal.Journal
+}
+
+// FetcherOption allows to set additional Fetcher options
+type FetcherOption func(*Fetcher)
+
+// NewFetcher returns Fetcher using provided http.Client.
+func NewFetcher(client *http.Client, options ...FetcherOption) *Fetcher {
+	f := &Fetcher{client: client}
+
+	for _, opt := range options {
+		opt(f)
+	}
+
+	return f
+}
+
+// WithJournal allows to record in-flight downloads, so they can be resumed
+// with Resume after the Agent restarts.
+func WithJournal(j *journal.Journal) FetcherOption {
+	return func(f *Fetcher) {
+		f.journal = j
+	}
+}
+
+type fetchState struct {
+	URL   string `json:"url"`
+	Image Image  `json:"image"`
+}
+
+// Resume is a journal.RecoverFunc that continues an interrupted download.
+func (f *Fetcher) Resume(ctx context.Context, op journal.Operation) error {
+	var state fetchState
+
+	if err := json.Unmarshal(op.State, &state); err != nil {
+		return err
+	}
+
+	return f.Fetch(ctx, state.URL, state.Image)
+}
+
+// Fetch downloads image from url into img.Path. Data is written into
+// img.Path + ".partial" and only renamed into place once its size and
+// checksum match the expected values, so a partial or corrupted image is
+// never used. If a partial file exists (e.g. the Agent was restarted during
+// the download), only the remaining part is requested from the Region.
+func (f *Fetcher) Fetch(ctx context.Context, url string, img Image) error {
+	if f.journal != nil {
+		if err := f.journal.Begin(img.Path, OperationFetch, fetchState{URL: url, Image: img}); err != nil {
+			return err
+		}
+	}
+
+	partial := img.Path + partialExt
+
+	err := f.fetch(ctx, url, img, partial)
+
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrChecksumMismatch), errors.Is(err, ErrSizeMismatch),
+		errors.Is(err, ErrUnsupportedEncoding):
+		// Partial data cannot be trusted, so the next attempt starts over
+		//nolint:errcheck,gosec // we already return a more important error
+		os.Remove(partial)
+	default:
+		// Partial data is kept, so the download can be resumed
+		return err
+	}
+
+	if f.journal != nil {
+		if jerr := f.journal.Done(img.Path); jerr != nil {
+			return errors.Join(err, jerr)
+		}
+	}
+
+	return err
+}
+
+func (f *Fetcher) fetch(ctx context.Context, url string, img Image, partial string) error {
+	if err := os.MkdirAll(filepath.Dir(img.Path), 0750); err != nil {
+		return err
+	}
+
+	//nolint:gosec // path is provided by the Region
+	tf, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // double Close() is harmless, errors are checked below
+	defer tf.Close()
+
+	h := sha256.New()
+
+	// Existing partial data is hashed first, so the checksum covers the whole image
+	offset, err := io.CopyBuffer(h, tf, make([]byte, verifyBufferSize))
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.request(ctx, url, offset)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// Server ignored the Range header, hence start over
+		if err = truncate(tf); err != nil {
+			return err
+		}
+
+		h.Reset()
+
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Partial file is either complete or bigger than the image
+		if img.Size <= 0 || offset != img.Size {
+			return fmt.Errorf("%w: %s is at least %d bytes", ErrSizeMismatch, url, offset)
+		}
+	default:
+		return fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+
+	body, err := NewDecodingReader(resp.Header.Get("Content-Encoding"), resp.Body)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer body.Close()
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		n, err := io.CopyBuffer(io.MultiWriter(tf, h), body, make([]byte, verifyBufferSize))
+		offset += n
+
+		if err != nil {
+			// Persist what has been received so far, so it can be resumed
+			//nolint:errcheck,gosec // we already return a more important error
+			tf.Sync()
+			return err
+		}
+	}
+
+	if img.Size > 0 && offset != img.Size {
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrSizeMismatch, url, offset, img.Size)
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != img.SHA256 {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, url)
+	}
+
+	if err = tf.Sync(); err != nil {
+		return err
+	}
+
+	if err = tf.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(partial, img.Path)
+}
+
+func (f *Fetcher) request(ctx context.Context, url string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		// Byte ranges of a compressed representation cannot be appended
+		// to decoded data, so resumed downloads are not compressed.
+		req.Header.Set("Accept-Encoding", EncodingIdentity)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		// Setting Accept-Encoding explicitly disables transparent gzip
+		// decompression of http.Transport, hence body is decoded by the caller.
+		req.Header.Set("Accept-Encoding", AcceptEncoding())
+	}
+
+	return f.client.Do(req)
+}
+
+func truncate(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err := f.Seek(0, io.SeekStart)
+
+	return err
+}
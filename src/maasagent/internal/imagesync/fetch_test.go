@@ -0,0 +1,261 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagesync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/journal"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	switch encoding {
+	case EncodingGzip:
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case EncodingZstd:
+		w, err := zstd.NewWriter(&buf)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	default:
+		buf.Write(data)
+	}
+
+	return buf.Bytes()
+}
+
+func TestFetch(t *testing.T) {
+	testcases := map[string]struct {
+		encoding string
+		body     []byte
+		in       Image
+		err      error
+		fails    bool
+	}{
+		"identity": {
+			in: Image{SHA256: squashfsSHA256, Size: 8},
+		},
+		"gzip": {
+			encoding: EncodingGzip,
+			in:       Image{SHA256: squashfsSHA256, Size: 8},
+		},
+		"zstd": {
+			encoding: EncodingZstd,
+			in:       Image{SHA256: squashfsSHA256, Size: 8},
+		},
+		"checksum mismatch": {
+			encoding: EncodingGzip,
+			in:       Image{SHA256: "0000"},
+			err:      ErrChecksumMismatch,
+			fails:    true,
+		},
+		"size mismatch": {
+			in:    Image{SHA256: squashfsSHA256, Size: 10},
+			err:   ErrSizeMismatch,
+			fails: true,
+		},
+		"unsupported encoding": {
+			encoding: "br",
+			in:       Image{SHA256: squashfsSHA256},
+			err:      ErrUnsupportedEncoding,
+			fails:    true,
+		},
+		"corrupted zstd": {
+			encoding: EncodingZstd,
+			body:     []byte("not a zstd frame"),
+			in:       Image{SHA256: squashfsSHA256},
+			fails:    true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			body := tc.body
+			if body == nil {
+				body = compress(t, tc.encoding, []byte("squashfs"))
+			}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, AcceptEncoding(), r.Header.Get("Accept-Encoding"))
+
+				if tc.encoding != "" {
+					w.Header().Set("Content-Encoding", tc.encoding)
+				}
+
+				//nolint:errcheck // test server
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			img := tc.in
+			img.Path = filepath.Join(t.TempDir(), "squashfs")
+
+			err := NewFetcher(server.Client()).Fetch(context.Background(), server.URL, img)
+
+			if !tc.fails {
+				require.NoError(t, err)
+
+				b, err := os.ReadFile(img.Path)
+				require.NoError(t, err)
+				assert.Equal(t, "squashfs", string(b))
+
+				return
+			}
+
+			assert.Error(t, err)
+
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			}
+
+			_, err = os.Stat(img.Path)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+func TestFetchResume(t *testing.T) {
+	testcases := map[string]struct {
+		partial string
+		status  int
+		err     error
+	}{
+		"resumed": {
+			partial: "squa",
+			status:  http.StatusPartialContent,
+		},
+		"complete": {
+			partial: "squashfs",
+			status:  http.StatusRequestedRangeNotSatisfiable,
+		},
+		"corrupted": {
+			partial: "xxxx",
+			status:  http.StatusPartialContent,
+			err:     ErrChecksumMismatch,
+		},
+		"too big": {
+			partial: "squashfs!",
+			status:  http.StatusRequestedRangeNotSatisfiable,
+			err:     ErrSizeMismatch,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, EncodingIdentity, r.Header.Get("Accept-Encoding"))
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader("squashfs"))
+			}))
+			defer server.Close()
+
+			dir := t.TempDir()
+
+			j, err := journal.New(filepath.Join(dir, "journal"))
+			require.NoError(t, err)
+
+			img := Image{Path: filepath.Join(dir, "squashfs"), SHA256: squashfsSHA256, Size: 8}
+			require.NoError(t, os.WriteFile(img.Path+partialExt, []byte(tc.partial), 0o600))
+
+			// Download was interrupted by a restart and is recovered from the journal
+			require.NoError(t, j.Begin(img.Path, OperationFetch, fetchState{URL: server.URL, Image: img}))
+
+			f := NewFetcher(server.Client(), WithJournal(j))
+
+			err = j.Recover(context.Background(), map[string]journal.RecoverFunc{
+				OperationFetch: f.Resume,
+			})
+
+			pending, jerr := j.Pending()
+			require.NoError(t, jerr)
+			assert.Empty(t, pending)
+
+			_, perr := os.Stat(img.Path + partialExt)
+			assert.ErrorIs(t, perr, os.ErrNotExist)
+
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			b, err := os.ReadFile(img.Path)
+			require.NoError(t, err)
+			assert.Equal(t, "squashfs", string(b))
+		})
+	}
+}
+
+func TestFetchKeepsPartialOnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", "8")
+		//nolint:errcheck // test server
+		w.Write([]byte("squa"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	j, err := journal.New(filepath.Join(dir, "journal"))
+	require.NoError(t, err)
+
+	img := Image{Path: filepath.Join(dir, "squashfs"), SHA256: squashfsSHA256, Size: 8}
+
+	err = NewFetcher(server.Client(), WithJournal(j)).Fetch(context.Background(), server.URL, img)
+	assert.Error(t, err)
+
+	b, err := os.ReadFile(img.Path + partialExt)
+	require.NoError(t, err)
+	assert.Equal(t, "squa", string(b))
+
+	pending, err := j.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, OperationFetch, pending[0].Kind)
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	assert.Equal(t, "zstd, gzip", AcceptEncoding())
+}
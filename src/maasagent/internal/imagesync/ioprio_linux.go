@@ -0,0 +1,54 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build linux
+
+package imagesync
+
+import (
+	"syscall"
+)
+
+// Constants from linux/ioprio.h
+const (
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioWhoProcess = 1
+	// ioprioLowestBE is the lowest priority within the best-effort class
+	ioprioLowestBE = 7
+)
+
+// setIOPriority sets I/O priority of the calling thread.
+func setIOPriority(p IOPriority) error {
+	var prio uintptr
+
+	switch p {
+	case IOPriorityBestEffort:
+		prio = ioprioClassBE<<ioprioClassShift | ioprioLowestBE
+	case IOPriorityIdle:
+		prio = ioprioClassIdle << ioprioClassShift
+	default:
+		return nil
+	}
+
+	// who=0 with IOPRIO_WHO_PROCESS refers to the calling thread
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, prio)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
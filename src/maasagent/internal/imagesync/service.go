@@ -0,0 +1,268 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/schedule"
+)
+
+const (
+	// defaultSyncInterval is how often boot resources are synced
+	defaultSyncInterval = time.Hour
+	// syncJitter spreads syncs of Agents over time, so they don't all
+	// download new images from the Region at the same moment
+	syncJitter = 5 * time.Minute
+	// syncTimeout limits the duration of a single sync, which is mostly
+	// spent downloading images
+	syncTimeout = 6 * time.Hour
+)
+
+var (
+	// ErrInvalidResourceName is returned when a boot resource name is not
+	// a local path within the boot resources directory
+	ErrInvalidResourceName = errors.New("invalid boot resource name")
+)
+
+// BootResource is a file the Region expects the Agent to have.
+type BootResource struct {
+	// Name is the path of the resource relative to the boot resources
+	// directory, e.g. ubuntu/amd64/ga-24.04/noble/stable/squashfs
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Service keeps boot resources of the Agent in sync with the Region.
+type Service struct {
+	fetcher  *Fetcher
+	verifier *Verifier
+	systemID string
+	dir      string
+	interval time.Duration
+}
+
+// ServiceOption allows to set additional Service options
+type ServiceOption func(*Service)
+
+// NewService returns Service syncing boot resources into dir with fetcher.
+func NewService(systemID, dir string, fetcher *Fetcher, options ...ServiceOption) *Service {
+	s := &Service{
+		fetcher:  fetcher,
+		verifier: NewVerifier(),
+		systemID: systemID,
+		dir:      dir,
+		interval: defaultSyncInterval,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithVerifier sets the Verifier used to find boot resources that are
+// missing or don't match the Region.
+func WithVerifier(v *Verifier) ServiceOption {
+	return func(s *Service) {
+		s.verifier = v
+	}
+}
+
+// WithSyncInterval sets how often boot resources are synced with the Region.
+// Zero disables periodic syncs. (default: 1 hour)
+func WithSyncInterval(d time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.interval = max(d, 0)
+	}
+}
+
+// SyncBootResourcesParam is the parameter of sync-boot-resources workflow
+type SyncBootResourcesParam struct {
+	SystemID string `json:"system_id"`
+}
+
+// SyncBootResourcesResult is the result of sync-boot-resources workflow
+type SyncBootResourcesResult struct {
+	// Fetched are names of boot resources downloaded from the Region
+	Fetched []string `json:"fetched"`
+}
+
+type getBootResourcesResult struct {
+	Resources []BootResource `json:"resources"`
+}
+
+// VerifyBootResourcesParam is the parameter of verify-boot-resources activity
+type VerifyBootResourcesParam struct {
+	Resources []BootResource `json:"resources"`
+}
+
+// VerifyBootResourcesResult is the result of verify-boot-resources activity
+type VerifyBootResourcesResult struct {
+	// Stale are boot resources that are missing or don't match the checksum
+	Stale []BootResource `json:"stale"`
+}
+
+// FetchBootResourceParam is the parameter of fetch-boot-resource activity
+type FetchBootResourceParam struct {
+	Resource BootResource `json:"resource"`
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{
+		"sync-boot-resources": s.syncBootResources,
+	}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"verify-boot-resources": s.verifyBootResources,
+		"fetch-boot-resource":   s.fetchBootResource,
+	}
+}
+
+// Schedules implements schedule.Provider
+func (s *Service) Schedules() []schedule.Schedule {
+	if s.interval == 0 {
+		return nil
+	}
+
+	return []schedule.Schedule{
+		{
+			ID:               "sync-boot-resources",
+			Workflow:         "sync-boot-resources",
+			Args:             []any{SyncBootResourcesParam{SystemID: s.systemID}},
+			Every:            s.interval,
+			Jitter:           syncJitter,
+			ExecutionTimeout: syncTimeout,
+		},
+	}
+}
+
+// syncBootResources asks the Region which boot resources the Agent should
+// have, verifies checksums of local copies and downloads those that are
+// missing or corrupted. Resources are downloaded one at a time, so a sync
+// doesn't saturate the link to the Region.
+func (s *Service) syncBootResources(ctx tworkflow.Context,
+	param SyncBootResourcesParam) (*SyncBootResourcesResult, error) {
+	regionCtx := tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              "region",
+		ScheduleToCloseTimeout: 60 * time.Second,
+	})
+
+	var resources getBootResourcesResult
+
+	if err := tworkflow.ExecuteActivity(regionCtx, "get-boot-resources", param).
+		Get(ctx, &resources); err != nil {
+		return nil, err
+	}
+
+	if len(resources.Resources) == 0 {
+		return &SyncBootResourcesResult{}, nil
+	}
+
+	verifyCtx := tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+	})
+
+	var verified VerifyBootResourcesResult
+
+	if err := tworkflow.ExecuteActivity(verifyCtx, "verify-boot-resources",
+		VerifyBootResourcesParam{Resources: resources.Resources}).Get(ctx, &verified); err != nil {
+		return nil, err
+	}
+
+	fetchCtx := tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		StartToCloseTimeout: 2 * time.Hour,
+		RetryPolicy: &temporal.RetryPolicy{
+			// Interrupted downloads are resumed by the next attempt
+			MaximumAttempts: 3,
+		},
+	})
+
+	result := &SyncBootResourcesResult{}
+
+	for _, res := range verified.Stale {
+		if err := tworkflow.ExecuteActivity(fetchCtx, "fetch-boot-resource",
+			FetchBootResourceParam{Resource: res}).Get(ctx, nil); err != nil {
+			return nil, err
+		}
+
+		result.Fetched = append(result.Fetched, res.Name)
+	}
+
+	return result, nil
+}
+
+func (s *Service) image(res BootResource) (Image, error) {
+	if !filepath.IsLocal(res.Name) {
+		err := fmt.Errorf("%w: %q", ErrInvalidResourceName, res.Name)
+		return Image{}, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return Image{
+		Path:   filepath.Join(s.dir, res.Name),
+		SHA256: res.SHA256,
+		Size:   res.Size,
+	}, nil
+}
+
+func (s *Service) verifyBootResources(ctx context.Context,
+	param VerifyBootResourcesParam) (*VerifyBootResourcesResult, error) {
+	images := make([]Image, len(param.Resources))
+
+	for i, res := range param.Resources {
+		img, err := s.image(res)
+		if err != nil {
+			return nil, err
+		}
+
+		images[i] = img
+	}
+
+	result := &VerifyBootResourcesResult{}
+
+	for i, res := range s.verifier.Verify(ctx, images) {
+		if res.Err != nil {
+			result.Stale = append(result.Stale, param.Resources[i])
+		}
+	}
+
+	// Cancellation makes every remaining image look stale
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *Service) fetchBootResource(ctx context.Context, param FetchBootResourceParam) error {
+	img, err := s.image(param.Resource)
+	if err != nil {
+		return err
+	}
+
+	return s.fetcher.Fetch(ctx, param.Resource.URL, img)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagesync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// Region activities are implemented in Python, hence dummy activities
+// are required to match function signatures.
+func getBootResourcesActivity(_ context.Context, _ SyncBootResourcesParam) (getBootResourcesResult, error) {
+	return getBootResourcesResult{}, nil
+}
+
+func TestSyncBootResources(t *testing.T) {
+	var requested []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		//nolint:errcheck // test server
+		w.Write([]byte("squashfs"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "noble"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "noble", "squashfs"), []byte("squashfs"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "noble", "kernel"), []byte("corrupted"), 0o600))
+
+	svc := NewService("agent", dir, NewFetcher(server.Client()))
+
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestWorkflowEnvironment()
+
+	env.RegisterWorkflow(svc.syncBootResources)
+	env.RegisterActivityWithOptions(getBootResourcesActivity,
+		activity.RegisterOptions{Name: "get-boot-resources"})
+
+	for name, fn := range svc.ConfigurationActivities() {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+
+	resources := []BootResource{
+		// up to date
+		{Name: "noble/squashfs", URL: server.URL + "/noble/squashfs", SHA256: squashfsSHA256, Size: 8},
+		// corrupted
+		{Name: "noble/kernel", URL: server.URL + "/noble/kernel", SHA256: squashfsSHA256, Size: 8},
+		// missing
+		{Name: "jammy/squashfs", URL: server.URL + "/jammy/squashfs", SHA256: squashfsSHA256, Size: 8},
+	}
+
+	env.OnActivity("get-boot-resources", mock.Anything, SyncBootResourcesParam{SystemID: "agent"}).
+		Return(getBootResourcesResult{Resources: resources}, nil)
+
+	env.ExecuteWorkflow(svc.syncBootResources, SyncBootResourcesParam{SystemID: "agent"})
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	var result SyncBootResourcesResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, []string{"noble/kernel", "jammy/squashfs"}, result.Fetched)
+	assert.Equal(t, []string{"/noble/kernel", "/jammy/squashfs"}, requested)
+
+	for _, res := range resources {
+		data, err := os.ReadFile(filepath.Join(dir, res.Name))
+		require.NoError(t, err)
+		assert.Equal(t, "squashfs", string(data))
+	}
+}
+
+func TestVerifyBootResourcesInvalidName(t *testing.T) {
+	svc := NewService("agent", t.TempDir(), nil)
+
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(svc.verifyBootResources)
+
+	_, err := env.ExecuteActivity(svc.verifyBootResources, VerifyBootResourcesParam{
+		Resources: []BootResource{{Name: "../../etc/passwd", SHA256: squashfsSHA256}},
+	})
+	assert.ErrorContains(t, err, ErrInvalidResourceName.Error())
+}
+
+func TestServiceSchedules(t *testing.T) {
+	svc := NewService("agent", "", nil)
+
+	schedules := svc.Schedules()
+	require.Len(t, schedules, 1)
+	assert.Equal(t, "sync-boot-resources", schedules[0].Workflow)
+	assert.Equal(t, defaultSyncInterval, schedules[0].Every)
+	assert.Equal(t, []any{SyncBootResourcesParam{SystemID: "agent"}}, schedules[0].Args)
+
+	svc = NewService("agent", "", nil, WithSyncInterval(0))
+	assert.Empty(t, svc.Schedules())
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package imagesync provides primitives used to synchronise boot resources
+// (kernels, initrds, squashfs images) from the Region to the Agent.
+package imagesync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// defaultVerifyConcurrency is a conservative default, because checksum
+	// verification is normally bound by disk throughput and not by CPU.
+	defaultVerifyConcurrency = 4
+	verifyBufferSize         = 1024 * 1024
+)
+
+var (
+	// ErrChecksumMismatch is returned when image content doesn't match
+	// the expected checksum
+	ErrChecksumMismatch = errors.New("image checksum mismatch")
+	// ErrSizeMismatch is returned when image size doesn't match
+	// the expected size
+	ErrSizeMismatch = errors.New("image size mismatch")
+)
+
+// IOPriority is a hint for the kernel I/O scheduler used while reading images.
+type IOPriority int
+
+const (
+	// IOPriorityDefault leaves the I/O priority unchanged.
+	IOPriorityDefault IOPriority = iota
+	// IOPriorityBestEffort uses the lowest best-effort priority, so
+	// verification yields to other I/O (e.g. serving images to machines).
+	IOPriorityBestEffort
+	// IOPriorityIdle only reads images when nobody else needs the disk.
+	IOPriorityIdle
+)
+
+// Image is a synced file with its expected checksum.
+type Image struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	// Size is the expected size in bytes. Zero means unknown.
+	Size int64 `json:"size"`
+}
+
+// VerifyResult is the verification outcome of a single image.
+type VerifyResult struct {
+	Err   error
+	Image Image
+}
+
+// Verifier verifies checksums of multiple images in parallel.
+type Verifier struct {
+	concurrency int
+	priority    IOPriority
+}
+
+// VerifierOption allows to set additional Verifier options
+type VerifierOption func(*Verifier)
+
+// NewVerifier returns a Verifier.
+func NewVerifier(options ...VerifierOption) *Verifier {
+	v := &Verifier{
+		concurrency: defaultVerifyConcurrency,
+		priority:    IOPriorityBestEffort,
+	}
+
+	for _, opt := range options {
+		opt(v)
+	}
+
+	return v
+}
+
+// WithConcurrency sets the maximum number of images verified at once.
+// (default: 4)
+func WithConcurrency(n int) VerifierOption {
+	return func(v *Verifier) {
+		if n > 0 {
+			v.concurrency = n
+		}
+	}
+}
+
+// WithIOPriority sets I/O priority hint used while reading images.
+// (default: IOPriorityBestEffort)
+func WithIOPriority(p IOPriority) VerifierOption {
+	return func(v *Verifier) {
+		v.priority = p
+	}
+}
+
+// Verify checks all images and returns a result per image in the same order.
+// A failure of one image doesn't stop verification of the others, however
+// cancellation of ctx does.
+func (v *Verifier) Verify(ctx context.Context, images []Image) []VerifyResult {
+	results := make([]VerifyResult, len(images))
+
+	var g errgroup.Group
+
+	g.SetLimit(v.concurrency)
+
+	for i, img := range images {
+		i, img := i, img
+
+		g.Go(func() error {
+			results[i] = VerifyResult{Image: img, Err: v.verify(ctx, img)}
+			return nil
+		})
+	}
+
+	//nolint:errcheck // goroutines never return errors, results are collected
+	_ = g.Wait()
+
+	return results
+}
+
+func (v *Verifier) verify(ctx context.Context, img Image) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if v.priority != IOPriorityDefault {
+		// I/O priority is set per OS thread, hence the goroutine is locked
+		// to its thread. The thread is not unlocked, which makes the runtime
+		// terminate it once goroutine exits, so the lowered priority never
+		// leaks to unrelated goroutines.
+		runtime.LockOSThread()
+
+		//nolint:errcheck // priority is only a hint, verification continues
+		_ = setIOPriority(v.priority)
+	}
+
+	return verifyFile(ctx, img)
+}
+
+func verifyFile(ctx context.Context, img Image) error {
+	f, err := os.Open(img.Path)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // file is opened read-only
+	defer f.Close()
+
+	h := sha256.New()
+
+	n, err := io.CopyBuffer(h, &ctxReader{ctx: ctx, r: f}, make([]byte, verifyBufferSize))
+	if err != nil {
+		return err
+	}
+
+	if img.Size > 0 && n != img.Size {
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrSizeMismatch, img.Path, n, img.Size)
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != img.SHA256 {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, img.Path)
+	}
+
+	return nil
+}
+
+// ctxReader stops reading once the context is cancelled, so verification
+// of multi-gigabyte images can be interrupted.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package imagesync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo -n 'squashfs' | sha256sum
+const squashfsSHA256 = "5cce3f70c6cb9f62ab53e322fa3975d02128080e1341e41de3a8dd3712cf1607"
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "squashfs")
+	require.NoError(t, os.WriteFile(p, []byte("squashfs"), 0o600))
+
+	testcases := map[string]struct {
+		in  Image
+		err error
+	}{
+		"valid": {
+			in: Image{Path: p, SHA256: squashfsSHA256, Size: 8},
+		},
+		"unknown size": {
+			in: Image{Path: p, SHA256: squashfsSHA256},
+		},
+		"checksum mismatch": {
+			in:  Image{Path: p, SHA256: "0000"},
+			err: ErrChecksumMismatch,
+		},
+		"size mismatch": {
+			in:  Image{Path: p, SHA256: squashfsSHA256, Size: 10},
+			err: ErrSizeMismatch,
+		},
+		"missing file": {
+			in:  Image{Path: filepath.Join(dir, "missing"), SHA256: squashfsSHA256},
+			err: os.ErrNotExist,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, priority := range []IOPriority{IOPriorityDefault, IOPriorityBestEffort, IOPriorityIdle} {
+				res := NewVerifier(WithIOPriority(priority)).Verify(context.Background(), []Image{tc.in})
+
+				require.Len(t, res, 1)
+				assert.Equal(t, tc.in, res[0].Image)
+				assert.ErrorIs(t, res[0].Err, tc.err)
+			}
+		})
+	}
+}
+
+func TestVerifyOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	images := make([]Image, 16)
+
+	for i := range images {
+		p := filepath.Join(dir, strconv.Itoa(i))
+		require.NoError(t, os.WriteFile(p, []byte("squashfs"), 0o600))
+
+		images[i] = Image{Path: p, SHA256: squashfsSHA256}
+	}
+
+	res := NewVerifier(WithConcurrency(3)).Verify(context.Background(), images)
+
+	for i := range images {
+		assert.Equal(t, images[i], res[i].Image)
+		assert.NoError(t, res[i].Err)
+	}
+}
+
+func TestVerifyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res := NewVerifier().Verify(ctx, []Image{{Path: "any", SHA256: squashfsSHA256}})
+
+	assert.ErrorIs(t, res[0].Err, context.Canceled)
+}
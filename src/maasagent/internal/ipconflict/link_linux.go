@@ -0,0 +1,106 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build linux
+
+package ipconflict
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// packetLink is AF_PACKET socket bound to an interface
+type packetLink struct {
+	hwAddr net.HardwareAddr
+	fd     int
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func openLink(name string) (link, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ifi.HardwareAddr) != 6 {
+		return nil, fmt.Errorf("%w: %s is not an ethernet interface", ErrUnsupported, name)
+	}
+
+	proto := htons(syscall.ETH_P_ALL)
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(proto))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{
+		Protocol: proto,
+		Ifindex:  ifi.Index,
+	}); err != nil {
+		//nolint:errcheck // we already return a more important error
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	return &packetLink{fd: fd, hwAddr: ifi.HardwareAddr}, nil
+}
+
+func (l *packetLink) HardwareAddr() net.HardwareAddr {
+	return l.hwAddr
+}
+
+func (l *packetLink) WriteFrame(b []byte) error {
+	_, err := syscall.Write(l.fd, b)
+	return os.NewSyscallError("write", err)
+}
+
+func (l *packetLink) ReadFrame(b []byte, deadline time.Time) (int, error) {
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(l.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return 0, os.NewSyscallError("setsockopt", err)
+	}
+
+	n, err := syscall.Read(l.fd, b)
+	for errors.Is(err, syscall.EINTR) {
+		n, err = syscall.Read(l.fd, b)
+	}
+
+	if errors.Is(err, syscall.EAGAIN) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	if err != nil {
+		return 0, os.NewSyscallError("read", err)
+	}
+
+	return n, nil
+}
+
+func (l *packetLink) Close() error {
+	return syscall.Close(l.fd)
+}
@@ -0,0 +1,370 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ipconflict actively probes for hosts already using IP addresses,
+// so that static IPs are not assigned into an address collision.
+package ipconflict
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+// Methods used to detect a conflict
+const (
+	// MethodARP is ARP probe as defined in RFC 5227
+	MethodARP = "arp"
+	// MethodDAD is IPv6 Duplicate Address Detection as defined in RFC 4862
+	MethodDAD = "dad"
+	// MethodICMP is ICMP echo for addresses that are not on-link
+	MethodICMP = "icmp"
+)
+
+const (
+	minFrameLen = 60
+	ipv6HdrLen  = 40
+
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+	// optTargetLinkLayerAddr is a Neighbor Discovery option
+	optTargetLinkLayerAddr = 2
+)
+
+var (
+	// ErrConflict is returned when an address is already in use
+	ErrConflict = errors.New("IP address conflict")
+	// ErrUnsupported is returned when link probing is not supported
+	ErrUnsupported = errors.New("link probing is not supported on this platform")
+)
+
+// Conflict describes a host that responded for an address
+type Conflict struct {
+	IP     netip.Addr `json:"ip"`
+	MAC    string     `json:"mac"`
+	Method string     `json:"method"`
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s is in use by %s (%s)", c.IP, c.MAC, c.Method)
+}
+
+// link sends and receives raw ethernet frames on an interface
+type link interface {
+	HardwareAddr() net.HardwareAddr
+	WriteFrame(b []byte) error
+	// ReadFrame returns os.ErrDeadlineExceeded when there was nothing to read
+	// before the deadline
+	ReadFrame(b []byte, deadline time.Time) (int, error)
+	Close() error
+}
+
+// probeLink sends count probes for every address, waiting interval between
+// them, and returns hosts which responded for any of the addresses.
+func probeLink(ctx context.Context, l link, ips []netip.Addr,
+	count int, interval time.Duration) (map[netip.Addr]Conflict, error) {
+	hwAddr := l.HardwareAddr()
+	wanted := make(map[netip.Addr]struct{}, len(ips))
+	conflicts := make(map[netip.Addr]Conflict)
+	buf := make([]byte, 1500)
+
+	for _, ip := range ips {
+		wanted[ip] = struct{}{}
+	}
+
+	for i := 0; i < count; i++ {
+		for _, ip := range ips {
+			if _, ok := conflicts[ip]; ok {
+				continue
+			}
+
+			if err := l.WriteFrame(probe(hwAddr, ip)); err != nil {
+				return nil, err
+			}
+		}
+
+		deadline := time.Now().Add(interval)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			n, err := l.ReadFrame(buf, deadline)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			c, ok := parseReply(buf[:n], hwAddr)
+			if !ok {
+				continue
+			}
+
+			if _, ok := wanted[c.IP]; ok {
+				conflicts[c.IP] = c
+			}
+		}
+
+		if len(conflicts) == len(wanted) {
+			break
+		}
+	}
+
+	return conflicts, nil
+}
+
+func probe(hwAddr net.HardwareAddr, ip netip.Addr) []byte {
+	if ip.Is4() {
+		return arpProbe(hwAddr, ip)
+	}
+
+	return dadProbe(hwAddr, ip)
+}
+
+func frame(dst, src net.HardwareAddr, ethType ethernet.EthernetType, payload []byte) []byte {
+	b := make([]byte, 0, minFrameLen)
+	b = append(b, dst...)
+	b = append(b, src...)
+	b = binary.BigEndian.AppendUint16(b, uint16(ethType))
+	b = append(b, payload...)
+
+	// Raw frames are not padded by the kernel
+	for len(b) < minFrameLen {
+		b = append(b, 0)
+	}
+
+	return b
+}
+
+// arpProbe returns ARP request with all-zero sender IP address, so that the
+// probe does not pollute ARP caches of other hosts (RFC 5227)
+func arpProbe(hwAddr net.HardwareAddr, ip netip.Addr) []byte {
+	b := make([]byte, 0, 28)
+	b = binary.BigEndian.AppendUint16(b, uint16(ethernet.HardwareTypeEthernet))
+	b = binary.BigEndian.AppendUint16(b, uint16(ethernet.ProtocolTypeIPv4))
+	b = append(b, 6, 4)
+	b = binary.BigEndian.AppendUint16(b, ethernet.OpRequest)
+	b = append(b, hwAddr...)
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, 0, 0, 0, 0, 0, 0)
+	b = append(b, ip.AsSlice()...)
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	return frame(broadcast, hwAddr, ethernet.EthernetTypeARP, b)
+}
+
+// dadProbe returns Neighbor Solicitation sent from the unspecified address to
+// the solicited-node multicast address of the target (RFC 4862)
+func dadProbe(hwAddr net.HardwareAddr, ip netip.Addr) []byte {
+	target := ip.As16()
+
+	dst := [16]byte{0: 0xff, 1: 0x02, 11: 0x01, 12: 0xff}
+	copy(dst[13:], target[13:])
+
+	icmp := make([]byte, 24)
+	icmp[0] = icmpv6NeighborSolicitation
+	copy(icmp[8:], target[:])
+	binary.BigEndian.PutUint16(icmp[2:], icmpv6Checksum(netip.IPv6Unspecified().As16(), dst, icmp))
+
+	b := make([]byte, ipv6HdrLen, ipv6HdrLen+len(icmp))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:], uint16(len(icmp)))
+	b[6] = 58 // ICMPv6
+	b[7] = 255
+	copy(b[24:], dst[:])
+	b = append(b, icmp...)
+
+	mcast := net.HardwareAddr{0x33, 0x33, dst[12], dst[13], dst[14], dst[15]}
+
+	return frame(mcast, hwAddr, ethernet.EthernetTypeIPv6, b)
+}
+
+func icmpv6Checksum(src, dst [16]byte, msg []byte) uint16 {
+	var sum uint32
+
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+
+	add(src[:])
+	add(dst[:])
+	//nolint:gosec // ICMPv6 messages are never larger than 4GB
+	add(binary.BigEndian.AppendUint32(nil, uint32(len(msg))))
+	add([]byte{0, 0, 0, 58})
+	add(msg)
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	//nolint:gosec // folded into 16 bits above
+	return ^uint16(sum)
+}
+
+// parseReply returns a conflict if the frame shows another host using an
+// address. Probes of other hosts (e.g. another DAD in progress) are ignored.
+func parseReply(b []byte, hwAddr net.HardwareAddr) (Conflict, bool) {
+	eth := &ethernet.EthernetFrame{}
+	if err := eth.UnmarshalBinary(b); err != nil {
+		return Conflict{}, false
+	}
+
+	if bytes.Equal(eth.SrcMAC, hwAddr) {
+		return Conflict{}, false
+	}
+
+	ethType, payload := eth.EthernetType, eth.Payload
+
+	if ethType == ethernet.EthernetTypeVLAN {
+		vlan, err := eth.ExtractVLAN()
+		if err != nil {
+			return Conflict{}, false
+		}
+
+		ethType, payload = vlan.EthernetType, payload[4:]
+	}
+
+	switch ethType {
+	case ethernet.EthernetTypeARP:
+		pkt, err := eth.ExtractARPPacket()
+		if err != nil || !pkt.SendIPAddr.Is4() || pkt.SendIPAddr.IsUnspecified() {
+			return Conflict{}, false
+		}
+
+		return Conflict{IP: pkt.SendIPAddr, MAC: pkt.SendHwAddr.String(), Method: MethodARP}, true
+	case ethernet.EthernetTypeIPv6:
+		return parseNeighborAdvertisement(payload, eth.SrcMAC)
+	}
+
+	return Conflict{}, false
+}
+
+func parseNeighborAdvertisement(b []byte, src net.HardwareAddr) (Conflict, bool) {
+	if len(b) < ipv6HdrLen+24 || b[6] != 58 || b[ipv6HdrLen] != icmpv6NeighborAdvertisement {
+		return Conflict{}, false
+	}
+
+	icmp := b[ipv6HdrLen:]
+	if l := int(binary.BigEndian.Uint16(b[4:])); l < len(icmp) {
+		icmp = icmp[:l]
+	}
+
+	target, ok := netip.AddrFromSlice(icmp[8:24])
+	if !ok {
+		return Conflict{}, false
+	}
+
+	mac := src
+
+	// Prefer Target Link-Layer Address, as the advertisement might be sent
+	// by a proxy
+	for opts := icmp[24:]; len(opts) >= 8 && opts[1] != 0; opts = opts[int(opts[1])*8:] {
+		if int(opts[1])*8 > len(opts) {
+			break
+		}
+
+		if opts[0] == optTargetLinkLayerAddr {
+			mac = net.HardwareAddr(opts[2:8])
+			break
+		}
+	}
+
+	return Conflict{IP: target, MAC: mac.String(), Method: MethodDAD}, true
+}
+
+// interfacePrefixes returns prefixes of all non-loopback interfaces that are up
+func interfacePrefixes() (map[string][]netip.Prefix, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]netip.Prefix)
+
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			prefix, err := netip.ParsePrefix(addr.String())
+			if err != nil {
+				continue
+			}
+
+			result[ifi.Name] = append(result[ifi.Name], prefix.Masked())
+		}
+	}
+
+	return result, nil
+}
+
+// groupByLink returns addresses which are on-link grouped by interface name,
+// and addresses which are only reachable through a router.
+func groupByLink(prefixes map[string][]netip.Prefix,
+	ips []netip.Addr) (map[string][]netip.Addr, []netip.Addr) {
+	onLink := make(map[string][]netip.Addr)
+
+	var routed []netip.Addr
+
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		name := ""
+
+		// Prefer the most specific prefix, e.g. when subnets overlap
+		bits := -1
+
+		for iface, ps := range prefixes {
+			for _, p := range ps {
+				if p.Contains(ip) && (p.Bits() > bits || p.Bits() == bits && iface < name) {
+					name, bits = iface, p.Bits()
+				}
+			}
+		}
+
+		if name == "" {
+			routed = append(routed, ip)
+			continue
+		}
+
+		onLink[name] = append(onLink[name], ip)
+	}
+
+	return onLink, routed
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipconflict
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/ethernet"
+)
+
+var (
+	ownMAC   = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x01}
+	otherMAC = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x02}
+	proxyMAC = net.HardwareAddr{0x00, 0x16, 0x3e, 0x00, 0x00, 0x03}
+)
+
+func arpReply(src net.HardwareAddr, ip netip.Addr) []byte {
+	b := make([]byte, 0, 28)
+	b = binary.BigEndian.AppendUint16(b, uint16(ethernet.HardwareTypeEthernet))
+	b = binary.BigEndian.AppendUint16(b, uint16(ethernet.ProtocolTypeIPv4))
+	b = append(b, 6, 4)
+	b = binary.BigEndian.AppendUint16(b, ethernet.OpReply)
+	b = append(b, src...)
+	b = append(b, ip.AsSlice()...)
+	b = append(b, ownMAC...)
+	b = append(b, 0, 0, 0, 0)
+
+	return frame(ownMAC, src, ethernet.EthernetTypeARP, b)
+}
+
+func neighborAdvertisement(src net.HardwareAddr, ip netip.Addr, tlla net.HardwareAddr) []byte {
+	target := ip.As16()
+
+	icmp := make([]byte, 24)
+	icmp[0] = icmpv6NeighborAdvertisement
+	icmp[4] = 0x20 // override
+	copy(icmp[8:], target[:])
+
+	if tlla != nil {
+		icmp = append(icmp, optTargetLinkLayerAddr, 1)
+		icmp = append(icmp, tlla...)
+	}
+
+	b := make([]byte, ipv6HdrLen, ipv6HdrLen+len(icmp))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:], uint16(len(icmp)))
+	b[6] = 58
+	b[7] = 255
+	copy(b[8:], target[:])
+	b = append(b, icmp...)
+
+	return frame(net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}, src, ethernet.EthernetTypeIPv6, b)
+}
+
+func TestARPProbe(t *testing.T) {
+	b := arpProbe(ownMAC, netip.MustParseAddr("10.0.0.5"))
+	require.Len(t, b, minFrameLen)
+
+	eth := &ethernet.EthernetFrame{}
+	require.NoError(t, eth.UnmarshalBinary(b))
+	assert.Equal(t, "ff:ff:ff:ff:ff:ff", eth.DstMAC.String())
+	assert.Equal(t, ownMAC, eth.SrcMAC)
+
+	pkt, err := eth.ExtractARPPacket()
+	require.NoError(t, err)
+	assert.Equal(t, ethernet.OpRequest, pkt.OpCode)
+	assert.Equal(t, ownMAC, pkt.SendHwAddr)
+	assert.Equal(t, netip.MustParseAddr("0.0.0.0"), pkt.SendIPAddr)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.5"), pkt.TgtIPAddr)
+}
+
+func TestDADProbe(t *testing.T) {
+	b := dadProbe(ownMAC, netip.MustParseAddr("2001:db8::aa:bbcc:ddee"))
+
+	eth := &ethernet.EthernetFrame{}
+	require.NoError(t, eth.UnmarshalBinary(b))
+	assert.Equal(t, "33:33:ff:cc:dd:ee", eth.DstMAC.String())
+	assert.Equal(t, ethernet.EthernetTypeIPv6, eth.EthernetType)
+
+	ip6 := eth.Payload
+	src, _ := netip.AddrFromSlice(ip6[8:24])
+	dst, _ := netip.AddrFromSlice(ip6[24:40])
+
+	assert.Equal(t, netip.IPv6Unspecified(), src)
+	assert.Equal(t, netip.MustParseAddr("ff02::1:ffcc:ddee"), dst)
+	assert.Equal(t, uint8(255), ip6[7])
+
+	icmp := ip6[ipv6HdrLen : ipv6HdrLen+24]
+	assert.Equal(t, uint8(icmpv6NeighborSolicitation), icmp[0])
+	assert.Equal(t, netip.MustParseAddr("2001:db8::aa:bbcc:ddee").AsSlice(), icmp[8:24])
+	// Checksum over a message with a valid checksum is zero
+	assert.Equal(t, uint16(0), icmpv6Checksum(src.As16(), dst.As16(), icmp))
+}
+
+func TestParseReply(t *testing.T) {
+	testcases := map[string]struct {
+		in       []byte
+		conflict Conflict
+		ok       bool
+	}{
+		"arp reply": {
+			in: arpReply(otherMAC, netip.MustParseAddr("10.0.0.5")),
+			conflict: Conflict{
+				IP: netip.MustParseAddr("10.0.0.5"), MAC: otherMAC.String(), Method: MethodARP,
+			},
+			ok: true,
+		},
+		"own arp probe": {
+			in: arpProbe(ownMAC, netip.MustParseAddr("10.0.0.5")),
+		},
+		"arp probe of another host": {
+			in: arpProbe(otherMAC, netip.MustParseAddr("10.0.0.5")),
+		},
+		"neighbor advertisement": {
+			in: neighborAdvertisement(otherMAC, netip.MustParseAddr("2001:db8::5"), nil),
+			conflict: Conflict{
+				IP: netip.MustParseAddr("2001:db8::5"), MAC: otherMAC.String(), Method: MethodDAD,
+			},
+			ok: true,
+		},
+		"proxied neighbor advertisement": {
+			in: neighborAdvertisement(proxyMAC, netip.MustParseAddr("2001:db8::5"), otherMAC),
+			conflict: Conflict{
+				IP: netip.MustParseAddr("2001:db8::5"), MAC: otherMAC.String(), Method: MethodDAD,
+			},
+			ok: true,
+		},
+		"dad probe of another host": {
+			in: dadProbe(otherMAC, netip.MustParseAddr("2001:db8::5")),
+		},
+		"truncated frame": {
+			in: []byte{0xff, 0xff},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			conflict, ok := parseReply(tc.in, ownMAC)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.conflict, conflict)
+		})
+	}
+}
+
+// fakeLink replies to probes for addresses of hosts
+type fakeLink struct {
+	hosts   map[netip.Addr]net.HardwareAddr
+	queue   [][]byte
+	written int
+}
+
+func (f *fakeLink) HardwareAddr() net.HardwareAddr {
+	return ownMAC
+}
+
+func (f *fakeLink) WriteFrame(b []byte) error {
+	f.written++
+
+	// Every probe is looped back, as it happens with AF_PACKET sockets
+	f.queue = append(f.queue, b)
+
+	eth := &ethernet.EthernetFrame{}
+	if err := eth.UnmarshalBinary(b); err != nil {
+		return err
+	}
+
+	var target netip.Addr
+
+	if eth.EthernetType == ethernet.EthernetTypeARP {
+		pkt, err := eth.ExtractARPPacket()
+		if err != nil {
+			return err
+		}
+
+		target = pkt.TgtIPAddr
+	} else {
+		target, _ = netip.AddrFromSlice(eth.Payload[ipv6HdrLen+8 : ipv6HdrLen+24])
+	}
+
+	if mac, ok := f.hosts[target]; ok {
+		if target.Is4() {
+			f.queue = append(f.queue, arpReply(mac, target))
+		} else {
+			f.queue = append(f.queue, neighborAdvertisement(mac, target, nil))
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeLink) ReadFrame(b []byte, _ time.Time) (int, error) {
+	if len(f.queue) == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	n := copy(b, f.queue[0])
+	f.queue = f.queue[1:]
+
+	return n, nil
+}
+
+func (f *fakeLink) Close() error {
+	return nil
+}
+
+func TestProbeLink(t *testing.T) {
+	l := &fakeLink{hosts: map[netip.Addr]net.HardwareAddr{
+		netip.MustParseAddr("10.0.0.5"):    otherMAC,
+		netip.MustParseAddr("2001:db8::5"): proxyMAC,
+		netip.MustParseAddr("10.0.0.99"):   otherMAC,
+	}}
+
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.0.0.5"),
+		netip.MustParseAddr("10.0.0.6"),
+		netip.MustParseAddr("2001:db8::5"),
+	}
+
+	conflicts, err := probeLink(context.Background(), l, ips, 3, time.Millisecond)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[netip.Addr]Conflict{
+		netip.MustParseAddr("10.0.0.5"): {
+			IP: netip.MustParseAddr("10.0.0.5"), MAC: otherMAC.String(), Method: MethodARP,
+		},
+		netip.MustParseAddr("2001:db8::5"): {
+			IP: netip.MustParseAddr("2001:db8::5"), MAC: proxyMAC.String(), Method: MethodDAD,
+		},
+	}, conflicts)
+
+	// Addresses in conflict are not probed again
+	assert.Equal(t, 3+1+1, l.written)
+}
+
+func TestProbeLinkCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	l := &fakeLink{hosts: map[netip.Addr]net.HardwareAddr{}}
+
+	_, err := probeLink(ctx, l, []netip.Addr{netip.MustParseAddr("10.0.0.5")}, 3, time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestGroupByLink(t *testing.T) {
+	prefixes := map[string][]netip.Prefix{
+		"eth0": {netip.MustParsePrefix("10.0.0.0/16"), netip.MustParsePrefix("2001:db8::/64")},
+		"eth1": {netip.MustParsePrefix("10.0.1.0/24")},
+	}
+
+	onLink, routed := groupByLink(prefixes, []netip.Addr{
+		netip.MustParseAddr("10.0.0.5"),
+		netip.MustParseAddr("10.0.1.5"),
+		netip.MustParseAddr("::ffff:10.0.0.6"),
+		netip.MustParseAddr("2001:db8::5"),
+		netip.MustParseAddr("192.168.0.1"),
+	})
+
+	assert.Equal(t, map[string][]netip.Addr{
+		"eth0": {
+			netip.MustParseAddr("10.0.0.5"),
+			netip.MustParseAddr("10.0.0.6"),
+			netip.MustParseAddr("2001:db8::5"),
+		},
+		"eth1": {netip.MustParseAddr("10.0.1.5")},
+	}, onLink)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.0.1")}, routed)
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipconflict
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+
+	"maas.io/core/src/maasagent/internal/netmon"
+)
+
+const (
+	// RFC 5227 uses 3 probes 1-2 seconds apart
+	defaultProbeCount    = 3
+	defaultProbeInterval = time.Second
+)
+
+// Scanner returns hardware addresses of hosts responding to ICMP echo
+type Scanner func(ctx context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error)
+
+// Service exposes conflict probing as an activity, so that a deployment can
+// fail before static IPs are configured on the machine.
+type Service struct {
+	scan          Scanner
+	openLink      func(name string) (link, error)
+	prefixes      func() (map[string][]netip.Prefix, error)
+	probeCount    int
+	probeInterval time.Duration
+}
+
+// ServiceOption allows to set additional Service options
+type ServiceOption func(*Service)
+
+// NewService returns an instance of Service
+func NewService(options ...ServiceOption) *Service {
+	s := &Service{
+		scan:          netmon.Scan,
+		openLink:      openLink,
+		prefixes:      interfacePrefixes,
+		probeCount:    defaultProbeCount,
+		probeInterval: defaultProbeInterval,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithScanner allows to set a Scanner used for addresses that are not on-link
+func WithScanner(scan Scanner) ServiceOption {
+	return func(s *Service) {
+		s.scan = scan
+	}
+}
+
+// WithProbes allows to set how many probes are sent and how long to wait
+// for responses after each of them
+func WithProbes(count int, interval time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.probeCount = count
+		s.probeInterval = interval
+	}
+}
+
+// ProbeIPConflictsParam is the activity parameter for probe-ip-conflicts
+type ProbeIPConflictsParam struct {
+	IPs []netip.Addr `json:"ips"`
+}
+
+// ProbeIPConflictsResult is the result of probe-ip-conflicts
+type ProbeIPConflictsResult struct {
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"probe-ip-conflicts": s.probeIPConflicts,
+	}
+}
+
+// probeIPConflicts fails with a non-retryable error of type ErrConflict,
+// which has conflicts as details, when any of the addresses is in use.
+func (s *Service) probeIPConflicts(ctx context.Context,
+	param ProbeIPConflictsParam) (*ProbeIPConflictsResult, error) {
+	conflicts, err := s.probe(ctx, param.IPs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conflicts) == 0 {
+		return &ProbeIPConflictsResult{}, nil
+	}
+
+	msg := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		msg[i] = c.String()
+	}
+
+	err = fmt.Errorf("%w: %s", ErrConflict, strings.Join(msg, ", "))
+
+	return nil, temporal.NewNonRetryableApplicationError(err.Error(), "ErrConflict", err,
+		ProbeIPConflictsResult{Conflicts: conflicts})
+}
+
+func (s *Service) probe(ctx context.Context, ips []netip.Addr) ([]Conflict, error) {
+	prefixes, err := s.prefixes()
+	if err != nil {
+		return nil, err
+	}
+
+	onLink, routed := groupByLink(prefixes, ips)
+
+	var conflicts []Conflict
+
+	for name, addrs := range onLink {
+		found, err := s.probeInterface(ctx, name, addrs)
+		if errors.Is(err, ErrUnsupported) {
+			routed = append(routed, addrs...)
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("probing %s: %w", name, err)
+		}
+
+		for _, c := range found {
+			conflicts = append(conflicts, c)
+		}
+	}
+
+	if len(routed) > 0 {
+		scanned, err := s.scan(ctx, routed)
+		if err != nil {
+			return nil, err
+		}
+
+		for ip, mac := range scanned {
+			if mac != nil {
+				conflicts = append(conflicts, Conflict{IP: ip, MAC: mac.String(), Method: MethodICMP})
+			}
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].IP.Less(conflicts[j].IP)
+	})
+
+	return conflicts, nil
+}
+
+func (s *Service) probeInterface(ctx context.Context, name string,
+	ips []netip.Addr) (map[netip.Addr]Conflict, error) {
+	l, err := s.openLink(name)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer l.Close()
+
+	return probeLink(ctx, l, ips, s.probeCount, s.probeInterval)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipconflict
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+)
+
+func newTestService(hosts map[netip.Addr]net.HardwareAddr,
+	scanned map[netip.Addr]net.HardwareAddr) (*Service, *[]netip.Addr) {
+	var scannedIPs []netip.Addr
+
+	s := NewService(
+		WithProbes(1, time.Millisecond),
+		WithScanner(func(_ context.Context, ips []netip.Addr) (map[netip.Addr]net.HardwareAddr, error) {
+			scannedIPs = append(scannedIPs, ips...)
+			return scanned, nil
+		}),
+	)
+
+	s.prefixes = func() (map[string][]netip.Prefix, error) {
+		return map[string][]netip.Prefix{"eth0": {netip.MustParsePrefix("10.0.0.0/24")}}, nil
+	}
+
+	s.openLink = func(name string) (link, error) {
+		if name != "eth0" {
+			return nil, errors.New("unexpected interface")
+		}
+
+		return &fakeLink{hosts: hosts}, nil
+	}
+
+	return s, &scannedIPs
+}
+
+func TestProbeIPConflicts(t *testing.T) {
+	s, scannedIPs := newTestService(
+		map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.7"): otherMAC},
+		map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("192.168.0.1"): nil},
+	)
+
+	result, err := s.probeIPConflicts(context.Background(), ProbeIPConflictsParam{
+		IPs: []netip.Addr{netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("192.168.0.1")},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, result.Conflicts)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.0.1")}, *scannedIPs)
+}
+
+func TestProbeIPConflictsFound(t *testing.T) {
+	s, _ := newTestService(
+		map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.5"): otherMAC},
+		map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("192.168.0.1"): proxyMAC},
+	)
+
+	_, err := s.probeIPConflicts(context.Background(), ProbeIPConflictsParam{
+		IPs: []netip.Addr{netip.MustParseAddr("192.168.0.1"), netip.MustParseAddr("10.0.0.5")},
+	})
+	require.ErrorIs(t, err, ErrConflict)
+
+	var appErr *temporal.ApplicationError
+
+	require.ErrorAs(t, err, &appErr)
+	assert.True(t, appErr.NonRetryable())
+	assert.ErrorContains(t, err, "10.0.0.5 is in use by "+otherMAC.String()+" (arp)")
+
+	var details ProbeIPConflictsResult
+
+	require.NoError(t, appErr.Details(&details))
+	assert.Equal(t, []Conflict{
+		{IP: netip.MustParseAddr("10.0.0.5"), MAC: otherMAC.String(), Method: MethodARP},
+		{IP: netip.MustParseAddr("192.168.0.1"), MAC: proxyMAC.String(), Method: MethodICMP},
+	}, details.Conflicts)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipmi
+
+const (
+	// bmcAddress is the IPMB slave address of the BMC
+	bmcAddress     = 0x20
+	cmdSendMessage = 0x34
+	// trackRequest makes the BMC track the bridged request, so the
+	// response of the target is passed back to the session
+	trackRequest = 0x40
+)
+
+// Bridge is a hop from the BMC to a controller behind it (IPMI v2.0, 6.13)
+type Bridge struct {
+	// Channel is the channel the controller is on (ipmitool -b or -B)
+	Channel uint8
+	// Address is the IPMB slave address of the controller (ipmitool -t
+	// or -T)
+	Address uint8
+}
+
+// Bridge returns a session sending commands to the controller reached
+// through bridges from the BMC, e.g. a node of a chassis, instead of the
+// BMC itself. One bridge is single bridging (ipmitool -b and -t), two
+// bridges are double bridging through a transit controller (-B and -T,
+// followed by -b and -t). The returned session shares the session of the
+// BMC, so commands of many controllers can be sent without logging in
+// again. Closing either of them closes the session of the BMC. The BMC
+// must embed the response of the controller in its response to Send
+// Message; BMCs passing it back on its own are not supported.
+func (s *Session) Bridge(bridges ...Bridge) *Session {
+	return &Session{lanSession: s.lanSession, bridges: bridges}
+}
+
+// bridgedRequest returns data of Send Message request to the BMC, which
+// encapsulates the request of the target within requests of transit
+// controllers. Each controller forwards the request as the requester, so
+// it gets the response of the next one.
+func bridgedRequest(bridges []Bridge, netFn, cmd, rqSeq byte, data []byte) []byte {
+	requester := func(i int) byte {
+		if i == 0 {
+			return bmcAddress
+		}
+
+		return bridges[i-1].Address
+	}
+
+	last := len(bridges) - 1
+	msg := ipmbRequest(bridges[last].Address, requester(last), netFn, cmd, rqSeq, data)
+
+	for i := last - 1; i >= 0; i-- {
+		msg = ipmbRequest(bridges[i].Address, requester(i), netFnApp, cmdSendMessage, rqSeq,
+			append([]byte{trackRequest | bridges[i+1].Channel}, msg...))
+	}
+
+	return append([]byte{trackRequest | bridges[0].Channel}, msg...)
+}
+
+// embeddedResponse returns completion code and data of the IPMB response
+// embedded in the response to Send Message. Responses of double bridged
+// requests are embedded in the response to Send Message of the transit
+// controller. ok is false for acknowledgements without response.
+func embeddedResponse(msg []byte, netFn, cmd byte) ([]byte, bool) {
+	if !validMessage(msg) {
+		return nil, false
+	}
+
+	resp := msg[6 : len(msg)-1]
+
+	if msg[1]>>2 == netFnApp|1 && msg[5] == cmdSendMessage {
+		if resp[0] != completionNormal {
+			return resp, true
+		}
+
+		return embeddedResponse(resp[1:], netFn, cmd)
+	}
+
+	if msg[1]>>2 != netFn|1 || msg[5] != cmd {
+		return nil, false
+	}
+
+	return resp, true
+}
+
+// validMessage returns true if msg is long enough to be an IPMB response
+// and its checksums are correct
+func validMessage(msg []byte) bool {
+	return len(msg) >= 8 && checksum(msg[:3]) == 0 && checksum(msg[3:]) == 0
+}
+
+// ipmbRequest returns IPMB message of the request from requester rqSA to
+// responder rsSA
+func ipmbRequest(rsSA, rqSA, netFn, cmd, rqSeq byte, data []byte) []byte {
+	b := []byte{rsSA, netFn << 2}
+	b = append(b, checksum(b))
+
+	body := append([]byte{rqSA, rqSeq << 2, cmd}, data...)
+	b = append(b, body...)
+
+	return append(b, checksum(body))
+}
+
+// checksum returns 2's complement checksum, so the sum of data and
+// the checksum is zero
+func checksum(data []byte) byte {
+	var c byte
+	for _, b := range data {
+		c += b
+	}
+
+	return -c
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipmi
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	cmdGetChassisStatus     = 0x01
+	cmdChassisControl       = 0x02
+	cmdSetSystemBootOptions = 0x08
+	cmdGetSystemBootOptions = 0x09
+
+	bootOptionBootFlags  = 0x05
+	bootFlagsValid       = 0x80
+	bootFlagsPersistent  = 0x40
+	bootFlagsEFI         = 0x20
+	bootFlagsDeviceMask  = 0x3c
+	bootOptionsParamMask = 0x7f
+)
+
+// ChassisControl is an action of Chassis Control command
+type ChassisControl byte
+
+// Chassis Control actions (IPMI v2.0, 28.3)
+const (
+	PowerDown    ChassisControl = 0x00
+	PowerUp      ChassisControl = 0x01
+	PowerCycle   ChassisControl = 0x02
+	HardReset    ChassisControl = 0x03
+	SoftShutdown ChassisControl = 0x05
+)
+
+// BootDevice is the boot device selector of boot flags
+type BootDevice byte
+
+// Boot devices (IPMI v2.0, 28.13, boot flags data 2)
+const (
+	BootDevicePXE  BootDevice = 0x04
+	BootDeviceDisk BootDevice = 0x08
+	BootDeviceCD   BootDevice = 0x14
+)
+
+// ChassisStatus is the response of Get Chassis Status command
+type ChassisStatus struct {
+	PowerOn           bool `json:"power_on"`
+	PowerOverload     bool `json:"power_overload"`
+	PowerFault        bool `json:"power_fault"`
+	PowerControlFault bool `json:"power_control_fault"`
+}
+
+// Faulty returns true if the BMC reports a power fault
+func (s ChassisStatus) Faulty() bool {
+	return s.PowerOverload || s.PowerFault || s.PowerControlFault
+}
+
+// ChassisStatus returns power state of the chassis
+func (s *Session) ChassisStatus(ctx context.Context) (*ChassisStatus, error) {
+	data, err := s.command(ctx, "Get Chassis Status", netFnChassis, cmdGetChassisStatus, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 3 {
+		return nil, fmt.Errorf("%w: chassis status is too short", ErrInvalidResponse)
+	}
+
+	return &ChassisStatus{
+		PowerOn:           data[0]&0x01 != 0,
+		PowerOverload:     data[0]&0x04 != 0,
+		PowerFault:        data[0]&0x08 != 0,
+		PowerControlFault: data[0]&0x10 != 0,
+	}, nil
+}
+
+// ChassisControl powers the chassis up, down, cycles or resets it.
+// Completion of the command doesn't mean that the action is completed.
+func (s *Session) ChassisControl(ctx context.Context, action ChassisControl) error {
+	_, err := s.command(ctx, "Chassis Control", netFnChassis, cmdChassisControl, []byte{byte(action)})
+	return err
+}
+
+// BootFlags are boot flags of the system (IPMI v2.0, 28.13, parameter 5)
+type BootFlags struct {
+	Device BootDevice `json:"device"`
+	// Valid is false when the BMC has no boot device set
+	Valid bool `json:"valid"`
+	// Persistent is true when the device applies to all future boots
+	Persistent bool `json:"persistent"`
+	EFI        bool `json:"efi"`
+}
+
+// SetBootDevice sets the device of the next boot only. If efi is set,
+// the machine is asked to boot in UEFI mode.
+func (s *Session) SetBootDevice(ctx context.Context, device BootDevice, efi bool) error {
+	return s.setBootFlags(ctx, BootFlags{Device: device, Valid: true, EFI: efi})
+}
+
+// SetPersistentBootDevice sets the device of all future boots, which is
+// the closest IPMI has to a persistent boot order.
+func (s *Session) SetPersistentBootDevice(ctx context.Context, device BootDevice, efi bool) error {
+	return s.setBootFlags(ctx, BootFlags{Device: device, Valid: true, Persistent: true, EFI: efi})
+}
+
+func (s *Session) setBootFlags(ctx context.Context, f BootFlags) error {
+	var flags byte
+
+	if f.Valid {
+		flags |= bootFlagsValid
+	}
+
+	if f.Persistent {
+		flags |= bootFlagsPersistent
+	}
+
+	if f.EFI {
+		flags |= bootFlagsEFI
+	}
+
+	_, err := s.command(ctx, "Set System Boot Options", netFnChassis, cmdSetSystemBootOptions,
+		[]byte{bootOptionBootFlags, flags, byte(f.Device), 0, 0, 0})
+
+	return err
+}
+
+// BootFlags returns boot flags of the system
+func (s *Session) BootFlags(ctx context.Context) (*BootFlags, error) {
+	data, err := s.command(ctx, "Get System Boot Options", netFnChassis, cmdGetSystemBootOptions,
+		[]byte{bootOptionBootFlags, 0, 0})
+	if err != nil {
+		return nil, err
+	}
+
+	// Parameter version, parameter selector and 5 bytes of boot flags
+	if len(data) < 4 || data[1]&bootOptionsParamMask != bootOptionBootFlags {
+		return nil, fmt.Errorf("%w: boot flags are too short", ErrInvalidResponse)
+	}
+
+	return &BootFlags{
+		Device:     BootDevice(data[3] & bootFlagsDeviceMask),
+		Valid:      data[2]&bootFlagsValid != 0,
+		Persistent: data[2]&bootFlagsPersistent != 0,
+		EFI:        data[2]&bootFlagsEFI != 0,
+	}, nil
+}
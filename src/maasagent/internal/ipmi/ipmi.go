@@ -0,0 +1,420 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ipmi is a client of IPMI v2.0 over LAN (RMCP+, also known as
+// lanplus or LAN_2_0), built on github.com/bougou/go-ipmi. It allows the
+// Agent to control power of machines without running a CLI tool for every
+// operation, and reports failures as distinct errors, so authentication
+// failures can be told apart from BMCs which are not reachable.
+package ipmi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	goipmi "github.com/bougou/go-ipmi"
+
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// DefaultPort is the RMCP port of BMCs
+const DefaultPort = 623
+
+// Privilege levels of a session
+const (
+	PrivilegeUser     = 0x02
+	PrivilegeOperator = 0x03
+	PrivilegeAdmin    = 0x04
+)
+
+// Cipher suites supported by the client. Both use AES-CBC-128 for
+// confidentiality.
+const (
+	// CipherSuite3 uses RAKP-HMAC-SHA1 and HMAC-SHA1-96
+	CipherSuite3 = 3
+	// CipherSuite17 uses RAKP-HMAC-SHA256 and HMAC-SHA256-128
+	CipherSuite17 = 17
+)
+
+const (
+	defaultTimeout   = 2 * time.Second
+	defaultRetries   = 2
+	maxUsernameSize  = 16
+	maxPasswordSize  = 20
+	completionNormal = 0x00
+
+	netFnChassis = 0x00
+	netFnApp     = 0x06
+)
+
+var (
+	// ErrAuthentication is returned when BMC rejects the credentials or
+	// the requested privilege level
+	ErrAuthentication = errcode.New(errcode.PowerAuthFailed, "IPMI authentication failed")
+	// ErrUnreachable is returned when BMC doesn't respond
+	ErrUnreachable = errcode.New(errcode.PowerUnreachable, "IPMI BMC is unreachable")
+	// ErrUnsupportedCipherSuite is returned when cipher suite is not
+	// supported by the client or by the BMC
+	ErrUnsupportedCipherSuite = errcode.New(errcode.PowerUnsupported, "unsupported IPMI cipher suite")
+	// ErrInvalidResponse is returned when response cannot be decoded, or
+	// its integrity cannot be verified
+	ErrInvalidResponse = errcode.New(errcode.PowerInvalidResponse, "invalid IPMI response")
+)
+
+var cipherSuites = map[int]goipmi.CipherSuiteID{
+	CipherSuite3:  goipmi.CipherSuiteID3,
+	CipherSuite17: goipmi.CipherSuiteID17,
+}
+
+// completionCodes describes generic completion codes (IPMI v2.0, 5.2)
+var completionCodes = map[uint8]string{
+	0xc0: "node busy",
+	0xc1: "invalid command",
+	0xc2: "invalid command for LUN",
+	0xc3: "timeout while processing command",
+	0xc4: "out of space",
+	0xc5: "reservation cancelled or invalid",
+	0xc6: "request data truncated",
+	0xc7: "request data length invalid",
+	0xc8: "request data field length limit exceeded",
+	0xc9: "parameter out of range",
+	0xcc: "invalid data field in request",
+	0xcd: "command illegal for sensor or record type",
+	0xce: "command response could not be provided",
+	0xd4: "insufficient privilege level",
+	0xd5: "command not supported in present state",
+	0xff: "unspecified error",
+}
+
+// rakpStatus describes RMCP+ status codes (IPMI v2.0, 13.24)
+var rakpStatus = map[uint8]string{
+	0x01: "insufficient resources to create a session",
+	0x02: "invalid session ID",
+	0x04: "invalid authentication algorithm",
+	0x05: "invalid integrity algorithm",
+	0x09: "invalid role",
+	0x0a: "unauthorized role or privilege level requested",
+	0x0b: "insufficient resources to create a session at the requested role",
+	0x0c: "invalid name length",
+	0x0d: "unauthorized name",
+	0x0e: "unauthorized GUID",
+	0x0f: "invalid integrity check value",
+	0x10: "invalid confidentiality algorithm",
+	0x11: "no cipher suite match with proposed security algorithms",
+}
+
+// rakpError returns error of non-zero RMCP+ status code
+func rakpError(stage string, code uint8) error {
+	err := ErrInvalidResponse
+
+	switch code {
+	case 0x09, 0x0a, 0x0c, 0x0d, 0x0e, 0x0f:
+		err = ErrAuthentication
+	case 0x04, 0x05, 0x10, 0x11:
+		err = ErrUnsupportedCipherSuite
+	}
+
+	if text, ok := rakpStatus[code]; ok {
+		return fmt.Errorf("%w: %s: %s", err, stage, text)
+	}
+
+	return fmt.Errorf("%w: %s: status 0x%02x", err, stage, code)
+}
+
+// CompletionError is returned when BMC completes a command with
+// non-zero completion code
+type CompletionError struct {
+	Command string
+	Code    uint8
+}
+
+func (e *CompletionError) Error() string {
+	if text, ok := completionCodes[e.Code]; ok {
+		return fmt.Sprintf("IPMI command %s failed: %s (0x%02x)", e.Command, text, e.Code)
+	}
+
+	return fmt.Sprintf("IPMI command %s failed: completion code 0x%02x", e.Command, e.Code)
+}
+
+// Session is an authenticated RMCP+ session with a BMC. It is safe for
+// concurrent use, although commands are sent one at a time.
+type Session struct {
+	*lanSession
+	// bridges lead to the controller commands are bridged to, if they are
+	// not for the BMC itself
+	bridges []Bridge
+}
+
+// lanSession is the RMCP+ session, shared by sessions bridging commands
+// to controllers behind the BMC
+type lanSession struct {
+	client *goipmi.Client
+	// conn is the connection of client, which is closed by the session,
+	// as the client only closes it once the BMC closes the session
+	conn    net.Conn
+	address string
+	timeout time.Duration
+	rqSeq   uint8
+	closed  bool
+	mutex   sync.Mutex
+}
+
+type config struct {
+	suite     int
+	privilege uint8
+	timeout   time.Duration
+	retries   int
+}
+
+// Option allows to set additional Session options
+type Option func(*config)
+
+// WithCipherSuite sets the cipher suite of the session (default: 3).
+// Only cipher suites 3 and 17 are supported.
+func WithCipherSuite(id int) Option {
+	return func(c *config) {
+		c.suite = id
+	}
+}
+
+// WithPrivilege sets the privilege level of the session
+// (default: PrivilegeAdmin)
+func WithPrivilege(level uint8) Option {
+	return func(c *config) {
+		c.privilege = level
+	}
+}
+
+// WithTimeout sets how long to wait for a response before the request is
+// retransmitted (default: 2s), and how many times it is retransmitted
+// (default: 2).
+func WithTimeout(timeout time.Duration, retries int) Option {
+	return func(c *config) {
+		c.timeout = timeout
+		c.retries = retries
+	}
+}
+
+// connDialer hands the connection dialed by Dial over to the client
+type connDialer struct {
+	conn net.Conn
+}
+
+func (d connDialer) Dial(_, _ string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+// Dial establishes a session with the BMC at address (host or host:port)
+// and authenticates with username and password.
+func Dial(ctx context.Context, address, username, password string, options ...Option) (*Session, error) {
+	cfg := config{
+		suite:     CipherSuite3,
+		privilege: PrivilegeAdmin,
+		timeout:   defaultTimeout,
+		retries:   defaultRetries,
+	}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	suite, ok := cipherSuites[cfg.suite]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCipherSuite, cfg.suite)
+	}
+
+	if len(username) > maxUsernameSize {
+		return nil, fmt.Errorf("%w: username is longer than %d bytes", ErrAuthentication, maxUsernameSize)
+	}
+
+	if len(password) > maxPasswordSize {
+		return nil, fmt.Errorf("%w: password is longer than %d bytes", ErrAuthentication, maxPasswordSize)
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(DefaultPort))
+	}
+
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrUnreachable, p)
+	}
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+
+	client, err := goipmi.NewClient(host, port, username, password)
+	if err != nil {
+		//nolint:errcheck // session is not established, the error is reported
+		conn.Close()
+		return nil, fmt.Errorf("%w: %w", ErrAuthentication, err)
+	}
+
+	client.WithUDPProxy(connDialer{conn: conn}).
+		WithTimeout(cfg.timeout).
+		WithRetry(cfg.retries, 0).
+		WithCipherSuiteID(suite).
+		WithMaxPrivilegeLevel(goipmi.PrivilegeLevel(cfg.privilege))
+
+	s := &Session{lanSession: &lanSession{
+		client:  client,
+		conn:    conn,
+		address: address,
+		timeout: cfg.timeout * time.Duration(cfg.retries+1),
+	}}
+
+	if err = s.open(ctx, cfg.privilege); err != nil {
+		//nolint:errcheck // session is not established, the error is reported
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// open establishes the session (IPMI v2.0, 13.15). Key exchange
+// authentication codes of RAKP messages don't match, if the password is
+// not valid.
+func (s *Session) open(ctx context.Context, privilege uint8) error {
+	resp, err := s.client.OpenSession(ctx)
+	if resp != nil && resp.RmcpStatusCode != goipmi.RmcpStatusCodeNoErrors {
+		return rakpError("open session", uint8(resp.RmcpStatusCode))
+	}
+
+	if err != nil {
+		return s.error(ctx, "Open Session", err, ErrInvalidResponse)
+	}
+
+	if _, err = s.client.RAKPMessage1(ctx); err != nil {
+		return s.error(ctx, "RAKP Message 1", err, ErrAuthentication)
+	}
+
+	if _, err = s.client.RAKPMessage3(ctx); err != nil {
+		return s.error(ctx, "RAKP Message 3", err, ErrAuthentication)
+	}
+
+	// Sessions start at User privilege level
+	if privilege <= PrivilegeUser {
+		return nil
+	}
+
+	_, err = s.client.SetSessionPrivilegeLevel(ctx, goipmi.PrivilegeLevel(privilege))
+	if err == nil {
+		return nil
+	}
+
+	err = s.error(ctx, "Set Session Privilege Level", err, ErrInvalidResponse)
+
+	var cerr *CompletionError
+	if errors.As(err, &cerr) && (cerr.Code == 0x80 || cerr.Code == 0x81) {
+		return fmt.Errorf("%w: %w", ErrAuthentication, err)
+	}
+
+	return err
+}
+
+// Close closes the session, together with sessions returned by Bridge
+func (s *Session) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return net.ErrClosed
+	}
+
+	s.closed = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	err := s.client.Close(ctx)
+
+	if cerr := s.conn.Close(); cerr != nil && !errors.Is(cerr, net.ErrClosed) {
+		err = errors.Join(err, cerr)
+	}
+
+	return err
+}
+
+// command sends IPMI request within the session and returns data of the
+// response (without completion code). Requests are bridged to the target
+// controller of the session, if there is one.
+func (s *Session) command(ctx context.Context, name string, netFn, cmd byte, data []byte) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.bridges) == 0 {
+		resp, err := s.client.RawCommand(ctx, goipmi.NetFn(netFn), cmd, data, name)
+		if err != nil {
+			return nil, s.error(ctx, name, err, ErrInvalidResponse)
+		}
+
+		return resp.Response, nil
+	}
+
+	s.rqSeq = (s.rqSeq + 1) & 0x3f
+
+	resp, err := s.client.RawCommand(ctx, goipmi.NetFnAppRequest, cmdSendMessage,
+		bridgedRequest(s.bridges, netFn, cmd, s.rqSeq, data), name)
+	if err != nil {
+		return nil, s.error(ctx, name, err, ErrInvalidResponse)
+	}
+
+	res, ok := embeddedResponse(resp.Response, netFn, cmd)
+	if !ok {
+		return nil, fmt.Errorf("%w: BMC didn't pass the response of %s back", ErrInvalidResponse, name)
+	}
+
+	if res[0] != completionNormal {
+		return nil, &CompletionError{Command: name, Code: res[0]}
+	}
+
+	return res[1:], nil
+}
+
+// error returns err of the client as CompletionError for responses with
+// non-zero completion code, or as ErrUnreachable for BMCs which don't
+// respond. Other errors are returned as kind.
+func (s *lanSession) error(ctx context.Context, name string, err error, kind error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var rerr *goipmi.ResponseError
+	if errors.As(err, &rerr) && rerr.CompletionCode() != completionNormal {
+		return &CompletionError{Command: name, Code: uint8(rerr.CompletionCode())}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %s: %w", ErrUnreachable, s.address, err)
+	}
+
+	return fmt.Errorf("%w: %s: %w", kind, name, err)
+}
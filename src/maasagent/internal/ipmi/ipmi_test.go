@@ -0,0 +1,726 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipmi
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // SHA1 is mandated by cipher suite 3
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rmcpHeader of IPMI messages: RMCP version 1.0, no RMCP ACK, class IPMI
+var rmcpHeader = []byte{0x06, 0x00, 0xff, 0x07}
+
+const (
+	authTypeRMCPPlus = 0x06
+	nextHeaderRMCP   = 0x07
+
+	payloadIPMI                = 0x00
+	payloadOpenSessionRequest  = 0x10
+	payloadOpenSessionResponse = 0x11
+	payloadRAKP1               = 0x12
+	payloadRAKP2               = 0x13
+	payloadRAKP3               = 0x14
+	payloadRAKP4               = 0x15
+	payloadEncrypted           = 0x80
+	payloadAuthenticated       = 0x40
+	payloadTypeMask            = 0x3f
+
+	consoleAddress = 0x81
+	maxPacketSize  = 1024
+
+	cmdSetSessionPrivilege = 0x3b
+	cmdCloseSession        = 0x3c
+)
+
+// cipherSuite holds algorithms of the fake BMC for a cipher suite
+type cipherSuite struct {
+	hash func() hash.Hash
+	// authentication and integrity algorithms as encoded in Open Session
+	// Request
+	authentication byte
+	integrity      byte
+	// authCodeSize is the size of truncated HMAC of session packets and
+	// of the integrity check value of RAKP Message 4
+	authCodeSize int
+}
+
+var testSuites = map[int]*cipherSuite{
+	CipherSuite3: {
+		hash:           sha1.New,
+		authentication: 0x01,
+		integrity:      0x01,
+		authCodeSize:   12,
+	},
+	CipherSuite17: {
+		hash:           sha256.New,
+		authentication: 0x03,
+		integrity:      0x04,
+		authCodeSize:   16,
+	},
+}
+
+func (c *cipherSuite) hmac(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(c.hash, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+
+	return mac.Sum(nil)
+}
+
+// packet returns unauthenticated RMCP+ packet
+func packet(payloadType byte, sessionID, seq uint32, payload []byte) []byte {
+	b := append([]byte{}, rmcpHeader...)
+	b = append(b, authTypeRMCPPlus, payloadType)
+	b = binary.LittleEndian.AppendUint32(b, sessionID)
+	b = binary.LittleEndian.AppendUint32(b, seq)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(payload)))
+
+	return append(b, payload...)
+}
+
+// sealPacket returns encrypted and authenticated RMCP+ packet of IPMI
+// message msg
+func sealPacket(suite *cipherSuite, k1, k2 []byte, sessionID, seq uint32, msg []byte) []byte {
+	// Payload is padded with 1, 2, 3... followed by the pad length
+	padSize := aes.BlockSize - (len(msg)+1)%aes.BlockSize
+	if padSize == aes.BlockSize {
+		padSize = 0
+	}
+
+	plain := append([]byte{}, msg...)
+	for i := 1; i <= padSize; i++ {
+		plain = append(plain, byte(i))
+	}
+
+	plain = append(plain, byte(padSize))
+
+	body := make([]byte, aes.BlockSize+len(plain))
+	//nolint:errcheck // crypto/rand never fails on supported platforms
+	rand.Read(body[:aes.BlockSize])
+
+	//nolint:errcheck // key size is always valid
+	block, _ := aes.NewCipher(k2)
+	cipher.NewCBCEncrypter(block, body[:aes.BlockSize]).CryptBlocks(body[aes.BlockSize:], plain)
+
+	b := packet(payloadIPMI|payloadEncrypted|payloadAuthenticated, sessionID, seq, body)
+
+	// Authenticated part (from auth type to next header) is padded to
+	// a multiple of 4 bytes
+	integrityPad := (4 - (len(b)-len(rmcpHeader)+2)%4) % 4
+	b = append(b, bytes.Repeat([]byte{0xff}, integrityPad)...)
+	b = append(b, byte(integrityPad), nextHeaderRMCP)
+
+	return append(b, suite.hmac(k1, b[len(rmcpHeader):])[:suite.authCodeSize]...)
+}
+
+// unsealPacket verifies and decrypts RMCP+ packet of the session
+func unsealPacket(suite *cipherSuite, k1, k2 []byte, sessionID uint32, b []byte) ([]byte, bool) {
+	if len(b) < 16 || b[5] != payloadIPMI|payloadEncrypted|payloadAuthenticated ||
+		binary.LittleEndian.Uint32(b[6:10]) != sessionID {
+		return nil, false
+	}
+
+	size := int(binary.LittleEndian.Uint16(b[14:16]))
+	if len(b) < 16+size+suite.authCodeSize+2 {
+		return nil, false
+	}
+
+	payload := b[16 : 16+size]
+
+	authCode := b[len(b)-suite.authCodeSize:]
+	if !hmac.Equal(suite.hmac(k1, b[len(rmcpHeader):len(b)-suite.authCodeSize])[:suite.authCodeSize], authCode) {
+		return nil, false
+	}
+
+	if len(payload) < 2*aes.BlockSize || len(payload)%aes.BlockSize != 0 {
+		return nil, false
+	}
+
+	//nolint:errcheck // key size is always valid
+	block, _ := aes.NewCipher(k2)
+
+	plain := make([]byte, len(payload)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, payload[:aes.BlockSize]).CryptBlocks(plain, payload[aes.BlockSize:])
+
+	padSize := int(plain[len(plain)-1])
+	if padSize >= len(plain) {
+		return nil, false
+	}
+
+	return plain[:len(plain)-1-padSize], true
+}
+
+// fakeBMC is a minimal RMCP+ BMC with one user
+type fakeBMC struct {
+	suite     *cipherSuite
+	username  string
+	password  string
+	controls  []ChassisControl
+	bootFlags []byte
+	// targets are controllers behind the BMC by their address, which
+	// commands are bridged to
+	targets map[byte]*fakeBMC
+	// ackFirst makes the BMC acknowledge Send Message and pass the
+	// response of the target on its own, instead of embedding it
+	ackFirst bool
+	queued   [][]byte
+	// session state
+	consoleID []byte
+	bmcID     []byte
+	rm        []byte
+	rc        []byte
+	guid      []byte
+	k1        []byte
+	k2        []byte
+	seq       uint32
+	role      byte
+	privilege byte
+	power     bool
+	mutex     sync.Mutex
+}
+
+func newFakeBMC(t *testing.T, bmc *fakeBMC) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { conn.Close() })
+
+	if bmc.suite == nil {
+		bmc.suite = testSuites[CipherSuite3]
+	}
+
+	if bmc.privilege == 0 {
+		bmc.privilege = PrivilegeAdmin
+	}
+
+	go func() {
+		buf := make([]byte, maxPacketSize)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if resp := bmc.handle(buf[:n]); resp != nil {
+				//nolint:errcheck // the client retries
+				conn.WriteTo(resp, addr)
+			}
+
+			for _, resp := range bmc.takeQueued() {
+				//nolint:errcheck // the client retries
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func (b *fakeBMC) handle(p []byte) []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(p) < 16 {
+		return nil
+	}
+
+	payload := p[16:]
+
+	switch p[5] & payloadTypeMask {
+	case payloadOpenSessionRequest:
+		b.consoleID = append([]byte{}, payload[4:8]...)
+		b.bmcID = []byte{0x11, 0x22, 0x33, 0x44}
+
+		resp := []byte{payload[0], 0, payload[1], 0}
+		resp = append(resp, b.consoleID...)
+		resp = append(resp, b.bmcID...)
+
+		if payload[12] != b.suite.authentication || payload[20] != b.suite.integrity {
+			resp[1] = 0x11
+			return packet(payloadOpenSessionResponse, 0, 0, resp)
+		}
+
+		resp = append(resp, payload[8:]...)
+
+		return packet(payloadOpenSessionResponse, 0, 0, resp)
+	case payloadRAKP1:
+		b.rm = append([]byte{}, payload[8:24]...)
+		b.role = payload[24]
+		username := string(payload[28 : 28+int(payload[27])])
+
+		resp := []byte{payload[0], 0, 0, 0}
+		resp = append(resp, b.consoleID...)
+
+		if username != b.username {
+			resp[1] = 0x0d
+			return packet(payloadRAKP2, 0, 0, resp)
+		}
+
+		b.rc = make([]byte, 16)
+		b.guid = bytes.Repeat([]byte{0xab}, 16)
+
+		//nolint:errcheck // crypto/rand never fails on supported platforms
+		rand.Read(b.rc)
+
+		resp = append(resp, b.rc...)
+		resp = append(resp, b.guid...)
+		resp = append(resp, b.suite.hmac([]byte(b.password), b.consoleID, b.bmcID, b.rm, b.rc, b.guid,
+			[]byte{b.role, byte(len(username))}, []byte(username))...)
+
+		return packet(payloadRAKP2, 0, 0, resp)
+	case payloadRAKP3:
+		name := []byte{b.role, byte(len(b.username))}
+		resp := []byte{payload[0], 0, 0, 0}
+		resp = append(resp, b.consoleID...)
+
+		want := b.suite.hmac([]byte(b.password), b.rc, b.consoleID, name, []byte(b.username))
+		if !bytes.Equal(want, payload[8:]) {
+			resp[1] = 0x0f
+			return packet(payloadRAKP4, 0, 0, resp)
+		}
+
+		sik := b.suite.hmac([]byte(b.password), b.rm, b.rc, name, []byte(b.username))
+		b.k1 = b.suite.hmac(sik, bytes.Repeat([]byte{0x01}, 20))
+		b.k2 = b.suite.hmac(sik, bytes.Repeat([]byte{0x02}, 20))[:16]
+
+		resp = append(resp, b.suite.hmac(sik, b.rm, b.bmcID, b.guid)[:b.suite.authCodeSize]...)
+
+		return packet(payloadRAKP4, 0, 0, resp)
+	case payloadIPMI:
+		msg, ok := unsealPacket(b.suite, b.k1, b.k2, binary.LittleEndian.Uint32(b.bmcID), p)
+		if !ok {
+			return nil
+		}
+
+		netFn, cmd, data := msg[1]>>2, msg[5], msg[6:len(msg)-1]
+
+		if netFn == netFnApp && cmd == cmdSendMessage && b.ackFirst {
+			embedded, ok := b.bridge(data)
+			if !ok {
+				return b.response(netFn, cmd, msg[4], []byte{0x83})
+			}
+
+			b.queued = append(b.queued, b.response(embedded[1]>>2, embedded[5], msg[4],
+				embedded[6:len(embedded)-1]))
+
+			return b.response(netFn, cmd, msg[4], []byte{completionNormal})
+		}
+
+		return b.response(netFn, cmd, msg[4], b.command(netFn, cmd, data))
+	}
+
+	return nil
+}
+
+// response returns the sealed response to the request of netFn and cmd
+func (b *fakeBMC) response(netFn, cmd, seq byte, resp []byte) []byte {
+	head := []byte{consoleAddress, (netFn | 1) << 2}
+	head = append(head, checksum(head))
+
+	body := append([]byte{bmcAddress, seq, cmd}, resp...)
+	body = append(body, checksum(body))
+
+	b.seq++
+
+	return sealPacket(b.suite, b.k1, b.k2, binary.LittleEndian.Uint32(b.consoleID), b.seq,
+		append(head, body...))
+}
+
+func (b *fakeBMC) takeQueued() [][]byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	queued := b.queued
+	b.queued = nil
+
+	return queued
+}
+
+// bridge passes the IPMB request in data of Send Message to the target and
+// returns the IPMB response. ok is false if there is no such target.
+func (b *fakeBMC) bridge(data []byte) ([]byte, bool) {
+	msg := data[1:]
+
+	target, ok := b.targets[msg[0]]
+	if !ok {
+		return nil, false
+	}
+
+	netFn, cmd := msg[1]>>2, msg[5]
+
+	head := []byte{msg[3], (netFn | 1) << 2}
+	head = append(head, checksum(head))
+
+	body := append([]byte{msg[0], msg[4], cmd}, target.command(netFn, cmd, msg[6:len(msg)-1])...)
+	body = append(body, checksum(body))
+
+	return append(head, body...), true
+}
+
+// command returns completion code and data of the response
+func (b *fakeBMC) command(netFn, cmd byte, data []byte) []byte {
+	switch {
+	case netFn == netFnApp && cmd == cmdSetSessionPrivilege:
+		if data[0] > b.privilege {
+			return []byte{0x81}
+		}
+
+		return []byte{completionNormal, data[0]}
+	case netFn == netFnApp && cmd == cmdCloseSession:
+		return []byte{completionNormal}
+	case netFn == netFnApp && cmd == cmdSendMessage:
+		resp, ok := b.bridge(data)
+		if !ok {
+			// NAK on write
+			return []byte{0x83}
+		}
+
+		return append([]byte{completionNormal}, resp...)
+	case netFn == netFnChassis && cmd == cmdGetChassisStatus:
+		var state byte
+		if b.power {
+			state = 0x01
+		}
+
+		return []byte{completionNormal, state, 0, 0}
+	case netFn == netFnChassis && cmd == cmdChassisControl:
+		b.controls = append(b.controls, ChassisControl(data[0]))
+		b.power = ChassisControl(data[0]) != PowerDown
+
+		return []byte{completionNormal}
+	case netFn == netFnChassis && cmd == cmdSetSystemBootOptions:
+		b.bootFlags = append([]byte{}, data...)
+		return []byte{completionNormal}
+	case netFn == netFnChassis && cmd == cmdGetSystemBootOptions:
+		flags := []byte{0, 0, 0, 0, 0}
+		if len(b.bootFlags) > 1 {
+			flags = b.bootFlags[1:]
+		}
+
+		return append([]byte{completionNormal, 0x01, bootOptionBootFlags}, flags...)
+	}
+
+	return []byte{0xc1}
+}
+
+func TestSession(t *testing.T) {
+	testcases := map[string]struct {
+		suite int
+	}{
+		"cipher suite 3": {
+			suite: CipherSuite3,
+		},
+		"cipher suite 17": {
+			suite: CipherSuite17,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			bmc := &fakeBMC{suite: testSuites[tc.suite], username: "admin", password: "secret"}
+			address := newFakeBMC(t, bmc)
+
+			ctx := context.Background()
+
+			s, err := Dial(ctx, address, "admin", "secret", WithCipherSuite(tc.suite))
+			require.NoError(t, err)
+
+			status, err := s.ChassisStatus(ctx)
+			require.NoError(t, err)
+			assert.False(t, status.PowerOn)
+
+			require.NoError(t, s.SetBootDevice(ctx, BootDevicePXE, true))
+
+			flags, err := s.BootFlags(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, &BootFlags{Device: BootDevicePXE, Valid: true, EFI: true}, flags)
+
+			require.NoError(t, s.ChassisControl(ctx, PowerUp))
+
+			status, err = s.ChassisStatus(ctx)
+			require.NoError(t, err)
+			assert.True(t, status.PowerOn)
+			assert.False(t, status.Faulty())
+
+			assert.NoError(t, s.Close())
+
+			bmc.mutex.Lock()
+			defer bmc.mutex.Unlock()
+
+			assert.Equal(t, []ChassisControl{PowerUp}, bmc.controls)
+			assert.Equal(t, []byte{bootOptionBootFlags, 0xa0, byte(BootDevicePXE), 0, 0, 0}, bmc.bootFlags)
+		})
+	}
+}
+
+func TestDialError(t *testing.T) {
+	testcases := map[string]struct {
+		bmc     *fakeBMC
+		user    string
+		pass    string
+		options []Option
+		err     error
+	}{
+		"invalid password": {
+			bmc:  &fakeBMC{username: "admin", password: "secret"},
+			user: "admin",
+			pass: "wrong",
+			err:  ErrAuthentication,
+		},
+		"unknown user": {
+			bmc:  &fakeBMC{username: "admin", password: "secret"},
+			user: "root",
+			pass: "secret",
+			err:  ErrAuthentication,
+		},
+		"privilege not allowed": {
+			bmc:  &fakeBMC{username: "admin", password: "secret", privilege: PrivilegeOperator},
+			user: "admin",
+			pass: "secret",
+			err:  ErrAuthentication,
+		},
+		"cipher suite not supported by BMC": {
+			bmc:     &fakeBMC{username: "admin", password: "secret"},
+			user:    "admin",
+			pass:    "secret",
+			options: []Option{WithCipherSuite(CipherSuite17)},
+			err:     ErrUnsupportedCipherSuite,
+		},
+		"unsupported cipher suite": {
+			bmc:     &fakeBMC{},
+			options: []Option{WithCipherSuite(1)},
+			err:     ErrUnsupportedCipherSuite,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			address := newFakeBMC(t, tc.bmc)
+
+			_, err := Dial(context.Background(), address, tc.user, tc.pass, tc.options...)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestPersistentBootDevice(t *testing.T) {
+	t.Parallel()
+
+	bmc := &fakeBMC{username: "admin", password: "secret"}
+	address := newFakeBMC(t, bmc)
+
+	ctx := context.Background()
+
+	s, err := Dial(ctx, address, "admin", "secret")
+	require.NoError(t, err)
+
+	//nolint:errcheck // fake BMC doesn't fail to close sessions
+	defer s.Close()
+
+	flags, err := s.BootFlags(ctx)
+	require.NoError(t, err)
+	assert.False(t, flags.Valid)
+
+	require.NoError(t, s.SetPersistentBootDevice(ctx, BootDeviceDisk, false))
+
+	flags, err = s.BootFlags(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &BootFlags{Device: BootDeviceDisk, Valid: true, Persistent: true}, flags)
+}
+
+func TestBridge(t *testing.T) {
+	testcases := map[string]struct {
+		bridges []Bridge
+	}{
+		"single": {
+			bridges: []Bridge{{Channel: 7, Address: 0x72}},
+		},
+		"double": {
+			bridges: []Bridge{{Channel: 0, Address: 0x82}, {Channel: 7, Address: 0x72}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			node := &fakeBMC{}
+			bmc := &fakeBMC{username: "admin", password: "secret"}
+
+			if len(tc.bridges) == 1 {
+				bmc.targets = map[byte]*fakeBMC{0x72: node}
+			} else {
+				bmc.targets = map[byte]*fakeBMC{0x82: {targets: map[byte]*fakeBMC{0x72: node}}}
+			}
+
+			address := newFakeBMC(t, bmc)
+			ctx := context.Background()
+
+			s, err := Dial(ctx, address, "admin", "secret")
+			require.NoError(t, err)
+
+			bridged := s.Bridge(tc.bridges...)
+
+			require.NoError(t, bridged.ChassisControl(ctx, PowerUp))
+
+			status, err := bridged.ChassisStatus(ctx)
+			require.NoError(t, err)
+			assert.True(t, status.PowerOn)
+
+			// The BMC itself is left as it is
+			status, err = s.ChassisStatus(ctx)
+			require.NoError(t, err)
+			assert.False(t, status.PowerOn)
+
+			assert.NoError(t, bridged.Close())
+
+			bmc.mutex.Lock()
+			defer bmc.mutex.Unlock()
+
+			assert.Equal(t, []ChassisControl{PowerUp}, node.controls)
+			assert.Empty(t, bmc.controls)
+		})
+	}
+}
+
+func TestBridgeUnknownTarget(t *testing.T) {
+	t.Parallel()
+
+	address := newFakeBMC(t, &fakeBMC{username: "admin", password: "secret"})
+	ctx := context.Background()
+
+	s, err := Dial(ctx, address, "admin", "secret")
+	require.NoError(t, err)
+
+	//nolint:errcheck // fake BMC doesn't fail to close sessions
+	defer s.Close()
+
+	_, err = s.Bridge(Bridge{Channel: 7, Address: 0x72}).ChassisStatus(ctx)
+
+	var cerr *CompletionError
+
+	require.ErrorAs(t, err, &cerr)
+	assert.Equal(t, uint8(0x83), cerr.Code)
+}
+
+func TestBridgeAcknowledgedFirst(t *testing.T) {
+	t.Parallel()
+
+	bmc := &fakeBMC{
+		username: "admin",
+		password: "secret",
+		targets:  map[byte]*fakeBMC{0x72: {}},
+		ackFirst: true,
+	}
+
+	address := newFakeBMC(t, bmc)
+	ctx := context.Background()
+
+	s, err := Dial(ctx, address, "admin", "secret")
+	require.NoError(t, err)
+
+	//nolint:errcheck // fake BMC doesn't fail to close sessions
+	defer s.Close()
+
+	_, err = s.Bridge(Bridge{Channel: 7, Address: 0x72}).ChassisStatus(ctx)
+	assert.ErrorIs(t, err, ErrInvalidResponse)
+}
+
+func TestDialUnreachable(t *testing.T) {
+	t.Parallel()
+
+	// Nothing is ever read from conn
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	_, err = Dial(context.Background(), conn.LocalAddr().String(), "admin", "secret",
+		WithTimeout(10*time.Millisecond, 1))
+	assert.ErrorIs(t, err, ErrUnreachable)
+	assert.False(t, errors.Is(err, ErrAuthentication))
+}
+
+func TestDialCancelled(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err = Dial(ctx, conn.LocalAddr().String(), "admin", "secret", WithTimeout(time.Minute, 0))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCompletionError(t *testing.T) {
+	t.Parallel()
+
+	address := newFakeBMC(t, &fakeBMC{username: "admin", password: "secret"})
+
+	s, err := Dial(context.Background(), address, "admin", "secret")
+	require.NoError(t, err)
+
+	defer s.Close()
+
+	_, err = s.command(context.Background(), "Get Device ID", netFnApp, 0x01, nil)
+
+	var cerr *CompletionError
+	require.True(t, errors.As(err, &cerr))
+	assert.Equal(t, uint8(0xc1), cerr.Code)
+	assert.EqualError(t, err, "IPMI command Get Device ID failed: invalid command (0xc1)")
+}
+
+func TestChecksum(t *testing.T) {
+	msg := ipmbRequest(bmcAddress, consoleAddress, netFnChassis, cmdGetChassisStatus, 5, nil)
+
+	assert.Equal(t, []byte{0x20, 0x00, 0xe0, 0x81, 0x14, 0x01, 0x6a}, msg)
+	assert.Equal(t, byte(0), checksum(msg[:3]))
+	assert.Equal(t, byte(0), checksum(msg[3:]))
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ipmibridge provides a limited ipmitool compatible interface to
+// power drivers of the Agent, so existing operator scripts keep working,
+// while their commands are rate limited, audited and subject to circuits
+// of the remediation engine like any other power operation.
+package ipmibridge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/remediation"
+)
+
+// Path is where Bridge is expected to be served
+const Path = "/ipmi-bridge"
+
+const (
+	defaultMinInterval = time.Second
+	commandTimeout     = 2 * time.Minute
+	maxRequestSize     = 64 << 10
+)
+
+// sources are activity names of the power actions, so the same remediation
+// rules and circuits apply to bridged commands and to power activities.
+var sources = map[string]string{
+	ActionStatus: "power-query",
+	ActionOn:     "power-on",
+	ActionOff:    "power-off",
+	ActionCycle:  "power-cycle",
+	ActionReset:  "power-cycle",
+}
+
+// Executor runs power actions. It is implemented by power.PowerService.
+type Executor interface {
+	Execute(ctx context.Context, action string, param power.PowerParam) (string, error)
+}
+
+// Request is sent by the ipmitool compatible client
+type Request struct {
+	Args []string `json:"args"`
+	// Password resolved by the client for -E or -f
+	Password string `json:"password,omitempty"`
+}
+
+// Response contains what ipmitool would print and its exit code
+type Response struct {
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// Bridge executes ipmitool commands with power drivers of the Agent
+type Bridge struct {
+	executor Executor
+	engine   *remediation.Engine
+	// next is when the next command to a BMC is allowed
+	next        map[string]time.Time
+	now         func() time.Time
+	minInterval time.Duration
+	mutex       sync.Mutex
+}
+
+// BridgeOption allows to set additional options for the Bridge
+type BridgeOption func(*Bridge)
+
+// WithMinInterval sets the minimum interval between commands sent to
+// the same BMC. Commands sent more often are delayed.
+func WithMinInterval(d time.Duration) BridgeOption {
+	return func(b *Bridge) {
+		b.minInterval = d
+	}
+}
+
+// WithRemediation makes commands subject to circuits of the engine and
+// reports their results as events.
+func WithRemediation(e *remediation.Engine) BridgeOption {
+	return func(b *Bridge) {
+		b.engine = e
+	}
+}
+
+// NewBridge returns Bridge executing commands with executor
+func NewBridge(executor Executor, options ...BridgeOption) *Bridge {
+	b := &Bridge{
+		executor:    executor,
+		next:        make(map[string]time.Time),
+		now:         time.Now,
+		minInterval: defaultMinInterval,
+	}
+
+	for _, opt := range options {
+		opt(b)
+	}
+
+	return b
+}
+
+// Run executes ipmitool command line
+func (b *Bridge) Run(ctx context.Context, req Request) Response {
+	cmd, err := ParseArgs(req.Args, req.Password)
+	if err != nil {
+		return Response{Stderr: err.Error() + "\n", ExitCode: 1}
+	}
+
+	source := sources[cmd.Action]
+	attrs := map[string]string{
+		"driver_type":               power.DriverIPMI,
+		"driver_opts.power_address": cmd.Host,
+		"driver_opts.power_user":    cmd.User,
+	}
+
+	logger := log.Info().Str("host", cmd.Host).Str("user", cmd.User).Str("action", cmd.Action)
+
+	if err := b.engine.Allow(source, attrs); err != nil {
+		logger.Err(err).Msg("IPMI bridge command rejected")
+		return Response{Stderr: err.Error() + "\n", ExitCode: 1}
+	}
+
+	if err := b.wait(ctx, cmd.Host); err != nil {
+		logger.Err(err).Msg("IPMI bridge command cancelled")
+		return Response{Stderr: err.Error() + "\n", ExitCode: 1}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	state, err := b.executor.Execute(ctx, cmd.driverAction(),
+		power.PowerParam{DriverType: power.DriverIPMI, DriverOpts: cmd.driverOpts()})
+
+	ev := remediation.Event{Kind: remediation.EventActivitySucceeded, Source: source, Attributes: attrs}
+
+	if err != nil {
+		ev.Kind = remediation.EventActivityFailed
+		attrs["error"] = err.Error()
+	}
+
+	b.engine.Handle(ev)
+
+	logger.Err(err).Str("state", state).Msg("IPMI bridge command")
+
+	if err != nil {
+		return Response{Stderr: cmd.failure(err), ExitCode: 1}
+	}
+
+	return Response{Stdout: cmd.output(state) + "\n"}
+}
+
+// wait delays the command until minInterval passed since the previous
+// command sent to the host.
+func (b *Bridge) wait(ctx context.Context, host string) error {
+	b.mutex.Lock()
+
+	now := b.now()
+	at := b.next[host]
+
+	if at.Before(now) {
+		at = now
+	}
+
+	b.next[host] = at.Add(b.minInterval)
+
+	for h, t := range b.next {
+		if t.Before(now) {
+			delete(b.next, h)
+		}
+	}
+
+	b.mutex.Unlock()
+
+	if !at.After(now) {
+		return nil
+	}
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ServeHTTP runs Request posted by the client. The response is always
+// 200 OK, unless the request is invalid, with the exit code in the body.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req Request
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := b.Run(r.Context(), req)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Warn().Err(err).Msg("Failed to write IPMI bridge response")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipmibridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/remediation"
+)
+
+type execution struct {
+	action string
+	param  power.PowerParam
+}
+
+type fakeExecutor struct {
+	err        error
+	state      string
+	executions []execution
+	mu         sync.Mutex
+}
+
+func (f *fakeExecutor) Execute(_ context.Context, action string, param power.PowerParam) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.executions = append(f.executions, execution{action: action, param: param})
+
+	return f.state, f.err
+}
+
+func TestBridgeRun(t *testing.T) {
+	testcases := map[string]struct {
+		args     []string
+		state    string
+		err      error
+		out      Response
+		executed string
+	}{
+		"status": {
+			args:     []string{"-H", "bmc", "-U", "admin", "-P", "x", "chassis", "power", "status"},
+			state:    "on",
+			out:      Response{Stdout: "Chassis Power is on\n"},
+			executed: ActionStatus,
+		},
+		"reset": {
+			args:     []string{"-H", "bmc", "power", "reset"},
+			state:    "on",
+			out:      Response{Stdout: "Chassis Power Control: Reset\n"},
+			executed: ActionCycle,
+		},
+		"driver error": {
+			args: []string{"-H", "bmc", "power", "off"},
+			err:  errors.New("exit status 1"),
+			out: Response{
+				Stderr:   "Error: Unable to set Chassis Power Control to off: exit status 1\n",
+				ExitCode: 1,
+			},
+			executed: ActionOff,
+		},
+		"unsupported": {
+			args: []string{"-H", "bmc", "sdr", "list"},
+			out: Response{
+				Stderr:   "not supported by the MAAS Agent bridge: command \"sdr list\"\n",
+				ExitCode: 1,
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			executor := &fakeExecutor{state: tc.state, err: tc.err}
+			b := NewBridge(executor)
+
+			res := b.Run(context.Background(), Request{Args: tc.args})
+			assert.Equal(t, tc.out, res)
+
+			if tc.executed == "" {
+				assert.Empty(t, executor.executions)
+				return
+			}
+
+			require.Len(t, executor.executions, 1)
+			assert.Equal(t, tc.executed, executor.executions[0].action)
+			assert.Equal(t, "ipmi", executor.executions[0].param.DriverType)
+		})
+	}
+}
+
+func TestBridgeCircuit(t *testing.T) {
+	t.Parallel()
+
+	engine, err := remediation.NewEngine([]remediation.Rule{{
+		Name:    "bmc-failures",
+		Event:   remediation.EventActivityFailed,
+		Source:  "power-on",
+		GroupBy: []string{"driver_opts.power_address"},
+		Count:   1,
+		Window:  time.Minute,
+		Actions: []remediation.Action{{Type: remediation.ActionOpenCircuit, Duration: time.Minute}},
+	}})
+	require.NoError(t, err)
+
+	executor := &fakeExecutor{err: errors.New("timeout")}
+	b := NewBridge(executor, WithRemediation(engine), WithMinInterval(time.Millisecond))
+
+	res := b.Run(context.Background(), Request{Args: []string{"-H", "bmc", "power", "on"}})
+	assert.Equal(t, 1, res.ExitCode)
+
+	res = b.Run(context.Background(), Request{Args: []string{"-H", "bmc", "power", "on"}})
+	assert.Equal(t, 1, res.ExitCode)
+	assert.Contains(t, res.Stderr, "circuit")
+	assert.Len(t, executor.executions, 1)
+
+	// Other BMCs are not affected
+	res = b.Run(context.Background(), Request{Args: []string{"-H", "other", "power", "on"}})
+	assert.Len(t, executor.executions, 2)
+	assert.Equal(t, 1, res.ExitCode)
+}
+
+func TestBridgeRateLimit(t *testing.T) {
+	t.Parallel()
+
+	executor := &fakeExecutor{state: "off"}
+	b := NewBridge(executor, WithMinInterval(50*time.Millisecond))
+
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		res := b.Run(context.Background(), Request{Args: []string{"-H", "bmc", "power", "status"}})
+		assert.Equal(t, 0, res.ExitCode)
+	}
+
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res := b.Run(ctx, Request{Args: []string{"-H", "bmc", "power", "status"}})
+	assert.Equal(t, 1, res.ExitCode)
+	assert.Len(t, executor.executions, 3)
+}
+
+func TestBridgeServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	b := NewBridge(&fakeExecutor{state: "off"})
+
+	body, err := json.Marshal(Request{Args: []string{"-H", "bmc", "-E", "power", "status"}, Password: "x"})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, httptest.NewRequest(http.MethodPost, Path, bytes.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var res Response
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, Response{Stdout: "Chassis Power is off\n"}, res)
+
+	w = httptest.NewRecorder()
+	b.ServeHTTP(w, httptest.NewRequest(http.MethodGet, Path, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
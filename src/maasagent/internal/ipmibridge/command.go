@@ -0,0 +1,226 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipmibridge
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Power actions supported by the bridge, as named by ipmitool
+const (
+	ActionStatus = "status"
+	ActionOn     = "on"
+	ActionOff    = "off"
+	ActionCycle  = "cycle"
+	ActionReset  = "reset"
+)
+
+const defaultIPMIPort = 623
+
+var (
+	// ErrUsage is returned for arguments ipmitool would reject
+	ErrUsage = errors.New("invalid arguments")
+	// ErrUnsupported is returned for valid ipmitool arguments which are
+	// not supported by the bridge
+	ErrUnsupported = errors.New("not supported by the MAAS Agent bridge")
+)
+
+// privilegeLevels map ipmitool privilege levels to ones of the IPMI driver
+var privilegeLevels = map[string]string{
+	"USER":          "USER",
+	"OPERATOR":      "OPERATOR",
+	"ADMINISTRATOR": "ADMIN",
+}
+
+// Command is a parsed ipmitool command line. Only power control of remote
+// BMCs is supported, e.g.:
+//
+//	ipmitool -I lanplus -H 10.0.0.5 -U admin -E chassis power status
+type Command struct {
+	Interface   string
+	Host        string
+	User        string
+	Password    string
+	Privilege   string
+	CipherSuite string
+	KG          string
+	Action      string
+}
+
+// ParseArgs parses ipmitool arguments. password is used for -E (environment)
+// and -f (file), which are resolved by the client.
+func ParseArgs(args []string, password string) (*Command, error) {
+	c := &Command{Interface: "lan"}
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		opt := args[0]
+		args = args[1:]
+
+		switch opt {
+		case "-E", "-f":
+			c.Password = password
+
+			if opt == "-f" {
+				if len(args) == 0 {
+					return nil, fmt.Errorf("%w: option %s requires an argument", ErrUsage, opt)
+				}
+
+				args = args[1:]
+			}
+
+			continue
+		case "-v", "-c":
+			// Verbosity and CSV output do not change power commands
+			continue
+		}
+
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%w: option %s requires an argument", ErrUsage, opt)
+		}
+
+		value := args[0]
+		args = args[1:]
+
+		switch opt {
+		case "-I":
+			if value != "lan" && value != "lanplus" {
+				return nil, fmt.Errorf("%w: interface %q", ErrUnsupported, value)
+			}
+
+			c.Interface = value
+		case "-H":
+			c.Host = value
+		case "-U":
+			c.User = value
+		case "-P":
+			c.Password = value
+		case "-L":
+			level, ok := privilegeLevels[strings.ToUpper(value)]
+			if !ok {
+				return nil, fmt.Errorf("%w: privilege level %q", ErrUsage, value)
+			}
+
+			c.Privilege = level
+		case "-C":
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("%w: cipher suite %q", ErrUsage, value)
+			}
+
+			c.CipherSuite = value
+		case "-y":
+			c.KG = value
+		case "-p":
+			if port, err := strconv.Atoi(value); err != nil || port != defaultIPMIPort {
+				return nil, fmt.Errorf("%w: port %q", ErrUnsupported, value)
+			}
+		case "-N", "-R":
+			// Timeouts and retries are handled by the driver
+		default:
+			return nil, fmt.Errorf("%w: option %s", ErrUnsupported, opt)
+		}
+	}
+
+	if c.Host == "" {
+		return nil, fmt.Errorf("%w: local interface, -H is required", ErrUnsupported)
+	}
+
+	if len(args) > 0 && args[0] == "chassis" {
+		args = args[1:]
+	}
+
+	if len(args) != 2 || args[0] != "power" {
+		return nil, fmt.Errorf("%w: command %q", ErrUnsupported, strings.Join(args, " "))
+	}
+
+	switch args[1] {
+	case ActionStatus, ActionOn, ActionOff, ActionCycle, ActionReset:
+		c.Action = args[1]
+	case "up":
+		c.Action = ActionOn
+	case "down":
+		c.Action = ActionOff
+	default:
+		return nil, fmt.Errorf("%w: chassis power %s", ErrUnsupported, args[1])
+	}
+
+	return c, nil
+}
+
+// driverOpts returns options of the IPMI power driver
+func (c *Command) driverOpts() map[string]interface{} {
+	opts := map[string]interface{}{
+		"power_address": c.Host,
+		"power_user":    c.User,
+		"power_pass":    c.Password,
+		"power_driver":  "LAN",
+	}
+
+	if c.Interface == "lanplus" {
+		opts["power_driver"] = "LAN_2_0"
+	}
+
+	if c.Privilege != "" {
+		opts["privilege_level"] = c.Privilege
+	}
+
+	if c.CipherSuite != "" {
+		opts["cipher_suite_id"] = c.CipherSuite
+	}
+
+	if c.KG != "" {
+		opts["k_g"] = c.KG
+	}
+
+	return opts
+}
+
+// driverAction returns the action of the power driver. The driver has no
+// hard reset, so it is done with power cycle.
+func (c *Command) driverAction() string {
+	if c.Action == ActionReset {
+		return ActionCycle
+	}
+
+	return c.Action
+}
+
+// output formats the result of the command like ipmitool does
+func (c *Command) output(state string) string {
+	switch c.Action {
+	case ActionStatus:
+		return fmt.Sprintf("Chassis Power is %s", state)
+	case ActionOn:
+		return "Chassis Power Control: Up/On"
+	case ActionOff:
+		return "Chassis Power Control: Down/Off"
+	case ActionCycle:
+		return "Chassis Power Control: Cycle"
+	default:
+		return "Chassis Power Control: Reset"
+	}
+}
+
+// failure formats error of the command like ipmitool does
+func (c *Command) failure(err error) string {
+	if c.Action == ActionStatus {
+		return "Error: Unable to get Chassis Power Status: " + err.Error() + "\n"
+	}
+
+	return "Error: Unable to set Chassis Power Control to " + c.Action + ": " + err.Error() + "\n"
+}
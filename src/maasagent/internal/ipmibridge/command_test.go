@@ -0,0 +1,117 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipmibridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	testcases := map[string]struct {
+		args     []string
+		password string
+		out      *Command
+		opts     map[string]interface{}
+		err      error
+	}{
+		"lanplus status": {
+			args: []string{"-I", "lanplus", "-H", "10.0.0.5", "-U", "admin", "-P", "secret",
+				"chassis", "power", "status"},
+			out: &Command{
+				Interface: "lanplus", Host: "10.0.0.5", User: "admin", Password: "secret",
+				Action: ActionStatus,
+			},
+			opts: map[string]interface{}{
+				"power_address": "10.0.0.5", "power_user": "admin", "power_pass": "secret",
+				"power_driver": "LAN_2_0",
+			},
+		},
+		"password from environment": {
+			args:     []string{"-H", "10.0.0.5", "-U", "admin", "-E", "-L", "operator", "power", "off"},
+			password: "env",
+			out: &Command{
+				Interface: "lan", Host: "10.0.0.5", User: "admin", Password: "env",
+				Privilege: "OPERATOR", Action: ActionOff,
+			},
+			opts: map[string]interface{}{
+				"power_address": "10.0.0.5", "power_user": "admin", "power_pass": "env",
+				"power_driver": "LAN", "privilege_level": "OPERATOR",
+			},
+		},
+		"password file": {
+			args: []string{"-I", "lanplus", "-H", "bmc", "-f", "/etc/pass", "-C", "17", "-y", "0102",
+				"-N", "5", "-R", "3", "chassis", "power", "up"},
+			password: "file",
+			out: &Command{
+				Interface: "lanplus", Host: "bmc", Password: "file", CipherSuite: "17",
+				KG: "0102", Action: ActionOn,
+			},
+			opts: map[string]interface{}{
+				"power_address": "bmc", "power_user": "", "power_pass": "file",
+				"power_driver": "LAN_2_0", "cipher_suite_id": "17", "k_g": "0102",
+			},
+		},
+		"local interface": {
+			args: []string{"chassis", "power", "status"},
+			err:  ErrUnsupported,
+		},
+		"open interface": {
+			args: []string{"-I", "open", "chassis", "power", "status"},
+			err:  ErrUnsupported,
+		},
+		"other command": {
+			args: []string{"-H", "bmc", "sel", "list"},
+			err:  ErrUnsupported,
+		},
+		"soft off": {
+			args: []string{"-H", "bmc", "chassis", "power", "soft"},
+			err:  ErrUnsupported,
+		},
+		"missing argument": {
+			args: []string{"-H"},
+			err:  ErrUsage,
+		},
+		"invalid privilege": {
+			args: []string{"-H", "bmc", "-L", "root", "power", "on"},
+			err:  ErrUsage,
+		},
+		"custom port": {
+			args: []string{"-H", "bmc", "-p", "6230", "power", "on"},
+			err:  ErrUnsupported,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := ParseArgs(tc.args, tc.password)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, res)
+			assert.Equal(t, tc.opts, res.driverOpts())
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package journal persists state of in-flight local operations (e.g. image
+// downloads), so that after an Agent crash or restart they can be resumed or
+// cleaned up instead of leaving orphaned files and processes behind.
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+)
+
+const fileExt = ".json"
+
+var (
+	// ErrNotFound is returned when there is no pending operation with the given ID
+	ErrNotFound = errors.New("operation not found")
+)
+
+// Operation is a pending local operation.
+type Operation struct {
+	StartedAt time.Time `json:"started_at"`
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	// State is an operation specific state required to resume it
+	State json.RawMessage `json:"state"`
+}
+
+// Journal stores every pending operation in its own file, written atomically,
+// so a crash at any point leaves either the previous or the new state.
+type Journal struct {
+	now func() time.Time
+	dir string
+}
+
+// New returns Journal storing operations in dir.
+// If dir does not exist, it will be created.
+func New(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	return &Journal{dir: dir, now: time.Now}, nil
+}
+
+func (j *Journal) path(id string) string {
+	return filepath.Join(j.dir, url.PathEscape(id)+fileExt)
+}
+
+// Begin records the start of an operation. Beginning an operation that
+// is already pending replaces its kind and state.
+func (j *Journal) Begin(id, kind string, state any) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return j.write(Operation{ID: id, Kind: kind, State: b, StartedAt: j.now().UTC()})
+}
+
+// Update replaces state of a pending operation.
+func (j *Journal) Update(id string, state any) error {
+	op, err := j.read(j.path(id))
+	if err != nil {
+		return err
+	}
+
+	op.State, err = json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return j.write(op)
+}
+
+// Done removes operation from the journal.
+// Completing an unknown operation is not an error.
+func (j *Journal) Done(id string) error {
+	err := os.Remove(j.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Pending returns all pending operations ordered by start time.
+func (j *Journal) Pending() ([]Operation, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Operation
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileExt) {
+			continue
+		}
+
+		op, err := j.read(filepath.Join(j.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, op)
+	}
+
+	sort.Slice(res, func(i, k int) bool { return res[i].StartedAt.Before(res[k].StartedAt) })
+
+	return res, nil
+}
+
+// RecoverFunc resumes or cleans up an operation interrupted by a restart.
+type RecoverFunc func(ctx context.Context, op Operation) error
+
+// Recover calls handler registered for the kind of each pending operation.
+// Operations are removed from the journal regardless of the outcome, so a
+// failing operation cannot prevent the Agent from starting repeatedly.
+// Handlers are expected to start the operation again (which records it
+// in the journal again) if it should be retried.
+func (j *Journal) Recover(ctx context.Context, handlers map[string]RecoverFunc) error {
+	ops, err := j.Pending()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, op := range ops {
+		if err := j.Done(op.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		handler, ok := handlers[op.Kind]
+		if !ok {
+			log.Warn().Str("id", op.ID).Str("kind", op.Kind).
+				Msg("Dropping interrupted operation of unknown kind")
+
+			continue
+		}
+
+		if err := handler(ctx, op); err != nil {
+			errs = append(errs, fmt.Errorf("failed to recover %s %q: %w", op.Kind, op.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (j *Journal) read(p string) (Operation, error) {
+	var op Operation
+
+	//nolint:gosec // path is built from the journal directory
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return op, ErrNotFound
+		}
+
+		return op, err
+	}
+
+	return op, json.Unmarshal(b, &op)
+}
+
+func (j *Journal) write(op Operation) error {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(j.path(op.ID), b, 0600)
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package journal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	j, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	now := time.Unix(100, 0)
+	j.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	require.NoError(t, j.Begin("/var/lib/maas/image", "image-fetch", "started"))
+	require.NoError(t, j.Begin("console:abc", "console", 1))
+	require.NoError(t, j.Update("console:abc", 2))
+
+	assert.ErrorIs(t, j.Update("missing", 1), ErrNotFound)
+	assert.NoError(t, j.Done("missing"))
+
+	ops, err := j.Pending()
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+
+	assert.Equal(t, "/var/lib/maas/image", ops[0].ID)
+	assert.Equal(t, "image-fetch", ops[0].Kind)
+	assert.JSONEq(t, `"started"`, string(ops[0].State))
+	assert.Equal(t, "console:abc", ops[1].ID)
+	assert.JSONEq(t, `2`, string(ops[1].State))
+}
+
+func TestRecover(t *testing.T) {
+	j, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, j.Begin("a", "resumed", nil))
+	require.NoError(t, j.Begin("b", "failing", nil))
+	require.NoError(t, j.Begin("c", "unknown", nil))
+
+	var recovered []string
+
+	errFailed := errors.New("failed")
+
+	err = j.Recover(context.Background(), map[string]RecoverFunc{
+		"resumed": func(_ context.Context, op Operation) error {
+			recovered = append(recovered, op.ID)
+			return nil
+		},
+		"failing": func(_ context.Context, op Operation) error {
+			recovered = append(recovered, op.ID)
+			return errFailed
+		},
+	})
+
+	assert.ErrorIs(t, err, errFailed)
+	assert.ElementsMatch(t, []string{"a", "b"}, recovered)
+
+	ops, err := j.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+}
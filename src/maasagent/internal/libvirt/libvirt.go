@@ -0,0 +1,503 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package libvirt connects the Agent to libvirtd with go-libvirt, the
+// implementation of the libvirt remote protocol, to control libvirt domains
+// without running virsh for every operation. Domain state is reported as it
+// is, rather than as virsh prints it.
+//
+// Connections are opened over the local UNIX socket (qemu:///system), TCP
+// (qemu+tcp://host/system) or SSH (qemu+ssh://user@host/system). SSH is
+// run by the ssh client, as libvirt does, which proxies the connection to
+// libvirtd with virt-ssh-helper, or netcat on older hosts.
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+	"github.com/digitalocean/go-libvirt/socket/dialers"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+const (
+	// DefaultTCPPort is the port of libvirtd listening on TCP
+	DefaultTCPPort = 16509
+	// DefaultSocket is the UNIX socket of the system libvirtd
+	DefaultSocket = "/var/run/libvirt/libvirt-sock"
+)
+
+const closeTimeout = 5 * time.Second
+
+// DomainXMLInactive asks for XML of the persistent definition of a domain,
+// rather than of the running one
+const DomainXMLInactive = uint32(golibvirt.DomainXMLInactive)
+
+var (
+	// ErrUnsupportedURI is returned for URIs with transports other than
+	// unix, tcp and ssh
+	ErrUnsupportedURI = errcode.New(errcode.PowerUnsupported, "unsupported libvirt URI")
+	// ErrAuthRequired is returned when libvirtd requires SASL or polkit
+	// authentication, which is not supported
+	ErrAuthRequired = errcode.New(errcode.PowerAuthFailed, "libvirt authentication required")
+	// ErrNoDomain is returned when the domain doesn't exist
+	ErrNoDomain = errcode.New(errcode.PowerNotFound, "no such domain")
+	// ErrOperationInvalid is returned when the domain is not in a state
+	// allowing the operation, e.g. starting a running domain
+	ErrOperationInvalid = errcode.New(errcode.PowerOperationFailed, "operation invalid for domain state")
+)
+
+// Error is an error reported by libvirtd
+type Error struct {
+	Message string
+	Code    golibvirt.ErrorNumber
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("libvirt error %d", e.Code)
+	}
+
+	return e.Message
+}
+
+// Is makes errors.Is() match libvirt errors the Agent handles
+func (e *Error) Is(target error) bool {
+	switch e.Code {
+	case golibvirt.ErrNoDomain:
+		return target == ErrNoDomain
+	case golibvirt.ErrOperationInvalid:
+		return target == ErrOperationInvalid
+	case golibvirt.ErrAuthFailed:
+		return target == ErrAuthRequired
+	}
+
+	return false
+}
+
+// Domain identifies a libvirt domain. ID is -1 unless the domain is running.
+type Domain = golibvirt.Domain
+
+// DomainState is the state of a libvirt domain (virDomainState)
+type DomainState int32
+
+// Domain states
+const (
+	DomainNoState     = DomainState(golibvirt.DomainNostate)
+	DomainRunning     = DomainState(golibvirt.DomainRunning)
+	DomainBlocked     = DomainState(golibvirt.DomainBlocked)
+	DomainPaused      = DomainState(golibvirt.DomainPaused)
+	DomainShutdown    = DomainState(golibvirt.DomainShutdown)
+	DomainShutoff     = DomainState(golibvirt.DomainShutoff)
+	DomainCrashed     = DomainState(golibvirt.DomainCrashed)
+	DomainPMSuspended = DomainState(golibvirt.DomainPmsuspended)
+)
+
+// String returns the state as printed by virsh
+func (s DomainState) String() string {
+	switch s {
+	case DomainNoState:
+		return "no state"
+	case DomainRunning:
+		return "running"
+	case DomainBlocked:
+		return "idle"
+	case DomainPaused:
+		return "paused"
+	case DomainShutdown:
+		return "in shutdown"
+	case DomainShutoff:
+		return "shut off"
+	case DomainCrashed:
+		return "crashed"
+	case DomainPMSuspended:
+		return "pmsuspended"
+	}
+
+	return "unknown"
+}
+
+// Active returns whether the domain has a running QEMU process, even if
+// its CPUs are paused or it is shutting down
+func (s DomainState) Active() bool {
+	switch s {
+	case DomainRunning, DomainBlocked, DomainPaused, DomainShutdown:
+		return true
+	}
+
+	return false
+}
+
+// Conn is a connection to libvirtd. go-libvirt calls don't take a context,
+// so the transport is closed when ctx of a call is done before it returns.
+type Conn struct {
+	client    *golibvirt.Libvirt
+	transport net.Conn
+	closeOnce sync.Once
+}
+
+// SupportsURI returns whether uri can be connected to by Dial
+func SupportsURI(uri string) bool {
+	_, _, _, err := parseURI(uri)
+	return err == nil
+}
+
+// parseURI returns parsed uri, its transport and the hypervisor URI as seen
+// by libvirtd
+func parseURI(uri string) (*url.URL, string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	driver, transport, _ := strings.Cut(u.Scheme, "+")
+	if driver == "" || u.Path == "" {
+		return nil, "", "", fmt.Errorf("%w: %q", ErrUnsupportedURI, uri)
+	}
+
+	switch transport {
+	case "", "unix":
+		// Remote URIs without a transport use TLS
+		if transport == "" && u.Host != "" {
+			return nil, "", "", fmt.Errorf("%w: TLS transport of %q", ErrUnsupportedURI, uri)
+		}
+
+		transport = "unix"
+	case "tcp":
+	case "ssh", "libssh", "libssh2":
+		transport = "ssh"
+	default:
+		return nil, "", "", fmt.Errorf("%w: %s transport", ErrUnsupportedURI, transport)
+	}
+
+	return u, transport, driver + "://" + u.Path, nil
+}
+
+// Dial connects to libvirtd of uri and opens the hypervisor connection
+func Dial(ctx context.Context, uri string) (*Conn, error) {
+	u, transport, name, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+
+	switch transport {
+	case "unix":
+		socket := u.Query().Get("socket")
+		if socket == "" {
+			socket = DefaultSocket
+		}
+
+		conn, err = (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	case "tcp":
+		port := u.Port()
+		if port == "" {
+			port = strconv.Itoa(DefaultTCPPort)
+		}
+
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	case "ssh":
+		conn, err = dialSSH(u, name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		client:    golibvirt.NewWithDialer(dialers.NewAlreadyConnected(conn)),
+		transport: conn,
+	}
+
+	// go-libvirt skips authentication types it doesn't support, so libvirtd
+	// requiring SASL refuses to open the connection
+	if err := c.do(ctx, func() error {
+		return c.client.ConnectToURI(golibvirt.ConnectURI(name))
+	}); err != nil {
+		//nolint:errcheck // we already return a more important error
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Ping checks the connection by asking for the hypervisor version
+func (c *Conn) Ping(ctx context.Context) error {
+	return c.do(ctx, func() error {
+		_, err := c.client.ConnectGetVersion()
+		return err
+	})
+}
+
+// Close closes the hypervisor connection and the transport
+func (c *Conn) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+
+		//nolint:errcheck // the connection is closed anyway
+		c.do(ctx, c.client.Disconnect)
+
+		// go-libvirt closes the transport, unless libvirtd is gone
+		err = c.transport.Close()
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+	})
+
+	return err
+}
+
+// LookupDomain returns the domain named name
+func (c *Conn) LookupDomain(ctx context.Context, name string) (Domain, error) {
+	var dom Domain
+
+	err := c.do(ctx, func() (err error) {
+		dom, err = c.client.DomainLookupByName(name)
+		return err
+	})
+
+	return dom, err
+}
+
+// DomainState returns the state of the domain
+func (c *Conn) DomainState(ctx context.Context, dom Domain) (DomainState, error) {
+	state := DomainNoState
+
+	err := c.do(ctx, func() error {
+		// The reason of the state is not used
+		s, _, err := c.client.DomainGetState(dom, 0)
+		state = DomainState(s)
+
+		return err
+	})
+
+	return state, err
+}
+
+// CreateDomain starts the defined domain
+func (c *Conn) CreateDomain(ctx context.Context, dom Domain) error {
+	return c.do(ctx, func() error { return c.client.DomainCreate(dom) })
+}
+
+// DestroyDomain stops the domain immediately, as pulling the plug
+func (c *Conn) DestroyDomain(ctx context.Context, dom Domain) error {
+	return c.do(ctx, func() error { return c.client.DomainDestroy(dom) })
+}
+
+// ShutdownDomain asks the guest OS to shut down, returning once the
+// request is sent
+func (c *Conn) ShutdownDomain(ctx context.Context, dom Domain) error {
+	return c.do(ctx, func() error { return c.client.DomainShutdown(dom) })
+}
+
+// ResumeDomain resumes CPUs of the paused domain
+func (c *Conn) ResumeDomain(ctx context.Context, dom Domain) error {
+	return c.do(ctx, func() error { return c.client.DomainResume(dom) })
+}
+
+// DomainXML returns XML description of the domain, of its persistent
+// definition with DomainXMLInactive flag
+func (c *Conn) DomainXML(ctx context.Context, dom Domain, flags uint32) (string, error) {
+	var xml string
+
+	err := c.do(ctx, func() (err error) {
+		xml, err = c.client.DomainGetXMLDesc(dom, golibvirt.DomainXMLFlags(flags))
+		return err
+	})
+
+	return xml, err
+}
+
+// DefineXML defines the domain described by xml, replacing the persistent
+// definition of the domain of the same name
+func (c *Conn) DefineXML(ctx context.Context, xml string) (Domain, error) {
+	var dom Domain
+
+	err := c.do(ctx, func() (err error) {
+		dom, err = c.client.DomainDefineXML(xml)
+		return err
+	})
+
+	return dom, err
+}
+
+// do runs fn, a call of go-libvirt. If ctx is done before fn returns, the
+// transport is closed, as there is no way to cancel a call.
+func (c *Conn) do(ctx context.Context, fn func() error) error {
+	stop := context.AfterFunc(ctx, func() {
+		//nolint:errcheck // the call returns ctx.Err()
+		c.transport.Close()
+	})
+	defer stop()
+
+	err := fn()
+	if err == nil {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var e golibvirt.Error
+	if errors.As(err, &e) {
+		return &Error{Code: golibvirt.ErrorNumber(e.Code), Message: e.Message}
+	}
+
+	return err
+}
+
+// cmdConn is a connection proxied by a process, e.g. ssh
+type cmdConn struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    io.Reader
+	closeOnce sync.Once
+}
+
+func dialSSH(u *url.URL, name string) (*cmdConn, error) {
+	// The process outlives ctx of Dial, it is terminated by Close()
+	//nolint:gosec // gosec's G204 flags any command execution using variables
+	cmd := exec.Command("ssh", sshArgs(u, name)...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &cmdConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+// sshArgs returns arguments of ssh connecting to libvirtd of u. Options
+// of the URI are those of libvirt ssh and libssh transports.
+func sshArgs(u *url.URL, name string) []string {
+	args := []string{"-T", "-e", "none", "-o", "BatchMode=yes"}
+
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+
+	if u.User != nil && u.User.Username() != "" {
+		args = append(args, "-l", u.User.Username())
+	}
+
+	query := u.Query()
+
+	if keyfile := query.Get("keyfile"); keyfile != "" {
+		args = append(args, "-i", keyfile)
+	}
+
+	if knownHosts := query.Get("known_hosts"); knownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+knownHosts)
+	}
+
+	switch {
+	case query.Get("no_verify") == "1" || query.Get("known_hosts_verify") == "ignore":
+		args = append(args, "-o", "StrictHostKeyChecking=no")
+	case query.Get("known_hosts_verify") == "normal":
+		args = append(args, "-o", "StrictHostKeyChecking=yes")
+	}
+
+	socket := query.Get("socket")
+	if socket == "" {
+		socket = DefaultSocket
+	}
+
+	proxy := fmt.Sprintf("if command -v virt-ssh-helper >/dev/null 2>&1; "+
+		"then exec virt-ssh-helper %s; else exec nc -U %s; fi",
+		shellQuote(name), shellQuote(socket))
+
+	return append(args, "--", u.Hostname(), "sh -c "+shellQuote(proxy))
+}
+
+// shellQuote quotes s as a single word of POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (c *cmdConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+func (c *cmdConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *cmdConn) Close() error {
+	c.closeOnce.Do(func() {
+		//nolint:errcheck // the process is killed anyway
+		c.stdin.Close()
+
+		//nolint:errcheck // the process might have exited already
+		c.cmd.Process.Kill()
+
+		//nolint:errcheck // killed process always returns an error
+		c.cmd.Wait()
+	})
+
+	return nil
+}
+
+// cmdAddr is the address of both ends of cmdConn
+type cmdAddr string
+
+func (a cmdAddr) Network() string { return "cmd" }
+
+func (a cmdAddr) String() string { return string(a) }
+
+func (c *cmdConn) LocalAddr() net.Addr {
+	return cmdAddr("agent")
+}
+
+func (c *cmdConn) RemoteAddr() net.Addr {
+	return cmdAddr(c.cmd.Path)
+}
+
+// SetDeadline is a no-op, deadlines are enforced by closing the connection
+func (c *cmdConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c *cmdConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *cmdConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package libvirt
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	libvirttest "maas.io/core/src/maasagent/internal/testing/libvirt"
+)
+
+func TestConnDomains(t *testing.T) {
+	daemon := libvirttest.NewLibvirtDaemon(t, map[string]*libvirttest.Domain{
+		"vm1": {State: libvirttest.StateShutoff, XML: "<domain><name>vm1</name></domain>"},
+	})
+
+	ctx := context.Background()
+
+	c, err := Dial(ctx, daemon.URI)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Ping(ctx))
+
+	_, err = c.LookupDomain(ctx, "vm2")
+	assert.ErrorIs(t, err, ErrNoDomain)
+
+	dom, err := c.LookupDomain(ctx, "vm1")
+	require.NoError(t, err)
+	assert.Equal(t, "vm1", dom.Name)
+	assert.Equal(t, int32(-1), dom.ID)
+
+	state, err := c.DomainState(ctx, dom)
+	require.NoError(t, err)
+	assert.Equal(t, DomainShutoff, state)
+	assert.False(t, state.Active())
+
+	require.NoError(t, c.CreateDomain(ctx, dom))
+	assert.ErrorIs(t, c.CreateDomain(ctx, dom), ErrOperationInvalid)
+
+	state, err = c.DomainState(ctx, dom)
+	require.NoError(t, err)
+	assert.Equal(t, "running", state.String())
+	assert.True(t, state.Active())
+
+	require.NoError(t, c.DestroyDomain(ctx, dom))
+
+	xml, err := c.DomainXML(ctx, dom, DomainXMLInactive)
+	require.NoError(t, err)
+	assert.Equal(t, "<domain><name>vm1</name></domain>", xml)
+
+	_, err = c.DefineXML(ctx, "<domain><name>vm1</name><os/></domain>")
+	require.NoError(t, err)
+	assert.Equal(t, "<domain><name>vm1</name><os/></domain>", daemon.Domain("vm1").XML)
+
+	require.NoError(t, c.Close())
+	require.NoError(t, c.Close())
+
+	// AUTH_LIST, CONNECT_OPEN, ..., CONNECT_CLOSE
+	procs := daemon.Procs()
+	assert.Equal(t, []uint32{66, 1}, procs[:2])
+	assert.Equal(t, uint32(2), procs[len(procs)-1])
+}
+
+func TestDialAuthRequired(t *testing.T) {
+	daemon := libvirttest.NewLibvirtDaemon(t, nil)
+	// SASL
+	daemon.AuthTypes = []int32{1}
+
+	_, err := Dial(context.Background(), daemon.URI)
+	assert.ErrorIs(t, err, ErrAuthRequired)
+}
+
+func TestDialUnsupportedURI(t *testing.T) {
+	testcases := map[string]string{
+		"TLS":         "qemu://host/system",
+		"unknown":     "qemu+ext://host/system?command=foo",
+		"no path":     "qemu+tcp://host",
+		"no driver":   "/system",
+		"invalid URI": "qemu+tcp://[host/system",
+	}
+
+	for name, uri := range testcases {
+		uri := uri
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.False(t, SupportsURI(uri))
+
+			_, err := Dial(context.Background(), uri)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCallCancelled(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "sock")
+
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { l.Close() })
+
+	// Accept connections, but never reply
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = Dial(ctx, "qemu:///system?socket="+socket)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSSHArgs(t *testing.T) {
+	testcases := map[string]struct {
+		uri  string
+		args []string
+	}{
+		"default": {
+			uri:  "qemu+ssh://host/system",
+			args: []string{"-T", "-e", "none", "-o", "BatchMode=yes", "--", "host"},
+		},
+		"user and port": {
+			uri: "qemu+ssh://maas@host:2222/system?keyfile=/root/.ssh/id_rsa&no_verify=1",
+			args: []string{"-T", "-e", "none", "-o", "BatchMode=yes", "-p", "2222", "-l", "maas",
+				"-i", "/root/.ssh/id_rsa", "-o", "StrictHostKeyChecking=no", "--", "host"},
+		},
+		"pinned host key": {
+			uri: "qemu+libssh://[fd00::1]/system?known_hosts=/var/lib/kh&known_hosts_verify=normal",
+			args: []string{"-T", "-e", "none", "-o", "BatchMode=yes",
+				"-o", "UserKnownHostsFile=/var/lib/kh", "-o", "StrictHostKeyChecking=yes", "--", "fd00::1"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			u, err := url.Parse(tc.uri)
+			require.NoError(t, err)
+
+			args := sshArgs(u, "qemu:///system")
+			assert.Equal(t, tc.args, args[:len(args)-1])
+			assert.Equal(t, "sh -c 'if command -v virt-ssh-helper >/dev/null 2>&1; "+
+				`then exec virt-ssh-helper '\''qemu:///system'\''; `+
+				`else exec nc -U '\''/var/run/libvirt/libvirt-sock'\''; fi'`, args[len(args)-1])
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lifecycle
+
+import (
+	"context"
+
+	"go.temporal.io/sdk/interceptor"
+)
+
+// NewInterceptor returns a worker interceptor that registers activities in
+// the Manager. Activities started while the Agent is draining fail with
+// ErrDraining, which is retryable, so they are executed again once the
+// Agent is restarted.
+func NewInterceptor(m *Manager) interceptor.WorkerInterceptor {
+	return &lifecycleInterceptor{manager: m}
+}
+
+type lifecycleInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	manager *Manager
+}
+
+func (x *lifecycleInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityInterceptor{manager: x.manager}
+	i.Next = next
+
+	return i
+}
+
+type activityInterceptor struct {
+	interceptor.ActivityInboundInterceptorBase
+	manager *Manager
+}
+
+func (a *activityInterceptor) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	if err := a.manager.Begin(); err != nil {
+		return nil, err
+	}
+
+	defer a.manager.End()
+
+	return a.Next.ExecuteActivity(ctx, in)
+}
@@ -0,0 +1,363 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package lifecycle serves hooks, which packaging calls around restarts of
+// the Agent (e.g. snap refreshes or package upgrades). The pre-stop hook
+// drains the Agent, so in-flight activities (e.g. of a deployment) are
+// completed and pending state is flushed before the Agent is stopped.
+// The post-start hook gates the refresh on the health of the new Agent.
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PathPrefix of hooks served by Handler
+const PathPrefix = "/hooks/"
+
+// Hooks served by Handler
+const (
+	HookPreStop   = "pre-stop"
+	HookPostStart = "post-start"
+)
+
+// States of the Agent
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateDraining = "draining"
+	StateDrained  = "drained"
+)
+
+const (
+	defaultDrainTimeout  = 5 * time.Minute
+	defaultHealthTimeout = time.Minute
+	pollInterval         = time.Second
+)
+
+var (
+	// ErrDraining is returned for work started while the Agent is draining
+	ErrDraining = errors.New("agent is draining before a restart")
+	// ErrDrainTimeout is returned if activities haven't completed in time
+	ErrDrainTimeout = errors.New("in-flight activities haven't completed")
+	// ErrUnhealthy is returned if the Agent hasn't become healthy in time
+	ErrUnhealthy = errors.New("agent is not healthy")
+)
+
+// Flusher persists or delivers pending state before the Agent is stopped
+type Flusher func(ctx context.Context) error
+
+// HealthCheck returns an error if a subsystem is not healthy
+type HealthCheck func() error
+
+// Status of the Agent reported by hooks
+type Status struct {
+	State    string `json:"state"`
+	InFlight int    `json:"in_flight"`
+	// Errors of flushers or health checks keyed by their name
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Manager tracks in-flight activities and runs hooks
+type Manager struct {
+	flushers      map[string]Flusher
+	checks        map[string]HealthCheck
+	idle          chan struct{}
+	state         string
+	inFlight      int
+	drainTimeout  time.Duration
+	healthTimeout time.Duration
+	mutex         sync.Mutex
+}
+
+// ManagerOption allows to set additional Manager options
+type ManagerOption func(*Manager)
+
+// NewManager returns Manager of the Agent that is starting
+func NewManager(options ...ManagerOption) *Manager {
+	m := &Manager{
+		flushers:      make(map[string]Flusher),
+		checks:        make(map[string]HealthCheck),
+		state:         StateStarting,
+		drainTimeout:  defaultDrainTimeout,
+		healthTimeout: defaultHealthTimeout,
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	return m
+}
+
+// WithDrainTimeout sets how long pre-stop waits for in-flight activities,
+// unless the request sets a timeout. (default: 5 minutes)
+func WithDrainTimeout(d time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.drainTimeout = d
+	}
+}
+
+// WithHealthTimeout sets how long post-start waits for the Agent to become
+// healthy, unless the request sets a timeout. (default: 1 minute)
+func WithHealthTimeout(d time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.healthTimeout = d
+	}
+}
+
+// WithFlusher registers f called by pre-stop once the Agent is drained
+func WithFlusher(name string, f Flusher) ManagerOption {
+	return func(m *Manager) {
+		m.flushers[name] = f
+	}
+}
+
+// WithHealthCheck registers c consulted by post-start
+func WithHealthCheck(name string, c HealthCheck) ManagerOption {
+	return func(m *Manager) {
+		m.checks[name] = c
+	}
+}
+
+// Started marks the Agent as running, once it is configured by the Region
+func (m *Manager) Started() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.state == StateStarting {
+		m.state = StateRunning
+	}
+}
+
+// Begin registers an activity. ErrDraining is returned if the Agent is
+// draining, in which case the activity must not be executed.
+func (m *Manager) Begin() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.state == StateDraining || m.state == StateDrained {
+		return ErrDraining
+	}
+
+	m.inFlight++
+
+	return nil
+}
+
+// End unregisters an activity registered by Begin
+func (m *Manager) End() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.inFlight--
+
+	if m.inFlight == 0 && m.idle != nil {
+		close(m.idle)
+		m.idle = nil
+	}
+}
+
+// Drain stops accepting new activities and waits until in-flight ones
+// complete, then runs flushers. Activities are accepted again after
+// Resume (e.g. if the refresh is aborted).
+func (m *Manager) Drain(ctx context.Context) (Status, error) {
+	m.mutex.Lock()
+
+	m.state = StateDraining
+
+	var idle chan struct{}
+	if m.inFlight > 0 {
+		if m.idle == nil {
+			m.idle = make(chan struct{})
+		}
+
+		idle = m.idle
+	}
+
+	m.mutex.Unlock()
+
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return m.Status(), ErrDrainTimeout
+		}
+	}
+
+	errs := run(m.flushers, func(f Flusher) error { return f(ctx) })
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.state == StateDraining {
+		m.state = StateDrained
+	}
+
+	status := m.status()
+	status.Errors = errs
+
+	if len(errs) > 0 {
+		return status, fmt.Errorf("failed to flush %s", joinNames(errs))
+	}
+
+	return status, nil
+}
+
+// Resume accepts activities again after Drain
+func (m *Manager) Resume() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.state == StateDraining || m.state == StateDrained {
+		m.state = StateRunning
+	}
+}
+
+// WaitHealthy waits until the Agent is running and all health checks pass
+func (m *Manager) WaitHealthy(ctx context.Context) (Status, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		status := m.Status()
+		status.Errors = run(m.checks, func(c HealthCheck) error { return c() })
+
+		if status.State == StateRunning && len(status.Errors) == 0 {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ErrUnhealthy
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the current status of the Agent
+func (m *Manager) Status() Status {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.status()
+}
+
+func (m *Manager) status() Status {
+	return Status{State: m.state, InFlight: m.inFlight}
+}
+
+// run calls fn for every named item and returns errors keyed by names
+func run[T any](items map[string]T, fn func(T) error) map[string]string {
+	var errs map[string]string
+
+	for name, item := range items {
+		if err := fn(item); err != nil {
+			if errs == nil {
+				errs = make(map[string]string)
+			}
+
+			errs[name] = err.Error()
+		}
+	}
+
+	return errs
+}
+
+func joinNames(errs map[string]string) string {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return fmt.Sprintf("%q", names)
+}
+
+// Handler returns http.Handler serving hooks:
+//
+//	POST /hooks/pre-stop   drains the Agent
+//	DELETE /hooks/pre-stop resumes the Agent (e.g. if the refresh is aborted)
+//	GET /hooks/post-start  waits for the Agent to become healthy
+//
+// Both pre-stop and post-start accept a timeout query parameter
+// (e.g. ?timeout=30s) and respond with the Status of the Agent.
+// 503 is returned if the hook hasn't succeeded in time.
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hook := r.URL.Path[len(PathPrefix):]
+
+		var timeout time.Duration
+
+		switch {
+		case hook == HookPreStop && r.Method == http.MethodPost:
+			timeout = m.drainTimeout
+		case hook == HookPreStop && r.Method == http.MethodDelete:
+			m.Resume()
+		case hook == HookPostStart && r.Method == http.MethodGet:
+			timeout = m.healthTimeout
+		case hook == HookPreStop || hook == HookPostStart:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		if s := r.URL.Query().Get("timeout"); s != "" {
+			var err error
+
+			timeout, err = time.ParseDuration(s)
+			if err != nil || timeout < 0 {
+				http.Error(w, "invalid timeout", http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		var (
+			status Status
+			err    error
+		)
+
+		switch {
+		case hook == HookPreStop && r.Method == http.MethodPost:
+			status, err = m.Drain(ctx)
+		case hook == HookPostStart:
+			status, err = m.WaitHealthy(ctx)
+		default:
+			status = m.Status()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(status)
+	})
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerDrain(t *testing.T) {
+	t.Parallel()
+
+	flushed := make(chan struct{})
+
+	m := NewManager(WithFlusher("events", func(context.Context) error {
+		close(flushed)
+		return nil
+	}))
+	m.Started()
+
+	require.NoError(t, m.Begin())
+
+	done := make(chan Status)
+
+	go func() {
+		status, err := m.Drain(context.Background())
+		assert.NoError(t, err)
+		done <- status
+	}()
+
+	// New activities are rejected once draining starts
+	for m.Status().State != StateDraining {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.ErrorIs(t, m.Begin(), ErrDraining)
+
+	select {
+	case <-flushed:
+		t.Fatal("flushed before in-flight activity completed")
+	default:
+	}
+
+	m.End()
+
+	status := <-done
+	assert.Equal(t, Status{State: StateDrained}, status)
+
+	m.Resume()
+	assert.NoError(t, m.Begin())
+}
+
+func TestManagerDrainTimeout(t *testing.T) {
+	t.Parallel()
+
+	m := NewManager()
+	m.Started()
+
+	require.NoError(t, m.Begin())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	status, err := m.Drain(ctx)
+	assert.ErrorIs(t, err, ErrDrainTimeout)
+	assert.Equal(t, Status{State: StateDraining, InFlight: 1}, status)
+}
+
+func TestManagerDrainFlushError(t *testing.T) {
+	t.Parallel()
+
+	m := NewManager(
+		WithFlusher("events", func(context.Context) error { return errors.New("unreachable") }),
+		WithFlusher("state", func(context.Context) error { return nil }),
+	)
+
+	status, err := m.Drain(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, map[string]string{"events": "unreachable"}, status.Errors)
+}
+
+func TestHandler(t *testing.T) {
+	testcases := map[string]struct {
+		method  string
+		path    string
+		started bool
+		healthy bool
+		state   string
+		code    int
+	}{
+		"pre-stop": {
+			method: http.MethodPost, path: "/hooks/pre-stop",
+			state: StateDrained, code: http.StatusOK,
+		},
+		"pre-stop invalid timeout": {
+			method: http.MethodPost, path: "/hooks/pre-stop?timeout=x",
+			code: http.StatusBadRequest,
+		},
+		"resume": {
+			method: http.MethodDelete, path: "/hooks/pre-stop", started: true,
+			state: StateRunning, code: http.StatusOK,
+		},
+		"post-start healthy": {
+			method: http.MethodGet, path: "/hooks/post-start", started: true, healthy: true,
+			state: StateRunning, code: http.StatusOK,
+		},
+		"post-start unhealthy": {
+			method: http.MethodGet, path: "/hooks/post-start?timeout=10ms", started: true,
+			state: StateRunning, code: http.StatusServiceUnavailable,
+		},
+		"post-start not started": {
+			method: http.MethodGet, path: "/hooks/post-start?timeout=10ms", healthy: true,
+			state: StateStarting, code: http.StatusServiceUnavailable,
+		},
+		"method not allowed": {
+			method: http.MethodGet, path: "/hooks/pre-stop",
+			code: http.StatusMethodNotAllowed,
+		},
+		"unknown hook": {
+			method: http.MethodPost, path: "/hooks/pre-start",
+			code: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m := NewManager(WithHealthCheck("data", func() error {
+				if !tc.healthy {
+					return errors.New("read-only file system")
+				}
+
+				return nil
+			}))
+
+			if tc.started {
+				m.Started()
+			}
+
+			w := httptest.NewRecorder()
+			m.Handler().ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+
+			assert.Equal(t, tc.code, w.Code)
+
+			if tc.state == "" {
+				return
+			}
+
+			var status Status
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+			assert.Equal(t, tc.state, status.State)
+		})
+	}
+}
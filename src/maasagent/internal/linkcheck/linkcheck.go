@@ -0,0 +1,184 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package linkcheck validates network cabling of machines. Negotiated link
+// parameters and LLDP neighbors observed during commissioning are compared
+// against values expected by the Region, so that miscabled machines are
+// caught before they are deployed.
+package linkcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Neighbor is an LLDP neighbor of an interface. When used in ExpectedLink,
+// empty fields are not checked.
+type Neighbor struct {
+	ChassisID  string `json:"chassis_id"`
+	PortID     string `json:"port_id"`
+	SystemName string `json:"system_name"`
+}
+
+// ObservedLink is a link as observed on the machine
+type ObservedLink struct {
+	Neighbor *Neighbor `json:"neighbor,omitempty"`
+	Name     string    `json:"name"`
+	MAC      string    `json:"mac_address"`
+	Duplex   string    `json:"duplex"`
+	// Speed in Mbit/s
+	Speed        int  `json:"speed"`
+	LinkDetected bool `json:"link_detected"`
+}
+
+// ExpectedLink is a link as expected by the Region. Zero Speed and empty
+// Duplex are not checked, neither is Neighbor if it is nil.
+type ExpectedLink struct {
+	Neighbor *Neighbor `json:"neighbor,omitempty"`
+	MAC      string    `json:"mac_address"`
+	Duplex   string    `json:"duplex"`
+	Speed    int       `json:"speed"`
+}
+
+// LinkResult is the result of validation of a single link
+type LinkResult struct {
+	Name     string   `json:"name"`
+	MAC      string   `json:"mac_address"`
+	Failures []string `json:"failures,omitempty"`
+	Passed   bool     `json:"passed"`
+}
+
+// ValidateNetworkLinksParam is the activity parameter for validate-network-links
+type ValidateNetworkLinksParam struct {
+	SystemID string         `json:"system_id"`
+	Observed []ObservedLink `json:"observed"`
+	Expected []ExpectedLink `json:"expected"`
+}
+
+// ValidateNetworkLinksResult is the result of validate-network-links
+type ValidateNetworkLinksResult struct {
+	SystemID string       `json:"system_id"`
+	Links    []LinkResult `json:"links"`
+	Passed   bool         `json:"passed"`
+}
+
+// Validate compares observed links with expected ones. Observed links
+// without expectations are ignored.
+func Validate(observed []ObservedLink, expected []ExpectedLink) []LinkResult {
+	byMAC := make(map[string]ObservedLink, len(observed))
+
+	for _, o := range observed {
+		byMAC[normalizeMAC(o.MAC)] = o
+	}
+
+	results := make([]LinkResult, 0, len(expected))
+
+	for _, e := range expected {
+		o, ok := byMAC[normalizeMAC(e.MAC)]
+
+		r := LinkResult{Name: o.Name, MAC: e.MAC}
+
+		switch {
+		case !ok:
+			r.Failures = []string{"interface not found"}
+		case !o.LinkDetected:
+			r.Failures = []string{"no link detected"}
+		default:
+			r.Failures = compare(o, e)
+		}
+
+		r.Passed = len(r.Failures) == 0
+		results = append(results, r)
+	}
+
+	return results
+}
+
+func compare(o ObservedLink, e ExpectedLink) []string {
+	var failures []string
+
+	if e.Speed != 0 && o.Speed != e.Speed {
+		failures = append(failures, fmt.Sprintf("negotiated speed %d Mbit/s, expected %d Mbit/s", o.Speed, e.Speed))
+	}
+
+	if e.Duplex != "" && !strings.EqualFold(o.Duplex, e.Duplex) {
+		failures = append(failures, fmt.Sprintf("negotiated %q duplex, expected %q", o.Duplex, e.Duplex))
+	}
+
+	if e.Neighbor == nil {
+		return failures
+	}
+
+	if o.Neighbor == nil {
+		return append(failures, "no LLDP neighbor")
+	}
+
+	for _, f := range []struct {
+		name, observed, expected string
+	}{
+		{"chassis", o.Neighbor.ChassisID, e.Neighbor.ChassisID},
+		{"port", o.Neighbor.PortID, e.Neighbor.PortID},
+		{"system name", o.Neighbor.SystemName, e.Neighbor.SystemName},
+	} {
+		if f.expected != "" && !strings.EqualFold(f.observed, f.expected) {
+			failures = append(failures, fmt.Sprintf("LLDP neighbor %s %q, expected %q", f.name, f.observed, f.expected))
+		}
+	}
+
+	return failures
+}
+
+func normalizeMAC(mac string) string {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		return hw.String()
+	}
+
+	return strings.ToLower(mac)
+}
+
+// Service exposes link validation as an activity of commissioning tests.
+type Service struct{}
+
+// NewService returns an instance of Service
+func NewService() *Service {
+	return &Service{}
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{"validate-network-links": s.validateNetworkLinks}
+}
+
+func (s *Service) validateNetworkLinks(_ context.Context,
+	param ValidateNetworkLinksParam) (*ValidateNetworkLinksResult, error) {
+	links := Validate(param.Observed, param.Expected)
+
+	result := &ValidateNetworkLinksResult{
+		SystemID: param.SystemID,
+		Links:    links,
+		Passed:   true,
+	}
+
+	for _, l := range links {
+		result.Passed = result.Passed && l.Passed
+	}
+
+	return result, nil
+}
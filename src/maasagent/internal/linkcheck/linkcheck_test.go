@@ -0,0 +1,114 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package linkcheck
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	neighbor := &Neighbor{ChassisID: "00:1c:73:00:00:01", PortID: "Ethernet1", SystemName: "leaf1"}
+
+	testcases := map[string]struct {
+		observed ObservedLink
+		expected ExpectedLink
+		failures []string
+	}{
+		"matches": {
+			observed: ObservedLink{Name: "eno1", MAC: "52:54:00:AA:BB:CC", Speed: 25000,
+				Duplex: "full", LinkDetected: true, Neighbor: neighbor},
+			expected: ExpectedLink{MAC: "52:54:00:aa:bb:cc", Speed: 25000, Duplex: "Full",
+				Neighbor: &Neighbor{SystemName: "LEAF1", PortID: "ethernet1"}},
+		},
+		"not checked": {
+			observed: ObservedLink{Name: "eno1", MAC: "52:54:00:aa:bb:cc", Speed: 100, LinkDetected: true},
+			expected: ExpectedLink{MAC: "52:54:00:aa:bb:cc"},
+		},
+		"not found": {
+			observed: ObservedLink{Name: "eno1", MAC: "52:54:00:aa:bb:cd", LinkDetected: true},
+			expected: ExpectedLink{MAC: "52:54:00:aa:bb:cc"},
+			failures: []string{"interface not found"},
+		},
+		"no link": {
+			observed: ObservedLink{Name: "eno1", MAC: "52:54:00:aa:bb:cc"},
+			expected: ExpectedLink{MAC: "52:54:00:aa:bb:cc", Speed: 10000},
+			failures: []string{"no link detected"},
+		},
+		"bad cable": {
+			observed: ObservedLink{Name: "eno1", MAC: "52:54:00:aa:bb:cc", Speed: 1000,
+				Duplex: "half", LinkDetected: true, Neighbor: neighbor},
+			expected: ExpectedLink{MAC: "52:54:00:aa:bb:cc", Speed: 10000, Duplex: "full"},
+			failures: []string{
+				"negotiated speed 1000 Mbit/s, expected 10000 Mbit/s",
+				`negotiated "half" duplex, expected "full"`,
+			},
+		},
+		"wrong switch port": {
+			observed: ObservedLink{Name: "eno1", MAC: "52:54:00:aa:bb:cc", LinkDetected: true,
+				Neighbor: neighbor},
+			expected: ExpectedLink{MAC: "52:54:00:aa:bb:cc",
+				Neighbor: &Neighbor{SystemName: "leaf1", PortID: "Ethernet2"}},
+			failures: []string{`LLDP neighbor port "Ethernet1", expected "Ethernet2"`},
+		},
+		"no neighbor": {
+			observed: ObservedLink{Name: "eno1", MAC: "52:54:00:aa:bb:cc", LinkDetected: true},
+			expected: ExpectedLink{MAC: "52:54:00:aa:bb:cc", Neighbor: neighbor},
+			failures: []string{"no LLDP neighbor"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			results := Validate([]ObservedLink{tc.observed}, []ExpectedLink{tc.expected})
+			require.Len(t, results, 1)
+
+			assert.Equal(t, tc.failures, results[0].Failures)
+			assert.Equal(t, len(tc.failures) == 0, results[0].Passed)
+		})
+	}
+}
+
+func TestValidateNetworkLinks(t *testing.T) {
+	s := NewService()
+
+	result, err := s.validateNetworkLinks(context.Background(), ValidateNetworkLinksParam{
+		SystemID: "abc123",
+		Observed: []ObservedLink{
+			{Name: "eno1", MAC: "52:54:00:aa:bb:01", Speed: 10000, LinkDetected: true},
+			{Name: "eno2", MAC: "52:54:00:aa:bb:02", Speed: 1000, LinkDetected: true},
+			{Name: "eno3", MAC: "52:54:00:aa:bb:03"},
+		},
+		Expected: []ExpectedLink{
+			{MAC: "52:54:00:aa:bb:01", Speed: 10000},
+			{MAC: "52:54:00:aa:bb:02", Speed: 10000},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.False(t, result.Passed)
+	require.Len(t, result.Links, 2)
+	assert.True(t, result.Links[0].Passed)
+	assert.Equal(t, "eno2", result.Links[1].Name)
+	assert.False(t, result.Links[1].Passed)
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	// ErrNoFamilyEnabled is returned when both address families are disabled
+	ErrNoFamilyEnabled = errors.New("no address family enabled")
+)
+
+// DualStack enables both IPv4 and IPv6.
+var DualStack = Families{IPv4: true, IPv6: true}
+
+// Families controls which address families a service listens on.
+type Families struct {
+	IPv4 bool `yaml:"ipv4" json:"ipv4"`
+	IPv6 bool `yaml:"ipv6" json:"ipv6"`
+}
+
+// Validate checks that at least one family is enabled.
+func (f Families) Validate() error {
+	if !f.IPv4 && !f.IPv6 {
+		return ErrNoFamilyEnabled
+	}
+
+	return nil
+}
+
+// networks returns family specific networks for proto ("tcp" or "udp")
+// that should be used for the binding. Wildcard bindings get a separate
+// socket per family, so each of them can be enabled independently.
+// IPv6 sockets are IPV6_V6ONLY, so they can coexist with IPv4 ones.
+func (f Families) networks(proto string, b Binding) []string {
+	var res []string
+
+	if b.Address != "" {
+		ip := net.ParseIP(b.Address)
+		if ip == nil {
+			return nil
+		}
+
+		if ip.To4() != nil {
+			if f.IPv4 {
+				res = append(res, proto+"4")
+			}
+		} else if f.IPv6 {
+			res = append(res, proto+"6")
+		}
+
+		return res
+	}
+
+	if f.IPv4 {
+		res = append(res, proto+"4")
+	}
+
+	if f.IPv6 {
+		res = append(res, proto+"6")
+	}
+
+	return res
+}
+
+// ListenDualStack announces on port of every binding using every enabled
+// address family. Bindings with an address of a disabled family are skipped.
+// If no bindings are provided, wildcard address is used.
+// proto must be "tcp".
+func ListenDualStack(ctx context.Context, proto string, port int, bindings []Binding,
+	families Families) ([]net.Listener, error) {
+	return listenEach(families, proto, bindings,
+		func(network string, b Binding) (net.Listener, error) {
+			return Listen(ctx, network, port, b)
+		})
+}
+
+// ListenPacketDualStack is like ListenDualStack, but for packet oriented
+// protocols. proto must be "udp".
+func ListenPacketDualStack(ctx context.Context, proto string, port int, bindings []Binding,
+	families Families) ([]net.PacketConn, error) {
+	return listenEach(families, proto, bindings,
+		func(network string, b Binding) (net.PacketConn, error) {
+			return ListenPacket(ctx, network, port, b)
+		})
+}
+
+func listenEach[T interface{ Close() error }](families Families, proto string,
+	bindings []Binding, listen func(network string, b Binding) (T, error)) ([]T, error) {
+	if err := families.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(bindings) == 0 {
+		bindings = []Binding{{}}
+	}
+
+	var res []T
+
+	for _, b := range bindings {
+		for _, network := range families.networks(proto, b) {
+			l, err := listen(network, b)
+			if err != nil {
+				closeAll(res)
+				return nil, err
+			}
+
+			res = append(res, l)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%w: bindings do not match enabled families", ErrInvalidBinding)
+	}
+
+	return res, nil
+}
+
+func closeAll[T interface{ Close() error }](items []T) {
+	for _, item := range items {
+		//nolint:errcheck // we already return a more important error
+		item.Close()
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Listeners of a Group are kept in the file descriptor store of the service
+// manager (systemd), which passes them back when the Agent is restarted. The
+// sockets are never closed in between, so connections made while the Agent
+// restarts wait in the backlog instead of being refused. This requires
+// FileDescriptorStoreMax= and NotifyAccess= to be set for the unit, without
+// them listeners are created as usual.
+
+// inherited are files passed by the service manager, by name
+var inherited struct {
+	files map[string]*os.File
+	once  sync.Once
+	mutex sync.Mutex
+}
+
+// fdName returns name the listener of key is stored under. Names must not
+// contain ":", so it is replaced in IPv6 addresses.
+func (g *Group) fdName(key groupKey) string {
+	return fmt.Sprintf("%s_%s_%s_%d_%s", g.name, key.network,
+		strings.ReplaceAll(key.binding.Address, ":", "-"), key.port, key.binding.device())
+}
+
+// takeInherited returns the listener passed by the service manager under
+// name, or nil if there is none.
+func takeInherited(name string) (net.Listener, error) {
+	inherited.once.Do(func() { inherited.files = loadInherited() })
+
+	inherited.mutex.Lock()
+	f, ok := inherited.files[name]
+	delete(inherited.files, name)
+	inherited.mutex.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	//nolint:errcheck // the listener has its own copy of the file descriptor
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use inherited listener %s: %w", name, err)
+	}
+
+	return l, nil
+}
+
+// releaseInherited closes listeners passed by the service manager whose
+// names start with prefix and removes them from the store, because they
+// are not used by the current configuration.
+func releaseInherited(prefix string) error {
+	inherited.once.Do(func() { inherited.files = loadInherited() })
+
+	inherited.mutex.Lock()
+	defer inherited.mutex.Unlock()
+
+	var errs []error
+
+	for name, f := range inherited.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		delete(inherited.files, name)
+		errs = append(errs, f.Close(), unstore(name))
+	}
+
+	return errors.Join(errs...)
+}
+
+// store passes the listener to the service manager to be kept under name
+func store(name string, l net.Listener) error {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// The descriptor is sent without duplicating it through File(), which
+	// would make the socket blocking
+	if cerr := rc.Control(func(fd uintptr) {
+		//nolint:gosec // file descriptors always fit into int
+		err = notify("FDSTORE=1\nFDNAME="+name, int(fd))
+	}); cerr != nil {
+		return cerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to store listener %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// unstore removes listeners stored under name
+func unstore(name string) error {
+	if err := notify("FDSTOREREMOVE=1\nFDNAME=" + name); err != nil {
+		return fmt.Errorf("failed to remove stored listener %s: %w", name, err)
+	}
+
+	return nil
+}
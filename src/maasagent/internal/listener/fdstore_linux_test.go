@@ -0,0 +1,182 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build linux
+
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serviceManager receives notifications sent to NOTIFY_SOCKET
+func serviceManager(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return conn
+}
+
+// receive returns the next notification and listeners passed with it
+func receive(t *testing.T, conn *net.UnixConn) (string, []net.Listener) {
+	t.Helper()
+
+	buf := make([]byte, 1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	require.NoError(t, err)
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t, err)
+
+	var res []net.Listener
+
+	for _, msg := range msgs {
+		fds, err := syscall.ParseUnixRights(&msg)
+		require.NoError(t, err)
+
+		for _, fd := range fds {
+			f := os.NewFile(uintptr(fd), "stored")
+
+			l, err := net.FileListener(f)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			t.Cleanup(func() { l.Close() })
+
+			res = append(res, l)
+		}
+	}
+
+	return string(buf[:n]), res
+}
+
+// inherit makes files be passed to the Agent as if by the service manager
+func inherit(t *testing.T, files map[string]*os.File) {
+	t.Helper()
+
+	inherited.once.Do(func() {})
+
+	inherited.mutex.Lock()
+	defer inherited.mutex.Unlock()
+
+	inherited.files = files
+
+	t.Cleanup(func() {
+		inherited.mutex.Lock()
+		defer inherited.mutex.Unlock()
+
+		for _, f := range inherited.files {
+			f.Close()
+		}
+
+		inherited.files = nil
+	})
+}
+
+func TestGroupStore(t *testing.T) {
+	sm := serviceManager(t)
+	port := freePort(t)
+	name := fmt.Sprintf("test_tcp4_127.0.0.1_%d_", port)
+
+	g := NewGroup("test", echoServe)
+
+	require.NoError(t, g.Apply(context.Background(), port, []Binding{{Address: "127.0.0.1"}},
+		Families{IPv4: true}))
+
+	state, stored := receive(t, sm)
+	assert.Equal(t, "FDSTORE=1\nFDNAME="+name, state)
+	require.Len(t, stored, 1)
+	assert.Equal(t, g.Addrs()[0].String(), stored[0].Addr().String())
+
+	require.NoError(t, g.Close())
+
+	state, stored = receive(t, sm)
+	assert.Equal(t, "FDSTOREREMOVE=1\nFDNAME="+name, state)
+	assert.Empty(t, stored)
+}
+
+// listenFile returns file of a new listener, which is closed
+func listenFile(t *testing.T, port int, address string) *os.File {
+	t.Helper()
+
+	l, err := Listen(context.Background(), "tcp4", port, Binding{Address: address})
+	require.NoError(t, err)
+
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	return f
+}
+
+func TestGroupInherited(t *testing.T) {
+	sm := serviceManager(t)
+	port := freePort(t)
+	name := fmt.Sprintf("test_tcp4_127.0.0.1_%d_", port)
+
+	// Listeners kept by the service manager while the Agent was restarted
+	inherit(t, map[string]*os.File{
+		name:                listenFile(t, port, "127.0.0.1"),
+		"test_tcp6_--1_1_":  listenFile(t, 0, "127.0.0.1"),
+		"other_tcp6_--1_1_": listenFile(t, 0, "127.0.0.1"),
+	})
+
+	// Connection made while the Agent was restarted
+	conn, err := net.Dial("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	g := NewGroup("test", echoServe)
+
+	defer g.Close()
+
+	require.NoError(t, g.Apply(context.Background(), port, []Binding{{Address: "127.0.0.1"}},
+		Families{IPv4: true}))
+
+	echo(t, conn, "inherited")
+
+	state, _ := receive(t, sm)
+	assert.Equal(t, "FDSTORE=1\nFDNAME="+name, state)
+
+	// Unused listeners of the group are released, others are kept
+	state, _ = receive(t, sm)
+	assert.Equal(t, "FDSTOREREMOVE=1\nFDNAME=test_tcp6_--1_1_", state)
+
+	inherited.mutex.Lock()
+	defer inherited.mutex.Unlock()
+
+	assert.Len(t, inherited.files, 1)
+	assert.Contains(t, inherited.files, "other_tcp6_--1_1_")
+}
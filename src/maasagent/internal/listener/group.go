@@ -0,0 +1,197 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// groupKey identifies a listener of the Group
+type groupKey struct {
+	network string
+	binding Binding
+	port    int
+}
+
+// Group keeps TCP listeners of a service and switches them over to new
+// bindings without refusing connections in between. Listeners of unchanged
+// bindings are kept, new listeners are created before removed ones are
+// closed. Closing a listener doesn't affect connections it has accepted,
+// so in-flight transfers (e.g. of boot images) are not interrupted.
+// Listeners are also kept by the service manager across restarts of the
+// Agent, see fdstore.go.
+type Group struct {
+	serve     func(net.Listener) error
+	listeners map[groupKey]net.Listener
+	errors    chan error
+	name      string
+	mutex     sync.Mutex
+}
+
+// NewGroup returns an empty Group, which calls serve in a new goroutine
+// for every listener it creates. name identifies listeners of the group
+// kept by the service manager, so it must be unique and not contain "_".
+func NewGroup(name string, serve func(net.Listener) error) *Group {
+	return &Group{
+		name:      name,
+		serve:     serve,
+		listeners: make(map[groupKey]net.Listener),
+		errors:    make(chan error, 1),
+	}
+}
+
+// Apply makes the group listen on port of every binding using every enabled
+// address family, like ListenDualStack does. If any of the new listeners
+// cannot be created, the group is left as it was.
+func (g *Group) Apply(ctx context.Context, port int, bindings []Binding, families Families) error {
+	if err := families.Validate(); err != nil {
+		return err
+	}
+
+	if len(bindings) == 0 {
+		bindings = []Binding{{}}
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	want := make(map[groupKey]struct{})
+	added := make(map[groupKey]net.Listener)
+
+	for _, b := range bindings {
+		for _, network := range families.networks("tcp", b) {
+			key := groupKey{network: network, binding: b, port: port}
+			want[key] = struct{}{}
+
+			if _, ok := g.listeners[key]; ok {
+				continue
+			}
+
+			if _, ok := added[key]; ok {
+				continue
+			}
+
+			l, err := g.listen(ctx, key)
+			if err != nil {
+				closeAll(mapValues(added))
+				return err
+			}
+
+			added[key] = l
+		}
+	}
+
+	if len(want) == 0 {
+		closeAll(mapValues(added))
+		return fmt.Errorf("%w: bindings do not match enabled families", ErrInvalidBinding)
+	}
+
+	var errs []error
+
+	for key, l := range added {
+		g.listeners[key] = l
+		go g.run(l)
+
+		errs = append(errs, store(g.fdName(key), l))
+	}
+
+	for key, l := range g.listeners {
+		if _, ok := want[key]; ok {
+			continue
+		}
+
+		delete(g.listeners, key)
+		errs = append(errs, l.Close(), unstore(g.fdName(key)))
+	}
+
+	errs = append(errs, releaseInherited(g.name+"_"))
+
+	return errors.Join(errs...)
+}
+
+// listen returns the listener of key passed by the service manager, if
+// there is one, or creates a new listener.
+func (g *Group) listen(ctx context.Context, key groupKey) (net.Listener, error) {
+	l, err := takeInherited(g.fdName(key))
+	if l != nil || err != nil {
+		return l, err
+	}
+
+	return Listen(ctx, key.network, key.port, key.binding)
+}
+
+// run serves l, reporting errors other than the one caused by closing it
+func (g *Group) run(l net.Listener) {
+	err := g.serve(l)
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		return
+	}
+
+	select {
+	case g.errors <- fmt.Errorf("failed to serve on %s: %w", l.Addr(), err):
+	default:
+	}
+}
+
+// Addrs returns addresses of the listeners
+func (g *Group) Addrs() []net.Addr {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	res := make([]net.Addr, 0, len(g.listeners))
+	for _, l := range g.listeners {
+		res = append(res, l.Addr())
+	}
+
+	return res
+}
+
+// Errors returns channel receiving errors of serve
+func (g *Group) Errors() <-chan error {
+	return g.errors
+}
+
+// Close closes all listeners and removes them from the service manager.
+// The group can be applied again afterwards. Listeners of a group that is
+// not closed are passed to the Agent when it starts again.
+func (g *Group) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var errs []error
+
+	for key, l := range g.listeners {
+		delete(g.listeners, key)
+		errs = append(errs, l.Close(), unstore(g.fdName(key)))
+	}
+
+	errs = append(errs, releaseInherited(g.name+"_"))
+
+	return errors.Join(errs...)
+}
+
+func mapValues[K comparable, V any](m map[K]V) []V {
+	res := make([]V, 0, len(m))
+	for _, v := range m {
+		res = append(res, v)
+	}
+
+	return res
+}
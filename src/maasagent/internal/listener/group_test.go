@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package listener
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServe echoes lines received on connections accepted by l
+func echoServe(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			r := bufio.NewReader(conn)
+
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if _, err := conn.Write([]byte(line)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func echo(t *testing.T, conn net.Conn, line string) {
+	t.Helper()
+
+	_, err := conn.Write([]byte(line + "\n"))
+	require.NoError(t, err)
+
+	res, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, line+"\n", res)
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestGroupApply(t *testing.T) {
+	ctx := context.Background()
+	ipv4 := Families{IPv4: true}
+	port := freePort(t)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	g := NewGroup("test", echoServe)
+
+	defer g.Close()
+
+	require.NoError(t, g.Apply(ctx, port, []Binding{{Address: "127.0.0.1"}}, ipv4))
+
+	first := g.Addrs()
+	require.Len(t, first, 1)
+
+	// In-flight connection
+	conn, err := net.Dial("tcp4", addr)
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	echo(t, conn, "before")
+
+	// Unchanged binding keeps its listener
+	require.NoError(t, g.Apply(ctx, port, []Binding{{Address: "127.0.0.1"}}, ipv4))
+	assert.Equal(t, first, g.Addrs())
+
+	// The same port is bound by the new listener, before the old one is closed
+	err = g.Apply(ctx, port, []Binding{{Address: "127.0.0.1", Interface: "lo"}}, ipv4)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, ErrBindToDeviceNotSupported) {
+		t.Skip("binding to a device is not permitted")
+	}
+
+	require.NoError(t, err)
+	require.Len(t, g.Addrs(), 1)
+
+	echo(t, conn, "after")
+
+	other, err := net.Dial("tcp4", addr)
+	require.NoError(t, err)
+
+	defer other.Close()
+
+	echo(t, other, "new")
+}
+
+func TestGroupApplyError(t *testing.T) {
+	ctx := context.Background()
+	ipv4 := Families{IPv4: true}
+
+	g := NewGroup("test", echoServe)
+
+	defer g.Close()
+
+	require.NoError(t, g.Apply(ctx, 0, []Binding{{Address: "127.0.0.1"}}, ipv4))
+
+	addrs := g.Addrs()
+
+	err := g.Apply(ctx, 0, []Binding{{Address: "127.0.0.1"}, {Address: "192.0.2.1"}}, ipv4)
+	assert.Error(t, err)
+	assert.Equal(t, addrs, g.Addrs(), "group must be left as it was")
+
+	err = g.Apply(ctx, 0, []Binding{{Address: "::1"}}, ipv4)
+	assert.ErrorIs(t, err, ErrInvalidBinding)
+	assert.Equal(t, addrs, g.Addrs())
+
+	require.NoError(t, g.Close())
+	assert.Empty(t, g.Addrs())
+}
+
+func TestGroupErrors(t *testing.T) {
+	failure := errors.New("failure")
+
+	g := NewGroup("test", func(net.Listener) error { return failure })
+
+	defer g.Close()
+
+	require.NoError(t, g.Apply(context.Background(), 0, []Binding{{Address: "127.0.0.1"}},
+		Families{IPv4: true}))
+
+	assert.ErrorIs(t, <-g.Errors(), failure)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package listener creates network listeners bound to specific interfaces
+// or VRFs, which is required for racks that straddle multiple isolated
+// provisioning networks (possibly with overlapping address space).
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+var (
+	// ErrBindToDeviceNotSupported is returned when binding to an interface
+	// or VRF is requested on a platform that doesn't support it
+	ErrBindToDeviceNotSupported = errors.New("binding to a device is not supported")
+	// ErrInvalidBinding is returned when binding configuration is not valid
+	ErrInvalidBinding = errors.New("invalid binding")
+)
+
+// Binding describes where a service should listen.
+type Binding struct {
+	// Interface restricts the listener to a single network interface.
+	Interface string `yaml:"interface" json:"interface"`
+	// VRF restricts the listener to interfaces enslaved to the VRF device.
+	// It is ignored when Interface is set, because binding to an interface
+	// that is part of a VRF implies the VRF.
+	VRF string `yaml:"vrf" json:"vrf"`
+	// Address to listen on. If empty, all addresses of the interface (or VRF)
+	// are used.
+	Address string `yaml:"address" json:"address"`
+}
+
+// device returns name of the device the socket should be bound to.
+func (b Binding) device() string {
+	if b.Interface != "" {
+		return b.Interface
+	}
+
+	return b.VRF
+}
+
+func (b Binding) String() string {
+	s := b.Address
+	if s == "" {
+		s = "*"
+	}
+
+	if b.Interface != "" {
+		s += "%" + b.Interface
+	}
+
+	if b.VRF != "" {
+		s += " vrf " + b.VRF
+	}
+
+	return s
+}
+
+// Validate checks that address is a valid IP and devices exist.
+func (b Binding) Validate() error {
+	if b.Address != "" && net.ParseIP(b.Address) == nil {
+		return fmt.Errorf("%w: %q is not an IP address", ErrInvalidBinding, b.Address)
+	}
+
+	for _, dev := range []string{b.Interface, b.VRF} {
+		if dev == "" {
+			continue
+		}
+
+		if _, err := net.InterfaceByName(dev); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidBinding, dev, err)
+		}
+	}
+
+	return nil
+}
+
+// listenConfig returns ListenConfig binding sockets to the device, if any.
+// Sockets use SO_REUSEPORT, so listeners can be switched over to another
+// socket (e.g. of a new Agent process) without refusing connections.
+func (b Binding) listenConfig() net.ListenConfig {
+	dev := b.device()
+
+	return net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var err error
+
+			if cerr := c.Control(func(fd uintptr) {
+				err = reusePort(fd)
+				if err == nil && dev != "" {
+					err = bindToDevice(fd, dev)
+				}
+			}); cerr != nil {
+				return cerr
+			}
+
+			return err
+		},
+	}
+}
+
+// Listen announces on port of the binding. network must be "tcp", "tcp4" or "tcp6".
+func Listen(ctx context.Context, network string, port int, b Binding) (net.Listener, error) {
+	lc := b.listenConfig()
+
+	l, err := lc.Listen(ctx, network, net.JoinHostPort(b.Address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", b, err)
+	}
+
+	return l, nil
+}
+
+// ListenPacket announces on port of the binding. network must be "udp",
+// "udp4" or "udp6".
+func ListenPacket(ctx context.Context, network string, port int, b Binding) (net.PacketConn, error) {
+	lc := b.listenConfig()
+
+	c, err := lc.ListenPacket(ctx, network, net.JoinHostPort(b.Address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", b, err)
+	}
+
+	return c, nil
+}
+
+// ListenAll announces on port of every binding. If any of the listeners
+// cannot be created, already created ones are closed.
+func ListenAll(ctx context.Context, network string, port int, bindings []Binding) ([]net.Listener, error) {
+	res := make([]net.Listener, 0, len(bindings))
+
+	for _, b := range bindings {
+		l, err := Listen(ctx, network, port, b)
+		if err != nil {
+			closeAll(res)
+			return nil, err
+		}
+
+		res = append(res, l)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package listener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindingValidate(t *testing.T) {
+	testcases := map[string]struct {
+		in  Binding
+		err error
+	}{
+		"any address": {
+			in: Binding{},
+		},
+		"address": {
+			in: Binding{Address: "::1"},
+		},
+		"interface": {
+			in: Binding{Interface: "lo", Address: "127.0.0.1"},
+		},
+		"invalid address": {
+			in:  Binding{Address: "localhost"},
+			err: ErrInvalidBinding,
+		},
+		"missing interface": {
+			in:  Binding{Interface: "maas-missing0"},
+			err: ErrInvalidBinding,
+		},
+		"missing vrf": {
+			in:  Binding{VRF: "maas-missing0"},
+			err: ErrInvalidBinding,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, tc.in.Validate(), tc.err)
+		})
+	}
+}
+
+func TestListenAll(t *testing.T) {
+	ctx := context.Background()
+
+	listeners, err := ListenAll(ctx, "tcp4", 0, []Binding{
+		{Address: "127.0.0.1"},
+		{Address: "127.0.0.1", Interface: "lo"},
+	})
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, ErrBindToDeviceNotSupported) {
+		t.Skip("binding to a device is not permitted")
+	}
+
+	require.NoError(t, err)
+	require.Len(t, listeners, 2)
+
+	for _, l := range listeners {
+		conn, err := net.Dial("tcp4", l.Addr().String())
+		require.NoError(t, err)
+		assert.NoError(t, conn.Close())
+		assert.NoError(t, l.Close())
+	}
+}
+
+func TestListenAllClosesOnError(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := Listen(ctx, "tcp4", 0, Binding{Address: "127.0.0.1"})
+	require.NoError(t, err)
+
+	defer l.Close()
+
+	port := l.Addr().(*net.TCPAddr).Port
+
+	_, err = ListenAll(ctx, "tcp4", port+1, []Binding{
+		{Address: "127.0.0.1"},
+		{Address: "192.0.2.1"},
+	})
+	assert.Error(t, err)
+
+	// The first listener must have been closed
+	l2, err := Listen(ctx, "tcp4", port+1, Binding{Address: "127.0.0.1"})
+	require.NoError(t, err)
+	assert.NoError(t, l2.Close())
+}
+
+func TestFamiliesNetworks(t *testing.T) {
+	testcases := map[string]struct {
+		families Families
+		binding  Binding
+		out      []string
+	}{
+		"dual stack wildcard": {
+			families: DualStack,
+			out:      []string{"tcp4", "tcp6"},
+		},
+		"ipv4 only wildcard": {
+			families: Families{IPv4: true},
+			out:      []string{"tcp4"},
+		},
+		"ipv6 only wildcard": {
+			families: Families{IPv6: true},
+			out:      []string{"tcp6"},
+		},
+		"ipv4 address": {
+			families: DualStack,
+			binding:  Binding{Address: "10.0.0.1"},
+			out:      []string{"tcp4"},
+		},
+		"ipv6 address": {
+			families: DualStack,
+			binding:  Binding{Address: "fd00::1"},
+			out:      []string{"tcp6"},
+		},
+		"ipv6 address with ipv6 disabled": {
+			families: Families{IPv4: true},
+			binding:  Binding{Address: "fd00::1"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.families.networks("tcp", tc.binding))
+		})
+	}
+}
+
+func TestListenDualStack(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := ListenDualStack(ctx, "tcp", 0, nil, Families{})
+	assert.ErrorIs(t, err, ErrNoFamilyEnabled)
+
+	_, err = ListenDualStack(ctx, "tcp", 0, []Binding{{Address: "::1"}}, Families{IPv4: true})
+	assert.ErrorIs(t, err, ErrInvalidBinding)
+
+	listeners, err := ListenDualStack(ctx, "tcp", 0, []Binding{
+		{Address: "127.0.0.1"},
+		{Address: "::1"},
+	}, Families{IPv4: true})
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+
+	conn, err := net.Dial("tcp4", listeners[0].Addr().String())
+	require.NoError(t, err)
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, listeners[0].Close())
+}
+
+func TestPacketConnRepliesFromLocalAddress(t *testing.T) {
+	ctx := context.Background()
+
+	conns, err := ListenPacketDualStack(ctx, "udp", 0, nil, Families{IPv4: true})
+	require.NoError(t, err)
+	require.Len(t, conns, 1)
+
+	pc, err := NewPacketConn(conns[0])
+	require.NoError(t, err)
+
+	defer pc.Close()
+
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+
+	// 127.0.0.2 is a local address too, so without packet info the reply
+	// would be sent from 127.0.0.1
+	client, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: port})
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	_, err = client.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+
+	n, addr, info, err := pc.ReadFromUDPWithInfo(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf[:n]))
+
+	if info.Local == nil {
+		t.Skip("packet info is not supported")
+	}
+
+	assert.True(t, info.Local.Equal(net.IPv4(127, 0, 0, 2)))
+
+	_, err = pc.WriteToUDPWithInfo([]byte("pong"), addr, info)
+	require.NoError(t, err)
+
+	// Connected UDP socket only accepts datagrams from the peer it is
+	// connected to, so receiving the reply proves the source address.
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+
+	n, err = client.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf[:n]))
+}
+
+func TestNewPacketConnNotUDP(t *testing.T) {
+	l, err := net.ListenPacket("unixgram", filepath.Join(t.TempDir(), "sock"))
+	require.NoError(t, err)
+
+	defer l.Close()
+
+	_, err = NewPacketConn(l)
+	assert.ErrorIs(t, err, ErrNotUDP)
+}
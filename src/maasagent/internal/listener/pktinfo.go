@@ -0,0 +1,108 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package listener
+
+import (
+	"errors"
+	"net"
+)
+
+// oobSize is large enough to hold a single in_pktinfo or in6_pktinfo
+// control message.
+const oobSize = 64
+
+var (
+	// ErrNotUDP is returned when PacketConn is created from a non UDP socket
+	ErrNotUDP = errors.New("not a UDP connection")
+)
+
+// PacketInfo describes where a packet was received.
+type PacketInfo struct {
+	// Local is the local address the packet was received on. It should be
+	// used as a source address for replies.
+	Local net.IP
+	// IfIndex is the index of the interface the packet was received on.
+	IfIndex int
+}
+
+// PacketConn is a UDP connection that reports on which local address
+// every packet was received and can reply from that address.
+// On multi-homed hosts the kernel picks the source address of a reply sent
+// from a wildcard socket by looking up a route to the peer, which might
+// differ from the address the peer has sent the request to. Such replies
+// are dropped by clients (e.g. TFTP or DHCP), so replies must be sent
+// from the address the request arrived on.
+type PacketConn struct {
+	*net.UDPConn
+	ipv6 bool
+}
+
+// NewPacketConn enables packet info on the UDP socket of c.
+// c is owned by the returned PacketConn.
+func NewPacketConn(c net.PacketConn) (*PacketConn, error) {
+	uc, ok := c.(*net.UDPConn)
+	if !ok {
+		return nil, ErrNotUDP
+	}
+
+	addr, ok := uc.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, ErrNotUDP
+	}
+
+	pc := &PacketConn{UDPConn: uc, ipv6: addr.IP.To4() == nil}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var serr error
+
+	if err := raw.Control(func(fd uintptr) {
+		serr = enablePacketInfo(fd, pc.ipv6)
+	}); err != nil {
+		return nil, err
+	}
+
+	if serr != nil {
+		return nil, serr
+	}
+
+	return pc, nil
+}
+
+// ReadFromUDPWithInfo acts like ReadFromUDP, but also returns where the
+// packet was received. PacketInfo is empty if the platform doesn't support it.
+func (c *PacketConn) ReadFromUDPWithInfo(b []byte) (int, *net.UDPAddr, PacketInfo, error) {
+	oob := make([]byte, oobSize)
+
+	n, oobn, _, addr, err := c.ReadMsgUDP(b, oob)
+	if err != nil {
+		return n, addr, PacketInfo{}, err
+	}
+
+	return n, addr, parsePacketInfo(oob[:oobn]), nil
+}
+
+// WriteToUDPWithInfo acts like WriteToUDP, but sends the packet from the
+// address and interface provided in info (normally, the one received
+// alongside the request).
+func (c *PacketConn) WriteToUDPWithInfo(b []byte, addr *net.UDPAddr, info PacketInfo) (int, error) {
+	n, _, err := c.WriteMsgUDP(b, marshalPacketInfo(info, c.ipv6), addr)
+
+	return n, err
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build linux
+
+package listener
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+func enablePacketInfo(fd uintptr, ipv6 bool) error {
+	if ipv6 {
+		//nolint:gosec // file descriptors always fit into int
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1)
+	}
+
+	//nolint:gosec // file descriptors always fit into int
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+}
+
+func parsePacketInfo(oob []byte) PacketInfo {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return PacketInfo{}
+	}
+
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_PKTINFO &&
+			len(m.Data) >= syscall.SizeofInet4Pktinfo:
+			// struct in_pktinfo { int ifindex; in_addr spec_dst; in_addr addr; }
+			// spec_dst is the local address that should be used for replies.
+			return PacketInfo{
+				IfIndex: int(int32(binary.NativeEndian.Uint32(m.Data[0:4]))),
+				Local:   net.IP(append([]byte(nil), m.Data[4:8]...)),
+			}
+		case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_PKTINFO &&
+			len(m.Data) >= syscall.SizeofInet6Pktinfo:
+			// struct in6_pktinfo { in6_addr addr; unsigned int ifindex; }
+			return PacketInfo{
+				Local:   net.IP(append([]byte(nil), m.Data[0:16]...)),
+				IfIndex: int(binary.NativeEndian.Uint32(m.Data[16:20])),
+			}
+		}
+	}
+
+	return PacketInfo{}
+}
+
+func marshalPacketInfo(info PacketInfo, ipv6 bool) []byte {
+	if info.Local == nil {
+		return nil
+	}
+
+	if !ipv6 {
+		local := info.Local.To4()
+		if local == nil {
+			return nil
+		}
+
+		data := make([]byte, syscall.SizeofInet4Pktinfo)
+		//nolint:gosec // interface index always fits into uint32
+		binary.NativeEndian.PutUint32(data[0:4], uint32(info.IfIndex))
+		copy(data[4:8], local)
+
+		return controlMessage(syscall.IPPROTO_IP, syscall.IP_PKTINFO, data)
+	}
+
+	data := make([]byte, syscall.SizeofInet6Pktinfo)
+	copy(data[0:16], info.Local.To16())
+	//nolint:gosec // interface index always fits into uint32
+	binary.NativeEndian.PutUint32(data[16:20], uint32(info.IfIndex))
+
+	return controlMessage(syscall.IPPROTO_IPV6, syscall.IPV6_PKTINFO, data)
+}
+
+func controlMessage(level, typ int, data []byte) []byte {
+	b := make([]byte, syscall.CmsgSpace(len(data)))
+
+	//nolint:gosec // b is large enough to hold the header
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(syscall.CmsgLen(len(data)))
+
+	copy(b[syscall.CmsgLen(0):], data)
+
+	return b
+}
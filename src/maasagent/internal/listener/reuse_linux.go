@@ -0,0 +1,33 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build linux
+
+package listener
+
+import (
+	"syscall"
+)
+
+// soReusePort is SO_REUSEPORT, which is missing in syscall of some
+// architectures. It has the same value on all architectures MAAS supports.
+const soReusePort = 0xf
+
+// reusePort allows the address to be bound by other sockets too, so a new
+// listener can be created before the one it replaces is closed.
+func reusePort(fd uintptr) error {
+	//nolint:gosec // file descriptors always fit into int
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
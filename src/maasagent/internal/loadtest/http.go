@@ -0,0 +1,63 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package loadtest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Path is where Handler is expected to be served
+const Path = "/load-test"
+
+// maxRequestSize limits size of the load test configuration
+const maxRequestSize = 1 << 16
+
+// Handler runs a load test configured with Config posted to Path and
+// responds with its Report. The load test is stopped when the client
+// disconnects.
+func (r *Runner) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		var cfg Config
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestSize)).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		report, err := r.Run(req.Context(), cfg)
+
+		switch {
+		case errors.Is(err, ErrInvalidConfig):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			// Client went away
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package loadtest generates synthetic power workload, executing power
+// workflows with the simulator driver at a configured rate, and reports
+// throughput and latency, so capacity of a rack can be measured.
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/power"
+)
+
+const (
+	defaultAction = "power-query"
+	// maxCount limits the number of workflows of a single load test
+	maxCount = 100000
+	// maxErrors is the number of distinct errors included in Report
+	maxErrors = 10
+)
+
+var (
+	// ErrInvalidConfig is returned when load test configuration is invalid
+	ErrInvalidConfig = errors.New("invalid load test configuration")
+)
+
+// Config of a load test
+type Config struct {
+	// Action is the power activity, e.g. "power-on" (default: power-query)
+	Action string `json:"action"`
+	// Latency of the simulator driver, e.g. "500ms" (default: 100ms)
+	Latency string `json:"latency,omitempty"`
+	// Count is the number of workflows
+	Count int `json:"count"`
+	// Rate is the number of workflows started per second
+	Rate float64 `json:"rate"`
+	// FailureRate is the probability of a simulated BMC failure
+	FailureRate float64 `json:"failure_rate,omitempty"`
+	// Machines is the number of simulated machines, workflows are spread
+	// among them evenly (default: Count)
+	Machines int `json:"machines,omitempty"`
+}
+
+func (c *Config) validate() error {
+	if c.Action == "" {
+		c.Action = defaultAction
+	}
+
+	if c.Machines == 0 {
+		c.Machines = c.Count
+	}
+
+	switch {
+	case c.Count <= 0 || c.Count > maxCount:
+		return fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidConfig, maxCount)
+	case c.Rate <= 0:
+		return fmt.Errorf("%w: rate must be positive", ErrInvalidConfig)
+	case c.FailureRate < 0 || c.FailureRate > 1:
+		return fmt.Errorf("%w: failure rate must be between 0 and 1", ErrInvalidConfig)
+	case c.Machines < 0:
+		return fmt.Errorf("%w: machines must not be negative", ErrInvalidConfig)
+	}
+
+	if c.Latency != "" {
+		if _, err := time.ParseDuration(c.Latency); err != nil {
+			return fmt.Errorf("%w: latency: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	return nil
+}
+
+// Latencies are percentiles of workflow latencies, from the request to
+// start a workflow to its completion
+type Latencies struct {
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// Report is the outcome of a load test
+type Report struct {
+	// Errors counts the most common errors by message
+	Errors    map[string]int `json:"errors,omitempty"`
+	Action    string         `json:"action"`
+	Latency   Latencies      `json:"latency"`
+	Duration  time.Duration  `json:"duration"`
+	Started   int            `json:"started"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	// Throughput is the number of workflows completed per second
+	Throughput float64 `json:"throughput"`
+}
+
+// Executor executes a synthetic-power workflow and waits for its completion
+type Executor interface {
+	Execute(ctx context.Context, id string, param power.SyntheticPowerParam) error
+}
+
+// Runner runs load tests
+type Runner struct {
+	executor Executor
+	now      func() time.Time
+	systemID string
+}
+
+// NewRunner returns Runner executing workflows with executor, which
+// execute power activities on the Agent identified by systemID.
+func NewRunner(systemID string, executor Executor) *Runner {
+	return &Runner{executor: executor, systemID: systemID, now: time.Now}
+}
+
+type result struct {
+	err     error
+	latency time.Duration
+}
+
+// Run starts cfg.Count workflows at cfg.Rate per second and waits for all
+// of them to complete. When ctx is cancelled no more workflows are started
+// and the report covers the started ones.
+func (r *Runner) Run(ctx context.Context, cfg Config) (Report, error) {
+	if err := cfg.validate(); err != nil {
+		return Report{}, err
+	}
+
+	opts := map[string]interface{}{
+		"failure_rate": strconv.FormatFloat(cfg.FailureRate, 'f', -1, 64),
+	}
+
+	if cfg.Latency != "" {
+		opts["latency"] = cfg.Latency
+	}
+
+	runID := strconv.FormatInt(r.now().UnixNano(), 36)
+	results := make(chan result, cfg.Count)
+	interval := time.Duration(float64(time.Second) / cfg.Rate)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+
+	start := r.now()
+	started := 0
+
+loop:
+	for ; started < cfg.Count; started++ {
+		if started > 0 {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+		}
+
+		driverOpts := make(map[string]interface{}, len(opts)+1)
+		for k, v := range opts {
+			driverOpts[k] = v
+		}
+
+		driverOpts["power_address"] = fmt.Sprintf("%s-%d", runID, started%cfg.Machines)
+
+		param := power.SyntheticPowerParam{
+			AgentSystemID: r.systemID,
+			Action:        cfg.Action,
+			DriverOpts:    driverOpts,
+		}
+		id := fmt.Sprintf("load-test:%s:%d", runID, started)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			t := r.now()
+			err := r.executor.Execute(ctx, id, param)
+			results <- result{latency: r.now().Sub(t), err: err}
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	report := Report{Action: cfg.Action, Started: started, Duration: r.now().Sub(start)}
+	report.add(results)
+
+	return report, ctx.Err()
+}
+
+func (r *Report) add(results <-chan result) {
+	latencies := make([]time.Duration, 0, r.Started)
+	errs := make(map[string]int)
+
+	for res := range results {
+		latencies = append(latencies, res.latency)
+
+		if res.err == nil {
+			r.Succeeded++
+			continue
+		}
+
+		r.Failed++
+
+		if _, ok := errs[res.err.Error()]; ok || len(errs) < maxErrors {
+			errs[res.err.Error()]++
+		}
+	}
+
+	if len(errs) > 0 {
+		r.Errors = errs
+	}
+
+	if r.Duration > 0 {
+		r.Throughput = float64(r.Succeeded+r.Failed) / r.Duration.Seconds()
+	}
+
+	slices.Sort(latencies)
+
+	r.Latency = Latencies{
+		P50: percentile(latencies, 50),
+		P95: percentile(latencies, 95),
+		P99: percentile(latencies, 99),
+	}
+
+	if len(latencies) > 0 {
+		r.Latency.Max = latencies[len(latencies)-1]
+	}
+}
+
+// percentile uses nearest-rank method on sorted samples
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := (p*len(sorted) + 99) / 100
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/power"
+)
+
+// fakeExecutor completes workflows after latency, failing every failEvery-th
+type fakeExecutor struct {
+	params    map[string]power.SyntheticPowerParam
+	latency   time.Duration
+	failEvery int
+	mutex     sync.Mutex
+}
+
+func (e *fakeExecutor) Execute(ctx context.Context, id string, param power.SyntheticPowerParam) error {
+	e.mutex.Lock()
+	if e.params == nil {
+		e.params = make(map[string]power.SyntheticPowerParam)
+	}
+
+	e.params[id] = param
+	n := len(e.params)
+	e.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(e.latency):
+	}
+
+	if e.failEvery > 0 && n%e.failEvery == 0 {
+		return power.ErrSimulatedFailure
+	}
+
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	executor := &fakeExecutor{latency: 10 * time.Millisecond, failEvery: 4}
+	runner := NewRunner("agent", executor)
+
+	report, err := runner.Run(context.Background(), Config{
+		Count:       8,
+		Rate:        1000,
+		Latency:     "5ms",
+		FailureRate: 0.1,
+		Machines:    2,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "power-query", report.Action)
+	assert.Equal(t, 8, report.Started)
+	assert.Equal(t, 6, report.Succeeded)
+	assert.Equal(t, 2, report.Failed)
+	assert.Equal(t, map[string]int{power.ErrSimulatedFailure.Error(): 2}, report.Errors)
+	assert.Greater(t, report.Throughput, 0.0)
+	assert.GreaterOrEqual(t, report.Latency.P50, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, report.Latency.Max, report.Latency.P99)
+	assert.GreaterOrEqual(t, report.Latency.P99, report.Latency.P95)
+	assert.GreaterOrEqual(t, report.Latency.P95, report.Latency.P50)
+
+	machines := make(map[interface{}]struct{})
+
+	for _, param := range executor.params {
+		assert.Equal(t, "agent", param.AgentSystemID)
+		assert.Equal(t, "power-query", param.Action)
+		assert.Equal(t, "5ms", param.DriverOpts["latency"])
+		assert.Equal(t, "0.1", param.DriverOpts["failure_rate"])
+
+		machines[param.DriverOpts["power_address"]] = struct{}{}
+	}
+
+	assert.Len(t, executor.params, 8)
+	assert.Len(t, machines, 2)
+}
+
+func TestRunCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	executor := &fakeExecutor{latency: time.Hour}
+	runner := NewRunner("agent", executor)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	// Workflows are started every 20ms, so the test is cancelled
+	// before all of them are started
+	report, err := runner.Run(ctx, Config{Count: 100, Rate: 50})
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.Greater(t, report.Started, 0)
+	assert.Less(t, report.Started, 100)
+	assert.Equal(t, report.Started, report.Failed)
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]Config{
+		"no workflows":         {Count: 0, Rate: 1},
+		"too many workflows":   {Count: maxCount + 1, Rate: 1},
+		"no rate":              {Count: 1},
+		"invalid failure rate": {Count: 1, Rate: 1, FailureRate: 2},
+		"invalid latency":      {Count: 1, Rate: 1, Latency: "fast"},
+		"negative machines":    {Count: 1, Rate: 1, Machines: -1},
+	}
+
+	for name, cfg := range testcases {
+		cfg := cfg
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewRunner("agent", &fakeExecutor{}).Run(context.Background(), cfg)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		method string
+		body   string
+		status int
+	}{
+		"load test": {
+			method: http.MethodPost,
+			body:   `{"count":2,"rate":100,"action":"power-on"}`,
+			status: http.StatusOK,
+		},
+		"invalid config": {
+			method: http.MethodPost,
+			body:   `{"count":2}`,
+			status: http.StatusBadRequest,
+		},
+		"invalid body": {
+			method: http.MethodPost,
+			body:   `{"count":`,
+			status: http.StatusBadRequest,
+		},
+		"method not allowed": {
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			runner := NewRunner("agent", &fakeExecutor{})
+
+			rec := httptest.NewRecorder()
+			runner.Handler().ServeHTTP(rec,
+				httptest.NewRequest(tc.method, Path, bytes.NewBufferString(tc.body)))
+
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var report Report
+
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+			assert.Equal(t, "power-on", report.Action)
+			assert.Equal(t, 2, report.Succeeded)
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	assert.Equal(t, 50*time.Millisecond, percentile(samples, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(samples, 99))
+	assert.Equal(t, time.Duration(0), percentile(nil, 99))
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package loadtest
+
+import (
+	"context"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"maas.io/core/src/maasagent/internal/power"
+)
+
+// workflowTimeout limits execution of a single synthetic-power workflow
+const workflowTimeout = 5 * time.Minute
+
+// NewTemporalExecutor returns Executor starting synthetic-power workflows
+// on the task queue (e.g. of the Agent main worker).
+func NewTemporalExecutor(c client.Client, taskQueue string) Executor {
+	return &temporalExecutor{client: c, taskQueue: taskQueue}
+}
+
+type temporalExecutor struct {
+	client    client.Client
+	taskQueue string
+}
+
+func (e *temporalExecutor) Execute(ctx context.Context, id string, param power.SyntheticPowerParam) error {
+	run, err := e.client.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:                       id,
+		TaskQueue:                e.taskQueue,
+		WorkflowExecutionTimeout: workflowTimeout,
+	}, "synthetic-power", param)
+	if err != nil {
+		return err
+	}
+
+	return run.Get(ctx, nil)
+}
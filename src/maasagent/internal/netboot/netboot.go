@@ -0,0 +1,163 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package netboot watches network boot activity of machines (DHCP leases
+// and requests of boot files through the HTTP proxy), so a machine which
+// was powered on but never got to network boot (e.g. it failed POST) is
+// told apart from one which failed later on.
+package netboot
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/dhcpd"
+	"maas.io/core/src/maasagent/internal/subnetmap"
+)
+
+// Sources of network boot activity
+const (
+	SourceDHCP = "dhcp"
+	SourceHTTP = "http"
+)
+
+// Activity is the latest network boot activity of a machine
+type Activity struct {
+	MAC    string    `json:"mac"`
+	Source string    `json:"source"`
+	At     time.Time `json:"at"`
+}
+
+// Watcher keeps the latest network boot activity of machines by MAC
+// address. Requests of the HTTP proxy are attributed to machines by the
+// address they were leased.
+type Watcher struct {
+	seen map[string]Activity
+	macs map[netip.Addr]string
+	// changed is closed and replaced on every activity
+	changed chan struct{}
+	now     func() time.Time
+	mutex   sync.Mutex
+}
+
+// NewWatcher returns Watcher without any activity
+func NewWatcher() *Watcher {
+	return &Watcher{
+		seen:    make(map[string]Activity),
+		macs:    make(map[netip.Addr]string),
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.ReplaceAll(mac, "-", ":"))
+}
+
+// observe records activity, w.mutex must be held
+func (w *Watcher) observe(mac, source string, at time.Time) {
+	if prev, ok := w.seen[mac]; ok && prev.At.After(at) {
+		return
+	}
+
+	w.seen[mac] = Activity{MAC: mac, Source: source, At: at}
+
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// ObserveDHCP records leases committed by dhcpd as activity of machines,
+// and remembers addresses leased to them
+func (w *Watcher) ObserveDHCP(notifications []*dhcpd.Notification) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, n := range notifications {
+		if n.Action != "commit" || len(n.MAC) == 0 {
+			continue
+		}
+
+		mac := normalizeMAC(n.MAC.String())
+
+		if addr, ok := netip.AddrFromSlice(n.IP); ok {
+			w.macs[addr.Unmap()] = mac
+		}
+
+		at := w.now()
+		if n.Timestamp > 0 {
+			at = time.Unix(n.Timestamp, 0)
+		}
+
+		w.observe(mac, SourceDHCP, at)
+	}
+}
+
+// ObserveHTTP records the request as activity of the machine it was sent
+// by, if the address of the machine was leased
+func (w *Watcher) ObserveHTTP(r *http.Request) {
+	addr, ok := subnetmap.ClientAddr(r)
+	if !ok {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if mac, ok := w.macs[addr.Unmap()]; ok {
+		w.observe(mac, SourceHTTP, w.now())
+	}
+}
+
+// Wait returns the first activity of any of macs since the given time,
+// waiting for it until ctx is done
+func (w *Watcher) Wait(ctx context.Context, macs []string, since time.Time) (Activity, error) {
+	normalized := make([]string, len(macs))
+	for i, mac := range macs {
+		normalized[i] = normalizeMAC(mac)
+	}
+
+	for {
+		w.mutex.Lock()
+
+		var (
+			found Activity
+			ok    bool
+		)
+
+		for _, mac := range normalized {
+			a, seen := w.seen[mac]
+			if seen && !a.At.Before(since) && (!ok || a.At.Before(found.At)) {
+				found, ok = a, true
+			}
+		}
+
+		changed := w.changed
+		w.mutex.Unlock()
+
+		if ok {
+			return found, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return Activity{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
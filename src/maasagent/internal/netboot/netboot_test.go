@@ -0,0 +1,88 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netboot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"maas.io/core/src/maasagent/internal/dhcpd"
+)
+
+func commit(mac, ip string, at time.Time) *dhcpd.Notification {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		panic(err)
+	}
+
+	return &dhcpd.Notification{
+		Action:    "commit",
+		MAC:       hw,
+		IP:        net.ParseIP(ip),
+		Timestamp: at.Unix(),
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	w := NewWatcher()
+	start := time.Now().Truncate(time.Second)
+
+	w.ObserveDHCP([]*dhcpd.Notification{
+		commit("00:11:22:33:44:55", "10.0.0.5", start.Add(-time.Hour)),
+		{Action: "expiry", MAC: net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x66}, IP: net.ParseIP("10.0.0.6")},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Activity before power on is ignored
+	_, err := w.Wait(ctx, []string{"00-11-22-33-44-55"}, start)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	done := make(chan Activity)
+
+	go func() {
+		a, err := w.Wait(context.Background(), []string{"00:11:22:33:44:66", "00:11:22:33:44:55"}, start)
+		assert.NoError(t, err)
+		done <- a
+	}()
+
+	// Requests are attributed by leased address, NGINX passes it in header
+	r := httptest.NewRequest(http.MethodGet, "/images/ubuntu/squashfs", nil)
+	r.RemoteAddr = "@"
+	r.Header.Set("X-Real-IP", "10.0.0.5")
+	w.ObserveHTTP(r)
+
+	select {
+	case a := <-done:
+		assert.Equal(t, "00:11:22:33:44:55", a.MAC)
+		assert.Equal(t, SourceHTTP, a.Source)
+		assert.False(t, a.At.Before(start))
+	case <-time.After(5 * time.Second):
+		t.Fatal("activity not observed")
+	}
+
+	// Requests of unknown addresses are not activity of any machine
+	r.Header.Set("X-Real-IP", "10.0.0.6")
+	w.ObserveHTTP(r)
+
+	assert.Len(t, w.seen, 1)
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netboot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	defaultDeadline = 5 * time.Minute
+	// heartbeatInterval is how often await-netboot heartbeats while waiting
+	heartbeatInterval = 10 * time.Second
+	// powerActionTimeout includes retries of the power service
+	powerActionTimeout = 15 * time.Minute
+)
+
+// ErrNetbootNotObserved is returned when the machine was powered on, but
+// didn't start network boot in time
+var ErrNetbootNotObserved = errcode.New(errcode.NetbootNotObserved, "no POST/netboot observed")
+
+// AwaitNetbootParam is the activity parameter for await-netboot
+type AwaitNetbootParam struct {
+	MACs []string `json:"macs"`
+	// Since is when the machine was powered on, earlier activity is ignored
+	Since time.Time `json:"since"`
+	// Timeout in seconds
+	Timeout int `json:"timeout"`
+}
+
+// PowerOnWatchedParam is the parameter of power-on-watched workflow
+type PowerOnWatchedParam struct {
+	// AgentSystemID is the system_id of the Agent performing power actions
+	AgentSystemID string `json:"agent_system_id"`
+	SystemID      string `json:"system_id"`
+	power.PowerParam
+	// MACs are addresses of interfaces the machine boots from network with
+	MACs []string `json:"macs"`
+	// Deadline in seconds for network boot to start after power on,
+	// 5 minutes by default
+	Deadline int `json:"deadline,omitempty"`
+	// Cycles is how many times the machine is power cycled when network
+	// boot is not observed, 1 by default, negative never cycles it
+	Cycles int `json:"cycles,omitempty"`
+}
+
+// PowerOnWatchedResult is the result of power-on-watched workflow
+type PowerOnWatchedResult struct {
+	Activity
+	// Attempts is how many times the machine was powered on or cycled
+	Attempts int `json:"attempts"`
+}
+
+func (w *Watcher) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{"power-on-watched": w.powerOnWatched}
+}
+
+func (w *Watcher) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{"await-netboot": w.awaitNetboot}
+}
+
+// awaitNetboot returns the first network boot activity of the machine since
+// the given time, or fails with ErrNetbootNotObserved after Timeout
+func (w *Watcher) awaitNetboot(ctx context.Context, param AwaitNetbootParam) (*Activity, error) {
+	timeout := time.Duration(param.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultDeadline
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		until := time.Now().Add(heartbeatInterval)
+		if deadline.Before(until) {
+			until = deadline
+		}
+
+		waitCtx, cancel := context.WithDeadline(ctx, until)
+		a, err := w.Wait(waitCtx, param.MACs, param.Since)
+
+		cancel()
+
+		if err == nil {
+			return &a, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("%s within %s", ErrNetbootNotObserved, timeout),
+				errcode.NetbootNotObserved, nil)
+		}
+
+		activity.RecordHeartbeat(ctx)
+	}
+}
+
+func localContext(ctx tworkflow.Context, timeout time.Duration) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		StartToCloseTimeout: timeout + time.Minute,
+		HeartbeatTimeout:    3 * heartbeatInterval,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+}
+
+// powerContext runs power actions on the Agent, without retrying them, as
+// the power service retries them itself
+func powerContext(ctx tworkflow.Context, systemID string) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:           fmt.Sprintf("%s@agent:power", systemID),
+		StartToCloseTimeout: powerActionTimeout,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 1,
+		},
+	})
+}
+
+// powerOnWatched powers the machine on and waits for it to start network
+// boot. Machines which don't are power cycled (stuck in POST, or hung by
+// firmware) and fail with ErrNetbootNotObserved if they still don't, rather
+// than hitting a generic deployment timeout much later. It is meant to be
+// run as a child of the deployment workflow on the Agent serving DHCP to
+// the machine.
+func (w *Watcher) powerOnWatched(ctx tworkflow.Context, param PowerOnWatchedParam) (*PowerOnWatchedResult, error) {
+	log := tworkflow.GetLogger(ctx)
+
+	deadline := time.Duration(param.Deadline) * time.Second
+	if deadline <= 0 {
+		deadline = defaultDeadline
+	}
+
+	cycles := param.Cycles
+	if cycles == 0 {
+		cycles = 1
+	}
+
+	for attempt := 1; attempt <= max(cycles, 0)+1; attempt++ {
+		since := tworkflow.Now(ctx)
+
+		action, actionParam := "power-on", interface{}(power.PowerOnParam{PowerParam: param.PowerParam})
+		if attempt > 1 {
+			action, actionParam = "power-cycle", power.PowerCycleParam{PowerParam: param.PowerParam}
+		}
+
+		if err := tworkflow.ExecuteActivity(powerContext(ctx, param.AgentSystemID), action,
+			actionParam).Get(ctx, nil); err != nil {
+			return nil, err
+		}
+
+		var a Activity
+
+		err := tworkflow.ExecuteActivity(localContext(ctx, deadline), "await-netboot", AwaitNetbootParam{
+			MACs:    param.MACs,
+			Since:   since,
+			Timeout: int(deadline.Seconds()),
+		}).Get(ctx, &a)
+		if err == nil {
+			log.Info("Network boot observed", tag.Builder().
+				KV("system_id", param.SystemID).
+				KV("source", a.Source).
+				KV("attempt", attempt).KeyVals...)
+
+			return &PowerOnWatchedResult{Activity: a, Attempts: attempt}, nil
+		}
+
+		if errcode.Of(err) != errcode.NetbootNotObserved {
+			return nil, err
+		}
+
+		log.Warn("Network boot not observed", tag.Builder().
+			KV("system_id", param.SystemID).
+			KV("attempt", attempt).KeyVals...)
+	}
+
+	return nil, fmt.Errorf("%w: %s within %s after %d attempts",
+		ErrNetbootNotObserved, param.SystemID, deadline, max(cycles, 0)+1)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netboot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"maas.io/core/src/maasagent/internal/dhcpd"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+func powerOnActivity(_ context.Context, _ power.PowerOnParam) error {
+	return nil
+}
+
+func powerCycleActivity(_ context.Context, _ power.PowerCycleParam) error {
+	return nil
+}
+
+func newNetbootEnvironment(w *Watcher) *testsuite.TestWorkflowEnvironment {
+	suite := testsuite.WorkflowTestSuite{}
+	suite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	for name, fn := range w.ConfigurationActivities() {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+
+	env.RegisterActivityWithOptions(powerOnActivity, activity.RegisterOptions{Name: "power-on"})
+	env.RegisterActivityWithOptions(powerCycleActivity, activity.RegisterOptions{Name: "power-cycle"})
+
+	return env
+}
+
+func notObserved() error {
+	return temporal.NewNonRetryableApplicationError("no POST/netboot observed",
+		errcode.NetbootNotObserved, nil)
+}
+
+func TestPowerOnWatched(t *testing.T) {
+	testcases := map[string]struct {
+		// observed are results of await-netboot, nil if activity is observed
+		observed []error
+		cycles   int
+		actions  []string
+		attempts int
+		err      string
+	}{
+		"observed": {
+			observed: []error{nil},
+			actions:  []string{"power-on"},
+			attempts: 1,
+		},
+		"observed after cycle": {
+			observed: []error{notObserved(), nil},
+			actions:  []string{"power-on", "power-cycle"},
+			attempts: 2,
+		},
+		"not observed": {
+			observed: []error{notObserved(), notObserved(), notObserved()},
+			cycles:   2,
+			actions:  []string{"power-on", "power-cycle", "power-cycle"},
+			err:      ErrNetbootNotObserved.Error(),
+		},
+		"never cycled": {
+			observed: []error{notObserved()},
+			cycles:   -1,
+			actions:  []string{"power-on"},
+			err:      ErrNetbootNotObserved.Error(),
+		},
+		"other failure": {
+			observed: []error{temporal.NewNonRetryableApplicationError("worker is gone", "", nil)},
+			actions:  []string{"power-on"},
+			err:      "worker is gone",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := NewWatcher()
+			env := newNetbootEnvironment(w)
+
+			var actions []string
+
+			env.OnActivity("power-on", mock.Anything, mock.Anything).
+				Return(func(context.Context, power.PowerOnParam) error {
+					actions = append(actions, "power-on")
+					return nil
+				})
+			env.OnActivity("power-cycle", mock.Anything, mock.Anything).
+				Return(func(context.Context, power.PowerCycleParam) error {
+					actions = append(actions, "power-cycle")
+					return nil
+				})
+
+			calls := 0
+
+			env.OnActivity("await-netboot", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, p AwaitNetbootParam) (*Activity, error) {
+					err := tc.observed[calls]
+					calls++
+
+					if err != nil {
+						return nil, err
+					}
+
+					return &Activity{MAC: p.MACs[0], Source: SourceDHCP, At: p.Since}, nil
+				})
+
+			env.ExecuteWorkflow(w.powerOnWatched, PowerOnWatchedParam{
+				AgentSystemID: "agent",
+				SystemID:      "abc",
+				MACs:          []string{"00:11:22:33:44:55"},
+				Cycles:        tc.cycles,
+			})
+
+			require.True(t, env.IsWorkflowCompleted())
+			assert.Equal(t, tc.actions, actions)
+
+			if tc.err != "" {
+				assert.ErrorContains(t, env.GetWorkflowError(), tc.err)
+				return
+			}
+
+			require.NoError(t, env.GetWorkflowError())
+
+			var result PowerOnWatchedResult
+			require.NoError(t, env.GetWorkflowResult(&result))
+			assert.Equal(t, tc.attempts, result.Attempts)
+			assert.Equal(t, "00:11:22:33:44:55", result.MAC)
+		})
+	}
+}
+
+func TestAwaitNetboot(t *testing.T) {
+	w := NewWatcher()
+
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(w.awaitNetboot)
+
+	since := time.Now().Truncate(time.Second)
+
+	_, err := env.ExecuteActivity(w.awaitNetboot, AwaitNetbootParam{
+		MACs:    []string{"00:11:22:33:44:55"},
+		Since:   since,
+		Timeout: 1,
+	})
+	require.Error(t, err)
+	assert.Equal(t, errcode.NetbootNotObserved, errcode.Of(err))
+
+	w.ObserveDHCP([]*dhcpd.Notification{commit("00:11:22:33:44:55", "10.0.0.5", since)})
+
+	val, err := env.ExecuteActivity(w.awaitNetboot, AwaitNetbootParam{
+		MACs:    []string{"00:11:22:33:44:55"},
+		Since:   since,
+		Timeout: 1,
+	})
+	require.NoError(t, err)
+
+	var a Activity
+	require.NoError(t, val.Get(&a))
+	assert.Equal(t, SourceDHCP, a.Source)
+}
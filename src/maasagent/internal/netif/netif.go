@@ -0,0 +1,293 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package netif inspects network interfaces of the host, so services can
+// be served on the interface that actually carries provisioning traffic
+// (e.g. an Open vSwitch bridge instead of its physical port).
+package netif
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxDepth limits how many levels of enslavement are followed
+// (e.g. NIC -> bond -> bridge)
+const maxDepth = 4
+
+const ovsDatapath = "ovs-system"
+
+var (
+	// ErrNotFound is returned when interface does not exist
+	ErrNotFound = errors.New("interface not found")
+)
+
+// Kind of network interface
+type Kind string
+
+const (
+	KindPhysical Kind = "physical"
+	KindBridge   Kind = "bridge"
+	KindBond     Kind = "bond"
+	KindVLAN     Kind = "vlan"
+	// KindOVSBridge is an OVS internal port, including OVS bridges
+	// and fake (VLAN) bridges.
+	KindOVSBridge Kind = "ovs-bridge"
+	// KindOVSPort is an interface attached to an OVS bridge
+	KindOVSPort Kind = "ovs-port"
+)
+
+// Info describes network interface
+type Info struct {
+	Name string
+	Kind Kind
+	// Master is the bridge or bond this interface is enslaved to
+	Master string
+	// Parent is the lower device of a VLAN interface
+	Parent string
+	// VLAN is VLAN ID of a VLAN interface or OVS fake bridge
+	VLAN int
+}
+
+// OVSCommand executes ovs-vsctl(8) and returns its output.
+type OVSCommand func(ctx context.Context, args ...string) (string, error)
+
+// Resolver inspects network interfaces using sysfs and ovs-vsctl(8).
+type Resolver struct {
+	ovs       OVSCommand
+	sysfsRoot string
+	procRoot  string
+}
+
+// ResolverOption allows to set additional Resolver options
+type ResolverOption func(*Resolver)
+
+// NewResolver returns an instance of Resolver
+func NewResolver(options ...ResolverOption) *Resolver {
+	r := &Resolver{
+		ovs:       runOVSCommand,
+		sysfsRoot: "/sys/class/net",
+		procRoot:  "/proc/net/vlan",
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+// WithSysfsRoot sets directory used instead of /sys/class/net
+func WithSysfsRoot(path string) ResolverOption {
+	return func(r *Resolver) {
+		r.sysfsRoot = path
+	}
+}
+
+// WithProcVLANRoot sets directory used instead of /proc/net/vlan
+func WithProcVLANRoot(path string) ResolverOption {
+	return func(r *Resolver) {
+		r.procRoot = path
+	}
+}
+
+// WithOVSCommand allows to replace the way ovs-vsctl(8) is executed
+func WithOVSCommand(fn OVSCommand) ResolverOption {
+	return func(r *Resolver) {
+		r.ovs = fn
+	}
+}
+
+// Inspect returns information about the interface.
+func (r *Resolver) Inspect(ctx context.Context, name string) (Info, error) {
+	dir := filepath.Join(r.sysfsRoot, name)
+
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return Info{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+
+		return Info{}, err
+	}
+
+	info := Info{Name: name, Kind: KindPhysical}
+
+	if master, err := os.Readlink(filepath.Join(dir, "master")); err == nil {
+		info.Master = filepath.Base(master)
+	}
+
+	switch devType := r.devType(dir); {
+	case devType == "bridge":
+		info.Kind = KindBridge
+	case devType == "bond":
+		info.Kind = KindBond
+	case devType == "vlan":
+		info.Kind = KindVLAN
+		info.Parent, info.VLAN = r.vlan(name)
+	case info.Master == ovsDatapath:
+		// Both OVS internal ports and interfaces added to OVS bridges are
+		// enslaved to the datapath, only OVS knows which one is which.
+		info.Master = ""
+
+		ifType, err := r.ovs(ctx, "--if-exists", "get", "Interface", name, "type")
+		if err != nil {
+			return Info{}, err
+		}
+
+		if ifType != "internal" {
+			info.Kind = KindOVSPort
+
+			info.Master, err = r.ovs(ctx, "port-to-br", name)
+			if err != nil {
+				return Info{}, err
+			}
+
+			break
+		}
+
+		info.Kind = KindOVSBridge
+
+		vlan, err := r.ovs(ctx, "br-to-vlan", name)
+		if err != nil {
+			// Internal ports that are not bridges are not known to br-to-vlan
+			//nolint:nilerr // VLAN is optional
+			return info, nil
+		}
+
+		info.VLAN, _ = strconv.Atoi(vlan) //nolint:errcheck // 0 means untagged
+	}
+
+	return info, nil
+}
+
+// ServingInterface returns the interface that should be used to serve
+// PXE, DHCP or HTTP for the provided interface. Interfaces enslaved to a
+// bond, Linux bridge or an OVS bridge carry no addresses, and raw sockets
+// (or sockets bound with SO_BINDTODEVICE) on them miss the traffic that is
+// delivered to the master, so the topmost master is returned instead.
+// VLAN tagging is handled by the kernel (VLAN interfaces) or OVS (fake
+// bridges), so the resolved interface always sees untagged frames.
+func (r *Resolver) ServingInterface(ctx context.Context, name string) (string, error) {
+	for i := 0; i < maxDepth; i++ {
+		info, err := r.Inspect(ctx, name)
+		if err != nil {
+			return "", err
+		}
+
+		if info.Master == "" {
+			return name, nil
+		}
+
+		name = info.Master
+	}
+
+	return name, nil
+}
+
+// ServingInterfaces resolves every interface in a whitespace separated
+// list (as used by dhcpd interface files) and removes duplicates.
+func (r *Resolver) ServingInterfaces(ctx context.Context, names string) (string, error) {
+	seen := make(map[string]struct{})
+
+	var res []string
+
+	for _, name := range strings.Fields(names) {
+		resolved, err := r.ServingInterface(ctx, name)
+		if err != nil {
+			return "", err
+		}
+
+		if _, ok := seen[resolved]; ok {
+			continue
+		}
+
+		seen[resolved] = struct{}{}
+
+		res = append(res, resolved)
+	}
+
+	return strings.Join(res, " "), nil
+}
+
+func (r *Resolver) devType(dir string) string {
+	data, err := os.ReadFile(filepath.Clean(filepath.Join(dir, "uevent")))
+	if err != nil {
+		return ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "DEVTYPE="); ok {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// vlan parses /proc/net/vlan/<name>, where the first lines are:
+//
+//	eth0.100  VID: 100	 REORDER_HDR: 1  dev->priv_flags: 1021
+//	...
+//	Device: eth0
+func (r *Resolver) vlan(name string) (string, int) {
+	data, err := os.ReadFile(filepath.Clean(filepath.Join(r.procRoot, name)))
+	if err != nil {
+		return "", 0
+	}
+
+	var (
+		parent string
+		vid    int
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		for i := 0; i < len(fields)-1; i++ {
+			switch fields[i] {
+			case "VID:":
+				vid, _ = strconv.Atoi(fields[i+1]) //nolint:errcheck // 0 means unknown
+			case "Device:":
+				parent = fields[i+1]
+			}
+		}
+	}
+
+	return parent, vid
+}
+
+func runOVSCommand(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "ovs-vsctl", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("ovs-vsctl %v: %w: %s", args, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return strings.Trim(strings.TrimSpace(string(out)), `"`), nil
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netif
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeInterface struct {
+	devType string
+	master  string
+}
+
+// newFakeResolver creates sysfs tree with the following topology:
+//
+//	eth0, eth1 -> bond0 -> br0 (Linux bridge)
+//	eth2 -> br-ex (OVS bridge) with fake bridge vlan100 (tag 100)
+//	eth3.200 (VLAN on eth3)
+func newFakeResolver(t *testing.T) *Resolver {
+	t.Helper()
+
+	sysfs := filepath.Join(t.TempDir(), "sys")
+	proc := filepath.Join(t.TempDir(), "proc")
+
+	interfaces := map[string]fakeInterface{
+		"eth0":     {master: "bond0"},
+		"eth1":     {master: "bond0"},
+		"bond0":    {devType: "bond", master: "br0"},
+		"br0":      {devType: "bridge"},
+		"eth2":     {master: ovsDatapath},
+		"br-ex":    {master: ovsDatapath},
+		"vlan100":  {master: ovsDatapath},
+		"eth3":     {},
+		"eth3.200": {devType: "vlan"},
+	}
+
+	for name, iface := range interfaces {
+		dir := filepath.Join(sysfs, name)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+
+		uevent := "INTERFACE=" + name + "\n"
+		if iface.devType != "" {
+			uevent += "DEVTYPE=" + iface.devType + "\n"
+		}
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "uevent"), []byte(uevent), 0o600))
+
+		if iface.master != "" {
+			require.NoError(t, os.Symlink(filepath.Join("..", iface.master), filepath.Join(dir, "master")))
+		}
+	}
+
+	require.NoError(t, os.MkdirAll(proc, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(proc, "eth3.200"),
+		[]byte("eth3.200  VID: 200\t REORDER_HDR: 1  dev->priv_flags: 1021\nDevice: eth3\n"), 0o600))
+
+	ovs := func(_ context.Context, args ...string) (string, error) {
+		switch strings.Join(args, " ") {
+		case "--if-exists get Interface eth2 type":
+			return "", nil
+		case "--if-exists get Interface br-ex type", "--if-exists get Interface vlan100 type":
+			return "internal", nil
+		case "port-to-br eth2":
+			return "br-ex", nil
+		case "br-to-vlan br-ex":
+			return "0", nil
+		case "br-to-vlan vlan100":
+			return "100", nil
+		}
+
+		return "", fmt.Errorf("unexpected ovs-vsctl call: %v", args)
+	}
+
+	return NewResolver(WithSysfsRoot(sysfs), WithProcVLANRoot(proc), WithOVSCommand(ovs))
+}
+
+func TestInspect(t *testing.T) {
+	testcases := map[string]struct {
+		out Info
+	}{
+		"eth0": {
+			out: Info{Name: "eth0", Kind: KindPhysical, Master: "bond0"},
+		},
+		"bond0": {
+			out: Info{Name: "bond0", Kind: KindBond, Master: "br0"},
+		},
+		"br0": {
+			out: Info{Name: "br0", Kind: KindBridge},
+		},
+		"eth2": {
+			out: Info{Name: "eth2", Kind: KindOVSPort, Master: "br-ex"},
+		},
+		"br-ex": {
+			out: Info{Name: "br-ex", Kind: KindOVSBridge},
+		},
+		"vlan100": {
+			out: Info{Name: "vlan100", Kind: KindOVSBridge, VLAN: 100},
+		},
+		"eth3.200": {
+			out: Info{Name: "eth3.200", Kind: KindVLAN, Parent: "eth3", VLAN: 200},
+		},
+	}
+
+	r := newFakeResolver(t)
+
+	for name, tc := range testcases {
+		name, tc := name, tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			info, err := r.Inspect(context.Background(), name)
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, info)
+		})
+	}
+}
+
+func TestInspectNotFound(t *testing.T) {
+	r := newFakeResolver(t)
+
+	_, err := r.Inspect(context.Background(), "eth9")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestServingInterfaces(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out string
+	}{
+		"bond slaves in a bridge": {
+			in:  "eth0 eth1",
+			out: "br0",
+		},
+		"ovs port": {
+			in:  "eth2",
+			out: "br-ex",
+		},
+		"ovs fake bridge": {
+			in:  "vlan100",
+			out: "vlan100",
+		},
+		"vlan and physical": {
+			in:  "eth3 eth3.200",
+			out: "eth3 eth3.200",
+		},
+		"empty": {},
+	}
+
+	r := newFakeResolver(t)
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := r.ServingInterfaces(context.Background(), tc.in)
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
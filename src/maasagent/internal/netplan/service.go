@@ -0,0 +1,337 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package netplan applies host network configuration (bridges, VLANs, bonds)
+// pushed by the Region onto the rack host itself.
+package netplan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"gopkg.in/yaml.v3"
+	"maas.io/core/src/maasagent/internal/atomicfile"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	defaultConfigPath          = "/etc/netplan/90-maas-agent.yaml"
+	defaultConnectivityTimeout = 120 * time.Second
+	connectivityCheckInterval  = 2 * time.Second
+)
+
+var (
+	// ErrInvalidConfig is returned when network configuration is not valid
+	ErrInvalidConfig = errors.New("invalid network configuration")
+	// ErrConnectivityLost is returned when the Region was not reachable
+	// after the configuration was applied, and it was rolled back.
+	ErrConnectivityLost = errors.New("region connectivity lost, configuration rolled back")
+)
+
+// CommandRunner executes netplan(8) with the provided arguments.
+type CommandRunner func(ctx context.Context, args ...string) error
+
+// ConnectivityCheck returns nil if the Region is reachable.
+type ConnectivityCheck func(ctx context.Context) error
+
+// NetplanService is a service that applies network configuration of the
+// rack host. Invocation of this service normally should happen via Temporal.
+type NetplanService struct {
+	run        CommandRunner
+	check      ConnectivityCheck
+	configPath string
+}
+
+// NetplanServiceOption allows to set additional NetplanService options
+type NetplanServiceOption func(*NetplanService)
+
+// NewNetplanService returns an instance of NetplanService
+func NewNetplanService(options ...NetplanServiceOption) *NetplanService {
+	s := &NetplanService{
+		run:        runNetplan,
+		check:      func(context.Context) error { return nil },
+		configPath: defaultConfigPath,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithConfigPath sets path of the netplan file managed by the Agent.
+func WithConfigPath(path string) NetplanServiceOption {
+	return func(s *NetplanService) {
+		s.configPath = path
+	}
+}
+
+// WithCommandRunner allows to replace the way netplan(8) is executed.
+func WithCommandRunner(run CommandRunner) NetplanServiceOption {
+	return func(s *NetplanService) {
+		s.run = run
+	}
+}
+
+// WithConnectivityCheck sets the check used to decide whether applied
+// configuration should be rolled back.
+func WithConnectivityCheck(check ConnectivityCheck) NetplanServiceOption {
+	return func(s *NetplanService) {
+		s.check = check
+	}
+}
+
+// DialCheck returns ConnectivityCheck that succeeds once any of the
+// addresses accepts a TCP connection.
+func DialCheck(addresses []string) ConnectivityCheck {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+
+		for {
+			for _, addr := range addresses {
+				conn, err := dialer.DialContext(ctx, "tcp", addr)
+				if err == nil {
+					return conn.Close()
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(connectivityCheckInterval):
+			}
+		}
+	}
+}
+
+func (s *NetplanService) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (s *NetplanService) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"apply-network-configuration": s.ApplyNetworkConfiguration,
+	}
+}
+
+// ApplyNetworkConfigurationParam is the activity parameter for
+// ApplyNetworkConfiguration
+type ApplyNetworkConfigurationParam struct {
+	// Config is netplan YAML
+	Config string `json:"config"`
+	// ConnectivityTimeout is how long (in seconds) to wait for the Region
+	// to become reachable after configuration was applied.
+	ConnectivityTimeout int `json:"connectivity_timeout"`
+}
+
+// ApplyNetworkConfiguration validates and applies network configuration.
+// If the Region is not reachable within ConnectivityTimeout, the previous
+// configuration is restored. Result of the activity can be delivered to
+// the Region only after connectivity is restored, hence rollback is done
+// locally without waiting for any instructions.
+func (s *NetplanService) ApplyNetworkConfiguration(ctx context.Context,
+	param ApplyNetworkConfigurationParam) error {
+	log := activity.GetLogger(ctx)
+
+	if err := validate([]byte(param.Config)); err != nil {
+		return err
+	}
+
+	backup, err := os.ReadFile(s.configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	hasBackup := err == nil
+
+	if err := s.write([]byte(param.Config)); err != nil {
+		return err
+	}
+
+	// netplan generate validates the whole configuration (including files
+	// not managed by the Agent) without touching interfaces.
+	if err := s.run(ctx, "generate"); err != nil {
+		if rerr := s.restore(backup, hasBackup); rerr != nil {
+			log.Error("Failed to restore network configuration", tag.Builder().Error(rerr).KeyVals...)
+		}
+
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	timeout := defaultConnectivityTimeout
+	if param.ConnectivityTimeout > 0 {
+		timeout = time.Duration(param.ConnectivityTimeout) * time.Second
+	}
+
+	err = s.run(ctx, "apply")
+	if err == nil {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = s.check(checkCtx)
+
+		cancel()
+
+		if err == nil {
+			log.Info("Network configuration applied")
+			return nil
+		}
+
+		err = fmt.Errorf("%w: %w", ErrConnectivityLost, err)
+	}
+
+	log.Warn("Rolling back network configuration", tag.Builder().Error(err).KeyVals...)
+
+	if rerr := s.rollback(ctx, backup, hasBackup); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+
+	return err
+}
+
+func (s *NetplanService) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.configPath), 0o755); err != nil {
+		return err
+	}
+
+	// netplan warns about configuration files that are readable by others
+	return atomicfile.WriteFile(s.configPath, data, 0o600)
+}
+
+func (s *NetplanService) restore(backup []byte, hasBackup bool) error {
+	if hasBackup {
+		return s.write(backup)
+	}
+
+	err := os.Remove(s.configPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *NetplanService) rollback(ctx context.Context, backup []byte, hasBackup bool) error {
+	if err := s.restore(backup, hasBackup); err != nil {
+		return err
+	}
+
+	// The activity context might be already cancelled, but rollback has to
+	// be completed in any case.
+	return s.run(context.WithoutCancel(ctx), "apply")
+}
+
+func runNetplan(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "netplan", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("netplan %v: %w: %s", args, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return nil
+}
+
+type netplanConfig struct {
+	Network struct {
+		Ethernets map[string]interface{} `yaml:"ethernets"`
+		Bonds     map[string]struct {
+			Interfaces []string `yaml:"interfaces"`
+		} `yaml:"bonds"`
+		Bridges map[string]struct {
+			Interfaces []string `yaml:"interfaces"`
+		} `yaml:"bridges"`
+		VLANs map[string]struct {
+			Link string `yaml:"link"`
+			ID   int    `yaml:"id"`
+		} `yaml:"vlans"`
+		Version int `yaml:"version"`
+	} `yaml:"network"`
+}
+
+// validate performs basic checks of the configuration, so obviously broken
+// configuration is rejected before netplan is invoked.
+func validate(data []byte) error {
+	var cfg netplanConfig
+
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	n := cfg.Network
+
+	if n.Version != 2 {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidConfig, n.Version)
+	}
+
+	declared := make(map[string]bool)
+
+	for name := range n.Ethernets {
+		declared[name] = true
+	}
+
+	for name := range n.Bonds {
+		declared[name] = true
+	}
+
+	for name := range n.VLANs {
+		declared[name] = true
+	}
+
+	for name, vlan := range n.VLANs {
+		if vlan.ID < 1 || vlan.ID > 4094 {
+			return fmt.Errorf("%w: vlan %s: invalid id %d", ErrInvalidConfig, name, vlan.ID)
+		}
+
+		_, isBridge := n.Bridges[vlan.Link]
+
+		if vlan.Link == name || (!declared[vlan.Link] && !isBridge) {
+			return fmt.Errorf("%w: vlan %s: unknown link %q", ErrInvalidConfig, name, vlan.Link)
+		}
+	}
+
+	for name, bond := range n.Bonds {
+		if err := checkMembers("bond", name, bond.Interfaces, declared); err != nil {
+			return err
+		}
+	}
+
+	for name, bridge := range n.Bridges {
+		if err := checkMembers("bridge", name, bridge.Interfaces, declared); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkMembers(kind, name string, members []string, declared map[string]bool) error {
+	for _, m := range members {
+		if m == name || !declared[m] {
+			return fmt.Errorf("%w: %s %s: unknown interface %q", ErrInvalidConfig, kind, name, m)
+		}
+	}
+
+	return nil
+}
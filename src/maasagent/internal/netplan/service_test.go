@@ -0,0 +1,197 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package netplan
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+)
+
+const validConfig = `
+network:
+  version: 2
+  ethernets:
+    eth0: {}
+    eth1: {}
+  bonds:
+    bond0:
+      interfaces: [eth0, eth1]
+  vlans:
+    bond0.100:
+      id: 100
+      link: bond0
+  bridges:
+    br0:
+      interfaces: [bond0.100]
+`
+
+func TestValidate(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		err error
+	}{
+		"valid": {
+			in: validConfig,
+		},
+		"not yaml": {
+			in:  "network: [",
+			err: ErrInvalidConfig,
+		},
+		"wrong version": {
+			in:  "network: {version: 1}",
+			err: ErrInvalidConfig,
+		},
+		"invalid vlan id": {
+			in:  "network: {version: 2, ethernets: {eth0: {}}, vlans: {v: {id: 4095, link: eth0}}}",
+			err: ErrInvalidConfig,
+		},
+		"vlan on bridge": {
+			in: "network: {version: 2, bridges: {br0: {}}, vlans: {v: {id: 10, link: br0}}}",
+		},
+		"unknown vlan link": {
+			in:  "network: {version: 2, vlans: {v: {id: 10, link: eth0}}}",
+			err: ErrInvalidConfig,
+		},
+		"unknown bond member": {
+			in:  "network: {version: 2, bonds: {bond0: {interfaces: [eth0]}}}",
+			err: ErrInvalidConfig,
+		},
+		"bridge member of itself": {
+			in:  "network: {version: 2, ethernets: {br0: {}}, bridges: {br0: {interfaces: [br0]}}}",
+			err: ErrInvalidConfig,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, validate([]byte(tc.in)), tc.err)
+		})
+	}
+}
+
+func TestApplyNetworkConfiguration(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	testcases := map[string]struct {
+		previous  string
+		generate  error
+		apply     error
+		check     error
+		err       error
+		commands  []string
+		wantFinal string
+	}{
+		"applied": {
+			previous:  "old",
+			commands:  []string{"generate", "apply"},
+			wantFinal: validConfig,
+		},
+		"generate failure restores previous": {
+			previous:  "old",
+			generate:  errFailed,
+			err:       ErrInvalidConfig,
+			commands:  []string{"generate"},
+			wantFinal: "old",
+		},
+		"connectivity lost rolls back": {
+			previous:  "old",
+			check:     errFailed,
+			err:       ErrConnectivityLost,
+			commands:  []string{"generate", "apply", "apply"},
+			wantFinal: "old",
+		},
+		"apply failure rolls back": {
+			previous:  "old",
+			apply:     errFailed,
+			err:       errFailed,
+			commands:  []string{"generate", "apply", "apply"},
+			wantFinal: "old",
+		},
+		"rollback without previous removes file": {
+			check:    errFailed,
+			err:      ErrConnectivityLost,
+			commands: []string{"generate", "apply", "apply"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "netplan", "90-maas-agent.yaml")
+
+			if tc.previous != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(tc.previous), 0o600))
+			}
+
+			var commands []string
+
+			svc := NewNetplanService(
+				WithConfigPath(path),
+				WithCommandRunner(func(_ context.Context, args ...string) error {
+					commands = append(commands, args[0])
+
+					switch {
+					case args[0] == "generate":
+						return tc.generate
+					case args[0] == "apply" && len(commands) == 2:
+						return tc.apply
+					}
+
+					return nil
+				}),
+				WithConnectivityCheck(func(context.Context) error { return tc.check }),
+			)
+
+			suite := testsuite.WorkflowTestSuite{}
+			env := suite.NewTestActivityEnvironment()
+			env.RegisterActivity(svc.ApplyNetworkConfiguration)
+
+			_, err := env.ExecuteActivity(svc.ApplyNetworkConfiguration,
+				ApplyNetworkConfigurationParam{Config: validConfig})
+
+			if tc.err != nil {
+				assert.ErrorContains(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.commands, commands)
+
+			data, err := os.ReadFile(path)
+			if tc.wantFinal == "" {
+				assert.True(t, os.IsNotExist(err))
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantFinal, string(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package operation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PathPrefix is where Handler is expected to be served
+const PathPrefix = "/operations/"
+
+// HTTP headers
+const (
+	// HeaderIdempotencyKey is set by clients to the operation ID they chose
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderOperationID is set in responses to the operation ID
+	HeaderOperationID = "X-MAAS-Operation-ID"
+	// HeaderReplayed is set in responses replayed from the stored result
+	HeaderReplayed = "Idempotent-Replayed"
+)
+
+const (
+	maxRequestSize = 1 << 20
+	// maxResponseSize is the largest response which is kept for replay
+	maxResponseSize = 1 << 20
+)
+
+// storedHeaders are response headers kept for replay
+var storedHeaders = []string{"Content-Type", "Location"}
+
+// Middleware makes requests other than GET and HEAD idempotent operations
+// of the kind. The operation ID is the Idempotency-Key of the request, or
+// is generated if the client didn't provide one. Requests retried with
+// the same key get the stored response (waiting for it, if the operation
+// is in progress) instead of executing the operation again. The key can
+// only be reused for the same request.
+//
+// Operations are not cancelled when the client disconnects, so their
+// outcome can be retrieved later with Handler.
+func (s *Store) Middleware(kind string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := r.Header.Get(HeaderIdempotencyKey)
+		if id == "" {
+			id = newID()
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		op, done, err := s.begin(id, kind, fingerprint(r, body))
+
+		switch {
+		case errors.Is(err, ErrConflict):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, ErrInvalidID):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(HeaderOperationID, id)
+
+		if op != nil {
+			if err := wait(r.Context(), done); err != nil {
+				return
+			}
+
+			stored, err := s.Get(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			replay(w, stored)
+
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+
+		r = r.WithContext(context.WithoutCancel(r.Context()))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		next.ServeHTTP(rec, r)
+
+		s.finish(id, rec.outcome())
+	})
+}
+
+// Handler returns operations by ID, e.g. GET /operations/<id>
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		op, err := s.Get(strings.TrimPrefix(r.URL.Path, PathPrefix))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(op); err != nil {
+			log.Warn().Err(err).Msg("Failed to write operation")
+		}
+	})
+}
+
+// replay writes the stored response of the operation
+func replay(w http.ResponseWriter, op Operation) {
+	w.Header().Set(HeaderReplayed, "true")
+
+	switch {
+	case op.Response != nil:
+		for k, v := range op.Response.Header {
+			w.Header().Set(k, v)
+		}
+
+		w.WriteHeader(op.Response.Status)
+		//nolint:errcheck // client will retry, if it didn't get the response
+		w.Write(op.Response.Body)
+	case op.State == StateInterrupted:
+		http.Error(w, "operation was interrupted, its outcome is unknown", http.StatusConflict)
+	default:
+		http.Error(w, "operation response is too large to be replayed", http.StatusConflict)
+	}
+}
+
+// fingerprint identifies the request by method, URL and body
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	//nolint:errcheck // hash.Hash never returns an error
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	//nolint:errcheck // crypto/rand never fails on supported platforms
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// recorder passes the response to the client and keeps it for replay
+type recorder struct {
+	http.ResponseWriter
+	body      bytes.Buffer
+	status    int
+	truncated bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.body.Len()+len(b) > maxResponseSize {
+		r.truncated = true
+	} else if !r.truncated {
+		r.body.Write(b)
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) outcome() Operation {
+	op := Operation{State: StateSucceeded}
+
+	if r.status >= http.StatusBadRequest {
+		op.State = StateFailed
+		op.Error = http.StatusText(r.status)
+	}
+
+	if r.truncated {
+		return op
+	}
+
+	op.Response = &Response{Status: r.status, Body: r.body.Bytes()}
+
+	for _, k := range storedHeaders {
+		if v := r.Header().Get(k); v != "" {
+			if op.Response.Header == nil {
+				op.Response.Header = make(map[string]string)
+			}
+
+			op.Response.Header[k] = v
+		}
+	}
+
+	return op
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHandler(t *testing.T) (http.Handler, *Store, *atomic.Int32) {
+	t.Helper()
+
+	s, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Other", "dropped")
+		w.WriteHeader(http.StatusCreated)
+		//nolint:errcheck // test response
+		json.NewEncoder(w).Encode(map[string]any{"call": n, "body": string(body)})
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/power", s.Middleware("power", next))
+	mux.Handle(PathPrefix, s.Handler())
+
+	return mux, s, &calls
+}
+
+func request(h http.Handler, method, target, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestMiddlewareReplay(t *testing.T) {
+	t.Parallel()
+
+	h, _, calls := newTestHandler(t)
+
+	first := request(h, http.MethodPost, "/power", "key-1", `{"action":"on"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, "key-1", first.Header().Get(HeaderOperationID))
+	assert.Empty(t, first.Header().Get(HeaderReplayed))
+
+	retry := request(h, http.MethodPost, "/power", "key-1", `{"action":"on"}`)
+	require.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(HeaderReplayed))
+	assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+	assert.Empty(t, retry.Header().Get("X-Other"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Same key for a different request
+	conflict := request(h, http.MethodPost, "/power", "key-1", `{"action":"off"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, conflict.Code)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestMiddlewareGeneratedID(t *testing.T) {
+	t.Parallel()
+
+	h, _, calls := newTestHandler(t)
+
+	w := request(h, http.MethodPost, "/power", "", `{"action":"on"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	id := w.Header().Get(HeaderOperationID)
+	require.Len(t, id, 32)
+
+	// Requests without key are not deduplicated
+	request(h, http.MethodPost, "/power", "", `{"action":"on"}`)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// GET is not an operation
+	w = request(h, http.MethodGet, "/power", "", "")
+	assert.Empty(t, w.Header().Get(HeaderOperationID))
+
+	w = request(h, http.MethodGet, PathPrefix+id, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var op Operation
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&op))
+	assert.Equal(t, id, op.ID)
+	assert.Equal(t, "power", op.Kind)
+	assert.Equal(t, StateSucceeded, op.State)
+	require.NotNil(t, op.Response)
+	assert.Equal(t, http.StatusCreated, op.Response.Status)
+	assert.JSONEq(t, `{"call":1,"body":"{\"action\":\"on\"}"}`, string(op.Response.Body))
+
+	w = request(h, http.MethodGet, PathPrefix+"unknown", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestMiddlewareClientGone(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	h := s.Middleware("power", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Operation must not be cancelled with the request
+		assert.NoError(t, r.Context().Err())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := httptest.NewRequest(http.MethodPost, "/power", nil).WithContext(ctx)
+	req.Header.Set(HeaderIdempotencyKey, "key-1")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	op, err := s.Get("key-1")
+	require.NoError(t, err)
+	assert.Equal(t, StateSucceeded, op.State)
+	assert.Equal(t, http.StatusNoContent, op.Response.Status)
+}
@@ -0,0 +1,332 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package operation gives operations triggered by external automation
+// (e.g. Terraform providers or Ansible) stable IDs and keeps their results,
+// so clients can safely retry requests and poll for the outcome without
+// executing the operation twice.
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+)
+
+// States of operations
+const (
+	StatePending   = "pending"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	// StateInterrupted is set for operations that were pending when
+	// the Agent stopped. Their outcome is unknown.
+	StateInterrupted = "interrupted"
+)
+
+const (
+	fileExt          = ".json"
+	defaultRetention = 24 * time.Hour
+	maxIDLength      = 128
+)
+
+var (
+	// ErrNotFound is returned when there is no operation with the given ID
+	ErrNotFound = errors.New("operation not found")
+	// ErrConflict is returned when ID is reused for a different operation
+	ErrConflict = errors.New("operation ID is used by a different operation")
+	// ErrInvalidID is returned when ID is empty or too long
+	ErrInvalidID = errors.New("invalid operation ID")
+)
+
+// Response is a stored HTTP response of an operation
+type Response struct {
+	Header map[string]string `json:"header,omitempty"`
+	Body   []byte            `json:"body,omitempty"`
+	Status int               `json:"status"`
+}
+
+// Operation is an operation with its outcome
+type Operation struct {
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Response of operations triggered via HTTP
+	Response *Response `json:"response,omitempty"`
+	// Result of operations executed with Do
+	Result json.RawMessage `json:"result,omitempty"`
+	ID     string          `json:"id"`
+	Kind   string          `json:"kind"`
+	State  string          `json:"state"`
+	Error  string          `json:"error,omitempty"`
+	// Fingerprint identifies the request, so the ID is not reused for
+	// a different one
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// Store keeps operations for the retention period. Every operation is
+// stored in its own file written atomically, like in the journal.
+type Store struct {
+	ops map[string]*Operation
+	// inflight are closed when the operation finishes
+	inflight  map[string]chan struct{}
+	now       func() time.Time
+	dir       string
+	retention time.Duration
+	mutex     sync.Mutex
+}
+
+// StoreOption allows to set additional options for the Store
+type StoreOption func(*Store)
+
+// WithRetention sets how long results of finished operations are kept
+func WithRetention(d time.Duration) StoreOption {
+	return func(s *Store) {
+		s.retention = d
+	}
+}
+
+// NewStore returns Store keeping operations in dir. Operations that
+// were pending when the Agent stopped are marked as interrupted.
+// If dir does not exist, it will be created.
+func NewStore(dir string, options ...StoreOption) (*Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create operations directory: %w", err)
+	}
+
+	s := &Store{
+		ops:       make(map[string]*Operation),
+		inflight:  make(map[string]chan struct{}),
+		now:       time.Now,
+		dir:       dir,
+		retention: defaultRetention,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileExt) {
+			continue
+		}
+
+		op, err := s.read(filepath.Join(dir, e.Name()))
+		if err != nil {
+			log.Warn().Err(err).Str("file", e.Name()).Msg("Dropping unreadable operation")
+			continue
+		}
+
+		if op.State == StatePending {
+			now := s.now().UTC()
+			op.State = StateInterrupted
+			op.FinishedAt = &now
+
+			if err := s.write(op); err != nil {
+				return nil, err
+			}
+		}
+
+		s.ops[op.ID] = op
+	}
+
+	return s, nil
+}
+
+// Get returns the operation with id
+func (s *Store) Get(id string) (Operation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	op, ok := s.ops[id]
+	if !ok || s.expired(op) {
+		return Operation{}, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+
+	return *op, nil
+}
+
+// Do executes fn as the operation with id, unless it was executed before.
+// If the operation is in progress, Do waits for it to finish. Result of
+// fn must be JSON serializable.
+func (s *Store) Do(ctx context.Context, id, kind string,
+	fn func(ctx context.Context) (any, error)) (Operation, error) {
+	op, done, err := s.begin(id, kind, "")
+	if err != nil {
+		return Operation{}, err
+	}
+
+	if op != nil {
+		if err := wait(ctx, done); err != nil {
+			return Operation{}, err
+		}
+
+		return s.Get(id)
+	}
+
+	res, fnErr := fn(ctx)
+
+	finished := Operation{State: StateSucceeded}
+
+	if fnErr != nil {
+		finished.State = StateFailed
+		finished.Error = fnErr.Error()
+	} else if finished.Result, err = json.Marshal(res); err != nil {
+		finished.State = StateFailed
+		finished.Error = err.Error()
+	}
+
+	return s.finish(id, finished), nil
+}
+
+func wait(ctx context.Context, done <-chan struct{}) error {
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// begin records the start of the operation. If the operation already
+// exists, it is returned instead, together with a channel closed when it
+// finishes (nil, if it is not in progress).
+func (s *Store) begin(id, kind, fingerprint string) (*Operation, <-chan struct{}, error) {
+	if id == "" || len(id) > maxIDLength {
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune()
+
+	if op, ok := s.ops[id]; ok {
+		if op.Kind != kind || op.Fingerprint != fingerprint {
+			return nil, nil, fmt.Errorf("%w: %q", ErrConflict, id)
+		}
+
+		cp := *op
+
+		return &cp, s.inflight[id], nil
+	}
+
+	op := &Operation{
+		StartedAt:   s.now().UTC(),
+		ID:          id,
+		Kind:        kind,
+		State:       StatePending,
+		Fingerprint: fingerprint,
+	}
+
+	if err := s.write(op); err != nil {
+		return nil, nil, err
+	}
+
+	s.ops[id] = op
+	s.inflight[id] = make(chan struct{})
+
+	return nil, nil, nil
+}
+
+// finish records the outcome of the operation started with begin.
+// Failure to persist it is only logged, as the operation has already
+// been executed and the outcome is still available until restart.
+func (s *Store) finish(id string, outcome Operation) Operation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	op := s.ops[id]
+
+	now := s.now().UTC()
+	op.FinishedAt = &now
+	op.State = outcome.State
+	op.Error = outcome.Error
+	op.Result = outcome.Result
+	op.Response = outcome.Response
+
+	if err := s.write(op); err != nil {
+		log.Warn().Err(err).Str("id", id).Msg("Failed to persist operation result")
+	}
+
+	close(s.inflight[id])
+	delete(s.inflight, id)
+
+	return *op
+}
+
+func (s *Store) expired(op *Operation) bool {
+	return op.FinishedAt != nil && s.now().Sub(*op.FinishedAt) > s.retention
+}
+
+// prune removes expired operations. Must be called with s.mutex held.
+func (s *Store) prune() {
+	for id, op := range s.ops {
+		if !s.expired(op) {
+			continue
+		}
+
+		if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Err(err).Str("id", id).Msg("Failed to remove expired operation")
+			continue
+		}
+
+		delete(s.ops, id)
+	}
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, url.PathEscape(id)+fileExt)
+}
+
+func (s *Store) read(p string) (*Operation, error) {
+	//nolint:gosec // path is built from the operations directory
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var op Operation
+
+	return &op, json.Unmarshal(b, &op)
+}
+
+func (s *Store) write(op *Operation) error {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path(op.ID), b, 0600)
+}
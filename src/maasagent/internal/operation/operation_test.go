@@ -0,0 +1,221 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package operation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreDo(t *testing.T) {
+	testcases := map[string]struct {
+		fn     func(ctx context.Context) (any, error)
+		state  string
+		result string
+		err    string
+	}{
+		"succeeded": {
+			fn:     func(context.Context) (any, error) { return map[string]string{"state": "on"}, nil },
+			state:  StateSucceeded,
+			result: `{"state":"on"}`,
+		},
+		"failed": {
+			fn:    func(context.Context) (any, error) { return nil, errors.New("boom") },
+			state: StateFailed,
+			err:   "boom",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewStore(t.TempDir())
+			require.NoError(t, err)
+
+			var calls atomic.Int32
+
+			fn := func(ctx context.Context) (any, error) {
+				calls.Add(1)
+				return tc.fn(ctx)
+			}
+
+			for i := 0; i < 2; i++ {
+				op, err := s.Do(context.Background(), "op-1", "power", fn)
+				require.NoError(t, err)
+
+				assert.Equal(t, "op-1", op.ID)
+				assert.Equal(t, tc.state, op.State)
+				assert.Equal(t, tc.err, op.Error)
+				assert.NotNil(t, op.FinishedAt)
+
+				if tc.result != "" {
+					assert.JSONEq(t, tc.result, string(op.Result))
+				}
+			}
+
+			assert.Equal(t, int32(1), calls.Load())
+		})
+	}
+}
+
+func TestStoreDoConcurrent(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	fn := func(context.Context) (any, error) {
+		calls.Add(1)
+		close(started)
+		<-release
+
+		return "done", nil
+	}
+
+	var wg sync.WaitGroup
+
+	results := make([]Operation, 5)
+
+	do := func(i int) {
+		defer wg.Done()
+
+		op, err := s.Do(context.Background(), "op-1", "power", fn)
+		assert.NoError(t, err)
+
+		results[i] = op
+	}
+
+	wg.Add(len(results))
+
+	go do(0)
+	<-started
+
+	op, err := s.Get("op-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatePending, op.State)
+
+	for i := 1; i < len(results); i++ {
+		go do(i)
+	}
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+
+	for _, op := range results {
+		assert.Equal(t, StateSucceeded, op.State)
+		assert.JSONEq(t, `"done"`, string(op.Result))
+	}
+}
+
+func TestStoreConflict(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	fn := func(context.Context) (any, error) { return nil, nil }
+
+	_, err = s.Do(context.Background(), "op-1", "power", fn)
+	require.NoError(t, err)
+
+	_, err = s.Do(context.Background(), "op-1", "dhcp", fn)
+	assert.ErrorIs(t, err, ErrConflict)
+
+	_, err = s.Do(context.Background(), "", "power", fn)
+	assert.ErrorIs(t, err, ErrInvalidID)
+}
+
+func TestStoreReload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	s, err := NewStore(dir)
+	require.NoError(t, err)
+
+	_, err = s.Do(context.Background(), "done", "power",
+		func(context.Context) (any, error) { return "on", nil })
+	require.NoError(t, err)
+
+	_, _, err = s.begin("pending", "power", "")
+	require.NoError(t, err)
+
+	// Agent restarts while "pending" is in progress
+	s, err = NewStore(dir)
+	require.NoError(t, err)
+
+	op, err := s.Get("done")
+	require.NoError(t, err)
+	assert.Equal(t, StateSucceeded, op.State)
+
+	op, err = s.Get("pending")
+	require.NoError(t, err)
+	assert.Equal(t, StateInterrupted, op.State)
+
+	// Interrupted operation is not executed again
+	op, err = s.Do(context.Background(), "pending", "power",
+		func(context.Context) (any, error) { return nil, errors.New("executed") })
+	require.NoError(t, err)
+	assert.Equal(t, StateInterrupted, op.State)
+}
+
+func TestStoreRetention(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	s, err := NewStore(dir, WithRetention(time.Hour))
+	require.NoError(t, err)
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	calls := 0
+	fn := func(context.Context) (any, error) {
+		calls++
+		return calls, nil
+	}
+
+	_, err = s.Do(context.Background(), "op-1", "power", fn)
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Hour)
+
+	_, err = s.Get("op-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Expired ID can be reused
+	op, err := s.Do(context.Background(), "op-1", "power", fn)
+	require.NoError(t, err)
+	assert.JSONEq(t, "2", string(op.Result))
+}
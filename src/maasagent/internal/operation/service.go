@@ -0,0 +1,55 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package operation
+
+import (
+	"context"
+	"errors"
+)
+
+// GetOperationParam is the activity parameter for get-operation
+type GetOperationParam struct {
+	ID string `json:"id"`
+}
+
+// GetOperationResult is the result of get-operation. Operation is nil if
+// it is unknown (or expired).
+type GetOperationResult struct {
+	Operation *Operation `json:"operation"`
+}
+
+func (s *Store) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// ConfigurationActivities allows the Region to retrieve results of
+// operations on behalf of external automation.
+func (s *Store) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{"get-operation": s.getOperation}
+}
+
+func (s *Store) getOperation(_ context.Context, param GetOperationParam) (*GetOperationResult, error) {
+	op, err := s.Get(param.ID)
+	if errors.Is(err, ErrNotFound) {
+		return &GetOperationResult{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetOperationResult{Operation: &op}, nil
+}
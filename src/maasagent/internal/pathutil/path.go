@@ -23,11 +23,11 @@ import (
 // GetDataPath returns directory for MAAS data files depending on
 // MAAS installation type (snap or deb).
 func GetDataPath(path string) string {
-	dataDir := os.Getenv("SNAP_DATA")
+	snapData := os.Getenv("SNAP_DATA")
 
-	if dataDir != "" {
-		return filepath.Join(filepath.Clean(dataDir), path)
+	if snapData != "" {
+		return filepath.Join(filepath.Clean(snapData), path)
 	}
 
-	return filepath.Join("/var/lib/maas", path)
+	return filepath.Join(dataDir(), path)
 }
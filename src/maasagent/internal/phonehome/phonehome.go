@@ -0,0 +1,301 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package phonehome accepts cloud-init phone_home callbacks of deployed
+// machines and converts them into signals of the workflows waiting for
+// the machine to boot, so first boot is detected rather than guessed with
+// timeouts.
+package phonehome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maas.io/core/src/maasagent/internal/blob"
+	"maas.io/core/src/maasagent/internal/state"
+)
+
+// PathPrefix is where Service is expected to be served
+const PathPrefix = "/phone-home/"
+
+// SignalPhoneHome is the name of the signal sent to the registered workflow.
+// Signal carries PhoneHome.
+const SignalPhoneHome = "phone-home"
+
+const (
+	defaultRegistrationTimeout = time.Hour
+	// maxFormSize is a limit of the callback body, which only has hostname
+	// and SSH host keys.
+	maxFormSize = 64 << 10
+)
+
+var (
+	// ErrUnknownMachine is returned when no workflow is waiting for
+	// the machine to phone home
+	ErrUnknownMachine = errors.New("no workflow is waiting for the machine")
+	// ErrInvalidSystemID is returned when system ID cannot be used in URL
+	ErrInvalidSystemID = errors.New("invalid system ID")
+)
+
+// Signaler sends signals to workflows. It is implemented by Temporal client.
+type Signaler interface {
+	SignalWorkflow(ctx context.Context, workflowID, runID, signalName string, arg interface{}) error
+}
+
+// PhoneHome is what cloud-init reported when the machine booted
+type PhoneHome struct {
+	SystemID   string `json:"system_id"`
+	InstanceID string `json:"instance_id"`
+	Hostname   string `json:"hostname"`
+	FQDN       string `json:"fqdn"`
+	// PubKeys are SSH host keys by type, e.g. "ed25519"
+	PubKeys map[string]string `json:"pub_keys,omitempty"`
+}
+
+type registration struct {
+	expires    time.Time
+	workflowID string
+}
+
+// Service keeps workflows waiting for machines to phone home. Callbacks are
+// only accepted for registered machines, with URLs signed by the Agent.
+type Service struct {
+	signaler      Signaler
+	signer        *blob.URLSigner
+	store         *state.Store
+	registrations map[string]*registration
+	now           func() time.Time
+	systemID      string
+	mutex         sync.Mutex
+}
+
+// ServiceOption allows to set additional Service options
+type ServiceOption func(*Service)
+
+// NewService returns an instance of Service
+func NewService(systemID string, signaler Signaler, signer *blob.URLSigner, options ...ServiceOption) *Service {
+	s := &Service{
+		systemID:      systemID,
+		signaler:      signaler,
+		signer:        signer,
+		registrations: make(map[string]*registration),
+		now:           time.Now,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	if s.store != nil {
+		s.load(context.Background())
+	}
+
+	return s
+}
+
+// WithStore keeps registrations as boot sessions of store, so machines
+// deployed before the Agent restarts can still phone home.
+func WithStore(store *state.Store) ServiceOption {
+	return func(s *Service) {
+		s.store = store
+	}
+}
+
+// load restores registrations which haven't expired from the store
+func (s *Service) load(ctx context.Context) {
+	sessions, err := s.store.BootSessions(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to restore phone home registrations")
+		return
+	}
+
+	for _, b := range sessions {
+		s.registrations[b.SystemID] = &registration{workflowID: b.WorkflowID, expires: b.ExpiresAt}
+	}
+}
+
+// persist keeps the registration of the machine in the store, or removes
+// it if r is nil
+func (s *Service) persist(ctx context.Context, systemID string, r *registration) error {
+	if s.store == nil {
+		return nil
+	}
+
+	if r == nil {
+		return s.store.DeleteBootSession(ctx, systemID)
+	}
+
+	return s.store.PutBootSession(ctx, state.BootSession{
+		SystemID:   systemID,
+		WorkflowID: r.workflowID,
+		ExpiresAt:  r.expires,
+	})
+}
+
+func signatureKey(systemID string) string {
+	return strings.TrimPrefix(PathPrefix, "/") + systemID
+}
+
+// register makes workflowID the receiver of the phone home of the machine
+// and returns path and query of the signed callback URL valid for timeout.
+// Registering the machine again replaces the previous workflow.
+func (s *Service) register(ctx context.Context, systemID, workflowID string, timeout time.Duration) (string, error) {
+	if systemID == "" || strings.ContainsAny(systemID, "/\\?#") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSystemID, systemID)
+	}
+
+	if timeout <= 0 {
+		timeout = defaultRegistrationTimeout
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+
+	for k, r := range s.registrations {
+		if !now.Before(r.expires) {
+			delete(s.registrations, k)
+		}
+	}
+
+	r := &registration{
+		workflowID: workflowID,
+		expires:    now.Add(timeout),
+	}
+
+	if err := s.persist(ctx, systemID, r); err != nil {
+		return "", fmt.Errorf("failed to persist registration: %w", err)
+	}
+
+	s.registrations[systemID] = r
+
+	key := signatureKey(systemID)
+
+	return "/" + key + "?" + s.signer.Sign(key, timeout).Encode(), nil
+}
+
+// take removes and returns the registration of the machine
+func (s *Service) take(ctx context.Context, systemID string) (*registration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r, ok := s.registrations[systemID]
+	if ok {
+		delete(s.registrations, systemID)
+
+		if err := s.persist(ctx, systemID, nil); err != nil {
+			log.Warn().Err(err).Str("system_id", systemID).Msg("Failed to remove phone home registration")
+		}
+	}
+
+	if !ok || !s.now().Before(r.expires) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMachine, systemID)
+	}
+
+	return r, nil
+}
+
+// restore puts back registration which could not be signalled, so
+// the callback can be retried by cloud-init.
+func (s *Service) restore(ctx context.Context, systemID string, r *registration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.registrations[systemID]; !ok {
+		s.registrations[systemID] = r
+
+		if err := s.persist(ctx, systemID, r); err != nil {
+			log.Warn().Err(err).Str("system_id", systemID).Msg("Failed to persist phone home registration")
+		}
+	}
+}
+
+// ServeHTTP accepts POST of cloud-init phone_home to the signed URL
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	systemID := strings.TrimPrefix(r.URL.Path, PathPrefix)
+
+	if err := s.signer.Verify(signatureKey(systemID), r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reg, err := s.take(r.Context(), systemID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := s.signaler.SignalWorkflow(r.Context(), reg.workflowID, "",
+		SignalPhoneHome, parseForm(systemID, r.PostForm)); err != nil {
+		s.restore(context.WithoutCancel(r.Context()), systemID, reg)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseForm converts phone_home post_data fields into PhoneHome
+func parseForm(systemID string, form map[string][]string) PhoneHome {
+	get := func(k string) string {
+		if v := form[k]; len(v) > 0 {
+			return v[0]
+		}
+
+		return ""
+	}
+
+	p := PhoneHome{
+		SystemID:   systemID,
+		InstanceID: get("instance_id"),
+		Hostname:   get("hostname"),
+		FQDN:       get("fqdn"),
+	}
+
+	for k := range form {
+		keyType, ok := strings.CutPrefix(k, "pub_key_")
+		if !ok || get(k) == "" {
+			continue
+		}
+
+		if p.PubKeys == nil {
+			p.PubKeys = make(map[string]string)
+		}
+
+		p.PubKeys[keyType] = strings.TrimSpace(get(k))
+	}
+
+	return p
+}
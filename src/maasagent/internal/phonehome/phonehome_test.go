@@ -0,0 +1,218 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package phonehome
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/blob"
+	"maas.io/core/src/maasagent/internal/state"
+)
+
+type signal struct {
+	arg        interface{}
+	workflowID string
+	name       string
+}
+
+type fakeSignaler struct {
+	err     error
+	signals []signal
+	mutex   sync.Mutex
+}
+
+func (f *fakeSignaler) SignalWorkflow(_ context.Context, workflowID, _, name string, arg interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	f.signals = append(f.signals, signal{workflowID: workflowID, name: name, arg: arg})
+
+	return nil
+}
+
+func phoneHome(s *Service, target string, form url.Values) int {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	return w.Code
+}
+
+func TestPhoneHome(t *testing.T) {
+	signaler := &fakeSignaler{}
+	s := NewService("agent", signaler, blob.NewURLSigner([]byte("secret")))
+
+	path, err := s.register(context.Background(), "abc123", "deploy:abc123", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(path, PathPrefix+"abc123?"))
+
+	form := url.Values{
+		"instance_id":     {"abc123"},
+		"hostname":        {"node1"},
+		"fqdn":            {"node1.maas"},
+		"pub_key_ed25519": {"ssh-ed25519 AAAA root@node1\n"},
+		"pub_key_rsa":     {""},
+	}
+
+	assert.Equal(t, http.StatusOK, phoneHome(s, path, form))
+
+	require.Len(t, signaler.signals, 1)
+	assert.Equal(t, signal{
+		workflowID: "deploy:abc123",
+		name:       SignalPhoneHome,
+		arg: PhoneHome{
+			SystemID:   "abc123",
+			InstanceID: "abc123",
+			Hostname:   "node1",
+			FQDN:       "node1.maas",
+			PubKeys:    map[string]string{"ed25519": "ssh-ed25519 AAAA root@node1"},
+		},
+	}, signaler.signals[0])
+
+	// Workflow is signalled only once
+	assert.Equal(t, http.StatusNotFound, phoneHome(s, path, form))
+}
+
+func TestPhoneHomeRejected(t *testing.T) {
+	testcases := map[string]struct {
+		target func(path string) string
+		method string
+		status int
+	}{
+		"unsigned": {
+			target: func(string) string { return PathPrefix + "abc123" },
+			method: http.MethodPost,
+			status: http.StatusForbidden,
+		},
+		"other machine": {
+			target: func(path string) string {
+				return strings.Replace(path, "abc123", "def456", 1)
+			},
+			method: http.MethodPost,
+			status: http.StatusForbidden,
+		},
+		"wrong method": {
+			target: func(path string) string { return path },
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			signaler := &fakeSignaler{}
+			s := NewService("agent", signaler, blob.NewURLSigner([]byte("secret")))
+
+			path, err := s.register(context.Background(), "abc123", "deploy:abc123", time.Minute)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target(path), nil))
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Empty(t, signaler.signals)
+		})
+	}
+}
+
+func TestPhoneHomeExpired(t *testing.T) {
+	s := NewService("agent", &fakeSignaler{}, blob.NewURLSigner([]byte("secret")))
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	path, err := s.register(context.Background(), "abc123", "deploy:abc123", time.Hour)
+	require.NoError(t, err)
+
+	// Registration expired, while the signature is still valid
+	s.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	_, err = s.take(context.Background(), "abc123")
+	assert.ErrorIs(t, err, ErrUnknownMachine)
+
+	assert.Equal(t, http.StatusNotFound, phoneHome(s, path, url.Values{}))
+}
+
+func TestPhoneHomeSignalFailure(t *testing.T) {
+	signaler := &fakeSignaler{err: errors.New("boom")}
+	s := NewService("agent", signaler, blob.NewURLSigner([]byte("secret")))
+
+	path, err := s.register(context.Background(), "abc123", "deploy:abc123", time.Minute)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusBadGateway, phoneHome(s, path, url.Values{}))
+
+	// cloud-init retries the callback
+	signaler.err = nil
+
+	assert.Equal(t, http.StatusOK, phoneHome(s, path, url.Values{}))
+	assert.Len(t, signaler.signals, 1)
+}
+
+func TestPhoneHomeAfterRestart(t *testing.T) {
+	store, err := state.Open(context.Background(), filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { store.Close() })
+
+	signer := blob.NewURLSigner([]byte("secret"))
+	s := NewService("agent", &fakeSignaler{}, signer, WithStore(store))
+
+	path, err := s.register(context.Background(), "abc123", "deploy:abc123", time.Minute)
+	require.NoError(t, err)
+
+	// The Agent restarts before the machine phones home
+	signaler := &fakeSignaler{}
+	s = NewService("agent", signaler, signer, WithStore(store))
+
+	assert.Equal(t, http.StatusOK, phoneHome(s, path, url.Values{}))
+	require.Len(t, signaler.signals, 1)
+	assert.Equal(t, "deploy:abc123", signaler.signals[0].workflowID)
+
+	// The registration is gone once the machine phoned home
+	s = NewService("agent", signaler, signer, WithStore(store))
+	assert.Equal(t, http.StatusNotFound, phoneHome(s, path, url.Values{}))
+}
+
+func TestRegisterInvalidSystemID(t *testing.T) {
+	s := NewService("agent", &fakeSignaler{}, blob.NewURLSigner([]byte("secret")))
+
+	for _, id := range []string{"", "../abc", "abc?x"} {
+		_, err := s.register(context.Background(), id, "deploy", time.Minute)
+		assert.ErrorIs(t, err, ErrInvalidSystemID, id)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package phonehome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+var (
+	// ErrFirstBootTimeout is returned when the machine did not phone home
+	// in time
+	ErrFirstBootTimeout = errors.New("machine did not phone home in time")
+)
+
+// RegisterPhoneHomeParam is the activity parameter for register-phone-home
+type RegisterPhoneHomeParam struct {
+	SystemID string `json:"system_id"`
+	// WorkflowID of the workflow to signal with SignalPhoneHome
+	WorkflowID string `json:"workflow_id"`
+	// Timeout in seconds
+	Timeout int `json:"timeout"`
+}
+
+// RegisterPhoneHomeResult is the result of register-phone-home
+type RegisterPhoneHomeResult struct {
+	// CallbackPath is path and query of the signed phone_home URL on the
+	// Agent HTTP socket
+	CallbackPath string `json:"callback_path"`
+}
+
+// WaitForFirstBootParam is the parameter of wait-for-first-boot workflow
+type WaitForFirstBootParam struct {
+	SystemID string `json:"system_id"`
+	// Timeout in seconds for the machine to phone home
+	Timeout int `json:"timeout"`
+}
+
+type machinePhoneHomeParam struct {
+	SystemID     string `json:"system_id"`
+	CallbackPath string `json:"callback_path"`
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{"wait-for-first-boot": s.waitForFirstBoot}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{"register-phone-home": s.registerPhoneHome}
+}
+
+func (s *Service) registerPhoneHome(ctx context.Context,
+	param RegisterPhoneHomeParam) (*RegisterPhoneHomeResult, error) {
+	path, err := s.register(ctx, param.SystemID, param.WorkflowID,
+		time.Duration(param.Timeout)*time.Second)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return &RegisterPhoneHomeResult{CallbackPath: path}, nil
+}
+
+func regionContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              "region",
+		ScheduleToCloseTimeout: 60 * time.Second,
+	})
+}
+
+func localContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		StartToCloseTimeout: 60 * time.Second,
+	})
+}
+
+// waitForFirstBoot registers itself for the phone home of the machine,
+// hands the callback URL over to the Region to be rendered into cloud-init
+// configuration and completes once the machine has phoned home. It is meant
+// to be run as a child of the deployment workflow before the machine is
+// powered on. Deployment workflows can also register themselves with
+// register-phone-home and wait for SignalPhoneHome directly.
+func (s *Service) waitForFirstBoot(ctx tworkflow.Context, param WaitForFirstBootParam) (*PhoneHome, error) {
+	log := tworkflow.GetLogger(ctx)
+
+	timeout := defaultRegistrationTimeout
+	if param.Timeout > 0 {
+		timeout = time.Duration(param.Timeout) * time.Second
+	}
+
+	var registered RegisterPhoneHomeResult
+
+	if err := tworkflow.ExecuteActivity(localContext(ctx), "register-phone-home",
+		RegisterPhoneHomeParam{
+			SystemID:   param.SystemID,
+			WorkflowID: tworkflow.GetInfo(ctx).WorkflowExecution.ID,
+			Timeout:    int(timeout.Seconds()),
+		}).Get(ctx, &registered); err != nil {
+		return nil, err
+	}
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "set-machine-phone-home",
+		machinePhoneHomeParam{
+			SystemID:     param.SystemID,
+			CallbackPath: registered.CallbackPath,
+		}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	timerCtx, cancelTimer := tworkflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	var result *PhoneHome
+
+	selector := tworkflow.NewSelector(ctx)
+	selector.AddReceive(tworkflow.GetSignalChannel(ctx, SignalPhoneHome),
+		func(c tworkflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, &result)
+		})
+	selector.AddFuture(tworkflow.NewTimer(timerCtx, timeout), func(tworkflow.Future) {})
+
+	selector.Select(ctx)
+
+	if result == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: %s after %s", ErrFirstBootTimeout, param.SystemID, timeout)
+	}
+
+	log.Info("Machine phoned home", tag.Builder().
+		KV("system_id", param.SystemID).
+		KV("hostname", result.Hostname).KeyVals...)
+
+	return result, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package phonehome
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"maas.io/core/src/maasagent/internal/blob"
+	"maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+func machinePhoneHomeActivity(_ context.Context, _ machinePhoneHomeParam) error {
+	return nil
+}
+
+func newPhoneHomeEnvironment(s *Service) *testsuite.TestWorkflowEnvironment {
+	suite := testsuite.WorkflowTestSuite{}
+	suite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	for name, fn := range s.ConfigurationActivities() {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+
+	env.RegisterActivityWithOptions(machinePhoneHomeActivity,
+		activity.RegisterOptions{Name: "set-machine-phone-home"})
+
+	return env
+}
+
+func TestWaitForFirstBoot(t *testing.T) {
+	s := NewService("agent", &fakeSignaler{}, blob.NewURLSigner([]byte("secret")))
+	env := newPhoneHomeEnvironment(s)
+
+	env.OnActivity("set-machine-phone-home", mock.Anything, mock.MatchedBy(
+		func(p machinePhoneHomeParam) bool {
+			return p.SystemID == "abc123" && p.CallbackPath != ""
+		})).Return(nil).Once()
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalPhoneHome, PhoneHome{SystemID: "abc123", Hostname: "node1"})
+	}, 5*time.Minute)
+
+	env.ExecuteWorkflow(s.waitForFirstBoot, WaitForFirstBootParam{SystemID: "abc123", Timeout: 3600})
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	var result PhoneHome
+	require.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, "node1", result.Hostname)
+
+	env.AssertExpectations(t)
+}
+
+func TestWaitForFirstBootTimeout(t *testing.T) {
+	s := NewService("agent", &fakeSignaler{}, blob.NewURLSigner([]byte("secret")))
+	env := newPhoneHomeEnvironment(s)
+
+	env.OnActivity("set-machine-phone-home", mock.Anything, mock.Anything).Return(nil)
+
+	env.ExecuteWorkflow(s.waitForFirstBoot, WaitForFirstBootParam{SystemID: "abc123", Timeout: 60})
+
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), ErrFirstBootTimeout.Error())
+}
@@ -0,0 +1,468 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DriverAMT is the power driver of Intel AMT. Power actions are performed
+// by the Agent directly with WS-Management, without wsman or amttool.
+const DriverAMT = "amt"
+
+const (
+	defaultAMTUser    = "admin"
+	amtPortHTTP       = "16992"
+	amtPortHTTPS      = "16993"
+	amtResponseLimit  = 1 << 20
+	wsmanAnonymous    = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+	wsmanEnumeration  = "http://schemas.xmlsoap.org/ws/2004/09/enumeration"
+	cimSchema         = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/"
+	amtBootConfig     = "Intel(r) AMT: Boot Configuration 0"
+	amtBootSourcePXE  = "Intel(r) AMT: Force PXE Boot"
+	amtBootService    = "Intel(r) AMT Boot Service"
+	amtPowerService   = "Intel(r) AMT Power Management Service"
+	amtManagedSystem  = "ManagedSystem"
+	amtPowerOn        = 2
+	amtPowerCycle     = 5
+	amtPowerOff       = 8
+	amtPowerReset     = 10
+	amtPowerSoftOff   = 12
+	amtReturnSuccess  = "0"
+	amtEnumerationMax = "1"
+)
+
+// amtStates maps PowerState of CIM_AssociatedPowerManagementService to
+// power states. Sleeping and hibernated machines are reported off, as by
+// the power driver.
+var amtStates = map[string]string{
+	"2":  "on",
+	"3":  "off",
+	"4":  "off",
+	"6":  "off",
+	"7":  "off",
+	"8":  "off",
+	"9":  "off",
+	"13": "off",
+}
+
+// amtDriver performs power actions of Intel AMT. Machines are set to boot
+// from the network once, before they are powered on or power cycled.
+//
+// Power parameters are power_address (host, host:port or URL), power_user
+// ("admin" by default), power_pass and port. With port set to "https", as
+// by the power driver, the Agent connects to the TLS port (16993), verifying
+// the certificate as with power_verify_ssl and certificate_fingerprint.
+type amtDriver struct{}
+
+func (amtDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return amtPower(ctx, opts, "on")
+}
+
+func (amtDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return amtPower(ctx, opts, "off")
+}
+
+func (amtDriver) SoftOff(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return amtPower(ctx, opts, "soft-off")
+}
+
+func (amtDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return amtPower(ctx, opts, "cycle")
+}
+
+func (amtDriver) Reset(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return amtPower(ctx, opts, "reset")
+}
+
+func (amtDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return amtPower(ctx, opts, "status")
+}
+
+func amtPower(ctx context.Context, opts map[string]interface{}, action string) (string, PowerDetails, error) {
+	c, err := dialAMT(opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer c.Close()
+
+	state, err := c.state(ctx)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	var (
+		change int
+		want   string
+	)
+
+	switch action {
+	case "status":
+		return state, PowerDetails{}, nil
+	case "on", "cycle":
+		if err = c.setPXEBoot(ctx); err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		// Machines which are on are reset, so they boot from the network.
+		change, want = amtPowerOn, "on"
+		if state == "on" {
+			change = amtPowerReset
+			if action == "cycle" {
+				change = amtPowerCycle
+			}
+		}
+	case "off", "soft-off":
+		if state == "off" {
+			return state, PowerDetails{}, nil
+		}
+
+		change, want = amtPowerOff, "off"
+		if action == "soft-off" {
+			change = amtPowerSoftOff
+		}
+	case "reset":
+		change, want = amtPowerReset, "on"
+	default:
+		return "", PowerDetails{}, fmt.Errorf("%w: %q", ErrUnsupportedPowerAction, action)
+	}
+
+	if err = c.requestPowerStateChange(ctx, change); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	if action == "soft-off" {
+		return state, PowerDetails{}, nil
+	}
+
+	return c.waitPowerState(ctx, want)
+}
+
+// amtConn is a WS-Management client of Intel AMT
+type amtConn struct {
+	client   *http.Client
+	endpoint string
+}
+
+func dialAMT(opts map[string]interface{}) (*amtConn, error) {
+	tlsEnabled := stringOpt(opts, "port") == "https"
+
+	address := stringOpt(opts, "power_address")
+	if !strings.Contains(address, "://") {
+		scheme, port := "http", amtPortHTTP
+		if tlsEnabled {
+			scheme, port = "https", amtPortHTTPS
+		}
+
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(strings.Trim(address, "[]"), port)
+		}
+
+		address = scheme + "://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/wsman"
+	}
+
+	tlsConfig, err := verifiedTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	user := stringOpt(opts, "power_user")
+	if user == "" {
+		user = defaultAMTUser
+	}
+
+	return &amtConn{
+		endpoint: u.String(),
+		client: &http.Client{
+			Transport: &digestTransport{
+				transport: &http.Transport{TLSClientConfig: tlsConfig},
+				user:      user,
+				pass:      stringOpt(opts, "power_pass"),
+			},
+		},
+	}, nil
+}
+
+// state returns the power state of the machine
+func (c *amtConn) state(ctx context.Context) (string, error) {
+	resource := cimSchema + "CIM_AssociatedPowerManagementService"
+
+	resp, err := c.invoke(ctx, wsmanEnumeration+"/Enumerate", resource, nil,
+		`<wsen:Enumerate xmlns:wsen="`+wsmanEnumeration+`">`+
+			`<wsman:OptimizeEnumeration/><wsman:MaxElements>`+amtEnumerationMax+`</wsman:MaxElements>`+
+			`</wsen:Enumerate>`)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := xmlValue(resp, "PowerState")
+	if !ok {
+		enumCtx, ok := xmlValue(resp, "EnumerationContext")
+		if !ok {
+			return "", fmt.Errorf("%w: no power state", ErrUnexpectedResponse)
+		}
+
+		resp, err = c.invoke(ctx, wsmanEnumeration+"/Pull", resource, nil,
+			`<wsen:Pull xmlns:wsen="`+wsmanEnumeration+`">`+
+				`<wsen:EnumerationContext>`+xmlEscape(enumCtx)+`</wsen:EnumerationContext>`+
+				`<wsen:MaxElements>`+amtEnumerationMax+`</wsen:MaxElements>`+
+				`</wsen:Pull>`)
+		if err != nil {
+			return "", err
+		}
+
+		if value, ok = xmlValue(resp, "PowerState"); !ok {
+			return "", fmt.Errorf("%w: no power state", ErrUnexpectedResponse)
+		}
+	}
+
+	if state, ok := amtStates[value]; ok {
+		return state, nil
+	}
+
+	return "unknown", nil
+}
+
+// waitPowerState polls the power state of the machine until it is want, or
+// powerActionWait passes, in which case the current state is returned.
+func (c *amtConn) waitPowerState(ctx context.Context, want string) (string, PowerDetails, error) {
+	deadline := time.Now().Add(powerActionWait)
+
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		state, err := c.state(ctx)
+		if err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		if state == want || time.Now().After(deadline) {
+			return state, PowerDetails{}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", PowerDetails{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *amtConn) requestPowerStateChange(ctx context.Context, state int) error {
+	resource := cimSchema + "CIM_PowerManagementService"
+
+	return c.call(ctx, resource, "RequestPowerStateChange",
+		map[string]string{"Name": amtPowerService},
+		`<p:PowerState>`+strconv.Itoa(state)+`</p:PowerState>`+
+			`<p:ManagedElement>`+
+			wsmanReference(cimSchema+"CIM_ComputerSystem", map[string]string{
+				"CreationClassName": "CIM_ComputerSystem",
+				"Name":              amtManagedSystem,
+			})+
+			`</p:ManagedElement>`)
+}
+
+// setPXEBoot makes the machine boot from the network once
+func (c *amtConn) setPXEBoot(ctx context.Context) error {
+	bootConfig := cimSchema + "CIM_BootConfigSetting"
+
+	if err := c.call(ctx, bootConfig, "ChangeBootOrder",
+		map[string]string{"InstanceID": amtBootConfig},
+		`<p:Source>`+
+			wsmanReference(cimSchema+"CIM_BootSourceSetting",
+				map[string]string{"InstanceID": amtBootSourcePXE})+
+			`</p:Source>`); err != nil {
+		return err
+	}
+
+	return c.call(ctx, cimSchema+"CIM_BootService", "SetBootConfigRole",
+		map[string]string{"Name": amtBootService},
+		`<p:BootConfigSetting>`+
+			wsmanReference(bootConfig, map[string]string{"InstanceID": amtBootConfig})+
+			`</p:BootConfigSetting><p:Role>1</p:Role>`)
+}
+
+// call invokes method of resource, failing unless it returns success
+func (c *amtConn) call(ctx context.Context, resource, method string,
+	selectors map[string]string, input string) error {
+	resp, err := c.invoke(ctx, resource+"/"+method, resource, selectors,
+		`<p:`+method+`_INPUT xmlns:p="`+resource+`">`+input+`</p:`+method+`_INPUT>`)
+	if err != nil {
+		return err
+	}
+
+	if value, _ := xmlValue(resp, "ReturnValue"); value != amtReturnSuccess {
+		return fmt.Errorf("%w: %s returned %q", ErrUnexpectedResponse, method, value)
+	}
+
+	return nil
+}
+
+// invoke sends a WS-Management request and returns the response envelope
+func (c *amtConn) invoke(ctx context.Context, action, resource string,
+	selectors map[string]string, body string) ([]byte, error) {
+	var b bytes.Buffer
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:wsman="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">` +
+		`<s:Header>` +
+		`<wsa:Action s:mustUnderstand="true">` + xmlEscape(action) + `</wsa:Action>` +
+		`<wsa:To s:mustUnderstand="true">` + xmlEscape(c.endpoint) + `</wsa:To>` +
+		`<wsman:ResourceURI s:mustUnderstand="true">` + xmlEscape(resource) + `</wsman:ResourceURI>` +
+		`<wsa:MessageID s:mustUnderstand="true">uuid:` + messageID() + `</wsa:MessageID>` +
+		`<wsa:ReplyTo><wsa:Address>` + wsmanAnonymous + `</wsa:Address></wsa:ReplyTo>`)
+
+	if len(selectors) > 0 {
+		b.WriteString(wsmanSelectorSet(selectors))
+	}
+
+	b.WriteString(`</s:Header><s:Body>` + body + `</s:Body></s:Envelope>`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, &b)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(io.LimitReader(resp.Body, amtResponseLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	if reason, ok := xmlValue(out, "Text"); ok && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s: %s", ErrUnexpectedResponse, action, reason)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s: %s", responseError(resp.StatusCode), action, resp.Status)
+	}
+
+	return out, nil
+}
+
+func (c *amtConn) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// wsmanReference returns endpoint reference of an instance of resource
+func wsmanReference(resource string, selectors map[string]string) string {
+	return `<wsa:Address>` + wsmanAnonymous + `</wsa:Address>` +
+		`<wsa:ReferenceParameters>` +
+		`<wsman:ResourceURI>` + xmlEscape(resource) + `</wsman:ResourceURI>` +
+		wsmanSelectorSet(selectors) +
+		`</wsa:ReferenceParameters>`
+}
+
+// wsmanSelectorSet returns selectors ordered by name, so requests are
+// reproducible
+func wsmanSelectorSet(selectors map[string]string) string {
+	names := make([]string, 0, len(selectors))
+	for name := range selectors {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	var b strings.Builder
+
+	b.WriteString(`<wsman:SelectorSet>`)
+
+	for _, name := range names {
+		b.WriteString(`<wsman:Selector Name="` + xmlEscape(name) + `">` +
+			xmlEscape(selectors[name]) + `</wsman:Selector>`)
+	}
+
+	b.WriteString(`</wsman:SelectorSet>`)
+
+	return b.String()
+}
+
+// xmlValue returns text of the first element with local name in doc
+func xmlValue(doc []byte, name string) (string, bool) {
+	d := xml.NewDecoder(bytes.NewReader(doc))
+
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return "", false
+		}
+
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == name {
+			var value string
+			if err := d.DecodeElement(&value, &start); err != nil {
+				return "", false
+			}
+
+			return strings.TrimSpace(value), true
+		}
+	}
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	//nolint:errcheck // strings.Builder never returns an error
+	xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
+
+// messageID returns a random UUID of a WS-Management message
+func messageID() string {
+	b := make([]byte, 16)
+	//nolint:errcheck // crypto/rand never fails on supported platforms
+	rand.Read(b)
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
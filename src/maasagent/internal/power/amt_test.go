@@ -0,0 +1,280 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // required by HTTP digest authentication
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fakeAMTNonce = "8b3f0a5c"
+
+// fakeAMT serves WS-Management of Intel AMT behind digest authentication
+type fakeAMT struct {
+	powerState string
+	// calls are methods invoked, with the requested power state
+	calls []string
+	// challenges is how many requests were challenged
+	challenges int
+	mutex      sync.Mutex
+}
+
+func md5Hex(s string) string {
+	//nolint:gosec // required by HTTP digest authentication
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (f *fakeAMT) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		return false
+	}
+
+	c, err := parseDigestChallenge(auth)
+	if err != nil || c.nonce != fakeAMTNonce {
+		return false
+	}
+
+	fields := make(map[string]string)
+
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "Digest "), ", ") {
+		k, v, _ := strings.Cut(field, "=")
+		fields[k] = strings.Trim(v, `"`)
+	}
+
+	ha1 := md5Hex("admin:Digest:AMT:P@ssw0rd")
+	ha2 := md5Hex(r.Method + ":" + fields["uri"])
+
+	return fields["username"] == "admin" && fields["response"] ==
+		md5Hex(strings.Join([]string{ha1, fakeAMTNonce, fields["nc"], fields["cnonce"], "auth", ha2}, ":"))
+}
+
+func (f *fakeAMT) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	body, _ := io.ReadAll(r.Body) //nolint:errcheck // the body is checked below
+
+	if !f.authorized(r) {
+		f.challenges++
+
+		w.Header().Set("WWW-Authenticate",
+			`Digest realm="Digest:AMT", nonce="`+fakeAMTNonce+`", stale="false", qop="auth"`)
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	action, _ := xmlValue(body, "Action")
+	method := action[strings.LastIndex(action, "/")+1:]
+
+	switch method {
+	case "Enumerate":
+		fmt.Fprintf(w, `<a:Envelope xmlns:a="http://www.w3.org/2003/05/soap-envelope"><a:Body>`+
+			`<g:EnumerateResponse><g:EnumerationContext>01000000</g:EnumerationContext>`+
+			`<h:Items><h:CIM_AssociatedPowerManagementService><h:PowerState>%s</h:PowerState>`+
+			`</h:CIM_AssociatedPowerManagementService></h:Items></g:EnumerateResponse>`+
+			`</a:Body></a:Envelope>`, f.powerState)
+
+		return
+	case "RequestPowerStateChange":
+		state, _ := xmlValue(body, "PowerState")
+		f.calls = append(f.calls, method+" "+state)
+
+		switch state {
+		case "2", "5", "10":
+			f.powerState = "2"
+		case "8":
+			f.powerState = "8"
+		}
+	case "ChangeBootOrder":
+		// The first selector is of the boot configuration, in the header
+		_, input, _ := strings.Cut(string(body), "<s:Body>")
+		source, _ := xmlValue([]byte(input), "Selector")
+		f.calls = append(f.calls, method+" "+source)
+	case "SetBootConfigRole":
+		f.calls = append(f.calls, method)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		//nolint:errcheck // the client fails anyway
+		w.Write([]byte(`<a:Envelope xmlns:a="http://www.w3.org/2003/05/soap-envelope"><a:Body><a:Fault>` +
+			`<a:Reason><a:Text>The action is not supported</a:Text></a:Reason></a:Fault></a:Body></a:Envelope>`))
+
+		return
+	}
+
+	fmt.Fprint(w, `<a:Envelope xmlns:a="http://www.w3.org/2003/05/soap-envelope"><a:Body>`+
+		`<g:`+method+`_OUTPUT><g:ReturnValue>0</g:ReturnValue></g:`+method+`_OUTPUT></a:Body></a:Envelope>`)
+}
+
+func TestAMTDriver(t *testing.T) {
+	testcases := map[string]struct {
+		action     string
+		powerState string
+		state      string
+		calls      []string
+	}{
+		"on": {
+			action:     "on",
+			powerState: "8",
+			state:      "on",
+			calls: []string{"ChangeBootOrder Intel(r) AMT: Force PXE Boot", "SetBootConfigRole",
+				"RequestPowerStateChange 2"},
+		},
+		"on when already on": {
+			action:     "on",
+			powerState: "2",
+			state:      "on",
+			calls: []string{"ChangeBootOrder Intel(r) AMT: Force PXE Boot", "SetBootConfigRole",
+				"RequestPowerStateChange 10"},
+		},
+		"cycle": {
+			action:     "cycle",
+			powerState: "2",
+			state:      "on",
+			calls: []string{"ChangeBootOrder Intel(r) AMT: Force PXE Boot", "SetBootConfigRole",
+				"RequestPowerStateChange 5"},
+		},
+		"off": {
+			action:     "off",
+			powerState: "2",
+			state:      "off",
+			calls:      []string{"RequestPowerStateChange 8"},
+		},
+		"already off": {
+			action:     "off",
+			powerState: "6",
+			state:      "off",
+		},
+		"soft off": {
+			action:     "soft-off",
+			powerState: "2",
+			state:      "on",
+			calls:      []string{"RequestPowerStateChange 12"},
+		},
+		"status": {
+			action:     "status",
+			powerState: "4",
+			state:      "off",
+		},
+		"unknown": {
+			action:     "status",
+			powerState: "0",
+			state:      "unknown",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			amt := &fakeAMT{powerState: tc.powerState}
+
+			srv := httptest.NewServer(amt)
+			defer srv.Close()
+
+			state, _, err := amtPower(context.Background(), map[string]interface{}{
+				"power_address": strings.TrimPrefix(srv.URL, "http://"),
+				"power_pass":    "P@ssw0rd",
+			}, tc.action)
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, state)
+			assert.Equal(t, tc.calls, amt.calls)
+			assert.Equal(t, 1, amt.challenges)
+		})
+	}
+}
+
+func TestAMTDriverFault(t *testing.T) {
+	srv := httptest.NewServer(&fakeAMT{powerState: "2"})
+	defer srv.Close()
+
+	c, err := dialAMT(map[string]interface{}{"power_address": srv.URL, "power_pass": "P@ssw0rd"})
+	require.NoError(t, err)
+
+	_, err = c.invoke(context.Background(), "Unsupported", cimSchema+"CIM_ComputerSystem", nil, "")
+	assert.ErrorIs(t, err, ErrUnexpectedResponse)
+	assert.ErrorContains(t, err, "The action is not supported")
+}
+
+func TestAMTDriverWrongPassword(t *testing.T) {
+	srv := httptest.NewServer(&fakeAMT{powerState: "2"})
+	defer srv.Close()
+
+	_, _, err := amtPower(context.Background(), map[string]interface{}{
+		"power_address": srv.URL,
+		"power_pass":    "wrong",
+	}, "status")
+	assert.ErrorIs(t, err, ErrUnexpectedResponse)
+}
+
+func TestDialAMT(t *testing.T) {
+	testcases := map[string]struct {
+		opts     map[string]interface{}
+		endpoint string
+	}{
+		"host": {
+			opts:     map[string]interface{}{"power_address": "10.0.0.1"},
+			endpoint: "http://10.0.0.1:16992/wsman",
+		},
+		"http": {
+			opts:     map[string]interface{}{"power_address": "10.0.0.1", "port": "http"},
+			endpoint: "http://10.0.0.1:16992/wsman",
+		},
+		"https": {
+			opts:     map[string]interface{}{"power_address": "10.0.0.1", "port": "https"},
+			endpoint: "https://10.0.0.1:16993/wsman",
+		},
+		"https with port": {
+			opts:     map[string]interface{}{"power_address": "10.0.0.1:8443", "port": "https"},
+			endpoint: "https://10.0.0.1:8443/wsman",
+		},
+		"port": {
+			opts:     map[string]interface{}{"power_address": "[fd00::1]:8080"},
+			endpoint: "http://[fd00::1]:8080/wsman",
+		},
+		"url": {
+			opts:     map[string]interface{}{"power_address": "https://amt.example.com/wsman"},
+			endpoint: "https://amt.example.com/wsman",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c, err := dialAMT(tc.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.endpoint, c.endpoint)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DriverAPC is the power driver of APC rack PDUs. Outlets are switched by
+// the Agent directly with SNMP.
+const DriverAPC = "apc"
+
+const (
+	apcPDUTypeRPDU         = "RPDU"
+	apcPDUTypeMasterSwitch = "MASTERSWITCH"
+	// defaultAPCCommunity is the community of SNMPv1 and SNMPv2c requests,
+	// as used by the power driver
+	defaultAPCCommunity = "private"
+)
+
+// apcOutlets maps outlets of PDU types to their control tables, which are
+// indexed by the outlet number (rPDUOutletControlOutletCommand and
+// sPDUOutletCtl of PowerNet-MIB). Values are both commands and states.
+var apcOutlets = map[string]snmpOutletMap{
+	apcPDUTypeRPDU: {
+		oids: indexedOutlets("1.3.6.1.4.1.318.1.1.12.3.3.1.1.4",
+			"1.3.6.1.4.1.318.1.1.12.3.3.1.1.4"),
+		states: map[int64]string{1: "on", 2: "off"},
+		on:     1,
+		off:    2,
+	},
+	apcPDUTypeMasterSwitch: {
+		oids: indexedOutlets("1.3.6.1.4.1.318.1.1.4.4.2.1.3",
+			"1.3.6.1.4.1.318.1.1.4.4.2.1.3"),
+		states: map[int64]string{1: "on", 2: "off"},
+		on:     1,
+		off:    2,
+	},
+}
+
+// dialAPC returns pduClient of the APC PDU with pdu_type ("RPDU" by
+// default, or "MASTERSWITCH")
+func dialAPC(ctx context.Context, opts map[string]interface{}) (pduClient, error) {
+	pduType := strings.ToUpper(stringOpt(opts, "pdu_type"))
+	if pduType == "" {
+		pduType = apcPDUTypeRPDU
+	}
+
+	outlets, ok := apcOutlets[pduType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedPDUType, pduType)
+	}
+
+	return dialSNMPPDU(ctx, opts, outlets, defaultAPCCommunity)
+}
@@ -0,0 +1,356 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	lxdapi "github.com/canonical/lxd/shared/api"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+const (
+	defaultQueryBatchWindow = 100 * time.Millisecond
+	batchQueryTimeout       = 60 * time.Second
+	defaultLXDPort          = "8443"
+)
+
+var (
+	// ErrInstanceNotFound is returned when a batched query didn't return
+	// state of the requested VM
+	ErrInstanceNotFound = errcode.New(errcode.PowerNotFound, "instance not found on the host")
+	// ErrUnexpectedResponse is returned when a virtualization host returned
+	// a response that cannot be parsed
+	ErrUnexpectedResponse = errcode.New(errcode.PowerInvalidResponse, "unexpected response")
+	// ErrAuthFailed is returned when a BMC or virtualization host rejected
+	// credentials of the request
+	ErrAuthFailed = errcode.New(errcode.PowerAuthFailed, "authentication failed")
+)
+
+// responseError returns the error of an HTTP response with status, which
+// is not what was expected. Rejected credentials are still unexpected
+// responses.
+func responseError(status int) error {
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return fmt.Errorf("%w: %w", ErrAuthFailed, ErrUnexpectedResponse)
+	}
+
+	return ErrUnexpectedResponse
+}
+
+// hostLister returns power states of all VMs of a virtualization host
+type hostLister func(ctx context.Context, opts map[string]interface{}) (map[string]string, error)
+
+// batchKey returns a key identifying the host (and credentials) together
+// with VM name. ok is false if driver options cannot be handled in a batch.
+type batchKey func(opts map[string]interface{}) (host string, instance string, ok bool)
+
+type batchDriver struct {
+	list hostLister
+	key  batchKey
+	// instanceOpt is the driver option naming the VM on the host
+	instanceOpt string
+}
+
+type queryBatch struct {
+	done   chan struct{}
+	states map[string]string
+	err    error
+}
+
+// queryBatcher coalesces power queries of VMs living on the same host that
+// arrive within a short window into a single call listing all VMs, instead
+// of opening one connection per VM.
+type queryBatcher struct {
+	drivers map[string]batchDriver
+	pending map[string]*queryBatch
+	window  time.Duration
+	mutex   sync.Mutex
+}
+
+// newHostListers returns drivers listing VMs of virtualization hosts,
+// which keep connections to hosts open for subsequent listings.
+func newHostListers(members *lxdMemberCache) map[string]batchDriver {
+	virshPool := newConnPool[*virshConn](defaultConnIdleTimeout, defaultConnHealthPeriod)
+	lxdPool := newConnPool[*lxdConn](defaultConnIdleTimeout, defaultConnHealthPeriod)
+
+	return map[string]batchDriver{
+		"virsh": {
+			list: func(ctx context.Context, opts map[string]interface{}) (map[string]string, error) {
+				return listVirshDomains(ctx, virshPool, opts)
+			},
+			key:         virshBatchKey,
+			instanceOpt: "power_id",
+		},
+		"lxd": {
+			list: func(ctx context.Context, opts map[string]interface{}) (map[string]string, error) {
+				return listLXDInstances(ctx, lxdPool, members, opts)
+			},
+			key:         lxdBatchKey,
+			instanceOpt: "instance_name",
+		},
+	}
+}
+
+func newQueryBatcher(window time.Duration, drivers map[string]batchDriver) *queryBatcher {
+	return &queryBatcher{
+		drivers: drivers,
+		pending: make(map[string]*queryBatch),
+		window:  window,
+	}
+}
+
+// query returns power state of the VM. ok is false if the query cannot be
+// batched and should be executed as usual.
+func (b *queryBatcher) query(ctx context.Context, driverType string,
+	opts map[string]interface{}) (string, bool, error) {
+	d, ok := b.drivers[driverType]
+	if !ok {
+		return "", false, nil
+	}
+
+	host, instance, ok := d.key(opts)
+	if !ok {
+		return "", false, nil
+	}
+
+	key := driverType + "\x00" + host
+
+	b.mutex.Lock()
+
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &queryBatch{done: make(chan struct{})}
+		b.pending[key] = batch
+
+		go b.run(key, batch, d.list, opts)
+	}
+
+	b.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return "", true, ctx.Err()
+	case <-batch.done:
+	}
+
+	if batch.err != nil {
+		return "", true, batch.err
+	}
+
+	state, ok := batch.states[instance]
+	if !ok {
+		return "", true, fmt.Errorf("%w: %s", ErrInstanceNotFound, instance)
+	}
+
+	return state, true, nil
+}
+
+func (b *queryBatcher) run(key string, batch *queryBatch, list hostLister, opts map[string]interface{}) {
+	time.Sleep(b.window)
+
+	// Queries arriving from now on will start a new batch
+	b.mutex.Lock()
+	delete(b.pending, key)
+	b.mutex.Unlock()
+
+	// Batch is shared, so it must not be cancelled with any of the callers
+	ctx, cancel := context.WithTimeout(context.Background(), batchQueryTimeout)
+	defer cancel()
+
+	batch.states, batch.err = list(ctx, opts)
+
+	close(batch.done)
+}
+
+func stringOpt(opts map[string]interface{}, key string) string {
+	v, _ := opts[key].(string) //nolint:errcheck // missing options are empty
+	return v
+}
+
+func virshBatchKey(opts map[string]interface{}) (string, string, bool) {
+	address, domain := stringOpt(opts, "power_address"), stringOpt(opts, "power_id")
+
+	// virsh cannot be given a password non-interactively, such hosts are
+	// handled by the power driver.
+	if address == "" || domain == "" || stringOpt(opts, "power_pass") != "" {
+		return "", "", false
+	}
+
+	return address + "\x00" + stringOpt(opts, optHostKey), domain, true
+}
+
+func listVirshDomains(ctx context.Context, pool *connPool[*virshConn],
+	opts map[string]interface{}) (map[string]string, error) {
+	uri := stringOpt(opts, "power_address")
+
+	if hostKey := stringOpt(opts, optHostKey); hostKey != "" {
+		var err error
+
+		uri, err = pinnedVirshURI(uri, hostKey, knownHostsDir())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out []string
+
+	// The pinned URI refers to a known_hosts file named after the host key,
+	// so connections with different pins are never shared.
+	err := pool.use(ctx, uri,
+		func(ctx context.Context) (*virshConn, error) { return dialVirsh(ctx, uri) },
+		func(c *virshConn) error {
+			var err error
+
+			out, err = c.run(ctx, "list --all")
+			if err == nil && len(out) == 0 {
+				// Errors are printed to stderr, the table header is always printed
+				err = fmt.Errorf("%w: virsh list returned nothing", ErrUnexpectedResponse)
+			}
+
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseVirshList([]byte(strings.Join(out, "\n"))), nil
+}
+
+// parseVirshList parses output of `virsh list --all`:
+//
+//	 Id   Name      State
+//	--------------------------
+//	 1    vm1       running
+//	 -    vm2       shut off
+func parseVirshList(out []byte) map[string]string {
+	states := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] == "Id" {
+			continue
+		}
+
+		states[fields[1]] = virshPowerState(strings.Join(fields[2:], " "))
+	}
+
+	return states
+}
+
+func virshPowerState(state string) string {
+	switch state {
+	case "running", "idle", "paused", "in shutdown", "blocked":
+		return "on"
+	}
+
+	return "off"
+}
+
+func lxdBatchKey(opts map[string]interface{}) (string, string, bool) {
+	address, instance := stringOpt(opts, "power_address"), stringOpt(opts, "instance_name")
+	cert, key := stringOpt(opts, "certificate"), stringOpt(opts, "key")
+
+	if address == "" || instance == "" || cert == "" || key == "" {
+		return "", "", false
+	}
+
+	return strings.Join([]string{address, lxdProject(opts), cert,
+		stringOpt(opts, optCertFingerprint)}, "\x00"), instance, true
+}
+
+func lxdProject(opts map[string]interface{}) string {
+	if project := stringOpt(opts, "project"); project != "" {
+		return project
+	}
+
+	return "default"
+}
+
+func lxdURL(address string) (*url.URL, error) {
+	// Bare IPv6 address would be ambiguous otherwise
+	if net.ParseIP(address) != nil {
+		address = net.JoinHostPort(address, defaultLXDPort)
+	}
+
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultLXDPort)
+	}
+
+	return u, nil
+}
+
+func listLXDInstances(ctx context.Context, pool *connPool[*lxdConn],
+	members *lxdMemberCache, opts map[string]interface{}) (map[string]string, error) {
+	key, _, _ := lxdBatchKey(opts)
+
+	var states map[string]string
+
+	err := pool.use(ctx, key,
+		func(ctx context.Context) (*lxdConn, error) { return dialLXDCluster(ctx, opts, members) },
+		func(c *lxdConn) error {
+			var err error
+
+			states, err = c.instances(ctx, lxdProject(opts))
+
+			return err
+		})
+
+	return states, err
+}
+
+// lxdInstanceStates returns power states of instances by their names
+func lxdInstanceStates(instances []lxdapi.Instance) map[string]string {
+	states := make(map[string]string, len(instances))
+
+	for _, i := range instances {
+		states[i.Name] = lxdPowerState(i.Status)
+	}
+
+	return states
+}
+
+func lxdPowerState(status string) string {
+	switch status {
+	case "Running", "Frozen":
+		return "on"
+	case "Error":
+		// Instances on offline cluster members are reported with
+		// Error status, their actual power state is not known.
+		return "unknown"
+	default:
+		return "off"
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lxdapi "github.com/canonical/lxd/shared/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryBatcher(t *testing.T) {
+	var calls atomic.Int32
+
+	b := newQueryBatcher(50*time.Millisecond, map[string]batchDriver{})
+	b.drivers["virsh"] = batchDriver{
+		key: virshBatchKey,
+		list: func(_ context.Context, _ map[string]interface{}) (map[string]string, error) {
+			calls.Add(1)
+			return map[string]string{"vm0": "on", "vm1": "off", "vm2": "on", "vm3": "unknown"}, nil
+		},
+	}
+
+	var wg sync.WaitGroup
+
+	states := make([]string, 3)
+	errs := make([]error, 3)
+
+	for i := range states {
+		i := i
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			states[i], _, errs[i] = b.query(context.Background(), "virsh", map[string]interface{}{
+				"power_address": "qemu+ssh://ubuntu@host/system",
+				"power_id":      "vm" + string(rune('0'+i)),
+			})
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"on", "off", "on"}, states)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Unknown instance
+	_, ok, err := b.query(context.Background(), "virsh", map[string]interface{}{
+		"power_address": "qemu+ssh://ubuntu@host/system",
+		"power_id":      "missing",
+	})
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestQueryBatcherNotBatchable(t *testing.T) {
+	b := newQueryBatcher(time.Millisecond, newHostListers(nil))
+
+	testcases := map[string]struct {
+		driver string
+		opts   map[string]interface{}
+	}{
+		"unsupported driver": {
+			driver: "ipmi",
+			opts:   map[string]interface{}{"power_address": "10.0.0.1"},
+		},
+		"virsh with password": {
+			driver: "virsh",
+			opts: map[string]interface{}{
+				"power_address": "qemu+ssh://ubuntu@host/system",
+				"power_id":      "vm0",
+				"power_pass":    "secret",
+			},
+		},
+		"lxd without certificate": {
+			driver: "lxd",
+			opts: map[string]interface{}{
+				"power_address": "10.0.0.1",
+				"instance_name": "vm0",
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, ok, err := b.query(context.Background(), tc.driver, tc.opts)
+			assert.False(t, ok)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseVirshList(t *testing.T) {
+	out := ` Id   Name        State
+------------------------------
+ 1    vm-running  running
+ 2    vm-paused   paused
+ -    vm-off      shut off
+
+`
+
+	assert.Equal(t, map[string]string{
+		"vm-running": "on",
+		"vm-paused":  "on",
+		"vm-off":     "off",
+	}, parseVirshList([]byte(out)))
+}
+
+func TestLXDInstanceStates(t *testing.T) {
+	instances := []lxdapi.Instance{
+		{Name: "vm0", Status: "Running"},
+		{Name: "vm1", Status: "Stopped"},
+		{Name: "vm2", Status: "Frozen"},
+		{Name: "vm3", Status: "Error"},
+	}
+
+	assert.Equal(t, map[string]string{"vm0": "on", "vm1": "off", "vm2": "on", "vm3": "unknown"},
+		lxdInstanceStates(instances))
+}
+
+func TestLXDURL(t *testing.T) {
+	testcases := map[string]string{
+		"10.0.0.1":                 "https://10.0.0.1:8443",
+		"10.0.0.1:9443":            "https://10.0.0.1:9443",
+		"https://lxd.example.com":  "https://lxd.example.com:8443",
+		"fd00::1":                  "https://[fd00::1]:8443",
+		"https://[fd00::1]:8443/x": "https://[fd00::1]:8443/x",
+	}
+
+	for in, out := range testcases {
+		in, out := in, out
+
+		t.Run(in, func(t *testing.T) {
+			t.Parallel()
+
+			u, err := lxdURL(in)
+			require.NoError(t, err)
+			assert.Equal(t, out, u.String())
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"go.temporal.io/sdk/activity"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+var (
+	// ErrUnsupportedBootOrder is returned when the persistent boot order
+	// of the machine can't be read or set by its power driver
+	ErrUnsupportedBootOrder = errcode.New(errcode.PowerUnsupported, "persistent boot order is not supported")
+)
+
+// BootOption is an entry of the persistent boot order of a machine
+type BootOption struct {
+	// ID identifies the option on the BMC (e.g. Redfish "Boot0001")
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Device is BootDevicePXE, BootDeviceDisk, BootDeviceCD or empty
+	// if the kind of the option is not known
+	Device string `json:"device,omitempty"`
+}
+
+// BootOrderPowerDriver is implemented by drivers which can read and set the
+// persistent boot order of the machine. ApplyBootOrder is called with IDs
+// of options returned by BootOrder, or devices if there were none.
+type BootOrderPowerDriver interface {
+	PowerDriver
+	BootOrder(ctx context.Context, opts map[string]interface{}) ([]BootOption, error)
+	ApplyBootOrder(ctx context.Context, opts map[string]interface{}, order []string) error
+}
+
+// desiredBootOrder returns current boot order with options of devices moved
+// to the front, in the order of devices. Other options keep their order.
+// If current is not known, the boot order consists of devices only.
+func desiredBootOrder(current []BootOption, devices []string) []BootOption {
+	if len(current) == 0 {
+		res := make([]BootOption, len(devices))
+		for i, d := range devices {
+			res[i] = BootOption{ID: d, Device: d}
+		}
+
+		return res
+	}
+
+	res := make([]BootOption, 0, len(current))
+
+	for _, d := range devices {
+		for _, o := range current {
+			if o.Device == d {
+				res = append(res, o)
+			}
+		}
+	}
+
+	for _, o := range current {
+		if !slices.Contains(devices, o.Device) {
+			res = append(res, o)
+		}
+	}
+
+	return res
+}
+
+func bootOptionIDs(options []BootOption) []string {
+	ids := make([]string, len(options))
+	for i, o := range options {
+		ids[i] = o.ID
+	}
+
+	return ids
+}
+
+// GetBootOrderParam is the activity parameter for get-persistent-boot-order
+type GetBootOrderParam struct {
+	PowerParam
+}
+
+// GetBootOrderResult is the result of get-persistent-boot-order
+type GetBootOrderResult struct {
+	Order []BootOption `json:"order"`
+}
+
+// ApplyBootOrderParam is the activity parameter for apply-persistent-boot-order
+type ApplyBootOrderParam struct {
+	PowerParam
+	// Devices which should come first in the boot order, e.g. BootDevicePXE
+	// followed by BootDeviceDisk for "network first" policy
+	Devices []string `json:"devices"`
+	// DryRun only reports the difference
+	DryRun bool `json:"dry_run"`
+}
+
+// BootOrderDiff is the result of apply-persistent-boot-order
+type BootOrderDiff struct {
+	Current []BootOption `json:"current"`
+	Desired []BootOption `json:"desired"`
+	// Changed is true when the current boot order differs from the desired one
+	Changed bool `json:"changed"`
+	// Applied is true when the desired boot order was set
+	Applied bool `json:"applied"`
+}
+
+// bootOrderDriver returns the driver of the machine, which can manage its
+// persistent boot order, and driver options it should be called with.
+func (s *PowerService) bootOrderDriver(ctx context.Context,
+	param PowerParam) (BootOrderPowerDriver, map[string]interface{}, error) {
+	opts, err := withBootMode(s.driverOpts(ctx, param.DriverType, param.DriverOpts), param.BootMode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, ok := s.drivers.Lookup(param.DriverType, opts)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s driver", ErrUnsupportedBootOrder, param.DriverType)
+	}
+
+	b, ok := d.(BootOrderPowerDriver)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s driver", ErrUnsupportedBootOrder, param.DriverType)
+	}
+
+	return b, opts, nil
+}
+
+// GetBootOrder returns the persistent boot order of the machine
+func (s *PowerService) GetBootOrder(ctx context.Context, param GetBootOrderParam) (*GetBootOrderResult, error) {
+	d, opts, err := s.bootOrderDriver(ctx, param.PowerParam)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.commandContext(ctx, param.PowerParam)
+	defer cancel()
+
+	order, err := d.BootOrder(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetBootOrderResult{Order: order}, nil
+}
+
+// ApplyBootOrder compares the persistent boot order of the machine with
+// the one having devices first, and sets the latter if they differ.
+func (s *PowerService) ApplyBootOrder(ctx context.Context, param ApplyBootOrderParam) (*BootOrderDiff, error) {
+	log := activity.GetLogger(ctx)
+
+	for _, device := range param.Devices {
+		switch device {
+		case BootDevicePXE, BootDeviceDisk, BootDeviceCD:
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedBootDevice, device)
+		}
+	}
+
+	d, opts, err := s.bootOrderDriver(ctx, param.PowerParam)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.commandContext(ctx, param.PowerParam)
+	defer cancel()
+
+	current, err := d.BootOrder(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := desiredBootOrder(current, param.Devices)
+
+	diff := &BootOrderDiff{
+		Current: current,
+		Desired: desired,
+		Changed: !slices.Equal(bootOptionIDs(current), bootOptionIDs(desired)),
+	}
+
+	if !diff.Changed || param.DryRun {
+		return diff, nil
+	}
+
+	log.Info("Setting persistent boot order",
+		tag.Builder().KV("order", bootOptionIDs(desired)).KeyVals...)
+
+	if err := d.ApplyBootOrder(ctx, opts, bootOptionIDs(desired)); err != nil {
+		return nil, err
+	}
+
+	diff.Applied = true
+
+	return diff, nil
+}
+
+// BootOrderMachine is a machine which persistent boot order is enforced
+type BootOrderMachine struct {
+	SystemID string `json:"system_id"`
+	PowerParam
+}
+
+// EnforceBootOrderParam is the parameter of enforce-boot-order workflow
+type EnforceBootOrderParam struct {
+	// SystemID is the system_id of the Agent reaching the BMCs
+	SystemID string             `json:"system_id"`
+	Machines []BootOrderMachine `json:"machines"`
+	Devices  []string           `json:"devices"`
+	DryRun   bool               `json:"dry_run"`
+}
+
+// BootOrderReport is the outcome of enforcing the boot order of a machine
+type BootOrderReport struct {
+	SystemID string `json:"system_id"`
+	Error    string `json:"error,omitempty"`
+	BootOrderDiff
+}
+
+// EnforceBootOrderResult is the result of enforce-boot-order workflow
+type EnforceBootOrderResult struct {
+	Reports []BootOrderReport `json:"reports"`
+}
+
+// enforceBootOrder applies the boot order policy (devices first) to the
+// persistent boot order of machines. Failures are reported per machine,
+// so a single unreachable BMC doesn't hide the state of the others.
+func (s *PowerService) enforceBootOrder(ctx tworkflow.Context,
+	param EnforceBootOrderParam) (*EnforceBootOrderResult, error) {
+	queryCtx := powerQueryContext(ctx, param.SystemID)
+
+	futures := make([]tworkflow.Future, len(param.Machines))
+	for i, m := range param.Machines {
+		futures[i] = tworkflow.ExecuteActivity(queryCtx, "apply-persistent-boot-order",
+			ApplyBootOrderParam{PowerParam: m.PowerParam, Devices: param.Devices, DryRun: param.DryRun})
+	}
+
+	result := &EnforceBootOrderResult{Reports: make([]BootOrderReport, len(param.Machines))}
+
+	for i, m := range param.Machines {
+		report := BootOrderReport{SystemID: m.SystemID}
+
+		if err := futures[i].Get(ctx, &report.BootOrderDiff); err != nil {
+			report.Error = err.Error()
+		}
+
+		result.Reports[i] = report
+	}
+
+	return result, nil
+}
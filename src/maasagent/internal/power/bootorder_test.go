@@ -0,0 +1,68 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package power
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDesiredBootOrder(t *testing.T) {
+	disk := BootOption{ID: "Boot0001", Device: BootDeviceDisk}
+	cd := BootOption{ID: "Boot0002", Device: BootDeviceCD}
+	pxe4 := BootOption{ID: "Boot0003", Device: BootDevicePXE}
+	pxe6 := BootOption{ID: "Boot0004", Device: BootDevicePXE}
+	shell := BootOption{ID: "Boot0005"}
+
+	testcases := map[string]struct {
+		current []BootOption
+		devices []string
+		out     []BootOption
+	}{
+		"network first": {
+			current: []BootOption{disk, cd, pxe4, shell, pxe6},
+			devices: []string{BootDevicePXE, BootDeviceDisk},
+			out:     []BootOption{pxe4, pxe6, disk, cd, shell},
+		},
+		"already applied": {
+			current: []BootOption{pxe4, disk, cd},
+			devices: []string{BootDevicePXE},
+			out:     []BootOption{pxe4, disk, cd},
+		},
+		"device not present": {
+			current: []BootOption{disk, shell},
+			devices: []string{BootDevicePXE},
+			out:     []BootOption{disk, shell},
+		},
+		"unknown boot order": {
+			devices: []string{BootDevicePXE, BootDeviceDisk},
+			out: []BootOption{
+				{ID: BootDevicePXE, Device: BootDevicePXE},
+				{ID: BootDeviceDisk, Device: BootDeviceDisk},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, desiredBootOrder(tc.current, tc.devices))
+		})
+	}
+}
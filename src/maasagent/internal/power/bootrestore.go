@@ -0,0 +1,137 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+// restoredBootOrder returns options of recorded still present in current,
+// in recorded order, followed by options added since, in current order.
+func restoredBootOrder(current, recorded []BootOption) []BootOption {
+	res := make([]BootOption, 0, len(current))
+
+	for _, r := range recorded {
+		for _, o := range current {
+			if o.ID == r.ID {
+				res = append(res, o)
+			}
+		}
+	}
+
+	for _, o := range current {
+		if !slices.ContainsFunc(recorded, func(r BootOption) bool { return r.ID == o.ID }) {
+			res = append(res, o)
+		}
+	}
+
+	return res
+}
+
+// RecordBootOrderParam is the activity parameter for record-boot-order
+type RecordBootOrderParam struct {
+	SystemID string `json:"system_id"`
+	PowerParam
+}
+
+// RecordBootOrderResult is the result of record-boot-order, which is passed
+// to restore-boot-order by the deployment
+type RecordBootOrderResult struct {
+	RecordedAt time.Time    `json:"recorded_at"`
+	Order      []BootOption `json:"order"`
+}
+
+// RestoreBootOrderParam is the activity parameter for restore-boot-order
+type RestoreBootOrderParam struct {
+	SystemID string `json:"system_id"`
+	PowerParam
+	// Recorded is the boot order returned by record-boot-order
+	Recorded []BootOption `json:"recorded"`
+	// LocalFirst sets local disk first instead of the recorded boot order
+	LocalFirst bool `json:"local_first"`
+	// DryRun only reports the difference
+	DryRun bool `json:"dry_run"`
+}
+
+// RecordBootOrder returns the persistent boot order of the machine, meant
+// to be run by deployment before the machine is set to boot from network.
+// Activities of a machine can be run by any Agent of its VLAN, so nothing
+// is kept on the Agent, the deployment passes the result to RestoreBootOrder.
+func (s *PowerService) RecordBootOrder(ctx context.Context,
+	param RecordBootOrderParam) (*RecordBootOrderResult, error) {
+	res, err := s.GetBootOrder(ctx, GetBootOrderParam{PowerParam: param.PowerParam})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordBootOrderResult{RecordedAt: time.Now().UTC(), Order: res.Order}, nil
+}
+
+// RestoreBootOrder sets the persistent boot order of the machine recorded
+// before deployment, so deployed machines left booting from network don't
+// loop through deployment on reboot. Machines without recorded (or known)
+// boot order, or when LocalFirst is set, boot from local disk first.
+func (s *PowerService) RestoreBootOrder(ctx context.Context, param RestoreBootOrderParam) (*BootOrderDiff, error) {
+	log := activity.GetLogger(ctx)
+
+	d, opts, err := s.bootOrderDriver(ctx, param.PowerParam)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.commandContext(ctx, param.PowerParam)
+	defer cancel()
+
+	current, err := d.BootOrder(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var desired []BootOption
+
+	if param.LocalFirst || len(param.Recorded) == 0 {
+		desired = desiredBootOrder(current, []string{BootDeviceDisk})
+	} else {
+		desired = restoredBootOrder(current, param.Recorded)
+	}
+
+	diff := &BootOrderDiff{
+		Current: current,
+		Desired: desired,
+		Changed: !slices.Equal(bootOptionIDs(current), bootOptionIDs(desired)),
+	}
+
+	if param.DryRun || !diff.Changed {
+		return diff, nil
+	}
+
+	log.Info("Restoring persistent boot order", tag.Builder().
+		KV("system_id", param.SystemID).
+		KV("order", bootOptionIDs(desired)).KeyVals...)
+
+	if err := d.ApplyBootOrder(ctx, opts, bootOptionIDs(desired)); err != nil {
+		return nil, err
+	}
+
+	diff.Applied = true
+
+	return diff, nil
+}
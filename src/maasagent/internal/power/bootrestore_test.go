@@ -0,0 +1,172 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+)
+
+type fakeBootOrderDriver struct {
+	fakeDriver
+	order   []BootOption
+	applied [][]string
+}
+
+func (d *fakeBootOrderDriver) BootOrder(context.Context, map[string]interface{}) ([]BootOption, error) {
+	return d.order, nil
+}
+
+func (d *fakeBootOrderDriver) ApplyBootOrder(_ context.Context, _ map[string]interface{}, order []string) error {
+	d.applied = append(d.applied, order)
+
+	options := make([]BootOption, 0, len(order))
+
+	for _, id := range order {
+		for _, o := range d.order {
+			if o.ID == id {
+				options = append(options, o)
+			}
+		}
+	}
+
+	d.order = options
+
+	return nil
+}
+
+func TestRestoredBootOrder(t *testing.T) {
+	disk := BootOption{ID: "Boot0001", Device: BootDeviceDisk}
+	pxe := BootOption{ID: "Boot0002", Device: BootDevicePXE}
+	cd := BootOption{ID: "Boot0003", Device: BootDeviceCD}
+	ubuntu := BootOption{ID: "Boot0004", Name: "ubuntu", Device: BootDeviceDisk}
+
+	testcases := map[string]struct {
+		current  []BootOption
+		recorded []BootOption
+		out      []BootOption
+	}{
+		"restored": {
+			current:  []BootOption{pxe, disk, cd},
+			recorded: []BootOption{disk, cd, pxe},
+			out:      []BootOption{disk, cd, pxe},
+		},
+		"option added by deployment": {
+			current:  []BootOption{pxe, ubuntu, disk},
+			recorded: []BootOption{disk, pxe},
+			out:      []BootOption{disk, pxe, ubuntu},
+		},
+		"option removed": {
+			current:  []BootOption{pxe, disk},
+			recorded: []BootOption{cd, disk, pxe},
+			out:      []BootOption{disk, pxe},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, restoredBootOrder(tc.current, tc.recorded))
+		})
+	}
+}
+
+func TestRestoreBootOrder(t *testing.T) {
+	disk := BootOption{ID: "Boot0001", Device: BootDeviceDisk}
+	pxe := BootOption{ID: "Boot0002", Device: BootDevicePXE}
+	cd := BootOption{ID: "Boot0003", Device: BootDeviceCD}
+
+	testcases := map[string]struct {
+		// recorded is the boot order before deployment, not recorded if nil
+		recorded   []BootOption
+		localFirst bool
+		dryRun     bool
+		applied    [][]string
+	}{
+		"recorded": {
+			recorded: []BootOption{cd, disk, pxe},
+			applied:  [][]string{{"Boot0003", "Boot0001", "Boot0002"}},
+		},
+		"not recorded": {
+			applied: [][]string{{"Boot0001", "Boot0002", "Boot0003"}},
+		},
+		"local first": {
+			recorded:   []BootOption{pxe, cd, disk},
+			localFirst: true,
+			applied:    [][]string{{"Boot0001", "Boot0002", "Boot0003"}},
+		},
+		"unchanged": {
+			recorded: []BootOption{pxe, disk, cd},
+		},
+		"dry run": {
+			recorded: []BootOption{disk, pxe, cd},
+			dryRun:   true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			driver := &fakeBootOrderDriver{fakeDriver: fakeDriver{supported: true}, order: tc.recorded}
+			s := NewPowerService("agent", nil, WithDriver("fake", driver))
+
+			suite := testsuite.WorkflowTestSuite{}
+			env := suite.NewTestActivityEnvironment()
+			env.RegisterActivity(s.RecordBootOrder)
+			env.RegisterActivity(s.RestoreBootOrder)
+
+			param := PowerParam{DriverType: "fake", DriverOpts: map[string]interface{}{}}
+
+			var recorded RecordBootOrderResult
+
+			if tc.recorded != nil {
+				val, err := env.ExecuteActivity(s.RecordBootOrder,
+					RecordBootOrderParam{SystemID: "abc", PowerParam: param})
+				require.NoError(t, err)
+				require.NoError(t, val.Get(&recorded))
+				assert.Equal(t, tc.recorded, recorded.Order)
+			}
+
+			// Deployment leaves the machine booting from network
+			driver.order = []BootOption{pxe, disk, cd}
+
+			val, err := env.ExecuteActivity(s.RestoreBootOrder, RestoreBootOrderParam{
+				SystemID:   "abc",
+				PowerParam: param,
+				Recorded:   recorded.Order,
+				LocalFirst: tc.localFirst,
+				DryRun:     tc.dryRun,
+			})
+			require.NoError(t, err)
+
+			var diff BootOrderDiff
+			require.NoError(t, val.Get(&diff))
+
+			assert.Equal(t, tc.applied, driver.applied)
+			assert.Equal(t, len(tc.applied) > 0, diff.Applied)
+		})
+	}
+}
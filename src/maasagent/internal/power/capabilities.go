@@ -0,0 +1,108 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+)
+
+// How machines are power cycled, reported in PowerCapabilities
+const (
+	// CycleNative means the BMC or hypervisor cycles power in one action
+	CycleNative = "native"
+	// CycleEmulated means the machine is powered off and on again
+	CycleEmulated = "emulated"
+)
+
+// How the OS of machines is asked to shut down, reported in PowerCapabilities
+const (
+	// SoftOffBMC means the BMC requests the shutdown (e.g. ACPI soft-off),
+	// used for machines with "soft" power_off_mode
+	SoftOffBMC = "bmc"
+	// SoftOffGuest means the hypervisor requests the shutdown of the guest,
+	// used for VMs with "soft" power_off_mode
+	SoftOffGuest = "guest"
+)
+
+// GetPowerCapabilitiesParam is the activity parameter for get-power-capabilities
+type GetPowerCapabilitiesParam struct {
+	PowerParam
+}
+
+// PowerCapabilities are operations supported for a machine by its power
+// driver, so composite workflows can adapt (e.g. skip setting the boot
+// device) instead of failing midway.
+type PowerCapabilities struct {
+	// Native is true if power actions are performed by the Agent, and false
+	// if they are left to the MAAS power CLI
+	Native bool `json:"native"`
+	// Cycle is CycleNative or CycleEmulated
+	Cycle string `json:"cycle"`
+	// Reset is true if the machine can be hard reset with power-reset
+	Reset bool `json:"reset"`
+	// SoftOff is SoftOffBMC, SoftOffGuest or empty if machines are always
+	// powered off forcibly
+	SoftOff string `json:"soft_off,omitempty"`
+	// BootDevices can be set with set-boot-device. Boot devices of other
+	// machines are left as they are.
+	BootDevices []string `json:"boot_devices"`
+	// BootOrder is true if the persistent boot order can be managed with
+	// get-persistent-boot-order and apply-persistent-boot-order
+	BootOrder bool `json:"boot_order"`
+}
+
+// GetPowerCapabilities reports which operations the power driver supports
+// for the machine. Nothing is sent to the BMC, so the result reflects the
+// driver and its options rather than the firmware of the machine.
+func (s *PowerService) GetPowerCapabilities(_ context.Context,
+	param GetPowerCapabilitiesParam) (*PowerCapabilities, error) {
+	return s.capabilities(param.DriverType, param.DriverOpts), nil
+}
+
+func (s *PowerService) capabilities(driverType string, opts map[string]interface{}) *PowerCapabilities {
+	c := &PowerCapabilities{Cycle: CycleEmulated, BootDevices: []string{}}
+
+	if _, ok := s.guests[driverType]; ok && s.softOffTimeout > 0 && s.drivers.registered(driverType) {
+		c.SoftOff = SoftOffGuest
+	}
+
+	d, ok := s.drivers.Lookup(driverType, opts)
+	if !ok {
+		// The MAAS power CLI powers machines off and on to cycle them
+		return c
+	}
+
+	c.Native = true
+	c.Cycle = CycleNative
+
+	if e, ok := d.(EmulatedCyclePowerDriver); ok && e.EmulatesCycle(opts) {
+		c.Cycle = CycleEmulated
+	}
+
+	_, c.Reset = d.(ResettingPowerDriver)
+
+	if _, ok := d.(SoftOffPowerDriver); ok && c.SoftOff == "" && s.softOffTimeout > 0 {
+		c.SoftOff = SoftOffBMC
+	}
+
+	if _, ok := d.(BootDevicePowerDriver); ok {
+		c.BootDevices = []string{BootDevicePXE, BootDeviceDisk, BootDeviceCD}
+	}
+
+	_, c.BootOrder = d.(BootOrderPowerDriver)
+
+	return c
+}
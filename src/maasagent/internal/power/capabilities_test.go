@@ -0,0 +1,134 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPowerCapabilities(t *testing.T) {
+	allDevices := []string{BootDevicePXE, BootDeviceDisk, BootDeviceCD}
+
+	testcases := map[string]struct {
+		driverType string
+		opts       map[string]interface{}
+		options    []PowerServiceOption
+		out        PowerCapabilities
+	}{
+		"ipmi": {
+			driverType: DriverIPMI,
+			opts:       map[string]interface{}{"power_address": "10.0.0.1", "power_driver": "LAN_2_0"},
+			out: PowerCapabilities{
+				Native:      true,
+				Cycle:       CycleNative,
+				Reset:       true,
+				SoftOff:     SoftOffBMC,
+				BootDevices: allDevices,
+				BootOrder:   true,
+			},
+		},
+		"ipmi v1.5 is left to the power CLI": {
+			driverType: DriverIPMI,
+			opts:       map[string]interface{}{"power_address": "10.0.0.1", "power_driver": "LAN"},
+			out:        PowerCapabilities{Cycle: CycleEmulated, BootDevices: []string{}},
+		},
+		"pdu": {
+			driverType: DriverAPC,
+			opts:       map[string]interface{}{"power_address": "10.0.0.2", "node_outlet": "1"},
+			out:        PowerCapabilities{Native: true, Cycle: CycleEmulated, BootDevices: []string{}},
+		},
+		"webhook with cycle uri": {
+			driverType: DriverWebhook,
+			opts:       map[string]interface{}{"power_cycle_uri": "http://bmc/cycle"},
+			out:        PowerCapabilities{Native: true, Cycle: CycleNative, BootDevices: []string{}},
+		},
+		"webhook without cycle uri": {
+			driverType: DriverWebhook,
+			opts:       map[string]interface{}{},
+			out:        PowerCapabilities{Native: true, Cycle: CycleEmulated, BootDevices: []string{}},
+		},
+		"hmc": {
+			driverType: DriverHMC,
+			opts: map[string]interface{}{
+				"power_address": "10.0.0.4", "power_user": "hscroot", "power_pass": "abc123",
+				"server_name": "sys1", "lpar": "lpar1", "hmc_api": "rest",
+			},
+			out: PowerCapabilities{
+				Native:      true,
+				Cycle:       CycleEmulated,
+				SoftOff:     SoftOffBMC,
+				BootDevices: []string{},
+			},
+		},
+		"virsh": {
+			driverType: DriverVirsh,
+			opts:       map[string]interface{}{"power_address": "qemu+ssh://ubuntu@10.0.0.3/system", "power_id": "vm1"},
+			out: PowerCapabilities{
+				Native:      true,
+				Cycle:       CycleNative,
+				SoftOff:     SoftOffGuest,
+				BootDevices: []string{},
+			},
+		},
+		"soft-off disabled": {
+			driverType: DriverIPMI,
+			opts:       map[string]interface{}{"power_address": "10.0.0.1", "power_driver": "LAN_2_0"},
+			options:    []PowerServiceOption{WithSoftOffTimeout(0)},
+			out: PowerCapabilities{
+				Native:      true,
+				Cycle:       CycleNative,
+				Reset:       true,
+				BootDevices: allDevices,
+				BootOrder:   true,
+			},
+		},
+		"moonshot": {
+			driverType: DriverMoonshot,
+			opts:       map[string]interface{}{"power_address": "10.0.0.5", "power_hwaddress": "-b 7 -t 0x72"},
+			out:        PowerCapabilities{Native: true, Cycle: CycleNative, BootDevices: []string{}},
+		},
+		"openbmc": {
+			driverType: DriverOpenBMC,
+			opts:       map[string]interface{}{"power_address": "10.0.0.6", "power_user": "root", "power_pass": "0penBmc"},
+			out:        PowerCapabilities{Native: true, Cycle: CycleEmulated, BootDevices: []string{}},
+		},
+		"power cli": {
+			driverType: "mscm",
+			opts:       map[string]interface{}{},
+			out:        PowerCapabilities{Cycle: CycleEmulated, BootDevices: []string{}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewPowerService("abc", nil, append([]PowerServiceOption{withAllNativeDrivers()}, tc.options...)...)
+
+			out, err := s.GetPowerCapabilities(context.Background(), GetPowerCapabilitiesParam{
+				PowerParam: PowerParam{DriverType: tc.driverType, DriverOpts: tc.opts},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, *out)
+		})
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sync"
+
+	lxdc "github.com/canonical/lxd/client"
+	lxdapi "github.com/canonical/lxd/shared/api"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// virshEndMarker is echoed after every command, so the end of command
+// output can be found in the virsh shell output stream.
+const virshEndMarker = "__maas_agent_end__"
+
+var (
+	// ErrConnClosed is returned when a pooled connection was closed
+	ErrConnClosed = errcode.New(errcode.PowerUnreachable, "connection closed")
+)
+
+// virshConn is a long-running virsh shell, which keeps a single libvirt
+// connection (and SSH session for qemu+ssh) open for many commands.
+type virshConn struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	lines     chan string
+	closeOnce sync.Once
+	mutex     sync.Mutex
+}
+
+func dialVirsh(ctx context.Context, uri string) (*virshConn, error) {
+	// The process outlives ctx, it is terminated by Close()
+	//nolint:gosec // gosec's G204 flags any command execution using variables
+	cmd := exec.Command("virsh", "--connect", uri)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := &virshConn{cmd: cmd, stdin: stdin, lines: make(chan string)}
+
+	go func() {
+		defer close(c.lines)
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			c.lines <- scanner.Text()
+		}
+	}()
+
+	if err := c.Ping(ctx); err != nil {
+		//nolint:errcheck // we already return a more important error
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// run executes virsh command and returns its output lines. If ctx is done
+// before the command completes, the connection is closed, as there is no
+// way to tell apart output of the abandoned command from the next one.
+func (c *virshConn) run(ctx context.Context, command string) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, err := fmt.Fprintf(c.stdin, "%s\necho %s\n", command, virshEndMarker); err != nil {
+		return nil, err
+	}
+
+	var out []string
+
+	for {
+		select {
+		case <-ctx.Done():
+			//nolint:errcheck // we already return a more important error
+			c.Close()
+			return nil, ctx.Err()
+		case line, ok := <-c.lines:
+			if !ok {
+				return nil, ErrConnClosed
+			}
+
+			if line == virshEndMarker {
+				return out, nil
+			}
+
+			out = append(out, line)
+		}
+	}
+}
+
+// Ping asks the hypervisor for its hostname, which requires a working
+// connection. Errors are only printed to stderr, so empty output means
+// the connection is broken.
+func (c *virshConn) Ping(ctx context.Context) error {
+	out, err := c.run(ctx, "hostname")
+	if err != nil {
+		return err
+	}
+
+	if len(out) == 0 {
+		return ErrConnClosed
+	}
+
+	return nil
+}
+
+func (c *virshConn) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		//nolint:errcheck // the process is killed anyway
+		c.stdin.Close()
+
+		if err = c.cmd.Process.Kill(); err != nil {
+			return
+		}
+
+		// Drain output, so the reader goroutine can finish
+		go func() {
+			//nolint:revive // empty block is intended
+			for range c.lines {
+			}
+		}()
+
+		//nolint:errcheck // killed process always returns an error
+		c.cmd.Wait()
+	})
+
+	return err
+}
+
+// lxdConn is a client of an LXD host with keep-alive connections,
+// authenticated with the client certificate.
+type lxdConn struct {
+	server lxdc.InstanceServer
+	base   *url.URL
+}
+
+// lxdTransport is the transport of LXD clients
+type lxdTransport struct {
+	transport *http.Transport
+}
+
+func (t lxdTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport.RoundTrip(req)
+}
+
+func (t lxdTransport) Transport() *http.Transport {
+	return t.transport
+}
+
+func dialLXD(ctx context.Context, opts map[string]interface{}, address string) (*lxdConn, error) {
+	base, err := lxdURL(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var verify func([][]byte, [][]*x509.Certificate) error
+
+	if pin := stringOpt(opts, optCertFingerprint); pin != "" {
+		verify, err = verifyFingerprint(pin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	server, err := lxdc.ConnectLXDWithContext(ctx, base.String(), &lxdc.ConnectionArgs{
+		TLSClientCert: stringOpt(opts, "certificate"),
+		TLSClientKey:  stringOpt(opts, "key"),
+		// LXD hosts use self-signed certificates, so chain verification is
+		// not possible. The certificate is verified against the pinned
+		// fingerprint instead, if there is one.
+		InsecureSkipVerify: true,
+		TransportWrapper: func(t *http.Transport) lxdc.HTTPTransporter {
+			// The client is pooled, connections are kept for the next call
+			t.DisableKeepAlives = false
+			t.TLSClientConfig.VerifyPeerCertificate = verify
+
+			return lxdTransport{transport: t}
+		},
+	})
+	if err != nil {
+		return nil, lxdError(err)
+	}
+
+	return &lxdConn{server: server, base: base}, nil
+}
+
+// use returns the client of the project, which requests are bound to ctx.
+// Every call gets its own client, so calls sharing the connection don't
+// interfere with each other. Empty project means the default one.
+func (c *lxdConn) use(ctx context.Context, project string) lxdc.InstanceServer {
+	server := c.server.UseProject(project)
+
+	if s, ok := server.(interface {
+		WithContext(context.Context) lxdc.InstanceServer
+	}); ok {
+		return s.WithContext(ctx)
+	}
+
+	return server
+}
+
+// lxdError classifies errors returned by the LXD API
+func lxdError(err error) error {
+	if status, ok := lxdapi.StatusErrorMatch(err); ok {
+		return fmt.Errorf("%w: %w", responseError(status), err)
+	}
+
+	return err
+}
+
+// Ping checks that the LXD API is reachable and the certificate is trusted.
+func (c *lxdConn) Ping(ctx context.Context) error {
+	_, _, err := c.use(ctx, "").GetServer()
+	return lxdError(err)
+}
+
+func (c *lxdConn) instances(ctx context.Context, project string) (map[string]string, error) {
+	instances, err := c.use(ctx, project).GetInstances(lxdapi.InstanceTypeAny)
+	if err != nil {
+		return nil, lxdError(err)
+	}
+
+	return lxdInstanceStates(instances), nil
+}
+
+func (c *lxdConn) Close() error {
+	c.server.Disconnect()
+
+	if client, err := c.server.GetHTTPClient(); err == nil {
+		client.CloseIdleConnections()
+	}
+
+	return nil
+}
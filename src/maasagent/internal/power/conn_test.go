@@ -0,0 +1,280 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeConn struct {
+	pingErr error
+	closed  atomic.Bool
+}
+
+func (c *fakeConn) Ping(context.Context) error { return c.pingErr }
+
+func (c *fakeConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestConnPoolReusesConnections(t *testing.T) {
+	pool := newConnPool[*fakeConn](time.Minute, time.Hour)
+
+	var dials atomic.Int32
+
+	dial := func(context.Context) (*fakeConn, error) {
+		dials.Add(1)
+		return &fakeConn{}, nil
+	}
+
+	c1, err := pool.get(context.Background(), "host", dial)
+	require.NoError(t, err)
+
+	c2, err := pool.get(context.Background(), "host", dial)
+	require.NoError(t, err)
+
+	assert.Same(t, c1, c2)
+	assert.Equal(t, int32(1), dials.Load())
+
+	// A failed operation evicts the connection and is retried on a new one
+	calls := 0
+	err = pool.use(context.Background(), "host", dial, func(c *fakeConn) error {
+		calls++
+		if c == c1 {
+			return errors.New("broken pipe")
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.True(t, c1.closed.Load())
+	assert.Equal(t, int32(2), dials.Load())
+}
+
+func TestConnPoolCheck(t *testing.T) {
+	pool := newConnPool[*fakeConn](time.Minute, time.Hour)
+
+	healthy := &fakeConn{}
+	unhealthy := &fakeConn{pingErr: errors.New("timeout")}
+	idle := &fakeConn{}
+
+	for key, conn := range map[string]*fakeConn{"healthy": healthy, "unhealthy": unhealthy, "idle": idle} {
+		conn := conn
+
+		_, err := pool.get(context.Background(), key, func(context.Context) (*fakeConn, error) {
+			return conn, nil
+		})
+		require.NoError(t, err)
+	}
+
+	pool.mutex.Lock()
+	pool.conns["idle"].lastUsed = time.Now().Add(-time.Hour)
+	pool.mutex.Unlock()
+
+	assert.True(t, pool.check())
+
+	assert.False(t, healthy.closed.Load())
+	assert.True(t, unhealthy.closed.Load())
+	assert.True(t, idle.closed.Load())
+
+	pool.evict("healthy")
+	assert.False(t, pool.check(), "maintenance must stop when the pool is empty")
+}
+
+// fakeVirsh emulates virsh shell reading commands from stdin
+const fakeVirsh = `#!/bin/sh
+while read -r cmd arg; do
+	case "$cmd" in
+	hostname) echo kvm-host ;;
+	list) printf ' Id   Name   State\n------------------\n 1    vm0    running\n -    vm1    shut off\n' ;;
+	echo) echo "$arg" ;;
+	esac
+done
+`
+
+func TestVirshConn(t *testing.T) {
+	dir := t.TempDir()
+	//nolint:gosec // the script has to be executable
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "virsh"), []byte(fakeVirsh), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	pool := newConnPool[*virshConn](time.Minute, time.Hour)
+	opts := map[string]interface{}{"power_address": "qemu+ssh://ubuntu@host/system"}
+
+	for i := 0; i < 2; i++ {
+		states, err := listVirshDomains(context.Background(), pool, opts)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"vm0": "on", "vm1": "off"}, states)
+	}
+
+	pool.mutex.Lock()
+	assert.Len(t, pool.conns, 1)
+	pool.mutex.Unlock()
+
+	pool.evict("qemu+ssh://ubuntu@host/system")
+}
+
+func newClientCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "maas"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestLXDConn(t *testing.T) {
+	var connections atomic.Int32
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1.0":
+			w.Write([]byte(`{"type":"sync","metadata":{"api_extensions":["instances"]}}`))
+		case "/1.0/instances":
+			assert.Equal(t, "maas", r.URL.Query().Get("project"))
+			w.Write([]byte(`{"type":"sync","metadata":[{"name":"vm0","status":"Running"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.StartTLS()
+
+	defer server.Close()
+
+	cert, key := newClientCertificate(t)
+
+	opts := map[string]interface{}{
+		"power_address": server.URL,
+		"instance_name": "vm0",
+		"project":       "maas",
+		"certificate":   cert,
+		"key":           key,
+	}
+
+	pool := newConnPool[*lxdConn](time.Minute, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		states, err := listLXDInstances(context.Background(), pool, nil, opts)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"vm0": "on"}, states)
+	}
+
+	// TLS handshake is made only once
+	assert.Equal(t, int32(1), connections.Load())
+}
+
+func TestLXDConnFingerprint(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/1.0" {
+			w.Write([]byte(`{"type":"sync","metadata":{"api_extensions":["instances"]}}`))
+			return
+		}
+
+		w.Write([]byte(`{"type":"sync","metadata":[{"name":"vm0","status":"Stopped"}]}`))
+	}))
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	sum := sha256.Sum256(server.Certificate().Raw)
+	cert, key := newClientCertificate(t)
+
+	testcases := map[string]struct {
+		in  string
+		err error
+	}{
+		"match": {
+			in: "sha256:" + hex.EncodeToString(sum[:]),
+		},
+		"mismatch": {
+			in:  strings.Repeat("00", sha256.Size),
+			err: ErrFingerprintMismatch,
+		},
+		"invalid": {
+			in:  "deadbeef",
+			err: ErrInvalidPin,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := map[string]interface{}{
+				"power_address":           server.URL,
+				"instance_name":           "vm0",
+				"certificate":             cert,
+				"key":                     key,
+				"certificate_fingerprint": tc.in,
+			}
+
+			pool := newConnPool[*lxdConn](time.Minute, time.Hour)
+
+			states, err := listLXDInstances(context.Background(), pool, nil, opts)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"vm0": "off"}, states)
+		})
+	}
+}
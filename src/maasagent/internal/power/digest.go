@@ -0,0 +1,264 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"crypto/md5" //nolint:gosec // required by HTTP digest authentication
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// ErrUnsupportedDigest is returned when the server asks for HTTP digest
+// authentication with an unknown algorithm or quality of protection
+var ErrUnsupportedDigest = errcode.New(errcode.PowerUnsupported, "unsupported digest authentication")
+
+// digestChallenge is WWW-Authenticate challenge of HTTP digest
+// authentication (RFC 7616)
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+// digestTransport authenticates requests with HTTP digest authentication,
+// as used by Intel AMT. The challenge is kept for subsequent requests, so
+// only the first request (or requests with a stale nonce) is sent twice.
+type digestTransport struct {
+	transport http.RoundTripper
+	user      string
+	pass      string
+
+	challenge *digestChallenge
+	nc        int
+	mutex     sync.Mutex
+}
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.GetBody == nil {
+		return nil, fmt.Errorf("%w: request body can't be sent again", ErrUnsupportedDigest)
+	}
+
+	t.mutex.Lock()
+	challenge := t.challenge
+	t.mutex.Unlock()
+
+	if challenge != nil {
+		r, err := t.authorize(req, challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.transport.RoundTrip(r)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+
+		drain(resp)
+	}
+
+	resp, err := t.transport.RoundTrip(cloneRequest(req))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge, err = parseDigestChallenge(resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		// Not a digest challenge, the caller gets the response as it is
+		return resp, nil //nolint:nilerr // see above
+	}
+
+	drain(resp)
+
+	t.mutex.Lock()
+	t.challenge = challenge
+	t.nc = 0
+	t.mutex.Unlock()
+
+	r, err := t.authorize(req, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.transport.RoundTrip(r)
+}
+
+// authorize returns a copy of req with Authorization header answering
+// challenge
+func (t *digestTransport) authorize(req *http.Request, c *digestChallenge) (*http.Request, error) {
+	var h func() hash.Hash
+
+	switch strings.ToUpper(strings.TrimSuffix(c.algorithm, "-sess")) {
+	case "", "MD5":
+		h = md5.New
+	case "SHA-256":
+		h = sha256.New
+	default:
+		return nil, fmt.Errorf("%w: algorithm %s", ErrUnsupportedDigest, c.algorithm)
+	}
+
+	digest := func(s ...string) string {
+		d := h()
+		//nolint:errcheck // hashes never return an error
+		io.WriteString(d, strings.Join(s, ":"))
+
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	cnonce := hex.EncodeToString(b)
+
+	t.mutex.Lock()
+	t.nc++
+	nc := fmt.Sprintf("%08x", t.nc)
+	t.mutex.Unlock()
+
+	uri := req.URL.RequestURI()
+
+	ha1 := digest(t.user, c.realm, t.pass)
+	if strings.HasSuffix(strings.ToLower(c.algorithm), "-sess") {
+		ha1 = digest(ha1, c.nonce, cnonce)
+	}
+
+	ha2 := digest(req.Method, uri)
+
+	fields := []string{
+		fmt.Sprintf("username=%q", t.user),
+		fmt.Sprintf("realm=%q", c.realm),
+		fmt.Sprintf("nonce=%q", c.nonce),
+		fmt.Sprintf("uri=%q", uri),
+	}
+
+	switch {
+	case c.qop == "":
+		fields = append(fields, fmt.Sprintf("response=%q", digest(ha1, c.nonce, ha2)))
+	case hasToken(c.qop, "auth"):
+		fields = append(fields,
+			fmt.Sprintf("response=%q", digest(ha1, c.nonce, nc, cnonce, "auth", ha2)),
+			"qop=auth", "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce))
+	default:
+		return nil, fmt.Errorf("%w: qop %s", ErrUnsupportedDigest, c.qop)
+	}
+
+	if c.algorithm != "" {
+		fields = append(fields, "algorithm="+c.algorithm)
+	}
+
+	if c.opaque != "" {
+		fields = append(fields, fmt.Sprintf("opaque=%q", c.opaque))
+	}
+
+	r := cloneRequest(req)
+	r.Header.Set("Authorization", "Digest "+strings.Join(fields, ", "))
+
+	return r, nil
+}
+
+// cloneRequest returns a copy of req with its body rewound
+func cloneRequest(req *http.Request) *http.Request {
+	r := req.Clone(req.Context())
+
+	if req.GetBody != nil {
+		//nolint:errcheck // bodies of http.NewRequest never fail
+		r.Body, _ = req.GetBody()
+	}
+
+	return r
+}
+
+// drain discards the body of resp, so the connection can be reused
+func drain(resp *http.Response) {
+	//nolint:errcheck // the response is discarded anyway
+	io.Copy(io.Discard, resp.Body)
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	resp.Body.Close()
+}
+
+// parseDigestChallenge parses WWW-Authenticate header of digest
+// authentication
+func parseDigestChallenge(header string) (*digestChallenge, error) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDigest, header)
+	}
+
+	c := &digestChallenge{}
+
+	for params = strings.TrimSpace(params); params != ""; {
+		var key, value string
+
+		key, params, _ = strings.Cut(params, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if strings.HasPrefix(params, `"`) {
+			end := strings.Index(params[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("%w: %q", ErrUnsupportedDigest, header)
+			}
+
+			value, params = params[1:end+1], params[end+2:]
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+			value = strings.TrimSpace(value)
+		}
+
+		params = strings.TrimLeft(params, ", ")
+
+		switch key {
+		case "realm":
+			c.realm = value
+		case "nonce":
+			c.nonce = value
+		case "opaque":
+			c.opaque = value
+		case "algorithm":
+			c.algorithm = value
+		case "qop":
+			c.qop = value
+		}
+	}
+
+	if c.nonce == "" {
+		return nil, fmt.Errorf("%w: no nonce in %q", ErrUnsupportedDigest, header)
+	}
+
+	return c, nil
+}
+
+// hasToken returns whether comma separated list has token
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDigestChallenge(t *testing.T) {
+	testcases := map[string]struct {
+		header    string
+		challenge *digestChallenge
+		err       error
+	}{
+		"amt": {
+			header: `Digest realm="Digest:A3829B3ECC8E4A8D", nonce="Q0Ue7AAAAAA=", stale="false", qop="auth"`,
+			challenge: &digestChallenge{
+				realm: "Digest:A3829B3ECC8E4A8D",
+				nonce: "Q0Ue7AAAAAA=",
+				qop:   "auth",
+			},
+		},
+		"unquoted algorithm": {
+			header: `Digest realm="x, y", nonce="n", opaque="o", algorithm=SHA-256, qop="auth,auth-int"`,
+			challenge: &digestChallenge{
+				realm:     "x, y",
+				nonce:     "n",
+				opaque:    "o",
+				algorithm: "SHA-256",
+				qop:       "auth,auth-int",
+			},
+		},
+		"basic": {
+			header: `Basic realm="AMT"`,
+			err:    ErrUnsupportedDigest,
+		},
+		"no nonce": {
+			header: `Digest realm="AMT"`,
+			err:    ErrUnsupportedDigest,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c, err := parseDigestChallenge(tc.header)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.challenge, c)
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// PowerDriver performs power actions natively, without the MAAS power CLI.
+// opts are driver options (power parameters) of the machine. Every action
+// returns the resulting power state ("on", "off" or "unknown") and details
+// reported by the BMC, if any.
+type PowerDriver interface {
+	On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error)
+	Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error)
+	Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error)
+	Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error)
+}
+
+// SelectivePowerDriver is implemented by drivers which support only some
+// machines of their driver type (e.g. IPMI v2.0 BMCs). Power actions of
+// other machines are run by the MAAS power CLI.
+type SelectivePowerDriver interface {
+	PowerDriver
+	Supports(opts map[string]interface{}) bool
+}
+
+// ResettingPowerDriver is implemented by drivers which can hard reset the
+// machine without removing power (e.g. IPMI chassis reset). The MAAS power
+// CLI has no such action, so reset is only supported by native drivers.
+type ResettingPowerDriver interface {
+	PowerDriver
+	Reset(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error)
+}
+
+// SoftOffPowerDriver is implemented by drivers which can ask the OS of the
+// machine to shut down (e.g. ACPI soft-off). SoftOff returns once the request
+// is sent, with the power state of the machine at that time.
+type SoftOffPowerDriver interface {
+	PowerDriver
+	SoftOff(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error)
+}
+
+// BootDevicePowerDriver is implemented by drivers which can set the device
+// the machine boots from next (BootDevicePXE, BootDeviceDisk or BootDeviceCD).
+type BootDevicePowerDriver interface {
+	PowerDriver
+	SetBootDevice(ctx context.Context, opts map[string]interface{}, device string) error
+}
+
+// EmulatedCyclePowerDriver is implemented by drivers which power cycle some
+// machines by powering them off and on again (e.g. PDU outlets), rather
+// than with a single action of the BMC or hypervisor.
+type EmulatedCyclePowerDriver interface {
+	PowerDriver
+	EmulatesCycle(opts map[string]interface{}) bool
+}
+
+// ChassisPowerDriver is implemented by drivers of chassis managers, which
+// address nodes of the chassis through a single BMC. QueryNodes returns
+// power states of nodes by their address, with errors of nodes which could
+// not be queried, without logging in to the BMC for every node.
+type ChassisPowerDriver interface {
+	PowerDriver
+	QueryNodes(ctx context.Context, opts map[string]interface{},
+		nodes []string) (map[string]string, map[string]error, error)
+}
+
+// DriverRegistry keeps native power drivers keyed by driver type, so
+// drivers can be moved from the MAAS power CLI into the Agent one by one.
+type DriverRegistry struct {
+	drivers map[string]PowerDriver
+	mutex   sync.RWMutex
+}
+
+// NewDriverRegistry returns an empty DriverRegistry
+func NewDriverRegistry() *DriverRegistry {
+	return &DriverRegistry{drivers: make(map[string]PowerDriver)}
+}
+
+// builtinDrivers returns drivers built into the Agent by driver type. They
+// are registered only for driver types enabled in the configuration, as
+// power drivers of the Region (the MAAS power CLI) remain the reference.
+// LXD connections fall back to known members of clusters, and vSphere
+// sessions are shared with guest shutdowns.
+func builtinDrivers(members *lxdMemberCache, vmware *vmwareSessionCache) map[string]PowerDriver {
+	return map[string]PowerDriver{
+		DriverRedfish:    redfishDriver{},
+		DriverIPMI:       ipmiDriver{},
+		DriverAMT:        amtDriver{},
+		DriverAPC:        pduDriver{dial: dialAPC},
+		DriverHMC:        newHMCDriver(),
+		DriverLXD:        newLXDDriver(members),
+		DriverMoonshot:   moonshotDriver{},
+		DriverNova:       newNovaDriver(),
+		DriverOpenBMC:    newOpenBMCDriver(),
+		DriverProxmox:    newProxmoxDriver(),
+		DriverRaritan:    pduDriver{dial: dialRaritan},
+		DriverServerTech: pduDriver{dial: dialServerTech},
+		DriverVirsh:      newVirshDriver(),
+		DriverVMware:     newVMwareDriver(vmware),
+		DriverWebhook:    webhookDriver{},
+	}
+}
+
+// defaultDrivers returns DriverRegistry with drivers always performing
+// power actions natively: the simulator has no MAAS power CLI driver.
+func defaultDrivers() *DriverRegistry {
+	r := NewDriverRegistry()
+	r.Register(DriverSimulator, powerSimulator)
+
+	return r
+}
+
+// Register makes d perform power actions of driverType, replacing the
+// driver registered before, if any.
+func (r *DriverRegistry) Register(driverType string, d PowerDriver) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.drivers[driverType] = d
+}
+
+// Lookup returns the driver performing power actions of the machine with
+// driver options opts, or false if they are left to the MAAS power CLI.
+func (r *DriverRegistry) Lookup(driverType string, opts map[string]interface{}) (PowerDriver, bool) {
+	r.mutex.RLock()
+	d, ok := r.drivers[driverType]
+	r.mutex.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	if s, ok := d.(SelectivePowerDriver); ok && !s.Supports(opts) {
+		return nil, false
+	}
+
+	return d, true
+}
+
+// registered returns whether there is a driver of driverType, whichever
+// machines it supports
+func (r *DriverRegistry) registered(driverType string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	_, ok := r.drivers[driverType]
+
+	return ok
+}
+
+// chassis returns the driver of driverType, if it can query nodes of a
+// chassis. Options of the chassis don't address a node, so they are not
+// checked.
+func (r *DriverRegistry) chassis(driverType string) (ChassisPowerDriver, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	d, ok := r.drivers[driverType].(ChassisPowerDriver)
+
+	return d, ok
+}
+
+// Drivers returns sorted driver types with registered drivers
+func (r *DriverRegistry) Drivers() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	res := make([]string, 0, len(r.drivers))
+	for driverType := range r.drivers {
+		res = append(res, driverType)
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// runDriver performs action ("on", "off", "soft-off", "cycle", "reset" or
+// "status") with d
+func runDriver(ctx context.Context, d PowerDriver, action string,
+	opts map[string]interface{}) (string, PowerDetails, error) {
+	switch action {
+	case "on":
+		return d.On(ctx, opts)
+	case "off":
+		return d.Off(ctx, opts)
+	case "soft-off":
+		if s, ok := d.(SoftOffPowerDriver); ok {
+			return s.SoftOff(ctx, opts)
+		}
+	case "cycle":
+		return d.Cycle(ctx, opts)
+	case "reset":
+		if r, ok := d.(ResettingPowerDriver); ok {
+			return r.Reset(ctx, opts)
+		}
+	case "status":
+		return d.Status(ctx, opts)
+	}
+
+	return "", PowerDetails{}, fmt.Errorf("%w: %q", ErrUnsupportedPowerAction, action)
+}
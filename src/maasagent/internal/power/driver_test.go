@@ -0,0 +1,271 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDriver struct {
+	// softOff is the state returned by SoftOff
+	softOff    string
+	softOffErr error
+	supported  bool
+	actions    []string
+}
+
+func (d *fakeDriver) Supports(map[string]interface{}) bool {
+	return d.supported
+}
+
+func (d *fakeDriver) On(context.Context, map[string]interface{}) (string, PowerDetails, error) {
+	d.actions = append(d.actions, "on")
+	return "on", PowerDetails{Health: "OK"}, nil
+}
+
+func (d *fakeDriver) Off(context.Context, map[string]interface{}) (string, PowerDetails, error) {
+	d.actions = append(d.actions, "off")
+	return "off", PowerDetails{Health: "OK"}, nil
+}
+
+func (d *fakeDriver) SoftOff(context.Context, map[string]interface{}) (string, PowerDetails, error) {
+	d.actions = append(d.actions, "soft-off")
+	return d.softOff, PowerDetails{Health: "OK"}, d.softOffErr
+}
+
+func (d *fakeDriver) Cycle(context.Context, map[string]interface{}) (string, PowerDetails, error) {
+	d.actions = append(d.actions, "cycle")
+	return "on", PowerDetails{Health: "OK"}, nil
+}
+
+func (d *fakeDriver) Reset(context.Context, map[string]interface{}) (string, PowerDetails, error) {
+	d.actions = append(d.actions, "reset")
+	return "on", PowerDetails{Health: "OK"}, nil
+}
+
+func (d *fakeDriver) Status(context.Context, map[string]interface{}) (string, PowerDetails, error) {
+	d.actions = append(d.actions, "status")
+	return "off", PowerDetails{Health: "OK"}, nil
+}
+
+func TestDriverRegistryLookup(t *testing.T) {
+	r := NewDriverRegistry()
+	r.Register("supported", &fakeDriver{supported: true})
+	r.Register("unsupported", &fakeDriver{})
+
+	testcases := map[string]struct {
+		driverType string
+		ok         bool
+	}{
+		"registered": {
+			driverType: "supported",
+			ok:         true,
+		},
+		"not supported by the driver": {
+			driverType: "unsupported",
+		},
+		"not registered": {
+			driverType: "amt",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, ok := r.Lookup(tc.driverType, nil)
+			assert.Equal(t, tc.ok, ok)
+		})
+	}
+
+	assert.Equal(t, []string{"supported", "unsupported"}, r.Drivers())
+}
+
+func TestRunDriver(t *testing.T) {
+	d := &fakeDriver{}
+
+	for _, action := range []string{"on", "off", "soft-off", "cycle", "reset", "status"} {
+		_, details, err := runDriver(context.Background(), d, action, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "OK", details.Health)
+	}
+
+	_, _, err := runDriver(context.Background(), d, "set-boot-order", nil)
+	assert.ErrorIs(t, err, ErrUnsupportedPowerAction)
+	assert.Equal(t, []string{"on", "off", "soft-off", "cycle", "reset", "status"}, d.actions)
+}
+
+// nonResettingDriver hides Reset of fakeDriver
+type nonResettingDriver struct {
+	PowerDriver
+}
+
+func TestRunDriverResetUnsupported(t *testing.T) {
+	d := &fakeDriver{}
+
+	_, _, err := runDriver(context.Background(), nonResettingDriver{d}, "reset", nil)
+	assert.ErrorIs(t, err, ErrUnsupportedPowerAction)
+	assert.Empty(t, d.actions)
+}
+
+func TestPowerServiceDriver(t *testing.T) {
+	d := &fakeDriver{supported: true}
+	s := NewPowerService("abc", nil, WithDriver("fake", d))
+
+	state, err := s.Execute(context.Background(), "cycle", PowerParam{DriverType: "fake"})
+	require.NoError(t, err)
+	assert.Equal(t, "on", state)
+	assert.Equal(t, []string{"cycle"}, d.actions)
+
+	// The MAAS power CLI can't reset machines
+	_, err = s.Execute(context.Background(), "reset", PowerParam{DriverType: "dli"})
+	assert.ErrorIs(t, err, ErrUnsupportedPowerAction)
+
+	assert.Equal(t, []string{"fake", DriverSimulator}, s.Drivers())
+}
+
+// withAllNativeDrivers enables all drivers built into the Agent
+func withAllNativeDrivers() PowerServiceOption {
+	var driverTypes []string
+	for driverType := range builtinDrivers(nil, nil) {
+		driverTypes = append(driverTypes, driverType)
+	}
+
+	return WithNativeDrivers(driverTypes...)
+}
+
+func TestPowerServiceNativeDrivers(t *testing.T) {
+	s := NewPowerService("abc", nil)
+	assert.Equal(t, []string{DriverSimulator}, s.Drivers(), "built-in drivers are opt-in")
+
+	s = NewPowerService("abc", nil, WithNativeDrivers(DriverRedfish, DriverIPMI, "mscm"))
+	assert.Equal(t, []string{DriverIPMI, DriverRedfish, DriverSimulator}, s.Drivers())
+}
+
+// failingDriver fails every power action
+type failingDriver struct {
+	PowerDriver
+	err error
+}
+
+func (d failingDriver) On(context.Context, map[string]interface{}) (string, PowerDetails, error) {
+	return "", PowerDetails{}, d.err
+}
+
+func TestPowerServiceDriverFallback(t *testing.T) {
+	errBMC := errors.New("BMC refused the request")
+
+	dir := t.TempDir()
+	t.Setenv("SNAP", "")
+	t.Setenv("PATH", dir)
+
+	s := NewPowerService("abc", nil, WithDriver("fake", failingDriver{err: errBMC}))
+
+	_, err := s.Execute(context.Background(), "on", PowerParam{DriverType: "fake"})
+	assert.ErrorIs(t, err, errBMC, "the error of the driver is kept without the MAAS power CLI")
+
+	//nolint:gosec // the script has to be executable
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "maas.power"), []byte("#!/bin/sh\necho on\n"), 0o755))
+
+	state, err := s.Execute(context.Background(), "on", PowerParam{DriverType: "fake"})
+	require.NoError(t, err)
+	assert.Equal(t, "on", state)
+
+	// The MAAS power CLI can't verify pinned identities of hosts
+	pinnedParam := PowerParam{DriverType: "fake", DriverOpts: map[string]interface{}{optHostKey: "ssh-ed25519 AAAA"}}
+
+	_, err = s.Execute(context.Background(), "on", pinnedParam)
+	assert.ErrorIs(t, err, errBMC)
+
+	pinnedParam.DriverType = "dli"
+
+	_, err = s.Execute(context.Background(), "on", pinnedParam)
+	assert.ErrorIs(t, err, ErrInvalidPin)
+}
+
+func TestPowerServiceSoftOff(t *testing.T) {
+	testcases := map[string]struct {
+		driver  *fakeDriver
+		mode    string
+		timeout time.Duration
+		actions []string
+	}{
+		"hard": {
+			driver:  &fakeDriver{supported: true, softOff: "on"},
+			mode:    "hard",
+			timeout: time.Minute,
+			actions: []string{"off"},
+		},
+		"shut down in time": {
+			driver:  &fakeDriver{supported: true, softOff: "on"},
+			mode:    "soft",
+			timeout: time.Minute,
+			actions: []string{"soft-off", "status"},
+		},
+		"already off": {
+			driver:  &fakeDriver{supported: true, softOff: "off"},
+			mode:    "soft",
+			timeout: time.Minute,
+			actions: []string{"soft-off"},
+		},
+		"escalated": {
+			driver:  &fakeDriver{supported: true, softOff: "on"},
+			mode:    "soft",
+			timeout: time.Millisecond,
+			actions: []string{"soft-off", "off"},
+		},
+		"not supported by the BMC": {
+			driver:  &fakeDriver{supported: true, softOffErr: ErrUnsupportedPowerAction},
+			mode:    "soft",
+			timeout: time.Minute,
+			actions: []string{"soft-off", "off"},
+		},
+		"no timeout": {
+			driver:  &fakeDriver{supported: true, softOff: "on"},
+			mode:    "soft",
+			actions: []string{"off"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewPowerService("abc", nil, WithDriver("fake", tc.driver),
+				WithSoftOffTimeout(tc.timeout))
+
+			state, err := s.Execute(context.Background(), "off", PowerParam{
+				DriverType: "fake",
+				DriverOpts: map[string]interface{}{"power_off_mode": tc.mode},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "off", state)
+			assert.Equal(t, tc.actions, tc.driver.actions)
+		})
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	lxdapi "github.com/canonical/lxd/shared/api"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+// How machines were powered off, reported in PowerDetails
+const (
+	// ShutdownGraceful means the OS of the machine shut down by itself
+	ShutdownGraceful = "graceful"
+	// ShutdownForced means power was removed (or the VM was destroyed)
+	ShutdownForced = "forced"
+)
+
+var (
+	// ErrGuestShutdownRefused is returned when the hypervisor could not
+	// pass the shutdown request to the guest (e.g. QEMU guest agent is not
+	// running)
+	ErrGuestShutdownRefused = errcode.New(errcode.PowerOperationFailed, "guest shutdown request refused")
+)
+
+// guestAgent asks the OS of a VM to shut down through the hypervisor
+type guestAgent interface {
+	// shutdown requests a graceful shutdown of the guest, which the
+	// hypervisor can give up after timeout
+	shutdown(ctx context.Context, timeout time.Duration) error
+	// state returns the power state of the VM ("on" or "off")
+	state(ctx context.Context) (string, error)
+	Close() error
+}
+
+// guestDialer returns guestAgent of the VM. ok is false if driver options
+// don't allow to reach the hypervisor natively, in which case the VM is
+// powered off forcibly by the MAAS power CLI.
+type guestDialer func(ctx context.Context, opts map[string]interface{}) (g guestAgent, ok bool, err error)
+
+// newGuestDialers returns guest dialers of VM driver types
+func newGuestDialers(members *lxdMemberCache, vmware *vmwareSessionCache) map[string]guestDialer {
+	return map[string]guestDialer{
+		"virsh": dialVirshGuest,
+		"lxd": func(ctx context.Context, opts map[string]interface{}) (guestAgent, bool, error) {
+			return dialLXDGuest(ctx, opts, members)
+		},
+		"vmware": func(ctx context.Context, opts map[string]interface{}) (guestAgent, bool, error) {
+			return dialVMwareGuest(ctx, opts, vmware)
+		},
+	}
+}
+
+// virshGuest shuts down libvirt domains with QEMU guest agent
+type virshGuest struct {
+	conn   *virshConn
+	domain string
+}
+
+func dialVirshGuest(ctx context.Context, opts map[string]interface{}) (guestAgent, bool, error) {
+	// virsh is given no password, the same as batched queries
+	if _, _, ok := virshBatchKey(opts); !ok {
+		return nil, false, nil
+	}
+
+	uri := stringOpt(opts, "power_address")
+
+	if hostKey := stringOpt(opts, optHostKey); hostKey != "" {
+		var err error
+
+		uri, err = pinnedVirshURI(uri, hostKey, knownHostsDir())
+		if err != nil {
+			return nil, true, err
+		}
+	}
+
+	c, err := dialVirsh(ctx, uri)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return &virshGuest{conn: c, domain: stringOpt(opts, "power_id")}, true, nil
+}
+
+// shutdown doesn't fall back to ACPI, as the guest agent is what tells
+// that the OS is able to shut down.
+func (g *virshGuest) shutdown(ctx context.Context, _ time.Duration) error {
+	out, err := g.conn.run(ctx, "shutdown --mode agent "+virshQuote(g.domain))
+	if err != nil {
+		return err
+	}
+
+	// Errors are printed to stderr
+	if len(strings.TrimSpace(strings.Join(out, ""))) == 0 {
+		return fmt.Errorf("%w: %s", ErrGuestShutdownRefused, g.domain)
+	}
+
+	return nil
+}
+
+func (g *virshGuest) state(ctx context.Context) (string, error) {
+	out, err := g.conn.run(ctx, "domstate "+virshQuote(g.domain))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range out {
+		if line = strings.TrimSpace(line); line != "" {
+			return virshPowerState(line), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrInstanceNotFound, g.domain)
+}
+
+func (g *virshGuest) Close() error {
+	return g.conn.Close()
+}
+
+// virshQuote quotes an argument of a virsh shell command
+func virshQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// lxdGuest shuts down LXD instances, which LXD does with lxd-agent or
+// ACPI for VMs, and SIGPWR for containers
+type lxdGuest struct {
+	conn     *lxdConn
+	project  string
+	instance string
+}
+
+func dialLXDGuest(ctx context.Context, opts map[string]interface{},
+	members *lxdMemberCache) (guestAgent, bool, error) {
+	_, instance, ok := lxdBatchKey(opts)
+	if !ok {
+		return nil, false, nil
+	}
+
+	c, err := dialLXDCluster(ctx, opts, members)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return &lxdGuest{conn: c, project: lxdProject(opts), instance: instance}, true, nil
+}
+
+// shutdown starts a stop operation, which LXD gives up after timeout
+// without forcing the instance to stop
+func (g *lxdGuest) shutdown(ctx context.Context, timeout time.Duration) error {
+	op, err := g.conn.use(ctx, g.project).UpdateInstanceState(g.instance, lxdapi.InstanceStatePut{
+		Action:  "stop",
+		Timeout: int(timeout / time.Second),
+	}, "")
+	if err != nil {
+		return lxdError(err)
+	}
+
+	// State of the instance is polled rather than the operation waited for.
+	// The operation is still waited for in the background, which releases
+	// its event listener once LXD is done with it.
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout+lxdOperationMargin)
+		defer cancel()
+
+		//nolint:errcheck // outcome is known from the state of the instance
+		op.WaitContext(ctx)
+	}()
+
+	return nil
+}
+
+func (g *lxdGuest) state(ctx context.Context) (string, error) {
+	status, err := g.conn.instanceStatus(ctx, g.project, g.instance)
+	if err != nil {
+		return "", err
+	}
+
+	return lxdPowerState(status), nil
+}
+
+func (g *lxdGuest) Close() error {
+	return g.conn.Close()
+}
+
+// guestPowerOff asks the guest OS of the VM to shut down and waits for it
+// up to softOffTimeout. VMs which guest doesn't respond, are still on after
+// that, or which hypervisor can't be reached natively, are powered off
+// forcibly.
+func (s *PowerService) guestPowerOff(ctx context.Context, dial guestDialer,
+	param PowerParam, opts map[string]interface{}) (string, PowerDetails, error) {
+	log := commandLogger(ctx)
+
+	forced := func() (string, PowerDetails, error) {
+		// The guest OS was asked already, it's not asked again by the driver
+		hard := maps.Clone(opts)
+		delete(hard, "power_off_mode")
+
+		state, details, err := s.powerWith(ctx, "off", param, hard)
+		details.Shutdown = ShutdownForced
+
+		return state, details, err
+	}
+
+	cmdCtx, cancel := s.commandContext(ctx, param)
+	g, ok, err := dial(cmdCtx, opts)
+
+	cancel()
+
+	if !ok {
+		return forced()
+	}
+
+	if err != nil {
+		log.Warn("Hypervisor is not reachable natively, powering off forcibly",
+			tag.Builder().Error(err).KeyVals...)
+
+		return forced()
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer g.Close()
+
+	state, err := s.guestCall(ctx, param, g.state)
+	if err == nil && state == "off" {
+		return state, PowerDetails{}, nil
+	}
+
+	if err == nil {
+		_, err = s.guestCall(ctx, param, func(ctx context.Context) (string, error) {
+			return "", g.shutdown(ctx, s.softOffTimeout)
+		})
+	}
+
+	if err != nil {
+		log.Warn("Guest did not accept shutdown request, powering off forcibly",
+			tag.Builder().Error(err).KeyVals...)
+
+		return forced()
+	}
+
+	timer := time.NewTimer(s.softOffTimeout)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", PowerDetails{}, ctx.Err()
+		case <-timer.C:
+			log.Warn("Guest did not shut down in time, powering off forcibly",
+				tag.Builder().KV("timeout", s.softOffTimeout).KeyVals...)
+
+			return forced()
+		case <-ticker.C:
+		}
+
+		state, err = s.guestCall(ctx, param, g.state)
+		if err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		if state == "off" {
+			return state, PowerDetails{Shutdown: ShutdownGraceful}, nil
+		}
+	}
+}
+
+// guestCall calls f limited by the timeout of the power action
+func (s *PowerService) guestCall(ctx context.Context, param PowerParam,
+	f func(context.Context) (string, error)) (string, error) {
+	ctx, cancel := s.commandContext(ctx, param)
+	defer cancel()
+
+	return f(ctx)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGuest reports states in order, the last one once they run out
+type fakeGuest struct {
+	shutdownErr error
+	states      []string
+	calls       []string
+}
+
+func (g *fakeGuest) shutdown(context.Context, time.Duration) error {
+	g.calls = append(g.calls, "shutdown")
+	return g.shutdownErr
+}
+
+func (g *fakeGuest) state(context.Context) (string, error) {
+	g.calls = append(g.calls, "state")
+
+	state := g.states[0]
+	if len(g.states) > 1 {
+		g.states = g.states[1:]
+	}
+
+	return state, nil
+}
+
+func (g *fakeGuest) Close() error {
+	return nil
+}
+
+func TestPowerServiceGuestShutdown(t *testing.T) {
+	testcases := map[string]struct {
+		guest    *fakeGuest
+		native   bool
+		dialErr  error
+		mode     string
+		timeout  time.Duration
+		shutdown string
+		calls    []string
+		actions  []string
+	}{
+		"shut down by the guest": {
+			guest:    &fakeGuest{states: []string{"on", "off"}},
+			mode:     "soft",
+			native:   true,
+			timeout:  time.Minute,
+			shutdown: ShutdownGraceful,
+			calls:    []string{"state", "shutdown", "state"},
+		},
+		"already off": {
+			guest:   &fakeGuest{states: []string{"off"}},
+			mode:    "soft",
+			native:  true,
+			timeout: time.Minute,
+			calls:   []string{"state"},
+		},
+		"guest agent is not running": {
+			guest:    &fakeGuest{states: []string{"on"}, shutdownErr: ErrGuestShutdownRefused},
+			mode:     "soft",
+			native:   true,
+			timeout:  time.Minute,
+			shutdown: ShutdownForced,
+			calls:    []string{"state", "shutdown"},
+			actions:  []string{"off"},
+		},
+		"escalated": {
+			guest:    &fakeGuest{states: []string{"on"}},
+			mode:     "soft",
+			native:   true,
+			timeout:  time.Millisecond,
+			shutdown: ShutdownForced,
+			calls:    []string{"state", "shutdown"},
+			actions:  []string{"off"},
+		},
+		"hypervisor is not reachable": {
+			guest:    &fakeGuest{},
+			mode:     "soft",
+			native:   true,
+			dialErr:  errors.New("connection refused"),
+			timeout:  time.Minute,
+			shutdown: ShutdownForced,
+			actions:  []string{"off"},
+		},
+		"not supported natively": {
+			guest:    &fakeGuest{},
+			mode:     "soft",
+			timeout:  time.Minute,
+			shutdown: ShutdownForced,
+			actions:  []string{"off"},
+		},
+		"hard": {
+			guest:   &fakeGuest{},
+			native:  true,
+			mode:    "hard",
+			timeout: time.Minute,
+			actions: []string{"off"},
+		},
+		"mode not set": {
+			guest:   &fakeGuest{},
+			native:  true,
+			timeout: time.Minute,
+			actions: []string{"off"},
+		},
+		"no timeout": {
+			guest:   &fakeGuest{},
+			mode:    "soft",
+			native:  true,
+			actions: []string{"off"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			driver := &fakeDriver{supported: true}
+
+			s := NewPowerService("abc", nil, WithDriver("fake", driver),
+				WithSoftOffTimeout(tc.timeout))
+			s.guests["fake"] = func(context.Context, map[string]interface{}) (guestAgent, bool, error) {
+				return tc.guest, tc.native, tc.dialErr
+			}
+
+			state, details, err := s.power(context.Background(), "off", PowerParam{
+				DriverType: "fake",
+				DriverOpts: map[string]interface{}{"power_off_mode": tc.mode},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "off", state)
+			assert.Equal(t, tc.shutdown, details.Shutdown)
+			assert.Equal(t, tc.calls, tc.guest.calls)
+			assert.Equal(t, tc.actions, driver.actions)
+		})
+	}
+}
+
+func TestVirshQuote(t *testing.T) {
+	assert.Equal(t, `"vm 1"`, virshQuote("vm 1"))
+	assert.Equal(t, `"a\"b\\c"`, virshQuote(`a"b\c`))
+}
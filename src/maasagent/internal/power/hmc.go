@@ -0,0 +1,516 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// DriverHMC is the power driver of IBM Power LPARs managed by an HMC
+const DriverHMC = "hmc"
+
+const (
+	// hmcAPIREST is the hmc_api of machines powered with the REST API
+	// rather than over SSH
+	hmcAPIREST = "rest"
+	// defaultHMCPort is the port of the HMC REST API, unless power_port
+	// is set
+	defaultHMCPort = "12443"
+	// hmcResponseLimit is how much of a response is decoded. Feeds of
+	// LPARs of large managed systems are verbose.
+	hmcResponseLimit = 8 << 20
+	// defaultHMCPollInterval is how often jobs are queried until they
+	// complete
+	defaultHMCPollInterval = 2 * time.Second
+	// defaultHMCBootMode is the boot mode of LPARs, unless lpar_boot_mode
+	// is set
+	defaultHMCBootMode = "norm"
+	// hmcBootString makes LPARs boot from the network, as with the power
+	// driver (chsysstate --bootstring network-all)
+	hmcBootString = "network-all"
+	// hmcNamespace is the namespace of HMC web requests
+	hmcNamespace = "http://www.ibm.com/xmlns/systems/power/firmware/web/mc/2012_10/"
+)
+
+// hmcBootModes are the boot modes LPARs can be activated with: normal,
+// SMS menu, Open Firmware prompt, and diagnostics with default or stored
+// boot list
+var hmcBootModes = map[string]bool{"norm": true, "sms": true, "of": true, "dd": true, "ds": true}
+
+// ErrHMCJobFailed is returned when an HMC job of a power action fails
+var ErrHMCJobFailed = errcode.New(errcode.PowerOperationFailed, "HMC job failed")
+
+// hmcDriver controls LPARs with the REST API of the HMC, instead of running
+// chsysstate and lssyscfg over SSH as the power driver does. It is only
+// used for machines with hmc_api set to "rest", as HMCs don't always have
+// the REST API enabled; others are left to the MAAS power CLI. Power
+// parameters are otherwise the same: power_address, power_user,
+// power_pass, server_name and lpar, with lpar_boot_mode to activate LPARs
+// with another boot mode than "norm". API sessions are kept until the HMC
+// rejects them.
+type hmcDriver struct {
+	sessions map[string]*hmcSession
+	poll     time.Duration
+	mutex    sync.Mutex
+}
+
+func newHMCDriver() *hmcDriver {
+	return &hmcDriver{sessions: make(map[string]*hmcSession), poll: defaultHMCPollInterval}
+}
+
+// hmcSession is a logged on session of the HMC REST API
+type hmcSession struct {
+	client *http.Client
+	base   *url.URL
+	token  string
+}
+
+// hmcPartition is the part of a LogicalPartition which tells its state
+type hmcPartition struct {
+	UUID  string `xml:"PartitionUUID"`
+	Name  string `xml:"PartitionName"`
+	State string `xml:"PartitionState"`
+}
+
+// state returns the power state of the LPAR, as the power driver does
+func (p hmcPartition) state() string {
+	switch strings.ToLower(p.State) {
+	case "starting", "running", "open firmware":
+		return "on"
+	case "shutting down", "not activated":
+		return "off"
+	}
+
+	return "unknown"
+}
+
+// hmcJob is the response of an HMC job
+type hmcJob struct {
+	ID      string `xml:"JobID"`
+	Status  string `xml:"Status"`
+	Message string `xml:"ResponseException>Message"`
+}
+
+// Supports returns whether the REST API is selected, and the HMC, its
+// credentials and the LPAR are given
+func (d *hmcDriver) Supports(opts map[string]interface{}) bool {
+	if stringOpt(opts, "hmc_api") != hmcAPIREST {
+		return false
+	}
+
+	for _, opt := range []string{"power_address", "power_user", "power_pass", "server_name", "lpar"} {
+		if stringOpt(opts, opt) == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// On activates the LPAR to boot from the network. LPARs which are running
+// are shut down first, so they boot from the network again, as with the
+// power driver.
+func (d *hmcDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	mode := stringOpt(opts, "lpar_boot_mode")
+	if mode == "" {
+		mode = defaultHMCBootMode
+	}
+
+	if !hmcBootModes[mode] {
+		return "", PowerDetails{}, fmt.Errorf("%w: %q", ErrUnsupportedBootMode, mode)
+	}
+
+	return d.do(ctx, opts, func(s *hmcSession, p hmcPartition) error {
+		if p.state() == "on" {
+			if err := d.job(ctx, s, p, "PowerOff", "operation", "shutdown", "immediate", "true"); err != nil {
+				return err
+			}
+		}
+
+		return d.job(ctx, s, p, "PowerOn", "bootmode", mode, "bootstring", hmcBootString)
+	})
+}
+
+// Off shuts the LPAR down immediately, without waiting for its OS
+func (d *hmcDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(s *hmcSession, p hmcPartition) error {
+		if p.state() == "off" {
+			return nil
+		}
+
+		return d.job(ctx, s, p, "PowerOff", "operation", "shutdown", "immediate", "true")
+	})
+}
+
+// SoftOff asks the OS of the LPAR to shut down, without waiting for it
+func (d *hmcDriver) SoftOff(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(s *hmcSession, p hmcPartition) error {
+		if p.state() == "off" {
+			return nil
+		}
+
+		_, err := s.submit(ctx, p, "PowerOff", "operation", "osshutdown")
+
+		return err
+	})
+}
+
+// Cycle shuts the LPAR down and activates it again, as On does
+func (d *hmcDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.On(ctx, opts)
+}
+
+// EmulatesCycle returns true, as LPARs are shut down and activated again
+func (*hmcDriver) EmulatesCycle(map[string]interface{}) bool {
+	return true
+}
+
+func (d *hmcDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, nil)
+}
+
+// do calls fn (if any) with the LPAR and returns its state afterwards. The state of
+// the LPAR is returned in PowerDetails, as reported by the HMC.
+func (d *hmcDriver) do(ctx context.Context, opts map[string]interface{},
+	fn func(*hmcSession, hmcPartition) error) (string, PowerDetails, error) {
+	var p hmcPartition
+
+	err := d.withSession(ctx, opts, func(s *hmcSession) error {
+		var err error
+
+		p, err = s.partition(ctx, stringOpt(opts, "server_name"), stringOpt(opts, "lpar"))
+		if err != nil {
+			return err
+		}
+
+		if fn == nil {
+			return nil
+		}
+
+		if err := fn(s, p); err != nil {
+			return err
+		}
+
+		p, err = s.partition(ctx, stringOpt(opts, "server_name"), stringOpt(opts, "lpar"))
+
+		return err
+	})
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return p.state(), PowerDetails{Status: p.State}, nil
+}
+
+// job runs operation of the LPAR and waits for the job to complete
+func (d *hmcDriver) job(ctx context.Context, s *hmcSession, p hmcPartition, operation string,
+	params ...string) error {
+	job, err := s.submit(ctx, p, operation, params...)
+	if err != nil {
+		return err
+	}
+
+	path := "/rest/api/uom/jobs/" + url.PathEscape(job.ID)
+
+	for {
+		switch job.Status {
+		case "COMPLETED_OK":
+			return nil
+		case "NOT_STARTED", "RUNNING":
+		default:
+			return fmt.Errorf("%w: %s: %s: %s", ErrHMCJobFailed, operation, job.Status, job.Message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d.poll):
+		}
+
+		job = hmcJob{}
+		if err := s.request(ctx, http.MethodGet, path, "", nil, "JobResponse", &job); err != nil {
+			return err
+		}
+	}
+}
+
+// withSession calls fn with a session of the HMC. Sessions expire when
+// unused for a while, so fn is called again with a new session if the
+// session was rejected.
+func (d *hmcDriver) withSession(ctx context.Context, opts map[string]interface{},
+	fn func(*hmcSession) error) error {
+	key := hmcSessionKey(opts)
+
+	d.mutex.Lock()
+	s, ok := d.sessions[key]
+	d.mutex.Unlock()
+
+	if ok {
+		err := fn(s)
+		if !errors.Is(err, ErrAuthFailed) {
+			return err
+		}
+	}
+
+	s, err := logonHMC(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.sessions[key] = s
+	d.mutex.Unlock()
+
+	return fn(s)
+}
+
+// hmcSessionKey identifies the HMC and credentials of a session. The
+// password is hashed, so it isn't kept in memory longer than needed.
+func hmcSessionKey(opts map[string]interface{}) string {
+	sum := sha256.Sum256([]byte(stringOpt(opts, "power_pass")))
+
+	return strings.Join([]string{
+		stringOpt(opts, "power_address"), stringOpt(opts, "power_port"),
+		stringOpt(opts, "power_user"), stringOpt(opts, "power_verify_ssl"),
+		stringOpt(opts, optCertFingerprint), hex.EncodeToString(sum[:]),
+	}, "\x00")
+}
+
+// logonHMC logs on to the HMC with power_user and power_pass
+func logonHMC(ctx context.Context, opts map[string]interface{}) (*hmcSession, error) {
+	tlsConfig, err := verifiedTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	port := stringOpt(opts, "power_port")
+	if port == "" {
+		port = defaultHMCPort
+	}
+
+	s := &hmcSession{
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		base:   &url.URL{Scheme: "https", Host: net.JoinHostPort(stringOpt(opts, "power_address"), port)},
+	}
+
+	logon := struct {
+		XMLName       xml.Name `xml:"LogonRequest"`
+		Namespace     string   `xml:"xmlns,attr"`
+		SchemaVersion string   `xml:"schemaVersion,attr"`
+		UserID        string   `xml:"UserID"`
+		Password      string   `xml:"Password"`
+	}{
+		Namespace:     hmcNamespace,
+		SchemaVersion: "V1_0",
+		UserID:        stringOpt(opts, "power_user"),
+		Password:      stringOpt(opts, "power_pass"),
+	}
+
+	var resp struct {
+		Token string `xml:"X-API-Session"`
+	}
+
+	if err := s.request(ctx, http.MethodPut, "/rest/api/web/Logon", "LogonRequest", logon,
+		"LogonResponse", &resp); err != nil {
+		return nil, err
+	}
+
+	s.token = resp.Token
+
+	return s, nil
+}
+
+// partition returns the LPAR named lpar of the managed system server
+func (s *hmcSession) partition(ctx context.Context, server, lpar string) (hmcPartition, error) {
+	var system struct {
+		ID string `xml:"id"`
+	}
+
+	path := "/rest/api/uom/ManagedSystem/search/(SystemName==" + url.PathEscape(server) + ")"
+
+	if err := s.request(ctx, http.MethodGet, path, "", nil, "entry", &system); err != nil {
+		return hmcPartition{}, err
+	}
+
+	if system.ID == "" {
+		return hmcPartition{}, fmt.Errorf("%w: managed system %q", ErrInstanceNotFound, server)
+	}
+
+	var feed struct {
+		Entries []struct {
+			Partition hmcPartition `xml:"content>LogicalPartition"`
+		} `xml:"entry"`
+	}
+
+	path = "/rest/api/uom/ManagedSystem/" + url.PathEscape(system.ID) + "/LogicalPartition"
+
+	if err := s.request(ctx, http.MethodGet, path, "", nil, "feed", &feed); err != nil {
+		return hmcPartition{}, err
+	}
+
+	for _, e := range feed.Entries {
+		if e.Partition.Name == lpar {
+			return e.Partition, nil
+		}
+	}
+
+	return hmcPartition{}, fmt.Errorf("%w: LPAR %q of %q", ErrInstanceNotFound, lpar, server)
+}
+
+// submit starts operation of the LPAR with job parameters given as name
+// and value pairs
+func (s *hmcSession) submit(ctx context.Context, p hmcPartition, operation string,
+	params ...string) (hmcJob, error) {
+	type jobParameter struct {
+		SchemaVersion string `xml:"schemaVersion,attr"`
+		Name          string `xml:"ParameterName"`
+		Value         string `xml:"ParameterValue"`
+	}
+
+	req := struct {
+		XMLName       xml.Name       `xml:"JobRequest"`
+		Namespace     string         `xml:"xmlns,attr"`
+		SchemaVersion string         `xml:"schemaVersion,attr"`
+		Operation     string         `xml:"RequestedOperation>OperationName"`
+		Group         string         `xml:"RequestedOperation>GroupName"`
+		Parameters    []jobParameter `xml:"JobParameters>JobParameter"`
+	}{
+		Namespace:     hmcNamespace,
+		SchemaVersion: "V1_0",
+		Operation:     operation,
+		Group:         "LogicalPartition",
+	}
+
+	for i := 0; i+1 < len(params); i += 2 {
+		req.Parameters = append(req.Parameters, jobParameter{
+			SchemaVersion: "V1_0", Name: params[i], Value: params[i+1],
+		})
+	}
+
+	var job hmcJob
+
+	path := "/rest/api/uom/LogicalPartition/" + url.PathEscape(p.UUID) + "/do/" + operation
+
+	if err := s.request(ctx, http.MethodPut, path, "JobRequest", req, "JobResponse", &job); err != nil {
+		return hmcJob{}, err
+	}
+
+	return job, nil
+}
+
+// request sends a request of the HMC REST API with XML body in (if any),
+// and decodes the first element named element of the response into out.
+// Resources are wrapped in Atom entries and feeds, which are looked through
+// this way.
+func (s *hmcSession) request(ctx context.Context, method, path, inType string, in interface{},
+	element string, out interface{}) error {
+	var body io.Reader
+
+	if in != nil {
+		b, err := xml.Marshal(in)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	// Paths are escaped already, as search expressions are part of them
+	req, err := http.NewRequestWithContext(ctx, method, s.base.String()+path, body)
+	if err != nil {
+		return err
+	}
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/vnd.ibm.powervm.web+xml; type="+inType)
+	}
+
+	if s.token != "" {
+		req.Header.Set("X-API-Session", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, hmcResponseLimit))
+	if err != nil {
+		return err
+	}
+
+	// Searches without result are answered with No Content
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var fault struct {
+			Message string `xml:"Message"`
+		}
+
+		if decodeXMLElement(b, "HttpErrorResponse", &fault) == nil && fault.Message != "" {
+			return fmt.Errorf("%w: %s %s: %s: %s", responseError(resp.StatusCode),
+				method, path, resp.Status, fault.Message)
+		}
+
+		return fmt.Errorf("%w: %s %s: %s", responseError(resp.StatusCode), method, path, resp.Status)
+	}
+
+	if err := decodeXMLElement(b, element, out); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+	}
+
+	return nil
+}
+
+// decodeXMLElement decodes the first element of b with local name into v
+func decodeXMLElement(b []byte, name string, v interface{}) error {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("no %s element", name)
+			}
+
+			return err
+		}
+
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == name {
+			return dec.DecodeElement(v, &start)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// fakeHMC is an HMC managing system "sys1" with LPARs "lpar1" and "lpar2".
+// Jobs take one more query to complete.
+type fakeHMC struct {
+	state   string
+	token   string
+	fail    string
+	logons  int
+	jobs    []string
+	pending string
+	mutex   sync.Mutex
+}
+
+func (f *fakeHMC) serve(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		if r.Method == http.MethodPut && r.URL.Path == "/rest/api/web/Logon" {
+			var req struct {
+				UserID   string `xml:"UserID"`
+				Password string `xml:"Password"`
+			}
+
+			require.NoError(t, xml.NewDecoder(r.Body).Decode(&req))
+
+			if req.UserID != "hscroot" || req.Password != "abc123" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`<HttpErrorResponse><Message>Logon failed</Message></HttpErrorResponse>`))
+
+				return
+			}
+
+			f.logons++
+			f.token = fmt.Sprintf("session-%d", f.logons)
+
+			w.Write([]byte(`<LogonResponse xmlns="` + hmcNamespace + `">` +
+				`<X-API-Session kb="ROR">` + f.token + `</X-API-Session></LogonResponse>`))
+
+			return
+		}
+
+		if r.Header.Get("X-API-Session") != f.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/rest/api/uom/ManagedSystem/search/(SystemName==sys1)":
+			w.Write([]byte(`<feed xmlns="http://www.w3.org/2005/Atom"><id>feed</id>` +
+				`<entry><id>ms-uuid</id><content type="application/vnd.ibm.powervm.uom+xml; ` +
+				`type=ManagedSystem"><ManagedSystem:ManagedSystem xmlns:ManagedSystem="` + hmcNamespace +
+				`"><SystemName>sys1</SystemName></ManagedSystem:ManagedSystem></content></entry></feed>`))
+		case strings.HasPrefix(r.URL.Path, "/rest/api/uom/ManagedSystem/search/"):
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/rest/api/uom/ManagedSystem/ms-uuid/LogicalPartition":
+			w.Write([]byte(`<feed xmlns="http://www.w3.org/2005/Atom"><id>ms-uuid</id>` +
+				hmcTestEntry("lpar2-uuid", "lpar2", "running") +
+				hmcTestEntry("lpar1-uuid", "lpar1", f.state) + `</feed>`))
+		case strings.HasPrefix(r.URL.Path, "/rest/api/uom/LogicalPartition/lpar1-uuid/do/"):
+			var req struct {
+				Operation  string `xml:"RequestedOperation>OperationName"`
+				Parameters []struct {
+					Name  string `xml:"ParameterName"`
+					Value string `xml:"ParameterValue"`
+				} `xml:"JobParameters>JobParameter"`
+			}
+
+			require.NoError(t, xml.NewDecoder(r.Body).Decode(&req))
+
+			job := req.Operation
+			for _, p := range req.Parameters {
+				job += " " + p.Name + "=" + p.Value
+			}
+
+			f.jobs = append(f.jobs, job)
+			f.pending = req.Operation
+
+			w.Write([]byte(`<entry xmlns="http://www.w3.org/2005/Atom"><content>` +
+				`<JobResponse:JobResponse xmlns:JobResponse="` + hmcNamespace + `">` +
+				`<JobID>1</JobID><Status>RUNNING</Status></JobResponse:JobResponse></content></entry>`))
+		case r.URL.Path == "/rest/api/uom/jobs/1":
+			status := "COMPLETED_OK"
+
+			switch {
+			case f.fail != "":
+				status = "FAILED_BEFORE_COMPLETION"
+			case f.pending == "PowerOn":
+				f.state = "running"
+			case f.pending == "PowerOff":
+				f.state = "not activated"
+			}
+
+			f.pending = ""
+
+			w.Write([]byte(`<JobResponse xmlns="` + hmcNamespace + `"><JobID>1</JobID><Status>` + status +
+				`</Status><ResponseException><Message>` + f.fail + `</Message></ResponseException>` +
+				`</JobResponse>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func hmcTestEntry(uuid, name, state string) string {
+	return `<entry><id>` + uuid + `</id><content type="application/vnd.ibm.powervm.uom+xml; ` +
+		`type=LogicalPartition"><LogicalPartition:LogicalPartition xmlns:LogicalPartition="` + hmcNamespace +
+		`"><PartitionName>` + name + `</PartitionName><PartitionState>` + state + `</PartitionState>` +
+		`<PartitionUUID>` + uuid + `</PartitionUUID></LogicalPartition:LogicalPartition></content></entry>`
+}
+
+func hmcTestOpts(server *httptest.Server) map[string]interface{} {
+	u, _ := url.Parse(server.URL)
+
+	return map[string]interface{}{
+		"power_address": u.Hostname(),
+		"power_port":    u.Port(),
+		"power_user":    "hscroot",
+		"power_pass":    "abc123",
+		"server_name":   "sys1",
+		"lpar":          "lpar1",
+		"hmc_api":       "rest",
+	}
+}
+
+func newTestHMCDriver() *hmcDriver {
+	d := newHMCDriver()
+	d.poll = time.Millisecond
+
+	return d
+}
+
+func TestHMCDriver(t *testing.T) {
+	powerOff := "PowerOff operation=shutdown immediate=true"
+	powerOn := "PowerOn bootmode=norm bootstring=network-all"
+
+	testcases := map[string]struct {
+		action  string
+		initial string
+		opts    map[string]interface{}
+		state   string
+		status  string
+		jobs    []string
+	}{
+		"on": {
+			action:  "on",
+			initial: "not activated",
+			state:   "on",
+			status:  "running",
+			jobs:    []string{powerOn},
+		},
+		"on restarts running lpar": {
+			action:  "on",
+			initial: "running",
+			state:   "on",
+			status:  "running",
+			jobs:    []string{powerOff, powerOn},
+		},
+		"on with boot mode": {
+			action:  "on",
+			initial: "not activated",
+			opts:    map[string]interface{}{"lpar_boot_mode": "sms"},
+			state:   "on",
+			status:  "running",
+			jobs:    []string{"PowerOn bootmode=sms bootstring=network-all"},
+		},
+		"off": {
+			action:  "off",
+			initial: "open firmware",
+			state:   "off",
+			status:  "not activated",
+			jobs:    []string{powerOff},
+		},
+		"off already off": {
+			action:  "off",
+			initial: "not activated",
+			state:   "off",
+			status:  "not activated",
+		},
+		"soft-off": {
+			action:  "soft-off",
+			initial: "running",
+			state:   "on",
+			status:  "running",
+			jobs:    []string{"PowerOff operation=osshutdown"},
+		},
+		"cycle": {
+			action:  "cycle",
+			initial: "running",
+			state:   "on",
+			status:  "running",
+			jobs:    []string{powerOff, powerOn},
+		},
+		"status": {
+			action:  "status",
+			initial: "Starting",
+			state:   "on",
+			status:  "Starting",
+		},
+		"status error": {
+			action:  "status",
+			initial: "error",
+			state:   "unknown",
+			status:  "error",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := &fakeHMC{state: tc.initial}
+			opts := hmcTestOpts(f.serve(t))
+			d := newTestHMCDriver()
+
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+
+			require.True(t, d.Supports(opts))
+
+			state, details, err := runDriver(context.Background(), d, tc.action, opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, state)
+			assert.Equal(t, tc.status, details.Status)
+
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+
+			assert.Equal(t, tc.jobs, f.jobs)
+		})
+	}
+}
+
+func TestHMCDriverSession(t *testing.T) {
+	f := &fakeHMC{state: "running"}
+	opts := hmcTestOpts(f.serve(t))
+	d := newTestHMCDriver()
+
+	for i := 0; i < 2; i++ {
+		_, _, err := d.Status(context.Background(), opts)
+		require.NoError(t, err)
+	}
+
+	f.mutex.Lock()
+	assert.Equal(t, 1, f.logons)
+	// Session expires
+	f.token = "expired"
+	f.mutex.Unlock()
+
+	state, _, err := d.Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "on", state)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	assert.Equal(t, 2, f.logons)
+}
+
+func TestHMCDriverErrors(t *testing.T) {
+	f := &fakeHMC{state: "not activated"}
+	server := f.serve(t)
+
+	opts := hmcTestOpts(server)
+	opts["power_pass"] = "wrong"
+
+	_, _, err := newTestHMCDriver().Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	assert.ErrorContains(t, err, "Logon failed")
+
+	opts = hmcTestOpts(server)
+	opts["server_name"] = "sys2"
+
+	_, _, err = newTestHMCDriver().Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+
+	opts = hmcTestOpts(server)
+	opts["lpar"] = "lpar3"
+
+	_, _, err = newTestHMCDriver().Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+
+	opts = hmcTestOpts(server)
+	opts["lpar_boot_mode"] = "cdrom"
+
+	_, _, err = newTestHMCDriver().On(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrUnsupportedBootMode)
+
+	f.mutex.Lock()
+	f.fail = "HSCL0DB4 The partition is not in a valid state"
+	f.mutex.Unlock()
+
+	_, _, err = newTestHMCDriver().On(context.Background(), hmcTestOpts(server))
+	assert.ErrorIs(t, err, ErrHMCJobFailed)
+	assert.Equal(t, errcode.PowerOperationFailed, errcode.Of(err))
+	assert.ErrorContains(t, err, "HSCL0DB4")
+}
+
+func TestHMCDriverSupports(t *testing.T) {
+	opts := map[string]interface{}{
+		"power_address": "10.0.0.4", "power_user": "hscroot", "power_pass": "abc123",
+		"server_name": "sys1", "lpar": "lpar1",
+	}
+
+	d := newHMCDriver()
+
+	assert.False(t, d.Supports(opts), "SSH is used unless the REST API is selected")
+
+	opts["hmc_api"] = "ssh"
+	assert.False(t, d.Supports(opts))
+
+	opts["hmc_api"] = "rest"
+	assert.True(t, d.Supports(opts))
+
+	delete(opts, "lpar")
+	assert.False(t, d.Supports(opts))
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+const (
+	// hostQueryConcurrency is how many VMs are queried at once, when the
+	// host can't be listed natively
+	hostQueryConcurrency = 8
+	// hostQueryTimeout is how long querying all VMs of a host can take
+	hostQueryTimeout = 5 * time.Minute
+)
+
+// PowerQueryHostParam is the parameter of power-query-host workflow
+type PowerQueryHostParam struct {
+	// PowerParam are power parameters of the VM host or chassis, without
+	// the name of a VM (power_id or instance_name) or node
+	PowerParam
+	// Instances are names of MAAS-managed VMs of the host, or addresses
+	// of nodes of a chassis (power_hwaddress)
+	Instances []string `json:"instances"`
+}
+
+// PowerQueryHostResult is the result of power-query-host workflow
+type PowerQueryHostResult struct {
+	// States are power states of VMs by their names
+	States map[string]string `json:"states"`
+	// Missing are VMs which don't exist on the host
+	Missing []string `json:"missing,omitempty"`
+	// Errors are errors of VMs which power state could not be queried
+	Errors map[string]string `json:"errors,omitempty"`
+	// ErrorCodes are codes of Errors, if they have one
+	ErrorCodes map[string]string `json:"error_codes,omitempty"`
+}
+
+// powerQueryHost returns power states of all MAAS-managed VMs of a VM host
+// in a single call, so the Region can refresh machines of the host without
+// a workflow per machine.
+func (s *PowerService) powerQueryHost(ctx tworkflow.Context,
+	param PowerQueryHostParam) (*PowerQueryHostResult, error) {
+	ctx = tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:           fmt.Sprintf("%s@agent:power", s.systemID),
+		StartToCloseTimeout: hostQueryTimeout,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+
+	var result PowerQueryHostResult
+
+	if err := tworkflow.ExecuteActivity(ctx, "query-host-power-states", param).Get(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// QueryHostPowerStates lists VMs of the host once, if the host can be
+// reached natively (e.g. virsh without password). Otherwise VMs are
+// queried one by one, a few of them at once. Nodes of a chassis are queried
+// within a single session of its BMC.
+func (s *PowerService) QueryHostPowerStates(ctx context.Context,
+	param PowerQueryHostParam) (*PowerQueryHostResult, error) {
+	result := &PowerQueryHostResult{States: make(map[string]string, len(param.Instances))}
+
+	if len(param.Instances) == 0 {
+		return result, nil
+	}
+
+	d, ok := s.hosts[param.DriverType]
+	if !ok {
+		if c, ok := s.drivers.chassis(param.DriverType); ok {
+			return s.queryChassis(ctx, c, param)
+		}
+
+		return nil, fmt.Errorf("%w: %s is not a VM host or chassis driver", ErrUnsupportedPowerAction,
+			param.DriverType)
+	}
+
+	opts := s.driverOpts(ctx, param.DriverType, param.DriverOpts)
+
+	// VMs of hosts left to the MAAS power CLI are queried one by one
+	if _, _, ok := d.key(instanceOpts(opts, d.instanceOpt, param.Instances[0])); ok &&
+		s.drivers.registered(param.DriverType) {
+		listCtx, cancel := s.commandContext(ctx, param.PowerParam)
+		defer cancel()
+
+		states, err := d.list(listCtx, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, instance := range param.Instances {
+			if state, ok := states[instance]; ok {
+				result.States[instance] = state
+			} else {
+				result.Missing = append(result.Missing, instance)
+			}
+		}
+
+		return result, nil
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+
+	sem := make(chan struct{}, hostQueryConcurrency)
+
+	for _, instance := range param.Instances {
+		instance := instance
+
+		wg.Add(1)
+
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			p := param.PowerParam
+			p.DriverOpts = instanceOpts(param.DriverOpts, d.instanceOpt, instance)
+
+			state, _, err := s.power(ctx, "status", p)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				result.setError(instance, err)
+				return
+			}
+
+			result.States[instance] = state
+		}()
+	}
+
+	wg.Wait()
+
+	return result, nil
+}
+
+// queryChassis queries all nodes of the chassis at once
+func (s *PowerService) queryChassis(ctx context.Context, d ChassisPowerDriver,
+	param PowerQueryHostParam) (*PowerQueryHostResult, error) {
+	ctx, cancel := s.commandContext(ctx, param.PowerParam)
+	defer cancel()
+
+	states, errs, err := d.QueryNodes(ctx, param.DriverOpts, param.Instances)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PowerQueryHostResult{States: states}
+
+	for node, err := range errs {
+		result.setError(node, err)
+	}
+
+	return result, nil
+}
+
+// setError records err of the VM or node
+func (r *PowerQueryHostResult) setError(instance string, err error) {
+	if r.Errors == nil {
+		r.Errors = make(map[string]string)
+	}
+
+	r.Errors[instance] = err.Error()
+
+	if code := errcode.Of(err); code != "" {
+		if r.ErrorCodes == nil {
+			r.ErrorCodes = make(map[string]string)
+		}
+
+		r.ErrorCodes[instance] = code
+	}
+}
+
+// instanceOpts returns opts of the VM host with the name of a VM
+func instanceOpts(opts map[string]interface{}, key, instance string) map[string]interface{} {
+	result := make(map[string]interface{}, len(opts)+1)
+	for k, v := range opts {
+		result[k] = v
+	}
+
+	result[key] = instance
+
+	return result
+}
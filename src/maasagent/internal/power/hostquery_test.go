@@ -0,0 +1,167 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// fakeVMDriver reports states of VMs by power_id
+type fakeVMDriver struct {
+	fakeDriver
+	states map[string]string
+	calls  atomic.Int32
+}
+
+func (d *fakeVMDriver) Status(_ context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	d.calls.Add(1)
+
+	state, ok := d.states[stringOpt(opts, "power_id")]
+	if !ok {
+		return "", PowerDetails{}, errors.New("domain not found")
+	}
+
+	return state, PowerDetails{}, nil
+}
+
+func TestQueryHostPowerStates(t *testing.T) {
+	states := map[string]string{"vm0": "on", "vm1": "off", "vm2": "on", "other": "on"}
+
+	testcases := map[string]struct {
+		native  bool
+		result  *PowerQueryHostResult
+		listed  int32
+		queried int32
+	}{
+		"listed at once": {
+			native: true,
+			result: &PowerQueryHostResult{
+				States:  map[string]string{"vm0": "on", "vm1": "off", "vm2": "on"},
+				Missing: []string{"vm3"},
+			},
+			listed: 1,
+		},
+		"queried one by one": {
+			result: &PowerQueryHostResult{
+				States: map[string]string{"vm0": "on", "vm1": "off", "vm2": "on"},
+				Errors: map[string]string{"vm3": "domain not found"},
+			},
+			queried: 4,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var listed atomic.Int32
+
+			driver := &fakeVMDriver{fakeDriver: fakeDriver{supported: true}, states: states}
+
+			s := NewPowerService("abc", nil, WithDriver("fake", driver))
+			s.hosts["fake"] = batchDriver{
+				key: func(opts map[string]interface{}) (string, string, bool) {
+					return stringOpt(opts, "power_address"), stringOpt(opts, "power_id"), tc.native
+				},
+				list: func(context.Context, map[string]interface{}) (map[string]string, error) {
+					listed.Add(1)
+					return states, nil
+				},
+				instanceOpt: "power_id",
+			}
+
+			result, err := s.QueryHostPowerStates(context.Background(), PowerQueryHostParam{
+				PowerParam: PowerParam{
+					DriverType: "fake",
+					DriverOpts: map[string]interface{}{"power_address": "qemu+ssh://ubuntu@host/system"},
+				},
+				Instances: []string{"vm0", "vm1", "vm2", "vm3"},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.result, result)
+			assert.Equal(t, tc.listed, listed.Load())
+			assert.Equal(t, tc.queried, driver.calls.Load())
+		})
+	}
+}
+
+// fakeChassisDriver reports states of nodes by their address
+type fakeChassisDriver struct {
+	fakeDriver
+	states  map[string]string
+	queries atomic.Int32
+}
+
+func (d *fakeChassisDriver) QueryNodes(_ context.Context, _ map[string]interface{},
+	nodes []string) (map[string]string, map[string]error, error) {
+	d.queries.Add(1)
+
+	states := make(map[string]string)
+	errs := make(map[string]error)
+
+	for _, node := range nodes {
+		if state, ok := d.states[node]; ok {
+			states[node] = state
+		} else {
+			errs[node] = ErrInvalidNodeAddress
+		}
+	}
+
+	return states, errs, nil
+}
+
+func TestQueryHostPowerStatesChassis(t *testing.T) {
+	driver := &fakeChassisDriver{
+		fakeDriver: fakeDriver{supported: true},
+		states:     map[string]string{"-b 7 -t 0x72": "on", "-b 7 -t 0x74": "off"},
+	}
+
+	s := NewPowerService("abc", nil, WithDriver("fake", driver))
+
+	result, err := s.QueryHostPowerStates(context.Background(), PowerQueryHostParam{
+		PowerParam: PowerParam{
+			DriverType: "fake",
+			DriverOpts: map[string]interface{}{"power_address": "10.0.0.1"},
+		},
+		Instances: []string{"-b 7 -t 0x72", "-b 7 -t 0x74", "-t"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &PowerQueryHostResult{
+		States:     map[string]string{"-b 7 -t 0x72": "on", "-b 7 -t 0x74": "off"},
+		Errors:     map[string]string{"-t": ErrInvalidNodeAddress.Error()},
+		ErrorCodes: map[string]string{"-t": errcode.PowerInvalidParameters},
+	}, result)
+	assert.Equal(t, int32(1), driver.queries.Load())
+}
+
+func TestQueryHostPowerStatesUnsupported(t *testing.T) {
+	s := NewPowerService("abc", nil)
+
+	_, err := s.QueryHostPowerStates(context.Background(), PowerQueryHostParam{
+		PowerParam: PowerParam{DriverType: "ipmi"},
+		Instances:  []string{"vm0"},
+	})
+	assert.ErrorIs(t, err, ErrUnsupportedPowerAction)
+}
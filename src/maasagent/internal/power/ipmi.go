@@ -0,0 +1,337 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/ipmi"
+)
+
+// DriverIPMI is the power driver of IPMI BMCs. Power actions of BMCs
+// using IPMI v2.0 are performed by the Agent directly, other BMCs are left
+// to the MAAS power CLI.
+const DriverIPMI = "ipmi"
+
+const (
+	ipmiDriverLAN2     = "LAN_2_0"
+	ipmiBootTypeEFI    = "efi"
+	ipmiBootTypeLegacy = "legacy"
+	// ipmiHealthOK and ipmiHealthCritical follow Redfish health values,
+	// so details of both drivers can be compared
+	ipmiHealthOK       = "OK"
+	ipmiHealthCritical = "Critical"
+)
+
+// ipmiPrivileges maps privilege_level option of the power driver. Operator
+// is used by default, as it is enough for power actions.
+var ipmiPrivileges = map[string]uint8{
+	"USER":     ipmi.PrivilegeUser,
+	"OPERATOR": ipmi.PrivilegeOperator,
+	"ADMIN":    ipmi.PrivilegeAdmin,
+}
+
+// ipmiBootDevices maps boot devices of set-boot-device to boot flags
+var ipmiBootDevices = map[string]ipmi.BootDevice{
+	BootDevicePXE:  ipmi.BootDevicePXE,
+	BootDeviceDisk: ipmi.BootDeviceDisk,
+	BootDeviceCD:   ipmi.BootDeviceCD,
+}
+
+// ipmiDriver performs power actions of IPMI v2.0 BMCs
+type ipmiDriver struct{}
+
+func (ipmiDriver) Supports(opts map[string]interface{}) bool {
+	return nativeIPMI(opts)
+}
+
+func (ipmiDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return ipmiPower(ctx, opts, "on")
+}
+
+func (ipmiDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return ipmiPower(ctx, opts, "off")
+}
+
+func (ipmiDriver) SoftOff(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return ipmiPower(ctx, opts, "soft-off")
+}
+
+func (ipmiDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return ipmiPower(ctx, opts, "cycle")
+}
+
+func (ipmiDriver) Reset(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return ipmiPower(ctx, opts, "reset")
+}
+
+// SetBootDevice sets the device of the next boot. Note that powering on
+// sets the machine to boot from the network, as the power driver does.
+func (ipmiDriver) SetBootDevice(ctx context.Context, opts map[string]interface{}, device string) error {
+	bootDevice, ok := ipmiBootDevices[device]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedBootDevice, device)
+	}
+
+	s, err := dialIPMI(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // BMC closes idle sessions anyway
+	defer s.Close()
+
+	return s.SetBootDevice(ctx, bootDevice, ipmiEFI(opts))
+}
+
+// BootOrder returns the persistent boot device followed by other devices,
+// as IPMI has no boot order beyond it. Boot order is not known unless the
+// BMC has a persistent boot device.
+func (ipmiDriver) BootOrder(ctx context.Context, opts map[string]interface{}) ([]BootOption, error) {
+	s, err := dialIPMI(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // BMC closes idle sessions anyway
+	defer s.Close()
+
+	flags, err := s.BootFlags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !flags.Valid || !flags.Persistent {
+		return nil, nil
+	}
+
+	var first string
+
+	for device, d := range ipmiBootDevices {
+		if d == flags.Device {
+			first = device
+		}
+	}
+
+	if first == "" {
+		return nil, nil
+	}
+
+	order := []BootOption{{ID: first, Device: first}}
+
+	for _, device := range []string{BootDevicePXE, BootDeviceDisk, BootDeviceCD} {
+		if device != first {
+			order = append(order, BootOption{ID: device, Device: device})
+		}
+	}
+
+	return order, nil
+}
+
+// ApplyBootOrder sets the first device of order as the persistent boot
+// device. Note that powering on still makes the machine boot from the
+// network once, which some BMCs apply by clearing the persistent device.
+func (ipmiDriver) ApplyBootOrder(ctx context.Context, opts map[string]interface{}, order []string) error {
+	if len(order) == 0 {
+		return fmt.Errorf("%w: empty boot order", ErrUnsupportedBootOrder)
+	}
+
+	bootDevice, ok := ipmiBootDevices[order[0]]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedBootDevice, order[0])
+	}
+
+	s, err := dialIPMI(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // BMC closes idle sessions anyway
+	defer s.Close()
+
+	return s.SetPersistentBootDevice(ctx, bootDevice, ipmiEFI(opts))
+}
+
+func (ipmiDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return ipmiPower(ctx, opts, "status")
+}
+
+// nativeIPMI returns true if power of the machine can be controlled by the
+// native IPMI client, which supports IPMI v2.0 with cipher suites 3 and 17,
+// without a BMC key (K_g).
+func nativeIPMI(opts map[string]interface{}) bool {
+	if driver := stringOpt(opts, "power_driver"); driver != "" && driver != ipmiDriverLAN2 {
+		return false
+	}
+
+	if stringOpt(opts, "k_g") != "" {
+		return false
+	}
+
+	switch stringOpt(opts, "cipher_suite_id") {
+	case "", "3", "17":
+		return true
+	default:
+		return false
+	}
+}
+
+// ipmiEFI returns true if boot flags should select EFI boot. Boot type set
+// for the BMC takes precedence over the firmware boot mode of the machine.
+func ipmiEFI(opts map[string]interface{}) bool {
+	switch stringOpt(opts, "power_boot_type") {
+	case ipmiBootTypeEFI:
+		return true
+	case ipmiBootTypeLegacy:
+		return false
+	default:
+		return stringOpt(opts, bootModeOpt) == FirmwareUEFI
+	}
+}
+
+func dialIPMI(ctx context.Context, opts map[string]interface{}) (*ipmi.Session, error) {
+	options := []ipmi.Option{ipmi.WithPrivilege(ipmi.PrivilegeOperator)}
+
+	if stringOpt(opts, "cipher_suite_id") == "17" {
+		options = append(options, ipmi.WithCipherSuite(ipmi.CipherSuite17))
+	}
+
+	if privilege, ok := ipmiPrivileges[stringOpt(opts, "privilege_level")]; ok {
+		options = append(options, ipmi.WithPrivilege(privilege))
+	}
+
+	return ipmi.Dial(ctx, stringOpt(opts, "power_address"), stringOpt(opts, "power_user"),
+		stringOpt(opts, "power_pass"), options...)
+}
+
+// ipmiPower performs action ("on", "off", "soft-off", "cycle", "reset" or
+// "status") and returns the resulting power state of the machine. As with
+// the power driver, machines are set to boot from the network once, when
+// powered on. Soft-off doesn't wait for the OS to shut down. Commands are
+// bridged to the controller of the machine behind the BMC, if bridges are
+// given.
+func ipmiPower(ctx context.Context, opts map[string]interface{}, action string,
+	bridges ...ipmi.Bridge) (string, PowerDetails, error) {
+	var control ipmi.ChassisControl
+
+	switch action {
+	case "status":
+	case "on":
+		control = ipmi.PowerUp
+	case "off":
+		control = ipmi.PowerDown
+	case "soft-off":
+		control = ipmi.SoftShutdown
+	case "cycle":
+		control = ipmi.PowerCycle
+	case "reset":
+		control = ipmi.HardReset
+	default:
+		return "", PowerDetails{}, fmt.Errorf("%w: %q", ErrUnsupportedPowerAction, action)
+	}
+
+	s, err := dialIPMI(ctx, opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // BMC closes idle sessions anyway
+	defer s.Close()
+
+	if len(bridges) > 0 {
+		s = s.Bridge(bridges...)
+	}
+
+	status, err := s.ChassisStatus(ctx)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	want := "on"
+	if control == ipmi.PowerDown || control == ipmi.SoftShutdown {
+		want = "off"
+	}
+
+	if action == "status" || (control != ipmi.PowerCycle && control != ipmi.HardReset &&
+		ipmiState(status) == want) {
+		return ipmiState(status), ipmiDetails(status), nil
+	}
+
+	if (control == ipmi.PowerCycle || control == ipmi.HardReset) && !status.PowerOn {
+		// Power cycle or reset of a chassis which is off has no effect
+		control = ipmi.PowerUp
+	}
+
+	if want == "on" {
+		if err = s.SetBootDevice(ctx, ipmi.BootDevicePXE, ipmiEFI(opts)); err != nil {
+			return "", PowerDetails{}, err
+		}
+	}
+
+	if err = s.ChassisControl(ctx, control); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	if control == ipmi.SoftShutdown {
+		return ipmiState(status), ipmiDetails(status), nil
+	}
+
+	return ipmiWaitPowerState(ctx, s, want)
+}
+
+// ipmiWaitPowerState polls the power state of the chassis until it is want,
+// or powerActionWait passes, in which case the current state is returned.
+func ipmiWaitPowerState(ctx context.Context, s *ipmi.Session, want string) (string, PowerDetails, error) {
+	deadline := time.Now().Add(powerActionWait)
+
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := s.ChassisStatus(ctx)
+		if err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		if ipmiState(status) == want || time.Now().After(deadline) {
+			return ipmiState(status), ipmiDetails(status), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", PowerDetails{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func ipmiState(status *ipmi.ChassisStatus) string {
+	if status.PowerOn {
+		return "on"
+	}
+
+	return "off"
+}
+
+func ipmiDetails(status *ipmi.ChassisStatus) PowerDetails {
+	if status.Faulty() {
+		return PowerDetails{Health: ipmiHealthCritical}
+	}
+
+	return PowerDetails{Health: ipmiHealthOK}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNativeIPMI(t *testing.T) {
+	testcases := map[string]struct {
+		opts   map[string]interface{}
+		native bool
+	}{
+		"defaults": {
+			opts:   map[string]interface{}{"power_address": "10.0.0.1"},
+			native: true,
+		},
+		"lan 2.0 with cipher suite 17": {
+			opts:   map[string]interface{}{"power_driver": "LAN_2_0", "cipher_suite_id": "17"},
+			native: true,
+		},
+		"lan 1.5": {
+			opts: map[string]interface{}{"power_driver": "LAN"},
+		},
+		"unsupported cipher suite": {
+			opts: map[string]interface{}{"power_driver": "LAN_2_0", "cipher_suite_id": "8"},
+		},
+		"BMC key": {
+			opts: map[string]interface{}{"power_driver": "LAN_2_0", "k_g": "key"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.native, nativeIPMI(tc.opts))
+		})
+	}
+}
+
+func TestIPMIEFI(t *testing.T) {
+	testcases := map[string]struct {
+		opts map[string]interface{}
+		efi  bool
+	}{
+		"defaults": {
+			opts: map[string]interface{}{},
+		},
+		"efi boot type": {
+			opts: map[string]interface{}{"power_boot_type": "efi"},
+			efi:  true,
+		},
+		"uefi boot mode": {
+			opts: map[string]interface{}{"power_boot_type": "auto", "boot_mode": FirmwareUEFI},
+			efi:  true,
+		},
+		"legacy boot type takes precedence": {
+			opts: map[string]interface{}{"power_boot_type": "legacy", "boot_mode": FirmwareUEFI},
+		},
+		"legacy boot mode": {
+			opts: map[string]interface{}{"boot_mode": FirmwareLegacy},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.efi, ipmiEFI(tc.opts))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+
+	zlog "github.com/rs/zerolog/log"
+	"go.temporal.io/sdk/activity"
+	tlog "go.temporal.io/sdk/log"
+	wflog "maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+// Execute runs the power action ("on", "off", "cycle" or "status") of the
+// driver outside of Temporal, e.g. for local tools bridged to the Agent.
+// Unlike activities, it returns the resulting power state as reported by
+// the driver, without checking it.
+func (s *PowerService) Execute(ctx context.Context, action string, param PowerParam) (string, error) {
+	out, _, err := s.power(ctx, action, param)
+	if err != nil {
+		return "", err
+	}
+
+	return out, nil
+}
+
+// commandLogger returns the activity logger, or the global one if ctx is
+// not an activity context, with metadata of the request of the power
+// action, if any.
+func commandLogger(ctx context.Context) tlog.Logger {
+	var log tlog.Logger = wflog.NewZerologAdapter(zlog.Logger)
+	if activity.IsActivity(ctx) {
+		log = activity.GetLogger(ctx)
+	}
+
+	if r := requestFrom(ctx); !r.empty() {
+		return tlog.With(log, r.keyVals()...)
+	}
+
+	return log
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	lxdapi "github.com/canonical/lxd/shared/api"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+const (
+	// DriverLXD is the power driver of LXD instances
+	DriverLXD = "lxd"
+	// lxdOperationMargin is how long LXD operations are waited for past
+	// their own timeout
+	lxdOperationMargin = 30 * time.Second
+)
+
+var (
+	// ErrLXDUntrusted is returned when the client certificate is not
+	// trusted by LXD and it could not be added with the trust password
+	ErrLXDUntrusted = errcode.New(errcode.PowerAuthFailed, "certificate is not trusted by LXD")
+	// ErrLXDOperationFailed is returned when an operation of LXD (e.g.
+	// starting an instance) failed
+	ErrLXDOperationFailed = errcode.New(errcode.PowerOperationFailed, "LXD operation failed")
+)
+
+// lxdDriver controls LXD instances over the LXD API, rather than running
+// the power driver for every power action. Connections are pooled and fall
+// back to other cluster members, the same as connections of batched queries.
+type lxdDriver struct {
+	pool    *connPool[*lxdConn]
+	members *lxdMemberCache
+}
+
+func newLXDDriver(members *lxdMemberCache) *lxdDriver {
+	return &lxdDriver{
+		pool:    newConnPool[*lxdConn](defaultConnIdleTimeout, defaultConnHealthPeriod),
+		members: members,
+	}
+}
+
+// Supports returns whether the client certificate is given, which only
+// the power driver can generate otherwise
+func (d *lxdDriver) Supports(opts map[string]interface{}) bool {
+	_, _, ok := lxdBatchKey(opts)
+	return ok
+}
+
+// On starts the instance, or unfreezes it if it is frozen
+func (d *lxdDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(status string) string {
+		switch status {
+		case "Running":
+			return ""
+		case "Frozen":
+			return "unfreeze"
+		}
+
+		return "start"
+	})
+}
+
+func (d *lxdDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(status string) string {
+		if status == "Stopped" {
+			return ""
+		}
+
+		return "stop"
+	})
+}
+
+func (d *lxdDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(status string) string {
+		if status == "Stopped" {
+			return "start"
+		}
+
+		return "restart"
+	})
+}
+
+func (d *lxdDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(string) string { return "" })
+}
+
+// do changes state of the instance with the action fn returns for its
+// current status, if any. Power state and status of the instance are
+// returned as LXD reports them afterwards.
+func (d *lxdDriver) do(ctx context.Context, opts map[string]interface{},
+	fn func(status string) string) (string, PowerDetails, error) {
+	key, instance, _ := lxdBatchKey(opts)
+	project := lxdProject(opts)
+
+	var status string
+
+	err := d.pool.use(ctx, key,
+		func(ctx context.Context) (*lxdConn, error) { return d.dial(ctx, opts) },
+		func(c *lxdConn) error {
+			var err error
+
+			status, err = c.instanceStatus(ctx, project, instance)
+			if err != nil {
+				return err
+			}
+
+			action := fn(status)
+			if action == "" {
+				return nil
+			}
+
+			if err = c.changeState(ctx, project, instance, action); err != nil {
+				return err
+			}
+
+			status, err = c.instanceStatus(ctx, project, instance)
+
+			return err
+		})
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return lxdPowerState(status), PowerDetails{Status: status}, nil
+}
+
+// dial connects to LXD and makes sure the client certificate is trusted
+func (d *lxdDriver) dial(ctx context.Context, opts map[string]interface{}) (*lxdConn, error) {
+	c, err := dialLXDCluster(ctx, opts, d.members)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.trust(ctx, stringOpt(opts, "password")); err != nil {
+		//nolint:errcheck // should be safe to ignore an error from Close()
+		c.Close()
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// trust adds the client certificate to the trust store of LXD with the
+// trust password, unless LXD already trusts it
+func (c *lxdConn) trust(ctx context.Context, password string) error {
+	server, _, err := c.use(ctx, "").GetServer()
+	if err != nil {
+		return lxdError(err)
+	}
+
+	if server.Auth != "untrusted" {
+		return nil
+	}
+
+	if password == "" {
+		return ErrLXDUntrusted
+	}
+
+	err = c.use(ctx, "").CreateCertificate(lxdapi.CertificatesPost{
+		CertificatePut: lxdapi.CertificatePut{Type: lxdapi.CertificateTypeClient},
+		Password:       password,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrLXDUntrusted, err)
+	}
+
+	// API extensions the client checks are only reported to trusted clients
+	_, _, err = c.server.GetServer()
+
+	return lxdError(err)
+}
+
+// instanceStatus returns status of the instance, e.g. "Running"
+func (c *lxdConn) instanceStatus(ctx context.Context, project, instance string) (string, error) {
+	state, _, err := c.use(ctx, project).GetInstanceState(instance)
+	if err != nil {
+		return "", lxdError(err)
+	}
+
+	return state.Status, nil
+}
+
+// changeState forces the instance to change state with action (e.g.
+// "start" or "stop") and waits for the operation to complete
+func (c *lxdConn) changeState(ctx context.Context, project, instance, action string) error {
+	op, err := c.use(ctx, project).UpdateInstanceState(instance, lxdapi.InstanceStatePut{
+		Action:  action,
+		Force:   true,
+		Timeout: -1,
+	}, "")
+	if err != nil {
+		return lxdError(err)
+	}
+
+	// Operations are waited for until ctx is done
+	if err := op.WaitContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("%w: %s: %w", ErrLXDOperationFailed, action, err)
+	}
+
+	return nil
+}
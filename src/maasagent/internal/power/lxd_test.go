@@ -0,0 +1,263 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLXD serves state of a single instance "vm1" of project "maas"
+type fakeLXD struct {
+	mu       sync.Mutex
+	status   string
+	trusted  bool
+	password string
+	actions  []string
+}
+
+func newFakeLXD(t *testing.T, l *fakeLXD) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /1.0":
+			auth := "trusted"
+			if !l.trusted {
+				auth = "untrusted"
+			}
+
+			w.Write([]byte(`{"type":"sync","metadata":{"auth":"` + auth + `","api_extensions":["instances"]}}`))
+		case "POST /1.0/certificates":
+			var req struct {
+				Type     string `json:"type"`
+				Password string `json:"password"`
+			}
+
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+			if req.Type != "client" || req.Password != l.password {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"type":"error","error":"not authorized","error_code":403}`))
+
+				return
+			}
+
+			l.trusted = true
+
+			w.Write([]byte(`{"type":"sync","metadata":{}}`))
+		case "GET /1.0/instances/vm1/state":
+			assert.Equal(t, "maas", r.URL.Query().Get("project"))
+
+			if !l.trusted {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"type":"error","error":"not authorized","error_code":403}`))
+
+				return
+			}
+
+			w.Write([]byte(`{"type":"sync","metadata":{"status":"` + l.status + `"}}`))
+		case "PUT /1.0/instances/vm1/state":
+			var req struct {
+				Action string `json:"action"`
+				Force  bool   `json:"force"`
+			}
+
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.True(t, req.Force)
+
+			l.actions = append(l.actions, req.Action)
+
+			switch req.Action {
+			case "start", "unfreeze", "restart":
+				l.status = "Running"
+			case "stop":
+				l.status = "Stopped"
+			}
+
+			// Operation is reported complete already, so the client
+			// doesn't wait for its events
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"type":"async","operation":"/1.0/operations/op1",` +
+				`"metadata":{"id":"op1","status":"Success","status_code":200}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"type":"error","error":"not found","error_code":404}`))
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestLXDDriver(t *testing.T) {
+	testcases := map[string]struct {
+		action  string
+		initial string
+		state   string
+		status  string
+		actions []string
+	}{
+		"on": {
+			action:  "on",
+			initial: "Stopped",
+			state:   "on",
+			status:  "Running",
+			actions: []string{"start"},
+		},
+		"on unfreezes": {
+			action:  "on",
+			initial: "Frozen",
+			state:   "on",
+			status:  "Running",
+			actions: []string{"unfreeze"},
+		},
+		"on already on": {
+			action:  "on",
+			initial: "Running",
+			state:   "on",
+			status:  "Running",
+		},
+		"off": {
+			action:  "off",
+			initial: "Running",
+			state:   "off",
+			status:  "Stopped",
+			actions: []string{"stop"},
+		},
+		"off already off": {
+			action:  "off",
+			initial: "Stopped",
+			state:   "off",
+			status:  "Stopped",
+		},
+		"cycle": {
+			action:  "cycle",
+			initial: "Running",
+			state:   "on",
+			status:  "Running",
+			actions: []string{"restart"},
+		},
+		"cycle off": {
+			action:  "cycle",
+			initial: "Stopped",
+			state:   "on",
+			status:  "Running",
+			actions: []string{"start"},
+		},
+		"status frozen": {
+			action:  "status",
+			initial: "Frozen",
+			state:   "on",
+			status:  "Frozen",
+		},
+		"status error": {
+			action:  "status",
+			initial: "Error",
+			state:   "unknown",
+			status:  "Error",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := &fakeLXD{status: tc.initial, trusted: true}
+			server := newFakeLXD(t, l)
+			cert, key := newClientCertificate(t)
+
+			d := newLXDDriver(newLXDMemberCache())
+			opts := map[string]interface{}{
+				"power_address": server.URL,
+				"instance_name": "vm1",
+				"project":       "maas",
+				"certificate":   cert,
+				"key":           key,
+			}
+
+			require.True(t, d.Supports(opts))
+
+			state, details, err := runDriver(context.Background(), d, tc.action, opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, state)
+			assert.Equal(t, tc.status, details.Status)
+			assert.Equal(t, tc.actions, l.actions)
+		})
+	}
+}
+
+func TestLXDDriverTrust(t *testing.T) {
+	testcases := map[string]struct {
+		password string
+		err      bool
+	}{
+		"trust password": {
+			password: "secret",
+		},
+		"wrong trust password": {
+			password: "wrong",
+			err:      true,
+		},
+		"no trust password": {
+			err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := &fakeLXD{status: "Running", password: "secret"}
+			server := newFakeLXD(t, l)
+			cert, key := newClientCertificate(t)
+
+			opts := map[string]interface{}{
+				"power_address": server.URL,
+				"instance_name": "vm1",
+				"project":       "maas",
+				"certificate":   cert,
+				"key":           key,
+				"password":      tc.password,
+			}
+
+			state, _, err := newLXDDriver(nil).Status(context.Background(), opts)
+			if tc.err {
+				assert.ErrorIs(t, err, ErrLXDUntrusted)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "on", state)
+			assert.True(t, l.trusted)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	lxdMemberOnline       = "Online"
+	lxdMemberProbeTimeout = 2 * time.Second
+)
+
+// LXDClusterMember is a member of an LXD cluster with its capacity.
+// Capacity is only reported for online members.
+type LXDClusterMember struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Cores is the number of logical CPUs
+	Cores int `json:"cores"`
+	// MemoryTotal and MemoryUsed are in bytes
+	MemoryTotal int64 `json:"memory_total"`
+	MemoryUsed  int64 `json:"memory_used"`
+}
+
+// lxdMemberCache remembers URLs of online members of LXD clusters, keyed
+// by the configured power address, so that another member can be used
+// when the configured one is down.
+type lxdMemberCache struct {
+	members      map[string][]string
+	probeTimeout time.Duration
+	mutex        sync.Mutex
+}
+
+func newLXDMemberCache() *lxdMemberCache {
+	return &lxdMemberCache{
+		members:      make(map[string][]string),
+		probeTimeout: lxdMemberProbeTimeout,
+	}
+}
+
+func (c *lxdMemberCache) set(address string, members []LXDClusterMember) {
+	if c == nil {
+		return
+	}
+
+	urls := make([]string, 0, len(members))
+
+	for _, m := range members {
+		if m.Status == lxdMemberOnline {
+			urls = append(urls, m.URL)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(urls) == 0 {
+		delete(c.members, address)
+		return
+	}
+
+	c.members[address] = urls
+}
+
+// addresses returns the configured address followed by other known members
+func (c *lxdMemberCache) addresses(address string) []string {
+	result := []string{address}
+
+	if c == nil {
+		return result
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	primary, err := lxdURL(address)
+
+	for _, m := range c.members[address] {
+		if u, uerr := lxdURL(m); err == nil && uerr == nil && u.Host == primary.Host {
+			continue
+		}
+
+		result = append(result, m)
+	}
+
+	return result
+}
+
+// reachable returns the first address from addresses() which accepts TCP
+// connections, or the configured address if none does.
+func (c *lxdMemberCache) reachable(ctx context.Context, address string) string {
+	addresses := c.addresses(address)
+	if len(addresses) == 1 {
+		return address
+	}
+
+	dialer := net.Dialer{Timeout: c.probeTimeout}
+
+	for _, a := range addresses {
+		u, err := lxdURL(a)
+		if err != nil {
+			continue
+		}
+
+		conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+		if err != nil {
+			continue
+		}
+
+		//nolint:errcheck // connection was only used as a probe
+		conn.Close()
+
+		return a
+	}
+
+	return address
+}
+
+// dialLXDCluster connects to the configured LXD host, falling back to other
+// known members of its cluster if the host is down. Known members are
+// refreshed on every successful connection.
+func dialLXDCluster(ctx context.Context, opts map[string]interface{},
+	members *lxdMemberCache) (*lxdConn, error) {
+	address := stringOpt(opts, "power_address")
+
+	var errs []error
+
+	for _, a := range members.addresses(address) {
+		c, err := dialLXD(ctx, opts, a)
+		if err != nil {
+			// All members share the cluster certificate, so there is
+			// no point trying others if it doesn't match the pin.
+			if errors.Is(err, ErrFingerprintMismatch) || errors.Is(err, ErrInvalidPin) {
+				return nil, err
+			}
+
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if m, err := c.clusterMembers(ctx); err == nil {
+			members.set(address, m)
+		}
+
+		return c, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// clusterMembers returns members of the cluster, or nothing if the host
+// is not clustered.
+func (c *lxdConn) clusterMembers(ctx context.Context) ([]LXDClusterMember, error) {
+	server := c.use(ctx, "")
+
+	cluster, _, err := server.GetCluster()
+	if err != nil {
+		return nil, lxdError(err)
+	}
+
+	if !cluster.Enabled {
+		return nil, nil
+	}
+
+	clusterMembers, err := server.GetClusterMembers()
+	if err != nil {
+		return nil, lxdError(err)
+	}
+
+	members := make([]LXDClusterMember, 0, len(clusterMembers))
+
+	for _, m := range clusterMembers {
+		members = append(members, LXDClusterMember{
+			Name:    m.ServerName,
+			URL:     m.URL,
+			Status:  m.Status,
+			Message: m.Message,
+		})
+	}
+
+	return members, nil
+}
+
+// memberResources fills capacity of the cluster member
+func (c *lxdConn) memberResources(ctx context.Context, m *LXDClusterMember) error {
+	resources, err := c.use(ctx, "").UseTarget(m.Name).GetServerResources()
+	if err != nil {
+		return lxdError(err)
+	}
+
+	m.Cores = int(resources.CPU.Total)
+	m.MemoryTotal = int64(resources.Memory.Total)
+	m.MemoryUsed = int64(resources.Memory.Used)
+
+	return nil
+}
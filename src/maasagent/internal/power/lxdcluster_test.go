@@ -0,0 +1,167 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLXDClusterServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1.0":
+			w.Write([]byte(`{"type":"sync","metadata":{"api_extensions":["clustering","resources"]}}`))
+		case "/1.0/cluster":
+			w.Write([]byte(`{"type":"sync","metadata":{"enabled":true}}`))
+		case "/1.0/cluster/members":
+			w.Write([]byte(`{"type":"sync","metadata":[
+				{"server_name":"m1","url":"` + server.URL + `","status":"Online","message":"Fully operational"},
+				{"server_name":"m2","url":"https://10.0.0.2:8443","status":"Offline","message":"No heartbeat"}
+			]}`))
+		case "/1.0/resources":
+			assert.Equal(t, "m1", r.URL.Query().Get("target"))
+			w.Write([]byte(`{"type":"sync","metadata":{"cpu":{"total":16},"memory":{"total":68719476736,"used":4294967296}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestLXDMemberCacheAddresses(t *testing.T) {
+	members := []LXDClusterMember{
+		{URL: "https://10.0.0.1:8443", Status: lxdMemberOnline},
+		{URL: "https://10.0.0.2:8443", Status: lxdMemberOnline},
+		{URL: "https://10.0.0.3:8443", Status: "Offline"},
+	}
+
+	testcases := map[string]struct {
+		cache   *lxdMemberCache
+		address string
+		out     []string
+	}{
+		"nil cache": {
+			address: "10.0.0.1",
+			out:     []string{"10.0.0.1"},
+		},
+		"unknown address": {
+			cache:   newLXDMemberCache(),
+			address: "10.0.0.9",
+			out:     []string{"10.0.0.9"},
+		},
+		"configured member first, offline skipped": {
+			cache:   newLXDMemberCache(),
+			address: "10.0.0.1",
+			out:     []string{"10.0.0.1", "https://10.0.0.2:8443"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tc.cache.set("10.0.0.1", members)
+			assert.Equal(t, tc.out, tc.cache.addresses(tc.address))
+		})
+	}
+}
+
+func TestDialLXDClusterFallback(t *testing.T) {
+	server := newLXDClusterServer(t)
+	cert, key := newClientCertificate(t)
+
+	// Nothing is listening there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	down := "https://" + l.Addr().String()
+	require.NoError(t, l.Close())
+
+	opts := map[string]interface{}{
+		"power_address": down,
+		"certificate":   cert,
+		"key":           key,
+	}
+
+	members := newLXDMemberCache()
+
+	_, err = dialLXDCluster(context.Background(), opts, members)
+	assert.Error(t, err)
+
+	members.set(down, []LXDClusterMember{{URL: server.URL, Status: lxdMemberOnline}})
+
+	c, err := dialLXDCluster(context.Background(), opts, members)
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	assert.Equal(t, server.URL, c.base.String())
+	assert.Equal(t, server.URL, members.reachable(context.Background(), down))
+}
+
+func TestLXDClusterMembers(t *testing.T) {
+	server := newLXDClusterServer(t)
+	cert, key := newClientCertificate(t)
+
+	opts := map[string]interface{}{
+		"power_address": server.URL,
+		"certificate":   cert,
+		"key":           key,
+	}
+
+	members := newLXDMemberCache()
+
+	c, err := dialLXDCluster(context.Background(), opts, members)
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	result, err := c.clusterMembers(context.Background())
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+
+	require.NoError(t, c.memberResources(context.Background(), &result[0]))
+
+	assert.Equal(t, LXDClusterMember{
+		Name:        "m1",
+		URL:         server.URL,
+		Status:      lxdMemberOnline,
+		Message:     "Fully operational",
+		Cores:       16,
+		MemoryTotal: 68719476736,
+		MemoryUsed:  4294967296,
+	}, result[0])
+	assert.Equal(t, "Offline", result[1].Status)
+
+	// Only the online member is remembered, which is the configured one
+	assert.Equal(t, []string{server.URL}, members.addresses(server.URL))
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/ipmi"
+)
+
+// DriverMoonshot is the power driver of nodes of HPE Moonshot cartridges,
+// managed by the iLO Chassis Manager
+const DriverMoonshot = "moonshot"
+
+// moonshotLocalAddress is the only requester address (ipmitool -m) nodes
+// can be addressed from, which is the address of the Chassis Manager
+const moonshotLocalAddress = 0x20
+
+// ErrInvalidNodeAddress is returned when power_hwaddress is not a node
+// address made of ipmitool bridging options
+var ErrInvalidNodeAddress = errcode.New(errcode.PowerInvalidParameters, "invalid chassis node address")
+
+// moonshotDriver performs power actions of cartridge nodes over IPMI,
+// bridged through the Chassis Manager as by the power driver. Nodes are
+// addressed by power_hwaddress, given as ipmitool bridging options, e.g.
+// "-B 0 -T 0x82 -b 7 -t 0x72" for node 1 of cartridge 1. Nodes are always
+// addressed through the Chassis Manager, so many of them can be queried
+// within a single IPMI session.
+type moonshotDriver struct{}
+
+// Supports returns whether the Chassis Manager is given and the node
+// address can be parsed. Nodes with other ipmitool options are left to the
+// MAAS power CLI.
+func (moonshotDriver) Supports(opts map[string]interface{}) bool {
+	if stringOpt(opts, "power_address") == "" {
+		return false
+	}
+
+	_, err := moonshotBridges(stringOpt(opts, "power_hwaddress"))
+
+	return err == nil
+}
+
+// On sets the node to boot from the network once and powers it on, as
+// the power driver does
+func (moonshotDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return moonshotPower(ctx, opts, "on")
+}
+
+func (moonshotDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return moonshotPower(ctx, opts, "off")
+}
+
+func (moonshotDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return moonshotPower(ctx, opts, "cycle")
+}
+
+func (moonshotDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return moonshotPower(ctx, opts, "status")
+}
+
+// QueryNodes returns power states of nodes of the chassis by their
+// power_hwaddress, querying them one after another within a single IPMI
+// session of the Chassis Manager. Nodes which fail to respond don't fail
+// the query of others.
+func (moonshotDriver) QueryNodes(ctx context.Context, opts map[string]interface{},
+	nodes []string) (map[string]string, map[string]error, error) {
+	s, err := dialIPMI(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//nolint:errcheck // BMC closes idle sessions anyway
+	defer s.Close()
+
+	states := make(map[string]string, len(nodes))
+	errs := make(map[string]error)
+
+	for _, node := range nodes {
+		bridges, err := moonshotBridges(node)
+		if err != nil {
+			errs[node] = err
+			continue
+		}
+
+		status, err := s.Bridge(bridges...).ChassisStatus(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+
+			errs[node] = err
+
+			continue
+		}
+
+		states[node] = ipmiState(status)
+	}
+
+	return states, errs, nil
+}
+
+func moonshotPower(ctx context.Context, opts map[string]interface{}, action string) (string, PowerDetails, error) {
+	bridges, err := moonshotBridges(stringOpt(opts, "power_hwaddress"))
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return ipmiPower(ctx, opts, action, bridges...)
+}
+
+// moonshotBridges parses ipmitool bridging options of power_hwaddress: -t
+// and -b (default 0) address the node, -T and -B (default 0) the transit
+// controller of the cartridge, if the node is double bridged.
+func moonshotBridges(value string) ([]ipmi.Bridge, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidNodeAddress, value)
+	}
+
+	options := make(map[string]uint8, len(fields)/2)
+
+	for i := 0; i < len(fields); i += 2 {
+		switch fields[i] {
+		case "-b", "-t", "-B", "-T", "-m":
+		default:
+			return nil, fmt.Errorf("%w: unknown option %s", ErrInvalidNodeAddress, fields[i])
+		}
+
+		// Addresses are usually given in hex (e.g. 0x72)
+		v, err := strconv.ParseUint(fields[i+1], 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s %s", ErrInvalidNodeAddress, fields[i], fields[i+1])
+		}
+
+		options[fields[i]] = uint8(v)
+	}
+
+	if m, ok := options["-m"]; ok && m != moonshotLocalAddress {
+		return nil, fmt.Errorf("%w: local address 0x%02x", ErrInvalidNodeAddress, m)
+	}
+
+	target, ok := options["-t"]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q has no target address", ErrInvalidNodeAddress, value)
+	}
+
+	bridges := []ipmi.Bridge{{Channel: options["-b"], Address: target}}
+
+	if transit, ok := options["-T"]; ok {
+		bridges = append([]ipmi.Bridge{{Channel: options["-B"], Address: transit}}, bridges...)
+	}
+
+	return bridges, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/ipmi"
+)
+
+func TestMoonshotBridges(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out []ipmi.Bridge
+		err error
+	}{
+		"single bridge": {
+			in:  "-b 7 -t 0x72",
+			out: []ipmi.Bridge{{Channel: 7, Address: 0x72}},
+		},
+		"double bridge": {
+			in:  "-B 0 -T 0x82 -b 7 -t 0x72",
+			out: []ipmi.Bridge{{Channel: 0, Address: 0x82}, {Channel: 7, Address: 0x72}},
+		},
+		"any order with local address": {
+			in:  " -t 0x74 -m 0x20 -T 0x8a  -b 7 ",
+			out: []ipmi.Bridge{{Channel: 0, Address: 0x8a}, {Channel: 7, Address: 0x74}},
+		},
+		"no target": {
+			in:  "-B 0 -T 0x82 -b 7",
+			err: ErrInvalidNodeAddress,
+		},
+		"other local address": {
+			in:  "-m 0x22 -b 7 -t 0x72",
+			err: ErrInvalidNodeAddress,
+		},
+		"unknown option": {
+			in:  "-b 7 -t 0x72 -L USER",
+			err: ErrInvalidNodeAddress,
+		},
+		"missing value": {
+			in:  "-b 7 -t",
+			err: ErrInvalidNodeAddress,
+		},
+		"address out of range": {
+			in:  "-b 7 -t 0x172",
+			err: ErrInvalidNodeAddress,
+		},
+		"empty": {
+			err: ErrInvalidNodeAddress,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := moonshotBridges(tc.in)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestMoonshotDriverSupports(t *testing.T) {
+	d := moonshotDriver{}
+
+	assert.True(t, d.Supports(map[string]interface{}{
+		"power_address": "10.0.0.1", "power_hwaddress": "-B 0 -T 0x82 -b 7 -t 0x72",
+	}))
+	assert.False(t, d.Supports(map[string]interface{}{"power_address": "10.0.0.1"}))
+	assert.False(t, d.Supports(map[string]interface{}{"power_hwaddress": "-b 7 -t 0x72"}))
+}
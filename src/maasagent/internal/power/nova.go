@@ -0,0 +1,469 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// DriverNova is the power driver of OpenStack Nova instances
+const DriverNova = "nova"
+
+const (
+	// novaTokenMargin is how long before expiry Keystone tokens are renewed
+	novaTokenMargin = time.Minute
+	// novaResponseLimit is how much of a response is decoded
+	novaResponseLimit = 1 << 20
+	// defaultNovaDomain is the Keystone domain of users and projects,
+	// unless os_domainname is set
+	defaultNovaDomain = "Default"
+	// defaultNovaPollInterval is how often the instance is queried until
+	// the power action completes
+	defaultNovaPollInterval = time.Second
+)
+
+// Power states of Nova instances (OS-EXT-STS:power_state)
+const (
+	novaPowerRunning   = 1
+	novaPowerPaused    = 3
+	novaPowerShutdown  = 4
+	novaPowerCrashed   = 6
+	novaPowerSuspended = 7
+)
+
+// ErrNoComputeEndpoint is returned when the Keystone service catalog has
+// no compute endpoint
+var ErrNoComputeEndpoint = errcode.New(errcode.PowerInvalidResponse, "no compute endpoint in service catalog")
+
+// novaDriver controls Nova instances with the Compute API, authenticated
+// with Keystone v3 password authentication. Power parameters are the same
+// as of the power driver: nova_id, os_authurl, os_username, os_password
+// and os_tenantname, together with os_domainname and os_region when the
+// defaults don't fit. Tokens are kept until they expire, so power actions
+// of instances of the same project don't authenticate every time.
+type novaDriver struct {
+	sessions map[string]*novaSession
+	poll     time.Duration
+	mutex    sync.Mutex
+}
+
+func newNovaDriver() *novaDriver {
+	return &novaDriver{sessions: make(map[string]*novaSession), poll: defaultNovaPollInterval}
+}
+
+// novaSession is a Keystone token scoped to the project of instances, with
+// the compute endpoint of the project
+type novaSession struct {
+	client  *http.Client
+	token   string
+	compute *url.URL
+	expires time.Time
+}
+
+// novaServer is the part of a Nova server which tells its power state
+type novaServer struct {
+	Status     string  `json:"status"`
+	PowerState *int    `json:"OS-EXT-STS:power_state"`
+	TaskState  *string `json:"OS-EXT-STS:task_state"`
+}
+
+// state returns the power state of the server. OS-EXT-STS attributes may
+// be hidden by the policy of the cloud, status is used then.
+func (s novaServer) state() string {
+	if s.PowerState != nil {
+		switch *s.PowerState {
+		case novaPowerRunning, novaPowerPaused:
+			return "on"
+		case novaPowerShutdown, novaPowerCrashed, novaPowerSuspended:
+			return "off"
+		}
+
+		return "unknown"
+	}
+
+	switch s.Status {
+	case "ACTIVE", "PAUSED", "REBOOT", "HARD_REBOOT", "RESCUE":
+		return "on"
+	case "SHUTOFF", "SUSPENDED", "SHELVED", "SHELVED_OFFLOADED":
+		return "off"
+	}
+
+	return "unknown"
+}
+
+// busy returns whether a task (e.g. powering on) is still in progress
+func (s novaServer) busy() bool {
+	return s.TaskState != nil && *s.TaskState != ""
+}
+
+// Supports returns whether the instance and Keystone credentials are given
+func (d *novaDriver) Supports(opts map[string]interface{}) bool {
+	for _, opt := range []string{"nova_id", "os_authurl", "os_username", "os_password", "os_tenantname"} {
+		if stringOpt(opts, opt) == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// On starts the instance, or resumes it if it is paused, suspended or
+// shelved
+func (d *novaDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, "on", func(s novaServer) interface{} {
+		switch s.Status {
+		case "PAUSED":
+			return map[string]interface{}{"unpause": nil}
+		case "SUSPENDED":
+			return map[string]interface{}{"resume": nil}
+		case "SHELVED", "SHELVED_OFFLOADED":
+			return map[string]interface{}{"unshelve": nil}
+		}
+
+		if s.state() == "on" {
+			return nil
+		}
+
+		return map[string]interface{}{"os-start": nil}
+	})
+}
+
+func (d *novaDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, "off", func(s novaServer) interface{} {
+		if s.state() == "off" {
+			return nil
+		}
+
+		return map[string]interface{}{"os-stop": nil}
+	})
+}
+
+func (d *novaDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, "on", func(s novaServer) interface{} {
+		if s.state() == "off" {
+			return map[string]interface{}{"os-start": nil}
+		}
+
+		return map[string]interface{}{"reboot": map[string]string{"type": "HARD"}}
+	})
+}
+
+func (d *novaDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, "", func(novaServer) interface{} { return nil })
+}
+
+// do sends the server action fn returns for the current state of the
+// instance, if any, and waits for the instance to be in the want power
+// state. Status of the instance is returned in PowerDetails.
+func (d *novaDriver) do(ctx context.Context, opts map[string]interface{}, want string,
+	fn func(novaServer) interface{}) (string, PowerDetails, error) {
+	path := "servers/" + url.PathEscape(stringOpt(opts, "nova_id"))
+
+	var server novaServer
+
+	err := d.withSession(ctx, opts, func(s *novaSession) error {
+		get := func() error {
+			server = novaServer{}
+			return s.request(ctx, http.MethodGet, path, nil, &server)
+		}
+
+		if err := get(); err != nil {
+			return err
+		}
+
+		action := fn(server)
+		if action == nil {
+			return nil
+		}
+
+		if err := s.request(ctx, http.MethodPost, path+"/action", action, nil); err != nil {
+			return err
+		}
+
+		for {
+			if err := get(); err != nil {
+				return err
+			}
+
+			if server.state() == want && !server.busy() {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(d.poll):
+			}
+		}
+	})
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return server.state(), PowerDetails{Status: server.Status}, nil
+}
+
+// withSession calls fn with the session of the project of the instance.
+// Tokens can be revoked before they expire, so fn is called again with a
+// new session if the token was rejected.
+func (d *novaDriver) withSession(ctx context.Context, opts map[string]interface{},
+	fn func(*novaSession) error) error {
+	key := novaSessionKey(opts)
+
+	d.mutex.Lock()
+	s, ok := d.sessions[key]
+	d.mutex.Unlock()
+
+	if ok && time.Now().Before(s.expires.Add(-novaTokenMargin)) {
+		err := fn(s)
+		if !errors.Is(err, ErrAuthFailed) {
+			return err
+		}
+	}
+
+	s, err := authenticateNova(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.sessions[key] = s
+	d.mutex.Unlock()
+
+	return fn(s)
+}
+
+// novaSessionKey identifies the project and credentials of a session.
+// The password is hashed, so it isn't kept in memory longer than needed.
+func novaSessionKey(opts map[string]interface{}) string {
+	sum := sha256.Sum256([]byte(stringOpt(opts, "os_password")))
+
+	return strings.Join([]string{
+		stringOpt(opts, "os_authurl"), stringOpt(opts, "os_domainname"),
+		stringOpt(opts, "os_username"), stringOpt(opts, "os_tenantname"),
+		stringOpt(opts, "os_region"), stringOpt(opts, "power_verify_ssl"),
+		stringOpt(opts, optCertFingerprint), hex.EncodeToString(sum[:]),
+	}, "\x00")
+}
+
+// keystoneURL returns the Identity v3 API of os_authurl, which may be given
+// with or without version, as by the power driver
+func keystoneURL(authURL string) (*url.URL, error) {
+	authURL = strings.TrimSuffix(authURL, "/")
+	authURL = strings.TrimSuffix(authURL, "/v2.0")
+
+	if !strings.HasSuffix(authURL, "/v3") {
+		authURL += "/v3"
+	}
+
+	return url.Parse(authURL)
+}
+
+// authenticateNova gets a token scoped to the project of the instance
+func authenticateNova(ctx context.Context, opts map[string]interface{}) (*novaSession, error) {
+	tlsConfig, err := verifiedTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := keystoneURL(stringOpt(opts, "os_authurl"))
+	if err != nil {
+		return nil, err
+	}
+
+	domain := stringOpt(opts, "os_domainname")
+	if domain == "" {
+		domain = defaultNovaDomain
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"auth": map[string]interface{}{
+			"identity": map[string]interface{}{
+				"methods": []string{"password"},
+				"password": map[string]interface{}{
+					"user": map[string]interface{}{
+						"name":     stringOpt(opts, "os_username"),
+						"domain":   map[string]string{"name": domain},
+						"password": stringOpt(opts, "os_password"),
+					},
+				},
+			},
+			"scope": map[string]interface{}{
+				"project": map[string]interface{}{
+					"name":   stringOpt(opts, "os_tenantname"),
+					"domain": map[string]string{"name": domain},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.JoinPath("auth", "tokens").String(),
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	s := &novaSession{client: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("%w: keystone: %s", responseError(resp.StatusCode), resp.Status)
+	}
+
+	var token struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+			Catalog   []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, novaResponseLimit)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+	}
+
+	s.token, s.expires = resp.Header.Get("X-Subject-Token"), token.Token.ExpiresAt
+
+	region := stringOpt(opts, "os_region")
+
+	for _, service := range token.Token.Catalog {
+		if service.Type != "compute" {
+			continue
+		}
+
+		for _, e := range service.Endpoints {
+			if e.Interface != "public" || (region != "" && e.Region != region) {
+				continue
+			}
+
+			if s.compute, err = url.Parse(e.URL); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+			}
+
+			return s, nil
+		}
+	}
+
+	return nil, ErrNoComputeEndpoint
+}
+
+// request sends a request of the Compute API with JSON body in (if any)
+// and decodes the server of the response into out (if any)
+func (s *novaSession) request(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.compute.JoinPath(path).String(), body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Auth-Token", s.token)
+	req.Header.Set("Accept", "application/json")
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, novaResponseLimit))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Faults are described as {"<fault>": {"message": ..., "code": ...}}
+		var fault map[string]struct {
+			Message string `json:"message"`
+		}
+
+		if json.Unmarshal(b, &fault) == nil {
+			for _, f := range fault {
+				if f.Message != "" {
+					return fmt.Errorf("%w: %s %s: %s: %s", responseError(resp.StatusCode),
+						method, path, resp.Status, f.Message)
+				}
+			}
+		}
+
+		return fmt.Errorf("%w: %s %s: %s", responseError(resp.StatusCode), method, path, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	var server struct {
+		Server json.RawMessage `json:"server"`
+	}
+
+	if err := json.Unmarshal(b, &server); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+	}
+
+	if err := json.Unmarshal(server.Server, out); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// fakeNova is Keystone and Nova with a single instance "vm1" of project
+// "maas". Actions take one more query to complete.
+type fakeNova struct {
+	status  string
+	task    string
+	token   string
+	auths   int
+	actions []string
+	mutex   sync.Mutex
+}
+
+func (f *fakeNova) serve(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		switch r.Method + " " + r.URL.Path {
+		case "POST /identity/v3/auth/tokens":
+			var req struct {
+				Auth struct {
+					Identity struct {
+						Password struct {
+							User struct {
+								Name     string `json:"name"`
+								Password string `json:"password"`
+							} `json:"user"`
+						} `json:"password"`
+					} `json:"identity"`
+					Scope struct {
+						Project struct {
+							Name   string `json:"name"`
+							Domain struct {
+								Name string `json:"name"`
+							} `json:"domain"`
+						} `json:"project"`
+					} `json:"scope"`
+				} `json:"auth"`
+			}
+
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+			user, project := req.Auth.Identity.Password.User, req.Auth.Scope.Project
+			if user.Name != "admin" || user.Password != "secret" || project.Name != "maas" ||
+				project.Domain.Name != defaultNovaDomain {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			f.auths++
+			f.token = fmt.Sprintf("token-%d", f.auths)
+
+			w.Header().Set("X-Subject-Token", f.token)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token":{"expires_at":"` + time.Now().Add(time.Hour).Format(time.RFC3339) +
+				`","catalog":[{"type":"identity","endpoints":[]},{"type":"compute","endpoints":[` +
+				`{"interface":"internal","region":"RegionOne","url":"http://10.0.0.1:8774/v2.1"},` +
+				`{"interface":"public","region":"RegionOne","url":"` + server.URL + `/compute/v2.1"}]}]}}`))
+
+			return
+		}
+
+		if r.Header.Get("X-Auth-Token") != f.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /compute/v2.1/servers/vm1":
+			task := "null"
+			if f.task != "" {
+				task = `"` + f.task + `"`
+			}
+
+			w.Write([]byte(`{"server":{"id":"vm1","status":"` + f.status +
+				`","OS-EXT-STS:task_state":` + task + `}}`))
+
+			f.task = ""
+		case "POST /compute/v2.1/servers/vm1/action":
+			var action map[string]interface{}
+
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&action))
+
+			for a := range action {
+				f.actions = append(f.actions, a)
+
+				switch a {
+				case "os-start", "unpause", "resume", "unshelve", "reboot":
+					f.status, f.task = "ACTIVE", "powering-on"
+				case "os-stop":
+					f.status, f.task = "SHUTOFF", "powering-off"
+				}
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"itemNotFound":{"code":404,"message":"Instance could not be found."}}`))
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func novaTestOpts(server *httptest.Server) map[string]interface{} {
+	return map[string]interface{}{
+		"nova_id":       "vm1",
+		"os_authurl":    server.URL + "/identity",
+		"os_username":   "admin",
+		"os_password":   "secret",
+		"os_tenantname": "maas",
+	}
+}
+
+func newTestNovaDriver() *novaDriver {
+	d := newNovaDriver()
+	d.poll = time.Millisecond
+
+	return d
+}
+
+func TestNovaDriver(t *testing.T) {
+	testcases := map[string]struct {
+		action  string
+		initial string
+		state   string
+		status  string
+		actions []string
+	}{
+		"on": {
+			action:  "on",
+			initial: "SHUTOFF",
+			state:   "on",
+			status:  "ACTIVE",
+			actions: []string{"os-start"},
+		},
+		"on unpauses": {
+			action:  "on",
+			initial: "PAUSED",
+			state:   "on",
+			status:  "ACTIVE",
+			actions: []string{"unpause"},
+		},
+		"on unshelves": {
+			action:  "on",
+			initial: "SHELVED_OFFLOADED",
+			state:   "on",
+			status:  "ACTIVE",
+			actions: []string{"unshelve"},
+		},
+		"on already on": {
+			action:  "on",
+			initial: "ACTIVE",
+			state:   "on",
+			status:  "ACTIVE",
+		},
+		"off": {
+			action:  "off",
+			initial: "ACTIVE",
+			state:   "off",
+			status:  "SHUTOFF",
+			actions: []string{"os-stop"},
+		},
+		"off already off": {
+			action:  "off",
+			initial: "SHUTOFF",
+			state:   "off",
+			status:  "SHUTOFF",
+		},
+		"cycle": {
+			action:  "cycle",
+			initial: "ACTIVE",
+			state:   "on",
+			status:  "ACTIVE",
+			actions: []string{"reboot"},
+		},
+		"cycle off": {
+			action:  "cycle",
+			initial: "SHUTOFF",
+			state:   "on",
+			status:  "ACTIVE",
+			actions: []string{"os-start"},
+		},
+		"status error": {
+			action:  "status",
+			initial: "ERROR",
+			state:   "unknown",
+			status:  "ERROR",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := &fakeNova{status: tc.initial}
+			opts := novaTestOpts(f.serve(t))
+			d := newTestNovaDriver()
+
+			require.True(t, d.Supports(opts))
+
+			state, details, err := runDriver(context.Background(), d, tc.action, opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, state)
+			assert.Equal(t, tc.status, details.Status)
+
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+
+			assert.Equal(t, tc.actions, f.actions)
+			assert.Empty(t, f.task)
+		})
+	}
+}
+
+func TestNovaDriverSession(t *testing.T) {
+	f := &fakeNova{status: "ACTIVE"}
+	opts := novaTestOpts(f.serve(t))
+	d := newTestNovaDriver()
+
+	for i := 0; i < 2; i++ {
+		_, _, err := d.Status(context.Background(), opts)
+		require.NoError(t, err)
+	}
+
+	f.mutex.Lock()
+	assert.Equal(t, 1, f.auths)
+	// Token is revoked
+	f.token = "revoked"
+	f.mutex.Unlock()
+
+	state, _, err := d.Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "on", state)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	assert.Equal(t, 2, f.auths)
+}
+
+func TestNovaDriverErrors(t *testing.T) {
+	f := &fakeNova{status: "ACTIVE"}
+	server := f.serve(t)
+
+	opts := novaTestOpts(server)
+	opts["os_password"] = "wrong"
+
+	_, _, err := newTestNovaDriver().Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	assert.Equal(t, errcode.PowerAuthFailed, errcode.Of(err))
+
+	opts = novaTestOpts(server)
+	opts["nova_id"] = "vm2"
+
+	_, _, err = newTestNovaDriver().Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrUnexpectedResponse)
+	assert.ErrorContains(t, err, "Instance could not be found.")
+
+	opts = novaTestOpts(server)
+	opts["os_region"] = "RegionTwo"
+
+	_, _, err = newTestNovaDriver().Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrNoComputeEndpoint)
+}
+
+func TestKeystoneURL(t *testing.T) {
+	for in, out := range map[string]string{
+		"http://keystone:5000":        "http://keystone:5000/v3",
+		"http://keystone:5000/":       "http://keystone:5000/v3",
+		"http://keystone:5000/v2.0":   "http://keystone:5000/v3",
+		"http://keystone:5000/v3/":    "http://keystone:5000/v3",
+		"https://cloud/identity/v3":   "https://cloud/identity/v3",
+		"https://cloud/identity/v2.0": "https://cloud/identity/v3",
+	} {
+		u, err := keystoneURL(in)
+		require.NoError(t, err)
+		assert.Equal(t, out, u.String(), in)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DriverOpenBMC is the power driver of hosts managed by OpenBMC
+const DriverOpenBMC = "openbmc"
+
+const (
+	openbmcHostState  = "/xyz/openbmc_project/state/host0/attr/"
+	openbmcBootOnce   = "/xyz/openbmc_project/control/host0/boot/one_time/attr/"
+	openbmcTransition = "xyz.openbmc_project.State.Host.Transition."
+	openbmcBootMode   = "xyz.openbmc_project.Control.Boot.Mode.Modes.Regular"
+	openbmcBootSource = "xyz.openbmc_project.Control.Boot.Source.Sources.Network"
+	// openbmcRedfishRetry is how long BMCs found without usable Redfish are
+	// controlled with the REST API only, before Redfish is tried again
+	// (e.g. after a firmware update)
+	openbmcRedfishRetry = time.Hour
+)
+
+// openbmcDriver controls hosts with the Redfish API of OpenBMC, falling back
+// to the legacy REST API (phosphor-rest), which the power driver uses, when
+// Redfish is missing or incomplete. Older firmware has no Redfish at all, and
+// some builds report no power state or reject resets and boot overrides.
+// Failures to connect or log in are not worked around, as the REST API would
+// fail the same way.
+type openbmcDriver struct {
+	// legacy keeps when BMCs were found without usable Redfish
+	legacy map[string]time.Time
+	mutex  sync.Mutex
+}
+
+func newOpenBMCDriver() *openbmcDriver {
+	return &openbmcDriver{legacy: make(map[string]time.Time)}
+}
+
+// openbmcHost is power control of the host through either API of OpenBMC.
+// power performs "on", "off" or "status" and returns the resulting state.
+type openbmcHost interface {
+	power(ctx context.Context, action string) (string, PowerDetails, error)
+	setNetworkBoot(ctx context.Context) error
+}
+
+// On sets the host to boot from the network once and powers it on, as the
+// power driver does. Running hosts are powered off first, so they boot from
+// the network again.
+func (d *openbmcDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.run(ctx, opts, func(h openbmcHost) (string, PowerDetails, error) {
+		state, _, err := h.power(ctx, "status")
+		if err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		if state == "on" {
+			if _, _, err = h.power(ctx, "off"); err != nil {
+				return "", PowerDetails{}, err
+			}
+		}
+
+		if err = h.setNetworkBoot(ctx); err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		return h.power(ctx, "on")
+	})
+}
+
+// Off sets the host to boot from the network once and powers it off, as the
+// power driver does
+func (d *openbmcDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.run(ctx, opts, func(h openbmcHost) (string, PowerDetails, error) {
+		if err := h.setNetworkBoot(ctx); err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		return h.power(ctx, "off")
+	})
+}
+
+// Cycle powers the host on, which restarts running hosts
+func (d *openbmcDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.On(ctx, opts)
+}
+
+func (d *openbmcDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.run(ctx, opts, func(h openbmcHost) (string, PowerDetails, error) {
+		return h.power(ctx, "status")
+	})
+}
+
+// EmulatesCycle returns true, as hosts are cycled by powering them off and
+// on again
+func (d *openbmcDriver) EmulatesCycle(map[string]interface{}) bool {
+	return true
+}
+
+// run calls fn with the Redfish API of the BMC, unless it was found unusable
+// recently, and with the REST API if Redfish turns out to be incomplete. fn
+// is called again from the start, so it must not depend on what was done
+// through Redfish.
+func (d *openbmcDriver) run(ctx context.Context, opts map[string]interface{},
+	fn func(openbmcHost) (string, PowerDetails, error)) (string, PowerDetails, error) {
+	c, err := dialRedfish(opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // only idle connections are closed
+	defer c.Close()
+
+	if !d.isLegacy(c.base.Host) {
+		state, details, err := fn(&openbmcRedfish{
+			conn:     c,
+			nodeID:   stringOpt(opts, "node_id"),
+			bootMode: stringOpt(opts, bootModeOpt),
+		})
+		if !redfishIncomplete(err) {
+			return state, details, err
+		}
+
+		d.setLegacy(c.base.Host)
+	}
+
+	rest := &openbmcREST{conn: c}
+	if err = rest.login(ctx); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // the BMC expires sessions anyway
+	defer rest.logout(context.WithoutCancel(ctx))
+
+	return fn(rest)
+}
+
+func (d *openbmcDriver) isLegacy(host string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	since, ok := d.legacy[host]
+	if ok && time.Since(since) > openbmcRedfishRetry {
+		delete(d.legacy, host)
+		return false
+	}
+
+	return ok
+}
+
+func (d *openbmcDriver) setLegacy(host string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.legacy[host] = time.Now()
+}
+
+// redfishIncomplete returns true if err means Redfish of the BMC is missing
+// (e.g. 404 Not Found) or doesn't support what was asked
+func redfishIncomplete(err error) bool {
+	if err == nil || errors.Is(err, ErrAuthFailed) {
+		return false
+	}
+
+	return errors.Is(err, ErrUnexpectedResponse) ||
+		errors.Is(err, ErrUnsupportedPowerAction) ||
+		errors.Is(err, ErrUnsupportedBootMode)
+}
+
+// openbmcRedfish controls the host with Redfish
+type openbmcRedfish struct {
+	conn     *redfishConn
+	nodeID   string
+	bootMode string
+}
+
+// power checks that the system has a power state before performing action,
+// as otherwise waiting for the action to complete would never succeed
+func (h *openbmcRedfish) power(ctx context.Context, action string) (string, PowerDetails, error) {
+	system, err := h.conn.systemPath(ctx, h.nodeID)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	var s redfishSystem
+	if _, err = h.conn.do(ctx, http.MethodGet, system, "", nil, &s); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	// Without a power state, the system is not backed by the host state
+	// manager, which the REST API talks to
+	if s.PowerState == "" {
+		return "", PowerDetails{}, fmt.Errorf("%w: %s has no power state", ErrUnexpectedResponse, system)
+	}
+
+	if action != "status" {
+		return h.conn.power(ctx, h.nodeID, action)
+	}
+
+	return s.state(), s.details(), nil
+}
+
+func (h *openbmcRedfish) setNetworkBoot(ctx context.Context) error {
+	return h.conn.setBootDevice(ctx, h.nodeID, BootDevicePXE, h.bootMode)
+}
+
+// openbmcREST controls the host with the legacy REST API, which keeps the
+// session in a cookie
+type openbmcREST struct {
+	conn *redfishConn
+}
+
+// openbmcResponse is the envelope of REST API responses
+type openbmcResponse struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+}
+
+// do sends request with data and decodes data of the response into out
+// (if any)
+func (h *openbmcREST) do(ctx context.Context, method, p string, data, out interface{}) error {
+	var body interface{}
+	if data != nil {
+		body = map[string]interface{}{"data": data}
+	}
+
+	resp := openbmcResponse{Data: out}
+
+	if _, err := h.conn.do(ctx, method, p, "", body, &resp); err != nil {
+		return err
+	}
+
+	if resp.Status != "ok" {
+		return fmt.Errorf("%w: %s %s: %s", ErrUnexpectedResponse, method, p, resp.Message)
+	}
+
+	return nil
+}
+
+func (h *openbmcREST) login(ctx context.Context) error {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+
+	h.conn.client.Jar = jar
+
+	return h.do(ctx, http.MethodPost, "/login", []string{h.conn.user, h.conn.pass}, nil)
+}
+
+func (h *openbmcREST) logout(ctx context.Context) error {
+	return h.do(ctx, http.MethodPost, "/logout", []string{}, nil)
+}
+
+// state returns the power state of the host with CurrentHostState (e.g.
+// "Running" or "Quiesced") as status
+func (h *openbmcREST) state(ctx context.Context) (string, PowerDetails, error) {
+	var current string
+	if err := h.do(ctx, http.MethodGet, openbmcHostState+"CurrentHostState", nil, &current); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	status := current[strings.LastIndex(current, ".")+1:]
+
+	switch status {
+	case "Running":
+		return "on", PowerDetails{Status: status}, nil
+	case "Off":
+		return "off", PowerDetails{Status: status}, nil
+	default:
+		return "unknown", PowerDetails{Status: status}, nil
+	}
+}
+
+func (h *openbmcREST) power(ctx context.Context, action string) (string, PowerDetails, error) {
+	state, details, err := h.state(ctx)
+	if err != nil || action == "status" {
+		return state, details, err
+	}
+
+	var transition string
+
+	switch action {
+	case "on":
+		transition = "On"
+	case "off":
+		transition = "Off"
+	default:
+		return "", PowerDetails{}, fmt.Errorf("%w: %q", ErrUnsupportedPowerAction, action)
+	}
+
+	if state == action {
+		return state, details, nil
+	}
+
+	if err = h.do(ctx, http.MethodPut, openbmcHostState+"RequestedHostTransition",
+		openbmcTransition+transition, nil); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return h.waitPowerState(ctx, action)
+}
+
+// waitPowerState polls the power state of the host until it is want, or
+// powerActionWait passes, in which case the current state is returned.
+func (h *openbmcREST) waitPowerState(ctx context.Context, want string) (string, PowerDetails, error) {
+	deadline := time.Now().Add(powerActionWait)
+
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		state, details, err := h.state(ctx)
+		if err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		if state == want || time.Now().After(deadline) {
+			return state, details, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", PowerDetails{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// setNetworkBoot makes the host boot from the network once, as the power
+// driver does
+func (h *openbmcREST) setNetworkBoot(ctx context.Context) error {
+	if err := h.do(ctx, http.MethodPut, openbmcBootOnce+"BootMode", openbmcBootMode, nil); err != nil {
+		return err
+	}
+
+	return h.do(ctx, http.MethodPut, openbmcBootOnce+"BootSource", openbmcBootSource, nil)
+}
@@ -0,0 +1,335 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOpenBMC is an OpenBMC with the legacy REST API, serving Redfish with
+// redfish, if set. calls are REST requests setting attributes, as
+// "<attribute>=<last part of the value>", and logins are login attempts.
+type fakeOpenBMC struct {
+	redfish  *fakeBMC
+	host     string
+	calls    []string
+	rfCalls  int
+	logins   int
+	sessions int
+	mutex    sync.Mutex
+}
+
+func (f *fakeOpenBMC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/redfish/") {
+		f.rfCalls++
+
+		if f.redfish == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		f.redfish.ServeHTTP(w, r)
+
+		return
+	}
+
+	var req struct {
+		Data interface{} `json:"data"`
+	}
+
+	if r.Method != http.MethodGet {
+		//nolint:errcheck // checked by the test
+		json.NewDecoder(r.Body).Decode(&req)
+	}
+
+	reply := func(status int, data interface{}) {
+		w.WriteHeader(status)
+
+		resp := map[string]interface{}{"status": "ok", "message": "200 OK", "data": data}
+		if status != http.StatusOK {
+			resp["status"], resp["message"] = "error", http.StatusText(status)
+		}
+
+		//nolint:errcheck // nothing to do if the client went away
+		json.NewEncoder(w).Encode(resp)
+	}
+
+	if r.Method+" "+r.URL.Path == "POST /login" {
+		f.logins++
+
+		creds, _ := req.Data.([]interface{}) //nolint:errcheck // checked below
+		if len(creds) != 2 || creds[0] != "admin" || creds[1] != "secret" {
+			reply(http.StatusUnauthorized, nil)
+			return
+		}
+
+		f.sessions++
+
+		http.SetCookie(w, &http.Cookie{Name: "SESSION", Value: "abc"})
+		reply(http.StatusOK, "User 'admin' logged in")
+
+		return
+	}
+
+	if cookie, err := r.Cookie("SESSION"); err != nil || cookie.Value != "abc" {
+		reply(http.StatusUnauthorized, nil)
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "POST /logout":
+		f.sessions--
+		reply(http.StatusOK, "User 'admin' logged out")
+	case "GET " + openbmcHostState + "CurrentHostState":
+		reply(http.StatusOK, "xyz.openbmc_project.State.Host.HostState."+f.host)
+	case "PUT " + openbmcHostState + "RequestedHostTransition",
+		"PUT " + openbmcBootOnce + "BootMode",
+		"PUT " + openbmcBootOnce + "BootSource":
+		value, _ := req.Data.(string) //nolint:errcheck // checked by the test
+		value = value[strings.LastIndex(value, ".")+1:]
+
+		f.calls = append(f.calls, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]+"="+value)
+
+		switch value {
+		case "On":
+			f.host = "Running"
+		case "Off":
+			f.host = "Off"
+		}
+
+		reply(http.StatusOK, nil)
+	default:
+		reply(http.StatusNotFound, nil)
+	}
+}
+
+func openbmcTestOpts(t *testing.T, f *fakeOpenBMC) map[string]interface{} {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(f)
+	t.Cleanup(srv.Close)
+
+	return map[string]interface{}{
+		"power_address": srv.URL,
+		"power_user":    "admin",
+		"power_pass":    "secret",
+	}
+}
+
+func TestOpenBMCDriver(t *testing.T) {
+	networkBoot := []string{"BootMode=Regular", "BootSource=Network"}
+
+	testcases := map[string]struct {
+		redfish *fakeBMC
+		host    string
+		action  string
+		state   string
+		details PowerDetails
+		resets  []string
+		boot    string
+		calls   []string
+	}{
+		"redfish on": {
+			redfish: &fakeBMC{power: "Off"},
+			action:  "on",
+			state:   "on",
+			details: PowerDetails{Health: "OK", BootMode: "UEFI"},
+			resets:  []string{"On"},
+			boot:    "Pxe",
+		},
+		"redfish on restarts running host": {
+			redfish: &fakeBMC{power: "On"},
+			action:  "on",
+			state:   "on",
+			details: PowerDetails{Health: "OK", BootMode: "UEFI"},
+			resets:  []string{"ForceOff", "On"},
+			boot:    "Pxe",
+		},
+		"redfish off": {
+			redfish: &fakeBMC{power: "On"},
+			action:  "off",
+			state:   "off",
+			details: PowerDetails{Health: "OK", BootMode: "UEFI"},
+			resets:  []string{"ForceOff"},
+			boot:    "Pxe",
+		},
+		"redfish status": {
+			redfish: &fakeBMC{power: "PoweringOn"},
+			action:  "status",
+			state:   "unknown",
+			details: PowerDetails{Health: "OK", BootMode: "UEFI"},
+		},
+		"rest on without redfish": {
+			host:    "Off",
+			action:  "on",
+			state:   "on",
+			details: PowerDetails{Status: "Running"},
+			calls:   append(networkBoot, "RequestedHostTransition=On"),
+		},
+		"rest on restarts running host": {
+			host:    "Running",
+			action:  "cycle",
+			state:   "on",
+			details: PowerDetails{Status: "Running"},
+			calls: append(append([]string{"RequestedHostTransition=Off"}, networkBoot...),
+				"RequestedHostTransition=On"),
+		},
+		"rest off without redfish": {
+			host:    "Running",
+			action:  "off",
+			state:   "off",
+			details: PowerDetails{Status: "Off"},
+			calls:   append(networkBoot, "RequestedHostTransition=Off"),
+		},
+		"rest status while quiesced": {
+			host:    "Quiesced",
+			action:  "status",
+			state:   "unknown",
+			details: PowerDetails{Status: "Quiesced"},
+		},
+		"redfish without power state": {
+			redfish: &fakeBMC{},
+			host:    "Running",
+			action:  "status",
+			state:   "on",
+			details: PowerDetails{Status: "Running"},
+		},
+		"redfish reset type not allowed": {
+			redfish: &fakeBMC{power: "On", resetTypes: []string{"On", "GracefulShutdown"}},
+			host:    "Running",
+			action:  "off",
+			state:   "off",
+			details: PowerDetails{Status: "Off"},
+			boot:    "Pxe",
+			calls:   append(networkBoot, "RequestedHostTransition=Off"),
+		},
+		"redfish boot target not allowed": {
+			redfish: &fakeBMC{power: "Off", targets: []string{"Hdd"}},
+			host:    "Off",
+			action:  "on",
+			state:   "on",
+			details: PowerDetails{Status: "Running"},
+			calls:   append(networkBoot, "RequestedHostTransition=On"),
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := &fakeOpenBMC{redfish: tc.redfish, host: tc.host}
+			d := newOpenBMCDriver()
+
+			state, details, err := runDriver(context.Background(), d, tc.action, openbmcTestOpts(t, f))
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, state)
+			assert.Equal(t, tc.details, details)
+
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+
+			assert.Equal(t, tc.calls, f.calls)
+			assert.Equal(t, 0, f.sessions)
+
+			if tc.redfish != nil {
+				assert.Equal(t, tc.resets, tc.redfish.resets)
+				assert.Equal(t, tc.boot, tc.redfish.boot["BootSourceOverrideTarget"])
+			}
+		})
+	}
+}
+
+func TestOpenBMCDriverLegacy(t *testing.T) {
+	f := &fakeOpenBMC{host: "Running"}
+	opts := openbmcTestOpts(t, f)
+	d := newOpenBMCDriver()
+
+	for i := 0; i < 2; i++ {
+		state, _, err := d.Status(context.Background(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, "on", state)
+	}
+
+	f.mutex.Lock()
+	// Redfish is not tried again after it was found missing
+	assert.Equal(t, 1, f.rfCalls)
+	assert.Equal(t, 2, f.logins)
+	f.mutex.Unlock()
+
+	d.mutex.Lock()
+	for host := range d.legacy {
+		d.legacy[host] = time.Now().Add(-2 * openbmcRedfishRetry)
+	}
+	d.mutex.Unlock()
+
+	_, _, err := d.Status(context.Background(), opts)
+	require.NoError(t, err)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	assert.Equal(t, 2, f.rfCalls)
+}
+
+func TestOpenBMCDriverUnauthorized(t *testing.T) {
+	testcases := map[string]struct {
+		redfish *fakeBMC
+		logins  int
+	}{
+		"redfish": {
+			redfish: &fakeBMC{power: "On"},
+		},
+		"rest": {
+			logins: 1,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := &fakeOpenBMC{redfish: tc.redfish, host: "Running"}
+			opts := openbmcTestOpts(t, f)
+			opts["power_pass"] = "wrong"
+
+			_, _, err := newOpenBMCDriver().Status(context.Background(), opts)
+			assert.ErrorIs(t, err, ErrAuthFailed)
+
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+
+			// Credentials rejected by Redfish are not tried with the REST API
+			assert.Equal(t, tc.logins, f.logins)
+		})
+	}
+}
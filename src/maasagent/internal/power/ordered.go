@@ -0,0 +1,333 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"fmt"
+	"time"
+
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	// defaultPowerGateTimeout is how long machines of a level can take to
+	// become healthy before the rest are left off
+	defaultPowerGateTimeout = 10 * time.Minute
+	// powerGateInterval is the interval between health checks of a level
+	powerGateInterval = 15 * time.Second
+	healthCritical    = "Critical"
+)
+
+var (
+	// ErrDependencyCycle is returned when machines depend on each other
+	ErrDependencyCycle = errcode.New(errcode.PowerDependencyInvalid, "dependency cycle")
+	// ErrUnknownDependency is returned when a machine depends on a machine
+	// which is not powered on by the workflow
+	ErrUnknownDependency = errcode.New(errcode.PowerDependencyInvalid, "unknown dependency")
+	// ErrPowerGateTimeout is returned when a machine didn't become healthy
+	// before the gate timeout
+	ErrPowerGateTimeout = errcode.New(errcode.PowerHealthTimeout, "machine didn't become healthy in time")
+)
+
+// OrderedMachine is a machine powered on by power-on-ordered workflow
+type OrderedMachine struct {
+	SystemID string `json:"system_id"`
+	PowerParam
+	// DependsOn are system_ids of machines which must be on and healthy
+	// before this machine is powered on (e.g. storage nodes of compute
+	// nodes, or switches of both)
+	DependsOn []string `json:"depends_on,omitempty"`
+	// CheckReady asks the Region whether the machine is ready (e.g. its
+	// services are up) before dependent machines are powered on. Otherwise
+	// the machine is healthy once it's on, unless the BMC reports critical
+	// health.
+	CheckReady bool `json:"check_ready,omitempty"`
+}
+
+// PowerOnOrderedParam is the parameter of power-on-ordered workflow
+type PowerOnOrderedParam struct {
+	// AgentSystemID is the system_id of the Agent performing power actions
+	AgentSystemID string           `json:"agent_system_id"`
+	Machines      []OrderedMachine `json:"machines"`
+	// GateTimeout in seconds is how long machines of a level can take to
+	// become healthy
+	GateTimeout int `json:"gate_timeout"`
+}
+
+// OrderedMachineResult is the outcome of powering on a machine
+type OrderedMachineResult struct {
+	SystemID string `json:"system_id"`
+	Level    int    `json:"level"`
+	State    string `json:"state,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// ErrorCode is the code of Error, e.g. "POWER_AUTH_FAILED"
+	ErrorCode string `json:"error_code,omitempty"`
+	// Skipped is true when the machine was left off, because machines of
+	// a previous level failed
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// PowerOnOrderedResult is the result of power-on-ordered workflow
+type PowerOnOrderedResult struct {
+	// Levels are system_ids of machines powered on together, in order
+	Levels   [][]string             `json:"levels"`
+	Machines []OrderedMachineResult `json:"machines"`
+	Success  bool                   `json:"success"`
+}
+
+type checkMachineReadyResult struct {
+	Ready bool `json:"ready"`
+}
+
+// powerOnOrdered powers on machines of composed infrastructures in order
+// of their dependencies. Machines without dependencies are powered on first,
+// all machines of a level together, and the next level is powered on only
+// once all of them are healthy. Once a level fails, the rest of machines are
+// left off.
+func (s *PowerService) powerOnOrdered(ctx tworkflow.Context,
+	param PowerOnOrderedParam) (*PowerOnOrderedResult, error) {
+	log := tworkflow.GetLogger(ctx)
+
+	levels, err := dependencyLevels(param.Machines)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultPowerGateTimeout
+	if param.GateTimeout > 0 {
+		timeout = time.Duration(param.GateTimeout) * time.Second
+	}
+
+	result := &PowerOnOrderedResult{Success: true}
+
+	for i, level := range levels {
+		systemIDs := make([]string, len(level))
+		for j, m := range level {
+			systemIDs[j] = m.SystemID
+		}
+
+		result.Levels = append(result.Levels, systemIDs)
+
+		if !result.Success {
+			for _, m := range level {
+				result.Machines = append(result.Machines,
+					OrderedMachineResult{SystemID: m.SystemID, Level: i, Skipped: true})
+			}
+
+			continue
+		}
+
+		results := s.powerOnLevel(ctx, param.AgentSystemID, level, tworkflow.Now(ctx).Add(timeout))
+
+		for j := range results {
+			results[j].Level = i
+
+			if results[j].Error != "" {
+				result.Success = false
+			}
+		}
+
+		result.Machines = append(result.Machines, results...)
+
+		log.Info("Power-on level finished", tag.Builder().
+			KV("level", i).
+			KV("machines", systemIDs).
+			KV("success", result.Success).KeyVals...)
+	}
+
+	return result, nil
+}
+
+// powerOnLevel powers on machines together and waits until all of them
+// are healthy or the deadline passes
+func (s *PowerService) powerOnLevel(ctx tworkflow.Context, agentSystemID string,
+	level []OrderedMachine, deadline time.Time) []OrderedMachineResult {
+	futures := make([]tworkflow.Future, len(level))
+
+	for i, m := range level {
+		futures[i] = tworkflow.ExecuteActivity(powerQueryContext(ctx, agentSystemID), "power-on",
+			PowerOnParam{PowerParam: m.PowerParam})
+	}
+
+	return s.awaitHealthy(ctx, agentSystemID, level, futures, deadline)
+}
+
+// awaitHealthy waits for power actions of machines (futures) and then until
+// all of them are healthy or the deadline passes
+func (s *PowerService) awaitHealthy(ctx tworkflow.Context, agentSystemID string,
+	level []OrderedMachine, futures []tworkflow.Future, deadline time.Time) []OrderedMachineResult {
+	results := make([]OrderedMachineResult, len(level))
+	for i, m := range level {
+		results[i].SystemID = m.SystemID
+	}
+
+	pending := 0
+
+	for i := range level {
+		var res PowerOnResult
+
+		if err := futures[i].Get(ctx, &res); err != nil {
+			results[i].Error, results[i].ErrorCode = err.Error(), errcode.Of(err)
+			continue
+		}
+
+		results[i].State = res.State
+		pending++
+	}
+
+	healthy := make([]bool, len(level))
+
+	for pending > 0 {
+		for i, m := range level {
+			if healthy[i] || results[i].Error != "" {
+				continue
+			}
+
+			ok, state := s.machineHealthy(ctx, agentSystemID, m)
+			results[i].State = state
+
+			if ok {
+				healthy[i] = true
+				pending--
+			}
+		}
+
+		if pending == 0 || !tworkflow.Now(ctx).Before(deadline) {
+			break
+		}
+
+		if err := tworkflow.Sleep(ctx, powerGateInterval); err != nil {
+			break
+		}
+	}
+
+	for i := range level {
+		if !healthy[i] && results[i].Error == "" {
+			results[i].Error, results[i].ErrorCode = ErrPowerGateTimeout.Error(), errcode.Of(ErrPowerGateTimeout)
+		}
+	}
+
+	return results
+}
+
+// machineHealthy returns whether the machine is on and healthy, and its
+// power state. Failed checks are retried by the gate until its deadline.
+func (s *PowerService) machineHealthy(ctx tworkflow.Context, agentSystemID string,
+	m OrderedMachine) (bool, string) {
+	var res PowerQueryResult
+
+	if err := tworkflow.ExecuteActivity(powerQueryContext(ctx, agentSystemID), "power-query",
+		PowerQueryParam{PowerParam: m.PowerParam}).Get(ctx, &res); err != nil {
+		return false, ""
+	}
+
+	if res.State != "on" || res.Health == healthCritical {
+		return false, res.State
+	}
+
+	if !m.CheckReady {
+		return true, res.State
+	}
+
+	var ready checkMachineReadyResult
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx), "check-machine-ready",
+		machineParam{SystemID: m.SystemID}).Get(ctx, &ready); err != nil {
+		return false, res.State
+	}
+
+	return ready.Ready, res.State
+}
+
+// dependencyLevels groups machines into levels, where machines of a level
+// depend only on machines of previous levels. Machines keep their order
+// within a level.
+func dependencyLevels(machines []OrderedMachine) ([][]OrderedMachine, error) {
+	index := make(map[string]int, len(machines))
+	for i, m := range machines {
+		index[m.SystemID] = i
+	}
+
+	remaining := make([]int, len(machines))
+	dependents := make([][]int, len(machines))
+
+	for i, m := range machines {
+		for _, dep := range m.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrUnknownDependency, m.SystemID, dep)
+			}
+
+			remaining[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var current []int
+
+	for i := range machines {
+		if remaining[i] == 0 {
+			current = append(current, i)
+		}
+	}
+
+	var (
+		levels [][]OrderedMachine
+		done   int
+	)
+
+	for len(current) > 0 {
+		level := make([]OrderedMachine, len(current))
+		next := make([]bool, len(machines))
+
+		for i, m := range current {
+			level[i] = machines[m]
+
+			for _, d := range dependents[m] {
+				remaining[d]--
+				if remaining[d] == 0 {
+					next[d] = true
+				}
+			}
+		}
+
+		levels = append(levels, level)
+		done += len(current)
+		current = current[:0]
+
+		for i, ok := range next {
+			if ok {
+				current = append(current, i)
+			}
+		}
+	}
+
+	if done != len(machines) {
+		var cycle []string
+
+		for i, m := range machines {
+			if remaining[i] > 0 {
+				cycle = append(cycle, m.SystemID)
+			}
+		}
+
+		return nil, fmt.Errorf("%w: %v", ErrDependencyCycle, cycle)
+	}
+
+	return levels, nil
+}
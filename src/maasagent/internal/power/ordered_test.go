@@ -0,0 +1,232 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+func orderedMachine(systemID string, dependsOn ...string) OrderedMachine {
+	return OrderedMachine{
+		SystemID: systemID,
+		PowerParam: PowerParam{
+			DriverType: "ipmi",
+			DriverOpts: map[string]interface{}{"power_id": systemID},
+		},
+		DependsOn: dependsOn,
+	}
+}
+
+func levelIDs(levels [][]OrderedMachine) [][]string {
+	ids := make([][]string, len(levels))
+
+	for i, level := range levels {
+		for _, m := range level {
+			ids[i] = append(ids[i], m.SystemID)
+		}
+	}
+
+	return ids
+}
+
+func TestDependencyLevels(t *testing.T) {
+	testcases := map[string]struct {
+		machines []OrderedMachine
+		levels   [][]string
+		err      error
+	}{
+		"no dependencies": {
+			machines: []OrderedMachine{orderedMachine("a"), orderedMachine("b")},
+			levels:   [][]string{{"a", "b"}},
+		},
+		"composed": {
+			machines: []OrderedMachine{
+				orderedMachine("compute1", "storage", "switch"),
+				orderedMachine("storage", "switch"),
+				orderedMachine("compute2", "storage"),
+				orderedMachine("switch"),
+			},
+			levels: [][]string{{"switch"}, {"storage"}, {"compute1", "compute2"}},
+		},
+		"unknown dependency": {
+			machines: []OrderedMachine{orderedMachine("a", "b")},
+			err:      ErrUnknownDependency,
+		},
+		"cycle": {
+			machines: []OrderedMachine{
+				orderedMachine("a"),
+				orderedMachine("b", "a", "c"),
+				orderedMachine("c", "b"),
+			},
+			err: ErrDependencyCycle,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			levels, err := dependencyLevels(tc.machines)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.levels, levelIDs(levels))
+		})
+	}
+}
+
+func checkMachineReadyActivity(_ context.Context, _ machineParam) (checkMachineReadyResult, error) {
+	return checkMachineReadyResult{}, nil
+}
+
+func TestPowerOnOrdered(t *testing.T) {
+	testcases := map[string]struct {
+		failOn  string
+		ready   bool
+		success bool
+		results []OrderedMachineResult
+	}{
+		"healthy": {
+			ready:   true,
+			success: true,
+			results: []OrderedMachineResult{
+				{SystemID: "switch", Level: 0, State: "on"},
+				{SystemID: "storage", Level: 1, State: "on"},
+				{SystemID: "compute", Level: 2, State: "on"},
+			},
+		},
+		"not ready": {
+			results: []OrderedMachineResult{
+				{SystemID: "switch", Level: 0, State: "on"},
+				{SystemID: "storage", Level: 1, State: "on", Error: ErrPowerGateTimeout.Error(),
+					ErrorCode: errcode.PowerHealthTimeout},
+				{SystemID: "compute", Level: 2, Skipped: true},
+			},
+		},
+		"failed to power on": {
+			failOn: "switch",
+			ready:  true,
+			results: []OrderedMachineResult{
+				{SystemID: "switch", Level: 0},
+				{SystemID: "storage", Level: 1, Skipped: true},
+				{SystemID: "compute", Level: 2, Skipped: true},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			svc := NewPowerService("agent", nil)
+			env := newTestWorkflowEnvironment(svc)
+
+			env.RegisterActivityWithOptions(svc.PowerOn, activity.RegisterOptions{Name: "power-on"})
+			env.RegisterActivityWithOptions(checkMachineReadyActivity,
+				activity.RegisterOptions{Name: "check-machine-ready"})
+
+			var (
+				mutex sync.Mutex
+				on    = make(map[string]bool)
+			)
+
+			env.OnActivity("power-on", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, p PowerOnParam) (*PowerOnResult, error) {
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					id := stringOpt(p.DriverOpts, "power_id")
+					if id == tc.failOn {
+						return nil, errors.New("BMC is not reachable")
+					}
+
+					on[id] = true
+
+					return &PowerOnResult{State: "on"}, nil
+				})
+			env.OnActivity("power-query", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, p PowerQueryParam) (*PowerQueryResult, error) {
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					if on[stringOpt(p.DriverOpts, "power_id")] {
+						return &PowerQueryResult{State: "on"}, nil
+					}
+
+					return &PowerQueryResult{State: "off"}, nil
+				})
+			env.OnActivity("check-machine-ready", mock.Anything, machineParam{SystemID: "storage"}).
+				Return(checkMachineReadyResult{}, nil).Once()
+			env.OnActivity("check-machine-ready", mock.Anything, machineParam{SystemID: "storage"}).
+				Return(checkMachineReadyResult{Ready: tc.ready}, nil)
+
+			storage := orderedMachine("storage", "switch")
+			storage.CheckReady = true
+
+			env.ExecuteWorkflow(svc.powerOnOrdered, PowerOnOrderedParam{
+				AgentSystemID: "agent",
+				Machines:      []OrderedMachine{orderedMachine("compute", "storage"), storage, orderedMachine("switch")},
+				GateTimeout:   60,
+			})
+
+			require.True(t, env.IsWorkflowCompleted())
+			require.NoError(t, env.GetWorkflowError())
+
+			var result PowerOnOrderedResult
+			require.NoError(t, env.GetWorkflowResult(&result))
+
+			assert.Equal(t, [][]string{{"switch"}, {"storage"}, {"compute"}}, result.Levels)
+			assert.Equal(t, tc.success, result.Success)
+
+			if tc.failOn != "" {
+				assert.Contains(t, result.Machines[0].Error, "BMC is not reachable")
+				result.Machines[0].Error = ""
+			}
+
+			assert.Equal(t, tc.results, result.Machines)
+
+			for _, r := range result.Machines {
+				assert.Equal(t, !r.Skipped && r.SystemID != tc.failOn, on[r.SystemID], r.SystemID)
+			}
+		})
+	}
+}
+
+func TestPowerOnOrderedCycle(t *testing.T) {
+	svc := NewPowerService("agent", nil)
+	env := newTestWorkflowEnvironment(svc)
+
+	env.ExecuteWorkflow(svc.powerOnOrdered, PowerOnOrderedParam{
+		Machines: []OrderedMachine{orderedMachine("a", "b"), orderedMachine("b", "a")},
+	})
+
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), ErrDependencyCycle.Error())
+}
@@ -0,0 +1,407 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/snmp"
+)
+
+// defaultPDUPowerOnDelay is how long outlets stay off when the machine is
+// powered on or power cycled, as with power drivers of PDUs
+const defaultPDUPowerOnDelay = 5 * time.Second
+
+var (
+	// ErrInvalidOutlet is returned when node_outlet is not an outlet id,
+	// or a list of them
+	ErrInvalidOutlet = errcode.New(errcode.PowerInvalidParameters, "invalid PDU outlet")
+	// ErrUnsupportedPDUType is returned when pdu_type or pdu_protocol is
+	// unknown
+	ErrUnsupportedPDUType = errcode.New(errcode.PowerUnsupported, "unsupported PDU type")
+)
+
+// pduClient switches outlets of a PDU. Outlets are identified by ids given
+// in node_outlet, which clients map to objects of their protocol.
+type pduClient interface {
+	// states returns power states ("on", "off" or "unknown") of outlets
+	states(ctx context.Context, outlets []string) ([]string, error)
+	// set switches all outlets on or off
+	set(ctx context.Context, outlets []string, on bool) error
+	Close() error
+}
+
+// pduDialer returns pduClient of the PDU of the machine with driver options
+// opts
+type pduDialer func(ctx context.Context, opts map[string]interface{}) (pduClient, error)
+
+// pduDriver switches outlets of PDUs. Machines with more than one power
+// supply can be connected to a group of outlets (e.g. "1,3" or "1-4"),
+// which are switched together. Power actions only need the outlets and
+// credentials of the PDU, the protocol is chosen by the dialer.
+type pduDriver struct {
+	dial pduDialer
+}
+
+// On powers the outlets off, if any of them is on, and powers them on after
+// power_on_delay, so the machine boots from the network, as with the power
+// driver.
+func (d pduDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return pduPower(ctx, d.dial, opts, "on")
+}
+
+func (d pduDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return pduPower(ctx, d.dial, opts, "off")
+}
+
+func (d pduDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return pduPower(ctx, d.dial, opts, "cycle")
+}
+
+// EmulatesCycle returns true, as outlets are switched off and on again
+func (d pduDriver) EmulatesCycle(map[string]interface{}) bool {
+	return true
+}
+
+func (d pduDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return pduPower(ctx, d.dial, opts, "status")
+}
+
+// pduOutlets parses node_outlet, a comma separated list of outlet ids or
+// ranges of outlet numbers. Duplicates are dropped.
+func pduOutlets(value string) ([]string, error) {
+	var outlets []string
+
+	seen := make(map[string]bool)
+
+	add := func(outlet string) {
+		if !seen[outlet] {
+			seen[outlet] = true
+
+			outlets = append(outlets, outlet)
+		}
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+
+		first, last, isRange := strings.Cut(item, "-")
+		if !isRange {
+			if item == "" {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidOutlet, value)
+			}
+
+			add(item)
+
+			continue
+		}
+
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil || from < 1 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidOutlet, value)
+		}
+
+		to, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil || to < from {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidOutlet, value)
+		}
+
+		for outlet := from; outlet <= to; outlet++ {
+			add(strconv.Itoa(outlet))
+		}
+	}
+
+	return outlets, nil
+}
+
+// pduOutletNumber returns outlet number of a numeric outlet id
+func pduOutletNumber(outlet string) (int, error) {
+	n, err := strconv.Atoi(outlet)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidOutlet, outlet)
+	}
+
+	return n, nil
+}
+
+// pduPowerOnDelay returns power_on_delay in seconds, as used by the
+// power drivers
+func pduPowerOnDelay(opts map[string]interface{}) time.Duration {
+	delay, err := strconv.ParseFloat(stringOpt(opts, "power_on_delay"), 64)
+	if err != nil || delay < 0 {
+		return defaultPDUPowerOnDelay
+	}
+
+	return time.Duration(delay * float64(time.Second))
+}
+
+// pduPower performs action ("on", "off", "cycle" or "status") on all
+// outlets of the machine and returns the resulting power state, which is
+// "on" if any of the outlets is on.
+func pduPower(ctx context.Context, dial pduDialer, opts map[string]interface{},
+	action string) (string, PowerDetails, error) {
+	switch action {
+	case "on", "off", "cycle", "status":
+	default:
+		return "", PowerDetails{}, fmt.Errorf("%w: %q", ErrUnsupportedPowerAction, action)
+	}
+
+	outlets, err := pduOutlets(stringOpt(opts, "node_outlet"))
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	c, err := dial(ctx, opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer c.Close()
+
+	states, err := c.states(ctx, outlets)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	state := pduState(states)
+
+	if action == "status" || (action == "off" && state == "off") {
+		return state, PowerDetails{}, nil
+	}
+
+	// Outlets in unknown states are switched off as well, so all of them
+	// are powered on together
+	if state != "off" {
+		if err = c.set(ctx, outlets, false); err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		if action == "off" {
+			return pduStates(ctx, c, outlets)
+		}
+
+		timer := time.NewTimer(pduPowerOnDelay(opts))
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return "", PowerDetails{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if err = c.set(ctx, outlets, true); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return pduStates(ctx, c, outlets)
+}
+
+func pduStates(ctx context.Context, c pduClient, outlets []string) (string, PowerDetails, error) {
+	states, err := c.states(ctx, outlets)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return pduState(states), PowerDetails{}, nil
+}
+
+func pduState(states []string) string {
+	state := "unknown"
+
+	for _, s := range states {
+		switch s {
+		case "on":
+			return "on"
+		case "off":
+			state = "off"
+		}
+	}
+
+	return state
+}
+
+// snmpOutletMap maps outlets of a PDU model to SNMP objects
+type snmpOutletMap struct {
+	// oids returns OIDs of the state and of the control of outlet
+	oids func(outlet string) (state string, control string, err error)
+	// states maps values of state objects to power states, others are
+	// reported as "unknown"
+	states map[int64]string
+	// on and off are values of control objects switching outlets
+	on  int64
+	off int64
+}
+
+// indexedOutlets returns oids of snmpOutletMap for tables indexed by the
+// outlet number
+func indexedOutlets(state, control string) func(string) (string, string, error) {
+	return func(outlet string) (string, string, error) {
+		n, err := pduOutletNumber(outlet)
+		if err != nil {
+			return "", "", err
+		}
+
+		return state + "." + strconv.Itoa(n), control + "." + strconv.Itoa(n), nil
+	}
+}
+
+// snmpPDU switches outlets with SNMP
+type snmpPDU struct {
+	client  *snmp.Client
+	outlets snmpOutletMap
+}
+
+// dialSNMPPDU returns pduClient of the PDU at power_address. community is
+// used with SNMPv1 and SNMPv2c unless snmp_community is set.
+func dialSNMPPDU(ctx context.Context, opts map[string]interface{}, outlets snmpOutletMap,
+	community string) (pduClient, error) {
+	options, err := pduSNMPOptions(opts, community)
+	if err != nil {
+		return nil, err
+	}
+
+	address := stringOpt(opts, "power_address")
+
+	if port := stringOpt(opts, "snmp_port"); port != "" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(strings.Trim(address, "[]"), port)
+		}
+	}
+
+	c, err := snmp.Dial(ctx, address, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snmpPDU{client: c, outlets: outlets}, nil
+}
+
+func (p *snmpPDU) states(ctx context.Context, outlets []string) ([]string, error) {
+	oids := make([]string, len(outlets))
+
+	for i, outlet := range outlets {
+		var err error
+
+		if oids[i], _, err = p.outlets.oids(outlet); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := p.client.Get(ctx, oids...)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]string, len(res))
+
+	for i, vb := range res {
+		states[i] = "unknown"
+
+		if v, ok := vb.Value.(int64); ok {
+			if state, ok := p.outlets.states[v]; ok {
+				states[i] = state
+			}
+		}
+	}
+
+	return states, nil
+}
+
+// set switches outlets in a single request
+func (p *snmpPDU) set(ctx context.Context, outlets []string, on bool) error {
+	value := p.outlets.off
+	if on {
+		value = p.outlets.on
+	}
+
+	varbinds := make([]snmp.Varbind, len(outlets))
+
+	for i, outlet := range outlets {
+		_, oid, err := p.outlets.oids(outlet)
+		if err != nil {
+			return err
+		}
+
+		varbinds[i] = snmp.Varbind{OID: oid, Value: value}
+	}
+
+	_, err := p.client.Set(ctx, varbinds...)
+
+	return err
+}
+
+func (p *snmpPDU) Close() error {
+	return p.client.Close()
+}
+
+// pduSNMPVersions maps snmp_version option of the power drivers. SNMPv1 is
+// used by default, as by the power drivers.
+var pduSNMPVersions = map[string]int{
+	"":   snmp.Version1,
+	"1":  snmp.Version1,
+	"2c": snmp.Version2c,
+	"3":  snmp.Version3,
+}
+
+// pduSNMPOptions returns options of the SNMP client from snmp_* options.
+// SNMPv3 protocols are set only with passphrases, so the security level
+// follows the credentials given.
+func pduSNMPOptions(opts map[string]interface{}, community string) ([]snmp.Option, error) {
+	version, ok := pduSNMPVersions[stringOpt(opts, "snmp_version")]
+	if !ok {
+		return nil, fmt.Errorf("%w: version %q", snmp.ErrUnsupportedProtocol, stringOpt(opts, "snmp_version"))
+	}
+
+	options := []snmp.Option{snmp.WithVersion(version)}
+
+	if version != snmp.Version3 {
+		if c := stringOpt(opts, "snmp_community"); c != "" {
+			community = c
+		}
+
+		return append(options, snmp.WithCommunity(community)), nil
+	}
+
+	options = append(options, snmp.WithUser(stringOpt(opts, "snmp_user")))
+
+	if pass := stringOpt(opts, "snmp_auth_pass"); pass != "" {
+		protocol := strings.ToUpper(stringOpt(opts, "snmp_auth_protocol"))
+		if protocol == "" {
+			protocol = snmp.AuthSHA
+		}
+
+		options = append(options, snmp.WithAuth(protocol, pass))
+	}
+
+	if pass := stringOpt(opts, "snmp_priv_pass"); pass != "" {
+		protocol := strings.ToUpper(stringOpt(opts, "snmp_priv_protocol"))
+		if protocol == "" {
+			protocol = snmp.PrivAES
+		}
+
+		options = append(options, snmp.WithPrivacy(protocol, pass))
+	}
+
+	return options, nil
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maas.io/core/src/maasagent/internal/snmp"
+)
+
+// fakePDU is an SNMPv1 agent of a PDU with outlets 1-127, which control
+// and state are the same object. It keeps outlet states and the order of
+// commands sent to outlets.
+type fakePDU struct {
+	outlets map[byte]byte
+	// values are names of commands
+	values    map[byte]string
+	commands  []string
+	community string
+	mutex     sync.Mutex
+}
+
+func newFakePDU(t *testing.T, pdu *fakePDU) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65507)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if resp := pdu.handle(buf[:n]); resp != nil {
+				//nolint:errcheck // the client retries
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func (p *fakePDU) handle(b []byte) []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, msg := berRead(&b)
+	_, version := berRead(&msg)
+	_, community := berRead(&msg)
+	kind, req := berRead(&msg)
+
+	if string(community) != p.community {
+		return nil
+	}
+
+	_, requestID := berRead(&req)
+	berRead(&req)
+	berRead(&req)
+	_, list := berRead(&req)
+
+	var res []byte
+
+	for len(list) > 0 {
+		_, vb := berRead(&list)
+		_, oid := berRead(&vb)
+		_, value := berRead(&vb)
+
+		outlet := oid[len(oid)-1]
+
+		if kind == 0xa3 {
+			p.outlets[outlet] = value[0]
+			p.commands = append(p.commands, p.values[value[0]])
+		}
+
+		state := []byte{}
+		if s, ok := p.outlets[outlet]; ok {
+			state = []byte{s}
+		}
+
+		tag := byte(0x02)
+		if len(state) == 0 {
+			tag = 0x81
+		}
+
+		res = append(res, berTLV(0x30, append(berTLV(0x06, oid), berTLV(tag, state)...))...)
+	}
+
+	resp := berTLV(0x02, requestID)
+	resp = append(resp, berTLV(0x02, []byte{0})...)
+	resp = append(resp, berTLV(0x02, []byte{0})...)
+	resp = append(resp, berTLV(0x30, res)...)
+
+	msg = berTLV(0x02, version)
+	msg = append(msg, berTLV(0x04, community)...)
+	msg = append(msg, berTLV(0xa2, resp)...)
+
+	return berTLV(0x30, msg)
+}
+
+func (p *fakePDU) state() (map[byte]byte, []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	outlets := make(map[byte]byte, len(p.outlets))
+	for outlet, state := range p.outlets {
+		outlets[outlet] = state
+	}
+
+	return outlets, append([]string{}, p.commands...)
+}
+
+// berRead reads the first TLV of b (with lengths up to 255 bytes)
+func berRead(b *[]byte) (byte, []byte) {
+	tag, length, header := (*b)[0], int((*b)[1]), 2
+	if length == 0x81 {
+		length, header = int((*b)[2]), 3
+	}
+
+	value := (*b)[header : header+length]
+	*b = (*b)[header+length:]
+
+	return tag, value
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	if len(value) < 0x80 {
+		return append([]byte{tag, byte(len(value))}, value...)
+	}
+
+	return append([]byte{tag, 0x81, byte(len(value))}, value...)
+}
+
+func TestPDUOutlets(t *testing.T) {
+	testcases := map[string]struct {
+		value   string
+		outlets []string
+		err     error
+	}{
+		"single outlet": {
+			value:   "3",
+			outlets: []string{"3"},
+		},
+		"group of outlets": {
+			value:   "1, 3",
+			outlets: []string{"1", "3"},
+		},
+		"range of outlets": {
+			value:   "1-3,6,2",
+			outlets: []string{"1", "2", "3", "6"},
+		},
+		"outlet ids": {
+			value:   "AA1,BA1",
+			outlets: []string{"AA1", "BA1"},
+		},
+		"missing outlet": {
+			value: "",
+			err:   ErrInvalidOutlet,
+		},
+		"range of outlet ids": {
+			value: "AA1-AA4",
+			err:   ErrInvalidOutlet,
+		},
+		"reversed range": {
+			value: "4-2",
+			err:   ErrInvalidOutlet,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			outlets, err := pduOutlets(tc.value)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.outlets, outlets)
+		})
+	}
+}
+
+func TestPDUSNMPOptions(t *testing.T) {
+	testcases := map[string]struct {
+		opts map[string]interface{}
+		err  error
+	}{
+		"defaults": {
+			opts: map[string]interface{}{},
+		},
+		"SNMPv3 with privacy": {
+			opts: map[string]interface{}{"snmp_version": "3", "snmp_user": "maas",
+				"snmp_auth_protocol": "md5", "snmp_auth_pass": "auth-secret",
+				"snmp_priv_protocol": "des", "snmp_priv_pass": "priv-secret"},
+		},
+		"unknown version": {
+			opts: map[string]interface{}{"snmp_version": "2"},
+			err:  snmp.ErrUnsupportedProtocol,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := pduSNMPOptions(tc.opts, defaultAPCCommunity)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestPDUPower(t *testing.T) {
+	testcases := map[string]struct {
+		dial     pduDialer
+		outlets  map[byte]byte
+		opts     map[string]interface{}
+		action   string
+		state    string
+		result   map[byte]byte
+		commands []string
+		err      error
+	}{
+		"status of outlet group": {
+			outlets:  map[byte]byte{1: 2, 3: 1},
+			opts:     map[string]interface{}{"node_outlet": "1,3"},
+			action:   "status",
+			state:    "on",
+			result:   map[byte]byte{1: 2, 3: 1},
+			commands: []string{},
+		},
+		"power on": {
+			outlets:  map[byte]byte{1: 2, 2: 2},
+			opts:     map[string]interface{}{"node_outlet": "1-2"},
+			action:   "on",
+			state:    "on",
+			result:   map[byte]byte{1: 1, 2: 1},
+			commands: []string{"on", "on"},
+		},
+		"power on cycles outlets which are on": {
+			outlets:  map[byte]byte{1: 1, 2: 2},
+			opts:     map[string]interface{}{"node_outlet": "1-2"},
+			action:   "on",
+			state:    "on",
+			result:   map[byte]byte{1: 1, 2: 1},
+			commands: []string{"off", "off", "on", "on"},
+		},
+		"power off": {
+			outlets:  map[byte]byte{4: 1},
+			opts:     map[string]interface{}{"node_outlet": "4", "pdu_type": "MASTERSWITCH"},
+			action:   "off",
+			state:    "off",
+			result:   map[byte]byte{4: 2},
+			commands: []string{"off"},
+		},
+		"power off outlets which are off": {
+			outlets:  map[byte]byte{4: 2},
+			opts:     map[string]interface{}{"node_outlet": "4"},
+			action:   "off",
+			state:    "off",
+			result:   map[byte]byte{4: 2},
+			commands: []string{},
+		},
+		"power cycle": {
+			outlets:  map[byte]byte{5: 1},
+			opts:     map[string]interface{}{"node_outlet": "5"},
+			action:   "cycle",
+			state:    "on",
+			result:   map[byte]byte{5: 1},
+			commands: []string{"off", "on"},
+		},
+		"missing outlet": {
+			outlets:  map[byte]byte{1: 1},
+			opts:     map[string]interface{}{"node_outlet": "7"},
+			action:   "status",
+			result:   map[byte]byte{1: 1},
+			commands: []string{},
+			err:      snmp.ErrNoSuchObject,
+		},
+		"outlet 0": {
+			outlets:  map[byte]byte{1: 1},
+			opts:     map[string]interface{}{"node_outlet": "0"},
+			action:   "status",
+			result:   map[byte]byte{1: 1},
+			commands: []string{},
+			err:      ErrInvalidOutlet,
+		},
+		"raritan PX": {
+			dial:     dialRaritan,
+			outlets:  map[byte]byte{2: 1, 3: 1},
+			opts:     map[string]interface{}{"node_outlet": "2-3", "pdu_type": "PX"},
+			action:   "off",
+			state:    "off",
+			result:   map[byte]byte{2: 0, 3: 0},
+			commands: []string{"off", "off"},
+		},
+		"unknown PDU type": {
+			outlets:  map[byte]byte{1: 1},
+			opts:     map[string]interface{}{"node_outlet": "1", "pdu_type": "PDU2G"},
+			action:   "status",
+			result:   map[byte]byte{1: 1},
+			commands: []string{},
+			err:      ErrUnsupportedPDUType,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pdu := &fakePDU{outlets: tc.outlets, values: map[byte]string{1: "on", 2: "off"},
+				commands: []string{}, community: "private"}
+			if tc.dial == nil {
+				tc.dial = dialAPC
+			} else {
+				pdu.values = map[byte]string{0: "off", 1: "on"}
+			}
+
+			opts := map[string]interface{}{"power_address": newFakePDU(t, pdu), "power_on_delay": "0"}
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+
+			state, _, err := runDriver(context.Background(), pduDriver{dial: tc.dial}, tc.action, opts)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.state, state)
+
+			outlets, commands := pdu.state()
+			assert.Equal(t, tc.result, outlets)
+			assert.Equal(t, tc.commands, commands)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/atomicfile"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/pathutil"
+)
+
+// Driver options used to pin identity of virtualization hosts
+const (
+	// optCertFingerprint is SHA256 fingerprint of the host TLS certificate,
+	// hex encoded with optional colons and "sha256:" prefix.
+	optCertFingerprint = "certificate_fingerprint"
+	// optHostKey is SSH public key of the host in authorized_keys format
+	// (e.g. "ssh-ed25519 AAAA...").
+	optHostKey = "host_key"
+)
+
+var (
+	// ErrFingerprintMismatch is returned when host certificate doesn't
+	// match the pinned fingerprint
+	ErrFingerprintMismatch = errcode.New(errcode.PowerCertificateMismatch, "certificate fingerprint mismatch")
+	// ErrInvalidPin is returned when pinned fingerprint or host key is malformed
+	ErrInvalidPin = errcode.New(errcode.PowerInvalidParameters, "invalid pinned host identity")
+)
+
+// pinned returns whether the identity of the host is pinned in opts
+func pinned(opts map[string]interface{}) bool {
+	return stringOpt(opts, optCertFingerprint) != "" || stringOpt(opts, optHostKey) != ""
+}
+
+// knownHostsDir is where known_hosts files with pinned keys are written
+var knownHostsDir = func() string { return pathutil.GetDataPath("known_hosts") }
+
+func normalizeFingerprint(fp string) (string, error) {
+	fp = strings.ToLower(strings.TrimSpace(fp))
+	fp = strings.TrimPrefix(fp, "sha256:")
+	fp = strings.ReplaceAll(fp, ":", "")
+
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("%w: %q is not a SHA256 fingerprint", ErrInvalidPin, fp)
+	}
+
+	return fp, nil
+}
+
+// verifyFingerprint returns tls.Config.VerifyPeerCertificate function that
+// fails closed unless the leaf certificate matches the pinned fingerprint.
+func verifyFingerprint(pin string) (func([][]byte, [][]*x509.Certificate) error, error) {
+	expected, err := normalizeFingerprint(pin)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return ErrFingerprintMismatch
+		}
+
+		sum := sha256.Sum256(rawCerts[0])
+		if actual := hex.EncodeToString(sum[:]); actual != expected {
+			return fmt.Errorf("%w: got %s", ErrFingerprintMismatch, actual)
+		}
+
+		return nil
+	}, nil
+}
+
+// verifiedTLSConfig returns TLS configuration of controllers which may use
+// self-signed certificates. The chain is verified only with power_verify_ssl
+// set to "y", as by the power drivers, and the certificate is verified
+// against the pinned fingerprint instead, if there is one.
+func verifiedTLSConfig(opts map[string]interface{}) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		//nolint:gosec // see above
+		InsecureSkipVerify: stringOpt(opts, "power_verify_ssl") != "y",
+	}
+
+	if pin := stringOpt(opts, optCertFingerprint); pin != "" {
+		var err error
+
+		tlsConfig.InsecureSkipVerify = true
+
+		tlsConfig.VerifyPeerCertificate, err = verifyFingerprint(pin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// pinnedVirshURI returns libvirt URI that verifies SSH host key against
+// the pinned one, with known_hosts and known_hosts_verify options. The
+// libvirt client of the Agent passes them to ssh, but virsh ignores them
+// with the plain ssh transport, so the libssh transport is used instead.
+func pinnedVirshURI(uri, hostKey, dir string) (string, error) {
+	fields := strings.Fields(hostKey)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("%w: host key must be in authorized_keys format", ErrInvalidPin)
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidPin, err)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	driver, transport, _ := strings.Cut(u.Scheme, "+")
+	if transport != "ssh" && transport != "libssh" {
+		return "", fmt.Errorf("%w: host key cannot be pinned for %q transport", ErrInvalidPin, transport)
+	}
+
+	// known_hosts uses [host]:port notation for non-default ports only
+	host := u.Hostname()
+	if port := u.Port(); port != "" && port != "22" {
+		host = "[" + host + "]:" + port
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(uri + "\x00" + hostKey))
+	knownHosts := filepath.Join(dir, hex.EncodeToString(sum[:8]))
+
+	line := fmt.Sprintf("%s %s %s\n", host, fields[0], fields[1])
+	if err := atomicfile.WriteFile(knownHosts, []byte(line), 0o600); err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("known_hosts", knownHosts)
+	query.Set("known_hosts_verify", "normal")
+
+	u.Scheme = driver + "+libssh"
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
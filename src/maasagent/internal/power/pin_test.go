@@ -0,0 +1,126 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeFingerprint(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out string
+		err error
+	}{
+		"plain": {
+			in:  "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08",
+			out: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		},
+		"colons and prefix": {
+			in: "sha256:9F:86:D0:81:88:4C:7D:65:9A:2F:EA:A0:C5:5A:D0:15:" +
+				"A3:BF:4F:1B:2B:0B:82:2C:D1:5D:6C:15:B0:F0:0A:08",
+			out: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		},
+		"too short": {
+			in:  "9f86d081",
+			err: ErrInvalidPin,
+		},
+		"not hex": {
+			in:  "zz86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			err: ErrInvalidPin,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := normalizeFingerprint(tc.in)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestPinnedVirshURI(t *testing.T) {
+	const hostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB6Yb2mB9ZgYqfLqo0c6s2tV1o3bL5q7F2y6p9gKcC5P root@kvm"
+
+	testcases := map[string]struct {
+		uri        string
+		hostKey    string
+		out        string
+		knownHosts string
+		err        error
+	}{
+		"ssh": {
+			uri:        "qemu+ssh://ubuntu@kvm/system",
+			hostKey:    hostKey,
+			out:        "qemu+libssh://ubuntu@kvm/system?known_hosts=",
+			knownHosts: "kvm ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB6Yb2mB9ZgYqfLqo0c6s2tV1o3bL5q7F2y6p9gKcC5P\n",
+		},
+		"custom port": {
+			uri:        "qemu+ssh://ubuntu@10.0.0.1:2222/system",
+			hostKey:    hostKey,
+			out:        "qemu+libssh://ubuntu@10.0.0.1:2222/system?known_hosts=",
+			knownHosts: "[10.0.0.1]:2222 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB6Yb2mB9ZgYqfLqo0c6s2tV1o3bL5q7F2y6p9gKcC5P\n",
+		},
+		"tcp transport": {
+			uri:     "qemu+tcp://kvm/system",
+			hostKey: hostKey,
+			err:     ErrInvalidPin,
+		},
+		"malformed key": {
+			uri:     "qemu+ssh://kvm/system",
+			hostKey: "AAAAC3NzaC1lZDI1NTE5",
+			err:     ErrInvalidPin,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			out, err := pinnedVirshURI(tc.uri, tc.hostKey, dir)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Contains(t, out, tc.out)
+			assert.Contains(t, out, "known_hosts_verify=normal")
+
+			files, err := filepath.Glob(filepath.Join(dir, "*"))
+			require.NoError(t, err)
+			require.Len(t, files, 1)
+
+			data, err := os.ReadFile(files[0])
+			require.NoError(t, err)
+			assert.Equal(t, tc.knownHosts, string(data))
+		})
+	}
+}
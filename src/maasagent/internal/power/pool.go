@@ -0,0 +1,209 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultConnIdleTimeout  = 5 * time.Minute
+	defaultConnHealthPeriod = 30 * time.Second
+)
+
+// pooledConn is a persistent authenticated connection to a host
+type pooledConn interface {
+	// Ping checks that the connection is still usable
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+type poolEntry[T pooledConn] struct {
+	lastUsed time.Time
+	conn     T
+}
+
+// connPool keeps connections to frequently used virtualization hosts, so
+// TLS/SSH handshakes are not made for every operation. Connections that
+// were not used for idleTimeout or failed a health check are closed.
+// Maintenance goroutine runs only while the pool is not empty.
+type connPool[T pooledConn] struct {
+	conns       map[string]*poolEntry[T]
+	idleTimeout time.Duration
+	healthCheck time.Duration
+	running     bool
+	mutex       sync.Mutex
+}
+
+func newConnPool[T pooledConn](idleTimeout, healthCheck time.Duration) *connPool[T] {
+	return &connPool[T]{
+		conns:       make(map[string]*poolEntry[T]),
+		idleTimeout: idleTimeout,
+		healthCheck: healthCheck,
+	}
+}
+
+// get returns pooled connection for the key or dials a new one.
+func (p *connPool[T]) get(ctx context.Context, key string,
+	dial func(ctx context.Context) (T, error)) (T, error) {
+	p.mutex.Lock()
+
+	if e, ok := p.conns[key]; ok {
+		e.lastUsed = time.Now()
+		p.mutex.Unlock()
+
+		return e.conn, nil
+	}
+
+	p.mutex.Unlock()
+
+	// Dial without holding the lock, handshakes might be slow
+	conn, err := dial(ctx)
+	if err != nil {
+		return conn, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Somebody else might have dialed in the meantime
+	if e, ok := p.conns[key]; ok {
+		//nolint:errcheck // the connection was never used
+		conn.Close()
+
+		e.lastUsed = time.Now()
+
+		return e.conn, nil
+	}
+
+	p.conns[key] = &poolEntry[T]{conn: conn, lastUsed: time.Now()}
+
+	if !p.running {
+		p.running = true
+
+		go p.maintain()
+	}
+
+	return conn, nil
+}
+
+// evict closes connection of the key, e.g. when it has failed.
+func (p *connPool[T]) evict(key string) {
+	p.evictEntry(key, nil)
+}
+
+// evictEntry closes connection of the key, if it is still the expected
+// one (any, if expected is nil).
+func (p *connPool[T]) evictEntry(key string, expected *poolEntry[T]) {
+	p.mutex.Lock()
+
+	e, ok := p.conns[key]
+	if ok && (expected == nil || e == expected) {
+		delete(p.conns, key)
+	} else {
+		ok = false
+	}
+
+	p.mutex.Unlock()
+
+	if ok {
+		//nolint:errcheck // the connection is broken anyway
+		e.conn.Close()
+	}
+}
+
+// use calls fn with pooled connection. If fn fails, the connection is
+// evicted and fn is retried once with a fresh connection, because a pooled
+// connection might have been closed by the remote end.
+func (p *connPool[T]) use(ctx context.Context, key string,
+	dial func(ctx context.Context) (T, error), fn func(conn T) error) error {
+	for attempt := 0; ; attempt++ {
+		conn, err := p.get(ctx, key, dial)
+		if err != nil {
+			return err
+		}
+
+		err = fn(conn)
+		if err == nil {
+			return nil
+		}
+
+		p.evict(key)
+
+		if attempt > 0 || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func (p *connPool[T]) maintain() {
+	ticker := time.NewTicker(p.healthCheck)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !p.check() {
+			return
+		}
+	}
+}
+
+// check closes idle and unhealthy connections. It returns false when the
+// pool became empty and maintenance should stop.
+func (p *connPool[T]) check() bool {
+	p.mutex.Lock()
+
+	entries := make(map[string]*poolEntry[T], len(p.conns))
+	for k, e := range p.conns {
+		entries[k] = e
+	}
+
+	p.mutex.Unlock()
+
+	for key, e := range entries {
+		p.mutex.Lock()
+		idle := time.Since(e.lastUsed) > p.idleTimeout
+		p.mutex.Unlock()
+
+		if idle {
+			p.evictEntry(key, e)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), p.healthCheck)
+		err := e.conn.Ping(ctx)
+
+		cancel()
+
+		if err != nil {
+			log.Debug().Err(err).Msg("Pooled connection failed health check")
+			p.evictEntry(key, e)
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.conns) == 0 {
+		p.running = false
+		return false
+	}
+
+	return true
+}
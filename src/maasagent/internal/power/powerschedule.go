@@ -0,0 +1,398 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/workflow/schedule"
+)
+
+const (
+	// powerScheduleRequester is the requester of scheduled power actions
+	powerScheduleRequester = "power-schedule"
+	// powerScheduleTimeout is how long a scheduled power action can take
+	powerScheduleTimeout = sequencedActionTimeout + 5*time.Minute
+)
+
+var (
+	// ErrInvalidPowerSchedule is returned for power schedules which can't
+	// be registered, e.g. with an unknown action or time zone
+	ErrInvalidPowerSchedule = errcode.New(errcode.PowerInvalidParameters, "invalid power schedule")
+	// ErrPowerSchedulesUnavailable is returned when the power service has
+	// no client to manage Temporal Schedules
+	ErrPowerSchedulesUnavailable = errors.New("power schedules are not available")
+)
+
+// WithScheduleClient allows to register Temporal Schedules of recurring
+// power actions of machines
+func WithScheduleClient(c client.ScheduleClient) PowerServiceOption {
+	return func(s *PowerService) {
+		s.schedules = c
+	}
+}
+
+// PowerSchedule is a recurring power action of a machine
+type PowerSchedule struct {
+	// Name identifies the schedule among schedules of the machine
+	// (e.g. "nightly-off")
+	Name     string `json:"name"`
+	SystemID string `json:"system_id"`
+	// Action is "on", "off", "cycle" or "reset"
+	Action string `json:"action"`
+	// Cron are cron expressions of when the action is performed
+	// (e.g. "0 7 * * MON-FRI")
+	Cron []string `json:"cron"`
+	// TimeZone is the IANA time zone Cron is evaluated in, e.g. the one of
+	// the lab of the machine. (default: UTC)
+	TimeZone string `json:"time_zone,omitempty"`
+	PowerParam
+}
+
+func (p PowerSchedule) validate() error {
+	for _, v := range []string{p.Name, p.SystemID} {
+		if v == "" || strings.ContainsAny(v, ":/") {
+			return fmt.Errorf("%w: name and system_id must be set, without ':' or '/'",
+				ErrInvalidPowerSchedule)
+		}
+	}
+
+	if _, ok := sequencedActions[p.Action]; !ok || p.Action == "query" {
+		return fmt.Errorf("%w: unsupported action %q", ErrInvalidPowerSchedule, p.Action)
+	}
+
+	if len(p.Cron) == 0 {
+		return fmt.Errorf("%w: cron is required", ErrInvalidPowerSchedule)
+	}
+
+	if _, err := time.LoadLocation(p.TimeZone); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPowerSchedule, err)
+	}
+
+	return nil
+}
+
+// note describes the schedule in the Temporal UI and when listed
+func (p PowerSchedule) note() string {
+	tz := p.TimeZone
+	if tz == "" {
+		tz = "UTC"
+	}
+
+	return fmt.Sprintf("power %s %s at %s (%s)", p.Action, p.SystemID, strings.Join(p.Cron, ", "), tz)
+}
+
+// powerSchedulePrefix is the prefix of IDs of power schedules of the Agent.
+// It isn't "<system_id>@agent:", so power schedules are not removed by the
+// schedule manager of the Agent, which owns those.
+func powerSchedulePrefix(agentSystemID string) string {
+	return agentSystemID + "@power-schedule:"
+}
+
+// powerScheduleID returns the ID of the Temporal Schedule of the machine
+func powerScheduleID(agentSystemID, systemID, name string) string {
+	return powerSchedulePrefix(agentSystemID) + systemID + ":" + name
+}
+
+// SchedulePowerActionsParam is the parameter of schedule-power-actions
+// workflow
+type SchedulePowerActionsParam struct {
+	Schedules []PowerSchedule `json:"schedules"`
+}
+
+// SchedulePowerActionsResult is the result of schedule-power-actions workflow
+type SchedulePowerActionsResult struct {
+	// IDs are IDs of Temporal Schedules in order of schedules of the
+	// parameter
+	IDs []string `json:"ids"`
+}
+
+// EnsurePowerScheduleParam is the activity parameter for
+// ensure-power-schedule
+type EnsurePowerScheduleParam struct {
+	PowerSchedule
+}
+
+// ListPowerSchedulesParam is the parameter of list-power-schedules workflow
+// and describe-power-schedules activity
+type ListPowerSchedulesParam struct {
+	// SystemID limits schedules to the ones of the machine, if set
+	SystemID string `json:"system_id,omitempty"`
+}
+
+// ScheduledPowerAction is a registered power schedule
+type ScheduledPowerAction struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	SystemID string `json:"system_id"`
+	// Description is the action, cron expressions and time zone of the
+	// schedule
+	Description string `json:"description"`
+	Paused      bool   `json:"paused,omitempty"`
+	// NextRuns are the next times the action is performed
+	NextRuns []time.Time `json:"next_runs,omitempty"`
+}
+
+// ListPowerSchedulesResult is the result of list-power-schedules workflow
+// and describe-power-schedules activity
+type ListPowerSchedulesResult struct {
+	Schedules []ScheduledPowerAction `json:"schedules"`
+}
+
+// CancelPowerSchedulesParam is the parameter of cancel-power-schedules
+// workflow
+type CancelPowerSchedulesParam struct {
+	// SystemID limits cancelled schedules to the ones of the machine, all
+	// power schedules of the Agent are cancelled if empty
+	SystemID string `json:"system_id,omitempty"`
+	// Names limits cancelled schedules to the ones with these names
+	Names []string `json:"names,omitempty"`
+}
+
+// CancelPowerSchedulesResult is the result of cancel-power-schedules workflow
+type CancelPowerSchedulesResult struct {
+	// Cancelled are IDs of removed Temporal Schedules
+	Cancelled []string `json:"cancelled"`
+}
+
+// DeletePowerScheduleParam is the activity parameter for
+// delete-power-schedule
+type DeletePowerScheduleParam struct {
+	// ID is the ID of a power schedule of the Agent, as listed by
+	// describe-power-schedules
+	ID string `json:"id"`
+}
+
+func powerScheduleContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 5,
+		},
+	})
+}
+
+// schedulePowerActions registers Temporal Schedules performing power actions
+// of machines with power-sequenced workflow, e.g. to power off lab machines
+// nightly and on again in the morning. Schedules are evaluated by the
+// Temporal server in the time zone of each machine, so they are neither
+// skipped nor duplicated when the Agent restarts. Registering a schedule
+// with the name of an existing one of the machine replaces it. Nothing is
+// registered unless all schedules are valid.
+func (s *PowerService) schedulePowerActions(ctx tworkflow.Context,
+	param SchedulePowerActionsParam) (*SchedulePowerActionsResult, error) {
+	localCtx := tworkflow.WithLocalActivityOptions(ctx, tworkflow.LocalActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+	})
+
+	// Time zones are validated out of the workflow, as the time zone
+	// database of the rack can change between replays
+	if err := tworkflow.ExecuteLocalActivity(localCtx,
+		func(context.Context) error {
+			for _, p := range param.Schedules {
+				if err := p.validate(); err != nil {
+					return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+				}
+			}
+
+			return nil
+		}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	result := &SchedulePowerActionsResult{IDs: make([]string, len(param.Schedules))}
+
+	for i, p := range param.Schedules {
+		if err := tworkflow.ExecuteActivity(powerScheduleContext(ctx), "ensure-power-schedule",
+			EnsurePowerScheduleParam{PowerSchedule: p}).
+			Get(ctx, &result.IDs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// listPowerSchedules returns power schedules registered for machines of
+// the Agent
+func (s *PowerService) listPowerSchedules(ctx tworkflow.Context,
+	param ListPowerSchedulesParam) (*ListPowerSchedulesResult, error) {
+	var result ListPowerSchedulesResult
+
+	if err := tworkflow.ExecuteActivity(powerScheduleContext(ctx), "describe-power-schedules",
+		param).Get(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// cancelPowerSchedules removes power schedules of machines of the Agent.
+// Power actions already started by the schedules are not cancelled.
+func (s *PowerService) cancelPowerSchedules(ctx tworkflow.Context,
+	param CancelPowerSchedulesParam) (*CancelPowerSchedulesResult, error) {
+	var list ListPowerSchedulesResult
+
+	if err := tworkflow.ExecuteActivity(powerScheduleContext(ctx), "describe-power-schedules",
+		ListPowerSchedulesParam{SystemID: param.SystemID}).
+		Get(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	result := &CancelPowerSchedulesResult{Cancelled: []string{}}
+
+	for _, sched := range list.Schedules {
+		if len(param.Names) > 0 && !slices.Contains(param.Names, sched.Name) {
+			continue
+		}
+
+		if err := tworkflow.ExecuteActivity(powerScheduleContext(ctx), "delete-power-schedule",
+			DeletePowerScheduleParam{ID: sched.ID}).Get(ctx, nil); err != nil {
+			return nil, err
+		}
+
+		result.Cancelled = append(result.Cancelled, sched.ID)
+	}
+
+	return result, nil
+}
+
+// EnsurePowerSchedule creates or replaces the Temporal Schedule of the
+// power schedule. Scheduled power actions are started on the task queue of
+// the activity, where power-sequenced workflow is registered.
+func (s *PowerService) EnsurePowerSchedule(ctx context.Context,
+	param EnsurePowerScheduleParam) (string, error) {
+	if s.schedules == nil {
+		return "", ErrPowerSchedulesUnavailable
+	}
+
+	p := param.PowerSchedule
+	if err := p.validate(); err != nil {
+		return "", temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	p.Requester = powerScheduleRequester
+
+	id := powerScheduleID(s.systemID, p.SystemID, p.Name)
+
+	err := schedule.Ensure(ctx, s.schedules, schedule.Schedule{
+		ID:        id,
+		Workflow:  "power-sequenced",
+		TaskQueue: activity.GetInfo(ctx).TaskQueue,
+		Args: []any{PowerSequencedParam{
+			AgentSystemID: s.systemID,
+			Action:        p.Action,
+			Machines:      []SequencedMachine{{SystemID: p.SystemID, PowerParam: p.PowerParam}},
+		}},
+		Cron:             p.Cron,
+		TimeZone:         p.TimeZone,
+		ExecutionTimeout: powerScheduleTimeout,
+		Note:             p.note(),
+	})
+
+	// Cron expressions are validated by the Temporal server
+	var invalid *serviceerror.InvalidArgument
+	if errors.As(err, &invalid) {
+		err = fmt.Errorf("%w: %w", ErrInvalidPowerSchedule, err)
+		return "", temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// DescribePowerSchedules lists power schedules of machines of the Agent
+func (s *PowerService) DescribePowerSchedules(ctx context.Context,
+	param ListPowerSchedulesParam) (*ListPowerSchedulesResult, error) {
+	if s.schedules == nil {
+		return nil, ErrPowerSchedulesUnavailable
+	}
+
+	iter, err := s.schedules.List(ctx, client.ScheduleListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := powerSchedulePrefix(s.systemID)
+
+	result := &ListPowerSchedulesResult{Schedules: []ScheduledPowerAction{}}
+
+	for iter.HasNext() {
+		entry, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		id, ok := strings.CutPrefix(entry.ID, prefix)
+		if !ok {
+			continue
+		}
+
+		systemID, name, ok := strings.Cut(id, ":")
+		if !ok {
+			continue
+		}
+
+		if param.SystemID != "" && systemID != param.SystemID {
+			continue
+		}
+
+		result.Schedules = append(result.Schedules, ScheduledPowerAction{
+			ID:          entry.ID,
+			Name:        name,
+			SystemID:    systemID,
+			Description: entry.Note,
+			Paused:      entry.Paused,
+			NextRuns:    entry.NextActionTimes,
+		})
+	}
+
+	slices.SortFunc(result.Schedules, func(a, b ScheduledPowerAction) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return result, nil
+}
+
+// DeletePowerSchedule removes the Temporal Schedule of a power schedule.
+// Only power schedules of the Agent can be removed, not the ones of other
+// Agents or schedules managed by the Agent itself.
+func (s *PowerService) DeletePowerSchedule(ctx context.Context, param DeletePowerScheduleParam) error {
+	if s.schedules == nil {
+		return ErrPowerSchedulesUnavailable
+	}
+
+	if !strings.HasPrefix(param.ID, powerSchedulePrefix(s.systemID)) {
+		err := fmt.Errorf("%w: %q is not a power schedule of the Agent", ErrInvalidPowerSchedule, param.ID)
+		return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return schedule.Remove(ctx, s.schedules, param.ID)
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	tworkflow "go.temporal.io/sdk/workflow"
+)
+
+type fakeScheduleClient struct {
+	client.ScheduleClient
+	schedules map[string]client.ScheduleOptions
+	updated   []string
+}
+
+func (c *fakeScheduleClient) Create(_ context.Context,
+	opts client.ScheduleOptions) (client.ScheduleHandle, error) {
+	if _, ok := c.schedules[opts.ID]; ok {
+		return nil, temporal.ErrScheduleAlreadyRunning
+	}
+
+	c.schedules[opts.ID] = opts
+
+	return nil, nil
+}
+
+func (c *fakeScheduleClient) GetHandle(_ context.Context, id string) client.ScheduleHandle {
+	return &fakeScheduleHandle{id: id, client: c}
+}
+
+func (c *fakeScheduleClient) List(_ context.Context,
+	_ client.ScheduleListOptions) (client.ScheduleListIterator, error) {
+	iter := &fakeScheduleListIterator{}
+	for id, opts := range c.schedules {
+		iter.entries = append(iter.entries, &client.ScheduleListEntry{ID: id, Note: opts.Note})
+	}
+
+	return iter, nil
+}
+
+type fakeScheduleHandle struct {
+	client.ScheduleHandle
+	client *fakeScheduleClient
+	id     string
+}
+
+func (h *fakeScheduleHandle) Update(_ context.Context, opts client.ScheduleUpdateOptions) error {
+	_, err := opts.DoUpdate(client.ScheduleUpdateInput{})
+	h.client.updated = append(h.client.updated, h.id)
+
+	return err
+}
+
+func (h *fakeScheduleHandle) Delete(_ context.Context) error {
+	delete(h.client.schedules, h.id)
+	return nil
+}
+
+type fakeScheduleListIterator struct {
+	entries []*client.ScheduleListEntry
+}
+
+func (i *fakeScheduleListIterator) HasNext() bool {
+	return len(i.entries) > 0
+}
+
+func (i *fakeScheduleListIterator) Next() (*client.ScheduleListEntry, error) {
+	entry := i.entries[0]
+	i.entries = i.entries[1:]
+
+	return entry, nil
+}
+
+func TestEnsurePowerSchedule(t *testing.T) {
+	c := &fakeScheduleClient{schedules: map[string]client.ScheduleOptions{
+		// Schedules of the schedule manager are not power schedules
+		"agent@agent:report-retry-stats": {ID: "agent@agent:report-retry-stats"},
+		"other@power-schedule:abc:off":   {ID: "other@power-schedule:abc:off"},
+	}}
+	s := NewPowerService("agent", nil, WithScheduleClient(c))
+
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(s.EnsurePowerSchedule)
+	env.RegisterActivity(s.DescribePowerSchedules)
+
+	param := EnsurePowerScheduleParam{
+		PowerSchedule: PowerSchedule{
+			Name:       "nightly-off",
+			SystemID:   "abc",
+			Action:     "off",
+			Cron:       []string{"0 22 * * *"},
+			TimeZone:   "Europe/London",
+			PowerParam: PowerParam{DriverType: "ipmi"},
+		},
+	}
+
+	val, err := env.ExecuteActivity(s.EnsurePowerSchedule, param)
+	require.NoError(t, err)
+
+	var id string
+	require.NoError(t, val.Get(&id))
+	assert.Equal(t, "agent@power-schedule:abc:nightly-off", id)
+
+	opts := c.schedules[id]
+	assert.Equal(t, []string{"0 22 * * *"}, opts.Spec.CronExpressions)
+	assert.Equal(t, "Europe/London", opts.Spec.TimeZoneName)
+	assert.Equal(t, "power off abc at 0 22 * * * (Europe/London)", opts.Note)
+
+	action := opts.Action.(*client.ScheduleWorkflowAction)
+	assert.Equal(t, "power-sequenced", action.Workflow)
+	assert.Equal(t, []any{PowerSequencedParam{
+		AgentSystemID: "agent",
+		Action:        "off",
+		Machines: []SequencedMachine{{SystemID: "abc", PowerParam: PowerParam{
+			DriverType:      "ipmi",
+			RequestMetadata: RequestMetadata{Requester: powerScheduleRequester},
+		}}},
+	}}, action.Args)
+
+	// Schedules with the same name are replaced
+	_, err = env.ExecuteActivity(s.EnsurePowerSchedule, param)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, c.updated)
+
+	val, err = env.ExecuteActivity(s.DescribePowerSchedules, ListPowerSchedulesParam{})
+	require.NoError(t, err)
+
+	var list ListPowerSchedulesResult
+	require.NoError(t, val.Get(&list))
+	assert.Equal(t, []ScheduledPowerAction{{
+		ID:          id,
+		Name:        "nightly-off",
+		SystemID:    "abc",
+		Description: "power off abc at 0 22 * * * (Europe/London)",
+	}}, list.Schedules)
+
+	param.TimeZone = "Nowhere/Special"
+
+	_, err = env.ExecuteActivity(s.EnsurePowerSchedule, param)
+	assert.ErrorContains(t, err, ErrInvalidPowerSchedule.Error())
+}
+
+func TestSchedulePowerActions(t *testing.T) {
+	testcases := map[string]struct {
+		schedules []PowerSchedule
+		ids       []string
+		err       bool
+	}{
+		"on and off": {
+			schedules: []PowerSchedule{
+				{Name: "off", SystemID: "abc", Action: "off", Cron: []string{"0 22 * * *"}},
+				{Name: "on", SystemID: "abc", Action: "on", Cron: []string{"0 7 * * MON-FRI"},
+					TimeZone: "America/New_York"},
+			},
+			ids: []string{"agent@power-schedule:abc:off", "agent@power-schedule:abc:on"},
+		},
+		"invalid": {
+			schedules: []PowerSchedule{
+				{Name: "off", SystemID: "abc", Action: "off", Cron: []string{"0 22 * * *"}},
+				{Name: "query", SystemID: "abc", Action: "query", Cron: []string{"0 7 * * *"}},
+			},
+			err: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			svc := NewPowerService("agent", nil)
+			env := newTestWorkflowEnvironment(svc)
+
+			var ensured []string
+
+			env.RegisterActivityWithOptions(svc.EnsurePowerSchedule,
+				activity.RegisterOptions{Name: "ensure-power-schedule"})
+			env.OnActivity("ensure-power-schedule", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, p EnsurePowerScheduleParam) (string, error) {
+					id := powerScheduleID("agent", p.SystemID, p.Name)
+					ensured = append(ensured, id)
+
+					return id, nil
+				})
+
+			env.ExecuteWorkflow(svc.schedulePowerActions, SchedulePowerActionsParam{
+				Schedules: tc.schedules,
+			})
+
+			require.True(t, env.IsWorkflowCompleted())
+
+			if tc.err {
+				assert.Error(t, env.GetWorkflowError())
+				assert.Empty(t, ensured)
+
+				return
+			}
+
+			require.NoError(t, env.GetWorkflowError())
+
+			var result SchedulePowerActionsResult
+			require.NoError(t, env.GetWorkflowResult(&result))
+			assert.Equal(t, tc.ids, result.IDs)
+			assert.Equal(t, tc.ids, ensured)
+		})
+	}
+}
+
+func TestCancelPowerSchedules(t *testing.T) {
+	svc := NewPowerService("agent", nil)
+
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestWorkflowEnvironment()
+
+	env.RegisterWorkflowWithOptions(svc.cancelPowerSchedules,
+		tworkflow.RegisterOptions{Name: "cancel-power-schedules"})
+	env.RegisterActivityWithOptions(svc.DescribePowerSchedules,
+		activity.RegisterOptions{Name: "describe-power-schedules"})
+	env.RegisterActivityWithOptions(svc.DeletePowerSchedule,
+		activity.RegisterOptions{Name: "delete-power-schedule"})
+
+	env.OnActivity("describe-power-schedules", mock.Anything,
+		ListPowerSchedulesParam{SystemID: "abc"}).
+		Return(&ListPowerSchedulesResult{Schedules: []ScheduledPowerAction{
+			{ID: "agent@power-schedule:abc:off", Name: "off", SystemID: "abc",
+				NextRuns: []time.Time{time.Now()}},
+			{ID: "agent@power-schedule:abc:on", Name: "on", SystemID: "abc"},
+		}}, nil)
+
+	var deleted []string
+
+	env.OnActivity("delete-power-schedule", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, p DeletePowerScheduleParam) error {
+			deleted = append(deleted, p.ID)
+			return nil
+		})
+
+	env.ExecuteWorkflow("cancel-power-schedules", CancelPowerSchedulesParam{
+		SystemID: "abc",
+		Names:    []string{"on"},
+	})
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	var result CancelPowerSchedulesResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, []string{"agent@power-schedule:abc:on"}, result.Cancelled)
+	assert.Equal(t, []string{"agent@power-schedule:abc:on"}, deleted)
+}
+
+func TestDeletePowerSchedule(t *testing.T) {
+	c := &fakeScheduleClient{schedules: map[string]client.ScheduleOptions{
+		"agent@power-schedule:abc:off":   {ID: "agent@power-schedule:abc:off"},
+		"agent@agent:report-retry-stats": {ID: "agent@agent:report-retry-stats"},
+		"other@power-schedule:abc:off":   {ID: "other@power-schedule:abc:off"},
+	}}
+	s := NewPowerService("agent", nil, WithScheduleClient(c))
+
+	suite := testsuite.WorkflowTestSuite{}
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(s.DeletePowerSchedule)
+
+	for _, id := range []string{"agent@agent:report-retry-stats", "other@power-schedule:abc:off"} {
+		_, err := env.ExecuteActivity(s.DeletePowerSchedule, DeletePowerScheduleParam{ID: id})
+		assert.ErrorContains(t, err, ErrInvalidPowerSchedule.Error())
+		assert.Contains(t, c.schedules, id)
+	}
+
+	_, err := env.ExecuteActivity(s.DeletePowerSchedule,
+		DeletePowerScheduleParam{ID: "agent@power-schedule:abc:off"})
+	require.NoError(t, err)
+	assert.NotContains(t, c.schedules, "agent@power-schedule:abc:off")
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DriverProxmox is the power driver of Proxmox VE virtual machines
+const DriverProxmox = "proxmox"
+
+const (
+	// defaultProxmoxPort is the port of the Proxmox VE API, unless
+	// power_address has one
+	defaultProxmoxPort = "8006"
+	// proxmoxResponseLimit is how much of a response is decoded. Resources
+	// of large clusters are verbose.
+	proxmoxResponseLimit = 8 << 20
+	// defaultProxmoxPollInterval is how often the VM is queried until the
+	// power action completes
+	defaultProxmoxPollInterval = time.Second
+)
+
+// proxmoxDriver controls VMs and containers with the Proxmox VE API.
+// Power parameters are the same as of the power driver: power_address,
+// power_user (including realm), power_pass or power_token_name and
+// power_token_secret, power_vm_name (VM ID or name) and power_verify_ssl.
+// The certificate of the host can be pinned with certificate_fingerprint.
+type proxmoxDriver struct {
+	poll time.Duration
+}
+
+func newProxmoxDriver() *proxmoxDriver {
+	return &proxmoxDriver{poll: defaultProxmoxPollInterval}
+}
+
+// proxmoxVM is a resource of the cluster of type "vm" (VMs and containers)
+type proxmoxVM struct {
+	VMID   json.Number `json:"vmid"`
+	Name   string      `json:"name"`
+	Node   string      `json:"node"`
+	Type   string      `json:"type"`
+	Status string      `json:"status"`
+}
+
+// path returns the API path of the VM
+func (vm proxmoxVM) path() string {
+	return "nodes/" + url.PathEscape(vm.Node) + "/" + url.PathEscape(vm.Type) + "/" +
+		url.PathEscape(vm.VMID.String())
+}
+
+// state returns the power state of the VM, as the power driver does
+func (vm proxmoxVM) state() string {
+	switch vm.Status {
+	case "running":
+		return "on"
+	case "stopped":
+		return "off"
+	}
+
+	return "unknown"
+}
+
+// Supports returns whether the host, credentials and the VM are given
+func (d *proxmoxDriver) Supports(opts map[string]interface{}) bool {
+	for _, opt := range []string{"power_address", "power_user", "power_vm_name"} {
+		if stringOpt(opts, opt) == "" {
+			return false
+		}
+	}
+
+	return stringOpt(opts, "power_pass") != "" ||
+		(stringOpt(opts, "power_token_name") != "" && stringOpt(opts, "power_token_secret") != "")
+}
+
+func (d *proxmoxDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.power(ctx, opts, "on")
+}
+
+func (d *proxmoxDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.power(ctx, opts, "off")
+}
+
+// Cycle stops the VM, if it is running, and starts it again, as the power
+// driver does
+func (d *proxmoxDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	s, vm, err := d.find(ctx, opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer s.Close()
+
+	if vm, err = d.set(ctx, s, vm, "off"); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	if vm, err = d.set(ctx, s, vm, "on"); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return vm.state(), PowerDetails{Status: vm.Status}, nil
+}
+
+// EmulatesCycle returns true, VMs are stopped and started again
+func (d *proxmoxDriver) EmulatesCycle(map[string]interface{}) bool {
+	return true
+}
+
+func (d *proxmoxDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	s, vm, err := d.find(ctx, opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer s.Close()
+
+	return vm.state(), PowerDetails{Status: vm.Status}, nil
+}
+
+func (d *proxmoxDriver) power(ctx context.Context, opts map[string]interface{},
+	want string) (string, PowerDetails, error) {
+	s, vm, err := d.find(ctx, opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer s.Close()
+
+	if vm, err = d.set(ctx, s, vm, want); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return vm.state(), PowerDetails{Status: vm.Status}, nil
+}
+
+// find logs in and returns the VM of power_vm_name
+func (d *proxmoxDriver) find(ctx context.Context, opts map[string]interface{}) (*proxmoxSession, proxmoxVM, error) {
+	s, err := dialProxmox(ctx, opts)
+	if err != nil {
+		return nil, proxmoxVM{}, err
+	}
+
+	var vms []proxmoxVM
+
+	if err := s.request(ctx, http.MethodGet, "cluster/resources?type=vm", &vms); err != nil {
+		//nolint:errcheck // should be safe to ignore an error from Close()
+		s.Close()
+
+		return nil, proxmoxVM{}, err
+	}
+
+	name := stringOpt(opts, "power_vm_name")
+
+	for _, vm := range vms {
+		if vm.VMID.String() == name || vm.Name == name {
+			return s, vm, nil
+		}
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	s.Close()
+
+	return nil, proxmoxVM{}, fmt.Errorf("%w: %q", ErrInstanceNotFound, name)
+}
+
+// set starts or stops the VM, unless it is already in the want power state,
+// and waits for the task to complete
+func (d *proxmoxDriver) set(ctx context.Context, s *proxmoxSession, vm proxmoxVM,
+	want string) (proxmoxVM, error) {
+	if vm.state() == want {
+		return vm, nil
+	}
+
+	action := "start"
+	if want == "off" {
+		action = "stop"
+	}
+
+	if err := s.request(ctx, http.MethodPost, vm.path()+"/status/"+action, nil); err != nil {
+		return vm, err
+	}
+
+	for {
+		var current proxmoxVM
+
+		if err := s.request(ctx, http.MethodGet, vm.path()+"/status/current", &current); err != nil {
+			return vm, err
+		}
+
+		vm.Status = current.Status
+
+		if vm.state() == want {
+			return vm, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return vm, ctx.Err()
+		case <-time.After(d.poll):
+		}
+	}
+}
+
+// proxmoxSession sends requests of the Proxmox VE API authenticated with
+// an API token, or a ticket of the user
+type proxmoxSession struct {
+	client *http.Client
+	base   *url.URL
+	header http.Header
+}
+
+// proxmoxURL returns the API of power_address, which may be given with or
+// without scheme and port, as by the power driver
+func proxmoxURL(address string) (*url.URL, error) {
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultProxmoxPort)
+	}
+
+	u.Path, u.RawQuery, u.Fragment = "/api2/json/", "", ""
+
+	return u, nil
+}
+
+func dialProxmox(ctx context.Context, opts map[string]interface{}) (*proxmoxSession, error) {
+	tlsConfig, err := verifiedTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := proxmoxURL(stringOpt(opts, "power_address"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &proxmoxSession{
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		base:   base,
+		header: make(http.Header),
+	}
+
+	user := stringOpt(opts, "power_user")
+
+	if tokenName := stringOpt(opts, "power_token_name"); tokenName != "" {
+		// The token name has to include the user, which the UI of Proxmox
+		// shows but doesn't make obvious
+		if !strings.Contains(tokenName, "!") {
+			tokenName = user + "!" + tokenName
+		}
+
+		s.header.Set("Authorization", "PVEAPIToken="+tokenName+"="+stringOpt(opts, "power_token_secret"))
+
+		return s, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"username": user, "password": stringOpt(opts, "power_pass")})
+	if err != nil {
+		return nil, err
+	}
+
+	var ticket struct {
+		Ticket string `json:"ticket"`
+		CSRF   string `json:"CSRFPreventionToken"`
+	}
+
+	if err := s.do(ctx, http.MethodPost, "access/ticket", body, &ticket); err != nil {
+		//nolint:errcheck // should be safe to ignore an error from Close()
+		s.Close()
+
+		return nil, err
+	}
+
+	s.header.Set("Cookie", "PVEAuthCookie="+ticket.Ticket)
+	s.header.Set("CSRFPreventionToken", ticket.CSRF)
+
+	return s, nil
+}
+
+func (s *proxmoxSession) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// request sends an authenticated request and decodes data of the response
+// into out (if any)
+func (s *proxmoxSession) request(ctx context.Context, method, endpoint string, out interface{}) error {
+	return s.do(ctx, method, endpoint, nil, out)
+}
+
+func (s *proxmoxSession) do(ctx context.Context, method, endpoint string, in []byte, out interface{}) error {
+	u, err := s.base.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		body = bytes.NewReader(in)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s %s: %s", responseError(resp.StatusCode), method, u.Path, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	var data struct {
+		Data json.RawMessage `json:"data"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, proxmoxResponseLimit)).Decode(&data); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+	}
+
+	if err := json.Unmarshal(data.Data, out); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProxmox is a Proxmox VE host with a single VM "vm1" (ID 100) on
+// node "pve1". Power actions take one more query to complete.
+type fakeProxmox struct {
+	status  string
+	pending string
+	actions []string
+	mutex   sync.Mutex
+}
+
+func (f *fakeProxmox) serve(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		if r.Method+" "+r.URL.Path == "POST /api2/json/access/ticket" {
+			var req struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+			}
+
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+			if req.Username != "root@pam" || req.Password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			w.Write([]byte(`{"data":{"ticket":"PVE:ticket","CSRFPreventionToken":"csrf"}}`))
+
+			return
+		}
+
+		ticket := r.Header.Get("Cookie") == "PVEAuthCookie=PVE:ticket" &&
+			(r.Method == http.MethodGet || r.Header.Get("CSRFPreventionToken") == "csrf")
+		token := r.Header.Get("Authorization") == "PVEAPIToken=root@pam!maas=s3cret"
+
+		if !ticket && !token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api2/json/cluster/resources":
+			assert.Equal(t, "vm", r.URL.Query().Get("type"))
+			w.Write([]byte(`{"data":[{"vmid":101,"name":"vm2","node":"pve1","type":"lxc","status":"running"},` +
+				`{"vmid":100,"name":"vm1","node":"pve1","type":"qemu","status":"` + f.status + `"}]}`))
+		case "GET /api2/json/nodes/pve1/qemu/100/status/current":
+			w.Write([]byte(`{"data":{"vmid":100,"status":"` + f.status + `"}}`))
+
+			if f.pending != "" {
+				f.status, f.pending = f.pending, ""
+			}
+		case "POST /api2/json/nodes/pve1/qemu/100/status/start":
+			f.actions = append(f.actions, "start")
+			f.pending = "running"
+			w.Write([]byte(`{"data":"UPID:pve1:start"}`))
+		case "POST /api2/json/nodes/pve1/qemu/100/status/stop":
+			f.actions = append(f.actions, "stop")
+			f.pending = "stopped"
+			w.Write([]byte(`{"data":"UPID:pve1:stop"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func proxmoxTestOpts(server *httptest.Server) map[string]interface{} {
+	return map[string]interface{}{
+		"power_address": strings.TrimPrefix(server.URL, "https://"),
+		"power_user":    "root@pam",
+		"power_pass":    "secret",
+		"power_vm_name": "vm1",
+	}
+}
+
+func TestProxmoxDriver(t *testing.T) {
+	testcases := map[string]struct {
+		action  string
+		initial string
+		opts    map[string]interface{}
+		state   string
+		actions []string
+		err     error
+	}{
+		"on": {
+			action: "on", initial: "stopped", state: "on", actions: []string{"start"},
+		},
+		"already on": {
+			action: "on", initial: "running", state: "on",
+		},
+		"off": {
+			action: "off", initial: "running", state: "off", actions: []string{"stop"},
+		},
+		"cycle": {
+			action: "cycle", initial: "running", state: "on", actions: []string{"stop", "start"},
+		},
+		"status": {
+			action: "status", initial: "running", state: "on",
+		},
+		"by VM ID": {
+			action: "status", initial: "stopped", state: "off",
+			opts: map[string]interface{}{"power_vm_name": "100"},
+		},
+		"token": {
+			action: "on", initial: "stopped", state: "on", actions: []string{"start"},
+			opts: map[string]interface{}{"power_pass": "", "power_token_name": "maas", "power_token_secret": "s3cret"},
+		},
+		"wrong password": {
+			action: "status", initial: "running",
+			opts: map[string]interface{}{"power_pass": "wrong"},
+			err:  ErrAuthFailed,
+		},
+		"not found": {
+			action: "status", initial: "running",
+			opts: map[string]interface{}{"power_vm_name": "vm3"},
+			err:  ErrInstanceNotFound,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := &fakeProxmox{status: tc.initial}
+			opts := proxmoxTestOpts(f.serve(t))
+
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+
+			d := newProxmoxDriver()
+			d.poll = time.Millisecond
+
+			state, details, err := runDriver(context.Background(), d, tc.action, opts)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, state)
+			assert.NotEmpty(t, details.Status)
+
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+
+			assert.Equal(t, tc.actions, f.actions)
+		})
+	}
+}
+
+func TestProxmoxDriverPinnedCertificate(t *testing.T) {
+	f := &fakeProxmox{status: "running"}
+	server := f.serve(t)
+	opts := proxmoxTestOpts(server)
+
+	sum := sha256.Sum256(server.Certificate().Raw)
+	opts[optCertFingerprint] = "sha256:" + hex.EncodeToString(sum[:])
+
+	state, _, err := newProxmoxDriver().Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "on", state)
+
+	opts[optCertFingerprint] = strings.Repeat("00", sha256.Size)
+
+	_, _, err = newProxmoxDriver().Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrFingerprintMismatch)
+}
+
+func TestProxmoxURL(t *testing.T) {
+	testcases := map[string]string{
+		"pve1":                      "https://pve1:8006/api2/json/",
+		"10.0.0.1:8443":             "https://10.0.0.1:8443/api2/json/",
+		"https://pve1.example.com/": "https://pve1.example.com:8006/api2/json/",
+		"http://[fd00::1]":          "http://[fd00::1]:8006/api2/json/",
+	}
+
+	for address, expected := range testcases {
+		u, err := proxmoxURL(address)
+		require.NoError(t, err)
+		assert.Equal(t, expected, u.String())
+	}
+}
+
+func TestProxmoxDriverSupports(t *testing.T) {
+	d := newProxmoxDriver()
+	opts := map[string]interface{}{"power_address": "pve1", "power_user": "root@pam", "power_vm_name": "vm1"}
+
+	assert.False(t, d.Supports(opts))
+
+	opts["power_token_name"], opts["power_token_secret"] = "maas", "s3cret"
+	assert.True(t, d.Supports(opts))
+
+	delete(opts, "power_token_secret")
+	opts["power_pass"] = "secret"
+	assert.True(t, d.Supports(opts))
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRaritan serves JSON-RPC API of outlets of a Raritan PDU
+type fakeRaritan struct {
+	// outlets are power states of outlets, indexed from 0
+	outlets map[int]int
+	calls   []string
+	mutex   sync.Mutex
+}
+
+func (f *fakeRaritan) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	index, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/model/pdu/0/outlet/"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Method string `json:"method"`
+		Params struct {
+			PState int `json:"pstate"`
+		} `json:"params"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls = append(f.calls, req.Method+" "+strconv.Itoa(index))
+
+	state, ok := f.outlets[index]
+	if !ok {
+		//nolint:errcheck // the client fails anyway
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"Unknown resource"}}`))
+		return
+	}
+
+	switch req.Method {
+	case "getState":
+		//nolint:errcheck // the client fails anyway
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"result":  map[string]interface{}{"_ret_": map[string]int{"powerState": state}},
+		})
+	case "setPowerState":
+		f.outlets[index] = req.Params.PState
+		//nolint:errcheck // the client fails anyway
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"_ret_":0}}`))
+	}
+}
+
+func TestRaritanJSONRPC(t *testing.T) {
+	testcases := map[string]struct {
+		outlets map[int]int
+		opts    map[string]interface{}
+		action  string
+		state   string
+		result  map[int]int
+		calls   []string
+		err     error
+	}{
+		"status": {
+			outlets: map[int]int{0: 0},
+			opts:    map[string]interface{}{"node_outlet": "1"},
+			action:  "status",
+			state:   "off",
+			result:  map[int]int{0: 0},
+			calls:   []string{"getState 0"},
+		},
+		"power on outlet group": {
+			outlets: map[int]int{0: 0, 2: 0},
+			opts:    map[string]interface{}{"node_outlet": "1,3"},
+			action:  "on",
+			state:   "on",
+			result:  map[int]int{0: 1, 2: 1},
+			calls: []string{"getState 0", "getState 2", "setPowerState 0", "setPowerState 2",
+				"getState 0", "getState 2"},
+		},
+		"unknown outlet": {
+			outlets: map[int]int{0: 0},
+			opts:    map[string]interface{}{"node_outlet": "8"},
+			action:  "status",
+			result:  map[int]int{0: 0},
+			calls:   []string{"getState 7"},
+			err:     ErrUnexpectedResponse,
+		},
+		"wrong password": {
+			outlets: map[int]int{0: 0},
+			opts:    map[string]interface{}{"node_outlet": "1", "power_pass": "wrong"},
+			action:  "status",
+			result:  map[int]int{0: 0},
+			err:     ErrUnexpectedResponse,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pdu := &fakeRaritan{outlets: tc.outlets}
+
+			srv := httptest.NewTLSServer(pdu)
+			defer srv.Close()
+
+			opts := map[string]interface{}{
+				"power_address":  srv.URL,
+				"power_user":     "admin",
+				"power_pass":     "secret",
+				"power_on_delay": "0",
+				"pdu_protocol":   "json-rpc",
+			}
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+
+			state, _, err := runDriver(context.Background(), pduDriver{dial: dialRaritan}, tc.action, opts)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.state, state)
+
+			pdu.mutex.Lock()
+			defer pdu.mutex.Unlock()
+
+			assert.Equal(t, tc.result, pdu.outlets)
+			assert.Equal(t, tc.calls, pdu.calls)
+		})
+	}
+}
+
+func TestDialRaritan(t *testing.T) {
+	_, err := dialRaritan(context.Background(), map[string]interface{}{"pdu_protocol": "telnet"})
+	assert.ErrorIs(t, err, ErrUnsupportedPDUType)
+
+	_, err = dialRaritan(context.Background(), map[string]interface{}{"pdu_type": "PX5"})
+	assert.ErrorIs(t, err, ErrUnsupportedPDUType)
+
+	c, err := dialRaritan(context.Background(), map[string]interface{}{"power_address": "127.0.0.1"})
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	state, control, err := c.(*snmpPDU).outlets.oids("4")
+	require.NoError(t, err)
+	assert.Equal(t, "1.3.6.1.4.1.13742.6.4.1.2.1.3.1.4", state)
+	assert.Equal(t, "1.3.6.1.4.1.13742.6.4.1.2.1.2.1.4", control)
+}
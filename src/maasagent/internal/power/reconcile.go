@@ -0,0 +1,230 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+	wf "maas.io/core/src/maasagent/internal/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	// powerTransitionTimeout is how long a machine is watched after the
+	// Agent restart before its power state is reported as a discrepancy.
+	powerTransitionTimeout = 5 * time.Minute
+	// powerTransitionInterval is the interval between power queries
+	// while watching a machine in transition.
+	powerTransitionInterval = 15 * time.Second
+	// defaultPowerSweepInterval is how often power states of transitioning
+	// machines are reconciled while the Agent is running.
+	defaultPowerSweepInterval = 10 * time.Minute
+	// powerSweepJitter spreads sweeps of Agents over time
+	powerSweepJitter = time.Minute
+	// powerSweepTimeout limits the duration of a single sweep
+	powerSweepTimeout = 5 * time.Minute
+)
+
+// TransitioningMachine is a machine the Region believes to be in the middle
+// of a power transition (e.g. power-on was requested, but not confirmed).
+type TransitioningMachine struct {
+	SystemID      string `json:"system_id"`
+	ExpectedState string `json:"expected_state"`
+	PowerParam
+}
+
+// ReconcilePowerStatesParam is the parameter of reconcile-power-states workflow
+type ReconcilePowerStatesParam struct {
+	SystemID string `json:"system_id"`
+}
+
+// PowerStateReport is the actual power state of a machine compared to
+// the state expected by the Region.
+type PowerStateReport struct {
+	SystemID      string `json:"system_id"`
+	ExpectedState string `json:"expected_state"`
+	ActualState   string `json:"actual_state,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// ErrorCode is the code of Error, e.g. "POWER_AUTH_FAILED"
+	ErrorCode string `json:"error_code,omitempty"`
+	// Watched is true when the machine is still watched for the transition
+	// to complete, so the final state will be reported separately.
+	Watched bool `json:"watched"`
+}
+
+type getTransitioningMachinesResult struct {
+	Machines []TransitioningMachine `json:"machines"`
+}
+
+type reportPowerStatesParam struct {
+	SystemID string             `json:"system_id"`
+	Reports  []PowerStateReport `json:"reports"`
+}
+
+// WatchPowerTransitionParam is the parameter (and carried over state)
+// of watch-power-transition workflow
+type WatchPowerTransitionParam struct {
+	Deadline      time.Time            `json:"deadline"`
+	AgentSystemID string               `json:"agent_system_id"`
+	Machine       TransitioningMachine `json:"machine"`
+}
+
+// WithPowerSweepInterval sets how often power states of machines the Region
+// believes are transitioning are reconciled. Zero disables periodic sweeps,
+// reconciliation still happens when the Agent starts. (default: 10 minutes)
+func WithPowerSweepInterval(d time.Duration) PowerServiceOption {
+	return func(s *PowerService) {
+		s.sweepInterval = max(d, 0)
+	}
+}
+
+func regionContext(ctx tworkflow.Context) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:              "region",
+		ScheduleToCloseTimeout: 60 * time.Second,
+	})
+}
+
+func powerQueryContext(ctx tworkflow.Context, systemID string) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:           fmt.Sprintf("%s@agent:power", systemID),
+		StartToCloseTimeout: 60 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+}
+
+// reconcilePowerStates is executed when the Agent starts and periodically by
+// the power sweep schedule. It queries actual power states of machines the
+// Region believes are transitioning, so that transitions interrupted by the
+// Agent downtime (or lost on the way) are not left hanging.
+// Machines that haven't reached the expected state are watched for a while
+// by a separate watch-power-transition workflow.
+func (s *PowerService) reconcilePowerStates(ctx tworkflow.Context, param ReconcilePowerStatesParam) error {
+	log := tworkflow.GetLogger(ctx)
+
+	var machines getTransitioningMachinesResult
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx),
+		"get-transitioning-machines", param).Get(ctx, &machines); err != nil {
+		return err
+	}
+
+	if len(machines.Machines) == 0 {
+		return nil
+	}
+
+	queryCtx := powerQueryContext(ctx, param.SystemID)
+
+	futures := make([]tworkflow.Future, len(machines.Machines))
+	for i, m := range machines.Machines {
+		futures[i] = tworkflow.ExecuteActivity(queryCtx, "power-query",
+			PowerQueryParam{PowerParam: m.PowerParam})
+	}
+
+	reports := make([]PowerStateReport, len(machines.Machines))
+
+	for i, m := range machines.Machines {
+		report := PowerStateReport{SystemID: m.SystemID, ExpectedState: m.ExpectedState}
+
+		var res PowerQueryResult
+
+		if err := futures[i].Get(ctx, &res); err != nil {
+			report.Error, report.ErrorCode = err.Error(), errcode.Of(err)
+		} else {
+			report.ActualState = res.State
+		}
+
+		if report.ActualState != m.ExpectedState {
+			report.Watched = s.watch(ctx, param.SystemID, m)
+
+			log.Warn("Power state discrepancy", tag.Builder().
+				KV("system_id", m.SystemID).
+				KV("expected", m.ExpectedState).
+				KV("actual", report.ActualState).KeyVals...)
+		}
+
+		reports[i] = report
+	}
+
+	return tworkflow.ExecuteActivity(regionContext(ctx), "report-power-states",
+		reportPowerStatesParam{SystemID: param.SystemID, Reports: reports}).Get(ctx, nil)
+}
+
+// watch starts watch-power-transition workflow, which outlives reconciliation.
+// It returns false if the workflow could not be started (e.g. the machine is
+// already being watched).
+func (s *PowerService) watch(ctx tworkflow.Context, systemID string, m TransitioningMachine) bool {
+	childCtx := tworkflow.WithChildOptions(ctx, tworkflow.ChildWorkflowOptions{
+		WorkflowID:            fmt.Sprintf("watch-power-transition:%s", m.SystemID),
+		ParentClosePolicy:     enums.PARENT_CLOSE_POLICY_ABANDON,
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	})
+
+	child := tworkflow.ExecuteChildWorkflow(childCtx, "watch-power-transition",
+		WatchPowerTransitionParam{
+			AgentSystemID: systemID,
+			Machine:       m,
+			Deadline:      tworkflow.Now(ctx).Add(powerTransitionTimeout),
+		})
+
+	if err := child.GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		tworkflow.GetLogger(ctx).Warn("Failed to watch power transition",
+			tag.Builder().KV("system_id", m.SystemID).Error(err).KeyVals...)
+
+		return false
+	}
+
+	return true
+}
+
+// watchPowerTransition polls power state of a machine until it reaches
+// the expected state or the deadline passes, then reports the final state.
+func (s *PowerService) watchPowerTransition(ctx tworkflow.Context, param WatchPowerTransitionParam) error {
+	opts := wf.MonitorOptions{Interval: powerTransitionInterval}
+
+	return wf.Monitor(ctx, "watch-power-transition", param, opts,
+		func(ctx tworkflow.Context, p *WatchPowerTransitionParam) (bool, error) {
+			m := p.Machine
+			report := PowerStateReport{SystemID: m.SystemID, ExpectedState: m.ExpectedState}
+
+			var res PowerQueryResult
+
+			err := tworkflow.ExecuteActivity(powerQueryContext(ctx, p.AgentSystemID), "power-query",
+				PowerQueryParam{PowerParam: m.PowerParam}).Get(ctx, &res)
+			if err != nil {
+				report.Error, report.ErrorCode = err.Error(), errcode.Of(err)
+			} else {
+				report.ActualState = res.State
+			}
+
+			if report.ActualState != m.ExpectedState && tworkflow.Now(ctx).Before(p.Deadline) {
+				return false, nil
+			}
+
+			return true, tworkflow.ExecuteActivity(regionContext(ctx), "report-power-states",
+				reportPowerStatesParam{
+					SystemID: p.AgentSystemID,
+					Reports:  []PowerStateReport{report},
+				}).Get(ctx, nil)
+		})
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+// Region activities are implemented in Python, hence dummy activities
+// are required to match function signatures.
+func getTransitioningMachinesActivity(_ context.Context,
+	_ ReconcilePowerStatesParam) (getTransitioningMachinesResult, error) {
+	return getTransitioningMachinesResult{}, nil
+}
+
+func reportPowerStatesActivity(_ context.Context, _ reportPowerStatesParam) error {
+	return nil
+}
+
+func newTestWorkflowEnvironment(svc *PowerService) *testsuite.TestWorkflowEnvironment {
+	wfTestSuite := testsuite.WorkflowTestSuite{}
+	wfTestSuite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := wfTestSuite.NewTestWorkflowEnvironment()
+
+	env.RegisterActivityWithOptions(getTransitioningMachinesActivity,
+		activity.RegisterOptions{Name: "get-transitioning-machines"})
+	env.RegisterActivityWithOptions(reportPowerStatesActivity,
+		activity.RegisterOptions{Name: "report-power-states"})
+	env.RegisterActivityWithOptions(svc.PowerQuery,
+		activity.RegisterOptions{Name: "power-query"})
+	env.RegisterWorkflowWithOptions(svc.watchPowerTransition,
+		tworkflow.RegisterOptions{Name: "watch-power-transition"})
+
+	return env
+}
+
+func TestReconcilePowerStates(t *testing.T) {
+	svc := NewPowerService("agent", nil)
+	env := newTestWorkflowEnvironment(svc)
+
+	machines := []TransitioningMachine{
+		{SystemID: "on", ExpectedState: "on", PowerParam: PowerParam{DriverType: "ipmi"}},
+		{SystemID: "off", ExpectedState: "on", PowerParam: PowerParam{DriverType: "redfish"}},
+	}
+
+	env.OnActivity("get-transitioning-machines", mock.Anything, mock.Anything).
+		Return(getTransitioningMachinesResult{Machines: machines}, nil)
+
+	env.OnActivity("power-query", mock.Anything, PowerQueryParam{PowerParam: machines[0].PowerParam}).
+		Return(&PowerQueryResult{State: "on"}, nil)
+	env.OnActivity("power-query", mock.Anything, PowerQueryParam{PowerParam: machines[1].PowerParam}).
+		Return(&PowerQueryResult{State: "off"}, nil)
+
+	var reports [][]PowerStateReport
+
+	env.OnActivity("report-power-states", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, p reportPowerStatesParam) error {
+			reports = append(reports, p.Reports)
+			return nil
+		})
+
+	env.ExecuteWorkflow(svc.reconcilePowerStates, ReconcilePowerStatesParam{SystemID: "agent"})
+
+	assert.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+
+	// The watched machine is reported separately by watch-power-transition,
+	// once it reaches the expected state or the deadline passes.
+	require.NotEmpty(t, reports)
+	assert.Equal(t, []PowerStateReport{
+		{SystemID: "on", ExpectedState: "on", ActualState: "on"},
+		{SystemID: "off", ExpectedState: "on", ActualState: "off", Watched: true},
+	}, reports[0])
+}
+
+func TestWatchPowerTransition(t *testing.T) {
+	svc := NewPowerService("agent", nil)
+	env := newTestWorkflowEnvironment(svc)
+
+	state := "off"
+
+	env.OnActivity("power-query", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, _ PowerQueryParam) (*PowerQueryResult, error) {
+			res := &PowerQueryResult{State: state}
+			state = "on"
+
+			return res, nil
+		})
+
+	var reports []PowerStateReport
+
+	env.OnActivity("report-power-states", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, p reportPowerStatesParam) error {
+			reports = append(reports, p.Reports...)
+			return nil
+		})
+
+	env.ExecuteWorkflow(svc.watchPowerTransition, WatchPowerTransitionParam{
+		AgentSystemID: "agent",
+		Machine:       TransitioningMachine{SystemID: "abc", ExpectedState: "on"},
+		Deadline:      env.Now().Add(time.Hour),
+	})
+
+	assert.NoError(t, env.GetWorkflowError())
+	assert.Equal(t, []PowerStateReport{
+		{SystemID: "abc", ExpectedState: "on", ActualState: "on"},
+	}, reports)
+}
@@ -0,0 +1,727 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// DriverRedfish is the power driver of Redfish BMCs. Power actions are
+// performed by the Agent directly, without the MAAS power CLI.
+const DriverRedfish = "redfish"
+
+// Boot modes of PXE-less provisioning, which don't rely on DHCP and TFTP
+// being available on the provisioning network.
+const (
+	// BootModeHTTP sets UEFI HTTP boot URI of the next boot
+	BootModeHTTP = "http"
+	// BootModeVirtualMedia inserts an image as virtual CD and boots from it
+	BootModeVirtualMedia = "virtual-media"
+)
+
+const (
+	redfishRoot            = "/redfish/v1"
+	redfishTargetHTTP      = "UefiHttp"
+	redfishTargetCD        = "Cd"
+	redfishTargetPXE       = "Pxe"
+	redfishTargetHdd       = "Hdd"
+	redfishOverrideEnabled = "Once"
+	redfishResetOn         = "On"
+	redfishResetOff        = "ForceOff"
+	redfishResetSoftOff    = "GracefulShutdown"
+	redfishResetRestart    = "ForceRestart"
+	// powerActionWait is how long a power action performed natively can
+	// take before the state is reported as it is
+	powerActionWait = 2 * time.Minute
+)
+
+// powerPollInterval is how often power state is checked while waiting
+// for a power action performed natively to complete
+var powerPollInterval = time.Second
+
+var (
+	// ErrUnsupportedBootMode is returned when boot mode is unknown or not
+	// supported by the BMC
+	ErrUnsupportedBootMode = errcode.New(errcode.PowerUnsupported, "unsupported boot mode")
+	// ErrNoVirtualMedia is returned when BMC has no virtual media device
+	// capable of emulating CD/DVD drive
+	ErrNoVirtualMedia = errcode.New(errcode.PowerUnsupported, "no virtual media device")
+	// ErrUnsupportedPowerAction is returned when power action is unknown or
+	// its reset type is not supported by the BMC
+	ErrUnsupportedPowerAction = errcode.New(errcode.PowerUnsupported, "unsupported power action")
+)
+
+// redfishOverrideModes maps firmware boot modes to BootSourceOverrideMode
+var redfishOverrideModes = map[string]string{
+	FirmwareUEFI:   "UEFI",
+	FirmwareLegacy: "Legacy",
+}
+
+// redfishBootTargets maps boot devices of set-boot-device to boot targets
+var redfishBootTargets = map[string]string{
+	BootDevicePXE:  redfishTargetPXE,
+	BootDeviceDisk: redfishTargetHdd,
+	BootDeviceCD:   redfishTargetCD,
+}
+
+// redfishDriver performs power actions of Redfish BMCs
+type redfishDriver struct{}
+
+func (redfishDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return redfishPower(ctx, opts, "on")
+}
+
+func (redfishDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return redfishPower(ctx, opts, "off")
+}
+
+func (redfishDriver) SoftOff(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return redfishPower(ctx, opts, "soft-off")
+}
+
+func (redfishDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return redfishPower(ctx, opts, "cycle")
+}
+
+func (redfishDriver) Reset(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return redfishPower(ctx, opts, "reset")
+}
+
+func (redfishDriver) SetBootDevice(ctx context.Context, opts map[string]interface{}, device string) error {
+	c, err := dialRedfish(opts)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // only idle connections are closed
+	defer c.Close()
+
+	return c.setBootDevice(ctx, stringOpt(opts, "node_id"), device, stringOpt(opts, bootModeOpt))
+}
+
+func (redfishDriver) BootOrder(ctx context.Context, opts map[string]interface{}) ([]BootOption, error) {
+	c, err := dialRedfish(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // only idle connections are closed
+	defer c.Close()
+
+	return c.bootOrder(ctx, stringOpt(opts, "node_id"))
+}
+
+func (redfishDriver) ApplyBootOrder(ctx context.Context, opts map[string]interface{}, order []string) error {
+	c, err := dialRedfish(opts)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // only idle connections are closed
+	defer c.Close()
+
+	return c.setBootOrder(ctx, stringOpt(opts, "node_id"), order)
+}
+
+func (redfishDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return redfishPower(ctx, opts, "status")
+}
+
+func redfishPower(ctx context.Context, opts map[string]interface{}, action string) (string, PowerDetails, error) {
+	c, err := dialRedfish(opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // only idle connections are closed
+	defer c.Close()
+
+	return c.power(ctx, stringOpt(opts, "node_id"), action)
+}
+
+// redfishConn is an HTTP client of the Redfish API of a BMC
+type redfishConn struct {
+	client *http.Client
+	base   *url.URL
+	user   string
+	pass   string
+}
+
+func dialRedfish(opts map[string]interface{}) (*redfishConn, error) {
+	address := stringOpt(opts, "power_address")
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+
+	base, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		// BMCs almost always use self-signed certificates, so chain
+		// verification is not possible. The certificate is verified against
+		// the pinned fingerprint instead, if there is one.
+		//nolint:gosec // see above
+		InsecureSkipVerify: true,
+	}
+
+	if pin := stringOpt(opts, optCertFingerprint); pin != "" {
+		tlsConfig.VerifyPeerCertificate, err = verifyFingerprint(pin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &redfishConn{
+		base: base,
+		user: stringOpt(opts, "power_user"),
+		pass: stringOpt(opts, "power_pass"),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// do sends request with JSON encoded body (if any) and decodes response into
+// out (if any). ETag of the response is returned, as some BMCs require it
+// in If-Match header of PATCH requests.
+func (c *redfishConn) do(ctx context.Context, method, p, etag string,
+	body, out interface{}) (string, error) {
+	var r io.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+
+		r = bytes.NewReader(b)
+	}
+
+	u := *c.base
+	u.Path = p
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth(c.user, c.pass)
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%w: %s %s: %s", responseError(resp.StatusCode), method, p, resp.Status)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		// Drain the body, so the connection can be reused
+		_, err = io.Copy(io.Discard, resp.Body)
+		return resp.Header.Get("ETag"), err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+type redfishLink struct {
+	ID string `json:"@odata.id"`
+}
+
+type redfishCollection struct {
+	Members []redfishLink `json:"Members"`
+}
+
+type redfishSystem struct {
+	Actions struct {
+		Reset struct {
+			Target     string   `json:"target"`
+			ResetTypes []string `json:"ResetType@Redfish.AllowableValues"`
+		} `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+	Boot struct {
+		Mode    string   `json:"BootSourceOverrideMode"`
+		Modes   []string `json:"BootSourceOverrideMode@Redfish.AllowableValues"`
+		Targets []string `json:"BootSourceOverrideTarget@Redfish.AllowableValues"`
+		// Order are BootOptionReference of BootOptions
+		Order   []string    `json:"BootOrder"`
+		Options redfishLink `json:"BootOptions"`
+	} `json:"Boot"`
+	Status struct {
+		Health string `json:"Health"`
+	} `json:"Status"`
+	Links struct {
+		ManagedBy []redfishLink `json:"ManagedBy"`
+	} `json:"Links"`
+	PowerState string `json:"PowerState"`
+}
+
+// state returns power state in the format of the MAAS power CLI
+// ("on", "off" or "unknown" while the system is powering on or off)
+func (s *redfishSystem) state() string {
+	switch s.PowerState {
+	case "On":
+		return "on"
+	case "Off":
+		return "off"
+	default:
+		return "unknown"
+	}
+}
+
+func (s *redfishSystem) details() PowerDetails {
+	return PowerDetails{Health: s.Status.Health, BootMode: s.Boot.Mode}
+}
+
+type redfishBootOption struct {
+	Reference      string `json:"BootOptionReference"`
+	DisplayName    string `json:"DisplayName"`
+	UefiDevicePath string `json:"UefiDevicePath"`
+}
+
+// device returns the boot device of the option guessed from its name and
+// UEFI device path, as Redfish doesn't classify boot options
+func (o *redfishBootOption) device() string {
+	s := strings.ToLower(o.DisplayName + " " + o.UefiDevicePath)
+
+	for _, d := range []struct {
+		device   string
+		keywords []string
+	}{
+		{BootDevicePXE, []string{"pxe", "ipv4", "ipv6", "network", "http", "mac("}},
+		{BootDeviceCD, []string{"cdrom", "cd-rom", "cd/dvd", "dvd", "optical"}},
+		{BootDeviceDisk, []string{"hdd", "hd(", "hard", "disk", "nvme", "sata", "scsi", "ssd", "raid"}},
+	} {
+		for _, k := range d.keywords {
+			if strings.Contains(s, k) {
+				return d.device
+			}
+		}
+	}
+
+	return ""
+}
+
+type redfishVirtualMedia struct {
+	Actions struct {
+		InsertMedia struct {
+			Target string `json:"target"`
+		} `json:"#VirtualMedia.InsertMedia"`
+		EjectMedia struct {
+			Target string `json:"target"`
+		} `json:"#VirtualMedia.EjectMedia"`
+	} `json:"Actions"`
+	ID         string   `json:"@odata.id"`
+	MediaTypes []string `json:"MediaTypes"`
+	Inserted   bool     `json:"Inserted"`
+}
+
+// systemPath returns path of the computer system with the given ID, or of
+// the only system if ID is empty, as BMCs usually manage one system.
+func (c *redfishConn) systemPath(ctx context.Context, id string) (string, error) {
+	if id != "" {
+		return path.Join(redfishRoot, "Systems", id), nil
+	}
+
+	var systems redfishCollection
+	if _, err := c.do(ctx, http.MethodGet, path.Join(redfishRoot, "Systems"), "", nil, &systems); err != nil {
+		return "", err
+	}
+
+	if len(systems.Members) == 0 {
+		return "", fmt.Errorf("%w: no systems", ErrUnexpectedResponse)
+	}
+
+	return systems.Members[0].ID, nil
+}
+
+// setBootOverride makes the system boot once from target, in the firmware
+// bootMode if it is set. uri is only used for UEFI HTTP boot.
+func (c *redfishConn) setBootOverride(ctx context.Context, system, target, uri, bootMode string) error {
+	var s redfishSystem
+
+	etag, err := c.do(ctx, http.MethodGet, system, "", nil, &s)
+	if err != nil {
+		return err
+	}
+
+	// Not all BMCs advertise allowed values, in which case PATCH fails
+	// if the target is not supported.
+	if len(s.Boot.Targets) > 0 && !slices.Contains(s.Boot.Targets, target) {
+		return fmt.Errorf("%w: boot target %q is not allowed", ErrUnsupportedBootMode, target)
+	}
+
+	boot := map[string]string{
+		"BootSourceOverrideEnabled": redfishOverrideEnabled,
+		"BootSourceOverrideTarget":  target,
+	}
+
+	if uri != "" {
+		boot["HttpBootUri"] = uri
+	}
+
+	if bootMode != "" {
+		mode, ok := redfishOverrideModes[bootMode]
+		if !ok || (len(s.Boot.Modes) > 0 && !slices.Contains(s.Boot.Modes, mode)) {
+			return fmt.Errorf("%w: firmware boot mode %q is not allowed", ErrUnsupportedBootMode, bootMode)
+		}
+
+		boot["BootSourceOverrideMode"] = mode
+	}
+
+	_, err = c.do(ctx, http.MethodPatch, system, etag, map[string]interface{}{"Boot": boot}, nil)
+
+	return err
+}
+
+// virtualMedia returns the first virtual media device of the manager of the
+// system, which can emulate CD/DVD drive.
+func (c *redfishConn) virtualMedia(ctx context.Context, system string) (*redfishVirtualMedia, error) {
+	var s redfishSystem
+	if _, err := c.do(ctx, http.MethodGet, system, "", nil, &s); err != nil {
+		return nil, err
+	}
+
+	if len(s.Links.ManagedBy) == 0 {
+		return nil, fmt.Errorf("%w: system has no manager", ErrNoVirtualMedia)
+	}
+
+	var manager struct {
+		VirtualMedia redfishLink `json:"VirtualMedia"`
+	}
+
+	if _, err := c.do(ctx, http.MethodGet, s.Links.ManagedBy[0].ID, "", nil, &manager); err != nil {
+		return nil, err
+	}
+
+	if manager.VirtualMedia.ID == "" {
+		return nil, ErrNoVirtualMedia
+	}
+
+	var media redfishCollection
+	if _, err := c.do(ctx, http.MethodGet, manager.VirtualMedia.ID, "", nil, &media); err != nil {
+		return nil, err
+	}
+
+	for _, m := range media.Members {
+		var vm redfishVirtualMedia
+		if _, err := c.do(ctx, http.MethodGet, m.ID, "", nil, &vm); err != nil {
+			return nil, err
+		}
+
+		if slices.Contains(vm.MediaTypes, "CD") || slices.Contains(vm.MediaTypes, "DVD") {
+			if vm.ID == "" {
+				vm.ID = m.ID
+			}
+
+			return &vm, nil
+		}
+	}
+
+	return nil, ErrNoVirtualMedia
+}
+
+// insertMedia inserts image into the virtual media device, ejecting the
+// previous one if there is any.
+func (c *redfishConn) insertMedia(ctx context.Context, vm *redfishVirtualMedia, image string) error {
+	if vm.Inserted {
+		if err := c.ejectMedia(ctx, vm); err != nil {
+			return err
+		}
+	}
+
+	target := vm.Actions.InsertMedia.Target
+	if target == "" {
+		target = path.Join(vm.ID, "Actions", "VirtualMedia.InsertMedia")
+	}
+
+	_, err := c.do(ctx, http.MethodPost, target, "", map[string]interface{}{
+		"Image":          image,
+		"Inserted":       true,
+		"WriteProtected": true,
+	}, nil)
+
+	return err
+}
+
+func (c *redfishConn) ejectMedia(ctx context.Context, vm *redfishVirtualMedia) error {
+	target := vm.Actions.EjectMedia.Target
+	if target == "" {
+		target = path.Join(vm.ID, "Actions", "VirtualMedia.EjectMedia")
+	}
+
+	_, err := c.do(ctx, http.MethodPost, target, "", map[string]interface{}{}, nil)
+
+	return err
+}
+
+// bootFromURL configures the system to boot once from url using mode.
+// UEFI HTTP boot is not available in legacy firmware boot mode.
+func (c *redfishConn) bootFromURL(ctx context.Context, nodeID, mode, u, bootMode string) error {
+	system, err := c.systemPath(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case BootModeHTTP:
+		if bootMode == FirmwareLegacy {
+			return fmt.Errorf("%w: %q in legacy firmware boot mode", ErrUnsupportedBootMode, mode)
+		}
+
+		return c.setBootOverride(ctx, system, redfishTargetHTTP, u, bootMode)
+	case BootModeVirtualMedia:
+		vm, err := c.virtualMedia(ctx, system)
+		if err != nil {
+			return err
+		}
+
+		if err := c.insertMedia(ctx, vm, u); err != nil {
+			return err
+		}
+
+		return c.setBootOverride(ctx, system, redfishTargetCD, "", bootMode)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedBootMode, mode)
+	}
+}
+
+// setBootDevice configures the system to boot once from device, in the
+// firmware bootMode if it is set
+func (c *redfishConn) setBootDevice(ctx context.Context, nodeID, device, bootMode string) error {
+	target, ok := redfishBootTargets[device]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedBootDevice, device)
+	}
+
+	system, err := c.systemPath(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	return c.setBootOverride(ctx, system, target, "", bootMode)
+}
+
+// bootOrder returns the persistent boot order of the system. Options which
+// are not listed in BootOptions (if the BMC has them) are left unnamed.
+func (c *redfishConn) bootOrder(ctx context.Context, nodeID string) ([]BootOption, error) {
+	system, err := c.systemPath(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var s redfishSystem
+	if _, err = c.do(ctx, http.MethodGet, system, "", nil, &s); err != nil {
+		return nil, err
+	}
+
+	if s.Boot.Order == nil {
+		return nil, fmt.Errorf("%w: BMC has no BootOrder", ErrUnsupportedBootOrder)
+	}
+
+	options := make(map[string]redfishBootOption)
+
+	if s.Boot.Options.ID != "" {
+		var members redfishCollection
+		if _, err = c.do(ctx, http.MethodGet, s.Boot.Options.ID, "", nil, &members); err != nil {
+			return nil, err
+		}
+
+		for _, m := range members.Members {
+			var o redfishBootOption
+			if _, err = c.do(ctx, http.MethodGet, m.ID, "", nil, &o); err != nil {
+				return nil, err
+			}
+
+			options[o.Reference] = o
+		}
+	}
+
+	order := make([]BootOption, len(s.Boot.Order))
+
+	for i, ref := range s.Boot.Order {
+		o := options[ref]
+		order[i] = BootOption{ID: ref, Name: o.DisplayName, Device: o.device()}
+	}
+
+	return order, nil
+}
+
+// setBootOrder sets the persistent boot order of the system to references
+// of boot options
+func (c *redfishConn) setBootOrder(ctx context.Context, nodeID string, order []string) error {
+	system, err := c.systemPath(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	var s redfishSystem
+
+	etag, err := c.do(ctx, http.MethodGet, system, "", nil, &s)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.do(ctx, http.MethodPatch, system, etag,
+		map[string]interface{}{"Boot": map[string]interface{}{"BootOrder": order}}, nil)
+
+	return err
+}
+
+// ejectVirtualMedia ejects media inserted by bootFromURL, if there is any
+func (c *redfishConn) ejectVirtualMedia(ctx context.Context, nodeID string) error {
+	system, err := c.systemPath(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	vm, err := c.virtualMedia(ctx, system)
+	if err != nil {
+		return err
+	}
+
+	if !vm.Inserted {
+		return nil
+	}
+
+	return c.ejectMedia(ctx, vm)
+}
+
+// power performs action ("on", "off", "cycle" or "status") and returns
+// the resulting power state of the system.
+func (c *redfishConn) power(ctx context.Context, nodeID, action string) (string, PowerDetails, error) {
+	system, err := c.systemPath(ctx, nodeID)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	var s redfishSystem
+	if _, err = c.do(ctx, http.MethodGet, system, "", nil, &s); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	var resetType, want string
+
+	switch action {
+	case "status":
+		return s.state(), s.details(), nil
+	case "on":
+		resetType, want = redfishResetOn, "on"
+	case "off":
+		resetType, want = redfishResetOff, "off"
+	case "soft-off":
+		resetType, want = redfishResetSoftOff, "off"
+	case "cycle", "reset":
+		resetType, want = redfishResetRestart, "on"
+		if s.state() == "off" {
+			resetType = redfishResetOn
+		}
+	default:
+		return "", PowerDetails{}, fmt.Errorf("%w: %q", ErrUnsupportedPowerAction, action)
+	}
+
+	if action != "cycle" && action != "reset" && s.state() == want {
+		return s.state(), s.details(), nil
+	}
+
+	// Not all BMCs advertise allowed values, in which case POST fails
+	// if the reset type is not supported.
+	if types := s.Actions.Reset.ResetTypes; len(types) > 0 && !slices.Contains(types, resetType) {
+		return "", PowerDetails{}, fmt.Errorf("%w: reset type %q is not allowed",
+			ErrUnsupportedPowerAction, resetType)
+	}
+
+	target := s.Actions.Reset.Target
+	if target == "" {
+		target = path.Join(system, "Actions", "ComputerSystem.Reset")
+	}
+
+	if _, err = c.do(ctx, http.MethodPost, target, "",
+		map[string]string{"ResetType": resetType}, nil); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	// OS shutdown can take much longer than other actions, so the caller
+	// decides how long to wait for it
+	if action == "soft-off" {
+		return s.state(), s.details(), nil
+	}
+
+	return c.waitPowerState(ctx, system, want)
+}
+
+// waitPowerState polls the power state of the system until it is want, or
+// powerActionWait passes, in which case the current state is returned.
+func (c *redfishConn) waitPowerState(ctx context.Context, system, want string) (string, PowerDetails, error) {
+	deadline := time.Now().Add(powerActionWait)
+
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var s redfishSystem
+		if _, err := c.do(ctx, http.MethodGet, system, "", nil, &s); err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		if s.state() == want || time.Now().After(deadline) {
+			return s.state(), s.details(), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", PowerDetails{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *redfishConn) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,506 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// fakeBMC is a minimal Redfish service with one system and one manager
+type fakeBMC struct {
+	targets    []string
+	modes      []string
+	media      []string
+	requests   []string
+	resetTypes []string
+	resets     []string
+	boot       map[string]string
+	bootOrder  []string
+	bootOpts   map[string]string // display names keyed by reference
+	image      string
+	power      string
+	inserted   bool
+	mutex      sync.Mutex
+}
+
+func (b *fakeBMC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	b.requests = append(b.requests, r.Method+" "+r.URL.Path)
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		//nolint:errcheck // GET requests have no body
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	var resp interface{}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /redfish/v1/Systems":
+		resp = map[string]interface{}{
+			"Members": []map[string]string{{"@odata.id": "/redfish/v1/Systems/1"}},
+		}
+	case "GET /redfish/v1/Systems/1":
+		w.Header().Set("ETag", `W/"1"`)
+		resp = map[string]interface{}{
+			"Actions": map[string]interface{}{
+				"#ComputerSystem.Reset": map[string]interface{}{
+					"target":                            "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset",
+					"ResetType@Redfish.AllowableValues": b.resetTypes,
+				},
+			},
+			"Boot": map[string]interface{}{
+				"BootSourceOverrideMode":                           "UEFI",
+				"BootSourceOverrideMode@Redfish.AllowableValues":   b.modes,
+				"BootSourceOverrideTarget@Redfish.AllowableValues": b.targets,
+				"BootOrder":   b.bootOrder,
+				"BootOptions": map[string]string{"@odata.id": "/redfish/v1/Systems/1/BootOptions"},
+			},
+			"Links": map[string]interface{}{
+				"ManagedBy": []map[string]string{{"@odata.id": "/redfish/v1/Managers/1"}},
+			},
+			"PowerState": b.power,
+			"Status":     map[string]string{"Health": "OK"},
+		}
+	case "PATCH /redfish/v1/Systems/1":
+		if r.Header.Get("If-Match") != `W/"1"` {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		b.boot = map[string]string{}
+
+		for k, v := range body["Boot"].(map[string]interface{}) {
+			if order, ok := v.([]interface{}); ok {
+				b.bootOrder = nil
+				for _, ref := range order {
+					b.bootOrder = append(b.bootOrder, ref.(string))
+				}
+
+				continue
+			}
+
+			b.boot[k] = v.(string)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	case "POST /redfish/v1/Systems/1/Actions/ComputerSystem.Reset":
+		resetType, _ := body["ResetType"].(string) //nolint:errcheck // checked by the test
+		b.resets = append(b.resets, resetType)
+
+		switch resetType {
+		case "On", "ForceRestart":
+			b.power = "On"
+		case "ForceOff":
+			b.power = "Off"
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	case "GET /redfish/v1/Systems/1/BootOptions":
+		members := []map[string]string{}
+		for ref := range b.bootOpts {
+			members = append(members, map[string]string{"@odata.id": "/redfish/v1/Systems/1/BootOptions/" + ref})
+		}
+
+		resp = map[string]interface{}{"Members": members}
+	case "GET /redfish/v1/Managers/1":
+		resp = map[string]interface{}{
+			"VirtualMedia": map[string]string{"@odata.id": "/redfish/v1/Managers/1/VirtualMedia"},
+		}
+	case "GET /redfish/v1/Managers/1/VirtualMedia":
+		resp = map[string]interface{}{
+			"Members": []map[string]string{{"@odata.id": "/redfish/v1/Managers/1/VirtualMedia/1"}},
+		}
+	case "GET /redfish/v1/Managers/1/VirtualMedia/1":
+		resp = map[string]interface{}{
+			"@odata.id":  "/redfish/v1/Managers/1/VirtualMedia/1",
+			"MediaTypes": b.media,
+			"Inserted":   b.inserted,
+		}
+	case "POST /redfish/v1/Managers/1/VirtualMedia/1/Actions/VirtualMedia.InsertMedia":
+		b.image, _ = body["Image"].(string) //nolint:errcheck // checked by the test
+		b.inserted = true
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	case "POST /redfish/v1/Managers/1/VirtualMedia/1/Actions/VirtualMedia.EjectMedia":
+		b.image, b.inserted = "", false
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	default:
+		ref, ok := strings.CutPrefix(r.URL.Path, "/redfish/v1/Systems/1/BootOptions/")
+		if name, found := b.bootOpts[ref]; ok && found && r.Method == http.MethodGet {
+			resp = map[string]string{"BootOptionReference": ref, "DisplayName": name}
+			break
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	//nolint:errcheck // nothing to do if the client went away
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newFakeBMC(t *testing.T, bmc *fakeBMC) *redfishConn {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(bmc)
+	t.Cleanup(srv.Close)
+
+	c, err := dialRedfish(map[string]interface{}{
+		"power_address": srv.Listener.Addr().String(),
+		"power_user":    "admin",
+		"power_pass":    "secret",
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func TestRedfishBootFromURL(t *testing.T) {
+	testcases := map[string]struct {
+		bmc      *fakeBMC
+		mode     string
+		bootMode string
+		boot     map[string]string
+		image    string
+		inserted bool
+		err      error
+	}{
+		"http boot": {
+			bmc:  &fakeBMC{targets: []string{"Pxe", "UefiHttp"}},
+			mode: BootModeHTTP,
+			boot: map[string]string{
+				"BootSourceOverrideEnabled": "Once",
+				"BootSourceOverrideTarget":  "UefiHttp",
+				"HttpBootUri":               "http://10.0.0.1:5248/boot.efi",
+			},
+		},
+		"http boot in uefi mode": {
+			bmc:      &fakeBMC{targets: []string{"Pxe", "UefiHttp"}, modes: []string{"Legacy", "UEFI"}},
+			mode:     BootModeHTTP,
+			bootMode: FirmwareUEFI,
+			boot: map[string]string{
+				"BootSourceOverrideEnabled": "Once",
+				"BootSourceOverrideMode":    "UEFI",
+				"BootSourceOverrideTarget":  "UefiHttp",
+				"HttpBootUri":               "http://10.0.0.1:5248/boot.efi",
+			},
+		},
+		"http boot in legacy mode": {
+			bmc:      &fakeBMC{targets: []string{"Pxe", "UefiHttp"}},
+			mode:     BootModeHTTP,
+			bootMode: FirmwareLegacy,
+			err:      ErrUnsupportedBootMode,
+		},
+		"http boot not allowed": {
+			bmc:  &fakeBMC{targets: []string{"Pxe", "Cd"}},
+			mode: BootModeHTTP,
+			err:  ErrUnsupportedBootMode,
+		},
+		"virtual media": {
+			bmc:  &fakeBMC{media: []string{"CD", "USBStick"}},
+			mode: BootModeVirtualMedia,
+			boot: map[string]string{
+				"BootSourceOverrideEnabled": "Once",
+				"BootSourceOverrideTarget":  "Cd",
+			},
+			image:    "http://10.0.0.1:5248/boot.efi",
+			inserted: true,
+		},
+		"virtual media replaces inserted image": {
+			bmc:  &fakeBMC{media: []string{"DVD"}, image: "old.iso", inserted: true},
+			mode: BootModeVirtualMedia,
+			boot: map[string]string{
+				"BootSourceOverrideEnabled": "Once",
+				"BootSourceOverrideTarget":  "Cd",
+			},
+			image:    "http://10.0.0.1:5248/boot.efi",
+			inserted: true,
+		},
+		"no virtual CD": {
+			bmc:  &fakeBMC{media: []string{"Floppy"}},
+			mode: BootModeVirtualMedia,
+			err:  ErrNoVirtualMedia,
+		},
+		"unknown mode": {
+			bmc:  &fakeBMC{},
+			mode: "pxe",
+			err:  ErrUnsupportedBootMode,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newFakeBMC(t, tc.bmc)
+
+			err := c.bootFromURL(context.Background(), "", tc.mode, "http://10.0.0.1:5248/boot.efi", tc.bootMode)
+			assert.ErrorIs(t, err, tc.err)
+
+			assert.Equal(t, tc.boot, tc.bmc.boot)
+			assert.Equal(t, tc.image, tc.bmc.image)
+			assert.Equal(t, tc.inserted, tc.bmc.inserted)
+		})
+	}
+}
+
+func TestRedfishSetBootDevice(t *testing.T) {
+	testcases := map[string]struct {
+		bmc      *fakeBMC
+		device   string
+		bootMode string
+		boot     map[string]string
+		err      error
+	}{
+		"pxe": {
+			bmc:    &fakeBMC{targets: []string{"Pxe", "Hdd", "Cd"}},
+			device: BootDevicePXE,
+			boot: map[string]string{
+				"BootSourceOverrideEnabled": "Once",
+				"BootSourceOverrideTarget":  "Pxe",
+			},
+		},
+		"disk": {
+			bmc:    &fakeBMC{},
+			device: BootDeviceDisk,
+			boot: map[string]string{
+				"BootSourceOverrideEnabled": "Once",
+				"BootSourceOverrideTarget":  "Hdd",
+			},
+		},
+		"pxe in legacy mode": {
+			bmc:      &fakeBMC{modes: []string{"Legacy", "UEFI"}},
+			device:   BootDevicePXE,
+			bootMode: FirmwareLegacy,
+			boot: map[string]string{
+				"BootSourceOverrideEnabled": "Once",
+				"BootSourceOverrideMode":    "Legacy",
+				"BootSourceOverrideTarget":  "Pxe",
+			},
+		},
+		"legacy mode not allowed": {
+			bmc:      &fakeBMC{modes: []string{"UEFI"}},
+			device:   BootDevicePXE,
+			bootMode: FirmwareLegacy,
+			err:      ErrUnsupportedBootMode,
+		},
+		"cd not allowed": {
+			bmc:    &fakeBMC{targets: []string{"Pxe", "Hdd"}},
+			device: BootDeviceCD,
+			err:    ErrUnsupportedBootMode,
+		},
+		"unknown device": {
+			bmc:    &fakeBMC{},
+			device: "floppy",
+			err:    ErrUnsupportedBootDevice,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newFakeBMC(t, tc.bmc)
+
+			err := c.setBootDevice(context.Background(), "", tc.device, tc.bootMode)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.boot, tc.bmc.boot)
+		})
+	}
+}
+
+func TestRedfishPower(t *testing.T) {
+	testcases := map[string]struct {
+		bmc    *fakeBMC
+		action string
+		state  string
+		resets []string
+		err    error
+	}{
+		"status": {
+			bmc:    &fakeBMC{power: "On"},
+			action: "status",
+			state:  "on",
+		},
+		"status while powering on": {
+			bmc:    &fakeBMC{power: "PoweringOn"},
+			action: "status",
+			state:  "unknown",
+		},
+		"power on": {
+			bmc:    &fakeBMC{power: "Off"},
+			action: "on",
+			state:  "on",
+			resets: []string{"On"},
+		},
+		"already on": {
+			bmc:    &fakeBMC{power: "On"},
+			action: "on",
+			state:  "on",
+		},
+		"power off": {
+			bmc:    &fakeBMC{power: "On", resetTypes: []string{"On", "ForceOff"}},
+			action: "off",
+			state:  "off",
+			resets: []string{"ForceOff"},
+		},
+		"power cycle": {
+			bmc:    &fakeBMC{power: "On"},
+			action: "cycle",
+			state:  "on",
+			resets: []string{"ForceRestart"},
+		},
+		"power cycle when off": {
+			bmc:    &fakeBMC{power: "Off"},
+			action: "cycle",
+			state:  "on",
+			resets: []string{"On"},
+		},
+		"soft power off": {
+			bmc:    &fakeBMC{power: "On"},
+			action: "soft-off",
+			state:  "on",
+			resets: []string{"GracefulShutdown"},
+		},
+		"power reset": {
+			bmc:    &fakeBMC{power: "On"},
+			action: "reset",
+			state:  "on",
+			resets: []string{"ForceRestart"},
+		},
+		"reset type not allowed": {
+			bmc:    &fakeBMC{power: "On", resetTypes: []string{"On", "GracefulShutdown"}},
+			action: "off",
+			err:    ErrUnsupportedPowerAction,
+		},
+		"unknown action": {
+			bmc:    &fakeBMC{power: "On"},
+			action: "set-boot-order",
+			err:    ErrUnsupportedPowerAction,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newFakeBMC(t, tc.bmc)
+
+			state, details, err := c.power(context.Background(), "", tc.action)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.state, state)
+			assert.Equal(t, tc.resets, tc.bmc.resets)
+
+			if tc.err == nil {
+				assert.Equal(t, PowerDetails{Health: "OK", BootMode: "UEFI"}, details)
+			}
+		})
+	}
+}
+
+func TestRedfishEjectVirtualMedia(t *testing.T) {
+	bmc := &fakeBMC{media: []string{"CD"}, image: "boot.iso", inserted: true}
+	c := newFakeBMC(t, bmc)
+
+	require.NoError(t, c.ejectVirtualMedia(context.Background(), "1"))
+	assert.False(t, bmc.inserted)
+
+	// Nothing to eject the second time
+	require.NoError(t, c.ejectVirtualMedia(context.Background(), "1"))
+	assert.NotContains(t, bmc.requests[len(bmc.requests)-1], "EjectMedia")
+}
+
+func TestRedfishUnauthorized(t *testing.T) {
+	srv := httptest.NewTLSServer(&fakeBMC{})
+	t.Cleanup(srv.Close)
+
+	c, err := dialRedfish(map[string]interface{}{
+		"power_address": srv.URL,
+		"power_user":    "admin",
+		"power_pass":    "wrong",
+	})
+	require.NoError(t, err)
+
+	err = c.bootFromURL(context.Background(), "", BootModeHTTP, "http://10.0.0.1/boot.efi", "")
+	assert.ErrorIs(t, err, ErrUnexpectedResponse)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	assert.Equal(t, errcode.PowerAuthFailed, errcode.Of(err))
+}
+
+func TestRedfishBootOrder(t *testing.T) {
+	t.Parallel()
+
+	bmc := &fakeBMC{
+		bootOrder: []string{"Boot0001", "Boot0002", "Boot0003"},
+		bootOpts: map[string]string{
+			"Boot0001": "UEFI: SATA HDD",
+			"Boot0002": "UEFI: Virtual CD/DVD",
+			"Boot0003": "UEFI PXEv4 (MAC:52540012CD34)",
+		},
+	}
+	c := newFakeBMC(t, bmc)
+
+	ctx := context.Background()
+
+	order, err := c.bootOrder(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []BootOption{
+		{ID: "Boot0001", Name: "UEFI: SATA HDD", Device: BootDeviceDisk},
+		{ID: "Boot0002", Name: "UEFI: Virtual CD/DVD", Device: BootDeviceCD},
+		{ID: "Boot0003", Name: "UEFI PXEv4 (MAC:52540012CD34)", Device: BootDevicePXE},
+	}, order)
+
+	desired := desiredBootOrder(order, []string{BootDevicePXE, BootDeviceDisk})
+	require.NoError(t, c.setBootOrder(ctx, "", bootOptionIDs(desired)))
+
+	assert.Equal(t, []string{"Boot0003", "Boot0001", "Boot0002"}, bmc.bootOrder)
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+// Priorities of power actions
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// RequestMetadata tells who asked for a power action and why, so actions
+// can be traced end to end. It is logged with everything the Agent does
+// for the action, counted in retry statistics by priority and echoed in
+// the result.
+type RequestMetadata struct {
+	// Requester is the identity of who asked for the action (e.g. a MAAS
+	// user, or the name of an automation)
+	Requester string `json:"requester,omitempty"`
+	// Reason is why the action was requested (e.g. "deploy")
+	Reason string `json:"reason,omitempty"`
+	// CorrelationID ties the action to the request of the caller
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Priority is PriorityLow, PriorityNormal or PriorityHigh. Empty (or
+	// unknown) priority is PriorityNormal.
+	Priority string `json:"priority,omitempty"`
+}
+
+func (r RequestMetadata) empty() bool {
+	return r == RequestMetadata{}
+}
+
+// priority returns the priority of the request
+func (r RequestMetadata) priority() string {
+	switch r.Priority {
+	case PriorityLow, PriorityHigh:
+		return r.Priority
+	}
+
+	return PriorityNormal
+}
+
+// echo returns r for results of power actions, or nil if it is empty
+func (r RequestMetadata) echo() *RequestMetadata {
+	if r.empty() {
+		return nil
+	}
+
+	return &r
+}
+
+// keyVals returns fields of r which are set as key-value pairs of logs
+func (r RequestMetadata) keyVals() []interface{} {
+	t := tag.Builder()
+
+	for _, kv := range [][2]string{
+		{"requester", r.Requester},
+		{"reason", r.Reason},
+		{"correlation_id", r.CorrelationID},
+		{"priority", r.Priority},
+	} {
+		if kv[1] != "" {
+			t = t.KV(kv[0], kv[1])
+		}
+	}
+
+	return t.KeyVals
+}
+
+type requestKey struct{}
+
+// withRequest returns ctx carrying r, so it is logged by commandLogger
+func withRequest(ctx context.Context, r RequestMetadata) context.Context {
+	if r.empty() {
+		return ctx
+	}
+
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+func requestFrom(ctx context.Context) RequestMetadata {
+	r, _ := ctx.Value(requestKey{}).(RequestMetadata)
+	return r
+}
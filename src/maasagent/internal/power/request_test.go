@@ -0,0 +1,92 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestMetadata(t *testing.T) {
+	var param PowerOnParam
+
+	require.NoError(t, json.Unmarshal([]byte(`{"driver_type":"fake","driver_opts":{},`+
+		`"requester":"admin","reason":"deploy","correlation_id":"req-1","priority":"high"}`), &param))
+
+	s := NewPowerService("abc", nil, WithDriver("fake", &fakeDriver{supported: true}))
+
+	res, err := s.PowerOn(context.Background(), param)
+	require.NoError(t, err)
+	assert.Equal(t, &RequestMetadata{
+		Requester:     "admin",
+		Reason:        "deploy",
+		CorrelationID: "req-1",
+		Priority:      PriorityHigh,
+	}, res.Request)
+
+	b, err := json.Marshal(res)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"state":"on","health":"OK","request":{"requester":"admin","reason":"deploy",`+
+		`"correlation_id":"req-1","priority":"high"}}`, string(b))
+
+	// Nothing is echoed without metadata
+	res, err = s.PowerOn(context.Background(), PowerOnParam{PowerParam: PowerParam{DriverType: "fake"}})
+	require.NoError(t, err)
+	assert.Nil(t, res.Request)
+
+	// The Region sends null for metadata it doesn't have
+	param = PowerOnParam{}
+	require.NoError(t, json.Unmarshal([]byte(`{"driver_type":"fake","driver_opts":{},"boot_mode":null,`+
+		`"requester":null,"reason":null,"correlation_id":null}`), &param))
+
+	res, err = s.PowerOn(context.Background(), param)
+	require.NoError(t, err)
+	assert.Nil(t, res.Request)
+}
+
+func TestRequestMetadataPriority(t *testing.T) {
+	testcases := map[string]struct {
+		in  string
+		out string
+	}{
+		"default": {out: PriorityNormal},
+		"low":     {in: PriorityLow, out: PriorityLow},
+		"high":    {in: PriorityHigh, out: PriorityHigh},
+		"unknown": {in: "urgent", out: PriorityNormal},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, RequestMetadata{Priority: tc.in}.priority())
+		})
+	}
+}
+
+func TestRequestMetadataContext(t *testing.T) {
+	r := RequestMetadata{Requester: "admin", CorrelationID: "req-1"}
+
+	assert.Equal(t, RequestMetadata{}, requestFrom(context.Background()))
+	assert.Equal(t, r, requestFrom(withRequest(context.Background(), r)))
+	assert.Equal(t, []interface{}{"requester", "admin", "correlation_id", "req-1"}, r.keyVals())
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	defaultRetryInitialInterval    = time.Second
+	defaultRetryBackoffCoefficient = 2.0
+	// deadlineMargin is the time left to report the result of a power
+	// action before the deadline of the activity (its StartToClose timeout)
+	deadlineMargin = 5 * time.Second
+	// minRetryAttempt is the least time an attempt needs, a retry is not
+	// started if less would be left until the deadline
+	minRetryAttempt = 10 * time.Second
+)
+
+var (
+	// ErrInvalidRetryPolicy is returned for a policy that can't be applied
+	ErrInvalidRetryPolicy = errcode.New(errcode.PowerInvalidParameters, "invalid retry policy")
+)
+
+// RetryPolicy defines how power actions are retried by the Agent before the
+// activity fails, so flaky BMCs don't fail power workflows of the Region
+// straight away. Retries are bounded by the deadline of the activity: no retry
+// is started unless it can complete before the deadline, so the activity fails
+// with the error of the last attempt rather than with a timeout.
+// The zero value performs a single attempt.
+type RetryPolicy struct {
+	// InitialInterval is the delay before the first retry (default: 1s)
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	// MaximumInterval caps the delay between retries, unlimited if 0
+	MaximumInterval time.Duration `yaml:"maximum_interval"`
+	// BackoffCoefficient multiplies the delay after each retry (default: 2)
+	BackoffCoefficient float64 `yaml:"backoff_coefficient"`
+	// MaximumAttempts including the first one, a single attempt if 0
+	MaximumAttempts int `yaml:"maximum_attempts"`
+	// NonRetryableErrorTypes are type names of errors which are never
+	// retried (e.g. "ExitError"), the same as in Temporal retry policies
+	NonRetryableErrorTypes []string `yaml:"non_retryable_error_types"`
+}
+
+// Validate checks that the policy can be applied
+func (p RetryPolicy) Validate() error {
+	if p.InitialInterval < 0 || p.MaximumInterval < 0 {
+		return fmt.Errorf("%w: negative interval", ErrInvalidRetryPolicy)
+	}
+
+	if p.BackoffCoefficient != 0 && p.BackoffCoefficient < 1 {
+		return fmt.Errorf("%w: backoff coefficient must be at least 1", ErrInvalidRetryPolicy)
+	}
+
+	if p.MaximumAttempts < 0 {
+		return fmt.Errorf("%w: negative maximum attempts", ErrInvalidRetryPolicy)
+	}
+
+	return nil
+}
+
+// retryable returns true if err doesn't match any of the non-retryable
+// error types. Wrapped errors are matched as well.
+func (p RetryPolicy) retryable(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if slices.Contains(p.NonRetryableErrorTypes, errorType(err)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// backoff returns the delay before the given retry (starting with 1)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	interval := p.InitialInterval
+	if interval == 0 {
+		interval = defaultRetryInitialInterval
+	}
+
+	coefficient := p.BackoffCoefficient
+	if coefficient == 0 {
+		coefficient = defaultRetryBackoffCoefficient
+	}
+
+	delay := float64(interval)
+	for i := 1; i < retry; i++ {
+		delay *= coefficient
+	}
+
+	if p.MaximumInterval > 0 && delay > float64(p.MaximumInterval) {
+		return p.MaximumInterval
+	}
+
+	return time.Duration(delay)
+}
+
+// errorType returns the type name of err the same way Temporal does for
+// application errors, so the same names can be used in both.
+func errorType(err error) string {
+	t := reflect.TypeOf(err)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.Name()
+}
+
+// retry calls fn until it succeeds, attempts are exhausted, the error is
+// not retryable, ctx is done or there is not enough time left until the
+// deadline of ctx for another attempt. Activities heartbeat between attempts.
+func retry[T any](ctx context.Context, p RetryPolicy,
+	fn func(ctx context.Context) (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := fn(ctx)
+		if err == nil || attempt >= p.MaximumAttempts || !p.retryable(err) ||
+			ctx.Err() != nil {
+			return result, err
+		}
+
+		delay := p.backoff(attempt)
+
+		if deadline, ok := ctx.Deadline(); ok &&
+			time.Until(deadline) < delay+minRetryAttempt+deadlineMargin {
+			commandLogger(ctx).Warn("Not retrying power action, activity deadline is too close",
+				tag.Builder().Error(err).KV("attempt", attempt).KV("deadline", deadline).KeyVals...)
+
+			return result, err
+		}
+
+		commandLogger(ctx).Warn("Retrying power action",
+			tag.Builder().Error(err).KV("attempt", attempt).KV("delay", delay).KeyVals...)
+
+		if activity.IsActivity(ctx) {
+			activity.RecordHeartbeat(ctx, attempt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attemptTimeout caps timeout of a single attempt, so it ends before the
+// deadline of ctx with a margin to report the result. Zero timeout is
+// unlimited.
+func attemptTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+
+	left := time.Until(deadline) - deadlineMargin
+	if left <= 0 {
+		// Too late for a margin, the deadline of ctx applies
+		return timeout
+	}
+
+	if timeout <= 0 || left < timeout {
+		return left
+	}
+
+	return timeout
+}
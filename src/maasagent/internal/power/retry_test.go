@@ -0,0 +1,248 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	testcases := map[string]struct {
+		policy RetryPolicy
+		out    []time.Duration
+	}{
+		"default": {
+			out: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		"coefficient": {
+			policy: RetryPolicy{InitialInterval: 100 * time.Millisecond, BackoffCoefficient: 3},
+			out:    []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond},
+		},
+		"maximum interval": {
+			policy: RetryPolicy{MaximumInterval: 3 * time.Second},
+			out:    []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for i, delay := range tc.out {
+				assert.Equal(t, delay, tc.policy.backoff(i+1))
+			}
+		})
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	testcases := map[string]struct {
+		policy RetryPolicy
+		err    error
+	}{
+		"zero value": {},
+		"valid": {
+			policy: RetryPolicy{InitialInterval: time.Second, BackoffCoefficient: 1.5, MaximumAttempts: 5},
+		},
+		"negative interval": {
+			policy: RetryPolicy{InitialInterval: -time.Second},
+			err:    ErrInvalidRetryPolicy,
+		},
+		"coefficient below 1": {
+			policy: RetryPolicy{BackoffCoefficient: 0.5},
+			err:    ErrInvalidRetryPolicy,
+		},
+		"negative attempts": {
+			policy: RetryPolicy{MaximumAttempts: -1},
+			err:    ErrInvalidRetryPolicy,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, tc.policy.Validate(), tc.err)
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	errExit := &exec.ExitError{}
+
+	testcases := map[string]struct {
+		policy   RetryPolicy
+		errs     []error
+		attempts int
+		err      error
+	}{
+		"single attempt by default": {
+			errs:     []error{errFlaky, nil},
+			attempts: 1,
+			err:      errFlaky,
+		},
+		"succeeds after retries": {
+			policy:   RetryPolicy{InitialInterval: time.Millisecond, MaximumAttempts: 3},
+			errs:     []error{errFlaky, errFlaky, nil},
+			attempts: 3,
+		},
+		"attempts exhausted": {
+			policy:   RetryPolicy{InitialInterval: time.Millisecond, MaximumAttempts: 2},
+			errs:     []error{errFlaky, errFlaky, nil},
+			attempts: 2,
+			err:      errFlaky,
+		},
+		"non-retryable error type": {
+			policy: RetryPolicy{InitialInterval: time.Millisecond, MaximumAttempts: 3,
+				NonRetryableErrorTypes: []string{"ExitError"}},
+			errs:     []error{fmt.Errorf("ipmipower: %w", errExit), nil},
+			attempts: 1,
+			err:      errExit,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			attempts := 0
+
+			out, err := retry(context.Background(), tc.policy, func(context.Context) (string, error) {
+				err := tc.errs[attempts]
+				attempts++
+
+				if err != nil {
+					return "", err
+				}
+
+				return "on", nil
+			})
+
+			assert.Equal(t, tc.attempts, attempts)
+
+			assert.ErrorIs(t, err, tc.err)
+
+			if tc.err == nil {
+				assert.Equal(t, "on", out)
+			}
+		})
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+
+	_, err := retry(ctx, RetryPolicy{InitialInterval: time.Hour, MaximumAttempts: 3},
+		func(context.Context) (string, error) {
+			attempts++
+			cancel()
+
+			return "", errors.New("flaky")
+		})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryDeadline(t *testing.T) {
+	t.Parallel()
+
+	// Not enough time is left for a retry before the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), minRetryAttempt+deadlineMargin)
+	defer cancel()
+
+	attempts := 0
+
+	_, err := retry(ctx, RetryPolicy{InitialInterval: time.Second, MaximumAttempts: 3},
+		func(context.Context) (string, error) {
+			attempts++
+			return "", errors.New("flaky")
+		})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.NoError(t, ctx.Err())
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	testcases := map[string]struct {
+		deadline time.Duration
+		timeout  time.Duration
+		out      time.Duration
+	}{
+		"no deadline": {
+			timeout: 4 * time.Minute,
+			out:     4 * time.Minute,
+		},
+		"timeout before deadline": {
+			deadline: 5 * time.Minute,
+			timeout:  time.Minute,
+			out:      time.Minute,
+		},
+		"timeout capped by deadline": {
+			deadline: 5 * time.Minute,
+			timeout:  5 * time.Minute,
+			out:      5*time.Minute - deadlineMargin,
+		},
+		"unlimited timeout capped by deadline": {
+			deadline: 5 * time.Minute,
+			out:      5*time.Minute - deadlineMargin,
+		},
+		"deadline within margin": {
+			deadline: time.Second,
+			timeout:  time.Minute,
+			out:      time.Minute,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			if tc.deadline > 0 {
+				var cancel context.CancelFunc
+
+				ctx, cancel = context.WithTimeout(ctx, tc.deadline)
+				defer cancel()
+			}
+
+			// Time passes between setting the deadline and the check
+			assert.InDelta(t, tc.out, attemptTimeout(ctx, tc.timeout), float64(time.Second))
+		})
+	}
+}
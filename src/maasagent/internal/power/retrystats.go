@@ -0,0 +1,209 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/schedule"
+)
+
+const (
+	// defaultRetryStatsInterval is how often retry statistics are reported
+	defaultRetryStatsInterval = 15 * time.Minute
+	// retryStatsJitter spreads reports of Agents over time
+	retryStatsJitter = time.Minute
+)
+
+// RetryStats are counters of power actions retried by the Agent
+type RetryStats struct {
+	// Actions is the number of power actions performed
+	Actions int `json:"actions"`
+	// Retries is the number of attempts made after the first one
+	Retries int `json:"retries"`
+	// Exhausted is the number of actions which failed on the last attempt
+	// allowed by the retry policy
+	Exhausted int `json:"exhausted"`
+}
+
+func (r *RetryStats) add(attempts int, exhausted bool) {
+	r.Actions++
+	r.Retries += max(attempts-1, 0)
+
+	if exhausted {
+		r.Exhausted++
+	}
+}
+
+// RetryStatsReport is the parameter of report-power-retry-stats activity
+// of the Region, with retry statistics collected since the previous report.
+type RetryStatsReport struct {
+	SystemID string    `json:"system_id"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	// Drivers are statistics keyed by power driver type
+	Drivers map[string]RetryStats `json:"drivers"`
+	// Machines are statistics keyed by system_id of machines, or their
+	// BMC address if the Region didn't pass the system_id
+	Machines map[string]RetryStats `json:"machines"`
+	// Priorities are statistics keyed by priority of requests
+	Priorities map[string]RetryStats `json:"priorities"`
+}
+
+// retryStats collects retry statistics between reports
+type retryStats struct {
+	since      time.Time
+	drivers    map[string]RetryStats
+	machines   map[string]RetryStats
+	priorities map[string]RetryStats
+	mutex      sync.Mutex
+}
+
+func newRetryStats(now time.Time) *retryStats {
+	return &retryStats{
+		since:      now,
+		drivers:    make(map[string]RetryStats),
+		machines:   make(map[string]RetryStats),
+		priorities: make(map[string]RetryStats),
+	}
+}
+
+func (r *retryStats) record(driverType, machine, priority string, attempts int, exhausted bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	d := r.drivers[driverType]
+	d.add(attempts, exhausted)
+	r.drivers[driverType] = d
+
+	p := r.priorities[priority]
+	p.add(attempts, exhausted)
+	r.priorities[priority] = p
+
+	if machine == "" {
+		return
+	}
+
+	m := r.machines[machine]
+	m.add(attempts, exhausted)
+	r.machines[machine] = m
+}
+
+// snapshot returns statistics collected since the previous snapshot and
+// starts collecting from scratch.
+func (r *retryStats) snapshot(now time.Time) RetryStatsReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := RetryStatsReport{
+		Since:      r.since,
+		Until:      now,
+		Drivers:    r.drivers,
+		Machines:   r.machines,
+		Priorities: r.priorities,
+	}
+
+	r.since = now
+	r.drivers = make(map[string]RetryStats)
+	r.machines = make(map[string]RetryStats)
+	r.priorities = make(map[string]RetryStats)
+
+	return report
+}
+
+// retryStatsMachine returns the system_id of the machine, or its BMC
+// address when the Region didn't pass the system_id.
+func retryStatsMachine(opts map[string]interface{}) string {
+	if id := stringOpt(opts, "system_id"); id != "" {
+		return id
+	}
+
+	return stringOpt(opts, "power_address")
+}
+
+// WithRetryStatsInterval sets how often retry statistics of power actions
+// are reported to the Region. Zero disables reporting. (default: 15 minutes)
+func WithRetryStatsInterval(d time.Duration) PowerServiceOption {
+	return func(s *PowerService) {
+		s.statsInterval = max(d, 0)
+	}
+}
+
+// Schedules implements schedule.Provider
+func (s *PowerService) Schedules() []schedule.Schedule {
+	var schedules []schedule.Schedule
+
+	if s.statsInterval > 0 {
+		schedules = append(schedules, schedule.Schedule{
+			ID:               "report-retry-stats",
+			Workflow:         "report-retry-stats",
+			Args:             []any{ReportRetryStatsParam{SystemID: s.systemID}},
+			Every:            s.statsInterval,
+			Jitter:           retryStatsJitter,
+			ExecutionTimeout: 5 * time.Minute,
+		})
+	}
+
+	if s.sweepInterval > 0 {
+		schedules = append(schedules, schedule.Schedule{
+			ID:       "reconcile-power-states",
+			Workflow: "reconcile-power-states",
+			Args:     []any{ReconcilePowerStatesParam{SystemID: s.systemID}},
+			Every:    s.sweepInterval,
+			Jitter:   powerSweepJitter,
+			// Machines left in transition are watched by child workflows
+			// that outlive the sweep, so the sweep itself is short.
+			ExecutionTimeout: powerSweepTimeout,
+		})
+	}
+
+	return schedules
+}
+
+// ReportRetryStatsParam is the parameter of report-retry-stats workflow
+type ReportRetryStatsParam struct {
+	SystemID string `json:"system_id"`
+}
+
+// reportRetryStats reports retry statistics of power actions collected
+// since the previous run, so the Region can show machines with degraded
+// BMCs without scraping metrics of Agents. Nothing is reported if there
+// were no power actions.
+func (s *PowerService) reportRetryStats(ctx tworkflow.Context, param ReportRetryStatsParam) error {
+	localCtx := tworkflow.WithLocalActivityOptions(ctx, tworkflow.LocalActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+	})
+
+	var report RetryStatsReport
+
+	if err := tworkflow.ExecuteLocalActivity(localCtx,
+		func(context.Context) (RetryStatsReport, error) {
+			return s.retryStats.snapshot(time.Now()), nil
+		}).Get(ctx, &report); err != nil {
+		return err
+	}
+
+	if len(report.Drivers) == 0 {
+		return nil
+	}
+
+	report.SystemID = param.SystemID
+
+	return tworkflow.ExecuteActivity(regionContext(ctx), "report-power-retry-stats", report).Get(ctx, nil)
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyDriver fails Status of fakeDriver the given number of times
+type flakyDriver struct {
+	*fakeDriver
+	failures int
+}
+
+func (d *flakyDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	if d.failures > 0 {
+		d.failures--
+		return "", PowerDetails{}, errors.New("BMC is busy")
+	}
+
+	return d.fakeDriver.Status(ctx, opts)
+}
+
+func TestRetryStats(t *testing.T) {
+	d := &flakyDriver{fakeDriver: &fakeDriver{supported: true}, failures: 4}
+	s := NewPowerService("abc", nil, WithDriver("fake", d),
+		WithRetryPolicy(RetryPolicy{InitialInterval: time.Millisecond, MaximumAttempts: 3}))
+
+	// fails 3 times, exhausting attempts
+	_, err := s.Execute(context.Background(), "status",
+		PowerParam{DriverType: "fake", DriverOpts: map[string]interface{}{"system_id": "abc123"}})
+	require.Error(t, err)
+
+	// succeeds on the second attempt
+	_, err = s.Execute(context.Background(), "status",
+		PowerParam{DriverType: "fake", DriverOpts: map[string]interface{}{"power_address": "10.0.0.1"}})
+	require.NoError(t, err)
+
+	// succeeds on the first attempt
+	_, err = s.Execute(context.Background(), "on",
+		PowerParam{DriverType: "fake", DriverOpts: map[string]interface{}{"system_id": "abc123"},
+			RequestMetadata: RequestMetadata{Priority: PriorityHigh}})
+	require.NoError(t, err)
+
+	now := time.Now()
+	report := s.retryStats.snapshot(now)
+
+	assert.Equal(t, now, report.Until)
+	assert.Equal(t, map[string]RetryStats{
+		"fake": {Actions: 3, Retries: 3, Exhausted: 1},
+	}, report.Drivers)
+	assert.Equal(t, map[string]RetryStats{
+		"abc123":   {Actions: 2, Retries: 2, Exhausted: 1},
+		"10.0.0.1": {Actions: 1, Retries: 1},
+	}, report.Machines)
+	assert.Equal(t, map[string]RetryStats{
+		PriorityNormal: {Actions: 2, Retries: 3, Exhausted: 1},
+		PriorityHigh:   {Actions: 1},
+	}, report.Priorities)
+
+	// statistics are collected from scratch after each report
+	report = s.retryStats.snapshot(now.Add(time.Minute))
+	assert.Equal(t, now, report.Since)
+	assert.Empty(t, report.Drivers)
+	assert.Empty(t, report.Machines)
+	assert.Empty(t, report.Priorities)
+}
+
+func TestPowerServiceSchedules(t *testing.T) {
+	s := NewPowerService("abc", nil)
+
+	schedules := s.Schedules()
+	require.Len(t, schedules, 2)
+	assert.Equal(t, "report-retry-stats", schedules[0].Workflow)
+	assert.Equal(t, defaultRetryStatsInterval, schedules[0].Every)
+	assert.Equal(t, []any{ReportRetryStatsParam{SystemID: "abc"}}, schedules[0].Args)
+	assert.Equal(t, "reconcile-power-states", schedules[1].Workflow)
+	assert.Equal(t, defaultPowerSweepInterval, schedules[1].Every)
+	assert.Equal(t, []any{ReconcilePowerStatesParam{SystemID: "abc"}}, schedules[1].Args)
+
+	s = NewPowerService("abc", nil, WithRetryStatsInterval(-1), WithPowerSweepInterval(0))
+	assert.Empty(t, s.Schedules())
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"time"
+
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+// RollingMachine is a machine power cycled by rolling-power-cycle workflow
+type RollingMachine struct {
+	SystemID string `json:"system_id"`
+	PowerParam
+	// CheckReady asks the Region whether the machine is ready (e.g. its
+	// services are up) before the next batch is cycled. Otherwise the
+	// machine is healthy once it's on, unless the BMC reports critical
+	// health.
+	CheckReady bool `json:"check_ready,omitempty"`
+}
+
+// RollingPowerCycleParam is the parameter of rolling-power-cycle workflow
+type RollingPowerCycleParam struct {
+	// AgentSystemID is the system_id of the Agent performing power actions
+	AgentSystemID string           `json:"agent_system_id"`
+	Machines      []RollingMachine `json:"machines"`
+	// BatchSize is how many machines are cycled together, one by default
+	BatchSize int `json:"batch_size,omitempty"`
+	// Delay in seconds between a batch becoming healthy and cycling the
+	// next one
+	Delay int `json:"delay,omitempty"`
+	// HealthTimeout in seconds is how long machines of a batch can take to
+	// become healthy
+	HealthTimeout int `json:"health_timeout,omitempty"`
+	// MaxFailures is how many machines can fail before the rest are left
+	// alone, none by default
+	MaxFailures int `json:"max_failures,omitempty"`
+}
+
+// RollingMachineResult is the outcome of power cycling a machine
+type RollingMachineResult struct {
+	SystemID string `json:"system_id"`
+	Batch    int    `json:"batch"`
+	State    string `json:"state,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// ErrorCode is the code of Error, e.g. "POWER_HEALTH_TIMEOUT"
+	ErrorCode string `json:"error_code,omitempty"`
+	// Skipped is true when the machine was not cycled, because too many
+	// machines of previous batches failed
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// RollingPowerCycleResult is the result of rolling-power-cycle workflow
+type RollingPowerCycleResult struct {
+	// Batches are system_ids of machines cycled together, in order
+	Batches  [][]string             `json:"batches"`
+	Machines []RollingMachineResult `json:"machines"`
+	Success  bool                   `json:"success"`
+}
+
+// rollingPowerCycle power cycles machines a batch at a time, e.g. to reboot
+// a rack into updated firmware or kernel without taking all of its
+// capacity down. The next batch is cycled once all machines of the batch
+// are healthy again, and after Delay. Once more than MaxFailures machines
+// failed, the rest are left alone.
+func (s *PowerService) rollingPowerCycle(ctx tworkflow.Context,
+	param RollingPowerCycleParam) (*RollingPowerCycleResult, error) {
+	log := tworkflow.GetLogger(ctx)
+
+	size := param.BatchSize
+	if size <= 0 {
+		size = 1
+	}
+
+	timeout := defaultPowerGateTimeout
+	if param.HealthTimeout > 0 {
+		timeout = time.Duration(param.HealthTimeout) * time.Second
+	}
+
+	delay := time.Duration(param.Delay) * time.Second
+
+	result := &RollingPowerCycleResult{Success: true}
+	failures := 0
+
+	for batch, start := 0, 0; start < len(param.Machines); batch, start = batch+1, start+size {
+		machines := param.Machines[start:min(start+size, len(param.Machines))]
+
+		systemIDs := make([]string, len(machines))
+		for i, m := range machines {
+			systemIDs[i] = m.SystemID
+		}
+
+		result.Batches = append(result.Batches, systemIDs)
+
+		if failures > param.MaxFailures {
+			for _, m := range machines {
+				result.Machines = append(result.Machines,
+					RollingMachineResult{SystemID: m.SystemID, Batch: batch, Skipped: true})
+			}
+
+			continue
+		}
+
+		if batch > 0 && delay > 0 {
+			if err := tworkflow.Sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+
+		deadline := tworkflow.Now(ctx).Add(timeout)
+
+		level := make([]OrderedMachine, len(machines))
+		futures := make([]tworkflow.Future, len(machines))
+
+		for i, m := range machines {
+			level[i] = OrderedMachine{SystemID: m.SystemID, PowerParam: m.PowerParam, CheckReady: m.CheckReady}
+			futures[i] = tworkflow.ExecuteActivity(sequencedActionContext(ctx, param.AgentSystemID),
+				"power-cycle", PowerCycleParam{PowerParam: m.PowerParam})
+		}
+
+		for _, r := range s.awaitHealthy(ctx, param.AgentSystemID, level, futures, deadline) {
+			if r.Error != "" {
+				failures++
+				result.Success = false
+			}
+
+			result.Machines = append(result.Machines, RollingMachineResult{
+				SystemID:  r.SystemID,
+				Batch:     batch,
+				State:     r.State,
+				Error:     r.Error,
+				ErrorCode: r.ErrorCode,
+			})
+		}
+
+		log.Info("Rolling power cycle batch finished", tag.Builder().
+			KV("batch", batch).
+			KV("machines", systemIDs).
+			KV("failures", failures).KeyVals...)
+	}
+
+	return result, nil
+}
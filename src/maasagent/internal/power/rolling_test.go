@@ -0,0 +1,167 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+func rollingMachine(systemID string) RollingMachine {
+	return RollingMachine{
+		SystemID: systemID,
+		PowerParam: PowerParam{
+			DriverType: "ipmi",
+			DriverOpts: map[string]interface{}{"power_id": systemID},
+		},
+	}
+}
+
+func TestRollingPowerCycle(t *testing.T) {
+	unhealthy := func(id string) RollingMachineResult {
+		return RollingMachineResult{SystemID: id, Batch: 1, State: "on",
+			Error: ErrPowerGateTimeout.Error(), ErrorCode: errcode.PowerHealthTimeout}
+	}
+
+	testcases := map[string]struct {
+		// critical is the machine reporting critical health after cycle
+		critical    string
+		maxFailures int
+		success     bool
+		results     []RollingMachineResult
+		// cycled are batches of machines cycled together
+		cycled [][]string
+	}{
+		"healthy": {
+			success: true,
+			results: []RollingMachineResult{
+				{SystemID: "a", Batch: 0, State: "on"},
+				{SystemID: "b", Batch: 0, State: "on"},
+				{SystemID: "c", Batch: 1, State: "on"},
+				{SystemID: "d", Batch: 1, State: "on"},
+				{SystemID: "e", Batch: 2, State: "on"},
+			},
+			cycled: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		"unhealthy": {
+			critical: "c",
+			results: []RollingMachineResult{
+				{SystemID: "a", Batch: 0, State: "on"},
+				{SystemID: "b", Batch: 0, State: "on"},
+				unhealthy("c"),
+				{SystemID: "d", Batch: 1, State: "on"},
+				{SystemID: "e", Batch: 2, Skipped: true},
+			},
+			cycled: [][]string{{"a", "b"}, {"c", "d"}},
+		},
+		"failures allowed": {
+			critical:    "c",
+			maxFailures: 1,
+			results: []RollingMachineResult{
+				{SystemID: "a", Batch: 0, State: "on"},
+				{SystemID: "b", Batch: 0, State: "on"},
+				unhealthy("c"),
+				{SystemID: "d", Batch: 1, State: "on"},
+				{SystemID: "e", Batch: 2, State: "on"},
+			},
+			cycled: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			svc := NewPowerService("agent", nil)
+			env := newTestWorkflowEnvironment(svc)
+
+			env.RegisterActivityWithOptions(svc.PowerCycle, activity.RegisterOptions{Name: "power-cycle"})
+
+			var (
+				mutex   sync.Mutex
+				cycled  = make(map[time.Time][]string)
+				started []time.Time
+			)
+
+			env.OnActivity("power-cycle", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, p PowerCycleParam) (*PowerCycleResult, error) {
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					now := env.Now()
+					if _, ok := cycled[now]; !ok {
+						started = append(started, now)
+					}
+
+					cycled[now] = append(cycled[now], stringOpt(p.DriverOpts, "power_id"))
+
+					return &PowerCycleResult{State: "on"}, nil
+				})
+			env.OnActivity("power-query", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, p PowerQueryParam) (*PowerQueryResult, error) {
+					res := &PowerQueryResult{State: "on"}
+					if stringOpt(p.DriverOpts, "power_id") == tc.critical {
+						res.Health = healthCritical
+					}
+
+					return res, nil
+				})
+
+			env.ExecuteWorkflow(svc.rollingPowerCycle, RollingPowerCycleParam{
+				AgentSystemID: "agent",
+				Machines: []RollingMachine{
+					rollingMachine("a"), rollingMachine("b"), rollingMachine("c"),
+					rollingMachine("d"), rollingMachine("e"),
+				},
+				BatchSize:   2,
+				Delay:       300,
+				MaxFailures: tc.maxFailures,
+			})
+
+			require.True(t, env.IsWorkflowCompleted())
+			require.NoError(t, env.GetWorkflowError())
+
+			var result RollingPowerCycleResult
+			require.NoError(t, env.GetWorkflowResult(&result))
+
+			assert.Equal(t, tc.success, result.Success)
+			assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, result.Batches)
+			assert.Equal(t, tc.results, result.Machines)
+
+			batches := make([][]string, len(started))
+			for i, at := range started {
+				batches[i] = cycled[at]
+			}
+
+			assert.ElementsMatch(t, tc.cycled[0], batches[0])
+
+			for i := 1; i < len(started); i++ {
+				assert.ElementsMatch(t, tc.cycled[i], batches[i])
+				assert.GreaterOrEqual(t, started[i].Sub(started[i-1]), 300*time.Second)
+			}
+
+			assert.Len(t, batches, len(tc.cycled))
+		})
+	}
+}
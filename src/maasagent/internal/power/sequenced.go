@@ -0,0 +1,230 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+// sequencedActionTimeout is how long a power action of power-sequenced
+// workflow can take, including retries of the power service. Chassis
+// managers can take minutes to power on a node.
+const sequencedActionTimeout = 15 * time.Minute
+
+// SequencedMachine is a machine powered by power-sequenced workflow
+type SequencedMachine struct {
+	SystemID string `json:"system_id"`
+	PowerParam
+}
+
+// PowerSequencedParam is the parameter of power-sequenced workflow
+type PowerSequencedParam struct {
+	// AgentSystemID is the system_id of the Agent performing power actions
+	AgentSystemID string `json:"agent_system_id"`
+	// Action is "on", "off", "cycle", "reset" or "query"
+	Action   string             `json:"action"`
+	Machines []SequencedMachine `json:"machines"`
+	// Concurrency is how many actions of machines sharing a chassis run at
+	// the same time, one (serialized) by default
+	Concurrency int `json:"concurrency,omitempty"`
+	// Stagger in seconds is the least time between starting actions of
+	// machines sharing a chassis
+	Stagger int `json:"stagger,omitempty"`
+}
+
+// SequencedMachineResult is the outcome of the power action of a machine
+type SequencedMachineResult struct {
+	SystemID string `json:"system_id"`
+	// Chassis is the BMC address shared by machines, which actions were
+	// sequenced
+	Chassis string `json:"chassis"`
+	State   string `json:"state,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// ErrorCode is the code of Error, e.g. "POWER_AUTH_FAILED"
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// PowerSequencedResult is the result of power-sequenced workflow
+type PowerSequencedResult struct {
+	// Machines are results in order of machines of the parameter
+	Machines []SequencedMachineResult `json:"machines"`
+	Success  bool                     `json:"success"`
+}
+
+// sequencedActions are activities and their parameters of power actions of
+// power-sequenced workflow
+var sequencedActions = map[string]struct {
+	activity string
+	param    func(PowerParam) interface{}
+}{
+	"on":    {"power-on", func(p PowerParam) interface{} { return PowerOnParam{PowerParam: p} }},
+	"off":   {"power-off", func(p PowerParam) interface{} { return PowerOffParam{PowerParam: p} }},
+	"cycle": {"power-cycle", func(p PowerParam) interface{} { return PowerCycleParam{PowerParam: p} }},
+	"reset": {"power-reset", func(p PowerParam) interface{} { return PowerResetParam{PowerParam: p} }},
+	"query": {"power-query", func(p PowerParam) interface{} { return PowerQueryParam{PowerParam: p} }},
+}
+
+// chassisKey returns the chassis of the machine: the BMC address it shares
+// with other machines of the driver type (e.g. blades of an enclosure, or
+// nodes of a Moonshot chassis). Machines without address have a chassis of
+// their own.
+func chassisKey(m SequencedMachine) string {
+	address := strings.ToLower(strings.TrimSpace(stringOpt(m.DriverOpts, "power_address")))
+	if address == "" {
+		return "machine:" + m.SystemID
+	}
+
+	if _, host, ok := strings.Cut(address, "://"); ok {
+		address = host
+	}
+
+	address, _, _ = strings.Cut(address, "/")
+
+	return m.DriverType + ":" + address
+}
+
+// sequencedActionContext runs power actions on the Agent, without retrying
+// them, as the power service retries them itself
+func sequencedActionContext(ctx tworkflow.Context, systemID string) tworkflow.Context {
+	return tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:           fmt.Sprintf("%s@agent:power", systemID),
+		StartToCloseTimeout: sequencedActionTimeout,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 1,
+		},
+	})
+}
+
+// powerSequenced performs the power action of machines, so machines sharing
+// a chassis don't overload its manager: actions of a chassis run a few at a
+// time (Concurrency) and start at least Stagger apart, while chassis are
+// handled in parallel. A failed action doesn't stop actions of other
+// machines of the chassis.
+func (s *PowerService) powerSequenced(ctx tworkflow.Context,
+	param PowerSequencedParam) (*PowerSequencedResult, error) {
+	log := tworkflow.GetLogger(ctx)
+
+	action, ok := sequencedActions[param.Action]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedPowerAction, param.Action)
+	}
+
+	concurrency := param.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	stagger := time.Duration(param.Stagger) * time.Second
+
+	result := &PowerSequencedResult{
+		Machines: make([]SequencedMachineResult, len(param.Machines)),
+		Success:  true,
+	}
+
+	var chassis []string
+
+	members := make(map[string][]int)
+
+	for i, m := range param.Machines {
+		key := chassisKey(m)
+		if _, ok := members[key]; !ok {
+			chassis = append(chassis, key)
+		}
+
+		members[key] = append(members[key], i)
+		result.Machines[i] = SequencedMachineResult{SystemID: m.SystemID, Chassis: key}
+	}
+
+	wg := tworkflow.NewWaitGroup(ctx)
+
+	for _, key := range chassis {
+		indexes := members[key]
+
+		wg.Add(1)
+
+		tworkflow.Go(ctx, func(ctx tworkflow.Context) {
+			defer wg.Done()
+
+			var (
+				inflight []int
+				futures  = make(map[int]tworkflow.Future, len(indexes))
+			)
+
+			wait := func(i int) {
+				var res struct {
+					State string `json:"state"`
+				}
+
+				if err := futures[i].Get(ctx, &res); err != nil {
+					result.Machines[i].Error, result.Machines[i].ErrorCode = err.Error(), errcode.Of(err)
+					return
+				}
+
+				result.Machines[i].State = res.State
+			}
+
+			for n, i := range indexes {
+				if len(inflight) == concurrency {
+					wait(inflight[0])
+					inflight = inflight[1:]
+				}
+
+				if n > 0 && stagger > 0 {
+					if err := tworkflow.Sleep(ctx, stagger); err != nil {
+						break
+					}
+				}
+
+				futures[i] = tworkflow.ExecuteActivity(sequencedActionContext(ctx, param.AgentSystemID),
+					action.activity, action.param(param.Machines[i].PowerParam))
+				inflight = append(inflight, i)
+			}
+
+			for _, i := range inflight {
+				wait(i)
+			}
+
+			// Actions not started before the workflow was cancelled
+			for _, i := range indexes {
+				if _, ok := futures[i]; !ok && ctx.Err() != nil {
+					result.Machines[i].Error = ctx.Err().Error()
+				}
+			}
+		})
+	}
+
+	wg.Wait(ctx)
+
+	for _, m := range result.Machines {
+		if m.Error != "" {
+			result.Success = false
+		}
+	}
+
+	log.Info("Sequenced power action finished", tag.Builder().
+		KV("action", param.Action).
+		KV("chassis", len(chassis)).
+		KV("success", result.Success).KeyVals...)
+
+	return result, nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+)
+
+func sequencedMachine(systemID, driverType, address string) SequencedMachine {
+	opts := map[string]interface{}{"power_id": systemID}
+	if address != "" {
+		opts["power_address"] = address
+	}
+
+	return SequencedMachine{
+		SystemID:   systemID,
+		PowerParam: PowerParam{DriverType: driverType, DriverOpts: opts},
+	}
+}
+
+func TestChassisKey(t *testing.T) {
+	testcases := map[string]struct {
+		machine SequencedMachine
+		key     string
+	}{
+		"address": {
+			machine: sequencedMachine("a", "moonshot", "10.0.0.1"),
+			key:     "moonshot:10.0.0.1",
+		},
+		"url": {
+			machine: sequencedMachine("a", "redfish", "https://BMC.example.com/redfish"),
+			key:     "redfish:bmc.example.com",
+		},
+		"no address": {
+			machine: sequencedMachine("a", "webhook", ""),
+			key:     "machine:a",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.key, chassisKey(tc.machine))
+		})
+	}
+}
+
+func TestPowerSequenced(t *testing.T) {
+	testcases := map[string]struct {
+		concurrency int
+		stagger     int
+		// maxInflight is the most actions of the chassis running together
+		maxInflight int
+	}{
+		"serialized": {
+			maxInflight: 1,
+		},
+		"concurrent": {
+			concurrency: 2,
+			maxInflight: 2,
+		},
+		"staggered": {
+			concurrency: 3,
+			stagger:     30,
+			maxInflight: 1,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			svc := NewPowerService("agent", nil)
+			env := newTestWorkflowEnvironment(svc)
+
+			env.RegisterActivityWithOptions(svc.PowerCycle, activity.RegisterOptions{Name: "power-cycle"})
+
+			var (
+				mutex    sync.Mutex
+				inflight = make(map[string]int)
+				most     = make(map[string]int)
+				started  []time.Time
+			)
+
+			env.OnActivity("power-cycle", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, p PowerCycleParam) (*PowerCycleResult, error) {
+					address := stringOpt(p.DriverOpts, "power_address")
+
+					mutex.Lock()
+					inflight[address]++
+					most[address] = max(most[address], inflight[address])
+
+					if address == "10.0.0.1" {
+						started = append(started, env.Now())
+					}
+					mutex.Unlock()
+
+					// Let other actions of the chassis start, if allowed
+					time.Sleep(50 * time.Millisecond)
+
+					mutex.Lock()
+					inflight[address]--
+					mutex.Unlock()
+
+					if stringOpt(p.DriverOpts, "power_id") == "a2" {
+						return nil, errors.New("node is not responding")
+					}
+
+					return &PowerCycleResult{State: "on"}, nil
+				})
+
+			env.ExecuteWorkflow(svc.powerSequenced, PowerSequencedParam{
+				AgentSystemID: "agent",
+				Action:        "cycle",
+				Machines: []SequencedMachine{
+					sequencedMachine("a1", "moonshot", "10.0.0.1"),
+					sequencedMachine("b1", "moonshot", "10.0.0.2"),
+					sequencedMachine("a2", "moonshot", "10.0.0.1"),
+					sequencedMachine("a3", "moonshot", "10.0.0.1"),
+				},
+				Concurrency: tc.concurrency,
+				Stagger:     tc.stagger,
+			})
+
+			require.True(t, env.IsWorkflowCompleted())
+			require.NoError(t, env.GetWorkflowError())
+
+			var result PowerSequencedResult
+			require.NoError(t, env.GetWorkflowResult(&result))
+
+			assert.False(t, result.Success)
+			assert.Contains(t, result.Machines[2].Error, "node is not responding")
+
+			result.Machines[2].Error = ""
+
+			assert.Equal(t, []SequencedMachineResult{
+				{SystemID: "a1", Chassis: "moonshot:10.0.0.1", State: "on"},
+				{SystemID: "b1", Chassis: "moonshot:10.0.0.2", State: "on"},
+				{SystemID: "a2", Chassis: "moonshot:10.0.0.1"},
+				{SystemID: "a3", Chassis: "moonshot:10.0.0.1", State: "on"},
+			}, result.Machines)
+
+			assert.Equal(t, tc.maxInflight, most["10.0.0.1"])
+
+			require.Len(t, started, 3)
+
+			for i := 1; i < len(started); i++ {
+				assert.GreaterOrEqual(t, started[i].Sub(started[i-1]), time.Duration(tc.stagger)*time.Second)
+			}
+		})
+	}
+}
+
+func TestPowerSequencedUnsupportedAction(t *testing.T) {
+	svc := NewPowerService("agent", nil)
+	env := newTestWorkflowEnvironment(svc)
+
+	env.ExecuteWorkflow(svc.powerSequenced, PowerSequencedParam{Action: "soft-off"})
+
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), ErrUnsupportedPowerAction.Error())
+}
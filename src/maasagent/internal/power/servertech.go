@@ -0,0 +1,79 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DriverServerTech is the power driver of ServerTech Sentry PDUs. Outlets
+// are switched by the Agent directly with SNMP.
+const DriverServerTech = "servertech"
+
+const (
+	// defaultServerTechCommunity is the community of SNMPv1 and SNMPv2c
+	// requests, the default write community of Sentry PDUs
+	defaultServerTechCommunity = "private"
+	// serverTechOutletStatus and serverTechOutletControl are columns of
+	// outletTable of Sentry3-MIB, indexed by tower, infeed and outlet
+	serverTechOutletStatus  = "1.3.6.1.4.1.1718.3.2.3.1.10"
+	serverTechOutletControl = "1.3.6.1.4.1.1718.3.2.3.1.11"
+)
+
+// serverTechOutlets maps outlets to Sentry3-MIB. Outlets are identified
+// as on the PDU, by the tower, infeed and outlet number (e.g. "AA1" or
+// "BA12"), or only the outlet number of the first infeed of the first
+// tower (e.g. "1").
+var serverTechOutlets = snmpOutletMap{
+	oids: func(outlet string) (string, string, error) {
+		index, err := serverTechOutletIndex(outlet)
+		if err != nil {
+			return "", "", err
+		}
+
+		return serverTechOutletStatus + "." + index, serverTechOutletControl + "." + index, nil
+	},
+	// outletStatus also reports pending (offWait, onWait), failed and
+	// fixed states
+	states: map[int64]string{0: "off", 1: "on", 8: "off", 9: "on"},
+	on:     1,
+	off:    2,
+}
+
+// serverTechOutletIndex returns the index of outletTable of the outlet
+func serverTechOutletIndex(outlet string) (string, error) {
+	tower, infeed, number := "A", "A", strings.ToUpper(outlet)
+
+	if len(number) > 2 && number[0] >= 'A' && number[0] <= 'Z' && number[1] >= 'A' && number[1] <= 'Z' {
+		tower, infeed, number = number[:1], number[1:2], number[2:]
+	}
+
+	n, err := pduOutletNumber(number)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidOutlet, outlet)
+	}
+
+	return strconv.Itoa(int(tower[0]-'A'+1)) + "." + strconv.Itoa(int(infeed[0]-'A'+1)) + "." +
+		strconv.Itoa(n), nil
+}
+
+// dialServerTech returns pduClient of the ServerTech PDU
+func dialServerTech(ctx context.Context, opts map[string]interface{}) (pduClient, error) {
+	return dialSNMPPDU(ctx, opts, serverTechOutlets, defaultServerTechCommunity)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerTechOutletIndex(t *testing.T) {
+	testcases := map[string]struct {
+		outlet string
+		index  string
+		err    error
+	}{
+		"outlet number": {
+			outlet: "5",
+			index:  "1.1.5",
+		},
+		"tower and infeed": {
+			outlet: "BA12",
+			index:  "2.1.12",
+		},
+		"lower case": {
+			outlet: "ab3",
+			index:  "1.2.3",
+		},
+		"missing outlet number": {
+			outlet: "AB",
+			err:    ErrInvalidOutlet,
+		},
+		"outlet 0": {
+			outlet: "AA0",
+			err:    ErrInvalidOutlet,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			index, err := serverTechOutletIndex(tc.outlet)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.index, index)
+		})
+	}
+}
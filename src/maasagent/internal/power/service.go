@@ -21,6 +21,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"net/url"
 	"os"
 	"os/exec"
 	"reflect"
@@ -28,38 +30,238 @@ import (
 	"time"
 
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
 	tworker "go.temporal.io/sdk/worker"
 	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
 	"maas.io/core/src/maasagent/internal/workflow/log/tag"
 	"maas.io/core/src/maasagent/internal/workflow/worker"
 )
 
 const powerServiceWorkerPoolGroup = "power-service"
 
+const (
+	// powerOffModeSoft is the power_off_mode driver option of machines and
+	// VMs, which OS should be shut down before power is removed
+	powerOffModeSoft = "soft"
+	// defaultSoftOffTimeout is how long the OS can take to shut down
+	defaultSoftOffTimeout = 2 * time.Minute
+)
+
+// defaultDriverTimeouts limit commands of driver types, which are much
+// slower or faster than others. Chassis managers can take minutes to power
+// on a cartridge, an HMC to activate an LPAR, and Nova to shut down an
+// instance gracefully, while VM hosts respond within milliseconds.
+// Timeouts are below the 5 minutes the Region allows for power activities,
+// so a slow command fails with its own error and leaves time for a retry.
+var defaultDriverTimeouts = map[string]time.Duration{
+	"moonshot": 4 * time.Minute,
+	"hmc":      4 * time.Minute,
+	"mscm":     4 * time.Minute,
+	"nova":     3 * time.Minute,
+	"lxd":      20 * time.Second,
+	"virsh":    20 * time.Second,
+}
+
 var (
 	// ErrWrongPowerState is an error for when a power action executes
 	// and the machine is found in an incorrect power state
-	ErrWrongPowerState = errors.New("BMC is in the wrong power state")
+	ErrWrongPowerState = errcode.New(errcode.PowerWrongState, "BMC is in the wrong power state")
+	// ErrPowerCLIUnavailable is returned for power actions of driver types
+	// without native driver, when the MAAS power CLI is not installed
+	// (e.g. on Windows)
+	ErrPowerCLIUnavailable = errcode.New(errcode.PowerCLIUnavailable, "MAAS power CLI is not available")
+	// ErrUnsupportedBootDevice is returned when boot device is unknown or
+	// not supported by the BMC
+	ErrUnsupportedBootDevice = errcode.New(errcode.PowerUnsupported, "unsupported boot device")
 )
 
 // PowerService is a service that knows how to reach BMC to perform power
 // operations. Invocation of this service normally should happen via Temporal.
 type PowerService struct {
-	pool *worker.WorkerPool
+	pool           *worker.WorkerPool
+	batcher        *queryBatcher
+	lxdMembers     *lxdMemberCache
+	hosts          map[string]batchDriver
+	drivers        *DriverRegistry
+	builtin        map[string]PowerDriver
+	guests         map[string]guestDialer
+	retryStats     *retryStats
+	schedules      client.ScheduleClient
+	systemID       string
+	retryPolicy    RetryPolicy
+	driverRetry    map[string]RetryPolicy
+	driverTimeouts map[string]time.Duration
+	commandTimeout time.Duration
+	softOffTimeout time.Duration
+	statsInterval  time.Duration
+	sweepInterval  time.Duration
+	concurrency    int
 }
 
-func NewPowerService(systemID string, pool *worker.WorkerPool) *PowerService {
-	return &PowerService{
-		pool: pool,
+// PowerServiceOption allows to set additional PowerService options
+type PowerServiceOption func(*PowerService)
+
+func NewPowerService(systemID string, pool *worker.WorkerPool,
+	options ...PowerServiceOption) *PowerService {
+	lxdMembers := newLXDMemberCache()
+	hosts := newHostListers(lxdMembers)
+	vmwareSessions := newVMwareSessionCache()
+
+	s := &PowerService{
+		pool:           pool,
+		batcher:        newQueryBatcher(defaultQueryBatchWindow, hosts),
+		lxdMembers:     lxdMembers,
+		hosts:          hosts,
+		drivers:        defaultDrivers(),
+		builtin:        builtinDrivers(lxdMembers, vmwareSessions),
+		guests:         newGuestDialers(lxdMembers, vmwareSessions),
+		retryStats:     newRetryStats(time.Now()),
+		systemID:       systemID,
+		driverRetry:    make(map[string]RetryPolicy),
+		driverTimeouts: maps.Clone(defaultDriverTimeouts),
+		softOffTimeout: defaultSoftOffTimeout,
+		statsInterval:  defaultRetryStatsInterval,
+		sweepInterval:  defaultPowerSweepInterval,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithQueryBatchWindow sets how long power queries of VMs living on the
+// same virtualization host are collected before a single query listing
+// all of them is made. Zero disables batching.
+func WithQueryBatchWindow(d time.Duration) PowerServiceOption {
+	return func(s *PowerService) {
+		if d <= 0 {
+			s.batcher = nil
+			return
+		}
+
+		s.batcher = newQueryBatcher(d, s.hosts)
+	}
+}
+
+// WithDriver registers d to perform power actions of driverType natively,
+// instead of the MAAS power CLI or the driver built into the Agent.
+func WithDriver(driverType string, d PowerDriver) PowerServiceOption {
+	return func(s *PowerService) {
+		s.drivers.Register(driverType, d)
+	}
+}
+
+// WithNativeDrivers makes drivers built into the Agent perform power actions
+// of driverTypes, instead of the MAAS power CLI. Driver types without a
+// built-in driver are ignored. (default: none)
+func WithNativeDrivers(driverTypes ...string) PowerServiceOption {
+	return func(s *PowerService) {
+		for _, driverType := range driverTypes {
+			if d, ok := s.builtin[driverType]; ok {
+				s.drivers.Register(driverType, d)
+			}
+		}
+	}
+}
+
+// Drivers returns driver types of power actions performed natively
+func (s *PowerService) Drivers() []string {
+	return s.drivers.Drivers()
+}
+
+// WithRetryPolicy sets how power actions are retried, unless there is a
+// policy for the driver type. (default: a single attempt)
+func WithRetryPolicy(p RetryPolicy) PowerServiceOption {
+	return func(s *PowerService) {
+		s.retryPolicy = p
+	}
+}
+
+// WithDriverRetryPolicy sets how power actions of driverType are retried
+func WithDriverRetryPolicy(driverType string, p RetryPolicy) PowerServiceOption {
+	return func(s *PowerService) {
+		s.driverRetry[driverType] = p
+	}
+}
+
+// WithCommandTimeout limits how long a single power driver command can run,
+// unless the driver type has its own timeout. Zero means no limit other
+// than the activity timeout. (default: 0)
+func WithCommandTimeout(d time.Duration) PowerServiceOption {
+	return func(s *PowerService) {
+		s.commandTimeout = d
+	}
+}
+
+// WithDriverTimeout limits how long a single command of driverType can run.
+// Zero removes the default timeout of the driver type.
+func WithDriverTimeout(driverType string, d time.Duration) PowerServiceOption {
+	return func(s *PowerService) {
+		if d <= 0 {
+			delete(s.driverTimeouts, driverType)
+			return
+		}
+
+		s.driverTimeouts[driverType] = d
+	}
+}
+
+// WithSoftOffTimeout sets how long machines with "soft" power_off_mode can
+// take to shut down before they are powered off forcibly. Zero powers them
+// off forcibly right away. (default: 2 minutes)
+func WithSoftOffTimeout(d time.Duration) PowerServiceOption {
+	return func(s *PowerService) {
+		s.softOffTimeout = max(d, 0)
+	}
+}
+
+// WithConcurrency sets the maximum number of power activities executed
+// at once by each power worker. Zero keeps the Temporal default.
+func WithConcurrency(n int) PowerServiceOption {
+	return func(s *PowerService) {
+		s.concurrency = n
+	}
+}
+
+// WithProbeTimeout sets how long TCP connect probes of LXD cluster
+// members can take. (default: 2 seconds)
+func WithProbeTimeout(d time.Duration) PowerServiceOption {
+	return func(s *PowerService) {
+		if d > 0 {
+			s.lxdMembers.probeTimeout = d
+		}
 	}
 }
 
 func (s *PowerService) ConfigurationWorkflows() map[string]interface{} {
-	return map[string]interface{}{"configure-power-service": s.configure}
+	return map[string]interface{}{
+		"configure-power-service": s.configure,
+		"reconcile-power-states":  s.reconcilePowerStates,
+		"watch-power-transition":  s.watchPowerTransition,
+		"smoke-test-rack":         s.smokeTestRack,
+		"synthetic-power":         s.syntheticPower,
+		"enforce-boot-order":      s.enforceBootOrder,
+		"report-retry-stats":      s.reportRetryStats,
+		"power-query-host":        s.powerQueryHost,
+		"power-on-ordered":        s.powerOnOrdered,
+		"power-sequenced":         s.powerSequenced,
+		"rolling-power-cycle":     s.rollingPowerCycle,
+		"schedule-power-actions":  s.schedulePowerActions,
+		"list-power-schedules":    s.listPowerSchedules,
+		"cancel-power-schedules":  s.cancelPowerSchedules,
+	}
 }
 
 func (s *PowerService) ConfigurationActivities() map[string]interface{} {
-	return map[string]interface{}{}
+	return map[string]interface{}{
+		// Temporal Schedules are managed by the Agent, not by power workers
+		"ensure-power-schedule":    s.EnsurePowerSchedule,
+		"describe-power-schedules": s.DescribePowerSchedules,
+		"delete-power-schedule":    s.DeletePowerSchedule,
+	}
 }
 
 func (s *PowerService) configure(ctx tworkflow.Context, systemID string) error {
@@ -98,7 +300,25 @@ func (s *PowerService) configure(ctx tworkflow.Context, systemID string) error {
 		"power-off":      s.PowerOff,
 		"power-query":    s.PowerQuery,
 		"power-cycle":    s.PowerCycle,
+		"power-reset":    s.PowerReset,
 		"set-boot-order": s.SetBootOrder,
+		// Persistent boot order is compared with a policy and then applied
+		"get-persistent-boot-order":   s.GetBootOrder,
+		"apply-persistent-boot-order": s.ApplyBootOrder,
+		// Boot order before deployment is restored once it is deployed
+		"record-boot-order":  s.RecordBootOrder,
+		"restore-boot-order": s.RestoreBootOrder,
+		// Devices of the next boot are set before power cycle on deployment
+		"set-boot-device": s.SetBootDevice,
+		// PXE-less provisioning for networks without DHCP and TFTP
+		"set-boot-from-url":   s.SetBootFromURL,
+		"eject-virtual-media": s.EjectVirtualMedia,
+		// Members are queried from the Agent that can reach the LXD host
+		"get-lxd-cluster-members": s.GetLXDClusterMembers,
+		// States of all VMs of a host are listed at once by VM host refresh
+		"query-host-power-states": s.QueryHostPowerStates,
+		// Composite workflows check what the driver supports beforehand
+		"get-power-capabilities": s.GetPowerCapabilities,
 	}
 
 	// TODO: register workflows once they are moved to the Agent
@@ -109,7 +329,7 @@ func (s *PowerService) configure(ctx tworkflow.Context, systemID string) error {
 	for _, vlan := range vlansResult.VLANs {
 		taskQueue := fmt.Sprintf("agent:power@vlan-%d", vlan)
 		if err := s.pool.AddWorker(powerServiceWorkerPoolGroup, taskQueue,
-			workflows, activities, tworker.Options{}); err != nil {
+			workflows, activities, s.workerOptions()); err != nil {
 			s.pool.RemoveWorkers(powerServiceWorkerPoolGroup)
 			return err
 		}
@@ -117,7 +337,7 @@ func (s *PowerService) configure(ctx tworkflow.Context, systemID string) error {
 
 	taskQueue := fmt.Sprintf("%s@agent:power", systemID)
 	if err := s.pool.AddWorker(powerServiceWorkerPoolGroup, taskQueue,
-		nil, activities, tworker.Options{}); err != nil {
+		nil, activities, s.workerOptions()); err != nil {
 		s.pool.RemoveWorkers(powerServiceWorkerPoolGroup)
 		return err
 	}
@@ -127,10 +347,34 @@ func (s *PowerService) configure(ctx tworkflow.Context, systemID string) error {
 	return nil
 }
 
+func (s *PowerService) workerOptions() tworker.Options {
+	return tworker.Options{
+		MaxConcurrentActivityExecutionSize: s.concurrency,
+	}
+}
+
+// Firmware boot modes of machines
+const (
+	FirmwareUEFI   = "uefi"
+	FirmwareLegacy = "legacy"
+)
+
+// bootModeOpt is the driver option native drivers read the firmware boot
+// mode of the machine from. It is not passed to the MAAS power CLI.
+const bootModeOpt = "boot_mode"
+
 // PowerParam is a generic activity parameter for power management of a host
 type PowerParam struct {
 	DriverOpts map[string]interface{} `json:"driver_opts"`
 	DriverType string                 `json:"driver_type"`
+	// BootMode is the firmware boot mode of the machine (FirmwareUEFI or
+	// FirmwareLegacy), which boot source overrides are applied with.
+	// Empty leaves the mode to the BMC.
+	BootMode string `json:"boot_mode,omitempty"`
+	// Timeout in seconds of a single attempt of the power action, overrides
+	// the timeout of the driver type
+	Timeout int `json:"timeout,omitempty"`
+	RequestMetadata
 }
 
 // PowerOnParam is the activity parameter for power management of a host
@@ -138,9 +382,29 @@ type PowerOnParam struct {
 	PowerParam
 }
 
+// PowerDetails is the state of a host reported in addition to the power
+// state by drivers talking to BMCs directly (e.g. Redfish). Fields are
+// empty when not known.
+type PowerDetails struct {
+	// Health is the health of the host as reported by the BMC
+	// (e.g. "OK", "Warning" or "Critical")
+	Health string `json:"health,omitempty"`
+	// BootMode is the firmware boot mode (e.g. "UEFI" or "Legacy")
+	BootMode string `json:"boot_mode,omitempty"`
+	// Shutdown is how the host was powered off by soft power off
+	// (ShutdownGraceful or ShutdownForced)
+	Shutdown string `json:"shutdown,omitempty"`
+	// Status is the status of the VM as reported by its hypervisor
+	// (e.g. "Running" or "Frozen" for LXD)
+	Status string `json:"status,omitempty"`
+}
+
 // PowerOnResult is the result of power action
 type PowerOnResult struct {
 	State string `json:"state"`
+	PowerDetails
+	// Request is metadata of the request of the power action, if any
+	Request *RequestMetadata `json:"request,omitempty"`
 }
 
 // PowerOffParam is the activity parameter for power management of a host
@@ -151,6 +415,9 @@ type PowerOffParam struct {
 // PowerOffResult is the result of power action
 type PowerOffResult struct {
 	State string `json:"state"`
+	PowerDetails
+	// Request is metadata of the request of the power action, if any
+	Request *RequestMetadata `json:"request,omitempty"`
 }
 
 // PowerCycleParam is the activity parameter for power management of a host
@@ -161,6 +428,22 @@ type PowerCycleParam struct {
 // PowerCycleResult is the result of power action
 type PowerCycleResult struct {
 	State string `json:"state"`
+	PowerDetails
+	// Request is metadata of the request of the power action, if any
+	Request *RequestMetadata `json:"request,omitempty"`
+}
+
+// PowerResetParam is the activity parameter for power management of a host
+type PowerResetParam struct {
+	PowerParam
+}
+
+// PowerResetResult is the result of power action
+type PowerResetResult struct {
+	State string `json:"state"`
+	PowerDetails
+	// Request is metadata of the request of the power action, if any
+	Request *RequestMetadata `json:"request,omitempty"`
 }
 
 // PowerQueryParam is the activity parameter for power management of a host
@@ -171,60 +454,101 @@ type PowerQueryParam struct {
 // PowerQueryResult is the result of power action
 type PowerQueryResult struct {
 	State string `json:"state"`
+	PowerDetails
+	// Request is metadata of the request of the power action, if any
+	Request *RequestMetadata `json:"request,omitempty"`
 }
 
 func (s *PowerService) PowerOn(ctx context.Context, param PowerOnParam) (*PowerOnResult, error) {
-	out, err := powerCommand(ctx, "on", param.DriverType, param.DriverOpts)
+	out, details, err := s.power(ctx, "on", param.PowerParam)
 	if err != nil {
 		return nil, err
 	}
 
-	out = strings.TrimSpace(out)
-
 	if out != "on" {
 		return nil, ErrWrongPowerState
 	}
 
-	return &PowerOnResult{State: out}, nil
+	return &PowerOnResult{
+		State:        out,
+		PowerDetails: details,
+		Request:      param.RequestMetadata.echo(),
+	}, nil
 }
 func (s *PowerService) PowerOff(ctx context.Context, param PowerOffParam) (*PowerOffResult, error) {
-	out, err := powerCommand(ctx, "off", param.DriverType, param.DriverOpts)
+	out, details, err := s.power(ctx, "off", param.PowerParam)
 	if err != nil {
 		return nil, err
 	}
 
-	out = strings.TrimSpace(out)
-
 	if out != "off" {
 		return nil, ErrWrongPowerState
 	}
 
-	return &PowerOffResult{State: out}, nil
+	return &PowerOffResult{
+		State:        out,
+		PowerDetails: details,
+		Request:      param.RequestMetadata.echo(),
+	}, nil
 }
 func (s *PowerService) PowerCycle(ctx context.Context, param PowerCycleParam) (*PowerCycleResult, error) {
-	out, err := powerCommand(ctx, "cycle", param.DriverType, param.DriverOpts)
+	out, details, err := s.power(ctx, "cycle", param.PowerParam)
 	if err != nil {
 		return nil, err
 	}
 
-	out = strings.TrimSpace(out)
+	if out != "on" {
+		return nil, ErrWrongPowerState
+	}
+
+	return &PowerCycleResult{
+		State:        out,
+		PowerDetails: details,
+		Request:      param.RequestMetadata.echo(),
+	}, nil
+}
+
+// PowerReset hard resets the host, keeping power supplied, which is only
+// supported by native power drivers.
+func (s *PowerService) PowerReset(ctx context.Context, param PowerResetParam) (*PowerResetResult, error) {
+	out, details, err := s.power(ctx, "reset", param.PowerParam)
+	if err != nil {
+		return nil, err
+	}
 
 	if out != "on" {
 		return nil, ErrWrongPowerState
 	}
 
-	return &PowerCycleResult{State: out}, nil
+	return &PowerResetResult{
+		State:        out,
+		PowerDetails: details,
+		Request:      param.RequestMetadata.echo(),
+	}, nil
 }
 
 func (s *PowerService) PowerQuery(ctx context.Context, param PowerQueryParam) (*PowerQueryResult, error) {
-	out, err := powerCommand(ctx, "status", param.DriverType, param.DriverOpts)
+	if s.batcher != nil && s.drivers.registered(param.DriverType) {
+		state, ok, err := s.batcher.query(ctx, param.DriverType, param.DriverOpts)
+		if ok {
+			if err != nil {
+				return nil, err
+			}
+
+			return &PowerQueryResult{State: state, Request: param.RequestMetadata.echo()}, nil
+		}
+	}
+
+	out, details, err := s.power(ctx, "status", param.PowerParam)
 	if err != nil {
 		return nil, err
 	}
 
-	out = strings.TrimSpace(out)
-
-	return &PowerQueryResult{State: out}, nil
+	return &PowerQueryResult{
+		State:        out,
+		PowerDetails: details,
+		Request:      param.RequestMetadata.echo(),
+	}, nil
 }
 
 type SetBootOrderParam struct {
@@ -238,20 +562,453 @@ func (s *PowerService) SetBootOrder(ctx context.Context, param SetBootOrderParam
 
 	log.Info("setting boot order of " + param.SystemID)
 
-	_, err := powerCommand(ctx, "set-boot-order", param.PowerParams.DriverType, param.PowerParams.DriverOpts)
+	_, err := s.powerCommand(ctx, "set-boot-order", param.PowerParams,
+		s.driverOpts(ctx, param.PowerParams.DriverType, param.PowerParams.DriverOpts))
 
 	return err
 }
 
-func powerCommand(ctx context.Context, action, driver string, opts map[string]interface{}, bootOrder ...map[string]interface{}) (string, error) {
+// Devices of the next boot set by set-boot-device
+const (
+	BootDevicePXE  = "pxe"
+	BootDeviceDisk = "disk"
+	BootDeviceCD   = "cd"
+)
+
+// SetBootDeviceParam is the activity parameter for set-boot-device
+type SetBootDeviceParam struct {
+	PowerParam
+	// Device is one of BootDevicePXE, BootDeviceDisk or BootDeviceCD
+	Device string `json:"device"`
+}
+
+// SetBootDevice sets the device the machine boots from next, so it is
+// guaranteed to netboot (or boot from disk) when power cycled afterwards.
+// Drivers of the MAAS power CLI have no such action and rely on their own
+// boot order, so the boot device of their machines is left as it is.
+func (s *PowerService) SetBootDevice(ctx context.Context, param SetBootDeviceParam) error {
+	log := activity.GetLogger(ctx)
+
+	switch param.Device {
+	case BootDevicePXE, BootDeviceDisk, BootDeviceCD:
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedBootDevice, param.Device)
+	}
+
+	opts, err := withBootMode(s.driverOpts(ctx, param.DriverType, param.DriverOpts), param.BootMode)
+	if err != nil {
+		return err
+	}
+
+	d, ok := s.drivers.Lookup(param.DriverType, opts)
+	if !ok {
+		log.Warn("Boot device is not set by the power driver",
+			tag.Builder().KV("driver", param.DriverType).KV("device", param.Device).KeyVals...)
+
+		return nil
+	}
+
+	b, ok := d.(BootDevicePowerDriver)
+	if !ok {
+		return fmt.Errorf("%w: %q with %s driver", ErrUnsupportedBootDevice, param.Device, param.DriverType)
+	}
+
+	ctx, cancel := s.commandContext(ctx, param.PowerParam)
+	defer cancel()
+
+	log.Info("Setting boot device", tag.Builder().KV("device", param.Device).KeyVals...)
+
+	return b.SetBootDevice(ctx, opts, param.Device)
+}
+
+// GetLXDClusterMembersParam is the activity parameter for get-lxd-cluster-members
+type GetLXDClusterMembersParam struct {
+	PowerParam
+}
+
+// GetLXDClusterMembersResult is the result of get-lxd-cluster-members.
+// Members are empty if the LXD host is not clustered.
+type GetLXDClusterMembersResult struct {
+	Members []LXDClusterMember `json:"members"`
+}
+
+// GetLXDClusterMembers returns members of the LXD cluster with their status
+// and capacity, so the Region can decide where to compose VMs.
+func (s *PowerService) GetLXDClusterMembers(ctx context.Context,
+	param GetLXDClusterMembersParam) (*GetLXDClusterMembersResult, error) {
+	log := activity.GetLogger(ctx)
+
+	c, err := dialLXDCluster(ctx, param.DriverOpts, s.lxdMembers)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer c.Close()
+
+	members, err := c.clusterMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range members {
+		if members[i].Status != lxdMemberOnline {
+			continue
+		}
+
+		// Member might have gone offline in the meantime, which is not
+		// a reason to fail reporting the others.
+		if err := c.memberResources(ctx, &members[i]); err != nil {
+			log.Warn("Failed to get LXD cluster member resources",
+				tag.Builder().KV("member", members[i].Name).Error(err).KeyVals...)
+		}
+	}
+
+	return &GetLXDClusterMembersResult{Members: members}, nil
+}
+
+// SetBootFromURLParam is the activity parameter for set-boot-from-url
+type SetBootFromURLParam struct {
+	PowerParam
+	// Mode is either BootModeHTTP or BootModeVirtualMedia
+	Mode string `json:"mode"`
+	// URL of the EFI boot loader for BootModeHTTP or of the ISO image
+	// for BootModeVirtualMedia
+	URL string `json:"url"`
+}
+
+// SetBootFromURL makes the machine boot once from URL using Redfish, so it
+// can be deployed without DHCP and TFTP on the provisioning network.
+// The machine has to be power cycled afterwards.
+func (s *PowerService) SetBootFromURL(ctx context.Context, param SetBootFromURLParam) error {
 	log := activity.GetLogger(ctx)
 
+	if param.DriverType != DriverRedfish {
+		return fmt.Errorf("%w: %q driver", ErrUnsupportedBootMode, param.DriverType)
+	}
+
+	if u, err := url.Parse(param.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("%w: invalid URL %q", ErrUnsupportedBootMode, param.URL)
+	}
+
+	if _, err := withBootMode(param.DriverOpts, param.BootMode); err != nil {
+		return err
+	}
+
+	c, err := dialRedfish(param.DriverOpts)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer c.Close()
+
+	log.Info("Setting boot from URL",
+		tag.Builder().KV("mode", param.Mode).KV("url", param.URL).KeyVals...)
+
+	return c.bootFromURL(ctx, stringOpt(param.DriverOpts, "node_id"), param.Mode, param.URL, param.BootMode)
+}
+
+// EjectVirtualMediaParam is the activity parameter for eject-virtual-media
+type EjectVirtualMediaParam struct {
+	PowerParam
+}
+
+// EjectVirtualMedia ejects image inserted by SetBootFromURL, which should
+// be done once the machine is deployed.
+func (s *PowerService) EjectVirtualMedia(ctx context.Context, param EjectVirtualMediaParam) error {
+	if param.DriverType != DriverRedfish {
+		return fmt.Errorf("%w: %q driver", ErrUnsupportedBootMode, param.DriverType)
+	}
+
+	c, err := dialRedfish(param.DriverOpts)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer c.Close()
+
+	return c.ejectVirtualMedia(ctx, stringOpt(param.DriverOpts, "node_id"))
+}
+
+// driverOpts returns driver options for the power CLI. LXD forwards
+// instance operations to the member running the instance, so another
+// member of the cluster is used if the configured one is down.
+func (s *PowerService) driverOpts(ctx context.Context, driverType string,
+	opts map[string]interface{}) map[string]interface{} {
+	if driverType != "lxd" {
+		return opts
+	}
+
+	address := stringOpt(opts, "power_address")
+
+	reachable := s.lxdMembers.reachable(ctx, address)
+	if reachable == address {
+		return opts
+	}
+
+	result := make(map[string]interface{}, len(opts))
+	for k, v := range opts {
+		result[k] = v
+	}
+
+	result["power_address"] = reachable
+
+	return result
+}
+
+// withBootMode returns opts with the firmware boot mode of the machine for
+// native drivers, or ErrUnsupportedBootMode if the mode is unknown.
+func withBootMode(opts map[string]interface{}, mode string) (map[string]interface{}, error) {
+	switch mode {
+	case "":
+		return opts, nil
+	case FirmwareUEFI, FirmwareLegacy:
+	default:
+		return nil, fmt.Errorf("%w: firmware boot mode %q", ErrUnsupportedBootMode, mode)
+	}
+
+	result := make(map[string]interface{}, len(opts)+1)
+	for k, v := range opts {
+		result[k] = v
+	}
+
+	result[bootModeOpt] = mode
+
+	return result, nil
+}
+
+// power performs action ("on", "off", "cycle", "reset" or "status") and
+// returns the resulting power state. Actions are performed by the registered
+// driver, or by the MAAS power CLI if there is none. Failed actions are
+// retried according to the retry policy of the driver type, attempts are
+// recorded in retry statistics reported to the Region.
+func (s *PowerService) power(ctx context.Context, action string,
+	param PowerParam) (string, PowerDetails, error) {
+	type result struct {
+		state   string
+		details PowerDetails
+	}
+
+	ctx = withRequest(ctx, param.RequestMetadata)
+
+	if !param.RequestMetadata.empty() {
+		commandLogger(ctx).Info("Performing power action",
+			tag.Builder().KV("action", action).KV("driver", param.DriverType).KeyVals...)
+	}
+
+	policy := s.retryPolicyFor(param.DriverType)
+	attempts := 0
+
+	r, err := retry(ctx, policy,
+		func(ctx context.Context) (result, error) {
+			attempts++
+			state, details, err := s.powerOnce(ctx, action, param)
+			return result{state: state, details: details}, err
+		})
+
+	s.retryStats.record(param.DriverType, retryStatsMachine(param.DriverOpts), param.priority(), attempts,
+		err != nil && attempts >= max(policy.MaximumAttempts, 1))
+
+	return r.state, r.details, err
+}
+
+// retryPolicyFor returns the retry policy of power actions of driverType
+func (s *PowerService) retryPolicyFor(driverType string) RetryPolicy {
+	if p, ok := s.driverRetry[driverType]; ok {
+		return p
+	}
+
+	return s.retryPolicy
+}
+
+// powerOnce performs a single attempt of the power action. Guest OS of VMs
+// with "soft" power_off_mode is asked to shut down before they are powered
+// off.
+func (s *PowerService) powerOnce(ctx context.Context, action string,
+	param PowerParam) (string, PowerDetails, error) {
+	opts, err := withBootMode(s.driverOpts(ctx, param.DriverType, param.DriverOpts), param.BootMode)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	if action == "off" && stringOpt(opts, "power_off_mode") == powerOffModeSoft && s.softOffTimeout > 0 &&
+		s.drivers.registered(param.DriverType) {
+		if dial, ok := s.guests[param.DriverType]; ok {
+			return s.guestPowerOff(ctx, dial, param, opts)
+		}
+	}
+
+	return s.powerWith(ctx, action, param, opts)
+}
+
+// powerWith performs the power action with the registered driver, or the
+// MAAS power CLI if there is none. Actions the registered driver fails are
+// performed again by the MAAS power CLI, if it is installed, so native
+// drivers never leave machines without power control.
+func (s *PowerService) powerWith(ctx context.Context, action string,
+	param PowerParam, opts map[string]interface{}) (string, PowerDetails, error) {
+	d, ok := s.drivers.Lookup(param.DriverType, opts)
+	if !ok {
+		if action == "reset" {
+			return "", PowerDetails{}, fmt.Errorf("%w: %q with %s driver",
+				ErrUnsupportedPowerAction, action, param.DriverType)
+		}
+
+		// The MAAS power CLI doesn't verify pinned identities, it must
+		// not be trusted blindly instead
+		if pinned(opts) {
+			return "", PowerDetails{}, fmt.Errorf("%w: %s driver of the MAAS power CLI can't verify it",
+				ErrInvalidPin, param.DriverType)
+		}
+
+		out, err := s.powerCommand(ctx, action, param, opts)
+		return strings.TrimSpace(out), PowerDetails{}, err
+	}
+
+	state, details, err := s.powerNative(ctx, d, action, param, opts)
+	if err == nil || action == "reset" || param.DriverType == DriverSimulator || pinned(opts) || ctx.Err() != nil {
+		return state, details, err
+	}
+
+	commandLogger(ctx).Warn("Native power driver failed, falling back to the MAAS power CLI",
+		tag.Builder().KV("driver", param.DriverType).KV("action", action).Error(err).KeyVals...)
+
+	out, cliErr := s.powerCommand(ctx, action, param, opts)
+	if errors.Is(cliErr, ErrPowerCLIUnavailable) {
+		return "", PowerDetails{}, err
+	}
+
+	return strings.TrimSpace(out), PowerDetails{}, cliErr
+}
+
+// powerNative performs the power action with d, asking the OS to shut down
+// first for machines with "soft" power_off_mode
+func (s *PowerService) powerNative(ctx context.Context, d PowerDriver, action string,
+	param PowerParam, opts map[string]interface{}) (string, PowerDetails, error) {
+	if action == "off" && stringOpt(opts, "power_off_mode") == powerOffModeSoft && s.softOffTimeout > 0 {
+		if _, ok := d.(SoftOffPowerDriver); ok {
+			return s.softPowerOff(ctx, d, param, opts)
+		}
+	}
+
+	return s.runDriver(ctx, d, action, param, opts)
+}
+
+// runDriver performs action with d limited by the timeout of the power action
+func (s *PowerService) runDriver(ctx context.Context, d PowerDriver, action string,
+	param PowerParam, opts map[string]interface{}) (string, PowerDetails, error) {
+	ctx, cancel := s.commandContext(ctx, param)
+	defer cancel()
+
+	return runDriver(ctx, d, action, opts)
+}
+
+// softPowerOff asks the OS of the machine to shut down and waits for it
+// up to softOffTimeout. Machines which are still on after that, or which
+// BMC refuses soft-off, are powered off forcibly.
+func (s *PowerService) softPowerOff(ctx context.Context, d PowerDriver,
+	param PowerParam, opts map[string]interface{}) (string, PowerDetails, error) {
+	log := commandLogger(ctx)
+
+	state, details, err := s.runDriver(ctx, d, "soft-off", param, opts)
+	if errors.Is(err, ErrUnsupportedPowerAction) {
+		log.Warn("Soft power off is not supported, powering off forcibly",
+			tag.Builder().Error(err).KeyVals...)
+
+		return s.forcedPowerOff(ctx, d, param, opts)
+	}
+
+	if err != nil || state == "off" {
+		return state, details, err
+	}
+
+	timer := time.NewTimer(s.softOffTimeout)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", PowerDetails{}, ctx.Err()
+		case <-timer.C:
+			log.Warn("Machine did not shut down in time, powering off forcibly",
+				tag.Builder().KV("timeout", s.softOffTimeout).KeyVals...)
+
+			return s.forcedPowerOff(ctx, d, param, opts)
+		case <-ticker.C:
+		}
+
+		state, details, err = s.runDriver(ctx, d, "status", param, opts)
+		if err != nil || state == "off" {
+			if state == "off" {
+				details.Shutdown = ShutdownGraceful
+			}
+
+			return state, details, err
+		}
+	}
+}
+
+func (s *PowerService) forcedPowerOff(ctx context.Context, d PowerDriver,
+	param PowerParam, opts map[string]interface{}) (string, PowerDetails, error) {
+	state, details, err := s.runDriver(ctx, d, "off", param, opts)
+	details.Shutdown = ShutdownForced
+
+	return state, details, err
+}
+
+// powerCommand runs powerCommand limited by the timeout of the power action.
+func (s *PowerService) powerCommand(ctx context.Context, action string, param PowerParam,
+	opts map[string]interface{}, bootOrder ...map[string]interface{}) (string, error) {
+	ctx, cancel := s.commandContext(ctx, param)
+	defer cancel()
+
+	return powerCommand(ctx, action, param.DriverType, opts, bootOrder...)
+}
+
+// commandTimeoutFor returns how long a single command of the power action
+// can run. The timeout set by the Region takes precedence over the timeout
+// of the driver type.
+func (s *PowerService) commandTimeoutFor(param PowerParam) time.Duration {
+	if param.Timeout > 0 {
+		return time.Duration(param.Timeout) * time.Second
+	}
+
+	if d, ok := s.driverTimeouts[param.DriverType]; ok {
+		return d
+	}
+
+	return s.commandTimeout
+}
+
+// commandContext returns ctx limited by the timeout of the power action,
+// which ends before the deadline of the activity.
+func (s *PowerService) commandContext(ctx context.Context,
+	param PowerParam) (context.Context, context.CancelFunc) {
+	if timeout := attemptTimeout(ctx, s.commandTimeoutFor(param)); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+func powerCommand(ctx context.Context, action, driver string, opts map[string]interface{}, bootOrder ...map[string]interface{}) (string, error) {
+	if driver == DriverSimulator {
+		return powerSimulator.run(ctx, action, opts)
+	}
+
+	log := commandLogger(ctx)
+
 	maasPowerCLI, err := exec.LookPath(powerCLIExecutableName())
 
 	if err != nil {
 		log.Error("MAAS power CLI executable path lookup failure",
 			tag.Builder().Error(err).KeyVals...)
-		return "", err
+		return "", fmt.Errorf("%w: %s driver requires it: %v", ErrPowerCLIUnavailable, driver, err)
 	}
 
 	formattedOpts := fmtPowerOpts(opts)
@@ -319,8 +1076,10 @@ func fmtPowerOpts(opts map[string]interface{}) []string {
 	for k, v := range opts {
 		// skip 'system_id' as it is not required by any power driver contract.
 		// it is added by the region when driver is called directly (not via CLI)
+		// skip 'boot_mode' as it is only used by native drivers, and so are
+		// pinned identities of hosts
 		// also skip 'null' values (some power options might have them empty)
-		if k == "system_id" || v == nil {
+		if k == "system_id" || k == bootModeOpt || k == optCertFingerprint || k == optHostKey || v == nil {
 			continue
 		}
 
@@ -17,8 +17,10 @@ package power
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFmtPowerOpts(t *testing.T) {
@@ -50,6 +52,14 @@ func TestFmtPowerOpts(t *testing.T) {
 			in:  map[string]interface{}{"system_id": "value1"},
 			out: []string{},
 		},
+		"ignore boot_mode": {
+			in:  map[string]interface{}{"boot_mode": "uefi"},
+			out: []string{},
+		},
+		"ignore pinned identities": {
+			in:  map[string]interface{}{"certificate_fingerprint": "sha256:00", "host_key": "ssh-ed25519 AAAA"},
+			out: []string{},
+		},
 		"ignore null": {
 			in:  map[string]interface{}{"key1": nil},
 			out: []string{},
@@ -67,3 +77,69 @@ func TestFmtPowerOpts(t *testing.T) {
 		})
 	}
 }
+
+func TestWithBootMode(t *testing.T) {
+	opts := map[string]interface{}{"power_address": "10.0.0.1"}
+
+	res, err := withBootMode(opts, "")
+	require.NoError(t, err)
+	assert.Equal(t, opts, res)
+
+	res, err = withBootMode(opts, FirmwareUEFI)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"power_address": "10.0.0.1", "boot_mode": "uefi"}, res)
+	// Options of the machine are not modified
+	assert.NotContains(t, opts, "boot_mode")
+
+	_, err = withBootMode(opts, "bios")
+	assert.ErrorIs(t, err, ErrUnsupportedBootMode)
+}
+
+func TestCommandTimeoutFor(t *testing.T) {
+	testcases := map[string]struct {
+		options []PowerServiceOption
+		param   PowerParam
+		out     time.Duration
+	}{
+		"command timeout": {
+			options: []PowerServiceOption{WithCommandTimeout(time.Minute)},
+			param:   PowerParam{DriverType: "ipmi"},
+			out:     time.Minute,
+		},
+		"no timeout": {
+			param: PowerParam{DriverType: "ipmi"},
+		},
+		"driver type default": {
+			options: []PowerServiceOption{WithCommandTimeout(time.Minute)},
+			param:   PowerParam{DriverType: "moonshot"},
+			out:     4 * time.Minute,
+		},
+		"driver type override": {
+			options: []PowerServiceOption{WithDriverTimeout("lxd", 5*time.Second)},
+			param:   PowerParam{DriverType: "lxd"},
+			out:     5 * time.Second,
+		},
+		"driver type default removed": {
+			options: []PowerServiceOption{
+				WithCommandTimeout(time.Minute), WithDriverTimeout("lxd", 0)},
+			param: PowerParam{DriverType: "lxd"},
+			out:   time.Minute,
+		},
+		"param override": {
+			options: []PowerServiceOption{WithDriverTimeout("lxd", 5*time.Second)},
+			param:   PowerParam{DriverType: "lxd", Timeout: 90},
+			out:     90 * time.Second,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewPowerService("abc", nil, tc.options...)
+			assert.Equal(t, tc.out, s.commandTimeoutFor(tc.param))
+		})
+	}
+}
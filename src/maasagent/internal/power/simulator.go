@@ -0,0 +1,259 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/state"
+)
+
+// DriverSimulator is a power driver that doesn't talk to any BMC. It keeps
+// power states in memory (and in the state store, if the Agent has one) and
+// responds after a configurable latency, so the
+// Agent can be load tested without hardware. Driver options are:
+//   - power_address: identifies the simulated machine
+//   - latency: duration of every power command, e.g. "500ms" (default: 100ms)
+//   - failure_rate: probability of a command failing, e.g. "0.05"
+const DriverSimulator = "simulator"
+
+const defaultSimulatorLatency = 100 * time.Millisecond
+
+var (
+	// ErrSimulatedFailure is returned by the simulator driver to simulate
+	// an unreachable BMC
+	ErrSimulatedFailure = errcode.New(errcode.PowerOperationFailed, "simulated BMC failure")
+	// ErrInvalidSimulatorOption is returned for invalid simulator driver options
+	ErrInvalidSimulatorOption = errcode.New(errcode.PowerInvalidParameters, "invalid simulator option")
+)
+
+// powerSimulator is shared by all workers, so states are consistent across
+// power activities.
+var powerSimulator = newSimulator()
+
+type simulator struct {
+	store  *state.Store
+	states map[string]string
+	mutex  sync.Mutex
+}
+
+func newSimulator() *simulator {
+	return &simulator{states: make(map[string]string)}
+}
+
+// WithStateStore keeps power states of simulated machines in store, so they
+// survive Agent restarts.
+func WithStateStore(store *state.Store) PowerServiceOption {
+	return func(s *PowerService) {
+		sim := newSimulator()
+		sim.store = store
+
+		s.drivers.Register(DriverSimulator, sim)
+	}
+}
+
+// run executes the power action, returning output the power CLI would print
+func (s *simulator) run(ctx context.Context, action string, opts map[string]interface{}) (string, error) {
+	latency := defaultSimulatorLatency
+	if v := stringOpt(opts, "latency"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return "", fmt.Errorf("%w: latency: %v", ErrInvalidSimulatorOption, err)
+		}
+
+		latency = d
+	}
+
+	var failureRate float64
+
+	if v := stringOpt(opts, "failure_rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", fmt.Errorf("%w: failure_rate: %v", ErrInvalidSimulatorOption, err)
+		}
+
+		failureRate = rate
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-timer.C:
+	}
+
+	//nolint:gosec // usage of math/rand is ok here
+	if failureRate > 0 && rand.Float64() < failureRate {
+		return "", ErrSimulatedFailure
+	}
+
+	machine := stringOpt(opts, "power_address")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, err := s.state(ctx, machine)
+	if err != nil {
+		return "", err
+	}
+
+	next := current
+
+	switch action {
+	case "on", "cycle", "reset":
+		next = "on"
+	case "off", "soft-off":
+		next = "off"
+	case "status":
+	default:
+		return "", fmt.Errorf("%w: unsupported action %q", ErrInvalidSimulatorOption, action)
+	}
+
+	if next != current && s.store != nil {
+		if err := s.store.SetPowerState(ctx, DriverSimulator, machine, next); err != nil {
+			return "", err
+		}
+	}
+
+	s.states[machine] = next
+
+	return next + "\n", nil
+}
+
+// state returns the power state of the machine, which is off unless it was
+// powered on before
+func (s *simulator) state(ctx context.Context, machine string) (string, error) {
+	if current, ok := s.states[machine]; ok {
+		return current, nil
+	}
+
+	if s.store == nil {
+		return "off", nil
+	}
+
+	ps, err := s.store.GetPowerState(ctx, DriverSimulator, machine)
+	if errors.Is(err, state.ErrNotFound) {
+		return "off", nil
+	}
+
+	return ps.State, err
+}
+
+func (s *simulator) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return s.power(ctx, "on", opts)
+}
+
+func (s *simulator) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return s.power(ctx, "off", opts)
+}
+
+func (s *simulator) SoftOff(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return s.power(ctx, "soft-off", opts)
+}
+
+func (s *simulator) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return s.power(ctx, "cycle", opts)
+}
+
+func (s *simulator) Reset(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return s.power(ctx, "reset", opts)
+}
+
+// SetBootDevice only validates device, as simulated machines never boot.
+// Latency and failures are simulated as for power actions.
+func (s *simulator) SetBootDevice(ctx context.Context, opts map[string]interface{}, device string) error {
+	switch device {
+	case BootDevicePXE, BootDeviceDisk, BootDeviceCD:
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedBootDevice, device)
+	}
+
+	_, err := s.run(ctx, "status", opts)
+
+	return err
+}
+
+func (s *simulator) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return s.power(ctx, "status", opts)
+}
+
+func (s *simulator) power(ctx context.Context, action string,
+	opts map[string]interface{}) (string, PowerDetails, error) {
+	out, err := s.run(ctx, action, opts)
+	return strings.TrimSpace(out), PowerDetails{}, err
+}
+
+// SyntheticPowerParam is the parameter of synthetic-power workflow
+type SyntheticPowerParam struct {
+	// AgentSystemID is the system_id of the Agent executing power activities
+	AgentSystemID string `json:"agent_system_id"`
+	// Action is the power activity, e.g. "power-on" or "power-query"
+	Action string `json:"action"`
+	// DriverOpts are options of the simulator driver
+	DriverOpts map[string]interface{} `json:"driver_opts"`
+}
+
+// syntheticActions are power activities executed by synthetic-power
+var syntheticActions = map[string]struct{}{
+	"power-on":    {},
+	"power-off":   {},
+	"power-cycle": {},
+	"power-reset": {},
+	"power-query": {},
+}
+
+// syntheticPower executes a power activity with the simulator driver
+// through the same task queue as real power actions, so load tests
+// exercise the whole path without touching any BMC. Activities are not
+// retried, so every failure is accounted for.
+func (s *PowerService) syntheticPower(ctx tworkflow.Context, param SyntheticPowerParam) (*PowerQueryResult, error) {
+	ctx = tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+		TaskQueue:           fmt.Sprintf("%s@agent:power", param.AgentSystemID),
+		StartToCloseTimeout: 60 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 1,
+		},
+	})
+
+	if _, ok := syntheticActions[param.Action]; !ok {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unsupported action %q", param.Action), "", nil)
+	}
+
+	// The driver is always the simulator, regardless of the caller. Parameters
+	// of all power actions have the same encoding as PowerParam.
+	powerParam := PowerParam{DriverType: DriverSimulator, DriverOpts: param.DriverOpts}
+
+	var res PowerQueryResult
+
+	if err := tworkflow.ExecuteActivity(ctx, param.Action, powerParam).Get(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
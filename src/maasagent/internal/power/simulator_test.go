@@ -0,0 +1,221 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"maas.io/core/src/maasagent/internal/state"
+)
+
+func TestSimulator(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		opts    map[string]interface{}
+		actions []string
+		out     string
+		err     error
+	}{
+		"initial state": {
+			actions: []string{"status"},
+			out:     "off\n",
+		},
+		"power on": {
+			actions: []string{"on", "status"},
+			out:     "on\n",
+		},
+		"power cycle": {
+			actions: []string{"off", "cycle"},
+			out:     "on\n",
+		},
+		"power reset": {
+			actions: []string{"on", "reset", "status"},
+			out:     "on\n",
+		},
+		"power off": {
+			actions: []string{"on", "off", "status"},
+			out:     "off\n",
+		},
+		"failure": {
+			opts:    map[string]interface{}{"failure_rate": "1"},
+			actions: []string{"on"},
+			err:     ErrSimulatedFailure,
+		},
+		"invalid latency": {
+			opts:    map[string]interface{}{"latency": "fast"},
+			actions: []string{"on"},
+			err:     ErrInvalidSimulatorOption,
+		},
+		"invalid failure rate": {
+			opts:    map[string]interface{}{"failure_rate": "often"},
+			actions: []string{"on"},
+			err:     ErrInvalidSimulatorOption,
+		},
+		"unsupported action": {
+			actions: []string{"set-boot-order"},
+			err:     ErrInvalidSimulatorOption,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := map[string]interface{}{"power_address": "sim-1", "latency": "1ms"}
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+
+			s := newSimulator()
+
+			var (
+				out string
+				err error
+			)
+
+			for _, action := range tc.actions {
+				out, err = s.run(context.Background(), action, opts)
+			}
+
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestSimulatorStateStore(t *testing.T) {
+	t.Parallel()
+
+	store, err := state.Open(context.Background(), filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { store.Close() })
+
+	param := PowerParam{
+		DriverType: DriverSimulator,
+		DriverOpts: map[string]interface{}{"power_address": "sim-1", "latency": "0s"},
+	}
+
+	s := NewPowerService("abc", nil, WithStateStore(store))
+
+	res, err := s.Execute(context.Background(), "on", param)
+	require.NoError(t, err)
+	assert.Equal(t, "on", res)
+
+	// Simulated machines keep their power state when the Agent restarts
+	s = NewPowerService("abc", nil, WithStateStore(store))
+
+	res, err = s.Execute(context.Background(), "status", param)
+	require.NoError(t, err)
+	assert.Equal(t, "on", res)
+}
+
+func TestSimulatorCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := newSimulator().run(ctx, "on", map[string]interface{}{"latency": "1h"})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSimulatorSetBootDevice(t *testing.T) {
+	t.Parallel()
+
+	s := newSimulator()
+	opts := map[string]interface{}{"latency": "0s"}
+
+	assert.NoError(t, s.SetBootDevice(context.Background(), opts, BootDevicePXE))
+	assert.ErrorIs(t, s.SetBootDevice(context.Background(), opts, "floppy"), ErrUnsupportedBootDevice)
+	assert.ErrorIs(t, s.SetBootDevice(context.Background(),
+		map[string]interface{}{"latency": "0s", "failure_rate": "1"}, BootDeviceDisk), ErrSimulatedFailure)
+}
+
+func TestPowerCommandTimeout(t *testing.T) {
+	t.Parallel()
+
+	s := NewPowerService("abc", nil, WithCommandTimeout(time.Millisecond))
+
+	_, err := s.Execute(context.Background(), "on", PowerParam{
+		DriverType: DriverSimulator,
+		DriverOpts: map[string]interface{}{"latency": "1h"},
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSyntheticPower(t *testing.T) {
+	testcases := map[string]struct {
+		action string
+		state  string
+		err    bool
+	}{
+		"power on": {
+			action: "power-on",
+			state:  "on",
+		},
+		"power off": {
+			action: "power-off",
+			state:  "off",
+		},
+		"unsupported action": {
+			action: "set-boot-order",
+			err:    true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			svc := NewPowerService("agent", nil)
+			env := newTestWorkflowEnvironment(svc)
+			env.RegisterActivityWithOptions(svc.PowerOn, activity.RegisterOptions{Name: "power-on"})
+			env.RegisterActivityWithOptions(svc.PowerOff, activity.RegisterOptions{Name: "power-off"})
+
+			env.ExecuteWorkflow(svc.syntheticPower, SyntheticPowerParam{
+				AgentSystemID: "agent",
+				Action:        tc.action,
+				DriverOpts: map[string]interface{}{
+					"power_address": "sim-" + name,
+					"latency":       time.Millisecond.String(),
+				},
+			})
+
+			require.True(t, env.IsWorkflowCompleted())
+
+			if tc.err {
+				assert.Error(t, env.GetWorkflowError())
+				return
+			}
+
+			require.NoError(t, env.GetWorkflowError())
+
+			var res PowerQueryResult
+			require.NoError(t, env.GetWorkflowResult(&res))
+			assert.Equal(t, tc.state, res.State)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/errcode"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+const (
+	defaultSmokeTestDeployTimeout = 30 * time.Minute
+	smokeTestPollInterval         = 30 * time.Second
+	machineStatusDeployed         = "Deployed"
+	machineStatusFailedDeployment = "Failed deployment"
+)
+
+var (
+	// ErrDeploymentFailed is returned when the canary machine failed to deploy
+	ErrDeploymentFailed = errcode.New(errcode.DeploymentFailed, "deployment failed")
+)
+
+// SmokeTestRackParam is the parameter of smoke-test-rack workflow
+type SmokeTestRackParam struct {
+	// AgentSystemID is the system_id of the rack under test
+	AgentSystemID string `json:"agent_system_id"`
+	// SystemID is the system_id of the canary machine
+	SystemID string `json:"system_id"`
+	PowerParam
+	// DeployTimeout in seconds
+	DeployTimeout int `json:"deploy_timeout"`
+	// Simulate runs every phase without touching the canary machine,
+	// which validates the rack plumbing (workers, task queues) only.
+	Simulate bool `json:"simulate"`
+}
+
+// SmokeTestPhase is the outcome of a single smoke test phase
+type SmokeTestPhase struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+	// ErrorCode is the code of Error, e.g. "POWER_AUTH_FAILED"
+	ErrorCode string        `json:"error_code,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// SmokeTestResult is the result of smoke-test-rack workflow
+type SmokeTestResult struct {
+	AgentSystemID string           `json:"agent_system_id"`
+	SystemID      string           `json:"system_id"`
+	Phases        []SmokeTestPhase `json:"phases"`
+	Simulated     bool             `json:"simulated"`
+	Success       bool             `json:"success"`
+}
+
+type machineParam struct {
+	SystemID string `json:"system_id"`
+}
+
+type getMachineStatusResult struct {
+	Status string `json:"status"`
+}
+
+type smokeTestPhase struct {
+	fn   func(ctx tworkflow.Context) error
+	name string
+}
+
+// smokeTestRack deploys a canary machine end-to-end and reports how long
+// every phase took, so operators can validate a rack after changes.
+// Execution stops at the first failed phase, but the result is always
+// reported to the Region.
+func (s *PowerService) smokeTestRack(ctx tworkflow.Context, param SmokeTestRackParam) (*SmokeTestResult, error) {
+	log := tworkflow.GetLogger(ctx)
+
+	phases := s.smokeTestPhases(param)
+
+	result := &SmokeTestResult{
+		AgentSystemID: param.AgentSystemID,
+		SystemID:      param.SystemID,
+		Simulated:     param.Simulate,
+		Success:       true,
+	}
+
+	for _, phase := range phases {
+		start := tworkflow.Now(ctx)
+
+		if param.Simulate {
+			phase.fn = simulatePhase
+		}
+
+		err := phase.fn(ctx)
+
+		p := SmokeTestPhase{Name: phase.name, Duration: tworkflow.Now(ctx).Sub(start)}
+		if err != nil {
+			p.Error, p.ErrorCode = err.Error(), errcode.Of(err)
+			result.Success = false
+		}
+
+		result.Phases = append(result.Phases, p)
+
+		log.Info("Smoke test phase finished", tag.Builder().
+			KV("phase", p.Name).
+			KV("duration", p.Duration).
+			KV("error", p.Error).KeyVals...)
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err := tworkflow.ExecuteActivity(regionContext(ctx),
+		"report-smoke-test", result).Get(ctx, nil); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (s *PowerService) smokeTestPhases(param SmokeTestRackParam) []smokeTestPhase {
+	machine := machineParam{SystemID: param.SystemID}
+
+	timeout := defaultSmokeTestDeployTimeout
+	if param.DeployTimeout > 0 {
+		timeout = time.Duration(param.DeployTimeout) * time.Second
+	}
+
+	return []smokeTestPhase{
+		{
+			name: "power-off",
+			fn: func(ctx tworkflow.Context) error {
+				return tworkflow.ExecuteActivity(powerQueryContext(ctx, param.AgentSystemID),
+					"power-off", PowerOffParam{PowerParam: param.PowerParam}).Get(ctx, nil)
+			},
+		},
+		{
+			name: "deploy",
+			fn: func(ctx tworkflow.Context) error {
+				return tworkflow.ExecuteActivity(regionContext(ctx), "deploy-machine", machine).
+					Get(ctx, nil)
+			},
+		},
+		{
+			name: "wait-deployed",
+			fn: func(ctx tworkflow.Context) error {
+				return waitDeployed(ctx, machine, tworkflow.Now(ctx).Add(timeout))
+			},
+		},
+		{
+			name: "power-query",
+			fn: func(ctx tworkflow.Context) error {
+				var res PowerQueryResult
+
+				if err := tworkflow.ExecuteActivity(powerQueryContext(ctx, param.AgentSystemID),
+					"power-query", PowerQueryParam{PowerParam: param.PowerParam}).Get(ctx, &res); err != nil {
+					return err
+				}
+
+				if res.State != "on" {
+					return fmt.Errorf("%w: %s", ErrWrongPowerState, res.State)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "release",
+			fn: func(ctx tworkflow.Context) error {
+				return tworkflow.ExecuteActivity(regionContext(ctx), "release-machine", machine).
+					Get(ctx, nil)
+			},
+		},
+	}
+}
+
+func waitDeployed(ctx tworkflow.Context, machine machineParam, deadline time.Time) error {
+	for {
+		var res getMachineStatusResult
+
+		if err := tworkflow.ExecuteActivity(regionContext(ctx), "get-machine-status", machine).
+			Get(ctx, &res); err != nil {
+			return err
+		}
+
+		switch res.Status {
+		case machineStatusDeployed:
+			return nil
+		case machineStatusFailedDeployment:
+			return fmt.Errorf("%w: machine %s: %s", ErrDeploymentFailed, machine.SystemID, res.Status)
+		}
+
+		if !tworkflow.Now(ctx).Before(deadline) {
+			return fmt.Errorf("%w: machine %s was not deployed in time, status: %s",
+				ErrDeploymentFailed, machine.SystemID, res.Status)
+		}
+
+		if err := tworkflow.Sleep(ctx, smokeTestPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// simulatePhase executes a no-op local activity instead of a real phase.
+func simulatePhase(ctx tworkflow.Context) error {
+	ctx = tworkflow.WithLocalActivityOptions(ctx, tworkflow.LocalActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+	})
+
+	return tworkflow.ExecuteLocalActivity(ctx, func(context.Context) error { return nil }).Get(ctx, nil)
+}
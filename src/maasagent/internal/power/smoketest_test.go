@@ -0,0 +1,162 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func machineActivity(_ context.Context, _ machineParam) error {
+	return nil
+}
+
+func getMachineStatusActivity(_ context.Context, _ machineParam) (getMachineStatusResult, error) {
+	return getMachineStatusResult{}, nil
+}
+
+func reportSmokeTestActivity(_ context.Context, _ *SmokeTestResult) error {
+	return nil
+}
+
+func newSmokeTestEnvironment(svc *PowerService) *testsuite.TestWorkflowEnvironment {
+	env := newTestWorkflowEnvironment(svc)
+
+	env.RegisterActivityWithOptions(svc.PowerOff, activity.RegisterOptions{Name: "power-off"})
+	env.RegisterActivityWithOptions(machineActivity, activity.RegisterOptions{Name: "deploy-machine"})
+	env.RegisterActivityWithOptions(machineActivity, activity.RegisterOptions{Name: "release-machine"})
+	env.RegisterActivityWithOptions(getMachineStatusActivity,
+		activity.RegisterOptions{Name: "get-machine-status"})
+	env.RegisterActivityWithOptions(reportSmokeTestActivity,
+		activity.RegisterOptions{Name: "report-smoke-test"})
+
+	return env
+}
+
+func phaseNames(phases []SmokeTestPhase) []string {
+	names := make([]string, len(phases))
+	for i, p := range phases {
+		names[i] = p.Name
+	}
+
+	return names
+}
+
+func TestSmokeTestRack(t *testing.T) {
+	allPhases := []string{"power-off", "deploy", "wait-deployed", "power-query", "release"}
+
+	testcases := map[string]struct {
+		statuses []string
+		success  bool
+		phases   []string
+	}{
+		"deployed": {
+			statuses: []string{"Deploying", machineStatusDeployed},
+			success:  true,
+			phases:   allPhases,
+		},
+		"failed deployment": {
+			statuses: []string{"Deploying", machineStatusFailedDeployment},
+			phases:   allPhases[:3],
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			svc := NewPowerService("agent", nil)
+			env := newSmokeTestEnvironment(svc)
+
+			param := SmokeTestRackParam{
+				AgentSystemID: "agent",
+				SystemID:      "canary",
+				PowerParam:    PowerParam{DriverType: "ipmi"},
+			}
+
+			env.OnActivity("power-off", mock.Anything, mock.Anything).
+				Return(&PowerOffResult{State: "off"}, nil)
+			env.OnActivity("deploy-machine", mock.Anything, machineParam{SystemID: "canary"}).
+				Return(nil)
+
+			for _, status := range tc.statuses {
+				env.OnActivity("get-machine-status", mock.Anything, mock.Anything).
+					Return(getMachineStatusResult{Status: status}, nil).Once()
+			}
+
+			env.OnActivity("power-query", mock.Anything, mock.Anything).
+				Return(&PowerQueryResult{State: "on"}, nil)
+			env.OnActivity("release-machine", mock.Anything, mock.Anything).Return(nil)
+
+			var reported *SmokeTestResult
+
+			env.OnActivity("report-smoke-test", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, r *SmokeTestResult) error {
+					reported = r
+					return nil
+				})
+
+			env.ExecuteWorkflow(svc.smokeTestRack, param)
+
+			require.True(t, env.IsWorkflowCompleted())
+			require.NoError(t, env.GetWorkflowError())
+
+			var result SmokeTestResult
+			require.NoError(t, env.GetWorkflowResult(&result))
+
+			assert.Equal(t, tc.success, result.Success)
+			assert.Equal(t, tc.phases, phaseNames(result.Phases))
+			require.NotNil(t, reported)
+			assert.Equal(t, result, *reported)
+
+			if !tc.success {
+				assert.Contains(t, result.Phases[len(result.Phases)-1].Error, ErrDeploymentFailed.Error())
+			}
+		})
+	}
+}
+
+func TestSmokeTestRackSimulated(t *testing.T) {
+	svc := NewPowerService("agent", nil)
+	env := newSmokeTestEnvironment(svc)
+
+	env.OnActivity("report-smoke-test", mock.Anything, mock.Anything).Return(nil)
+
+	env.ExecuteWorkflow(svc.smokeTestRack, SmokeTestRackParam{
+		AgentSystemID: "agent",
+		Simulate:      true,
+	})
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	var result SmokeTestResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+
+	assert.True(t, result.Success)
+	assert.True(t, result.Simulated)
+	assert.Len(t, result.Phases, 5)
+
+	// Nothing but the report must reach the Region or BMC
+	env.AssertNotCalled(t, "power-off", mock.Anything, mock.Anything)
+	env.AssertNotCalled(t, "deploy-machine", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+
+	"maas.io/core/src/maasagent/internal/libvirt"
+)
+
+// DriverVirsh is the power driver of libvirt domains
+const DriverVirsh = "virsh"
+
+// virshDriver controls libvirt domains over the libvirt remote protocol,
+// rather than running virsh for every power action. Connections are pooled
+// by URI, the same as connections of batched queries.
+type virshDriver struct {
+	pool *connPool[*libvirt.Conn]
+}
+
+func newVirshDriver() *virshDriver {
+	return &virshDriver{
+		pool: newConnPool[*libvirt.Conn](defaultConnIdleTimeout, defaultConnHealthPeriod),
+	}
+}
+
+// Supports returns whether libvirtd can be reached without a password,
+// which only the power driver can give to ssh, over a supported transport
+func (d *virshDriver) Supports(opts map[string]interface{}) bool {
+	if _, _, ok := virshBatchKey(opts); !ok {
+		return false
+	}
+
+	return libvirt.SupportsURI(stringOpt(opts, "power_address"))
+}
+
+// On starts the domain, or resumes it if it is paused
+func (d *virshDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(c *libvirt.Conn, dom libvirt.Domain, state libvirt.DomainState) (string, error) {
+		switch {
+		case state == libvirt.DomainPaused:
+			return "on", c.ResumeDomain(ctx, dom)
+		case !state.Active():
+			return "on", c.CreateDomain(ctx, dom)
+		}
+
+		return "on", nil
+	})
+}
+
+func (d *virshDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(c *libvirt.Conn, dom libvirt.Domain, state libvirt.DomainState) (string, error) {
+		if state.Active() {
+			return "off", c.DestroyDomain(ctx, dom)
+		}
+
+		return "off", nil
+	})
+}
+
+// SoftOff asks the guest OS to shut down with ACPI power button
+func (d *virshDriver) SoftOff(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(c *libvirt.Conn, dom libvirt.Domain, state libvirt.DomainState) (string, error) {
+		if !state.Active() {
+			return "off", nil
+		}
+
+		return "on", c.ShutdownDomain(ctx, dom)
+	})
+}
+
+func (d *virshDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(c *libvirt.Conn, dom libvirt.Domain, state libvirt.DomainState) (string, error) {
+		if state.Active() {
+			if err := c.DestroyDomain(ctx, dom); err != nil {
+				return "", err
+			}
+		}
+
+		return "on", c.CreateDomain(ctx, dom)
+	})
+}
+
+func (d *virshDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(_ *libvirt.Conn, _ libvirt.Domain, state libvirt.DomainState) (string, error) {
+		return virshPowerState(state.String()), nil
+	})
+}
+
+// do calls fn with a pooled connection to the host of the domain, the
+// domain and its current state
+func (d *virshDriver) do(ctx context.Context, opts map[string]interface{},
+	fn func(*libvirt.Conn, libvirt.Domain, libvirt.DomainState) (string, error)) (string, PowerDetails, error) {
+	uri := stringOpt(opts, "power_address")
+
+	if hostKey := stringOpt(opts, optHostKey); hostKey != "" {
+		var err error
+
+		uri, err = pinnedVirshURI(uri, hostKey, knownHostsDir())
+		if err != nil {
+			return "", PowerDetails{}, err
+		}
+	}
+
+	var state string
+
+	err := d.pool.use(ctx, uri,
+		func(ctx context.Context) (*libvirt.Conn, error) { return libvirt.Dial(ctx, uri) },
+		func(c *libvirt.Conn) error {
+			dom, err := c.LookupDomain(ctx, stringOpt(opts, "power_id"))
+			if err != nil {
+				return err
+			}
+
+			current, err := c.DomainState(ctx, dom)
+			if err != nil {
+				return err
+			}
+
+			state, err = fn(c, dom, current)
+
+			return err
+		})
+
+	return state, PowerDetails{}, err
+}
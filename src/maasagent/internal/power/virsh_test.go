@@ -0,0 +1,167 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/libvirt"
+	libvirttest "maas.io/core/src/maasagent/internal/testing/libvirt"
+)
+
+func TestVirshDriver(t *testing.T) {
+	testcases := map[string]struct {
+		action  string
+		initial int32
+		state   string
+		domain  int32
+	}{
+		"on": {
+			action:  "on",
+			initial: libvirttest.StateShutoff,
+			state:   "on",
+			domain:  libvirttest.StateRunning,
+		},
+		"on resumes paused": {
+			action:  "on",
+			initial: libvirttest.StatePaused,
+			state:   "on",
+			domain:  libvirttest.StateRunning,
+		},
+		"on already on": {
+			action:  "on",
+			initial: libvirttest.StateRunning,
+			state:   "on",
+			domain:  libvirttest.StateRunning,
+		},
+		"off": {
+			action:  "off",
+			initial: libvirttest.StateRunning,
+			state:   "off",
+			domain:  libvirttest.StateShutoff,
+		},
+		"off already off": {
+			action:  "off",
+			initial: libvirttest.StateShutoff,
+			state:   "off",
+			domain:  libvirttest.StateShutoff,
+		},
+		"soft-off": {
+			action:  "soft-off",
+			initial: libvirttest.StateRunning,
+			state:   "on",
+			domain:  libvirttest.StateShutoff,
+		},
+		"cycle": {
+			action:  "cycle",
+			initial: libvirttest.StateRunning,
+			state:   "on",
+			domain:  libvirttest.StateRunning,
+		},
+		"cycle off": {
+			action:  "cycle",
+			initial: libvirttest.StateShutoff,
+			state:   "on",
+			domain:  libvirttest.StateRunning,
+		},
+		"status paused": {
+			action:  "status",
+			initial: libvirttest.StatePaused,
+			state:   "on",
+			domain:  libvirttest.StatePaused,
+		},
+		"status off": {
+			action:  "status",
+			initial: libvirttest.StateShutoff,
+			state:   "off",
+			domain:  libvirttest.StateShutoff,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			daemon := libvirttest.NewLibvirtDaemon(t, map[string]*libvirttest.Domain{
+				"vm1": {State: tc.initial},
+			})
+
+			d := newVirshDriver()
+			opts := map[string]interface{}{"power_address": daemon.URI, "power_id": "vm1"}
+
+			require.True(t, d.Supports(opts))
+
+			state, _, err := runDriver(context.Background(), d, tc.action, opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, state)
+			assert.Equal(t, tc.domain, daemon.Domain("vm1").State)
+		})
+	}
+}
+
+func TestVirshDriverNoDomain(t *testing.T) {
+	daemon := libvirttest.NewLibvirtDaemon(t, map[string]*libvirttest.Domain{})
+
+	_, _, err := newVirshDriver().Status(context.Background(), map[string]interface{}{
+		"power_address": daemon.URI,
+		"power_id":      "vm1",
+	})
+	assert.ErrorIs(t, err, libvirt.ErrNoDomain)
+}
+
+func TestVirshDriverSupports(t *testing.T) {
+	testcases := map[string]struct {
+		opts map[string]interface{}
+		ok   bool
+	}{
+		"ssh": {
+			opts: map[string]interface{}{"power_address": "qemu+ssh://maas@host/system", "power_id": "vm1"},
+			ok:   true,
+		},
+		"tcp": {
+			opts: map[string]interface{}{"power_address": "qemu+tcp://host/system", "power_id": "vm1"},
+			ok:   true,
+		},
+		"password": {
+			opts: map[string]interface{}{
+				"power_address": "qemu+ssh://maas@host/system",
+				"power_id":      "vm1",
+				"power_pass":    "secret",
+			},
+		},
+		"TLS": {
+			opts: map[string]interface{}{"power_address": "qemu://host/system", "power_id": "vm1"},
+		},
+		"no domain": {
+			opts: map[string]interface{}{"power_address": "qemu+ssh://maas@host/system"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.ok, newVirshDriver().Supports(tc.opts))
+		})
+	}
+}
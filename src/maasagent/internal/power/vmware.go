@@ -0,0 +1,438 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// DriverVMware is the power driver of VMware vSphere VMs
+const DriverVMware = "vmware"
+
+var (
+	// ErrVMwareTaskFailed is returned when a vSphere task of a power
+	// action fails
+	ErrVMwareTaskFailed = errcode.New(errcode.PowerOperationFailed, "vSphere task failed")
+)
+
+// vmwareError classifies faults returned by vSphere
+func vmwareError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var notFound *find.NotFoundError
+	if errors.As(err, &notFound) {
+		return fmt.Errorf("%w: %w", ErrInstanceNotFound, err)
+	}
+
+	var fault types.AnyType
+
+	switch {
+	case soap.IsSoapFault(err):
+		fault = soap.ToSoapFault(err).VimFault()
+	case soap.IsVimFault(err):
+		fault = soap.ToVimFault(err)
+	default:
+		return err
+	}
+
+	switch fault.(type) {
+	case types.NotAuthenticated, *types.NotAuthenticated, types.InvalidLogin, *types.InvalidLogin,
+		types.NoPermission, *types.NoPermission:
+		return fmt.Errorf("%w: %w", ErrAuthFailed, err)
+	case types.ManagedObjectNotFound, *types.ManagedObjectNotFound:
+		return fmt.Errorf("%w: %w", ErrInstanceNotFound, err)
+	case types.ToolsUnavailable, *types.ToolsUnavailable:
+		return fmt.Errorf("%w: %w", ErrGuestShutdownRefused, err)
+	}
+
+	return fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+}
+
+// vmwareSession is a logged in session of vCenter or ESXi
+type vmwareSession struct {
+	client *vim25.Client
+}
+
+// vmwareSessionCache keeps sessions of vSphere hosts, shared by power
+// actions and guest shutdowns of their VMs
+type vmwareSessionCache struct {
+	sessions map[string]*vmwareSession
+	mutex    sync.Mutex
+}
+
+func newVMwareSessionCache() *vmwareSessionCache {
+	return &vmwareSessionCache{sessions: make(map[string]*vmwareSession)}
+}
+
+// withSession calls fn with the session of the host of the VM. Idle
+// sessions are closed by vSphere after a while, so fn is called again with
+// a new session if the session was rejected.
+func (c *vmwareSessionCache) withSession(ctx context.Context, opts map[string]interface{},
+	fn func(*vmwareSession) error) error {
+	key := vmwareSessionKey(opts)
+
+	c.mutex.Lock()
+	s, ok := c.sessions[key]
+	c.mutex.Unlock()
+
+	if ok {
+		err := fn(s)
+		if !errors.Is(err, ErrAuthFailed) {
+			return err
+		}
+	}
+
+	s, err := loginVMware(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.sessions[key] = s
+	c.mutex.Unlock()
+
+	return fn(s)
+}
+
+// vmwareSessionKey identifies the host and credentials of a session.
+// The password is hashed, so it isn't kept in memory longer than needed.
+func vmwareSessionKey(opts map[string]interface{}) string {
+	sum := sha256.Sum256([]byte(stringOpt(opts, "power_pass")))
+
+	return strings.Join([]string{
+		vmwareURL(opts).String(), stringOpt(opts, "power_user"), stringOpt(opts, "power_verify_ssl"),
+		stringOpt(opts, optCertFingerprint), hex.EncodeToString(sum[:]),
+	}, "\x00")
+}
+
+// vmwareURL returns the SDK endpoint of the host, with power_protocol
+// and power_port defaulting to https and its port, as by the power driver
+func vmwareURL(opts map[string]interface{}) *url.URL {
+	protocol := stringOpt(opts, "power_protocol")
+	if protocol == "" {
+		protocol = "https"
+	}
+
+	host := stringOpt(opts, "power_address")
+	if port := stringOpt(opts, "power_port"); port != "" {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+
+	return &url.URL{Scheme: protocol, Host: host, Path: "/sdk"}
+}
+
+// loginVMware logs in with power_user and power_pass
+func loginVMware(ctx context.Context, opts map[string]interface{}) (*vmwareSession, error) {
+	tlsConfig, err := verifiedTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := soap.NewClient(vmwareURL(opts), tlsConfig.InsecureSkipVerify)
+	// The certificate is verified against the pinned fingerprint, if any
+	sc.DefaultTransport().TLSClientConfig.VerifyPeerCertificate = tlsConfig.VerifyPeerCertificate
+
+	client, err := vim25.NewClient(ctx, sc)
+	if err != nil {
+		return nil, vmwareError(err)
+	}
+
+	err = session.NewManager(client).Login(ctx,
+		url.UserPassword(stringOpt(opts, "power_user"), stringOpt(opts, "power_pass")))
+	if err != nil {
+		return nil, vmwareError(err)
+	}
+
+	return &vmwareSession{client: client}, nil
+}
+
+// findVM returns the VM with power_uuid (BIOS or instance UUID), or at
+// power_vm_name in the VM folder of power_datacenter
+func (s *vmwareSession) findVM(ctx context.Context, opts map[string]interface{}) (*object.VirtualMachine, error) {
+	finder := find.NewFinder(s.client, false)
+
+	var dc *object.Datacenter
+
+	if datacenter := strings.Trim(stringOpt(opts, "power_datacenter"), "/"); datacenter != "" {
+		var err error
+
+		if dc, err = finder.Datacenter(ctx, datacenter); err != nil {
+			return nil, vmwareError(err)
+		}
+
+		finder.SetDatacenter(dc)
+	}
+
+	uuid := stringOpt(opts, "power_uuid")
+	if uuid == "" {
+		vm, err := finder.VirtualMachine(ctx, strings.TrimLeft(stringOpt(opts, "power_vm_name"), "/"))
+		return vm, vmwareError(err)
+	}
+
+	index := object.NewSearchIndex(s.client)
+
+	// UUIDs of VMs discovered by MAAS are BIOS UUIDs on ESXi, and instance
+	// UUIDs on vCenter
+	for _, instanceUUID := range []bool{false, true} {
+		instanceUUID := instanceUUID
+
+		ref, err := index.FindByUuid(ctx, dc, uuid, true, &instanceUUID)
+		if err != nil {
+			return nil, vmwareError(err)
+		}
+
+		if vm, ok := ref.(*object.VirtualMachine); ok {
+			return vm, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrInstanceNotFound, uuid)
+}
+
+// vmwarePowerState returns the power state of runtime.powerState. Suspended
+// VMs are off, as by the power driver.
+func vmwarePowerState(status types.VirtualMachinePowerState) string {
+	switch status {
+	case types.VirtualMachinePowerStatePoweredOn:
+		return "on"
+	case types.VirtualMachinePowerStatePoweredOff, types.VirtualMachinePowerStateSuspended:
+		return "off"
+	}
+
+	return "unknown"
+}
+
+// vmwareTask starts a power operation of the VM,
+// e.g. (*object.VirtualMachine).PowerOn
+type vmwareTask func(*object.VirtualMachine, context.Context) (*object.Task, error)
+
+// runVMwareTask starts the power operation of the VM and waits for its task
+// to complete
+func runVMwareTask(ctx context.Context, vm *object.VirtualMachine, op vmwareTask) error {
+	task, err := op(vm, ctx)
+	if err != nil {
+		return vmwareError(err)
+	}
+
+	if err := task.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// The task was started, but the session expired while waiting
+		if soap.IsSoapFault(err) {
+			return vmwareError(err)
+		}
+
+		return fmt.Errorf("%w: %w", ErrVMwareTaskFailed, err)
+	}
+
+	return nil
+}
+
+// vmwareDriver controls vSphere VMs with the vSphere Web Services API of
+// vCenter or ESXi. Power parameters are the same as of the power driver,
+// with power_datacenter to find VMs by their path (power_vm_name) in the
+// VM folder of the datacenter. VMs without power_uuid nor datacenter are
+// left to the power driver, which searches the whole inventory.
+type vmwareDriver struct {
+	sessions *vmwareSessionCache
+}
+
+func newVMwareDriver(sessions *vmwareSessionCache) *vmwareDriver {
+	return &vmwareDriver{sessions: sessions}
+}
+
+// Supports returns whether the host, credentials and VM are given
+func (d *vmwareDriver) Supports(opts map[string]interface{}) bool {
+	if stringOpt(opts, "power_address") == "" || stringOpt(opts, "power_user") == "" ||
+		stringOpt(opts, "power_pass") == "" {
+		return false
+	}
+
+	return stringOpt(opts, "power_uuid") != "" ||
+		(stringOpt(opts, "power_vm_name") != "" && stringOpt(opts, "power_datacenter") != "")
+}
+
+// On powers the VM on, or resumes it if it is suspended
+func (d *vmwareDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(status types.VirtualMachinePowerState) vmwareTask {
+		if status == types.VirtualMachinePowerStatePoweredOn {
+			return nil
+		}
+
+		return (*object.VirtualMachine).PowerOn
+	})
+}
+
+func (d *vmwareDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(status types.VirtualMachinePowerState) vmwareTask {
+		if vmwarePowerState(status) == "off" {
+			return nil
+		}
+
+		return (*object.VirtualMachine).PowerOff
+	})
+}
+
+// Cycle resets the VM, or powers it on if it is off
+func (d *vmwareDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.Reset(ctx, opts)
+}
+
+// Reset resets the VM, or powers it on if it is off
+func (d *vmwareDriver) Reset(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(status types.VirtualMachinePowerState) vmwareTask {
+		if status == types.VirtualMachinePowerStatePoweredOn {
+			return (*object.VirtualMachine).Reset
+		}
+
+		return (*object.VirtualMachine).PowerOn
+	})
+}
+
+func (d *vmwareDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.do(ctx, opts, func(types.VirtualMachinePowerState) vmwareTask { return nil })
+}
+
+// do runs the power operation fn returns for runtime.powerState of the VM,
+// if any. runtime.powerState is returned as status in PowerDetails.
+func (d *vmwareDriver) do(ctx context.Context, opts map[string]interface{},
+	fn func(status types.VirtualMachinePowerState) vmwareTask) (string, PowerDetails, error) {
+	var status types.VirtualMachinePowerState
+
+	err := d.sessions.withSession(ctx, opts, func(s *vmwareSession) error {
+		vm, err := s.findVM(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		if status, err = vm.PowerState(ctx); err != nil {
+			return vmwareError(err)
+		}
+
+		op := fn(status)
+		if op == nil {
+			return nil
+		}
+
+		if err = runVMwareTask(ctx, vm, op); err != nil {
+			return err
+		}
+
+		status, err = vm.PowerState(ctx)
+
+		return vmwareError(err)
+	})
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return vmwarePowerState(status), PowerDetails{Status: string(status)}, nil
+}
+
+// vmwareGuest shuts down guest OS of vSphere VMs with VMware Tools
+type vmwareGuest struct {
+	sessions *vmwareSessionCache
+	opts     map[string]interface{}
+	vm       types.ManagedObjectReference
+}
+
+func dialVMwareGuest(ctx context.Context, opts map[string]interface{},
+	sessions *vmwareSessionCache) (guestAgent, bool, error) {
+	if !(&vmwareDriver{}).Supports(opts) {
+		return nil, false, nil
+	}
+
+	g := &vmwareGuest{sessions: sessions, opts: opts}
+
+	err := sessions.withSession(ctx, opts, func(s *vmwareSession) error {
+		vm, err := s.findVM(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		g.vm = vm.Reference()
+
+		return nil
+	})
+	if err != nil {
+		return nil, true, err
+	}
+
+	return g, true, nil
+}
+
+// shutdown asks VMware Tools to shut down the guest OS, which is refused
+// if Tools are not running. vSphere doesn't give up the shutdown.
+func (g *vmwareGuest) shutdown(ctx context.Context, _ time.Duration) error {
+	return g.sessions.withSession(ctx, g.opts, func(s *vmwareSession) error {
+		// The VM is bound to the client of the session, which may be renewed
+		vm := object.NewVirtualMachine(s.client, g.vm)
+
+		running, err := vm.IsToolsRunning(ctx)
+		if err != nil {
+			return vmwareError(err)
+		}
+
+		if !running {
+			return fmt.Errorf("%w: VMware Tools are not running", ErrGuestShutdownRefused)
+		}
+
+		return vmwareError(vm.ShutdownGuest(ctx))
+	})
+}
+
+func (g *vmwareGuest) state(ctx context.Context) (string, error) {
+	var state string
+
+	err := g.sessions.withSession(ctx, g.opts, func(s *vmwareSession) error {
+		status, err := object.NewVirtualMachine(s.client, g.vm).PowerState(ctx)
+		if err != nil {
+			return vmwareError(err)
+		}
+
+		state = vmwarePowerState(status)
+
+		return nil
+	})
+
+	return state, err
+}
+
+// Close keeps the session, which is shared by VMs of the host
+func (g *vmwareGuest) Close() error {
+	return nil
+}
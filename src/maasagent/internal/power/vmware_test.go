@@ -0,0 +1,274 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/session"
+	vcsim "github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newVMwareSimulator starts a vCenter simulator and returns power parameters
+// of one of its VMs in datacenter "DC0". The simulator inventory is global,
+// so tests using it can't run in parallel.
+func newVMwareSimulator(t *testing.T) (map[string]interface{}, *vcsim.VirtualMachine) {
+	t.Helper()
+
+	model := vcsim.VPX()
+	require.NoError(t, model.Create())
+	t.Cleanup(model.Remove)
+
+	// Any credentials are accepted without a login set
+	model.Service.Listen = &url.URL{User: url.UserPassword("admin", "secret")}
+
+	server := model.Service.NewServer()
+	t.Cleanup(server.Close)
+
+	vm := vcsim.Map.Any("VirtualMachine").(*vcsim.VirtualMachine)
+	password, _ := server.URL.User.Password()
+
+	return map[string]interface{}{
+		"power_address":    server.URL.Hostname(),
+		"power_port":       server.URL.Port(),
+		"power_protocol":   server.URL.Scheme,
+		"power_user":       server.URL.User.Username(),
+		"power_pass":       password,
+		"power_vm_name":    vm.Name,
+		"power_datacenter": "DC0",
+	}, vm
+}
+
+func setVMwareProperty(vm *vcsim.VirtualMachine, name string, val types.AnyType) {
+	vcsim.Map.Update(vm, []types.PropertyChange{{Name: name, Val: val}})
+}
+
+// setVMwarePowerState puts the VM into state with power operations, so the
+// simulator performs the same state transitions as vSphere does.
+func setVMwarePowerState(t *testing.T, opts map[string]interface{}, vm *vcsim.VirtualMachine,
+	state types.VirtualMachinePowerState) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	u := vmwareURL(opts)
+	u.User = url.UserPassword(stringOpt(opts, "power_user"), stringOpt(opts, "power_pass"))
+
+	c, err := govmomi.NewClient(ctx, u, true)
+	require.NoError(t, err)
+
+	//nolint:errcheck // the simulator is removed anyway
+	defer c.Logout(ctx)
+
+	obj := object.NewVirtualMachine(c.Client, vm.Reference())
+
+	current, err := obj.PowerState(ctx)
+	require.NoError(t, err)
+
+	if current == state {
+		return
+	}
+
+	var ops []func(context.Context) (*object.Task, error)
+
+	if current != types.VirtualMachinePowerStatePoweredOn {
+		ops = append(ops, obj.PowerOn)
+	}
+
+	switch state {
+	case types.VirtualMachinePowerStatePoweredOff:
+		ops = append(ops, obj.PowerOff)
+	case types.VirtualMachinePowerStateSuspended:
+		ops = append(ops, obj.Suspend)
+	}
+
+	for _, op := range ops {
+		task, err := op(ctx)
+		require.NoError(t, err)
+		require.NoError(t, task.Wait(ctx))
+	}
+}
+
+func TestVMwareDriver(t *testing.T) {
+	testcases := map[string]struct {
+		action  string
+		initial types.VirtualMachinePowerState
+		state   string
+		status  string
+	}{
+		"on": {
+			action:  "on",
+			initial: types.VirtualMachinePowerStatePoweredOff,
+			state:   "on",
+			status:  "poweredOn",
+		},
+		"on resumes": {
+			action:  "on",
+			initial: types.VirtualMachinePowerStateSuspended,
+			state:   "on",
+			status:  "poweredOn",
+		},
+		"on already on": {
+			action:  "on",
+			initial: types.VirtualMachinePowerStatePoweredOn,
+			state:   "on",
+			status:  "poweredOn",
+		},
+		"off": {
+			action:  "off",
+			initial: types.VirtualMachinePowerStatePoweredOn,
+			state:   "off",
+			status:  "poweredOff",
+		},
+		"off suspended": {
+			action:  "off",
+			initial: types.VirtualMachinePowerStateSuspended,
+			state:   "off",
+			status:  "suspended",
+		},
+		"cycle": {
+			action:  "cycle",
+			initial: types.VirtualMachinePowerStatePoweredOn,
+			state:   "on",
+			status:  "poweredOn",
+		},
+		"reset off": {
+			action:  "reset",
+			initial: types.VirtualMachinePowerStatePoweredOff,
+			state:   "on",
+			status:  "poweredOn",
+		},
+		"status": {
+			action:  "status",
+			initial: types.VirtualMachinePowerStatePoweredOff,
+			state:   "off",
+			status:  "poweredOff",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			opts, vm := newVMwareSimulator(t)
+			setVMwarePowerState(t, opts, vm, tc.initial)
+
+			d := newVMwareDriver(newVMwareSessionCache())
+			require.True(t, d.Supports(opts))
+
+			state, details, err := runDriver(context.Background(), d, tc.action, opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, state)
+			assert.Equal(t, tc.status, details.Status)
+		})
+	}
+}
+
+func TestVMwareDriverUUID(t *testing.T) {
+	opts, vm := newVMwareSimulator(t)
+	opts["power_vm_name"] = "renamed"
+
+	// BIOS UUIDs on ESXi, instance UUIDs on vCenter
+	for _, uuid := range []string{vm.Config.Uuid, vm.Config.InstanceUuid} {
+		opts["power_uuid"] = uuid
+
+		state, _, err := newVMwareDriver(newVMwareSessionCache()).Status(context.Background(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, "on", state)
+	}
+
+	opts["power_uuid"] = "00000000-0000-0000-0000-000000000000"
+
+	_, _, err := newVMwareDriver(newVMwareSessionCache()).Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+}
+
+func TestVMwareDriverSession(t *testing.T) {
+	opts, _ := newVMwareSimulator(t)
+	sessions := newVMwareSessionCache()
+	d := newVMwareDriver(sessions)
+	key := vmwareSessionKey(opts)
+
+	_, _, err := d.Status(context.Background(), opts)
+	require.NoError(t, err)
+
+	s := sessions.sessions[key]
+
+	_, _, err = d.Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Same(t, s, sessions.sessions[key])
+
+	// Idle session is closed by vCenter
+	require.NoError(t, session.NewManager(s.client).Logout(context.Background()))
+
+	state, _, err := d.Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "on", state)
+	assert.NotSame(t, s, sessions.sessions[key])
+}
+
+func TestVMwareDriverErrors(t *testing.T) {
+	opts, _ := newVMwareSimulator(t)
+	password := opts["power_pass"]
+	opts["power_pass"] = "wrong"
+
+	_, _, err := newVMwareDriver(newVMwareSessionCache()).Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+
+	opts["power_pass"] = password
+	opts["power_vm_name"] = "missing"
+
+	_, _, err = newVMwareDriver(newVMwareSessionCache()).Status(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+
+	delete(opts, "power_datacenter")
+
+	assert.False(t, newVMwareDriver(nil).Supports(opts))
+}
+
+func TestVMwareGuest(t *testing.T) {
+	opts, vm := newVMwareSimulator(t)
+	setVMwareProperty(vm, "guest.toolsRunningStatus",
+		string(types.VirtualMachineToolsRunningStatusGuestToolsNotRunning))
+
+	g, ok, err := dialVMwareGuest(context.Background(), opts, newVMwareSessionCache())
+	require.True(t, ok)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, g.shutdown(context.Background(), time.Minute), ErrGuestShutdownRefused)
+
+	setVMwareProperty(vm, "guest.toolsRunningStatus",
+		string(types.VirtualMachineToolsRunningStatusGuestToolsRunning))
+
+	require.NoError(t, g.shutdown(context.Background(), time.Minute))
+
+	assert.Eventually(t, func() bool {
+		state, err := g.state(context.Background())
+		return err == nil && state == "off"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, ok, err = dialVMwareGuest(context.Background(), map[string]interface{}{}, nil)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,480 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// DriverWebhook is the power driver of home-grown power controllers. Power
+// actions are HTTP requests configured in power parameters of the machine.
+const DriverWebhook = "webhook"
+
+const (
+	// defaultWebhookOnRegex and defaultWebhookOffRegex match responses of
+	// power query, as with the power driver
+	defaultWebhookOnRegex  = `status.*\:.*running`
+	defaultWebhookOffRegex = `status.*\:.*stopped`
+	// webhookResponseLimit is how much of a response is matched
+	webhookResponseLimit = 1 << 20
+)
+
+// ErrInvalidWebhook is returned when a request of the webhook driver can't
+// be built from power parameters
+var ErrInvalidWebhook = errcode.New(errcode.PowerInvalidParameters, "invalid webhook")
+
+// defaultWebhookWait is how long the driver waits before querying the state
+// of the machine after each attempt of a power action, as with the waiting
+// policy of the power drivers
+var defaultWebhookWait = []time.Duration{
+	1 * time.Second, 2 * time.Second, 2 * time.Second, 4 * time.Second,
+	6 * time.Second, 8 * time.Second, 12 * time.Second,
+}
+
+// webhookDriver performs power actions with HTTP requests. Each action
+// ("on", "off", "cycle" and "query") is configured with options:
+//
+//   - power_<action>_uri, URL of the request
+//   - power_<action>_method, POST by default, or GET for query
+//   - power_<action>_body, body of the request, empty by default
+//   - power_<action>_status, comma separated status codes of success, any
+//     below 400 by default
+//   - power_<action>_expect, regular expression the response must match
+//
+// URLs, bodies and headers (power_headers, "Name: value" per line) are
+// templates of text/template given power parameters, e.g.
+// "https://ctl/{{.node_id}}/on". Requests are authenticated with
+// power_token (bearer) or power_user and power_pass (basic), and carry the
+// System_Id of the machine, as with the power driver. Requests failing
+// with 404 are retried with a trailing slash in the URL.
+//
+// Power state is queried with power_query_uri and matched against
+// power_on_regex and power_off_regex. As with the power driver, power on
+// and off are retried until the machine is found in the state requested,
+// waiting longer after every attempt. Without power_query_uri, the state
+// requested is reported. Machines are power cycled by powering them off,
+// if they are on, and on unless power_cycle_uri is set.
+type webhookDriver struct {
+	// wait is the waiting policy of power actions, defaultWebhookWait
+	// if nil
+	wait []time.Duration
+}
+
+func (d webhookDriver) On(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.power(ctx, opts, "on")
+}
+
+func (d webhookDriver) Off(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	return d.power(ctx, opts, "off")
+}
+
+func (d webhookDriver) Cycle(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	if stringOpt(opts, "power_cycle_uri") == "" {
+		state, _, err := d.Status(ctx, opts)
+		if err != nil {
+			return "", PowerDetails{}, err
+		}
+
+		if state == "on" {
+			if _, _, err = d.Off(ctx, opts); err != nil {
+				return "", PowerDetails{}, err
+			}
+		}
+
+		return d.On(ctx, opts)
+	}
+
+	c, err := dialWebhook(opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer c.Close()
+
+	if _, err = c.do(ctx, "cycle"); err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	return c.state(ctx, "on")
+}
+
+// EmulatesCycle returns true if the webhook has no power_cycle_uri, in which
+// case the machine is powered off and on instead
+func (webhookDriver) EmulatesCycle(opts map[string]interface{}) bool {
+	return stringOpt(opts, "power_cycle_uri") == ""
+}
+
+func (webhookDriver) Status(ctx context.Context, opts map[string]interface{}) (string, PowerDetails, error) {
+	c, err := dialWebhook(opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer c.Close()
+
+	if stringOpt(opts, "power_query_uri") == "" {
+		return "", PowerDetails{}, fmt.Errorf("%w: power_query_uri is not set", ErrInvalidWebhook)
+	}
+
+	return c.state(ctx, "unknown")
+}
+
+// power performs the power action, and queries the state of the machine
+// after waiting, until it is the one requested. Failed requests are retried
+// likewise, unless power parameters are invalid.
+func (d webhookDriver) power(ctx context.Context, opts map[string]interface{},
+	action string) (string, PowerDetails, error) {
+	c, err := dialWebhook(opts)
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer c.Close()
+
+	wait := d.wait
+	if wait == nil {
+		wait = defaultWebhookWait
+	}
+
+	state := "unknown"
+
+	var lastErr error
+
+	for _, w := range wait {
+		_, err = c.do(ctx, action)
+		if errors.Is(err, ErrInvalidWebhook) {
+			return "", PowerDetails{}, err
+		}
+
+		if err != nil {
+			lastErr = err
+		}
+
+		if perr := webhookPause(ctx, w); perr != nil {
+			return "", PowerDetails{}, perr
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if stringOpt(opts, "power_query_uri") == "" {
+			return action, PowerDetails{}, nil
+		}
+
+		state, _, err = c.state(ctx, "unknown")
+		if errors.Is(err, ErrInvalidWebhook) {
+			return "", PowerDetails{}, err
+		}
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if state == action {
+			return state, PowerDetails{}, nil
+		}
+	}
+
+	if lastErr != nil {
+		return "", PowerDetails{}, lastErr
+	}
+
+	return "", PowerDetails{}, fmt.Errorf("%w: machine is %s, not %s", ErrWrongPowerState, state, action)
+}
+
+// webhookPause waits for d, or until ctx is done
+func webhookPause(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// webhookConn sends requests of power actions of a machine
+type webhookConn struct {
+	client *http.Client
+	opts   map[string]interface{}
+}
+
+func dialWebhook(opts map[string]interface{}) (*webhookConn, error) {
+	tlsConfig, err := verifiedTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhookConn{
+		opts: opts,
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// state returns the power state of the machine, or fallback if it can't
+// be queried
+func (c *webhookConn) state(ctx context.Context, fallback string) (string, PowerDetails, error) {
+	if stringOpt(c.opts, "power_query_uri") == "" {
+		return fallback, PowerDetails{}, nil
+	}
+
+	body, err := c.do(ctx, "query")
+	if err != nil {
+		return "", PowerDetails{}, err
+	}
+
+	for _, s := range []struct {
+		state, opt, regex string
+	}{
+		{"on", "power_on_regex", defaultWebhookOnRegex},
+		{"off", "power_off_regex", defaultWebhookOffRegex},
+	} {
+		regex := stringOpt(c.opts, s.opt)
+		if regex == "" {
+			regex = s.regex
+		}
+
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return "", PowerDetails{}, fmt.Errorf("%w: %s: %w", ErrInvalidWebhook, s.opt, err)
+		}
+
+		if re.Match(body) {
+			return s.state, PowerDetails{}, nil
+		}
+	}
+
+	return "unknown", PowerDetails{}, nil
+}
+
+// do sends the request of action and returns the body of its response
+func (c *webhookConn) do(ctx context.Context, action string) ([]byte, error) {
+	opt := func(name string) string {
+		return stringOpt(c.opts, "power_"+action+"_"+name)
+	}
+
+	uri, err := c.render(action+" uri", opt("uri"))
+	if err != nil {
+		return nil, err
+	}
+
+	if uri == "" {
+		return nil, fmt.Errorf("%w: power_%s_uri is not set", ErrInvalidWebhook, action)
+	}
+
+	method := strings.ToUpper(opt("method"))
+	if method == "" {
+		method = http.MethodPost
+		if action == "query" {
+			method = http.MethodGet
+		}
+	}
+
+	body, err := c.render(action+" body", opt("body"))
+	if err != nil {
+		return nil, err
+	}
+
+	req, resp, err := c.send(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// BMCs differ in whether they want a trailing slash
+	if resp.StatusCode == http.StatusNotFound && !strings.HasSuffix(uri, "/") {
+		//nolint:errcheck // should be safe to ignore an error from Close()
+		resp.Body.Close()
+
+		req, resp, err = c.send(ctx, method, uri+"/", body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	ok, err := webhookStatusOK(opt("status"), resp.StatusCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %s: %s", responseError(resp.StatusCode), method,
+			req.URL.Path, resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	if expect := opt("expect"); expect != "" {
+		re, err := regexp.Compile(expect)
+		if err != nil {
+			return nil, fmt.Errorf("%w: power_%s_expect: %w", ErrInvalidWebhook, action, err)
+		}
+
+		if !re.Match(b) {
+			return nil, fmt.Errorf("%w: %s %s: response doesn't match %q", ErrUnexpectedResponse,
+				method, req.URL.Path, expect)
+		}
+	}
+
+	return b, nil
+}
+
+// send sends the request with headers of the webhook
+func (c *webhookConn) send(ctx context.Context, method, uri,
+	body string) (*http.Request, *http.Response, error) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidWebhook, err)
+	}
+
+	if err = c.setHeaders(req); err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return req, resp, nil
+}
+
+// setHeaders sets headers sent by the power driver, power_headers and
+// credentials of req
+func (c *webhookConn) setHeaders(req *http.Request) error {
+	req.Header.Set("User-Agent", webhookUserAgent())
+	req.Header.Set("Accept", "application/json")
+
+	if systemID := stringOpt(c.opts, "system_id"); systemID != "" {
+		req.Header.Set("System_Id", systemID)
+	}
+
+	headers, err := c.render("headers", stringOpt(c.opts, "power_headers"))
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(headers, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("%w: header %q", ErrInvalidWebhook, line)
+		}
+
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	user, pass := stringOpt(c.opts, "power_user"), stringOpt(c.opts, "power_pass")
+
+	if token := stringOpt(c.opts, "power_token"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if user != "" && pass != "" {
+		req.SetBasicAuth(user, pass)
+	}
+
+	return nil
+}
+
+// render executes text as a template of power parameters. Parameters
+// which are not set fail the request, rather than being sent empty.
+func (c *webhookConn) render(name, text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidWebhook, err)
+	}
+
+	var b strings.Builder
+
+	if err := t.Execute(&b, c.opts); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidWebhook, err)
+	}
+
+	return b.String(), nil
+}
+
+func (c *webhookConn) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// webhookUserAgent returns the User-Agent of webhook requests, with the
+// version of the Agent when it is known
+func webhookUserAgent() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return "MAAS " + info.Main.Version
+	}
+
+	return "MAAS"
+}
+
+// webhookStatusOK returns whether code is one of status codes of success,
+// comma separated, or below 400 if there are none, as with the power driver
+func webhookStatusOK(codes string, code int) (bool, error) {
+	if strings.TrimSpace(codes) == "" {
+		return code < http.StatusBadRequest, nil
+	}
+
+	for _, s := range strings.Split(codes, ",") {
+		expected, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return false, fmt.Errorf("%w: status %q", ErrInvalidWebhook, codes)
+		}
+
+		if expected == code {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
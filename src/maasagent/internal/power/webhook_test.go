@@ -0,0 +1,332 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package power
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeController is a home-grown power controller of machines by name
+type fakeController struct {
+	machines map[string]string
+	calls    []string
+	mutex    sync.Mutex
+}
+
+func (f *fakeController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer t0ken" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body) //nolint:errcheck // the body is checked by tests
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Rack")+" "+string(body))
+
+	name := r.URL.Query().Get("machine")
+	if _, ok := f.machines[name]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/on":
+		f.machines[name] = "running"
+	case "/off":
+		f.machines[name] = "stopped"
+	case "/status":
+		//nolint:errcheck // the client fails anyway
+		w.Write([]byte(`{"status": "` + f.machines[name] + `"}`))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	//nolint:errcheck // the client fails anyway
+	w.Write([]byte(`{"result": "done"}`))
+}
+
+func TestWebhookDriver(t *testing.T) {
+	testcases := map[string]struct {
+		action  string
+		machine string
+		opts    map[string]interface{}
+		state   string
+		calls   []string
+		err     error
+	}{
+		"on": {
+			action: "on",
+			state:  "on",
+			calls:  []string{"PUT /on rack1 {\"name\": \"m1\"}", "GET /status rack1 "},
+		},
+		"off": {
+			action: "off",
+			state:  "off",
+			calls:  []string{"POST /off rack1 ", "GET /status rack1 "},
+		},
+		"cycle": {
+			action: "cycle",
+			state:  "on",
+			calls:  []string{"GET /status rack1 ", "PUT /on rack1 {\"name\": \"m1\"}", "GET /status rack1 "},
+		},
+		"cycle when on": {
+			action:  "cycle",
+			machine: "running",
+			state:   "on",
+			calls: []string{
+				"GET /status rack1 ", "POST /off rack1 ", "GET /status rack1 ",
+				"PUT /on rack1 {\"name\": \"m1\"}", "GET /status rack1 ",
+			},
+		},
+		"never transitions": {
+			action: "on",
+			opts:   map[string]interface{}{"power_on_regex": "up"},
+			calls: []string{
+				"PUT /on rack1 {\"name\": \"m1\"}", "GET /status rack1 ",
+				"PUT /on rack1 {\"name\": \"m1\"}", "GET /status rack1 ",
+			},
+			err: ErrWrongPowerState,
+		},
+		"not found": {
+			action: "off",
+			opts:   map[string]interface{}{"power_off_uri": "{{.server}}/off?machine=m2"},
+			calls:  []string{"POST /off rack1 ", "POST /off rack1 ", "POST /off rack1 ", "POST /off rack1 "},
+			err:    ErrUnexpectedResponse,
+		},
+		"status": {
+			action: "status",
+			state:  "off",
+			calls:  []string{"GET /status rack1 "},
+		},
+		"without query": {
+			action: "on",
+			opts:   map[string]interface{}{"power_query_uri": ""},
+			state:  "on",
+			calls:  []string{"PUT /on rack1 {\"name\": \"m1\"}"},
+		},
+		"unknown state": {
+			action: "status",
+			opts:   map[string]interface{}{"power_on_regex": "up", "power_off_regex": "down"},
+			state:  "unknown",
+			calls:  []string{"GET /status rack1 "},
+		},
+		"unexpected status": {
+			action: "off",
+			opts:   map[string]interface{}{"power_off_status": "200"},
+			calls:  []string{"POST /off rack1 ", "POST /off rack1 "},
+			err:    ErrUnexpectedResponse,
+		},
+		"unexpected response": {
+			action: "off",
+			opts:   map[string]interface{}{"power_off_expect": `"result": "ok"`},
+			calls:  []string{"POST /off rack1 ", "POST /off rack1 "},
+			err:    ErrUnexpectedResponse,
+		},
+		"missing parameter": {
+			action: "off",
+			opts:   map[string]interface{}{"power_off_uri": "{{.server}}/off?machine={{.missing}}"},
+			err:    ErrInvalidWebhook,
+		},
+		"invalid header": {
+			action: "off",
+			opts:   map[string]interface{}{"power_headers": "X-Rack"},
+			err:    ErrInvalidWebhook,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			machine := tc.machine
+			if machine == "" {
+				machine = "stopped"
+			}
+
+			controller := &fakeController{machines: map[string]string{"m1": machine}}
+
+			srv := httptest.NewTLSServer(controller)
+			defer srv.Close()
+
+			opts := map[string]interface{}{
+				"server":          srv.URL,
+				"machine":         "m1",
+				"power_token":     "t0ken",
+				"power_headers":   "X-Rack: {{.rack}}\n",
+				"rack":            "rack1",
+				"power_on_uri":    "{{.server}}/on?machine={{.machine}}",
+				"power_on_method": "put",
+				"power_on_body":   `{"name": "{{.machine}}"}`,
+				"power_off_uri":   "{{.server}}/off?machine={{.machine}}",
+				"power_query_uri": "{{.server}}/status?machine={{.machine}}",
+			}
+
+			for k, v := range tc.opts {
+				opts[k] = v
+			}
+
+			d := webhookDriver{wait: []time.Duration{time.Millisecond, time.Millisecond}}
+
+			actions := map[string]func(context.Context, map[string]interface{}) (string, PowerDetails, error){
+				"on":     d.On,
+				"off":    d.Off,
+				"cycle":  d.Cycle,
+				"status": d.Status,
+			}
+
+			state, _, err := actions[tc.action](context.Background(), opts)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.state, state)
+			}
+
+			assert.Equal(t, tc.calls, controller.calls)
+		})
+	}
+}
+
+func TestWebhookHeaders(t *testing.T) {
+	var header http.Header
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		//nolint:errcheck // the client fails anyway
+		w.Write([]byte(`{"status": "stopped"}`))
+	}))
+	defer srv.Close()
+
+	opts := map[string]interface{}{
+		"system_id":       "abc123",
+		"power_query_uri": srv.URL,
+		"power_user":      "admin",
+		"power_pass":      "secret",
+	}
+
+	state, _, err := webhookDriver{}.Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "off", state)
+
+	assert.Equal(t, "abc123", header.Get("System_Id"))
+	assert.Equal(t, "application/json", header.Get("Accept"))
+	assert.True(t, strings.HasPrefix(header.Get("User-Agent"), "MAAS"))
+
+	user, pass, ok := (&http.Request{Header: header}).BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "secret", pass)
+
+	delete(opts, "power_pass")
+
+	_, _, err = webhookDriver{}.Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Empty(t, header.Get("Authorization"))
+}
+
+func TestWebhookTrailingSlash(t *testing.T) {
+	var paths []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+
+		if r.URL.Path != "/status/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		//nolint:errcheck // the client fails anyway
+		w.Write([]byte(`{"status": "running"}`))
+	}))
+	defer srv.Close()
+
+	opts := map[string]interface{}{"power_query_uri": srv.URL + "/status"}
+
+	state, _, err := webhookDriver{}.Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "on", state)
+	assert.Equal(t, []string{"/status", "/status/"}, paths)
+}
+
+func TestWebhookVerifySSL(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		//nolint:errcheck // the client fails anyway
+		w.Write([]byte(`status: stopped`))
+	}))
+	defer srv.Close()
+
+	opts := map[string]interface{}{
+		"power_query_uri":  srv.URL,
+		"power_verify_ssl": "y",
+	}
+
+	_, _, err := webhookDriver{}.Status(context.Background(), opts)
+	assert.Error(t, err)
+
+	opts["power_verify_ssl"] = "n"
+
+	state, _, err := webhookDriver{}.Status(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "off", state)
+}
+
+func TestWebhookStatusOK(t *testing.T) {
+	testcases := map[string]struct {
+		codes string
+		code  int
+		ok    bool
+		err   bool
+	}{
+		"2xx":          {code: 204, ok: true},
+		"3xx":          {code: 302, ok: true},
+		"4xx":          {code: 404},
+		"listed":       {codes: "200, 302", code: 302, ok: true},
+		"not listed":   {codes: "200", code: 202},
+		"invalid list": {codes: "2xx", code: 200, err: true},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ok, err := webhookStatusOK(tc.codes, tc.code)
+			if tc.err {
+				assert.ErrorIs(t, err, ErrInvalidWebhook)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.ok, ok)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package redact strips secrets and personal data from data the Agent
+// forwards (exported events, webhooks) or stores (text artifacts, e.g.
+// console logs), for sites with compliance constraints. Filters are
+// configured with regular expressions matched against text and names of
+// JSON fields which values are always replaced.
+package redact
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// DefaultReplacement replaces redacted data unless a rule sets its own
+const DefaultReplacement = "[REDACTED]"
+
+// maxLineLength limits how much of a line is buffered by Reader. Longer
+// lines are filtered in chunks.
+const maxLineLength = 64 * 1024
+
+// ErrInvalidRule is returned for rules with invalid patterns or unknown
+// presets
+var ErrInvalidRule = errors.New("invalid redaction rule")
+
+// presets are rules which can be enabled by name
+var presets = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"ipv4":  `\b(?:\d{1,3}\.){3}\d{1,3}\b`,
+	"mac":   `\b(?:[0-9A-Fa-f]{2}[:-]){5}[0-9A-Fa-f]{2}\b`,
+	// Values of key=value and key: value pairs with secret names
+	"password": `(?i)\b(?:password|passwd|pass|secret|token|api[_-]?key)\b\s*[=:]\s*("[^"]*"|'[^']*'|\S+)`,
+	// Credentials of HTTP Authorization headers
+	"bearer": `(?i)\b(?:bearer|basic)\s+([A-Za-z0-9._~+/=-]+)`,
+}
+
+// Rule replaces matches of a regular expression (RE2 syntax). If Pattern
+// has groups, only the first group is replaced, e.g. `password=(\S+)`
+// keeps "password=".
+type Rule struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// Config of redaction. Nothing is redacted if it is empty.
+type Config struct {
+	// Presets are built-in rules enabled by name: email, ipv4, mac,
+	// password and bearer
+	Presets []string `yaml:"presets"`
+	Rules   []Rule   `yaml:"rules"`
+	// Fields are names of JSON fields (case insensitive) which values are
+	// replaced, whatever they are
+	Fields []string `yaml:"fields"`
+	// Artifacts are subsystems of artifacts (e.g. "console") which are
+	// filtered as text before they are stored
+	Artifacts []string `yaml:"artifacts"`
+}
+
+type rule struct {
+	re          *regexp.Regexp
+	replacement []byte
+}
+
+// Filter redacts text and JSON. A nil Filter returns data as it is.
+type Filter struct {
+	rules  []rule
+	fields map[string]struct{}
+}
+
+// New returns Filter of cfg, or nil if cfg has no rules nor fields
+func New(cfg Config) (*Filter, error) {
+	f := &Filter{fields: make(map[string]struct{}, len(cfg.Fields))}
+
+	for _, name := range cfg.Presets {
+		pattern, ok := presets[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown preset %q", ErrInvalidRule, name)
+		}
+
+		f.rules = append(f.rules, rule{
+			re:          regexp.MustCompile(pattern),
+			replacement: []byte(DefaultReplacement),
+		})
+	}
+
+	for _, r := range cfg.Rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidRule, r.Name, err)
+		}
+
+		replacement := r.Replacement
+		if replacement == "" {
+			replacement = DefaultReplacement
+		}
+
+		f.rules = append(f.rules, rule{re: re, replacement: []byte(replacement)})
+	}
+
+	for _, name := range cfg.Fields {
+		f.fields[strings.ToLower(name)] = struct{}{}
+	}
+
+	if len(f.rules) == 0 && len(f.fields) == 0 {
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+// Text returns b with matches of rules replaced. b is never modified.
+func (f *Filter) Text(b []byte) []byte {
+	if f == nil {
+		return b
+	}
+
+	for _, r := range f.rules {
+		b = r.apply(b)
+	}
+
+	return b
+}
+
+// String is Text for strings
+func (f *Filter) String(s string) string {
+	if f == nil || len(f.rules) == 0 {
+		return s
+	}
+
+	return string(f.Text([]byte(s)))
+}
+
+// JSON returns JSON document b with values of fields replaced and rules
+// applied to all strings, so the result is still valid JSON. Documents
+// which are not valid JSON are filtered as text.
+func (f *Filter) JSON(b []byte) []byte {
+	if f == nil {
+		return b
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return f.Text(b)
+	}
+
+	v, changed := f.value(v)
+	if !changed {
+		return b
+	}
+
+	res, err := json.Marshal(v)
+	if err != nil {
+		return f.Text(b)
+	}
+
+	return res
+}
+
+// value redacts v decoded from JSON, returning whether it was changed
+func (f *Filter) value(v interface{}) (interface{}, bool) {
+	changed := false
+
+	switch v := v.(type) {
+	case string:
+		s := f.String(v)
+		return s, s != v
+	case map[string]interface{}:
+		for k, item := range v {
+			if _, ok := f.fields[strings.ToLower(k)]; ok {
+				v[k] = DefaultReplacement
+				changed = true
+
+				continue
+			}
+
+			if item, ok := f.value(item); ok {
+				v[k] = item
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if item, ok := f.value(item); ok {
+				v[i] = item
+				changed = true
+			}
+		}
+	}
+
+	return v, changed
+}
+
+// Reader returns a reader of r filtered as text line by line, so matches
+// within a line are found whatever chunks r returns
+func (f *Filter) Reader(r io.Reader) io.Reader {
+	if f == nil || len(f.rules) == 0 {
+		return r
+	}
+
+	return &reader{filter: f, r: bufio.NewReaderSize(r, maxLineLength)}
+}
+
+type reader struct {
+	filter *Filter
+	r      *bufio.Reader
+	buf    []byte
+	err    error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		line, err := r.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			err = nil
+		}
+
+		// line is consumed before the next ReadSlice() overwrites it
+		r.buf, r.err = r.filter.Text(line), err
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+// apply replaces matches of the rule, or of its first group
+func (r rule) apply(b []byte) []byte {
+	if r.re.NumSubexp() == 0 {
+		return r.re.ReplaceAllLiteral(b, r.replacement)
+	}
+
+	matches := r.re.FindAllSubmatchIndex(b, -1)
+	if matches == nil {
+		return b
+	}
+
+	res := make([]byte, 0, len(b))
+	last := 0
+
+	for _, m := range matches {
+		if m[2] < 0 {
+			continue
+		}
+
+		res = append(res, b[last:m[2]]...)
+		res = append(res, r.replacement...)
+		last = m[3]
+	}
+
+	return append(res, b[last:]...)
+}
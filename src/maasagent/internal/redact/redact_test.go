@@ -0,0 +1,165 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package redact
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+func TestFilterText(t *testing.T) {
+	testcases := map[string]struct {
+		cfg  Config
+		in   string
+		want string
+	}{
+		"email": {
+			cfg:  Config{Presets: []string{"email"}},
+			in:   "user admin@example.com logged in",
+			want: "user [REDACTED] logged in",
+		},
+		"ipv4 and mac": {
+			cfg:  Config{Presets: []string{"ipv4", "mac"}},
+			in:   "DHCPACK on 10.0.0.5 to 52:54:00:12:34:56",
+			want: "DHCPACK on [REDACTED] to [REDACTED]",
+		},
+		"password keeps the key": {
+			cfg:  Config{Presets: []string{"password"}},
+			in:   `login password=hunter2 token: "abc def" user=root`,
+			want: `login password=[REDACTED] token: [REDACTED] user=root`,
+		},
+		"bearer": {
+			cfg:  Config{Presets: []string{"bearer"}},
+			in:   "Authorization: Bearer eyJhbGciOi.J9",
+			want: "Authorization: Bearer [REDACTED]",
+		},
+		"rule with replacement": {
+			cfg: Config{Rules: []Rule{{
+				Name:        "serial",
+				Pattern:     `SN-\d+`,
+				Replacement: "SN-XXXX",
+			}}},
+			in:   "chassis SN-12345 and SN-678",
+			want: "chassis SN-XXXX and SN-XXXX",
+		},
+		"rule with group": {
+			cfg:  Config{Rules: []Rule{{Pattern: `ssn=(\d+)`}}},
+			in:   "ssn=123 ssn=456",
+			want: "ssn=[REDACTED] ssn=[REDACTED]",
+		},
+		"no match": {
+			cfg:  Config{Presets: []string{"email"}},
+			in:   "nothing to see",
+			want: "nothing to see",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := New(tc.cfg)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, f.String(tc.in))
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	f, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	assert.Equal(t, "a@b.cd", f.String("a@b.cd"))
+
+	_, err = New(Config{Presets: []string{"phone"}})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+
+	_, err = New(Config{Rules: []Rule{{Name: "broken", Pattern: "("}}})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
+
+func TestFilterJSON(t *testing.T) {
+	f, err := New(Config{Presets: []string{"email"}, Fields: []string{"Password", "ssh_keys"}})
+	require.NoError(t, err)
+
+	out := f.JSON([]byte(`{"name":"deploy","password":"x","owner":"a@example.com",` +
+		`"nested":[{"ssh_keys":["k1"],"count":12345678901234567890}]}`))
+	assert.JSONEq(t, `{"name":"deploy","password":"[REDACTED]","owner":"[REDACTED]",`+
+		`"nested":[{"ssh_keys":"[REDACTED]","count":12345678901234567890}]}`, string(out))
+
+	unchanged := []byte(`{"b":1, "a":2}`)
+	assert.Equal(t, unchanged, f.JSON(unchanged))
+
+	assert.Equal(t, "not json [REDACTED]", string(f.JSON([]byte("not json a@example.com"))))
+}
+
+func TestFilterReader(t *testing.T) {
+	f, err := New(Config{Presets: []string{"password"}})
+	require.NoError(t, err)
+
+	in := "boot ok\nlogin password=secret1\n" + strings.Repeat("x", maxLineLength+10) + "\nend password=p"
+
+	// One byte at a time, so matches are split between reads
+	out, err := io.ReadAll(f.Reader(iotest.OneByteReader(strings.NewReader(in))))
+	require.NoError(t, err)
+
+	assert.Equal(t, "boot ok\nlogin password=[REDACTED]\n"+strings.Repeat("x", maxLineLength+10)+
+		"\nend password=[REDACTED]", string(out))
+}
+
+type memStore struct {
+	blob.Store
+	data map[string][]byte
+	size map[string]int64
+}
+
+func (s *memStore) Put(_ context.Context, key string, r io.Reader, size int64) error {
+	b, err := io.ReadAll(r)
+	s.data[key], s.size[key] = b, size
+
+	return err
+}
+
+func TestStore(t *testing.T) {
+	f, err := New(Config{Presets: []string{"ipv4"}})
+	require.NoError(t, err)
+
+	mem := &memStore{data: make(map[string][]byte), size: make(map[string]int64)}
+	s := NewStore(mem, f, []string{"console"})
+
+	data := []byte("link up 10.0.0.1\n")
+
+	require.NoError(t, s.Put(context.Background(), "console/abc/1.log", bytes.NewReader(data), 17))
+	require.NoError(t, s.Put(context.Background(), "pcap/abc/1.pcap", bytes.NewReader(data), 17))
+
+	assert.Equal(t, "link up [REDACTED]\n", string(mem.data["console/abc/1.log"]))
+	assert.Equal(t, int64(-1), mem.size["console/abc/1.log"])
+	assert.Equal(t, data, mem.data["pcap/abc/1.pcap"])
+	assert.Equal(t, int64(17), mem.size["pcap/abc/1.pcap"])
+
+	assert.Same(t, mem, NewStore(mem, nil, []string{"console"}))
+}
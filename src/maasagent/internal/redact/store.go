@@ -0,0 +1,58 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package redact
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"maas.io/core/src/maasagent/internal/blob"
+)
+
+// store filters artifacts of some subsystems before they are stored
+type store struct {
+	blob.Store
+	filter     *Filter
+	subsystems map[string]struct{}
+}
+
+// NewStore returns blob.Store which filters artifacts of subsystems (the
+// first element of the key, e.g. "console") with f as text. Size of
+// filtered artifacts is not known in advance. store is returned as it is
+// if there is nothing to filter.
+func NewStore(s blob.Store, f *Filter, subsystems []string) blob.Store {
+	if f == nil || len(f.rules) == 0 || len(subsystems) == 0 {
+		return s
+	}
+
+	res := &store{Store: s, filter: f, subsystems: make(map[string]struct{}, len(subsystems))}
+
+	for _, sub := range subsystems {
+		res.subsystems[sub] = struct{}{}
+	}
+
+	return res
+}
+
+func (s *store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	sub, _, _ := strings.Cut(key, "/")
+	if _, ok := s.subsystems[sub]; !ok {
+		return s.Store.Put(ctx, key, r, size)
+	}
+
+	return s.Store.Put(ctx, key, s.filter.Reader(r), -1)
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+)
+
+// sensitiveAttributes are never turned into event attributes
+var sensitiveAttributes = map[string]struct{}{
+	"driver_opts.power_pass": {},
+	"driver_opts.password":   {},
+	"driver_opts.key":        {},
+	"driver_opts.secret":     {},
+}
+
+// NewInterceptor returns a worker interceptor that emits an event for every
+// activity execution and rejects executions while a circuit is open.
+// Attributes of events are the activity parameters flattened with dots,
+// e.g. "driver_opts.power_address", together with "error" and "error_type"
+// of failed executions.
+func NewInterceptor(e *Engine) interceptor.WorkerInterceptor {
+	return &remediationInterceptor{engine: e}
+}
+
+type remediationInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	engine *Engine
+}
+
+func (r *remediationInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityRemediation{engine: r.engine}
+	i.Next = next
+
+	return i
+}
+
+type activityRemediation struct {
+	interceptor.ActivityInboundInterceptorBase
+	engine *Engine
+}
+
+func (a *activityRemediation) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	source := activity.GetInfo(ctx).ActivityType.Name
+	attrs := attributes(in.Args)
+
+	if err := a.engine.Allow(source, attrs); err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "ErrCircuitOpen", err)
+	}
+
+	res, err := a.Next.ExecuteActivity(ctx, in)
+
+	ev := Event{Kind: EventActivitySucceeded, Source: source, Attributes: attrs}
+
+	if err != nil {
+		ev.Kind = EventActivityFailed
+		attrs["error"] = err.Error()
+
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) {
+			attrs["error_type"] = appErr.Type()
+		}
+	}
+
+	a.engine.Handle(ev)
+
+	return res, err
+}
+
+// attributes flattens activity parameters into event attributes
+func attributes(args []interface{}) map[string]string {
+	attrs := make(map[string]string)
+
+	for _, arg := range args {
+		b, err := json.Marshal(arg)
+		if err != nil {
+			continue
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			continue
+		}
+
+		flatten(attrs, "", v)
+	}
+
+	return attrs
+}
+
+func flatten(attrs map[string]string, prefix string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+
+			flatten(attrs, k, item)
+		}
+	case []interface{}, nil:
+		// Lists are not supported by conditions
+	default:
+		if prefix == "" {
+			return
+		}
+
+		if _, ok := sensitiveAttributes[prefix]; ok {
+			return
+		}
+
+		attrs[prefix] = fmt.Sprint(v)
+	}
+}
+
+// SetRemediationRulesParam is the activity parameter for set-remediation-rules
+type SetRemediationRulesParam struct {
+	Rules []Rule `json:"rules"`
+}
+
+func (e *Engine) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// ConfigurationActivities allows the Region to replace rules configured
+// on the Agent.
+func (e *Engine) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{"set-remediation-rules": e.setRules}
+}
+
+func (e *Engine) setRules(_ context.Context, param SetRemediationRulesParam) error {
+	if err := e.SetRules(param.Rules); err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return nil
+}
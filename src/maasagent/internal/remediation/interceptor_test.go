@@ -0,0 +1,44 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package remediation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"maas.io/core/src/maasagent/internal/power"
+)
+
+func TestAttributes(t *testing.T) {
+	attrs := attributes([]interface{}{power.PowerQueryParam{
+		PowerParam: power.PowerParam{
+			DriverType: "ipmi",
+			DriverOpts: map[string]interface{}{
+				"power_address": "10.0.0.1",
+				"power_user":    "admin",
+				"power_pass":    "secret",
+				"mac_address":   []interface{}{"00:16:3e:00:00:01"},
+				"k_g":           nil,
+			},
+		},
+	}})
+
+	assert.Equal(t, map[string]string{
+		"driver_type":               "ipmi",
+		"driver_opts.power_address": "10.0.0.1",
+		"driver_opts.power_user":    "admin",
+	}, attrs)
+}
@@ -0,0 +1,464 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package remediation evaluates operator defined rules (event, condition,
+// action) against events of the Agent, so responses to recurring failures
+// (e.g. stop querying a BMC that keeps rejecting credentials) can be
+// configured without code changes.
+package remediation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/backpressure"
+	"maas.io/core/src/maasagent/internal/ringbuf"
+)
+
+// Kinds of events
+const (
+	EventActivityFailed    = "activity-failed"
+	EventActivitySucceeded = "activity-succeeded"
+)
+
+// Types of actions
+const (
+	// ActionOpenCircuit rejects executions of the event source (within
+	// the same group) for Duration
+	ActionOpenCircuit = "open-circuit"
+	// ActionNotify reports the triggered rule to the Region
+	ActionNotify = "notify"
+)
+
+const (
+	// notificationBufferBytes limits notifications waiting to be reported
+	notificationBufferBytes = 1024 * 1024
+	// notificationOverhead is an approximate size of Notification
+	// without strings
+	notificationOverhead   = 64
+	defaultCircuitDuration = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidRule is returned when a rule cannot be evaluated
+	ErrInvalidRule = errors.New("invalid remediation rule")
+	// ErrCircuitOpen is returned when execution is rejected by an open circuit
+	ErrCircuitOpen = errors.New("circuit is open")
+)
+
+// Event is something that happened in the Agent, e.g. an activity failed
+type Event struct {
+	// Attributes describe the event, e.g. "error" or "driver_type"
+	Attributes map[string]string
+	Kind       string
+	// Source is what emitted the event, e.g. activity name
+	Source string
+}
+
+// Action is executed when a rule is triggered
+type Action struct {
+	Type string `yaml:"type" json:"type"`
+	// Duration of ActionOpenCircuit
+	Duration time.Duration `yaml:"duration" json:"duration"`
+}
+
+// Rule triggers actions when Count events matching it happen within
+// Window. Events are counted separately for every combination of values
+// of GroupBy attributes (e.g. per BMC address).
+type Rule struct {
+	Name string `yaml:"name" json:"name"`
+	// Event kind and Source (empty matches any source)
+	Event  string `yaml:"event" json:"event"`
+	Source string `yaml:"source" json:"source"`
+	// Match are regular expressions attributes of the event must match
+	Match   map[string]string `yaml:"match" json:"match"`
+	GroupBy []string          `yaml:"group_by" json:"group_by"`
+	Actions []Action          `yaml:"actions" json:"actions"`
+	// Count defaults to 1, Window to unlimited
+	Count  int           `yaml:"count" json:"count"`
+	Window time.Duration `yaml:"window" json:"window"`
+}
+
+// Notification is reported when a rule with ActionNotify is triggered
+type Notification struct {
+	Time   time.Time         `json:"time"`
+	Group  map[string]string `json:"group,omitempty"`
+	Rule   string            `json:"rule"`
+	Source string            `json:"source"`
+	Error  string            `json:"error,omitempty"`
+	Count  int               `json:"count"`
+}
+
+func (n Notification) size() int {
+	size := notificationOverhead + len(n.Rule) + len(n.Source) + len(n.Error)
+	for k, v := range n.Group {
+		size += len(k) + len(v)
+	}
+
+	return size
+}
+
+// Circuit is an open circuit rejecting executions of Source
+type Circuit struct {
+	Until  time.Time         `json:"until"`
+	Group  map[string]string `json:"group,omitempty"`
+	Rule   string            `json:"rule"`
+	Source string            `json:"source"`
+}
+
+// Reporter is used to report notifications (e.g. to the Region).
+type Reporter interface {
+	Report(ctx context.Context, notifications []Notification) error
+}
+
+type compiledRule struct {
+	match map[string]*regexp.Regexp
+	Rule
+}
+
+// Engine evaluates rules against events and keeps open circuits
+type Engine struct {
+	reporter      Reporter
+	pressure      *backpressure.Controller
+	now           func() time.Time
+	history       map[string][]time.Time
+	circuits      map[string]Circuit
+	notifications *ringbuf.Buffer[Notification]
+	rules         []compiledRule
+	mutex         sync.Mutex
+}
+
+// EngineOption allows to set additional Engine options
+type EngineOption func(*Engine)
+
+// NewEngine returns Engine evaluating rules
+func NewEngine(rules []Rule, options ...EngineOption) (*Engine, error) {
+	e := &Engine{
+		now:           time.Now,
+		history:       make(map[string][]time.Time),
+		circuits:      make(map[string]Circuit),
+		notifications: ringbuf.New("remediation", notificationBufferBytes, Notification.size),
+	}
+
+	for _, opt := range options {
+		opt(e)
+	}
+
+	if err := e.SetRules(rules); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// WithReporter sets Reporter called with notifications.
+func WithReporter(r Reporter) EngineOption {
+	return func(e *Engine) {
+		e.reporter = r
+	}
+}
+
+// WithBackpressure sets Controller used to batch notifications for longer
+// when the Region is slow to acknowledge them.
+func WithBackpressure(c *backpressure.Controller) EngineOption {
+	return func(e *Engine) {
+		e.pressure = c
+	}
+}
+
+func compile(rules []Rule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	names := make(map[string]struct{}, len(rules))
+
+	for _, r := range rules {
+		if r.Name == "" || r.Event == "" {
+			return nil, fmt.Errorf("%w: name and event are required", ErrInvalidRule)
+		}
+
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate rule %q", ErrInvalidRule, r.Name)
+		}
+
+		names[r.Name] = struct{}{}
+
+		if len(r.Actions) == 0 {
+			return nil, fmt.Errorf("%w: %q has no actions", ErrInvalidRule, r.Name)
+		}
+
+		for _, a := range r.Actions {
+			if a.Type != ActionOpenCircuit && a.Type != ActionNotify {
+				return nil, fmt.Errorf("%w: %q: unknown action %q", ErrInvalidRule, r.Name, a.Type)
+			}
+		}
+
+		c := compiledRule{Rule: r, match: make(map[string]*regexp.Regexp, len(r.Match))}
+
+		for attr, expr := range r.Match {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q: %w", ErrInvalidRule, r.Name, err)
+			}
+
+			c.match[attr] = re
+		}
+
+		if c.Count <= 0 {
+			c.Count = 1
+		}
+
+		compiled = append(compiled, c)
+	}
+
+	return compiled, nil
+}
+
+// SetRules replaces rules. Counted events and open circuits of rules that
+// no longer exist are dropped.
+func (e *Engine) SetRules(rules []Rule) error {
+	compiled, err := compile(rules)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.rules = compiled
+	e.history = make(map[string][]time.Time)
+
+	for k, c := range e.circuits {
+		if !slices.ContainsFunc(compiled, func(r compiledRule) bool { return r.Name == c.Rule }) {
+			delete(e.circuits, k)
+		}
+	}
+
+	return nil
+}
+
+func (r *compiledRule) matches(ev Event) bool {
+	if r.Event != ev.Kind || (r.Source != "" && r.Source != ev.Source) {
+		return false
+	}
+
+	for attr, re := range r.match {
+		if !re.MatchString(ev.Attributes[attr]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *compiledRule) group(attrs map[string]string) map[string]string {
+	if len(r.GroupBy) == 0 {
+		return nil
+	}
+
+	g := make(map[string]string, len(r.GroupBy))
+	for _, attr := range r.GroupBy {
+		g[attr] = attrs[attr]
+	}
+
+	return g
+}
+
+// groupKey returns a stable key of the group
+func groupKey(prefix string, g map[string]string) string {
+	keys := make([]string, 0, len(g))
+	for k := range g {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+
+	b.WriteString(prefix)
+
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + g[k])
+	}
+
+	return b.String()
+}
+
+// Handle evaluates rules against the event and executes actions of
+// triggered rules. It is safe to call Handle on nil Engine.
+func (e *Engine) Handle(ev Event) {
+	if e == nil {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := e.now()
+
+	for i := range e.rules {
+		r := &e.rules[i]
+		if !r.matches(ev) {
+			continue
+		}
+
+		group := r.group(ev.Attributes)
+		key := groupKey(r.Name, group)
+
+		history := append(e.history[key], now)
+		if r.Window > 0 {
+			cutoff := now.Add(-r.Window)
+
+			for len(history) > 0 && !history[0].After(cutoff) {
+				history = history[1:]
+			}
+		}
+
+		if len(history) < r.Count {
+			e.history[key] = history
+			continue
+		}
+
+		// Rule is triggered again only after another Count events
+		delete(e.history, key)
+
+		e.trigger(r, ev, group, len(history), now)
+	}
+}
+
+func (e *Engine) trigger(r *compiledRule, ev Event, group map[string]string, count int, now time.Time) {
+	for _, a := range r.Actions {
+		switch a.Type {
+		case ActionOpenCircuit:
+			d := a.Duration
+			if d <= 0 {
+				d = defaultCircuitDuration
+			}
+
+			e.circuits[groupKey(ev.Source, group)] = Circuit{
+				Rule:   r.Name,
+				Source: ev.Source,
+				Group:  group,
+				Until:  now.Add(d),
+			}
+
+			log.Warn().Str("rule", r.Name).Str("source", ev.Source).
+				Interface("group", group).Dur("duration", d).Msg("Circuit opened")
+		case ActionNotify:
+			n := Notification{
+				Time:   now,
+				Rule:   r.Name,
+				Source: ev.Source,
+				Group:  group,
+				Error:  ev.Attributes["error"],
+				Count:  count,
+			}
+
+			e.notifications.Push(n)
+		}
+	}
+}
+
+// Allow returns ErrCircuitOpen if there is an open circuit for the source
+// whose group matches attributes. It is safe to call Allow on nil Engine.
+func (e *Engine) Allow(source string, attrs map[string]string) error {
+	if e == nil {
+		return nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := e.now()
+
+	for k, c := range e.circuits {
+		if !now.Before(c.Until) {
+			delete(e.circuits, k)
+			continue
+		}
+
+		if c.Source != source || !groupMatches(c.Group, attrs) {
+			continue
+		}
+
+		return fmt.Errorf("%w: %s until %s (rule %q)", ErrCircuitOpen, source,
+			c.Until.Format(time.RFC3339), c.Rule)
+	}
+
+	return nil
+}
+
+func groupMatches(group, attrs map[string]string) bool {
+	for k, v := range group {
+		if attrs[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Circuits returns currently open circuits
+func (e *Engine) Circuits() []Circuit {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := e.now()
+	res := make([]Circuit, 0, len(e.circuits))
+
+	for _, c := range e.circuits {
+		if now.Before(c.Until) {
+			res = append(res, c)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Until.Before(res[j].Until) })
+
+	return res
+}
+
+// Run reports notifications until ctx is cancelled. Notifications emitted
+// in a quick succession are reported together.
+func (e *Engine) Run(ctx context.Context) {
+	for {
+		notifications, err := e.notifications.PopAll(ctx)
+		if err != nil {
+			return
+		}
+
+		if e.reporter == nil {
+			continue
+		}
+
+		if err := e.reporter.Report(ctx, notifications); err != nil {
+			log.Warn().Err(err).Msg("Failed to report remediation notifications")
+		}
+
+		e.pressure.Wait(ctx)
+	}
+}
+
+// BufferStats returns usage and drop counters of notifications waiting
+// to be reported.
+func (e *Engine) BufferStats() ringbuf.Stats {
+	return e.notifications.Stats()
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package remediation
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReporter struct {
+	reported chan []Notification
+}
+
+func (r *fakeReporter) Report(_ context.Context, n []Notification) error {
+	r.reported <- n
+	return nil
+}
+
+func authFailureRule() Rule {
+	return Rule{
+		Name:    "bmc-auth",
+		Event:   EventActivityFailed,
+		Source:  "power-query",
+		Match:   map[string]string{"error": "(?i)auth"},
+		GroupBy: []string{"driver_opts.power_address"},
+		Count:   3,
+		Window:  time.Minute,
+		Actions: []Action{
+			{Type: ActionOpenCircuit, Duration: 10 * time.Minute},
+			{Type: ActionNotify},
+		},
+	}
+}
+
+func authFailure(address string) Event {
+	return Event{
+		Kind:   EventActivityFailed,
+		Source: "power-query",
+		Attributes: map[string]string{
+			"driver_opts.power_address": address,
+			"error":                     "Authentication failed",
+		},
+	}
+}
+
+func TestOpenCircuit(t *testing.T) {
+	e, err := NewEngine([]Rule{authFailureRule()})
+	require.NoError(t, err)
+
+	now := time.Now()
+	e.now = func() time.Time { return now }
+
+	bmc1 := map[string]string{"driver_opts.power_address": "10.0.0.1"}
+	bmc2 := map[string]string{"driver_opts.power_address": "10.0.0.2"}
+
+	for i := 0; i < 2; i++ {
+		e.Handle(authFailure("10.0.0.1"))
+	}
+
+	// Other BMCs are counted separately
+	e.Handle(authFailure("10.0.0.2"))
+
+	// Events not matching the rule are ignored
+	e.Handle(Event{Kind: EventActivityFailed, Source: "power-query",
+		Attributes: map[string]string{"driver_opts.power_address": "10.0.0.1", "error": "timeout"}})
+	e.Handle(Event{Kind: EventActivityFailed, Source: "power-on",
+		Attributes: authFailure("10.0.0.1").Attributes})
+
+	require.NoError(t, e.Allow("power-query", bmc1))
+
+	e.Handle(authFailure("10.0.0.1"))
+
+	assert.ErrorIs(t, e.Allow("power-query", bmc1), ErrCircuitOpen)
+	assert.NoError(t, e.Allow("power-query", bmc2))
+	assert.NoError(t, e.Allow("power-on", bmc1))
+	assert.Len(t, e.Circuits(), 1)
+
+	now = now.Add(10 * time.Minute)
+
+	assert.NoError(t, e.Allow("power-query", bmc1))
+	assert.Empty(t, e.Circuits())
+}
+
+func TestWindow(t *testing.T) {
+	e, err := NewEngine([]Rule{authFailureRule()})
+	require.NoError(t, err)
+
+	now := time.Now()
+	e.now = func() time.Time { return now }
+
+	bmc := map[string]string{"driver_opts.power_address": "10.0.0.1"}
+
+	for i := 0; i < 3; i++ {
+		e.Handle(authFailure("10.0.0.1"))
+
+		now = now.Add(40 * time.Second)
+	}
+
+	// Only two failures happened within a minute
+	assert.NoError(t, e.Allow("power-query", bmc))
+}
+
+func TestNotify(t *testing.T) {
+	reporter := &fakeReporter{reported: make(chan []Notification, 1)}
+
+	e, err := NewEngine([]Rule{authFailureRule()}, WithReporter(reporter))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		e.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	for i := 0; i < 3; i++ {
+		e.Handle(authFailure("10.0.0.1"))
+	}
+
+	select {
+	case n := <-reporter.reported:
+		require.Len(t, n, 1)
+		assert.Equal(t, "bmc-auth", n[0].Rule)
+		assert.Equal(t, "power-query", n[0].Source)
+		assert.Equal(t, map[string]string{"driver_opts.power_address": "10.0.0.1"}, n[0].Group)
+		assert.Equal(t, "Authentication failed", n[0].Error)
+		assert.Equal(t, 3, n[0].Count)
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not reported")
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	e, err := NewEngine([]Rule{authFailureRule()})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		e.Handle(authFailure("10.0.0.1"))
+	}
+
+	require.Len(t, e.Circuits(), 1)
+
+	// Circuits of removed rules are closed
+	require.NoError(t, e.SetRules(nil))
+	assert.Empty(t, e.Circuits())
+
+	testcases := map[string]struct {
+		rule Rule
+	}{
+		"no name": {
+			rule: Rule{Event: EventActivityFailed, Actions: []Action{{Type: ActionNotify}}},
+		},
+		"no actions": {
+			rule: Rule{Name: "x", Event: EventActivityFailed},
+		},
+		"unknown action": {
+			rule: Rule{Name: "x", Event: EventActivityFailed, Actions: []Action{{Type: "reboot"}}},
+		},
+		"invalid match": {
+			rule: Rule{Name: "x", Event: EventActivityFailed, Match: map[string]string{"error": "("},
+				Actions: []Action{{Type: ActionNotify}}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewEngine([]Rule{tc.rule})
+			assert.ErrorIs(t, err, ErrInvalidRule)
+		})
+	}
+
+	_, err = NewEngine([]Rule{authFailureRule(), authFailureRule()})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ringbuf provides in-memory queues of messages (e.g. events
+// waiting to be reported) with strict byte budgets. Producers never block:
+// once the budget is used up, the oldest messages are dropped and counted,
+// so a chatty producer cannot make the Agent run out of memory.
+package ringbuf
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Path is the URL path of buffer statistics on the local API
+const Path = "/buffers"
+
+// warnInterval limits how often drops of the same buffer are logged
+const warnInterval = time.Minute
+
+// Stats are usage and drop counters of a Buffer
+type Stats struct {
+	Name string `json:"name"`
+	// Messages and Bytes are currently buffered
+	Messages int   `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+	Budget   int64 `json:"budget"`
+	// Dropped and DroppedBytes count messages dropped since the start
+	Dropped      uint64 `json:"dropped"`
+	DroppedBytes uint64 `json:"dropped_bytes"`
+}
+
+type entry[T any] struct {
+	value T
+	size  int64
+}
+
+// Buffer is a FIFO queue of messages limited by the total size of them.
+// It is safe for concurrent use by multiple producers and consumers.
+type Buffer[T any] struct {
+	lastWarn time.Time
+	size     func(T) int
+	ready    chan struct{}
+	entries  []entry[T]
+	stats    Stats
+	mutex    sync.Mutex
+}
+
+// New returns a Buffer called name (used in Stats and logs), which keeps
+// at most budget bytes of messages. Size of a message is returned by size.
+func New[T any](name string, budget int64, size func(T) int) *Buffer[T] {
+	return &Buffer[T]{
+		size:  size,
+		ready: make(chan struct{}, 1),
+		stats: Stats{Name: name, Budget: budget},
+	}
+}
+
+// Push adds v to the end of the buffer, dropping the oldest messages if
+// there is not enough space. It returns false if v itself was dropped,
+// because it is bigger than the whole budget.
+func (b *Buffer[T]) Push(v T) bool {
+	size := int64(b.size(v))
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if size > b.stats.Budget {
+		b.drop(size)
+		return false
+	}
+
+	for b.stats.Bytes+size > b.stats.Budget {
+		oldest := b.entries[0]
+		b.entries[0] = entry[T]{}
+		b.entries = b.entries[1:]
+		b.stats.Bytes -= oldest.size
+		b.drop(oldest.size)
+	}
+
+	b.entries = append(b.entries, entry[T]{value: v, size: size})
+	b.stats.Bytes += size
+
+	b.signal()
+
+	return true
+}
+
+// drop counts a dropped message. b.mutex must be held.
+func (b *Buffer[T]) drop(size int64) {
+	b.stats.Dropped++
+	b.stats.DroppedBytes += uint64(size)
+
+	if now := time.Now(); now.Sub(b.lastWarn) >= warnInterval {
+		b.lastWarn = now
+
+		log.Warn().Str("buffer", b.stats.Name).Uint64("dropped", b.stats.Dropped).
+			Int64("budget", b.stats.Budget).Msg("Buffer is full, the oldest messages are dropped")
+	}
+}
+
+// signal wakes up a waiting consumer. b.mutex must be held.
+func (b *Buffer[T]) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Pop removes and returns the oldest message, waiting for one if the
+// buffer is empty, until ctx is cancelled.
+func (b *Buffer[T]) Pop(ctx context.Context) (T, error) {
+	res, err := b.pop(ctx, 1)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return res[0], nil
+}
+
+// PopAll removes and returns all messages, waiting for at least one if
+// the buffer is empty, until ctx is cancelled. It allows consumers to
+// report messages produced in a quick succession together.
+func (b *Buffer[T]) PopAll(ctx context.Context) ([]T, error) {
+	return b.pop(ctx, 0)
+}
+
+// pop returns up to n messages (all if n is 0)
+func (b *Buffer[T]) pop(ctx context.Context, n int) ([]T, error) {
+	for {
+		b.mutex.Lock()
+
+		if len(b.entries) > 0 {
+			if n == 0 || n > len(b.entries) {
+				n = len(b.entries)
+			}
+
+			res := make([]T, n)
+			for i := range res {
+				res[i] = b.entries[i].value
+				b.stats.Bytes -= b.entries[i].size
+				b.entries[i] = entry[T]{}
+			}
+
+			b.entries = b.entries[n:]
+			if len(b.entries) == 0 {
+				// Release the backing array, which might have grown in a burst
+				b.entries = nil
+			} else {
+				// Other consumers might be waiting
+				b.signal()
+			}
+
+			b.mutex.Unlock()
+
+			return res, nil
+		}
+
+		b.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-b.ready:
+		}
+	}
+}
+
+// Len returns the number of buffered messages
+func (b *Buffer[T]) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.entries)
+}
+
+// Stats returns usage and drop counters of the buffer
+func (b *Buffer[T]) Stats() Stats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := b.stats
+	s.Messages = len(b.entries)
+
+	return s
+}
+
+// Handler returns http.Handler serving Stats of buffers sorted by name
+func Handler(buffers ...func() Stats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		res := make([]Stats, 0, len(buffers))
+		for _, stats := range buffers {
+			res = append(res, stats())
+		}
+
+		sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+		w.Header().Set("Content-Type", "application/json")
+		//nolint:errcheck // nothing can be done if client went away
+		json.NewEncoder(w).Encode(res)
+	})
+}
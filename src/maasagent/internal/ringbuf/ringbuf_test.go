@@ -0,0 +1,224 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ringbuf
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func strlen(s string) int { return len(s) }
+
+func TestBufferPush(t *testing.T) {
+	testcases := map[string]struct {
+		in       []string
+		out      []string
+		pushed   []bool
+		dropped  uint64
+		dropSize uint64
+	}{
+		"within budget": {
+			in:     []string{"aa", "bb", "cc"},
+			out:    []string{"aa", "bb", "cc"},
+			pushed: []bool{true, true, true},
+		},
+		"oldest dropped": {
+			in:       []string{"aaaa", "bbbb", "cc", "dddddd"},
+			out:      []string{"cc", "dddddd"},
+			pushed:   []bool{true, true, true, true},
+			dropped:  2,
+			dropSize: 8,
+		},
+		"too big": {
+			in:       []string{"aa", "bbbbbbbbbbbb"},
+			out:      []string{"aa"},
+			pushed:   []bool{true, false},
+			dropped:  1,
+			dropSize: 12,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			b := New("test", 10, strlen)
+
+			var pushed []bool
+			for _, s := range tc.in {
+				pushed = append(pushed, b.Push(s))
+			}
+
+			assert.Equal(t, tc.pushed, pushed)
+
+			stats := b.Stats()
+			assert.Equal(t, tc.dropped, stats.Dropped)
+			assert.Equal(t, tc.dropSize, stats.DroppedBytes)
+			assert.Equal(t, len(tc.out), stats.Messages)
+			assert.LessOrEqual(t, stats.Bytes, stats.Budget)
+
+			out, err := b.PopAll(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tc.out, out)
+
+			stats = b.Stats()
+			assert.Equal(t, 0, stats.Messages)
+			assert.Equal(t, int64(0), stats.Bytes)
+		})
+	}
+}
+
+func TestBufferPop(t *testing.T) {
+	t.Parallel()
+
+	b := New("test", 1024, strlen)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res := make(chan string)
+
+	go func() {
+		v, err := b.Pop(ctx)
+		if err == nil {
+			res <- v
+		}
+	}()
+
+	b.Push("a")
+	b.Push("b")
+
+	select {
+	case v := <-res:
+		assert.Equal(t, "a", v)
+	case <-ctx.Done():
+		t.Fatal("message was not popped")
+	}
+
+	v, err := b.Pop(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "b", v)
+	assert.Equal(t, 0, b.Len())
+}
+
+func TestBufferPopCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := New("test", 1024, strlen).Pop(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBufferConcurrentConsumers(t *testing.T) {
+	t.Parallel()
+
+	const messages = 1000
+
+	b := New("test", messages, strlen)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		received int
+	)
+
+	done := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				if _, err := b.Pop(ctx); err != nil {
+					return
+				}
+
+				mutex.Lock()
+				received++
+				if received == messages {
+					close(done)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < messages; i++ {
+		b.Push("x")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages were not received")
+	}
+
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, uint64(0), b.Stats().Dropped)
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	a := New("b-buffer", 10, strlen)
+	a.Push("aaaaaaaaaaaa")
+
+	b := New("a-buffer", 100, strlen)
+	b.Push("abc")
+
+	srv := httptest.NewServer(Handler(a.Stats, b.Stats))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	var res []Stats
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+	assert.Equal(t, []Stats{
+		{Name: "a-buffer", Messages: 1, Bytes: 3, Budget: 100},
+		{Name: "b-buffer", Budget: 10, Dropped: 1, DroppedBytes: 12},
+	}, res)
+
+	resp, err = http.Post(srv.URL, "application/json", nil)
+	require.NoError(t, err)
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build windows
+
+package servicecontroller
+
+import (
+	"context"
+	"errors"
+)
+
+// SystemdController is not supported on Windows, every operation
+// returns errors.ErrUnsupported.
+type SystemdController struct {
+	unit string
+}
+
+func NewSystemdController(service string) *SystemdController {
+	return &SystemdController{unit: service}
+}
+
+func (c *SystemdController) Start(_ context.Context) error {
+	return errors.ErrUnsupported
+}
+
+func (c *SystemdController) Stop(_ context.Context) error {
+	return errors.ErrUnsupported
+}
+
+func (c *SystemdController) Restart(_ context.Context) error {
+	return errors.ErrUnsupported
+}
+
+func (c *SystemdController) Status(_ context.Context) (ServiceStatus, error) {
+	return StatusUnknown, errors.ErrUnsupported
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package slo
+
+import (
+	"context"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+)
+
+// NewInterceptor returns a worker interceptor that records latency of
+// every activity execution, using activity type as the operation name.
+func NewInterceptor(t *Tracker) interceptor.WorkerInterceptor {
+	return &latencyInterceptor{tracker: t}
+}
+
+type latencyInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	tracker *Tracker
+}
+
+func (l *latencyInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityLatency{tracker: l.tracker}
+	i.Next = next
+
+	return i
+}
+
+type activityLatency struct {
+	interceptor.ActivityInboundInterceptorBase
+	tracker *Tracker
+}
+
+func (a *activityLatency) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	start := time.Now()
+
+	res, err := a.Next.ExecuteActivity(ctx, in)
+
+	a.tracker.Record(activity.GetInfo(ctx).ActivityType.Name, time.Since(start))
+
+	return res, err
+}
+
+// RecordLatencyParam is the activity parameter for record-operation-latency
+type RecordLatencyParam struct {
+	// Operation name, e.g. "deploy:commissioning"
+	Operation string `json:"operation"`
+	// Duration in milliseconds
+	Duration int64 `json:"duration"`
+}
+
+func (t *Tracker) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// ConfigurationActivities allows the Region to record latencies of
+// operations the Agent doesn't execute itself (e.g. deploy phases).
+func (t *Tracker) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{"record-operation-latency": t.recordLatency}
+}
+
+func (t *Tracker) recordLatency(_ context.Context, param RecordLatencyParam) error {
+	t.Record(param.Operation, time.Duration(param.Duration)*time.Millisecond)
+	return nil
+}
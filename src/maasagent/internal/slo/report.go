@@ -0,0 +1,64 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package slo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"maas.io/core/src/maasagent/internal/apiclient"
+)
+
+var (
+	// ErrFailedToReport is returned when the Region rejects latency events
+	ErrFailedToReport = errors.New("failed to report latency events")
+)
+
+// APIReporter reports latency events to the Region via internal API.
+type APIReporter struct {
+	client   *apiclient.APIClient
+	systemID string
+}
+
+// NewAPIReporter returns APIReporter for the Agent with systemID.
+func NewAPIReporter(client *apiclient.APIClient, systemID string) *APIReporter {
+	return &APIReporter{client: client, systemID: systemID}
+}
+
+func (r *APIReporter) Report(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Request(ctx, http.MethodPost,
+		fmt.Sprintf("/v3internal/agents/%s/latency-events", r.systemID), body)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("%w: %s", ErrFailedToReport, resp.Status)
+	}
+
+	return nil
+}
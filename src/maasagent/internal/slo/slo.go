@@ -0,0 +1,304 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package slo tracks rolling latency percentiles of operations (e.g. power
+// actions or deploy phases) against configured objectives and emits events
+// when an objective is breached or met again.
+package slo
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/backpressure"
+	"maas.io/core/src/maasagent/internal/ringbuf"
+)
+
+const (
+	defaultWindow     = 500
+	defaultMinSamples = 20
+	// eventBufferBytes limits events waiting to be reported
+	eventBufferBytes = 256 * 1024
+	// eventOverhead is an approximate size of Event without strings
+	eventOverhead = 64
+)
+
+// Objective is latency objective of an operation. Zero value disables
+// the corresponding percentile.
+type Objective struct {
+	P95 time.Duration `yaml:"p95" json:"p95"`
+	P99 time.Duration `yaml:"p99" json:"p99"`
+}
+
+// Event is emitted when an objective becomes breached or is met again.
+type Event struct {
+	Operation  string        `json:"operation"`
+	Percentile string        `json:"percentile"`
+	Objective  time.Duration `json:"objective"`
+	Actual     time.Duration `json:"actual"`
+	Samples    int           `json:"samples"`
+	Breached   bool          `json:"breached"`
+}
+
+func (e Event) size() int {
+	return eventOverhead + len(e.Operation) + len(e.Percentile)
+}
+
+// Reporter is used to report events (e.g. to the Region).
+type Reporter interface {
+	Report(ctx context.Context, events []Event) error
+}
+
+// Latency is a snapshot of rolling latency percentiles of an operation.
+type Latency struct {
+	Operation string        `json:"operation"`
+	P95       time.Duration `json:"p95"`
+	P99       time.Duration `json:"p99"`
+	Samples   int           `json:"samples"`
+}
+
+// window is a ring buffer of the most recent samples
+type window struct {
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func (w *window) add(d time.Duration) {
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+func (w *window) sorted() []time.Duration {
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+
+	res := slices.Clone(w.samples[:n])
+	slices.Sort(res)
+
+	return res
+}
+
+// percentile uses nearest-rank method on sorted samples
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := (p*len(sorted) + 99) / 100
+
+	return sorted[rank-1]
+}
+
+// Tracker tracks latencies of operations with objectives. Operations
+// without objectives are ignored.
+type Tracker struct {
+	reporter   Reporter
+	pressure   *backpressure.Controller
+	objectives map[string]Objective
+	windows    map[string]*window
+	breached   map[string]bool
+	events     *ringbuf.Buffer[Event]
+	size       int
+	minSamples int
+	mutex      sync.Mutex
+}
+
+// TrackerOption allows to set additional Tracker options
+type TrackerOption func(*Tracker)
+
+// NewTracker returns Tracker for the provided objectives keyed by operation.
+func NewTracker(objectives map[string]Objective, options ...TrackerOption) *Tracker {
+	t := &Tracker{
+		objectives: objectives,
+		windows:    make(map[string]*window),
+		breached:   make(map[string]bool),
+		events:     ringbuf.New("latency", eventBufferBytes, Event.size),
+		size:       defaultWindow,
+		minSamples: defaultMinSamples,
+	}
+
+	for _, opt := range options {
+		opt(t)
+	}
+
+	return t
+}
+
+// WithWindow sets how many of the most recent samples are used to
+// calculate percentiles (default: 500).
+func WithWindow(size int) TrackerOption {
+	return func(t *Tracker) {
+		t.size = size
+	}
+}
+
+// WithMinSamples sets how many samples are required before objectives
+// are evaluated (default: 20).
+func WithMinSamples(n int) TrackerOption {
+	return func(t *Tracker) {
+		t.minSamples = n
+	}
+}
+
+// WithReporter sets Reporter called with emitted events.
+func WithReporter(r Reporter) TrackerOption {
+	return func(t *Tracker) {
+		t.reporter = r
+	}
+}
+
+// WithBackpressure sets Controller used to batch events for longer when
+// the Region is slow to acknowledge them.
+func WithBackpressure(c *backpressure.Controller) TrackerOption {
+	return func(t *Tracker) {
+		t.pressure = c
+	}
+}
+
+// Record adds latency sample of the operation and evaluates its objective.
+// It is safe to call Record on nil Tracker.
+func (t *Tracker) Record(operation string, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	objective, ok := t.objectives[operation]
+	if !ok {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	w, ok := t.windows[operation]
+	if !ok {
+		w = &window{samples: make([]time.Duration, t.size)}
+		t.windows[operation] = w
+	}
+
+	w.add(d)
+
+	sorted := w.sorted()
+	if len(sorted) < t.minSamples {
+		return
+	}
+
+	t.evaluate(operation, "p95", objective.P95, percentile(sorted, 95), len(sorted))
+	t.evaluate(operation, "p99", objective.P99, percentile(sorted, 99), len(sorted))
+}
+
+func (t *Tracker) evaluate(operation, name string, objective, actual time.Duration, samples int) {
+	if objective == 0 {
+		return
+	}
+
+	key := operation + "/" + name
+	breached := actual > objective
+
+	if t.breached[key] == breached {
+		return
+	}
+
+	t.breached[key] = breached
+
+	e := Event{
+		Operation:  operation,
+		Percentile: name,
+		Objective:  objective,
+		Actual:     actual,
+		Samples:    samples,
+		Breached:   breached,
+	}
+
+	if breached {
+		log.Warn().Str("operation", operation).Str("percentile", name).
+			Dur("objective", objective).Dur("actual", actual).Msg("Latency objective breached")
+	} else {
+		log.Info().Str("operation", operation).Str("percentile", name).
+			Dur("objective", objective).Dur("actual", actual).Msg("Latency objective met")
+	}
+
+	t.events.Push(e)
+}
+
+// Latencies returns current percentiles of all tracked operations.
+func (t *Tracker) Latencies() []Latency {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	res := make([]Latency, 0, len(t.windows))
+
+	for op, w := range t.windows {
+		sorted := w.sorted()
+		res = append(res, Latency{
+			Operation: op,
+			P95:       percentile(sorted, 95),
+			P99:       percentile(sorted, 99),
+			Samples:   len(sorted),
+		})
+	}
+
+	slices.SortFunc(res, func(a, b Latency) int {
+		if a.Operation < b.Operation {
+			return -1
+		}
+
+		if a.Operation > b.Operation {
+			return 1
+		}
+
+		return 0
+	})
+
+	return res
+}
+
+// Run reports emitted events until ctx is cancelled. Events emitted
+// in a quick succession are reported together.
+func (t *Tracker) Run(ctx context.Context) {
+	for {
+		events, err := t.events.PopAll(ctx)
+		if err != nil {
+			return
+		}
+
+		if t.reporter == nil {
+			continue
+		}
+
+		if err := t.reporter.Report(ctx, events); err != nil {
+			log.Warn().Err(err).Msg("Failed to report latency events")
+		}
+
+		t.pressure.Wait(ctx)
+	}
+}
+
+// BufferStats returns usage and drop counters of events waiting to be
+// reported.
+func (t *Tracker) BufferStats() ringbuf.Stats {
+	return t.events.Stats()
+}
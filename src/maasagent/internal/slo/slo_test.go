@@ -0,0 +1,175 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package slo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	testcases := map[string]struct {
+		in  []time.Duration
+		p   int
+		out time.Duration
+	}{
+		"empty": {
+			p: 95,
+		},
+		"single": {
+			in:  []time.Duration{5},
+			p:   99,
+			out: 5,
+		},
+		"p95 of 20": {
+			in:  []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+			p:   95,
+			out: 19,
+		},
+		"p99 of 20": {
+			in:  []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+			p:   99,
+			out: 20,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, percentile(tc.in, tc.p))
+		})
+	}
+}
+
+func drain(t *Tracker) []Event {
+	if t.events.Len() == 0 {
+		return nil
+	}
+
+	//nolint:errcheck // never fails, as events are buffered
+	res, _ := t.events.PopAll(context.Background())
+
+	return res
+}
+
+func TestTrackerBreachAndRecovery(t *testing.T) {
+	tracker := NewTracker(map[string]Objective{
+		"power-on": {P95: 10 * time.Second, P99: 20 * time.Second},
+	}, WithWindow(10), WithMinSamples(5))
+
+	for i := 0; i < 4; i++ {
+		tracker.Record("power-on", time.Minute)
+	}
+
+	assert.Empty(t, drain(tracker), "objectives must not be evaluated before min samples")
+
+	tracker.Record("power-on", time.Minute)
+
+	events := drain(tracker)
+	require.Len(t, events, 2)
+	assert.Equal(t, "p95", events[0].Percentile)
+	assert.True(t, events[0].Breached)
+	assert.Equal(t, time.Minute, events[0].Actual)
+	assert.Equal(t, "p99", events[1].Percentile)
+
+	tracker.Record("power-on", time.Minute)
+	assert.Empty(t, drain(tracker), "events are emitted only on state changes")
+
+	// Slow samples leave the window
+	for i := 0; i < 10; i++ {
+		tracker.Record("power-on", time.Second)
+	}
+
+	events = drain(tracker)
+	require.Len(t, events, 2)
+	assert.False(t, events[0].Breached)
+	assert.False(t, events[1].Breached)
+	assert.Equal(t, 10, events[0].Samples)
+}
+
+func TestTrackerIgnoresUnknownOperations(t *testing.T) {
+	var nilTracker *Tracker
+	nilTracker.Record("power-on", time.Second)
+
+	tracker := NewTracker(map[string]Objective{"power-on": {P95: time.Second}}, WithMinSamples(1))
+	tracker.Record("power-query", time.Hour)
+
+	assert.Empty(t, tracker.Latencies())
+	assert.Empty(t, drain(tracker))
+}
+
+func TestTrackerLatencies(t *testing.T) {
+	tracker := NewTracker(map[string]Objective{
+		"power-on":  {},
+		"power-off": {},
+	})
+
+	tracker.Record("power-on", time.Second)
+	tracker.Record("power-off", 2*time.Second)
+
+	assert.Equal(t, []Latency{
+		{Operation: "power-off", P95: 2 * time.Second, P99: 2 * time.Second, Samples: 1},
+		{Operation: "power-on", P95: time.Second, P99: time.Second, Samples: 1},
+	}, tracker.Latencies())
+}
+
+type fakeReporter struct {
+	reported chan []Event
+}
+
+func (r *fakeReporter) Report(_ context.Context, events []Event) error {
+	r.reported <- events
+	return nil
+}
+
+func TestTrackerRun(t *testing.T) {
+	reporter := &fakeReporter{reported: make(chan []Event, 1)}
+
+	tracker := NewTracker(map[string]Objective{"power-on": {P95: time.Second}},
+		WithMinSamples(1), WithReporter(reporter))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		tracker.Run(ctx)
+	}()
+
+	tracker.Record("power-on", time.Minute)
+
+	select {
+	case events := <-reporter.reported:
+		require.Len(t, events, 1)
+		assert.True(t, events[0].Breached)
+	case <-time.After(5 * time.Second):
+		t.Fatal("events were not reported")
+	}
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,517 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package snmp is a small SNMP client (GET and SET requests) supporting
+// SNMPv1, SNMPv2c and SNMPv3 with the User-based Security Model, built on
+// gosnmp. It allows the Agent to control power of PDUs without running
+// a CLI tool for every operation, and reports failures as distinct errors,
+// so authentication failures can be told apart from devices which are not
+// reachable.
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"maas.io/core/src/maasagent/internal/errcode"
+)
+
+// DefaultPort is the SNMP port of agents
+const DefaultPort = 161
+
+// SNMP versions as encoded in messages
+const (
+	Version1  = 0
+	Version2c = 1
+	Version3  = 3
+)
+
+// USM authentication protocols (RFC 3414)
+const (
+	AuthMD5 = "MD5"
+	AuthSHA = "SHA"
+)
+
+// USM privacy protocols (RFC 3414 and RFC 3826)
+const (
+	PrivDES = "DES"
+	PrivAES = "AES"
+)
+
+const (
+	defaultTimeout = 2 * time.Second
+	defaultRetries = 2
+	// minPassphraseSize is required by RFC 3414, 11.2
+	minPassphraseSize = 8
+)
+
+var (
+	// ErrAuthentication is returned when the agent rejects the credentials.
+	// Note that SNMPv1 and SNMPv2c agents silently drop requests with
+	// a wrong community, which is reported as ErrUnreachable.
+	ErrAuthentication = errcode.New(errcode.PowerAuthFailed, "SNMP authentication failed")
+	// ErrUnreachable is returned when the agent doesn't respond
+	ErrUnreachable = errcode.New(errcode.PowerUnreachable, "SNMP agent is unreachable")
+	// ErrInvalidResponse is returned when response cannot be decoded, or
+	// its integrity cannot be verified
+	ErrInvalidResponse = errcode.New(errcode.PowerInvalidResponse, "invalid SNMP response")
+	// ErrNoSuchObject is returned when the agent doesn't have the object
+	ErrNoSuchObject = errcode.New(errcode.PowerNotFound, "no such SNMP object")
+	// ErrInvalidOID is returned for OIDs which can't be encoded
+	ErrInvalidOID = errcode.New(errcode.PowerInvalidParameters, "invalid OID")
+	// ErrUnsupportedValue is returned for values which can't be encoded
+	ErrUnsupportedValue = errcode.New(errcode.PowerInvalidResponse, "unsupported SNMP value")
+	// ErrUnsupportedProtocol is returned for unknown SNMP versions and
+	// USM authentication or privacy protocols
+	ErrUnsupportedProtocol = errcode.New(errcode.PowerUnsupported, "unsupported SNMP protocol")
+)
+
+// usmErrors are USM reports (RFC 3414, 5) which mean the agent rejected
+// the credentials
+var usmErrors = []error{
+	gosnmp.ErrUnknownSecurityLevel,
+	gosnmp.ErrUnknownUsername,
+	gosnmp.ErrWrongDigest,
+	gosnmp.ErrDecryption,
+	gosnmp.ErrNotInTimeWindow,
+}
+
+// errorStatuses describes error-status of responses (RFC 3416, 3)
+var errorStatuses = map[int64]string{
+	1:  "tooBig",
+	2:  "noSuchName",
+	3:  "badValue",
+	4:  "readOnly",
+	5:  "genErr",
+	6:  "noAccess",
+	7:  "wrongType",
+	8:  "wrongLength",
+	9:  "wrongEncoding",
+	10: "wrongValue",
+	11: "noCreation",
+	12: "inconsistentValue",
+	13: "resourceUnavailable",
+	14: "commitFailed",
+	15: "undoFailed",
+	16: "authorizationError",
+	17: "notWritable",
+	18: "inconsistentName",
+}
+
+// StatusError is returned when the agent responds with non-zero
+// error-status
+type StatusError struct {
+	Request string
+	Status  int64
+	// Index of the varbind which caused the error, starting with 1
+	Index int64
+}
+
+func (e *StatusError) Error() string {
+	if text, ok := errorStatuses[e.Status]; ok {
+		return fmt.Sprintf("SNMP %s failed: %s (varbind %d)", e.Request, text, e.Index)
+	}
+
+	return fmt.Sprintf("SNMP %s failed: error-status %d (varbind %d)", e.Request, e.Status, e.Index)
+}
+
+// Is reports access denied by the agent as ErrAuthentication
+func (e *StatusError) Is(target error) bool {
+	return target == ErrAuthentication &&
+		(e.Status == int64(gosnmp.NoAccess) || e.Status == int64(gosnmp.AuthorizationError))
+}
+
+// Varbind is a variable binding. Values are sent as INTEGER (int and
+// int64), OCTET STRING (string and []byte) or NULL (nil). Received
+// values are decoded as described by decodeValue.
+type Varbind struct {
+	Value interface{}
+	OID   string
+}
+
+// Client sends requests to an SNMP agent. It is safe for concurrent use,
+// although requests are sent one at a time.
+type Client struct {
+	snmp  *gosnmp.GoSNMP
+	mutex sync.Mutex
+}
+
+type usmConfig struct {
+	user         string
+	authProtocol string
+	authPass     string
+	privProtocol string
+	privPass     string
+}
+
+type config struct {
+	usm       usmConfig
+	community string
+	version   int
+	timeout   time.Duration
+	retries   int
+}
+
+// Option allows to set additional Client options
+type Option func(*config)
+
+// WithVersion sets the SNMP version (default: Version2c)
+func WithVersion(version int) Option {
+	return func(c *config) {
+		c.version = version
+	}
+}
+
+// WithCommunity sets the community of SNMPv1 and SNMPv2c requests
+// (default: "public")
+func WithCommunity(community string) Option {
+	return func(c *config) {
+		c.community = community
+	}
+}
+
+// WithUser sets the USM user of SNMPv3 requests
+func WithUser(user string) Option {
+	return func(c *config) {
+		c.usm.user = user
+	}
+}
+
+// WithAuth sets the authentication protocol (AuthMD5 or AuthSHA) and the
+// passphrase of the USM user. Requests are not authenticated without it.
+func WithAuth(protocol, passphrase string) Option {
+	return func(c *config) {
+		c.usm.authProtocol = protocol
+		c.usm.authPass = passphrase
+	}
+}
+
+// WithPrivacy sets the privacy protocol (PrivDES or PrivAES) and the
+// passphrase of the USM user. Requests are not encrypted without it.
+func WithPrivacy(protocol, passphrase string) Option {
+	return func(c *config) {
+		c.usm.privProtocol = protocol
+		c.usm.privPass = passphrase
+	}
+}
+
+// WithTimeout sets how long to wait for a response before the request is
+// retransmitted (default: 2s), and how many times it is retransmitted
+// (default: 2).
+func WithTimeout(timeout time.Duration, retries int) Option {
+	return func(c *config) {
+		c.timeout = timeout
+		c.retries = retries
+	}
+}
+
+// usmParameters returns USM security parameters of the user and flags of
+// the security level they provide
+func usmParameters(cfg usmConfig) (*gosnmp.UsmSecurityParameters, gosnmp.SnmpV3MsgFlags, error) {
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:               cfg.user,
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+
+	switch cfg.authProtocol {
+	case "":
+		if cfg.privProtocol != "" {
+			return nil, 0, fmt.Errorf("%w: privacy requires authentication", ErrUnsupportedProtocol)
+		}
+
+		return params, gosnmp.NoAuthNoPriv, nil
+	case AuthMD5:
+		params.AuthenticationProtocol = gosnmp.MD5
+	case AuthSHA:
+		params.AuthenticationProtocol = gosnmp.SHA
+	default:
+		return nil, 0, fmt.Errorf("%w: authentication protocol %q", ErrUnsupportedProtocol, cfg.authProtocol)
+	}
+
+	if len(cfg.authPass) < minPassphraseSize {
+		return nil, 0, fmt.Errorf("%w: passphrase is shorter than %d bytes", ErrAuthentication, minPassphraseSize)
+	}
+
+	params.AuthenticationPassphrase = cfg.authPass
+
+	switch cfg.privProtocol {
+	case "":
+		return params, gosnmp.AuthNoPriv, nil
+	case PrivDES:
+		params.PrivacyProtocol = gosnmp.DES
+	case PrivAES:
+		params.PrivacyProtocol = gosnmp.AES
+	default:
+		return nil, 0, fmt.Errorf("%w: privacy protocol %q", ErrUnsupportedProtocol, cfg.privProtocol)
+	}
+
+	if len(cfg.privPass) < minPassphraseSize {
+		return nil, 0, fmt.Errorf("%w: passphrase is shorter than %d bytes", ErrAuthentication, minPassphraseSize)
+	}
+
+	params.PrivacyPassphrase = cfg.privPass
+
+	return params, gosnmp.AuthPriv, nil
+}
+
+// Dial returns Client of the agent at address (host or host:port). SNMPv3
+// clients discover the engine of the agent with the first request.
+func Dial(ctx context.Context, address string, options ...Option) (*Client, error) {
+	cfg := config{
+		community: "public",
+		version:   Version2c,
+		timeout:   defaultTimeout,
+		retries:   defaultRetries,
+	}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), strconv.Itoa(DefaultPort)
+	}
+
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrUnreachable, port)
+	}
+
+	s := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(portNumber),
+		Transport: "udp",
+		Community: cfg.community,
+		Timeout:   cfg.timeout,
+		Retries:   cfg.retries,
+		Context:   ctx,
+		MaxOids:   gosnmp.MaxOids,
+	}
+
+	switch cfg.version {
+	case Version1:
+		s.Version = gosnmp.Version1
+	case Version2c:
+		s.Version = gosnmp.Version2c
+	case Version3:
+		params, flags, err := usmParameters(cfg.usm)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.usm.user == "" {
+			return nil, fmt.Errorf("%w: user is required", ErrAuthentication)
+		}
+
+		s.Version = gosnmp.Version3
+		s.SecurityModel = gosnmp.UserSecurityModel
+		s.MsgFlags = flags
+		s.SecurityParameters = params
+	default:
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedProtocol, cfg.version)
+	}
+
+	if err := s.Connect(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+
+	return &Client{snmp: s}, nil
+}
+
+// Close closes the client
+func (c *Client) Close() error {
+	return c.snmp.Conn.Close()
+}
+
+// Get returns values of oids
+func (c *Client) Get(ctx context.Context, oids ...string) ([]Varbind, error) {
+	names := make([]string, len(oids))
+
+	for i, oid := range oids {
+		name, err := encodeOID(oid)
+		if err != nil {
+			return nil, err
+		}
+
+		names[i] = name
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.snmp.Context = ctx
+
+	p, err := c.snmp.Get(names)
+
+	return c.response(ctx, "GET", p, err)
+}
+
+// Set sets values of varbinds and returns values reported by the agent
+func (c *Client) Set(ctx context.Context, varbinds ...Varbind) ([]Varbind, error) {
+	pdus := make([]gosnmp.SnmpPDU, len(varbinds))
+
+	for i, vb := range varbinds {
+		pdu, err := encodeVarbind(vb)
+		if err != nil {
+			return nil, err
+		}
+
+		pdus[i] = pdu
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.snmp.Context = ctx
+
+	p, err := c.snmp.Set(pdus)
+
+	return c.response(ctx, "SET", p, err)
+}
+
+func (c *Client) response(ctx context.Context, name string, p *gosnmp.SnmpPacket, err error) ([]Varbind, error) {
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+
+	if p.Error != gosnmp.NoError {
+		return nil, &StatusError{Request: name, Status: int64(p.Error), Index: int64(p.ErrorIndex)}
+	}
+
+	varbinds := make([]Varbind, len(p.Variables))
+
+	for i, pdu := range p.Variables {
+		value, err := decodeValue(pdu)
+		if err != nil {
+			return nil, err
+		}
+
+		varbinds[i] = Varbind{OID: strings.TrimPrefix(pdu.Name, "."), Value: value}
+	}
+
+	return varbinds, nil
+}
+
+// requestError maps errors of gosnmp to errors of the package
+func requestError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for _, usmErr := range usmErrors {
+		if errors.Is(err, usmErr) {
+			return fmt.Errorf("%w: %w", ErrAuthentication, err)
+		}
+	}
+
+	var netErr net.Error
+
+	// gosnmp reports requests without a response as a plain error
+	if errors.As(err, &netErr) || strings.Contains(err.Error(), "timeout") {
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+
+	return fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+}
+
+// encodeOID returns oid in the notation of gosnmp
+func encodeOID(oid string) (string, error) {
+	parts := strings.Split(oid, ".")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidOID, oid)
+	}
+
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		// The first two arcs are encoded as a single subidentifier
+		// (ITU-T X.690, 8.19.4)
+		if err != nil || (i == 0 && n > 2) || (i == 1 && parts[0] != "2" && n > 39) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidOID, oid)
+		}
+	}
+
+	return "." + oid, nil
+}
+
+func encodeVarbind(vb Varbind) (gosnmp.SnmpPDU, error) {
+	name, err := encodeOID(vb.OID)
+	if err != nil {
+		return gosnmp.SnmpPDU{}, err
+	}
+
+	pdu := gosnmp.SnmpPDU{Name: name}
+
+	switch v := vb.Value.(type) {
+	case int:
+		pdu.Type, pdu.Value = gosnmp.Integer, v
+	case int64:
+		pdu.Type, pdu.Value = gosnmp.Integer, int(v)
+	case string:
+		pdu.Type, pdu.Value = gosnmp.OctetString, v
+	case []byte:
+		pdu.Type, pdu.Value = gosnmp.OctetString, v
+	case nil:
+		pdu.Type = gosnmp.Null
+	default:
+		return gosnmp.SnmpPDU{}, fmt.Errorf("%w: %T", ErrUnsupportedValue, v)
+	}
+
+	return pdu, nil
+}
+
+// decodeValue returns INTEGER as int64, OCTET STRING and Opaque as []byte,
+// OBJECT IDENTIFIER as string, IpAddress as net.IP, unsigned types as
+// uint64 and NULL as nil. Exceptions are returned as ErrNoSuchObject.
+func decodeValue(pdu gosnmp.SnmpPDU) (interface{}, error) {
+	switch pdu.Type {
+	case gosnmp.Integer:
+		return gosnmp.ToBigInt(pdu.Value).Int64(), nil
+	case gosnmp.OctetString, gosnmp.Opaque:
+		if b, ok := pdu.Value.([]byte); ok {
+			return b, nil
+		}
+
+		return nil, fmt.Errorf("%w: %s value %T", ErrInvalidResponse, pdu.Type, pdu.Value)
+	case gosnmp.Null:
+		return nil, nil
+	case gosnmp.ObjectIdentifier:
+		oid, _ := pdu.Value.(string)
+		return strings.TrimPrefix(oid, "."), nil
+	case gosnmp.IPAddress:
+		addr, _ := pdu.Value.(string)
+
+		ip := net.ParseIP(addr).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("%w: IpAddress %q", ErrInvalidResponse, addr)
+		}
+
+		return ip, nil
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(pdu.Value).Uint64(), nil
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return nil, ErrNoSuchObject
+	default:
+		return nil, fmt.Errorf("%w: %s value", ErrInvalidResponse, pdu.Type)
+	}
+}
@@ -0,0 +1,483 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package snmp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oidSysName = "1.3.6.1.2.1.1.5.0"
+	oidOutlet  = "1.3.6.1.4.1.318.1.1.12.3.3.1.1.4.1"
+
+	// OIDs of USM statistics sent in reports (RFC 3414, 5)
+	oidNotInTimeWindows = ".1.3.6.1.6.3.15.1.1.2.0"
+	oidUnknownUserNames = ".1.3.6.1.6.3.15.1.1.3.0"
+	oidUnknownEngineIDs = ".1.3.6.1.6.3.15.1.1.4.0"
+	oidWrongDigests     = ".1.3.6.1.6.3.15.1.1.5.0"
+
+	agentEngineID = "\x80\x00\x1f\x88\x04fake-agent"
+	agentBoots    = 3
+	agentTime     = 1000
+)
+
+// fakeAgent is a minimal SNMP agent. SNMPv3 agents have the engine of
+// the agent and the only user.
+type fakeAgent struct {
+	objects   map[string]interface{}
+	readOnly  map[string]bool
+	usm       *gosnmp.UsmSecurityParameters
+	community string
+	flags     gosnmp.SnmpV3MsgFlags
+	// discoveryTime reports engine time 0 in discovery reports, as some
+	// agents do, so time has to be synced on the first request
+	discoveryTime bool
+	mutex         sync.Mutex
+}
+
+func newFakeAgent(t *testing.T, agent *fakeAgent) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65507)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if resp := agent.handle(append([]byte{}, buf[:n]...)); resp != nil {
+				//nolint:errcheck // the client retries
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// newAgentUSM returns the agent side USM parameters of the user
+func newAgentUSM(t *testing.T, cfg usmConfig) (*gosnmp.UsmSecurityParameters, gosnmp.SnmpV3MsgFlags) {
+	t.Helper()
+
+	params, flags, err := usmParameters(cfg)
+	require.NoError(t, err)
+
+	params.AuthoritativeEngineID = agentEngineID
+	params.AuthoritativeEngineBoots = agentBoots
+	params.AuthoritativeEngineTime = agentTime
+
+	require.NoError(t, params.InitSecurityKeys())
+
+	return params, flags
+}
+
+func (a *fakeAgent) handle(b []byte) []byte {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.usm != nil {
+		return a.handleV3(b)
+	}
+
+	req, err := (&gosnmp.GoSNMP{Version: gosnmp.Version2c}).SnmpDecodePacket(b)
+	if err != nil || req.Community != a.community {
+		return nil
+	}
+
+	return a.marshal(&gosnmp.SnmpPacket{
+		Version:   req.Version,
+		Community: req.Community,
+	}, req)
+}
+
+func (a *fakeAgent) handleV3(b []byte) []byte {
+	agent := &gosnmp.GoSNMP{
+		Version:            gosnmp.Version3,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		MsgFlags:           a.flags,
+		SecurityParameters: a.usm,
+	}
+
+	req, err := agent.UnmarshalTrap(b, true)
+	if err != nil {
+		// The request is not authentic, it is decoded without verifying
+		// it to answer with a report
+		agent.SecurityParameters = a.usm.Copy()
+
+		if req, err = agent.SnmpDecodePacket(b); err != nil {
+			return nil
+		}
+
+		return a.report(req, oidWrongDigests, false)
+	}
+
+	params, ok := req.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case params.AuthoritativeEngineID == "":
+		return a.report(req, oidUnknownEngineIDs, false)
+	case params.UserName != a.usm.UserName:
+		return a.report(req, oidUnknownUserNames, false)
+	case req.MsgFlags&gosnmp.AuthPriv != a.flags:
+		return a.report(req, oidWrongDigests, false)
+	case a.flags&gosnmp.AuthNoPriv != 0 && (params.AuthoritativeEngineBoots != agentBoots ||
+		params.AuthoritativeEngineTime < agentTime-150 || params.AuthoritativeEngineTime > agentTime+150):
+		return a.report(req, oidNotInTimeWindows, true)
+	}
+
+	return a.marshal(a.v3Packet(req, a.flags), req)
+}
+
+// v3Packet returns SNMPv3 packet of a response to req
+func (a *fakeAgent) v3Packet(req *gosnmp.SnmpPacket, flags gosnmp.SnmpV3MsgFlags) *gosnmp.SnmpPacket {
+	params := &gosnmp.UsmSecurityParameters{
+		AuthoritativeEngineID:    agentEngineID,
+		AuthoritativeEngineBoots: agentBoots,
+		AuthoritativeEngineTime:  agentTime,
+	}
+
+	if flags&gosnmp.AuthNoPriv != 0 {
+		params = a.usm.Copy().(*gosnmp.UsmSecurityParameters)
+	}
+
+	return &gosnmp.SnmpPacket{
+		Version:            gosnmp.Version3,
+		MsgFlags:           flags,
+		MsgID:              req.MsgID,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		SecurityParameters: params,
+		ContextEngineID:    agentEngineID,
+	}
+}
+
+func (a *fakeAgent) report(req *gosnmp.SnmpPacket, oid string, authenticated bool) []byte {
+	p := a.v3Packet(req, gosnmp.NoAuthNoPriv)
+	if authenticated {
+		p = a.v3Packet(req, a.flags&gosnmp.AuthNoPriv)
+	}
+
+	if a.discoveryTime && oid == oidUnknownEngineIDs {
+		params := p.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		params.AuthoritativeEngineBoots, params.AuthoritativeEngineTime = 0, 0
+	}
+
+	p.PDUType = gosnmp.Report
+	p.RequestID = req.RequestID
+	p.Variables = []gosnmp.SnmpPDU{{Name: oid, Type: gosnmp.Counter32, Value: uint32(1)}}
+
+	return a.marshalPacket(p)
+}
+
+// marshal returns p as the response to req
+func (a *fakeAgent) marshal(p, req *gosnmp.SnmpPacket) []byte {
+	p.PDUType = gosnmp.GetResponse
+	p.RequestID = req.RequestID
+
+	for i, pdu := range req.Variables {
+		oid := pdu.Name[1:]
+
+		if req.PDUType == gosnmp.SetRequest {
+			if a.readOnly[oid] {
+				if p.Error == gosnmp.NoError {
+					p.Error, p.ErrorIndex = gosnmp.NotWritable, uint8(i+1)
+				}
+			} else {
+				v := pdu.Value
+				if n, ok := v.(int); ok {
+					v = int64(n)
+				}
+
+				a.objects[oid] = v
+			}
+		}
+
+		res := gosnmp.SnmpPDU{Name: pdu.Name, Type: gosnmp.NoSuchInstance}
+
+		switch v := a.objects[oid].(type) {
+		case int64:
+			res.Type, res.Value = gosnmp.Integer, int(v)
+		case []byte:
+			res.Type, res.Value = gosnmp.OctetString, v
+		}
+
+		p.Variables = append(p.Variables, res)
+	}
+
+	return a.marshalPacket(p)
+}
+
+func (a *fakeAgent) marshalPacket(p *gosnmp.SnmpPacket) []byte {
+	if p.Version == gosnmp.Version3 {
+		if err := p.SecurityParameters.InitPacket(p); err != nil {
+			return nil
+		}
+	}
+
+	b, err := p.MarshalMsg()
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+func TestCommunity(t *testing.T) {
+	t.Parallel()
+
+	agent := &fakeAgent{
+		community: "private",
+		objects:   map[string]interface{}{oidSysName: []byte("pdu-1"), oidOutlet: int64(2)},
+		readOnly:  map[string]bool{oidSysName: true},
+	}
+	address := newFakeAgent(t, agent)
+
+	for _, version := range []int{Version1, Version2c} {
+		agent.mutex.Lock()
+		agent.objects[oidOutlet] = int64(2)
+		agent.mutex.Unlock()
+
+		c, err := Dial(context.Background(), address, WithVersion(version), WithCommunity("private"))
+		require.NoError(t, err)
+
+		defer c.Close()
+
+		res, err := c.Get(context.Background(), oidSysName, oidOutlet)
+		require.NoError(t, err)
+		assert.Equal(t, []Varbind{
+			{OID: oidSysName, Value: []byte("pdu-1")},
+			{OID: oidOutlet, Value: int64(2)},
+		}, res)
+
+		res, err = c.Set(context.Background(), Varbind{OID: oidOutlet, Value: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []Varbind{{OID: oidOutlet, Value: int64(1)}}, res)
+
+		_, err = c.Get(context.Background(), "1.3.6.1.2.1.1.6.0")
+		assert.ErrorIs(t, err, ErrNoSuchObject)
+
+		_, err = c.Set(context.Background(), Varbind{OID: oidSysName, Value: "pdu-2"})
+
+		var serr *StatusError
+		require.True(t, errors.As(err, &serr))
+		assert.EqualError(t, err, "SNMP SET failed: notWritable (varbind 1)")
+		assert.False(t, errors.Is(err, ErrAuthentication))
+	}
+}
+
+func TestWrongCommunity(t *testing.T) {
+	t.Parallel()
+
+	address := newFakeAgent(t, &fakeAgent{community: "private"})
+
+	c, err := Dial(context.Background(), address, WithTimeout(10*time.Millisecond, 1))
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	_, err = c.Get(context.Background(), oidSysName)
+	assert.ErrorIs(t, err, ErrUnreachable)
+}
+
+func TestUSM(t *testing.T) {
+	testcases := map[string]struct {
+		cfg usmConfig
+	}{
+		"noAuthNoPriv": {
+			cfg: usmConfig{user: "maas"},
+		},
+		"MD5 and DES": {
+			cfg: usmConfig{user: "maas", authProtocol: AuthMD5, authPass: "auth-secret",
+				privProtocol: PrivDES, privPass: "priv-secret"},
+		},
+		"SHA and AES": {
+			cfg: usmConfig{user: "maas", authProtocol: AuthSHA, authPass: "auth-secret",
+				privProtocol: PrivAES, privPass: "priv-secret"},
+		},
+		"SHA without privacy": {
+			cfg: usmConfig{user: "maas", authProtocol: AuthSHA, authPass: "auth-secret"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			usm, flags := newAgentUSM(t, tc.cfg)
+			address := newFakeAgent(t, &fakeAgent{
+				objects: map[string]interface{}{oidOutlet: int64(2)},
+				usm:     usm,
+				flags:   flags,
+			})
+
+			options := []Option{WithVersion(Version3), WithUser(tc.cfg.user)}
+			if tc.cfg.authProtocol != "" {
+				options = append(options, WithAuth(tc.cfg.authProtocol, tc.cfg.authPass))
+			}
+
+			if tc.cfg.privProtocol != "" {
+				options = append(options, WithPrivacy(tc.cfg.privProtocol, tc.cfg.privPass))
+			}
+
+			c, err := Dial(context.Background(), address, options...)
+			require.NoError(t, err)
+
+			defer c.Close()
+
+			_, err = c.Set(context.Background(), Varbind{OID: oidOutlet, Value: 1})
+			require.NoError(t, err)
+
+			res, err := c.Get(context.Background(), oidOutlet)
+			require.NoError(t, err)
+			assert.Equal(t, []Varbind{{OID: oidOutlet, Value: int64(1)}}, res)
+		})
+	}
+}
+
+func TestUSMTimeWindow(t *testing.T) {
+	t.Parallel()
+
+	usm, flags := newAgentUSM(t, usmConfig{user: "maas", authProtocol: AuthSHA, authPass: "auth-secret",
+		privProtocol: PrivAES, privPass: "priv-secret"})
+	address := newFakeAgent(t, &fakeAgent{
+		objects:       map[string]interface{}{oidOutlet: int64(2)},
+		usm:           usm,
+		flags:         flags,
+		discoveryTime: true,
+	})
+
+	c, err := Dial(context.Background(), address, WithVersion(Version3), WithUser("maas"),
+		WithAuth(AuthSHA, "auth-secret"), WithPrivacy(PrivAES, "priv-secret"))
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	res, err := c.Get(context.Background(), oidOutlet)
+	require.NoError(t, err)
+	assert.Equal(t, []Varbind{{OID: oidOutlet, Value: int64(2)}}, res)
+}
+
+func TestUSMWrongPassphrase(t *testing.T) {
+	t.Parallel()
+
+	usm, flags := newAgentUSM(t, usmConfig{user: "maas", authProtocol: AuthSHA, authPass: "auth-secret"})
+	address := newFakeAgent(t, &fakeAgent{
+		objects: map[string]interface{}{oidOutlet: int64(2)},
+		usm:     usm,
+		flags:   flags,
+	})
+
+	c, err := Dial(context.Background(), address, WithVersion(Version3), WithUser("maas"),
+		WithAuth(AuthSHA, "wrong-secret"))
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	_, err = c.Get(context.Background(), oidOutlet)
+	assert.ErrorIs(t, err, ErrAuthentication)
+	assert.ErrorContains(t, err, "wrong digest")
+}
+
+func TestDialOptions(t *testing.T) {
+	testcases := map[string]struct {
+		options []Option
+		err     error
+	}{
+		"unknown version": {
+			options: []Option{WithVersion(2)},
+			err:     ErrUnsupportedProtocol,
+		},
+		"no user": {
+			options: []Option{WithVersion(Version3)},
+			err:     ErrAuthentication,
+		},
+		"unknown auth protocol": {
+			options: []Option{WithVersion(Version3), WithAuth("SHA512", "auth-secret")},
+			err:     ErrUnsupportedProtocol,
+		},
+		"privacy without auth": {
+			options: []Option{WithVersion(Version3), WithPrivacy(PrivAES, "priv-secret")},
+			err:     ErrUnsupportedProtocol,
+		},
+		"short passphrase": {
+			options: []Option{WithVersion(Version3), WithAuth(AuthSHA, "secret")},
+			err:     ErrAuthentication,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Dial(context.Background(), "127.0.0.1", tc.options...)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	t.Parallel()
+
+	// Nothing is ever read from conn
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	c, err := Dial(context.Background(), conn.LocalAddr().String(), WithVersion(Version3),
+		WithUser("maas"), WithTimeout(10*time.Millisecond, 1))
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	_, err = c.Get(context.Background(), oidSysName)
+	assert.ErrorIs(t, err, ErrUnreachable)
+}
+
+func TestEncodeOID(t *testing.T) {
+	for _, oid := range []string{"1.3.6.1.4.1.318.1.1.12.3.3.1.1.4.24", "2.999.1", "0.0"} {
+		encoded, err := encodeOID(oid)
+		require.NoError(t, err)
+		assert.Equal(t, "."+oid, encoded)
+	}
+
+	for _, oid := range []string{"1", "1.40", "3.1", "1.3.a", ".1.3"} {
+		_, err := encodeOID(oid)
+		assert.ErrorIs(t, err, ErrInvalidOID)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package snmptrap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// SNMP versions as encoded in the message
+const (
+	Version1  = int(gosnmp.Version1)
+	Version2c = int(gosnmp.Version2c)
+)
+
+const (
+	// oidTrapV2 is snmpTrapOID.0 carried by SNMPv2 notifications
+	oidTrapV2 = "1.3.6.1.6.3.1.1.4.1.0"
+	// oidGenericTraps is the prefix of SNMPv1 generic traps (RFC 3584)
+	oidGenericTraps = "1.3.6.1.6.3.1.1.5"
+	// genericEnterpriseSpecific is generic-trap of enterprise specific traps
+	genericEnterpriseSpecific = 6
+)
+
+var (
+	// ErrMalformedPacket is returned when packet is not a valid SNMP message
+	ErrMalformedPacket = errors.New("malformed SNMP packet")
+	// ErrUnsupportedPDU is returned for SNMP messages which are not traps
+	// (or informs), and for SNMPv3 messages
+	ErrUnsupportedPDU = errors.New("unsupported SNMP PDU")
+)
+
+// Varbind is a variable binding of a trap. Value is formatted as text:
+// integers in decimal, OIDs and IP addresses in dotted notation and
+// octet strings as they are.
+type Varbind struct {
+	OID   string `json:"oid"`
+	Value string `json:"value"`
+}
+
+// Trap is a decoded SNMPv1 trap, SNMPv2c trap or inform
+type Trap struct {
+	Community string `json:"community"`
+	// OID of the trap. SNMPv1 traps are converted as per RFC 3584.
+	OID      string    `json:"oid"`
+	Varbinds []Varbind `json:"varbinds,omitempty"`
+	Version  int       `json:"version"`
+
+	// inform is set for InformRequest, which must be acknowledged
+	inform bool
+	packet *gosnmp.SnmpPacket
+}
+
+// Value returns the value of the varbind with oid
+func (t *Trap) Value(oid string) (string, bool) {
+	for _, v := range t.Varbinds {
+		if v.OID == oid {
+			return v.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// ParseTrap decodes SNMP message carrying a trap or an inform
+func ParseTrap(packet []byte) (*Trap, error) {
+	// Security parameters are set for SNMPv3 messages to be decoded far
+	// enough to be rejected as unsupported.
+	decoder := &gosnmp.GoSNMP{
+		Version:            gosnmp.Version3,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{},
+	}
+
+	p, err := decoder.UnmarshalTrap(packet, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedPacket, err)
+	}
+
+	t := &Trap{
+		Version:   int(p.Version),
+		Community: p.Community,
+		packet:    p,
+	}
+
+	switch {
+	case p.PDUType == gosnmp.Trap && p.Version == gosnmp.Version1:
+		err = t.parseV1(p)
+	case p.PDUType == gosnmp.SNMPv2Trap && p.Version == gosnmp.Version2c:
+		err = t.parseV2(p)
+	case p.PDUType == gosnmp.InformRequest && p.Version == gosnmp.Version2c:
+		t.inform = true
+		err = t.parseV2(p)
+	case p.Version != gosnmp.Version1 && p.Version != gosnmp.Version2c:
+		err = fmt.Errorf("%w: SNMP version %s", ErrUnsupportedPDU, p.Version)
+	default:
+		err = fmt.Errorf("%w: PDU type %s", ErrUnsupportedPDU, p.PDUType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// parseV1 converts enterprise, generic-trap and specific-trap of Trap-PDU
+// to the OID of the trap.
+func (t *Trap) parseV1(p *gosnmp.SnmpPacket) error {
+	enterprise := strings.TrimPrefix(p.Enterprise, ".")
+	if enterprise == "" {
+		return fmt.Errorf("%w: missing enterprise", ErrMalformedPacket)
+	}
+
+	if p.GenericTrap == genericEnterpriseSpecific {
+		t.OID = fmt.Sprintf("%s.0.%d", enterprise, p.SpecificTrap)
+	} else {
+		t.OID = fmt.Sprintf("%s.%d", oidGenericTraps, p.GenericTrap+1)
+	}
+
+	t.Varbinds = formatVarbinds(p.Variables)
+
+	return nil
+}
+
+// parseV2 reads the OID of the trap from snmpTrapOID.0, the second
+// variable binding of SNMPv2-Trap-PDU and InformRequest-PDU.
+func (t *Trap) parseV2(p *gosnmp.SnmpPacket) error {
+	t.Varbinds = formatVarbinds(p.Variables)
+
+	oid, ok := t.Value(oidTrapV2)
+	if !ok {
+		return fmt.Errorf("%w: missing snmpTrapOID", ErrMalformedPacket)
+	}
+
+	t.OID = oid
+
+	return nil
+}
+
+// response returns Response-PDU acknowledging the inform, which echoes
+// request-id and variable-bindings back.
+func (t *Trap) response() ([]byte, error) {
+	p := *t.packet
+	p.PDUType = gosnmp.GetResponse
+	p.Error = gosnmp.NoError
+	p.ErrorIndex = 0
+
+	return p.MarshalMsg()
+}
+
+func formatVarbinds(pdus []gosnmp.SnmpPDU) []Varbind {
+	var res []Varbind
+
+	for _, pdu := range pdus {
+		res = append(res, Varbind{
+			OID:   strings.TrimPrefix(pdu.Name, "."),
+			Value: formatValue(pdu),
+		})
+	}
+
+	return res
+}
+
+func formatValue(pdu gosnmp.SnmpPDU) string {
+	switch pdu.Type {
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks,
+		gosnmp.Counter64, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(pdu.Value).String()
+	case gosnmp.OctetString, gosnmp.Opaque:
+		if b, ok := pdu.Value.([]byte); ok {
+			return string(b)
+		}
+
+		return fmt.Sprint(pdu.Value)
+	case gosnmp.ObjectIdentifier:
+		s, _ := pdu.Value.(string)
+		return strings.TrimPrefix(s, ".")
+	case gosnmp.IPAddress:
+		s, _ := pdu.Value.(string)
+		return s
+	default:
+		// NULL and exceptions (noSuchObject, noSuchInstance, endOfMibView)
+		return ""
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package snmptrap
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func marshal(t *testing.T, p *gosnmp.SnmpPacket) []byte {
+	t.Helper()
+
+	b, err := p.MarshalMsg()
+	require.NoError(t, err)
+
+	return b
+}
+
+// linkDownV1 is SNMPv1 linkDown trap of ifIndex.12
+func linkDownV1(t *testing.T) []byte {
+	t.Helper()
+
+	return marshal(t, &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version1,
+		Community: "public",
+		PDUType:   gosnmp.Trap,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: ".1.3.6.1.2.1.2.2.1.1.12", Type: gosnmp.Integer, Value: 12},
+		},
+		SnmpTrap: gosnmp.SnmpTrap{
+			Enterprise:   ".1.3.6.1.4.1.9",
+			AgentAddress: "10.0.0.2",
+			GenericTrap:  2,
+			Timestamp:    256,
+		},
+	})
+}
+
+// outletV2 is SNMPv2c notification of the outlet state
+func outletV2(t *testing.T, pduType gosnmp.PDUType, outlet, state int) []byte {
+	t.Helper()
+
+	suffix := "." + strconv.Itoa(outlet)
+
+	return marshal(t, &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: "private",
+		PDUType:   pduType,
+		RequestID: 258,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(4660)},
+			{Name: "." + oidTrapV2, Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.318.0.268"},
+			{Name: ".1.3.6.1.4.1.318.1.1.12.3.3.1.1.1" + suffix, Type: gosnmp.Integer, Value: outlet},
+			{Name: ".1.3.6.1.4.1.318.1.1.12.3.3.1.1.4" + suffix, Type: gosnmp.Integer, Value: state},
+			{Name: ".1.3.6.1.4.1.318.1.1.12.3.3.1.1.2" + suffix, Type: gosnmp.OctetString, Value: "node01"},
+		},
+	})
+}
+
+func TestParseTrap(t *testing.T) {
+	testcases := map[string]struct {
+		packet func(t *testing.T) []byte
+		out    *Trap
+		err    error
+	}{
+		"v1 generic": {
+			packet: linkDownV1,
+			out: &Trap{
+				Version:   Version1,
+				Community: "public",
+				OID:       "1.3.6.1.6.3.1.1.5.3",
+				Varbinds: []Varbind{
+					{OID: "1.3.6.1.2.1.2.2.1.1.12", Value: "12"},
+				},
+			},
+		},
+		"v1 enterprise specific": {
+			packet: func(t *testing.T) []byte {
+				return marshal(t, &gosnmp.SnmpPacket{
+					Version:   gosnmp.Version1,
+					Community: "public",
+					PDUType:   gosnmp.Trap,
+					SnmpTrap: gosnmp.SnmpTrap{
+						Enterprise:   ".1.3.6.1.4.1.318",
+						AgentAddress: "10.0.0.3",
+						GenericTrap:  6,
+						SpecificTrap: 268,
+					},
+				})
+			},
+			out: &Trap{
+				Version:   Version1,
+				Community: "public",
+				OID:       "1.3.6.1.4.1.318.0.268",
+			},
+		},
+		"v2c": {
+			packet: func(t *testing.T) []byte { return outletV2(t, gosnmp.SNMPv2Trap, 3, 2) },
+			out: &Trap{
+				Version:   Version2c,
+				Community: "private",
+				OID:       "1.3.6.1.4.1.318.0.268",
+				Varbinds: []Varbind{
+					{OID: "1.3.6.1.2.1.1.3.0", Value: "4660"},
+					{OID: oidTrapV2, Value: "1.3.6.1.4.1.318.0.268"},
+					{OID: "1.3.6.1.4.1.318.1.1.12.3.3.1.1.1.3", Value: "3"},
+					{OID: "1.3.6.1.4.1.318.1.1.12.3.3.1.1.4.3", Value: "2"},
+					{OID: "1.3.6.1.4.1.318.1.1.12.3.3.1.1.2.3", Value: "node01"},
+				},
+			},
+		},
+		"get request": {
+			packet: func(t *testing.T) []byte {
+				return marshal(t, &gosnmp.SnmpPacket{
+					Version:   gosnmp.Version2c,
+					Community: "public",
+					PDUType:   gosnmp.GetRequest,
+					Variables: []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.Null}},
+				})
+			},
+			err: ErrUnsupportedPDU,
+		},
+		"v3": {
+			packet: func(t *testing.T) []byte {
+				return marshal(t, &gosnmp.SnmpPacket{
+					Version:            gosnmp.Version3,
+					MsgFlags:           gosnmp.NoAuthNoPriv,
+					SecurityModel:      gosnmp.UserSecurityModel,
+					SecurityParameters: &gosnmp.UsmSecurityParameters{UserName: "maas"},
+					PDUType:            gosnmp.SNMPv2Trap,
+					MsgMaxSize:         1400,
+				})
+			},
+			err: ErrUnsupportedPDU,
+		},
+		"truncated": {
+			packet: func(t *testing.T) []byte {
+				p := linkDownV1(t)
+				return p[:len(p)-3]
+			},
+			err: ErrMalformedPacket,
+		},
+		"missing trap OID": {
+			packet: func(t *testing.T) []byte {
+				return marshal(t, &gosnmp.SnmpPacket{
+					Version:   gosnmp.Version2c,
+					Community: "public",
+					PDUType:   gosnmp.SNMPv2Trap,
+					Variables: []gosnmp.SnmpPDU{
+						{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(0)},
+					},
+				})
+			},
+			err: ErrMalformedPacket,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := ParseTrap(tc.packet(t))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.out.Version, res.Version)
+			assert.Equal(t, tc.out.Community, res.Community)
+			assert.Equal(t, tc.out.OID, res.OID)
+			assert.Equal(t, tc.out.Varbinds, res.Varbinds)
+		})
+	}
+}
+
+func TestInformResponse(t *testing.T) {
+	t.Parallel()
+
+	trap, err := ParseTrap(outletV2(t, gosnmp.InformRequest, 3, 1))
+	require.NoError(t, err)
+	require.True(t, trap.inform)
+
+	b, err := trap.response()
+	require.NoError(t, err)
+
+	res, err := (&gosnmp.GoSNMP{}).SnmpDecodePacket(b)
+	require.NoError(t, err)
+	assert.Equal(t, gosnmp.GetResponse, res.PDUType)
+	assert.Equal(t, "private", res.Community)
+	assert.Equal(t, uint32(258), res.RequestID)
+	assert.Equal(t, trap.Varbinds, formatVarbinds(res.Variables))
+}
@@ -0,0 +1,480 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package snmptrap receives SNMP traps from PDUs and switches and maps them
+// to machine events and workflow signals, so external power changes and
+// link state changes are reflected without polling.
+package snmptrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.temporal.io/api/serviceerror"
+
+	"maas.io/core/src/maasagent/internal/backpressure"
+	"maas.io/core/src/maasagent/internal/ringbuf"
+)
+
+// Types of machine events
+const (
+	EventPowerOn  = "power-on"
+	EventPowerOff = "power-off"
+	EventPortUp   = "port-up"
+	EventPortDown = "port-down"
+)
+
+// SignalSNMPTrap is the name of the signal sent to workflows watching
+// the machine. Signal carries MachineEvent.
+const SignalSNMPTrap = "snmp-trap"
+
+const (
+	// maxPacketSize is the maximum size of UDP datagram
+	maxPacketSize = 65535
+	// eventsBufferBytes limits events waiting to be reported
+	eventsBufferBytes = 1024 * 1024
+	// eventOverhead is an approximate size of MachineEvent without strings
+	eventOverhead = 64
+)
+
+var (
+	// ErrInvalidMapping is returned when a mapping cannot be used
+	ErrInvalidMapping = errors.New("invalid SNMP trap mapping")
+)
+
+// DefaultMappings map standard IF-MIB link traps (RFC 2863) of switches
+// to port events. PDU traps are vendor specific and have to be configured.
+var DefaultMappings = []Mapping{
+	{
+		Name:     "link-down",
+		TrapOID:  "1.3.6.1.6.3.1.1.5.3",
+		IndexOID: "1.3.6.1.2.1.2.2.1.1",
+		Event:    EventPortDown,
+	},
+	{
+		Name:     "link-up",
+		TrapOID:  "1.3.6.1.6.3.1.1.5.4",
+		IndexOID: "1.3.6.1.2.1.2.2.1.1",
+		Event:    EventPortUp,
+	},
+}
+
+// Mapping converts traps with TrapOID to machine events. E.g. a PDU
+// reporting outlet state changes:
+//
+//	name: outlet-state
+//	trap_oid: 1.3.6.1.4.1.318.0.268
+//	index_oid: 1.3.6.1.4.1.318.1.1.12.3.3.1.1.1
+//	state_oid: 1.3.6.1.4.1.318.1.1.12.3.3.1.1.4
+//	states: {"1": power-on, "2": power-off}
+type Mapping struct {
+	// States map values of the StateOID varbind to event types
+	States  map[string]string `yaml:"states" json:"states,omitempty"`
+	Name    string            `yaml:"name" json:"name"`
+	TrapOID string            `yaml:"trap_oid" json:"trap_oid"`
+	// IndexOID is the prefix of the varbind identifying the outlet or port
+	// (e.g. ifIndex). Index is the remainder of the varbind OID.
+	IndexOID string `yaml:"index_oid" json:"index_oid,omitempty"`
+	// StateOID is the prefix of the varbind with the new state
+	StateOID string `yaml:"state_oid" json:"state_oid,omitempty"`
+	// Event is the event type, unless it is derived from StateOID
+	Event string `yaml:"event" json:"event,omitempty"`
+}
+
+// Validate checks that the mapping can produce events
+func (m Mapping) Validate() error {
+	if m.TrapOID == "" {
+		return fmt.Errorf("%w %q: missing trap OID", ErrInvalidMapping, m.Name)
+	}
+
+	if m.Event == "" && (m.StateOID == "" || len(m.States) == 0) {
+		return fmt.Errorf("%w %q: event or state OID with states is required",
+			ErrInvalidMapping, m.Name)
+	}
+
+	return nil
+}
+
+// Target links outlet or port of a device to a machine
+type Target struct {
+	// Address of the device sending traps
+	Address  string `json:"address"`
+	Index    string `json:"index"`
+	SystemID string `json:"system_id"`
+}
+
+// MachineEvent is an event of a machine derived from a trap
+type MachineEvent struct {
+	Time     time.Time `json:"time"`
+	SystemID string    `json:"system_id"`
+	Type     string    `json:"type"`
+	// Source is the address of the device which sent the trap
+	Source  string `json:"source"`
+	Index   string `json:"index,omitempty"`
+	Mapping string `json:"mapping"`
+	TrapOID string `json:"trap_oid"`
+}
+
+func (ev MachineEvent) size() int {
+	return eventOverhead + len(ev.SystemID) + len(ev.Type) + len(ev.Source) +
+		len(ev.Index) + len(ev.Mapping) + len(ev.TrapOID)
+}
+
+// Reporter is used to report machine events (e.g. to the Region)
+type Reporter interface {
+	Report(ctx context.Context, events []MachineEvent) error
+}
+
+// Signaler sends signals to workflows. It is implemented by Temporal client.
+type Signaler interface {
+	SignalWorkflow(ctx context.Context, workflowID, runID, signalName string, arg interface{}) error
+}
+
+type targetKey struct {
+	address string
+	index   string
+}
+
+// Receiver maps received traps to machine events
+type Receiver struct {
+	reporter Reporter
+	pressure *backpressure.Controller
+	signaler Signaler
+	// communities which are accepted, any if empty
+	communities map[string]struct{}
+	targets     map[targetKey]string
+	// watchers are workflows signalled on events of machines
+	watchers map[string]map[string]struct{}
+	events   *ringbuf.Buffer[MachineEvent]
+	now      func() time.Time
+	mappings []Mapping
+	mutex    sync.RWMutex
+}
+
+// ReceiverOption allows to set additional options for the Receiver
+type ReceiverOption func(*Receiver)
+
+// WithCommunities restricts accepted traps to the communities
+func WithCommunities(communities ...string) ReceiverOption {
+	return func(r *Receiver) {
+		for _, c := range communities {
+			r.communities[c] = struct{}{}
+		}
+	}
+}
+
+// WithReporter sets Reporter of machine events
+func WithReporter(reporter Reporter) ReceiverOption {
+	return func(r *Receiver) {
+		r.reporter = reporter
+	}
+}
+
+// WithBackpressure sets Controller used to batch events for longer, and to
+// skip some of port events, when the Region is slow to acknowledge them.
+// Power events are never skipped.
+func WithBackpressure(c *backpressure.Controller) ReceiverOption {
+	return func(r *Receiver) {
+		r.pressure = c
+	}
+}
+
+// WithSignaler sets Signaler used to signal watching workflows
+func WithSignaler(signaler Signaler) ReceiverOption {
+	return func(r *Receiver) {
+		r.signaler = signaler
+	}
+}
+
+// NewReceiver returns Receiver with mappings. DefaultMappings are used
+// if no mappings are provided.
+func NewReceiver(mappings []Mapping, options ...ReceiverOption) (*Receiver, error) {
+	if len(mappings) == 0 {
+		mappings = DefaultMappings
+	}
+
+	for _, m := range mappings {
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Receiver{
+		communities: make(map[string]struct{}),
+		targets:     make(map[targetKey]string),
+		watchers:    make(map[string]map[string]struct{}),
+		events:      ringbuf.New("snmp-trap", eventsBufferBytes, MachineEvent.size),
+		now:         time.Now,
+		mappings:    mappings,
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r, nil
+}
+
+// SetTargets replaces the devices, outlets and ports known to belong to
+// machines. Target without Index matches any index of the device.
+func (r *Receiver) SetTargets(targets []Target) {
+	res := make(map[targetKey]string, len(targets))
+
+	for _, t := range targets {
+		address := t.Address
+		if ip := net.ParseIP(address); ip != nil {
+			address = ip.String()
+		}
+
+		res[targetKey{address: address, index: t.Index}] = t.SystemID
+	}
+
+	r.mutex.Lock()
+	r.targets = res
+	r.mutex.Unlock()
+}
+
+// Watch makes workflowID receive signals with events of the machine
+func (r *Receiver) Watch(systemID, workflowID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.watchers[systemID] == nil {
+		r.watchers[systemID] = make(map[string]struct{})
+	}
+
+	r.watchers[systemID][workflowID] = struct{}{}
+}
+
+// Unwatch stops signalling workflowID
+func (r *Receiver) Unwatch(systemID, workflowID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.watchers[systemID], workflowID)
+
+	if len(r.watchers[systemID]) == 0 {
+		delete(r.watchers, systemID)
+	}
+}
+
+// Handle maps the trap received from source to machine events
+func (r *Receiver) Handle(source net.IP, trap *Trap) []MachineEvent {
+	if len(r.communities) > 0 {
+		if _, ok := r.communities[trap.Community]; !ok {
+			log.Debug().Str("source", source.String()).Msg("SNMP trap with unknown community dropped")
+			return nil
+		}
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var res []MachineEvent
+
+	for _, m := range r.mappings {
+		if m.TrapOID != trap.OID {
+			continue
+		}
+
+		index := varbindSuffix(trap, m.IndexOID)
+
+		event := m.Event
+		if m.StateOID != "" {
+			if state, ok := m.States[varbindValue(trap, m.StateOID)]; ok {
+				event = state
+			}
+		}
+
+		if event == "" {
+			continue
+		}
+
+		systemID, ok := r.targets[targetKey{address: source.String(), index: index}]
+		if !ok {
+			systemID, ok = r.targets[targetKey{address: source.String()}]
+		}
+
+		if !ok {
+			continue
+		}
+
+		res = append(res, MachineEvent{
+			Time:     r.now(),
+			SystemID: systemID,
+			Type:     event,
+			Source:   source.String(),
+			Index:    index,
+			Mapping:  m.Name,
+			TrapOID:  trap.OID,
+		})
+	}
+
+	for _, ev := range res {
+		// Link state changes of flapping ports are often a storm of traps,
+		// losing some of them is better than losing power events.
+		if (ev.Type == EventPortUp || ev.Type == EventPortDown) && !r.pressure.Sample() {
+			continue
+		}
+
+		r.events.Push(ev)
+	}
+
+	return res
+}
+
+// varbindSuffix returns the remainder of the OID of the first varbind
+// under prefix, e.g. ifIndex of ifIndex.12
+func varbindSuffix(trap *Trap, prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+
+	for _, v := range trap.Varbinds {
+		if suffix, ok := strings.CutPrefix(v.OID, prefix+"."); ok {
+			return suffix
+		}
+	}
+
+	return ""
+}
+
+// varbindValue returns the value of the first varbind under prefix
+func varbindValue(trap *Trap, prefix string) string {
+	for _, v := range trap.Varbinds {
+		if v.OID == prefix || strings.HasPrefix(v.OID, prefix+".") {
+			return v.Value
+		}
+	}
+
+	return ""
+}
+
+// Serve receives traps on conn until ctx is cancelled or conn is closed.
+// Informs are acknowledged, even if they are not mapped to events.
+func (r *Receiver) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		//nolint:errcheck // unblocks ReadFrom
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxPacketSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		trap, err := ParseTrap(buf[:n])
+		if err != nil {
+			log.Debug().Err(err).Str("source", addr.String()).Msg("Invalid SNMP trap")
+			continue
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		r.Handle(udpAddr.IP, trap)
+
+		if trap.inform {
+			if err := acknowledge(conn, addr, trap); err != nil {
+				log.Debug().Err(err).Str("source", addr.String()).Msg("Failed to acknowledge SNMP inform")
+			}
+		}
+	}
+}
+
+// acknowledge sends Response-PDU of the inform to addr
+func acknowledge(conn net.PacketConn, addr net.Addr, trap *Trap) error {
+	b, err := trap.response()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.WriteTo(b, addr)
+
+	return err
+}
+
+// Run reports machine events and signals watching workflows until ctx is
+// cancelled.
+func (r *Receiver) Run(ctx context.Context) {
+	for {
+		events, err := r.events.PopAll(ctx)
+		if err != nil {
+			return
+		}
+
+		r.signal(ctx, events)
+
+		if r.reporter == nil {
+			continue
+		}
+
+		if err := r.reporter.Report(ctx, events); err != nil {
+			log.Warn().Err(err).Msg("Failed to report SNMP trap events")
+		}
+
+		r.pressure.Wait(ctx)
+	}
+}
+
+// BufferStats returns usage and drop counters of events waiting to be
+// reported.
+func (r *Receiver) BufferStats() ringbuf.Stats {
+	return r.events.Stats()
+}
+
+func (r *Receiver) signal(ctx context.Context, events []MachineEvent) {
+	if r.signaler == nil {
+		return
+	}
+
+	for _, ev := range events {
+		r.mutex.RLock()
+		workflowIDs := make([]string, 0, len(r.watchers[ev.SystemID]))
+
+		for id := range r.watchers[ev.SystemID] {
+			workflowIDs = append(workflowIDs, id)
+		}
+		r.mutex.RUnlock()
+
+		for _, id := range workflowIDs {
+			err := r.signaler.SignalWorkflow(ctx, id, "", SignalSNMPTrap, ev)
+
+			var notFound *serviceerror.NotFound
+			if errors.As(err, &notFound) {
+				// Workflow has finished without unwatching the machine
+				r.Unwatch(ev.SystemID, id)
+			} else if err != nil {
+				log.Warn().Err(err).Str("workflow_id", id).Msg("Failed to signal SNMP trap event")
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package snmptrap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+
+	"maas.io/core/src/maasagent/internal/backpressure"
+)
+
+var outletMapping = Mapping{
+	Name:     "outlet-state",
+	TrapOID:  "1.3.6.1.4.1.318.0.268",
+	IndexOID: "1.3.6.1.4.1.318.1.1.12.3.3.1.1.1",
+	StateOID: "1.3.6.1.4.1.318.1.1.12.3.3.1.1.4",
+	States:   map[string]string{"1": EventPowerOn, "2": EventPowerOff},
+}
+
+type fakeSignaler struct {
+	signals  map[string][]MachineEvent
+	finished map[string]bool
+	mu       sync.Mutex
+}
+
+func (f *fakeSignaler) SignalWorkflow(_ context.Context, workflowID, _, signalName string,
+	arg interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.finished[workflowID] {
+		return serviceerror.NewNotFound("workflow execution already completed")
+	}
+
+	if signalName == SignalSNMPTrap {
+		f.signals[workflowID] = append(f.signals[workflowID], arg.(MachineEvent))
+	}
+
+	return nil
+}
+
+type fakeReporter struct {
+	events chan []MachineEvent
+}
+
+func (f *fakeReporter) Report(_ context.Context, events []MachineEvent) error {
+	f.events <- events
+	return nil
+}
+
+func TestNewReceiver(t *testing.T) {
+	testcases := map[string]struct {
+		mappings []Mapping
+		err      error
+	}{
+		"default": {},
+		"outlet":  {mappings: []Mapping{outletMapping}},
+		"missing trap OID": {
+			mappings: []Mapping{{Name: "x", Event: EventPowerOff}},
+			err:      ErrInvalidMapping,
+		},
+		"missing event": {
+			mappings: []Mapping{{Name: "x", TrapOID: "1.3.6", StateOID: "1.3.6.1"}},
+			err:      ErrInvalidMapping,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewReceiver(tc.mappings)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestReceiverHandle(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testcases := map[string]struct {
+		packet      func(t *testing.T) []byte
+		communities []string
+		source      string
+		out         []MachineEvent
+	}{
+		"switch port down": {
+			packet: linkDownV1,
+			source: "10.0.0.2",
+			out: []MachineEvent{{
+				Time: now, SystemID: "port12", Type: EventPortDown, Source: "10.0.0.2",
+				Index: "12", Mapping: "link-down", TrapOID: "1.3.6.1.6.3.1.1.5.3",
+			}},
+		},
+		"outlet power off": {
+			packet: func(t *testing.T) []byte { return outletV2(t, gosnmp.SNMPv2Trap, 3, 2) },
+			source: "10.0.0.3",
+			out: []MachineEvent{{
+				Time: now, SystemID: "outlet3", Type: EventPowerOff, Source: "10.0.0.3",
+				Index: "3", Mapping: "outlet-state", TrapOID: "1.3.6.1.4.1.318.0.268",
+			}},
+		},
+		"device level target": {
+			packet: func(t *testing.T) []byte { return outletV2(t, gosnmp.SNMPv2Trap, 5, 1) },
+			source: "10.0.0.3",
+			out: []MachineEvent{{
+				Time: now, SystemID: "pdu", Type: EventPowerOn, Source: "10.0.0.3",
+				Index: "5", Mapping: "outlet-state", TrapOID: "1.3.6.1.4.1.318.0.268",
+			}},
+		},
+		"unknown state": {
+			packet: func(t *testing.T) []byte { return outletV2(t, gosnmp.SNMPv2Trap, 3, 7) },
+			source: "10.0.0.3",
+		},
+		"unknown device": {
+			packet: linkDownV1,
+			source: "10.0.0.9",
+		},
+		"community rejected": {
+			packet:      linkDownV1,
+			communities: []string{"private"},
+			source:      "10.0.0.2",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mappings := append([]Mapping{outletMapping}, DefaultMappings...)
+
+			r, err := NewReceiver(mappings, WithCommunities(tc.communities...))
+			require.NoError(t, err)
+
+			r.now = func() time.Time { return now }
+			r.SetTargets([]Target{
+				{Address: "10.0.0.2", Index: "12", SystemID: "port12"},
+				{Address: "10.0.0.3", Index: "3", SystemID: "outlet3"},
+				{Address: "10.0.0.3", SystemID: "pdu"},
+			})
+
+			trap, err := ParseTrap(tc.packet(t))
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.out, r.Handle(net.ParseIP(tc.source), trap))
+		})
+	}
+}
+
+func TestReceiverHandleBackpressure(t *testing.T) {
+	t.Parallel()
+
+	// Region lags so much, that all events which can be lost are skipped
+	pressure := backpressure.NewController(backpressure.WithMinSampleRate(0))
+	for i := 0; i < 64; i++ {
+		pressure.Observe(0, errors.New("report failed"))
+	}
+
+	r, err := NewReceiver(append([]Mapping{outletMapping}, DefaultMappings...),
+		WithBackpressure(pressure))
+	require.NoError(t, err)
+
+	r.SetTargets([]Target{
+		{Address: "10.0.0.2", Index: "12", SystemID: "port12"},
+		{Address: "10.0.0.3", Index: "3", SystemID: "outlet3"},
+	})
+
+	linkDown, err := ParseTrap(linkDownV1(t))
+	require.NoError(t, err)
+
+	outletOff, err := ParseTrap(outletV2(t, gosnmp.SNMPv2Trap, 3, 2))
+	require.NoError(t, err)
+
+	r.Handle(net.ParseIP("10.0.0.2"), linkDown)
+	r.Handle(net.ParseIP("10.0.0.3"), outletOff)
+
+	events, err := r.events.PopAll(context.Background())
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventPowerOff, events[0].Type)
+	assert.Equal(t, uint64(1), pressure.Status().Skipped)
+}
+
+func TestReceiverServe(t *testing.T) {
+	t.Parallel()
+
+	signaler := &fakeSignaler{
+		signals:  make(map[string][]MachineEvent),
+		finished: map[string]bool{"finished": true},
+	}
+	reporter := &fakeReporter{events: make(chan []MachineEvent, 1)}
+
+	r, err := NewReceiver([]Mapping{outletMapping},
+		WithSignaler(signaler), WithReporter(reporter))
+	require.NoError(t, err)
+
+	r.SetTargets([]Target{{Address: "127.0.0.1", Index: "3", SystemID: "abc"}})
+	r.Watch("abc", "power-off:abc")
+	r.Watch("abc", "finished")
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+
+	go func() { done <- r.Serve(ctx, conn) }()
+	go r.Run(ctx)
+
+	client, err := net.Dial("udp4", conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	//nolint:errcheck // test connection
+	defer client.Close()
+
+	_, err = client.Write(outletV2(t, gosnmp.InformRequest, 3, 2))
+	require.NoError(t, err)
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	buf := make([]byte, maxPacketSize)
+	n, err := client.Read(buf)
+	require.NoError(t, err)
+
+	res, err := (&gosnmp.GoSNMP{}).SnmpDecodePacket(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, gosnmp.GetResponse, res.PDUType)
+
+	select {
+	case events := <-reporter.events:
+		require.Len(t, events, 1)
+		assert.Equal(t, EventPowerOff, events[0].Type)
+		assert.Equal(t, "abc", events[0].SystemID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not reported")
+	}
+
+	signaler.mu.Lock()
+	assert.Len(t, signaler.signals["power-off:abc"], 1)
+	signaler.mu.Unlock()
+
+	r.mutex.RLock()
+	assert.NotContains(t, r.watchers["abc"], "finished")
+	r.mutex.RUnlock()
+
+	cancel()
+	assert.NoError(t, <-done)
+}
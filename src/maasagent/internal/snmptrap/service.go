@@ -0,0 +1,62 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package snmptrap
+
+import (
+	"context"
+)
+
+// SetSNMPTrapTargetsParam is the activity parameter for set-snmp-trap-targets
+type SetSNMPTrapTargetsParam struct {
+	Targets []Target `json:"targets"`
+}
+
+// WatchSNMPTrapsParam is the activity parameter for watch-snmp-traps and
+// unwatch-snmp-traps
+type WatchSNMPTrapsParam struct {
+	SystemID   string `json:"system_id"`
+	WorkflowID string `json:"workflow_id"`
+}
+
+func (r *Receiver) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// ConfigurationActivities allows the Region to set which outlets and ports
+// belong to machines, and workflows to wait for events of machines
+// (e.g. power off after a shutdown was requested).
+func (r *Receiver) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"set-snmp-trap-targets": r.setTargets,
+		"watch-snmp-traps":      r.watch,
+		"unwatch-snmp-traps":    r.unwatch,
+	}
+}
+
+func (r *Receiver) setTargets(_ context.Context, param SetSNMPTrapTargetsParam) error {
+	r.SetTargets(param.Targets)
+	return nil
+}
+
+func (r *Receiver) watch(_ context.Context, param WatchSNMPTrapsParam) error {
+	r.Watch(param.SystemID, param.WorkflowID)
+	return nil
+}
+
+func (r *Receiver) unwatch(_ context.Context, param WatchSNMPTrapsParam) error {
+	r.Unwatch(param.SystemID, param.WorkflowID)
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order and must never be changed once released,
+// new migrations should be appended instead. Schema version is stored in
+// SQLite user_version pragma.
+var migrations = []string{
+	// 1: initial schema
+	`CREATE TABLE power_state (
+		driver_type TEXT NOT NULL,
+		machine     TEXT NOT NULL,
+		state       TEXT NOT NULL,
+		updated_at  INTEGER NOT NULL,
+		PRIMARY KEY (driver_type, machine)
+	);
+	CREATE TABLE boot_session (
+		system_id   TEXT PRIMARY KEY,
+		workflow_id TEXT NOT NULL,
+		expires_at  INTEGER NOT NULL
+	);
+	CREATE TABLE offline_queue (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind       TEXT NOT NULL,
+		payload    BLOB NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX offline_queue_kind ON offline_queue (kind, id);`,
+}
+
+// migrate applies pending migrations, each in its own transaction.
+func migrate(ctx context.Context, db *sql.DB) error {
+	var version int
+
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported %d",
+			version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := applyMigration(ctx, db, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, version int, stmt string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // rollback is a no-op once committed
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+
+	// PRAGMA doesn't support bound parameters
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
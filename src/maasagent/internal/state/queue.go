@@ -0,0 +1,114 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// defaultQueueLimit is how many reports of a kind are kept while the
+	// Region is unreachable. The oldest reports are dropped first.
+	defaultQueueLimit = 1000
+	// queueBatch is how many queued reports are read at once
+	queueBatch = 100
+)
+
+// Reporter is implemented by reporters of events to the Region
+// (e.g. remediation.APIReporter)
+type Reporter[T any] interface {
+	Report(ctx context.Context, events []T) error
+}
+
+// QueuedReporter reports events with another reporter, and keeps events it
+// failed to report in the offline queue of the store. Queued events are
+// reported first the next time events are reported, even after the Agent
+// restarts, so events produced while the Region is unreachable are not lost.
+type QueuedReporter[T any] struct {
+	store    *Store
+	reporter Reporter[T]
+	kind     string
+	limit    int
+	mutex    sync.Mutex
+}
+
+// NewQueuedReporter returns QueuedReporter queueing events of reporter as
+// kind, which must be unique to the events.
+func NewQueuedReporter[T any](store *Store, kind string, reporter Reporter[T]) *QueuedReporter[T] {
+	return &QueuedReporter[T]{
+		store:    store,
+		reporter: reporter,
+		kind:     kind,
+		limit:    defaultQueueLimit,
+	}
+}
+
+// Report reports queued events and then events. Events are queued if they
+// cannot be reported, and the error of the reporter is returned.
+func (r *QueuedReporter[T]) Report(ctx context.Context, events []T) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.flush(ctx)
+	if err == nil {
+		err = r.reporter.Report(ctx, events)
+	}
+
+	if err == nil || len(events) == 0 {
+		return err
+	}
+
+	payload, qerr := json.Marshal(events)
+	if qerr == nil {
+		qerr = r.store.Enqueue(context.WithoutCancel(ctx), r.kind, payload, r.limit)
+	}
+
+	if qerr != nil {
+		return errors.Join(err, fmt.Errorf("failed to queue %s: %w", r.kind, qerr))
+	}
+
+	return err
+}
+
+// flush reports queued events, oldest first, until the queue is empty
+func (r *QueuedReporter[T]) flush(ctx context.Context) error {
+	for {
+		items, err := r.store.Peek(ctx, r.kind, queueBatch)
+		if err != nil || len(items) == 0 {
+			return err
+		}
+
+		for _, item := range items {
+			var events []T
+
+			// Events which can no longer be decoded are dropped, they would
+			// block the queue otherwise
+			if err := json.Unmarshal(item.Payload, &events); err == nil {
+				if err := r.reporter.Report(ctx, events); err != nil {
+					return err
+				}
+			}
+
+			if err := r.store.Ack(ctx, item.ID); err != nil {
+				return err
+			}
+		}
+	}
+}
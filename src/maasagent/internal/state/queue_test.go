@@ -0,0 +1,65 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReporter struct {
+	err      error
+	reported [][]string
+}
+
+func (r *fakeReporter) Report(_ context.Context, events []string) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	r.reported = append(r.reported, events)
+
+	return nil
+}
+
+func TestQueuedReporter(t *testing.T) {
+	errUnreachable := errors.New("region unreachable")
+	path := filepath.Join(t.TempDir(), "state.db")
+	ctx := context.Background()
+
+	reporter := &fakeReporter{err: errUnreachable}
+	r := NewQueuedReporter[string](openStore(t, path), "events", reporter)
+
+	assert.ErrorIs(t, r.Report(ctx, []string{"a", "b"}), errUnreachable)
+	assert.ErrorIs(t, r.Report(ctx, []string{"c"}), errUnreachable)
+	assert.ErrorIs(t, r.Report(ctx, nil), errUnreachable)
+
+	// Queued events are reported first once the Region is reachable, even
+	// after the Agent restarts
+	reporter.err = nil
+	r = NewQueuedReporter[string](openStore(t, path), "events", reporter)
+
+	require.NoError(t, r.Report(ctx, []string{"d"}))
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}, {"d"}}, reporter.reported)
+
+	require.NoError(t, r.Report(ctx, []string{"e"}))
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}, {"d"}, {"e"}}, reporter.reported)
+}
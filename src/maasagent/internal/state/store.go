@@ -0,0 +1,254 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package state provides an embedded SQLite store for Agent-local state
+// (power state cache, boot sessions and offline queue of reports), so that
+// it survives Agent restarts. SQLite is linked with modernc.org/sqlite,
+// which doesn't require cgo.
+package state
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	// registers "sqlite" driver of database/sql
+	_ "modernc.org/sqlite"
+)
+
+// driverName is the database/sql driver name of modernc.org/sqlite
+const driverName = "sqlite"
+
+var (
+	// ErrNotFound is returned when there is no record for the given key
+	ErrNotFound = errors.New("state not found")
+)
+
+// Store is an Agent-local state store backed by SQLite.
+type Store struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// Open opens SQLite database at path and applies pending migrations.
+// The database is created if it doesn't exist.
+func Open(ctx context.Context, path string) (*Store, error) {
+	// WAL allows readers to proceed while a write is in progress and
+	// busy_timeout avoids spurious SQLITE_BUSY errors under contention.
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"+
+		"&_pragma=synchronous(NORMAL)", path)
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite supports a single writer, so a single connection avoids
+	// lock contention between connections of the pool.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(ctx, db); err != nil {
+		//nolint:errcheck // we already return a more important error
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate state database: %w", err)
+	}
+
+	return &Store{db: db, now: time.Now}, nil
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// PowerState is the last known power state of a machine.
+type PowerState struct {
+	UpdatedAt  time.Time
+	Machine    string
+	DriverType string
+	State      string
+}
+
+// SetPowerState records the last known power state of a machine, which is
+// identified within its driver type (e.g. by its power address).
+func (s *Store) SetPowerState(ctx context.Context, driverType, machine, state string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO power_state (driver_type, machine, state, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (driver_type, machine) DO UPDATE SET
+			state = excluded.state,
+			updated_at = excluded.updated_at`,
+		driverType, machine, state, s.now().UTC().UnixMilli())
+
+	return err
+}
+
+// GetPowerState returns the last known power state of a machine.
+func (s *Store) GetPowerState(ctx context.Context, driverType, machine string) (PowerState, error) {
+	ps := PowerState{DriverType: driverType, Machine: machine}
+
+	var updatedAt int64
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT state, updated_at FROM power_state WHERE driver_type = ? AND machine = ?`,
+		driverType, machine).Scan(&ps.State, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ps, ErrNotFound
+	}
+
+	ps.UpdatedAt = time.UnixMilli(updatedAt).UTC()
+
+	return ps, err
+}
+
+// BootSession is a workflow waiting for a machine to boot (e.g. to phone
+// home once deployed).
+type BootSession struct {
+	ExpiresAt  time.Time
+	SystemID   string
+	WorkflowID string
+}
+
+// PutBootSession adds or replaces the boot session of the machine.
+func (s *Store) PutBootSession(ctx context.Context, b BootSession) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO boot_session (system_id, workflow_id, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (system_id) DO UPDATE SET
+			workflow_id = excluded.workflow_id,
+			expires_at = excluded.expires_at`,
+		b.SystemID, b.WorkflowID, b.ExpiresAt.UTC().UnixMilli())
+
+	return err
+}
+
+// DeleteBootSession removes the boot session of the machine.
+func (s *Store) DeleteBootSession(ctx context.Context, systemID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM boot_session WHERE system_id = ?`, systemID)
+	return err
+}
+
+// BootSessions removes expired boot sessions and returns the others.
+func (s *Store) BootSessions(ctx context.Context) ([]BootSession, error) {
+	now := s.now().UTC().UnixMilli()
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM boot_session WHERE expires_at <= ?`, now); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT system_id, workflow_id, expires_at FROM boot_session ORDER BY system_id`)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // rows.Err() is checked below
+	defer rows.Close()
+
+	var res []BootSession
+
+	for rows.Next() {
+		var (
+			b         BootSession
+			expiresAt int64
+		)
+
+		if err := rows.Scan(&b.SystemID, &b.WorkflowID, &expiresAt); err != nil {
+			return nil, err
+		}
+
+		b.ExpiresAt = time.UnixMilli(expiresAt).UTC()
+		res = append(res, b)
+	}
+
+	return res, rows.Err()
+}
+
+// QueueItem is a message waiting to be delivered to the Region.
+type QueueItem struct {
+	CreatedAt time.Time
+	Kind      string
+	Payload   []byte
+	ID        int64
+}
+
+// Enqueue appends a message of kind to the offline queue. Only the newest
+// limit messages of the kind are kept, if limit is positive.
+func (s *Store) Enqueue(ctx context.Context, kind string, payload []byte, limit int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // rollback is a no-op once committed
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO offline_queue (kind, payload, created_at) VALUES (?, ?, ?)`,
+		kind, payload, s.now().UTC().UnixMilli()); err != nil {
+		return err
+	}
+
+	if limit > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM offline_queue WHERE kind = ? AND id NOT IN (
+				SELECT id FROM offline_queue WHERE kind = ? ORDER BY id DESC LIMIT ?)`,
+			kind, kind, limit); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Peek returns up to limit oldest messages of kind in the offline queue.
+// Messages stay in the queue until they are removed with Ack.
+func (s *Store) Peek(ctx context.Context, kind string, limit int) ([]QueueItem, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, payload, created_at FROM offline_queue WHERE kind = ? ORDER BY id LIMIT ?`,
+		kind, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // rows.Err() is checked below
+	defer rows.Close()
+
+	var res []QueueItem
+
+	for rows.Next() {
+		var (
+			item      QueueItem
+			createdAt int64
+		)
+
+		if err := rows.Scan(&item.ID, &item.Payload, &createdAt); err != nil {
+			return nil, err
+		}
+
+		item.Kind = kind
+		item.CreatedAt = time.UnixMilli(createdAt).UTC()
+		res = append(res, item)
+	}
+
+	return res, rows.Err()
+}
+
+// Ack removes a delivered message from the offline queue.
+func (s *Store) Ack(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM offline_queue WHERE id = ?`, id)
+	return err
+}
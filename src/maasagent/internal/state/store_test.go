@@ -0,0 +1,144 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openStore(t *testing.T, path string) *Store {
+	t.Helper()
+
+	s, err := Open(context.Background(), path)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestOpenMigrates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	ctx := context.Background()
+
+	s, err := Open(ctx, path)
+	require.NoError(t, err)
+
+	var version int
+
+	require.NoError(t, s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version))
+	assert.Equal(t, len(migrations), version)
+	require.NoError(t, s.Close())
+
+	// Opening the database again doesn't apply migrations twice
+	s = openStore(t, path)
+
+	_, err = s.db.ExecContext(ctx, `PRAGMA user_version = 999`)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	_, err = Open(ctx, path)
+	assert.ErrorContains(t, err, "newer than supported")
+}
+
+func TestPowerState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	ctx := context.Background()
+	s := openStore(t, path)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	_, err := s.GetPowerState(ctx, "simulator", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.SetPowerState(ctx, "simulator", "10.0.0.1", "on"))
+	require.NoError(t, s.SetPowerState(ctx, "simulator", "10.0.0.1", "off"))
+	require.NoError(t, s.SetPowerState(ctx, "virsh", "10.0.0.1", "on"))
+	require.NoError(t, s.Close())
+
+	// States survive reopening the database
+	s = openStore(t, path)
+
+	ps, err := s.GetPowerState(ctx, "simulator", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, PowerState{DriverType: "simulator", Machine: "10.0.0.1", State: "off", UpdatedAt: now}, ps)
+}
+
+func TestBootSessions(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	sessions := []BootSession{
+		{SystemID: "abc", WorkflowID: "deploy:abc", ExpiresAt: now.Add(time.Hour)},
+		{SystemID: "def", WorkflowID: "deploy:def", ExpiresAt: now.Add(time.Minute)},
+	}
+
+	for _, b := range sessions {
+		require.NoError(t, s.PutBootSession(ctx, b))
+	}
+
+	res, err := s.BootSessions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, sessions, res)
+
+	now = now.Add(2 * time.Minute)
+
+	require.NoError(t, s.DeleteBootSession(ctx, "abc"))
+
+	res, err = s.BootSessions(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, res, "expired sessions are removed")
+}
+
+func TestOfflineQueue(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+
+	for _, payload := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, s.Enqueue(ctx, "events", []byte(payload), 3))
+	}
+
+	require.NoError(t, s.Enqueue(ctx, "other", []byte("x"), 0))
+
+	items, err := s.Peek(ctx, "events", 2)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "2", string(items[0].Payload), "only the newest messages are kept")
+	assert.Equal(t, "3", string(items[1].Payload))
+
+	require.NoError(t, s.Ack(ctx, items[0].ID))
+
+	items, err = s.Peek(ctx, "events", 10)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "3", string(items[0].Payload))
+	assert.Equal(t, "4", string(items[1].Payload))
+
+	items, err = s.Peek(ctx, "other", 10)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "other", items[0].Kind)
+}
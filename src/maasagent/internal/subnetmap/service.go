@@ -0,0 +1,77 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package subnetmap
+
+import (
+	"time"
+
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+// SubnetMapService keeps Map in sync with configuration of the Region.
+// Invocation of this service normally should happen via Temporal.
+type SubnetMapService struct {
+	m *Map
+}
+
+// NewSubnetMapService returns an instance of SubnetMapService
+func NewSubnetMapService(m *Map) *SubnetMapService {
+	return &SubnetMapService{m: m}
+}
+
+type getSubnetServicesParam struct {
+	SystemID string `json:"system_id"`
+}
+
+type getSubnetServicesResult struct {
+	Subnets []Entry `json:"subnets"`
+}
+
+func (s *SubnetMapService) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{"configure-subnet-services": s.configure}
+}
+
+func (s *SubnetMapService) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (s *SubnetMapService) configure(ctx tworkflow.Context, systemID string) error {
+	log := tworkflow.GetLogger(ctx)
+
+	var result getSubnetServicesResult
+
+	if err := tworkflow.ExecuteActivity(
+		tworkflow.WithActivityOptions(ctx,
+			tworkflow.ActivityOptions{
+				TaskQueue:              "region",
+				ScheduleToCloseTimeout: 60 * time.Second,
+			}),
+		"get-subnet-services", getSubnetServicesParam{SystemID: systemID}).
+		Get(ctx, &result); err != nil {
+		return err
+	}
+
+	// Update is an in-memory operation, so it is safe to call it from
+	// the workflow. It is deterministic on replay as well.
+	if err := s.m.Update(result.Subnets); err != nil {
+		return err
+	}
+
+	log.Info("Subnet services configured", tag.Builder().KV("subnets", len(result.Subnets)).KeyVals...)
+
+	return nil
+}
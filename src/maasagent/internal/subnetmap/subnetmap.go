@@ -0,0 +1,136 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package subnetmap holds an explicit mapping of provisioning subnets to the
+// services (images, proxies, DNS servers) machines in that subnet should use.
+package subnetmap
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// ErrInvalidSubnet is returned when an entry has invalid subnet CIDR
+	ErrInvalidSubnet = errors.New("invalid subnet")
+)
+
+// Services that are available for a subnet. Empty fields mean there are no
+// restrictions and defaults should be used.
+type Services struct {
+	// Images are IDs of boot resources machines are allowed to boot
+	Images []string `json:"images"`
+	// Proxies are Region endpoints used to fetch boot resources
+	Proxies []string `json:"proxies"`
+	// DNSServers to be used by machines
+	DNSServers []string `json:"dns_servers"`
+}
+
+// Entry maps subnet CIDR to its services
+type Entry struct {
+	Subnet string `json:"subnet"`
+	Services
+}
+
+type entry struct {
+	prefix   netip.Prefix
+	services Services
+}
+
+// Map is a concurrency safe subnet to services mapping. The most specific
+// subnet containing an address wins.
+type Map struct {
+	entries atomic.Pointer[[]entry]
+}
+
+// New returns an empty Map
+func New() *Map {
+	return &Map{}
+}
+
+// Update replaces all entries of the map. Entries are validated first, so
+// the map is never partially updated.
+func (m *Map) Update(entries []Entry) error {
+	res := make([]entry, 0, len(entries))
+
+	for _, e := range entries {
+		prefix, err := netip.ParsePrefix(e.Subnet)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSubnet, err)
+		}
+
+		res = append(res, entry{prefix: prefix.Masked(), services: e.Services})
+	}
+
+	// Longest prefix first, so the first match is the most specific one
+	slices.SortStableFunc(res, func(a, b entry) int {
+		return b.prefix.Bits() - a.prefix.Bits()
+	})
+
+	m.entries.Store(&res)
+
+	return nil
+}
+
+// Lookup returns services of the most specific subnet containing addr.
+func (m *Map) Lookup(addr netip.Addr) (Services, bool) {
+	if m == nil {
+		return Services{}, false
+	}
+
+	entries := m.entries.Load()
+	if entries == nil {
+		return Services{}, false
+	}
+
+	addr = addr.Unmap()
+
+	for _, e := range *entries {
+		if e.prefix.Contains(addr) {
+			return e.services, true
+		}
+	}
+
+	return Services{}, false
+}
+
+// ClientAddr returns address of the client that has sent the request.
+// Requests proxied by NGINX (via unix socket) carry the address in the
+// X-Real-IP or X-Forwarded-For header.
+func ClientAddr(r *http.Request) (netip.Addr, bool) {
+	if v := r.Header.Get("X-Real-IP"); v != "" {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(v)); err == nil {
+			return addr, true
+		}
+	}
+
+	if v := r.Header.Get("X-Forwarded-For"); v != "" {
+		first, _, _ := strings.Cut(v, ",")
+		if addr, err := netip.ParseAddr(strings.TrimSpace(first)); err == nil {
+			return addr, true
+		}
+	}
+
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		return addrPort.Addr(), true
+	}
+
+	return netip.Addr{}, false
+}
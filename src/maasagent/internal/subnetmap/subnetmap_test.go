@@ -0,0 +1,143 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package subnetmap
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	m := New()
+
+	_, ok := m.Lookup(netip.MustParseAddr("10.0.0.1"))
+	assert.False(t, ok)
+
+	require.NoError(t, m.Update([]Entry{
+		{Subnet: "10.0.0.0/8", Services: Services{DNSServers: []string{"10.0.0.53"}}},
+		{Subnet: "10.1.0.1/16", Services: Services{Images: []string{"abcdef"}}},
+		{Subnet: "fd00::/64", Services: Services{Proxies: []string{"http://[fd00::1]:5240"}}},
+	}))
+
+	testcases := map[string]struct {
+		in  string
+		out Services
+		ok  bool
+	}{
+		"most specific subnet": {
+			in:  "10.1.2.3",
+			out: Services{Images: []string{"abcdef"}},
+			ok:  true,
+		},
+		"less specific subnet": {
+			in:  "10.2.0.1",
+			out: Services{DNSServers: []string{"10.0.0.53"}},
+			ok:  true,
+		},
+		"ipv4-mapped ipv6": {
+			in:  "::ffff:10.2.0.1",
+			out: Services{DNSServers: []string{"10.0.0.53"}},
+			ok:  true,
+		},
+		"ipv6": {
+			in:  "fd00::10",
+			out: Services{Proxies: []string{"http://[fd00::1]:5240"}},
+			ok:  true,
+		},
+		"no match": {
+			in: "192.168.0.1",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, ok := m.Lookup(netip.MustParseAddr(tc.in))
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestUpdateInvalidSubnet(t *testing.T) {
+	m := New()
+	require.NoError(t, m.Update([]Entry{{Subnet: "10.0.0.0/8"}}))
+
+	err := m.Update([]Entry{{Subnet: "192.168.0.0/16"}, {Subnet: "10.0.0.0"}})
+	assert.ErrorIs(t, err, ErrInvalidSubnet)
+
+	// The map is not partially updated
+	_, ok := m.Lookup(netip.MustParseAddr("10.0.0.1"))
+	assert.True(t, ok)
+
+	_, ok = m.Lookup(netip.MustParseAddr("192.168.0.1"))
+	assert.False(t, ok)
+}
+
+func TestClientAddr(t *testing.T) {
+	testcases := map[string]struct {
+		headers    map[string]string
+		remoteAddr string
+		out        string
+	}{
+		"x-real-ip": {
+			headers: map[string]string{"X-Real-IP": "10.0.0.2", "X-Forwarded-For": "10.0.0.3"},
+			out:     "10.0.0.2",
+		},
+		"x-forwarded-for": {
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.4"},
+			out:     "10.0.0.3",
+		},
+		"remote address": {
+			remoteAddr: "[fd00::2]:1234",
+			out:        "fd00::2",
+		},
+		"unix socket": {
+			remoteAddr: "@",
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("GET", "http://example.com/", nil)
+			r.RemoteAddr = tc.remoteAddr
+
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			addr, ok := ClientAddr(r)
+			if tc.out == "" {
+				assert.False(t, ok)
+				return
+			}
+
+			assert.True(t, ok)
+			assert.Equal(t, tc.out, addr.String())
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package switchport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// eapi configures Arista EOS switches with eAPI JSON-RPC
+type eapi struct {
+	client *http.Client
+	url    string
+	cfg    SwitchConfig
+}
+
+type eapiRequest struct {
+	Params  eapiParams `json:"params"`
+	JSONRPC string     `json:"jsonrpc"`
+	Method  string     `json:"method"`
+	ID      string     `json:"id"`
+}
+
+type eapiParams struct {
+	Format  string   `json:"format"`
+	Cmds    []string `json:"cmds"`
+	Version int      `json:"version"`
+}
+
+type eapiResponse struct {
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Result []json.RawMessage `json:"result"`
+}
+
+func newEAPI(cfg SwitchConfig) (Backend, error) {
+	return &eapi{
+		client: httpClient(cfg),
+		url:    baseURL(cfg.Address) + "/command-api",
+		cfg:    cfg,
+	}, nil
+}
+
+// run executes commands in privileged mode and returns their results,
+// without the result of "enable"
+func (e *eapi) run(ctx context.Context, cmds ...string) ([]json.RawMessage, error) {
+	req := eapiRequest{
+		JSONRPC: "2.0",
+		Method:  "runCmds",
+		Params: eapiParams{
+			Version: 1,
+			Format:  "json",
+			Cmds:    append([]string{"enable"}, cmds...),
+		},
+		ID: "maas",
+	}
+
+	var resp eapiResponse
+	if err := postJSON(ctx, e.client, e.cfg, e.url, "application/json", req, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCommandFailed, resp.Error.Message)
+	}
+
+	if len(resp.Result) != len(req.Params.Cmds) {
+		return nil, fmt.Errorf("%w: expected %d results, got %d", ErrCommandFailed,
+			len(req.Params.Cmds), len(resp.Result))
+	}
+
+	return resp.Result[1:], nil
+}
+
+func (e *eapi) AccessVLAN(ctx context.Context, port string) (int, error) {
+	result, err := e.run(ctx, fmt.Sprintf("show interfaces %s switchport", port))
+	if err != nil {
+		return 0, err
+	}
+
+	var body struct {
+		Switchports map[string]struct {
+			SwitchportInfo struct {
+				AccessVlanID int `json:"accessVlanId"`
+			} `json:"switchportInfo"`
+		} `json:"switchports"`
+	}
+
+	if err := json.Unmarshal(result[0], &body); err != nil {
+		return 0, err
+	}
+
+	// Switch might report the port with a different, canonical name
+	for _, sp := range body.Switchports {
+		return sp.SwitchportInfo.AccessVlanID, nil
+	}
+
+	return 0, fmt.Errorf("%w: no switchport information for %s", ErrCommandFailed, port)
+}
+
+func (e *eapi) SetAccessVLAN(ctx context.Context, port string, vlan int) error {
+	_, err := e.run(ctx,
+		"configure",
+		fmt.Sprintf("interface %s", port),
+		"switchport",
+		"switchport mode access",
+		fmt.Sprintf("switchport access vlan %d", vlan),
+		"end",
+	)
+
+	return err
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package switchport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+const (
+	netconfPort = "830"
+	// netconfEOM is the end of message marker of NETCONF 1.0 framing
+	netconfEOM = "]]>]]>"
+	netconfNS  = "urn:ietf:params:netconf:base:1.0"
+
+	capabilityCandidate       = "urn:ietf:params:netconf:capability:candidate:1.0"
+	capabilityWritableRunning = "urn:ietf:params:netconf:capability:writable-running:1.0"
+)
+
+// Ports are configured with OpenConfig interfaces and VLAN models
+const (
+	openconfigInterface = `<interfaces xmlns="http://openconfig.net/yang/interfaces">` +
+		`<interface><name>%s</name>` +
+		`<ethernet xmlns="http://openconfig.net/yang/interfaces/ethernet">%s</ethernet>` +
+		`</interface></interfaces>`
+	openconfigSwitchedVLAN = `<switched-vlan xmlns="http://openconfig.net/yang/vlan">` +
+		`<config><interface-mode>ACCESS</interface-mode><access-vlan>%d</access-vlan></config>` +
+		`</switched-vlan>`
+)
+
+type netconfDialer func(ctx context.Context, cfg SwitchConfig) (io.ReadWriteCloser, error)
+
+// netconf configures switches supporting NETCONF with OpenConfig models
+type netconf struct {
+	dial netconfDialer
+	cfg  SwitchConfig
+}
+
+func newNETCONF(cfg SwitchConfig) (Backend, error) {
+	return &netconf{cfg: cfg, dial: dialSSHNETCONF}, nil
+}
+
+type cmdConn struct {
+	io.Reader
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (c *cmdConn) Close() error {
+	//nolint:errcheck // the process is waited for below
+	c.WriteCloser.Close()
+
+	return c.cmd.Wait()
+}
+
+// dialSSHNETCONF starts NETCONF subsystem with the system ssh client.
+// Authentication is done with keys of the Agent, the password is not used.
+func dialSSHNETCONF(ctx context.Context, cfg SwitchConfig) (io.ReadWriteCloser, error) {
+	host, port, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		host, port = cfg.Address, netconfPort
+	}
+
+	//nolint:gosec // arguments are passed to ssh without a shell
+	cmd := exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes",
+		"-p", port, "-l", cfg.Username, host, "-s", "netconf")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &cmdConn{Reader: stdout, WriteCloser: stdin, cmd: cmd}, nil
+}
+
+type netconfSession struct {
+	conn         io.ReadWriteCloser
+	r            *bufio.Reader
+	capabilities []string
+	messageID    int
+}
+
+type netconfHello struct {
+	Capabilities []string `xml:"capabilities>capability"`
+}
+
+type netconfReply struct {
+	Data struct {
+		Inner []byte `xml:",innerxml"`
+	} `xml:"data"`
+	Errors []struct {
+		Severity string `xml:"error-severity"`
+		Message  string `xml:"error-message"`
+	} `xml:"rpc-error"`
+}
+
+func (n *netconf) session(ctx context.Context) (*netconfSession, error) {
+	conn, err := n.dial(ctx, n.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &netconfSession{conn: conn, r: bufio.NewReader(conn)}
+
+	msg, err := s.read()
+	if err != nil {
+		//nolint:errcheck // we already return a more important error
+		conn.Close()
+		return nil, err
+	}
+
+	var hello netconfHello
+	if err := xml.Unmarshal(msg, &hello); err != nil {
+		//nolint:errcheck // we already return a more important error
+		conn.Close()
+		return nil, err
+	}
+
+	s.capabilities = hello.Capabilities
+
+	if err := s.write(`<hello xmlns="` + netconfNS + `"><capabilities>` +
+		`<capability>urn:ietf:params:netconf:base:1.0</capability>` +
+		`</capabilities></hello>`); err != nil {
+		//nolint:errcheck // we already return a more important error
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *netconfSession) has(capability string) bool {
+	for _, c := range s.capabilities {
+		// Capabilities might have parameters, e.g. "?module=..."
+		if strings.HasPrefix(strings.TrimSpace(c), capability) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *netconfSession) write(msg string) error {
+	_, err := io.WriteString(s.conn, msg+netconfEOM)
+	return err
+}
+
+func (s *netconfSession) read() ([]byte, error) {
+	var buf bytes.Buffer
+
+	for {
+		chunk, err := s.r.ReadBytes('>')
+		buf.Write(chunk)
+
+		if bytes.HasSuffix(buf.Bytes(), []byte(netconfEOM)) {
+			return bytes.TrimSuffix(buf.Bytes(), []byte(netconfEOM)), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *netconfSession) rpc(operation string) (*netconfReply, error) {
+	s.messageID++
+
+	if err := s.write(fmt.Sprintf(`<rpc message-id="%d" xmlns="%s">%s</rpc>`,
+		s.messageID, netconfNS, operation)); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var reply netconfReply
+	if err := xml.Unmarshal(msg, &reply); err != nil {
+		return nil, err
+	}
+
+	for _, e := range reply.Errors {
+		if e.Severity == "error" {
+			return nil, fmt.Errorf("%w: %s", ErrCommandFailed, strings.TrimSpace(e.Message))
+		}
+	}
+
+	return &reply, nil
+}
+
+func (s *netconfSession) Close() error {
+	//nolint:errcheck // session is closed anyway
+	s.rpc("<close-session/>")
+
+	return s.conn.Close()
+}
+
+func (n *netconf) AccessVLAN(ctx context.Context, port string) (int, error) {
+	s, err := n.session(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer s.Close()
+
+	reply, err := s.rpc(`<get-config><source><running/></source><filter type="subtree">` +
+		fmt.Sprintf(openconfigInterface, port, `<switched-vlan xmlns="http://openconfig.net/yang/vlan"/>`) +
+		`</filter></get-config>`)
+	if err != nil {
+		return 0, err
+	}
+
+	var data struct {
+		Interfaces []struct {
+			VLAN *int   `xml:"ethernet>switched-vlan>config>access-vlan"`
+			Name string `xml:"name"`
+		} `xml:"interfaces>interface"`
+	}
+
+	if err := xml.Unmarshal(append(append([]byte("<data>"), reply.Data.Inner...), "</data>"...), &data); err != nil {
+		return 0, err
+	}
+
+	for _, i := range data.Interfaces {
+		if i.Name == port && i.VLAN != nil {
+			return *i.VLAN, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: no access VLAN for %s", ErrCommandFailed, port)
+}
+
+// SetAccessVLAN edits the running configuration directly if supported,
+// otherwise the candidate configuration is edited and committed.
+func (n *netconf) SetAccessVLAN(ctx context.Context, port string, vlan int) error {
+	s, err := n.session(ctx)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer s.Close()
+
+	target := "running"
+	if !s.has(capabilityWritableRunning) && s.has(capabilityCandidate) {
+		target = "candidate"
+	}
+
+	if _, err := s.rpc(fmt.Sprintf(`<edit-config><target><%s/></target><config>`, target) +
+		fmt.Sprintf(openconfigInterface, port, fmt.Sprintf(openconfigSwitchedVLAN, vlan)) +
+		`</config></edit-config>`); err != nil {
+		return err
+	}
+
+	if target == "candidate" {
+		if _, err := s.rpc("<commit/>"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package switchport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var messageIDRe = regexp.MustCompile(`message-id="(\d+)"`)
+
+// fakeNETCONF serves NETCONF session over the pipe, replying to every rpc
+// with the given function and recording received operations
+type fakeNETCONF struct {
+	reply        func(rpc string) string
+	capabilities []string
+	rpcs         []string
+}
+
+func (f *fakeNETCONF) dial(t *testing.T) netconfDialer {
+	return func(_ context.Context, _ SwitchConfig) (io.ReadWriteCloser, error) {
+		client, server := net.Pipe()
+
+		go f.serve(t, server)
+
+		return client, nil
+	}
+}
+
+func (f *fakeNETCONF) serve(t *testing.T, conn net.Conn) {
+	//nolint:errcheck // fake server
+	defer conn.Close()
+
+	var hello bytes.Buffer
+
+	hello.WriteString(`<hello xmlns="urn:ietf:params:netconf:base:1.0"><capabilities>`)
+
+	for _, c := range f.capabilities {
+		fmt.Fprintf(&hello, "<capability>%s</capability>", c)
+	}
+
+	hello.WriteString(`</capabilities><session-id>1</session-id></hello>` + netconfEOM)
+
+	if _, err := conn.Write(hello.Bytes()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := &netconfSession{conn: conn, r: bufio.NewReader(conn)}
+
+	// Client hello
+	if _, err := s.read(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for {
+		msg, err := s.read()
+		if err != nil {
+			return
+		}
+
+		rpc := string(msg)
+		f.rpcs = append(f.rpcs, rpc)
+
+		id := messageIDRe.FindStringSubmatch(rpc)[1]
+
+		body := "<ok/>"
+		if f.reply != nil {
+			body = f.reply(rpc)
+		}
+
+		if err := s.write(fmt.Sprintf(`<rpc-reply message-id="%s" xmlns="%s">%s</rpc-reply>`,
+			id, netconfNS, body)); err != nil {
+			return
+		}
+	}
+}
+
+func TestNETCONFAccessVLAN(t *testing.T) {
+	fake := &fakeNETCONF{reply: func(rpc string) string {
+		if !bytes.Contains([]byte(rpc), []byte("<get-config>")) {
+			return "<ok/>"
+		}
+
+		return `<data><interfaces xmlns="http://openconfig.net/yang/interfaces">` +
+			`<interface><name>Ethernet1</name>` +
+			`<ethernet xmlns="http://openconfig.net/yang/interfaces/ethernet">` +
+			`<switched-vlan xmlns="http://openconfig.net/yang/vlan"><config>` +
+			`<interface-mode>ACCESS</interface-mode><access-vlan>42</access-vlan>` +
+			`</config></switched-vlan></ethernet></interface></interfaces></data>`
+	}}
+
+	backend := &netconf{dial: fake.dial(t)}
+
+	vlan, err := backend.AccessVLAN(context.Background(), "Ethernet1")
+	require.NoError(t, err)
+	assert.Equal(t, 42, vlan)
+
+	_, err = backend.AccessVLAN(context.Background(), "Ethernet2")
+	assert.ErrorIs(t, err, ErrCommandFailed)
+}
+
+func TestNETCONFSetAccessVLAN(t *testing.T) {
+	testcases := map[string]struct {
+		capabilities []string
+		operations   []string
+	}{
+		"writable running": {
+			capabilities: []string{capabilityWritableRunning, capabilityCandidate},
+			operations:   []string{"<edit-config><target><running/>", "<close-session/>"},
+		},
+		"candidate": {
+			capabilities: []string{capabilityCandidate},
+			operations:   []string{"<edit-config><target><candidate/>", "<commit/>", "<close-session/>"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fake := &fakeNETCONF{capabilities: append([]string{"urn:ietf:params:netconf:base:1.0"},
+				tc.capabilities...)}
+
+			backend := &netconf{dial: fake.dial(t)}
+
+			require.NoError(t, backend.SetAccessVLAN(context.Background(), "Ethernet1", 100))
+
+			require.Len(t, fake.rpcs, len(tc.operations))
+
+			for i, op := range tc.operations {
+				assert.Contains(t, fake.rpcs[i], op)
+			}
+
+			assert.Contains(t, fake.rpcs[0], "<name>Ethernet1</name>")
+			assert.Contains(t, fake.rpcs[0], "<access-vlan>100</access-vlan>")
+		})
+	}
+}
+
+func TestNETCONFError(t *testing.T) {
+	fake := &fakeNETCONF{
+		capabilities: []string{capabilityWritableRunning},
+		reply: func(rpc string) string {
+			return `<rpc-error><error-type>application</error-type>` +
+				`<error-severity>error</error-severity>` +
+				`<error-message>VLAN 100 does not exist</error-message></rpc-error>`
+		},
+	}
+
+	backend := &netconf{dial: fake.dial(t)}
+
+	err := backend.SetAccessVLAN(context.Background(), "Ethernet1", 100)
+	assert.ErrorIs(t, err, ErrCommandFailed)
+	assert.ErrorContains(t, err, "VLAN 100 does not exist")
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package switchport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// nxapi configures Cisco Nexus switches with NX-API JSON-RPC
+type nxapi struct {
+	client *http.Client
+	url    string
+	cfg    SwitchConfig
+}
+
+type nxapiRequest struct {
+	Params  nxapiParams `json:"params"`
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	ID      int         `json:"id"`
+}
+
+type nxapiParams struct {
+	Cmd     string `json:"cmd"`
+	Version int    `json:"version"`
+}
+
+type nxapiResponse struct {
+	Result *struct {
+		Body json.RawMessage `json:"body"`
+	} `json:"result"`
+	Error *struct {
+		Data *struct {
+			Msg string `json:"msg"`
+		} `json:"data"`
+		Message string `json:"message"`
+	} `json:"error"`
+	ID int `json:"id"`
+}
+
+func newNXAPI(cfg SwitchConfig) (Backend, error) {
+	return &nxapi{
+		client: httpClient(cfg),
+		url:    baseURL(cfg.Address) + "/ins",
+		cfg:    cfg,
+	}, nil
+}
+
+func (n *nxapi) run(ctx context.Context, cmds ...string) ([]nxapiResponse, error) {
+	req := make([]nxapiRequest, len(cmds))
+	for i, cmd := range cmds {
+		req[i] = nxapiRequest{
+			JSONRPC: "2.0",
+			Method:  "cli",
+			Params:  nxapiParams{Cmd: cmd, Version: 1},
+			ID:      i + 1,
+		}
+	}
+
+	var raw json.RawMessage
+	if err := postJSON(ctx, n.client, n.cfg, n.url, "application/json-rpc", req, &raw); err != nil {
+		return nil, err
+	}
+
+	// Response to a single command is not wrapped in an array
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		raw = append(append([]byte("["), raw...), ']')
+	}
+
+	var resp []nxapiResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, err
+	}
+
+	for i, r := range resp {
+		if r.Error == nil {
+			continue
+		}
+
+		msg := r.Error.Message
+		if r.Error.Data != nil && r.Error.Data.Msg != "" {
+			msg = r.Error.Data.Msg
+		}
+
+		return nil, fmt.Errorf("%w: %q: %s", ErrCommandFailed, cmds[i], msg)
+	}
+
+	return resp, nil
+}
+
+func (n *nxapi) AccessVLAN(ctx context.Context, port string) (int, error) {
+	resp, err := n.run(ctx, fmt.Sprintf("show interface %s switchport", port))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(resp) != 1 || resp[0].Result == nil {
+		return 0, fmt.Errorf("%w: no switchport information for %s", ErrCommandFailed, port)
+	}
+
+	var body struct {
+		Table struct {
+			Row json.RawMessage `json:"ROW_interface"`
+		} `json:"TABLE_interface"`
+	}
+
+	if err := json.Unmarshal(resp[0].Result.Body, &body); err != nil {
+		return 0, err
+	}
+
+	// Single row is not wrapped in an array
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(body.Table.Row, &rows); err != nil {
+		var row map[string]interface{}
+		if err := json.Unmarshal(body.Table.Row, &row); err != nil {
+			return 0, err
+		}
+
+		rows = append(rows, row)
+	}
+
+	for _, row := range rows {
+		if vlan, ok := intValue(row["access_vlan"]); ok {
+			return vlan, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: no access VLAN for %s", ErrCommandFailed, port)
+}
+
+func (n *nxapi) SetAccessVLAN(ctx context.Context, port string, vlan int) error {
+	_, err := n.run(ctx,
+		"configure terminal",
+		fmt.Sprintf("interface %s", port),
+		"switchport",
+		"switchport mode access",
+		fmt.Sprintf("switchport access vlan %d", vlan),
+	)
+
+	return err
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package switchport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// Service exposes switch port configuration as activities, so the Region
+// can set the access VLAN of a machine before netbooting it.
+type Service struct {
+	backends map[string]BackendFactory
+}
+
+// ServiceOption allows to set additional Service options
+type ServiceOption func(*Service)
+
+// NewService returns an instance of Service with NX-API, eAPI and NETCONF
+// backends.
+func NewService(options ...ServiceOption) *Service {
+	s := &Service{
+		backends: map[string]BackendFactory{
+			DriverNXAPI:   newNXAPI,
+			DriverEAPI:    newEAPI,
+			DriverNETCONF: newNETCONF,
+		},
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithBackend allows to add a backend or replace a built-in one
+func WithBackend(driver string, factory BackendFactory) ServiceOption {
+	return func(s *Service) {
+		s.backends[driver] = factory
+	}
+}
+
+func (s *Service) backend(cfg SwitchConfig) (Backend, error) {
+	factory, ok := s.backends[cfg.Driver]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, cfg.Driver)
+	}
+
+	return factory(cfg)
+}
+
+// SetSwitchPortVLANParam is the activity parameter for set-switch-port-vlan
+type SetSwitchPortVLANParam struct {
+	Switch SwitchConfig `json:"switch"`
+	Port   string       `json:"port"`
+	VLAN   int          `json:"vlan"`
+}
+
+// SetSwitchPortVLANResult is the result of set-switch-port-vlan
+type SetSwitchPortVLANResult struct {
+	// PreviousVLAN allows the workflow to restore the port afterwards
+	PreviousVLAN int  `json:"previous_vlan"`
+	Changed      bool `json:"changed"`
+}
+
+// GetSwitchPortVLANParam is the activity parameter for get-switch-port-vlan
+type GetSwitchPortVLANParam struct {
+	Switch SwitchConfig `json:"switch"`
+	Port   string       `json:"port"`
+}
+
+// GetSwitchPortVLANResult is the result of get-switch-port-vlan
+type GetSwitchPortVLANResult struct {
+	VLAN int `json:"vlan"`
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"set-switch-port-vlan": s.setSwitchPortVLAN,
+		"get-switch-port-vlan": s.getSwitchPortVLAN,
+	}
+}
+
+// nonRetryable wraps errors that won't go away by retrying
+func nonRetryable(err error) error {
+	if errors.Is(err, ErrUnknownDriver) || errors.Is(err, ErrInvalidPort) ||
+		errors.Is(err, ErrCommandFailed) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return err
+}
+
+func (s *Service) setSwitchPortVLAN(ctx context.Context,
+	param SetSwitchPortVLANParam) (*SetSwitchPortVLANResult, error) {
+	if err := validatePort(param.Port, param.VLAN); err != nil {
+		return nil, nonRetryable(err)
+	}
+
+	b, err := s.backend(param.Switch)
+	if err != nil {
+		return nil, nonRetryable(err)
+	}
+
+	previous, err := b.AccessVLAN(ctx, param.Port)
+	if err != nil {
+		return nil, nonRetryable(err)
+	}
+
+	if previous == param.VLAN {
+		return &SetSwitchPortVLANResult{PreviousVLAN: previous}, nil
+	}
+
+	if err := b.SetAccessVLAN(ctx, param.Port, param.VLAN); err != nil {
+		return nil, nonRetryable(err)
+	}
+
+	return &SetSwitchPortVLANResult{PreviousVLAN: previous, Changed: true}, nil
+}
+
+func (s *Service) getSwitchPortVLAN(ctx context.Context,
+	param GetSwitchPortVLANParam) (*GetSwitchPortVLANResult, error) {
+	if !portRe.MatchString(param.Port) {
+		return nil, nonRetryable(fmt.Errorf("%w: port %q", ErrInvalidPort, param.Port))
+	}
+
+	b, err := s.backend(param.Switch)
+	if err != nil {
+		return nil, nonRetryable(err)
+	}
+
+	vlan, err := b.AccessVLAN(ctx, param.Port)
+	if err != nil {
+		return nil, nonRetryable(err)
+	}
+
+	return &GetSwitchPortVLANResult{VLAN: vlan}, nil
+}
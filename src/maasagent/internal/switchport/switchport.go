@@ -0,0 +1,154 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package switchport configures ports of top-of-rack switches, so that
+// deployment workflows can put the port of a machine into the right access
+// VLAN before it netboots. Switches are managed by pluggable backends.
+package switchport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported backends
+const (
+	DriverNXAPI   = "nxapi"
+	DriverEAPI    = "eapi"
+	DriverNETCONF = "netconf"
+)
+
+const requestTimeout = 30 * time.Second
+
+var (
+	// ErrUnknownDriver is returned when there is no backend for the driver
+	ErrUnknownDriver = errors.New("unknown switch driver")
+	// ErrInvalidPort is returned when port name or VLAN is not valid
+	ErrInvalidPort = errors.New("invalid switch port")
+	// ErrCommandFailed is returned when the switch rejected a command
+	ErrCommandFailed = errors.New("switch command failed")
+)
+
+// portRe matches interface names, so they are safe to use in CLI commands
+var portRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9/.:-]*$`)
+
+// SwitchConfig describes how to reach management API of a switch
+type SwitchConfig struct {
+	Driver   string `json:"driver"`
+	Address  string `json:"address"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Insecure skips verification of the switch TLS certificate
+	Insecure bool `json:"insecure"`
+}
+
+// Backend configures ports of a switch
+type Backend interface {
+	// AccessVLAN returns the access VLAN of the port
+	AccessVLAN(ctx context.Context, port string) (int, error)
+	// SetAccessVLAN puts the port into access mode in the given VLAN
+	SetAccessVLAN(ctx context.Context, port string, vlan int) error
+}
+
+// BackendFactory returns Backend for the switch
+type BackendFactory func(cfg SwitchConfig) (Backend, error)
+
+func validatePort(port string, vlan int) error {
+	if !portRe.MatchString(port) {
+		return fmt.Errorf("%w: port %q", ErrInvalidPort, port)
+	}
+
+	if vlan < 1 || vlan > 4094 {
+		return fmt.Errorf("%w: VLAN %d", ErrInvalidPort, vlan)
+	}
+
+	return nil
+}
+
+func httpClient(cfg SwitchConfig) *http.Client {
+	return &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				// Switches often use self-signed certificates
+				//nolint:gosec // explicitly requested by the operator
+				InsecureSkipVerify: cfg.Insecure,
+			},
+		},
+	}
+}
+
+// baseURL returns URL of the switch management API
+func baseURL(address string) string {
+	if strings.Contains(address, "://") {
+		return strings.TrimSuffix(address, "/")
+	}
+
+	return "https://" + address
+}
+
+// postJSON posts JSON-RPC request authenticated with basic auth and decodes
+// the response into resp
+func postJSON(ctx context.Context, client *http.Client, cfg SwitchConfig,
+	url, contentType string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Content-Type", contentType)
+	r.SetBasicAuth(cfg.Username, cfg.Password)
+
+	res, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// intValue converts VLAN ID reported as a number or a string
+func intValue(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case float64:
+		return int(v), true
+	case string:
+		i, err := strconv.Atoi(v)
+		return i, err == nil
+	}
+
+	return 0, false
+}
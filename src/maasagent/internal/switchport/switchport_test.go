@@ -0,0 +1,287 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package switchport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePort(t *testing.T) {
+	testcases := map[string]struct {
+		port string
+		vlan int
+		err  error
+	}{
+		"nexus port":        {port: "Ethernet1/1", vlan: 100},
+		"breakout port":     {port: "Ethernet1/1/2", vlan: 4094},
+		"subinterface":      {port: "Ethernet1/1.10", vlan: 1},
+		"command injection": {port: "Ethernet1/1\nshutdown", vlan: 100, err: ErrInvalidPort},
+		"xml injection":     {port: "<x/>", vlan: 100, err: ErrInvalidPort},
+		"empty port":        {port: "", vlan: 100, err: ErrInvalidPort},
+		"vlan zero":         {port: "Ethernet1", vlan: 0, err: ErrInvalidPort},
+		"vlan too big":      {port: "Ethernet1", vlan: 4095, err: ErrInvalidPort},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, validatePort(tc.port, tc.vlan), tc.err)
+		})
+	}
+}
+
+// fakeSwitch records commands and replies with the given function
+type fakeSwitch struct {
+	reply    func(cmds []string) interface{}
+	commands []string
+}
+
+func (f *fakeSwitch) server(t *testing.T, path string,
+	parse func(body []byte) []string) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != path {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var body json.RawMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+
+		cmds := parse(body)
+		f.commands = append(f.commands, cmds...)
+
+		require.NoError(t, json.NewEncoder(w).Encode(f.reply(cmds)))
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func switchConfig(driver, url string) SwitchConfig {
+	return SwitchConfig{
+		Driver:   driver,
+		Address:  url,
+		Username: "admin",
+		Password: "secret",
+		Insecure: true,
+	}
+}
+
+func parseNXAPI(body []byte) []string {
+	var req []nxapiRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+
+	cmds := make([]string, len(req))
+	for i, r := range req {
+		cmds[i] = r.Params.Cmd
+	}
+
+	return cmds
+}
+
+func TestNXAPIAccessVLAN(t *testing.T) {
+	testcases := map[string]struct {
+		body interface{}
+		vlan int
+		err  error
+	}{
+		"single row": {
+			body: map[string]interface{}{
+				"TABLE_interface": map[string]interface{}{
+					"ROW_interface": map[string]interface{}{"interface": "Ethernet1/1", "access_vlan": 10},
+				},
+			},
+			vlan: 10,
+		},
+		"multiple rows": {
+			body: map[string]interface{}{
+				"TABLE_interface": map[string]interface{}{
+					"ROW_interface": []interface{}{map[string]interface{}{"access_vlan": "20"}},
+				},
+			},
+			vlan: 20,
+		},
+		"no access vlan": {
+			body: map[string]interface{}{
+				"TABLE_interface": map[string]interface{}{
+					"ROW_interface": map[string]interface{}{"interface": "Ethernet1/1"},
+				},
+			},
+			err: ErrCommandFailed,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fake := &fakeSwitch{reply: func(cmds []string) interface{} {
+				// Response to a single command is not wrapped in an array
+				return map[string]interface{}{
+					"jsonrpc": "2.0",
+					"result":  map[string]interface{}{"body": tc.body},
+					"id":      1,
+				}
+			}}
+			server := fake.server(t, "/ins", parseNXAPI)
+
+			backend, err := newNXAPI(switchConfig(DriverNXAPI, server.URL))
+			require.NoError(t, err)
+
+			vlan, err := backend.AccessVLAN(context.Background(), "Ethernet1/1")
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.vlan, vlan)
+			assert.Equal(t, []string{"show interface Ethernet1/1 switchport"}, fake.commands)
+		})
+	}
+}
+
+func TestNXAPISetAccessVLAN(t *testing.T) {
+	fake := &fakeSwitch{reply: func(cmds []string) interface{} {
+		resp := make([]interface{}, len(cmds))
+		for i := range cmds {
+			resp[i] = map[string]interface{}{"jsonrpc": "2.0", "result": nil, "id": i + 1}
+		}
+
+		if strings.Contains(cmds[len(cmds)-1], "4000") {
+			resp[len(cmds)-1] = map[string]interface{}{
+				"jsonrpc": "2.0",
+				"error": map[string]interface{}{
+					"message": "Input CLI command error",
+					"data":    map[string]interface{}{"msg": "VLAN 4000 is reserved"},
+				},
+				"id": len(cmds),
+			}
+		}
+
+		return resp
+	}}
+	server := fake.server(t, "/ins", parseNXAPI)
+
+	backend, err := newNXAPI(switchConfig(DriverNXAPI, server.URL))
+	require.NoError(t, err)
+
+	require.NoError(t, backend.SetAccessVLAN(context.Background(), "Ethernet1/1", 100))
+	assert.Equal(t, []string{
+		"configure terminal",
+		"interface Ethernet1/1",
+		"switchport",
+		"switchport mode access",
+		"switchport access vlan 100",
+	}, fake.commands)
+
+	err = backend.SetAccessVLAN(context.Background(), "Ethernet1/1", 4000)
+	assert.ErrorIs(t, err, ErrCommandFailed)
+	assert.ErrorContains(t, err, "VLAN 4000 is reserved")
+}
+
+func TestNXAPIUnauthorized(t *testing.T) {
+	fake := &fakeSwitch{}
+	server := fake.server(t, "/ins", parseNXAPI)
+
+	cfg := switchConfig(DriverNXAPI, server.URL)
+	cfg.Password = "wrong"
+
+	backend, err := newNXAPI(cfg)
+	require.NoError(t, err)
+
+	_, err = backend.AccessVLAN(context.Background(), "Ethernet1/1")
+	assert.ErrorContains(t, err, "401")
+}
+
+func parseEAPI(body []byte) []string {
+	var req eapiRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+
+	return req.Params.Cmds
+}
+
+func TestEAPI(t *testing.T) {
+	fake := &fakeSwitch{reply: func(cmds []string) interface{} {
+		if strings.Contains(cmds[len(cmds)-2], "4000") {
+			return map[string]interface{}{
+				"jsonrpc": "2.0",
+				"error":   map[string]interface{}{"code": 1002, "message": "CLI command 6 failed"},
+				"id":      "maas",
+			}
+		}
+
+		result := make([]interface{}, len(cmds))
+		for i := range cmds {
+			result[i] = map[string]interface{}{}
+		}
+
+		if strings.HasPrefix(cmds[len(cmds)-1], "show") {
+			result[len(cmds)-1] = map[string]interface{}{
+				"switchports": map[string]interface{}{
+					"Ethernet1": map[string]interface{}{
+						"switchportInfo": map[string]interface{}{"accessVlanId": 30},
+					},
+				},
+			}
+		}
+
+		return map[string]interface{}{"jsonrpc": "2.0", "result": result, "id": "maas"}
+	}}
+	server := fake.server(t, "/command-api", parseEAPI)
+
+	backend, err := newEAPI(switchConfig(DriverEAPI, server.URL))
+	require.NoError(t, err)
+
+	vlan, err := backend.AccessVLAN(context.Background(), "Et1")
+	require.NoError(t, err)
+	assert.Equal(t, 30, vlan)
+
+	require.NoError(t, backend.SetAccessVLAN(context.Background(), "Et1", 100))
+	assert.Equal(t, []string{
+		"enable",
+		"show interfaces Et1 switchport",
+		"enable",
+		"configure",
+		"interface Et1",
+		"switchport",
+		"switchport mode access",
+		"switchport access vlan 100",
+		"end",
+	}, fake.commands)
+
+	err = backend.SetAccessVLAN(context.Background(), "Et1", 4000)
+	assert.ErrorIs(t, err, ErrCommandFailed)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package tagging computes tags of machines from the hardware inventory
+// collected during commissioning, using rules evaluated by the Agent, so
+// tags like "gpu" or "nvme-2" don't have to be maintained by hand.
+package tagging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Inventory is the hardware inventory of a machine relevant for tagging
+type Inventory struct {
+	System System   `json:"system"`
+	CPUs   []Device `json:"cpus"`
+	GPUs   []Device `json:"gpus"`
+	NICs   []Device `json:"nics"`
+	Disks  []Disk   `json:"disks"`
+	Cores  int      `json:"cores"`
+	Memory uint64   `json:"memory"`
+}
+
+// System identifies the machine
+type System struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Family  string `json:"family"`
+}
+
+// Device is a CPU socket, GPU or network card
+type Device struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Driver  string `json:"driver"`
+}
+
+// Disk is a block device
+type Disk struct {
+	// Type is the bus, e.g. nvme, scsi or virtio
+	Type  string `json:"type"`
+	Model string `json:"model"`
+	Size  uint64 `json:"size"`
+	// RPM is 0 for solid state drives
+	RPM       uint64 `json:"rpm"`
+	Removable bool   `json:"removable"`
+}
+
+// resources is the subset of LXD resources API, as collected by
+// machine-resources during commissioning
+type resources struct {
+	System struct {
+		Vendor  string `json:"vendor"`
+		Product string `json:"product"`
+		Family  string `json:"family"`
+	} `json:"system"`
+	CPU struct {
+		Sockets []struct {
+			Name   string `json:"name"`
+			Vendor string `json:"vendor"`
+		} `json:"sockets"`
+		Total int `json:"total"`
+	} `json:"cpu"`
+	Memory struct {
+		Total uint64 `json:"total"`
+	} `json:"memory"`
+	GPU struct {
+		Cards []resourcesCard `json:"cards"`
+	} `json:"gpu"`
+	Network struct {
+		Cards []resourcesCard `json:"cards"`
+	} `json:"network"`
+	Storage struct {
+		Disks []struct {
+			Type      string `json:"type"`
+			Model     string `json:"model"`
+			Size      uint64 `json:"size"`
+			RPM       uint64 `json:"rpm"`
+			Removable bool   `json:"removable"`
+		} `json:"disks"`
+	} `json:"storage"`
+}
+
+type resourcesCard struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Driver  string `json:"driver"`
+}
+
+// ParseResources returns Inventory from the output of machine-resources
+// (LXD resources API format).
+func ParseResources(r io.Reader) (*Inventory, error) {
+	var res resources
+
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to parse machine resources: %w", err)
+	}
+
+	inv := &Inventory{
+		System: System{
+			Vendor:  res.System.Vendor,
+			Product: res.System.Product,
+			Family:  res.System.Family,
+		},
+		Cores:  res.CPU.Total,
+		Memory: res.Memory.Total,
+	}
+
+	for _, s := range res.CPU.Sockets {
+		inv.CPUs = append(inv.CPUs, Device{Vendor: s.Vendor, Product: s.Name})
+	}
+
+	for _, c := range res.GPU.Cards {
+		inv.GPUs = append(inv.GPUs, Device(c))
+	}
+
+	for _, c := range res.Network.Cards {
+		inv.NICs = append(inv.NICs, Device(c))
+	}
+
+	for _, d := range res.Storage.Disks {
+		inv.Disks = append(inv.Disks, Disk(d))
+	}
+
+	return inv, nil
+}
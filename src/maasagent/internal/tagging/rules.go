@@ -0,0 +1,347 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tagging
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operators of conditions. Comparison of strings is case-insensitive,
+// while ordering operators require numeric values.
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpGt       = "gt"
+	OpGe       = "ge"
+	OpLt       = "lt"
+	OpLe       = "le"
+	OpContains = "contains"
+	OpMatches  = "matches"
+)
+
+var (
+	// ErrInvalidRule is returned when a rule refers to unknown field or
+	// operator, or its value cannot be used with the operator
+	ErrInvalidRule = errors.New("invalid tag rule")
+)
+
+var (
+	placeholderRe = regexp.MustCompile(`\{([a-z0-9_.]+)\}`)
+	invalidTagRe  = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// Field returns values of an inventory attribute. Fields with many values
+// (e.g. vendors of all GPUs) satisfy a condition if any of the values does.
+type Field func(*Inventory) []string
+
+// Condition compares a field with a value
+type Condition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// Rule applies Tag when all conditions are satisfied. Tag can refer to
+// fields with {field} placeholders, e.g. "gpu-{gpu.vendor}", producing
+// a tag for every value of the field.
+type Rule struct {
+	Tag        string      `json:"tag"`
+	Conditions []Condition `json:"conditions"`
+}
+
+// DefaultRules tag machines with GPUs, NVMe drives and by vendor
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Tag:        "gpu",
+			Conditions: []Condition{{Field: "gpu.count", Op: OpGe, Value: "1"}},
+		},
+		{
+			Tag:        "gpu-{gpu.vendor}",
+			Conditions: []Condition{{Field: "gpu.count", Op: OpGe, Value: "1"}},
+		},
+		{
+			Tag:        "nvme-{disk.nvme.count}",
+			Conditions: []Condition{{Field: "disk.nvme.count", Op: OpGe, Value: "1"}},
+		},
+		{
+			Tag:        "vendor-{system.vendor}",
+			Conditions: []Condition{{Field: "system.vendor", Op: OpNe, Value: ""}},
+		},
+	}
+}
+
+func devices(get func(*Inventory) []Device, attr func(Device) string) Field {
+	return func(inv *Inventory) []string {
+		var values []string
+		for _, d := range get(inv) {
+			values = append(values, attr(d))
+		}
+
+		return values
+	}
+}
+
+func count(n func(*Inventory) int) Field {
+	return func(inv *Inventory) []string {
+		return []string{strconv.Itoa(n(inv))}
+	}
+}
+
+func countDisks(match func(Disk) bool) Field {
+	return count(func(inv *Inventory) int {
+		var n int
+
+		for _, d := range inv.Disks {
+			if !d.Removable && match(d) {
+				n++
+			}
+		}
+
+		return n
+	})
+}
+
+func one(get func(*Inventory) string) Field {
+	return func(inv *Inventory) []string {
+		return []string{get(inv)}
+	}
+}
+
+var (
+	cpus   = func(inv *Inventory) []Device { return inv.CPUs }
+	gpus   = func(inv *Inventory) []Device { return inv.GPUs }
+	nics   = func(inv *Inventory) []Device { return inv.NICs }
+	vendor = func(d Device) string { return d.Vendor }
+	driver = func(d Device) string { return d.Driver }
+)
+
+func defaultFields() map[string]Field {
+	return map[string]Field{
+		"system.vendor":  one(func(inv *Inventory) string { return inv.System.Vendor }),
+		"system.product": one(func(inv *Inventory) string { return inv.System.Product }),
+		"system.family":  one(func(inv *Inventory) string { return inv.System.Family }),
+		"cpu.vendor":     devices(cpus, vendor),
+		"cpu.cores":      count(func(inv *Inventory) int { return inv.Cores }),
+		"memory.total": one(func(inv *Inventory) string {
+			return strconv.FormatUint(inv.Memory, 10)
+		}),
+		"gpu.count":       count(func(inv *Inventory) int { return len(inv.GPUs) }),
+		"gpu.vendor":      devices(gpus, vendor),
+		"gpu.driver":      devices(gpus, driver),
+		"nic.vendor":      devices(nics, vendor),
+		"nic.driver":      devices(nics, driver),
+		"disk.count":      countDisks(func(Disk) bool { return true }),
+		"disk.nvme.count": countDisks(func(d Disk) bool { return d.Type == "nvme" }),
+		"disk.ssd.count":  countDisks(func(d Disk) bool { return d.RPM == 0 }),
+		"disk.hdd.count":  countDisks(func(d Disk) bool { return d.RPM > 0 }),
+	}
+}
+
+// Engine evaluates tag rules against inventories
+type Engine struct {
+	fields map[string]Field
+}
+
+// EngineOption allows to set additional Engine options
+type EngineOption func(*Engine)
+
+// NewEngine returns Engine with the built-in fields
+func NewEngine(options ...EngineOption) *Engine {
+	e := &Engine{fields: defaultFields()}
+
+	for _, opt := range options {
+		opt(e)
+	}
+
+	return e
+}
+
+// WithField makes field available to rules under name, replacing
+// the built-in field with the same name.
+func WithField(name string, field Field) EngineOption {
+	return func(e *Engine) {
+		e.fields[name] = field
+	}
+}
+
+// Validate checks that rules can be evaluated
+func (e *Engine) Validate(rules []Rule) error {
+	for _, r := range rules {
+		if r.Tag == "" {
+			return fmt.Errorf("%w: empty tag", ErrInvalidRule)
+		}
+
+		for _, m := range placeholderRe.FindAllStringSubmatch(r.Tag, -1) {
+			if _, ok := e.fields[m[1]]; !ok {
+				return fmt.Errorf("%w: %q: unknown field %q", ErrInvalidRule, r.Tag, m[1])
+			}
+		}
+
+		for _, c := range r.Conditions {
+			if err := e.validateCondition(c); err != nil {
+				return fmt.Errorf("%w: %q: %w", ErrInvalidRule, r.Tag, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) validateCondition(c Condition) error {
+	if _, ok := e.fields[c.Field]; !ok {
+		return fmt.Errorf("unknown field %q", c.Field)
+	}
+
+	switch c.Op {
+	case OpEq, OpNe, OpContains:
+	case OpGt, OpGe, OpLt, OpLe:
+		if _, err := strconv.ParseFloat(c.Value, 64); err != nil {
+			return fmt.Errorf("%s requires numeric value, got %q", c.Op, c.Value)
+		}
+	case OpMatches:
+		if _, err := regexp.Compile(c.Value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown operator %q", c.Op)
+	}
+
+	return nil
+}
+
+// Tags returns sorted tags of the inventory produced by rules
+func (e *Engine) Tags(inv *Inventory, rules []Rule) ([]string, error) {
+	if err := e.Validate(rules); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+
+	for _, r := range rules {
+		if !e.match(inv, r.Conditions) {
+			continue
+		}
+
+		for _, tag := range e.expand(inv, r.Tag) {
+			if tag = sanitizeTag(tag); tag != "" {
+				seen[tag] = struct{}{}
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(seen))
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+func (e *Engine) match(inv *Inventory, conditions []Condition) bool {
+	for _, c := range conditions {
+		values := e.fields[c.Field](inv)
+
+		var ok bool
+
+		if c.Op == OpNe {
+			ok = !anyValue(values, Condition{Field: c.Field, Op: OpEq, Value: c.Value})
+		} else {
+			ok = anyValue(values, c)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func anyValue(values []string, c Condition) bool {
+	for _, v := range values {
+		if compare(v, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func compare(v string, c Condition) bool {
+	switch c.Op {
+	case OpEq:
+		return strings.EqualFold(v, c.Value)
+	case OpContains:
+		return strings.Contains(strings.ToLower(v), strings.ToLower(c.Value))
+	case OpMatches:
+		// Validated before rules are evaluated
+		return regexp.MustCompile(c.Value).MatchString(v)
+	}
+
+	a, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false
+	}
+
+	//nolint:errcheck // validated before rules are evaluated
+	b, _ := strconv.ParseFloat(c.Value, 64)
+
+	switch c.Op {
+	case OpGt:
+		return a > b
+	case OpGe:
+		return a >= b
+	case OpLt:
+		return a < b
+	case OpLe:
+		return a <= b
+	}
+
+	return false
+}
+
+// expand replaces placeholders of tag with field values, producing a tag
+// for every combination of values.
+func (e *Engine) expand(inv *Inventory, tag string) []string {
+	m := placeholderRe.FindStringSubmatchIndex(tag)
+	if m == nil {
+		return []string{tag}
+	}
+
+	var tags []string
+
+	for _, v := range e.fields[tag[m[2]:m[3]]](inv) {
+		for _, rest := range e.expand(inv, tag[m[1]:]) {
+			tags = append(tags, tag[:m[0]]+v+rest)
+		}
+	}
+
+	return tags
+}
+
+// sanitizeTag makes tag a valid MAAS tag name
+func sanitizeTag(tag string) string {
+	tag = invalidTagRe.ReplaceAllString(strings.ToLower(tag), "-")
+	return strings.Trim(tag, "-")
+}
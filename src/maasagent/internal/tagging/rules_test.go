@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tagging
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testResources = `{
+  "system": {"vendor": "Dell Inc.", "product": "PowerEdge R750", "family": "PowerEdge"},
+  "cpu": {"sockets": [{"name": "Intel(R) Xeon(R) Gold 6338", "vendor": "GenuineIntel"}], "total": 64},
+  "memory": {"total": 274877906944},
+  "gpu": {"cards": [{"vendor": "NVIDIA Corporation", "product": "GA100 [A100 PCIe 40GB]", "driver": "nvidia"}]},
+  "network": {"cards": [{"vendor": "Mellanox Technologies", "product": "MT2892", "driver": "mlx5_core"}]},
+  "storage": {"disks": [
+    {"type": "nvme", "model": "SAMSUNG MZ7L3480", "size": 480103981056, "rpm": 0},
+    {"type": "nvme", "model": "SAMSUNG MZ7L3480", "size": 480103981056, "rpm": 0},
+    {"type": "scsi", "model": "ST4000NM", "size": 4000787030016, "rpm": 7200},
+    {"type": "usb", "model": "Cruzer", "size": 16008609792, "rpm": 0, "removable": true}
+  ]}
+}`
+
+func testInventory(t *testing.T) *Inventory {
+	t.Helper()
+
+	inv, err := ParseResources(strings.NewReader(testResources))
+	require.NoError(t, err)
+
+	return inv
+}
+
+func TestParseResources(t *testing.T) {
+	inv := testInventory(t)
+
+	assert.Equal(t, System{Vendor: "Dell Inc.", Product: "PowerEdge R750", Family: "PowerEdge"}, inv.System)
+	assert.Equal(t, 64, inv.Cores)
+	assert.Equal(t, []Device{{Vendor: "GenuineIntel", Product: "Intel(R) Xeon(R) Gold 6338"}}, inv.CPUs)
+	assert.Equal(t, []Device{{Vendor: "NVIDIA Corporation", Product: "GA100 [A100 PCIe 40GB]",
+		Driver: "nvidia"}}, inv.GPUs)
+	assert.Len(t, inv.Disks, 4)
+
+	_, err := ParseResources(strings.NewReader("{"))
+	assert.Error(t, err)
+}
+
+func TestTags(t *testing.T) {
+	testcases := map[string]struct {
+		rules []Rule
+		out   []string
+		err   error
+	}{
+		"default rules": {
+			rules: DefaultRules(),
+			out:   []string{"gpu", "gpu-nvidia-corporation", "nvme-2", "vendor-dell-inc"},
+		},
+		"all conditions": {
+			rules: []Rule{{Tag: "big-nvme", Conditions: []Condition{
+				{Field: "disk.nvme.count", Op: OpGe, Value: "2"},
+				{Field: "memory.total", Op: OpGt, Value: "137438953472"},
+			}}},
+			out: []string{"big-nvme"},
+		},
+		"condition not met": {
+			rules: []Rule{{Tag: "hdd-only", Conditions: []Condition{
+				{Field: "disk.ssd.count", Op: OpEq, Value: "0"},
+			}}},
+			out: []string{},
+		},
+		"any value": {
+			rules: []Rule{{Tag: "mellanox", Conditions: []Condition{
+				{Field: "nic.vendor", Op: OpContains, Value: "mellanox"},
+			}}},
+			out: []string{"mellanox"},
+		},
+		"not equal": {
+			rules: []Rule{{Tag: "no-amd-gpu", Conditions: []Condition{
+				{Field: "gpu.vendor", Op: OpNe, Value: "Advanced Micro Devices, Inc. [AMD/ATI]"},
+			}}},
+			out: []string{"no-amd-gpu"},
+		},
+		"matches": {
+			rules: []Rule{{Tag: "r7x0", Conditions: []Condition{
+				{Field: "system.product", Op: OpMatches, Value: `^PowerEdge R7\d0$`},
+			}}},
+			out: []string{"r7x0"},
+		},
+		"no conditions": {
+			rules: []Rule{{Tag: "{cpu.cores}_cores"}},
+			out:   []string{"64_cores"},
+		},
+		"unknown field": {
+			rules: []Rule{{Tag: "x", Conditions: []Condition{{Field: "foo", Op: OpEq}}}},
+			err:   ErrInvalidRule,
+		},
+		"unknown placeholder": {
+			rules: []Rule{{Tag: "x-{foo}"}},
+			err:   ErrInvalidRule,
+		},
+		"unknown operator": {
+			rules: []Rule{{Tag: "x", Conditions: []Condition{{Field: "gpu.count", Op: "in"}}}},
+			err:   ErrInvalidRule,
+		},
+		"non-numeric value": {
+			rules: []Rule{{Tag: "x", Conditions: []Condition{{Field: "gpu.count", Op: OpGe, Value: "one"}}}},
+			err:   ErrInvalidRule,
+		},
+		"invalid regexp": {
+			rules: []Rule{{Tag: "x", Conditions: []Condition{{Field: "gpu.vendor", Op: OpMatches, Value: "("}}}},
+			err:   ErrInvalidRule,
+		},
+	}
+
+	inv := testInventory(t)
+	engine := NewEngine()
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tags, err := engine.Tags(inv, tc.rules)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.out, tags)
+		})
+	}
+}
+
+func TestWithField(t *testing.T) {
+	engine := NewEngine(WithField("gpu.model", func(inv *Inventory) []string {
+		var models []string
+		for _, g := range inv.GPUs {
+			if strings.Contains(g.Product, "A100") {
+				models = append(models, "a100")
+			}
+		}
+
+		return models
+	}))
+
+	tags, err := engine.Tags(testInventory(t), []Rule{{Tag: "gpu-{gpu.model}"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gpu-a100"}, tags)
+}
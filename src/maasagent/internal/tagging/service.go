@@ -0,0 +1,126 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tagging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	tworkflow "go.temporal.io/sdk/workflow"
+	"maas.io/core/src/maasagent/internal/workflow/log/tag"
+)
+
+// ComputeMachineTagsParam is the activity parameter for compute-machine-tags
+type ComputeMachineTagsParam struct {
+	// Resources is the output of machine-resources
+	Resources json.RawMessage `json:"resources"`
+	SystemID  string          `json:"system_id"`
+	// Rules to evaluate, DefaultRules are used if empty
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// ComputeMachineTagsResult is the result of compute-machine-tags
+type ComputeMachineTagsResult struct {
+	Tags []string `json:"tags"`
+}
+
+// TagMachineParam is the parameter of tag-machine workflow
+type TagMachineParam = ComputeMachineTagsParam
+
+type setMachineTagsParam struct {
+	SystemID string   `json:"system_id"`
+	Tags     []string `json:"tags"`
+}
+
+// Service computes tags of commissioned machines and pushes them to
+// the Region
+type Service struct {
+	engine *Engine
+}
+
+// NewService returns an instance of Service evaluating rules with engine
+func NewService(engine *Engine) *Service {
+	return &Service{engine: engine}
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{"tag-machine": s.tagMachine}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{"compute-machine-tags": s.computeMachineTags}
+}
+
+func (s *Service) computeMachineTags(_ context.Context,
+	param ComputeMachineTagsParam) (*ComputeMachineTagsResult, error) {
+	inv, err := ParseResources(bytes.NewReader(param.Resources))
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	rules := param.Rules
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+
+	tags, err := s.engine.Tags(inv, rules)
+	if errors.Is(err, ErrInvalidRule) {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ComputeMachineTagsResult{Tags: tags}, nil
+}
+
+// tagMachine is started by the Region once resources of a commissioned
+// machine are processed. Tags are computed by the Agent and the Region
+// replaces the tags it previously set on the machine with them.
+func (s *Service) tagMachine(ctx tworkflow.Context, param TagMachineParam) (*ComputeMachineTagsResult, error) {
+	log := tworkflow.GetLogger(ctx)
+
+	var result ComputeMachineTagsResult
+
+	if err := tworkflow.ExecuteActivity(
+		tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+			StartToCloseTimeout: 60 * time.Second,
+		}), "compute-machine-tags", param).Get(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	if err := tworkflow.ExecuteActivity(
+		tworkflow.WithActivityOptions(ctx, tworkflow.ActivityOptions{
+			TaskQueue:              "region",
+			ScheduleToCloseTimeout: 60 * time.Second,
+		}), "set-machine-tags", setMachineTagsParam{
+			SystemID: param.SystemID,
+			Tags:     result.Tags,
+		}).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	log.Info("Machine tagged", tag.Builder().
+		KV("system_id", param.SystemID).
+		KV("tags", result.Tags).KeyVals...)
+
+	return &result, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tagging
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+func setMachineTagsActivity(_ context.Context, _ setMachineTagsParam) error {
+	return nil
+}
+
+func TestTagMachine(t *testing.T) {
+	suite := testsuite.WorkflowTestSuite{}
+	suite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	s := NewService(NewEngine())
+
+	for name, fn := range s.ConfigurationActivities() {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+
+	env.RegisterActivityWithOptions(setMachineTagsActivity,
+		activity.RegisterOptions{Name: "set-machine-tags"})
+
+	env.OnActivity("set-machine-tags", mock.Anything, setMachineTagsParam{
+		SystemID: "abc123",
+		Tags:     []string{"gpu", "gpu-nvidia-corporation", "nvme-2", "vendor-dell-inc"},
+	}).Return(nil).Once()
+
+	env.ExecuteWorkflow(s.tagMachine, TagMachineParam{
+		SystemID:  "abc123",
+		Resources: json.RawMessage(testResources),
+	})
+
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	env.AssertExpectations(t)
+}
+
+func TestTagMachineInvalidRule(t *testing.T) {
+	suite := testsuite.WorkflowTestSuite{}
+	suite.SetLogger(log.NewZerologAdapter(zerolog.Nop()))
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	s := NewService(NewEngine())
+
+	for name, fn := range s.ConfigurationActivities() {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+
+	env.RegisterActivityWithOptions(setMachineTagsActivity,
+		activity.RegisterOptions{Name: "set-machine-tags"})
+
+	env.ExecuteWorkflow(s.tagMachine, TagMachineParam{
+		SystemID:  "abc123",
+		Resources: json.RawMessage(testResources),
+		Rules:     []Rule{{Tag: "x-{foo}"}},
+	})
+
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), ErrInvalidRule.Error())
+}
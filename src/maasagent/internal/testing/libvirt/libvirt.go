@@ -0,0 +1,332 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package testing
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Domain states of libvirt used by tests
+const (
+	StateRunning = 1
+	StatePaused  = 3
+	StateShutoff = 5
+)
+
+const (
+	program             = 0x20008086
+	errNoDomain         = 42
+	errAuthFailed       = 45
+	errOperationInvalid = 55
+)
+
+var domainName = regexp.MustCompile(`<name>([^<]+)</name>`)
+
+// Domain is a domain of the fake libvirtd
+type Domain struct {
+	State int32
+	XML   string
+}
+
+// Daemon is a fake libvirtd speaking the remote protocol over a UNIX
+// socket. It supports procedures used by the Agent to control domains.
+type Daemon struct {
+	// URI connects to the daemon
+	URI string
+	// AuthTypes are reported by the daemon, no authentication by default
+	AuthTypes []int32
+	domains   map[string]*Domain
+	// Procedures called, in order
+	procs []uint32
+	mutex sync.Mutex
+}
+
+// NewLibvirtDaemon is a test helper starting a fake libvirtd with domains,
+// which is stopped automatically using t.Cleanup()
+func NewLibvirtDaemon(t testing.TB, domains map[string]*Domain) *Daemon {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "libvirt-sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	d := &Daemon{URI: "qemu:///system?socket=" + socket, domains: domains}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go d.serve(conn)
+		}
+	}()
+
+	return d
+}
+
+// Domain returns a copy of the domain
+func (d *Daemon) Domain(name string) Domain {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return *d.domains[name]
+}
+
+// Procs returns procedures called so far
+func (d *Daemon) Procs() []uint32 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return append([]uint32(nil), d.procs...)
+}
+
+func (d *Daemon) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+
+		size := binary.BigEndian.Uint32(b)
+		if size < 28 {
+			return
+		}
+
+		msg := make([]byte, size-4)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+
+		proc, serial := binary.BigEndian.Uint32(msg[8:]), binary.BigEndian.Uint32(msg[16:])
+
+		body, code := d.call(proc, &reader{buf: msg[24:]})
+
+		w := &writer{}
+		w.uint32(0)
+		w.uint32(program)
+		w.uint32(1)
+		w.uint32(proc)
+		w.uint32(1)
+		w.uint32(serial)
+
+		if code != 0 {
+			w.uint32(1)
+			w.error(code)
+		} else {
+			w.uint32(0)
+			w.buf = append(w.buf, body...)
+		}
+
+		binary.BigEndian.PutUint32(w.buf, uint32(len(w.buf)))
+
+		if _, err := conn.Write(w.buf); err != nil {
+			return
+		}
+	}
+}
+
+// call performs procedure proc and returns its reply, or libvirt error code
+func (d *Daemon) call(proc uint32, args *reader) ([]byte, int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.procs = append(d.procs, proc)
+
+	w := &writer{}
+
+	switch proc {
+	case 1: // CONNECT_OPEN
+		// libvirtd refuses clients which skipped authentication
+		if d.AuthTypes != nil && !slices.Contains(d.AuthTypes, 0) {
+			return nil, errAuthFailed
+		}
+	case 2: // CONNECT_CLOSE
+	case 4: // CONNECT_GET_VERSION
+		w.uint32(0)
+		w.uint32(8002000)
+	case 66: // AUTH_LIST
+		types := d.AuthTypes
+		if types == nil {
+			types = []int32{0}
+		}
+
+		w.uint32(uint32(len(types)))
+
+		for _, t := range types {
+			w.uint32(uint32(t))
+		}
+	case 23: // DOMAIN_LOOKUP_BY_NAME
+		name := args.string()
+		if _, ok := d.domains[name]; !ok {
+			return nil, errNoDomain
+		}
+
+		d.domain(w, name)
+	case 212: // DOMAIN_GET_STATE
+		dom, ok := d.lookup(args)
+		if !ok {
+			return nil, errNoDomain
+		}
+
+		w.uint32(uint32(dom.State))
+		w.uint32(0)
+	case 9, 12, 28, 33: // DOMAIN_CREATE, DESTROY, RESUME, SHUTDOWN
+		dom, ok := d.lookup(args)
+		if !ok {
+			return nil, errNoDomain
+		}
+
+		if code := transition(proc, dom); code != 0 {
+			return nil, code
+		}
+	case 14: // DOMAIN_GET_XML_DESC
+		dom, ok := d.lookup(args)
+		if !ok {
+			return nil, errNoDomain
+		}
+
+		w.string(dom.XML)
+	case 11: // DOMAIN_DEFINE_XML
+		xml := args.string()
+
+		m := domainName.FindStringSubmatch(xml)
+		if m == nil {
+			return nil, 1
+		}
+
+		dom, ok := d.domains[m[1]]
+		if !ok {
+			dom = &Domain{State: StateShutoff}
+			d.domains[m[1]] = dom
+		}
+
+		dom.XML = xml
+		d.domain(w, m[1])
+	default:
+		return nil, 1
+	}
+
+	return w.buf, 0
+}
+
+func transition(proc uint32, dom *Domain) int {
+	active := dom.State != StateShutoff
+
+	switch {
+	case proc == 9 && !active:
+		dom.State = StateRunning
+	case proc == 12 && active, proc == 33 && active:
+		dom.State = StateShutoff
+	case proc == 28 && dom.State == StatePaused:
+		dom.State = StateRunning
+	default:
+		return errOperationInvalid
+	}
+
+	return 0
+}
+
+// lookup decodes remote_nonnull_domain of args
+func (d *Daemon) lookup(args *reader) (*Domain, bool) {
+	name := args.string()
+	args.next(16 + 4)
+
+	dom, ok := d.domains[name]
+
+	return dom, ok
+}
+
+// domain encodes remote_nonnull_domain
+func (d *Daemon) domain(w *writer, name string) {
+	w.string(name)
+	w.buf = append(w.buf, make([]byte, 16)...)
+
+	if d.domains[name].State != StateShutoff {
+		w.uint32(1)
+	} else {
+		w.uint32(0xffffffff)
+	}
+}
+
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) uint32(v uint32) {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+// error encodes remote_error with code
+func (w *writer) error(code int) {
+	w.uint32(uint32(code))
+	// domain
+	w.uint32(0)
+	w.uint32(1)
+	w.string("fake libvirt error")
+	// level: VIR_ERR_ERROR
+	w.uint32(2)
+	// dom, str1, str2, str3, int1, int2, net
+	for _, v := range []uint32{0, 0, 0, 0, 0, 0, 0} {
+		w.uint32(v)
+	}
+}
+
+func (w *writer) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, make([]byte, (4-len(s)%4)%4)...)
+}
+
+type reader struct {
+	buf []byte
+}
+
+// next returns the next n bytes, or zeros if args are truncated
+func (r *reader) next(n int) []byte {
+	if len(r.buf) < n {
+		r.buf = nil
+		return make([]byte, n)
+	}
+
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+
+	return b
+}
+
+func (r *reader) string() string {
+	n := int(binary.BigEndian.Uint32(r.next(4)))
+	s := string(r.next(n))
+	r.next((4 - n%4) % 4)
+
+	return s
+}
@@ -0,0 +1,444 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package wake powers on machines parked off by power-saving policies of the
+// Region when something asks for them through the Agent (e.g. a job
+// scheduler needing more capacity), and reports once they are ready to
+// serve. Idle machines can then be powered off without making them
+// unavailable.
+package wake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.temporal.io/sdk/temporal"
+	"maas.io/core/src/maasagent/internal/power"
+	"maas.io/core/src/maasagent/internal/remediation"
+)
+
+// PathPrefix is where Service is expected to be served
+const PathPrefix = "/wake/"
+
+// SignalMachineWoken is the name of the signal sent to the workflow given
+// when the machine was parked, once it is ready or failed to wake up.
+// Signal carries Status.
+const SignalMachineWoken = "machine-woken"
+
+// States of parked machines
+const (
+	// StateParked machines are powered off and can be woken up
+	StateParked = "parked"
+	// StateWaking machines are being powered on, or are not ready yet
+	StateWaking = "waking"
+	// StateReady machines are powered on and ready to serve
+	StateReady = "ready"
+	// StateFailed machines failed to power on or to become ready, and
+	// can be woken up again
+	StateFailed = "failed"
+)
+
+const (
+	defaultReadyTimeout = 10 * time.Minute
+	defaultPollInterval = 5 * time.Second
+	// powerOnTimeout limits powering on, which is retried by the power
+	// service according to its retry policy
+	powerOnTimeout = 5 * time.Minute
+	// signalTimeout limits signalling of the workflow of the machine
+	signalTimeout = 30 * time.Second
+	// maxWait is the longest clients can wait for the machine in a single
+	// request
+	maxWait = 30 * time.Minute
+)
+
+var (
+	// ErrNotParked is returned when the machine was not parked by the Region
+	ErrNotParked = errors.New("machine is not parked")
+	// ErrInvalidSystemID is returned when system ID cannot be used in URL
+	ErrInvalidSystemID = errors.New("invalid system ID")
+	// ErrStillOff is returned when the machine is off after powering it on
+	ErrStillOff = errors.New("machine is still off after power on")
+)
+
+// Executor runs power actions. It is implemented by power.PowerService.
+type Executor interface {
+	Execute(ctx context.Context, action string, param power.PowerParam) (string, error)
+}
+
+// Signaler sends signals to workflows. It is implemented by Temporal client.
+type Signaler interface {
+	SignalWorkflow(ctx context.Context, workflowID, runID, signalName string, arg interface{}) error
+}
+
+// ParkMachineParam is the activity parameter for park-machine
+type ParkMachineParam struct {
+	SystemID   string                 `json:"system_id"`
+	DriverType string                 `json:"driver_type"`
+	DriverOpts map[string]interface{} `json:"driver_opts"`
+	// ReadyAddress is host:port of a service of the machine (e.g. SSH),
+	// which accepts connections once the machine is ready. Machines
+	// without it are ready once they are powered on.
+	ReadyAddress string `json:"ready_address,omitempty"`
+	// ReadyTimeout in seconds for the machine to accept connections
+	ReadyTimeout int `json:"ready_timeout,omitempty"`
+	// WorkflowID of the workflow to signal with SignalMachineWoken, if any
+	WorkflowID string `json:"workflow_id,omitempty"`
+}
+
+// UnparkMachineParam is the activity parameter for unpark-machine
+type UnparkMachineParam struct {
+	SystemID string `json:"system_id"`
+}
+
+// Status is the state of a parked machine, as served on PathPrefix
+type Status struct {
+	SystemID string `json:"system_id"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+	// WokenAt is when the machine was asked for
+	WokenAt *time.Time `json:"woken_at,omitempty"`
+	// ReadyAt is when the machine became ready
+	ReadyAt *time.Time `json:"ready_at,omitempty"`
+}
+
+type machine struct {
+	param  ParkMachineParam
+	status Status
+	// done is closed once waking up completes
+	done chan struct{}
+}
+
+// Service keeps machines parked by the Region and wakes them up on request.
+// Parked machines are kept in memory only, so the Region has to park them
+// again after the Agent restarts. Credentials of BMCs are never served.
+type Service struct {
+	executor Executor
+	signaler Signaler
+	engine   *remediation.Engine
+	machines map[string]*machine
+	dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	now      func() time.Time
+	poll     time.Duration
+	mutex    sync.Mutex
+}
+
+// ServiceOption allows to set additional options for the Service
+type ServiceOption func(*Service)
+
+// WithRemediation makes power actions of wake-ups subject to circuits of
+// the engine and reports their results as events.
+func WithRemediation(e *remediation.Engine) ServiceOption {
+	return func(s *Service) {
+		s.engine = e
+	}
+}
+
+// NewService returns an instance of Service powering machines on with
+// executor
+func NewService(executor Executor, signaler Signaler, options ...ServiceOption) *Service {
+	s := &Service{
+		executor: executor,
+		signaler: signaler,
+		machines: make(map[string]*machine),
+		dial:     (&net.Dialer{}).DialContext,
+		now:      time.Now,
+		poll:     defaultPollInterval,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *Service) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (s *Service) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"park-machine":   s.park,
+		"unpark-machine": s.unpark,
+	}
+}
+
+func (s *Service) park(_ context.Context, param ParkMachineParam) error {
+	if err := s.Park(param); err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return nil
+}
+
+// Park makes the machine available to be woken up, e.g. once it is powered
+// off by a power-saving policy. Parking the machine again replaces its
+// parameters and makes it parked, unless it is waking up.
+func (s *Service) Park(param ParkMachineParam) error {
+	if param.SystemID == "" || strings.ContainsAny(param.SystemID, "/\\?#") {
+		return fmt.Errorf("%w: %q", ErrInvalidSystemID, param.SystemID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if m, ok := s.machines[param.SystemID]; ok && m.status.State == StateWaking {
+		m.param = param
+		return nil
+	}
+
+	s.machines[param.SystemID] = &machine{
+		param:  param,
+		status: Status{SystemID: param.SystemID, State: StateParked},
+	}
+
+	return nil
+}
+
+// unpark makes the machine unavailable to be woken up, e.g. once it is
+// allocated or deleted
+func (s *Service) unpark(_ context.Context, param UnparkMachineParam) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.machines, param.SystemID)
+
+	return nil
+}
+
+// Wake starts waking up the parked machine, unless it is already waking up
+// or ready, and returns its status with a channel closed once waking up
+// completes.
+func (s *Service) Wake(systemID string) (Status, <-chan struct{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.machines[systemID]
+	if !ok {
+		return Status{}, nil, fmt.Errorf("%w: %s", ErrNotParked, systemID)
+	}
+
+	if m.status.State == StateParked || m.status.State == StateFailed {
+		now := s.now().UTC()
+
+		m.status = Status{SystemID: systemID, State: StateWaking, WokenAt: &now}
+		m.done = make(chan struct{})
+
+		//nolint:contextcheck // waking up outlives the request
+		go s.wake(m)
+	}
+
+	return m.status, m.done, nil
+}
+
+// Get returns status of the parked machine, with a channel closed once
+// waking up completes (nil if it is not waking up).
+func (s *Service) Get(systemID string) (Status, <-chan struct{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.machines[systemID]
+	if !ok {
+		return Status{}, nil, fmt.Errorf("%w: %s", ErrNotParked, systemID)
+	}
+
+	return m.status, m.done, nil
+}
+
+// wake powers the machine on and waits for it to be ready. Outcome is
+// recorded in its status and signalled to the workflow of the machine.
+func (s *Service) wake(m *machine) {
+	s.mutex.Lock()
+	param := m.param
+	s.mutex.Unlock()
+
+	logger := log.Info().Str("system_id", param.SystemID)
+
+	err := s.powerOn(param)
+	if err == nil && param.ReadyAddress != "" {
+		err = s.waitReady(param)
+	}
+
+	s.mutex.Lock()
+
+	status := m.status
+
+	if err != nil {
+		status.State, status.Error = StateFailed, err.Error()
+		logger = log.Warn().Str("system_id", param.SystemID).Err(err)
+	} else {
+		now := s.now().UTC()
+		status.State, status.ReadyAt = StateReady, &now
+	}
+
+	m.status = status
+	close(m.done)
+
+	s.mutex.Unlock()
+
+	logger.Str("state", status.State).Msg("Parked machine woken")
+
+	if param.WorkflowID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), signalTimeout)
+	defer cancel()
+
+	if err := s.signaler.SignalWorkflow(ctx, param.WorkflowID, "", SignalMachineWoken, status); err != nil {
+		log.Warn().Err(err).Str("system_id", param.SystemID).Msg("Failed to signal wake-up")
+	}
+}
+
+func (s *Service) powerOn(param ParkMachineParam) error {
+	attrs := map[string]string{
+		"system_id":   param.SystemID,
+		"driver_type": param.DriverType,
+	}
+
+	if err := s.engine.Allow("power-on", attrs); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), powerOnTimeout)
+	defer cancel()
+
+	state, err := s.executor.Execute(ctx, "on", power.PowerParam{
+		DriverType: param.DriverType,
+		DriverOpts: param.DriverOpts,
+		RequestMetadata: power.RequestMetadata{
+			Requester: "wake-on-api",
+			Reason:    "parked machine requested",
+		},
+	})
+	if err == nil && state == "off" {
+		err = ErrStillOff
+	}
+
+	ev := remediation.Event{Kind: remediation.EventActivitySucceeded, Source: "power-on", Attributes: attrs}
+
+	if err != nil {
+		ev.Kind = remediation.EventActivityFailed
+		attrs["error"] = err.Error()
+	}
+
+	s.engine.Handle(ev)
+
+	return err
+}
+
+// waitReady waits for the service of the machine to accept connections
+func (s *Service) waitReady(param ParkMachineParam) error {
+	timeout := defaultReadyTimeout
+	if param.ReadyTimeout > 0 {
+		timeout = time.Duration(param.ReadyTimeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(s.poll)
+	defer ticker.Stop()
+
+	for {
+		conn, err := s.dial(ctx, "tcp", param.ReadyAddress)
+		if err == nil {
+			//nolint:errcheck // should be safe to ignore an error from Close()
+			conn.Close()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s is not accepting connections after %s: %w", param.ReadyAddress, timeout, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeHTTP wakes up the machine on POST /wake/<system_id>, and returns its
+// status on GET. Both wait until the machine is ready (or failed) for up to
+// the duration in the wait query parameter (e.g. ?wait=5m). Waking machines
+// are reported with 202 Accepted.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	systemID := strings.TrimPrefix(r.URL.Path, PathPrefix)
+
+	var wait time.Duration
+
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxWait {
+			http.Error(w, fmt.Sprintf("invalid wait %q", v), http.StatusBadRequest)
+			return
+		}
+
+		wait = d
+	}
+
+	var (
+		status Status
+		done   <-chan struct{}
+		err    error
+	)
+
+	switch r.Method {
+	case http.MethodPost:
+		status, done, err = s.Wake(systemID)
+	case http.MethodGet:
+		status, done, err = s.Get(systemID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if wait > 0 && status.State == StateWaking {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+		case <-done:
+		}
+
+		if status, _, err = s.Get(systemID); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if status.State == StateWaking {
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	//nolint:errcheck // nothing to do if the client went away
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/power"
+)
+
+type fakeExecutor struct {
+	err error
+	// release blocks power actions until it is closed, if set
+	release chan struct{}
+	state   string
+	params  []power.PowerParam
+	mutex   sync.Mutex
+}
+
+func (f *fakeExecutor) Execute(_ context.Context, action string, param power.PowerParam) (string, error) {
+	if f.release != nil {
+		<-f.release
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if action != "on" {
+		return "", errors.New("unexpected action " + action)
+	}
+
+	f.params = append(f.params, param)
+
+	if f.state == "" {
+		return "on", f.err
+	}
+
+	return f.state, f.err
+}
+
+func (f *fakeExecutor) calls() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return len(f.params)
+}
+
+type fakeSignaler struct {
+	statuses []Status
+	mutex    sync.Mutex
+}
+
+func (f *fakeSignaler) SignalWorkflow(_ context.Context, workflowID, _, name string, arg interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if workflowID != "workflow-1" || name != SignalMachineWoken {
+		return errors.New("unexpected signal")
+	}
+
+	f.statuses = append(f.statuses, arg.(Status))
+
+	return nil
+}
+
+func request(s *Service, method, target string) (int, Status) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+
+	var status Status
+	//nolint:errcheck // status is empty for errors
+	json.NewDecoder(w.Body).Decode(&status)
+
+	return w.Code, status
+}
+
+func parkParam(systemID string) ParkMachineParam {
+	return ParkMachineParam{
+		SystemID:   systemID,
+		DriverType: "ipmi",
+		DriverOpts: map[string]interface{}{"power_address": "10.0.0.1"},
+		WorkflowID: "workflow-1",
+	}
+}
+
+func TestWake(t *testing.T) {
+	executor := &fakeExecutor{}
+	signaler := &fakeSignaler{}
+	s := NewService(executor, signaler)
+
+	require.NoError(t, s.park(context.Background(), parkParam("abc")))
+
+	code, status := request(s, http.MethodGet, "/wake/abc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateParked, status.State)
+
+	code, status = request(s, http.MethodPost, "/wake/abc?wait=10s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateReady, status.State)
+	assert.NotNil(t, status.WokenAt)
+	assert.NotNil(t, status.ReadyAt)
+
+	// Ready machines are not powered on again
+	code, status = request(s, http.MethodPost, "/wake/abc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateReady, status.State)
+	assert.Equal(t, 1, executor.calls())
+
+	assert.Equal(t, "ipmi", executor.params[0].DriverType)
+	assert.Equal(t, "wake-on-api", executor.params[0].Requester)
+
+	require.Eventually(t, func() bool {
+		signaler.mutex.Lock()
+		defer signaler.mutex.Unlock()
+
+		return len(signaler.statuses) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, StateReady, signaler.statuses[0].State)
+
+	// Machines parked again can be woken up again
+	require.NoError(t, s.park(context.Background(), parkParam("abc")))
+
+	code, _ = request(s, http.MethodPost, "/wake/abc?wait=10s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, executor.calls())
+
+	require.NoError(t, s.unpark(context.Background(), UnparkMachineParam{SystemID: "abc"}))
+
+	code, _ = request(s, http.MethodPost, "/wake/abc")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestWakeAccepted(t *testing.T) {
+	executor := &fakeExecutor{release: make(chan struct{})}
+	s := NewService(executor, &fakeSignaler{})
+
+	require.NoError(t, s.park(context.Background(), parkParam("abc")))
+
+	code, status := request(s, http.MethodPost, "/wake/abc")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, StateWaking, status.State)
+
+	// Requests while the machine is waking up don't power it on again
+	code, status = request(s, http.MethodPost, "/wake/abc?wait=10ms")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, StateWaking, status.State)
+
+	close(executor.release)
+
+	code, status = request(s, http.MethodGet, "/wake/abc?wait=10s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateReady, status.State)
+	assert.Equal(t, 1, executor.calls())
+}
+
+func TestWakeReadyAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { l.Close() })
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	s := NewService(&fakeExecutor{}, &fakeSignaler{})
+	s.poll = 10 * time.Millisecond
+
+	param := parkParam("abc")
+	param.ReadyAddress = l.Addr().String()
+	require.NoError(t, s.park(context.Background(), param))
+
+	param = parkParam("def")
+	param.ReadyAddress = closed.Addr().String()
+	param.ReadyTimeout = 1
+	require.NoError(t, s.park(context.Background(), param))
+
+	code, status := request(s, http.MethodPost, "/wake/abc?wait=10s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateReady, status.State)
+
+	code, status = request(s, http.MethodPost, "/wake/def?wait=10s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateFailed, status.State)
+	assert.Contains(t, status.Error, "not accepting connections")
+	assert.Nil(t, status.ReadyAt)
+}
+
+func TestWakeErrors(t *testing.T) {
+	testcases := map[string]struct {
+		executor *fakeExecutor
+		target   string
+		code     int
+		err      string
+	}{
+		"power on failed": {
+			executor: &fakeExecutor{err: power.ErrAuthFailed},
+			target:   "/wake/abc?wait=10s",
+			code:     http.StatusOK,
+			err:      power.ErrAuthFailed.Error(),
+		},
+		"still off": {
+			executor: &fakeExecutor{state: "off"},
+			target:   "/wake/abc?wait=10s",
+			code:     http.StatusOK,
+			err:      ErrStillOff.Error(),
+		},
+		"not parked": {
+			executor: &fakeExecutor{},
+			target:   "/wake/def",
+			code:     http.StatusNotFound,
+		},
+		"invalid wait": {
+			executor: &fakeExecutor{},
+			target:   "/wake/abc?wait=forever",
+			code:     http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewService(tc.executor, &fakeSignaler{})
+			require.NoError(t, s.park(context.Background(), parkParam("abc")))
+
+			code, status := request(s, http.MethodPost, tc.target)
+			assert.Equal(t, tc.code, code)
+
+			if tc.err != "" {
+				assert.Equal(t, StateFailed, status.State)
+				assert.Equal(t, tc.err, status.Error)
+			}
+		})
+	}
+}
+
+func TestParkInvalidSystemID(t *testing.T) {
+	s := NewService(&fakeExecutor{}, &fakeSignaler{})
+
+	err := s.park(context.Background(), parkParam("a/b"))
+	assert.ErrorIs(t, err, ErrInvalidSystemID)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package webhook
+
+import (
+	"context"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// NewInterceptor returns a worker interceptor that publishes lifecycle
+// events of workflows executed by the Agent and failures of activities.
+// Events are not published while workflow history is replayed.
+func NewInterceptor(d *Dispatcher) interceptor.WorkerInterceptor {
+	return &webhookInterceptor{dispatcher: d}
+}
+
+type webhookInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	dispatcher *Dispatcher
+}
+
+func (w *webhookInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityWebhooks{dispatcher: w.dispatcher}
+	i.Next = next
+
+	return i
+}
+
+func (w *webhookInterceptor) InterceptWorkflow(_ workflow.Context,
+	next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	i := &workflowWebhooks{dispatcher: w.dispatcher}
+	i.Next = next
+
+	return i
+}
+
+type activityWebhooks struct {
+	interceptor.ActivityInboundInterceptorBase
+	dispatcher *Dispatcher
+}
+
+// ExecuteActivity publishes every failed attempt, as the retry policy is
+// not known to the activity. Attempt allows receivers to filter them.
+func (a *activityWebhooks) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	res, err := a.Next.ExecuteActivity(ctx, in)
+	if err != nil {
+		info := activity.GetInfo(ctx)
+
+		a.dispatcher.Publish(Event{
+			Type:       EventActivityFailed,
+			Name:       info.ActivityType.Name,
+			WorkflowID: info.WorkflowExecution.ID,
+			RunID:      info.WorkflowExecution.RunID,
+			Attempt:    info.Attempt,
+			Error:      err.Error(),
+		})
+	}
+
+	return res, err
+}
+
+type workflowWebhooks struct {
+	interceptor.WorkflowInboundInterceptorBase
+	dispatcher *Dispatcher
+}
+
+func (w *workflowWebhooks) ExecuteWorkflow(ctx workflow.Context,
+	in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	info := workflow.GetInfo(ctx)
+	ev := Event{
+		Name:       info.WorkflowType.Name,
+		WorkflowID: info.WorkflowExecution.ID,
+		RunID:      info.WorkflowExecution.RunID,
+	}
+
+	if !workflow.IsReplaying(ctx) {
+		ev.Type = EventWorkflowStarted
+		w.dispatcher.Publish(ev)
+	}
+
+	res, err := w.Next.ExecuteWorkflow(ctx, in)
+
+	// Workflow continued as new is not finished yet
+	if workflow.IsReplaying(ctx) || workflow.IsContinueAsNewError(err) {
+		return res, err
+	}
+
+	ev.Type = EventWorkflowCompleted
+
+	if err != nil {
+		ev.Type = EventWorkflowFailed
+		ev.Error = err.Error()
+	}
+
+	w.dispatcher.Publish(ev)
+
+	return res, err
+}
+
+// PublishWebhookEventParam is the activity parameter for publish-webhook-event.
+// It allows the Region to publish events of workflows it executes itself,
+// e.g. deployments.
+type PublishWebhookEventParam struct {
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Type       string                 `json:"type"`
+	Name       string                 `json:"name"`
+	WorkflowID string                 `json:"workflow_id"`
+	SystemID   string                 `json:"system_id"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// SetWebhookEndpointsParam is the activity parameter for set-webhook-endpoints
+type SetWebhookEndpointsParam struct {
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+func (d *Dispatcher) ConfigurationWorkflows() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (d *Dispatcher) ConfigurationActivities() map[string]interface{} {
+	return map[string]interface{}{
+		"publish-webhook-event": d.publishEvent,
+		"set-webhook-endpoints": d.setEndpoints,
+	}
+}
+
+func (d *Dispatcher) publishEvent(_ context.Context, param PublishWebhookEventParam) error {
+	d.Publish(Event{
+		Type:       param.Type,
+		Name:       param.Name,
+		WorkflowID: param.WorkflowID,
+		SystemID:   param.SystemID,
+		Error:      param.Error,
+		Data:       param.Data,
+	})
+
+	return nil
+}
+
+func (d *Dispatcher) setEndpoints(_ context.Context, param SetWebhookEndpointsParam) error {
+	if err := d.SetEndpoints(param.Endpoints); err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package webhook delivers signed webhooks on workflow lifecycle events
+// (e.g. deployment finished, power cycle failed) to operator defined
+// endpoints, so external systems like CMDBs or chatops can react to them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"maas.io/core/src/maasagent/internal/redact"
+	"maas.io/core/src/maasagent/internal/ringbuf"
+)
+
+// Types of events
+const (
+	EventWorkflowStarted   = "workflow.started"
+	EventWorkflowCompleted = "workflow.completed"
+	EventWorkflowFailed    = "workflow.failed"
+	EventActivityFailed    = "activity.failed"
+)
+
+// Headers of webhook requests. Signature is "sha256=" followed by hex
+// encoded HMAC-SHA256 of timestamp, "." and the body, keyed with the
+// endpoint secret.
+const (
+	HeaderSignature = "X-MAAS-Signature"
+	HeaderTimestamp = "X-MAAS-Timestamp"
+	HeaderEvent     = "X-MAAS-Event"
+	HeaderDelivery  = "X-MAAS-Delivery"
+)
+
+const (
+	defaultBufferBytes = 8 * 1024 * 1024
+	workers            = 4
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	requestTimeout     = 10 * time.Second
+)
+
+var (
+	// ErrInvalidEndpoint is returned when endpoint URL or event pattern
+	// is not valid
+	ErrInvalidEndpoint = errors.New("invalid webhook endpoint")
+	// ErrDeliveryFailed is returned when endpoint didn't accept webhook
+	ErrDeliveryFailed = errors.New("webhook delivery failed")
+)
+
+// Endpoint receives webhooks of events matching any of Events patterns.
+// Patterns are matched against "<type>/<name>" with path.Match, e.g.
+// "workflow.completed/deploy*" or "activity.failed/power-*".
+type Endpoint struct {
+	URL    string   `yaml:"url" json:"url"`
+	Secret string   `yaml:"secret" json:"secret"`
+	Events []string `yaml:"events" json:"events"`
+}
+
+// Event is a workflow lifecycle event
+type Event struct {
+	Time time.Time `json:"time"`
+	// Data is additional data of events published by the Region
+	Data       map[string]interface{} `json:"data,omitempty"`
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Name       string                 `json:"name"`
+	WorkflowID string                 `json:"workflow_id,omitempty"`
+	RunID      string                 `json:"run_id,omitempty"`
+	SystemID   string                 `json:"system_id"`
+	Error      string                 `json:"error,omitempty"`
+	Attempt    int32                  `json:"attempt,omitempty"`
+}
+
+type delivery struct {
+	endpoint Endpoint
+	event    Event
+	body     []byte
+}
+
+func (dl delivery) size() int {
+	return len(dl.endpoint.URL) + len(dl.body)
+}
+
+// Dispatcher delivers events to matching endpoints in the background,
+// retrying failed deliveries with exponential backoff.
+type Dispatcher struct {
+	client      *http.Client
+	queue       *ringbuf.Buffer[delivery]
+	redaction   *redact.Filter
+	now         func() time.Time
+	systemID    string
+	endpoints   []Endpoint
+	maxAttempts int
+	backoff     time.Duration
+	mutex       sync.Mutex
+}
+
+// DispatcherOption allows to set additional Dispatcher options
+type DispatcherOption func(*Dispatcher)
+
+// NewDispatcher returns Dispatcher delivering events of the Agent with
+// systemID to endpoints.
+func NewDispatcher(systemID string, endpoints []Endpoint, options ...DispatcherOption) (*Dispatcher, error) {
+	d := &Dispatcher{
+		client:      &http.Client{Timeout: requestTimeout},
+		queue:       ringbuf.New("webhook", defaultBufferBytes, delivery.size),
+		now:         time.Now,
+		systemID:    systemID,
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	if err := d.SetEndpoints(endpoints); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// WithHTTPClient sets HTTP client used for deliveries
+func WithHTTPClient(c *http.Client) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.client = c
+	}
+}
+
+// WithRetry sets how many times delivery is attempted (default: 5) and
+// the delay before the first retry, which is doubled on every attempt
+// (default: 1s).
+func WithRetry(maxAttempts int, backoff time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+		d.backoff = backoff
+	}
+}
+
+// WithBufferBytes limits the size of webhooks waiting to be delivered
+// (default: 8 MiB). The oldest webhooks are dropped above it.
+func WithBufferBytes(n int64) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.queue = ringbuf.New("webhook", n, delivery.size)
+	}
+}
+
+// WithRedaction filters bodies of webhooks with f before they are queued,
+// so they are signed as they are delivered
+func WithRedaction(f *redact.Filter) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.redaction = f
+	}
+}
+
+// BufferStats returns usage and drop counters of webhooks waiting to be
+// delivered.
+func (d *Dispatcher) BufferStats() ringbuf.Stats {
+	return d.queue.Stats()
+}
+
+// SetEndpoints replaces endpoints. Events already queued are still
+// delivered to the previous endpoints.
+func (d *Dispatcher) SetEndpoints(endpoints []Endpoint) error {
+	for _, e := range endpoints {
+		u, err := url.Parse(e.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: URL %q", ErrInvalidEndpoint, e.URL)
+		}
+
+		for _, p := range e.Events {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("%w: pattern %q", ErrInvalidEndpoint, p)
+			}
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.endpoints = endpoints
+
+	return nil
+}
+
+func (e *Endpoint) matches(ev Event) bool {
+	for _, p := range e.Events {
+		//nolint:errcheck // patterns are validated by SetEndpoints
+		if ok, _ := path.Match(p, ev.Type+"/"+ev.Name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Publish queues delivery of the event to matching endpoints. ID, Time and
+// SystemID are set if empty. It is safe to call Publish on nil Dispatcher.
+func (d *Dispatcher) Publish(ev Event) {
+	if d == nil {
+		return
+	}
+
+	if ev.ID == "" {
+		b := make([]byte, 16)
+		//nolint:errcheck // crypto/rand never fails on supported platforms
+		rand.Read(b)
+		ev.ID = hex.EncodeToString(b)
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = d.now().UTC()
+	}
+
+	if ev.SystemID == "" {
+		ev.SystemID = d.systemID
+	}
+
+	d.mutex.Lock()
+	endpoints := d.endpoints
+	d.mutex.Unlock()
+
+	var body []byte
+
+	for _, e := range endpoints {
+		if !e.matches(ev) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+
+			body, err = json.Marshal(ev)
+			if err != nil {
+				log.Warn().Err(err).Str("event", ev.Type).Msg("Failed to encode webhook")
+				return
+			}
+
+			body = d.redaction.JSON(body)
+		}
+
+		d.queue.Push(delivery{endpoint: e, event: ev, body: body})
+	}
+}
+
+// Run delivers queued events until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				dl, err := d.queue.Pop(ctx)
+				if err != nil {
+					return
+				}
+
+				if err := d.deliver(ctx, dl); err != nil {
+					log.Warn().Err(err).Str("event", dl.event.Type).
+						Str("url", dl.endpoint.URL).Msg("Failed to deliver webhook")
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// deliver sends the webhook until it is accepted or attempts are exhausted
+func (d *Dispatcher) deliver(ctx context.Context, dl delivery) error {
+	backoff := d.backoff
+
+	for attempt := 1; ; attempt++ {
+		err := d.send(ctx, dl, dl.body)
+		if err == nil || attempt >= d.maxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, dl delivery, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.event.Type)
+	req.Header.Set(HeaderDelivery, dl.event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(dl.endpoint.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	//nolint:errcheck // should be safe to ignore an error from Close()
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrDeliveryFailed, resp.Status)
+	}
+
+	return nil
+}
+
+// Sign returns value of HeaderSignature for the body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maas.io/core/src/maasagent/internal/redact"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, failures int32) (*httptest.Server, chan received) {
+	t.Helper()
+
+	ch := make(chan received, 16)
+
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ch <- received{header: r.Header.Clone(), body: body}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, ch
+}
+
+func runDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		d.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+func receive(t *testing.T, ch chan received) received {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	return received{}
+}
+
+func TestDeliver(t *testing.T) {
+	srv, ch := newReceiver(t, 2)
+
+	d, err := NewDispatcher("agent", []Endpoint{{
+		URL:    srv.URL,
+		Secret: "secret",
+		Events: []string{"workflow.completed/deploy*", "activity.failed/power-*"},
+	}}, WithRetry(3, time.Millisecond))
+	require.NoError(t, err)
+
+	runDispatcher(t, d)
+
+	d.Publish(Event{Type: EventWorkflowCompleted, Name: "deploy", WorkflowID: "deploy:abc123"})
+
+	r := receive(t, ch)
+
+	assert.Equal(t, EventWorkflowCompleted, r.header.Get(HeaderEvent))
+	assert.Equal(t, Sign("secret", r.header.Get(HeaderTimestamp), r.body), r.header.Get(HeaderSignature))
+
+	var ev Event
+	require.NoError(t, json.Unmarshal(r.body, &ev))
+	assert.Equal(t, "deploy", ev.Name)
+	assert.Equal(t, "agent", ev.SystemID)
+	assert.Equal(t, ev.ID, r.header.Get(HeaderDelivery))
+	assert.False(t, ev.Time.IsZero())
+}
+
+func TestPublishFilter(t *testing.T) {
+	srv, ch := newReceiver(t, 0)
+
+	d, err := NewDispatcher("agent", []Endpoint{{
+		URL:    srv.URL,
+		Events: []string{"activity.failed/power-*"},
+	}})
+	require.NoError(t, err)
+
+	runDispatcher(t, d)
+
+	d.Publish(Event{Type: EventWorkflowCompleted, Name: "deploy"})
+	d.Publish(Event{Type: EventActivityFailed, Name: "set-boot-order"})
+	d.Publish(Event{Type: EventActivityFailed, Name: "power-cycle"})
+
+	var ev Event
+	require.NoError(t, json.Unmarshal(receive(t, ch).body, &ev))
+	assert.Equal(t, "power-cycle", ev.Name)
+
+	select {
+	case r := <-ch:
+		t.Fatalf("unexpected webhook %s", r.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDeliverRedacted(t *testing.T) {
+	srv, ch := newReceiver(t, 0)
+
+	f, err := redact.New(redact.Config{Presets: []string{"password"}})
+	require.NoError(t, err)
+
+	d, err := NewDispatcher("agent", []Endpoint{{
+		URL:    srv.URL,
+		Secret: "secret",
+		Events: []string{"activity.failed/*"},
+	}}, WithRedaction(f))
+	require.NoError(t, err)
+
+	runDispatcher(t, d)
+
+	d.Publish(Event{Type: EventActivityFailed, Name: "power-on", Error: "ipmitool -P password=hunter2 failed"})
+
+	r := receive(t, ch)
+	assert.Equal(t, Sign("secret", r.header.Get(HeaderTimestamp), r.body), r.header.Get(HeaderSignature))
+
+	var ev Event
+	require.NoError(t, json.Unmarshal(r.body, &ev))
+	assert.Equal(t, "ipmitool -P password=[REDACTED] failed", ev.Error)
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	srv, _ := newReceiver(t, 100)
+
+	d, err := NewDispatcher("agent", nil, WithRetry(3, time.Millisecond))
+	require.NoError(t, err)
+
+	err = d.deliver(context.Background(), delivery{
+		endpoint: Endpoint{URL: srv.URL},
+		event:    Event{Type: EventWorkflowFailed},
+	})
+	assert.ErrorIs(t, err, ErrDeliveryFailed)
+}
+
+func TestSetEndpoints(t *testing.T) {
+	testcases := map[string]struct {
+		endpoint Endpoint
+		err      error
+	}{
+		"valid": {
+			endpoint: Endpoint{URL: "https://cmdb.example.com/hooks", Events: []string{"workflow.*/*"}},
+		},
+		"invalid scheme": {
+			endpoint: Endpoint{URL: "file:///etc/passwd"},
+			err:      ErrInvalidEndpoint,
+		},
+		"no host": {
+			endpoint: Endpoint{URL: "http://"},
+			err:      ErrInvalidEndpoint,
+		},
+		"invalid pattern": {
+			endpoint: Endpoint{URL: "http://example.com", Events: []string{"workflow.["}},
+			err:      ErrInvalidEndpoint,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewDispatcher("agent", []Endpoint{tc.endpoint})
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
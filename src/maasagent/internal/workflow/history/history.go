@@ -0,0 +1,116 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package history exports workflow histories for bug reports, with secrets
+// redacted, and replays them against workflows registered on the Agent to
+// check that a given version of the Agent can still execute them.
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	zlog "github.com/rs/zerolog/log"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/temporalproto"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+	wflog "maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+var (
+	// ErrEmptyHistory is returned for histories without events
+	ErrEmptyHistory = errors.New("history has no events")
+	// ErrUnknownWorkflow is returned when a replayed workflow is not
+	// registered on the Agent
+	ErrUnknownWorkflow = errors.New("unknown workflow type")
+)
+
+// Export returns history of the workflow run with secrets redacted.
+// The latest run is exported when runID is empty.
+func Export(ctx context.Context, c client.Client, workflowID, runID string) (*historypb.History, error) {
+	h := &historypb.History{}
+
+	iter := c.GetWorkflowHistory(ctx, workflowID, runID, false,
+		enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+
+	for iter.HasNext() {
+		ev, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		h.Events = append(h.Events, ev)
+	}
+
+	if len(h.Events) == 0 {
+		return nil, ErrEmptyHistory
+	}
+
+	Redact(h)
+
+	return h, nil
+}
+
+// Marshal encodes history into the JSON format used by Temporal tools
+// (e.g. temporal workflow show --output json).
+func Marshal(h *historypb.History) ([]byte, error) {
+	return temporalproto.CustomJSONMarshalOptions{Indent: "  "}.Marshal(h)
+}
+
+// Unmarshal decodes history in the JSON format used by Temporal tools.
+func Unmarshal(r io.Reader) (*historypb.History, error) {
+	h, err := client.HistoryFromJSON(r, client.HistoryJSONOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(h.Events) == 0 {
+		return nil, ErrEmptyHistory
+	}
+
+	return h, nil
+}
+
+// WorkflowType returns the type of workflow the history belongs to
+func WorkflowType(h *historypb.History) string {
+	if len(h.Events) == 0 {
+		return ""
+	}
+
+	return h.Events[0].GetWorkflowExecutionStartedEventAttributes().GetWorkflowType().GetName()
+}
+
+// Replay replays history against workflows (e.g. registered on the worker
+// pool), returning an error if the workflow code is not deterministic with
+// respect to the history. Histories with redacted secrets replay correctly
+// as long as the workflow doesn't branch on their values.
+func Replay(h *historypb.History, workflows map[string]interface{}) error {
+	name := WorkflowType(h)
+
+	fn, ok := workflows[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownWorkflow, name)
+	}
+
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflowWithOptions(fn, workflow.RegisterOptions{Name: name})
+
+	return replayer.ReplayWorkflowHistory(wflog.NewZerologAdapter(zlog.Logger), h)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// PathPrefix is where Handler is expected to be served
+const PathPrefix = "/workflows/"
+
+// maxHistorySize is the largest history accepted for replay
+const maxHistorySize = 64 << 20
+
+// ReplayResult is the response of a successful replay
+type ReplayResult struct {
+	WorkflowType string `json:"workflow_type"`
+	Events       int    `json:"events"`
+}
+
+// Handler serves redacted histories (GET /workflows/<id>/history, with
+// optional run_id query parameter) and replays histories posted to
+// POST /workflows/replay.
+type Handler struct {
+	client    client.Client
+	workflows map[string]interface{}
+}
+
+// NewHandler returns Handler exporting histories with client and replaying
+// them against workflows.
+func NewHandler(c client.Client, workflows map[string]interface{}) *Handler {
+	return &Handler{client: c, workflows: workflows}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, PathPrefix)
+
+	switch {
+	case path == "replay" && r.Method == http.MethodPost:
+		h.replay(w, r)
+	case strings.HasSuffix(path, "/history") && r.Method == http.MethodGet:
+		h.export(w, r, strings.TrimSuffix(path, "/history"))
+	case path == "replay" || strings.HasSuffix(path, "/history"):
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) export(w http.ResponseWriter, r *http.Request, workflowID string) {
+	hist, err := Export(r.Context(), h.client, workflowID, r.URL.Query().Get("run_id"))
+
+	var notFound *serviceerror.NotFound
+
+	switch {
+	case errors.As(err, &notFound), errors.Is(err, ErrEmptyHistory):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := Marshal(hist)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint:errcheck // nothing can be done if client went away
+	w.Write(data)
+}
+
+func (h *Handler) replay(w http.ResponseWriter, r *http.Request) {
+	hist, err := Unmarshal(http.MaxBytesReader(w, r.Body, maxHistorySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid history: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Failed replay is not an error of the Agent, but the outcome
+	// the client asked for
+	if err := Replay(hist, h.workflows); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint:errcheck // nothing can be done if client went away
+	json.NewEncoder(w).Encode(ReplayResult{
+		WorkflowType: WorkflowType(hist),
+		Events:       len(hist.Events),
+	})
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/serviceerror"
+	taskqueuepb "go.temporal.io/api/taskqueue/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+)
+
+// fakeClient returns history of a workflow that completed without doing
+// anything, or NotFound for other workflows
+type fakeClient struct {
+	client.Client
+}
+
+func (c *fakeClient) GetWorkflowHistory(_ context.Context, workflowID, _ string, _ bool,
+	_ enumspb.HistoryEventFilterType) client.HistoryEventIterator {
+	if workflowID != "noop:1" {
+		return &fakeIterator{err: serviceerror.NewNotFound("workflow not found")}
+	}
+
+	return &fakeIterator{events: noopHistory().Events}
+}
+
+type fakeIterator struct {
+	err    error
+	events []*historypb.HistoryEvent
+}
+
+func (i *fakeIterator) HasNext() bool {
+	return i.err != nil || len(i.events) > 0
+}
+
+func (i *fakeIterator) Next() (*historypb.HistoryEvent, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+
+	ev := i.events[0]
+	i.events = i.events[1:]
+
+	return ev, nil
+}
+
+func noop(_ workflow.Context, _ map[string]interface{}) error {
+	return nil
+}
+
+func noopHistory() *historypb.History {
+	return &historypb.History{
+		Events: []*historypb.HistoryEvent{
+			{
+				EventId:   1,
+				EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED,
+				Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{
+					WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+						WorkflowType: &commonpb.WorkflowType{Name: "noop"},
+						TaskQueue:    &taskqueuepb.TaskQueue{Name: "agent@main"},
+						Input: &commonpb.Payloads{Payloads: []*commonpb.Payload{{
+							Metadata: map[string][]byte{"encoding": []byte("json/plain")},
+							Data:     []byte(`{"power_pass":"secret"}`),
+						}}},
+					},
+				},
+			},
+			{
+				EventId:   2,
+				EventType: enumspb.EVENT_TYPE_WORKFLOW_TASK_SCHEDULED,
+				Attributes: &historypb.HistoryEvent_WorkflowTaskScheduledEventAttributes{
+					WorkflowTaskScheduledEventAttributes: &historypb.WorkflowTaskScheduledEventAttributes{
+						TaskQueue: &taskqueuepb.TaskQueue{Name: "agent@main"},
+					},
+				},
+			},
+			{
+				EventId:   3,
+				EventType: enumspb.EVENT_TYPE_WORKFLOW_TASK_STARTED,
+				Attributes: &historypb.HistoryEvent_WorkflowTaskStartedEventAttributes{
+					WorkflowTaskStartedEventAttributes: &historypb.WorkflowTaskStartedEventAttributes{
+						ScheduledEventId: 2,
+					},
+				},
+			},
+			{
+				EventId:   4,
+				EventType: enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED,
+				Attributes: &historypb.HistoryEvent_WorkflowTaskCompletedEventAttributes{
+					WorkflowTaskCompletedEventAttributes: &historypb.WorkflowTaskCompletedEventAttributes{
+						ScheduledEventId: 2,
+						StartedEventId:   3,
+					},
+				},
+			},
+			{
+				EventId:   5,
+				EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED,
+				Attributes: &historypb.HistoryEvent_WorkflowExecutionCompletedEventAttributes{
+					WorkflowExecutionCompletedEventAttributes: &historypb.WorkflowExecutionCompletedEventAttributes{
+						WorkflowTaskCompletedEventId: 4,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestHandlerExport(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		method string
+		path   string
+		status int
+	}{
+		"export": {
+			method: http.MethodGet,
+			path:   "/workflows/noop:1/history",
+			status: http.StatusOK,
+		},
+		"unknown workflow": {
+			method: http.MethodGet,
+			path:   "/workflows/noop:2/history",
+			status: http.StatusNotFound,
+		},
+		"method not allowed": {
+			method: http.MethodDelete,
+			path:   "/workflows/noop:1/history",
+			status: http.StatusMethodNotAllowed,
+		},
+		"unknown path": {
+			method: http.MethodGet,
+			path:   "/workflows/noop:1",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewHandler(&fakeClient{}, nil)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			assert.NotContains(t, rec.Body.String(), "secret")
+
+			hist, err := Unmarshal(rec.Body)
+			require.NoError(t, err)
+			assert.Len(t, hist.Events, 5)
+			assert.Equal(t, "noop", WorkflowType(hist))
+		})
+	}
+}
+
+func TestHandlerReplay(t *testing.T) {
+	t.Parallel()
+
+	data, err := Marshal(noopHistory())
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		workflows map[string]interface{}
+		body      string
+		status    int
+	}{
+		"replay": {
+			workflows: map[string]interface{}{"noop": noop},
+			body:      string(data),
+			status:    http.StatusOK,
+		},
+		"unknown workflow": {
+			workflows: map[string]interface{}{},
+			body:      string(data),
+			status:    http.StatusUnprocessableEntity,
+		},
+		"invalid history": {
+			workflows: map[string]interface{}{"noop": noop},
+			body:      `{"events":`,
+			status:    http.StatusBadRequest,
+		},
+		"empty history": {
+			workflows: map[string]interface{}{"noop": noop},
+			body:      `{"events":[]}`,
+			status:    http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewHandler(&fakeClient{}, tc.workflows)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/workflows/replay",
+				bytes.NewBufferString(tc.body)))
+
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var res ReplayResult
+
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, ReplayResult{WorkflowType: "noop", Events: 5}, res)
+		})
+	}
+}
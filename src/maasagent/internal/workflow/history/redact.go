@@ -0,0 +1,164 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package history
+
+import (
+	"encoding/json"
+	"strings"
+
+	commonpb "go.temporal.io/api/common/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Redacted replaces values of sensitive fields
+const Redacted = "REDACTED"
+
+// sensitiveKeys are substrings of names of fields redacted from payloads,
+// e.g. driver_opts.power_pass or ssh_keys
+var sensitiveKeys = []string{
+	"pass",
+	"secret",
+	"token",
+	"key",
+	"credential",
+	"cookie",
+	"private",
+}
+
+// Redact removes secrets from payloads (e.g. activity inputs and results)
+// of the history. Values of sensitive fields in JSON payloads are replaced
+// keeping their JSON types, so the history can still be replayed. Binary
+// payloads are dropped, as they cannot be inspected.
+func Redact(h *historypb.History) {
+	redactMessage(h.ProtoReflect())
+}
+
+func redactMessage(m protoreflect.Message) {
+	if p, ok := m.Interface().(*commonpb.Payload); ok {
+		redactPayload(p)
+		return
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			if fd.Message() == nil {
+				break
+			}
+
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				redactMessage(list.Get(i).Message())
+			}
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				break
+			}
+
+			v.Map().Range(func(_ protoreflect.MapKey, item protoreflect.Value) bool {
+				redactMessage(item.Message())
+				return true
+			})
+		case fd.Message() != nil:
+			redactMessage(v.Message())
+		}
+
+		return true
+	})
+}
+
+func redactPayload(p *commonpb.Payload) {
+	switch string(p.GetMetadata()["encoding"]) {
+	case "json/plain", "json/protobuf":
+		if data, ok := redactJSON(p.Data); ok {
+			p.Data = data
+		}
+	case "binary/null":
+	default:
+		p.Data = nil
+	}
+}
+
+// redactJSON returns data with values of sensitive fields redacted,
+// or false if data was not changed.
+func redactJSON(data []byte) ([]byte, bool) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, false
+	}
+
+	v, changed := redactValue(v, false)
+	if !changed {
+		return nil, false
+	}
+
+	res, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+
+	return res, true
+}
+
+// redactValue redacts all values of fields with sensitive names, including
+// nested ones. Strings are replaced with Redacted and numbers with zero.
+func redactValue(v interface{}, sensitive bool) (interface{}, bool) {
+	changed := false
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			var c bool
+
+			v[k], c = redactValue(item, sensitive || isSensitive(k))
+			changed = changed || c
+		}
+
+		return v, changed
+	case []interface{}:
+		for i, item := range v {
+			var c bool
+
+			v[i], c = redactValue(item, sensitive)
+			changed = changed || c
+		}
+
+		return v, changed
+	case string:
+		if sensitive && v != Redacted {
+			return Redacted, true
+		}
+	case float64:
+		if sensitive && v != 0 {
+			return float64(0), true
+		}
+	}
+
+	return v, false
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+
+	return false
+}
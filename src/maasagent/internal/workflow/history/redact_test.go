@@ -0,0 +1,107 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package history
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	historypb "go.temporal.io/api/history/v1"
+)
+
+func TestRedactJSON(t *testing.T) {
+	t.Parallel()
+
+	testcases := map[string]struct {
+		in      string
+		out     string
+		changed bool
+	}{
+		"power parameters": {
+			in: `{"driver_type":"ipmi","driver_opts":{"power_address":"10.0.0.5",` +
+				`"power_user":"admin","power_pass":"secret","k_g":""}}`,
+			out: `{"driver_type":"ipmi","driver_opts":{"power_address":"10.0.0.5",` +
+				`"power_user":"admin","power_pass":"REDACTED","k_g":""}}`,
+			changed: true,
+		},
+		"nested values keep types": {
+			in:      `[{"SSH_Keys":["ssh-rsa AAAA"],"api_token":{"id":42,"valid":true}}]`,
+			out:     `[{"SSH_Keys":["REDACTED"],"api_token":{"id":0,"valid":true}}]`,
+			changed: true,
+		},
+		"nothing to redact": {
+			in: `{"system_id":"abc123","state":"on"}`,
+		},
+		"already redacted": {
+			in: `{"password":"REDACTED"}`,
+		},
+		"not JSON": {
+			in: `password=secret`,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out, changed := redactJSON([]byte(tc.in))
+			require.Equal(t, tc.changed, changed)
+
+			if tc.changed {
+				assert.JSONEq(t, tc.out, string(out))
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	t.Parallel()
+
+	input := &commonpb.Payload{
+		Metadata: map[string][]byte{"encoding": []byte("json/plain")},
+		Data:     []byte(`{"driver_opts":{"power_pass":"secret"}}`),
+	}
+	binary := &commonpb.Payload{
+		Metadata: map[string][]byte{"encoding": []byte("binary/plain")},
+		Data:     []byte("secret"),
+	}
+
+	h := &historypb.History{
+		Events: []*historypb.HistoryEvent{
+			{
+				EventId: 1,
+				Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{
+					WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+						Input: &commonpb.Payloads{Payloads: []*commonpb.Payload{input}},
+						Memo: &commonpb.Memo{
+							Fields: map[string]*commonpb.Payload{"blob": binary},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	Redact(h)
+
+	assert.JSONEq(t, `{"driver_opts":{"power_pass":"REDACTED"}}`, string(input.Data))
+	assert.Empty(t, binary.Data)
+	assert.Equal(t, "binary/plain", string(binary.Metadata["encoding"]))
+}
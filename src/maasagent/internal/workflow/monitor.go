@@ -0,0 +1,98 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package workflow
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// defaultMonitorMaxIterations is the number of checks after which
+	// a monitor workflow continues as new, even if Temporal didn't suggest it.
+	defaultMonitorMaxIterations = 500
+	// defaultMonitorMaxHistoryLength is the number of history events after
+	// which a monitor workflow continues as new. Temporal has a hard limit of
+	// 51200 events and starts warning at 10240.
+	defaultMonitorMaxHistoryLength = 10000
+)
+
+// MonitorCheck is executed on every monitor iteration with the carried over
+// state. It should return true once the monitor is done.
+type MonitorCheck[S any] func(ctx workflow.Context, state *S) (bool, error)
+
+// MonitorOptions controls how often a monitor runs and when its history
+// is truncated with continue-as-new.
+type MonitorOptions struct {
+	// Interval between two checks
+	Interval time.Duration
+	// MaxIterations is the maximum number of checks in a single run.
+	// (default: 500)
+	MaxIterations int
+	// MaxHistoryLength is the maximum number of history events in a single run.
+	// (default: 10000)
+	MaxHistoryLength int
+}
+
+// Monitor runs check every Interval until it returns true or an error.
+// Long-running monitors would otherwise grow the workflow history without
+// bound, so once it gets big enough Monitor returns ContinueAsNewError that
+// restarts wf with the current state as its only argument.
+//
+// wf must be the workflow function (or its registered name) calling Monitor
+// and accepting state S, for example:
+//
+//	func PowerMonitor(ctx workflow.Context, state PowerMonitorState) error {
+//		return Monitor(ctx, PowerMonitor, state, opts, check)
+//	}
+func Monitor[S any](ctx workflow.Context, wf any, state S, opts MonitorOptions,
+	check MonitorCheck[S]) error {
+	if opts.MaxIterations <= 0 {
+		opts.MaxIterations = defaultMonitorMaxIterations
+	}
+
+	if opts.MaxHistoryLength <= 0 {
+		opts.MaxHistoryLength = defaultMonitorMaxHistoryLength
+	}
+
+	for i := 0; ; i++ {
+		done, err := check(ctx, &state)
+		if err != nil || done {
+			return err
+		}
+
+		if shouldContinueAsNew(ctx, i+1, opts) {
+			workflow.GetLogger(ctx).Debug("Monitor continues as new",
+				"iterations", i+1,
+				"history_length", workflow.GetInfo(ctx).GetCurrentHistoryLength())
+
+			return workflow.NewContinueAsNewError(ctx, wf, state)
+		}
+
+		if err := workflow.Sleep(ctx, opts.Interval); err != nil {
+			return err
+		}
+	}
+}
+
+func shouldContinueAsNew(ctx workflow.Context, iterations int, opts MonitorOptions) bool {
+	info := workflow.GetInfo(ctx)
+
+	return info.GetContinueAsNewSuggested() ||
+		info.GetCurrentHistoryLength() >= opts.MaxHistoryLength ||
+		iterations >= opts.MaxIterations
+}
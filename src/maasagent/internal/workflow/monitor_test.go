@@ -0,0 +1,90 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package workflow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+
+	wflog "maas.io/core/src/maasagent/internal/workflow/log"
+)
+
+type testMonitorState struct {
+	Checks int
+	Until  int
+}
+
+func testMonitor(ctx workflow.Context, state testMonitorState) error {
+	return Monitor(ctx, testMonitor, state,
+		MonitorOptions{Interval: time.Second, MaxIterations: 3},
+		func(_ workflow.Context, s *testMonitorState) (bool, error) {
+			s.Checks++
+			return s.Checks == s.Until, nil
+		})
+}
+
+func TestMonitor(t *testing.T) {
+	testcases := map[string]struct {
+		in             testMonitorState
+		continueAsNew  bool
+		expectedChecks int
+	}{
+		"done before continue-as-new": {
+			in: testMonitorState{Until: 2},
+		},
+		"continue-as-new carries state": {
+			in:             testMonitorState{Until: 10},
+			continueAsNew:  true,
+			expectedChecks: 3,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			wfTestSuite := testsuite.WorkflowTestSuite{}
+			wfTestSuite.SetLogger(wflog.NewZerologAdapter(zerolog.Nop()))
+			env := wfTestSuite.NewTestWorkflowEnvironment()
+
+			env.ExecuteWorkflow(testMonitor, tc.in)
+
+			assert.True(t, env.IsWorkflowCompleted())
+
+			err := env.GetWorkflowError()
+			if !tc.continueAsNew {
+				assert.NoError(t, err)
+				return
+			}
+
+			var continueAsNew *workflow.ContinueAsNewError
+			if assert.True(t, errors.As(err, &continueAsNew)) {
+				var state testMonitorState
+
+				assert.NoError(t, converter.GetDefaultDataConverter().
+					FromPayloads(continueAsNew.Input, &state))
+				assert.Equal(t, tc.expectedChecks, state.Checks)
+			}
+		})
+	}
+}
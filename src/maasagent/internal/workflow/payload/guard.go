@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package payload provides guards that keep Temporal payloads small.
+//
+// Every activity input and result is stored in the workflow history, and
+// Temporal fails workflow tasks with payloads bigger than 2MB. Large data
+// (CLI output, inventory dumps) should be spilled to a blob store and only
+// a reference to it should be passed through Temporal.
+package payload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+)
+
+const (
+	// DefaultMaxSize is the default maximum size of a single activity
+	// input or result. Temporal starts warning about payloads of 512KB.
+	DefaultMaxSize = 256 * 1024
+
+	errTypePayloadTooLarge = "PayloadTooLarge"
+)
+
+var (
+	// ErrPayloadTooLarge is returned when activity input or result exceeds
+	// the configured maximum size.
+	ErrPayloadTooLarge = errors.New("payload exceeds maximum size")
+)
+
+// Store is a blob store used to spill oversized data.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// Ref is a reference to data spilled to a blob store.
+type Ref struct {
+	Key    string `json:"key"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Blob is data that is either inlined or, when too large, stored in a blob
+// store and referenced by Ref. Only one of the fields is set.
+type Blob struct {
+	Ref  *Ref   `json:"ref,omitempty"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// Spill returns Blob with data inlined if it fits maxSize, otherwise data
+// is written to the store under the given key and Blob contains a reference.
+func Spill(ctx context.Context, store Store, key string, data []byte,
+	maxSize int) (Blob, error) {
+	if len(data) <= maxSize {
+		return Blob{Data: data}, nil
+	}
+
+	if store == nil {
+		return Blob{}, fmt.Errorf("%w: %d bytes and no blob store configured",
+			ErrPayloadTooLarge, len(data))
+	}
+
+	size := int64(len(data))
+	if err := store.Put(ctx, key, bytes.NewReader(data), size); err != nil {
+		return Blob{}, fmt.Errorf("failed to spill payload: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	return Blob{Ref: &Ref{Key: key, SHA256: hex.EncodeToString(sum[:]), Size: size}}, nil
+}
+
+// NewGuardInterceptor returns a worker interceptor that rejects activity
+// inputs and results bigger than maxSize with a non-retryable error, instead
+// of bloating the workflow history or failing the workflow task.
+func NewGuardInterceptor(maxSize int) interceptor.WorkerInterceptor {
+	return &guardInterceptor{
+		maxSize:   maxSize,
+		converter: converter.GetDefaultDataConverter(),
+	}
+}
+
+type guardInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	converter converter.DataConverter
+	maxSize   int
+}
+
+func (g *guardInterceptor) InterceptActivity(_ context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityGuard{guard: g}
+	i.Next = next
+
+	return i
+}
+
+// check returns an error if any of the values encoded exceeds maxSize.
+func (g *guardInterceptor) check(kind string, values ...any) error {
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+
+		p, err := g.converter.ToPayload(v)
+		if err != nil {
+			return err
+		}
+
+		if size := len(p.GetData()); size > g.maxSize {
+			return temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("activity %s of %d bytes exceeds %d bytes", kind, size, g.maxSize),
+				errTypePayloadTooLarge, ErrPayloadTooLarge)
+		}
+	}
+
+	return nil
+}
+
+type activityGuard struct {
+	interceptor.ActivityInboundInterceptorBase
+	guard *guardInterceptor
+}
+
+func (a *activityGuard) ExecuteActivity(ctx context.Context,
+	in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	if err := a.guard.check("input", in.Args...); err != nil {
+		return nil, err
+	}
+
+	res, err := a.Next.ExecuteActivity(ctx, in)
+	if err != nil {
+		return res, err
+	}
+
+	if err := a.guard.check("result", res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
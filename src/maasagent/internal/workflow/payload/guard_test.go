@@ -0,0 +1,95 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package payload
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore map[string][]byte
+
+func (s fakeStore) Put(_ context.Context, key string, r io.Reader, _ int64) error {
+	b, err := io.ReadAll(r)
+	s[key] = b
+
+	return err
+}
+
+func TestSpill(t *testing.T) {
+	testcases := map[string]struct {
+		store   fakeStore
+		data    []byte
+		inline  bool
+		spilled bool
+		err     error
+	}{
+		"fits": {
+			store:  fakeStore{},
+			data:   []byte("on"),
+			inline: true,
+		},
+		"too large": {
+			store:   fakeStore{},
+			data:    []byte(strings.Repeat("x", 10)),
+			spilled: true,
+		},
+		"too large without store": {
+			data: []byte(strings.Repeat("x", 10)),
+			err:  ErrPayloadTooLarge,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var store Store
+			if tc.store != nil {
+				store = tc.store
+			}
+
+			blob, err := Spill(context.Background(), store, "key", tc.data, 5)
+			assert.ErrorIs(t, err, tc.err)
+
+			if tc.inline {
+				assert.Equal(t, tc.data, blob.Data)
+				assert.Nil(t, blob.Ref)
+			}
+
+			if tc.spilled {
+				assert.Nil(t, blob.Data)
+				assert.Equal(t, int64(len(tc.data)), blob.Ref.Size)
+				assert.Equal(t, tc.data, tc.store[blob.Ref.Key])
+			}
+		})
+	}
+}
+
+func TestGuardCheck(t *testing.T) {
+	g, ok := NewGuardInterceptor(10).(*guardInterceptor)
+	assert.True(t, ok)
+
+	assert.NoError(t, g.check("result", "small"))
+	assert.NoError(t, g.check("result", nil))
+	assert.ErrorIs(t, g.check("result", strings.Repeat("x", 20)), ErrPayloadTooLarge)
+}
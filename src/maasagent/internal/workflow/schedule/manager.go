@@ -0,0 +1,136 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/client"
+)
+
+// Provider is an interface implemented by services that have recurring
+// maintenance work (image sync, monitoring sweeps, certificate renewal).
+// Scheduled workflows should be registered on the main worker via
+// worker.Configurator, so they can be started on the main task queue.
+type Provider interface {
+	// Schedules should return a collection of schedules required by the
+	// service. Schedule ID should be unique within the service and is
+	// namespaced by the Manager with the Agent systemID.
+	Schedules() []Schedule
+}
+
+// Manager keeps Temporal Schedules owned by the Agent in sync with the
+// schedules required by the registered providers.
+type Manager struct {
+	client    client.ScheduleClient
+	providers []Provider
+	prefix    string
+	taskQueue string
+}
+
+// ManagerOption allows to set additional Manager options
+type ManagerOption func(*Manager)
+
+// NewManager returns a Manager that owns all schedules with IDs prefixed by
+// the Agent systemID. taskQueue is used for schedules that don't set one.
+func NewManager(c client.ScheduleClient, systemID, taskQueue string,
+	options ...ManagerOption) *Manager {
+	m := &Manager{
+		client:    c,
+		prefix:    fmt.Sprintf("%s@agent:", systemID),
+		taskQueue: taskQueue,
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	return m
+}
+
+// WithProvider adds a Provider whose schedules are managed by the Manager.
+func WithProvider(p Provider) ManagerOption {
+	return func(m *Manager) {
+		m.providers = append(m.providers, p)
+	}
+}
+
+// Reconcile creates or updates all schedules required by providers and
+// removes schedules owned by this Agent that are no longer required
+// (e.g. a service has been disabled or a task was removed after upgrade).
+func (m *Manager) Reconcile(ctx context.Context) error {
+	desired := make(map[string]struct{})
+
+	var errs []error
+
+	for _, p := range m.providers {
+		for _, s := range p.Schedules() {
+			s.ID = m.prefix + s.ID
+			if s.TaskQueue == "" {
+				s.TaskQueue = m.taskQueue
+			}
+
+			desired[s.ID] = struct{}{}
+
+			if err := Ensure(ctx, m.client, s); err != nil {
+				errs = append(errs, fmt.Errorf("failed to ensure schedule %q: %w", s.ID, err))
+			}
+		}
+	}
+
+	existing, err := m.list(ctx)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, id := range existing {
+		if _, ok := desired[id]; ok {
+			continue
+		}
+
+		if err := Remove(ctx, m.client, id); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove schedule %q: %w", id, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// list returns IDs of all schedules owned by this Agent.
+func (m *Manager) list(ctx context.Context) ([]string, error) {
+	iter, err := m.client.List(ctx, client.ScheduleListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+
+	for iter.HasNext() {
+		entry, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(entry.ID, m.prefix) {
+			ids = append(ids, entry.ID)
+		}
+	}
+
+	return ids, nil
+}
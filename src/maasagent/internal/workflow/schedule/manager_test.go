@@ -0,0 +1,122 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+)
+
+type fakeScheduleClient struct {
+	client.ScheduleClient
+	schedules map[string]client.ScheduleOptions
+	updated   []string
+	deleted   []string
+}
+
+func (c *fakeScheduleClient) Create(_ context.Context,
+	opts client.ScheduleOptions) (client.ScheduleHandle, error) {
+	if _, ok := c.schedules[opts.ID]; ok {
+		return nil, temporal.ErrScheduleAlreadyRunning
+	}
+
+	c.schedules[opts.ID] = opts
+
+	return &fakeScheduleHandle{id: opts.ID, client: c}, nil
+}
+
+func (c *fakeScheduleClient) GetHandle(_ context.Context, id string) client.ScheduleHandle {
+	return &fakeScheduleHandle{id: id, client: c}
+}
+
+func (c *fakeScheduleClient) List(_ context.Context,
+	_ client.ScheduleListOptions) (client.ScheduleListIterator, error) {
+	iter := &fakeScheduleListIterator{}
+	for id := range c.schedules {
+		iter.entries = append(iter.entries, &client.ScheduleListEntry{ID: id})
+	}
+
+	return iter, nil
+}
+
+type fakeScheduleHandle struct {
+	client.ScheduleHandle
+	client *fakeScheduleClient
+	id     string
+}
+
+func (h *fakeScheduleHandle) Update(_ context.Context, opts client.ScheduleUpdateOptions) error {
+	_, err := opts.DoUpdate(client.ScheduleUpdateInput{})
+	h.client.updated = append(h.client.updated, h.id)
+
+	return err
+}
+
+func (h *fakeScheduleHandle) Delete(_ context.Context) error {
+	delete(h.client.schedules, h.id)
+	h.client.deleted = append(h.client.deleted, h.id)
+
+	return nil
+}
+
+type fakeScheduleListIterator struct {
+	entries []*client.ScheduleListEntry
+}
+
+func (i *fakeScheduleListIterator) HasNext() bool {
+	return len(i.entries) > 0
+}
+
+func (i *fakeScheduleListIterator) Next() (*client.ScheduleListEntry, error) {
+	entry := i.entries[0]
+	i.entries = i.entries[1:]
+
+	return entry, nil
+}
+
+type fakeProvider []Schedule
+
+func (p fakeProvider) Schedules() []Schedule {
+	return p
+}
+
+func TestManagerReconcile(t *testing.T) {
+	c := &fakeScheduleClient{
+		schedules: map[string]client.ScheduleOptions{
+			"abc@agent:existing": {ID: "abc@agent:existing"},
+			"abc@agent:stale":    {ID: "abc@agent:stale"},
+			"def@agent:foreign":  {ID: "def@agent:foreign"},
+		},
+	}
+
+	m := NewManager(c, "abc", "abc@agent:main", WithProvider(fakeProvider{
+		{ID: "existing", Workflow: "existing-workflow", Every: time.Hour},
+		{ID: "new", Workflow: "new-workflow", Every: time.Hour},
+	}))
+
+	assert.NoError(t, m.Reconcile(context.Background()))
+
+	assert.Contains(t, c.schedules, "abc@agent:new")
+	assert.Equal(t, "abc@agent:main", c.schedules["abc@agent:new"].Action.(*client.ScheduleWorkflowAction).TaskQueue)
+	assert.Equal(t, []string{"abc@agent:existing"}, c.updated)
+	assert.Equal(t, []string{"abc@agent:stale"}, c.deleted)
+	assert.Contains(t, c.schedules, "def@agent:foreign")
+}
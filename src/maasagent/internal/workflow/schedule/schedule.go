@@ -0,0 +1,217 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package schedule provides helpers to run recurring Agent work through
+// Temporal Schedules instead of local timers.
+//
+// Schedules are evaluated by the Temporal server, so the rack clock drifting
+// or the Agent being restarted neither skips nor duplicates a run: missed runs
+// are caught up within the CatchupWindow and overlapping runs are resolved by
+// the Overlap policy.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// defaultCatchupWindow is how long after a missed run (e.g. the Agent
+	// was down) the Temporal server will still start it.
+	defaultCatchupWindow = 10 * time.Minute
+)
+
+var (
+	// ErrMissingID is returned when a Schedule has no ID
+	ErrMissingID = errors.New("schedule ID is required")
+	// ErrMissingWorkflow is returned when a Schedule has no workflow to start
+	ErrMissingWorkflow = errors.New("schedule workflow is required")
+	// ErrMissingSpec is returned when a Schedule has neither interval nor cron
+	ErrMissingSpec = errors.New("schedule requires an interval or cron expression")
+
+	// scheduledStartTimeKey is a search attribute set by the Temporal server
+	// on workflows started by a Schedule.
+	scheduledStartTimeKey = temporal.NewSearchAttributeKeyTime("TemporalScheduledStartTime")
+)
+
+// Schedule describes recurring work that should be started by Temporal.
+type Schedule struct {
+	// ID uniquely identifies the schedule within the Temporal namespace.
+	// It should be prefixed with the Agent systemID for per-Agent schedules.
+	ID string
+	// Workflow is the name of the workflow to start.
+	Workflow string
+	// TaskQueue is the task queue the workflow is started on.
+	TaskQueue string
+	// Args are passed to the workflow on each run.
+	Args []any
+	// Every starts the workflow at a fixed interval, aligned to the epoch
+	// (not to the moment the schedule was created).
+	Every time.Duration
+	// Offset shifts the interval alignment.
+	Offset time.Duration
+	// Cron is a list of cron expressions used in addition to Every.
+	Cron []string
+	// TimeZone is an IANA time zone name used to interpret Cron.
+	// (default: UTC)
+	TimeZone string
+	// Jitter randomly delays each run by up to the given duration, which
+	// avoids all Agents hitting the Region at the same moment.
+	Jitter time.Duration
+	// Overlap controls what happens when a run is due while the previous one
+	// is still running.
+	// (default: skip)
+	Overlap enums.ScheduleOverlapPolicy
+	// CatchupWindow is how long after a missed run it is still started.
+	// (default: 10 minutes)
+	CatchupWindow time.Duration
+	// ExecutionTimeout limits the duration of a single run.
+	ExecutionTimeout time.Duration
+	// Note describes the schedule to operators, e.g. in the Temporal UI and
+	// when schedules are listed.
+	Note string
+}
+
+func (s Schedule) validate() error {
+	if s.ID == "" {
+		return ErrMissingID
+	}
+
+	if s.Workflow == "" {
+		return ErrMissingWorkflow
+	}
+
+	if s.Every <= 0 && len(s.Cron) == 0 {
+		return ErrMissingSpec
+	}
+
+	return nil
+}
+
+func (s Schedule) spec() client.ScheduleSpec {
+	spec := client.ScheduleSpec{
+		CronExpressions: s.Cron,
+		Jitter:          s.Jitter,
+		TimeZoneName:    s.TimeZone,
+	}
+
+	if s.Every > 0 {
+		spec.Intervals = []client.ScheduleIntervalSpec{
+			{Every: s.Every, Offset: s.Offset},
+		}
+	}
+
+	return spec
+}
+
+func (s Schedule) action() *client.ScheduleWorkflowAction {
+	return &client.ScheduleWorkflowAction{
+		// Temporal appends the scheduled time to this ID, which makes every
+		// run unique and prevents duplicate runs of the same tick.
+		ID:                       s.ID,
+		Workflow:                 s.Workflow,
+		Args:                     s.Args,
+		TaskQueue:                s.TaskQueue,
+		WorkflowExecutionTimeout: s.ExecutionTimeout,
+	}
+}
+
+func (s Schedule) policies() client.SchedulePolicies {
+	policies := client.SchedulePolicies{
+		Overlap:       s.Overlap,
+		CatchupWindow: s.CatchupWindow,
+	}
+
+	if policies.Overlap == enums.SCHEDULE_OVERLAP_POLICY_UNSPECIFIED {
+		policies.Overlap = enums.SCHEDULE_OVERLAP_POLICY_SKIP
+	}
+
+	if policies.CatchupWindow <= 0 {
+		policies.CatchupWindow = defaultCatchupWindow
+	}
+
+	return policies
+}
+
+// Ensure creates the schedule or, if it already exists, updates its spec,
+// action and policies in place. It is safe to call on every Agent start.
+func Ensure(ctx context.Context, c client.ScheduleClient, s Schedule) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	policies := s.policies()
+
+	_, err := c.Create(ctx, client.ScheduleOptions{
+		ID:            s.ID,
+		Spec:          s.spec(),
+		Action:        s.action(),
+		Overlap:       policies.Overlap,
+		CatchupWindow: policies.CatchupWindow,
+		Note:          s.Note,
+	})
+	if !errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		return err
+	}
+
+	return c.GetHandle(ctx, s.ID).Update(ctx, client.ScheduleUpdateOptions{
+		DoUpdate: func(in client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+			sched := in.Description.Schedule
+			spec := s.spec()
+			sched.Spec = &spec
+			sched.Action = s.action()
+			sched.Policy = &policies
+
+			if sched.State != nil {
+				sched.State.Note = s.Note
+			}
+
+			return &client.ScheduleUpdate{Schedule: &sched}, nil
+		},
+	})
+}
+
+// Remove deletes the schedule. Removing a schedule that does not exist
+// is not an error.
+func Remove(ctx context.Context, c client.ScheduleClient, id string) error {
+	err := c.GetHandle(ctx, id).Delete(ctx)
+
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+
+	return err
+}
+
+// ScheduledTime returns the nominal time a scheduled workflow run was due.
+// Recurring work should use it instead of the local clock, so that a run
+// started late (e.g. caught up after Agent downtime) still processes the
+// period it was scheduled for.
+// If the workflow was not started by a Schedule, workflow.Now() is returned.
+func ScheduledTime(ctx workflow.Context) time.Time {
+	if t, ok := workflow.GetTypedSearchAttributes(ctx).GetTime(scheduledStartTimeKey); ok {
+		return t
+	}
+
+	return workflow.Now(ctx)
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2023-2024 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+)
+
+func TestScheduleValidate(t *testing.T) {
+	testcases := map[string]struct {
+		in  Schedule
+		err error
+	}{
+		"missing ID": {
+			in:  Schedule{Workflow: "wf", Every: time.Minute},
+			err: ErrMissingID,
+		},
+		"missing workflow": {
+			in:  Schedule{ID: "id", Every: time.Minute},
+			err: ErrMissingWorkflow,
+		},
+		"missing spec": {
+			in:  Schedule{ID: "id", Workflow: "wf"},
+			err: ErrMissingSpec,
+		},
+		"interval": {
+			in: Schedule{ID: "id", Workflow: "wf", Every: time.Minute},
+		},
+		"cron": {
+			in: Schedule{ID: "id", Workflow: "wf", Cron: []string{"0 7 * * *"}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.ErrorIs(t, tc.in.validate(), tc.err)
+		})
+	}
+}
+
+func TestScheduleSpec(t *testing.T) {
+	testcases := map[string]struct {
+		in  Schedule
+		out client.ScheduleSpec
+	}{
+		"interval with offset and jitter": {
+			in: Schedule{Every: time.Hour, Offset: time.Minute, Jitter: time.Second},
+			out: client.ScheduleSpec{
+				Intervals: []client.ScheduleIntervalSpec{
+					{Every: time.Hour, Offset: time.Minute},
+				},
+				Jitter: time.Second,
+			},
+		},
+		"cron with time zone": {
+			in: Schedule{Cron: []string{"0 7 * * *"}, TimeZone: "Europe/London"},
+			out: client.ScheduleSpec{
+				CronExpressions: []string{"0 7 * * *"},
+				TimeZoneName:    "Europe/London",
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.spec())
+		})
+	}
+}
+
+func TestSchedulePolicies(t *testing.T) {
+	testcases := map[string]struct {
+		in  Schedule
+		out client.SchedulePolicies
+	}{
+		"defaults": {
+			in: Schedule{},
+			out: client.SchedulePolicies{
+				Overlap:       enums.SCHEDULE_OVERLAP_POLICY_SKIP,
+				CatchupWindow: defaultCatchupWindow,
+			},
+		},
+		"explicit": {
+			in: Schedule{
+				Overlap:       enums.SCHEDULE_OVERLAP_POLICY_BUFFER_ONE,
+				CatchupWindow: time.Hour,
+			},
+			out: client.SchedulePolicies{
+				Overlap:       enums.SCHEDULE_OVERLAP_POLICY_BUFFER_ONE,
+				CatchupWindow: time.Hour,
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.out, tc.in.policies())
+		})
+	}
+}
@@ -17,10 +17,12 @@ package worker
 
 import (
 	"fmt"
+	"maps"
 	"sync"
 
 	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
 	"go.temporal.io/sdk/workflow"
 )
@@ -56,6 +58,7 @@ type WorkerPool struct {
 	workers           map[string][]worker.Worker
 	workflows         map[string]interface{}
 	activities        map[string]interface{}
+	interceptors      []interceptor.WorkerInterceptor
 	systemID          string
 	taskQueue         string
 	mutex             sync.Mutex
@@ -85,6 +88,7 @@ func NewWorkerPool(systemID string, client client.Client,
 		DisableRegistrationAliasing:            true,
 		MaxConcurrentWorkflowTaskPollers:       2,
 		MaxConcurrentWorkflowTaskExecutionSize: 2,
+		Interceptors:                           pool.interceptors,
 		// Used to catch runtime errors from main
 		OnFatalError: func(err error) { pool.fatal <- err },
 	})
@@ -115,6 +119,16 @@ func (p *WorkerPool) Start() error {
 	return p.main.Start()
 }
 
+// TaskQueue returns the Task Queue polled by the main worker.
+func (p *WorkerPool) TaskQueue() string {
+	return p.taskQueue
+}
+
+// Workflows returns workflows registered on the main worker by name.
+func (p *WorkerPool) Workflows() map[string]interface{} {
+	return maps.Clone(p.workflows)
+}
+
 func (p *WorkerPool) Error() error {
 	return <-p.fatal
 }
@@ -133,6 +147,7 @@ func (p *WorkerPool) AddWorker(group, taskQueue string,
 
 	opts.OnFatalError = func(err error) { p.fatal <- err }
 	opts.DisableRegistrationAliasing = true
+	opts.Interceptors = append(opts.Interceptors, p.interceptors...)
 
 	w := p.workerConstructor(p.client, taskQueue, opts)
 
@@ -196,6 +211,14 @@ func WithWorkerConstructor(fn workerConstructor) WorkerPoolOption {
 	}
 }
 
+// WithInterceptors adds worker interceptors applied to every worker
+// in the pool, including the main worker.
+func WithInterceptors(interceptors ...interceptor.WorkerInterceptor) WorkerPoolOption {
+	return func(p *WorkerPool) {
+		p.interceptors = append(p.interceptors, interceptors...)
+	}
+}
+
 // WithConfigurator adds Configurator that will be registered as a workflow
 func WithConfigurator(configurator Configurator) WorkerPoolOption {
 	return func(p *WorkerPool) {
@@ -1,13 +1,38 @@
 #  Copyright 2024 Canonical Ltd.  This software is licensed under the
 #  GNU Affero General Public License version 3 (see the file LICENSE).
 
+from datetime import timedelta
+
 from fastapi import Depends, Response
 
 from maasapiserver.common.api.base import Handler, handler
 from maasapiserver.v3.api import services
+from maasapiserver.v3.api.internal.models.requests.agents import (
+    FilesystemHealthRequest,
+    LatencyEventRequest,
+    MachineAnnotationsRequest,
+    MachineEventRequest,
+    PowerAnomalyRequest,
+    RemediationEventRequest,
+)
+from maascommon.enums.events import EventTypeEnum
 from maasservicelayer.services import ServiceCollectionV3
 
 
+# Event types of machine events derived from SNMP traps. Other types can be
+# configured in trap mappings, they are recorded as NODE_SNMP_TRAP.
+SNMP_TRAP_EVENT_TYPES = {
+    "power-on": EventTypeEnum.NODE_POWERED_ON,
+    "power-off": EventTypeEnum.NODE_POWERED_OFF,
+    "port-up": EventTypeEnum.NODE_PORT_UP,
+    "port-down": EventTypeEnum.NODE_PORT_DOWN,
+}
+
+
+def _format_duration(nanoseconds: int) -> str:
+    return f"{nanoseconds / 1_000_000:g}ms"
+
+
 class AgentHandler(Handler):
     """
     MAAS Agent API handler provides collection of handlers that can be called
@@ -32,3 +57,190 @@ class AgentHandler(Handler):
         )
 
         return tokens
+
+    @handler(
+        path="/agents/{system_id}/filesystem-health",
+        methods=["POST"],
+        responses={
+            204: {},
+        },
+        status_code=204,
+    )
+    async def report_filesystem_health(
+        self,
+        system_id: str,
+        response: Response,
+        statuses: list[FilesystemHealthRequest],
+        services: ServiceCollectionV3 = Depends(services),
+    ) -> Response:
+        for status in statuses:
+            if status.error:
+                event_type = EventTypeEnum.AGENT_FILESYSTEM_UNHEALTHY
+                description = (
+                    f"{status.subsystem} directory {status.path}: "
+                    f"{status.error}"
+                )
+            else:
+                event_type = EventTypeEnum.AGENT_FILESYSTEM_HEALTHY
+                description = (
+                    f"{status.subsystem} directory {status.path}: "
+                    f"{status.free_bytes} of {status.total_bytes} bytes free"
+                )
+            await services.events.record_node_event(
+                system_id, event_type, description
+            )
+
+    @handler(
+        path="/agents/{system_id}/latency-events",
+        methods=["POST"],
+        responses={
+            204: {},
+        },
+        status_code=204,
+    )
+    async def report_latency_events(
+        self,
+        system_id: str,
+        response: Response,
+        events: list[LatencyEventRequest],
+        services: ServiceCollectionV3 = Depends(services),
+    ) -> Response:
+        for event in events:
+            if event.breached:
+                event_type = EventTypeEnum.AGENT_LATENCY_OBJECTIVE_BREACHED
+                comparison = "above"
+            else:
+                event_type = EventTypeEnum.AGENT_LATENCY_OBJECTIVE_MET
+                comparison = "within"
+            description = (
+                f"{event.percentile} latency of {event.operation} is "
+                f"{_format_duration(event.actual)}, {comparison} objective "
+                f"of {_format_duration(event.objective)} "
+                f"({event.samples} samples)"
+            )
+            await services.events.record_node_event(
+                system_id, event_type, description
+            )
+
+    @handler(
+        path="/agents/{system_id}/remediation-events",
+        methods=["POST"],
+        responses={
+            204: {},
+        },
+        status_code=204,
+    )
+    async def report_remediation_events(
+        self,
+        system_id: str,
+        response: Response,
+        events: list[RemediationEventRequest],
+        services: ServiceCollectionV3 = Depends(services),
+    ) -> Response:
+        for event in events:
+            description = (
+                f"{event.rule} triggered by {event.count} failures of "
+                f"{event.source}"
+            )
+            if event.group:
+                group = ", ".join(
+                    f"{key}={value}"
+                    for key, value in sorted(event.group.items())
+                )
+                description += f" ({group})"
+            if event.error:
+                description += f": {event.error}"
+            await services.events.record_node_event(
+                system_id,
+                EventTypeEnum.AGENT_REMEDIATION_RULE_TRIGGERED,
+                description,
+            )
+
+    @handler(
+        path="/agents/{system_id}/machine-events",
+        methods=["POST"],
+        responses={
+            204: {},
+        },
+        status_code=204,
+    )
+    async def report_machine_events(
+        self,
+        system_id: str,
+        response: Response,
+        events: list[MachineEventRequest],
+        services: ServiceCollectionV3 = Depends(services),
+    ) -> Response:
+        for event in events:
+            event_type = SNMP_TRAP_EVENT_TYPES.get(
+                event.type, EventTypeEnum.NODE_SNMP_TRAP
+            )
+            source = event.source
+            if event.index:
+                source += f" index {event.index}"
+            description = (
+                f"{event.type} reported by {source} "
+                f"({event.mapping} trap {event.trap_oid})"
+            )
+            # events are of the machines, not of the Agent receiving traps
+            await services.events.record_node_event(
+                event.system_id, event_type, description
+            )
+
+    @handler(
+        path="/agents/{system_id}/power-anomalies",
+        methods=["POST"],
+        responses={
+            204: {},
+        },
+        status_code=204,
+    )
+    async def report_power_anomalies(
+        self,
+        system_id: str,
+        response: Response,
+        anomalies: list[PowerAnomalyRequest],
+        services: ServiceCollectionV3 = Depends(services),
+    ) -> Response:
+        for anomaly in anomalies:
+            window = timedelta(microseconds=anomaly.window // 1000)
+            description = (
+                f"{anomaly.kind}: {anomaly.count} times within {window}"
+            )
+            if anomaly.state:
+                description += f", last found {anomaly.state}"
+            await services.events.record_node_event(
+                anomaly.machine, EventTypeEnum.NODE_POWER_ANOMALY, description
+            )
+
+    @handler(
+        path="/agents/{system_id}/machine-annotations",
+        methods=["POST"],
+        responses={
+            204: {},
+        },
+        status_code=204,
+    )
+    async def report_machine_annotations(
+        self,
+        system_id: str,
+        response: Response,
+        request: MachineAnnotationsRequest,
+        services: ServiceCollectionV3 = Depends(services),
+    ) -> Response:
+        # The Agent keeps annotations and sends all of them whenever one is
+        # attached, so only the newest one is recorded.
+        if not request.annotations:
+            await services.events.record_node_event(
+                request.system_id,
+                EventTypeEnum.NODE_ANNOTATIONS_CLEARED,
+                "",
+            )
+            return
+        annotation = request.annotations[-1]
+        description = annotation.text
+        if annotation.source:
+            description += f" (by {annotation.source})"
+        await services.events.record_node_event(
+            request.system_id, EventTypeEnum.NODE_ANNOTATED, description
+        )
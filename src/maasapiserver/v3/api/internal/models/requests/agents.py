@@ -0,0 +1,74 @@
+#  Copyright 2024 Canonical Ltd.  This software is licensed under the
+#  GNU Affero General Public License version 3 (see the file LICENSE).
+
+from datetime import datetime
+from typing import Optional
+
+from pydantic import BaseModel
+
+
+class FilesystemHealthRequest(BaseModel):
+    subsystem: str
+    path: str
+    # set when the directory isn't writable or is running out of space
+    error: Optional[str] = None
+    free_bytes: int
+    total_bytes: int
+
+
+class LatencyEventRequest(BaseModel):
+    operation: str
+    percentile: str
+    # durations are in nanoseconds
+    objective: int
+    actual: int
+    samples: int
+    breached: bool
+
+
+class RemediationEventRequest(BaseModel):
+    time: datetime
+    # attributes the failures were grouped by, e.g. the subnet
+    group: dict[str, str] = {}
+    rule: str
+    source: str
+    error: Optional[str] = None
+    count: int
+
+
+class MachineEventRequest(BaseModel):
+    time: datetime
+    system_id: str
+    # e.g. power-on or port-down, as configured in the trap mapping
+    type: str
+    # address of the device which sent the trap
+    source: str
+    index: Optional[str] = None
+    mapping: str
+    trap_oid: str
+
+
+class PowerAnomalyRequest(BaseModel):
+    time: datetime
+    # system_id of the machine
+    machine: str
+    # unexpected-off, unexpected-on or flapping
+    kind: str
+    # power state the machine was found in, if any
+    state: Optional[str] = None
+    count: int
+    # nanoseconds
+    window: int
+
+
+class AnnotationRequest(BaseModel):
+    created_at: datetime
+    text: str
+    # who attached the annotation, e.g. workflow type or user
+    source: Optional[str] = None
+
+
+class MachineAnnotationsRequest(BaseModel):
+    system_id: str
+    # all annotations of the machine, oldest first
+    annotations: list[AnnotationRequest]
@@ -6,18 +6,25 @@ import re
 
 from pydantic import BaseModel, IPvAnyAddress, validator
 
-from maascommon.enums.ipaddress import LeaseAction
+from maascommon.enums.ipaddress import IpAddressFamily, LeaseAction
 
 
 class LeaseInfoRequest(BaseModel):
     action: LeaseAction
-    ip_family: str
+    ip_family: IpAddressFamily
     hostname: str
     mac: str
     ip: IPvAnyAddress
     timestamp: int
     lease_time: int  # seconds
 
+    # dhcpd notifications name the family "ipv4" or "ipv6"
+    @validator("ip_family", pre=True)
+    def parse_ip_family(cls, v: str | int) -> int:
+        if isinstance(v, str) and v.lower().startswith("ipv"):
+            return int(v[3:])
+        return v
+
     # Validator to normalize MAC address
     @validator("mac", pre=True)
     def normalize_mac(cls, v: str) -> str:
@@ -0,0 +1,37 @@
+#  Copyright 2024 Canonical Ltd.  This software is licensed under the
+#  GNU Affero General Public License version 3 (see the file LICENSE).
+
+from enum import Enum
+
+
+class EventTypeEnum(str, Enum):
+    """Types of the events reported by Agents about nodes."""
+
+    # Filesystem health of the Agent subsystems
+    AGENT_FILESYSTEM_HEALTHY = "AGENT_FILESYSTEM_HEALTHY"
+    AGENT_FILESYSTEM_UNHEALTHY = "AGENT_FILESYSTEM_UNHEALTHY"
+    # Latency objectives of the Agent operations
+    AGENT_LATENCY_OBJECTIVE_BREACHED = "AGENT_LATENCY_OBJECTIVE_BREACHED"
+    AGENT_LATENCY_OBJECTIVE_MET = "AGENT_LATENCY_OBJECTIVE_MET"
+    # Remediation rules of the Agent triggered by repeated failures
+    AGENT_REMEDIATION_RULE_TRIGGERED = "AGENT_REMEDIATION_RULE_TRIGGERED"
+    # Health of DHCP failover pairs served by the Agent
+    AGENT_DHCP_FAILOVER_HEALTHY = "AGENT_DHCP_FAILOVER_HEALTHY"
+    AGENT_DHCP_FAILOVER_UNHEALTHY = "AGENT_DHCP_FAILOVER_UNHEALTHY"
+    AGENT_DHCP_FAILOVER_PROMOTED = "AGENT_DHCP_FAILOVER_PROMOTED"
+    # Machine events derived from SNMP traps of PDUs and switches
+    NODE_POWERED_ON = "NODE_POWERED_ON"
+    NODE_POWERED_OFF = "NODE_POWERED_OFF"
+    NODE_PORT_UP = "NODE_PORT_UP"
+    NODE_PORT_DOWN = "NODE_PORT_DOWN"
+    NODE_SNMP_TRAP = "NODE_SNMP_TRAP"
+    # Power behavior of machines crossing thresholds of the Agent
+    NODE_POWER_ANOMALY = "NODE_POWER_ANOMALY"
+    # Annotations attached to machines on the Agent
+    NODE_ANNOTATED = "NODE_ANNOTATED"
+    NODE_ANNOTATIONS_CLEARED = "NODE_ANNOTATIONS_CLEARED"
+    # Verdicts of burn-in tests of machines
+    NODE_BURN_IN_PASSED = "NODE_BURN_IN_PASSED"
+    NODE_BURN_IN_FAILED = "NODE_BURN_IN_FAILED"
+    # Images captured from machines by Agents
+    NODE_IMAGE_CAPTURED = "NODE_IMAGE_CAPTURED"
@@ -10,12 +10,31 @@ CONFIGURE_AGENT_WORKFLOW_NAME = "configure-agent"
 CONFIGURE_POWER_SERVICE_WORKFLOW_NAME = "configure-power-service"
 CONFIGURE_HTTPPROXY_SERVICE_WORKFLOW_NAME = "configure-httpproxy-service"
 CONFIGURE_DHCP_SERVICE_WORKFLOW_NAME = "configure-dhcp-service"
+CONFIGURE_SUBNET_SERVICES_WORKFLOW_NAME = "configure-subnet-services"
+CONFIGURE_DNS_PUBLICATION_WORKFLOW_NAME = "configure-dns-publication"
+
+# Agent roles, defined in maasagent
+AGENT_ROLE_POWER = "power"
+AGENT_ROLE_HTTPPROXY = "httpproxy"
+AGENT_ROLE_DHCP = "dhcp"
+
+# NodeMetadata key of roles enabled on the Agent of a rack, as a JSON list.
+# Racks without it run an Agent that doesn't send its roles, which has all
+# roles enabled.
+AGENT_ROLES_METADATA_KEY = "agent_roles"
 
 
 # Workflows parameters
 @dataclass
 class ConfigureAgentParam:
     system_id: str
+    # Roles enabled on the Agent. Configuration workflows of disabled roles
+    # are not registered by the Agent, hence they must not be started.
+    # All roles are configured for Agents that don't send their roles.
+    roles: list[str] | None = None
+
+    def has_role(self, role: str) -> bool:
+        return self.roles is None or role in self.roles
 
 
 @dataclass
@@ -3,14 +3,29 @@
 
 from dataclasses import dataclass
 from enum import Enum
-from typing import Any
+from typing import Any, Optional
 
 # Workflows names
 POWER_ON_WORKFLOW_NAME = "power-on"
 POWER_CYCLE_WORKFLOW_NAME = "power-cycle"
 POWER_OFF_WORKFLOW_NAME = "power-off"
 POWER_QUERY_WORKFLOW_NAME = "power-query"
+POWER_RESET_WORKFLOW_NAME = "power-reset"
 POWER_MANY_WORKFLOW_NAME = "power-many"
+POWER_ON_TEMPORARILY_WORKFLOW_NAME = "power-on-temporarily"
+SET_BOOT_DEVICE_WORKFLOW_NAME = "set-boot-device"
+
+# Signal powering off a machine held on by PowerOnTemporarily early
+POWER_ON_TEMPORARILY_RELEASE_SIGNAL = "release"
+
+# Firmware boot modes of machines
+BOOT_MODE_UEFI = "uefi"
+BOOT_MODE_LEGACY = "legacy"
+
+# Devices of the next boot
+BOOT_DEVICE_PXE = "pxe"
+BOOT_DEVICE_DISK = "disk"
+BOOT_DEVICE_CD = "cd"
 
 
 # XXX: Once Python 3.11 switch to StrEnum
@@ -19,6 +34,7 @@ class PowerAction(Enum):
     POWER_OFF = POWER_OFF_WORKFLOW_NAME
     POWER_CYCLE = POWER_CYCLE_WORKFLOW_NAME
     POWER_QUERY = POWER_QUERY_WORKFLOW_NAME
+    POWER_RESET = POWER_RESET_WORKFLOW_NAME
 
 
 # Workflows parameters
@@ -31,6 +47,15 @@ class PowerParam:
     driver_type: str
     driver_opts: dict[str, Any]
     task_queue: str
+    # firmware boot mode boot source overrides are applied with,
+    # left to the BMC if not set
+    boot_mode: Optional[str] = None
+    # who asked for the power action (e.g. a MAAS user) and why, logged
+    # by the Agent with everything it does for the action
+    requester: Optional[str] = None
+    reason: Optional[str] = None
+    # ties the power action to the request of the caller
+    correlation_id: Optional[str] = None
 
 
 @dataclass
@@ -48,6 +73,32 @@ class PowerCycleParam(PowerParam):
     Parameters required by the PowerCycle workflow
     """
 
+    # power cycle is emulated by powering the machine off and on, if its
+    # driver can't cycle power (e.g. the BMC doesn't allow restart)
+    emulate: bool = True
+    # seconds the machine is left off when power cycle is emulated
+    emulation_off_delay: int = 5
+    # seconds the machine can take to reach the power state when power
+    # cycle is emulated
+    emulation_verify_timeout: int = 120
+
+
+@dataclass
+class PowerOnTemporarilyParam(PowerParam):
+    """
+    Parameters required by the PowerOnTemporarily workflow
+    """
+
+    # seconds the machine is held on, unless it is released earlier
+    duration: int = 3600
+
+
+@dataclass
+class PowerResetParam(PowerParam):
+    """
+    Parameters required by the PowerReset workflow
+    """
+
     pass
 
 
@@ -70,6 +121,16 @@ class PowerOffParam(PowerParam):
     pass
 
 
+@dataclass
+class SetBootDeviceParam(PowerParam):
+    """
+    Parameters required by the SetBootDevice workflow
+    """
+
+    # one of BOOT_DEVICE_PXE, BOOT_DEVICE_DISK or BOOT_DEVICE_CD
+    device: str = BOOT_DEVICE_PXE
+
+
 @dataclass
 class PowerParam:
     # XXX: PoweParam class should be removed, once we can fetch everything by system_id
@@ -77,6 +138,15 @@ class PowerParam:
     driver_type: str
     driver_opts: dict[str, Any]
     task_queue: str
+    # firmware boot mode boot source overrides are applied with,
+    # left to the BMC if not set
+    boot_mode: Optional[str] = None
+    # who asked for the power action (e.g. a MAAS user) and why, logged
+    # by the Agent with everything it does for the action
+    requester: Optional[str] = None
+    reason: Optional[str] = None
+    # ties the power action to the request of the caller
+    correlation_id: Optional[str] = None
 
 
 @dataclass
@@ -89,3 +159,17 @@ class PowerManyParam:
     # XXX: params property should be removed, once we can fetch everything by system_id
     # change to list[str] (list of system_ids)
     params: list[PowerParam]
+
+
+def get_boot_mode(bios_boot_method: Optional[str]) -> Optional[str]:
+    """
+    Return the firmware boot mode of a machine with the given BIOS boot
+    method, or None if it is not known to be UEFI or legacy.
+    """
+    match bios_boot_method:
+        case "uefi":
+            return BOOT_MODE_UEFI
+        case "pxe":
+            return BOOT_MODE_LEGACY
+        case _:
+            return None
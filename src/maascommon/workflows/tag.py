@@ -7,6 +7,8 @@ from lxml import etree
 
 # Workflows names
 TAG_EVALUATION_WORKFLOW_NAME = "tag-evaluation"
+# Executed by the Agent once resources of a commissioned machine are known
+TAG_MACHINE_WORKFLOW_NAME = "tag-machine"
 
 """Based on a study on the performance of the tag evaluation query, the time
 estimated for comparing a XPath expression over ten of thousands of nodes can be
@@ -1352,6 +1352,7 @@ def initialize_image_storage(region: RegionController):
         lfile = res.local_file()
         if lfile.complete:
             expected_files.add(lfile.path)
+            expected_files.add(lfile.compressed_file_path)
 
             if (
                 res.filetype == BOOT_RESOURCE_FILE_TYPE.ARCHIVE_TAR_XZ
@@ -17,6 +17,7 @@ from socket import gethostname
 
 from django import forms
 from django.core.exceptions import ValidationError
+from netaddr import AddrFormatError, IPNetwork
 
 from maasserver.bootresources import IMPORT_RESOURCES_SERVICE_PERIOD
 from maasserver.enum import INTERFACE_LINK_TYPE_CHOICES
@@ -287,6 +288,50 @@ def validate_ipmi_k_g(value):
         )
 
 
+# Services that can be set for a subnet in "subnet_services"
+SUBNET_SERVICES = ("images", "proxies", "dns_servers")
+
+
+def validate_subnet_services(value):
+    """
+    Ensure that the subnet services mapping maps subnet CIDRs to lists of
+    images, proxies and DNS servers.
+    """
+    if not isinstance(value, dict):
+        raise ValidationError("Subnet services must map subnets to services.")
+    for cidr, services in value.items():
+        try:
+            IPNetwork(cidr)
+        except (AddrFormatError, ValueError):
+            raise ValidationError(f"Invalid subnet: {cidr}")
+        if not isinstance(services, dict):
+            raise ValidationError(f"Services of {cidr} must be a mapping.")
+        for name, items in services.items():
+            if name not in SUBNET_SERVICES:
+                raise ValidationError(f"Unknown service of {cidr}: {name}")
+            if not isinstance(items, list) or not all(
+                isinstance(item, str) for item in items
+            ):
+                raise ValidationError(
+                    f"{name} of {cidr} must be a list of strings."
+                )
+
+
+def validate_rack_dns_zones(value):
+    """
+    Ensure that the rack DNS zones mapping maps subnet CIDRs to zone names.
+    """
+    if not isinstance(value, dict):
+        raise ValidationError("Rack DNS zones must map subnets to zones.")
+    for cidr, zone in value.items():
+        try:
+            IPNetwork(cidr)
+        except (AddrFormatError, ValueError):
+            raise ValidationError(f"Invalid subnet: {cidr}")
+        if not isinstance(zone, str) or not zone.strip("."):
+            raise ValidationError(f"Zone of {cidr} must be a domain name.")
+
+
 def make_ipmi_k_g_field(*args, **kwargs):
     field = forms.CharField(
         validators=[validate_ipmi_k_g],
@@ -471,6 +516,40 @@ CONFIG_ITEMS = {
             ),
         },
     },
+    "subnet_services": {
+        "default": None,
+        "form": forms.JSONField,
+        "form_kwargs": {
+            "label": "Services of provisioning subnets",
+            "required": False,
+            "validators": [validate_subnet_services],
+            "help_text": normalise_whitespace(
+                """\
+                Images, Region proxies and DNS servers machines in a subnet
+                should use, e.g. {"10.0.0.0/24": {"images": ["ubuntu/noble"],
+                "proxies": ["http://10.0.0.2:5240/MAAS/"]}}. Services that
+                aren't set aren't restricted, DNS servers default to the
+                ones of the subnet.
+            """
+            ),
+        },
+    },
+    "rack_dns_zones": {
+        "default": None,
+        "form": forms.JSONField,
+        "form_kwargs": {
+            "label": "Rack-local DNS zones of provisioning subnets",
+            "required": False,
+            "validators": [validate_rack_dns_zones],
+            "help_text": normalise_whitespace(
+                """\
+                Domains in which rack controllers serving a subnet publish
+                their metadata, boot and syslog services as SRV records,
+                e.g. {"10.0.0.0/24": "rack1.maas"}.
+            """
+            ),
+        },
+    },
     "ntp_servers": {
         "default": None,
         "form": HostListFormField,
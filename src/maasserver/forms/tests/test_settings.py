@@ -1,6 +1,7 @@
 # Copyright 2013-2016 Canonical Ltd.  This software is licensed under the
 # GNU Affero General Public License version 3 (see the file LICENSE).
 
+import json
 from textwrap import dedent
 
 from django import forms
@@ -161,3 +162,61 @@ class TestIPMIKGConfigSettings(MAASServerTestCase):
         input_value = factory.make_hex_string(40)
         field = get_config_field("maas_auto_ipmi_k_g_bmc_key")
         self.assertRaises(ValidationError, field.clean, input_value)
+
+
+class TestSubnetServicesConfigSettings(MAASServerTestCase):
+    def test_default_value(self):
+        form = get_config_form("subnet_services")
+        self.assertEqual({"subnet_services": None}, form.initial)
+
+    def test_valid_input(self):
+        value = {
+            "10.0.0.0/24": {
+                "images": ["ubuntu/noble"],
+                "proxies": ["http://10.0.0.2:5240/MAAS/"],
+            },
+            "fd00::/64": {"dns_servers": ["fd00::53"]},
+        }
+        field = get_config_field("subnet_services")
+        self.assertEqual(value, field.clean(json.dumps(value)))
+
+    def test_invalid_subnet(self):
+        field = get_config_field("subnet_services")
+        self.assertRaises(
+            ValidationError, field.clean, '{"10.0.0/33": {"images": []}}'
+        )
+
+    def test_invalid_service(self):
+        field = get_config_field("subnet_services")
+        self.assertRaises(
+            ValidationError, field.clean, '{"10.0.0.0/24": {"ntp": []}}'
+        )
+
+    def test_invalid_services_list(self):
+        field = get_config_field("subnet_services")
+        self.assertRaises(
+            ValidationError,
+            field.clean,
+            '{"10.0.0.0/24": {"images": "ubuntu/noble"}}',
+        )
+
+
+class TestRackDNSZonesConfigSettings(MAASServerTestCase):
+    def test_default_value(self):
+        form = get_config_form("rack_dns_zones")
+        self.assertEqual({"rack_dns_zones": None}, form.initial)
+
+    def test_valid_input(self):
+        value = {"10.0.0.0/24": "rack1.maas", "fd00::/64": "rack1.maas."}
+        field = get_config_field("rack_dns_zones")
+        self.assertEqual(value, field.clean(json.dumps(value)))
+
+    def test_invalid_subnet(self):
+        field = get_config_field("rack_dns_zones")
+        self.assertRaises(
+            ValidationError, field.clean, '{"10.0.0/33": "rack1.maas"}'
+        )
+
+    def test_invalid_zone(self):
+        field = get_config_field("rack_dns_zones")
+        self.assertRaises(ValidationError, field.clean, '{"10.0.0.0/24": 1}')
@@ -76,6 +76,8 @@ def get_default_config():
         "upstream_dns": None,
         "dnssec_validation": "auto",
         "dns_trusted_acl": None,
+        "subnet_services": None,
+        "rack_dns_zones": None,
         "maas_internal_domain": "maas-internal",
         # NTP settings
         "ntp_servers": "ntp.ubuntu.com",
@@ -26,6 +26,7 @@ import socket
 from socket import gethostname
 from typing import List
 from urllib.parse import urlparse
+import uuid
 
 from crochet import TimeoutError
 from django.contrib.auth.models import User
@@ -75,7 +76,7 @@ from twisted.python.failure import Failure
 from twisted.python.threadable import isInIOThread
 
 from maascommon.workflows.deploy import DEPLOY_MANY_WORKFLOW_NAME
-from maascommon.workflows.power import PowerParam
+from maascommon.workflows.power import get_boot_mode, PowerParam
 from maasserver.clusterrpc.pods import decompose_machine
 from maasserver.clusterrpc.power import (
     power_driver_check,
@@ -5906,7 +5907,12 @@ class Node(CleanSave, TimestampedModel):
         NodeUserData.objects.set_user_data(self, user_data)
 
     def _temporal_deploy(
-        self, _, d: Deferred, power_info: PowerInfo, task_queue: str
+        self,
+        _,
+        d: Deferred,
+        power_info: PowerInfo,
+        task_queue: str,
+        requester: str | None = None,
     ) -> Deferred:
         dd = start_workflow(
             DEPLOY_MANY_WORKFLOW_NAME,
@@ -5919,6 +5925,10 @@ class Node(CleanSave, TimestampedModel):
                             driver_type=str(power_info.power_type),
                             driver_opts=dict(power_info.power_parameters),
                             task_queue=task_queue,
+                            boot_mode=get_boot_mode(self.bios_boot_method),
+                            requester=requester,
+                            reason="deploy",
+                            correlation_id=str(uuid.uuid4()),
                         ),
                         ephemeral_deploy=bool(self.ephemeral_deploy),
                         can_set_boot_order=bool(power_info.can_set_boot_order),
@@ -5993,7 +6003,13 @@ class Node(CleanSave, TimestampedModel):
             needs_power_call = False
             task_queue = str(get_temporal_task_queue_for_bmc(self))
 
-            d.addCallback(self._temporal_deploy, d, power_info, task_queue)
+            d.addCallback(
+                self._temporal_deploy,
+                d,
+                power_info,
+                task_queue,
+                user.username,
+            )
 
         elif self.status in COMMISSIONING_LIKE_STATUSES:
             if old_status is None:
@@ -6021,7 +6037,13 @@ class Node(CleanSave, TimestampedModel):
 
             task_queue = str(get_temporal_task_queue_for_bmc(self))
 
-            d.addCallback(self._temporal_deploy, d, power_info, task_queue)
+            d.addCallback(
+                self._temporal_deploy,
+                d,
+                power_info,
+                task_queue,
+                user.username,
+            )
         else:
             set_deployment_timeout = False
 
@@ -6040,11 +6062,19 @@ class Node(CleanSave, TimestampedModel):
             # Request that the node be powered on post-commit.
             if self.power_state == POWER_STATE.ON and allow_power_cycle:
                 d = self._power_control_node(
-                    d, POWER_WORKFLOW_ACTIONS.CYCLE, power_info, boot_order
+                    d,
+                    POWER_WORKFLOW_ACTIONS.CYCLE,
+                    power_info,
+                    boot_order,
+                    user=user,
                 )
             else:
                 d = self._power_control_node(
-                    d, POWER_WORKFLOW_ACTIONS.ON, power_info, boot_order
+                    d,
+                    POWER_WORKFLOW_ACTIONS.ON,
+                    power_info,
+                    boot_order,
+                    user=user,
                 )
 
         # Set the deployment timeout so the node is marked failed after
@@ -6124,7 +6154,7 @@ class Node(CleanSave, TimestampedModel):
         # Request that the node be powered off post-commit.
         d = post_commit()
         return self._power_control_node(
-            d, POWER_WORKFLOW_ACTIONS.OFF, power_info, boot_order
+            d, POWER_WORKFLOW_ACTIONS.OFF, power_info, boot_order, user=user
         )
 
     @asynchronous
@@ -6202,7 +6232,7 @@ class Node(CleanSave, TimestampedModel):
         return d
 
     def _power_control_node(
-        self, defer, power_method_name, power_info, order=None
+        self, defer, power_method_name, power_info, order=None, user=None
     ):
         # Check if the BMC is accessible. If not we need to do some work to
         # make sure we can determine which rack controller can power
@@ -6296,6 +6326,7 @@ class Node(CleanSave, TimestampedModel):
                         power_method_name.replace("_", "-"),
                         self,
                         power_info,
+                        user.username if user is not None else None,
                     ),
                 )
 
@@ -6305,6 +6336,7 @@ class Node(CleanSave, TimestampedModel):
                     try:
                         res = yield execute_workflow(
                             workflow_name,
+                            workflow_id=workflow_param.correlation_id,
                             task_queue="region",
                             param=workflow_param,
                         )
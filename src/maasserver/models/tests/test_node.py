@@ -2695,7 +2695,7 @@ class TestNode(MAASServerTestCase):
 
         expected_power_info = node.get_effective_power_info()
         node._power_control_node.assert_called_once_with(
-            d, "power_off", expected_power_info, []
+            d, "power_off", expected_power_info, [], user=None
         )
 
     def test_release_node_that_has_power_on_and_uncontrolled_power_type(self):
@@ -10298,7 +10298,7 @@ class TestNode_Stop(MAASServerTestCase):
         expected_power_info = node.get_effective_power_info()
         expected_power_info.power_parameters["power_off_mode"] = stop_mode
         mock_power_control.assert_called_once_with(
-            d, "power_off", expected_power_info, []
+            d, "power_off", expected_power_info, [], user=admin
         )
 
     def test_stop_allows_no_user(self):
@@ -10311,7 +10311,7 @@ class TestNode_Stop(MAASServerTestCase):
         expected_power_info = node.get_effective_power_info()
         expected_power_info.power_parameters["power_off_mode"] = stop_mode
         mock_power_control.assert_called_once_with(
-            d, "power_off", expected_power_info, []
+            d, "power_off", expected_power_info, [], user=None
         )
 
 
@@ -95,6 +95,10 @@ class TestRegionHTTPService(
         self.assertIn("ssl_certificate cert_path;", nginx_config)
         self.assertIn("ssl_certificate_key key_path;", nginx_config)
         self.assertIn(f"root {boot_resources_dir};", nginx_config)
+        self.assertIn(
+            "try_files /$1$boot_resources_zstd_suffix /$1 /$1/$2 =404;",
+            nginx_config,
+        )
 
         nginx_stream_config = nginx_stream_conf.read_text()
         self.assertIn("listen 5242;", nginx_stream_config)
@@ -146,6 +150,10 @@ class TestRegionHTTPService(
         self.assertIn("ssl_certificate cert_path;", nginx_config)
         self.assertIn("ssl_certificate_key key_path;", nginx_config)
         self.assertIn(f"root {boot_resources_dir};", nginx_config)
+        self.assertIn(
+            "try_files /$1$boot_resources_zstd_suffix /$1 /$1/$2 =404;",
+            nginx_config,
+        )
 
         nginx_stream_config = nginx_stream_conf.read_text()
         self.assertIn("listen 5242;", nginx_stream_config)
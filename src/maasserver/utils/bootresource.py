@@ -4,12 +4,14 @@
 """Utilities for working with local boot resources."""
 from __future__ import annotations
 
+import asyncio
 from contextlib import asynccontextmanager, contextmanager
 import fcntl
 import hashlib
 import mmap
 import os
 from pathlib import Path
+import shutil
 import tarfile
 from tempfile import NamedTemporaryFile
 from typing import BinaryIO
@@ -22,6 +24,14 @@ CHUNK_SIZE = 4 * (2**20)
 
 BOOTLOADERS_DIR = "bootloaders"
 
+# Compressed copies of files are served to clients that accept the zstd
+# content encoding, see regiond.nginx.conf.template
+ZSTD_SUFFIX = ".zst"
+
+# Compressed copies saving less than this are not worth keeping, e.g. for
+# squashfs images with an already compressed filesystem
+MIN_COMPRESSION_RATIO = 0.9
+
 
 class LocalStoreWriteBeyondEOF(Exception):
     """Attempt to write beyond EOF"""
@@ -123,6 +133,15 @@ class LocalBootResourceFile:
         """
         return self._base_path.with_suffix(".incomplete")
 
+    @property
+    def compressed_file_path(self) -> Path:
+        """The zstd-compressed copy of the file
+
+        Returns:
+            Path: Path object
+        """
+        return self._base_path.with_name(self._base_path.name + ZSTD_SUFFIX)
+
     def _get_file_path(self) -> Path | None:
         for p in [self.path, self.partial_file_path]:
             if p.exists():
@@ -193,6 +212,47 @@ class LocalBootResourceFile:
             self.partial_file_path.rename(self.path)
         return True
 
+    async def acompress(self) -> bool:
+        """Write the zstd-compressed copy of the complete file, unless it
+        already exists. The `zstd` command is used if it is installed.
+
+        Returns:
+            bool: Whether the compressed copy is available
+        """
+        if self.compressed_file_path.exists():
+            return True
+        zstd = shutil.which("zstd")
+        if zstd is None or not self.path.exists():
+            return False
+
+        tmp = self.compressed_file_path.with_name(
+            self.compressed_file_path.name + ".incomplete"
+        )
+        proc = await asyncio.create_subprocess_exec(
+            zstd,
+            "--quiet",
+            "--force",
+            "-T0",
+            "-o",
+            str(tmp),
+            str(self.path),
+        )
+        try:
+            returncode = await proc.wait()
+        except asyncio.CancelledError:
+            proc.kill()
+            await proc.wait()
+            tmp.unlink(missing_ok=True)
+            raise
+        if (
+            returncode != 0
+            or tmp.stat().st_size > self.total_size * MIN_COMPRESSION_RATIO
+        ):
+            tmp.unlink(missing_ok=True)
+            return False
+        tmp.rename(self.compressed_file_path)
+        return True
+
     def allocate(self):
         """Allocates disk space for this file"""
         if self._size > 0:
@@ -272,7 +332,11 @@ class LocalBootResourceFile:
     def unlink(self):
         """Removes the file from local disk"""
         self._size = 0
-        for p in [self.path, self.partial_file_path]:
+        for p in [
+            self.path,
+            self.partial_file_path,
+            self.compressed_file_path,
+        ]:
             p.unlink(missing_ok=True)
 
     @property
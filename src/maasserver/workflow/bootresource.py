@@ -203,6 +203,7 @@ class BootResourcesActivity(MAASAPIClient):
             if await lfile.avalid():
                 activity.logger.info("file already downloaded, skipping")
                 lfile.commit()
+                await self._compress(lfile)
                 for target in param.extract_paths:
                     lfile.extract_file(target)
                     activity.heartbeat()
@@ -230,6 +231,7 @@ class BootResourcesActivity(MAASAPIClient):
             if await lfile.avalid():
                 lfile.commit()
                 activity.logger.debug(f"file commited {lfile.size}")
+                await self._compress(lfile)
 
                 for target in param.extract_paths:
                     lfile.extract_file(target)
@@ -264,6 +266,26 @@ class BootResourcesActivity(MAASAPIClient):
         finally:
             lfile.release_lock()
 
+    async def _compress(self, lfile: LocalBootResourceFile) -> None:
+        """Compress the file for clients accepting zstd, e.g. Agents"""
+        task = asyncio.create_task(lfile.acompress())
+        try:
+            while not task.done():
+                activity.heartbeat()
+                await asyncio.wait(
+                    [task], timeout=HEARTBEAT_TIMEOUT.total_seconds() / 2
+                )
+        finally:
+            task.cancel()
+        # the uncompressed file is still served, e.g. when out of disk space
+        try:
+            compressed = task.result()
+        except OSError as ex:
+            activity.logger.warning(f"failed to compress file: {ex}")
+            return
+        if not compressed:
+            activity.logger.debug("file not compressed")
+
     @activity.defn(name=DELETE_BOOTRESOURCEFILE_ACTIVITY_NAME)
     async def delete_bootresourcefile(
         self, param: ResourceDeleteParam
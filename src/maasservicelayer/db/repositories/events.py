@@ -3,14 +3,20 @@
 
 from typing import Any, Type
 
-from sqlalchemy import case, select, Select, Table
+from sqlalchemy import case, insert, select, Select, Table
+from sqlalchemy.dialects.postgresql import insert as pg_insert
 from sqlalchemy.sql.expression import func
 from sqlalchemy.sql.operators import eq, ne, or_
 
 from maasservicelayer.db.filters import Clause, ClauseFactory
 from maasservicelayer.db.repositories.base import BaseRepository
 from maasservicelayer.db.tables import EventTable, EventTypeTable, NodeTable
-from maasservicelayer.models.events import Event
+from maasservicelayer.models.events import (
+    EndpointChoicesEnum,
+    Event,
+    LoggingLevelEnum,
+)
+from maasservicelayer.utils.date import utcnow
 
 
 class EventsClauseFactory(ClauseFactory):
@@ -83,3 +89,53 @@ class EventsRepository(BaseRepository[Event]):
                 isouter=True,
             )
         )
+
+    async def ensure_event_type(
+        self, name: str, description: str, level: LoggingLevelEnum
+    ) -> int:
+        """Return the id of the event type `name`, creating it if needed."""
+        now = utcnow()
+        stmt = (
+            pg_insert(EventTypeTable)
+            .values(
+                created=now,
+                updated=now,
+                name=name,
+                description=description,
+                level=level.value,
+            )
+            .on_conflict_do_nothing(index_elements=[EventTypeTable.c.name])
+        )
+        await self.connection.execute(stmt)
+        stmt = select(EventTypeTable.c.id).where(
+            eq(EventTypeTable.c.name, name)
+        )
+        return (await self.connection.execute(stmt)).scalar_one()
+
+    async def create_node_event(
+        self, system_id: str, type_id: int, description: str, action: str
+    ) -> None:
+        """
+        Create an event of the node `system_id`. The event is kept even if
+        the node is unknown (e.g. it was deleted), like the events of
+        deleted nodes are.
+        """
+        stmt = select(NodeTable.c.id, NodeTable.c.hostname).where(
+            eq(NodeTable.c.system_id, system_id)
+        )
+        node = (await self.connection.execute(stmt)).one_or_none()
+        now = utcnow()
+        stmt = insert(EventTable).values(
+            created=now,
+            updated=now,
+            type_id=type_id,
+            node_id=node.id if node else None,
+            node_hostname=node.hostname if node else "",
+            node_system_id=system_id,
+            description=description,
+            action=action,
+            username="",
+            user_agent="",
+            endpoint=EndpointChoicesEnum.API.value,
+        )
+        await self.connection.execute(stmt)
@@ -1,6 +1,7 @@
 #  Copyright 2024 Canonical Ltd.  This software is licensed under the
 #  GNU Affero General Public License version 3 (see the file LICENSE).
 
+from datetime import datetime
 from typing import Any, Type
 
 from sqlalchemy import ColumnElement, Select, select, Table, update
@@ -23,6 +24,16 @@ class NodeResourceBuilder(ResourceBuilder):
         self._request.set_value(NodeTable.c.status.name, status)
         return self
 
+    def with_power_state(self, power_state: str) -> "NodeResourceBuilder":
+        self._request.set_value(NodeTable.c.power_state.name, power_state)
+        return self
+
+    def with_power_state_updated(
+        self, value: datetime
+    ) -> "NodeResourceBuilder":
+        self._request.set_value(NodeTable.c.power_state_updated.name, value)
+        return self
+
 
 class NodesRepository(BaseRepository[Node]):
 
@@ -83,6 +83,99 @@ BMCTable = Table(
     Column("created_by_commissioning", Boolean, nullable=True),
 )
 
+BMCRoutableRackControllerRelationshipTable = Table(
+    "maasserver_bmcroutablerackcontrollerrelationship",
+    METADATA,
+    Column("id", BigInteger, primary_key=True, unique=True),
+    Column("created", DateTime(timezone=True), nullable=False),
+    Column("updated", DateTime(timezone=True), nullable=False),
+    Column("routable", Boolean, nullable=False),
+    Column(
+        "bmc_id", BigInteger, ForeignKey("maasserver_bmc.id"), nullable=False
+    ),
+    Column(
+        "rack_controller_id",
+        BigInteger,
+        ForeignKey("maasserver_node.id"),
+        nullable=False,
+    ),
+)
+
+BootResourceFileSyncTable = Table(
+    "maasserver_bootresourcefilesync",
+    METADATA,
+    Column("id", BigInteger, primary_key=True, unique=True),
+    Column("created", DateTime(timezone=True), nullable=False),
+    Column("updated", DateTime(timezone=True), nullable=False),
+    Column("size", BigInteger, nullable=False),
+    Column(
+        "file_id",
+        BigInteger,
+        ForeignKey("maasserver_bootresourcefile.id"),
+        nullable=False,
+    ),
+    Column(
+        "region_id",
+        BigInteger,
+        ForeignKey("maasserver_node.id"),
+        nullable=False,
+    ),
+)
+
+BootResourceFileTable = Table(
+    "maasserver_bootresourcefile",
+    METADATA,
+    Column("id", BigInteger, primary_key=True, unique=True),
+    Column("created", DateTime(timezone=True), nullable=False),
+    Column("updated", DateTime(timezone=True), nullable=False),
+    Column("filename", String(255), nullable=False),
+    Column("filetype", String(20), nullable=False),
+    Column("extra", JSONB, nullable=False),
+    Column("largefile_id", Integer, nullable=True),
+    Column(
+        "resource_set_id",
+        BigInteger,
+        ForeignKey("maasserver_bootresourceset.id"),
+        nullable=False,
+    ),
+    Column("sha256", String(64), nullable=False),
+    Column("size", BigInteger, nullable=False),
+    Column("filename_on_disk", String(64), nullable=False),
+)
+
+BootResourceSetTable = Table(
+    "maasserver_bootresourceset",
+    METADATA,
+    Column("id", BigInteger, primary_key=True, unique=True),
+    Column("created", DateTime(timezone=True), nullable=False),
+    Column("updated", DateTime(timezone=True), nullable=False),
+    Column("version", String(255), nullable=False),
+    Column("label", String(255), nullable=False),
+    Column(
+        "resource_id",
+        BigInteger,
+        ForeignKey("maasserver_bootresource.id"),
+        nullable=False,
+    ),
+)
+
+BootResourceTable = Table(
+    "maasserver_bootresource",
+    METADATA,
+    Column("id", BigInteger, primary_key=True, unique=True),
+    Column("created", DateTime(timezone=True), nullable=False),
+    Column("updated", DateTime(timezone=True), nullable=False),
+    Column("rtype", Integer, nullable=False),
+    Column("name", String(255), nullable=False),
+    Column("architecture", String(255), nullable=False),
+    Column("extra", JSONB, nullable=False),
+    Column("kflavor", String(32), nullable=True),
+    Column("bootloader_type", String(32), nullable=True),
+    Column("rolling", Boolean, nullable=False),
+    Column("base_image", String(255), nullable=False),
+    Column("alias", String(255), nullable=True),
+)
+
 ConfigTable = Table(
     "maasserver_config",
     METADATA,
@@ -115,6 +208,23 @@ DefaultResourceTable = Table(
     ),
 )
 
+DNSDataTable = Table(
+    "maasserver_dnsdata",
+    METADATA,
+    Column("id", BigInteger, primary_key=True, unique=True),
+    Column("created", DateTime(timezone=True), nullable=False),
+    Column("updated", DateTime(timezone=True), nullable=False),
+    Column(
+        "dnsresource_id",
+        BigInteger,
+        ForeignKey("maasserver_dnsresource.id"),
+        nullable=False,
+    ),
+    Column("ttl", Integer, nullable=True),
+    Column("rrtype", String(8), nullable=False),
+    Column("rrdata", Text, nullable=False),
+)
+
 DNSPublicationTable = Table(
     "maasserver_dnspublication",
     METADATA,
@@ -366,6 +476,19 @@ NodeDeviceVpdTable = Table(
     ),
 )
 
+NodeMetadataTable = Table(
+    "maasserver_nodemetadata",
+    METADATA,
+    Column("id", BigInteger, primary_key=True, unique=True),
+    Column("created", DateTime(timezone=True), nullable=False),
+    Column("updated", DateTime(timezone=True), nullable=False),
+    Column("key", String(64), nullable=False),
+    Column("value", Text, nullable=False),
+    Column(
+        "node_id", BigInteger, ForeignKey("maasserver_node.id"), nullable=False
+    ),
+)
+
 NodeTable = Table(
     "maasserver_node",
     METADATA,
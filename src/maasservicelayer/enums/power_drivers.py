@@ -21,6 +21,7 @@ class PowerTypeEnum(str, Enum):
     RECS = "recs_box"
     REDFISH = "redfish"
     SEAMICRO = "sm15k"
+    SERVERTECH = "servertech"
     UCSM = "ucsm"
     VIRSH = "virsh"
     VMWARE = "vmware"
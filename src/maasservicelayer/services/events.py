@@ -1,14 +1,87 @@
 #  Copyright 2024 Canonical Ltd.  This software is licensed under the
 #  GNU Affero General Public License version 3 (see the file LICENSE).
 
+from typing import NamedTuple
+
+from maascommon.enums.events import EventTypeEnum
 from maasservicelayer.context import Context
 from maasservicelayer.db.filters import QuerySpec
 from maasservicelayer.db.repositories.events import EventsRepository
 from maasservicelayer.models.base import ListResult
-from maasservicelayer.models.events import Event
+from maasservicelayer.models.events import Event, LoggingLevelEnum
 from maasservicelayer.services._base import Service
 
 
+class EventDetail(NamedTuple):
+    description: str
+    level: LoggingLevelEnum
+
+
+EVENT_DETAILS = {
+    EventTypeEnum.AGENT_FILESYSTEM_HEALTHY: EventDetail(
+        description="Filesystem healthy", level=LoggingLevelEnum.DEBUG
+    ),
+    EventTypeEnum.AGENT_FILESYSTEM_UNHEALTHY: EventDetail(
+        description="Filesystem unhealthy", level=LoggingLevelEnum.ERROR
+    ),
+    EventTypeEnum.AGENT_LATENCY_OBJECTIVE_BREACHED: EventDetail(
+        description="Latency objective breached",
+        level=LoggingLevelEnum.WARNING,
+    ),
+    EventTypeEnum.AGENT_LATENCY_OBJECTIVE_MET: EventDetail(
+        description="Latency objective met", level=LoggingLevelEnum.INFO
+    ),
+    EventTypeEnum.AGENT_REMEDIATION_RULE_TRIGGERED: EventDetail(
+        description="Remediation rule triggered",
+        level=LoggingLevelEnum.WARNING,
+    ),
+    EventTypeEnum.AGENT_DHCP_FAILOVER_HEALTHY: EventDetail(
+        description="DHCP failover healthy", level=LoggingLevelEnum.INFO
+    ),
+    EventTypeEnum.AGENT_DHCP_FAILOVER_UNHEALTHY: EventDetail(
+        description="DHCP failover unhealthy", level=LoggingLevelEnum.ERROR
+    ),
+    EventTypeEnum.AGENT_DHCP_FAILOVER_PROMOTED: EventDetail(
+        description="DHCP failover peer promoted",
+        level=LoggingLevelEnum.WARNING,
+    ),
+    # same as in provisioningserver.events
+    EventTypeEnum.NODE_POWERED_ON: EventDetail(
+        description="Node powered on", level=LoggingLevelEnum.DEBUG
+    ),
+    EventTypeEnum.NODE_POWERED_OFF: EventDetail(
+        description="Node powered off", level=LoggingLevelEnum.DEBUG
+    ),
+    EventTypeEnum.NODE_PORT_UP: EventDetail(
+        description="Switch port up", level=LoggingLevelEnum.INFO
+    ),
+    EventTypeEnum.NODE_PORT_DOWN: EventDetail(
+        description="Switch port down", level=LoggingLevelEnum.WARNING
+    ),
+    EventTypeEnum.NODE_SNMP_TRAP: EventDetail(
+        description="SNMP trap", level=LoggingLevelEnum.INFO
+    ),
+    EventTypeEnum.NODE_POWER_ANOMALY: EventDetail(
+        description="Power anomaly", level=LoggingLevelEnum.WARNING
+    ),
+    EventTypeEnum.NODE_ANNOTATED: EventDetail(
+        description="Node annotated", level=LoggingLevelEnum.INFO
+    ),
+    EventTypeEnum.NODE_ANNOTATIONS_CLEARED: EventDetail(
+        description="Node annotations cleared", level=LoggingLevelEnum.INFO
+    ),
+    EventTypeEnum.NODE_BURN_IN_PASSED: EventDetail(
+        description="Burn-in passed", level=LoggingLevelEnum.INFO
+    ),
+    EventTypeEnum.NODE_BURN_IN_FAILED: EventDetail(
+        description="Burn-in failed", level=LoggingLevelEnum.ERROR
+    ),
+    EventTypeEnum.NODE_IMAGE_CAPTURED: EventDetail(
+        description="Image captured", level=LoggingLevelEnum.INFO
+    ),
+}
+
+
 class EventsService(Service):
     def __init__(
         self,
@@ -28,3 +101,21 @@ class EventsService(Service):
         return await self.events_repository.list(
             token=token, size=size, query=query
         )
+
+    async def record_node_event(
+        self,
+        system_id: str,
+        event_type: EventTypeEnum,
+        description: str,
+        action: str = "",
+    ) -> None:
+        detail = EVENT_DETAILS[event_type]
+        type_id = await self.events_repository.ensure_event_type(
+            event_type.value, detail.description, detail.level
+        )
+        await self.events_repository.create_node_event(
+            system_id=system_id,
+            type_id=type_id,
+            description=description,
+            action=action,
+        )
@@ -12,6 +12,9 @@ from maasserver.workflow.worker import Worker as TemporalWorker
 from maasservicelayer.db import Database
 from maasservicelayer.logging.configure import configure_logging
 from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.bootresource import BootResourceActivity
+from maastemporalworker.workflow.burnin import BurnInActivity
+from maastemporalworker.workflow.certificate import AgentCertificateActivity
 from maastemporalworker.workflow.commission import CommissionNWorkflow
 from maastemporalworker.workflow.configure import (
     ConfigureAgentActivity,
@@ -31,6 +34,9 @@ from maastemporalworker.workflow.dns import (
     ConfigureDNSWorkflow,
     DNSConfigActivity,
 )
+from maastemporalworker.workflow.dnspub import DNSPublicationActivity
+from maastemporalworker.workflow.ephemeral import EphemeralBootActivity
+from maastemporalworker.workflow.imagecapture import ImageCaptureActivity
 from maastemporalworker.workflow.msm import (
     MSMConnectorActivity,
     MSMEnrolSiteWorkflow,
@@ -38,17 +44,24 @@ from maastemporalworker.workflow.msm import (
     MSMTokenRefreshWorkflow,
     MSMWithdrawWorkflow,
 )
+from maastemporalworker.workflow.phonehome import PhoneHomeActivity
 from maastemporalworker.workflow.power import (
+    PowerActivity,
     PowerCycleWorkflow,
     PowerManyWorkflow,
     PowerOffWorkflow,
+    PowerOnTemporarilyWorkflow,
     PowerOnWorkflow,
     PowerQueryWorkflow,
+    PowerResetWorkflow,
+    SetBootDeviceWorkflow,
 )
+from maastemporalworker.workflow.subnet import SubnetServicesActivity
 from maastemporalworker.workflow.tag_evaluation import (
     TagEvaluationActivity,
     TagEvaluationWorkflow,
 )
+from maastemporalworker.workflow.tagging import MachineTaggingActivity
 from provisioningserver.utils.env import MAAS_ID
 
 log = structlog.getLogger()
@@ -84,12 +97,22 @@ async def main() -> None:
     maas_id = MAAS_ID.get()
 
     services_cache = CacheForServices()
+    bootresource_activity = BootResourceActivity(db, services_cache)
+    burn_in_activity = BurnInActivity(db, services_cache)
+    certificate_activity = AgentCertificateActivity(db, services_cache)
     configure_activity = ConfigureAgentActivity(db, services_cache)
     msm_activity = MSMConnectorActivity(db, services_cache)
     tag_evaluation_activity = TagEvaluationActivity(db, services_cache)
+    tagging_activity = MachineTaggingActivity(db, services_cache)
     deploy_activity = DeployActivity(db, services_cache)
     dhcp_activity = DHCPConfigActivity(db, services_cache)
     dns_activity = DNSConfigActivity(db, services_cache)
+    dns_publication_activity = DNSPublicationActivity(db, services_cache)
+    ephemeral_boot_activity = EphemeralBootActivity(db, services_cache)
+    image_capture_activity = ImageCaptureActivity(db, services_cache)
+    phone_home_activity = PhoneHomeActivity(db, services_cache)
+    power_activity = PowerActivity(db, services_cache)
+    subnet_services_activity = SubnetServicesActivity(db, services_cache)
 
     temporal_workers = [
         # All regions listen to a shared task queue. The first to pick up a task will execute it.
@@ -115,14 +138,26 @@ async def main() -> None:
                 PowerOffWorkflow,
                 PowerCycleWorkflow,
                 PowerQueryWorkflow,
+                PowerResetWorkflow,
+                PowerOnTemporarilyWorkflow,
+                SetBootDeviceWorkflow,
                 PowerManyWorkflow,
                 # Tag Evaluation workflows
                 TagEvaluationWorkflow,
             ],
             activities=[
+                # Boot resources activities
+                bootresource_activity.get_boot_resources,
+                # Burn-in activities
+                burn_in_activity.set_machine_ephemeral_burn_in,
+                burn_in_activity.get_burn_in_results,
+                burn_in_activity.report_burn_in,
+                # Certificate activities
+                certificate_activity.issue_agent_certificate,
                 # Configuration activities
                 configure_activity.get_rack_controller_vlans,
                 configure_activity.get_region_controller_endpoints,
+                configure_activity.set_agent_roles,
                 # Deploy activities
                 deploy_activity.set_node_status,
                 deploy_activity.get_boot_order,
@@ -130,9 +165,19 @@ async def main() -> None:
                 dhcp_activity.find_agents_for_updates,
                 dhcp_activity.fetch_hosts_for_update,
                 dhcp_activity.get_omapi_key,
+                dhcp_activity.get_dhcp_failover_peers,
+                dhcp_activity.report_dhcp_failover_health,
                 # DNS activities
                 dns_activity.get_changes_since_current_serial,
                 dns_activity.get_region_controllers,
+                # DNS publication activities
+                dns_publication_activity.get_rack_dns_zones,
+                dns_publication_activity.publish_dns_records,
+                # Ephemeral boot activities
+                ephemeral_boot_activity.restore_machine_boot,
+                # Image capture activities
+                image_capture_activity.set_machine_ephemeral_capture,
+                image_capture_activity.register_captured_image,
                 # MSM connector activities,
                 msm_activity.check_enrol,
                 msm_activity.get_enrol,
@@ -142,8 +187,17 @@ async def main() -> None:
                 msm_activity.send_heartbeat,
                 msm_activity.set_enrol,
                 msm_activity.verify_token,
+                # Phone home activities
+                phone_home_activity.set_machine_phone_home,
+                # Power activities
+                power_activity.get_transitioning_machines,
+                power_activity.report_power_states,
+                # Subnet services activities
+                subnet_services_activity.get_subnet_services,
                 # Tag evaluation activities
                 tag_evaluation_activity.evaluate_tag,
+                # Tagging activities
+                tagging_activity.set_machine_tags,
             ],
         ),
         # Individual region controller worker
@@ -0,0 +1,208 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from collections import defaultdict
+from dataclasses import dataclass
+
+from netaddr import IPAddress
+from sqlalchemy import and_, select
+from sqlalchemy.ext.asyncio import AsyncConnection
+
+from maasservicelayer.db.tables import (
+    BootResourceFileSyncTable,
+    BootResourceFileTable,
+    BootResourceSetTable,
+    BootResourceTable,
+    InterfaceIPAddressTable,
+    InterfaceTable,
+    NodeTable,
+    StaticIPAddressTable,
+)
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.utils import activity_defn_with_context
+
+# Activities names
+# Executed on the Region by the Agent sync-boot-resources workflow
+GET_BOOT_RESOURCES_ACTIVITY_NAME = "get-boot-resources"
+
+
+# Activities parameters
+@dataclass
+class GetBootResourcesParam:
+    # system_id of the Agent
+    system_id: str
+
+
+@dataclass
+class BootResource:
+    # path of the file relative to the boot resources directory of the Agent
+    name: str
+    url: str
+    sha256: str
+    size: int
+
+
+@dataclass
+class GetBootResourcesResult:
+    resources: list[BootResource]
+
+
+def _resource_path(
+    name: str, architecture: str, label: str, filename: str
+) -> str:
+    """
+    Return the path of a boot resource file in the layout rack controllers
+    use, e.g. ubuntu/amd64/ga-24.04/noble/stable/squashfs
+    """
+    if "/" in name:
+        osystem, series = name.split("/", 1)
+    else:
+        # uploaded custom images have no OS in their name
+        osystem, series = "custom", name
+    arch, subarch = architecture.split("/", 1)
+    return f"{osystem}/{arch}/{subarch}/{series}/{label}/{filename}"
+
+
+def _format_endpoint(ip: str) -> str:
+    addr = IPAddress(ip)
+    if addr.version == 4:
+        return f"http://{ip}:5240/MAAS/boot-resources/"
+    return f"http://[{ip}]:5240/MAAS/boot-resources/"
+
+
+class BootResourceActivity(ActivityBase):
+    async def _get_region_endpoints(
+        self, tx: AsyncConnection, region_ids: set[int]
+    ) -> dict[int, str]:
+        """
+        Return the boot resources endpoint of each region controller.
+        """
+        stmt = (
+            select(NodeTable.c.id, StaticIPAddressTable.c.ip)
+            .select_from(NodeTable)
+            .join(
+                InterfaceTable,
+                InterfaceTable.c.node_config_id
+                == NodeTable.c.current_config_id,
+            )
+            .join(
+                InterfaceIPAddressTable,
+                InterfaceIPAddressTable.c.interface_id == InterfaceTable.c.id,
+            )
+            .join(
+                StaticIPAddressTable,
+                StaticIPAddressTable.c.id
+                == InterfaceIPAddressTable.c.staticipaddress_id,
+            )
+            .filter(
+                and_(
+                    NodeTable.c.id.in_(region_ids),
+                    StaticIPAddressTable.c.ip.is_not(None),
+                ),
+            )
+            .order_by(NodeTable.c.id, StaticIPAddressTable.c.id)
+        )
+        endpoints = {}
+        for region_id, ip in (await tx.execute(stmt)).all():
+            endpoints.setdefault(region_id, _format_endpoint(str(ip)))
+        return endpoints
+
+    @activity_defn_with_context(name=GET_BOOT_RESOURCES_ACTIVITY_NAME)
+    async def get_boot_resources(
+        self, param: GetBootResourcesParam
+    ) -> GetBootResourcesResult:
+        """
+        Return files of the newest complete set of each boot resource. A set
+        is complete once a region controller has a full copy of each of its
+        files, so the Agent can download them.
+        """
+        async with self._start_transaction() as tx:
+            stmt = (
+                select(
+                    BootResourceTable.c.id.label("resource_id"),
+                    BootResourceTable.c.name,
+                    BootResourceTable.c.architecture,
+                    BootResourceSetTable.c.id.label("set_id"),
+                    BootResourceSetTable.c.label,
+                    BootResourceFileTable.c.id.label("file_id"),
+                    BootResourceFileTable.c.filename,
+                    BootResourceFileTable.c.filename_on_disk,
+                    BootResourceFileTable.c.sha256,
+                    BootResourceFileTable.c.size,
+                    BootResourceFileSyncTable.c.region_id,
+                )
+                .select_from(BootResourceFileTable)
+                .join(
+                    BootResourceSetTable,
+                    BootResourceSetTable.c.id
+                    == BootResourceFileTable.c.resource_set_id,
+                )
+                .join(
+                    BootResourceTable,
+                    BootResourceTable.c.id
+                    == BootResourceSetTable.c.resource_id,
+                )
+                .outerjoin(
+                    BootResourceFileSyncTable,
+                    and_(
+                        BootResourceFileSyncTable.c.file_id
+                        == BootResourceFileTable.c.id,
+                        BootResourceFileSyncTable.c.size
+                        == BootResourceFileTable.c.size,
+                    ),
+                )
+                .order_by(
+                    BootResourceFileTable.c.id,
+                    BootResourceFileSyncTable.c.region_id,
+                )
+            )
+            rows = (await tx.execute(stmt)).all()
+
+            # regions with a full copy of each file
+            copies = defaultdict(list)
+            files = {}
+            for row in rows:
+                files.setdefault(row.file_id, row)
+                if row.region_id is not None:
+                    copies[row.file_id].append(row.region_id)
+
+            incomplete_sets = {
+                row.set_id for row in files.values() if not copies[row.file_id]
+            }
+            newest_sets = {}
+            for row in files.values():
+                if row.set_id in incomplete_sets:
+                    continue
+                newest_sets[row.resource_id] = max(
+                    newest_sets.get(row.resource_id, row.set_id), row.set_id
+                )
+
+            endpoints = await self._get_region_endpoints(
+                tx, {region_id for ids in copies.values() for region_id in ids}
+            )
+
+        resources = []
+        for row in files.values():
+            if newest_sets.get(row.resource_id) != row.set_id:
+                continue
+            endpoint = next(
+                (
+                    endpoints[region_id]
+                    for region_id in copies[row.file_id]
+                    if region_id in endpoints
+                ),
+                None,
+            )
+            if endpoint is None:
+                continue
+            resources.append(
+                BootResource(
+                    name=_resource_path(
+                        row.name, row.architecture, row.label, row.filename
+                    ),
+                    url=f"{endpoint}{row.filename_on_disk}",
+                    sha256=row.sha256,
+                    size=row.size,
+                )
+            )
+        return GetBootResourcesResult(resources=resources)
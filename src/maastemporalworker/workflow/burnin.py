@@ -0,0 +1,150 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from dataclasses import dataclass, field
+import json
+from typing import Any
+
+from maascommon.enums.events import EventTypeEnum
+from maascommon.enums.node import NodeStatus
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.ephemeral import (
+    boot_ephemeral,
+    get_ephemeral_boot,
+    get_machine_id,
+    get_metadata,
+    MachineParam,
+    set_ephemeral_boot,
+    set_metadata,
+)
+from maastemporalworker.workflow.utils import activity_defn_with_context
+
+# Activities names
+# Executed on the Region by the Agent burn-in-machine workflow
+SET_MACHINE_EPHEMERAL_BURN_IN_ACTIVITY_NAME = "set-machine-ephemeral-burn-in"
+GET_BURN_IN_RESULTS_ACTIVITY_NAME = "get-burn-in-results"
+REPORT_BURN_IN_ACTIVITY_NAME = "report-burn-in"
+
+# NodeMetadata keys of tests run by the ephemeral environment and of the
+# results it reports
+BURN_IN_TESTS_KEY = "burn_in_tests"
+BURN_IN_RESULTS_KEY = "burn_in_results"
+
+
+# Activities parameters
+@dataclass
+class EphemeralBurnInParam:
+    # system_id of the machine
+    system_id: str
+    # tests as defined by the Agent, passed to the ephemeral environment
+    tests: list[dict[str, Any]]
+
+
+@dataclass
+class GetBurnInResultsResult:
+    results: list[dict[str, Any]]
+    # whether every test has a result
+    completed: bool
+
+
+@dataclass
+class BurnInVerdict:
+    name: str
+    passed: bool
+    failures: list[str] = field(default_factory=list)
+
+
+@dataclass
+class BurnInMachineResult:
+    system_id: str
+    verdicts: list[BurnInVerdict]
+    results: list[dict[str, Any]]
+    # whether not all results were reported in time
+    timed_out: bool
+    passed: bool
+
+
+def merge_burn_in_results(
+    results: list[dict[str, Any]], reported: list[dict[str, Any]]
+) -> list[dict[str, Any]]:
+    """Return `results` with the `reported` ones, replacing them by name."""
+    by_name = {result["name"]: result for result in results}
+    by_name.update((result["name"], result) for result in reported)
+    return list(by_name.values())
+
+
+class BurnInActivity(ActivityBase):
+    @activity_defn_with_context(
+        name=SET_MACHINE_EPHEMERAL_BURN_IN_ACTIVITY_NAME
+    )
+    async def set_machine_ephemeral_burn_in(
+        self, param: EphemeralBurnInParam
+    ) -> None:
+        """
+        Make the machine boot the ephemeral environment for testing, which
+        runs `param.tests` and reports their results.
+        """
+        async with self._start_transaction() as tx:
+            node_id = await get_machine_id(tx, param.system_id)
+            await set_metadata(
+                tx, node_id, BURN_IN_TESTS_KEY, json.dumps(param.tests)
+            )
+            await set_metadata(tx, node_id, BURN_IN_RESULTS_KEY, "[]")
+            await boot_ephemeral(
+                tx,
+                node_id,
+                NodeStatus.TESTING,
+                [BURN_IN_TESTS_KEY, BURN_IN_RESULTS_KEY],
+            )
+
+    @activity_defn_with_context(name=GET_BURN_IN_RESULTS_ACTIVITY_NAME)
+    async def get_burn_in_results(
+        self, param: MachineParam
+    ) -> GetBurnInResultsResult:
+        async with self._start_transaction() as tx:
+            node_id = await get_machine_id(tx, param.system_id)
+            tests = json.loads(
+                await get_metadata(tx, node_id, BURN_IN_TESTS_KEY) or "[]"
+            )
+            results = json.loads(
+                await get_metadata(tx, node_id, BURN_IN_RESULTS_KEY) or "[]"
+            )
+
+        reported = {result["name"] for result in results}
+        return GetBurnInResultsResult(
+            results=results,
+            completed=all(test["name"] in reported for test in tests),
+        )
+
+    @activity_defn_with_context(name=REPORT_BURN_IN_ACTIVITY_NAME)
+    async def report_burn_in(self, param: BurnInMachineResult) -> None:
+        """
+        Record the verdict of the burn-in as an event of the machine. Failed
+        machines become Failed testing once their boot is restored, instead
+        of getting their previous status back.
+        """
+        if param.passed:
+            event_type = EventTypeEnum.NODE_BURN_IN_PASSED
+            description = f"{len(param.verdicts)} tests passed"
+        else:
+            event_type = EventTypeEnum.NODE_BURN_IN_FAILED
+            failures = [
+                f"{verdict.name}: {', '.join(verdict.failures)}"
+                for verdict in param.verdicts
+                if not verdict.passed
+            ]
+            if param.timed_out:
+                failures.insert(0, "timed out")
+            description = "; ".join(failures)
+
+        async with self._start_transaction() as tx:
+            node_id = await get_machine_id(tx, param.system_id)
+            boot = await get_ephemeral_boot(tx, node_id)
+            if not param.passed and boot is not None:
+                boot["status"] = NodeStatus.FAILED_TESTING
+                await set_ephemeral_boot(tx, node_id, boot)
+
+        async with self.start_transaction() as services:
+            await services.events.record_node_event(
+                param.system_id, event_type, description
+            )
@@ -0,0 +1,123 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from dataclasses import dataclass
+from datetime import timedelta
+import random
+
+from OpenSSL import crypto
+from sqlalchemy import and_, select
+from temporalio.exceptions import ApplicationError
+
+from maascommon.enums.node import NodeTypeEnum
+from maasservicelayer.db.tables import NodeTable
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.utils import activity_defn_with_context
+from provisioningserver.certificates import Certificate
+
+# Activities names
+# Executed on the Region by the Agent renew-agent-certificate workflow
+ISSUE_AGENT_CERTIFICATE_ACTIVITY_NAME = "issue-agent-certificate"
+
+# Validity of certificates issued to Agents, which renew them before expiry
+AGENT_CERTIFICATE_VALIDITY = timedelta(days=365)
+
+# Error types of the activities
+INVALID_CERTIFICATE_REQUEST_ERROR = "INVALID_CERTIFICATE_REQUEST"
+UNKNOWN_AGENT_ERROR = "UNKNOWN_AGENT"
+
+
+# Activities parameters
+@dataclass
+class IssueAgentCertificateParam:
+    # system_id of the Agent
+    system_id: str
+    # PEM encoded certificate signing request
+    csr: str
+
+
+@dataclass
+class IssueAgentCertificateResult:
+    # PEM encoded certificate chain, leaf first
+    certificate: str
+
+
+def sign_agent_certificate_request(
+    ca: Certificate, csr: crypto.X509Req, validity: timedelta
+) -> crypto.X509:
+    """
+    Return a certificate for the key of `csr` signed by `ca`. Unlike
+    Certificate.sign_certificate_request, the private key of the requester
+    isn't needed, so it never leaves the Agent.
+    """
+    cert = crypto.X509()
+    cert.set_version(crypto.x509.Version.v3.value)
+    cert.set_serial_number(random.randint(0, (1 << 128) - 1))
+    cert.gmtime_adj_notBefore(0)
+    cert.gmtime_adj_notAfter(int(validity.total_seconds()))
+    cert.set_pubkey(csr.get_pubkey())
+    cert.set_issuer(ca.cert.get_subject())
+    cert.set_subject(csr.get_subject())
+    cert.add_extensions(csr.get_extensions())
+    cert.sign(ca.key, "sha512")
+    return cert
+
+
+class AgentCertificateActivity(ActivityBase):
+    @activity_defn_with_context(name=ISSUE_AGENT_CERTIFICATE_ACTIVITY_NAME)
+    async def issue_agent_certificate(
+        self, param: IssueAgentCertificateParam
+    ) -> IssueAgentCertificateResult:
+        try:
+            csr = crypto.load_certificate_request(
+                crypto.FILETYPE_PEM, param.csr.encode()
+            )
+            csr.verify(csr.get_pubkey())
+        except crypto.Error as e:
+            raise ApplicationError(
+                f"Invalid certificate request of {param.system_id}: {e}",
+                type=INVALID_CERTIFICATE_REQUEST_ERROR,
+                non_retryable=True,
+            )
+
+        async with self._start_transaction() as tx:
+            stmt = (
+                select(NodeTable.c.id)
+                .select_from(NodeTable)
+                .filter(
+                    and_(
+                        NodeTable.c.system_id == param.system_id,
+                        NodeTable.c.node_type.in_(
+                            [
+                                NodeTypeEnum.RACK_CONTROLLER,
+                                NodeTypeEnum.REGION_AND_RACK_CONTROLLER,
+                            ]
+                        ),
+                    ),
+                )
+            )
+            agent = (await tx.execute(stmt)).one_or_none()
+
+        # Only Agents of known rack controllers get certificates
+        if agent is None:
+            raise ApplicationError(
+                f"{param.system_id} is not a rack controller",
+                type=UNKNOWN_AGENT_ERROR,
+                non_retryable=True,
+            )
+
+        async with self.start_transaction() as services:
+            secret = await services.secrets.get_composite_secret(
+                "global/maas-ca-certificate"
+            )
+        ca = Certificate.from_pem(secret["key"], secret["cert"])
+
+        cert = sign_agent_certificate_request(
+            ca, csr, AGENT_CERTIFICATE_VALIDITY
+        )
+        return IssueAgentCertificateResult(
+            certificate=crypto.dump_certificate(
+                crypto.FILETYPE_PEM, cert
+            ).decode()
+            + ca.certificate_pem()
+        )
@@ -3,18 +3,27 @@
 
 from dataclasses import dataclass
 from datetime import timedelta
+import json
 from typing import List
 
 from netaddr import IPAddress
+from sqlalchemy import and_, select
+from sqlalchemy.ext.asyncio import AsyncConnection
 from temporalio import workflow
 from temporalio.common import RetryPolicy
 
 from maascommon.enums.node import NodeTypeEnum
 from maascommon.workflows.configure import (
+    AGENT_ROLE_DHCP,
+    AGENT_ROLE_HTTPPROXY,
+    AGENT_ROLE_POWER,
+    AGENT_ROLES_METADATA_KEY,
     CONFIGURE_AGENT_WORKFLOW_NAME,
     CONFIGURE_DHCP_SERVICE_WORKFLOW_NAME,
+    CONFIGURE_DNS_PUBLICATION_WORKFLOW_NAME,
     CONFIGURE_HTTPPROXY_SERVICE_WORKFLOW_NAME,
     CONFIGURE_POWER_SERVICE_WORKFLOW_NAME,
+    CONFIGURE_SUBNET_SERVICES_WORKFLOW_NAME,
     ConfigureAgentParam,
     ConfigureDHCPServiceParam,
 )
@@ -23,7 +32,12 @@ from maasservicelayer.db.repositories.staticipaddress import (
     StaticIPAddressClauseFactory,
 )
 from maasservicelayer.db.repositories.vlans import VlansClauseFactory
+from maasservicelayer.db.tables import NodeMetadataTable, NodeTable
 from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.ephemeral import (
+    delete_metadata,
+    set_metadata,
+)
 from maastemporalworker.workflow.utils import (
     activity_defn_with_context,
     workflow_run_with_context,
@@ -42,6 +56,10 @@ GET_RACK_CONTROLLER_VLANS_ACTIVITY_NAME = "get-rack-controller-vlans"
 GET_REGION_CONTROLLER_ENDPOINTS_ACTIVITY_NAME = (
     "get-region-controller-endpoints"
 )
+SET_AGENT_ROLES_ACTIVITY_NAME = "set-agent-roles"
+
+# Agents started configure-agent before roles were recorded
+SET_AGENT_ROLES_PATCH = "set-agent-roles"
 
 
 # Activities parameters
@@ -116,6 +134,54 @@ class ConfigureAgentActivity(ActivityBase):
                 [_format_endpoint(str(ipaddress.ip)) for ipaddress in result]
             )
 
+    @activity_defn_with_context(name=SET_AGENT_ROLES_ACTIVITY_NAME)
+    async def set_agent_roles(self, param: ConfigureAgentParam) -> None:
+        """
+        Record roles enabled on the Agent, so that work of other roles is not
+        routed to it.
+        """
+        async with self._start_transaction() as tx:
+            node_id = (
+                await tx.execute(
+                    select(NodeTable.c.id).filter(
+                        NodeTable.c.system_id == param.system_id
+                    )
+                )
+            ).scalar_one_or_none()
+            if node_id is None:
+                return
+            if param.roles is None:
+                await delete_metadata(tx, node_id, [AGENT_ROLES_METADATA_KEY])
+            else:
+                await set_metadata(
+                    tx,
+                    node_id,
+                    AGENT_ROLES_METADATA_KEY,
+                    json.dumps(sorted(param.roles)),
+                )
+
+
+async def get_agents_without_role(
+    tx: AsyncConnection, system_ids: set[str], role: str
+) -> set[str]:
+    """Return Agents among `system_ids` which don't have `role` enabled."""
+    stmt = (
+        select(NodeTable.c.system_id, NodeMetadataTable.c.value)
+        .select_from(NodeMetadataTable)
+        .join(NodeTable, NodeTable.c.id == NodeMetadataTable.c.node_id)
+        .filter(
+            and_(
+                NodeTable.c.system_id.in_(list(system_ids)),
+                NodeMetadataTable.c.key == AGENT_ROLES_METADATA_KEY,
+            )
+        )
+    )
+    return {
+        system_id
+        for system_id, roles in (await tx.execute(stmt)).all()
+        if role not in json.loads(roles)
+    }
+
 
 def _format_endpoint(ip: str) -> str:
     addr = IPAddress(ip)
@@ -133,29 +199,61 @@ class ConfigureAgentWorkflow:
 
     @workflow_run_with_context
     async def run(self, param: ConfigureAgentParam) -> None:
+        if workflow.patched(SET_AGENT_ROLES_PATCH):
+            await workflow.execute_activity(
+                SET_AGENT_ROLES_ACTIVITY_NAME,
+                param,
+                start_to_close_timeout=DEFAULT_CONFIGURE_ACTIVITY_TIMEOUT,
+                retry_policy=DEFAULT_CONFIGURE_RETRY_POLICY,
+            )
+
         # Agent registers workflows for configuring it's services
         # during Temporal worker pool initialization using WithConfigurator.
         # Make sure that used workflow names are in sync with the Agent.
-        await workflow.execute_child_workflow(
-            CONFIGURE_POWER_SERVICE_WORKFLOW_NAME,
-            param.system_id,
-            id=f"configure-power-service:{param.system_id}",
-            task_queue=f"{param.system_id}@agent:main",
-            retry_policy=RetryPolicy(maximum_attempts=1),
-        )
+        # Workflows of disabled roles are not registered, so starting them
+        # would block until the Agent gives up waiting for configuration.
+        if param.has_role(AGENT_ROLE_POWER):
+            await workflow.execute_child_workflow(
+                CONFIGURE_POWER_SERVICE_WORKFLOW_NAME,
+                param.system_id,
+                id=f"configure-power-service:{param.system_id}",
+                task_queue=f"{param.system_id}@agent:main",
+                retry_policy=RetryPolicy(maximum_attempts=1),
+            )
+
+        if param.has_role(AGENT_ROLE_HTTPPROXY):
+            await workflow.execute_child_workflow(
+                CONFIGURE_HTTPPROXY_SERVICE_WORKFLOW_NAME,
+                param.system_id,
+                id=f"configure-httpproxy-service:{param.system_id}",
+                task_queue=f"{param.system_id}@agent:main",
+                retry_policy=RetryPolicy(maximum_attempts=1),
+            )
 
+        if param.has_role(AGENT_ROLE_DHCP):
+            await workflow.execute_child_workflow(
+                CONFIGURE_DHCP_SERVICE_WORKFLOW_NAME,
+                ConfigureDHCPServiceParam(enabled=True),
+                id=f"configure-dhcp-service:{param.system_id}",
+                task_queue=f"{param.system_id}@agent:main",
+                retry_policy=RetryPolicy(maximum_attempts=1),
+            )
+
+        # Subnet services are used by all roles, so Agents always register
+        # the workflow
         await workflow.execute_child_workflow(
-            CONFIGURE_HTTPPROXY_SERVICE_WORKFLOW_NAME,
+            CONFIGURE_SUBNET_SERVICES_WORKFLOW_NAME,
             param.system_id,
-            id=f"configure-httpproxy-service:{param.system_id}",
+            id=f"configure-subnet-services:{param.system_id}",
             task_queue=f"{param.system_id}@agent:main",
             retry_policy=RetryPolicy(maximum_attempts=1),
         )
 
+        # Agents register the workflow even if publication is disabled
         await workflow.execute_child_workflow(
-            CONFIGURE_DHCP_SERVICE_WORKFLOW_NAME,
-            ConfigureDHCPServiceParam(enabled=True),
-            id=f"configure-dhcp-service:{param.system_id}",
+            CONFIGURE_DNS_PUBLICATION_WORKFLOW_NAME,
+            param.system_id,
+            id=f"configure-dns-publication:{param.system_id}",
             task_queue=f"{param.system_id}@agent:main",
             retry_policy=RetryPolicy(maximum_attempts=1),
         )
@@ -2,16 +2,20 @@
 # GNU Affero General Public License version 3 (see the file LICENSE).
 
 import asyncio
-from dataclasses import dataclass
+from dataclasses import dataclass, field
 from datetime import datetime, timedelta
-from typing import Any
+from typing import Any, Optional
 
 from sqlalchemy import Result, select
 from sqlalchemy.ext.asyncio import AsyncConnection
 import structlog
 from temporalio import workflow
 from temporalio.common import RetryPolicy
-from temporalio.exceptions import CancelledError, ChildWorkflowError
+from temporalio.exceptions import (
+    ActivityError,
+    CancelledError,
+    ChildWorkflowError,
+)
 
 from maascommon.enums.node import NodeStatus
 from maascommon.workflows.deploy import (
@@ -22,10 +26,12 @@ from maascommon.workflows.deploy import (
     DeployResult,
 )
 from maascommon.workflows.power import (
+    BOOT_DEVICE_PXE,
     PowerCycleParam,
     PowerOnParam,
     PowerParam,
     PowerQueryParam,
+    SetBootDeviceParam,
 )
 from maasservicelayer.db.repositories.nodes import NodeResourceBuilder
 from maasservicelayer.db.tables import (
@@ -39,9 +45,10 @@ from maasservicelayer.db.tables import (
 )
 from maastemporalworker.workflow.activity import ActivityBase
 from maastemporalworker.workflow.power import (
-    POWER_CYCLE_ACTIVITY_NAME,
+    power_cycle,
     POWER_ON_ACTIVITY_NAME,
     POWER_QUERY_ACTIVITY_NAME,
+    SET_BOOT_DEVICE_ACTIVITY_NAME,
 )
 from maastemporalworker.workflow.utils import (
     activity_defn_with_context,
@@ -57,6 +64,12 @@ DEFAULT_DEPLOY_RETRY_TIMEOUT = timedelta(seconds=60)
 GET_BOOT_ORDER_ACTIVITY_NAME = "get-boot-order"
 SET_NODE_STATUS_ACTIVITY_NAME = "set-node-status"
 SET_BOOT_ORDER_ACTIVITY_NAME = "set-boot-order"
+RECORD_BOOT_ORDER_ACTIVITY_NAME = "record-boot-order"
+RESTORE_BOOT_ORDER_ACTIVITY_NAME = "restore-boot-order"
+
+# Patches
+SET_BOOT_DEVICE_PATCH = "set-boot-device"
+RESTORE_BOOT_ORDER_PATCH = "restore-boot-order"
 
 
 class InvalidMachineStateException(Exception):
@@ -89,6 +102,19 @@ class SetBootOrderParam:
     order: list[dict[str, Any]]
 
 
+@dataclass
+class RecordBootOrderParam(PowerParam):
+    pass
+
+
+@dataclass
+class RestoreBootOrderParam(PowerParam):
+    # persistent boot order returned by record-boot-order
+    recorded: list[dict[str, Any]] = field(default_factory=list)
+    # local disk first instead of the recorded boot order
+    local_first: bool = False
+
+
 class DeployActivity(ActivityBase):
     @activity_defn_with_context(name=SET_NODE_STATUS_ACTIVITY_NAME)
     async def set_node_status(self, params: SetNodeStatusParam) -> None:
@@ -321,6 +347,8 @@ class DeployWorkflow:
     def __init__(self) -> None:
         self._has_netbooted = False
         self._deployed_os_ready = False
+        # persistent boot order before deployment, if it was recorded
+        self._recorded_boot_order: Optional[list[dict[str, Any]]] = None
 
     @workflow.signal(name="netboot-finished")
     async def netboot_signal(self, *args: list[Any]) -> None:
@@ -340,6 +368,10 @@ class DeployWorkflow:
                 driver_type=params.power_params.driver_type,
                 driver_opts=params.power_params.driver_opts,
                 task_queue=params.power_params.task_queue,
+                boot_mode=params.power_params.boot_mode,
+                requester=params.power_params.requester,
+                reason=params.power_params.reason,
+                correlation_id=params.power_params.correlation_id,
             ),
             task_queue=params.power_params.task_queue,
             start_to_close_timeout=DEFAULT_DEPLOY_ACTIVITY_TIMEOUT,
@@ -348,16 +380,60 @@ class DeployWorkflow:
             ),
         )
 
+        # Machines whose boot order is set by the Region keep it, others get
+        # their boot order from before the deployment back once deployed.
+        # Agents which predate record-boot-order don't have the activity.
+        if (
+            not params.ephemeral_deploy
+            and not params.can_set_boot_order
+            and workflow.patched(RESTORE_BOOT_ORDER_PATCH)
+        ):
+            await self._record_boot_order(params)
+
+        # Make sure the machine netboots, regardless of its boot order.
+        # Agents which predate set-boot-device don't have the activity,
+        # so the deployment carries on with the current boot order.
+        if workflow.patched(SET_BOOT_DEVICE_PATCH):
+            try:
+                await workflow.execute_activity(
+                    SET_BOOT_DEVICE_ACTIVITY_NAME,
+                    SetBootDeviceParam(
+                        system_id=params.power_params.system_id,
+                        driver_type=params.power_params.driver_type,
+                        driver_opts=params.power_params.driver_opts,
+                        task_queue=params.power_params.task_queue,
+                        boot_mode=params.power_params.boot_mode,
+                        requester=params.power_params.requester,
+                        reason=params.power_params.reason,
+                        correlation_id=params.power_params.correlation_id,
+                        device=BOOT_DEVICE_PXE,
+                    ),
+                    task_queue=params.power_params.task_queue,
+                    start_to_close_timeout=DEFAULT_DEPLOY_ACTIVITY_TIMEOUT,
+                    retry_policy=RetryPolicy(
+                        maximum_attempts=3,
+                        maximum_interval=DEFAULT_DEPLOY_RETRY_TIMEOUT,
+                    ),
+                )
+            except ActivityError as e:
+                workflow.logger.warning(
+                    "can't set boot device of "
+                    f"{params.power_params.system_id}: {e.cause}"
+                )
+
         if result["state"] == "on":
-            await workflow.execute_activity(
-                POWER_CYCLE_ACTIVITY_NAME,
+            # Machines which driver can't cycle power are powered off and on
+            await power_cycle(
                 PowerCycleParam(
                     system_id=params.power_params.system_id,
                     driver_type=params.power_params.driver_type,
                     driver_opts=params.power_params.driver_opts,
                     task_queue=params.power_params.task_queue,
+                    boot_mode=params.power_params.boot_mode,
+                    requester=params.power_params.requester,
+                    reason=params.power_params.reason,
+                    correlation_id=params.power_params.correlation_id,
                 ),
-                task_queue=params.power_params.task_queue,
                 start_to_close_timeout=DEFAULT_DEPLOY_ACTIVITY_TIMEOUT,
                 retry_policy=RetryPolicy(
                     maximum_interval=DEFAULT_DEPLOY_RETRY_TIMEOUT
@@ -371,6 +447,10 @@ class DeployWorkflow:
                     driver_type=params.power_params.driver_type,
                     driver_opts=params.power_params.driver_opts,
                     task_queue=params.power_params.task_queue,
+                    boot_mode=params.power_params.boot_mode,
+                    requester=params.power_params.requester,
+                    reason=params.power_params.reason,
+                    correlation_id=params.power_params.correlation_id,
                 ),
                 task_queue=params.power_params.task_queue,
                 start_to_close_timeout=DEFAULT_DEPLOY_ACTIVITY_TIMEOUT,
@@ -379,6 +459,66 @@ class DeployWorkflow:
                 ),
             )
 
+    async def _record_boot_order(self, params: DeployParam) -> None:
+        try:
+            result = await workflow.execute_activity(
+                RECORD_BOOT_ORDER_ACTIVITY_NAME,
+                RecordBootOrderParam(
+                    system_id=params.power_params.system_id,
+                    driver_type=params.power_params.driver_type,
+                    driver_opts=params.power_params.driver_opts,
+                    task_queue=params.power_params.task_queue,
+                    boot_mode=params.power_params.boot_mode,
+                    requester=params.power_params.requester,
+                    reason=params.power_params.reason,
+                    correlation_id=params.power_params.correlation_id,
+                ),
+                task_queue=params.power_params.task_queue,
+                start_to_close_timeout=DEFAULT_DEPLOY_ACTIVITY_TIMEOUT,
+                retry_policy=RetryPolicy(
+                    maximum_attempts=3,
+                    maximum_interval=DEFAULT_DEPLOY_RETRY_TIMEOUT,
+                ),
+            )
+        except ActivityError as e:
+            workflow.logger.warning(
+                "can't record boot order of "
+                f"{params.power_params.system_id}: {e.cause}"
+            )
+            return
+
+        # The Agent which restores the boot order may not be the one
+        # which recorded it, so it is kept by the workflow
+        self._recorded_boot_order = result["order"] or []
+
+    async def _restore_boot_order(self, params: DeployParam) -> None:
+        try:
+            await workflow.execute_activity(
+                RESTORE_BOOT_ORDER_ACTIVITY_NAME,
+                RestoreBootOrderParam(
+                    system_id=params.power_params.system_id,
+                    driver_type=params.power_params.driver_type,
+                    driver_opts=params.power_params.driver_opts,
+                    task_queue=params.power_params.task_queue,
+                    boot_mode=params.power_params.boot_mode,
+                    requester=params.power_params.requester,
+                    reason=params.power_params.reason,
+                    correlation_id=params.power_params.correlation_id,
+                    recorded=self._recorded_boot_order or [],
+                ),
+                task_queue=params.power_params.task_queue,
+                start_to_close_timeout=DEFAULT_DEPLOY_ACTIVITY_TIMEOUT,
+                retry_policy=RetryPolicy(
+                    maximum_attempts=3,
+                    maximum_interval=DEFAULT_DEPLOY_RETRY_TIMEOUT,
+                ),
+            )
+        except ActivityError as e:
+            workflow.logger.warning(
+                "can't restore boot order of "
+                f"{params.power_params.system_id}: {e.cause}"
+            )
+
     async def _set_boot_order(self, params: DeployParam) -> None:
         boot_order = await workflow.execute_activity(
             GET_BOOT_ORDER_ACTIVITY_NAME,
@@ -426,4 +566,7 @@ class DeployWorkflow:
             await workflow.wait_condition(lambda: self._deployed_os_ready)
             logger.debug(f"{params.system_id} has booted into deployed OS")
 
+            if self._recorded_boot_order is not None:
+                await self._restore_boot_order(params)
+
         return DeployResult(system_id=params.system_id, success=True)
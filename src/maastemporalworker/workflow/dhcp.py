@@ -10,7 +10,10 @@ from sqlalchemy import and_, or_, select, true
 from sqlalchemy.ext.asyncio import AsyncConnection
 from temporalio import workflow
 from temporalio.exceptions import WorkflowAlreadyStartedError
+from temporalio.workflow import ParentClosePolicy
 
+from maascommon.enums.events import EventTypeEnum
+from maascommon.workflows.configure import AGENT_ROLE_DHCP
 from maascommon.workflows.dhcp import (
     CONFIGURE_DHCP_FOR_AGENT_WORKFLOW_NAME,
     CONFIGURE_DHCP_WORKFLOW_NAME,
@@ -28,6 +31,7 @@ from maasservicelayer.db.tables import (
     VlanTable,
 )
 from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.configure import get_agents_without_role
 from maastemporalworker.workflow.utils import (
     activity_defn_with_context,
     workflow_run_with_context,
@@ -39,17 +43,25 @@ FETCH_HOSTS_FOR_UPDATE_TIMEOUT = timedelta(minutes=5)
 GET_OMAPI_KEY_TIMEOUT = timedelta(minutes=5)
 APPLY_DHCP_CONFIG_VIA_OMAPI_TIMEOUT = timedelta(minutes=5)
 RESTART_DHCP_SERVICE_TIMEOUT = timedelta(minutes=5)
+GET_DHCP_FAILOVER_PEERS_TIMEOUT = timedelta(minutes=5)
 
 # Activities names
 FIND_AGENTS_FOR_UPDATE_ACTIVITY_NAME = "find-agents-for-update"
 FETCH_HOSTS_FOR_UPDATE_ACTIVITY_NAME = "fetch-hosts-for-update"
 GET_OMAPI_KEY_ACTIVITY_NAME = "get-omapi-key"
+GET_DHCP_FAILOVER_PEERS_ACTIVITY_NAME = "get-dhcp-failover-peers"
+# Executed on the Region by the Agent monitor-dhcp-failover workflow
+REPORT_DHCP_FAILOVER_HEALTH_ACTIVITY_NAME = "report-dhcp-failover-health"
 
 # Executed on maasagent
 APPLY_DHCP_CONFIG_VIA_FILE_ACTIVITY_NAME = "apply-dhcp-config-via-file"
 RESTART_DHCP_SERVICE_ACTIVITY_NAME = "restart-dhcp-service"
 APPLY_DHCP_CONFIG_VIA_OMAPI_ACTIVITY_NAME = "apply-dhcp-config-via-omapi"
 
+# Workflows names
+# Executed on maasagent
+MONITOR_DHCP_FAILOVER_WORKFLOW_NAME = "monitor-dhcp-failover"
+
 
 # Activities parameters
 @dataclass
@@ -93,6 +105,49 @@ class OMAPIKeyResult:
     key: str
 
 
+@dataclass
+class GetDHCPFailoverPeersParam:
+    system_id: str
+
+
+@dataclass
+class DHCPFailoverPeer:
+    # name of the failover peer, as in the dhcpd configuration
+    name: str
+    # system_id of the Agent serving the partner
+    peer_system_id: str
+
+
+@dataclass
+class DHCPFailoverPeersResult:
+    peers: list[DHCPFailoverPeer]
+
+
+@dataclass
+class MonitorDHCPFailoverParam:
+    name: str
+    secret: str
+    peer_system_id: str
+
+
+@dataclass
+class DHCPFailoverHealthReport:
+    # system_id of the Agent
+    system_id: str
+    # name of the failover peer
+    name: str
+    local_state: str = ""
+    partner_state: str = ""
+    primary: bool = False
+    healthy: bool = False
+    # whether the Agent of the partner could be reached
+    peer_reachable: bool = True
+    # whether the local server was put into partner-down state
+    promoted: bool = False
+    # set when the state of the failover peer couldn't be retrieved
+    error: str = ""
+
+
 class DHCPConfigActivity(ActivityBase):
     async def _get_agents_for_vlans(
         self, tx: AsyncConnection, vlan_ids: set[int]
@@ -267,6 +322,11 @@ class DHCPConfigActivity(ActivityBase):
             if vlan_ids:
                 system_ids |= await self._get_agents_for_vlans(tx, vlan_ids)
 
+            # Agents without the DHCP role don't run dhcpd
+            system_ids -= await get_agents_without_role(
+                tx, system_ids, AGENT_ROLE_DHCP
+            )
+
             return AgentsForUpdateResult(agent_system_ids=list(system_ids))
 
     async def _get_hosts_for_static_ip_addresses(
@@ -389,10 +449,124 @@ class DHCPConfigActivity(ActivityBase):
             key = await services.secrets.get_simple_secret("global/omapi-key")
             return OMAPIKeyResult(key=key)
 
+    @activity_defn_with_context(name=GET_DHCP_FAILOVER_PEERS_ACTIVITY_NAME)
+    async def get_dhcp_failover_peers(
+        self, param: GetDHCPFailoverPeersParam
+    ) -> DHCPFailoverPeersResult:
+        """
+        Return failover peers of the Agent, one for each VLAN with DHCP
+        enabled that has both a primary and a secondary rack controller.
+        """
+        primary = NodeTable.alias("primary")
+        secondary = NodeTable.alias("secondary")
+        async with self._start_transaction() as tx:
+            stmt = (
+                select(
+                    VlanTable.c.id,
+                    primary.c.system_id,
+                    secondary.c.system_id,
+                )
+                .select_from(VlanTable)
+                .join(primary, primary.c.id == VlanTable.c.primary_rack_id)
+                .join(
+                    secondary, secondary.c.id == VlanTable.c.secondary_rack_id
+                )
+                .filter(
+                    and_(
+                        VlanTable.c.dhcp_on == true(),
+                        or_(
+                            primary.c.system_id == param.system_id,
+                            secondary.c.system_id == param.system_id,
+                        ),
+                    ),
+                )
+                .order_by(VlanTable.c.id)
+            )
+            result = await tx.execute(stmt)
+            return DHCPFailoverPeersResult(
+                peers=[
+                    DHCPFailoverPeer(
+                        # same as in maasserver.dhcp
+                        name=f"failover-vlan-{vlan_id}",
+                        peer_system_id=(
+                            secondary_id
+                            if primary_id == param.system_id
+                            else primary_id
+                        ),
+                    )
+                    for vlan_id, primary_id, secondary_id in result.all()
+                ]
+            )
+
+    @activity_defn_with_context(name=REPORT_DHCP_FAILOVER_HEALTH_ACTIVITY_NAME)
+    async def report_dhcp_failover_health(
+        self, param: DHCPFailoverHealthReport
+    ) -> None:
+        """Record changes of the health of a failover pair as Agent events."""
+        if param.promoted:
+            event_type = EventTypeEnum.AGENT_DHCP_FAILOVER_PROMOTED
+            description = (
+                f"Failover peer {param.name} promoted to {param.local_state}, "
+                "as the partner Agent is unreachable"
+            )
+        elif param.error:
+            event_type = EventTypeEnum.AGENT_DHCP_FAILOVER_UNHEALTHY
+            description = f"Failover peer {param.name}: {param.error}"
+        else:
+            event_type = (
+                EventTypeEnum.AGENT_DHCP_FAILOVER_HEALTHY
+                if param.healthy
+                else EventTypeEnum.AGENT_DHCP_FAILOVER_UNHEALTHY
+            )
+            role = "primary" if param.primary else "secondary"
+            description = (
+                f"Failover peer {param.name} ({role}) is "
+                f"{param.local_state}, partner is {param.partner_state}"
+            )
+
+        async with self.start_transaction() as services:
+            await services.events.record_node_event(
+                param.system_id, event_type, description
+            )
+
 
 @workflow.defn(name=CONFIGURE_DHCP_FOR_AGENT_WORKFLOW_NAME, sandboxed=False)
 class ConfigureDHCPForAgentWorkflow:
 
+    async def _monitor_failover_peers(self, system_id: str) -> None:
+        """
+        Start monitoring of failover peers on the Agent, which reports their
+        health back to the Region. Monitors keep running across reloads.
+        """
+        peers = await workflow.execute_activity(
+            GET_DHCP_FAILOVER_PEERS_ACTIVITY_NAME,
+            GetDHCPFailoverPeersParam(system_id=system_id),
+            start_to_close_timeout=GET_DHCP_FAILOVER_PEERS_TIMEOUT,
+        )
+        if not peers["peers"]:
+            return
+
+        omapi_key = await workflow.execute_activity(
+            GET_OMAPI_KEY_ACTIVITY_NAME,
+            start_to_close_timeout=GET_OMAPI_KEY_TIMEOUT,
+        )
+
+        for peer in peers["peers"]:
+            try:
+                await workflow.start_child_workflow(
+                    MONITOR_DHCP_FAILOVER_WORKFLOW_NAME,
+                    MonitorDHCPFailoverParam(
+                        name=peer["name"],
+                        secret=omapi_key["key"],
+                        peer_system_id=peer["peer_system_id"],
+                    ),
+                    id=f"monitor-dhcp-failover:{system_id}:{peer['name']}",
+                    task_queue=f"{system_id}@agent:main",
+                    parent_close_policy=ParentClosePolicy.ABANDON,
+                )
+            except WorkflowAlreadyStartedError:
+                pass
+
     @workflow_run_with_context
     async def run(self, param: ConfigureDHCPForAgentParam) -> None:
         # When dhcpd restarts the static leases are lost unless they are present in the dhcpd config. This is why in every
@@ -408,6 +582,7 @@ class ConfigureDHCPForAgentWorkflow:
                 task_queue=f"{param.system_id}@agent:main",
                 start_to_close_timeout=RESTART_DHCP_SERVICE_TIMEOUT,
             )
+            await self._monitor_failover_peers(param.system_id)
         else:
             hosts = await workflow.execute_activity(
                 FETCH_HOSTS_FOR_UPDATE_ACTIVITY_NAME,
@@ -0,0 +1,361 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+"""
+Activities of the Agent configure-dns-publication workflow, which publishes
+endpoints of services of the rack in rack-local zones.
+
+Zones are MAAS domains configured for subnets in the "rack_dns_zones"
+configuration, e.g. {"10.0.0.0/24": "rack1.maas"}. Addresses of the rack
+are published with its host name, linked to its static IP addresses, while
+endpoints are published as SRV and TXT records.
+"""
+
+from dataclasses import dataclass
+
+from netaddr import IPNetwork
+from sqlalchemy import and_, delete, exists, insert, or_, select
+from sqlalchemy.dialects.postgresql import insert as pg_insert
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.exceptions import ApplicationError
+
+from maasservicelayer.db.tables import (
+    DNSDataTable,
+    DNSResourceIPAddressTable,
+    DNSResourceTable,
+    DomainTable,
+    InterfaceIPAddressTable,
+    InterfaceTable,
+    NodeConfigTable,
+    NodeTable,
+    StaticIPAddressTable,
+    SubnetTable,
+    VlanTable,
+)
+from maasservicelayer.utils.date import utcnow
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.utils import activity_defn_with_context
+
+# Activities names
+# Executed on the Region by the Agent configure-dns-publication workflow
+GET_RACK_DNS_ZONES_ACTIVITY_NAME = "get-rack-dns-zones"
+PUBLISH_DNS_RECORDS_ACTIVITY_NAME = "publish-dns-records"
+
+# Error types of the activities
+UNKNOWN_RACK_ERROR = "UNKNOWN_RACK"
+
+ADDRESS_RRTYPES = ("A", "AAAA")
+
+
+# Activities parameters
+@dataclass
+class GetRackDNSZonesParam:
+    # system_id of the Agent
+    system_id: str
+
+
+@dataclass
+class DNSZone:
+    name: str
+    subnet: str
+
+
+@dataclass
+class GetRackDNSZonesResult:
+    zones: list[DNSZone]
+
+
+@dataclass
+class DNSRecord:
+    # absolute name, e.g. "_syslog._udp.rack1.maas."
+    name: str
+    type: str
+    data: str
+    ttl: int
+
+
+@dataclass
+class DNSZoneRecords:
+    zone: str
+    # Zones without records are published too, which removes records of
+    # addresses the rack no longer has
+    records: list[DNSRecord] | None = None
+
+
+@dataclass
+class PublishDNSRecordsParam:
+    # system_id of the Agent
+    system_id: str
+    zones: list[DNSZoneRecords]
+
+
+async def _get_or_create_domain(tx: AsyncConnection, name: str) -> int:
+    now = utcnow()
+    await tx.execute(
+        pg_insert(DomainTable)
+        .values(created=now, updated=now, name=name, authoritative=True)
+        .on_conflict_do_nothing(index_elements=[DomainTable.c.name])
+    )
+    return (
+        await tx.execute(
+            select(DomainTable.c.id).filter(DomainTable.c.name == name)
+        )
+    ).scalar_one()
+
+
+async def _get_or_create_resource(
+    tx: AsyncConnection, domain_id: int, name: str
+) -> int:
+    resource_id = (
+        await tx.execute(
+            select(DNSResourceTable.c.id).filter(
+                and_(
+                    DNSResourceTable.c.domain_id == domain_id,
+                    DNSResourceTable.c.name == name,
+                )
+            )
+        )
+    ).scalar()
+    if resource_id is None:
+        now = utcnow()
+        resource_id = (
+            await tx.execute(
+                insert(DNSResourceTable)
+                .values(
+                    created=now, updated=now, name=name, domain_id=domain_id
+                )
+                .returning(DNSResourceTable.c.id)
+            )
+        ).scalar_one()
+    return resource_id
+
+
+class DNSPublicationActivity(ActivityBase):
+    async def _get_rack(self, tx: AsyncConnection, system_id: str):
+        rack = (
+            await tx.execute(
+                select(NodeTable.c.id, NodeTable.c.hostname).filter(
+                    NodeTable.c.system_id == system_id
+                )
+            )
+        ).one_or_none()
+        if rack is None:
+            raise ApplicationError(
+                f"Rack {system_id} not found",
+                type=UNKNOWN_RACK_ERROR,
+                non_retryable=True,
+            )
+        return rack
+
+    @activity_defn_with_context(name=GET_RACK_DNS_ZONES_ACTIVITY_NAME)
+    async def get_rack_dns_zones(
+        self, param: GetRackDNSZonesParam
+    ) -> GetRackDNSZonesResult:
+        """
+        Return zones configured for subnets on VLANs served by the Agent.
+        """
+        async with self.start_transaction() as services:
+            configured = (
+                await services.configurations.get("rack_dns_zones") or {}
+            )
+        if not configured:
+            return GetRackDNSZonesResult(zones=[])
+        configured = {
+            str(IPNetwork(cidr).cidr): name.rstrip(".")
+            for cidr, name in configured.items()
+        }
+
+        zones = []
+        async with self._start_transaction() as tx:
+            stmt = (
+                select(SubnetTable.c.cidr)
+                .select_from(SubnetTable)
+                .join(VlanTable, VlanTable.c.id == SubnetTable.c.vlan_id)
+                .join(
+                    NodeTable,
+                    or_(
+                        VlanTable.c.primary_rack_id == NodeTable.c.id,
+                        VlanTable.c.secondary_rack_id == NodeTable.c.id,
+                    ),
+                )
+                .filter(NodeTable.c.system_id == param.system_id)
+                .order_by(SubnetTable.c.id)
+            )
+            for (cidr,) in (await tx.execute(stmt)).all():
+                if name := configured.get(str(cidr)):
+                    zones.append(DNSZone(name=name, subnet=str(cidr)))
+
+        return GetRackDNSZonesResult(zones=zones)
+
+    @activity_defn_with_context(name=PUBLISH_DNS_RECORDS_ACTIVITY_NAME)
+    async def publish_dns_records(self, param: PublishDNSRecordsParam) -> None:
+        """
+        Replace records of the Agent in each zone with `param.zones`.
+        Records of the Agent are the addresses of its host name and SRV
+        records targeting it. TXT records of services are removed once no
+        rack publishes the service anymore.
+        """
+        async with self._start_transaction() as tx:
+            rack_id, hostname = await self._get_rack(tx, param.system_id)
+            host = hostname.split(".")[0]
+
+            for zone in param.zones:
+                name = zone.zone.rstrip(".")
+                domain_id = await _get_or_create_domain(tx, name)
+                await self._unpublish(tx, domain_id, host, f"{host}.{name}.")
+                for record in zone.records or []:
+                    await self._publish(
+                        tx,
+                        domain_id,
+                        rack_id,
+                        record.name.removesuffix(f".{name}."),
+                        record,
+                    )
+                await self._remove_orphans(tx, domain_id, host)
+
+    async def _unpublish(
+        self, tx: AsyncConnection, domain_id: int, host: str, target: str
+    ) -> None:
+        resources = select(DNSResourceTable.c.id).filter(
+            DNSResourceTable.c.domain_id == domain_id
+        )
+        await tx.execute(
+            delete(DNSResourceIPAddressTable).where(
+                DNSResourceIPAddressTable.c.dnsresource_id.in_(
+                    resources.filter(DNSResourceTable.c.name == host)
+                )
+            )
+        )
+        await tx.execute(
+            delete(DNSDataTable).where(
+                and_(
+                    DNSDataTable.c.dnsresource_id.in_(resources),
+                    DNSDataTable.c.rrtype == "SRV",
+                    DNSDataTable.c.rrdata.endswith(
+                        f" {target}", autoescape=True
+                    ),
+                )
+            )
+        )
+
+    async def _publish(
+        self,
+        tx: AsyncConnection,
+        domain_id: int,
+        rack_id: int,
+        name: str,
+        record: DNSRecord,
+    ) -> None:
+        if record.type in ADDRESS_RRTYPES:
+            # Addresses are published by linking static IP addresses of the
+            # rack, which are unknown to the Region until the rack reports
+            # its interfaces.
+            ip_id = (
+                await tx.execute(
+                    select(StaticIPAddressTable.c.id)
+                    .select_from(StaticIPAddressTable)
+                    .join(
+                        InterfaceIPAddressTable,
+                        InterfaceIPAddressTable.c.staticipaddress_id
+                        == StaticIPAddressTable.c.id,
+                    )
+                    .join(
+                        InterfaceTable,
+                        InterfaceTable.c.id
+                        == InterfaceIPAddressTable.c.interface_id,
+                    )
+                    .join(
+                        NodeConfigTable,
+                        NodeConfigTable.c.id
+                        == InterfaceTable.c.node_config_id,
+                    )
+                    .filter(
+                        and_(
+                            NodeConfigTable.c.node_id == rack_id,
+                            StaticIPAddressTable.c.ip == record.data,
+                        )
+                    )
+                )
+            ).scalar()
+            if ip_id is None:
+                return
+            resource_id = await _get_or_create_resource(tx, domain_id, name)
+            await tx.execute(
+                insert(DNSResourceIPAddressTable).values(
+                    dnsresource_id=resource_id, staticipaddress_id=ip_id
+                )
+            )
+            return
+
+        resource_id = await _get_or_create_resource(tx, domain_id, name)
+        if record.type != "SRV":
+            # Other records of services are the same for all racks
+            published = (
+                await tx.execute(
+                    select(
+                        exists().where(
+                            and_(
+                                DNSDataTable.c.dnsresource_id == resource_id,
+                                DNSDataTable.c.rrtype == record.type,
+                                DNSDataTable.c.rrdata == record.data,
+                            )
+                        )
+                    )
+                )
+            ).scalar()
+            if published:
+                return
+        now = utcnow()
+        await tx.execute(
+            insert(DNSDataTable).values(
+                created=now,
+                updated=now,
+                dnsresource_id=resource_id,
+                ttl=record.ttl,
+                rrtype=record.type,
+                rrdata=record.data,
+            )
+        )
+
+    async def _remove_orphans(
+        self, tx: AsyncConnection, domain_id: int, host: str
+    ) -> None:
+        # Only resources of the host and of services, named after owners of
+        # SRV records (e.g. "_syslog._udp"), are managed by Agents
+        services = and_(
+            DNSResourceTable.c.domain_id == domain_id,
+            DNSResourceTable.c.name.startswith("_", autoescape=True),
+        )
+        await tx.execute(
+            delete(DNSDataTable).where(
+                and_(
+                    DNSDataTable.c.dnsresource_id.in_(
+                        select(DNSResourceTable.c.id).filter(services)
+                    ),
+                    DNSDataTable.c.dnsresource_id.not_in(
+                        select(DNSDataTable.c.dnsresource_id).filter(
+                            DNSDataTable.c.rrtype == "SRV"
+                        )
+                    ),
+                )
+            )
+        )
+        await tx.execute(
+            delete(DNSResourceTable).where(
+                and_(
+                    or_(
+                        services,
+                        and_(
+                            DNSResourceTable.c.domain_id == domain_id,
+                            DNSResourceTable.c.name == host,
+                        ),
+                    ),
+                    DNSResourceTable.c.id.not_in(
+                        select(DNSDataTable.c.dnsresource_id)
+                    ),
+                    DNSResourceTable.c.id.not_in(
+                        select(DNSResourceIPAddressTable.c.dnsresource_id)
+                    ),
+                )
+            )
+        )
@@ -0,0 +1,181 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+"""
+Activities of Agent workflows booting machines into the ephemeral
+environment, e.g. to burn them in or to capture their image.
+
+Before the machine is booted, its status and netboot flag are kept as node
+metadata, along with keys of the metadata used by the workflow, so that the
+machine gets them back once the workflow is done.
+"""
+
+from dataclasses import dataclass
+import json
+from typing import Any
+
+from sqlalchemy import and_, delete, select, update
+from sqlalchemy.dialects.postgresql import insert as pg_insert
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.exceptions import ApplicationError
+
+from maascommon.enums.node import NodeStatus
+from maasservicelayer.db.tables import NodeMetadataTable, NodeTable
+from maasservicelayer.utils.date import utcnow
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.utils import activity_defn_with_context
+
+# Activities names
+# Executed on the Region by the Agent burn-in-machine and
+# capture-machine-image workflows
+RESTORE_MACHINE_BOOT_ACTIVITY_NAME = "restore-machine-boot"
+
+# NodeMetadata key of the boot state to restore
+EPHEMERAL_BOOT_KEY = "ephemeral_boot"
+
+# Error types of the activities
+UNKNOWN_MACHINE_ERROR = "UNKNOWN_MACHINE"
+
+
+# Activities parameters
+@dataclass
+class MachineParam:
+    # system_id of the machine
+    system_id: str
+
+
+async def get_machine_id(tx: AsyncConnection, system_id: str) -> int:
+    node_id = (
+        await tx.execute(
+            select(NodeTable.c.id)
+            .select_from(NodeTable)
+            .filter(NodeTable.c.system_id == system_id)
+        )
+    ).scalar_one_or_none()
+    if node_id is None:
+        raise ApplicationError(
+            f"Machine {system_id} not found",
+            type=UNKNOWN_MACHINE_ERROR,
+            non_retryable=True,
+        )
+    return node_id
+
+
+async def get_metadata(
+    tx: AsyncConnection, node_id: int, key: str
+) -> str | None:
+    return (
+        await tx.execute(
+            select(NodeMetadataTable.c.value).filter(
+                and_(
+                    NodeMetadataTable.c.node_id == node_id,
+                    NodeMetadataTable.c.key == key,
+                )
+            )
+        )
+    ).scalar_one_or_none()
+
+
+async def set_metadata(
+    tx: AsyncConnection, node_id: int, key: str, value: str
+) -> None:
+    now = utcnow()
+    stmt = pg_insert(NodeMetadataTable).values(
+        created=now, updated=now, node_id=node_id, key=key, value=value
+    )
+    await tx.execute(
+        stmt.on_conflict_do_update(
+            index_elements=[
+                NodeMetadataTable.c.node_id,
+                NodeMetadataTable.c.key,
+            ],
+            set_={
+                "updated": stmt.excluded.updated,
+                "value": stmt.excluded.value,
+            },
+        )
+    )
+
+
+async def delete_metadata(
+    tx: AsyncConnection, node_id: int, keys: list[str]
+) -> None:
+    await tx.execute(
+        delete(NodeMetadataTable).where(
+            and_(
+                NodeMetadataTable.c.node_id == node_id,
+                NodeMetadataTable.c.key.in_(keys),
+            )
+        )
+    )
+
+
+async def get_ephemeral_boot(
+    tx: AsyncConnection, node_id: int
+) -> dict[str, Any] | None:
+    value = await get_metadata(tx, node_id, EPHEMERAL_BOOT_KEY)
+    return json.loads(value) if value is not None else None
+
+
+async def set_ephemeral_boot(
+    tx: AsyncConnection, node_id: int, boot: dict[str, Any]
+) -> None:
+    await set_metadata(tx, node_id, EPHEMERAL_BOOT_KEY, json.dumps(boot))
+
+
+async def boot_ephemeral(
+    tx: AsyncConnection, node_id: int, status: NodeStatus, keys: list[str]
+) -> None:
+    """
+    Make the machine netboot the ephemeral environment with `status`, which
+    is expected to be one of the commissioning-like statuses. `keys` of the
+    node metadata are removed once the boot is restored.
+    """
+    boot = await get_ephemeral_boot(tx, node_id)
+    # A retried activity must not take the ephemeral boot as the one
+    # to restore
+    if boot is None:
+        status_before, netboot_before = (
+            await tx.execute(
+                select(NodeTable.c.status, NodeTable.c.netboot).filter(
+                    NodeTable.c.id == node_id
+                )
+            )
+        ).one()
+        boot = {"status": status_before, "netboot": netboot_before}
+    boot["keys"] = sorted(set(boot.get("keys", [])) | set(keys))
+    await set_ephemeral_boot(tx, node_id, boot)
+
+    await tx.execute(
+        update(NodeTable)
+        .where(NodeTable.c.id == node_id)
+        .values(status=status, netboot=True, updated=utcnow())
+    )
+
+
+class EphemeralBootActivity(ActivityBase):
+    @activity_defn_with_context(name=RESTORE_MACHINE_BOOT_ACTIVITY_NAME)
+    async def restore_machine_boot(self, param: MachineParam) -> None:
+        """
+        Give the machine back the status and netboot flag it had before it
+        was booted into the ephemeral environment.
+        """
+        async with self._start_transaction() as tx:
+            node_id = await get_machine_id(tx, param.system_id)
+            boot = await get_ephemeral_boot(tx, node_id)
+            # Cleanup is retried by Agents, so there may be nothing left
+            if boot is None:
+                return
+
+            await tx.execute(
+                update(NodeTable)
+                .where(NodeTable.c.id == node_id)
+                .values(
+                    status=boot["status"],
+                    netboot=boot["netboot"],
+                    updated=utcnow(),
+                )
+            )
+            await delete_metadata(
+                tx, node_id, [EPHEMERAL_BOOT_KEY, *boot.get("keys", [])]
+            )
@@ -0,0 +1,104 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from dataclasses import dataclass
+import json
+
+from maascommon.enums.events import EventTypeEnum
+from maascommon.enums.node import NodeStatus
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.ephemeral import (
+    boot_ephemeral,
+    get_machine_id,
+    get_metadata,
+    set_metadata,
+)
+from maastemporalworker.workflow.utils import activity_defn_with_context
+
+# Activities names
+# Executed on the Region by the Agent capture-machine-image workflow
+SET_MACHINE_EPHEMERAL_CAPTURE_ACTIVITY_NAME = "set-machine-ephemeral-capture"
+REGISTER_CAPTURED_IMAGE_ACTIVITY_NAME = "register-captured-image"
+
+# NodeMetadata keys of the disk streamed by the ephemeral environment and of
+# the signed path on the Agent it is uploaded to
+IMAGE_CAPTURE_DISK_KEY = "image_capture_disk"
+IMAGE_CAPTURE_UPLOAD_PATH_KEY = "image_capture_upload_path"
+
+# NodeMetadata key of the images captured from the machine, by name. Images
+# are kept in the artifact store of the Agent that captured them.
+CAPTURED_IMAGES_KEY = "captured_images"
+
+
+# Activities parameters
+@dataclass
+class EphemeralCaptureParam:
+    # system_id of the machine
+    system_id: str
+    # disk to capture, e.g. "/dev/sda"
+    disk: str
+    # path and query of the signed upload URL on the Agent HTTP socket
+    upload_path: str
+
+
+@dataclass
+class CapturedImageParam:
+    # system_id of the machine
+    system_id: str
+    name: str
+    # key of the image in the artifact store of the Agent
+    key: str
+    sha256: str
+    size: int
+
+
+class ImageCaptureActivity(ActivityBase):
+    @activity_defn_with_context(
+        name=SET_MACHINE_EPHEMERAL_CAPTURE_ACTIVITY_NAME
+    )
+    async def set_machine_ephemeral_capture(
+        self, param: EphemeralCaptureParam
+    ) -> None:
+        """
+        Make the machine boot the ephemeral environment in rescue mode,
+        which uploads `param.disk` to the Agent.
+        """
+        async with self._start_transaction() as tx:
+            node_id = await get_machine_id(tx, param.system_id)
+            await set_metadata(tx, node_id, IMAGE_CAPTURE_DISK_KEY, param.disk)
+            await set_metadata(
+                tx, node_id, IMAGE_CAPTURE_UPLOAD_PATH_KEY, param.upload_path
+            )
+            await boot_ephemeral(
+                tx,
+                node_id,
+                NodeStatus.RESCUE_MODE,
+                [IMAGE_CAPTURE_DISK_KEY, IMAGE_CAPTURE_UPLOAD_PATH_KEY],
+            )
+
+    @activity_defn_with_context(name=REGISTER_CAPTURED_IMAGE_ACTIVITY_NAME)
+    async def register_captured_image(self, param: CapturedImageParam) -> None:
+        """
+        Record the image among the ones captured from the machine, replacing
+        any previous capture with the same name.
+        """
+        async with self._start_transaction() as tx:
+            node_id = await get_machine_id(tx, param.system_id)
+            images = json.loads(
+                await get_metadata(tx, node_id, CAPTURED_IMAGES_KEY) or "{}"
+            )
+            images[param.name] = {
+                "key": param.key,
+                "sha256": param.sha256,
+                "size": param.size,
+            }
+            await set_metadata(
+                tx, node_id, CAPTURED_IMAGES_KEY, json.dumps(images)
+            )
+
+        async with self.start_transaction() as services:
+            await services.events.record_node_event(
+                param.system_id,
+                EventTypeEnum.NODE_IMAGE_CAPTURED,
+                f"{param.name} ({param.size} bytes)",
+            )
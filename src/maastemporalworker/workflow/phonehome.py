@@ -0,0 +1,78 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from dataclasses import dataclass
+
+from sqlalchemy import select
+from sqlalchemy.dialects.postgresql import insert as pg_insert
+from temporalio.exceptions import ApplicationError
+
+from maasservicelayer.db.tables import NodeMetadataTable, NodeTable
+from maasservicelayer.utils.date import utcnow
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.utils import activity_defn_with_context
+
+# Activities names
+# Executed on the Region by the Agent wait-for-first-boot workflow
+SET_MACHINE_PHONE_HOME_ACTIVITY_NAME = "set-machine-phone-home"
+
+# NodeMetadata key of the phone_home callback rendered into vendor-data
+PHONE_HOME_CALLBACK_PATH_KEY = "phone_home_callback_path"
+
+# Error types of the activities
+UNKNOWN_MACHINE_ERROR = "UNKNOWN_MACHINE"
+
+
+# Activities parameters
+@dataclass
+class SetMachinePhoneHomeParam:
+    # system_id of the machine
+    system_id: str
+    # path and query of the signed phone_home URL on the Agent
+    callback_path: str
+
+
+class PhoneHomeActivity(ActivityBase):
+    @activity_defn_with_context(name=SET_MACHINE_PHONE_HOME_ACTIVITY_NAME)
+    async def set_machine_phone_home(
+        self, param: SetMachinePhoneHomeParam
+    ) -> None:
+        """
+        Keep the phone_home callback of the machine, so that cloud-init
+        reports its first boot to the Agent waiting for it.
+        """
+        async with self._start_transaction() as tx:
+            node_id = (
+                await tx.execute(
+                    select(NodeTable.c.id)
+                    .select_from(NodeTable)
+                    .filter(NodeTable.c.system_id == param.system_id)
+                )
+            ).scalar_one_or_none()
+            if node_id is None:
+                raise ApplicationError(
+                    f"Machine {param.system_id} not found",
+                    type=UNKNOWN_MACHINE_ERROR,
+                    non_retryable=True,
+                )
+
+            now = utcnow()
+            stmt = pg_insert(NodeMetadataTable).values(
+                created=now,
+                updated=now,
+                node_id=node_id,
+                key=PHONE_HOME_CALLBACK_PATH_KEY,
+                value=param.callback_path,
+            )
+            await tx.execute(
+                stmt.on_conflict_do_update(
+                    index_elements=[
+                        NodeMetadataTable.c.node_id,
+                        NodeMetadataTable.c.key,
+                    ],
+                    set_={
+                        "updated": stmt.excluded.updated,
+                        "value": stmt.excluded.value,
+                    },
+                )
+            )
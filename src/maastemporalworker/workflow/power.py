@@ -1,37 +1,102 @@
 #  Copyright 2024 Canonical Ltd.  This software is licensed under the
 #  GNU Affero General Public License version 3 (see the file LICENSE).
 
-from dataclasses import dataclass
+import asyncio
+from dataclasses import dataclass, replace
 from datetime import timedelta
+import json
 from typing import Any, Optional
 import uuid
 
+from sqlalchemy import and_, or_, select, true
+from sqlalchemy.ext.asyncio import AsyncConnection
+import structlog
 from temporalio import workflow
 from temporalio.common import RetryPolicy
+from temporalio.exceptions import ActivityError, ApplicationError
 
+from maascommon.enums.node import NodeStatus, NodeTypeEnum
+from maascommon.workflows.configure import (
+    AGENT_ROLE_POWER,
+    AGENT_ROLES_METADATA_KEY,
+)
 from maascommon.workflows.power import (
+    get_boot_mode,
     POWER_CYCLE_WORKFLOW_NAME,
     POWER_MANY_WORKFLOW_NAME,
     POWER_OFF_WORKFLOW_NAME,
+    POWER_ON_TEMPORARILY_RELEASE_SIGNAL,
+    POWER_ON_TEMPORARILY_WORKFLOW_NAME,
     POWER_ON_WORKFLOW_NAME,
     POWER_QUERY_WORKFLOW_NAME,
+    POWER_RESET_WORKFLOW_NAME,
     PowerAction,
     PowerCycleParam,
     PowerManyParam,
     PowerOffParam,
     PowerOnParam,
+    PowerOnTemporarilyParam,
+    PowerParam,
     PowerQueryParam,
+    PowerResetParam,
+    SET_BOOT_DEVICE_WORKFLOW_NAME,
+    SetBootDeviceParam,
 )
 from maasserver.workflow.worker.worker import REGION_TASK_QUEUE
+from maasservicelayer.db.repositories.nodes import NodeResourceBuilder
+from maasservicelayer.db.tables import (
+    BMCRoutableRackControllerRelationshipTable,
+    BMCTable,
+    NodeTable,
+    StaticIPAddressTable,
+    SubnetTable,
+    VlanTable,
+)
+from maasservicelayer.exceptions.catalog import NotFoundException
+from maasservicelayer.utils.date import utcnow
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.utils import activity_defn_with_context
+from provisioningserver.enum import POWER_STATE
+
+logger = structlog.getLogger()
 
 # Maximum power activity duration (to cope with broken BMCs)
 POWER_ACTION_ACTIVITY_TIMEOUT = timedelta(minutes=5)
 
+# Interval between power queries verifying emulated power cycle
+POWER_CYCLE_EMULATION_POLL_INTERVAL = timedelta(seconds=5)
+
+# Error codes reported by the Agent as type of application errors
+POWER_UNSUPPORTED_ERROR = "POWER_UNSUPPORTED"
+POWER_WRONG_STATE_ERROR = "POWER_WRONG_STATE"
+
 # Activities names
 POWER_ON_ACTIVITY_NAME = "power-on"
 POWER_OFF_ACTIVITY_NAME = "power-off"
 POWER_CYCLE_ACTIVITY_NAME = "power-cycle"
 POWER_QUERY_ACTIVITY_NAME = "power-query"
+POWER_RESET_ACTIVITY_NAME = "power-reset"
+SET_BOOT_DEVICE_ACTIVITY_NAME = "set-boot-device"
+
+# Executed on the Region by the Agent reconcile-power-states workflow
+GET_TRANSITIONING_MACHINES_ACTIVITY_NAME = "get-transitioning-machines"
+REPORT_POWER_STATES_ACTIVITY_NAME = "report-power-states"
+
+# Power state machines are expected to end up in, by their status. Machines
+# with other statuses are not in a power transition.
+EXPECTED_POWER_STATES = {
+    NodeStatus.COMMISSIONING: POWER_STATE.ON,
+    NodeStatus.DEPLOYING: POWER_STATE.ON,
+    NodeStatus.DISK_ERASING: POWER_STATE.ON,
+    NodeStatus.ENTERING_RESCUE_MODE: POWER_STATE.ON,
+    NodeStatus.TESTING: POWER_STATE.ON,
+    NodeStatus.RELEASING: POWER_STATE.OFF,
+}
+
+# Power states the Agent reports for queried machines
+REPORTED_POWER_STATES = frozenset(
+    {POWER_STATE.ON, POWER_STATE.OFF, POWER_STATE.UNKNOWN}
+)
 
 
 # Activities parameters
@@ -62,6 +127,15 @@ class PowerCycleResult:
     state: str
 
 
+@dataclass
+class PowerResetResult:
+    """
+    Result returned by PowerReset workflow
+    """
+
+    state: str
+
+
 @dataclass
 class PowerQueryResult:
     """
@@ -71,6 +145,251 @@ class PowerQueryResult:
     state: str
 
 
+@dataclass
+class GetTransitioningMachinesParam:
+    # system_id of the Agent
+    system_id: str
+
+
+@dataclass
+class TransitioningMachine:
+    """
+    Machine the Region believes to be in a power transition, with the
+    power parameters the Agent queries its power state with.
+    """
+
+    system_id: str
+    expected_state: str
+    driver_type: str
+    driver_opts: dict[str, Any]
+    boot_mode: Optional[str] = None
+
+
+@dataclass
+class GetTransitioningMachinesResult:
+    machines: list[TransitioningMachine]
+
+
+@dataclass
+class PowerStateReport:
+    system_id: str
+    expected_state: str
+    actual_state: Optional[str] = None
+    error: Optional[str] = None
+    error_code: Optional[str] = None
+    # the Agent keeps watching the machine and reports its final state
+    # separately
+    watched: bool = False
+
+
+@dataclass
+class ReportPowerStatesParam:
+    # system_id of the Agent
+    system_id: str
+    reports: list[PowerStateReport]
+
+
+def _correlated(param: PowerParam) -> PowerParam:
+    """
+    Return `param` with the correlation ID of the power action set. It is
+    the ID of the workflow, unless the caller of the workflow set one.
+    """
+    if param.correlation_id:
+        return param
+    return replace(param, correlation_id=workflow.info().workflow_id)
+
+
+def _power_activity_param(param: PowerParam) -> dict[str, Any]:
+    """
+    Return the parameter of power activities of the Agent for `param`.
+    """
+    param = _correlated(param)
+    return {
+        "driver_type": param.driver_type,
+        "driver_opts": param.driver_opts,
+        "boot_mode": param.boot_mode,
+        "requester": param.requester,
+        "reason": param.reason,
+        "correlation_id": param.correlation_id,
+    }
+
+
+def _effective_power_parameters(
+    system_id: str, power_type: str, power_parameters: dict[str, Any]
+) -> dict[str, Any]:
+    """
+    Return `power_parameters` with defaults, like
+    Node.get_effective_power_parameters does.
+    """
+    power_parameters = dict(power_parameters)
+    power_parameters.setdefault("system_id", system_id)
+    if power_type == "virsh":
+        power_parameters.setdefault("power_address", "qemu://localhost/system")
+        power_parameters.setdefault("power_id", system_id)
+    if power_type == "ipmi":
+        power_parameters.setdefault("power_off_mode", "")
+    return power_parameters
+
+
+class PowerActivity(ActivityBase):
+    async def _get_bmcs_of_agent(
+        self, tx: AsyncConnection, system_id: str
+    ) -> set[int]:
+        """
+        Return IDs of BMCs the Agent can reach, either on a VLAN it serves
+        or by routing.
+        """
+        rack_stmt = (
+            select(NodeTable.c.id)
+            .select_from(NodeTable)
+            .filter(NodeTable.c.system_id == system_id)
+        )
+        rack_id = (await tx.execute(rack_stmt)).scalar_one_or_none()
+        if rack_id is None:
+            return set()
+
+        connected_stmt = (
+            select(BMCTable.c.id)
+            .select_from(BMCTable)
+            .join(
+                StaticIPAddressTable,
+                StaticIPAddressTable.c.id == BMCTable.c.ip_address_id,
+            )
+            .join(
+                SubnetTable,
+                SubnetTable.c.id == StaticIPAddressTable.c.subnet_id,
+            )
+            .join(
+                VlanTable,
+                VlanTable.c.id == SubnetTable.c.vlan_id,
+            )
+            .filter(
+                or_(
+                    VlanTable.c.primary_rack_id == rack_id,
+                    VlanTable.c.secondary_rack_id == rack_id,
+                ),
+            )
+        )
+        routes = BMCRoutableRackControllerRelationshipTable
+        routable_stmt = (
+            select(routes.c.bmc_id)
+            .select_from(routes)
+            .filter(
+                and_(
+                    routes.c.rack_controller_id == rack_id,
+                    routes.c.routable == true(),
+                ),
+            )
+        )
+        result = await tx.execute(connected_stmt.union(routable_stmt))
+        return {r[0] for r in result.all()}
+
+    @activity_defn_with_context(name=GET_TRANSITIONING_MACHINES_ACTIVITY_NAME)
+    async def get_transitioning_machines(
+        self, param: GetTransitioningMachinesParam
+    ) -> GetTransitioningMachinesResult:
+        async with self._start_transaction() as tx:
+            bmc_ids = await self._get_bmcs_of_agent(tx, param.system_id)
+            if not bmc_ids:
+                return GetTransitioningMachinesResult(machines=[])
+
+            stmt = (
+                select(
+                    NodeTable.c.id,
+                    NodeTable.c.system_id,
+                    NodeTable.c.status,
+                    NodeTable.c.power_state,
+                    NodeTable.c.bios_boot_method,
+                    NodeTable.c.instance_power_parameters,
+                    BMCTable.c.id.label("bmc_id"),
+                    BMCTable.c.power_type,
+                    BMCTable.c.power_parameters,
+                )
+                .select_from(NodeTable)
+                .join(BMCTable, BMCTable.c.id == NodeTable.c.bmc_id)
+                .filter(
+                    and_(
+                        NodeTable.c.node_type == NodeTypeEnum.MACHINE,
+                        NodeTable.c.status.in_(list(EXPECTED_POWER_STATES)),
+                        BMCTable.c.id.in_(bmc_ids),
+                        BMCTable.c.power_type != "manual",
+                    ),
+                )
+                .order_by(NodeTable.c.id)
+            )
+            rows = (await tx.execute(stmt)).all()
+
+        machines = []
+        async with self.start_transaction() as services:
+            for row in rows:
+                expected_state = EXPECTED_POWER_STATES[row.status]
+                if row.power_state == expected_state:
+                    continue
+                power_parameters = {
+                    **row.power_parameters,
+                    **await services.secrets.get_composite_secret(
+                        f"bmc/{row.bmc_id}/power-parameters", default={}
+                    ),
+                    **row.instance_power_parameters,
+                    **await services.secrets.get_composite_secret(
+                        f"node/{row.id}/power-parameters", default={}
+                    ),
+                }
+                machines.append(
+                    TransitioningMachine(
+                        system_id=row.system_id,
+                        expected_state=expected_state,
+                        driver_type=row.power_type,
+                        driver_opts=_effective_power_parameters(
+                            row.system_id, row.power_type, power_parameters
+                        ),
+                        boot_mode=get_boot_mode(row.bios_boot_method),
+                    )
+                )
+        return GetTransitioningMachinesResult(machines=machines)
+
+    @activity_defn_with_context(name=REPORT_POWER_STATES_ACTIVITY_NAME)
+    async def report_power_states(self, param: ReportPowerStatesParam) -> None:
+        now = utcnow()
+        async with self.start_transaction() as services:
+            for report in param.reports:
+                if report.error:
+                    logger.warning(
+                        f"Agent {param.system_id} failed to query power "
+                        f"state of {report.system_id}: {report.error}"
+                    )
+                    # a machine still watched may come back, its final
+                    # state is reported once the transition ends
+                    if report.watched:
+                        continue
+                    power_state = POWER_STATE.ERROR
+                elif report.actual_state in REPORTED_POWER_STATES:
+                    power_state = report.actual_state
+                else:
+                    continue
+
+                discrepancy = power_state != report.expected_state
+                if discrepancy and not report.watched:
+                    logger.warning(
+                        f"Machine {report.system_id} is {power_state}, "
+                        f"expected to be {report.expected_state}"
+                    )
+
+                resource = (
+                    NodeResourceBuilder()
+                    .with_power_state(power_state)
+                    .with_power_state_updated(now)
+                    .build()
+                )
+                try:
+                    await services.nodes.update_by_system_id(
+                        system_id=report.system_id, resource=resource
+                    )
+                except NotFoundException:
+                    # the machine was deleted in the meantime
+                    pass
+
+
 @workflow.defn(name=POWER_ON_WORKFLOW_NAME, sandboxed=False)
 class PowerOnWorkflow:
     """
@@ -83,10 +402,7 @@ class PowerOnWorkflow:
     async def run(self, param: PowerOnParam) -> PowerOnResult:
         result = await workflow.execute_activity(
             POWER_ON_ACTIVITY_NAME,
-            {
-                "driver_type": param.driver_type,
-                "driver_opts": param.driver_opts,
-            },
+            _power_activity_param(param),
             task_queue=param.task_queue,
             retry_policy=RetryPolicy(maximum_attempts=3),
             start_to_close_timeout=POWER_ACTION_ACTIVITY_TIMEOUT,
@@ -107,10 +423,7 @@ class PowerOffWorkflow:
     async def run(self, param: PowerOffParam) -> PowerOffResult:
         result = await workflow.execute_activity(
             POWER_OFF_ACTIVITY_NAME,
-            {
-                "driver_type": param.driver_type,
-                "driver_opts": param.driver_opts,
-            },
+            _power_activity_param(param),
             task_queue=param.task_queue,
             retry_policy=RetryPolicy(maximum_attempts=3),
             start_to_close_timeout=POWER_ACTION_ACTIVITY_TIMEOUT,
@@ -119,22 +432,178 @@ class PowerOffWorkflow:
         return result
 
 
+def _is_power_unsupported(error: ActivityError) -> bool:
+    return (
+        isinstance(error.cause, ApplicationError)
+        and error.cause.type == POWER_UNSUPPORTED_ERROR
+    )
+
+
+async def _wait_power_state(
+    param: PowerCycleParam,
+    state: str,
+    start_to_close_timeout: timedelta,
+    retry_policy: RetryPolicy,
+) -> None:
+    deadline = workflow.now() + timedelta(
+        seconds=param.emulation_verify_timeout
+    )
+
+    while True:
+        result = await workflow.execute_activity(
+            POWER_QUERY_ACTIVITY_NAME,
+            _power_activity_param(param),
+            task_queue=param.task_queue,
+            retry_policy=retry_policy,
+            start_to_close_timeout=start_to_close_timeout,
+        )
+
+        if result["state"] == state:
+            return
+
+        if workflow.now() >= deadline:
+            raise ApplicationError(
+                f"{param.system_id} is {result['state']} instead of {state}",
+                type=POWER_WRONG_STATE_ERROR,
+                non_retryable=True,
+            )
+
+        await asyncio.sleep(POWER_CYCLE_EMULATION_POLL_INTERVAL.total_seconds())
+
+
+async def power_cycle(
+    param: PowerCycleParam,
+    start_to_close_timeout: timedelta = POWER_ACTION_ACTIVITY_TIMEOUT,
+    retry_policy: RetryPolicy = RetryPolicy(maximum_attempts=3),
+) -> dict[str, Any]:
+    """
+    Power cycle the machine. If its driver can't cycle power, the machine
+    is powered off and on instead, and its power state is verified after
+    each step, unless emulation is disabled in `param`.
+    """
+    param = _correlated(param)
+    try:
+        return await workflow.execute_activity(
+            POWER_CYCLE_ACTIVITY_NAME,
+            _power_activity_param(param),
+            task_queue=param.task_queue,
+            retry_policy=replace(
+                retry_policy,
+                non_retryable_error_types=[
+                    *(retry_policy.non_retryable_error_types or []),
+                    POWER_UNSUPPORTED_ERROR,
+                ],
+            ),
+            start_to_close_timeout=start_to_close_timeout,
+        )
+    except ActivityError as e:
+        if not param.emulate or not _is_power_unsupported(e):
+            raise
+
+    workflow.logger.info(
+        f"{param.driver_type} driver can't cycle power of {param.system_id}, "
+        "powering it off and on"
+    )
+
+    await workflow.execute_activity(
+        POWER_OFF_ACTIVITY_NAME,
+        _power_activity_param(param),
+        task_queue=param.task_queue,
+        retry_policy=retry_policy,
+        start_to_close_timeout=start_to_close_timeout,
+    )
+    await _wait_power_state(
+        param, "off", start_to_close_timeout, retry_policy
+    )
+
+    await asyncio.sleep(param.emulation_off_delay)
+
+    await workflow.execute_activity(
+        POWER_ON_ACTIVITY_NAME,
+        _power_activity_param(param),
+        task_queue=param.task_queue,
+        retry_policy=retry_policy,
+        start_to_close_timeout=start_to_close_timeout,
+    )
+    await _wait_power_state(param, "on", start_to_close_timeout, retry_policy)
+
+    return {"state": "on"}
+
+
 @workflow.defn(name=POWER_CYCLE_WORKFLOW_NAME, sandboxed=False)
 class PowerCycleWorkflow:
     """
     PowerCycleWorkflow is executed by the Region Controller itself.
+    Drivers which can't cycle power have it emulated.
     """
 
     # TODO: we can use structlogs from 3.7 once the power workflows are registered only on the maastemporalworker
     # @workflow_run_with_context
     @workflow.run
     async def run(self, param: PowerCycleParam) -> PowerCycleResult:
+        return await power_cycle(param)
+
+
+@workflow.defn(name=POWER_ON_TEMPORARILY_WORKFLOW_NAME, sandboxed=False)
+class PowerOnTemporarilyWorkflow:
+    """
+    PowerOnTemporarilyWorkflow is executed by the Region Controller itself.
+    It powers the machine on, holds it on for the given duration or until
+    the release signal is received, and powers it off again (e.g. for
+    burn-in slots or on-demand lab access). The machine is powered off as
+    well if the workflow is cancelled while it is held on.
+    """
+
+    def __init__(self) -> None:
+        self._released = False
+
+    @workflow.signal(name=POWER_ON_TEMPORARILY_RELEASE_SIGNAL)
+    async def release(self) -> None:
+        self._released = True
+
+    async def _power(self, action: str, param: PowerOnTemporarilyParam):
+        return await workflow.execute_activity(
+            action,
+            _power_activity_param(param),
+            task_queue=param.task_queue,
+            retry_policy=RetryPolicy(maximum_attempts=3),
+            start_to_close_timeout=POWER_ACTION_ACTIVITY_TIMEOUT,
+        )
+
+    # TODO: we can use structlogs from 3.7 once the power workflows are registered only on the maastemporalworker
+    # @workflow_run_with_context
+    @workflow.run
+    async def run(self, param: PowerOnTemporarilyParam) -> PowerOffResult:
+        await self._power(POWER_ON_ACTIVITY_NAME, param)
+
+        try:
+            await workflow.wait_condition(
+                lambda: self._released,
+                timeout=timedelta(seconds=param.duration),
+            )
+        except asyncio.TimeoutError:
+            pass
+        except asyncio.CancelledError:
+            await self._power(POWER_OFF_ACTIVITY_NAME, param)
+            raise
+
+        return await self._power(POWER_OFF_ACTIVITY_NAME, param)
+
+
+@workflow.defn(name=POWER_RESET_WORKFLOW_NAME, sandboxed=False)
+class PowerResetWorkflow:
+    """
+    PowerResetWorkflow is executed by the Region Controller itself.
+    Unlike PowerCycle, the machine is reset without being powered off.
+    """
+
+    # TODO: we can use structlogs from 3.7 once the power workflows are registered only on the maastemporalworker
+    # @workflow_run_with_context
+    @workflow.run
+    async def run(self, param: PowerResetParam) -> PowerResetResult:
         result = await workflow.execute_activity(
-            POWER_CYCLE_ACTIVITY_NAME,
-            {
-                "driver_type": param.driver_type,
-                "driver_opts": param.driver_opts,
-            },
+            POWER_RESET_ACTIVITY_NAME,
+            _power_activity_param(param),
             task_queue=param.task_queue,
             retry_policy=RetryPolicy(maximum_attempts=3),
             start_to_close_timeout=POWER_ACTION_ACTIVITY_TIMEOUT,
@@ -155,10 +624,7 @@ class PowerQueryWorkflow:
     async def run(self, param: PowerQueryParam) -> PowerQueryResult:
         result = await workflow.execute_activity(
             POWER_QUERY_ACTIVITY_NAME,
-            {
-                "driver_type": param.driver_type,
-                "driver_opts": param.driver_opts,
-            },
+            _power_activity_param(param),
             task_queue=param.task_queue,
             retry_policy=RetryPolicy(maximum_attempts=3),
             start_to_close_timeout=POWER_ACTION_ACTIVITY_TIMEOUT,
@@ -166,6 +632,27 @@ class PowerQueryWorkflow:
         return result
 
 
+@workflow.defn(name=SET_BOOT_DEVICE_WORKFLOW_NAME, sandboxed=False)
+class SetBootDeviceWorkflow:
+    """
+    SetBootDeviceWorkflow is executed by the Region Controller itself.
+    It sets the device the machine boots from next, without changing
+    its power state.
+    """
+
+    # TODO: we can use structlogs from 3.7 once the power workflows are registered only on the maastemporalworker
+    # @workflow_run_with_context
+    @workflow.run
+    async def run(self, param: SetBootDeviceParam) -> None:
+        await workflow.execute_activity(
+            SET_BOOT_DEVICE_ACTIVITY_NAME,
+            {**_power_activity_param(param), "device": param.device},
+            task_queue=param.task_queue,
+            retry_policy=RetryPolicy(maximum_attempts=3),
+            start_to_close_timeout=POWER_ACTION_ACTIVITY_TIMEOUT,
+        )
+
+
 @workflow.defn(name=POWER_MANY_WORKFLOW_NAME, sandboxed=False)
 class PowerManyWorkflow:
     """
@@ -192,6 +679,28 @@ class UnroutablePowerWorkflowException(Exception):
     pass
 
 
+def _with_power_role(racks: list[Any]) -> list[Any]:
+    """
+    Return `racks` whose Agent has the power role enabled, as other Agents
+    don't listen on power task queues.
+    """
+    # Circular imports.
+    from maasserver.models.nodemetadata import NodeMetadata
+
+    roles = {
+        node_id: json.loads(value)
+        for node_id, value in NodeMetadata.objects.filter(
+            node_id__in=[rack.id for rack in racks],
+            key=AGENT_ROLES_METADATA_KEY,
+        ).values_list("node_id", "value")
+    }
+    return [
+        rack
+        for rack in racks
+        if AGENT_ROLE_POWER in roles.get(rack.id, [AGENT_ROLE_POWER])
+    ]
+
+
 def get_temporal_task_queue_for_bmc(machine: Any) -> str:
     bmc_vlan = None
     try:
@@ -201,15 +710,15 @@ def get_temporal_task_queue_for_bmc(machine: Any) -> str:
 
     # Check if there are any rack controllers that are connected to this VLAN.
     # If such rack controllers exist, use vlan specific task queue.
-    if bmc_vlan and bmc_vlan.connected_rack_controllers():
+    if bmc_vlan and _with_power_role(bmc_vlan.connected_rack_controllers()):
         return f"agent:power@vlan-{bmc_vlan.id}"
 
     # Check if there are any rack controllers/agents that have access to
     # the BMC by routing instead of having direct layer 2 access.
     # TODO: check that picked rack/agent has connection to Temporal
     # with_connection=True is a temporary solution that relies on RPC
-    racks = machine.bmc.get_routable_usable_rack_controllers(
-        with_connection=True
+    racks = _with_power_role(
+        machine.bmc.get_routable_usable_rack_controllers(with_connection=True)
     )
     if racks:
         return f"{racks[0].system_id}@agent:power"
@@ -225,7 +734,10 @@ class UnknownPowerActionException(Exception):
 
 # XXX: remove this temporary solution, once we switch to SQLAlchemy
 def convert_power_action_to_power_workflow(
-    power_action: str, machine: Any, extra_params: Optional[Any] = None
+    power_action: str,
+    machine: Any,
+    extra_params: Optional[Any] = None,
+    requester: Optional[str] = None,
 ) -> tuple[str, Any]:
     """
     This function converts power action and power parameters into Power
@@ -233,7 +745,16 @@ def convert_power_action_to_power_workflow(
 
     Power is an 'umbrella' workflow that allows execution of multiple Power
     commands at once.
+
+    The power action is requested by `requester`, and gets a new
+    correlation ID, which the caller can use as the ID of the workflow.
     """
+    request = {
+        "requester": requester,
+        "reason": power_action,
+        "correlation_id": str(uuid.uuid4()),
+    }
+
 
     match power_action:
         case PowerAction.POWER_ON.value:
@@ -244,6 +765,8 @@ def convert_power_action_to_power_workflow(
                     task_queue=get_temporal_task_queue_for_bmc(machine),
                     driver_type=extra_params.power_type,
                     driver_opts=extra_params.power_parameters,
+                    boot_mode=get_boot_mode(machine.bios_boot_method),
+                    **request,
                 ),
             )
         case PowerAction.POWER_OFF.value:
@@ -254,6 +777,8 @@ def convert_power_action_to_power_workflow(
                     task_queue=get_temporal_task_queue_for_bmc(machine),
                     driver_type=extra_params.power_type,
                     driver_opts=extra_params.power_parameters,
+                    boot_mode=get_boot_mode(machine.bios_boot_method),
+                    **request,
                 ),
             )
         case PowerAction.POWER_CYCLE.value:
@@ -264,6 +789,20 @@ def convert_power_action_to_power_workflow(
                     task_queue=get_temporal_task_queue_for_bmc(machine),
                     driver_type=extra_params.power_type,
                     driver_opts=extra_params.power_parameters,
+                    boot_mode=get_boot_mode(machine.bios_boot_method),
+                    **request,
+                ),
+            )
+        case PowerAction.POWER_RESET.value:
+            return (
+                power_action,
+                PowerResetParam(
+                    system_id=machine.system_id,
+                    task_queue=get_temporal_task_queue_for_bmc(machine),
+                    driver_type=extra_params.power_type,
+                    driver_opts=extra_params.power_parameters,
+                    boot_mode=get_boot_mode(machine.bios_boot_method),
+                    **request,
                 ),
             )
         case PowerAction.POWER_QUERY.value:
@@ -274,6 +813,8 @@ def convert_power_action_to_power_workflow(
                     task_queue=get_temporal_task_queue_for_bmc(machine),
                     driver_type=extra_params.power_type,
                     driver_opts=extra_params.power_parameters,
+                    boot_mode=get_boot_mode(machine.bios_boot_method),
+                    **request,
                 ),
             )
         case _:
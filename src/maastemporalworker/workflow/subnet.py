@@ -0,0 +1,91 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from dataclasses import dataclass, field
+
+from netaddr import IPNetwork
+from sqlalchemy import or_, select
+
+from maasservicelayer.db.tables import NodeTable, SubnetTable, VlanTable
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.utils import activity_defn_with_context
+
+# Activities names
+# Executed on the Region by the Agent configure-subnet-services workflow
+GET_SUBNET_SERVICES_ACTIVITY_NAME = "get-subnet-services"
+
+# Services that can be set for a subnet in the configuration
+SUBNET_SERVICES = ("images", "proxies", "dns_servers")
+
+
+# Activities parameters
+@dataclass
+class GetSubnetServicesParam:
+    # system_id of the Agent
+    system_id: str
+
+
+@dataclass
+class SubnetServices:
+    subnet: str
+    # Empty lists mean there are no restrictions and defaults are used
+    images: list[str] = field(default_factory=list)
+    proxies: list[str] = field(default_factory=list)
+    dns_servers: list[str] = field(default_factory=list)
+
+
+@dataclass
+class GetSubnetServicesResult:
+    subnets: list[SubnetServices]
+
+
+class SubnetServicesActivity(ActivityBase):
+    @activity_defn_with_context(name=GET_SUBNET_SERVICES_ACTIVITY_NAME)
+    async def get_subnet_services(
+        self, param: GetSubnetServicesParam
+    ) -> GetSubnetServicesResult:
+        """
+        Return services of the subnets on VLANs served by the Agent, with
+        DNS servers of the subnet, merged with the "subnet_services"
+        configuration. Configured subnets are returned even if the Agent
+        doesn't serve them, as it can relay requests of other subnets.
+        """
+        entries = {}
+        async with self._start_transaction() as tx:
+            rack_stmt = (
+                select(NodeTable.c.id)
+                .select_from(NodeTable)
+                .filter(NodeTable.c.system_id == param.system_id)
+            )
+            rack_id = (await tx.execute(rack_stmt)).scalar_one_or_none()
+            if rack_id is not None:
+                stmt = (
+                    select(SubnetTable.c.cidr, SubnetTable.c.dns_servers)
+                    .select_from(SubnetTable)
+                    .join(VlanTable, VlanTable.c.id == SubnetTable.c.vlan_id)
+                    .filter(
+                        or_(
+                            VlanTable.c.primary_rack_id == rack_id,
+                            VlanTable.c.secondary_rack_id == rack_id,
+                        ),
+                    )
+                    .order_by(SubnetTable.c.id)
+                )
+                for cidr, dns_servers in (await tx.execute(stmt)).all():
+                    entries[str(cidr)] = SubnetServices(
+                        subnet=str(cidr), dns_servers=list(dns_servers or [])
+                    )
+
+        async with self.start_transaction() as services:
+            configured = (
+                await services.configurations.get("subnet_services") or {}
+            )
+
+        for cidr, configured_services in configured.items():
+            cidr = str(IPNetwork(cidr).cidr)
+            entry = entries.setdefault(cidr, SubnetServices(subnet=cidr))
+            for name in SUBNET_SERVICES:
+                if name in configured_services:
+                    setattr(entry, name, list(configured_services[name]))
+
+        return GetSubnetServicesResult(subnets=list(entries.values()))
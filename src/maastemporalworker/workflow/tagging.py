@@ -0,0 +1,126 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from dataclasses import dataclass
+
+from sqlalchemy import and_, delete, insert, select
+from sqlalchemy.dialects.postgresql import insert as pg_insert
+from temporalio.exceptions import ApplicationError
+
+from maasservicelayer.db.tables import NodeTable, NodeTagTable, TagTable
+from maasservicelayer.utils.date import utcnow
+from maastemporalworker.workflow.activity import ActivityBase
+from maastemporalworker.workflow.utils import activity_defn_with_context
+
+# Activities names
+# Executed on the Region by the Agent tag-machine workflow
+SET_MACHINE_TAGS_ACTIVITY_NAME = "set-machine-tags"
+
+# Tags computed by Agents are told apart from the ones of users by their
+# comment, so that only them are replaced when a machine is tagged again
+AGENT_TAG_COMMENT = "Set by the MAAS Agent from machine resources"
+
+# Error types of the activities
+UNKNOWN_MACHINE_ERROR = "UNKNOWN_MACHINE"
+
+
+# Activities parameters
+@dataclass
+class SetMachineTagsParam:
+    # system_id of the machine
+    system_id: str
+    tags: list[str]
+
+
+class MachineTaggingActivity(ActivityBase):
+    @activity_defn_with_context(name=SET_MACHINE_TAGS_ACTIVITY_NAME)
+    async def set_machine_tags(self, param: SetMachineTagsParam) -> None:
+        """
+        Replace tags previously set by Agents on the machine with
+        `param.tags`. Missing tags are created, while tags of users with the
+        same name are reused and never removed.
+        """
+        async with self._start_transaction() as tx:
+            node_id = (
+                await tx.execute(
+                    select(NodeTable.c.id)
+                    .select_from(NodeTable)
+                    .filter(NodeTable.c.system_id == param.system_id)
+                )
+            ).scalar_one_or_none()
+            if node_id is None:
+                raise ApplicationError(
+                    f"Machine {param.system_id} not found",
+                    type=UNKNOWN_MACHINE_ERROR,
+                    non_retryable=True,
+                )
+
+            names = sorted(set(param.tags))
+            if names:
+                now = utcnow()
+                await tx.execute(
+                    pg_insert(TagTable)
+                    .values(
+                        [
+                            {
+                                "created": now,
+                                "updated": now,
+                                "name": name,
+                                "definition": "",
+                                "comment": AGENT_TAG_COMMENT,
+                                "kernel_opts": "",
+                            }
+                            for name in names
+                        ]
+                    )
+                    .on_conflict_do_nothing(index_elements=[TagTable.c.name])
+                )
+            tag_ids = dict(
+                (
+                    await tx.execute(
+                        select(TagTable.c.name, TagTable.c.id).filter(
+                            TagTable.c.name.in_(names)
+                        )
+                    )
+                ).all()
+            )
+
+            current = dict(
+                (
+                    await tx.execute(
+                        select(NodeTagTable.c.tag_id, TagTable.c.comment)
+                        .select_from(NodeTagTable)
+                        .join(TagTable, TagTable.c.id == NodeTagTable.c.tag_id)
+                        .filter(NodeTagTable.c.node_id == node_id)
+                    )
+                ).all()
+            )
+
+            stale = [
+                tag_id
+                for tag_id, comment in current.items()
+                if comment == AGENT_TAG_COMMENT
+                and tag_id not in tag_ids.values()
+            ]
+            if stale:
+                await tx.execute(
+                    delete(NodeTagTable).where(
+                        and_(
+                            NodeTagTable.c.node_id == node_id,
+                            NodeTagTable.c.tag_id.in_(stale),
+                        )
+                    )
+                )
+
+            missing = [
+                tag_id for tag_id in tag_ids.values() if tag_id not in current
+            ]
+            if missing:
+                await tx.execute(
+                    insert(NodeTagTable).values(
+                        [
+                            {"node_id": node_id, "tag_id": tag_id}
+                            for tag_id in missing
+                        ]
+                    )
+                )
@@ -85,6 +85,10 @@ from maasserver.preseed import (
 from maasserver.preseed_network import NodeNetworkConfiguration
 from maasserver.utils import find_rack_controller
 from maasserver.utils.orm import get_one, is_retryable_failure
+from maastemporalworker.workflow.burnin import (
+    BURN_IN_RESULTS_KEY,
+    merge_burn_in_results,
+)
 from metadataserver import logger
 from metadataserver.enum import (
     SCRIPT_STATUS,
@@ -935,6 +939,39 @@ class VersionIndexHandler(MetadataViewHandler):
         node.set_boot_order(True)
         return rc.ALL_OK
 
+    @operation(idempotent=False)
+    def burn_in_results(self, request, version=None, mac=None):
+        """Report results of burn-in tests run by the ephemeral environment.
+
+        :param results: JSON list of results, each with the name of the test,
+            its metrics, duration and exit status. Results of tests reported
+            before are replaced.
+        """
+        node = get_queried_node(request, for_mac=mac)
+        try:
+            reported = json.loads(get_mandatory_param(request.POST, "results"))
+        except ValueError as e:
+            raise MAASAPIBadRequest(f"Invalid results: {e}")
+        if not isinstance(reported, list) or not all(
+            isinstance(result, dict) and "name" in result
+            for result in reported
+        ):
+            raise MAASAPIBadRequest("Results must be a list of named results")
+
+        metadata = (
+            NodeMetadata.objects.select_for_update()
+            .filter(node=node, key=BURN_IN_RESULTS_KEY)
+            .first()
+        )
+        # Results are only accepted while the burn-in workflow waits for them
+        if metadata is None:
+            raise MAASAPIBadRequest("No burn-in is in progress")
+        metadata.value = json.dumps(
+            merge_burn_in_results(json.loads(metadata.value), reported)
+        )
+        metadata.save()
+        return rc.ALL_OK
+
 
 class EnlistMetaDataHandler(OperationsHandler):
     """this has to handle the 'meta-data' portion of the meta-data api
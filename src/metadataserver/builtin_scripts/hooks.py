@@ -22,6 +22,7 @@ from django.db.models import Q
 from temporalio.common import RetryPolicy
 
 from maascommon.workflows.configure import CONFIGURE_AGENT_WORKFLOW_NAME
+from maascommon.workflows.tag import TAG_MACHINE_WORKFLOW_NAME
 from maasserver.enum import (
     NODE_DEVICE_BUS,
     NODE_METADATA,
@@ -1243,6 +1244,22 @@ def process_lxd_results(node, output, exit_status):
                 execution_timeout=timedelta(seconds=120),
             )
 
+    # Tags of the machine hardware are computed by the Agent it booted from
+    # and set back on the Region.
+    if node.node_type == NODE_TYPE.MACHINE:
+        rack_controller = node.get_boot_rack_controller()
+        if rack_controller is not None:
+            start_workflow(
+                TAG_MACHINE_WORKFLOW_NAME,
+                param={
+                    "system_id": node.system_id,
+                    "resources": data["resources"],
+                },
+                task_queue=f"{rack_controller.system_id}@agent:main",
+                retry_policy=RetryPolicy(maximum_attempts=1),
+                execution_timeout=timedelta(seconds=120),
+            )
+
     for pod in node.get_hosted_pods():
         pod.sync_hints_from_nodes()
 
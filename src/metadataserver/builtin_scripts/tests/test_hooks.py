@@ -3,6 +3,7 @@
 
 
 from copy import deepcopy
+from datetime import timedelta
 import json
 import random
 
@@ -10,7 +11,9 @@ from distro_info import UbuntuDistroInfo
 from django.db.models import Q
 from fixtures import FakeLogger
 from netaddr import IPNetwork
+from temporalio.common import RetryPolicy
 
+from maascommon.workflows.tag import TAG_MACHINE_WORKFLOW_NAME
 from maasserver.enum import (
     FILESYSTEM_TYPE,
     INTERFACE_TYPE,
@@ -3426,6 +3429,36 @@ class TestProcessLXDResults(MAASServerTestCase):
         process_lxd_results(node, json.dumps(lxd_output).encode(), 0)
         mock_start_workflow.assert_not_called()
 
+    def test_machine_triggers_tag_machine_workflow(self):
+        rack = factory.make_RackController()
+        machine = factory.make_Machine()
+        self.patch(machine, "get_boot_rack_controller").return_value = rack
+        mock_start_workflow = self.patch(hooks_module, "start_workflow")
+
+        lxd_output = make_lxd_output()
+
+        process_lxd_results(machine, json.dumps(lxd_output).encode(), 0)
+        mock_start_workflow.assert_called_once_with(
+            TAG_MACHINE_WORKFLOW_NAME,
+            param={
+                "system_id": machine.system_id,
+                "resources": lxd_output["resources"],
+            },
+            task_queue=f"{rack.system_id}@agent:main",
+            retry_policy=RetryPolicy(maximum_attempts=1),
+            execution_timeout=timedelta(seconds=120),
+        )
+
+    def test_machine_without_rack_doesnt_trigger_tag_machine_workflow(self):
+        machine = factory.make_Machine()
+        self.patch(machine, "get_boot_rack_controller").return_value = None
+        mock_start_workflow = self.patch(hooks_module, "start_workflow")
+
+        lxd_output = make_lxd_output()
+
+        process_lxd_results(machine, json.dumps(lxd_output).encode(), 0)
+        mock_start_workflow.assert_not_called()
+
 
 class TestUpdateNodePhysicalBlockDevices(MAASServerTestCase):
     def test_idempotent_block_devices(self):
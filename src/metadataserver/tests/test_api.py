@@ -53,6 +53,7 @@ from maasserver.testing.factory import factory
 from maasserver.testing.testcase import MAASServerTestCase
 from maasserver.testing.testclient import MAASSensibleOAuthClient
 from maasserver.utils.orm import reload_object
+from maastemporalworker.workflow.burnin import BURN_IN_RESULTS_KEY
 from maastesting.utils import sample_binary_data
 from metadataserver import api
 from metadataserver.api import (
@@ -4005,6 +4006,45 @@ class TestNetbootOperationAPI(MAASServerTestCase):
         )
 
 
+class TestBurnInResultsOperationAPI(MAASServerTestCase):
+    def _post_results(self, node, results):
+        client = make_node_client(node=node)
+        url = reverse("metadata-version", args=["latest"])
+        return client.post(
+            url, {"op": "burn_in_results", "results": json.dumps(results)}
+        )
+
+    def test_burn_in_results(self):
+        node = factory.make_Node(status=NODE_STATUS.TESTING)
+        node.nodemetadata_set.create(
+            key=BURN_IN_RESULTS_KEY,
+            value=json.dumps([{"name": "memory", "exit_status": 1}]),
+        )
+        results = [
+            {"name": "memory", "exit_status": 0},
+            {"name": "disk", "exit_status": 0},
+        ]
+        response = self._post_results(node, results)
+        self.assertEqual(http.client.OK, response.status_code, response)
+        metadata = node.nodemetadata_set.get(key=BURN_IN_RESULTS_KEY)
+        self.assertEqual(results, json.loads(metadata.value))
+
+    def test_burn_in_results_without_burn_in(self):
+        node = factory.make_Node(status=NODE_STATUS.TESTING)
+        response = self._post_results(node, [{"name": "memory"}])
+        self.assertEqual(
+            http.client.BAD_REQUEST, response.status_code, response
+        )
+
+    def test_burn_in_results_invalid(self):
+        node = factory.make_Node(status=NODE_STATUS.TESTING)
+        node.nodemetadata_set.create(key=BURN_IN_RESULTS_KEY, value="[]")
+        response = self._post_results(node, {"name": "memory"})
+        self.assertEqual(
+            http.client.BAD_REQUEST, response.status_code, response
+        )
+
+
 class TestAnonymousAPI(MAASServerTestCase):
     def test_anonymous_netboot_off(self):
         node = factory.make_Node(netboot=True, power_type="hmcz")
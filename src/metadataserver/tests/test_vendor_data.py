@@ -1,6 +1,7 @@
 # Copyright 2016-2021 Canonical Ltd.  This software is licensed under the
 # GNU Affero General Public License version 3 (see the file LICENSE).
 
+import json
 import random
 from textwrap import dedent
 
@@ -17,17 +18,29 @@ from maasserver.testing.factory import factory
 from maasserver.testing.fixtures import RBACEnabled
 from maasserver.testing.testcase import MAASServerTestCase
 from maasserver.utils.converters import systemd_interval_to_calendar
+from maastemporalworker.workflow.burnin import BURN_IN_TESTS_KEY
+from maastemporalworker.workflow.imagecapture import (
+    IMAGE_CAPTURE_DISK_KEY,
+    IMAGE_CAPTURE_UPLOAD_PATH_KEY,
+)
+from maastemporalworker.workflow.phonehome import (
+    PHONE_HOME_CALLBACK_PATH_KEY,
+)
 from metadataserver import vendor_data
 from metadataserver.vendor_data import (
     _get_metadataserver_template,
+    BURN_IN_TESTS_PATH,
     DEPLOY_SECRETS_LXD_KEY,
     DEPLOY_SECRETS_VIRSH_KEY,
+    generate_burn_in_configuration,
     generate_ephemeral_deployment_network_configuration,
     generate_ephemeral_netplan_lock_removal,
     generate_hardware_sync_systemd_configuration,
+    generate_image_capture_configuration,
     generate_kvm_pod_configuration,
     generate_ntp_configuration,
     generate_openvswitch_configuration,
+    generate_phone_home_configuration,
     generate_rack_controller_configuration,
     generate_snap_configuration,
     generate_system_info,
@@ -807,6 +820,135 @@ class TestGenerateHardwareSyncSystemdConfiguration(MAASServerTestCase):
         self.assertCountEqual(next(config), expected)
 
 
+class TestGeneratePhoneHomeConfiguration(MAASServerTestCase):
+    def test_returns_phone_home_of_deployed_os(self):
+        node = factory.make_Node(status=NODE_STATUS.DEPLOYING, netboot=False)
+        node.boot_cluster_ip = "10.0.0.1"
+        node.save()
+        node.nodemetadata_set.create(
+            key=PHONE_HOME_CALLBACK_PATH_KEY,
+            value=f"/phone-home/{node.system_id}?signature=abc",
+        )
+        config = generate_phone_home_configuration(node)
+        self.assertEqual(
+            (
+                "phone_home",
+                {
+                    "url": "http://10.0.0.1:5248/phone-home/"
+                    f"{node.system_id}?signature=abc",
+                    "post": "all",
+                    "tries": 10,
+                },
+            ),
+            next(config),
+        )
+
+    def test_returns_nothing_without_callback(self):
+        node = factory.make_Node(status=NODE_STATUS.DEPLOYING, netboot=False)
+        node.boot_cluster_ip = "10.0.0.1"
+        node.save()
+        config = generate_phone_home_configuration(node)
+        self.assertRaises(StopIteration, next, config)
+
+    def test_returns_nothing_for_ephemeral_os(self):
+        node = factory.make_Node(status=NODE_STATUS.DEPLOYING, netboot=True)
+        node.boot_cluster_ip = "10.0.0.1"
+        node.save()
+        node.nodemetadata_set.create(
+            key=PHONE_HOME_CALLBACK_PATH_KEY, value="/phone-home/"
+        )
+        config = generate_phone_home_configuration(node)
+        self.assertRaises(StopIteration, next, config)
+
+    def test_returns_nothing_once_deployed(self):
+        node = factory.make_Node(status=NODE_STATUS.DEPLOYED, netboot=False)
+        node.boot_cluster_ip = "10.0.0.1"
+        node.save()
+        node.nodemetadata_set.create(
+            key=PHONE_HOME_CALLBACK_PATH_KEY, value="/phone-home/"
+        )
+        config = generate_phone_home_configuration(node)
+        self.assertRaises(StopIteration, next, config)
+
+
+class TestGenerateBurnInConfiguration(MAASServerTestCase):
+    def test_returns_burn_in_tests(self):
+        node = factory.make_Node(status=NODE_STATUS.TESTING, netboot=True)
+        tests = json.dumps([{"name": "memory", "tool": "memtest"}])
+        node.nodemetadata_set.create(key=BURN_IN_TESTS_KEY, value=tests)
+        config = generate_burn_in_configuration(node)
+        self.assertEqual(
+            (
+                "write_files",
+                [
+                    {
+                        "content": tests,
+                        "path": BURN_IN_TESTS_PATH,
+                        "permissions": "0644",
+                    }
+                ],
+            ),
+            next(config),
+        )
+
+    def test_returns_nothing_without_burn_in(self):
+        node = factory.make_Node(status=NODE_STATUS.TESTING, netboot=True)
+        config = generate_burn_in_configuration(node)
+        self.assertRaises(StopIteration, next, config)
+
+    def test_returns_nothing_if_not_testing(self):
+        node = factory.make_Node(status=NODE_STATUS.READY, netboot=True)
+        node.nodemetadata_set.create(key=BURN_IN_TESTS_KEY, value="[]")
+        config = generate_burn_in_configuration(node)
+        self.assertRaises(StopIteration, next, config)
+
+
+class TestGenerateImageCaptureConfiguration(MAASServerTestCase):
+    def make_capturing_node(self, status=NODE_STATUS.RESCUE_MODE):
+        node = factory.make_Node(status=status, netboot=True)
+        node.boot_cluster_ip = "10.0.0.1"
+        node.save()
+        node.nodemetadata_set.create(
+            key=IMAGE_CAPTURE_DISK_KEY, value="/dev/sda"
+        )
+        node.nodemetadata_set.create(
+            key=IMAGE_CAPTURE_UPLOAD_PATH_KEY,
+            value=f"/capture/image/{node.system_id}/golden?signature=abc",
+        )
+        return node
+
+    def test_returns_upload_of_disk(self):
+        node = self.make_capturing_node()
+        config = generate_image_capture_configuration(node)
+        self.assertEqual(
+            (
+                "runcmd",
+                [
+                    [
+                        "sh",
+                        "-c",
+                        "dd if=/dev/sda bs=4M | curl --fail --upload-file - "
+                        "'http://10.0.0.1:5248/capture/image/"
+                        f"{node.system_id}/golden?signature=abc'",
+                    ]
+                ],
+            ),
+            next(config),
+        )
+
+    def test_returns_nothing_without_capture(self):
+        node = factory.make_Node(status=NODE_STATUS.RESCUE_MODE, netboot=True)
+        node.boot_cluster_ip = "10.0.0.1"
+        node.save()
+        config = generate_image_capture_configuration(node)
+        self.assertRaises(StopIteration, next, config)
+
+    def test_returns_nothing_if_not_in_rescue_mode(self):
+        node = self.make_capturing_node(status=NODE_STATUS.DEPLOYED)
+        config = generate_image_capture_configuration(node)
+        self.assertRaises(StopIteration, next, config)
+
+
 class TestGetNodeMAASURL(MAASServerTestCase):
     def test_maas_url_uses_boot_rack_controller(self):
         subnet = factory.make_Subnet()
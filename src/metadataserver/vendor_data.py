@@ -9,6 +9,7 @@ from ipaddress import ip_address
 from itertools import chain
 from os import urandom
 import pkgutil
+import shlex
 from textwrap import dedent
 
 from netaddr import IPAddress
@@ -17,7 +18,7 @@ import tempita
 import yaml
 
 from maasserver import ntp
-from maasserver.enum import BRIDGE_TYPE, INTERFACE_TYPE
+from maasserver.enum import BRIDGE_TYPE, INTERFACE_TYPE, NODE_STATUS
 from maasserver.models import Config, NodeKey, NodeMetadata
 from maasserver.models.controllerinfo import get_target_version
 from maasserver.node_status import COMMISSIONING_LIKE_STATUSES
@@ -28,6 +29,14 @@ from maasserver.secrets import SecretManager
 from maasserver.server_address import get_maas_facing_server_host
 from maasserver.utils.certificates import generate_certificate
 from maasserver.utils.converters import systemd_interval_to_calendar
+from maastemporalworker.workflow.burnin import BURN_IN_TESTS_KEY
+from maastemporalworker.workflow.imagecapture import (
+    IMAGE_CAPTURE_DISK_KEY,
+    IMAGE_CAPTURE_UPLOAD_PATH_KEY,
+)
+from maastemporalworker.workflow.phonehome import (
+    PHONE_HOME_CALLBACK_PATH_KEY,
+)
 from provisioningserver.ntp.config import normalise_address
 from provisioningserver.utils.text import make_gecos_field
 
@@ -38,6 +47,8 @@ HARDWARE_SYNC_MACHINE_TOKEN_PATH = "/etc/maas/maas-machine-creds.yml"
 HARDWARE_SYNC_SERVICE_TEMPLATE = "hardware_sync_service.template"
 HARDWARE_SYNC_TIMER_TEMPLATE = "hardware_sync_timer.template"
 
+BURN_IN_TESTS_PATH = "/etc/maas/burn-in-tests.json"
+
 
 def get_vendor_data(node, proxy):
     generators = (
@@ -51,6 +62,9 @@ def get_vendor_data(node, proxy):
         generate_openvswitch_configuration(node),
         generate_vcenter_configuration(node),
         generate_hardware_sync_systemd_configuration(node),
+        generate_phone_home_configuration(node),
+        generate_burn_in_configuration(node),
+        generate_image_capture_configuration(node),
     )
     vendor_data = {}
     for key, value in chain(*generators):
@@ -467,3 +481,74 @@ def generate_hardware_sync_systemd_configuration(node):
 def _generate_password():
     """Generate a 32-character password by encoding 24 bytes as base64."""
     return b64encode(urandom(24), altchars=b".!").decode("ascii")
+
+
+def generate_phone_home_configuration(node):
+    """Generate cloud-init configuration to report the first boot of the
+    deployed OS to the Agent waiting for it."""
+    # Like for the rack controller configuration, 'node.netboot' tells the
+    # deployed OS apart from the ephemeral one installing it.
+    if node.netboot or node.status != NODE_STATUS.DEPLOYING:
+        return
+    if node.boot_cluster_ip is None:
+        return
+    callback = NodeMetadata.objects.filter(
+        node=node, key=PHONE_HOME_CALLBACK_PATH_KEY
+    ).first()
+    if callback is None:
+        return
+    rack_url = get_node_rack_url(node).removesuffix("/MAAS")
+    yield "phone_home", {
+        "url": f"{rack_url}{callback.value}",
+        "post": "all",
+        "tries": 10,
+    }
+
+
+def generate_burn_in_configuration(node):
+    """Generate configuration of the burn-in tests run by the ephemeral
+    environment, which reports their results with the burn_in_results
+    operation of the metadata API."""
+    if not node.netboot or node.status != NODE_STATUS.TESTING:
+        return
+    tests = NodeMetadata.objects.filter(
+        node=node, key=BURN_IN_TESTS_KEY
+    ).first()
+    if tests is None:
+        return
+    yield "write_files", [
+        {
+            "content": tests.value,
+            "path": BURN_IN_TESTS_PATH,
+            "permissions": "0644",
+        }
+    ]
+
+
+def generate_image_capture_configuration(node):
+    """Generate commands uploading the disk of the machine to the Agent
+    capturing its image."""
+    if not node.netboot or node.status != NODE_STATUS.RESCUE_MODE:
+        return
+    if node.boot_cluster_ip is None:
+        return
+    metadata = dict(
+        NodeMetadata.objects.filter(
+            node=node,
+            key__in=[IMAGE_CAPTURE_DISK_KEY, IMAGE_CAPTURE_UPLOAD_PATH_KEY],
+        ).values_list("key", "value")
+    )
+    if len(metadata) != 2:
+        return
+    rack_url = get_node_rack_url(node).removesuffix("/MAAS")
+    disk = shlex.quote(metadata[IMAGE_CAPTURE_DISK_KEY])
+    url = shlex.quote(f"{rack_url}{metadata[IMAGE_CAPTURE_UPLOAD_PATH_KEY]}")
+    # The size of the disk isn't known upfront, so the image is streamed
+    # with a chunked upload.
+    yield "runcmd", [
+        [
+            "sh",
+            "-c",
+            f"dd if={disk} bs=4M | curl --fail --upload-file - {url}",
+        ]
+    ]
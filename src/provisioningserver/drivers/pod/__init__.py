@@ -37,6 +37,14 @@ JSON_POD_DRIVERS_SCHEMA = {
 }
 
 
+# Choices of power_off_mode of VMs. The Agent asks the guest OS of VMs with
+# "soft" power off mode to shut down before they are powered off.
+VM_POWER_OFF_MODE_CHOICES = [
+    ["soft", "Shut down the guest OS first"],
+    ["hard", "Power off"],
+]
+
+
 class PodError(Exception):
     """Base error for all pod driver failure commands."""
 
@@ -40,6 +40,7 @@ from provisioningserver.drivers.pod import (
     InterfaceAttachType,
     PodDriver,
     RequestedMachine,
+    VM_POWER_OFF_MODE_CHOICES,
 )
 from provisioningserver.logger import get_maas_logger
 from provisioningserver.prometheus.metrics import PROMETHEUS_METRICS
@@ -227,6 +228,20 @@ class LXDPodDriver(PodDriver):
             field_type="password",
             secret=True,
         ),
+        make_setting_field(
+            "certificate_fingerprint",
+            "Pinned SHA256 fingerprint of the LXD certificate (optional)",
+            required=False,
+        ),
+        make_setting_field(
+            "power_off_mode",
+            "Power off mode",
+            field_type="choice",
+            choices=VM_POWER_OFF_MODE_CHOICES,
+            default="hard",
+            scope=SETTING_SCOPE.NODE,
+            required=False,
+        ),
     ]
     ip_extractor = make_ip_extractor(
         "power_address", IP_EXTRACTOR_PATTERNS.URL
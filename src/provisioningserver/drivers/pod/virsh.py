@@ -35,6 +35,7 @@ from provisioningserver.drivers.pod import (
     DiscoveredPodStoragePool,
     InterfaceAttachType,
     PodDriver,
+    VM_POWER_OFF_MODE_CHOICES,
 )
 from provisioningserver.enum import LIBVIRT_NETWORK
 from provisioningserver.logger import get_maas_logger
@@ -1290,9 +1291,23 @@ class VirshPodDriver(PodDriver):
             field_type="password",
             secret=True,
         ),
+        make_setting_field(
+            "host_key",
+            "Pinned SSH host key, in authorized_keys format (optional)",
+            required=False,
+        ),
         make_setting_field(
             "power_id", "Virsh VM ID", scope=SETTING_SCOPE.NODE, required=True
         ),
+        make_setting_field(
+            "power_off_mode",
+            "Power off mode",
+            field_type="choice",
+            choices=VM_POWER_OFF_MODE_CHOICES,
+            default="hard",
+            scope=SETTING_SCOPE.NODE,
+            required=False,
+        ),
     ]
     ip_extractor = make_ip_extractor(
         "power_address", IP_EXTRACTOR_PATTERNS.URL
@@ -30,6 +30,15 @@ class HMCState:
     ON = ("Starting", "Running", "Open Firmware")
 
 
+HMC_API_SSH = "ssh"
+HMC_API_REST = "rest"
+
+HMC_API_CHOICES = [
+    [HMC_API_SSH, "SSH (chsysstate)"],
+    [HMC_API_REST, "REST API"],
+]
+
+
 class HMCPowerDriver(PowerDriver):
     name = "hmc"
     chassis = True
@@ -59,6 +68,13 @@ class HMCPowerDriver(PowerDriver):
             scope=SETTING_SCOPE.NODE,
             required=True,
         ),
+        make_setting_field(
+            "hmc_api",
+            "HMC interface used by the rack controller agent",
+            field_type="choice",
+            choices=HMC_API_CHOICES,
+            default=HMC_API_SSH,
+        ),
     ]
     ip_extractor = make_ip_extractor("power_address")
 
@@ -66,6 +66,11 @@ class ProxmoxPowerDriver(WebhookPowerDriver):
             choices=SSL_INSECURE_CHOICES,
             default=SSL_INSECURE_NO,
         ),
+        make_setting_field(
+            "certificate_fingerprint",
+            "Pinned SHA256 fingerprint of the Proxmox certificate (optional)",
+            required=False,
+        ),
     ]
 
     ip_extractor = make_ip_extractor(
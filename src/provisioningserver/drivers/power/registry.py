@@ -26,6 +26,7 @@ from provisioningserver.drivers.power.raritan import RaritanPowerDriver
 from provisioningserver.drivers.power.recs import RECSPowerDriver
 from provisioningserver.drivers.power.redfish import RedfishPowerDriver
 from provisioningserver.drivers.power.seamicro import SeaMicroPowerDriver
+from provisioningserver.drivers.power.servertech import ServerTechPowerDriver
 from provisioningserver.drivers.power.ucsm import UCSMPowerDriver
 from provisioningserver.drivers.power.vmware import VMwarePowerDriver
 from provisioningserver.drivers.power.webhook import WebhookPowerDriver
@@ -70,6 +71,7 @@ power_drivers = [
     RECSPowerDriver(),
     RedfishPowerDriver(),
     SeaMicroPowerDriver(),
+    ServerTechPowerDriver(),
     UCSMPowerDriver(),
     VMwarePowerDriver(),
     WebhookPowerDriver(),
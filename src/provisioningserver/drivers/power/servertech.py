@@ -0,0 +1,158 @@
+# Copyright 2026 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+"""ServerTech PDU Power Driver.
+
+Support for managing ServerTech Sentry PDU outlets via SNMP.
+"""
+
+
+import re
+from time import sleep
+
+from provisioningserver.drivers import (
+    make_ip_extractor,
+    make_setting_field,
+    SETTING_SCOPE,
+)
+from provisioningserver.drivers.power import PowerActionError, PowerDriver
+from provisioningserver.utils import shell
+
+# Columns of outletTable of Sentry3-MIB, indexed by tower, infeed and outlet.
+SERVERTECH_OUTLET_STATUS_OID = "1.3.6.1.4.1.1718.3.2.3.1.10"
+SERVERTECH_OUTLET_CONTROL_OID = "1.3.6.1.4.1.1718.3.2.3.1.11"
+
+
+class ServerTechState:
+    # outletStatus also reports pending states (offWait, onWait).
+    ON = ("1", "9")
+    OFF = ("0", "8")
+
+
+class ServerTechControl:
+    ON = "1"
+    OFF = "2"
+
+
+class ServerTechPowerDriver(PowerDriver):
+    name = "servertech"
+    chassis = True
+    can_probe = False
+    can_set_boot_order = False
+    description = "ServerTech Sentry PDU"
+    settings = [
+        make_setting_field(
+            "power_address", "IP for ServerTech PDU", required=True
+        ),
+        make_setting_field(
+            "node_outlet",
+            "ServerTech PDU node outlet (e.g. AA1, or 1 for the first "
+            "infeed of the first tower)",
+            scope=SETTING_SCOPE.NODE,
+            required=True,
+        ),
+        make_setting_field(
+            "power_on_delay", "Power ON outlet delay (seconds)", default="5"
+        ),
+    ]
+    ip_extractor = make_ip_extractor("power_address")
+
+    def detect_missing_packages(self):
+        binary, package = ["snmpset", "snmp"]
+        if not shell.has_command_available(binary):
+            return [package]
+        return []
+
+    def run_process(self, *command):
+        """Run SNMP command in subprocess."""
+        result = shell.run_command(*command)
+        if result.returncode != 0:
+            raise PowerActionError(
+                "ServerTech Power Driver external process error for command "
+                "%s: %s" % ("".join(command), result.stderr)
+            )
+        match = re.search(r"INTEGER:\s*(\d+)", result.stdout)
+        if match is None:
+            raise PowerActionError(
+                "ServerTech Power Driver unable to extract outlet power state"
+                " from: %s" % result.stdout
+            )
+        else:
+            return match.group(1)
+
+    def power_on(self, system_id, context):
+        """Power on ServerTech outlet."""
+        if self.power_query(system_id, context) == "on":
+            self.power_off(system_id, context)
+        sleep(float(context["power_on_delay"]))
+        self.run_process(
+            "snmpset",
+            *_get_common_args(
+                context["power_address"],
+                SERVERTECH_OUTLET_CONTROL_OID,
+                context["node_outlet"],
+            ),
+            "i",
+            ServerTechControl.ON,
+        )
+
+    def power_off(self, system_id, context):
+        """Power off ServerTech outlet."""
+        self.run_process(
+            "snmpset",
+            *_get_common_args(
+                context["power_address"],
+                SERVERTECH_OUTLET_CONTROL_OID,
+                context["node_outlet"],
+            ),
+            "i",
+            ServerTechControl.OFF,
+        )
+
+    def power_query(self, system_id, context):
+        """Power query ServerTech outlet."""
+        power_state = self.run_process(
+            "snmpget",
+            *_get_common_args(
+                context["power_address"],
+                SERVERTECH_OUTLET_STATUS_OID,
+                context["node_outlet"],
+            ),
+        )
+        if power_state in ServerTechState.OFF:
+            return "off"
+        elif power_state in ServerTechState.ON:
+            return "on"
+        else:
+            raise PowerActionError(
+                "ServerTech Power Driver retrieved unknown power state: %r"
+                % power_state
+            )
+
+
+def get_outlet_index(outlet):
+    """Return the index of outletTable of the outlet.
+
+    Outlets are identified as on the PDU, by the tower, infeed and outlet
+    number (e.g. "AA1" or "BA12"), or only by the outlet number of the first
+    infeed of the first tower (e.g. "1").
+    """
+    match = re.fullmatch(r"(?:([A-Z])([A-Z]))?(\d+)", outlet.strip().upper())
+    if match is None or int(match.group(3)) == 0:
+        raise PowerActionError(
+            "ServerTech Power Driver invalid outlet: %r" % outlet
+        )
+    tower, infeed, number = match.groups()
+    tower = ord(tower) - ord("A") + 1 if tower else 1
+    infeed = ord(infeed) - ord("A") + 1 if infeed else 1
+    return f"{tower}.{infeed}.{int(number)}"
+
+
+def _get_common_args(address, oid, outlet):
+    return [
+        "-c",
+        "private",
+        "-v1",
+        address,
+        f".{oid}.{get_outlet_index(outlet)}",
+    ]
@@ -0,0 +1,150 @@
+# Copyright 2026 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+"""Tests for `provisioningserver.drivers.power.servertech`."""
+
+
+from maastesting.factory import factory
+from maastesting.testcase import MAASTestCase
+from provisioningserver.drivers.power import PowerActionError
+from provisioningserver.drivers.power import (
+    servertech as servertech_module,
+)
+from provisioningserver.utils.shell import has_command_available, ProcessResult
+
+STATUS_ARGS = "-c private -v1 {} .1.3.6.1.4.1.1718.3.2.3.1.10.{}"
+CONTROL_ARGS = "-c private -v1 {} .1.3.6.1.4.1.1718.3.2.3.1.11.{}"
+COMMON_OUTPUT = "iso.1.3.6.1.4.1.1718.3.2.3.1.10.1.1.%s = INTEGER: 9\n"
+
+
+class TestServerTechPowerDriver(MAASTestCase):
+    def make_context(self):
+        return {
+            "power_address": factory.make_name("power_address"),
+            "node_outlet": "BA12",
+            "power_on_delay": "5",
+        }
+
+    def test_missing_packages(self):
+        mock = self.patch(has_command_available)
+        mock.return_value = False
+        driver = servertech_module.ServerTechPowerDriver()
+        missing = driver.detect_missing_packages()
+        self.assertEqual(["snmp"], missing)
+
+    def test_no_missing_packages(self):
+        mock = self.patch(has_command_available)
+        mock.return_value = True
+        driver = servertech_module.ServerTechPowerDriver()
+        missing = driver.detect_missing_packages()
+        self.assertEqual([], missing)
+
+    def patch_run_command(self, stdout="", stderr="", returncode=0):
+        mock_run_command = self.patch(servertech_module.shell, "run_command")
+        mock_run_command.return_value = ProcessResult(
+            stdout=stdout, stderr=stderr, returncode=returncode
+        )
+        return mock_run_command
+
+    def test_run_process_calls_command_and_returns_output(self):
+        driver = servertech_module.ServerTechPowerDriver()
+        context = self.make_context()
+        command = ["snmpget"] + STATUS_ARGS.format(
+            context["power_address"], "2.1.12"
+        ).split()
+        mock_run_command = self.patch_run_command(
+            stdout=COMMON_OUTPUT % "12", stderr="error_output"
+        )
+        output = driver.run_process(*command)
+        mock_run_command.assert_called_once_with(*command)
+        self.assertEqual(output, "9")
+
+    def test_run_process_crashes_on_external_process_error(self):
+        driver = servertech_module.ServerTechPowerDriver()
+        self.patch_run_command(returncode=1)
+        self.assertRaises(
+            PowerActionError, driver.run_process, factory.make_name("command")
+        )
+
+    def test_run_process_crashes_on_no_power_state_match_found(self):
+        driver = servertech_module.ServerTechPowerDriver()
+        self.patch_run_command(stdout="Error")
+        self.assertRaises(
+            PowerActionError, driver.run_process, factory.make_name("command")
+        )
+
+    def test_get_outlet_index(self):
+        self.assertEqual(servertech_module.get_outlet_index("AA1"), "1.1.1")
+        self.assertEqual(servertech_module.get_outlet_index("ba12"), "2.1.12")
+        self.assertEqual(servertech_module.get_outlet_index("3"), "1.1.3")
+
+    def test_get_outlet_index_crashes_for_invalid_outlet(self):
+        for outlet in ("", "A1", "AA0", "AA1-4"):
+            self.assertRaises(
+                PowerActionError, servertech_module.get_outlet_index, outlet
+            )
+
+    def test_power_on_calls_run_process(self):
+        driver = servertech_module.ServerTechPowerDriver()
+        system_id = factory.make_name("system_id")
+        context = self.make_context()
+        mock_power_query = self.patch(driver, "power_query")
+        mock_power_query.return_value = "on"
+        self.patch(driver, "power_off")
+        mock_sleep = self.patch(servertech_module, "sleep")
+        mock_run_process = self.patch(driver, "run_process")
+        driver.power_on(system_id, context)
+
+        mock_power_query.assert_called_once_with(system_id, context)
+        mock_sleep.assert_called_once_with(float(context["power_on_delay"]))
+        command = (
+            ["snmpset"]
+            + CONTROL_ARGS.format(context["power_address"], "2.1.12").split()
+            + ["i", "1"]
+        )
+        mock_run_process.assert_called_once_with(*command)
+
+    def test_power_off_calls_run_process(self):
+        driver = servertech_module.ServerTechPowerDriver()
+        system_id = factory.make_name("system_id")
+        context = self.make_context()
+        mock_run_process = self.patch(driver, "run_process")
+        driver.power_off(system_id, context)
+        command = (
+            ["snmpset"]
+            + CONTROL_ARGS.format(context["power_address"], "2.1.12").split()
+            + ["i", "2"]
+        )
+        mock_run_process.assert_called_once_with(*command)
+
+    def test_power_query_returns_power_state_on(self):
+        driver = servertech_module.ServerTechPowerDriver()
+        system_id = factory.make_name("system_id")
+        context = self.make_context()
+        mock_run_process = self.patch(driver, "run_process")
+        mock_run_process.return_value = "1"
+        result = driver.power_query(system_id, context)
+        command = ["snmpget"] + STATUS_ARGS.format(
+            context["power_address"], "2.1.12"
+        ).split()
+        mock_run_process.assert_called_once_with(*command)
+        self.assertEqual(result, "on")
+
+    def test_power_query_returns_power_state_off_while_pending(self):
+        driver = servertech_module.ServerTechPowerDriver()
+        system_id = factory.make_name("system_id")
+        context = self.make_context()
+        mock_run_process = self.patch(driver, "run_process")
+        mock_run_process.return_value = "8"
+        result = driver.power_query(system_id, context)
+        self.assertEqual(result, "off")
+
+    def test_power_query_crashes_for_unknown_power_state(self):
+        driver = servertech_module.ServerTechPowerDriver()
+        system_id = factory.make_name("system_id")
+        context = self.make_context()
+        mock_run_process = self.patch(driver, "run_process")
+        mock_run_process.return_value = "5"
+        self.assertRaises(
+            PowerActionError, driver.power_query, system_id, context
+        )
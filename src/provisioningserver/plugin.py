@@ -30,6 +30,12 @@ from provisioningserver.utils.env import MAAS_SECRET, MAAS_SHARED_SECRET
 from provisioningserver.utils.twisted import retries
 
 
+def _get_tftp_port():
+    """Return the TFTP port, which can be changed while rackd runs."""
+    with ClusterConfiguration.open() as config:
+        return config.tftp_port
+
+
 class Options(logger.VerbosityOptions):
     """Command line options for `rackd`."""
 
@@ -81,7 +87,10 @@ class ProvisioningServiceMaker:
         from provisioningserver.rackdservices.tftp import TFTPService
 
         tftp_service = TFTPService(
-            resource_root=tftp_root, port=tftp_port, client_service=rpc_service
+            resource_root=tftp_root,
+            port=tftp_port,
+            client_service=rpc_service,
+            get_port=_get_tftp_port,
         )
         tftp_service.setName("tftp")
 
@@ -786,6 +786,39 @@ class TestTFTPService(MAASTestCase):
             interfaces, {server.name for server in tftp_service.getServers()}
         )
 
+    def test_tftp_service_moves_servers_to_new_port(self):
+        interfaces = {"1.1.1.1", "2.2.2.2"}
+        self.patch(
+            tftp_module, "get_all_interface_addresses", lambda: interfaces
+        )
+        ports = [factory.pick_port(port_max=65534)]
+
+        tftp_service = TFTPService(
+            resource_root=self.make_dir(),
+            client_service=Mock(),
+            port=ports[0],
+            get_port=lambda: ports[-1],
+        )
+        tftp_service.updateServers()
+        self.assertEqual(
+            {ports[0]},
+            {server.args[0] for server in tftp_service.getServers()},
+        )
+
+        # The configured port changes.
+        ports.append(factory.pick_port(port_min=ports[0] + 1))
+        tftp_service.updateServers()
+        reactor.runUntilCurrent()
+
+        self.assertEqual(ports[-1], tftp_service.port)
+        self.assertEqual(
+            interfaces, {server.name for server in tftp_service.getServers()}
+        )
+        self.assertEqual(
+            {ports[-1]},
+            {server.args[0] for server in tftp_service.getServers()},
+        )
+
     def test_tftp_service_does_not_bind_to_link_local_addresses(self):
         # Initial set of interfaces to bind to.
         ipv4_test_net_3 = IPNetwork("203.0.113.0/24")  # RFC 5737
@@ -501,20 +501,26 @@ class TFTPService(MultiService):
 
     :ivar port: The port on which each server is started.
 
+    :ivar get_port: Optional callable returning the port servers should be
+        started on, so that a changed port is applied without a restart.
+
     :ivar refresher: A :class:`TimerService` that calls
         ``updateServers`` periodically.
 
     """
 
-    def __init__(self, resource_root, port, client_service):
+    def __init__(self, resource_root, port, client_service, get_port=None):
         """
         :param resource_root: The root directory for this TFTP server.
         :param port: The port on which each server should be started.
         :param client_service: The RPC client service for the rack controller.
+        :param get_port: Optional callable returning the port on which each
+            server should be started when servers are updated.
         """
         super().__init__()
         self.backend = TFTPBackend(resource_root, client_service)
         self.port = port
+        self.get_port = get_port
         # Establish a periodic call to self.updateServers() every 45
         # seconds, so that this service eventually converges on truth.
         # TimerService ensures that a call is made to it's target
@@ -537,9 +543,27 @@ class TFTPService(MultiService):
         For each configured network interface this will start a TFTP
         server. If called later it will bring up servers on newly
         configured interfaces and bring down servers on deconfigured
-        interfaces.
+        interfaces, and move servers to the port if it changed.
+
+        Transfers in progress use their own sockets, so they are not
+        interrupted when the server which received the request is brought
+        down.
         """
-        addrs_established = {service.name for service in self.getServers()}
+        if self.get_port is not None:
+            self.port = self.get_port()
+
+        servers = {service.name: service for service in self.getServers()}
+        moved = {
+            address
+            for address, service in servers.items()
+            if service.args[0] != self.port
+        }
+        # Servers are named after their address, so servers on another port
+        # are brought down before they are brought up on the new one.
+        for address in moved:
+            servers[address].disownServiceParent()
+
+        addrs_established = set(servers) - moved
         addrs_desired = set(get_all_interface_addresses())
 
         for address in addrs_desired - addrs_established:
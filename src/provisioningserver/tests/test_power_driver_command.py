@@ -86,6 +86,8 @@ class TestPowerDriverCommand(MAASTestCase):
                 "qemu+ssh://ubuntu@$KVM_HOST/system",
                 "--power-id",
                 "power_id",
+                "--power-off-mode",
+                "soft",
             ]
         )
 
@@ -95,6 +97,29 @@ class TestPowerDriverCommand(MAASTestCase):
             args.power_address, "qemu+ssh://ubuntu@$KVM_HOST/system"
         )
         self.assertEqual(args.power_id, "power_id")
+        self.assertEqual(args.power_off_mode, "soft")
+
+    def test_parse_args_hmc(self):
+        args = power_driver_command._parse_args(
+            [
+                "on",
+                "hmc",
+                "--power-address",
+                "power_address",
+                "--server-name",
+                "sys1",
+                "--lpar",
+                "lpar1",
+                "--hmc-api",
+                "rest",
+            ]
+        )
+
+        self.assertEqual(args.command, "on")
+        self.assertEqual(args.driver, "hmc")
+        self.assertEqual(args.server_name, "sys1")
+        self.assertEqual(args.lpar, "lpar1")
+        self.assertEqual(args.hmc_api, "rest")
 
     def test_parse_args_ipmi(self):
         """Test parsing args with ipmi, as it includes settings with defined choices"""
@@ -4,6 +4,7 @@ from .fixtures.app import (
     app_with_mocked_services,
     app_with_mocked_services_admin,
     app_with_mocked_services_admin_rbac,
+    app_with_mocked_services_internal,
     app_with_mocked_services_rbac,
     app_with_mocked_services_user,
     app_with_mocked_services_user_rbac,
@@ -21,6 +22,7 @@ from .fixtures.app import (
     mocked_api_client_session_id,
     mocked_api_client_user,
     mocked_api_client_user_rbac,
+    mocked_internal_api_client,
     services_mock,
     user_session_id,
 )
@@ -54,12 +56,14 @@ __all__ = [
     "mocked_api_client_rbac",
     "mocked_api_client_user_rbac",
     "mocked_api_client_admin_rbac",
+    "mocked_internal_api_client",
     "app_with_mocked_services",
     "app_with_mocked_services_user",
     "app_with_mocked_services_admin",
     "app_with_mocked_services_rbac",
     "app_with_mocked_services_user_rbac",
     "app_with_mocked_services_admin_rbac",
+    "app_with_mocked_services_internal",
     "transaction_middleware_class",
     "user_session_id",
 ]
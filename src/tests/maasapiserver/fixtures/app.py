@@ -17,7 +17,9 @@ from maasapiserver.common.middlewares.exceptions import (
     ExceptionMiddleware,
 )
 from maasapiserver.main import create_app
+from maasapiserver.common.api.base import API
 from maasapiserver.settings import Config
+from maasapiserver.v3.api.internal.handlers import APIv3Internal
 from maasapiserver.v3.api.public.handlers import APIv3
 from maasapiserver.v3.api.public.models.responses.oauth2 import (
     AccessTokenResponse,
@@ -44,6 +46,7 @@ def create_app_with_mocks(
     mocked_services: ServiceCollectionV3,
     roles: set[UserRole] | None = None,
     external_auth: bool = False,
+    api: API = APIv3,
 ):
     class InjectServicesMocks(BaseHTTPMiddleware):
         async def dispatch(
@@ -86,7 +89,6 @@ def create_app_with_mocks(
         title="MAASAPIServer",
         name="maasapiserver",
     )
-    api = APIv3
 
     app.add_middleware(InjectUserInRequest)
     app.add_middleware(InjectServicesMocks)
@@ -137,6 +139,11 @@ def app_with_mocked_services_admin_rbac(services_mock: ServiceCollectionV3):
     )
 
 
+@pytest.fixture
+def app_with_mocked_services_internal(services_mock: ServiceCollectionV3):
+    yield create_app_with_mocks(services_mock, api=APIv3Internal)
+
+
 @pytest.fixture
 async def mocked_api_client(
     app_with_mocked_services: FastAPI,
@@ -208,6 +215,16 @@ async def mocked_api_client_admin_rbac(
         yield client
 
 
+@pytest.fixture
+async def mocked_internal_api_client(
+    app_with_mocked_services_internal: FastAPI,
+) -> AsyncIterator[AsyncClient]:
+    async with AsyncClient(
+        app=app_with_mocked_services_internal, base_url="http://test"
+    ) as client:
+        yield client
+
+
 @pytest.fixture
 async def api_app(
     test_config: Config,
@@ -0,0 +1,290 @@
+#  Copyright 2024 Canonical Ltd.  This software is licensed under the
+#  GNU Affero General Public License version 3 (see the file LICENSE).
+
+from unittest.mock import call, Mock
+
+from httpx import AsyncClient
+import pytest
+
+from maasapiserver.v3.constants import V3_INTERNAL_API_PREFIX
+from maascommon.enums.events import EventTypeEnum
+from maasservicelayer.services import ServiceCollectionV3
+from maasservicelayer.services.events import EventsService
+
+
+@pytest.mark.asyncio
+class TestAgentApi:
+    BASE_PATH = f"{V3_INTERNAL_API_PREFIX}/agents/abcdef"
+
+    async def test_report_filesystem_health(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.events = Mock(EventsService)
+        response = await mocked_internal_api_client.post(
+            f"{self.BASE_PATH}/filesystem-health",
+            json=[
+                {
+                    "subsystem": "tftp",
+                    "path": "/var/lib/maas/boot-resources",
+                    "free_bytes": 10,
+                    "total_bytes": 100,
+                },
+                {
+                    "subsystem": "dhcp",
+                    "path": "/var/lib/maas/dhcp",
+                    "error": "not writable",
+                    "free_bytes": 0,
+                    "total_bytes": 100,
+                },
+            ],
+        )
+        assert response.status_code == 204
+        services_mock.events.record_node_event.assert_has_calls(
+            [
+                call(
+                    "abcdef",
+                    EventTypeEnum.AGENT_FILESYSTEM_HEALTHY,
+                    "tftp directory /var/lib/maas/boot-resources: "
+                    "10 of 100 bytes free",
+                ),
+                call(
+                    "abcdef",
+                    EventTypeEnum.AGENT_FILESYSTEM_UNHEALTHY,
+                    "dhcp directory /var/lib/maas/dhcp: not writable",
+                ),
+            ]
+        )
+
+    async def test_report_filesystem_health_invalid(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.events = Mock(EventsService)
+        response = await mocked_internal_api_client.post(
+            f"{self.BASE_PATH}/filesystem-health",
+            json=[{"subsystem": "tftp"}],
+        )
+        assert response.status_code == 422
+        services_mock.events.record_node_event.assert_not_called()
+
+    async def test_report_latency_events(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.events = Mock(EventsService)
+        response = await mocked_internal_api_client.post(
+            f"{self.BASE_PATH}/latency-events",
+            json=[
+                {
+                    "operation": "dhcp",
+                    "percentile": "p99",
+                    "objective": 100_000_000,
+                    "actual": 250_000_000,
+                    "samples": 42,
+                    "breached": True,
+                },
+                {
+                    "operation": "dns",
+                    "percentile": "p50",
+                    "objective": 10_000_000,
+                    "actual": 1_500_000,
+                    "samples": 7,
+                    "breached": False,
+                },
+            ],
+        )
+        assert response.status_code == 204
+        services_mock.events.record_node_event.assert_has_calls(
+            [
+                call(
+                    "abcdef",
+                    EventTypeEnum.AGENT_LATENCY_OBJECTIVE_BREACHED,
+                    "p99 latency of dhcp is 250ms, above objective of "
+                    "100ms (42 samples)",
+                ),
+                call(
+                    "abcdef",
+                    EventTypeEnum.AGENT_LATENCY_OBJECTIVE_MET,
+                    "p50 latency of dns is 1.5ms, within objective of "
+                    "10ms (7 samples)",
+                ),
+            ]
+        )
+
+    async def test_report_remediation_events(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.events = Mock(EventsService)
+        response = await mocked_internal_api_client.post(
+            f"{self.BASE_PATH}/remediation-events",
+            json=[
+                {
+                    "time": "2024-01-01T00:00:00Z",
+                    "group": {"subnet": "10.0.0.0/24", "iface": "eth0"},
+                    "rule": "dhcp-exhausted",
+                    "source": "dhcp",
+                    "error": "no free leases",
+                    "count": 5,
+                },
+                {
+                    "time": "2024-01-01T00:00:01Z",
+                    "rule": "tftp-errors",
+                    "source": "tftp",
+                    "count": 3,
+                },
+            ],
+        )
+        assert response.status_code == 204
+        services_mock.events.record_node_event.assert_has_calls(
+            [
+                call(
+                    "abcdef",
+                    EventTypeEnum.AGENT_REMEDIATION_RULE_TRIGGERED,
+                    "dhcp-exhausted triggered by 5 failures of dhcp "
+                    "(iface=eth0, subnet=10.0.0.0/24): no free leases",
+                ),
+                call(
+                    "abcdef",
+                    EventTypeEnum.AGENT_REMEDIATION_RULE_TRIGGERED,
+                    "tftp-errors triggered by 3 failures of tftp",
+                ),
+            ]
+        )
+
+    async def test_report_machine_events(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.events = Mock(EventsService)
+        response = await mocked_internal_api_client.post(
+            f"{self.BASE_PATH}/machine-events",
+            json=[
+                {
+                    "time": "2024-01-01T00:00:00Z",
+                    "system_id": "machine1",
+                    "type": "port-down",
+                    "source": "10.0.0.2",
+                    "index": "12",
+                    "mapping": "link-down",
+                    "trap_oid": "1.3.6.1.6.3.1.1.5.3",
+                },
+                {
+                    "time": "2024-01-01T00:00:01Z",
+                    "system_id": "machine2",
+                    "type": "overheat",
+                    "source": "10.0.0.3",
+                    "mapping": "pdu-temperature",
+                    "trap_oid": "1.3.6.1.4.1.318.0.1",
+                },
+            ],
+        )
+        assert response.status_code == 204
+        services_mock.events.record_node_event.assert_has_calls(
+            [
+                call(
+                    "machine1",
+                    EventTypeEnum.NODE_PORT_DOWN,
+                    "port-down reported by 10.0.0.2 index 12 "
+                    "(link-down trap 1.3.6.1.6.3.1.1.5.3)",
+                ),
+                call(
+                    "machine2",
+                    EventTypeEnum.NODE_SNMP_TRAP,
+                    "overheat reported by 10.0.0.3 "
+                    "(pdu-temperature trap 1.3.6.1.4.1.318.0.1)",
+                ),
+            ]
+        )
+
+    async def test_report_power_anomalies(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.events = Mock(EventsService)
+        response = await mocked_internal_api_client.post(
+            f"{self.BASE_PATH}/power-anomalies",
+            json=[
+                {
+                    "time": "2024-01-01T00:00:00Z",
+                    "machine": "machine1",
+                    "kind": "unexpected-off",
+                    "state": "off",
+                    "count": 3,
+                    "window": 3_600_000_000_000,
+                },
+                {
+                    "time": "2024-01-01T00:00:01Z",
+                    "machine": "machine2",
+                    "kind": "flapping",
+                    "count": 6,
+                    "window": 600_000_000_000,
+                },
+            ],
+        )
+        assert response.status_code == 204
+        services_mock.events.record_node_event.assert_has_calls(
+            [
+                call(
+                    "machine1",
+                    EventTypeEnum.NODE_POWER_ANOMALY,
+                    "unexpected-off: 3 times within 1:00:00, last found off",
+                ),
+                call(
+                    "machine2",
+                    EventTypeEnum.NODE_POWER_ANOMALY,
+                    "flapping: 6 times within 0:10:00",
+                ),
+            ]
+        )
+
+    async def test_report_machine_annotations(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.events = Mock(EventsService)
+        response = await mocked_internal_api_client.post(
+            f"{self.BASE_PATH}/machine-annotations",
+            json={
+                "system_id": "machine1",
+                "annotations": [
+                    {
+                        "created_at": "2024-01-01T00:00:00Z",
+                        "text": "disk replaced",
+                        "source": "admin",
+                    },
+                    {
+                        "created_at": "2024-01-01T00:00:01Z",
+                        "text": "flaky NIC",
+                        "source": "deploy",
+                    },
+                ],
+            },
+        )
+        assert response.status_code == 204
+        services_mock.events.record_node_event.assert_called_once_with(
+            "machine1", EventTypeEnum.NODE_ANNOTATED, "flaky NIC (by deploy)"
+        )
+
+    async def test_report_machine_annotations_cleared(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.events = Mock(EventsService)
+        response = await mocked_internal_api_client.post(
+            f"{self.BASE_PATH}/machine-annotations",
+            json={"system_id": "machine1", "annotations": []},
+        )
+        assert response.status_code == 204
+        services_mock.events.record_node_event.assert_called_once_with(
+            "machine1", EventTypeEnum.NODE_ANNOTATIONS_CLEARED, ""
+        )
@@ -0,0 +1,88 @@
+#  Copyright 2024 Canonical Ltd.  This software is licensed under the
+#  GNU Affero General Public License version 3 (see the file LICENSE).
+
+from ipaddress import IPv4Address, IPv6Address
+from unittest.mock import call, Mock
+
+from httpx import AsyncClient
+import pytest
+
+from maasapiserver.v3.constants import V3_INTERNAL_API_PREFIX
+from maascommon.enums.ipaddress import IpAddressFamily, LeaseAction
+from maasservicelayer.models.leases import Lease
+from maasservicelayer.services import ServiceCollectionV3
+from maasservicelayer.services.leases import LeasesService
+
+
+@pytest.mark.asyncio
+class TestLeasesApi:
+    BASE_PATH = f"{V3_INTERNAL_API_PREFIX}/leases"
+
+    async def test_store_lease_info(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.leases = Mock(LeasesService)
+        response = await mocked_internal_api_client.post(
+            self.BASE_PATH,
+            json=[
+                {
+                    "action": "commit",
+                    "ip_family": "ipv4",
+                    "hostname": "machine",
+                    "mac": "00:16:3e:00:00:10",
+                    "ip": "10.0.0.10",
+                    "timestamp": 1700000000,
+                    "lease_time": 600,
+                },
+                {
+                    "action": "expiry",
+                    "ip_family": "ipv6",
+                    "hostname": "",
+                    "mac": "00:16:3e:00:00:20",
+                    "ip": "fd00::20",
+                    "timestamp": 1700000001,
+                    "lease_time": 0,
+                },
+            ],
+        )
+        assert response.status_code == 204
+        services_mock.leases.store_lease_info.assert_has_calls(
+            [
+                call(
+                    Lease(
+                        action=LeaseAction.COMMIT,
+                        ip_family=IpAddressFamily.IPV4,
+                        hostname="machine",
+                        mac="00:16:3e:00:00:10",
+                        ip=IPv4Address("10.0.0.10"),
+                        timestamp_epoch=1700000000,
+                        lease_time_seconds=600,
+                    )
+                ),
+                call(
+                    Lease(
+                        action=LeaseAction.EXPIRY,
+                        ip_family=IpAddressFamily.IPV6,
+                        hostname="",
+                        mac="00:16:3e:00:00:20",
+                        ip=IPv6Address("fd00::20"),
+                        timestamp_epoch=1700000001,
+                        lease_time_seconds=0,
+                    )
+                ),
+            ]
+        )
+
+    async def test_store_lease_info_empty(
+        self,
+        services_mock: ServiceCollectionV3,
+        mocked_internal_api_client: AsyncClient,
+    ) -> None:
+        services_mock.leases = Mock(LeasesService)
+        response = await mocked_internal_api_client.post(
+            self.BASE_PATH, json=[]
+        )
+        assert response.status_code == 204
+        services_mock.leases.store_lease_info.assert_not_called()
@@ -9,7 +9,7 @@ import pytest
 from maasapiserver.v3.api.internal.models.requests.leases import (
     LeaseInfoRequest,
 )
-from maascommon.enums.ipaddress import LeaseAction
+from maascommon.enums.ipaddress import IpAddressFamily, LeaseAction
 
 
 class TestNamedBaseModel:
@@ -56,3 +56,38 @@ class TestNamedBaseModel:
                 timestamp=int(time.time()),
                 lease_time=30,
             )
+
+    @pytest.mark.parametrize(
+        "raw_family, family",
+        [
+            ("ipv4", IpAddressFamily.IPV4),
+            ("ipv6", IpAddressFamily.IPV6),
+            (4, IpAddressFamily.IPV4),
+            (6, IpAddressFamily.IPV6),
+        ],
+    )
+    def test_ip_family(self, raw_family: str | int, family: IpAddressFamily):
+        assert (
+            LeaseInfoRequest(
+                action=LeaseAction.COMMIT,
+                ip_family=raw_family,
+                hostname="hostname",
+                mac="00:1b:44:11:3a:b7",
+                ip=IPv4Address("10.0.0.1"),
+                timestamp=int(time.time()),
+                lease_time=30,
+            ).ip_family
+            == family
+        )
+
+    def test_ip_family_invalid(self):
+        with pytest.raises(ValueError):
+            LeaseInfoRequest(
+                action=LeaseAction.COMMIT,
+                ip_family="ipx",
+                hostname="hostname",
+                mac="00:1b:44:11:3a:b7",
+                ip=IPv4Address("10.0.0.1"),
+                timestamp=int(time.time()),
+                lease_time=30,
+            )
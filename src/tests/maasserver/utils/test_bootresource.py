@@ -117,6 +117,78 @@ class TestLocalBootResourceFile:
         assert not os.access(f.partial_file_path, os.F_OK)
         assert not os.access(f.path, os.F_OK)
 
+    def test_unlink_compressed_file(self, image_store_dir: Path):
+        open(image_store_dir / "cadecafe", "wb").close()
+        open(image_store_dir / "cadecafe.zst", "wb").close()
+
+        f = LocalBootResourceFile(
+            sha256="cadecafe",
+            filename_on_disk="cadecafe",
+            total_size=FILE_SIZE,
+        )
+        f.unlink()
+        assert not os.access(f.compressed_file_path, os.F_OK)
+
+    @pytest.mark.asyncio
+    @pytest.mark.skipif(
+        shutil.which("zstd") is None, reason="zstd is not installed"
+    )
+    async def test_compress(
+        self,
+        image_store_dir: Path,
+        file_content: bytes,
+        file_sha256: str,
+        file_filename_on_disk: str,
+    ):
+        with open(image_store_dir / file_filename_on_disk, "wb") as stream:
+            stream.write(file_content)
+        f = LocalBootResourceFile(
+            sha256=file_sha256,
+            filename_on_disk=file_filename_on_disk,
+            total_size=FILE_SIZE,
+        )
+        assert await f.acompress()
+        assert f.compressed_file_path == image_store_dir / (
+            file_filename_on_disk + ".zst"
+        )
+        assert f.compressed_file_path.stat().st_size < FILE_SIZE
+        # the incomplete copy is renamed into place
+        assert sorted(image_store_dir.iterdir()) == [
+            f.path,
+            f.compressed_file_path,
+        ]
+
+    @pytest.mark.asyncio
+    @pytest.mark.skipif(
+        shutil.which("zstd") is None, reason="zstd is not installed"
+    )
+    async def test_compress_incompressible(self, image_store_dir: Path):
+        content = os.urandom(FILE_SIZE)
+        with open(image_store_dir / "cadecafe", "wb") as stream:
+            stream.write(content)
+        f = LocalBootResourceFile(
+            sha256=hashlib.sha256(content).hexdigest(),
+            filename_on_disk="cadecafe",
+            total_size=FILE_SIZE,
+        )
+        assert not await f.acompress()
+        assert list(image_store_dir.iterdir()) == [f.path]
+
+    @pytest.mark.asyncio
+    async def test_compress_without_zstd(
+        self, mocker, image_store_dir: Path, file_content: bytes
+    ):
+        mocker.patch.object(shutil, "which").return_value = None
+        with open(image_store_dir / "cadecafe", "wb") as stream:
+            stream.write(file_content)
+        f = LocalBootResourceFile(
+            sha256="cadecafe",
+            filename_on_disk="cadecafe",
+            total_size=FILE_SIZE,
+        )
+        assert not await f.acompress()
+        assert not os.access(f.compressed_file_path, os.F_OK)
+
     def test_valid(
         self,
         image_store_dir: Path,
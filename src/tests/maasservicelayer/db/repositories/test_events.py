@@ -13,14 +13,15 @@ from maasservicelayer.db.repositories.events import (
     EventsClauseFactory,
     EventsRepository,
 )
-from maasservicelayer.db.tables import EventTable, NodeTable
-from maasservicelayer.models.events import Event
+from maasservicelayer.db.tables import EventTable, EventTypeTable, NodeTable
+from maasservicelayer.models.events import Event, LoggingLevelEnum
 from tests.fixtures.factories.bmc import create_test_bmc
 from tests.fixtures.factories.events import (
     create_test_event_entry,
     create_test_event_type_entry,
 )
 from tests.fixtures.factories.machines import create_test_machine
+from tests.fixtures.factories.node import create_test_machine_entry
 from tests.fixtures.factories.user import create_test_user
 from tests.maasapiserver.fixtures.db import Fixture
 from tests.maasservicelayer.db.repositories.base import RepositoryCommonTests
@@ -155,3 +156,54 @@ class TestEventsRepository(RepositoryCommonTests[Event]):
         )
         assert events_result.next_token is None
         assert len(events_result.items) == 0
+
+    async def test_ensure_event_type(
+        self, repository_instance: EventsRepository, fixture: Fixture
+    ) -> None:
+        existing = await create_test_event_type_entry(fixture)
+        type_id = await repository_instance.ensure_event_type(
+            existing.name, "Other description", LoggingLevelEnum.ERROR
+        )
+        assert type_id == existing.id
+
+        type_id = await repository_instance.ensure_event_type(
+            "NEW_TYPE", "New type", LoggingLevelEnum.ERROR
+        )
+        [event_type] = await fixture.get(
+            "maasserver_eventtype", eq(EventTypeTable.c.id, type_id)
+        )
+        assert event_type["name"] == "NEW_TYPE"
+        assert event_type["description"] == "New type"
+        assert event_type["level"] == LoggingLevelEnum.ERROR.value
+
+    async def test_create_node_event(
+        self, repository_instance: EventsRepository, fixture: Fixture
+    ) -> None:
+        machine = await create_test_machine_entry(fixture)
+        event_type = await create_test_event_type_entry(fixture)
+        await repository_instance.create_node_event(
+            system_id=machine["system_id"],
+            type_id=event_type.id,
+            description="description",
+            action="action",
+        )
+        # events of unknown nodes are kept too
+        await repository_instance.create_node_event(
+            system_id="unknown",
+            type_id=event_type.id,
+            description="description",
+            action="",
+        )
+
+        events = await fixture.get("maasserver_event")
+        assert len(events) == 2
+        known, unknown = sorted(events, key=lambda e: e["id"])
+        assert known["node_id"] == machine["id"]
+        assert known["node_hostname"] == machine["hostname"]
+        assert known["node_system_id"] == machine["system_id"]
+        assert known["type_id"] == event_type.id
+        assert known["description"] == "description"
+        assert known["action"] == "action"
+        assert unknown["node_id"] is None
+        assert unknown["node_hostname"] == ""
+        assert unknown["node_system_id"] == "unknown"
@@ -5,10 +5,11 @@ from unittest.mock import Mock
 
 import pytest
 
+from maascommon.enums.events import EventTypeEnum
 from maasservicelayer.context import Context
 from maasservicelayer.db.repositories.events import EventsRepository
 from maasservicelayer.models.base import ListResult
-from maasservicelayer.models.events import Event
+from maasservicelayer.models.events import Event, LoggingLevelEnum
 from maasservicelayer.services.events import EventsService
 
 
@@ -29,3 +30,25 @@ class TestEventsService:
         )
         assert events_list.next_token is None
         assert events_list.items == []
+
+    async def test_record_node_event(self) -> None:
+        events_repository_mock = Mock(EventsRepository)
+        events_repository_mock.ensure_event_type.return_value = 5
+        events_service = EventsService(
+            context=Context(),
+            events_repository=events_repository_mock,
+        )
+        await events_service.record_node_event(
+            "abcdef", EventTypeEnum.AGENT_FILESYSTEM_UNHEALTHY, "not writable"
+        )
+        events_repository_mock.ensure_event_type.assert_called_once_with(
+            "AGENT_FILESYSTEM_UNHEALTHY",
+            "Filesystem unhealthy",
+            LoggingLevelEnum.ERROR,
+        )
+        events_repository_mock.create_node_event.assert_called_once_with(
+            system_id="abcdef",
+            type_id=5,
+            description="not writable",
+            action="",
+        )
@@ -0,0 +1,199 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from typing import Any
+
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.testing import ActivityEnvironment
+
+from maasservicelayer.db import Database
+from maasservicelayer.services import CacheForServices
+from maasservicelayer.utils.date import utcnow
+from maastemporalworker.workflow.bootresource import (
+    BootResource,
+    BootResourceActivity,
+    GetBootResourcesParam,
+    GetBootResourcesResult,
+)
+from tests.fixtures.factories.interface import create_test_interface_entry
+from tests.fixtures.factories.node import (
+    create_test_rack_controller_entry,
+    create_test_region_controller_entry,
+)
+from tests.fixtures.factories.staticipaddress import (
+    create_test_staticipaddress_entry,
+)
+from tests.fixtures.factories.subnet import create_test_subnet_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+
+async def _create_resource(
+    fixture: Fixture, name: str, architecture: str
+) -> dict[str, Any]:
+    [resource] = await fixture.create(
+        "maasserver_bootresource",
+        [
+            {
+                "created": utcnow(),
+                "updated": utcnow(),
+                "rtype": 0,
+                "name": name,
+                "architecture": architecture,
+                "extra": {},
+                "rolling": False,
+                "base_image": "",
+            }
+        ],
+    )
+    return resource
+
+
+async def _create_set(
+    fixture: Fixture, resource: dict[str, Any], version: str
+) -> dict[str, Any]:
+    [resource_set] = await fixture.create(
+        "maasserver_bootresourceset",
+        [
+            {
+                "created": utcnow(),
+                "updated": utcnow(),
+                "version": version,
+                "label": "stable",
+                "resource_id": resource["id"],
+            }
+        ],
+    )
+    return resource_set
+
+
+async def _create_file(
+    fixture: Fixture,
+    resource_set: dict[str, Any],
+    filename: str,
+    synced: dict[int, int],
+) -> dict[str, Any]:
+    """Create a file of `resource_set`, of which regions have `synced` bytes"""
+    [rfile] = await fixture.create(
+        "maasserver_bootresourcefile",
+        [
+            {
+                "created": utcnow(),
+                "updated": utcnow(),
+                "filename": filename,
+                "filetype": filename,
+                "extra": {},
+                "resource_set_id": resource_set["id"],
+                "sha256": f"{resource_set['id']}-{filename}",
+                "size": 100,
+                "filename_on_disk": f"{resource_set['id']}{filename}"[:64],
+            }
+        ],
+    )
+    for region_id, size in synced.items():
+        await fixture.create(
+            "maasserver_bootresourcefilesync",
+            [
+                {
+                    "created": utcnow(),
+                    "updated": utcnow(),
+                    "size": size,
+                    "file_id": rfile["id"],
+                    "region_id": region_id,
+                }
+            ],
+        )
+    return rfile
+
+
+@pytest.mark.asyncio
+class TestBootResourceActivity:
+    async def test_get_boot_resources(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        region = await create_test_region_controller_entry(fixture)
+        other_region = await create_test_region_controller_entry(fixture)
+        subnet = await create_test_subnet_entry(fixture, cidr="10.0.0.0/24")
+        ips = await create_test_staticipaddress_entry(fixture, subnet=subnet)
+        await create_test_interface_entry(fixture, node=region, ips=ips)
+
+        noble = await _create_resource(
+            fixture, "ubuntu/noble", "amd64/ga-24.04"
+        )
+        complete = await _create_set(fixture, noble, "20240101")
+        kernel = await _create_file(
+            fixture, complete, "boot-kernel", {region["id"]: 100}
+        )
+        squashfs = await _create_file(
+            fixture,
+            complete,
+            "squashfs",
+            {region["id"]: 100, other_region["id"]: 100},
+        )
+        # newer set still being synced between regions
+        incomplete = await _create_set(fixture, noble, "20240202")
+        await _create_file(
+            fixture,
+            incomplete,
+            "squashfs",
+            {region["id"]: 50, other_region["id"]: 100},
+        )
+        await _create_file(fixture, incomplete, "boot-kernel", {})
+
+        custom = await _create_resource(fixture, "mycustom", "amd64/generic")
+        custom_set = await _create_set(fixture, custom, "1")
+        root = await _create_file(
+            fixture, custom_set, "root-tgz", {region["id"]: 100}
+        )
+
+        env = ActivityEnvironment()
+        activities = BootResourceActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_boot_resources,
+            GetBootResourcesParam(system_id=agent["system_id"]),
+        )
+
+        endpoint = f"http://{ips[0]['ip']}:5240/MAAS/boot-resources/"
+        assert result == GetBootResourcesResult(
+            resources=[
+                BootResource(
+                    name="ubuntu/amd64/ga-24.04/noble/stable/boot-kernel",
+                    url=f"{endpoint}{kernel['filename_on_disk']}",
+                    sha256=kernel["sha256"],
+                    size=100,
+                ),
+                BootResource(
+                    name="ubuntu/amd64/ga-24.04/noble/stable/squashfs",
+                    url=f"{endpoint}{squashfs['filename_on_disk']}",
+                    sha256=squashfs["sha256"],
+                    size=100,
+                ),
+                BootResource(
+                    name="custom/amd64/generic/mycustom/stable/root-tgz",
+                    url=f"{endpoint}{root['filename_on_disk']}",
+                    sha256=root["sha256"],
+                    size=100,
+                ),
+            ]
+        )
+
+    async def test_get_boot_resources_none(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+
+        env = ActivityEnvironment()
+        activities = BootResourceActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_boot_resources,
+            GetBootResourcesParam(system_id=agent["system_id"]),
+        )
+
+        assert result == GetBootResourcesResult(resources=[])
@@ -0,0 +1,233 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+import json
+
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.testing import ActivityEnvironment
+
+from maascommon.enums.events import EventTypeEnum
+from maascommon.enums.node import NodeStatus
+from maasservicelayer.db import Database
+from maasservicelayer.db.tables import (
+    EventTable,
+    EventTypeTable,
+    NodeMetadataTable,
+    NodeTable,
+)
+from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.burnin import (
+    BURN_IN_RESULTS_KEY,
+    BURN_IN_TESTS_KEY,
+    BurnInActivity,
+    BurnInMachineResult,
+    BurnInVerdict,
+    EphemeralBurnInParam,
+    GetBurnInResultsResult,
+    merge_burn_in_results,
+)
+from maastemporalworker.workflow.ephemeral import (
+    EphemeralBootActivity,
+    MachineParam,
+    set_metadata,
+)
+from tests.fixtures.factories.node import create_test_machine_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+TESTS = [
+    {"name": "memory", "tool": "memtest", "duration": 60},
+    {"name": "disk", "tool": "fio", "duration": 60},
+]
+
+
+async def _metadata(fixture: Fixture, machine_id: int) -> dict[str, str]:
+    entries = await fixture.get(
+        NodeMetadataTable.name, NodeMetadataTable.c.node_id == machine_id
+    )
+    return {entry["key"]: entry["value"] for entry in entries}
+
+
+def test_merge_burn_in_results() -> None:
+    assert merge_burn_in_results(
+        [{"name": "memory", "exit_status": 1}, {"name": "disk"}],
+        [{"name": "memory", "exit_status": 0}, {"name": "cpu"}],
+    ) == [
+        {"name": "memory", "exit_status": 0},
+        {"name": "disk"},
+        {"name": "cpu"},
+    ]
+
+
+@pytest.mark.asyncio
+class TestBurnInActivity:
+    async def test_set_machine_ephemeral_burn_in(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(
+            fixture, status=NodeStatus.READY, netboot=False
+        )
+
+        env = ActivityEnvironment()
+        activities = BurnInActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.set_machine_ephemeral_burn_in,
+            EphemeralBurnInParam(system_id=machine["system_id"], tests=TESTS),
+        )
+
+        [node] = await fixture.get(
+            NodeTable.name, NodeTable.c.id == machine["id"]
+        )
+        assert node["status"] == NodeStatus.TESTING
+        assert node["netboot"]
+        metadata = await _metadata(fixture, machine["id"])
+        assert json.loads(metadata[BURN_IN_TESTS_KEY]) == TESTS
+        assert json.loads(metadata[BURN_IN_RESULTS_KEY]) == []
+
+        # tests and results are removed with the ephemeral boot
+        await env.run(
+            EphemeralBootActivity(
+                db, CacheForServices(), connection=db_connection
+            ).restore_machine_boot,
+            MachineParam(system_id=machine["system_id"]),
+        )
+        assert await _metadata(fixture, machine["id"]) == {}
+
+    async def test_get_burn_in_results(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(fixture)
+        await set_metadata(
+            db_connection, machine["id"], BURN_IN_TESTS_KEY, json.dumps(TESTS)
+        )
+        results = [
+            {
+                "name": "memory",
+                "metrics": {"errors": 0},
+                "duration": 50,
+                "exit_status": 0,
+            }
+        ]
+        await set_metadata(
+            db_connection,
+            machine["id"],
+            BURN_IN_RESULTS_KEY,
+            json.dumps(results),
+        )
+
+        env = ActivityEnvironment()
+        activities = BurnInActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+        param = MachineParam(system_id=machine["system_id"])
+
+        result = await env.run(activities.get_burn_in_results, param)
+        assert result == GetBurnInResultsResult(
+            results=results, completed=False
+        )
+
+        results.append(
+            {"name": "disk", "metrics": {}, "duration": 60, "exit_status": 1}
+        )
+        await set_metadata(
+            db_connection,
+            machine["id"],
+            BURN_IN_RESULTS_KEY,
+            json.dumps(results),
+        )
+
+        result = await env.run(activities.get_burn_in_results, param)
+        assert result == GetBurnInResultsResult(
+            results=results, completed=True
+        )
+
+    @pytest.mark.parametrize(
+        "passed,timed_out,event_type,description,status",
+        [
+            (
+                True,
+                False,
+                EventTypeEnum.NODE_BURN_IN_PASSED,
+                "2 tests passed",
+                NodeStatus.READY,
+            ),
+            (
+                False,
+                False,
+                EventTypeEnum.NODE_BURN_IN_FAILED,
+                "disk: exit status 1",
+                NodeStatus.FAILED_TESTING,
+            ),
+            (
+                False,
+                True,
+                EventTypeEnum.NODE_BURN_IN_FAILED,
+                "timed out; disk: exit status 1",
+                NodeStatus.FAILED_TESTING,
+            ),
+        ],
+    )
+    async def test_report_burn_in(
+        self,
+        fixture: Fixture,
+        db_connection: AsyncConnection,
+        db: Database,
+        passed: bool,
+        timed_out: bool,
+        event_type: EventTypeEnum,
+        description: str,
+        status: NodeStatus,
+    ) -> None:
+        machine = await create_test_machine_entry(
+            fixture, status=NodeStatus.READY
+        )
+
+        env = ActivityEnvironment()
+        activities = BurnInActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+        await env.run(
+            activities.set_machine_ephemeral_burn_in,
+            EphemeralBurnInParam(system_id=machine["system_id"], tests=TESTS),
+        )
+
+        await env.run(
+            activities.report_burn_in,
+            BurnInMachineResult(
+                system_id=machine["system_id"],
+                verdicts=[
+                    BurnInVerdict(name="memory", passed=True),
+                    BurnInVerdict(
+                        name="disk",
+                        passed=passed,
+                        failures=[] if passed else ["exit status 1"],
+                    ),
+                ],
+                results=[],
+                timed_out=timed_out,
+                passed=passed,
+            ),
+        )
+
+        [event] = await fixture.get(EventTable.name)
+        [recorded_type] = await fixture.get(
+            EventTypeTable.name, EventTypeTable.c.id == event["type_id"]
+        )
+        assert recorded_type["name"] == event_type.value
+        assert event["node_id"] == machine["id"]
+        assert event["description"] == description
+
+        # the verdict decides the status the machine is restored to
+        await env.run(
+            EphemeralBootActivity(
+                db, CacheForServices(), connection=db_connection
+            ).restore_machine_boot,
+            MachineParam(system_id=machine["system_id"]),
+        )
+        [node] = await fixture.get(
+            NodeTable.name, NodeTable.c.id == machine["id"]
+        )
+        assert node["status"] == status
@@ -0,0 +1,131 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from OpenSSL import crypto
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.exceptions import ApplicationError
+from temporalio.testing import ActivityEnvironment
+
+from maasservicelayer.db import Database
+from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.certificate import (
+    AgentCertificateActivity,
+    INVALID_CERTIFICATE_REQUEST_ERROR,
+    IssueAgentCertificateParam,
+    UNKNOWN_AGENT_ERROR,
+)
+from provisioningserver.certificates import Certificate, CertificateRequest
+from tests.fixtures.factories.node import (
+    create_test_machine_entry,
+    create_test_rack_controller_entry,
+)
+from tests.fixtures.factories.secret import create_test_secret
+from tests.maasapiserver.fixtures.db import Fixture
+
+
+@pytest.fixture
+async def maas_ca(fixture: Fixture) -> Certificate:
+    ca = Certificate.generate_ca_certificate("maas-ca", key_bits=2048)
+    await create_test_secret(
+        fixture,
+        path="global/maas-ca-certificate",
+        value={"key": ca.private_key_pem(), "cert": ca.certificate_pem()},
+    )
+    return ca
+
+
+def _csr_pem(request: CertificateRequest) -> str:
+    return crypto.dump_certificate_request(
+        crypto.FILETYPE_PEM, request.csr
+    ).decode()
+
+
+@pytest.mark.asyncio
+class TestAgentCertificateActivity:
+    async def test_issue_agent_certificate(
+        self,
+        fixture: Fixture,
+        db_connection: AsyncConnection,
+        db: Database,
+        maas_ca: Certificate,
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        request = CertificateRequest.generate(
+            "maas-cluster",
+            key_bits=2048,
+            subject_alternative_name=b"DNS:maas",
+        )
+
+        env = ActivityEnvironment()
+        activities = AgentCertificateActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.issue_agent_certificate,
+            IssueAgentCertificateParam(
+                system_id=agent["system_id"], csr=_csr_pem(request)
+            ),
+        )
+
+        # The chain is issued for the key of the Agent, leaf first
+        issued = Certificate.from_pem(
+            crypto.dump_privatekey(crypto.FILETYPE_PEM, request.key).decode(),
+            result.certificate,
+            ca_certs_material=result.certificate,
+        )
+        assert issued.cn() == "maas-cluster"
+        assert issued.ca_certs[-1].get_subject() == maas_ca.cert.get_subject()
+
+        store = crypto.X509Store()
+        store.add_cert(maas_ca.cert)
+        crypto.X509StoreContext(store, issued.cert).verify_certificate()
+
+    async def test_issue_agent_certificate_invalid_request(
+        self,
+        fixture: Fixture,
+        db_connection: AsyncConnection,
+        db: Database,
+        maas_ca: Certificate,
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+
+        env = ActivityEnvironment()
+        activities = AgentCertificateActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        with pytest.raises(ApplicationError) as e:
+            await env.run(
+                activities.issue_agent_certificate,
+                IssueAgentCertificateParam(
+                    system_id=agent["system_id"], csr="not a CSR"
+                ),
+            )
+        assert e.value.type == INVALID_CERTIFICATE_REQUEST_ERROR
+        assert e.value.non_retryable
+
+    async def test_issue_agent_certificate_unknown_agent(
+        self,
+        fixture: Fixture,
+        db_connection: AsyncConnection,
+        db: Database,
+        maas_ca: Certificate,
+    ) -> None:
+        machine = await create_test_machine_entry(fixture)
+        request = CertificateRequest.generate("maas-cluster", key_bits=2048)
+
+        env = ActivityEnvironment()
+        activities = AgentCertificateActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        with pytest.raises(ApplicationError) as e:
+            await env.run(
+                activities.issue_agent_certificate,
+                IssueAgentCertificateParam(
+                    system_id=machine["system_id"], csr=_csr_pem(request)
+                ),
+            )
+        assert e.value.type == UNKNOWN_AGENT_ERROR
@@ -1,20 +1,40 @@
 # Copyright 2024 Canonical Ltd.  This software is licensed under the
 # GNU Affero General Public License version 3 (see the file LICENSE).
 
+from datetime import timedelta
 from ipaddress import IPv4Address, IPv6Address
+import json
 from unittest.mock import Mock
 
 import pytest
 from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio import activity, workflow
+from temporalio.testing import ActivityEnvironment, WorkflowEnvironment
+from temporalio.worker import Worker
 
 from maascommon.enums.ipaddress import IpAddressType
 from maascommon.enums.node import NodeTypeEnum
+from maascommon.workflows.configure import (
+    AGENT_ROLE_DHCP,
+    AGENT_ROLE_HTTPPROXY,
+    AGENT_ROLE_POWER,
+    AGENT_ROLES_METADATA_KEY,
+    CONFIGURE_AGENT_WORKFLOW_NAME,
+    CONFIGURE_DHCP_SERVICE_WORKFLOW_NAME,
+    CONFIGURE_DNS_PUBLICATION_WORKFLOW_NAME,
+    CONFIGURE_HTTPPROXY_SERVICE_WORKFLOW_NAME,
+    CONFIGURE_POWER_SERVICE_WORKFLOW_NAME,
+    CONFIGURE_SUBNET_SERVICES_WORKFLOW_NAME,
+    ConfigureAgentParam,
+    ConfigureDHCPServiceParam,
+)
 from maasservicelayer.db import Database
 from maasservicelayer.db.filters import QuerySpec
 from maasservicelayer.db.repositories.staticipaddress import (
     StaticIPAddressClauseFactory,
 )
 from maasservicelayer.db.repositories.vlans import VlansClauseFactory
+from maasservicelayer.db.tables import NodeMetadataTable
 from maasservicelayer.models.staticipaddress import StaticIPAddress
 from maasservicelayer.models.vlans import Vlan
 from maasservicelayer.services import (
@@ -27,10 +47,23 @@ from maasservicelayer.utils.date import utcnow
 import maastemporalworker.workflow.activity as activity_module
 from maastemporalworker.workflow.configure import (
     ConfigureAgentActivity,
+    ConfigureAgentWorkflow,
     GetRackControllerVLANsInput,
     GetRackControllerVLANsResult,
     GetRegionControllerEndpointsResult,
+    SET_AGENT_ROLES_ACTIVITY_NAME,
 )
+from tests.fixtures.factories.node import create_test_rack_controller_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+
+async def _roles(fixture: Fixture, node_id: int) -> list[str] | None:
+    entries = await fixture.get(
+        NodeMetadataTable.name,
+        NodeMetadataTable.c.node_id == node_id,
+        NodeMetadataTable.c.key == AGENT_ROLES_METADATA_KEY,
+    )
+    return json.loads(entries[0]["value"]) if entries else None
 
 
 @pytest.mark.asyncio
@@ -138,3 +171,155 @@ class TestConfigureAgentActivity:
                 )
             )
         )
+
+    async def test_set_agent_roles(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        rack = await create_test_rack_controller_entry(fixture)
+
+        env = ActivityEnvironment()
+        activities = ConfigureAgentActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.set_agent_roles,
+            ConfigureAgentParam(
+                system_id=rack["system_id"],
+                roles=[AGENT_ROLE_POWER, AGENT_ROLE_DHCP],
+            ),
+        )
+        assert await _roles(fixture, rack["id"]) == [
+            AGENT_ROLE_DHCP,
+            AGENT_ROLE_POWER,
+        ]
+
+        # Agents that don't send roles have all of them enabled
+        await env.run(
+            activities.set_agent_roles,
+            ConfigureAgentParam(system_id=rack["system_id"]),
+        )
+        assert await _roles(fixture, rack["id"]) is None
+
+
+# Configuration workflows registered by the Agent
+configured_services = []
+
+# Roles recorded by the Region
+recorded_roles = []
+
+
+@activity.defn(name=SET_AGENT_ROLES_ACTIVITY_NAME)
+async def set_agent_roles(param: ConfigureAgentParam) -> None:
+    recorded_roles.append(param.roles)
+
+
+@workflow.defn(name=CONFIGURE_POWER_SERVICE_WORKFLOW_NAME, sandboxed=False)
+class ConfigurePowerServiceWorkflow:
+    name = CONFIGURE_POWER_SERVICE_WORKFLOW_NAME
+
+    @workflow.run
+    async def run(self, system_id: str) -> None:
+        configured_services.append(self.name)
+
+
+@workflow.defn(name=CONFIGURE_HTTPPROXY_SERVICE_WORKFLOW_NAME, sandboxed=False)
+class ConfigureHTTPProxyServiceWorkflow:
+    name = CONFIGURE_HTTPPROXY_SERVICE_WORKFLOW_NAME
+
+    @workflow.run
+    async def run(self, system_id: str) -> None:
+        configured_services.append(self.name)
+
+
+@workflow.defn(name=CONFIGURE_DHCP_SERVICE_WORKFLOW_NAME, sandboxed=False)
+class ConfigureDHCPServiceWorkflow:
+    name = CONFIGURE_DHCP_SERVICE_WORKFLOW_NAME
+
+    @workflow.run
+    async def run(self, param: ConfigureDHCPServiceParam) -> None:
+        configured_services.append(self.name)
+
+
+@workflow.defn(name=CONFIGURE_SUBNET_SERVICES_WORKFLOW_NAME, sandboxed=False)
+class ConfigureSubnetServicesWorkflow:
+    name = CONFIGURE_SUBNET_SERVICES_WORKFLOW_NAME
+
+    @workflow.run
+    async def run(self, system_id: str) -> None:
+        configured_services.append(self.name)
+
+
+@workflow.defn(name=CONFIGURE_DNS_PUBLICATION_WORKFLOW_NAME, sandboxed=False)
+class ConfigureDNSPublicationWorkflow:
+    name = CONFIGURE_DNS_PUBLICATION_WORKFLOW_NAME
+
+    @workflow.run
+    async def run(self, system_id: str) -> None:
+        configured_services.append(self.name)
+
+
+@pytest.mark.asyncio
+class TestConfigureAgentWorkflow:
+    @pytest.mark.parametrize(
+        "roles, expected",
+        [
+            (
+                None,
+                [
+                    ConfigurePowerServiceWorkflow,
+                    ConfigureHTTPProxyServiceWorkflow,
+                    ConfigureDHCPServiceWorkflow,
+                    ConfigureSubnetServicesWorkflow,
+                    ConfigureDNSPublicationWorkflow,
+                ],
+            ),
+            # e.g. Agents on Windows only support the power role
+            (
+                [AGENT_ROLE_POWER],
+                [
+                    ConfigurePowerServiceWorkflow,
+                    ConfigureSubnetServicesWorkflow,
+                    ConfigureDNSPublicationWorkflow,
+                ],
+            ),
+            (
+                [AGENT_ROLE_HTTPPROXY, AGENT_ROLE_DHCP],
+                [
+                    ConfigureHTTPProxyServiceWorkflow,
+                    ConfigureDHCPServiceWorkflow,
+                    ConfigureSubnetServicesWorkflow,
+                    ConfigureDNSPublicationWorkflow,
+                ],
+            ),
+        ],
+    )
+    async def test_configures_enabled_roles(
+        self, roles: list[str] | None, expected: list[type]
+    ):
+        configured_services.clear()
+        recorded_roles.clear()
+
+        async with await WorkflowEnvironment.start_time_skipping() as env:
+            # Only workflows of enabled roles are registered by the Agent,
+            # others would never complete.
+            async with Worker(
+                env.client,
+                task_queue="region",
+                workflows=[ConfigureAgentWorkflow],
+                activities=[set_agent_roles],
+            ) as worker, Worker(
+                env.client,
+                task_queue="abc@agent:main",
+                workflows=expected,
+            ):
+                await env.client.execute_workflow(
+                    CONFIGURE_AGENT_WORKFLOW_NAME,
+                    ConfigureAgentParam(system_id="abc", roles=roles),
+                    id="configure-agent:abc",
+                    task_queue=worker.task_queue,
+                    execution_timeout=timedelta(seconds=120),
+                )
+
+        assert recorded_roles == [roles]
+        assert configured_services == [wf.name for wf in expected]
@@ -25,6 +25,7 @@ from maascommon.workflows.power import (
     PowerOnParam,
     PowerParam,
     PowerQueryParam,
+    SetBootDeviceParam,
 )
 from maasservicelayer.db import Database
 from maasservicelayer.db.tables import NodeTable
@@ -39,6 +40,10 @@ from maastemporalworker.workflow.deploy import (
     GET_BOOT_ORDER_ACTIVITY_NAME,
     GetBootOrderParam,
     GetBootOrderResult,
+    RECORD_BOOT_ORDER_ACTIVITY_NAME,
+    RecordBootOrderParam,
+    RESTORE_BOOT_ORDER_ACTIVITY_NAME,
+    RestoreBootOrderParam,
     SET_BOOT_ORDER_ACTIVITY_NAME,
     SET_NODE_STATUS_ACTIVITY_NAME,
     SetBootOrderParam,
@@ -53,6 +58,7 @@ from maastemporalworker.workflow.power import (
     PowerOffResult,
     PowerOnResult,
     PowerQueryResult,
+    SET_BOOT_DEVICE_ACTIVITY_NAME,
 )
 from tests.fixtures.factories.block_device import create_test_blockdevice_entry
 from tests.fixtures.factories.bmc import create_test_bmc_entry
@@ -249,6 +255,10 @@ class TestDeployManyWorkflow:
             calls["power_on"].append(True)
             return PowerOnResult(state="on")
 
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
         @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
         async def power_off(params: PowerOffParam) -> PowerOffResult:
             calls["power_off"].append(True)
@@ -265,6 +275,7 @@ class TestDeployManyWorkflow:
                     power_query,
                     power_cycle,
                     power_on,
+                    set_boot_device,
                     power_off,
                 ],
             ) as worker:
@@ -312,6 +323,7 @@ class TestDeployManyWorkflow:
                 assert calls["set_node_status"][0] == NodeStatus.DEPLOYED
                 assert len(calls["get_boot_order"]) == 0
                 assert len(calls["power_query"]) == 1
+                assert calls["set_boot_device"] == ["pxe"]
                 assert len(calls["power_on"]) == 1
                 assert len(calls["power_cycle"]) == 0
 
@@ -367,6 +379,10 @@ class TestDeployManyWorkflow:
             calls["power_on"].append(True)
             return PowerOnResult(state="on")
 
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
         @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
         async def power_off(params: PowerOffParam) -> PowerOffResult:
             calls["power_off"].append(True)
@@ -383,6 +399,7 @@ class TestDeployManyWorkflow:
                     power_query,
                     power_cycle,
                     power_on,
+                    set_boot_device,
                     power_off,
                 ],
             ) as worker:
@@ -501,6 +518,10 @@ class TestDeployManyWorkflow:
             calls["power_on"].append(True)
             return PowerOnResult(state="on")
 
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
         @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
         async def power_off(params: PowerOffParam) -> PowerOffResult:
             calls["power_off"].append(True)
@@ -517,6 +538,7 @@ class TestDeployManyWorkflow:
                     power_query,
                     power_cycle,
                     power_on,
+                    set_boot_device,
                     power_off,
                 ],
             ) as worker:
@@ -647,6 +669,10 @@ class TestDeployManyWorkflow:
             calls["power_on"].append(True)
             return PowerOnResult(state="on")
 
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
         @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
         async def power_off(params: PowerOffParam) -> PowerOffResult:
             calls["power_off"].append(True)
@@ -668,6 +694,7 @@ class TestDeployManyWorkflow:
                     power_query,
                     power_cycle,
                     power_on,
+                    set_boot_device,
                     power_off,
                 ],
             ) as worker:
@@ -792,6 +819,10 @@ class TestDeployManyWorkflow:
             calls["power_on"].append(True)
             return PowerOnResult(state="on")
 
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
         @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
         async def power_off(params: PowerOffParam) -> PowerOffResult:
             calls["power_off"].append(True)
@@ -814,6 +845,7 @@ class TestDeployManyWorkflow:
                     power_query,
                     power_cycle,
                     power_on,
+                    set_boot_device,
                     power_off,
                 ],
             ) as worker:
@@ -888,6 +920,120 @@ class TestDeployWorkflow:
 
         calls = defaultdict(list)
 
+        @activity.defn(name=SET_NODE_STATUS_ACTIVITY_NAME)
+        async def set_node_status(params: SetNodeStatusParam) -> None:
+            calls["set_node_status"].append(True)
+
+        @activity.defn(name=GET_BOOT_ORDER_ACTIVITY_NAME)
+        async def get_boot_order(
+            params: GetBootOrderParam,
+        ) -> GetBootOrderResult:
+            calls["get_boot_order"].append(True)
+            order = []
+            if params.netboot:
+                order = [boot_iface, boot_disk]
+            else:
+                order = [boot_disk, boot_iface]
+            return GetBootOrderResult(
+                system_id=machine["system_id"],
+                order=[_stringify_datetime_fields(dev) for dev in order],
+            )
+
+        @activity.defn(name=POWER_QUERY_ACTIVITY_NAME)
+        async def power_query(params: PowerQueryParam) -> PowerQueryResult:
+            calls["power_query"].append(True)
+            return PowerQueryResult(state="off")
+
+        @activity.defn(name=POWER_CYCLE_ACTIVITY_NAME)
+        async def power_cycle(params: PowerCycleParam) -> PowerCycleResult:
+            calls["power_cycle"].append(True)
+            return PowerCycleResult(state="on")
+
+        @activity.defn(name=POWER_ON_ACTIVITY_NAME)
+        async def power_on(params: PowerOnParam) -> PowerOnResult:
+            calls["power_on"].append(True)
+            return PowerOnResult(state="on")
+
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
+        @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
+        async def power_off(params: PowerOffParam) -> PowerOffResult:
+            calls["power_off"].append(True)
+            return PowerOffResult(state="off")
+
+        async with await WorkflowEnvironment.start_time_skipping() as env:
+            async with Worker(
+                env.client,
+                task_queue="region",
+                workflows=[DeployWorkflow],
+                activities=[
+                    set_node_status,
+                    get_boot_order,
+                    power_query,
+                    power_cycle,
+                    power_on,
+                    set_boot_device,
+                    power_off,
+                ],
+            ) as worker:
+                wf = await env.client.start_workflow(
+                    DEPLOY_WORKFLOW_NAME,
+                    DeployParam(
+                        system_id=machine["system_id"],
+                        ephemeral_deploy=False,
+                        can_set_boot_order=False,
+                        task_queue=worker.task_queue,
+                        power_params=PowerParam(
+                            system_id=machine["system_id"],
+                            driver_type=bmc["power_type"],
+                            driver_opts=bmc["power_parameters"],
+                            task_queue=worker.task_queue,
+                        ),
+                    ),
+                    id=f"workflow-{uuid.uuid4()}",
+                    task_queue=worker.task_queue,
+                )
+
+                assert (
+                    WorkflowExecutionStatus.RUNNING
+                    == (await wf.describe()).status
+                )
+
+                await env.sleep(duration=timedelta(seconds=5))
+                await wf.signal("netboot-finished")
+                await env.sleep(duration=timedelta(seconds=5))
+                await wf.signal("deployed-os-ready")
+                await env.sleep(duration=timedelta(seconds=5))
+
+                await wf.result()
+
+                assert len(calls["set_node_status"]) == 0
+                assert len(calls["get_boot_order"]) == 0
+                assert len(calls["power_query"]) == 1
+                assert len(calls["power_on"]) == 1
+                assert len(calls["power_cycle"]) == 0
+
+    async def test_deploy_workflow_agent_without_set_boot_device(
+        self,
+        fixture: Fixture,
+        db_connection: AsyncConnection,
+        db: Database,
+    ) -> None:
+        bmc = await create_test_bmc_entry(fixture)
+        machine = await create_test_machine_entry(fixture, bmc_id=bmc["id"])
+        subnet = await create_test_subnet_entry(fixture)
+        [ip] = await create_test_staticipaddress_entry(fixture, subnet=subnet)
+        boot_iface = await create_test_interface_dict(
+            fixture, node=machine, ips=[ip]
+        )
+        boot_disk = await create_test_blockdevice_entry(fixture, node=machine)
+
+        calls = defaultdict(list)
+
+        # Agents which predate set-boot-device don't register the activity
+
         @activity.defn(name=SET_NODE_STATUS_ACTIVITY_NAME)
         async def set_node_status(params: SetNodeStatusParam) -> None:
             calls["set_node_status"].append(True)
@@ -978,6 +1124,92 @@ class TestDeployWorkflow:
                 assert len(calls["power_on"]) == 1
                 assert len(calls["power_cycle"]) == 0
 
+    async def test_deploy_workflow_restores_boot_order(
+        self,
+        fixture: Fixture,
+        db_connection: AsyncConnection,
+        db: Database,
+    ) -> None:
+        bmc = await create_test_bmc_entry(fixture)
+        machine = await create_test_machine_entry(fixture, bmc_id=bmc["id"])
+
+        calls = defaultdict(list)
+        recorded = [
+            {"id": "Boot0001", "device": "disk"},
+            {"id": "Boot0002", "device": "pxe"},
+        ]
+
+        @activity.defn(name=POWER_QUERY_ACTIVITY_NAME)
+        async def power_query(params: PowerQueryParam) -> PowerQueryResult:
+            calls["power_query"].append(True)
+            return PowerQueryResult(state="off")
+
+        @activity.defn(name=POWER_ON_ACTIVITY_NAME)
+        async def power_on(params: PowerOnParam) -> PowerOnResult:
+            calls["power_on"].append(True)
+            return PowerOnResult(state="on")
+
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
+        @activity.defn(name=RECORD_BOOT_ORDER_ACTIVITY_NAME)
+        async def record_boot_order(
+            params: RecordBootOrderParam,
+        ) -> dict[str, Any]:
+            # the boot order is recorded before it is changed
+            assert len(calls["set_boot_device"]) == 0
+            calls["record_boot_order"].append(params.system_id)
+            return {"recorded_at": "2024-01-01T00:00:00Z", "order": recorded}
+
+        @activity.defn(name=RESTORE_BOOT_ORDER_ACTIVITY_NAME)
+        async def restore_boot_order(params: RestoreBootOrderParam) -> None:
+            calls["restore_boot_order"].append(params.recorded)
+
+        async with await WorkflowEnvironment.start_time_skipping() as env:
+            async with Worker(
+                env.client,
+                task_queue="region",
+                workflows=[DeployWorkflow],
+                activities=[
+                    power_query,
+                    power_on,
+                    set_boot_device,
+                    record_boot_order,
+                    restore_boot_order,
+                ],
+            ) as worker:
+                wf = await env.client.start_workflow(
+                    DEPLOY_WORKFLOW_NAME,
+                    DeployParam(
+                        system_id=machine["system_id"],
+                        ephemeral_deploy=False,
+                        can_set_boot_order=False,
+                        task_queue=worker.task_queue,
+                        power_params=PowerParam(
+                            system_id=machine["system_id"],
+                            driver_type=bmc["power_type"],
+                            driver_opts=bmc["power_parameters"],
+                            task_queue=worker.task_queue,
+                        ),
+                    ),
+                    id=f"workflow-{uuid.uuid4()}",
+                    task_queue=worker.task_queue,
+                )
+
+                await env.sleep(duration=timedelta(seconds=5))
+                await wf.signal("netboot-finished")
+                await env.sleep(duration=timedelta(seconds=5))
+
+                # the boot order is restored once the machine is deployed
+                assert len(calls["restore_boot_order"]) == 0
+
+                await wf.signal("deployed-os-ready")
+                await wf.result()
+
+                assert calls["record_boot_order"] == [machine["system_id"]]
+                assert calls["restore_boot_order"] == [recorded]
+
     async def test_deploy_workflow_timeout(
         self,
         fixture: Fixture,
@@ -1079,6 +1311,10 @@ class TestDeployWorkflow:
             calls["power_on"].append(True)
             return PowerOnResult(state="on")
 
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
         @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
         async def power_off(params: PowerOffParam) -> PowerOffResult:
             calls["power_off"].append(True)
@@ -1095,6 +1331,7 @@ class TestDeployWorkflow:
                     power_query,
                     power_cycle,
                     power_on,
+                    set_boot_device,
                     power_off,
                 ],
             ) as worker:
@@ -1186,6 +1423,10 @@ class TestDeployWorkflow:
             calls["power_on"].append(True)
             return PowerOnResult(state="on")
 
+        @activity.defn(name=SET_BOOT_DEVICE_ACTIVITY_NAME)
+        async def set_boot_device(params: SetBootDeviceParam) -> None:
+            calls["set_boot_device"].append(params.device)
+
         @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
         async def power_off(params: PowerOffParam) -> PowerOffResult:
             calls["power_off"].append(True)
@@ -1208,6 +1449,7 @@ class TestDeployWorkflow:
                     power_query,
                     power_cycle,
                     power_on,
+                    set_boot_device,
                     power_off,
                 ],
             ) as worker:
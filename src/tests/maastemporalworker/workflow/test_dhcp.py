@@ -2,12 +2,24 @@ import pytest
 from sqlalchemy.ext.asyncio import AsyncConnection
 from temporalio.testing import ActivityEnvironment
 
+from maascommon.enums.events import EventTypeEnum
+from maascommon.workflows.configure import AGENT_ROLES_METADATA_KEY
 from maasservicelayer.db import Database
+from maasservicelayer.db.tables import (
+    EventTable,
+    EventTypeTable,
+    NodeMetadataTable,
+)
+from maasservicelayer.utils.date import utcnow
 from maasservicelayer.services import CacheForServices
 from maastemporalworker.workflow.dhcp import (
     ConfigureDHCPParam,
     DHCPConfigActivity,
+    DHCPFailoverHealthReport,
+    DHCPFailoverPeer,
+    DHCPFailoverPeersResult,
     FetchHostsForUpdateParam,
+    GetDHCPFailoverPeersParam,
     Host,
 )
 from tests.fixtures.factories.interface import create_test_interface_entry
@@ -206,6 +218,65 @@ class TestDHCPConfigActivity:
         for rc in [rack_controller1, rack_controller2, rack_controller3]:
             assert rc["system_id"] in result.agent_system_ids
 
+    async def test_find_agents_for_update_without_dhcp_role(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        env = ActivityEnvironment()
+
+        rack_controller1 = await create_test_rack_controller_entry(fixture)
+        rack_controller2 = await create_test_rack_controller_entry(fixture)
+        rack_controller3 = await create_test_rack_controller_entry(fixture)
+
+        vlan = await create_test_vlan_entry(
+            fixture,
+            primary_rack_id=rack_controller1["id"],
+            secondary_rack_id=rack_controller2["id"],
+            dhcp_on=True,
+        )
+
+        now = utcnow()
+        await fixture.create(
+            NodeMetadataTable.name,
+            [
+                {
+                    "created": now,
+                    "updated": now,
+                    "node_id": rack_controller1["id"],
+                    "key": AGENT_ROLES_METADATA_KEY,
+                    "value": '["dhcp", "power"]',
+                },
+                {
+                    "created": now,
+                    "updated": now,
+                    "node_id": rack_controller2["id"],
+                    "key": AGENT_ROLES_METADATA_KEY,
+                    "value": '["power"]',
+                },
+            ],
+        )
+
+        services_cache = CacheForServices()
+        activities = DHCPConfigActivity(
+            db, services_cache, connection=db_connection
+        )
+
+        result = await env.run(
+            activities.find_agents_for_updates,
+            ConfigureDHCPParam(
+                system_ids=[rack_controller3["system_id"]],
+                vlan_ids=[vlan["id"]],
+                subnet_ids=[],
+                static_ip_addr_ids=[],
+                ip_range_ids=[],
+                reserved_ip_ids=[],
+            ),
+        )
+
+        # Agents which didn't report roles have all of them
+        assert sorted(result.agent_system_ids) == sorted(
+            [rack_controller1["system_id"], rack_controller3["system_id"]]
+        )
+
     async def test_get_hosts_for_static_ip_addrs(
         self, fixture: Fixture, db_connection: AsyncConnection, db: Database
     ) -> None:
@@ -330,3 +401,132 @@ class TestDHCPConfigActivity:
         result = await env.run(activities.get_omapi_key)
 
         assert result.key == key.value["secret"]
+
+    async def test_get_dhcp_failover_peers(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        other_agent = await create_test_rack_controller_entry(fixture)
+        primary_vlan = await create_test_vlan_entry(
+            fixture,
+            dhcp_on=True,
+            primary_rack_id=agent["id"],
+            secondary_rack_id=other_agent["id"],
+        )
+        secondary_vlan = await create_test_vlan_entry(
+            fixture,
+            dhcp_on=True,
+            primary_rack_id=other_agent["id"],
+            secondary_rack_id=agent["id"],
+        )
+        # no failover without a secondary or with DHCP disabled
+        await create_test_vlan_entry(
+            fixture, dhcp_on=True, primary_rack_id=agent["id"]
+        )
+        await create_test_vlan_entry(
+            fixture,
+            dhcp_on=False,
+            primary_rack_id=agent["id"],
+            secondary_rack_id=other_agent["id"],
+        )
+
+        env = ActivityEnvironment()
+        activities = DHCPConfigActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_dhcp_failover_peers,
+            GetDHCPFailoverPeersParam(system_id=agent["system_id"]),
+        )
+
+        assert result == DHCPFailoverPeersResult(
+            peers=[
+                DHCPFailoverPeer(
+                    name=f"failover-vlan-{primary_vlan['id']}",
+                    peer_system_id=other_agent["system_id"],
+                ),
+                DHCPFailoverPeer(
+                    name=f"failover-vlan-{secondary_vlan['id']}",
+                    peer_system_id=other_agent["system_id"],
+                ),
+            ]
+        )
+
+    @pytest.mark.parametrize(
+        "report,event_type,description",
+        [
+            (
+                DHCPFailoverHealthReport(
+                    system_id="",
+                    name="failover-vlan-1",
+                    local_state="normal",
+                    partner_state="normal",
+                    primary=True,
+                    healthy=True,
+                ),
+                EventTypeEnum.AGENT_DHCP_FAILOVER_HEALTHY,
+                "Failover peer failover-vlan-1 (primary) is normal, "
+                "partner is normal",
+            ),
+            (
+                DHCPFailoverHealthReport(
+                    system_id="",
+                    name="failover-vlan-1",
+                    local_state="communications-interrupted",
+                    partner_state="normal",
+                ),
+                EventTypeEnum.AGENT_DHCP_FAILOVER_UNHEALTHY,
+                "Failover peer failover-vlan-1 (secondary) is "
+                "communications-interrupted, partner is normal",
+            ),
+            (
+                DHCPFailoverHealthReport(
+                    system_id="",
+                    name="failover-vlan-1",
+                    error="connection refused",
+                ),
+                EventTypeEnum.AGENT_DHCP_FAILOVER_UNHEALTHY,
+                "Failover peer failover-vlan-1: connection refused",
+            ),
+            (
+                DHCPFailoverHealthReport(
+                    system_id="",
+                    name="failover-vlan-1",
+                    local_state="partner-down",
+                    partner_state="communications-interrupted",
+                    peer_reachable=False,
+                    promoted=True,
+                ),
+                EventTypeEnum.AGENT_DHCP_FAILOVER_PROMOTED,
+                "Failover peer failover-vlan-1 promoted to partner-down, "
+                "as the partner Agent is unreachable",
+            ),
+        ],
+    )
+    async def test_report_dhcp_failover_health(
+        self,
+        fixture: Fixture,
+        db_connection: AsyncConnection,
+        db: Database,
+        report: DHCPFailoverHealthReport,
+        event_type: EventTypeEnum,
+        description: str,
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        report.system_id = agent["system_id"]
+
+        env = ActivityEnvironment()
+        activities = DHCPConfigActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(activities.report_dhcp_failover_health, report)
+
+        [event] = await fixture.get(EventTable.name)
+        [recorded_type] = await fixture.get(
+            EventTypeTable.name, EventTypeTable.c.id == event["type_id"]
+        )
+        assert recorded_type["name"] == event_type.value
+        assert event["node_id"] == agent["id"]
+        assert event["description"] == description
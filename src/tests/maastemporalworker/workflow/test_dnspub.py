@@ -0,0 +1,280 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+from typing import Any
+
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.exceptions import ApplicationError
+from temporalio.testing import ActivityEnvironment
+
+from maasservicelayer.db import Database
+from maasservicelayer.db.tables import (
+    DNSDataTable,
+    DNSResourceIPAddressTable,
+    DNSResourceTable,
+    DomainTable,
+    StaticIPAddressTable,
+)
+from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.dnspub import (
+    DNSPublicationActivity,
+    DNSRecord,
+    DNSZone,
+    DNSZoneRecords,
+    GetRackDNSZonesParam,
+    GetRackDNSZonesResult,
+    PublishDNSRecordsParam,
+    UNKNOWN_RACK_ERROR,
+)
+from tests.fixtures.factories.configuration import create_test_configuration
+from tests.fixtures.factories.interface import create_test_interface_entry
+from tests.fixtures.factories.node import create_test_rack_controller_entry
+from tests.fixtures.factories.staticipaddress import (
+    create_test_staticipaddress_entry,
+)
+from tests.fixtures.factories.subnet import create_test_subnet_entry
+from tests.fixtures.factories.vlan import create_test_vlan_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+TXT = '"path=/MAAS/metadata/"'
+
+RACK_IPS = {"rack-a": "10.0.0.2", "rack-b": "10.0.0.3"}
+
+
+async def _create_racks(fixture: Fixture) -> list[dict[str, Any]]:
+    vlan = await create_test_vlan_entry(fixture)
+    subnet = await create_test_subnet_entry(
+        fixture, cidr="10.0.0.0/24", vlan_id=vlan["id"]
+    )
+    racks = []
+    for hostname, ip in RACK_IPS.items():
+        rack = await create_test_rack_controller_entry(
+            fixture, hostname=hostname
+        )
+        ips = await create_test_staticipaddress_entry(
+            fixture, ip=ip, subnet_id=subnet["id"]
+        )
+        await create_test_interface_entry(
+            fixture, node=rack, ips=ips, vlan=vlan
+        )
+        racks.append(rack)
+    return racks
+
+
+def _rack_records(host: str, ip: str) -> list[DNSRecord]:
+    return [
+        DNSRecord(name=f"{host}.rack1.maas.", type="A", data=ip, ttl=300),
+        DNSRecord(
+            name="_maas-metadata._tcp.rack1.maas.",
+            type="SRV",
+            data=f"0 0 5248 {host}.rack1.maas.",
+            ttl=300,
+        ),
+        DNSRecord(
+            name="_maas-metadata._tcp.rack1.maas.",
+            type="TXT",
+            data=TXT,
+            ttl=300,
+        ),
+    ]
+
+
+async def _publish_racks(
+    env: ActivityEnvironment,
+    activities: DNSPublicationActivity,
+    racks: list[dict[str, Any]],
+) -> None:
+    for rack in racks:
+        await env.run(
+            activities.publish_dns_records,
+            PublishDNSRecordsParam(
+                system_id=rack["system_id"],
+                zones=[
+                    DNSZoneRecords(
+                        zone="rack1.maas",
+                        records=_rack_records(
+                            rack["hostname"], RACK_IPS[rack["hostname"]]
+                        ),
+                    )
+                ],
+            ),
+        )
+
+
+async def _published(fixture: Fixture, zone: str) -> list[tuple[str, ...]]:
+    [domain] = await fixture.get(DomainTable.name, DomainTable.c.name == zone)
+    resources = {
+        resource["id"]: resource["name"]
+        for resource in await fixture.get(
+            DNSResourceTable.name,
+            DNSResourceTable.c.domain_id == domain["id"],
+        )
+    }
+    ips = {
+        ip["id"]: str(ip["ip"])
+        for ip in await fixture.get(StaticIPAddressTable.name)
+    }
+    records = [
+        (resources[data["dnsresource_id"]], data["rrtype"], data["rrdata"])
+        for data in await fixture.get(DNSDataTable.name)
+        if data["dnsresource_id"] in resources
+    ]
+    records.extend(
+        (
+            resources[link["dnsresource_id"]],
+            "A",
+            ips[link["staticipaddress_id"]],
+        )
+        for link in await fixture.get(DNSResourceIPAddressTable.name)
+        if link["dnsresource_id"] in resources
+    )
+    # resources without records are removed
+    assert {record[0] for record in records} == set(resources.values())
+    return sorted(records)
+
+
+@pytest.mark.asyncio
+class TestDNSPublicationActivity:
+    async def test_get_rack_dns_zones(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        other_agent = await create_test_rack_controller_entry(fixture)
+        vlan = await create_test_vlan_entry(
+            fixture, primary_rack_id=other_agent["id"]
+        )
+        secondary_vlan = await create_test_vlan_entry(
+            fixture,
+            primary_rack_id=other_agent["id"],
+            secondary_rack_id=agent["id"],
+        )
+        await create_test_subnet_entry(
+            fixture, cidr="10.0.0.0/24", vlan_id=secondary_vlan["id"]
+        )
+        await create_test_subnet_entry(
+            fixture, cidr="10.0.1.0/24", vlan_id=secondary_vlan["id"]
+        )
+        await create_test_subnet_entry(
+            fixture, cidr="10.0.2.0/24", vlan_id=vlan["id"]
+        )
+        await create_test_configuration(
+            fixture,
+            name="rack_dns_zones",
+            value={"10.0.0.1/24": "rack1.maas.", "10.0.2.0/24": "rack2.maas"},
+        )
+
+        env = ActivityEnvironment()
+        activities = DNSPublicationActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_rack_dns_zones,
+            GetRackDNSZonesParam(system_id=agent["system_id"]),
+        )
+
+        assert result == GetRackDNSZonesResult(
+            zones=[DNSZone(name="rack1.maas", subnet="10.0.0.0/24")]
+        )
+
+    async def test_get_rack_dns_zones_not_configured(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        vlan = await create_test_vlan_entry(
+            fixture, primary_rack_id=agent["id"]
+        )
+        await create_test_subnet_entry(
+            fixture, cidr="10.0.0.0/24", vlan_id=vlan["id"]
+        )
+
+        env = ActivityEnvironment()
+        activities = DNSPublicationActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_rack_dns_zones,
+            GetRackDNSZonesParam(system_id=agent["system_id"]),
+        )
+
+        assert result == GetRackDNSZonesResult(zones=[])
+
+    async def test_publish_dns_records(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        rack, other_rack = await _create_racks(fixture)
+
+        env = ActivityEnvironment()
+        activities = DNSPublicationActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await _publish_racks(env, activities, [rack, other_rack])
+
+        assert await _published(fixture, "rack1.maas") == [
+            ("_maas-metadata._tcp", "SRV", "0 0 5248 rack-a.rack1.maas."),
+            ("_maas-metadata._tcp", "SRV", "0 0 5248 rack-b.rack1.maas."),
+            ("_maas-metadata._tcp", "TXT", TXT),
+            ("rack-a", "A", "10.0.0.2"),
+            ("rack-b", "A", "10.0.0.3"),
+        ]
+
+        # records are replaced when published again
+        await _publish_racks(env, activities, [rack])
+        assert len(await _published(fixture, "rack1.maas")) == 5
+
+    async def test_publish_dns_records_removes_stale_records(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        rack, other_rack = await _create_racks(fixture)
+
+        env = ActivityEnvironment()
+        activities = DNSPublicationActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await _publish_racks(env, activities, [rack, other_rack])
+
+        # the rack no longer has an address in the zone
+        await env.run(
+            activities.publish_dns_records,
+            PublishDNSRecordsParam(
+                system_id=rack["system_id"],
+                zones=[DNSZoneRecords(zone="rack1.maas", records=None)],
+            ),
+        )
+        assert await _published(fixture, "rack1.maas") == [
+            ("_maas-metadata._tcp", "SRV", "0 0 5248 rack-b.rack1.maas."),
+            ("_maas-metadata._tcp", "TXT", TXT),
+            ("rack-b", "A", "10.0.0.3"),
+        ]
+
+        await env.run(
+            activities.publish_dns_records,
+            PublishDNSRecordsParam(
+                system_id=other_rack["system_id"],
+                zones=[DNSZoneRecords(zone="rack1.maas")],
+            ),
+        )
+        assert await _published(fixture, "rack1.maas") == []
+
+    async def test_publish_dns_records_unknown_rack(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        env = ActivityEnvironment()
+        activities = DNSPublicationActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        with pytest.raises(ApplicationError) as e:
+            await env.run(
+                activities.publish_dns_records,
+                PublishDNSRecordsParam(
+                    system_id="unknown",
+                    zones=[DNSZoneRecords(zone="rack1.maas")],
+                ),
+            )
+        assert e.value.type == UNKNOWN_RACK_ERROR
+        assert e.value.non_retryable
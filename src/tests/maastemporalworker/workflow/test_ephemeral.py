@@ -0,0 +1,101 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.exceptions import ApplicationError
+from temporalio.testing import ActivityEnvironment
+
+from maascommon.enums.node import NodeStatus
+from maasservicelayer.db import Database
+from maasservicelayer.db.tables import NodeMetadataTable, NodeTable
+from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.ephemeral import (
+    boot_ephemeral,
+    EphemeralBootActivity,
+    MachineParam,
+    set_metadata,
+    UNKNOWN_MACHINE_ERROR,
+)
+from tests.fixtures.factories.node import create_test_machine_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+
+@pytest.mark.asyncio
+class TestEphemeralBootActivity:
+    async def test_restore_machine_boot(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(
+            fixture, status=NodeStatus.READY, netboot=False
+        )
+        await set_metadata(db_connection, machine["id"], "kept", "value")
+        await set_metadata(db_connection, machine["id"], "removed", "value")
+        await boot_ephemeral(
+            db_connection, machine["id"], NodeStatus.TESTING, ["removed"]
+        )
+        # booting again keeps the boot to restore
+        await boot_ephemeral(
+            db_connection, machine["id"], NodeStatus.TESTING, ["removed"]
+        )
+        [booted] = await fixture.get(
+            NodeTable.name, NodeTable.c.id == machine["id"]
+        )
+        assert booted["status"] == NodeStatus.TESTING
+        assert booted["netboot"]
+
+        env = ActivityEnvironment()
+        activities = EphemeralBootActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.restore_machine_boot,
+            MachineParam(system_id=machine["system_id"]),
+        )
+
+        [restored] = await fixture.get(
+            NodeTable.name, NodeTable.c.id == machine["id"]
+        )
+        assert restored["status"] == NodeStatus.READY
+        assert not restored["netboot"]
+        metadata = await fixture.get(NodeMetadataTable.name)
+        assert [entry["key"] for entry in metadata] == ["kept"]
+
+    async def test_restore_machine_boot_restored(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(
+            fixture, status=NodeStatus.DEPLOYED, netboot=False
+        )
+
+        env = ActivityEnvironment()
+        activities = EphemeralBootActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.restore_machine_boot,
+            MachineParam(system_id=machine["system_id"]),
+        )
+
+        [node] = await fixture.get(
+            NodeTable.name, NodeTable.c.id == machine["id"]
+        )
+        assert node["status"] == NodeStatus.DEPLOYED
+
+    async def test_restore_machine_boot_unknown_machine(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        env = ActivityEnvironment()
+        activities = EphemeralBootActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        with pytest.raises(ApplicationError) as e:
+            await env.run(
+                activities.restore_machine_boot,
+                MachineParam(system_id="unknown"),
+            )
+        assert e.value.type == UNKNOWN_MACHINE_ERROR
+        assert e.value.non_retryable
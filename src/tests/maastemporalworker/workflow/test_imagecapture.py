@@ -0,0 +1,161 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+import json
+
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.exceptions import ApplicationError
+from temporalio.testing import ActivityEnvironment
+
+from maascommon.enums.events import EventTypeEnum
+from maascommon.enums.node import NodeStatus
+from maasservicelayer.db import Database
+from maasservicelayer.db.tables import (
+    EventTable,
+    EventTypeTable,
+    NodeMetadataTable,
+    NodeTable,
+)
+from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.ephemeral import (
+    EphemeralBootActivity,
+    MachineParam,
+    UNKNOWN_MACHINE_ERROR,
+)
+from maastemporalworker.workflow.imagecapture import (
+    CAPTURED_IMAGES_KEY,
+    CapturedImageParam,
+    EphemeralCaptureParam,
+    IMAGE_CAPTURE_DISK_KEY,
+    IMAGE_CAPTURE_UPLOAD_PATH_KEY,
+    ImageCaptureActivity,
+)
+from tests.fixtures.factories.node import create_test_machine_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+UPLOAD_PATH = "/capture/image/abc/golden?expires=1&signature=xyz"
+
+
+async def _metadata(fixture: Fixture, machine_id: int) -> dict[str, str]:
+    entries = await fixture.get(
+        NodeMetadataTable.name, NodeMetadataTable.c.node_id == machine_id
+    )
+    return {entry["key"]: entry["value"] for entry in entries}
+
+
+@pytest.mark.asyncio
+class TestImageCaptureActivity:
+    async def test_set_machine_ephemeral_capture(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(
+            fixture, status=NodeStatus.DEPLOYED, netboot=False
+        )
+
+        env = ActivityEnvironment()
+        activities = ImageCaptureActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.set_machine_ephemeral_capture,
+            EphemeralCaptureParam(
+                system_id=machine["system_id"],
+                disk="/dev/sda",
+                upload_path=UPLOAD_PATH,
+            ),
+        )
+
+        [node] = await fixture.get(
+            NodeTable.name, NodeTable.c.id == machine["id"]
+        )
+        assert node["status"] == NodeStatus.RESCUE_MODE
+        assert node["netboot"]
+        metadata = await _metadata(fixture, machine["id"])
+        assert metadata[IMAGE_CAPTURE_DISK_KEY] == "/dev/sda"
+        assert metadata[IMAGE_CAPTURE_UPLOAD_PATH_KEY] == UPLOAD_PATH
+
+        # the capture metadata is removed with the ephemeral boot
+        await env.run(
+            EphemeralBootActivity(
+                db, CacheForServices(), connection=db_connection
+            ).restore_machine_boot,
+            MachineParam(system_id=machine["system_id"]),
+        )
+        [node] = await fixture.get(
+            NodeTable.name, NodeTable.c.id == machine["id"]
+        )
+        assert node["status"] == NodeStatus.DEPLOYED
+        assert not node["netboot"]
+        assert await _metadata(fixture, machine["id"]) == {}
+
+    async def test_register_captured_image(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(fixture)
+
+        env = ActivityEnvironment()
+        activities = ImageCaptureActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        for name, sha256, size in [
+            ("golden", "aaa", 10),
+            ("other", "bbb", 20),
+            ("golden", "ccc", 30),
+        ]:
+            await env.run(
+                activities.register_captured_image,
+                CapturedImageParam(
+                    system_id=machine["system_id"],
+                    name=name,
+                    key=f"image/{machine['system_id']}/{name}",
+                    sha256=sha256,
+                    size=size,
+                ),
+            )
+
+        # captures with the same name replace the previous one
+        metadata = await _metadata(fixture, machine["id"])
+        assert json.loads(metadata[CAPTURED_IMAGES_KEY]) == {
+            "golden": {
+                "key": f"image/{machine['system_id']}/golden",
+                "sha256": "ccc",
+                "size": 30,
+            },
+            "other": {
+                "key": f"image/{machine['system_id']}/other",
+                "sha256": "bbb",
+                "size": 20,
+            },
+        }
+
+        events = await fixture.get(EventTable.name)
+        assert sorted(event["description"] for event in events) == [
+            "golden (10 bytes)",
+            "golden (30 bytes)",
+            "other (20 bytes)",
+        ]
+        [event_type] = await fixture.get(EventTypeTable.name)
+        assert event_type["name"] == EventTypeEnum.NODE_IMAGE_CAPTURED.value
+
+    async def test_set_machine_ephemeral_capture_unknown_machine(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        env = ActivityEnvironment()
+        activities = ImageCaptureActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        with pytest.raises(ApplicationError) as e:
+            await env.run(
+                activities.set_machine_ephemeral_capture,
+                EphemeralCaptureParam(
+                    system_id="unknown",
+                    disk="/dev/sda",
+                    upload_path=UPLOAD_PATH,
+                ),
+            )
+        assert e.value.type == UNKNOWN_MACHINE_ERROR
+        assert e.value.non_retryable
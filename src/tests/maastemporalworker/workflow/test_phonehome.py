@@ -0,0 +1,65 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.exceptions import ApplicationError
+from temporalio.testing import ActivityEnvironment
+
+from maasservicelayer.db import Database
+from maasservicelayer.db.tables import NodeMetadataTable
+from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.phonehome import (
+    PHONE_HOME_CALLBACK_PATH_KEY,
+    PhoneHomeActivity,
+    SetMachinePhoneHomeParam,
+    UNKNOWN_MACHINE_ERROR,
+)
+from tests.fixtures.factories.node import create_test_machine_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+
+@pytest.mark.asyncio
+class TestPhoneHomeActivity:
+    async def test_set_machine_phone_home(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(fixture)
+
+        env = ActivityEnvironment()
+        activities = PhoneHomeActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        # a new deployment replaces the callback of the previous one
+        for signature in ("abc", "def"):
+            await env.run(
+                activities.set_machine_phone_home,
+                SetMachinePhoneHomeParam(
+                    system_id=machine["system_id"],
+                    callback_path=f"/phone-home/x?signature={signature}",
+                ),
+            )
+
+        [metadata] = await fixture.get(NodeMetadataTable.name)
+        assert metadata["node_id"] == machine["id"]
+        assert metadata["key"] == PHONE_HOME_CALLBACK_PATH_KEY
+        assert metadata["value"] == "/phone-home/x?signature=def"
+
+    async def test_set_machine_phone_home_unknown_machine(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        env = ActivityEnvironment()
+        activities = PhoneHomeActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        with pytest.raises(ApplicationError) as e:
+            await env.run(
+                activities.set_machine_phone_home,
+                SetMachinePhoneHomeParam(
+                    system_id="unknown", callback_path="/phone-home/x"
+                ),
+            )
+        assert e.value.type == UNKNOWN_MACHINE_ERROR
+        assert e.value.non_retryable
@@ -1,25 +1,83 @@
 #  Copyright 2024 Canonical Ltd.  This software is licensed under the
 #  GNU Affero General Public License version 3 (see the file LICENSE).
 
-from collections import namedtuple
+import asyncio
+from collections import defaultdict, namedtuple
+from datetime import timedelta
+from functools import partial
 from unittest.mock import Mock
+import uuid
 
 import pytest
+from sqlalchemy import select
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio import activity
+from temporalio.client import WorkflowFailureError
+from temporalio.exceptions import ApplicationError
+from temporalio.testing import ActivityEnvironment, WorkflowEnvironment
+from temporalio.worker import Worker
 
+from maascommon.enums.node import NodeStatus
+from maascommon.workflows.configure import AGENT_ROLES_METADATA_KEY
 from maascommon.workflows.power import (
+    get_boot_mode,
+    POWER_CYCLE_WORKFLOW_NAME,
+    POWER_OFF_WORKFLOW_NAME,
+    POWER_ON_TEMPORARILY_RELEASE_SIGNAL,
+    POWER_ON_TEMPORARILY_WORKFLOW_NAME,
+    POWER_ON_WORKFLOW_NAME,
+    POWER_QUERY_WORKFLOW_NAME,
     PowerCycleParam,
     PowerOffParam,
     PowerOnParam,
+    PowerOnTemporarilyParam,
     PowerQueryParam,
+    PowerResetParam,
 )
 from maasserver.models import bmc as model_bmc
+from maasservicelayer.db import Database
+from maasservicelayer.db.tables import NodeTable
+from maasservicelayer.services import CacheForServices
+from maasservicelayer.utils.date import utcnow
 from maastemporalworker.workflow import power as power_workflow
 from maastemporalworker.workflow.power import (
     convert_power_action_to_power_workflow,
     get_temporal_task_queue_for_bmc,
+    GetTransitioningMachinesParam,
+    GetTransitioningMachinesResult,
+    POWER_CYCLE_ACTIVITY_NAME,
+    POWER_OFF_ACTIVITY_NAME,
+    POWER_ON_ACTIVITY_NAME,
+    POWER_QUERY_ACTIVITY_NAME,
+    PowerActivity,
+    PowerCycleResult,
+    PowerCycleWorkflow,
+    PowerOffResult,
+    PowerOffWorkflow,
+    PowerOnResult,
+    PowerOnTemporarilyWorkflow,
+    PowerOnWorkflow,
+    PowerQueryResult,
+    PowerQueryWorkflow,
+    PowerStateReport,
+    ReportPowerStatesParam,
+    TransitioningMachine,
     UnknownPowerActionException,
     UnroutablePowerWorkflowException,
 )
+from provisioningserver.enum import POWER_STATE
+from tests.fixtures.factories.bmc import create_test_bmc_entry
+from tests.fixtures.factories.node import (
+    create_test_machine_entry,
+    create_test_rack_controller_entry,
+)
+from tests.fixtures.factories.secret import create_test_secret
+from tests.fixtures.factories.staticipaddress import (
+    create_test_staticipaddress_entry,
+)
+from tests.fixtures.factories.subnet import create_test_subnet_entry
+from tests.fixtures.factories.vlan import create_test_vlan_entry
+from tests.maasapiserver.fixtures.db import Fixture
 
 
 @pytest.mark.usefixtures("maasdb")
@@ -50,6 +108,33 @@ class TestGetTemporalQueueForMachine:
         queue = get_temporal_task_queue_for_bmc(machine)
         assert queue == f"agent:power@vlan-{vlan.id}"
 
+    def test_get_temporal_task_queue_for_bmc_skips_racks_without_power_role(
+        self, factory, mocker
+    ):
+        vlan = factory.make_VLAN()
+        subnet = factory.make_Subnet(vlan=vlan)
+        rack = factory.make_RackController()
+        factory.make_Interface(
+            node=rack,
+            vlan=vlan,
+            subnet=subnet,
+            ip=subnet.get_next_ip_for_allocation()[0],
+        )
+        factory.make_NodeMetadata(
+            node=rack, key=AGENT_ROLES_METADATA_KEY, value='["dhcp"]'
+        )
+        ip = factory.make_StaticIPAddress(subnet=subnet)
+        bmc = factory.make_BMC(ip_address=ip)
+        machine = factory.make_Machine(bmc=bmc)
+        factory.make_BMCRoutableRackControllerRelationship(bmc, rack)
+
+        client = Mock()
+        client.ident = rack.system_id
+        mocker.patch.object(model_bmc, "getAllClients").return_value = [client]
+
+        with pytest.raises(UnroutablePowerWorkflowException):
+            get_temporal_task_queue_for_bmc(machine)
+
     def test_get_temporal_task_queue_for_bmc_machine_with_bmc_without_vlan(
         self, factory, mocker
     ):
@@ -84,6 +169,7 @@ class TestGetTemporalQueueForMachine:
             "power_off": PowerOffParam,
             "power_query": PowerQueryParam,
             "power_cycle": PowerCycleParam,
+            "power_reset": PowerResetParam,
         }
         machine = factory.make_Machine()
         params = namedtuple("params", ["power_type", "power_parameters"])(
@@ -111,7 +197,58 @@ class TestGetTemporalQueueForMachine:
                 task_queue="agent:power@vlan-1",
                 driver_type=params.power_type,
                 driver_opts=params.power_parameters,
+                reason=power_action.replace("_", "-"),
+                correlation_id=workflow_param.correlation_id,
             )
+            assert uuid.UUID(workflow_param.correlation_id)
+
+    def test_convert_power_action_to_power_workflow_with_requester(
+        self, factory, mocker
+    ):
+        machine = factory.make_Machine()
+        params = namedtuple("params", ["power_type", "power_parameters"])(
+            {}, {}
+        )
+
+        mocked_get_temporal_task_queue_for_bmc = mocker.patch.object(
+            power_workflow, "get_temporal_task_queue_for_bmc"
+        )
+        mocked_get_temporal_task_queue_for_bmc.return_value = (
+            "agent:power@vlan-1"
+        )
+
+        _, first = convert_power_action_to_power_workflow(
+            "power-off", machine, params, "admin"
+        )
+        _, second = convert_power_action_to_power_workflow(
+            "power-off", machine, params, "admin"
+        )
+
+        assert first.requester == "admin"
+        assert first.reason == "power-off"
+        # Every power action is correlated separately
+        assert first.correlation_id != second.correlation_id
+
+    def test_convert_power_action_to_power_workflow_with_boot_mode(
+        self, factory, mocker
+    ):
+        machine = factory.make_Machine(bios_boot_method="uefi")
+        params = namedtuple("params", ["power_type", "power_parameters"])(
+            {}, {}
+        )
+
+        mocked_get_temporal_task_queue_for_bmc = mocker.patch.object(
+            power_workflow, "get_temporal_task_queue_for_bmc"
+        )
+        mocked_get_temporal_task_queue_for_bmc.return_value = (
+            "agent:power@vlan-1"
+        )
+
+        _, workflow_param = convert_power_action_to_power_workflow(
+            "power-on", machine, params
+        )
+
+        assert workflow_param.boot_mode == "uefi"
 
     def test_convert_power_action_to_power_workflow_fail_unknown(
         self, factory, mocker
@@ -132,3 +269,511 @@ class TestGetTemporalQueueForMachine:
             convert_power_action_to_power_workflow(
                 power_action, machine, params
             )
+
+
+@pytest.mark.parametrize(
+    "bios_boot_method,boot_mode",
+    [
+        ("uefi", "uefi"),
+        ("pxe", "legacy"),
+        ("powernv", None),
+        (None, None),
+    ],
+)
+def test_get_boot_mode(bios_boot_method, boot_mode):
+    assert get_boot_mode(bios_boot_method) == boot_mode
+
+
+class TestPowerWorkflowRequestMetadata:
+    async def _run(
+        self, workflow_cls, workflow_name, activity_name, param
+    ) -> tuple:
+        received = []
+
+        # The Agent decodes the parameter into PowerParam of its own
+        @activity.defn(name=activity_name)
+        async def power(params: dict) -> dict:
+            received.append(params)
+            return {"state": "on"}
+
+        workflow_id = f"workflow-{uuid.uuid4()}"
+
+        async with await WorkflowEnvironment.start_time_skipping() as env:
+            async with Worker(
+                env.client,
+                task_queue="region",
+                workflows=[workflow_cls],
+                activities=[power],
+            ) as worker:
+                await env.client.execute_workflow(
+                    workflow_name,
+                    param(task_queue=worker.task_queue),
+                    id=workflow_id,
+                    task_queue=worker.task_queue,
+                )
+
+        return received, workflow_id
+
+    @pytest.mark.parametrize(
+        "workflow_cls,workflow_name,activity_name,param_cls",
+        [
+            (
+                PowerOnWorkflow,
+                POWER_ON_WORKFLOW_NAME,
+                POWER_ON_ACTIVITY_NAME,
+                PowerOnParam,
+            ),
+            (
+                PowerOffWorkflow,
+                POWER_OFF_WORKFLOW_NAME,
+                POWER_OFF_ACTIVITY_NAME,
+                PowerOffParam,
+            ),
+            (
+                PowerQueryWorkflow,
+                POWER_QUERY_WORKFLOW_NAME,
+                POWER_QUERY_ACTIVITY_NAME,
+                PowerQueryParam,
+            ),
+            (
+                PowerCycleWorkflow,
+                POWER_CYCLE_WORKFLOW_NAME,
+                POWER_CYCLE_ACTIVITY_NAME,
+                PowerCycleParam,
+            ),
+        ],
+    )
+    async def test_metadata_reaches_agent(
+        self, workflow_cls, workflow_name, activity_name, param_cls
+    ):
+        received, _ = await self._run(
+            workflow_cls,
+            workflow_name,
+            activity_name,
+            partial(
+                param_cls,
+                system_id="abc",
+                driver_type="redfish",
+                driver_opts={},
+                requester="admin",
+                reason="deploy",
+                correlation_id="req-1",
+            ),
+        )
+
+        assert len(received) == 1
+        assert received[0]["requester"] == "admin"
+        assert received[0]["reason"] == "deploy"
+        assert received[0]["correlation_id"] == "req-1"
+
+    async def test_correlated_with_workflow(self):
+        received, workflow_id = await self._run(
+            PowerOnWorkflow,
+            POWER_ON_WORKFLOW_NAME,
+            POWER_ON_ACTIVITY_NAME,
+            partial(
+                PowerOnParam,
+                system_id="abc",
+                driver_type="redfish",
+                driver_opts={},
+            ),
+        )
+
+        assert received[0]["requester"] is None
+        assert received[0]["correlation_id"] == workflow_id
+
+
+class TestPowerCycleWorkflow:
+    def _activities(self, calls: dict, native: bool) -> list:
+        state = {"power": "on"}
+
+        @activity.defn(name=POWER_CYCLE_ACTIVITY_NAME)
+        async def power_cycle(params: dict) -> PowerCycleResult:
+            calls["actions"].append("cycle")
+            calls["params"].append(params)
+            if not native:
+                raise ApplicationError(
+                    "unsupported power action",
+                    type="POWER_UNSUPPORTED",
+                )
+            return PowerCycleResult(state="on")
+
+        @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
+        async def power_off(params: dict) -> PowerOffResult:
+            calls["actions"].append("off")
+            calls["params"].append(params)
+            state["power"] = "off"
+            return PowerOffResult(state="off")
+
+        @activity.defn(name=POWER_ON_ACTIVITY_NAME)
+        async def power_on(params: dict) -> PowerOnResult:
+            calls["actions"].append("on")
+            calls["params"].append(params)
+            state["power"] = "on"
+            return PowerOnResult(state="on")
+
+        @activity.defn(name=POWER_QUERY_ACTIVITY_NAME)
+        async def power_query(params: dict) -> PowerQueryResult:
+            calls["actions"].append("query")
+            calls["params"].append(params)
+            return PowerQueryResult(state=state["power"])
+
+        return [power_cycle, power_off, power_on, power_query]
+
+    async def _run(self, native: bool, emulate: bool = True):
+        calls = defaultdict(list)
+
+        async with await WorkflowEnvironment.start_time_skipping() as env:
+            async with Worker(
+                env.client,
+                task_queue="region",
+                workflows=[PowerCycleWorkflow],
+                activities=self._activities(calls, native),
+            ) as worker:
+                result = await env.client.execute_workflow(
+                    POWER_CYCLE_WORKFLOW_NAME,
+                    PowerCycleParam(
+                        system_id="abc",
+                        driver_type="redfish",
+                        driver_opts={},
+                        task_queue=worker.task_queue,
+                        emulate=emulate,
+                    ),
+                    id=f"workflow-{uuid.uuid4()}",
+                    task_queue=worker.task_queue,
+                )
+
+        return result, calls
+
+    async def test_native_cycle(self):
+        result, calls = await self._run(native=True)
+
+        assert result == {"state": "on"}
+        assert calls["actions"] == ["cycle"]
+
+    async def test_emulated_cycle(self):
+        result, calls = await self._run(native=False)
+
+        assert result == {"state": "on"}
+        # Unsupported cycle is not retried
+        assert calls["actions"] == ["cycle", "off", "query", "on", "query"]
+        # Activities get the same parameter as the ones of other workflows
+        for params in calls["params"]:
+            assert params.keys() == {
+                "driver_type",
+                "driver_opts",
+                "boot_mode",
+                "requester",
+                "reason",
+                "correlation_id",
+            }
+
+    async def test_emulation_disabled(self):
+        with pytest.raises(WorkflowFailureError):
+            await self._run(native=False, emulate=False)
+
+
+class TestPowerOnTemporarilyWorkflow:
+    def _activities(self, actions: list) -> list:
+        @activity.defn(name=POWER_ON_ACTIVITY_NAME)
+        async def power_on(params: PowerOnParam) -> PowerOnResult:
+            actions.append("on")
+            return PowerOnResult(state="on")
+
+        @activity.defn(name=POWER_OFF_ACTIVITY_NAME)
+        async def power_off(params: PowerOffParam) -> PowerOffResult:
+            actions.append("off")
+            return PowerOffResult(state="off")
+
+        return [power_on, power_off]
+
+    async def _run(self, actions: list, handle_fn) -> tuple:
+        async with await WorkflowEnvironment.start_time_skipping() as env:
+            async with Worker(
+                env.client,
+                task_queue="region",
+                workflows=[PowerOnTemporarilyWorkflow],
+                activities=self._activities(actions),
+            ) as worker:
+                start = await env.get_current_time()
+                handle = await env.client.start_workflow(
+                    POWER_ON_TEMPORARILY_WORKFLOW_NAME,
+                    PowerOnTemporarilyParam(
+                        system_id="abc",
+                        driver_type="redfish",
+                        driver_opts={},
+                        task_queue=worker.task_queue,
+                        duration=3600,
+                    ),
+                    id=f"workflow-{uuid.uuid4()}",
+                    task_queue=worker.task_queue,
+                )
+
+                # Wait for the machine to be held on
+                while "on" not in actions:
+                    await asyncio.sleep(0.1)
+
+                await handle_fn(handle)
+                result = await handle.result()
+                held = await env.get_current_time() - start
+
+        return result, held
+
+    async def test_powered_off_after_duration(self):
+        actions = []
+
+        async def wait(handle):
+            pass
+
+        result, held = await self._run(actions, wait)
+
+        assert result == {"state": "off"}
+        assert actions == ["on", "off"]
+        assert held >= timedelta(hours=1)
+
+    async def test_released_early(self):
+        actions = []
+
+        async def release(handle):
+            await handle.signal(POWER_ON_TEMPORARILY_RELEASE_SIGNAL)
+
+        result, held = await self._run(actions, release)
+
+        assert result == {"state": "off"}
+        assert actions == ["on", "off"]
+        assert held < timedelta(hours=1)
+
+    async def test_powered_off_when_cancelled(self):
+        actions = []
+
+        async def cancel(handle):
+            await handle.cancel()
+
+        with pytest.raises(WorkflowFailureError):
+            await self._run(actions, cancel)
+
+        assert actions == ["on", "off"]
+
+
+@pytest.mark.asyncio
+class TestPowerActivity:
+    async def test_get_transitioning_machines(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        other_agent = await create_test_rack_controller_entry(fixture)
+
+        # BMC on a VLAN served by the Agent
+        vlan = await create_test_vlan_entry(
+            fixture, primary_rack_id=agent["id"]
+        )
+        subnet = await create_test_subnet_entry(fixture, vlan_id=vlan["id"])
+        [ip] = await create_test_staticipaddress_entry(fixture, subnet=subnet)
+        ipmi = await create_test_bmc_entry(
+            fixture,
+            power_type="ipmi",
+            ip_address_id=ip["id"],
+            power_parameters={"power_address": "10.0.0.1"},
+        )
+        await create_test_secret(
+            fixture,
+            path=f"bmc/{ipmi['id']}/power-parameters",
+            value={"power_pass": "secret"},
+        )
+        deploying = await create_test_machine_entry(
+            fixture,
+            bmc_id=ipmi["id"],
+            status=NodeStatus.DEPLOYING,
+            power_state=POWER_STATE.OFF,
+            bios_boot_method="uefi",
+        )
+        # already in the expected state
+        await create_test_machine_entry(
+            fixture,
+            bmc_id=ipmi["id"],
+            status=NodeStatus.COMMISSIONING,
+            power_state=POWER_STATE.ON,
+        )
+        # not in transition
+        await create_test_machine_entry(
+            fixture,
+            bmc_id=ipmi["id"],
+            status=NodeStatus.READY,
+            power_state=POWER_STATE.ON,
+        )
+
+        # BMC routable from the Agent
+        virsh = await create_test_bmc_entry(
+            fixture,
+            power_type="virsh",
+            power_parameters={"power_address": "qemu+ssh://host/system"},
+        )
+        await fixture.create(
+            "maasserver_bmcroutablerackcontrollerrelationship",
+            [
+                {
+                    "created": utcnow(),
+                    "updated": utcnow(),
+                    "routable": True,
+                    "bmc_id": virsh["id"],
+                    "rack_controller_id": agent["id"],
+                }
+            ],
+        )
+        releasing = await create_test_machine_entry(
+            fixture,
+            bmc_id=virsh["id"],
+            status=NodeStatus.RELEASING,
+            power_state=POWER_STATE.ON,
+            instance_power_parameters={"power_id": "vm1"},
+        )
+
+        # BMC served by another Agent
+        other_vlan = await create_test_vlan_entry(
+            fixture, primary_rack_id=other_agent["id"]
+        )
+        other_subnet = await create_test_subnet_entry(
+            fixture, vlan_id=other_vlan["id"]
+        )
+        [other_ip] = await create_test_staticipaddress_entry(
+            fixture, subnet=other_subnet
+        )
+        other_bmc = await create_test_bmc_entry(
+            fixture, power_type="ipmi", ip_address_id=other_ip["id"]
+        )
+        await create_test_machine_entry(
+            fixture,
+            bmc_id=other_bmc["id"],
+            status=NodeStatus.DEPLOYING,
+            power_state=POWER_STATE.OFF,
+        )
+
+        env = ActivityEnvironment()
+        activities = PowerActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_transitioning_machines,
+            GetTransitioningMachinesParam(system_id=agent["system_id"]),
+        )
+
+        assert result == GetTransitioningMachinesResult(
+            machines=[
+                TransitioningMachine(
+                    system_id=deploying["system_id"],
+                    expected_state=POWER_STATE.ON,
+                    driver_type="ipmi",
+                    driver_opts={
+                        "power_address": "10.0.0.1",
+                        "power_pass": "secret",
+                        "power_off_mode": "",
+                        "system_id": deploying["system_id"],
+                    },
+                    boot_mode="uefi",
+                ),
+                TransitioningMachine(
+                    system_id=releasing["system_id"],
+                    expected_state=POWER_STATE.OFF,
+                    driver_type="virsh",
+                    driver_opts={
+                        "power_address": "qemu+ssh://host/system",
+                        "power_id": "vm1",
+                        "system_id": releasing["system_id"],
+                    },
+                ),
+            ]
+        )
+
+    async def test_get_transitioning_machines_unknown_agent(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        env = ActivityEnvironment()
+        activities = PowerActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_transitioning_machines,
+            GetTransitioningMachinesParam(system_id="unknown"),
+        )
+
+        assert result == GetTransitioningMachinesResult(machines=[])
+
+    async def test_report_power_states(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        machines = [
+            await create_test_machine_entry(
+                fixture,
+                status=NodeStatus.DEPLOYING,
+                power_state=POWER_STATE.OFF,
+            )
+            for _ in range(4)
+        ]
+
+        env = ActivityEnvironment()
+        activities = PowerActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.report_power_states,
+            ReportPowerStatesParam(
+                system_id=agent["system_id"],
+                reports=[
+                    PowerStateReport(
+                        system_id=machines[0]["system_id"],
+                        expected_state=POWER_STATE.ON,
+                        actual_state=POWER_STATE.ON,
+                    ),
+                    PowerStateReport(
+                        system_id=machines[1]["system_id"],
+                        expected_state=POWER_STATE.ON,
+                        error="authentication failed",
+                        error_code="POWER_AUTH_FAILED",
+                    ),
+                    # final state is reported once the Agent stops watching
+                    PowerStateReport(
+                        system_id=machines[2]["system_id"],
+                        expected_state=POWER_STATE.ON,
+                        error="connection refused",
+                        watched=True,
+                    ),
+                    PowerStateReport(
+                        system_id=machines[3]["system_id"],
+                        expected_state=POWER_STATE.ON,
+                        actual_state=POWER_STATE.OFF,
+                        watched=True,
+                    ),
+                    # deleted machines are ignored
+                    PowerStateReport(
+                        system_id="deleted",
+                        expected_state=POWER_STATE.ON,
+                        actual_state=POWER_STATE.ON,
+                    ),
+                ],
+            ),
+        )
+
+        result = await db_connection.execute(
+            select(
+                NodeTable.c.system_id,
+                NodeTable.c.power_state,
+                NodeTable.c.power_state_updated,
+            ).filter(
+                NodeTable.c.system_id.in_([m["system_id"] for m in machines])
+            )
+        )
+        states = {
+            row.system_id: (row.power_state, row.power_state_updated)
+            for row in result.all()
+        }
+
+        assert states[machines[0]["system_id"]][0] == POWER_STATE.ON
+        assert states[machines[0]["system_id"]][1] is not None
+        assert states[machines[1]["system_id"]][0] == POWER_STATE.ERROR
+        assert states[machines[2]["system_id"]] == (POWER_STATE.OFF, None)
+        assert states[machines[3]["system_id"]][0] == POWER_STATE.OFF
+        assert states[machines[3]["system_id"]][1] is not None
@@ -0,0 +1,104 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.testing import ActivityEnvironment
+
+from maasservicelayer.db import Database
+from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.subnet import (
+    GetSubnetServicesParam,
+    GetSubnetServicesResult,
+    SubnetServices,
+    SubnetServicesActivity,
+)
+from tests.fixtures.factories.configuration import create_test_configuration
+from tests.fixtures.factories.node import create_test_rack_controller_entry
+from tests.fixtures.factories.subnet import create_test_subnet_entry
+from tests.fixtures.factories.vlan import create_test_vlan_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+
+@pytest.mark.asyncio
+class TestSubnetServicesActivity:
+    async def test_get_subnet_services(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        agent = await create_test_rack_controller_entry(fixture)
+        other_agent = await create_test_rack_controller_entry(fixture)
+        vlan = await create_test_vlan_entry(
+            fixture, primary_rack_id=agent["id"]
+        )
+        secondary_vlan = await create_test_vlan_entry(
+            fixture,
+            primary_rack_id=other_agent["id"],
+            secondary_rack_id=agent["id"],
+        )
+        other_vlan = await create_test_vlan_entry(
+            fixture, primary_rack_id=other_agent["id"]
+        )
+        await create_test_subnet_entry(
+            fixture,
+            cidr="10.0.0.0/24",
+            vlan_id=vlan["id"],
+            dns_servers=["10.0.0.53"],
+        )
+        await create_test_subnet_entry(
+            fixture, cidr="10.0.1.0/24", vlan_id=secondary_vlan["id"]
+        )
+        await create_test_subnet_entry(
+            fixture, cidr="10.0.2.0/24", vlan_id=other_vlan["id"]
+        )
+        await create_test_configuration(
+            fixture,
+            name="subnet_services",
+            value={
+                "10.0.0.0/24": {
+                    "images": ["ubuntu/noble"],
+                    "proxies": ["http://10.0.0.2:5240/MAAS/"],
+                },
+                # relayed subnets are configured too
+                "192.168.0.1/24": {"dns_servers": ["192.168.0.53"]},
+            },
+        )
+
+        env = ActivityEnvironment()
+        activities = SubnetServicesActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_subnet_services,
+            GetSubnetServicesParam(system_id=agent["system_id"]),
+        )
+
+        assert result == GetSubnetServicesResult(
+            subnets=[
+                SubnetServices(
+                    subnet="10.0.0.0/24",
+                    images=["ubuntu/noble"],
+                    proxies=["http://10.0.0.2:5240/MAAS/"],
+                    dns_servers=["10.0.0.53"],
+                ),
+                SubnetServices(subnet="10.0.1.0/24"),
+                SubnetServices(
+                    subnet="192.168.0.0/24", dns_servers=["192.168.0.53"]
+                ),
+            ]
+        )
+
+    async def test_get_subnet_services_unknown_agent(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        env = ActivityEnvironment()
+        activities = SubnetServicesActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        result = await env.run(
+            activities.get_subnet_services,
+            GetSubnetServicesParam(system_id="unknown"),
+        )
+
+        assert result == GetSubnetServicesResult(subnets=[])
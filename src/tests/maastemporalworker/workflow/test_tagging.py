@@ -0,0 +1,141 @@
+# Copyright 2024 Canonical Ltd.  This software is licensed under the
+# GNU Affero General Public License version 3 (see the file LICENSE).
+
+import pytest
+from sqlalchemy.ext.asyncio import AsyncConnection
+from temporalio.exceptions import ApplicationError
+from temporalio.testing import ActivityEnvironment
+
+from maasservicelayer.db import Database
+from maasservicelayer.db.tables import NodeTagTable, TagTable
+from maasservicelayer.services import CacheForServices
+from maastemporalworker.workflow.tagging import (
+    AGENT_TAG_COMMENT,
+    MachineTaggingActivity,
+    SetMachineTagsParam,
+    UNKNOWN_MACHINE_ERROR,
+)
+from tests.fixtures.factories.node import create_test_machine_entry
+from tests.fixtures.factories.tag import create_test_tag_entry
+from tests.maasapiserver.fixtures.db import Fixture
+
+
+async def _machine_tags(fixture: Fixture, machine_id: int) -> list[str]:
+    links = await fixture.get(
+        NodeTagTable.name, NodeTagTable.c.node_id == machine_id
+    )
+    tags = await fixture.get(TagTable.name)
+    names = {tag["id"]: tag["name"] for tag in tags}
+    return sorted(names[link["tag_id"]] for link in links)
+
+
+@pytest.mark.asyncio
+class TestMachineTaggingActivity:
+    async def test_set_machine_tags(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(fixture)
+
+        env = ActivityEnvironment()
+        activities = MachineTaggingActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.set_machine_tags,
+            SetMachineTagsParam(
+                system_id=machine["system_id"], tags=["gpu", "nvme"]
+            ),
+        )
+
+        assert await _machine_tags(fixture, machine["id"]) == ["gpu", "nvme"]
+        tags = await fixture.get(TagTable.name)
+        assert {tag["comment"] for tag in tags} == {AGENT_TAG_COMMENT}
+
+    async def test_set_machine_tags_replaces_agent_tags(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(fixture)
+        other_machine = await create_test_machine_entry(fixture)
+        user_tag = await create_test_tag_entry(
+            fixture, name="rack-1", definition=""
+        )
+        await fixture.create(
+            NodeTagTable.name,
+            {"node_id": machine["id"], "tag_id": user_tag["id"]},
+        )
+
+        env = ActivityEnvironment()
+        activities = MachineTaggingActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.set_machine_tags,
+            SetMachineTagsParam(
+                system_id=machine["system_id"], tags=["gpu", "nvme"]
+            ),
+        )
+        await env.run(
+            activities.set_machine_tags,
+            SetMachineTagsParam(
+                system_id=other_machine["system_id"], tags=["nvme"]
+            ),
+        )
+        await env.run(
+            activities.set_machine_tags,
+            SetMachineTagsParam(
+                system_id=machine["system_id"], tags=["nvme", "sriov"]
+            ),
+        )
+
+        # tags of users are kept, as are tags of other machines
+        assert await _machine_tags(fixture, machine["id"]) == [
+            "nvme",
+            "rack-1",
+            "sriov",
+        ]
+        assert await _machine_tags(fixture, other_machine["id"]) == ["nvme"]
+
+    async def test_set_machine_tags_reuses_user_tags(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        machine = await create_test_machine_entry(fixture)
+        user_tag = await create_test_tag_entry(
+            fixture, name="gpu", definition=""
+        )
+
+        env = ActivityEnvironment()
+        activities = MachineTaggingActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        await env.run(
+            activities.set_machine_tags,
+            SetMachineTagsParam(system_id=machine["system_id"], tags=["gpu"]),
+        )
+        await env.run(
+            activities.set_machine_tags,
+            SetMachineTagsParam(system_id=machine["system_id"], tags=[]),
+        )
+
+        # the tag belongs to users, so the Agent never removes it
+        assert await _machine_tags(fixture, machine["id"]) == ["gpu"]
+        [tag] = await fixture.get(TagTable.name)
+        assert tag["id"] == user_tag["id"]
+
+    async def test_set_machine_tags_unknown_machine(
+        self, fixture: Fixture, db_connection: AsyncConnection, db: Database
+    ) -> None:
+        env = ActivityEnvironment()
+        activities = MachineTaggingActivity(
+            db, CacheForServices(), connection=db_connection
+        )
+
+        with pytest.raises(ApplicationError) as e:
+            await env.run(
+                activities.set_machine_tags,
+                SetMachineTagsParam(system_id="unknown", tags=["gpu"]),
+            )
+        assert e.value.type == UNKNOWN_MACHINE_ERROR
+        assert e.value.non_retryable